@@ -0,0 +1,284 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package annotations keeps the notes and soft deletions that analysts add to the findings, without
+// changing the graph databases. The annotations are persisted in the output directory for each graph
+// database system, and the events are identified by their root domain names.
+package annotations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the file in the output directory that holds the annotations.
+const FileName = "annotations.json"
+
+// NoteRestored is the note recording why a hidden name was shown again.
+const NoteRestored = "restored"
+
+// fileVersion is the version of the annotations file format.
+const fileVersion = 1
+
+// Annotation holds the notes and the hidden flag of a name found by an event.
+type Annotation struct {
+	Event  string            `json:"event"`
+	Name   string            `json:"name"`
+	Hidden bool              `json:"hidden"`
+	Notes  map[string]string `json:"notes,omitempty"`
+	// Records are the records the name had when it was hidden
+	Records []string  `json:"records,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+type annotationsFile struct {
+	Version  int                               `json:"version"`
+	Backends map[string]map[string]*Annotation `json:"backends"`
+}
+
+// Store holds the annotations of each graph database system.
+type Store struct {
+	sync.Mutex
+	path     string
+	backends map[string]map[string]*Annotation
+}
+
+// Open loads the annotations file in the directory, which is created by the first annotation.
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		path:     filepath.Join(dir, FileName),
+		backends: make(map[string]map[string]*Annotation),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the annotations: %v", err)
+	}
+
+	var f annotationsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse the annotations: %v", err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("the annotations file has the unsupported version %d", f.Version)
+	}
+	for system, annotations := range f.Backends {
+		if annotations != nil {
+			s.backends[strings.ToLower(system)] = annotations
+		}
+	}
+	return s, nil
+}
+
+// Backend returns the annotations of the findings stored in the graph database system.
+func (s *Store) Backend(system string) *Backend {
+	if s == nil {
+		return nil
+	}
+	return &Backend{store: s, system: strings.ToLower(system)}
+}
+
+// save writes the annotations, replacing the file only once the new content is complete.
+// The store must be locked by the caller.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(&annotationsFile{
+		Version:  fileVersion,
+		Backends: s.backends,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the annotations: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write the annotations: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace the annotations file: %v", err)
+	}
+	return nil
+}
+
+// Backend provides the annotations of a single graph database system. A nil Backend has no annotations.
+type Backend struct {
+	store  *Store
+	system string
+}
+
+// SetAnnotation sets the note of the name found by the event, and an empty value removes the note.
+func (b *Backend) SetAnnotation(event, name, key, value string) error {
+	if key == "" {
+		return errors.New("the annotation key is empty")
+	}
+
+	return b.update(event, name, func(a *Annotation) {
+		if value == "" {
+			delete(a.Notes, key)
+			return
+		}
+		if a.Notes == nil {
+			a.Notes = make(map[string]string)
+		}
+		a.Notes[key] = value
+	})
+}
+
+// Hide marks the name found by the event as a false positive, which is excluded from the output.
+// The records of the name are kept, since a change of them shows the name again.
+func (b *Backend) Hide(event, name string, records []string) error {
+	return b.update(event, name, func(a *Annotation) {
+		a.Hidden = true
+		a.Records = normalize(records)
+		delete(a.Notes, NoteRestored)
+	})
+}
+
+// Restore shows the hidden name found by the event again.
+func (b *Backend) Restore(event, name string) error {
+	return b.update(event, name, func(a *Annotation) {
+		a.Hidden = false
+		a.Records = nil
+	})
+}
+
+// Hidden returns true when the name found by the event has been hidden.
+func (b *Backend) Hidden(event, name string) bool {
+	if b == nil {
+		return false
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	a := b.store.backends[b.system][key(event, name)]
+	return a != nil && a.Hidden
+}
+
+// Refound checks the records of a hidden name found again by the event, and returns true when the name
+// stays hidden. A name with changed records is shown again, with a note recording the change.
+func (b *Backend) Refound(event, name string, records []string) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	a := b.store.backends[b.system][key(event, name)]
+	if a == nil || !a.Hidden {
+		return false, nil
+	}
+
+	current := normalize(records)
+	if strings.Join(current, ",") == strings.Join(a.Records, ",") {
+		return true, nil
+	}
+
+	if a.Notes == nil {
+		a.Notes = make(map[string]string)
+	}
+	a.Notes[NoteRestored] = fmt.Sprintf("the records changed from [%s] to [%s] after the name was hidden",
+		strings.Join(a.Records, ", "), strings.Join(current, ", "))
+	a.Hidden = false
+	a.Records = nil
+	a.Updated = time.Now()
+	return false, b.store.save()
+}
+
+// ListAnnotated returns the annotations of the names found by the event, sorted by the name.
+func (b *Backend) ListAnnotated(event string) []Annotation {
+	if b == nil {
+		return nil
+	}
+	event = strings.ToLower(event)
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	var list []Annotation
+	for _, a := range b.store.backends[b.system] {
+		if a.Event != event {
+			continue
+		}
+
+		c := *a
+		c.Notes = make(map[string]string, len(a.Notes))
+		for k, v := range a.Notes {
+			c.Notes[k] = v
+		}
+		c.Records = append([]string(nil), a.Records...)
+		list = append(list, c)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// update changes the annotation of the name found by the event, and persists the change.
+// The annotations without notes that are not hidden are removed.
+func (b *Backend) update(event, name string, change func(a *Annotation)) error {
+	if b == nil {
+		return errors.New("the annotations have not been opened")
+	}
+
+	event, name = strings.ToLower(event), strings.ToLower(name)
+	if event == "" || name == "" {
+		return errors.New("the annotation requires the event and the name")
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	annotations, found := b.store.backends[b.system]
+	if !found {
+		annotations = make(map[string]*Annotation)
+		b.store.backends[b.system] = annotations
+	}
+
+	k := key(event, name)
+	a, found := annotations[k]
+	if !found {
+		a = &Annotation{Event: event, Name: name}
+		annotations[k] = a
+	}
+
+	change(a)
+	a.Updated = time.Now()
+	if !a.Hidden && len(a.Notes) == 0 {
+		delete(annotations, k)
+	}
+	return b.store.save()
+}
+
+func key(event, name string) string {
+	return strings.ToLower(event) + "|" + strings.ToLower(name)
+}
+
+// normalize returns the sorted and unique records, so they can be compared across runs.
+func normalize(records []string) []string {
+	seen := make(map[string]struct{}, len(records))
+
+	var results []string
+	for _, r := range records {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if _, found := seen[r]; found || r == "" {
+			continue
+		}
+
+		seen[r] = struct{}{}
+		results = append(results, r)
+	}
+	sort.Strings(results)
+	return results
+}
@@ -0,0 +1,130 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package annotations
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnnotationsPersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := s.Backend("local")
+	if err := local.SetAnnotation("owasp.org", "WWW.owasp.org", "owner", "web team"); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Hide("owasp.org", "test.owasp.org", []string{"192.0.2.2", "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.SetAnnotation("owasp.org", "test.owasp.org", "reason", "false positive"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local = s.Backend("Local")
+	if !local.Hidden("owasp.org", "test.owasp.org") || local.Hidden("owasp.org", "www.owasp.org") {
+		t.Error("the hidden flags were not persisted")
+	}
+	// The annotations of each graph database system are kept apart
+	if s.Backend("postgres").Hidden("owasp.org", "test.owasp.org") {
+		t.Error("the name was hidden in another graph database system")
+	}
+
+	list := local.ListAnnotated("owasp.org")
+	if len(list) != 2 || list[0].Name != "test.owasp.org" || list[1].Name != "www.owasp.org" {
+		t.Fatalf("ListAnnotated returned %+v", list)
+	}
+	if list[0].Notes["reason"] != "false positive" || strings.Join(list[0].Records, ",") != "192.0.2.1,192.0.2.2" {
+		t.Errorf("the annotation of the hidden name is %+v", list[0])
+	}
+	if list[1].Notes["owner"] != "web team" || list[1].Hidden {
+		t.Errorf("the annotation of the noted name is %+v", list[1])
+	}
+	if other := local.ListAnnotated("example.com"); len(other) != 0 {
+		t.Errorf("ListAnnotated returned the annotations of another event: %+v", other)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Backend("local")
+
+	if err := b.Hide("owasp.org", "test.owasp.org", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Restore("owasp.org", "test.owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	if b.Hidden("owasp.org", "test.owasp.org") {
+		t.Error("the restored name is still hidden")
+	}
+	// The restored name without notes is no longer annotated
+	if list := b.ListAnnotated("owasp.org"); len(list) != 0 {
+		t.Errorf("ListAnnotated returned %+v", list)
+	}
+}
+
+func TestRefound(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Backend("local")
+
+	if err := b.Hide("owasp.org", "test.owasp.org", []string{"192.0.2.1", "192.0.2.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if hidden, err := b.Refound("owasp.org", "test.owasp.org", []string{"192.0.2.2", "192.0.2.1", "192.0.2.1"}); err != nil || !hidden {
+		t.Errorf("the name with the same records was shown again: %v", err)
+	}
+	if hidden, _ := b.Refound("owasp.org", "www.owasp.org", []string{"192.0.2.1"}); hidden {
+		t.Error("a name that was not hidden was reported as hidden")
+	}
+
+	hidden, err := b.Refound("owasp.org", "test.owasp.org", []string{"192.0.2.3"})
+	if err != nil || hidden {
+		t.Fatalf("the name with changed records stayed hidden: %v", err)
+	}
+	list := b.ListAnnotated("owasp.org")
+	if len(list) != 1 || list[0].Hidden || !strings.Contains(list[0].Notes[NoteRestored], "192.0.2.3") {
+		t.Errorf("the change of the records was not noted: %+v", list)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("Open accepted a corrupt annotations file")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(`{"version":99}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("Open accepted an annotations file of another version")
+	}
+
+	var b *Backend
+	if b.Hidden("owasp.org", "www.owasp.org") || b.SetAnnotation("owasp.org", "www.owasp.org", "k", "v") == nil {
+		t.Error("the nil Backend has annotations")
+	}
+}
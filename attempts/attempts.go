@@ -0,0 +1,425 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package attempts remembers the brute force and alteration candidates that failed to resolve, across the
+// enumerations sharing an output directory, so the candidates are not queried again by each enumeration.
+// The names that resolved are held by the graph, which is the source of truth for them, so only the failures
+// are kept here, as the hashes of the names along with the start time of the enumeration that tried them.
+//
+// The candidates of each domain are held by a file of fixed size records under the directory, starting with
+// a versioned header. Once the file holds the maximum number of records, it becomes the previous generation
+// and a new file is started, so the disk and memory used by each domain are bounded by two generations.
+// A missing, corrupt or unknown file is discarded, and its candidates are attempted again.
+package attempts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// DirName is the name of the directory in the output directory holding the attempted candidates.
+	DirName = "attempts"
+	// DefaultRetryAge is the time after which the candidates that failed are attempted again.
+	DefaultRetryAge = 30 * 24 * time.Hour
+	// DefaultMaxEntries is the number of candidates held by a generation of the file of each domain.
+	DefaultMaxEntries = 1 << 20
+	// fileSuffix is the extension of the file of each domain, and the previous generation is suffixed with ".1"
+	fileSuffix = ".att"
+	// version is the version of the format of the files
+	version = 1
+	// headerSize is the number of bytes of the magic, the version and the reserved bytes
+	headerSize = 8
+	// recordSize is the number of bytes of the hash of the name and the time it was attempted
+	recordSize = 16
+)
+
+var magic = []byte("AMAT")
+
+// ErrCorrupt is returned for the files that are not in the format of the attempted candidates.
+var ErrCorrupt = errors.New("the file of the attempted candidates is corrupt")
+
+// Config is the configuration of the attempted candidates.
+type Config struct {
+	// RetryAge is the time after which the candidates that failed are attempted again
+	RetryAge time.Duration
+	// MaxEntries is the number of candidates held by a generation of the file of each domain
+	MaxEntries int
+}
+
+// ConfigFromOptions returns the settings found in the 'attempts' configuration options. The attempted
+// candidates are remembered unless the options disable them, in which case nil is returned.
+func ConfigFromOptions(cfg *config.Config) *Config {
+	c := &Config{RetryAge: DefaultRetryAge, MaxEntries: DefaultMaxEntries}
+	if cfg == nil || cfg.Options == nil {
+		return c
+	}
+
+	opts, ok := cfg.Options["attempts"].(map[string]interface{})
+	if !ok {
+		return c
+	}
+	if enabled, found := opts["enabled"].(bool); found && !enabled {
+		return nil
+	}
+	// The retry age is provided in hours
+	if hours := options.Int(opts["retry_age"]); hours > 0 {
+		c.RetryAge = time.Duration(hours) * time.Hour
+	}
+	if n := options.Int(opts["max_entries"]); n > 0 {
+		c.MaxEntries = n
+	}
+	return c
+}
+
+// Stats reports the candidates held for the domains loaded so far.
+type Stats struct {
+	Domains int `json:"domains"`
+	// Entries is the number of candidates held across both generations
+	Entries int `json:"entries"`
+	// Added is the number of candidates recorded by this process
+	Added int `json:"added"`
+	// Skipped is the number of times a candidate was found to have failed within the retry age
+	Skipped int `json:"skipped"`
+	// Discarded is the number of files that could not be read and were started over
+	Discarded int `json:"discarded"`
+	// Rotated is the number of times a file became the previous generation
+	Rotated int `json:"rotated"`
+}
+
+// Set is the attempted candidates of the domains, loaded from their files the first time a domain is used.
+type Set struct {
+	sync.Mutex
+	dir      string
+	retryAge time.Duration
+	max      int
+	domains  map[string]*domainSet
+	stats    Stats
+}
+
+// domainSet is the attempted candidates of a domain, along with the file the new candidates are appended to.
+type domainSet struct {
+	cur   map[uint64]int64
+	prev  map[uint64]int64
+	f     *os.File
+	w     *bufio.Writer
+	count int
+}
+
+// Open returns the attempted candidates kept in the directory, which is created when missing.
+func Open(dir string, c *Config) (*Set, error) {
+	if c == nil {
+		c = &Config{}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the directory of the attempted candidates: %v", err)
+	}
+
+	s := &Set{
+		dir:      dir,
+		retryAge: c.RetryAge,
+		max:      c.MaxEntries,
+		domains:  make(map[string]*domainSet),
+	}
+	if s.retryAge <= 0 {
+		s.retryAge = DefaultRetryAge
+	}
+	if s.max <= 0 {
+		s.max = DefaultMaxEntries
+	}
+	return s, nil
+}
+
+// RetryAge returns the time after which the candidates that failed are attempted again.
+func (s *Set) RetryAge() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return s.retryAge
+}
+
+// Recent returns true when the candidate of the domain failed within the retry age before the time.
+func (s *Set) Recent(domain, name string, now time.Time) bool {
+	if s == nil {
+		return false
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	ds := s.domain(domain)
+	if ds == nil {
+		return false
+	}
+
+	h := hashName(name)
+	at, found := ds.cur[h]
+	if !found {
+		at, found = ds.prev[h]
+	}
+	if found && now.Sub(time.Unix(at, 0)) < s.retryAge {
+		s.stats.Skipped++
+		return true
+	}
+	return false
+}
+
+// Add records the candidate of the domain as failed by the enumeration started at the time. The record is
+// buffered until the set is flushed or closed.
+func (s *Set) Add(domain, name string, at time.Time) error {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	ds := s.domain(domain)
+	if ds == nil {
+		return fmt.Errorf("the domain %q cannot hold attempted candidates", domain)
+	}
+
+	h, secs := hashName(name), at.Unix()
+	if prev, found := ds.cur[h]; found && prev >= secs {
+		return nil
+	}
+	if ds.count >= s.max {
+		if err := s.rotate(domain, ds); err != nil {
+			return err
+		}
+	}
+	if ds.f == nil {
+		if err := s.create(domain, ds); err != nil {
+			return err
+		}
+	}
+
+	var rec [recordSize]byte
+	binary.BigEndian.PutUint64(rec[:8], h)
+	binary.BigEndian.PutUint64(rec[8:], uint64(secs))
+	if _, err := ds.w.Write(rec[:]); err != nil {
+		return fmt.Errorf("failed to write the attempted candidate: %v", err)
+	}
+	ds.cur[h] = secs
+	ds.count++
+	s.stats.Added++
+	return nil
+}
+
+// Stats returns the candidates held for the domains loaded so far.
+func (s *Set) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	st := s.stats
+	st.Domains = len(s.domains)
+	for _, ds := range s.domains {
+		st.Entries += len(ds.cur) + len(ds.prev)
+	}
+	return st
+}
+
+// Flush writes the buffered candidates to their files.
+func (s *Set) Flush() error {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var errs []string
+	for domain, ds := range s.domains {
+		if ds.w == nil {
+			continue
+		}
+		if err := ds.w.Flush(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", domain, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to write the attempted candidates: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close writes the buffered candidates to their files, and closes them.
+func (s *Set) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	err := s.Flush()
+	s.Lock()
+	defer s.Unlock()
+
+	for _, ds := range s.domains {
+		if ds.f != nil {
+			_ = ds.f.Close()
+			ds.f, ds.w = nil, nil
+		}
+	}
+	return err
+}
+
+// path returns the path of the file holding the generation of the domain.
+func (s *Set) path(domain string, previous bool) string {
+	p := filepath.Join(s.dir, domain+fileSuffix)
+	if previous {
+		p += ".1"
+	}
+	return p
+}
+
+// domain returns the candidates of the domain, loading them from the files the first time. The lock must be
+// held by the caller. The domains that cannot name a file are refused.
+func (s *Set) domain(domain string) *domainSet {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	if domain == "" || strings.ContainsAny(domain, `/\`) {
+		return nil
+	}
+	if ds, found := s.domains[domain]; found {
+		return ds
+	}
+
+	ds := &domainSet{cur: make(map[uint64]int64), prev: make(map[uint64]int64)}
+	if n, err := load(s.path(domain, false), ds.cur); err != nil {
+		s.discard(s.path(domain, false))
+		ds.cur = make(map[uint64]int64)
+	} else {
+		ds.count = n
+	}
+	if _, err := load(s.path(domain, true), ds.prev); err != nil {
+		s.discard(s.path(domain, true))
+		ds.prev = make(map[uint64]int64)
+	}
+	s.domains[domain] = ds
+	return ds
+}
+
+// discard removes the file that could not be read, so it is started over.
+func (s *Set) discard(path string) {
+	if err := os.Remove(path); err == nil {
+		s.stats.Discarded++
+	}
+}
+
+// create opens the file of the domain for appending, writing the header when the file is new, and dropping
+// the partial record left at its end by a crash.
+func (s *Set) create(domain string, ds *domainSet) error {
+	path := s.path(domain, false)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the attempted candidates: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	size := fi.Size()
+	if size < headerSize {
+		size = 0
+		if err := f.Truncate(0); err == nil {
+			_, err = f.WriteAt(header(), 0)
+		}
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to write the attempted candidates: %v", err)
+		}
+		size = headerSize
+	} else if extra := (size - headerSize) % recordSize; extra != 0 {
+		size -= extra
+		if err := f.Truncate(size); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	ds.f = f
+	ds.w = bufio.NewWriter(f)
+	return nil
+}
+
+// rotate makes the full file of the domain the previous generation, replacing the older one.
+func (s *Set) rotate(domain string, ds *domainSet) error {
+	if ds.f != nil {
+		if err := ds.w.Flush(); err != nil {
+			return err
+		}
+		_ = ds.f.Close()
+		ds.f, ds.w = nil, nil
+	}
+	if err := os.Rename(s.path(domain, false), s.path(domain, true)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate the attempted candidates: %v", err)
+	}
+
+	ds.prev, ds.cur = ds.cur, make(map[uint64]int64)
+	ds.count = 0
+	s.stats.Rotated++
+	return nil
+}
+
+// load reads the records of the file into the map, and returns their number. A missing file holds no records,
+// and the partial record left at the end of the file by a crash is ignored.
+func load(path string, into map[uint64]int64) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(data) < headerSize || !bytes.Equal(data[:headerSize], header()) {
+		return 0, ErrCorrupt
+	}
+
+	var n int
+	for data = data[headerSize:]; len(data) >= recordSize; data = data[recordSize:] {
+		h := binary.BigEndian.Uint64(data[:8])
+		at := int64(binary.BigEndian.Uint64(data[8:recordSize]))
+		if at > into[h] {
+			into[h] = at
+		}
+		n++
+	}
+	return n, nil
+}
+
+// header returns the magic and version starting each file.
+func header() []byte {
+	h := make([]byte, headerSize)
+	copy(h, magic)
+	binary.BigEndian.PutUint16(h[4:6], version)
+	return h
+}
+
+// hashName returns the hash identifying the candidate name within its domain.
+func hashName(name string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))))
+	return h.Sum64()
+}
@@ -0,0 +1,161 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package attempts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func openSet(t *testing.T, dir string, c *Config) *Set {
+	s, err := Open(dir, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestConfigFromOptions(t *testing.T) {
+	cfg := config.NewConfig()
+	if c := ConfigFromOptions(cfg); c == nil || c.RetryAge != DefaultRetryAge || c.MaxEntries != DefaultMaxEntries {
+		t.Errorf("the defaults were not used: %+v", c)
+	}
+
+	cfg.Options = map[string]interface{}{"attempts": map[string]interface{}{"retry_age": 48, "max_entries": 100}}
+	if c := ConfigFromOptions(cfg); c == nil || c.RetryAge != 48*time.Hour || c.MaxEntries != 100 {
+		t.Errorf("the options were not parsed: %+v", c)
+	}
+
+	cfg.Options = map[string]interface{}{"attempts": map[string]interface{}{"enabled": false}}
+	if ConfigFromOptions(cfg) != nil {
+		t.Error("the attempted candidates were remembered while disabled")
+	}
+}
+
+func TestAttemptsAcrossRuns(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	s := openSet(t, dir, &Config{RetryAge: 7 * 24 * time.Hour})
+	for _, name := range []string{"dev.owasp.org", "Test.owasp.org.", "dev.owasp.org"} {
+		if err := s.Add("owasp.org", name, first); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next enumeration loads the candidates of the domain from the file
+	s = openSet(t, dir, &Config{RetryAge: 7 * 24 * time.Hour})
+	if !s.Recent("owasp.org", "test.owasp.org", first.Add(24*time.Hour)) {
+		t.Error("the candidate that failed the day before was attempted again")
+	}
+	if s.Recent("owasp.org", "www.owasp.org", first.Add(24*time.Hour)) {
+		t.Error("the candidate that was never attempted was skipped")
+	}
+	if s.Recent("owasp.org", "dev.owasp.org", first.Add(8*24*time.Hour)) {
+		t.Error("the candidate was skipped past its retry age")
+	}
+	if st := s.Stats(); st.Domains != 1 || st.Entries != 2 || st.Skipped != 1 {
+		t.Errorf("the stats were %+v", st)
+	}
+
+	// The file holds the header and a record for each distinct candidate
+	fi, err := os.Stat(filepath.Join(dir, "owasp.org"+fileSuffix))
+	if err != nil || fi.Size() != headerSize+2*recordSize {
+		t.Errorf("the file of the domain holds %d bytes: %v", fi.Size(), err)
+	}
+}
+
+func TestAttemptsRotation(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Now()
+
+	s := openSet(t, dir, &Config{MaxEntries: 10})
+	for i := 0; i < 25; i++ {
+		if err := s.Add("owasp.org", fmt.Sprintf("host%d.owasp.org", i), at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.Close()
+
+	// Two generations are kept, so the oldest candidates were dropped
+	s = openSet(t, dir, &Config{MaxEntries: 10})
+	if s.Recent("owasp.org", "host0.owasp.org", at) || !s.Recent("owasp.org", "host24.owasp.org", at) ||
+		!s.Recent("owasp.org", "host10.owasp.org", at) {
+		t.Error("the generations did not hold the newest candidates")
+	}
+	if st := s.Stats(); st.Entries != 15 {
+		t.Errorf("%d candidates were kept", st.Entries)
+	}
+	for _, name := range []string{"owasp.org" + fileSuffix, "owasp.org" + fileSuffix + ".1"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil || fi.Size() > headerSize+10*recordSize {
+			t.Errorf("the file %s was not bounded: %v", name, err)
+		}
+	}
+}
+
+func TestAttemptsDamagedFiles(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Now()
+
+	s := openSet(t, dir, nil)
+	_ = s.Add("owasp.org", "dev.owasp.org", at)
+	_ = s.Add("owasp.org", "test.owasp.org", at)
+	_ = s.Close()
+
+	// The partial record left by a crash is dropped, and the whole records are kept
+	path := filepath.Join(dir, "owasp.org"+fileSuffix)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{1, 2, 3})
+	_ = f.Close()
+
+	s = openSet(t, dir, nil)
+	if !s.Recent("owasp.org", "test.owasp.org", at) {
+		t.Error("the records before the partial record were lost")
+	}
+	if err := s.Add("owasp.org", "www.owasp.org", at); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+	if fi, err := os.Stat(path); err != nil || fi.Size() != headerSize+3*recordSize {
+		t.Errorf("the partial record was not dropped: %v", err)
+	}
+
+	// A file of another version or of something else degrades to attempting the candidates again
+	for _, content := range [][]byte{[]byte("AMAT\x00\x09\x00\x00"), []byte("not the attempted candidates")} {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		s = openSet(t, dir, nil)
+		if s.Recent("owasp.org", "dev.owasp.org", at) {
+			t.Error("the candidate of the damaged file was skipped")
+		}
+		if st := s.Stats(); st.Discarded != 1 {
+			t.Errorf("%d files were discarded", st.Discarded)
+		}
+		if err := s.Add("owasp.org", "dev.owasp.org", at); err != nil {
+			t.Error(err)
+		}
+		_ = s.Close()
+	}
+
+	var nilset *Set
+	if nilset.Recent("owasp.org", "dev.owasp.org", at) || nilset.Add("owasp.org", "dev.owasp.org", at) != nil {
+		t.Error("the nil set remembered the candidate")
+	}
+}
@@ -0,0 +1,619 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package authoritative sends the DNS queries straight to the authoritative servers of the zone holding
+// each name, without the latency and the caching of the recursive resolvers. The servers of each zone cut
+// are discovered through the recursive resolvers and cached, and the queries fall back to the recursive
+// resolvers when the authoritative servers cannot be found or do not respond.
+package authoritative
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/clock"
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// DefaultQPS is the number of queries sent to each authoritative server every second when none has been configured.
+const DefaultQPS = 10
+
+// DefaultTimeout is the time a query waits for the authoritative server when none has been configured.
+const DefaultTimeout = 2 * time.Second
+
+const (
+	// maxFailures is the number of consecutive failures that takes a server out of the rotation
+	maxFailures = 3
+	// holdTime is how long an unresponsive server is left out before it is tried again
+	holdTime = time.Minute
+	// missingTime is how long the names without a discovered zone are sent to the recursive resolvers
+	missingTime = 10 * time.Minute
+	// maxServers bounds the servers kept for each zone
+	maxServers = 8
+	// maxReferrals bounds the zone cuts followed by a single query
+	maxReferrals = 4
+)
+
+// Resolver is the pool of recursive resolvers, such as the pools of the resolve package.
+type Resolver interface {
+	Len() int
+	Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg)
+	QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+}
+
+// Options select the rate, the timeout and the sockets of the queries sent to the authoritative servers.
+type Options struct {
+	// QPS is the number of queries sent to each authoritative server every second
+	QPS int
+	// Timeout is the time a query waits for the authoritative server before the next one is tried
+	Timeout time.Duration
+	// Socket holds the bind address and the source ports of the sockets
+	Socket *amassnet.SocketOptions
+	// Allow returns false for the server addresses that must not be queried, such as those of a never-touch list
+	Allow func(addr string) bool
+	// Bytes is called with the number of bytes written to and read from the sockets of the direct queries
+	Bytes func(sent, received int)
+}
+
+// OptionsFromConfig returns the settings of the 'authoritative' section, or nil when the mode is not enabled.
+func OptionsFromConfig(cfg *config.Config) *Options {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["authoritative"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, ok := opts["enabled"].(bool); ok && !enabled {
+		return nil
+	}
+
+	o := &Options{QPS: DefaultQPS, Timeout: DefaultTimeout}
+	if n := options.Int(opts["qps"]); n > 0 {
+		o.QPS = n
+	}
+	if n := options.Int(opts["timeout"]); n > 0 {
+		o.Timeout = time.Duration(n) * time.Second
+	}
+	return o
+}
+
+// Stats are the counters of the direct queries.
+type Stats struct {
+	Zones   int `json:"zones"`
+	Servers int `json:"servers"`
+	// Direct counts the queries answered by the authoritative servers, and Authoritative those with the AA bit set
+	Direct        int64 `json:"direct"`
+	Authoritative int64 `json:"authoritative"`
+	Referrals     int64 `json:"referrals"`
+	// Fallbacks counts the queries sent to the recursive resolvers
+	Fallbacks int64 `json:"fallbacks"`
+}
+
+type exchangeFunc func(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, error)
+
+// Zones discovers the authoritative servers of the zone cuts and caches them, along with the health and
+// the rate limit of each server, which are shared by the pools sending the queries.
+type Zones struct {
+	sync.Mutex
+	lookup   Resolver
+	opts     Options
+	clock    clock.Clock
+	port     string
+	exchange exchangeFunc
+	zones    map[string]*zone
+	servers  map[string]*server
+	// missing holds the names without a discovered zone, until they are tried again
+	missing  map[string]time.Time
+	inflight map[string]chan struct{}
+	direct   atomic.Int64
+	aa       atomic.Int64
+	refs     atomic.Int64
+	fallback atomic.Int64
+}
+
+type zone struct {
+	name    string
+	servers []*server
+	next    atomic.Uint32
+}
+
+type server struct {
+	sync.Mutex
+	addr     string
+	rate     *rate.Limiter
+	failures int
+	until    time.Time
+}
+
+// NewZones returns the cache of the zone cuts, discovered through the recursive resolvers of lookup.
+func NewZones(lookup Resolver, opts *Options) *Zones {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+
+	z := &Zones{
+		lookup:   lookup,
+		opts:     o,
+		clock:    clock.System,
+		port:     "53",
+		zones:    make(map[string]*zone),
+		servers:  make(map[string]*server),
+		missing:  make(map[string]time.Time),
+		inflight: make(map[string]chan struct{}),
+	}
+	z.exchange = z.exchangeUDP
+	return z
+}
+
+// NewPool returns the pool sending the queries to the authoritative servers, and to fallback otherwise.
+func (z *Zones) NewPool(fallback Resolver) *Pool {
+	return &Pool{zones: z, fallback: fallback}
+}
+
+// Stats returns the counters of the direct queries.
+func (z *Zones) Stats() Stats {
+	z.Lock()
+	defer z.Unlock()
+
+	return Stats{
+		Zones:         len(z.zones),
+		Servers:       len(z.servers),
+		Direct:        z.direct.Load(),
+		Authoritative: z.aa.Load(),
+		Referrals:     z.refs.Load(),
+		Fallbacks:     z.fallback.Load(),
+	}
+}
+
+// Forget drops the zone cuts and missing names at or below the domain, along with the servers no other zone uses,
+// so the domain is discovered again when a name of it is queried later.
+func (z *Zones) Forget(domain string) {
+	if z == nil {
+		return
+	}
+	domain = strings.ToLower(resolve.RemoveLastDot(domain))
+
+	z.Lock()
+	defer z.Unlock()
+
+	for name := range z.zones {
+		if dns.IsSubDomain(domain, name) {
+			delete(z.zones, name)
+		}
+	}
+	for name := range z.missing {
+		if dns.IsSubDomain(domain, name) {
+			delete(z.missing, name)
+		}
+	}
+
+	used := make(map[string]struct{})
+	for _, zn := range z.zones {
+		for _, s := range zn.servers {
+			used[s.addr] = struct{}{}
+		}
+	}
+	for addr := range z.servers {
+		if _, found := used[addr]; !found {
+			delete(z.servers, addr)
+		}
+	}
+}
+
+// Pool sends the queries to the authoritative servers of the names, and implements the pool used by the enumeration.
+type Pool struct {
+	zones    *Zones
+	fallback Resolver
+}
+
+// Len returns the number of recursive resolvers, which bounds the queries in flight.
+func (p *Pool) Len() int {
+	return p.fallback.Len()
+}
+
+// Query sends the response on the channel once it has been received from the authoritative servers,
+// or from the recursive resolvers when the authoritative servers did not respond.
+func (p *Pool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	if msg == nil || len(msg.Question) == 0 {
+		ch <- msg
+		return
+	}
+
+	go func() {
+		if resp := p.zones.query(ctx, msg); resp != nil {
+			ch <- resp
+			return
+		}
+		p.zones.fallback.Add(1)
+		p.fallback.Query(ctx, msg, ch)
+	}()
+}
+
+// QueryBlocking returns the response once it has been received.
+func (p *Pool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if msg == nil || len(msg.Question) == 0 {
+		return msg, errors.New("the query has no question")
+	}
+
+	if resp := p.zones.query(ctx, msg); resp != nil {
+		return resp, nil
+	}
+	p.zones.fallback.Add(1)
+	return p.fallback.QueryBlocking(ctx, msg)
+}
+
+// query asks the authoritative servers of the zone holding the name, following the referrals to the zone
+// cuts below it, and returns nil when the servers could not be found or did not respond.
+func (z *Zones) query(ctx context.Context, msg *dns.Msg) *dns.Msg {
+	name := strings.ToLower(resolve.RemoveLastDot(msg.Question[0].Name))
+
+	zn := z.zoneFor(ctx, name)
+	for i := 0; zn != nil && i < maxReferrals; i++ {
+		resp := z.ask(ctx, zn, msg)
+		if resp == nil {
+			return nil
+		}
+
+		child, ns := referral(resp, zn.name, name)
+		if child == "" {
+			z.direct.Add(1)
+			if resp.Authoritative {
+				z.aa.Add(1)
+			}
+			return resp
+		}
+		z.refs.Add(1)
+		zn = z.delegated(ctx, child, ns, resp.Extra)
+	}
+	return nil
+}
+
+// ask sends the query to the servers of the zone in turn, skipping those that stopped responding,
+// and returns nil when none of them answered.
+func (z *Zones) ask(ctx context.Context, zn *zone, msg *dns.Msg) *dns.Msg {
+	n := len(zn.servers)
+	start := int(zn.next.Add(1) - 1)
+
+	for i := 0; i < n; i++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		s := zn.servers[(start+i)%n]
+		if !s.available(z.clock.Now()) {
+			continue
+		}
+		if s.rate.TakeContext(ctx) != nil {
+			return nil
+		}
+
+		q := msg.Copy()
+		q.RecursionDesired = false
+		resp, err := z.exchange(ctx, q, s.addr)
+		if err != nil || resp == nil || !sameQuestion(msg, resp) || failure(resp.Rcode) {
+			s.failed(z.clock.Now())
+			continue
+		}
+		s.succeeded()
+
+		resp.Id = msg.Id
+		return resp
+	}
+	return nil
+}
+
+func failure(rcode int) bool {
+	return rcode == dns.RcodeServerFailure || rcode == dns.RcodeRefused || rcode == dns.RcodeNotImplemented
+}
+
+// zoneFor returns the closest zone cut cached for the name, and discovers the zone holding the name
+// when none has been cached. It returns nil when the zone could not be discovered.
+func (z *Zones) zoneFor(ctx context.Context, name string) *zone {
+	for {
+		zn, wait, found := z.cached(name)
+		if found {
+			return zn
+		}
+		if wait == nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wait:
+		}
+	}
+
+	key := parent(name)
+	defer func() {
+		z.Lock()
+		close(z.inflight[key])
+		delete(z.inflight, key)
+		z.Unlock()
+	}()
+
+	zn := z.discover(ctx, name)
+	if zn == nil {
+		z.Lock()
+		z.missing[name] = z.clock.Now().Add(missingTime)
+		z.Unlock()
+	}
+	return zn
+}
+
+// cached returns the closest zone cut of the name, or the channel closed once the discovery started by another
+// query for a name under the same parent has finished. It registers the discovery of the caller otherwise.
+func (z *Zones) cached(name string) (*zone, chan struct{}, bool) {
+	z.Lock()
+	defer z.Unlock()
+
+	if until, found := z.missing[name]; found {
+		if z.clock.Now().Before(until) {
+			return nil, nil, true
+		}
+		delete(z.missing, name)
+	}
+
+	for s := name; s != ""; s = parent(s) {
+		if zn, found := z.zones[s]; found {
+			return zn, nil, true
+		}
+	}
+
+	key := parent(name)
+	if wait, found := z.inflight[key]; found {
+		return nil, wait, false
+	}
+	z.inflight[key] = make(chan struct{})
+	return nil, nil, false
+}
+
+// discover learns the zone holding the name from the SOA record returned by the recursive resolvers,
+// which is the answer for the apex of a zone and the authority of the other names.
+func (z *Zones) discover(ctx context.Context, name string) *zone {
+	resp, err := z.lookup.QueryBlocking(ctx, resolve.QueryMsg(name, dns.TypeSOA))
+	if err != nil || resp == nil {
+		return nil
+	}
+
+	var apex string
+	for _, rr := range append(append([]dns.RR(nil), resp.Answer...), resp.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			owner := strings.ToLower(resolve.RemoveLastDot(soa.Hdr.Name))
+			// The SOA of a CNAME target belongs to another zone
+			if dns.IsSubDomain(owner, name) {
+				apex = owner
+				break
+			}
+		}
+	}
+	if apex == "" {
+		return nil
+	}
+
+	z.Lock()
+	zn, found := z.zones[apex]
+	z.Unlock()
+	if found {
+		return zn
+	}
+
+	resp, err = z.lookup.QueryBlocking(ctx, resolve.QueryMsg(apex, dns.TypeNS))
+	if err != nil || resp == nil {
+		return nil
+	}
+
+	var hosts []string
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			hosts = append(hosts, ns.Ns)
+		}
+	}
+	return z.addZone(apex, z.addresses(ctx, hosts, nil))
+}
+
+// delegated returns the zone cut named by the referral, using the glue records of the referral when provided.
+func (z *Zones) delegated(ctx context.Context, child string, hosts []string, glue []dns.RR) *zone {
+	z.Lock()
+	zn, found := z.zones[child]
+	z.Unlock()
+	if found {
+		return zn
+	}
+	return z.addZone(child, z.addresses(ctx, hosts, glue))
+}
+
+// addresses returns the addresses of the nameservers, taken from the glue records or asked of the recursive resolvers.
+func (z *Zones) addresses(ctx context.Context, hosts []string, glue []dns.RR) []string {
+	var addrs []string
+
+	for _, host := range hosts {
+		if len(addrs) >= maxServers {
+			break
+		}
+
+		var ips []string
+		for _, rr := range glue {
+			if a, ok := rr.(*dns.A); ok && strings.EqualFold(a.Hdr.Name, host) {
+				ips = append(ips, a.A.String())
+			}
+		}
+		if len(ips) == 0 {
+			if resp, err := z.lookup.QueryBlocking(ctx, resolve.QueryMsg(host, dns.TypeA)); err == nil && resp != nil {
+				for _, rr := range resp.Answer {
+					if a, ok := rr.(*dns.A); ok {
+						ips = append(ips, a.A.String())
+					}
+				}
+			}
+		}
+		// One address of each nameserver is enough, since the rotation is across the nameservers
+		for _, ip := range ips {
+			if z.opts.Allow == nil || z.opts.Allow(ip) {
+				addrs = append(addrs, net.JoinHostPort(ip, z.port))
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+// addZone caches the zone cut along with its servers, which are shared with the other zones they serve.
+func (z *Zones) addZone(name string, addrs []string) *zone {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	z.Lock()
+	defer z.Unlock()
+
+	if zn, found := z.zones[name]; found {
+		return zn
+	}
+
+	zn := &zone{name: name}
+	for _, addr := range addrs {
+		s, found := z.servers[addr]
+		if !found {
+			s = &server{addr: addr, rate: rate.NewLimiter(z.opts.QPS, 1, z.clock)}
+			z.servers[addr] = s
+		}
+		zn.servers = append(zn.servers, s)
+	}
+	z.zones[name] = zn
+	return zn
+}
+
+// referral returns the zone cut below the zone, and its nameservers, when the response delegates the name to it.
+func referral(resp *dns.Msg, zone, name string) (string, []string) {
+	if resp.Rcode != dns.RcodeSuccess || resp.Authoritative || len(resp.Answer) > 0 {
+		return "", nil
+	}
+
+	var child string
+	var hosts []string
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+
+		owner := strings.ToLower(resolve.RemoveLastDot(ns.Hdr.Name))
+		if owner == zone || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, name) {
+			continue
+		}
+		if child == "" {
+			child = owner
+		}
+		if owner == child {
+			hosts = append(hosts, ns.Ns)
+		}
+	}
+	return child, hosts
+}
+
+func (s *server) available(now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.failures < maxFailures || !now.Before(s.until)
+}
+
+func (s *server) failed(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failures++
+	if s.failures >= maxFailures {
+		s.until = now.Add(holdTime)
+	}
+}
+
+func (s *server) succeeded() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failures = 0
+}
+
+// exchangeUDP sends the query from a socket bound as selected by the options, and again over TCP when truncated.
+func (z *Zones) exchangeUDP(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, error) {
+	client := &dns.Client{Net: "udp", Timeout: z.opts.Timeout}
+
+	var conn net.Conn
+	if z.opts.Socket != nil {
+		udp, err := z.opts.Socket.DialUDP(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = udp
+	}
+	resp, err := z.exchangeConn(ctx, client, msg, addr, conn)
+	if err != nil || resp == nil || !resp.Truncated {
+		return resp, err
+	}
+
+	client.Net = "tcp"
+	if z.opts.Socket != nil && len(z.opts.Socket.BindAddress) > 0 {
+		client.Dialer = &net.Dialer{Timeout: z.opts.Timeout, LocalAddr: &net.TCPAddr{IP: z.opts.Socket.BindAddress}}
+	}
+	return z.exchangeConn(ctx, client, msg, addr, nil)
+}
+
+// exchangeConn sends the query on the connection, or on one dialed by the client when it is nil, and
+// passes the bytes crossing the connection to the function set by the options.
+func (z *Zones) exchangeConn(ctx context.Context, client *dns.Client, msg *dns.Msg, addr string, conn net.Conn) (*dns.Msg, error) {
+	if conn == nil {
+		co, err := client.DialContext(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = co.Conn
+	}
+	defer func() { _ = conn.Close() }()
+
+	resp, _, err := client.ExchangeWithConnContext(ctx, msg, &dns.Conn{Conn: bandwidth.NewConn(conn, z.opts.Bytes)})
+	return resp, err
+}
+
+// parent returns the name without its first label, or the empty string for a single label.
+func parent(name string) string {
+	if idx := strings.Index(name, "."); idx >= 0 {
+		return name[idx+1:]
+	}
+	return ""
+}
+
+// sameQuestion returns true when the response answers the question of the query.
+func sameQuestion(msg, resp *dns.Msg) bool {
+	if len(resp.Question) != len(msg.Question) {
+		return false
+	}
+
+	for i, q := range msg.Question {
+		r := resp.Question[i]
+		if r.Qtype != q.Qtype || r.Qclass != q.Qclass || !strings.EqualFold(r.Name, q.Name) {
+			return false
+		}
+	}
+	return true
+}
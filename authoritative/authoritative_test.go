@@ -0,0 +1,308 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package authoritative
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// fakeResolver answers the queries from its records, like a recursive resolver.
+type fakeResolver struct {
+	sync.Mutex
+	records map[string][]string
+	queries int
+}
+
+func newFakeResolver(records map[string][]string) *fakeResolver {
+	return &fakeResolver{records: records}
+}
+
+func (f *fakeResolver) Len() int { return 1 }
+
+func (f *fakeResolver) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	resp, _ := f.QueryBlocking(ctx, msg)
+	ch <- resp
+}
+
+func (f *fakeResolver) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.queries++
+
+	q := msg.Question[0]
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	resp.RecursionAvailable = true
+	for _, s := range f.records[dns.Type(q.Qtype).String()+" "+resolve.RemoveLastDot(q.Name)] {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := rr.(*dns.SOA); ok && q.Qtype == dns.TypeSOA && !dns.IsSubDomain(rr.Header().Name, q.Name) {
+			resp.Ns = append(resp.Ns, rr)
+			continue
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp, nil
+}
+
+func (f *fakeResolver) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.queries
+}
+
+// fakeServers stands in for the authoritative servers, keyed by their address.
+type fakeServers struct {
+	sync.Mutex
+	handlers map[string]func(msg *dns.Msg) (*dns.Msg, error)
+	asked    map[string]int
+}
+
+func (f *fakeServers) exchange(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, error) {
+	f.Lock()
+	h := f.handlers[addr]
+	if f.asked == nil {
+		f.asked = make(map[string]int)
+	}
+	f.asked[addr]++
+	f.Unlock()
+
+	if msg.RecursionDesired {
+		return nil, errors.New("recursion was asked of the authoritative server")
+	}
+	if h == nil {
+		return nil, errors.New("i/o timeout")
+	}
+	return h(msg)
+}
+
+func (f *fakeServers) count(addr string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.asked[addr]
+}
+
+func answer(records ...string) func(msg *dns.Msg) (*dns.Msg, error) {
+	return func(msg *dns.Msg) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(msg)
+		resp.Id = 999
+		resp.Authoritative = true
+		for _, s := range records {
+			rr, _ := dns.NewRR(s)
+			resp.Answer = append(resp.Answer, rr)
+		}
+		return resp, nil
+	}
+}
+
+var owaspZone = map[string][]string{
+	"SOA www.owasp.org": {"owasp.org. 300 IN SOA ns1.owasp.org. admin.owasp.org. 1 7200 3600 86400 300"},
+	"SOA owasp.org":     {"owasp.org. 300 IN SOA ns1.owasp.org. admin.owasp.org. 1 7200 3600 86400 300"},
+	"NS owasp.org":      {"owasp.org. 300 IN NS ns1.owasp.org.", "owasp.org. 300 IN NS ns2.owasp.org."},
+	"A ns1.owasp.org":   {"ns1.owasp.org. 300 IN A 192.0.2.1"},
+	"A ns2.owasp.org":   {"ns2.owasp.org. 300 IN A 192.0.2.2"},
+}
+
+func newTestZones(lookup Resolver, servers *fakeServers, opts *Options) *Zones {
+	z := NewZones(lookup, opts)
+	z.exchange = servers.exchange
+	return z
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if opts := OptionsFromConfig(cfg); opts != nil {
+		t.Error("the mode was enabled without the section")
+	}
+
+	cfg.Options = map[string]interface{}{"authoritative": map[string]interface{}{}}
+	if opts := OptionsFromConfig(cfg); opts == nil || opts.QPS != DefaultQPS || opts.Timeout != DefaultTimeout {
+		t.Errorf("the default settings were %+v", opts)
+	}
+
+	cfg.Options = map[string]interface{}{"authoritative": map[string]interface{}{"qps": 5, "timeout": 4.0}}
+	if opts := OptionsFromConfig(cfg); opts == nil || opts.QPS != 5 || opts.Timeout != 4*time.Second {
+		t.Errorf("the settings were %+v", opts)
+	}
+
+	cfg.Options = map[string]interface{}{"authoritative": map[string]interface{}{"enabled": false}}
+	if opts := OptionsFromConfig(cfg); opts != nil {
+		t.Errorf("the disabled mode returned the settings %+v", opts)
+	}
+}
+
+func TestDirectQueries(t *testing.T) {
+	lookup := newFakeResolver(owaspZone)
+	servers := &fakeServers{handlers: map[string]func(*dns.Msg) (*dns.Msg, error){
+		"192.0.2.1:53": answer("www.owasp.org. 300 IN A 198.51.100.1"),
+		"192.0.2.2:53": answer("www.owasp.org. 300 IN A 198.51.100.1"),
+	}}
+	z := newTestZones(lookup, servers, &Options{QPS: 100})
+	fallback := newFakeResolver(nil)
+	pool := z.NewPool(fallback)
+
+	for i := 0; i < 4; i++ {
+		msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+		resp, err := pool.QueryBlocking(context.Background(), msg)
+		if err != nil || resp == nil || !resp.Authoritative || len(resp.Answer) != 1 || resp.Id != msg.Id {
+			t.Fatalf("the query returned %v, %v", resp, err)
+		}
+	}
+
+	// The zone is discovered once, and the queries rotate among its servers
+	if n := lookup.count(); n != 4 {
+		t.Errorf("the recursive resolvers were asked %d queries to discover the zone, expected 4", n)
+	}
+	if servers.count("192.0.2.1:53") != 2 || servers.count("192.0.2.2:53") != 2 {
+		t.Errorf("the servers were asked %v", servers.asked)
+	}
+	if stats := z.Stats(); stats.Zones != 1 || stats.Servers != 2 || stats.Direct != 4 || stats.Authoritative != 4 || fallback.count() != 0 {
+		t.Errorf("the statistics were %+v", stats)
+	}
+
+	ch := make(chan *dns.Msg, 1)
+	pool.Query(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA), ch)
+	if resp := <-ch; resp == nil || !resp.Authoritative {
+		t.Errorf("the asynchronous query returned %v", resp)
+	}
+}
+
+func TestForget(t *testing.T) {
+	lookup := newFakeResolver(owaspZone)
+	handler := answer("www.owasp.org. 300 IN A 198.51.100.1")
+	servers := &fakeServers{handlers: map[string]func(*dns.Msg) (*dns.Msg, error){"192.0.2.1:53": handler, "192.0.2.2:53": handler}}
+	z := newTestZones(lookup, servers, nil)
+	pool := z.NewPool(newFakeResolver(nil))
+
+	_, _ = pool.QueryBlocking(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA))
+	z.Forget("example.com")
+	if stats := z.Stats(); stats.Zones != 1 || stats.Servers != 2 {
+		t.Errorf("the zones of another domain were forgotten: %+v", stats)
+	}
+
+	z.Forget("owasp.org")
+	if stats := z.Stats(); stats.Zones != 0 || stats.Servers != 0 {
+		t.Errorf("the zones of the domain were kept: %+v", stats)
+	}
+	// The zone is discovered again for the names queried later
+	before := lookup.count()
+	if resp, err := pool.QueryBlocking(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA)); err != nil || resp == nil || !resp.Authoritative {
+		t.Errorf("the query returned %v, %v", resp, err)
+	}
+	if lookup.count() == before {
+		t.Error("the forgotten zone was not discovered again")
+	}
+}
+
+func TestReferrals(t *testing.T) {
+	lookup := newFakeResolver(owaspZone)
+	refer := func(msg *dns.Msg) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(msg)
+		ns, _ := dns.NewRR("dev.owasp.org. 300 IN NS ns.dev.owasp.org.")
+		glue, _ := dns.NewRR("ns.dev.owasp.org. 300 IN A 192.0.2.10")
+		resp.Ns = append(resp.Ns, ns)
+		resp.Extra = append(resp.Extra, glue)
+		return resp, nil
+	}
+	servers := &fakeServers{handlers: map[string]func(*dns.Msg) (*dns.Msg, error){
+		"192.0.2.1:53":  refer,
+		"192.0.2.2:53":  refer,
+		"192.0.2.10:53": answer("api.dev.owasp.org. 300 IN A 198.51.100.2"),
+	}}
+	z := newTestZones(lookup, servers, nil)
+	pool := z.NewPool(newFakeResolver(nil))
+
+	if _, err := pool.QueryBlocking(context.Background(), resolve.QueryMsg("owasp.org", dns.TypeSOA)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := pool.QueryBlocking(context.Background(), resolve.QueryMsg("api.dev.owasp.org", dns.TypeA))
+	if err != nil || resp == nil || !resp.Authoritative || len(resp.Answer) != 1 {
+		t.Fatalf("the query returned %v, %v", resp, err)
+	}
+
+	// The zone cut is cached, so the next names under it are sent straight to its servers
+	before := servers.count("192.0.2.1:53") + servers.count("192.0.2.2:53")
+	_, _ = pool.QueryBlocking(context.Background(), resolve.QueryMsg("web.dev.owasp.org", dns.TypeA))
+	if after := servers.count("192.0.2.1:53") + servers.count("192.0.2.2:53"); after != before || servers.count("192.0.2.10:53") != 2 {
+		t.Errorf("the zone cut was not cached: %v", servers.asked)
+	}
+	if stats := z.Stats(); stats.Zones != 2 || stats.Referrals != 1 {
+		t.Errorf("the statistics were %+v", stats)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	lookup := newFakeResolver(owaspZone)
+	// The authoritative servers do not respond
+	servers := &fakeServers{}
+	z := newTestZones(lookup, servers, nil)
+	now := time.Now()
+	z.clock = clock.NewFake(now)
+	fallback := newFakeResolver(map[string][]string{"A www.owasp.org": {"www.owasp.org. 300 IN A 198.51.100.1"}})
+	pool := z.NewPool(fallback)
+
+	for i := 0; i < maxFailures+1; i++ {
+		resp, err := pool.QueryBlocking(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA))
+		if err != nil || resp == nil || resp.Authoritative || len(resp.Answer) != 1 {
+			t.Fatalf("the fallback returned %v, %v", resp, err)
+		}
+	}
+	// The unresponsive servers are left out of the rotation until they are tried again
+	if n := servers.count("192.0.2.1:53"); n != maxFailures {
+		t.Errorf("the unresponsive server was asked %d times, expected %d", n, maxFailures)
+	}
+	if stats := z.Stats(); stats.Fallbacks != maxFailures+1 || stats.Direct != 0 {
+		t.Errorf("the statistics were %+v", stats)
+	}
+	z.clock.(*clock.Fake).Jump(holdTime)
+	_, _ = pool.QueryBlocking(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA))
+	if n := servers.count("192.0.2.1:53"); n != maxFailures+1 {
+		t.Errorf("the server was not tried again after the hold time")
+	}
+
+	// The names without a discovered zone are not looked up again until later
+	before := lookup.count()
+	for i := 0; i < 3; i++ {
+		_, _ = pool.QueryBlocking(context.Background(), resolve.QueryMsg("www.example.com", dns.TypeA))
+	}
+	if n := lookup.count() - before; n != 1 {
+		t.Errorf("the missing zone was looked up %d times, expected 1", n)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	lookup := newFakeResolver(owaspZone)
+	handler := answer("www.owasp.org. 300 IN A 198.51.100.1")
+	servers := &fakeServers{handlers: map[string]func(*dns.Msg) (*dns.Msg, error){"192.0.2.1:53": handler, "192.0.2.2:53": handler}}
+	// The second nameserver is on the never-touch list
+	z := newTestZones(lookup, servers, &Options{QPS: 1, Allow: func(addr string) bool { return addr != "192.0.2.2" }})
+	fake := clock.NewFake(time.Now())
+	z.clock = fake
+	pool := z.NewPool(newFakeResolver(nil))
+
+	for i := 0; i < 3; i++ {
+		_, _ = pool.QueryBlocking(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA))
+	}
+	if servers.count("192.0.2.2:53") != 0 || servers.count("192.0.2.1:53") != 3 {
+		t.Errorf("the servers were asked %v", servers.asked)
+	}
+	// The server is limited to a query per second
+	if slept, _ := fake.Slept(); slept < 2*time.Second {
+		t.Errorf("the queries waited %v for the rate limit, expected 2s", slept)
+	}
+}
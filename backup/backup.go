@@ -0,0 +1,393 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package backup writes the graph database to a portable archive, and restores the archive into an empty
+// graph database of any system. Restoring the archive of one system into another migrates the graph.
+// The custom nodes and edges registered through the custom package are archived beside the assets and
+// relations, when a custom store is registered for the graph.
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/format"
+	"github.com/owasp-amass/asset-db/repository"
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+)
+
+// FileVersion is the version of the archive format written by Snapshot.
+const FileVersion = 1
+
+// maxLineLength is the longest archive line that is read.
+const maxLineLength = 1 << 20
+
+// ErrNotEmpty is returned when the graph database the archive would be restored into already holds assets.
+var ErrNotEmpty = errors.New("the graph database is not empty")
+
+// assetTypes are the types of the assets kept by the graph, in the order they are archived.
+var assetTypes = []oam.AssetType{oam.FQDN, oam.IPAddress, oam.Netblock, oam.ASN, oam.RIROrg}
+
+// The kinds of the archive lines.
+const (
+	kindMetadata = "metadata"
+	kindAsset    = "asset"
+	kindRelation = "relation"
+)
+
+// Metadata describes the graph held by an archive.
+type Metadata struct {
+	Version   int       `json:"version"`
+	Amass     string    `json:"amass"`
+	Created   time.Time `json:"created"`
+	Assets    int       `json:"assets"`
+	Relations int       `json:"relations"`
+}
+
+// line is a line of the archive, which is JSON Lines starting with the metadata, followed by the assets and
+// then the relations between them. The assets are referenced by their identifiers in the archived graph.
+type line struct {
+	Kind      string          `json:"kind"`
+	Metadata  *Metadata       `json:"metadata,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Type      string          `json:"type,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	From      string          `json:"from,omitempty"`
+	To        string          `json:"to,omitempty"`
+	CreatedAt *time.Time      `json:"created_at,omitempty"`
+	LastSeen  *time.Time      `json:"last_seen,omitempty"`
+}
+
+// Snapshot writes the assets and relations of the graph to the archive. The relations created while the
+// snapshot is taken, which lead to assets missing from the archive, are left out, so the archive is always
+// a complete graph. Taking the snapshot only reads the graph, so the database can be open read-only.
+func Snapshot(ctx context.Context, g *netmap.Graph, dst io.Writer) (*Metadata, error) {
+	var assets []*types.Asset
+	for _, atype := range assetTypes {
+		// The graph returns an error when it holds no assets of the type
+		if found, err := g.DB.FindByType(atype, time.Time{}); err == nil {
+			assets = append(assets, sortByID(found)...)
+		}
+	}
+
+	var nodes []*custom.Node
+	var edges []*custom.Edge
+	if cs := custom.For(g); cs != nil {
+		var err error
+		if nodes, err = cs.FindNodes(ctx, time.Time{}); err != nil {
+			return nil, fmt.Errorf("failed to read the custom nodes: %v", err)
+		}
+		if edges, err = cs.Edges(ctx, time.Time{}); err != nil {
+			return nil, fmt.Errorf("failed to read the custom edges: %v", err)
+		}
+	}
+
+	ids := make(map[string]struct{}, len(assets)+len(nodes))
+	for _, a := range assets {
+		ids[a.ID] = struct{}{}
+	}
+	for _, n := range nodes {
+		ids[n.ID] = struct{}{}
+	}
+
+	var rels []*types.Relation
+	for _, a := range assets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		out, err := g.DB.OutgoingRelations(a, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the relations of asset %s: %v", a.ID, err)
+		}
+		for _, rel := range out {
+			// The custom edges are archived with the rest of the custom data
+			if custom.IsCustom(rel.Type) {
+				continue
+			}
+			if _, found := ids[rel.ToAsset.ID]; found {
+				rels = append(rels, rel)
+			}
+		}
+	}
+
+	var cedges []*custom.Edge
+	for _, e := range edges {
+		_, from := ids[e.From]
+		_, to := ids[e.To]
+		if from && to {
+			cedges = append(cedges, e)
+		}
+	}
+
+	meta := &Metadata{
+		Version:   FileVersion,
+		Amass:     format.Version,
+		Created:   time.Now().UTC(),
+		Assets:    len(assets) + len(nodes),
+		Relations: len(rels) + len(cedges),
+	}
+
+	w := bufio.NewWriter(dst)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&line{Kind: kindMetadata, Metadata: meta}); err != nil {
+		return nil, err
+	}
+	for _, a := range assets {
+		content, err := a.Asset.JSON()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode asset %s: %v", a.ID, err)
+		}
+
+		created, seen := a.CreatedAt, a.LastSeen
+		if err := enc.Encode(&line{
+			Kind:      kindAsset,
+			ID:        a.ID,
+			Type:      string(a.Asset.AssetType()),
+			Content:   content,
+			CreatedAt: &created,
+			LastSeen:  &seen,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range nodes {
+		content, err := n.Content()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode custom node %s: %v", n.ID, err)
+		}
+
+		created, seen := n.CreatedAt, n.LastSeen
+		if err := enc.Encode(&line{
+			Kind:      kindAsset,
+			ID:        n.ID,
+			Type:      n.Type,
+			Content:   content,
+			CreatedAt: &created,
+			LastSeen:  &seen,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, rel := range rels {
+		created, seen := rel.CreatedAt, rel.LastSeen
+		if err := enc.Encode(&line{
+			Kind:      kindRelation,
+			Type:      rel.Type,
+			From:      rel.FromAsset.ID,
+			To:        rel.ToAsset.ID,
+			CreatedAt: &created,
+			LastSeen:  &seen,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range cedges {
+		created, seen := e.CreatedAt, e.LastSeen
+		if err := enc.Encode(&line{
+			Kind:      kindRelation,
+			Type:      e.Predicate,
+			From:      e.From,
+			To:        e.To,
+			CreatedAt: &created,
+			LastSeen:  &seen,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return meta, w.Flush()
+}
+
+// sortByID orders the assets by their numeric identifiers, so the same graph is always archived the same way.
+func sortByID(assets []*types.Asset) []*types.Asset {
+	sort.SliceStable(assets, func(i, j int) bool {
+		a, aerr := strconv.ParseInt(assets[i].ID, 10, 64)
+		b, berr := strconv.ParseInt(assets[j].ID, 10, 64)
+		if aerr != nil || berr != nil {
+			return assets[i].ID < assets[j].ID
+		}
+		return a < b
+	})
+	return assets
+}
+
+// Restore writes the graph held by the archive into the graph database, which must be empty. The graph
+// databases set the created and last seen times of the assets and relations at the time of the restore,
+// while the archive keeps the original times, which the custom nodes and edges are restored with.
+// The metadata of the archive is returned.
+func Restore(ctx context.Context, g *netmap.Graph, src io.Reader) (*Metadata, error) {
+	cs := custom.For(g)
+	if err := checkEmpty(ctx, g, cs); err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	var meta *Metadata
+	var nassets, nrels int
+	restored := make(map[string]*types.Asset)
+	// The identifiers of the custom nodes and the assets restored, since the custom edges may lead to either
+	ids := make(map[string]string)
+	for n := 1; scanner.Scan(); n++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if meta == nil && l.Kind != kindMetadata {
+			return nil, fmt.Errorf("line %d: the archive does not start with its metadata", n)
+		}
+
+		switch l.Kind {
+		case kindMetadata:
+			if meta != nil || l.Metadata == nil {
+				return nil, fmt.Errorf("line %d: unexpected metadata", n)
+			}
+			if l.Metadata.Version > FileVersion {
+				return nil, fmt.Errorf("the archive version %d is not supported", l.Metadata.Version)
+			}
+			meta = l.Metadata
+		case kindAsset:
+			if custom.IsCustom(l.Type) {
+				id, err := restoreNode(ctx, cs, &l)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				ids[l.ID] = id
+				nassets++
+				continue
+			}
+
+			asset, err := repository.Asset{Type: l.Type, Content: []byte(l.Content)}.Parse()
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+
+			a, err := g.DB.Create(nil, "", asset)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: failed to restore the asset: %v", n, err)
+			}
+			restored[l.ID] = a
+			ids[l.ID] = a.ID
+			nassets++
+		case kindRelation:
+			if custom.IsCustom(l.Type) {
+				if err := restoreEdge(ctx, cs, ids, &l); err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				nrels++
+				continue
+			}
+
+			from, found := restored[l.From]
+			if !found {
+				return nil, fmt.Errorf("line %d: the relation is from asset %s, which is not in the archive", n, l.From)
+			}
+			to, found := restored[l.To]
+			if !found {
+				return nil, fmt.Errorf("line %d: the relation is to asset %s, which is not in the archive", n, l.To)
+			}
+
+			if _, err := g.DB.Create(from, l.Type, to.Asset); err != nil {
+				return nil, fmt.Errorf("line %d: failed to restore the relation: %v", n, err)
+			}
+			nrels++
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q", n, l.Kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if meta == nil {
+		return nil, errors.New("the archive is empty")
+	}
+	if nassets != meta.Assets || nrels != meta.Relations {
+		return meta, fmt.Errorf("the archive is truncated: restored %d of %d assets and %d of %d relations",
+			nassets, meta.Assets, nrels, meta.Relations)
+	}
+	return meta, nil
+}
+
+func restoreNode(ctx context.Context, cs *custom.Store, l *line) (string, error) {
+	if cs == nil {
+		return "", fmt.Errorf("the graph database cannot store the custom node type %s", l.Type)
+	}
+
+	key, data, err := custom.ParseContent(l.Content)
+	if err != nil {
+		return "", err
+	}
+
+	node := &custom.Node{Type: l.Type, Key: key, Data: data}
+	if l.CreatedAt != nil {
+		node.CreatedAt = *l.CreatedAt
+	}
+	if l.LastSeen != nil {
+		node.LastSeen = *l.LastSeen
+	}
+
+	restored, err := cs.PutNode(ctx, node)
+	if err != nil {
+		return "", fmt.Errorf("failed to restore the custom node: %v", err)
+	}
+	return restored.ID, nil
+}
+
+func restoreEdge(ctx context.Context, cs *custom.Store, ids map[string]string, l *line) error {
+	if cs == nil {
+		return fmt.Errorf("the graph database cannot store the custom predicate %s", l.Type)
+	}
+
+	from, found := ids[l.From]
+	if !found {
+		return fmt.Errorf("the relation is from asset %s, which is not in the archive", l.From)
+	}
+	to, found := ids[l.To]
+	if !found {
+		return fmt.Errorf("the relation is to asset %s, which is not in the archive", l.To)
+	}
+
+	edge := &custom.Edge{Predicate: l.Type, From: from, To: to}
+	if l.CreatedAt != nil {
+		edge.CreatedAt = *l.CreatedAt
+	}
+	if l.LastSeen != nil {
+		edge.LastSeen = *l.LastSeen
+	}
+
+	if _, err := cs.PutEdge(ctx, edge); err != nil {
+		return fmt.Errorf("failed to restore the custom edge: %v", err)
+	}
+	return nil
+}
+
+func checkEmpty(ctx context.Context, g *netmap.Graph, cs *custom.Store) error {
+	for _, atype := range assetTypes {
+		if assets, err := g.DB.FindByType(atype, time.Time{}); err == nil && len(assets) > 0 {
+			return ErrNotEmpty
+		}
+	}
+	if cs != nil {
+		if nodes, err := cs.FindNodes(ctx, time.Time{}); err == nil && len(nodes) > 0 {
+			return ErrNotEmpty
+		}
+	}
+	return nil
+}
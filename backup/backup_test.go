@@ -0,0 +1,148 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+)
+
+func testGraph(t *testing.T) *netmap.Graph {
+	ctx := context.Background()
+	g := netmap.NewGraph("memory", "", "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+
+	for _, err := range []error{
+		g.UpsertA(ctx, "www.owasp.org", "192.168.1.1"),
+		g.UpsertAAAA(ctx, "www.owasp.org", "2001:db8::1"),
+		g.UpsertCNAME(ctx, "docs.owasp.org", "www.owasp.org"),
+		g.UpsertMX(ctx, "owasp.org", "mail.owasp.org"),
+		g.UpsertInfrastructure(ctx, 26808, "UTICA-COLLEGE - Utica College", "192.168.1.1", "192.168.1.0/24"),
+	} {
+		if err != nil {
+			t.Fatalf("failed to populate the graph: %v", err)
+		}
+	}
+	return g
+}
+
+// countGraph returns the number of assets and relations in the graph.
+func countGraph(g *netmap.Graph) (int, int) {
+	var assets, rels int
+
+	for _, atype := range assetTypes {
+		found, err := g.DB.FindByType(atype, time.Time{})
+		if err != nil {
+			continue
+		}
+
+		assets += len(found)
+		for _, a := range found {
+			if out, err := g.DB.OutgoingRelations(a, time.Time{}); err == nil {
+				rels += len(out)
+			}
+		}
+	}
+	return assets, rels
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	src := testGraph(t)
+	defer src.Remove()
+
+	var archive bytes.Buffer
+	meta, err := Snapshot(ctx, src, &archive)
+	if err != nil {
+		t.Fatalf("failed to take the snapshot: %v", err)
+	}
+
+	assets, rels := countGraph(src)
+	if meta.Assets != assets || meta.Relations != rels || meta.Version != FileVersion {
+		t.Errorf("the metadata %+v does not match the %d assets and %d relations", meta, assets, rels)
+	}
+
+	// The archive of the in-memory graph migrates it to the local database
+	dst := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if dst == nil {
+		t.Fatal("failed to create the local graph")
+	}
+	defer dst.Remove()
+
+	data := archive.Bytes()
+	if _, err := Restore(ctx, dst, bytes.NewReader(data)); err != nil {
+		t.Fatalf("failed to restore the archive: %v", err)
+	}
+	if a, r := countGraph(dst); a != assets || r != rels {
+		t.Errorf("restored %d assets and %d relations, expected %d and %d", a, r, assets, rels)
+	}
+
+	since := time.Time{}
+	pairs, err := dst.NamesToAddrs(ctx, since, "www.owasp.org", "docs.owasp.org")
+	if err != nil || len(pairs) != 4 {
+		t.Errorf("the restored names resolve to %d addresses, expected 4: %v", len(pairs), err)
+	}
+	if !dst.IsMXNode(ctx, "mail.owasp.org", since) {
+		t.Error("the restored mail server is missing its MX record")
+	}
+	if desc := dst.ReadASDescription(ctx, 26808, since); desc != "UTICA-COLLEGE - Utica College" {
+		t.Errorf("the restored AS description is %q", desc)
+	}
+	if prefixes := dst.ReadASPrefixes(ctx, 26808, since); len(prefixes) != 1 || prefixes[0] != "192.168.1.0/24" {
+		t.Errorf("the restored AS prefixes are %v", prefixes)
+	}
+
+	// The archive is only restored into an empty graph
+	if _, err := Restore(ctx, dst, bytes.NewReader(data)); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("restoring into the populated graph returned %v", err)
+	}
+
+	// The same graph is always archived the same way, apart from the creation time of the archive
+	var again bytes.Buffer
+	if _, err := Snapshot(ctx, src, &again); err != nil {
+		t.Fatalf("failed to take the second snapshot: %v", err)
+	}
+	first := strings.SplitN(archive.String(), "\n", 2)[1]
+	second := strings.SplitN(again.String(), "\n", 2)[1]
+	if first != second {
+		t.Error("the snapshots of the same graph differ")
+	}
+}
+
+func TestRestoreTruncated(t *testing.T) {
+	ctx := context.Background()
+	src := testGraph(t)
+	defer src.Remove()
+
+	var archive bytes.Buffer
+	if _, err := Snapshot(ctx, src, &archive); err != nil {
+		t.Fatalf("failed to take the snapshot: %v", err)
+	}
+	lines := strings.SplitAfter(archive.String(), "\n")
+	truncated := strings.Join(lines[:len(lines)-3], "")
+
+	dst := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if dst == nil {
+		t.Fatal("failed to create the local graph")
+	}
+	defer dst.Remove()
+
+	if _, err := Restore(ctx, dst, strings.NewReader(truncated)); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("restoring the truncated archive returned %v", err)
+	}
+	if _, err := Restore(ctx, netmap.NewGraph("local", filepath.Join(t.TempDir(), "empty.sqlite"), ""),
+		strings.NewReader(lines[1])); err == nil {
+		t.Error("the archive without its metadata was restored")
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bandwidth accounts for the bytes an enumeration sends and receives over DNS and HTTP,
+// and enforces the optional budget on their total. The bytes are counted where they cross the
+// sockets whenever possible, so the savings of the name compression, TLS and the HTTP content
+// encodings are reflected in the counts, rather than the size of the logical payloads.
+package bandwidth
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// The protocols the bytes are accounted for.
+const (
+	DNS  = "dns"
+	HTTP = "http"
+)
+
+// Counter holds the number of bytes sent and received.
+type Counter struct {
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+}
+
+// Total returns the number of bytes sent and received.
+func (c Counter) Total() int64 {
+	return c.Sent + c.Received
+}
+
+func (c *Counter) add(sent, received int64) {
+	c.Sent += sent
+	c.Received += received
+}
+
+// Stats aggregates the bytes accounted for by a Meter.
+type Stats struct {
+	Total Counter `json:"total"`
+	// Protocols holds the bytes of each protocol, such as DNS and HTTP
+	Protocols map[string]Counter `json:"protocols"`
+	// Components holds the bytes of each part of the enumeration, such as the resolver pools and the data sources
+	Components map[string]Counter `json:"components"`
+	// Sources holds the bytes of each data source that made HTTP requests
+	Sources map[string]Counter `json:"sources,omitempty"`
+	// Budget is the maximum number of bytes, which is unlimited when zero
+	Budget    int64 `json:"budget,omitempty"`
+	Exhausted bool  `json:"exhausted,omitempty"`
+}
+
+// ProtocolNames returns the protocols of the statistics, sorted by the name.
+func (s Stats) ProtocolNames() []string {
+	names := make([]string, 0, len(s.Protocols))
+	for name := range s.Protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type key struct {
+	protocol  string
+	component string
+	source    string
+}
+
+// Meter accounts for the bytes of an enumeration, and signals once the budget has been exhausted.
+// A nil Meter accounts for nothing.
+type Meter struct {
+	sync.Mutex
+	budget    atomic.Int64
+	spent     atomic.Int64
+	counters  map[key]*Counter
+	done      chan struct{}
+	exhausted sync.Once
+}
+
+// NewMeter returns a Meter enforcing the budget on the total bytes, which is unlimited when not positive.
+func NewMeter(budget int64) *Meter {
+	m := &Meter{
+		counters: make(map[key]*Counter),
+		done:     make(chan struct{}),
+	}
+	m.SetBudget(budget)
+	return m
+}
+
+// SetBudget changes the maximum number of bytes, which is unlimited when not positive.
+func (m *Meter) SetBudget(budget int64) {
+	if m == nil {
+		return
+	}
+	if budget < 0 {
+		budget = 0
+	}
+	m.budget.Store(budget)
+	m.check(m.spent.Load())
+}
+
+// Add accounts for the bytes sent and received by the component, on behalf of the data source when it is not empty.
+func (m *Meter) Add(protocol, component, source string, sent, received int64) {
+	if m == nil || (sent <= 0 && received <= 0) {
+		return
+	}
+
+	k := key{protocol: protocol, component: component, source: source}
+	m.Lock()
+	c, found := m.counters[k]
+	if !found {
+		c = new(Counter)
+		m.counters[k] = c
+	}
+	c.add(sent, received)
+	m.Unlock()
+
+	m.check(m.spent.Add(sent + received))
+}
+
+func (m *Meter) check(total int64) {
+	if budget := m.budget.Load(); budget > 0 && total > budget {
+		m.exhausted.Do(func() { close(m.done) })
+	}
+}
+
+// Func returns a function accounting for the bytes of the component, as required by the transports.
+func (m *Meter) Func(protocol, component string) func(sent, received int) {
+	if m == nil {
+		return nil
+	}
+	return func(sent, received int) {
+		m.Add(protocol, component, "", int64(sent), int64(received))
+	}
+}
+
+// Spent returns the number of bytes sent and received so far.
+func (m *Meter) Spent() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.spent.Load()
+}
+
+// Budget returns the maximum number of bytes, which is unlimited when zero.
+func (m *Meter) Budget() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.budget.Load()
+}
+
+// Done returns a channel that is closed once the budget has been exhausted, or nil when there is no budget.
+func (m *Meter) Done() <-chan struct{} {
+	if m == nil || m.budget.Load() == 0 {
+		return nil
+	}
+	return m.done
+}
+
+// Exhausted returns true once the bytes sent and received have exceeded the budget.
+func (m *Meter) Exhausted() bool {
+	if m == nil {
+		return false
+	}
+
+	select {
+	case <-m.done:
+		return true
+	default:
+	}
+	return false
+}
+
+// Stats returns the bytes accounted for so far, aggregated by protocol, component and data source.
+func (m *Meter) Stats() Stats {
+	s := Stats{
+		Protocols:  make(map[string]Counter),
+		Components: make(map[string]Counter),
+	}
+	if m == nil {
+		return s
+	}
+
+	s.Budget = m.budget.Load()
+	s.Exhausted = m.Exhausted()
+
+	m.Lock()
+	defer m.Unlock()
+
+	for k, c := range m.counters {
+		s.Total.add(c.Sent, c.Received)
+
+		p := s.Protocols[k.protocol]
+		p.add(c.Sent, c.Received)
+		s.Protocols[k.protocol] = p
+
+		comp := s.Components[k.component]
+		comp.add(c.Sent, c.Received)
+		s.Components[k.component] = comp
+
+		if k.source != "" {
+			if s.Sources == nil {
+				s.Sources = make(map[string]Counter)
+			}
+			src := s.Sources[k.source]
+			src.add(c.Sent, c.Received)
+			s.Sources[k.source] = src
+		}
+	}
+	return s
+}
+
+// MsgSize returns the number of bytes the DNS message takes on the wire, with the names compressed as the
+// servers send them. The message is not modified.
+func MsgSize(msg *dns.Msg) int {
+	if msg == nil {
+		return 0
+	}
+
+	c := *msg
+	c.Compress = true
+	return c.Len()
+}
+
+type meterKey struct{}
+
+type sourceKey struct{}
+
+// WithMeter returns a context carrying the Meter, which accounts for the HTTP requests made with the context.
+func WithMeter(ctx context.Context, m *Meter) context.Context {
+	if m == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, meterKey{}, m)
+}
+
+// FromContext returns the Meter carried by the context, or nil.
+func FromContext(ctx context.Context) *Meter {
+	if ctx == nil {
+		return nil
+	}
+	m, _ := ctx.Value(meterKey{}).(*Meter)
+	return m
+}
+
+// WithSource returns a context carrying the name of the data source the HTTP requests are made for.
+func WithSource(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, name)
+}
+
+// SourceFromContext returns the name of the data source carried by the context, or the empty string.
+func SourceFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	name, _ := ctx.Value(sourceKey{}).(string)
+	return name
+}
+
+// NewConn returns the connection with the bytes written and read passed to the count function. The
+// packet connections are still seen as such, so the DNS clients keep framing the messages for UDP.
+func NewConn(c net.Conn, count func(sent, received int)) net.Conn {
+	if c == nil || count == nil {
+		return c
+	}
+
+	mc := &conn{Conn: c, count: count}
+	if pc, ok := c.(net.PacketConn); ok {
+		return &packetConn{conn: mc, pc: pc}
+	}
+	return mc
+}
+
+type conn struct {
+	net.Conn
+	count func(sent, received int)
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.count(0, n)
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.count(n, 0)
+	}
+	return n, err
+}
+
+type packetConn struct {
+	*conn
+	pc net.PacketConn
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(b)
+	if n > 0 {
+		c.count(0, n)
+	}
+	return n, addr, err
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.pc.WriteTo(b, addr)
+	if n > 0 {
+		c.count(n, 0)
+	}
+	return n, err
+}
@@ -0,0 +1,198 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package bandwidth
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMeter(t *testing.T) {
+	m := NewMeter(0)
+	m.Add(DNS, "resolvers", "", 100, 400)
+	m.Add(DNS, "trusted_resolvers", "", 50, 150)
+	m.Add(HTTP, "data_sources", "crtsh", 300, 5000)
+	m.Add(HTTP, "data_sources", "HackerTarget", 200, 1000)
+	m.Add(HTTP, "enumeration", "", 10, 20)
+
+	s := m.Stats()
+	if s.Total != (Counter{Sent: 660, Received: 6570}) || s.Total.Total() != m.Spent() {
+		t.Errorf("the total was %+v", s.Total)
+	}
+	if !reflect.DeepEqual(s.ProtocolNames(), []string{DNS, HTTP}) ||
+		s.Protocols[DNS] != (Counter{Sent: 150, Received: 550}) || s.Protocols[HTTP] != (Counter{Sent: 510, Received: 6020}) {
+		t.Errorf("the protocols were %+v", s.Protocols)
+	}
+	if s.Components["data_sources"] != (Counter{Sent: 500, Received: 6000}) || len(s.Components) != 4 {
+		t.Errorf("the components were %+v", s.Components)
+	}
+	if s.Sources["crtsh"] != (Counter{Sent: 300, Received: 5000}) || len(s.Sources) != 2 {
+		t.Errorf("the sources were %+v", s.Sources)
+	}
+	if m.Done() != nil || m.Exhausted() || s.Exhausted {
+		t.Error("the meter without a budget was exhausted")
+	}
+
+	var nilMeter *Meter
+	nilMeter.Add(DNS, "resolvers", "", 1, 1)
+	if nilMeter.Spent() != 0 || nilMeter.Exhausted() || nilMeter.Func(DNS, "resolvers") != nil ||
+		len(nilMeter.Stats().Protocols) != 0 {
+		t.Error("the nil meter accounted for the bytes")
+	}
+}
+
+func TestBudget(t *testing.T) {
+	m := NewMeter(1000)
+	count := m.Func(DNS, "resolvers")
+
+	count(400, 600)
+	if m.Exhausted() {
+		t.Fatal("the budget was exhausted once it was reached")
+	}
+	count(1, 0)
+	select {
+	case <-m.Done():
+	default:
+		t.Fatal("the budget was not exhausted once it was exceeded")
+	}
+	if s := m.Stats(); !s.Exhausted || s.Budget != 1000 {
+		t.Errorf("the statistics were %+v", s)
+	}
+	// Exceeding the budget again is harmless
+	count(100, 100)
+
+	// A budget set below the bytes already spent is exhausted immediately
+	m = NewMeter(0)
+	m.Add(HTTP, "enumeration", "", 500, 500)
+	m.SetBudget(100)
+	if !m.Exhausted() {
+		t.Error("the budget set below the bytes spent was not exhausted")
+	}
+}
+
+func TestMsgSize(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.owasp.org.", dns.TypeA)
+	for i := 0; i < 10; i++ {
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "www.owasp.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, byte(i)),
+		})
+	}
+
+	uncompressed := msg.Len()
+	c := msg.Copy()
+	c.Compress = true
+	data, err := c.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The size is the one on the wire, where the repeated names are compressed
+	if size := MsgSize(msg); size != len(data) || size >= uncompressed {
+		t.Errorf("the size was %d, expected %d below %d", size, len(data), uncompressed)
+	}
+	if msg.Compress {
+		t.Error("the message was modified")
+	}
+	if MsgSize(nil) != 0 {
+		t.Error("the nil message has a size")
+	}
+}
+
+func TestContext(t *testing.T) {
+	m := NewMeter(0)
+	ctx := WithSource(WithMeter(context.Background(), m), "crtsh")
+	if FromContext(ctx) != m || SourceFromContext(ctx) != "crtsh" {
+		t.Error("the context did not carry the meter and the source")
+	}
+	if WithMeter(context.Background(), nil) != context.Background() || FromContext(context.Background()) != nil {
+		t.Error("the context carried a nil meter")
+	}
+}
+
+func TestNewConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	var sent, received int
+	c := NewConn(client, func(s, r int) {
+		sent += s
+		received += r
+	})
+	defer c.Close()
+
+	go func() {
+		buf := make([]byte, 5)
+		if _, err := server.Read(buf); err == nil {
+			_, _ = server.Write([]byte("pong!!"))
+		}
+	}()
+	if _, err := c.Write([]byte("ping!")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 5 || received != n || n != 6 {
+		t.Errorf("the connection counted %d bytes sent and %d received", sent, received)
+	}
+	if _, ok := c.(net.PacketConn); ok {
+		t.Error("the stream connection was seen as a packet connection")
+	}
+}
+
+func TestNewPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		_ = w.WriteMsg(m)
+	})}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+	defer func() { _ = srv.Shutdown() }()
+
+	udp, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent, received int
+	c := NewConn(udp, func(s, r int) {
+		sent += s
+		received += r
+	})
+	defer c.Close()
+	// The DNS client frames the messages for UDP only when it sees a packet connection
+	if _, ok := c.(net.PacketConn); !ok {
+		t.Fatal("the packet connection was not seen as such")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.owasp.org.", dns.TypeA)
+	client := &dns.Client{Net: "udp"}
+	resp, _, err := client.ExchangeWithConn(msg, &dns.Conn{Conn: c})
+	if err != nil || resp == nil {
+		t.Fatalf("the exchange failed: %v", err)
+	}
+
+	data, _ := msg.Pack()
+	if sent != len(data) || received != resp.Len() {
+		t.Errorf("the connection counted %d bytes sent and %d received, expected %d and %d", sent, received, len(data), resp.Len())
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package blacklist compiles the entries of the scope blacklist into the rules matched against each candidate
+// name. A plain entry blacklists the name and every name under it, an entry holding the '*' or '?' wildcards is
+// a glob matched against the whole name, and an entry prefixed by 're:' is a regular expression. An entry prefixed
+// by '!' is an exception written in any of those syntaxes, which is evaluated after the other rules, so a name
+// matching an exception is never blacklisted. The rules are compiled once, since they are evaluated per candidate.
+package blacklist
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// RegexPrefix starts the entries holding a regular expression
+	RegexPrefix = "re:"
+	// ExceptionPrefix starts the entries naming the exceptions to the other rules
+	ExceptionPrefix = "!"
+)
+
+// List holds the compiled rules of a blacklist. The rules can be extended while the list is being evaluated,
+// such as when the enumeration blacklists the subdomains found to be false positives.
+type List struct {
+	sync.Mutex
+	rules atomic.Pointer[rules]
+}
+
+type rules struct {
+	entries []string
+	match   ruleSet
+	except  ruleSet
+}
+
+// ruleSet keeps the plain entries in a map looked up with each suffix of the name, so their number does not
+// slow the evaluation down, and the globs and regular expressions compiled in the order they were provided.
+type ruleSet struct {
+	suffixes map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// FromConfig compiles the blacklist of the configuration.
+func FromConfig(cfg *config.Config) (*List, error) {
+	if cfg == nil {
+		return Compile(nil)
+	}
+	return Compile(cfg.Scope.Blacklist)
+}
+
+// Compile returns the List of the rules in the entries, or an error naming the first entry that is not valid.
+func Compile(entries []string) (*List, error) {
+	r, err := compile(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	l := new(List)
+	l.rules.Store(r)
+	return l, nil
+}
+
+func compile(entries []string) (*rules, error) {
+	r := &rules{
+		match:  ruleSet{suffixes: make(map[string]struct{})},
+		except: ruleSet{suffixes: make(map[string]struct{})},
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		set := &r.match
+		rule := entry
+		if strings.HasPrefix(rule, ExceptionPrefix) {
+			set = &r.except
+			rule = strings.TrimSpace(strings.TrimPrefix(rule, ExceptionPrefix))
+		}
+		if err := set.add(rule); err != nil {
+			return nil, fmt.Errorf("the blacklist entry %q is not valid: %v", entry, err)
+		}
+		r.entries = append(r.entries, entry)
+	}
+	return r, nil
+}
+
+func (s *ruleSet) add(rule string) error {
+	if strings.HasPrefix(rule, RegexPrefix) {
+		expr := strings.TrimPrefix(rule, RegexPrefix)
+		if expr == "" {
+			return fmt.Errorf("the regular expression is empty")
+		}
+
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return err
+		}
+		s.patterns = append(s.patterns, re)
+		return nil
+	}
+
+	rule = normalize(rule)
+	if rule == "" {
+		return fmt.Errorf("the entry provides no name")
+	}
+	if !strings.ContainsAny(rule, "*?") {
+		s.suffixes[rule] = struct{}{}
+		return nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range rule {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return err
+	}
+	s.patterns = append(s.patterns, re)
+	return nil
+}
+
+func (s *ruleSet) matches(name string) bool {
+	if len(s.suffixes) > 0 {
+		for suffix := name; ; {
+			if _, found := s.suffixes[suffix]; found {
+				return true
+			}
+
+			i := strings.IndexByte(suffix, '.')
+			if i < 0 {
+				break
+			}
+			suffix = suffix[i+1:]
+		}
+	}
+
+	for _, re := range s.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blacklisted returns true when the name matches a rule of the list and none of its exceptions.
+func (l *List) Blacklisted(name string) bool {
+	if l == nil {
+		return false
+	}
+
+	r := l.rules.Load()
+	n := normalize(name)
+	if n == "" || !r.match.matches(n) {
+		return false
+	}
+	return !r.except.matches(n)
+}
+
+// Add compiles the entry and adds it to the rules of the list, unless the list already holds it.
+func (l *List) Add(entry string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	entries := l.Entries()
+	for _, e := range entries {
+		if e == strings.TrimSpace(entry) {
+			return nil
+		}
+	}
+
+	r, err := compile(append(entries, entry))
+	if err != nil {
+		return err
+	}
+	l.rules.Store(r)
+	return nil
+}
+
+// Entries returns the entries the rules of the list were compiled from.
+func (l *List) Entries() []string {
+	if l == nil {
+		return nil
+	}
+
+	entries := l.rules.Load().entries
+	return append([]string(nil), entries...)
+}
+
+func normalize(name string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,123 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package blacklist
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestBlacklisted(t *testing.T) {
+	entries := []string{
+		"internal.owasp.org",
+		"*.dev.owasp.org",
+		"build-??.owasp.org",
+		"re:^cdn[0-9]+\\.owasp\\.org$",
+		"!public.internal.owasp.org",
+		"!re:^api\\.",
+		"!keep-*.dev.owasp.org",
+		"staging.owasp.org",
+		"!staging.owasp.org",
+	}
+	l, err := Compile(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		expected bool
+	}{
+		// The plain entries blacklist the name and every name under it, on the label boundaries
+		{"internal.owasp.org", true},
+		{"db.internal.owasp.org", true},
+		{"notinternal.owasp.org", false},
+		{"INTERNAL.OWASP.ORG.", true},
+		// The globs are matched against the whole name
+		{"web.dev.owasp.org", true},
+		{"a.b.dev.owasp.org", true},
+		{"dev.owasp.org", false},
+		{"build-01.owasp.org", true},
+		{"build-1.owasp.org", false},
+		// The regular expressions are matched without regard to case
+		{"cdn42.owasp.org", true},
+		{"CDN7.owasp.org", true},
+		{"cdn.owasp.org", false},
+		// The exceptions are evaluated after the matches, whatever the syntax of either
+		{"public.internal.owasp.org", false},
+		{"www.public.internal.owasp.org", false},
+		{"api.internal.owasp.org", false},
+		{"api.dev.owasp.org", false},
+		{"keep-me.dev.owasp.org", false},
+		{"drop-me.dev.owasp.org", true},
+		// The exception of the same entry wins over the match
+		{"staging.owasp.org", false},
+		{"www.owasp.org", false},
+		{"", false},
+	} {
+		if got := l.Blacklisted(tc.name); got != tc.expected {
+			t.Errorf("%q was blacklisted: %t, expected %t", tc.name, got, tc.expected)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, entry := range []string{"re:(unclosed", "re:", "!", "!re:[a-"} {
+		if _, err := Compile([]string{"owasp.org", entry}); err == nil || !strings.Contains(err.Error(), entry) {
+			t.Errorf("the entry %q was compiled: %v", entry, err)
+		}
+	}
+
+	// An empty list blacklists nothing, as does a nil list
+	l, err := Compile([]string{" ", ""})
+	if err != nil || l.Blacklisted("www.owasp.org") || len(l.Entries()) != 0 {
+		t.Errorf("the empty list was %v, %v", l, err)
+	}
+	var none *List
+	if none.Blacklisted("www.owasp.org") || none.Add("owasp.org") != nil {
+		t.Error("the nil list blacklisted a name")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.BlacklistSubdomain("!keep.owasp.org")
+	l, err := FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Add("owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add("owasp.org"); err != nil || len(l.Entries()) != 2 {
+		t.Errorf("the entries were %v after adding the same entry twice", l.Entries())
+	}
+	// The exception provided before the match still applies
+	if !l.Blacklisted("www.owasp.org") || l.Blacklisted("keep.owasp.org") {
+		t.Error("the added entry was not evaluated along with the exception")
+	}
+	if err := l.Add("re:("); err == nil || len(l.Entries()) != 2 {
+		t.Error("the entry that is not valid was added")
+	}
+}
+
+func BenchmarkBlacklisted(b *testing.B) {
+	entries := []string{"*.dev.owasp.org", "re:^cdn[0-9]+\\.", "!api.*"}
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, "host"+strings.Repeat("x", i%10)+".owasp.org")
+	}
+	l, err := Compile(entries)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Blacklisted("www.app.services.owasp.org")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package clock provides the time to the components that enforce rates and backoff,
+// so their behavior around large clock jumps can be tested without waiting.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for durations to pass.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// System is the clock of the host. Durations between its readings are measured
+// with the monotonic clock, so changes to the wall clock do not affect them.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Fake is a clock that only moves when asked to. Waiting on it advances the time
+// by the duration instead of blocking, and Jump simulates the clock being changed.
+type Fake struct {
+	sync.Mutex
+	now    time.Time
+	slept  time.Duration
+	sleeps int
+}
+
+// NewFake returns a Fake clock set to the provided time.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now implements the Clock interface.
+func (f *Fake) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.now
+}
+
+// Sleep implements the Clock interface.
+func (f *Fake) Sleep(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+
+	if d > 0 {
+		f.now = f.now.Add(d)
+		f.slept += d
+	}
+	f.sleeps++
+}
+
+// After implements the Clock interface.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.Sleep(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- f.Now()
+	return ch
+}
+
+// Jump moves the clock forward, or backward when the duration is negative, without anyone waiting.
+func (f *Fake) Jump(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// Slept returns the total duration and number of calls spent waiting on the clock.
+func (f *Fake) Slept() (time.Duration, int) {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.slept, f.sleeps
+}
@@ -0,0 +1,209 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/resources"
+	"github.com/owasp-amass/config/config"
+)
+
+// TemplatesFile is the name of the embedded candidate templates.
+const TemplatesFile = "cloud_candidates.json"
+
+// The placeholders of the candidate templates.
+const (
+	// PlaceholderOrg is replaced by the leftmost label of the registered domain of the scope, such as 'example'
+	PlaceholderOrg = "{org}"
+	// PlaceholderStem is replaced by each label stem observed among the names in scope, such as 'assets'
+	PlaceholderStem = "{stem}"
+)
+
+// Template builds the candidate names of a provider service from the naming conventions of the organization.
+type Template struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// Bucket is true for the services answering every name under their domain, such as the storage buckets,
+	// so the existence of a candidate is checked over HTTP rather than through DNS
+	Bucket bool `json:"bucket,omitempty"`
+}
+
+// ProviderTemplates are the templates of a provider, named like the provider of the classification rules.
+type ProviderTemplates struct {
+	Provider  string     `json:"provider"`
+	Templates []Template `json:"templates"`
+}
+
+// Templates are the candidate templates of each provider. The templates are data, so the candidates of
+// another provider are generated by adding its templates to a copy of the file.
+type Templates struct {
+	Version   string              `json:"version"`
+	Providers []ProviderTemplates `json:"providers"`
+}
+
+// Candidate is a name generated from a template, tagged with the provider and template that generated it.
+type Candidate struct {
+	Name     string
+	Provider string
+	// Generator identifies the template, such as 'AWS/s3'
+	Generator string
+	Bucket    bool
+}
+
+var placeholderRE = regexp.MustCompile(`\{[^}]*\}`)
+
+var labelRE = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// LoadTemplates parses the JSON candidate templates, and returns an error naming the first template that is not valid.
+func LoadTemplates(r io.Reader) (*Templates, error) {
+	var t Templates
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, fmt.Errorf("failed to parse the candidate templates: %v", err)
+	}
+
+	for i, p := range t.Providers {
+		if strings.TrimSpace(p.Provider) == "" {
+			return nil, fmt.Errorf("the candidate templates at position %d name no provider", i)
+		}
+
+		for j, tmpl := range p.Templates {
+			pattern := strings.ToLower(strings.TrimSpace(tmpl.Pattern))
+			if tmpl.Name == "" {
+				return nil, fmt.Errorf("the template %s of %s has no name", pattern, p.Provider)
+			}
+			for _, ph := range placeholderRE.FindAllString(pattern, -1) {
+				if ph != PlaceholderOrg && ph != PlaceholderStem {
+					return nil, fmt.Errorf("the template %s of %s holds the unknown placeholder %s", tmpl.Name, p.Provider, ph)
+				}
+			}
+			if !strings.Contains(pattern, PlaceholderOrg) && !strings.Contains(pattern, PlaceholderStem) {
+				return nil, fmt.Errorf("the template %s of %s holds no placeholder", tmpl.Name, p.Provider)
+			}
+			t.Providers[i].Templates[j].Pattern = pattern
+		}
+	}
+	return &t, nil
+}
+
+// DefaultTemplates returns the candidate templates embedded in the binary.
+func DefaultTemplates() (*Templates, error) {
+	f, err := resources.GetResourceFile(TemplatesFile)
+	if err != nil {
+		return nil, err
+	}
+	return LoadTemplates(f)
+}
+
+// OpenTemplates reads the candidate templates from the JSON file.
+func OpenTemplates(path string) (*Templates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadTemplates(f)
+}
+
+// TemplatesFromConfig returns the candidate templates named by the 'cloud.candidate_templates' configuration
+// option, relative to the configuration file, or the embedded templates when none were named. A file that
+// cannot be read is returned as the error, along with the embedded templates.
+func TemplatesFromConfig(cfg *config.Config) (*Templates, error) {
+	def, err := DefaultTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	var path string
+	if cfg != nil && cfg.Options != nil {
+		if opts, ok := cfg.Options["cloud"].(map[string]interface{}); ok {
+			path, _ = opts["candidate_templates"].(string)
+		}
+	}
+	if path = strings.TrimSpace(path); path == "" {
+		return def, nil
+	}
+	if !filepath.IsAbs(path) && cfg.Filepath != "" {
+		path = filepath.Join(filepath.Dir(cfg.Filepath), path)
+	}
+
+	t, err := OpenTemplates(path)
+	if err != nil {
+		return def, fmt.Errorf("failed to load the candidate templates %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// Expand applies the templates of the provider to the organization names and the stems, which are expected
+// to be ordered by their yield, and returns at most max candidates. The candidates of the templates without
+// the stem placeholder come first, and the names holding a label that is not valid are skipped.
+func (t *Templates) Expand(provider string, orgs, stems []string, max int) []Candidate {
+	if t == nil || max <= 0 {
+		return nil
+	}
+
+	var p *ProviderTemplates
+	for i := range t.Providers {
+		if strings.EqualFold(t.Providers[i].Provider, provider) {
+			p = &t.Providers[i]
+			break
+		}
+	}
+	if p == nil {
+		return nil
+	}
+
+	var candidates []Candidate
+	seen := make(map[string]struct{})
+	add := func(tmpl Template, org, stem string) bool {
+		name := strings.ReplaceAll(strings.ReplaceAll(tmpl.Pattern, PlaceholderOrg, org), PlaceholderStem, stem)
+		if _, found := seen[name]; found || !validName(name) {
+			return true
+		}
+
+		seen[name] = struct{}{}
+		candidates = append(candidates, Candidate{
+			Name:      name,
+			Provider:  p.Provider,
+			Generator: p.Provider + "/" + tmpl.Name,
+			Bucket:    tmpl.Bucket,
+		})
+		return len(candidates) < max
+	}
+
+	for _, org := range orgs {
+		for _, tmpl := range p.Templates {
+			if !strings.Contains(tmpl.Pattern, PlaceholderStem) && !add(tmpl, org, "") {
+				return candidates
+			}
+		}
+	}
+	for _, stem := range stems {
+		for _, org := range orgs {
+			for _, tmpl := range p.Templates {
+				if strings.Contains(tmpl.Pattern, PlaceholderStem) && !add(tmpl, org, stem) {
+					return candidates
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+func validName(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		if !labelRE.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+const testTemplates = `{
+  "version": "test",
+  "providers": [
+    {"provider": "AWS", "templates": [
+      {"name": "s3", "pattern": "{stem}.s3.amazonaws.com", "bucket": true},
+      {"name": "s3-org", "pattern": "{ORG}.s3.amazonaws.com", "bucket": true},
+      {"name": "beanstalk", "pattern": "{org}-{stem}.elasticbeanstalk.com"}
+    ]}
+  ]
+}`
+
+func TestExpandTemplates(t *testing.T) {
+	tmpl, err := LoadTemplates(strings.NewReader(testTemplates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The templates without a stem come first, and the stems keep their order across the templates
+	got := tmpl.Expand("aws", []string{"owasp"}, []string{"assets", "bad_label", "api"}, 10)
+	var names []string
+	for _, c := range got {
+		names = append(names, c.Name)
+	}
+	expected := []string{
+		"owasp.s3.amazonaws.com",
+		"assets.s3.amazonaws.com",
+		"owasp-assets.elasticbeanstalk.com",
+		"api.s3.amazonaws.com",
+		"owasp-api.elasticbeanstalk.com",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("the candidates were %v, expected %v", names, expected)
+	}
+	if c := got[0]; c.Provider != "AWS" || c.Generator != "AWS/s3-org" || !c.Bucket {
+		t.Errorf("the candidate was tagged %+v", c)
+	}
+	if c := got[2]; c.Generator != "AWS/beanstalk" || c.Bucket {
+		t.Errorf("the candidate was tagged %+v", c)
+	}
+
+	// The candidates of each provider are capped
+	if got := tmpl.Expand("AWS", []string{"owasp"}, []string{"assets", "api"}, 2); len(got) != 2 {
+		t.Errorf("%d candidates were generated with a cap of 2", len(got))
+	}
+	if got := tmpl.Expand("Azure", []string{"owasp"}, []string{"assets"}, 10); len(got) != 0 {
+		t.Errorf("the provider without templates generated %v", got)
+	}
+}
+
+func TestLoadTemplatesErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"providers": [{"templates": [{"name": "s3", "pattern": "{stem}.s3.amazonaws.com"}]}]}`,
+		`{"providers": [{"provider": "AWS", "templates": [{"pattern": "{stem}.s3.amazonaws.com"}]}]}`,
+		`{"providers": [{"provider": "AWS", "templates": [{"name": "s3", "pattern": "{region}.s3.amazonaws.com"}]}]}`,
+		`{"providers": [{"provider": "AWS", "templates": [{"name": "s3", "pattern": "s3.amazonaws.com"}]}]}`,
+		`{"providers": [`,
+	} {
+		if _, err := LoadTemplates(strings.NewReader(data)); err == nil {
+			t.Errorf("the templates %s were loaded", data)
+		}
+	}
+}
+
+func TestTemplatesFromConfig(t *testing.T) {
+	def, err := DefaultTemplates()
+	if err != nil || len(def.Providers) == 0 {
+		t.Fatalf("the embedded templates were not loaded: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "templates.json"), []byte(testTemplates), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig()
+	cfg.Filepath = filepath.Join(dir, "config.yaml")
+	cfg.Options["cloud"] = map[string]interface{}{"candidate_templates": "templates.json"}
+	if tmpl, err := TemplatesFromConfig(cfg); err != nil || tmpl.Version != "test" {
+		t.Errorf("the templates relative to the configuration were not loaded: %v", err)
+	}
+
+	cfg.Options["cloud"] = map[string]interface{}{"candidate_templates": "missing.json"}
+	if tmpl, err := TemplatesFromConfig(cfg); err == nil || tmpl == nil || tmpl.Version != def.Version {
+		t.Error("the embedded templates were not returned along with the error")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cloud classifies where the discovered names are hosted, such as the CDN or cloud provider
+// and the service, by matching the terminal CNAME targets and the resolved addresses against a ruleset.
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/resources"
+	"github.com/owasp-amass/config/config"
+)
+
+// RulesFile is the name of the embedded ruleset.
+const RulesFile = "cloud_rules.json"
+
+// Unknown is the provider of the names none of the rules matched.
+const Unknown = "unknown"
+
+// The parts of the names and addresses matched by the rules.
+const (
+	MatchCNAME = "cname"
+	MatchCIDR  = "cidr"
+)
+
+// Rule identifies the infrastructure of a provider service by the suffixes and patterns of the
+// CNAME targets pointing at it, and by the address ranges it is served from.
+type Rule struct {
+	Provider      string   `json:"provider"`
+	Service       string   `json:"service"`
+	CNAMESuffixes []string `json:"cname_suffixes,omitempty"`
+	CNAMEPatterns []string `json:"cname_patterns,omitempty"`
+	CIDRs         []string `json:"cidrs,omitempty"`
+}
+
+// Classification is the provider and service hosting a name, along with the rule part that matched.
+type Classification struct {
+	Provider string `json:"provider"`
+	Service  string `json:"service,omitempty"`
+	// Match is the part of the name that matched, either the CNAME target or an address
+	Match string `json:"match,omitempty"`
+	// Evidence is the CNAME target or the address that matched the rule
+	Evidence string `json:"evidence,omitempty"`
+}
+
+type pattern struct {
+	re   *regexp.Regexp
+	rule *Rule
+}
+
+type network struct {
+	ipnet *net.IPNet
+	rule  *Rule
+}
+
+// Ruleset classifies the names. The CNAME rules are checked before the address ranges, since the
+// target names the service, and the longest suffix or prefix matched wins regardless of the rule order.
+type Ruleset struct {
+	Version  string
+	suffixes map[string]*Rule
+	patterns []pattern
+	networks []network
+}
+
+type rulesFile struct {
+	Version string  `json:"version"`
+	Rules   []*Rule `json:"rules"`
+}
+
+// Load parses the JSON ruleset.
+func Load(r io.Reader) (*Ruleset, error) {
+	var f rulesFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse the ruleset: %v", err)
+	}
+
+	rs := &Ruleset{
+		Version:  f.Version,
+		suffixes: make(map[string]*Rule),
+	}
+	for _, rule := range f.Rules {
+		if rule == nil || rule.Provider == "" {
+			continue
+		}
+
+		for _, s := range rule.CNAMESuffixes {
+			if s = strings.Trim(strings.ToLower(strings.TrimSpace(s)), "."); s != "" {
+				rs.suffixes[s] = rule
+			}
+		}
+		for _, p := range rule.CNAMEPatterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("the pattern %s of %s is not valid: %v", p, rule.Provider, err)
+			}
+			rs.patterns = append(rs.patterns, pattern{re: re, rule: rule})
+		}
+		for _, c := range rule.CIDRs {
+			_, ipnet, err := net.ParseCIDR(strings.TrimSpace(c))
+			if err != nil {
+				return nil, fmt.Errorf("the CIDR %s of %s is not valid: %v", c, rule.Provider, err)
+			}
+			rs.networks = append(rs.networks, network{ipnet: ipnet, rule: rule})
+		}
+	}
+	return rs, nil
+}
+
+// Default returns the ruleset embedded in the binary.
+func Default() (*Ruleset, error) {
+	f, err := resources.GetResourceFile(RulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return Load(f)
+}
+
+// Open reads the ruleset from the JSON file.
+func Open(path string) (*Ruleset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// RulesPathFromOptions returns the path of the ruleset file in the 'cloud.rules' configuration option,
+// relative to the configuration file, or an empty string when the embedded ruleset is used.
+func RulesPathFromOptions(cfg *config.Config) string {
+	if cfg == nil || cfg.Options == nil {
+		return ""
+	}
+
+	opts, ok := cfg.Options["cloud"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	path, _ := opts["rules"].(string)
+	if path = strings.TrimSpace(path); path != "" && !filepath.IsAbs(path) && cfg.Filepath != "" {
+		path = filepath.Join(filepath.Dir(cfg.Filepath), path)
+	}
+	return path
+}
+
+// FromConfig returns the ruleset named by the configuration, or the embedded ruleset when none was named.
+// A ruleset file that cannot be read is returned as the error, along with the embedded ruleset.
+func FromConfig(cfg *config.Config) (*Ruleset, error) {
+	def, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	path := RulesPathFromOptions(cfg)
+	if path == "" {
+		return def, nil
+	}
+
+	rs, err := Open(path)
+	if err != nil {
+		return def, fmt.Errorf("failed to load the cloud ruleset %s: %v", path, err)
+	}
+	return rs, nil
+}
+
+// Classify returns the provider hosting the name, which is pointed at the target by its CNAME chain,
+// or has no CNAME when the target is empty, and resolves to the addresses. The names none of the rules
+// match are labeled unknown rather than guessed.
+func (rs *Ruleset) Classify(target string, addrs []net.IP) Classification {
+	if rs != nil {
+		if target = strings.Trim(strings.ToLower(strings.TrimSpace(target)), "."); target != "" {
+			if rule := rs.matchCNAME(target); rule != nil {
+				return Classification{Provider: rule.Provider, Service: rule.Service, Match: MatchCNAME, Evidence: target}
+			}
+		}
+
+		for _, ip := range addrs {
+			if rule := rs.matchAddr(ip); rule != nil {
+				return Classification{Provider: rule.Provider, Service: rule.Service, Match: MatchCIDR, Evidence: ip.String()}
+			}
+		}
+	}
+	return Classification{Provider: Unknown}
+}
+
+// matchCNAME returns the rule of the longest suffix of the target, or the first pattern it matches.
+func (rs *Ruleset) matchCNAME(target string) *Rule {
+	labels := strings.Split(target, ".")
+
+	for i := range labels {
+		if rule, found := rs.suffixes[strings.Join(labels[i:], ".")]; found {
+			// A suffix of only two labels, such as amazonaws.com, is less specific than the patterns
+			if i >= len(labels)-2 {
+				if p := rs.matchPattern(target); p != nil {
+					return p
+				}
+			}
+			return rule
+		}
+	}
+	return rs.matchPattern(target)
+}
+
+func (rs *Ruleset) matchPattern(target string) *Rule {
+	for _, p := range rs.patterns {
+		if p.re.MatchString(target) {
+			return p.rule
+		}
+	}
+	return nil
+}
+
+// matchAddr returns the rule of the longest prefix holding the address.
+func (rs *Ruleset) matchAddr(ip net.IP) *Rule {
+	var best *Rule
+	bestLen := -1
+
+	for _, n := range rs.networks {
+		if !n.ipnet.Contains(ip) {
+			continue
+		}
+		if ones, _ := n.ipnet.Mask.Size(); ones > bestLen {
+			best, bestLen = n.rule, ones
+		}
+	}
+	return best
+}
@@ -0,0 +1,136 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+const testRules = `{
+  "version": "test",
+  "rules": [
+    {"provider": "AWS", "service": "Other", "cname_suffixes": ["amazonaws.com"]},
+    {"provider": "AWS", "service": "ELB", "cname_suffixes": ["elb.amazonaws.com"]},
+    {"provider": "AWS", "service": "S3", "cname_patterns": ["(^|\\.)s3[.-][a-z0-9-]+\\.amazonaws\\.com$"]},
+    {"provider": "Fastly", "service": "CDN", "cname_suffixes": ["fastly.net"], "cidrs": ["151.101.0.0/16"]},
+    {"provider": "Corp", "service": "Edge", "cidrs": ["151.101.64.0/18"]},
+    {"provider": "On-Premises", "service": "Private Network", "cidrs": ["10.0.0.0/8", "fc00::/7"]}
+  ]
+}`
+
+func TestCNAMERules(t *testing.T) {
+	rs, err := Load(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("failed to load the ruleset: %v", err)
+	}
+
+	for _, test := range []struct {
+		target   string
+		provider string
+		service  string
+	}{
+		{"dualstack.my-lb-123.us-east-1.elb.amazonaws.com.", "AWS", "ELB"},
+		{"ec2-192-0-2-1.compute-1.amazonaws.com", "AWS", "Other"},
+		{"assets.s3.us-west-2.amazonaws.com", "AWS", "S3"},
+		{"Prod.Map.Fastly.NET", "Fastly", "CDN"},
+		{"notfastly.net", Unknown, ""},
+		{"www.example.com", Unknown, ""},
+	} {
+		c := rs.Classify(test.target, nil)
+		if c.Provider != test.provider || c.Service != test.service {
+			t.Errorf("%s was classified as %s %s, expected %s %s", test.target, c.Provider, c.Service, test.provider, test.service)
+		}
+		if c.Provider != Unknown && c.Match != MatchCNAME {
+			t.Errorf("%s was matched by the %s", test.target, c.Match)
+		}
+	}
+}
+
+func TestCIDRRules(t *testing.T) {
+	rs, err := Load(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("failed to load the ruleset: %v", err)
+	}
+
+	for _, test := range []struct {
+		addrs    []string
+		provider string
+	}{
+		{[]string{"151.101.1.69"}, "Fastly"},
+		// The longest prefix wins over the order of the rules
+		{[]string{"151.101.65.1"}, "Corp"},
+		{[]string{"192.0.2.1", "10.1.2.3"}, "On-Premises"},
+		{[]string{"fd00::1"}, "On-Premises"},
+		{[]string{"192.0.2.1"}, Unknown},
+		{nil, Unknown},
+	} {
+		var ips []net.IP
+		for _, a := range test.addrs {
+			ips = append(ips, net.ParseIP(a))
+		}
+
+		c := rs.Classify("", ips)
+		if c.Provider != test.provider {
+			t.Errorf("%v was classified as %s, expected %s", test.addrs, c.Provider, test.provider)
+		}
+		if c.Provider != Unknown && (c.Match != MatchCIDR || c.Evidence == "") {
+			t.Errorf("%v was matched by the %s with the evidence %s", test.addrs, c.Match, c.Evidence)
+		}
+	}
+
+	// The CNAME target names the service before the addresses are considered
+	if c := rs.Classify("x.elb.amazonaws.com", []net.IP{net.ParseIP("151.101.1.69")}); c.Provider != "AWS" {
+		t.Errorf("the CNAME target was classified as %s", c.Provider)
+	}
+	var none *Ruleset
+	if c := none.Classify("x.fastly.net", nil); c.Provider != Unknown {
+		t.Errorf("the nil ruleset classified the target as %s", c.Provider)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"rules": [{"provider": "Bad", "cidrs": ["not a cidr"]}]}`,
+		`{"rules": [{"provider": "Bad", "cname_patterns": ["("]}]}`,
+		`not json`,
+	} {
+		if _, err := Load(strings.NewReader(data)); err == nil {
+			t.Errorf("the ruleset %s was loaded", data)
+		}
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	rs, err := FromConfig(config.NewConfig())
+	if err != nil {
+		t.Fatalf("failed to load the embedded ruleset: %v", err)
+	}
+	if c := rs.Classify("d111111abcdef8.cloudfront.net", nil); c.Provider != "AWS" || c.Service != "CloudFront" {
+		t.Errorf("the embedded ruleset classified CloudFront as %s %s", c.Provider, c.Service)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rules.json"), []byte(testRules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig()
+	cfg.Filepath = filepath.Join(dir, "config.yaml")
+	cfg.Options = map[string]interface{}{"cloud": map[string]interface{}{"rules": "rules.json"}}
+	if rs, err := FromConfig(cfg); err != nil || rs.Version != "test" {
+		t.Errorf("the ruleset file was not loaded: %v", err)
+	}
+
+	// A ruleset file that cannot be read leaves the embedded ruleset in place
+	cfg.Options = map[string]interface{}{"cloud": map[string]interface{}{"rules": "missing.json"}}
+	if rs, err := FromConfig(cfg); err == nil || rs == nil || rs.Version == "test" {
+		t.Errorf("the missing ruleset file returned %v", err)
+	}
+}
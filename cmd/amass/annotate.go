@@ -0,0 +1,295 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/owasp-amass/amass/v4/annotations"
+	"github.com/owasp-amass/amass/v4/provenance"
+	"github.com/owasp-amass/amass/v4/quarantine"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	annotateUsageMsg = "annotate [options] -d DOMAIN [-name NAME]"
+)
+
+type annotateArgs struct {
+	Domain  string
+	Name    string
+	Notes   noteFlags
+	Force   bool
+	Hide    bool
+	Restore bool
+	List    bool
+	// Promote, Reject and Quarantined review the findings quarantined by the enumerations
+	Promote     bool
+	Reject      bool
+	Quarantined bool
+	Provenance  bool
+	Paths       struct {
+		ConfigFile string
+		Directory  string
+	}
+}
+
+// noteFlags collects the key=value notes, which can contain commas.
+type noteFlags []string
+
+func (n *noteFlags) String() string {
+	if n == nil {
+		return ""
+	}
+	return strings.Join(*n, " ")
+}
+
+func (n *noteFlags) Set(s string) error {
+	if k, _, found := strings.Cut(s, "="); !found || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("the note %q is not in the key=value format", s)
+	}
+	*n = append(*n, s)
+	return nil
+}
+
+func runAnnotateCommand(clArgs []string) {
+	var args annotateArgs
+	var help1, help2 bool
+	annotateCommand := flag.NewFlagSet("annotate", flag.ContinueOnError)
+
+	annotateBuf := new(bytes.Buffer)
+	annotateCommand.SetOutput(annotateBuf)
+
+	annotateCommand.BoolVar(&help1, "h", false, "Show the program usage message")
+	annotateCommand.BoolVar(&help2, "help", false, "Show the program usage message")
+	annotateCommand.StringVar(&args.Domain, "d", "", "Root domain name of the event that found the name")
+	annotateCommand.StringVar(&args.Name, "name", "", "Name found by the event to be annotated")
+	annotateCommand.Var(&args.Notes, "note", "Note in the key=value format, where an empty value removes it (can be used multiple times)")
+	annotateCommand.BoolVar(&args.Force, "force", false, "Break the lock on the output directory left by a process that is no longer running")
+	annotateCommand.BoolVar(&args.Hide, "hide", false, "Hide the name from the output as a false positive")
+	annotateCommand.BoolVar(&args.Restore, "restore", false, "Show the hidden name in the output again")
+	annotateCommand.BoolVar(&args.List, "list", false, "Print the annotated names found by the event")
+	annotateCommand.BoolVar(&args.Promote, "promote", false, "Promote the quarantined name into the results")
+	annotateCommand.BoolVar(&args.Reject, "reject", false, "Reject the quarantined name from the results for good")
+	annotateCommand.BoolVar(&args.Quarantined, "quarantined", false, "Print the names quarantined by the event for a review")
+	annotateCommand.BoolVar(&args.Provenance, "provenance", false, "Print the chain of derivations that explains how the name was discovered")
+	annotateCommand.StringVar(&args.Paths.ConfigFile, "config", "", "Path to the YAML configuration file")
+	annotateCommand.StringVar(&args.Paths.Directory, "dir", "", "Path to the directory containing the graph database")
+
+	if len(clArgs) < 1 {
+		commandUsage(annotateUsageMsg, annotateCommand, annotateBuf)
+		return
+	}
+	if err := annotateCommand.Parse(clArgs); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	if help1 || help2 {
+		commandUsage(annotateUsageMsg, annotateCommand, annotateBuf)
+		return
+	}
+	if args.Domain == "" {
+		r.Fprintln(color.Error, "The root domain name of the event was not provided")
+		os.Exit(1)
+	}
+	if args.Hide && args.Restore {
+		r.Fprintln(color.Error, "The hide and restore flags cannot be used together")
+		os.Exit(1)
+	}
+	if args.Promote && args.Reject {
+		r.Fprintln(color.Error, "The promote and reject flags cannot be used together")
+		os.Exit(1)
+	}
+	review := args.Promote || args.Reject
+	if !args.List && !args.Quarantined && (args.Name == "" || (!args.Hide && !args.Restore && !review && !args.Provenance && len(args.Notes) == 0)) {
+		r.Fprintln(color.Error, "A name with the hide, restore, promote, reject, provenance or note flags is required, unless the names are listed")
+		os.Exit(1)
+	}
+
+	cfg := config.NewConfig()
+	if err := acquireConfig(args.Paths.Directory, args.Paths.ConfigFile, cfg); err != nil && args.Paths.ConfigFile != "" {
+		r.Fprintf(color.Error, "Failed to load the configuration file: %v\n", err)
+		os.Exit(1)
+	}
+	if args.Paths.Directory != "" {
+		cfg.Dir = args.Paths.Directory
+	}
+	if args.Force {
+		if cfg.Options == nil {
+			cfg.Options = make(map[string]interface{})
+		}
+		cfg.Options["force"] = true
+	}
+	cfg.AddDomain(args.Domain)
+	event := cfg.Domains()[0]
+	name := strings.ToLower(strings.TrimSpace(args.Name))
+	// The quarantined names outside of the scope are reviewed as well
+	if name != "" && cfg.WhichDomain(name) != event && !review {
+		r.Fprintf(color.Error, "The name %s was not found by the event for %s\n", name, event)
+		os.Exit(1)
+	}
+	cfg.Log = log.New(io.Discard, "", 0)
+	createOutputDirectory(cfg)
+
+	// The lock keeps a running enumeration from changing the annotations at the same time
+	graph, release, err := systems.OpenPrimaryGraph(cfg)
+	if err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	defer release()
+
+	store, err := annotations.Open(config.OutputDirectory(cfg.Dir))
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the annotations: %v\n", err)
+		os.Exit(1)
+	}
+	b := store.Backend(primarySystem(cfg))
+
+	held, err := quarantine.Open(config.OutputDirectory(cfg.Dir))
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the quarantine: %v\n", err)
+		os.Exit(1)
+	}
+	q := held.Backend(primarySystem(cfg))
+
+	derivations, err := provenance.Open(config.OutputDirectory(cfg.Dir))
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the provenance: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = derivations.Close() }()
+
+	if name != "" && review {
+		if err := reviewQuarantined(q, args, event, name); err != nil {
+			r.Fprintf(color.Error, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if name != "" && (args.Hide || args.Restore || len(args.Notes) > 0) {
+		if err := annotate(b, args, event, name, nameRecords(context.Background(), graph, name)); err != nil {
+			r.Fprintf(color.Error, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if args.List {
+		printAnnotations(b.ListAnnotated(event))
+	}
+	if args.Quarantined {
+		printQuarantine(q.List(event))
+	}
+	if name != "" && args.Provenance {
+		chains, err := derivations.Backend(primarySystem(cfg)).Load(event)
+		if err != nil {
+			r.Fprintf(color.Error, "%v\n", err)
+			os.Exit(1)
+		}
+		printProvenance(chains.Chain(name))
+	}
+}
+
+// reviewQuarantined records the decision requested by the flags on the name quarantined by the event.
+// The next enumeration sends a promoted name with the results, and leaves a rejected name out of them.
+func reviewQuarantined(q *quarantine.Backend, args annotateArgs, event, name string) error {
+	if args.Promote {
+		if err := q.Promote(event, name); err != nil {
+			return fmt.Errorf("Failed to promote %s: %v", name, err)
+		}
+	}
+	if args.Reject {
+		if err := q.Reject(event, name); err != nil {
+			return fmt.Errorf("Failed to reject %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// annotate applies the changes requested by the flags to the name found by the event.
+func annotate(b *annotations.Backend, args annotateArgs, event, name string, records []string) error {
+	if args.Hide {
+		if err := b.Hide(event, name, records); err != nil {
+			return fmt.Errorf("Failed to hide %s: %v", name, err)
+		}
+	}
+	if args.Restore {
+		if err := b.Restore(event, name); err != nil {
+			return fmt.Errorf("Failed to restore %s: %v", name, err)
+		}
+	}
+
+	for _, note := range args.Notes {
+		k, v, _ := strings.Cut(note, "=")
+
+		if err := b.SetAnnotation(event, name, strings.TrimSpace(k), strings.TrimSpace(v)); err != nil {
+			return fmt.Errorf("Failed to annotate %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// primarySystem returns the graph database system that the enumerations write their findings to.
+func primarySystem(cfg *config.Config) string {
+	for _, db := range cfg.GraphDBs {
+		if db.Primary {
+			return db.System
+		}
+	}
+	return ""
+}
+
+func printQuarantine(list []quarantine.Entry) {
+	for _, en := range list {
+		state := ""
+		if en.State != quarantine.Quarantined {
+			state = yellow(" (" + string(en.State) + ")")
+		}
+
+		fmt.Fprintf(color.Output, "%s%s\n", green(en.Name), state)
+		fmt.Fprintf(color.Output, "    %s: %s\n", blue(en.Rule), en.Reason)
+		if len(en.Addresses) > 0 {
+			fmt.Fprintf(color.Output, "    %s: %s\n", blue("addresses"), strings.Join(en.Addresses, ", "))
+		}
+	}
+}
+
+func printProvenance(chain []provenance.Step) {
+	for _, st := range chain {
+		fmt.Fprintf(color.Output, "%s %s", green(st.Name), blue(st.Derivation))
+		if st.Parent != "" {
+			fmt.Fprintf(color.Output, " %s", st.Parent)
+		}
+		fmt.Fprintln(color.Output)
+	}
+}
+
+func printAnnotations(list []annotations.Annotation) {
+	for _, a := range list {
+		state := ""
+		if a.Hidden {
+			state = yellow(" (hidden)")
+		}
+
+		keys := make([]string, 0, len(a.Notes))
+		for k := range a.Notes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(color.Output, "%s%s\n", green(a.Name), state)
+		for _, k := range keys {
+			fmt.Fprintf(color.Output, "    %s: %s\n", blue(k), a.Notes[k])
+		}
+	}
+}
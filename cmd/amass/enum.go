@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -17,6 +18,8 @@ import (
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -25,11 +28,31 @@ import (
 	"github.com/caffix/netmap"
 	"github.com/caffix/stringset"
 	"github.com/fatih/color"
+	"github.com/owasp-amass/amass/v4/annotations"
+	"github.com/owasp-amass/amass/v4/attempts"
+	"github.com/owasp-amass/amass/v4/bandwidth"
 	"github.com/owasp-amass/amass/v4/datasrcs"
 	"github.com/owasp-amass/amass/v4/enum"
+	"github.com/owasp-amass/amass/v4/evidence"
 	"github.com/owasp-amass/amass/v4/format"
+	"github.com/owasp-amass/amass/v4/format/incremental"
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/format/stix"
+	"github.com/owasp-amass/amass/v4/format/zone"
+	"github.com/owasp-amass/amass/v4/geo"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/journal"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/provenance"
+	"github.com/owasp-amass/amass/v4/quarantine"
+	"github.com/owasp-amass/amass/v4/rdap"
+	"github.com/owasp-amass/amass/v4/remote"
+	"github.com/owasp-amass/amass/v4/requests"
 	"github.com/owasp-amass/amass/v4/resources"
+	"github.com/owasp-amass/amass/v4/snapshot"
 	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/amass/v4/wordlists"
 	"github.com/owasp-amass/config/config"
 )
 
@@ -41,16 +64,19 @@ type enumArgs struct {
 	CIDRs             format.ParseCIDRs
 	AltWordList       *stringset.Set
 	AltWordListMask   *stringset.Set
-	BruteWordList     *stringset.Set
+	BruteWordList     wordlists.List
 	BruteWordListMask *stringset.Set
 	Blacklist         *stringset.Set
 	Domains           *stringset.Set
 	Excluded          *stringset.Set
 	Included          *stringset.Set
 	Interface         string
+	ReadDatabase      string
 	MaxDNSQueries     int
 	ResolverQPS       int
 	TrustedQPS        int
+	Seed              int64
+	Shard             string
 	MaxDepth          int
 	MinForRecursive   int
 	Names             *stringset.Set
@@ -58,16 +84,25 @@ type enumArgs struct {
 	Resolvers         *stringset.Set
 	Trusted           *stringset.Set
 	Timeout           int
+	Trace             *stringset.Set
 	Options           struct {
 		Active       bool
 		Alterations  bool
 		BruteForcing bool
 		DemoMode     bool
+		DryRun       bool
+		Force        bool
+		IncHidden    bool
+		IncHistory   bool
 		ListSources  bool
 		NoAlts       bool
 		NoColor      bool
 		NoRecursive  bool
+		OPSEC        bool
 		Passive      bool
+		Promote      bool
+		RequireSrcs  bool
+		Resume       bool
 		Silent       bool
 		Verbose      bool
 	}
@@ -77,17 +112,24 @@ type enumArgs struct {
 		Blacklist        string
 		BruteWordlist    format.ParseStrings
 		ConfigFile       string
+		CSVOutput        string
+		Delegations      string
 		Directory        string
 		Domains          format.ParseStrings
 		ExcludedSrcs     string
 		IncludedSrcs     string
+		Imports          format.ParseStrings
 		JSONOutput       string
 		LogFile          string
+		MailOutput       string
+		Suggestions      string
 		Names            format.ParseStrings
 		Resolvers        format.ParseStrings
 		Trusted          format.ParseStrings
 		ScriptsDirectory string
+		STIXOutput       string
 		TermOut          string
+		ZoneDirectory    string
 	}
 }
 
@@ -102,27 +144,39 @@ func defineEnumArgumentFlags(enumFlags *flag.FlagSet, args *enumArgs) {
 	enumFlags.Var(args.Excluded, "exclude", "Data source names separated by commas to be excluded")
 	enumFlags.Var(args.Included, "include", "Data source names separated by commas to be included")
 	enumFlags.StringVar(&args.Interface, "iface", "", "Provide the network interface to send traffic through")
+	enumFlags.StringVar(&args.ReadDatabase, "read-db", "", "Graph database system the output is read from (Default: all configured databases)")
 	enumFlags.IntVar(&args.MaxDNSQueries, "max-dns-queries", 0, "Deprecated flag to be replaced by dns-qps in version 4.0")
 	enumFlags.IntVar(&args.MaxDNSQueries, "dns-qps", 0, "Maximum number of DNS queries per second across all resolvers")
 	enumFlags.IntVar(&args.ResolverQPS, "rqps", 0, "Maximum number of DNS queries per second for each untrusted resolver")
 	enumFlags.IntVar(&args.TrustedQPS, "trqps", 0, "Maximum number of DNS queries per second for each trusted resolver")
+	enumFlags.Int64Var(&args.Seed, "seed", 0, "Seed of the randomness of the run, which reproduces an earlier run")
+	enumFlags.StringVar(&args.Shard, "shard", "", "Shard of the work handled by this process as INDEX/COUNT, such as 0/4")
 	enumFlags.IntVar(&args.MaxDepth, "max-depth", 0, "Maximum number of subdomain labels for brute forcing")
 	enumFlags.IntVar(&args.MinForRecursive, "min-for-recursive", 1, "Subdomain labels seen before recursive brute forcing (Default: 1)")
 	enumFlags.Var(&args.Ports, "p", "Ports separated by commas (default: 80, 443)")
-	enumFlags.Var(args.Resolvers, "r", "IP addresses of untrusted DNS resolvers (can be used multiple times)")
-	enumFlags.Var(args.Resolvers, "tr", "IP addresses of trusted DNS resolvers (can be used multiple times)")
+	enumFlags.Var(args.Resolvers, "r", "IP addresses, with optional ports, of untrusted DNS resolvers (can be used multiple times)")
+	enumFlags.Var(args.Trusted, "tr", "IP addresses, with optional ports, of trusted DNS resolvers (can be used multiple times)")
 	enumFlags.IntVar(&args.Timeout, "timeout", 0, "Number of minutes to let enumeration run before quitting")
+	enumFlags.Var(args.Trace, "trace", "Name to trace through resolution, filtering and storage, or *.DOMAIN for the names below it (can be used multiple times)")
 }
 
 func defineEnumOptionFlags(enumFlags *flag.FlagSet, args *enumArgs) {
 	enumFlags.BoolVar(&args.Options.Active, "active", false, "Attempt zone transfers and certificate name grabs")
 	enumFlags.BoolVar(&args.Options.BruteForcing, "brute", false, "Execute brute forcing after searches")
 	enumFlags.BoolVar(&args.Options.DemoMode, "demo", false, "Censor output to make it suitable for demonstrations")
+	enumFlags.BoolVar(&args.Options.DryRun, "dry-run", false, "Print the planned activity without sending any traffic")
+	enumFlags.BoolVar(&args.Options.Force, "force", false, "Break the lock on the output directory left by a process that is no longer running")
+	enumFlags.BoolVar(&args.Options.IncHidden, "include-hidden", false, "Include the names hidden by annotations in the output")
+	enumFlags.BoolVar(&args.Options.IncHistory, "include-historical", false, "Include the addresses the names no longer resolve to in the output")
 	enumFlags.BoolVar(&args.Options.ListSources, "list", false, "Print the names of all available data sources")
 	enumFlags.BoolVar(&args.Options.Alterations, "alts", false, "Enable generation of altered names")
 	enumFlags.BoolVar(&args.Options.NoColor, "nocolor", false, "Disable colorized output")
 	enumFlags.BoolVar(&args.Options.NoRecursive, "norecursive", false, "Turn off recursive brute forcing")
+	enumFlags.BoolVar(&args.Options.OPSEC, "opsec", false, "Randomize the order and timing of the queries and data source starts")
 	enumFlags.BoolVar(&args.Options.Passive, "passive", false, "Deprecated since passive is the default setting")
+	enumFlags.BoolVar(&args.Options.Promote, "promote", false, "Bring the apex domains observed across the address scope into the enumeration")
+	enumFlags.BoolVar(&args.Options.RequireSrcs, "require-sources", false, "Quit when any data source fails to start")
+	enumFlags.BoolVar(&args.Options.Resume, "resume", false, "Append to the JSON and CSV files of an interrupted run without writing their names again")
 	enumFlags.BoolVar(&args.Options.Silent, "silent", false, "Disable all output during execution")
 	enumFlags.BoolVar(&args.Options.Verbose, "v", false, "Output status / debug / troubleshooting info")
 }
@@ -133,16 +187,24 @@ func defineEnumFilepathFlags(enumFlags *flag.FlagSet, args *enumArgs) {
 	enumFlags.StringVar(&args.Filepaths.Blacklist, "blf", "", "Path to a file providing blacklisted subdomains")
 	enumFlags.Var(&args.Filepaths.BruteWordlist, "w", "Path to a different wordlist file for brute forcing")
 	enumFlags.StringVar(&args.Filepaths.ConfigFile, "config", "", "Path to the YAML configuration file. Additional details below")
+	enumFlags.StringVar(&args.Filepaths.Delegations, "delegations", "", "Path to the JSON file containing the zone cuts found under each domain (requires -active)")
 	enumFlags.StringVar(&args.Filepaths.Directory, "dir", "", "Path to the directory containing the output files")
 	enumFlags.Var(&args.Filepaths.Domains, "df", "Path to a file providing root domain names")
 	enumFlags.StringVar(&args.Filepaths.ExcludedSrcs, "ef", "", "Path to a file providing data sources to exclude")
 	enumFlags.StringVar(&args.Filepaths.IncludedSrcs, "if", "", "Path to a file providing data sources to include")
+	enumFlags.Var(&args.Filepaths.Imports, "import", "Path to a CSV or JSON Lines file of known names to verify and merge (can be used multiple times)")
 	enumFlags.StringVar(&args.Filepaths.LogFile, "log", "", "Path to the log file where errors will be written")
+	enumFlags.StringVar(&args.Filepaths.MailOutput, "mail", "", "Path to the JSON file containing the mail infrastructure of each domain (requires -active)")
 	enumFlags.Var(&args.Filepaths.Names, "nf", "Path to a file providing already known subdomain names (from other tools/sources)")
 	enumFlags.Var(&args.Filepaths.Resolvers, "rf", "Path to a file providing untrusted DNS resolvers")
 	enumFlags.Var(&args.Filepaths.Trusted, "trf", "Path to a file providing trusted DNS resolvers")
+	enumFlags.StringVar(&args.Filepaths.Suggestions, "suggest", "", "Path to the JSON file containing the domains proposed for the scope, since they share infrastructure with it")
 	enumFlags.StringVar(&args.Filepaths.ScriptsDirectory, "scripts", "", "Path to a directory containing ADS scripts")
+	enumFlags.StringVar(&args.Filepaths.CSVOutput, "csv", "", "Path to the CSV file of the findings written as they are found")
+	enumFlags.StringVar(&args.Filepaths.JSONOutput, "json", "", "Path to the JSON Lines file of the findings written as they are found, or - for stdout")
+	enumFlags.StringVar(&args.Filepaths.STIXOutput, "stix", "", "Path to the STIX 2.1 bundle file written after the enumeration")
 	enumFlags.StringVar(&args.Filepaths.TermOut, "o", "", "Path to the text file containing terminal stdout/stderr")
+	enumFlags.StringVar(&args.Filepaths.ZoneDirectory, "zone", "", "Path to the directory where a zone file is written for each domain")
 }
 
 func runEnumCommand(clArgs []string) {
@@ -151,6 +213,16 @@ func runEnumCommand(clArgs []string) {
 	if cfg == nil {
 		return
 	}
+	if args.Options.DryRun {
+		if !printPlan(color.Output, enum.Plan(cfg)) {
+			os.Exit(1)
+		}
+		return
+	}
+	// Each shard keeps its findings in its own subdirectory of the output directory, to be merged later
+	if sub := enum.ShardDirectory(cfg); sub != "" {
+		cfg.Dir = filepath.Join(config.OutputDirectory(cfg.Dir), sub)
+	}
 	createOutputDirectory(cfg)
 
 	rLog, wLog := io.Pipe()
@@ -170,8 +242,12 @@ func runEnumCommand(clArgs []string) {
 		os.Exit(1)
 	}
 	defer func() { _ = sys.Shutdown() }()
+	// The names asked for are traced through the enumeration
+	for _, pattern := range args.Trace.Slice() {
+		sys.TraceName(pattern)
+	}
 
-	if err := sys.SetDataSources(datasrcs.GetAllSources(sys)); err != nil {
+	if _, err := sys.SetDataSources(datasrcs.GetAllSources(sys)); err != nil {
 		r.Fprintf(color.Error, "%v\n", err)
 		os.Exit(1)
 	}
@@ -182,6 +258,133 @@ func runEnumCommand(clArgs []string) {
 		r.Fprintf(color.Error, "%s\n", "Failed to setup the enumeration")
 		os.Exit(1)
 	}
+	// The enumeration is not started when the never-touch list cannot be loaded
+	if err := e.Policy.Err(); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	// Distribute the DNS queries across the remote workers when they are registered
+	if wcfg := remote.ConfigFromOptions(cfg); wcfg != nil {
+		coord, err := newCoordinator(wcfg)
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to setup the workers: %v\n", err)
+			os.Exit(1)
+		}
+		defer coord.Close()
+		e.Resolvers = coord
+	}
+	// Keep the data source responses that yielded the names when the evidence store is enabled
+	if ecfg := evidence.ConfigFromOptions(cfg); ecfg != nil {
+		store, err := evidence.Open(filepath.Join(dir, evidence.DirName), ecfg.MaxSize)
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to open the evidence store: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = store.Close() }()
+		e.Evidence = store
+	}
+	// Keep the writes failing to reach a remote graph database, so they are replayed once it returns
+	if jcfg := journal.ConfigFromOptions(cfg); jcfg != nil && journal.Remote(sys.GraphSystem(sys.GraphDatabases()[0])) {
+		j, err := journal.Open(filepath.Join(dir, journal.FileName), jcfg.MaxSize)
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to open the graph journal: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = j.Close() }()
+		e.Journal = j
+	}
+	// Remember the generated candidates that failed, so the next enumerations do not query them again
+	if acfg := attempts.ConfigFromOptions(cfg); acfg != nil {
+		set, err := attempts.Open(filepath.Join(dir, attempts.DirName), acfg)
+		if err != nil {
+			r.Fprintf(color.Error, "%v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = set.Close() }()
+		e.Attempts = set
+	}
+	// Write the disposition of each candidate name when requested, so the missing names can be explained
+	if enum.DispositionsToFile(cfg) {
+		f, err := os.OpenFile(filepath.Join(dir, enum.DispositionsFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to open the dispositions file: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = f.Close() }()
+		e.Dispositions = f
+	}
+	// Look up the registration data of the domains and netblocks when the registration lookups are enabled
+	if rcfg := rdap.ConfigFromOptions(cfg); rcfg != nil {
+		store, err := rdap.Open(dir)
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to open the registrations: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = store.Close() }()
+		e.RDAP = rdap.NewClient(rcfg)
+		e.Registrations = store
+	}
+	// Record the effective configuration of the run beside the results
+	snaps, err := snapshot.Open(filepath.Join(dir, snapshot.DirName))
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the configuration snapshots: %v\n", err)
+		os.Exit(1)
+	}
+	e.Snapshots = snaps
+	// Keep the periods of the resolutions, so the historical ones are told apart from the current ones
+	past, err := history.Open(dir)
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the edge history: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = past.Close() }()
+	e.History = past.Backend(sys.GraphSystem(sys.GraphDatabases()[0]))
+	// The suspicious findings are kept apart from the output, along with the decisions made on them
+	held, err := quarantine.Open(dir)
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the quarantine: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = held.Close() }()
+	e.Quarantine = held.Backend(sys.GraphSystem(sys.GraphDatabases()[0]))
+	// The provenance of the findings is kept beside the graph, so the chains outlive the working set
+	derivations, err := provenance.Open(dir)
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the provenance: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = derivations.Close() }()
+	e.Derivations = derivations.Backend(sys.GraphSystem(sys.GraphDatabases()[0]))
+	// The names hidden by the analysts are excluded from the output
+	notes, err := annotations.Open(dir)
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to open the annotations: %v\n", err)
+		os.Exit(1)
+	}
+	// Merge the imported names into the graph and verify them like any other finding
+	if len(args.Filepaths.Imports) > 0 {
+		reqs, err := importFiles(context.Background(), sys.GraphDatabases()[0], cfg, args.Filepaths.Imports, e.Evidence)
+		if err != nil {
+			r.Fprintf(color.Error, "%v\n", err)
+			os.Exit(1)
+		}
+		e.Imported = reqs
+	}
+
+	// The version of the JSON records is checked before the enumeration sends any queries
+	version, err := schema.VersionFromConfig(cfg)
+	if err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+
+	// The findings are appended to the JSON Lines and CSV files as they are found
+	files, err := openFindingsFiles(args, version)
+	if err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	defer files.Close()
 
 	var wg sync.WaitGroup
 	var outChans []chan string
@@ -202,26 +405,29 @@ func runEnumCommand(clArgs []string) {
 	go saveTextOutput(e, args, txtOutChan, &wg)
 	outChans = append(outChans, txtOutChan)
 
-	var ctx context.Context
-	var cancel context.CancelFunc
-	if args.Timeout == 0 {
-		ctx, cancel = context.WithCancel(context.Background())
-	} else {
-		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(args.Timeout)*time.Minute)
+	// The timeout and the signals cancel the enumeration with their cause, which is reported in the summary
+	ctx, cancel := requests.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if args.Timeout > 0 {
+		timeout := time.AfterFunc(time.Duration(args.Timeout)*time.Minute, func() {
+			cancel(requests.NewCancelCause(requests.CancelBudget, fmt.Sprintf("the timeout of %d minutes has passed", args.Timeout)))
+		})
+		defer timeout.Stop()
 	}
-	defer cancel()
 
 	wg.Add(1)
-	go processOutput(ctx, sys.GraphDatabases()[0], e, outChans, done, &wg)
+	hidden := newHiddenNames(notes, cfg, sys, args.Options.IncHidden)
+	historical := newAddressHistory(past, sys, cfg.CollectionStartTime, args.Options.IncHistory)
+	go processOutput(ctx, sys.ReadGraphDatabases(), e, hidden, historical, files, outChans, done, &wg)
 	// Monitor for cancellation by the user
-	go func(d chan struct{}, c context.Context, f context.CancelFunc) {
+	go func(d chan struct{}, c context.Context, f func(*requests.CancelCause)) {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 		defer signal.Stop(quit)
 
 		select {
-		case <-quit:
-			f()
+		case sig := <-quit:
+			f(requests.NewCancelCause(requests.CancelStopped, "the "+sig.String()+" signal was received"))
 		case <-d:
 		case <-c.Done():
 		}
@@ -234,14 +440,487 @@ func runEnumCommand(clArgs []string) {
 	// Let all the output goroutines know that the enumeration has finished
 	close(done)
 	wg.Wait()
+	logSkippedRecords(cfg, e.SkippedRecords())
+	logParseErrors(cfg, datasrcs.ParseErrors(sys.DataSources()))
+	files.write(context.Background(), sys.ReadGraphDatabases(), e, hidden, historical)
+	if args.Filepaths.JSONOutput == "-" {
+		if err := writeJSONOutput(version, sys.ReadGraphDatabases(), e, hidden, historical); err != nil {
+			r.Fprintf(color.Error, "Failed to write the JSON output: %v\n", err)
+		}
+	}
+	if zones := e.CertificateZones(); len(zones) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.CertZonesFile), zones); err != nil {
+			r.Fprintf(color.Error, "Failed to write the certificate zones: %v\n", err)
+		}
+	}
+	if clusters := e.WildcardClusters(); len(clusters) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.WildcardClustersFile), clusters); err != nil {
+			r.Fprintf(color.Error, "Failed to write the names kept within the wildcard zones: %v\n", err)
+		}
+	}
+	bw := e.Bandwidth()
+	if bw.Total.Total() > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.BandwidthFile), bw); err != nil {
+			r.Fprintf(color.Error, "Failed to write the bandwidth of the enumeration: %v\n", err)
+		}
+	}
+	if infra := e.AllInfrastructure(); len(infra) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.InfrastructureFile), infra); err != nil {
+			r.Fprintf(color.Error, "Failed to write the infrastructure of the names: %v\n", err)
+		}
+	}
+	if delta := e.Delta(); delta != nil {
+		if err := writeJSONFile(filepath.Join(dir, enum.DeltaFile), delta); err != nil {
+			r.Fprintf(color.Error, "Failed to write the findings carried forward: %v\n", err)
+		}
+	}
+	if scores := e.AllConfidence(); len(scores) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.ConfidenceFile), scores); err != nil {
+			r.Fprintf(color.Error, "Failed to write the confidence in the names: %v\n", err)
+		}
+	}
+	if answers := e.SplitHorizonAnswers(); len(answers) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.SplitHorizonFile), &splitHorizonReport{
+			Differences: enum.SplitHorizonReport(answers),
+			Answers:     answers,
+		}); err != nil {
+			r.Fprintf(color.Error, "Failed to write the split-horizon comparison: %v\n", err)
+		}
+	}
+	if e.Geo != nil {
+		if err := writeLocations(filepath.Join(dir, geo.LocationsFile), sys.ReadGraphDatabases(), e); err != nil {
+			r.Fprintf(color.Error, "Failed to write the geolocation of the addresses: %v\n", err)
+		}
+	}
+	if args.Filepaths.STIXOutput != "" {
+		if err := writeSTIXBundle(args.Filepaths.STIXOutput, sys.GraphDatabases()[0], e); err != nil {
+			r.Fprintf(color.Error, "Failed to write the STIX bundle: %v\n", err)
+		}
+	}
+	if args.Filepaths.ZoneDirectory != "" {
+		if err := writeZoneFiles(args.Filepaths.ZoneDirectory, sys.GraphDatabases()[0], e); err != nil {
+			r.Fprintf(color.Error, "Failed to write the zone files: %v\n", err)
+		}
+	}
+	if args.Filepaths.Delegations != "" {
+		if err := writeJSONFile(args.Filepaths.Delegations, e.Delegations()); err != nil {
+			r.Fprintf(color.Error, "Failed to write the delegations: %v\n", err)
+		}
+	}
+	if args.Filepaths.MailOutput != "" {
+		if err := writeMailSummaries(args.Filepaths.MailOutput, e); err != nil {
+			r.Fprintf(color.Error, "Failed to write the mail summaries: %v\n", err)
+		}
+	}
+	if args.Filepaths.Suggestions != "" {
+		if err := writeScopeSuggestions(args.Filepaths.Suggestions, sys.GraphDatabases()[0], e); err != nil {
+			r.Fprintf(color.Error, "Failed to write the scope suggestions: %v\n", err)
+		}
+	}
+	if report := e.AddressScopeReport(); report != nil {
+		if err := writeJSONFile(filepath.Join(dir, enum.NetblocksFile), report); err != nil {
+			r.Fprintf(color.Error, "Failed to write the names observed in the netblocks: %v\n", err)
+		}
+		printNetblockSummary(report)
+	}
+	if vhosts := e.VirtualHosts(); len(vhosts) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.VirtualHostsFile), vhosts); err != nil {
+			r.Fprintf(color.Error, "Failed to write the virtual hosts: %v\n", err)
+		}
+		printVirtualHosts(vhosts)
+	}
+	if names := e.CloudNames(); len(names) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.CloudNamesFile), names); err != nil {
+			r.Fprintf(color.Error, "Failed to write the provider names: %v\n", err)
+		}
+		printCloudNames(names)
+	}
+	if posture := e.WebPosture(); len(posture) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.WebPostureFile), posture); err != nil {
+			r.Fprintf(color.Error, "Failed to write the web posture of the names: %v\n", err)
+		}
+		printPostureSummary(e.WebPostureSummary())
+	}
+	if findings := e.QuarantinedFindings(); len(findings) > 0 {
+		if err := writeJSONFile(filepath.Join(dir, enum.QuarantineFile), findings); err != nil {
+			r.Fprintf(color.Error, "Failed to write the quarantined findings: %v\n", err)
+		}
+		printQuarantined(findings)
+	}
+	if recs := e.Traces(""); len(recs) > 0 {
+		if err := writeTrace(filepath.Join(dir, systems.TraceFile), recs); err != nil {
+			r.Fprintf(color.Error, "Failed to write the traces of the names: %v\n", err)
+		}
+	}
+	printInfrastructureSummary(e.InfrastructureCounts())
+	printBandwidthSummary(bw)
+	// The blocked attempts are written even when there were none, as the evidence that the list was honored
+	if report := e.Policy.Report(); report != nil {
+		if err := writeJSONFile(filepath.Join(dir, policy.BlocksFile), report); err != nil {
+			r.Fprintf(color.Error, "Failed to write the blocked attempts: %v\n", err)
+		}
+		printPolicySummary(report)
+	}
+	if cause := e.TerminationReason(); cause != nil {
+		fmt.Fprintf(color.Error, "\n%s\n", green("The enumeration was cut short ("+string(cause.Reason)+"): "+cause.Detail))
+		return
+	}
+	if reason := e.Termination(); reason != "" && reason != enum.TerminationCompleted {
+		fmt.Fprintf(color.Error, "\n%s\n", green("The enumeration has finished: "+string(reason)))
+		return
+	}
 	fmt.Fprintf(color.Error, "\n%s\n", green("The enumeration has finished"))
 }
 
+// writeTrace writes the events captured for the traced names to the file as text.
+func writeTrace(path string, recs []systems.TraceRecord) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return systems.RenderTrace(f, recs)
+}
+
+// writeLocations locates the addresses found by the enumeration, and writes their locations to the file.
+func writeLocations(path string, graphs []*netmap.Graph, e *enum.Enumeration) error {
+	ctx := context.Background()
+
+	if _, err := EventOutput(ctx, graphs, e.Config.Domains(), e.Config.CollectionStartTime, nil, false, nil, nil, nil, func(o *requests.Output) error {
+		e.Geo.Enrich(ctx, o.Addresses)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return e.Geo.WriteFile(path)
+}
+
+func writeSTIXBundle(path string, g *netmap.Graph, e *enum.Enumeration) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	event := stix.Event{
+		Domains:  e.Config.Domains(),
+		Start:    e.Config.CollectionStartTime,
+		Metadata: e.Metadata(),
+	}
+	if e.Evidence != nil {
+		event.Evidence = e.EvidenceHashes
+	}
+	return stix.WriteBundle(context.Background(), f, g, event)
+}
+
+func writeZoneFiles(dir string, g *netmap.Graph, e *enum.Enumeration) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, d := range e.Config.Domains() {
+		records, err := zone.RecordsFromGraph(ctx, g, d, e.Config.CollectionStartTime)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, d+".zone"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+
+		err = zone.Write(f, d, zone.DefaultTTL, records)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSONOutput writes the findings of the enumeration to the standard output as JSON Lines records of the schema version.
+func writeJSONOutput(version int, graphs []*netmap.Graph, e *enum.Enumeration, hn *hiddenNames, ah *addressHistory) error {
+	enc, err := schema.NewEncoder(os.Stdout, version)
+	if err != nil {
+		return err
+	}
+
+	_, err = extractOutput(context.Background(), graphs, e, nil, true, hn, ah, func(o *requests.Output) error {
+		return enc.Encode(o)
+	})
+	return err
+}
+
+// findingsFiles appends the findings to the JSON Lines and CSV files as they are found, so the files
+// remain parseable when the process dies, and a resumed run does not write the same names again.
+type findingsFiles struct {
+	sync.Mutex
+	writers []*incremental.Writer
+	// filter holds the names already extracted from the graphs
+	filter *stringset.Set
+}
+
+// openFindingsFiles opens the files selected by the flags, or returns nil when none have been selected.
+func openFindingsFiles(args *enumArgs, version int) (*findingsFiles, error) {
+	opts := incremental.Options{Resume: args.Options.Resume}
+
+	var writers []*incremental.Writer
+	if path := args.Filepaths.JSONOutput; path != "" && path != "-" {
+		w, err := incremental.NewJSONWriter(path, version, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the JSON output: %v", err)
+		}
+		writers = append(writers, w)
+	}
+	if path := args.Filepaths.CSVOutput; path != "" {
+		w, err := incremental.NewCSVWriter(path, opts)
+		if err != nil {
+			for _, w := range writers {
+				_ = w.Close()
+			}
+			return nil, fmt.Errorf("failed to open the CSV output: %v", err)
+		}
+		writers = append(writers, w)
+	}
+	if len(writers) == 0 {
+		return nil, nil
+	}
+	return &findingsFiles{writers: writers, filter: stringset.New()}, nil
+}
+
+// write appends the findings that were not extracted from the graphs before.
+func (ff *findingsFiles) write(ctx context.Context, graphs []*netmap.Graph, e *enum.Enumeration, hn *hiddenNames, ah *addressHistory) {
+	if ff == nil {
+		return
+	}
+
+	ff.Lock()
+	defer ff.Unlock()
+
+	// A file that failed to be written is skipped for the remaining findings
+	failed := make([]bool, len(ff.writers))
+	if _, err := extractOutput(ctx, graphs, e, ff.filter, true, hn, ah, func(o *requests.Output) error {
+		for i, w := range ff.writers {
+			if failed[i] {
+				continue
+			}
+			if _, err := w.Write(o); err != nil {
+				r.Fprintf(color.Error, "Failed to write the findings: %v\n", err)
+				failed[i] = true
+			}
+		}
+		return nil
+	}); err != nil {
+		r.Fprintf(color.Error, "Failed to read the findings: %v\n", err)
+	}
+}
+
+// Close flushes the files to the disk and closes them.
+func (ff *findingsFiles) Close() {
+	if ff == nil {
+		return
+	}
+
+	ff.Lock()
+	defer ff.Unlock()
+
+	for _, w := range ff.writers {
+		if err := w.Close(); err != nil {
+			r.Fprintf(color.Error, "Failed to close the findings file: %v\n", err)
+		}
+	}
+	ff.filter.Close()
+}
+
+func writeMailSummaries(path string, e *enum.Enumeration) error {
+	var summaries []*enum.MailSummary
+
+	for _, d := range e.Config.Domains() {
+		if s, err := e.MailSummary(context.Background(), d); err == nil {
+			summaries = append(summaries, s)
+		}
+	}
+
+	return writeJSONFile(path, summaries)
+}
+
+// printInfrastructureSummary prints the number of names hosted by each provider, from the most to the fewest.
+func printInfrastructureSummary(counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+
+	providers := make([]string, 0, len(counts))
+	for p := range counts {
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		if counts[providers[i]] != counts[providers[j]] {
+			return counts[providers[i]] > counts[providers[j]]
+		}
+		return providers[i] < providers[j]
+	})
+
+	fmt.Fprintf(color.Error, "\n%s\n", blue("Infrastructure of the names:"))
+	for _, p := range providers {
+		fmt.Fprintf(color.Error, "%s %s\n", green(fmt.Sprintf("%-20s", p)), yellow(strconv.Itoa(counts[p])))
+	}
+}
+
+// printBandwidthSummary prints the bytes sent and received over each protocol, along with the total.
+func printBandwidthSummary(stats bandwidth.Stats) {
+	if stats.Total.Total() == 0 {
+		return
+	}
+
+	fmt.Fprintf(color.Error, "\n%s\n", blue("Bytes sent and received:"))
+	line := func(name string, c bandwidth.Counter) {
+		fmt.Fprintf(color.Error, "%s %s %s\n", green(fmt.Sprintf("%-20s", name)),
+			yellow(fmt.Sprintf("%-15s", strconv.FormatInt(c.Sent, 10))), yellow(strconv.FormatInt(c.Received, 10)))
+	}
+	for _, name := range stats.ProtocolNames() {
+		line(strings.ToUpper(name), stats.Protocols[name])
+	}
+	line("Total", stats.Total)
+	if stats.Exhausted {
+		r.Fprintf(color.Error, "The bandwidth budget of %d bytes was exhausted\n", stats.Budget)
+	}
+}
+
+// printPolicySummary prints the number of active probes blocked by each rule of the never-touch list.
+func printPolicySummary(report *policy.Report) {
+	fmt.Fprintf(color.Error, "\n%s %s\n", blue("Active probes blocked by the never-touch list:"), yellow(strconv.FormatInt(report.Blocked, 10)))
+
+	type ruleKey struct{ rule, component string }
+	var keys []ruleKey
+	attempts := make(map[ruleKey]int64)
+	for _, v := range report.Violations {
+		k := ruleKey{rule: v.Rule, component: v.Component}
+		if _, found := attempts[k]; !found {
+			keys = append(keys, k)
+		}
+		attempts[k] += v.Attempts
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].rule != keys[j].rule {
+			return keys[i].rule < keys[j].rule
+		}
+		return keys[i].component < keys[j].component
+	})
+
+	for _, k := range keys {
+		fmt.Fprintf(color.Error, "%s %s %s\n", green(fmt.Sprintf("%-30s", k.rule)),
+			blue(fmt.Sprintf("%-5s", k.component)), yellow(strconv.FormatInt(attempts[k], 10)))
+	}
+}
+
+// printNetblockSummary prints the names observed in each netblock of the address scope, and the apex domains observed.
+func printNetblockSummary(report *enum.AddressScopeReport) {
+	for _, nb := range report.Netblocks {
+		fmt.Fprintf(color.Error, "\n%s %s\n", blue("Netblock:"), yellow(nb.Netblock))
+		for _, n := range nb.Names {
+			fmt.Fprintf(color.Error, "%s %s %s\n", green(n.Name), yellow(n.Address), blue(strings.Join(n.Sources, ",")))
+		}
+	}
+	if len(report.Domains) == 0 {
+		return
+	}
+
+	fmt.Fprintf(color.Error, "\n%s\n", blue("Apex domains observed in the address scope:"))
+	for _, d := range report.Domains {
+		line := fmt.Sprintf("%s %s", green(fmt.Sprintf("%-30s", d.Domain)), yellow(strconv.Itoa(d.Addresses)+" addresses"))
+		if d.Promoted {
+			line += " " + r.Sprint("promoted")
+		}
+		fmt.Fprintln(color.Error, line)
+	}
+}
+
+// printVirtualHosts lists the names served by addresses they do not resolve to, marking the orphaned ones.
+func printVirtualHosts(vhosts []enum.VirtualHost) {
+	fmt.Fprintf(color.Error, "\n%s\n", blue("Virtual hosts served by addresses their names do not resolve to:"))
+	for _, vh := range vhosts {
+		var evidence []string
+		if vh.ValidCert {
+			evidence = append(evidence, "cert")
+		}
+		if vh.DistinctContent {
+			evidence = append(evidence, "content")
+		}
+
+		line := fmt.Sprintf("%s %s %s", green(vh.Name), yellow(vh.Address), blue(strings.Join(evidence, ",")))
+		if vh.Orphaned {
+			line += " " + r.Sprint("orphaned")
+		}
+		fmt.Fprintln(color.Error, line)
+	}
+}
+
+// printCloudNames lists the provider names generated from the naming conventions, along with their generator.
+func printCloudNames(names []enum.CloudName) {
+	fmt.Fprintf(color.Error, "\n%s\n", blue("Provider names generated from the naming conventions:"))
+	for _, cn := range names {
+		line := fmt.Sprintf("%s %s %s", green(cn.Name), yellow(cn.Generator), blue(cn.Validated))
+		if cn.StatusCode != 0 {
+			line += " " + strconv.Itoa(cn.StatusCode)
+		}
+		fmt.Fprintln(color.Error, line)
+	}
+}
+
+// printPostureSummary prints the number of names served over HTTPS missing each part of the web posture.
+func printPostureSummary(s enum.PostureSummary) {
+	fmt.Fprintf(color.Error, "\n%s\n", blue(fmt.Sprintf("Web posture of the %d names served over HTTPS:", s.Names)))
+	for _, row := range []struct {
+		label string
+		count int
+	}{
+		{"Missing HSTS", s.MissingHSTS},
+		{"Missing CSP", s.MissingCSP},
+		{"Missing X-Frame-Options", s.MissingFrameOption},
+		{"Insecure cookies", s.InsecureCookies},
+	} {
+		fmt.Fprintf(color.Error, "%s %s\n", green(fmt.Sprintf("%-24s", row.label)), yellow(strconv.Itoa(row.count)))
+	}
+}
+
+// printQuarantined lists the findings set apart for a review, along with the rule that matched each of them.
+func printQuarantined(findings []quarantine.Entry) {
+	fmt.Fprintf(color.Error, "\n%s\n", blue("Findings quarantined for a review:"))
+	for _, en := range findings {
+		fmt.Fprintf(color.Error, "%s %s %s\n", green(en.Name), yellow(en.Rule), strings.Join(en.Addresses, ","))
+	}
+}
+
+// splitHorizonReport is the content of the file comparing the answers of the resolver groups.
+type splitHorizonReport struct {
+	Differences []enum.SplitHorizonDiff    `json:"differences"`
+	Answers     []enum.SplitHorizonAnswers `json:"answers"`
+}
+
+// writeScopeSuggestions writes the domains sharing infrastructure with the scope, which are never added to it.
+func writeScopeSuggestions(path string, g *netmap.Graph, e *enum.Enumeration) error {
+	suggestions, err := enum.SuggestScope(context.Background(), g, e.Config.Domains(), e.Config.CollectionStartTime)
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(path, suggestions)
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 func argsAndConfig(clArgs []string) (*config.Config, *enumArgs) {
 	args := enumArgs{
 		AltWordList:       stringset.New(),
 		AltWordListMask:   stringset.New(),
-		BruteWordList:     stringset.New(),
 		BruteWordListMask: stringset.New(),
 		Blacklist:         stringset.New(),
 		Domains:           stringset.New(),
@@ -250,6 +929,7 @@ func argsAndConfig(clArgs []string) (*config.Config, *enumArgs) {
 		Names:             stringset.New(),
 		Resolvers:         stringset.New(),
 		Trusted:           stringset.New(),
+		Trace:             stringset.New(),
 	}
 	var help1, help2 bool
 	enumCommand := flag.NewFlagSet("enum", flag.ContinueOnError)
@@ -298,7 +978,7 @@ func argsAndConfig(clArgs []string) (*config.Config, *enumArgs) {
 		args.AltWordList.Union(args.AltWordListMask)
 	}
 	if args.BruteWordListMask.Len() > 0 {
-		args.BruteWordList.Union(args.BruteWordListMask)
+		args.BruteWordList = wordlists.Merge(args.BruteWordList, wordlists.FromLabels(args.BruteWordListMask.Slice()...))
 	}
 	if (args.Excluded.Len() > 0 || args.Filepaths.ExcludedSrcs != "") &&
 		(args.Included.Len() > 0 || args.Filepaths.IncludedSrcs != "") {
@@ -313,7 +993,7 @@ func argsAndConfig(clArgs []string) (*config.Config, *enumArgs) {
 
 	cfg := config.NewConfig()
 	// Check if a configuration file was provided, and if so, load the settings
-	if err := config.AcquireConfig(args.Filepaths.Directory, args.Filepaths.ConfigFile, cfg); err == nil {
+	if err := acquireConfig(args.Filepaths.Directory, args.Filepaths.ConfigFile, cfg); err == nil {
 		// Check if a config file was provided that has DNS resolvers specified
 		if len(cfg.Resolvers) > 0 && args.Resolvers.Len() == 0 {
 			args.Resolvers = stringset.New(cfg.Resolvers...)
@@ -339,8 +1019,9 @@ func argsAndConfig(clArgs []string) (*config.Config, *enumArgs) {
 		r.Fprintln(color.Error, "Ports can only be scanned in the active mode")
 		os.Exit(1)
 	}
-	if len(cfg.Domains()) == 0 {
-		r.Fprintln(color.Error, "Configuration error: No root domain names were provided")
+	// The addresses and netblocks can be the sole scope of the enumeration
+	if len(cfg.Domains()) == 0 && !enum.AddressScopeOnly(cfg) {
+		r.Fprintln(color.Error, "Configuration error: No root domain names or addresses were provided")
 		os.Exit(1)
 	}
 	return cfg, &args
@@ -395,7 +1076,7 @@ func saveTextOutput(e *enum.Enumeration, args *enumArgs, output chan string, wg
 	}
 }
 
-func processOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, outputs []chan string, done chan struct{}, wg *sync.WaitGroup) {
+func processOutput(ctx context.Context, graphs []*netmap.Graph, e *enum.Enumeration, hn *hiddenNames, ah *addressHistory, files *findingsFiles, outputs []chan string, done chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer func() {
 		// Signal all the other output goroutines to terminate
@@ -407,9 +1088,17 @@ func processOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, ou
 	// This filter ensures that we only get new names
 	known := stringset.New()
 	defer known.Close()
+	// The findings missing from each graph database are reported once the enumeration is done
+	mismatches := make([]int, len(graphs))
+	defer func() { logMismatches(e.Config, mismatches) }()
 	// The function that obtains output from the enum and puts it on the channel
 	extract := func(since time.Time) {
-		for _, o := range NewOutput(ctx, g, e, known, since) {
+		lines, missing := NewOutput(ctx, graphs, e, known, since, hn, ah)
+
+		for i, n := range missing {
+			mismatches[i] += n
+		}
+		for _, o := range lines {
 			for _, ch := range outputs {
 				ch <- o
 			}
@@ -430,6 +1119,8 @@ func processOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, ou
 		case <-t.C:
 			next := time.Now()
 			extract(last)
+			// The remaining findings are written once the enumeration has finished
+			files.write(ctx, graphs, e, hn, ah)
 			t.Reset(10 * time.Second)
 			last = next
 		}
@@ -485,18 +1176,17 @@ func writeLogsAndMessages(logs *io.PipeReader, logfile string, verbose bool) {
 // Obtain parameters from provided input files
 func processEnumInputFiles(args *enumArgs) error {
 	if args.Options.BruteForcing {
+		// The wordlists keep their order, which can be annotated with the yield of previous runs
 		if len(args.Filepaths.BruteWordlist) > 0 {
-			for _, f := range args.Filepaths.BruteWordlist {
-				list, err := config.GetListFromFile(f)
-				if err != nil {
-					return fmt.Errorf("failed to parse the brute force wordlist file: %v", err)
-				}
-				args.BruteWordList.InsertMany(list...)
+			list, err := wordlists.Load(args.Filepaths.BruteWordlist...)
+			if err != nil {
+				return fmt.Errorf("failed to parse the brute force wordlist file: %v", err)
 			}
+			args.BruteWordList = wordlists.Merge(args.BruteWordList, list)
 		} else {
 			if f, err := resources.GetResourceFile("namelist.txt"); err == nil {
-				if list, err := getWordList(f); err == nil {
-					args.BruteWordList.InsertMany(list...)
+				if list, err := wordlists.Read(f); err == nil {
+					args.BruteWordList = wordlists.Merge(args.BruteWordList, list)
 				}
 			}
 		}
@@ -566,6 +1256,15 @@ func processEnumInputFiles(args *enumArgs) error {
 			args.Resolvers.InsertMany(list...)
 		}
 	}
+	if len(args.Filepaths.Trusted) > 0 {
+		for _, f := range args.Filepaths.Trusted {
+			list, err := config.GetListFromFile(f)
+			if err != nil {
+				return fmt.Errorf("failed to parse the trusted resolver file: %v", err)
+			}
+			args.Trusted.InsertMany(list...)
+		}
+	}
 	return nil
 }
 
@@ -592,8 +1291,8 @@ func (e enumArgs) OverrideConfig(conf *config.Config) error {
 	if e.Names.Len() > 0 {
 		conf.ProvidedNames = e.Names.Slice()
 	}
-	if e.BruteWordList.Len() > 0 {
-		conf.Wordlist = e.BruteWordList.Slice()
+	if len(e.BruteWordList) > 0 {
+		conf.Wordlist = e.BruteWordList.Labels()
 	}
 	if e.AltWordList.Len() > 0 {
 		conf.AltWordlist = e.AltWordList.Slice()
@@ -623,6 +1322,71 @@ func (e enumArgs) OverrideConfig(conf *config.Config) error {
 	if e.Options.Verbose {
 		conf.Verbose = true
 	}
+	if e.Options.Force {
+		if conf.Options == nil {
+			conf.Options = make(map[string]interface{})
+		}
+		conf.Options["force"] = true
+	}
+	if e.Options.RequireSrcs {
+		if conf.Options == nil {
+			conf.Options = make(map[string]interface{})
+		}
+		section, ok := conf.Options["datasource_start"].(map[string]interface{})
+		if !ok {
+			section = make(map[string]interface{})
+			conf.Options["datasource_start"] = section
+		}
+		section["fatal"] = true
+	}
+	if e.Seed != 0 {
+		if conf.Options == nil {
+			conf.Options = make(map[string]interface{})
+		}
+		conf.Options["seed"] = e.Seed
+	}
+	if e.Shard != "" {
+		index, count, err := enum.ParseShard(e.Shard)
+		if err != nil {
+			return err
+		}
+		if conf.Options == nil {
+			conf.Options = make(map[string]interface{})
+		}
+		conf.Options["shard"] = map[string]interface{}{"index": index, "count": count}
+	}
+	if e.Options.OPSEC {
+		if conf.Options == nil {
+			conf.Options = make(map[string]interface{})
+		}
+		section, ok := conf.Options["opsec"].(map[string]interface{})
+		if !ok {
+			section = make(map[string]interface{})
+			conf.Options["opsec"] = section
+		}
+		section["enabled"] = true
+		// The seed of the run replaces the one configured for the mode, so it reproduces the order too
+		if e.Seed != 0 {
+			delete(section, "seed")
+		}
+	}
+	if e.Options.Promote {
+		if conf.Options == nil {
+			conf.Options = make(map[string]interface{})
+		}
+		section, ok := conf.Options["address_scope"].(map[string]interface{})
+		if !ok {
+			section = make(map[string]interface{})
+			conf.Options["address_scope"] = section
+		}
+		section["promote"] = true
+	}
+	if e.ReadDatabase != "" {
+		if conf.Options == nil {
+			conf.Options = make(map[string]interface{})
+		}
+		conf.Options["read_database"] = e.ReadDatabase
+	}
 	if e.ResolverQPS > 0 {
 		conf.ResolversQPS = e.ResolverQPS
 	}
@@ -633,13 +1397,23 @@ func (e enumArgs) OverrideConfig(conf *config.Config) error {
 		conf.SetResolvers(e.Resolvers.Slice()...)
 	}
 	if e.Trusted.Len() > 0 {
-		conf.SetTrustedResolvers(e.Trusted.Slice()...)
+		// SetTrustedResolvers replaces the untrusted resolvers
+		conf.TrustedResolvers = nil
+		conf.AddTrustedResolvers(e.Trusted.Slice()...)
 	}
 	if e.MaxDNSQueries > 0 {
 		conf.MaxDNSQueries = e.MaxDNSQueries
 	}
-	// Attempt to add the provided domains to the configuration
-	conf.AddDomains(e.Domains.Slice()...)
+	// Attempt to add the provided domains to the configuration in their punycode form
+	var domains []string
+	for _, d := range e.Domains.Slice() {
+		n, err := amassdns.NormalizeName(d)
+		if err != nil {
+			return err
+		}
+		domains = append(domains, n)
+	}
+	conf.AddDomains(domains...)
 	return nil
 }
 
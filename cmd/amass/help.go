@@ -23,6 +23,12 @@ func runHelpCommand(clArgs []string) {
 		runEnumCommand(help)
 	case "intel":
 		runIntelCommand(help)
+	case "import":
+		runImportCommand(help)
+	case "server":
+		runServerCommand(help)
+	case "worker":
+		runWorkerCommand(help)
 	default:
 		commandUsage(mainUsageMsg, helpCommand, helpBuf)
 		return
@@ -0,0 +1,263 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/caffix/netmap"
+	"github.com/caffix/stringset"
+	"github.com/fatih/color"
+	"github.com/owasp-amass/amass/v4/backup"
+	"github.com/owasp-amass/amass/v4/evidence"
+	"github.com/owasp-amass/amass/v4/format"
+	"github.com/owasp-amass/amass/v4/importer"
+	"github.com/owasp-amass/amass/v4/journal"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	importUsageMsg = "import [options] -d DOMAIN -i FILE"
+)
+
+type importArgs struct {
+	Domains   *stringset.Set
+	Force     bool
+	Reconcile bool
+	Replay    bool
+	Filepaths struct {
+		Backup     string
+		ConfigFile string
+		Directory  string
+		Domains    format.ParseStrings
+		Imports    format.ParseStrings
+	}
+}
+
+func runImportCommand(clArgs []string) {
+	args := importArgs{Domains: stringset.New()}
+	var help1, help2 bool
+	importCommand := flag.NewFlagSet("import", flag.ContinueOnError)
+
+	importBuf := new(bytes.Buffer)
+	importCommand.SetOutput(importBuf)
+
+	importCommand.BoolVar(&help1, "h", false, "Show the program usage message")
+	importCommand.BoolVar(&help2, "help", false, "Show the program usage message")
+	importCommand.Var(args.Domains, "d", "Domain names separated by commas (can be used multiple times)")
+	importCommand.Var(&args.Filepaths.Domains, "df", "Path to a file providing root domain names")
+	importCommand.BoolVar(&args.Force, "force", false, "Break the lock on the output directory left by a process that is no longer running")
+	importCommand.BoolVar(&args.Replay, "replay", false, "Replay the graph journal of the output directory into the primary graph database")
+	importCommand.BoolVar(&args.Reconcile, "reconcile", false, "Write the findings of the local graph database missing from the remote primary graph database")
+	importCommand.Var(&args.Filepaths.Imports, "i", "Path to a CSV or JSON Lines file of known names (can be used multiple times)")
+	importCommand.StringVar(&args.Filepaths.Backup, "backup", "", "Path to the archive of the graph database written before the import")
+	importCommand.StringVar(&args.Filepaths.ConfigFile, "config", "", "Path to the YAML configuration file")
+	importCommand.StringVar(&args.Filepaths.Directory, "dir", "", "Path to the directory containing the graph database")
+
+	if len(clArgs) < 1 {
+		commandUsage(importUsageMsg, importCommand, importBuf)
+		return
+	}
+	if err := importCommand.Parse(clArgs); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	if help1 || help2 {
+		commandUsage(importUsageMsg, importCommand, importBuf)
+		return
+	}
+	if len(args.Filepaths.Imports) == 0 && !args.Replay && !args.Reconcile {
+		r.Fprintln(color.Error, "No import files were provided")
+		os.Exit(1)
+	}
+	for _, f := range args.Filepaths.Domains {
+		list, err := config.GetListFromFile(f)
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to parse the domain names file: %v\n", err)
+			os.Exit(1)
+		}
+		args.Domains.InsertMany(list...)
+	}
+
+	cfg := config.NewConfig()
+	if err := acquireConfig(args.Filepaths.Directory, args.Filepaths.ConfigFile, cfg); err != nil && args.Filepaths.ConfigFile != "" {
+		r.Fprintf(color.Error, "Failed to load the configuration file: %v\n", err)
+		os.Exit(1)
+	}
+	if args.Filepaths.Directory != "" {
+		cfg.Dir = args.Filepaths.Directory
+	}
+	if args.Force {
+		if cfg.Options == nil {
+			cfg.Options = make(map[string]interface{})
+		}
+		cfg.Options["force"] = true
+	}
+	cfg.AddDomains(args.Domains.Slice()...)
+	// The journal and the local graph are replayed without regard to the scope
+	if len(cfg.Domains()) == 0 && len(args.Filepaths.Imports) > 0 {
+		r.Fprintln(color.Error, "Configuration error: No root domain names were provided")
+		os.Exit(1)
+	}
+	cfg.Log = log.New(io.Discard, "", 0)
+	createOutputDirectory(cfg)
+
+	graph, release, err := systems.OpenPrimaryGraph(cfg)
+	if err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	defer release()
+
+	if args.Filepaths.Backup != "" {
+		if err := writeBackup(context.Background(), graph, args.Filepaths.Backup); err != nil {
+			r.Fprintf(color.Error, "Failed to back up the graph database: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if args.Replay {
+		if err := replayJournal(context.Background(), graph, cfg); err != nil {
+			r.Fprintf(color.Error, "Failed to replay the graph journal: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if args.Reconcile {
+		if err := reconcileGraph(context.Background(), graph, cfg); err != nil {
+			r.Fprintf(color.Error, "Failed to reconcile the graph databases: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(args.Filepaths.Imports) == 0 {
+		return
+	}
+
+	var store *evidence.Store
+	if ecfg := evidence.ConfigFromOptions(cfg); ecfg != nil {
+		store, err = evidence.Open(filepath.Join(config.OutputDirectory(cfg.Dir), evidence.DirName), ecfg.MaxSize)
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to open the evidence store: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = store.Close() }()
+	}
+
+	// The imported names are resolved by the next enumeration that reads the stored event
+	if _, err := importFiles(context.Background(), graph, cfg, args.Filepaths.Imports, store); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// writeBackup writes the archive of the graph to the file, which is only kept once the archive is complete.
+func writeBackup(ctx context.Context, graph *netmap.Graph, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	meta, err := backup.Snapshot(ctx, graph, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	g.Fprintf(color.Error, "%s: archived %d assets and %d relations\n", path, meta.Assets, meta.Relations)
+	return nil
+}
+
+// replayJournal writes the findings kept in the graph journal of the output directory into the graph.
+func replayJournal(ctx context.Context, graph *netmap.Graph, cfg *config.Config) error {
+	var max int64
+	if jcfg := journal.ConfigFromOptions(cfg); jcfg != nil {
+		max = jcfg.MaxSize
+	}
+
+	j, err := journal.Open(filepath.Join(config.OutputDirectory(cfg.Dir), journal.FileName), max)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = j.Close() }()
+
+	res, err := j.Replay(ctx, graph)
+	printReplayResult(j.Path(), res)
+	return err
+}
+
+// reconcileGraph writes the findings of the local graph in the output directory that are missing from the
+// remote primary graph.
+func reconcileGraph(ctx context.Context, graph *netmap.Graph, cfg *config.Config) error {
+	for _, db := range cfg.GraphDBs {
+		if db.Primary && !journal.Remote(db.System) {
+			return fmt.Errorf("the primary graph database is not a remote one")
+		}
+	}
+
+	path := filepath.Join(config.OutputDirectory(cfg.Dir), "amass.sqlite")
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("the local graph database could not be found: %v", err)
+	}
+
+	local := netmap.NewGraph("local", path, "")
+	if local == nil {
+		return fmt.Errorf("failed to open the local graph database")
+	}
+
+	res, err := journal.Reconcile(ctx, local, graph)
+	printReplayResult(path, res)
+	return err
+}
+
+func printReplayResult(path string, res *journal.Result) {
+	if res == nil {
+		return
+	}
+
+	g.Fprintf(color.Error, "%s: wrote %d findings, %d were already present and %d were refused",
+		path, res.Replayed, res.Existing, res.Rejected)
+	g.Fprintf(color.Error, ", %d remain to be written\n", res.Pending)
+	if res.Corrupt > 0 {
+		r.Fprintf(color.Error, "%s: skipped %d corrupt records at lines %v\n", path, res.Corrupt, res.CorruptLines)
+	}
+}
+
+// importFiles merges the names in the import files into the graph and returns the requests that schedule them
+// for resolution. Invalid lines are reported and skipped.
+func importFiles(ctx context.Context, graph *netmap.Graph, cfg *config.Config, files []string, store *evidence.Store) ([]*requests.DNSRequest, error) {
+	var ev requests.EvidenceRecorder
+	if store != nil {
+		ev = store
+	}
+
+	var reqs []*requests.DNSRequest
+	for _, file := range files {
+		records, errs := importer.ReadFile(file)
+		for _, err := range errs {
+			r.Fprintf(color.Error, "%s: %v\n", file, err)
+		}
+
+		res, err := importer.Import(ctx, graph, cfg, file, records, ev)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to import %s: %v", file, err)
+		}
+
+		g.Fprintf(color.Error, "%s: imported %d new names and merged %d known names", file, res.Imported, res.Merged)
+		g.Fprintf(color.Error, " with %d addresses, skipped %d names out of scope\n", res.Addresses, res.OutOfScope)
+		reqs = append(reqs, importer.Requests(cfg, file, records)...)
+	}
+	return reqs, nil
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/owasp-amass/amass/v4/datasrcs"
 	"github.com/owasp-amass/amass/v4/format"
 	"github.com/owasp-amass/amass/v4/intel"
+	"github.com/owasp-amass/amass/v4/rdap"
 	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/config/config"
 )
@@ -144,7 +145,7 @@ func runIntelCommand(clArgs []string) {
 
 	cfg := config.NewConfig()
 	// Check if a configuration file was provided, and if so, load the settings
-	if err := config.AcquireConfig(args.Filepaths.Directory, args.Filepaths.ConfigFile, cfg); err == nil {
+	if err := acquireConfig(args.Filepaths.Directory, args.Filepaths.ConfigFile, cfg); err == nil {
 		// Check if a config file was provided that has DNS resolvers specified
 		if len(cfg.Resolvers) > 0 && args.Resolvers.Len() == 0 {
 			args.Resolvers = stringset.New(cfg.Resolvers...)
@@ -194,7 +195,7 @@ func runIntelCommand(clArgs []string) {
 		return
 	}
 
-	if err := sys.SetDataSources(datasrcs.GetAllSources(sys)); err != nil {
+	if _, err := sys.SetDataSources(datasrcs.GetAllSources(sys)); err != nil {
 		return
 	}
 
@@ -219,6 +220,22 @@ func runIntelCommand(clArgs []string) {
 		r.Fprintf(color.Error, "%s\n", "No DNS resolvers passed the sanity check")
 		os.Exit(1)
 	}
+	// The collection is not started when the never-touch list cannot be loaded
+	if err := ic.Policy.Err(); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	// Provide the registrant organizations to the reverse whois when the registration lookups are enabled
+	if rcfg := rdap.ConfigFromOptions(cfg); rcfg != nil {
+		store, err := rdap.Open(config.OutputDirectory(cfg.Dir))
+		if err != nil {
+			r.Fprintf(color.Error, "Failed to open the registrations: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = store.Close() }()
+		ic.RDAP = rdap.NewClient(rcfg)
+		ic.Registrations = store
+	}
 
 	if args.Options.ReverseWhois {
 		if len(ic.Config.Domains()) == 0 {
@@ -257,6 +274,9 @@ func runIntelCommand(clArgs []string) {
 	if !processIntelOutput(ic, &args) {
 		os.Exit(1)
 	}
+	if report := ic.Policy.Report(); report != nil {
+		printPolicySummary(report)
+	}
 }
 
 func printNetblocks(asns []int, cfg *config.Config, sys systems.System) {
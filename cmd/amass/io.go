@@ -8,28 +8,195 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/caffix/netmap"
 	"github.com/caffix/stringset"
+	"github.com/owasp-amass/amass/v4/annotations"
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
 	"github.com/owasp-amass/amass/v4/enum"
+	"github.com/owasp-amass/amass/v4/format"
+	"github.com/owasp-amass/amass/v4/history"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
 	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/config/config"
 	oam "github.com/owasp-amass/open-asset-model"
 	"github.com/owasp-amass/open-asset-model/domain"
 	"github.com/owasp-amass/open-asset-model/network"
 	"golang.org/x/net/publicsuffix"
 )
 
-func NewOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, filter *stringset.Set, since time.Time) []string {
+// hiddenNames excludes the names hidden by the analysts from the output read from each graph database.
+type hiddenNames struct {
+	store   *annotations.Store
+	cfg     *config.Config
+	systems map[*netmap.Graph]string
+	// include keeps the hidden names in the output
+	include bool
+}
+
+func newHiddenNames(store *annotations.Store, cfg *config.Config, sys systems.System, include bool) *hiddenNames {
+	hn := &hiddenNames{
+		store:   store,
+		cfg:     cfg,
+		systems: make(map[*netmap.Graph]string),
+		include: include,
+	}
+
+	for _, g := range sys.ReadGraphDatabases() {
+		hn.systems[g] = sys.GraphSystem(g)
+	}
+	return hn
+}
+
+// hidden returns true when the name is excluded from the output of the graph. A hidden name that
+// was found again with changed records is shown again.
+func (hn *hiddenNames) hidden(ctx context.Context, g *netmap.Graph, name string) bool {
+	if hn == nil || hn.store == nil {
+		return false
+	}
+
+	event := hn.cfg.WhichDomain(name)
+	b := hn.store.Backend(hn.systems[g])
+	if event == "" || !b.Hidden(event, name) {
+		return false
+	}
+
+	hidden, err := b.Refound(event, name, nameRecords(ctx, g, name))
+	if err != nil {
+		hn.cfg.Log.Printf("Output: %v", err)
+	}
+	return hidden && !hn.include
+}
+
+// addressHistory keeps the addresses the names no longer resolve to out of the output read from each graph database,
+// and provides the display forms of the names recorded when they were stored.
+type addressHistory struct {
+	store   *history.Store
+	systems map[*netmap.Graph]string
+	// at is the time from which the observed edges are current
+	at time.Time
+	// include keeps the historical addresses in the output
+	include bool
+}
+
+func newAddressHistory(store *history.Store, sys systems.System, at time.Time, include bool) *addressHistory {
+	ah := &addressHistory{
+		store:   store,
+		systems: make(map[*netmap.Graph]string),
+		at:      at,
+		include: include,
+	}
+
+	for _, g := range sys.ReadGraphDatabases() {
+		ah.systems[g] = sys.GraphSystem(g)
+	}
+	return ah
+}
+
+// backend returns the periods of the edges stored in the graph, or nil when the history is not kept.
+func (ah *addressHistory) backend(g *netmap.Graph) *history.Backend {
+	if ah == nil || ah.store == nil {
+		return nil
+	}
+	return ah.store.Backend(ah.systems[g])
+}
+
+// namesToAddrs returns the pairs of the names and their addresses in the graph selected for the output.
+func (ah *addressHistory) namesToAddrs(ctx context.Context, g *netmap.Graph, since time.Time, names ...string) ([]*netmap.NameAddrPair, error) {
+	if ah == nil {
+		return g.NamesToAddrs(ctx, since, names...)
+	}
+
+	v := history.Current
+	if ah.include {
+		v = history.Ever
+	}
+	return history.NamesToAddrs(ctx, g, ah.backend(g), v, ah.at, since, names...)
+}
+
+// historical returns the period of the edge from the name to the address in the graph, and true when
+// the name no longer resolved to the address at the time the output is current from.
+func (ah *addressHistory) historical(g *netmap.Graph, name, addr string) (history.Period, bool) {
+	if ah == nil {
+		return history.Period{}, false
+	}
+
+	p, found := ah.backend(g).Period(name, addr)
+	return p, found && !p.ValidAt(ah.at)
+}
+
+// nameRecords returns the addresses that the name has resolved to in the graph.
+func nameRecords(ctx context.Context, g *netmap.Graph, name string) []string {
+	var records []string
+
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, name); err == nil {
+		for _, p := range pairs {
+			if p.FQDN.Name == name && p.Addr.Address.IsValid() {
+				records = append(records, p.Addr.Address.String())
+			}
+		}
+	}
+	return records
+}
+
+// NewOutput returns the relationships discovered in the graphs, and for each graph the number of them it was missing.
+// The addresses the names no longer resolve to are left out, unless the history includes them.
+func NewOutput(ctx context.Context, graphs []*netmap.Graph, e *enum.Enumeration, filter *stringset.Set, since time.Time, hn *hiddenNames, ah *addressHistory) ([]string, []int) {
 	var output []string
+	mismatches := make([]int, len(graphs))
 
 	// Make sure a filter has been created
 	if filter == nil {
 		filter = stringset.New()
 		defer filter.Close()
 	}
+	// The identifiers differ between databases, so the lines are compared
+	found := make(map[string][]bool)
+	for i, g := range graphs {
+		for _, line := range graphOutput(ctx, g, e, filter, since, hn, ah) {
+			if _, seen := found[line]; !seen {
+				found[line] = make([]bool, len(graphs))
+				output = append(output, line)
+			}
+			found[line][i] = true
+		}
+	}
+
+	for _, line := range output {
+		filter.Insert(line)
+		for i, has := range found[line] {
+			if !has {
+				mismatches[i]++
+			}
+		}
+	}
+	return output, mismatches
+}
+
+func graphOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, filter *stringset.Set, since time.Time, hn *hiddenNames, ah *addressHistory) []string {
+	var output []string
+
+	excluded := make(map[string]bool)
+	hidden := func(a *types.Asset) bool {
+		fqdn, ok := a.Asset.(domain.FQDN)
+		if !ok {
+			return false
+		}
+		if h, found := excluded[fqdn.Name]; found {
+			return h
+		}
+
+		// The names scoring below the minimum confidence or quarantined are left out along with the hidden ones
+		h := e.BelowConfidence(fqdn.Name) || e.Quarantined(fqdn.Name) || hn.hidden(ctx, g, fqdn.Name)
+		excluded[fqdn.Name] = h
+		return h
+	}
 
 	var assets []*types.Asset
 	for _, atype := range []oam.AssetType{oam.FQDN, oam.IPAddress, oam.Netblock, oam.ASN, oam.RIROrg} {
@@ -41,19 +208,29 @@ func NewOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, filter
 	arrow := white("-->")
 	start := e.Config.CollectionStartTime.UTC()
 	for _, from := range assets {
+		if hidden(from) {
+			continue
+		}
 		fromstr := extractAssetName(from)
+		if fqdn, ok := from.Asset.(domain.FQDN); ok && e.CarriedForward(fqdn.Name) {
+			fromstr += yellow(" (carried forward)")
+		}
 
 		if rels, err := g.DB.OutgoingRelations(from, start); err == nil {
 			for _, rel := range rels {
-				lineid := from.ID + rel.ID + rel.ToAsset.ID
-				if filter.Has(lineid) {
-					continue
-				}
-				if to, err := g.DB.FindById(rel.ToAsset.ID, start); err == nil {
+				if to, err := g.DB.FindById(rel.ToAsset.ID, start); err == nil && !hidden(to) {
 					tostr := extractAssetName(to)
-
-					output = append(output, fmt.Sprintf("%s %s %s %s %s", fromstr, arrow, magenta(rel.Type), arrow, tostr))
-					filter.Insert(lineid)
+					// The resolutions that ended are only included when requested, and are marked
+					if p, ended := historicalEdge(g, ah, from, to); ended {
+						if !ah.include {
+							continue
+						}
+						tostr += yellow(fmt.Sprintf(" (historical, last seen %s)", p.LastSeen.Format("2006-01-02")))
+					}
+
+					if line := fmt.Sprintf("%s %s %s %s %s", fromstr, arrow, magenta(rel.Type), arrow, tostr); !filter.Has(line) {
+						output = append(output, line)
+					}
 				}
 			}
 		}
@@ -62,13 +239,62 @@ func NewOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, filter
 	return output
 }
 
+// historicalEdge returns the period of the edge from the name to the address, and true when it ended.
+func historicalEdge(g *netmap.Graph, ah *addressHistory, from, to *types.Asset) (history.Period, bool) {
+	fqdn, ok := from.Asset.(domain.FQDN)
+	if !ok {
+		return history.Period{}, false
+	}
+
+	ip, ok := to.Asset.(network.IPAddress)
+	if !ok || !ip.Address.IsValid() {
+		return history.Period{}, false
+	}
+	return ah.historical(g, fqdn.Name, ip.Address.String())
+}
+
+// logMismatches reports the findings that some of the graph databases were missing, which indicates failed writes.
+func logMismatches(cfg *config.Config, mismatches []int) {
+	if len(mismatches) < 2 {
+		return
+	}
+
+	for i, n := range mismatches {
+		if n > 0 {
+			cfg.Log.Printf("Output: graph database %d was missing %d findings stored in the other databases", i+1, n)
+		}
+	}
+}
+
+func logSkippedRecords(cfg *config.Config, skipped map[string]int) {
+	var names []string
+	for t := range skipped {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	for _, t := range names {
+		cfg.Log.Printf("Output: %d %s records were not stored in the graph database", skipped[t], t)
+	}
+}
+
+func logParseErrors(cfg *config.Config, counts []salvage.Count) {
+	for _, c := range counts {
+		cfg.Log.Printf("%s: %d responses failed to parse in full, losing %d entries: %s", c.Source, c.Responses, c.Entries, c.LastError)
+	}
+}
+
 func extractAssetName(a *types.Asset) string {
 	var result string
 
 	switch a.Asset.AssetType() {
 	case oam.FQDN:
 		if fqdn, ok := a.Asset.(domain.FQDN); ok {
-			result = green(fqdn.Name) + blue(" (FQDN)")
+			name := fqdn.Name
+			if u := amassdns.UnicodeName(name); u != name {
+				name += " [" + u + "]"
+			}
+			result = green(name) + blue(" (FQDN)")
 		}
 	case oam.IPAddress:
 		if ip, ok := a.Asset.(network.IPAddress); ok {
@@ -92,19 +318,55 @@ func extractAssetName(a *types.Asset) string {
 }
 
 // ExtractOutput is a convenience method for obtaining new discoveries made by the enumeration process.
-func ExtractOutput(ctx context.Context, g *netmap.Graph, e *enum.Enumeration, filter *stringset.Set, asinfo bool) []*requests.Output {
-	return EventOutput(ctx, g, e.Config.Domains(), e.Config.CollectionStartTime, filter, asinfo, e.Sys.Cache())
+// The names scoring below the minimum confidence of the enumeration are left out, and fn is called with
+// each of the findings in the order of their names.
+func ExtractOutput(ctx context.Context, graphs []*netmap.Graph, e *enum.Enumeration, filter *stringset.Set, asinfo bool, hn *hiddenNames, ah *addressHistory, fn func(*requests.Output) error) error {
+	mismatches, err := extractOutput(ctx, graphs, e, filter, asinfo, hn, ah, fn)
+	logMismatches(e.Config, mismatches)
+	return err
 }
 
-type outLookup map[string]*requests.Output
+// extractOutput calls fn with the discoveries of the enumeration, and returns the number of names each graph was missing.
+func extractOutput(ctx context.Context, graphs []*netmap.Graph, e *enum.Enumeration, filter *stringset.Set, asinfo bool, hn *hiddenNames, ah *addressHistory, fn func(*requests.Output) error) ([]int, error) {
+	return EventOutput(ctx, graphs, e.Config.Domains(), e.Config.CollectionStartTime, filter, asinfo, e.Sys.Cache(), hn, ah, func(o *requests.Output) error {
+		if e.BelowConfidence(o.Name) || e.Quarantined(o.Name) {
+			return nil
+		}
+		if c, found := e.Confidence(o.Name); found {
+			o.Confidence = c.Confidence
+			o.Sources = c.Sources
+		}
+		// Include the immediate parent of each name and how it was derived
+		if chain := e.Provenance(o.Name); len(chain) > 0 {
+			o.Parent = chain[0].Parent
+			o.Derivation = chain[0].Derivation
+		}
+		o.Evidence = e.EvidenceHashes(o.Name)
+		o.CarriedForward = e.CarriedForward(o.Name)
+		for i, a := range o.Addresses {
+			o.Addresses[i].Authoritative = e.AuthoritativeAddress(o.Name, a.Address.String())
+		}
+		e.Geo.Enrich(ctx, o.Addresses)
+		if c, found := e.Infrastructure(o.Name); found {
+			o.Provider = c.Provider
+			o.Service = c.Service
+		}
+		return fn(o)
+	})
+}
 
-// EventOutput returns findings within the receiver Graph within the scope identified by the provided domain names.
-// The filter is updated by EventOutput.
-func EventOutput(ctx context.Context, g *netmap.Graph, domains []string, since time.Time, f *stringset.Set, asninfo bool, cache *requests.ASNCache) []*requests.Output {
-	var res []*requests.Output
+type outLookup map[string]*requests.Output
 
-	if len(domains) == 0 {
-		return res
+// EventOutput calls fn with the findings within the receiver Graphs within the scope identified by the provided domain
+// names, in the order of their names. The names are read one page at a time, and the findings of a page are handed to
+// fn before the next page is read, so the event is never held in memory. The names found in several graphs are merged,
+// and the number of names each graph was missing is returned. The filter is updated by EventOutput, and the hidden
+// names are excluded. The addresses the names no longer resolve to are left out, unless the history includes them in
+// the Historical field of the output. The error stopping the names from being read, or returned by fn, is returned.
+func EventOutput(ctx context.Context, graphs []*netmap.Graph, domains []string, since time.Time, f *stringset.Set, asninfo bool, cache *requests.ASNCache, hn *hiddenNames, ah *addressHistory, fn func(*requests.Output) error) ([]int, error) {
+	mismatches := make([]int, len(graphs))
+	if len(domains) == 0 || len(graphs) == 0 {
+		return mismatches, nil
 	}
 	// Make sure a filter has been created
 	if f == nil {
@@ -112,60 +374,160 @@ func EventOutput(ctx context.Context, g *netmap.Graph, domains []string, since t
 		defer f.Close()
 	}
 
-	var fqdns []oam.Asset
-	for _, d := range domains {
-		fqdns = append(fqdns, domain.FQDN{Name: d})
-	}
-
 	qtime := time.Time{}
 	if !since.IsZero() {
 		qtime = since.UTC()
 	}
 
-	assets, err := g.DB.FindByScope(fqdns, qtime)
-	if err != nil {
-		return res
+	readers := make([]*graphNames, len(graphs))
+	for i, g := range graphs {
+		readers[i] = &graphNames{g: g, it: cursor.SortedNamesIterator(ctx, g, qtime, domains...)}
+		readers[i].advance(ctx, f, hn)
 	}
 
-	var names []string
-	for _, a := range assets {
-		if n, ok := a.Asset.(domain.FQDN); ok && !f.Has(n.Name) {
-			names = append(names, n.Name)
+	for {
+		names := nextPage(ctx, readers, f, hn)
+		if names == nil {
+			break
+		}
+
+		sets := make([][]*requests.Output, len(graphs))
+		for i, r := range readers {
+			lookup := make(outLookup, len(names[i]))
+			addNames(ctx, r.g, qtime, lookup, names[i], ah)
+			for _, n := range names[i] {
+				if o, found := lookup[n]; found {
+					sets[i] = append(sets[i], o)
+				}
+			}
+		}
+
+		merged, missing := format.MergeOutputs(sets...)
+		for i, n := range missing {
+			mismatches[i] += n
+		}
+		lookup := make(outLookup, len(merged))
+		for _, o := range merged {
+			lookup[o.Name] = o
+		}
+
+		var res []*requests.Output
+		if !asninfo || cache == nil {
+			res = removeDuplicates(lookup, f)
+		} else {
+			res = addInfrastructureInfo(lookup, f, cache)
+		}
+		// The findings are sorted, so exporting the same event again produces the same files
+		format.SortOutputs(res)
+		for _, o := range res {
+			if err := fn(o); err != nil {
+				return mismatches, err
+			}
+		}
+	}
+
+	for _, r := range readers {
+		if err := r.it.Err(); err != nil {
+			return mismatches, err
+		}
+	}
+	return mismatches, nil
+}
+
+// graphNames reads the names of a graph that are not in the filter or hidden, in sorted order.
+type graphNames struct {
+	g    *netmap.Graph
+	it   *cursor.SortedIterator
+	head string
+}
+
+// advance moves to the next name of the graph, leaving the head empty once the names are exhausted.
+func (gn *graphNames) advance(ctx context.Context, f *stringset.Set, hn *hiddenNames) {
+	gn.head = ""
+	for gn.it.Next() {
+		if n := gn.it.Name(); !f.Has(n) && !hn.hidden(ctx, gn.g, n) {
+			gn.head = n
+			return
 		}
 	}
+}
+
+// nextPage returns up to a page of the smallest names read from the graphs, where the names of
+// each graph are at its index, or nil once the names of all the graphs are exhausted.
+func nextPage(ctx context.Context, readers []*graphNames, f *stringset.Set, hn *hiddenNames) [][]string {
+	names := make([][]string, len(readers))
+
+	for num := 0; num < cursor.DefaultPageSize; num++ {
+		var next string
+		for _, r := range readers {
+			if r.head != "" && (next == "" || r.head < next) {
+				next = r.head
+			}
+		}
+		if next == "" {
+			if num == 0 {
+				return nil
+			}
+			break
+		}
+
+		for i, r := range readers {
+			if r.head == next {
+				names[i] = append(names[i], next)
+				r.advance(ctx, f, hn)
+			}
+		}
+	}
+	return names
+}
+
+// addNames adds the names and the addresses they resolve to into the lookup.
+func addNames(ctx context.Context, g *netmap.Graph, since time.Time, lookup outLookup, names []string, ah *addressHistory) {
+	var added []string
 
-	lookup := make(outLookup, len(names))
 	for _, n := range names {
+		if _, found := lookup[n]; found {
+			continue
+		}
+
 		d, err := publicsuffix.EffectiveTLDPlusOne(n)
 		if err != nil {
 			continue
 		}
 
-		o := &requests.Output{
-			Name:   n,
-			Domain: d,
+		lookup[n] = &requests.Output{
+			Name:        n,
+			DisplayName: ah.displayName(g, n),
+			Domain:      d,
 		}
-		res = append(res, o)
-		lookup[n] = o
+		added = append(added, n)
+	}
+	if len(added) == 0 {
+		return
 	}
 	// Build the lookup map used to create the final result set
-	if pairs, err := g.NamesToAddrs(ctx, qtime, names...); err == nil {
+	if pairs, err := ah.namesToAddrs(ctx, g, since, added...); err == nil {
 		for _, p := range pairs {
 			addr := p.Addr.Address.String()
 
 			if p.FQDN.Name == "" || addr == "" {
 				continue
 			}
-			if o, found := lookup[p.FQDN.Name]; found {
-				o.Addresses = append(o.Addresses, requests.AddressInfo{Address: net.ParseIP(addr)})
+			o, found := lookup[p.FQDN.Name]
+			if !found {
+				continue
 			}
+			if period, ended := ah.historical(g, p.FQDN.Name, addr); ended {
+				o.Historical = append(o.Historical, requests.Resolution{
+					Address:   addr,
+					FirstSeen: period.FirstSeen,
+					LastSeen:  period.LastSeen,
+				})
+				continue
+			}
+			o.Addresses = append(o.Addresses, requests.AddressInfo{Address: net.ParseIP(addr)})
 		}
 	}
-
-	if !asninfo || cache == nil {
-		return removeDuplicates(lookup, f)
-	}
-	return addInfrastructureInfo(lookup, f, cache)
 }
 
 func removeDuplicates(lookup outLookup, filter *stringset.Set) []*requests.Output {
@@ -212,12 +574,13 @@ func addInfrastructureInfo(lookup outLookup, filter *stringset.Set, cache *reque
 }
 
 // EventNames returns findings within the receiver Graph within the scope identified by the provided domain names.
-// The filter is updated by EventNames.
-func EventNames(ctx context.Context, g *netmap.Graph, domains []string, since time.Time, f *stringset.Set) []*requests.Output {
+// The findings are sorted by name, the filter is updated by EventNames, and the hidden names are excluded.
+// The error stopping the names from being read is returned.
+func EventNames(ctx context.Context, g *netmap.Graph, domains []string, since time.Time, f *stringset.Set, hn *hiddenNames, ah *addressHistory) ([]*requests.Output, error) {
 	var res []*requests.Output
 
 	if len(domains) == 0 {
-		return res
+		return res, nil
 	}
 	// Make sure a filter has been created
 	if f == nil {
@@ -225,28 +588,22 @@ func EventNames(ctx context.Context, g *netmap.Graph, domains []string, since ti
 		defer f.Close()
 	}
 
-	var fqdns []oam.Asset
-	for _, d := range domains {
-		fqdns = append(fqdns, domain.FQDN{Name: d})
-	}
-
 	qtime := time.Time{}
 	if !since.IsZero() {
 		qtime = since.UTC()
 	}
 
-	assets, err := g.DB.FindByScope(fqdns, qtime)
-	if err != nil {
-		return res
-	}
-
 	var names []string
-	for _, a := range assets {
-		if n, ok := a.Asset.(domain.FQDN); ok && !f.Has(n.Name) {
-			names = append(names, n.Name)
-			f.Insert(n.Name)
+	it := cursor.SortedNamesIterator(ctx, g, qtime, domains...)
+	for it.Next() {
+		if n := it.Name(); !f.Has(n) && !hn.hidden(ctx, g, n) {
+			names = append(names, n)
+			f.Insert(n)
 		}
 	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
 
 	for _, n := range names {
 		d, err := publicsuffix.EffectiveTLDPlusOne(n)
@@ -255,9 +612,22 @@ func EventNames(ctx context.Context, g *netmap.Graph, domains []string, since ti
 		}
 
 		res = append(res, &requests.Output{
-			Name:   n,
-			Domain: d,
+			Name:        n,
+			DisplayName: ah.displayName(g, n),
+			Domain:      d,
 		})
 	}
-	return res
+	return res, nil
+}
+
+// displayName returns the unicode form of an internationalized name recorded when it was stored in the graph,
+// or the empty string. The names stored before the display forms were recorded are converted instead.
+func (ah *addressHistory) displayName(g *netmap.Graph, name string) string {
+	if d, found := ah.backend(g).DisplayName(name); found {
+		return d
+	}
+	if u := amassdns.UnicodeName(name); u != name {
+		return u
+	}
+	return ""
 }
@@ -0,0 +1,154 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/glebarez/sqlite"
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/format/incremental"
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/requests"
+	oam "github.com/owasp-amass/open-asset-model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// populateGraph adds n names within owasp.org to the local graph database, each resolving to an address, without
+// going through the graph, which is far too slow for large events.
+func populateGraph(b *testing.B, path string, n int) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		if sqldb, err := db.DB(); err == nil {
+			_ = sqldb.Close()
+		}
+	}()
+
+	const batch = 300
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < n; i += batch {
+			var assets, relations []string
+			var aargs, rargs []interface{}
+
+			for j := i; j < i+batch && j < n; j++ {
+				name, addr := int64(2*j+1), int64(2*j+2)
+
+				assets = append(assets, "(?, ?, ?)", "(?, ?, ?)")
+				aargs = append(aargs,
+					name, string(oam.FQDN), `{"name":"host`+strconv.Itoa(j)+`.owasp.org"}`,
+					addr, string(oam.IPAddress), fmt.Sprintf(`{"address":"10.%d.%d.%d","type":"IPv4"}`, j>>16&255, j>>8&255, j&255))
+				relations = append(relations, "(?, ?, ?)")
+				rargs = append(rargs, "a_record", name, addr)
+			}
+			if err := tx.Exec("INSERT INTO assets (id, type, content) VALUES "+strings.Join(assets, ","), aargs...).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("INSERT INTO relations (type, from_asset_id, to_asset_id) VALUES "+strings.Join(relations, ","), rargs...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkEventOutput writes the findings of synthetic events to the JSON Lines of the standard output and to the
+// incremental JSON and CSV files, and reports the largest heap growth observed during each export, which stays flat
+// as the event grows since only one page of findings is held at a time.
+func BenchmarkEventOutput(b *testing.B) {
+	for _, n := range []int{1000, 5000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			dir := b.TempDir()
+			path := filepath.Join(dir, "amass.sqlite")
+			g := netmap.NewGraph("local", path, "")
+			if g == nil {
+				b.Fatal("failed to create the local graph")
+			}
+			defer g.Remove()
+			populateGraph(b, path, n)
+
+			p, err := cursor.NewSQLPager("local", path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			cursor.Register(g, p)
+			defer cursor.Unregister(g)
+
+			var peak uint64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if p := exportEvent(b, g, dir, n); p > peak {
+					peak = p
+				}
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+		})
+	}
+}
+
+func exportEvent(b *testing.B, g *netmap.Graph, dir string, expected int) uint64 {
+	enc, err := schema.NewEncoder(io.Discard, schema.Latest)
+	if err != nil {
+		b.Fatal(err)
+	}
+	jw, err := incremental.NewJSONWriter(filepath.Join(dir, "amass.jsonl"), schema.Latest, incremental.Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	cw, err := incremental.NewCSVWriter(filepath.Join(dir, "amass.csv"), incremental.Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	base, peak := m.HeapAlloc, uint64(0)
+
+	var num int
+	if _, err := EventOutput(context.Background(), []*netmap.Graph{g}, []string{"owasp.org"}, time.Time{}, nil, false, nil, nil, nil, func(o *requests.Output) error {
+		if err := enc.Encode(o); err != nil {
+			return err
+		}
+		for _, w := range []*incremental.Writer{jw, cw} {
+			if _, err := w.Write(o); err != nil {
+				return err
+			}
+		}
+		if num++; num%cursor.DefaultPageSize == 0 {
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > base && m.HeapAlloc-base > peak {
+				peak = m.HeapAlloc - base
+			}
+		}
+		return nil
+	}); err != nil {
+		b.Fatal(err)
+	}
+	for _, w := range []*incremental.Writer{jw, cw} {
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if num != expected {
+		b.Fatalf("the export wrote %d findings, expected %d", num, expected)
+	}
+	return peak
+}
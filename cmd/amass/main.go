@@ -35,12 +35,13 @@ import (
 	"github.com/owasp-amass/amass/v4/datasrcs"
 	"github.com/owasp-amass/amass/v4/format"
 	amassnet "github.com/owasp-amass/amass/v4/net"
+	"github.com/owasp-amass/amass/v4/profile"
 	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/config/config"
 )
 
 const (
-	mainUsageMsg         = "intel|enum [options]"
+	mainUsageMsg         = "intel|enum|import|annotate|server|worker [options]"
 	exampleConfigFileURL = "https://github.com/owasp-amass/amass/blob/master/examples/config.yaml"
 	userGuideURL         = "https://github.com/owasp-amass/amass/blob/master/doc/user_guide.md"
 	tutorialURL          = "https://github.com/owasp-amass/amass/blob/master/doc/tutorial.md"
@@ -69,6 +70,10 @@ func commandUsage(msg string, cmdFlagSet *flag.FlagSet, errBuf *bytes.Buffer) {
 		g.Fprintf(color.Error, "\nSubcommands: \n\n")
 		g.Fprintf(color.Error, "\t%-11s - Discover targets for enumerations\n", "amass intel")
 		g.Fprintf(color.Error, "\t%-11s - Perform enumerations and network mapping\n", "amass enum")
+		g.Fprintf(color.Error, "\t%-11s - Merge externally known names into the graph\n", "amass import")
+		g.Fprintf(color.Error, "\t%-11s - Add notes to the findings or hide the false positives\n", "amass annotate")
+		g.Fprintf(color.Error, "\t%-11s - Run enumerations submitted through an HTTP API\n", "amass server")
+		g.Fprintf(color.Error, "\t%-11s - Perform the DNS queries of remote enumerations\n", "amass worker")
 	}
 
 	g.Fprintln(color.Error)
@@ -110,6 +115,14 @@ func main() {
 		runEnumCommand(os.Args[2:])
 	case "intel":
 		runIntelCommand(os.Args[2:])
+	case "import":
+		runImportCommand(os.Args[2:])
+	case "annotate":
+		runAnnotateCommand(os.Args[2:])
+	case "server":
+		runServerCommand(os.Args[2:])
+	case "worker":
+		runWorkerCommand(os.Args[2:])
 	case "help":
 		runHelpCommand(os.Args[2:])
 	default:
@@ -131,7 +144,7 @@ func GetAllSourceInfo(cfg *config.Config) []string {
 	defer func() { _ = sys.Shutdown() }()
 
 	srcs := datasrcs.SelectedDataSources(cfg, datasrcs.GetAllSources(sys))
-	if err := sys.SetDataSources(srcs); err != nil {
+	if _, err := sys.SetDataSources(srcs); err != nil {
 		return []string{}
 	}
 	return DataSourceInfo(srcs, sys)
@@ -178,6 +191,20 @@ func createOutputDirectory(cfg *config.Config) {
 		r.Fprintf(color.Error, "Failed to create the directory: %v\n", err)
 		os.Exit(1)
 	}
+	// The commands always store the findings in the output directory, instead of the memory graph
+	cfg.Dir = dir
+}
+
+// acquireConfig loads the configuration file like config.AcquireConfig, while
+// a configuration file extending another one is loaded as a profile.
+func acquireConfig(dir, file string, cfg *config.Config) error {
+	if file != "" {
+		if extends, err := profile.Extends(file); err == nil && extends {
+			_, err = profile.Load(file, cfg)
+			return err
+		}
+	}
+	return config.AcquireConfig(dir, file, cfg)
 }
 
 func assignNetInterface(iface *net.Interface) error {
@@ -0,0 +1,78 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/enum"
+)
+
+// printPlan writes the activity planned by the dry run and returns false when it has blockers.
+func printPlan(w io.Writer, p *enum.ActivityPlan) bool {
+	fmt.Fprintf(w, "%s %s\n", blue("Domains:"), strings.Join(p.Domains, ", "))
+
+	t := p.Techniques
+	var techniques []string
+	for _, tech := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"passive", t.Passive},
+		{"active", t.Active},
+		{"brute forcing", t.BruteForcing},
+		{"recursive brute forcing", t.Recursive},
+		{"alterations", t.Alterations},
+		{"OPSEC", t.OPSEC},
+	} {
+		if tech.enabled {
+			techniques = append(techniques, tech.name)
+		}
+	}
+	fmt.Fprintf(w, "%s %s\n", blue("Techniques:"), strings.Join(techniques, ", "))
+
+	var starts int
+	for _, src := range p.Sources {
+		if src.Start {
+			starts++
+		}
+	}
+	fmt.Fprintf(w, "\n%s %d of %d would start\n", blue("Data Sources:"), starts, len(p.Sources))
+	for _, src := range p.Sources {
+		if src.Start {
+			fmt.Fprintf(w, "  %-35s%s\n", green(src.Name), src.Type)
+		} else {
+			fmt.Fprintf(w, "  %-35s%s: %s\n", yellow(src.Name), src.Type, src.Reason)
+		}
+	}
+
+	q := p.Queries
+	if !t.Passive {
+		fmt.Fprintf(w, "\n%s at least %d", blue("DNS Queries:"), q.Total)
+		if q.Duration > 0 {
+			fmt.Fprintf(w, " taking at least %v", q.Duration)
+		}
+		fmt.Fprintf(w, "\n  %d for brute forcing %d words, %d for the known names, and %d for each name discovered\n",
+			q.BruteForce, q.Words, q.Known, q.PerName)
+	}
+
+	fmt.Fprintf(w, "\n%s\n", blue("External Endpoints:"))
+	for _, ep := range p.Endpoints {
+		fmt.Fprintf(w, "  %-35s%s\n", ep.Address, ep.Purpose)
+	}
+
+	for _, warn := range p.Warnings {
+		fmt.Fprintf(w, "\n%s %s", yellow("Warning:"), warn)
+	}
+	for _, b := range p.Blockers {
+		fmt.Fprintf(w, "\n%s %s", r.Sprint("Blocker:"), b)
+	}
+	if len(p.Warnings) > 0 || len(p.Blockers) > 0 {
+		fmt.Fprintln(w)
+	}
+	return len(p.Blockers) == 0
+}
@@ -0,0 +1,149 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/owasp-amass/amass/v4/server"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	serverUsageMsg = "server [options] -token TOKEN"
+	// serverTokenEnv is the environment variable that can provide the API token
+	serverTokenEnv = "AMASS_SERVER_TOKEN"
+)
+
+type serverArgs struct {
+	Listen        string
+	Token         string
+	MaxConcurrent int
+	Shared        bool
+	Health        bool
+	Verbose       bool
+	Filepaths     struct {
+		ConfigFile string
+		Directory  string
+		LogFile    string
+	}
+}
+
+func runServerCommand(clArgs []string) {
+	var args serverArgs
+	var help1, help2 bool
+	serverCommand := flag.NewFlagSet("server", flag.ContinueOnError)
+
+	serverBuf := new(bytes.Buffer)
+	serverCommand.SetOutput(serverBuf)
+
+	serverCommand.BoolVar(&help1, "h", false, "Show the program usage message")
+	serverCommand.BoolVar(&help2, "help", false, "Show the program usage message")
+	serverCommand.StringVar(&args.Listen, "listen", "", "Address the API is served on (default: 127.0.0.1:4000)")
+	serverCommand.StringVar(&args.Token, "token", "", "Static token the API clients present as a bearer token (or "+serverTokenEnv+")")
+	serverCommand.IntVar(&args.MaxConcurrent, "max", 0, "Number of enumerations that run at once, while the other jobs are queued")
+	serverCommand.BoolVar(&args.Shared, "shared", false, "Run all the enumerations on a single shared system")
+	serverCommand.BoolVar(&args.Health, "health", false, "Serve the /healthz and /readyz endpoints without the token")
+	serverCommand.BoolVar(&args.Verbose, "v", false, "Output status / debug / troubleshooting info")
+	serverCommand.StringVar(&args.Filepaths.ConfigFile, "config", "", "Path to the YAML configuration file")
+	serverCommand.StringVar(&args.Filepaths.Directory, "dir", "", "Path to the directory containing the output files")
+	serverCommand.StringVar(&args.Filepaths.LogFile, "log", "", "Path to the log file where errors will be written")
+
+	if err := serverCommand.Parse(clArgs); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	if help1 || help2 {
+		commandUsage(serverUsageMsg, serverCommand, serverBuf)
+		return
+	}
+
+	cfg := config.NewConfig()
+	if err := acquireConfig(args.Filepaths.Directory, args.Filepaths.ConfigFile, cfg); err != nil && args.Filepaths.ConfigFile != "" {
+		r.Fprintf(color.Error, "Failed to load the configuration file: %v\n", err)
+		os.Exit(1)
+	}
+	if args.Filepaths.Directory != "" {
+		cfg.Dir = args.Filepaths.Directory
+	}
+	createOutputDirectory(cfg)
+
+	// Override the configuration file settings with the command-line arguments
+	scfg := server.ConfigFromOptions(cfg)
+	if args.Listen != "" {
+		scfg.Listen = args.Listen
+	}
+	if scfg.Listen == "" {
+		scfg.Listen = "127.0.0.1:4000"
+	}
+	if token := os.Getenv(serverTokenEnv); token != "" {
+		scfg.Token = token
+	}
+	if args.Token != "" {
+		scfg.Token = args.Token
+	}
+	if args.MaxConcurrent > 0 {
+		scfg.MaxConcurrent = args.MaxConcurrent
+	}
+	if args.Shared {
+		scfg.Shared = true
+	}
+	if args.Health {
+		scfg.Health = true
+	}
+	if scfg.Token == "" {
+		commandUsage(serverUsageMsg, serverCommand, serverBuf)
+		os.Exit(1)
+	}
+
+	rLog, wLog := io.Pipe()
+	cfg.Log = log.New(wLog, "", log.Lmicroseconds)
+	logfile := filepath.Join(config.OutputDirectory(cfg.Dir), "amass.log")
+	if args.Filepaths.LogFile != "" {
+		logfile = args.Filepaths.LogFile
+	}
+	go writeLogsAndMessages(rLog, logfile, args.Verbose)
+
+	srv, err := server.NewServer(scfg, cfg)
+	if err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	defer srv.Close()
+
+	hs := &http.Server{
+		Addr:              scfg.Listen,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = hs.Shutdown(shutdown)
+	}()
+
+	g.Fprintf(color.Error, "The API is being served on %s\n", scfg.Listen)
+	if err := hs.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/caffix/stringset"
+	"github.com/fatih/color"
+	"github.com/owasp-amass/amass/v4/remote"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	workerUsageMsg = "worker [options] -ca CA -cert CERT -key KEY"
+)
+
+type workerArgs struct {
+	Listen    string
+	Resolvers *stringset.Set
+	Filepaths struct {
+		CA         string
+		Cert       string
+		Key        string
+		ConfigFile string
+		Directory  string
+	}
+}
+
+func runWorkerCommand(clArgs []string) {
+	args := workerArgs{Resolvers: stringset.New()}
+	var help1, help2 bool
+	workerCommand := flag.NewFlagSet("worker", flag.ContinueOnError)
+
+	workerBuf := new(bytes.Buffer)
+	workerCommand.SetOutput(workerBuf)
+
+	workerCommand.BoolVar(&help1, "h", false, "Show the program usage message")
+	workerCommand.BoolVar(&help2, "help", false, "Show the program usage message")
+	workerCommand.StringVar(&args.Listen, "listen", ":4500", "Address the worker accepts the coordinator connections on")
+	workerCommand.Var(args.Resolvers, "r", "IP addresses of preferred DNS resolvers (can be used multiple times)")
+	workerCommand.StringVar(&args.Filepaths.CA, "ca", "", "Path to the PEM file of the CA that signs the coordinator certificates")
+	workerCommand.StringVar(&args.Filepaths.Cert, "cert", "", "Path to the PEM certificate presented by the worker")
+	workerCommand.StringVar(&args.Filepaths.Key, "key", "", "Path to the PEM private key of the worker certificate")
+	workerCommand.StringVar(&args.Filepaths.ConfigFile, "config", "", "Path to the YAML configuration file")
+	workerCommand.StringVar(&args.Filepaths.Directory, "dir", "", "Path to the directory containing the configuration file")
+
+	if err := workerCommand.Parse(clArgs); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+	if help1 || help2 {
+		commandUsage(workerUsageMsg, workerCommand, workerBuf)
+		return
+	}
+
+	cfg := config.NewConfig()
+	if err := acquireConfig(args.Filepaths.Directory, args.Filepaths.ConfigFile, cfg); err != nil && args.Filepaths.ConfigFile != "" {
+		r.Fprintf(color.Error, "Failed to load the configuration file: %v\n", err)
+		os.Exit(1)
+	}
+	if args.Resolvers.Len() > 0 {
+		cfg.Resolvers = args.Resolvers.Slice()
+	}
+	cfg.Log = log.New(color.Error, "", log.Lmicroseconds)
+
+	tlsConfig, err := remote.ServerTLSConfig(args.Filepaths.CA, args.Filepaths.Cert, args.Filepaths.Key)
+	if err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+
+	ln, err := tls.Listen("tcp", args.Listen, tlsConfig)
+	if err != nil {
+		r.Fprintf(color.Error, "Failed to listen on %s: %v\n", args.Listen, err)
+		os.Exit(1)
+	}
+
+	pool := systems.NewResolverPool(cfg)
+	defer pool.Stop()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	g.Fprintf(color.Error, "The worker is accepting connections on %s using %d resolvers\n", ln.Addr(), pool.Len())
+	if err := remote.NewWorker(pool).Serve(ctx, ln); err != nil {
+		r.Fprintf(color.Error, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newCoordinator connects to the workers registered in the configuration using its mutual TLS credentials.
+func newCoordinator(cfg *remote.Config) (*remote.Coordinator, error) {
+	tlsConfig, err := remote.ClientTLSConfig(cfg.CA, cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	return remote.NewCoordinator(cfg, tlsConfig)
+}
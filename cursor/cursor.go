@@ -0,0 +1,291 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cursor streams enumeration findings out of the graph one page at a time,
+// so exporting a very large event does not require the whole event to be in memory.
+package cursor
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// DefaultPageSize is the number of names requested from the database at a time.
+const DefaultPageSize = 1000
+
+// Pager obtains the names stored in a graph database page by page.
+type Pager interface {
+	// Page returns up to limit FQDN assets matching the domain that were last seen after since and
+	// have names greater than after, ordered by name. A short page ends the iteration.
+	Page(ctx context.Context, domain string, since time.Time, after string, limit int) ([]*types.Asset, error)
+	Close() error
+}
+
+var pagers = struct {
+	sync.Mutex
+	graphs map[*netmap.Graph]Pager
+}{graphs: make(map[*netmap.Graph]Pager)}
+
+// Register makes the iterators over the graph use the pager.
+func Register(g *netmap.Graph, p Pager) {
+	pagers.Lock()
+	defer pagers.Unlock()
+
+	pagers.graphs[g] = p
+}
+
+// Unregister closes the pager registered for the graph.
+func Unregister(g *netmap.Graph) {
+	pagers.Lock()
+	p, found := pagers.graphs[g]
+	delete(pagers.graphs, g)
+	pagers.Unlock()
+
+	if found {
+		_ = p.Close()
+	}
+}
+
+// pagerFor returns the pager registered for the graph, or one that pages through the results of a scope query.
+func pagerFor(g *netmap.Graph) Pager {
+	pagers.Lock()
+	defer pagers.Unlock()
+
+	if p, found := pagers.graphs[g]; found {
+		return p
+	}
+	return &graphPager{g: g}
+}
+
+// NameIterator walks the names discovered within a domain in sorted order. It is not safe for concurrent use.
+type NameIterator struct {
+	ctx    context.Context
+	pager  Pager
+	domain string
+	since  time.Time
+	size   int
+	page   []*types.Asset
+	pos    int
+	after  string
+	last   bool
+	cur    *types.Asset
+	err    error
+}
+
+// NamesIterator returns an iterator over the names within the domain last seen after since.
+func NamesIterator(ctx context.Context, g *netmap.Graph, since time.Time, d string) *NameIterator {
+	if !since.IsZero() {
+		since = since.UTC()
+	}
+
+	return &NameIterator{
+		ctx:    ctx,
+		pager:  pagerFor(g),
+		domain: strings.ToLower(d),
+		since:  since,
+		size:   DefaultPageSize,
+	}
+}
+
+// Next advances the iterator to the next name and returns false once the names are exhausted or an error occurs.
+func (it *NameIterator) Next() bool {
+	for {
+		if it.err != nil {
+			return false
+		}
+
+		for it.pos < len(it.page) {
+			a := it.page[it.pos]
+			it.pos++
+
+			if fqdn, ok := a.Asset.(domain.FQDN); ok && inScope(fqdn.Name, it.domain) {
+				it.cur = a
+				return true
+			}
+		}
+
+		if it.last {
+			it.cur = nil
+			return false
+		}
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+
+		page, err := it.pager.Page(it.ctx, it.domain, it.since, it.after, it.size)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page, it.pos = page, 0
+		it.last = len(page) < it.size
+		if len(page) > 0 {
+			it.after = fqdnName(page[len(page)-1])
+		}
+	}
+}
+
+// Value returns the FQDN asset at the current position of the iterator.
+func (it *NameIterator) Value() *types.Asset { return it.cur }
+
+// Name returns the name at the current position of the iterator.
+func (it *NameIterator) Name() string {
+	if it.cur == nil {
+		return ""
+	}
+	return fqdnName(it.cur)
+}
+
+// Err returns the error that stopped the iteration.
+func (it *NameIterator) Err() error { return it.err }
+
+// NormalizeName returns the form of the name the exports are sorted and identified by.
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(name), "."))
+}
+
+// SortedIterator walks the distinct names within several domains, normalized and sorted, so the exports of an event
+// do not depend on the order the names were inserted into the graph. The names of each domain are read one page at a
+// time and merged as they are read. It is not safe for concurrent use.
+type SortedIterator struct {
+	its   []*NameIterator
+	heads []string
+	init  bool
+	cur   string
+	err   error
+}
+
+// SortedNamesIterator returns an iterator over the distinct names within the domains last seen after since.
+func SortedNamesIterator(ctx context.Context, g *netmap.Graph, since time.Time, domains ...string) *SortedIterator {
+	s := &SortedIterator{heads: make([]string, len(domains))}
+
+	for _, d := range domains {
+		s.its = append(s.its, NamesIterator(ctx, g, since, d))
+	}
+	return s
+}
+
+// Next advances the iterator to the next name and returns false once the names are exhausted or an error occurs.
+func (s *SortedIterator) Next() bool {
+	if !s.init {
+		s.init = true
+		for i := range s.its {
+			s.advance(i, "")
+		}
+	}
+	if s.err != nil {
+		s.cur = ""
+		return false
+	}
+
+	s.cur = ""
+	for _, h := range s.heads {
+		if h != "" && (s.cur == "" || h < s.cur) {
+			s.cur = h
+		}
+	}
+	if s.cur == "" {
+		return false
+	}
+	// The name is returned once, even when several of the domains contain it
+	for i, h := range s.heads {
+		if h == s.cur {
+			s.advance(i, s.cur)
+		}
+	}
+	return true
+}
+
+// advance moves the iterator of the domain past the name, and keeps the next name as its head.
+func (s *SortedIterator) advance(i int, name string) {
+	it := s.its[i]
+
+	s.heads[i] = ""
+	for it.Next() {
+		if n := NormalizeName(it.Name()); n != "" && n != name {
+			s.heads[i] = n
+			return
+		}
+	}
+	if err := it.Err(); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// Name returns the normalized name at the current position of the iterator.
+func (s *SortedIterator) Name() string { return s.cur }
+
+// Err returns the error that stopped the iteration.
+func (s *SortedIterator) Err() error { return s.err }
+
+// inScope returns true when the name is the domain or a subdomain, as the database pattern also matches other names.
+func inScope(name, d string) bool {
+	name = strings.ToLower(name)
+	return name == d || strings.HasSuffix(name, "."+d)
+}
+
+// graphPager pages through the results of a scope query for databases that cannot be paginated, such as the
+// in-memory graph. Only the results of the latest query are held, but those are not streamed from the database.
+type graphPager struct {
+	sync.Mutex
+	g      *netmap.Graph
+	key    string
+	assets []*types.Asset
+	names  []string
+}
+
+func (gp *graphPager) Page(ctx context.Context, d string, since time.Time, after string, limit int) ([]*types.Asset, error) {
+	gp.Lock()
+	defer gp.Unlock()
+
+	if key := d + "|" + since.String(); after == "" || key != gp.key {
+		gp.query(d, since)
+		gp.key = key
+	}
+
+	start := sort.Search(len(gp.names), func(i int) bool { return gp.names[i] > after })
+	end := start + limit
+	if end > len(gp.assets) {
+		end = len(gp.assets)
+	}
+	return gp.assets[start:end], nil
+}
+
+func (gp *graphPager) query(d string, since time.Time) {
+	gp.assets, gp.names = nil, nil
+	// An error is returned when there are no assets in scope
+	assets, err := gp.g.DB.FindByScope([]oam.Asset{domain.FQDN{Name: d}}, since)
+	if err != nil {
+		return
+	}
+
+	for _, a := range assets {
+		if _, ok := a.Asset.(domain.FQDN); ok {
+			gp.assets = append(gp.assets, a)
+		}
+	}
+	sort.Slice(gp.assets, func(i, j int) bool { return fqdnName(gp.assets[i]) < fqdnName(gp.assets[j]) })
+
+	for _, a := range gp.assets {
+		gp.names = append(gp.names, fqdnName(a))
+	}
+}
+
+// fqdnName returns the name of the FQDN asset.
+func fqdnName(a *types.Asset) string {
+	fqdn, _ := a.Asset.(domain.FQDN)
+	return fqdn.Name
+}
+
+func (gp *graphPager) Close() error { return nil }
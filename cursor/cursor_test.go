@@ -0,0 +1,191 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package cursor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/glebarez/sqlite"
+	oam "github.com/owasp-amass/open-asset-model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// insertNames adds the names to a local graph database without going through the graph, which is far too slow for large events.
+func insertNames(tb testing.TB, path string, names func(i int) string, n int) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() {
+		if sqldb, err := db.DB(); err == nil {
+			_ = sqldb.Close()
+		}
+	}()
+
+	const batch = 500
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < n; i += batch {
+			var values []string
+			var args []interface{}
+
+			for j := i; j < i+batch && j < n; j++ {
+				values = append(values, "(?, ?)")
+				args = append(args, string(oam.FQDN), `{"name":`+strconv.Quote(names(j))+`}`)
+			}
+			if err := tx.Exec("INSERT INTO assets (type, content) VALUES "+strings.Join(values, ","), args...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// newLocalGraph returns a local graph database holding n names within owasp.org and a few names out of scope.
+func newLocalGraph(tb testing.TB, dir string, n int) (*netmap.Graph, string) {
+	path := filepath.Join(dir, "amass.sqlite")
+	g := netmap.NewGraph("local", path, "")
+	if g == nil {
+		tb.Fatal("failed to create the local graph")
+	}
+
+	insertNames(tb, path, func(i int) string {
+		switch i % 100 {
+		case 10:
+			return fmt.Sprintf("host%d.notowasp.org", i)
+		case 20:
+			return fmt.Sprintf("host%d.example.com", i)
+		}
+		return fmt.Sprintf("host%d.owasp.org", i)
+	}, n)
+	return g, path
+}
+
+func collectNames(t *testing.T, it *NameIterator) []string {
+	var names []string
+	var last string
+
+	for it.Next() {
+		if name := it.Name(); name <= last {
+			t.Fatalf("the name %s does not follow %s", name, last)
+		}
+		last = it.Name()
+		names = append(names, it.Name())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func checkNames(t *testing.T, names []string, expected int) {
+	if len(names) != expected {
+		t.Errorf("the iterator returned %d names, expected %d", len(names), expected)
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		if n != "owasp.org" && !strings.HasSuffix(n, ".owasp.org") {
+			t.Errorf("the iterator returned the out of scope name %s", n)
+		}
+		if _, dup := seen[n]; dup {
+			t.Errorf("the iterator returned %s more than once", n)
+		}
+		seen[n] = struct{}{}
+	}
+}
+
+func TestNamesIteratorSQL(t *testing.T) {
+	g, path := newLocalGraph(t, t.TempDir(), 1050)
+	defer g.Remove()
+
+	p, err := NewSQLPager("local", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Register(g, p)
+	defer Unregister(g)
+
+	it := NamesIterator(context.Background(), g, time.Time{}, "OWASP.org")
+	if _, ok := it.pager.(*sqlPager); !ok {
+		t.Fatal("the registered pager was not used")
+	}
+	it.size = 100
+	checkNames(t, collectNames(t, it), 1028)
+
+	// Names last seen before the event are not returned
+	it = NamesIterator(context.Background(), g, time.Now().Add(time.Hour), "owasp.org")
+	checkNames(t, collectNames(t, it), 0)
+}
+
+func TestNamesIteratorGraph(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	for i := 0; i < 25; i++ {
+		_, _ = g.UpsertFQDN(ctx, fmt.Sprintf("host%d.owasp.org", i))
+	}
+	_, _ = g.UpsertFQDN(ctx, "www.notowasp.org")
+
+	it := NamesIterator(ctx, g, time.Time{}, "owasp.org")
+	it.size = 10
+	checkNames(t, collectNames(t, it), 26)
+
+	it = NamesIterator(ctx, g, time.Time{}, "example.com")
+	checkNames(t, collectNames(t, it), 0)
+}
+
+func TestSortedNames(t *testing.T) {
+	g := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if g == nil {
+		t.Fatal("failed to create the local graph")
+	}
+	defer g.Remove()
+
+	ctx := context.Background()
+	for _, name := range []string{"www.owasp.org", "api.owasp.org", "dev.www.owasp.org", "mail.example.com", "owasp.org"} {
+		_, _ = g.UpsertFQDN(ctx, name)
+	}
+
+	// The names within both domains are returned once
+	var names []string
+	it := SortedNamesIterator(ctx, g, time.Time{}, "www.owasp.org", "OWASP.org")
+	for it.Next() {
+		names = append(names, it.Name())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"api.owasp.org", "dev.www.owasp.org", "owasp.org", "www.owasp.org"}; strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("the names were %v, expected %v", names, expected)
+	}
+}
+
+func TestNamesIteratorCanceled(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 3; i++ {
+		_, _ = g.UpsertFQDN(ctx, fmt.Sprintf("host%d.owasp.org", i))
+	}
+	cancel()
+
+	it := NamesIterator(ctx, g, time.Time{}, "owasp.org")
+	if it.Next() || it.Err() != context.Canceled {
+		t.Errorf("the iterator returned %v after the context was canceled", it.Err())
+	}
+}
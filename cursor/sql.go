@@ -0,0 +1,92 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package cursor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/owasp-amass/asset-db/repository"
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlPager uses keyset pagination on the names in the assets table of the SQLite and Postgres graph databases.
+type sqlPager struct {
+	db *gorm.DB
+	// name is the expression of the FQDN name, compared byte by byte like the Go strings
+	name string
+}
+
+// NewSQLPager opens a separate connection to the graph database identified by the system and DSN,
+// using the same values that were provided to netmap.NewGraph.
+func NewSQLPager(system, dsn string) (Pager, error) {
+	var dialect gorm.Dialector
+
+	name := "content->>'name'"
+	switch system {
+	case "local":
+		// Reads wait for the writes of the enumeration instead of failing
+		if !strings.Contains(dsn, "?") {
+			dsn += "?_pragma=busy_timeout(5000)"
+		}
+		dialect = sqlite.Open(dsn)
+	case "postgres":
+		dialect = postgres.Open(dsn)
+		// The collation of the database may not order the names byte by byte
+		name = `(content->>'name') COLLATE "C"`
+	default:
+		return nil, fmt.Errorf("NewSQLPager: the %s database cannot be paginated", system)
+	}
+
+	db, err := gorm.Open(dialect, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLPager: %v", err)
+	}
+	// The pages are read in the order of the names, which the schema of the database does not index.
+	// A database the connection cannot alter is still paged, only at the cost of sorting each page.
+	_ = db.Exec("CREATE INDEX IF NOT EXISTS idx_assets_type_name ON assets (type, (" + name + "))").Error
+	return &sqlPager{db: db, name: name}, nil
+}
+
+func (sp *sqlPager) Page(ctx context.Context, d string, since time.Time, after string, limit int) ([]*types.Asset, error) {
+	tx := sp.db.WithContext(ctx).Where("type = ? AND content->>'name' LIKE ? AND "+sp.name+" > ?", oam.FQDN, "%"+d, after)
+	if !since.IsZero() {
+		tx = tx.Where("last_seen > ?", since)
+	}
+
+	var rows []repository.Asset
+	if err := tx.Order(sp.name).Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	page := make([]*types.Asset, 0, len(rows))
+	for _, r := range rows {
+		if a, err := r.Parse(); err == nil {
+			page = append(page, &types.Asset{
+				ID:        strconv.FormatInt(r.ID, 10),
+				CreatedAt: r.CreatedAt,
+				LastSeen:  r.LastSeen,
+				Asset:     a,
+			})
+		}
+	}
+	return page, nil
+}
+
+func (sp *sqlPager) Close() error {
+	db, err := sp.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
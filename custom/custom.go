@@ -0,0 +1,544 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package custom stores the node types and edge predicates registered by the programs built on the library,
+// such as the hashes of screenshots or the identifiers of vulnerability findings, in the graph databases beside
+// the assets of the Open Asset Model. The names of the types and predicates are namespaced, as in
+// "acme:screenshot", so they never collide with the types of the model or with those of other programs.
+// The graph only knows the types of the model, so the custom nodes and edges are read and written through
+// a separate connection to the same database, and the rest of the code skips them.
+package custom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/glebarez/sqlite"
+	"github.com/owasp-amass/asset-db/repository"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ErrNotRegistered is returned for the node types and predicates that were not registered.
+var ErrNotRegistered = errors.New("the custom type has not been registered")
+
+// namePattern matches the namespaced names of the custom node types and predicates.
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*:[a-z0-9][a-z0-9_.-]*$`)
+
+// NodeType is a custom node type. The nodes of a type are identified by their keys.
+type NodeType struct {
+	Name        string
+	Description string
+}
+
+// Predicate is a custom edge predicate, along with the types of the nodes it may lead from and to. The types
+// are those of the model, such as FQDN, or custom node types, and an empty list allows any type.
+type Predicate struct {
+	Name string
+	From []string
+	To   []string
+}
+
+var registry = struct {
+	sync.RWMutex
+	nodes      map[string]NodeType
+	predicates map[string]Predicate
+}{
+	nodes:      make(map[string]NodeType),
+	predicates: make(map[string]Predicate),
+}
+
+// IsCustom returns true when the type or predicate name is namespaced, which the names of the model never are.
+func IsCustom(name string) bool {
+	return strings.Contains(name, ":")
+}
+
+// ValidName returns an error unless the name is namespaced and made of lowercase letters, digits,
+// dots, dashes and underscores.
+func ValidName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("the custom name %q is not in the namespace:name format", name)
+	}
+	return nil
+}
+
+// RegisterNode makes the node type available to the stores. Registering the same type again replaces it.
+func RegisterNode(t NodeType) error {
+	if err := ValidName(t.Name); err != nil {
+		return err
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.nodes[t.Name] = t
+	return nil
+}
+
+// RegisterPredicate makes the edge predicate available to the stores. Registering the same predicate again replaces it.
+func RegisterPredicate(p Predicate) error {
+	if err := ValidName(p.Name); err != nil {
+		return err
+	}
+	for _, t := range append(append([]string(nil), p.From...), p.To...) {
+		if IsCustom(t) {
+			if err := ValidName(t); err != nil {
+				return err
+			}
+		}
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.predicates[p.Name] = p
+	return nil
+}
+
+// RegisteredNode returns the node type registered with the name.
+func RegisteredNode(name string) (NodeType, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	t, found := registry.nodes[name]
+	return t, found
+}
+
+// RegisteredPredicate returns the edge predicate registered with the name.
+func RegisteredPredicate(name string) (Predicate, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	p, found := registry.predicates[name]
+	return p, found
+}
+
+// Node is a custom node stored in the graph database.
+type Node struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Key       string          `json:"key"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	LastSeen  time.Time       `json:"last_seen"`
+}
+
+// Edge is a custom edge stored in the graph database. The nodes it leads from and to are either custom nodes
+// or assets of the model, identified the same way.
+type Edge struct {
+	ID        string    `json:"id"`
+	Predicate string    `json:"predicate"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// content is the JSON content of the asset rows holding the custom nodes.
+type content struct {
+	Key  string          `json:"key"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// ParseContent returns the key and data of the custom node stored with the content.
+func ParseContent(raw []byte) (string, json.RawMessage, error) {
+	var c content
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return "", nil, fmt.Errorf("failed to parse the custom node: %v", err)
+	}
+	if c.Key == "" {
+		return "", nil, errors.New("the custom node has no key")
+	}
+	return c.Key, c.Data, nil
+}
+
+// Content returns the JSON content of the asset row holding the node.
+func (n *Node) Content() ([]byte, error) {
+	return json.Marshal(&content{Key: n.Key, Data: n.Data})
+}
+
+// Store reads and writes the custom nodes and edges of a graph database.
+type Store struct {
+	db *gorm.DB
+}
+
+// Open opens a separate connection to the graph database identified by the system and DSN,
+// using the same values that were provided to netmap.NewGraph.
+func Open(system, dsn string) (*Store, error) {
+	var dialect gorm.Dialector
+
+	switch system {
+	case "local":
+		// The writes wait for those of the graph instead of failing
+		if !strings.Contains(dsn, "?") {
+			dsn += "?_pragma=busy_timeout(5000)"
+		}
+		dialect = sqlite.Open(dsn)
+	case "postgres":
+		dialect = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("custom: the %s database cannot store custom types", system)
+	}
+
+	db, err := gorm.Open(dialect, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("custom: %v", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the connection to the graph database.
+func (s *Store) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+var stores = struct {
+	sync.Mutex
+	graphs map[*netmap.Graph]*Store
+}{graphs: make(map[*netmap.Graph]*Store)}
+
+// Register makes the store available for the graph through For.
+func Register(g *netmap.Graph, s *Store) {
+	stores.Lock()
+	defer stores.Unlock()
+
+	stores.graphs[g] = s
+}
+
+// Unregister closes the store registered for the graph.
+func Unregister(g *netmap.Graph) {
+	stores.Lock()
+	s, found := stores.graphs[g]
+	delete(stores.graphs, g)
+	stores.Unlock()
+
+	if found {
+		_ = s.Close()
+	}
+}
+
+// For returns the store of the custom nodes and edges registered for the graph, or nil when there is none.
+// The System registers a store for each graph database it opens.
+func For(g *netmap.Graph) *Store {
+	stores.Lock()
+	defer stores.Unlock()
+
+	return stores.graphs[g]
+}
+
+// AddNode stores the node of the registered type, identified by its key, along with its data encoded as JSON.
+// The node stored before with the same key has its data replaced and its last seen time updated.
+func (s *Store) AddNode(ctx context.Context, ntype, key string, data interface{}) (*Node, error) {
+	if _, found := RegisteredNode(ntype); !found {
+		return nil, fmt.Errorf("%w: %s", ErrNotRegistered, ntype)
+	}
+	if key == "" {
+		return nil, errors.New("the custom node requires a key")
+	}
+
+	var raw json.RawMessage
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the data of the custom node: %v", err)
+		}
+		raw = b
+	}
+
+	now := time.Now().UTC()
+	return s.PutNode(ctx, &Node{Type: ntype, Key: key, Data: raw, CreatedAt: now, LastSeen: now})
+}
+
+// PutNode stores the node with the times it carries, whether or not its type has been registered, so the
+// archives and merges of the graph preserve the custom nodes of every program. The node stored before
+// with the same key has its data replaced, and its times widened to cover those of the node.
+func (s *Store) PutNode(ctx context.Context, n *Node) (*Node, error) {
+	if err := ValidName(n.Type); err != nil {
+		return nil, err
+	}
+
+	raw, err := n.Content()
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := s.findRow(ctx, n.Type, n.Key)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		row = &repository.Asset{CreatedAt: n.CreatedAt, LastSeen: n.LastSeen, Type: n.Type, Content: raw}
+		if err := s.db.WithContext(ctx).Create(row).Error; err != nil {
+			return nil, fmt.Errorf("failed to store the custom node: %v", err)
+		}
+		return toNode(row)
+	}
+
+	updates := map[string]interface{}{"content": raw}
+	if !n.CreatedAt.IsZero() && n.CreatedAt.Before(row.CreatedAt) {
+		updates["created_at"] = n.CreatedAt
+	}
+	if n.LastSeen.After(row.LastSeen) {
+		updates["last_seen"] = n.LastSeen
+	}
+	if err := s.db.WithContext(ctx).Model(row).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update the custom node: %v", err)
+	}
+	return toNode(row)
+}
+
+// Link stores the edge of the registered predicate between the nodes, which are custom nodes or assets of the
+// model. The edge stored before between the same nodes has its last seen time updated.
+func (s *Store) Link(ctx context.Context, from, predicate, to string) (*Edge, error) {
+	p, found := RegisteredPredicate(predicate)
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNotRegistered, predicate)
+	}
+
+	ftype, err := s.assetType(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	ttype, err := s.assetType(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed(p.From, ftype) || !allowed(p.To, ttype) {
+		return nil, fmt.Errorf("%s -%s-> %s is not allowed by the predicate", ftype, predicate, ttype)
+	}
+
+	now := time.Now().UTC()
+	return s.PutEdge(ctx, &Edge{Predicate: predicate, From: from, To: to, CreatedAt: now, LastSeen: now})
+}
+
+// PutEdge stores the edge with the times it carries, whether or not its predicate has been registered.
+// The edge stored before between the same nodes has its times widened to cover those of the edge.
+func (s *Store) PutEdge(ctx context.Context, e *Edge) (*Edge, error) {
+	if err := ValidName(e.Predicate); err != nil {
+		return nil, err
+	}
+
+	fromID, err := strconv.ParseInt(e.From, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("the node identifier %q is not valid", e.From)
+	}
+	toID, err := strconv.ParseInt(e.To, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("the node identifier %q is not valid", e.To)
+	}
+
+	var rows []repository.Relation
+	if err := s.db.WithContext(ctx).Where("type = ? AND from_asset_id = ? AND to_asset_id = ?",
+		e.Predicate, fromID, toID).Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		row := &repository.Relation{
+			CreatedAt:   e.CreatedAt,
+			LastSeen:    e.LastSeen,
+			Type:        e.Predicate,
+			FromAssetID: fromID,
+			ToAssetID:   toID,
+		}
+		if err := s.db.WithContext(ctx).Omit("FromAsset", "ToAsset").Create(row).Error; err != nil {
+			return nil, fmt.Errorf("failed to store the custom edge: %v", err)
+		}
+		return toEdge(row), nil
+	}
+
+	row := &rows[0]
+	updates := make(map[string]interface{})
+	if !e.CreatedAt.IsZero() && e.CreatedAt.Before(row.CreatedAt) {
+		updates["created_at"] = e.CreatedAt
+	}
+	if e.LastSeen.After(row.LastSeen) {
+		updates["last_seen"] = e.LastSeen
+	}
+	if len(updates) > 0 {
+		if err := s.db.WithContext(ctx).Model(row).Omit("FromAsset", "ToAsset").Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update the custom edge: %v", err)
+		}
+	}
+	return toEdge(row), nil
+}
+
+// FindNode returns the node of the type with the key, or nil when none is stored.
+func (s *Store) FindNode(ctx context.Context, ntype, key string) (*Node, error) {
+	row, err := s.findRow(ctx, ntype, key)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return toNode(row)
+}
+
+// NodeByID returns the custom node with the identifier.
+func (s *Store) NodeByID(ctx context.Context, id string) (*Node, error) {
+	var rows []repository.Asset
+	if err := s.db.WithContext(ctx).Where("id = ?", id).Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || !IsCustom(rows[0].Type) {
+		return nil, fmt.Errorf("the custom node %s was not found", id)
+	}
+	return toNode(&rows[0])
+}
+
+// FindNodes returns the nodes of the types last seen after since, ordered by their identifiers. Without types,
+// the custom nodes of every type are returned, including those of the types that were not registered.
+func (s *Store) FindNodes(ctx context.Context, since time.Time, ntypes ...string) ([]*Node, error) {
+	tx := s.db.WithContext(ctx)
+	if len(ntypes) > 0 {
+		tx = tx.Where("type IN ?", ntypes)
+	} else {
+		tx = tx.Where("type LIKE ?", "%:%")
+	}
+	if !since.IsZero() {
+		tx = tx.Where("last_seen > ?", since.UTC())
+	}
+
+	var rows []repository.Asset
+	if err := tx.Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*Node, 0, len(rows))
+	for i := range rows {
+		if n, err := toNode(&rows[i]); err == nil {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// Outgoing returns the custom edges leading from the node, of the predicates when provided.
+func (s *Store) Outgoing(ctx context.Context, id string, predicates ...string) ([]*Edge, error) {
+	return s.edges(ctx, "from_asset_id = ?", id, predicates)
+}
+
+// Incoming returns the custom edges leading to the node, of the predicates when provided.
+func (s *Store) Incoming(ctx context.Context, id string, predicates ...string) ([]*Edge, error) {
+	return s.edges(ctx, "to_asset_id = ?", id, predicates)
+}
+
+// Edges returns the custom edges of every predicate last seen after since, ordered by their identifiers.
+func (s *Store) Edges(ctx context.Context, since time.Time) ([]*Edge, error) {
+	tx := s.db.WithContext(ctx).Where("type LIKE ?", "%:%")
+	if !since.IsZero() {
+		tx = tx.Where("last_seen > ?", since.UTC())
+	}
+
+	var rows []repository.Relation
+	if err := tx.Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	edges := make([]*Edge, 0, len(rows))
+	for i := range rows {
+		edges = append(edges, toEdge(&rows[i]))
+	}
+	return edges, nil
+}
+
+func (s *Store) edges(ctx context.Context, cond, id string, predicates []string) ([]*Edge, error) {
+	nid, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("the node identifier %q is not valid", id)
+	}
+
+	tx := s.db.WithContext(ctx).Where(cond, nid)
+	if len(predicates) > 0 {
+		tx = tx.Where("type IN ?", predicates)
+	} else {
+		tx = tx.Where("type LIKE ?", "%:%")
+	}
+
+	var rows []repository.Relation
+	if err := tx.Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	edges := make([]*Edge, 0, len(rows))
+	for i := range rows {
+		edges = append(edges, toEdge(&rows[i]))
+	}
+	sort.SliceStable(edges, func(i, j int) bool { return edges[i].Predicate < edges[j].Predicate })
+	return edges, nil
+}
+
+func (s *Store) findRow(ctx context.Context, ntype, key string) (*repository.Asset, error) {
+	var rows []repository.Asset
+	if err := s.db.WithContext(ctx).Where("type = ? AND content->>'key' = ?",
+		ntype, key).Order("id").Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+// assetType returns the type of the custom node or asset of the model with the identifier.
+func (s *Store) assetType(ctx context.Context, id string) (string, error) {
+	var rows []repository.Asset
+	if err := s.db.WithContext(ctx).Select("id", "type").Where("id = ?", id).Limit(1).Find(&rows).Error; err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("the node %s was not found", id)
+	}
+	return rows[0].Type, nil
+}
+
+func allowed(types []string, t string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, a := range types {
+		if a == t {
+			return true
+		}
+	}
+	return false
+}
+
+func toNode(row *repository.Asset) (*Node, error) {
+	key, data, err := ParseContent(row.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Node{
+		ID:        strconv.FormatInt(row.ID, 10),
+		Type:      row.Type,
+		Key:       key,
+		Data:      data,
+		CreatedAt: row.CreatedAt,
+		LastSeen:  row.LastSeen,
+	}, nil
+}
+
+func toEdge(row *repository.Relation) *Edge {
+	return &Edge{
+		ID:        strconv.FormatInt(row.ID, 10),
+		Predicate: row.Type,
+		From:      strconv.FormatInt(row.FromAssetID, 10),
+		To:        strconv.FormatInt(row.ToAssetID, 10),
+		CreatedAt: row.CreatedAt,
+		LastSeen:  row.LastSeen,
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package custom
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestValidName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"acme:screenshot":     true,
+		"acme.io:tls_finding": true,
+		"screenshot":          false,
+		"Acme:screenshot":     false,
+		"acme:":               false,
+		":screenshot":         false,
+		"acme:screen shot":    false,
+	} {
+		if err := ValidName(name); (err == nil) != valid {
+			t.Errorf("the validity of %q was not %t", name, valid)
+		}
+	}
+
+	if err := RegisterNode(NodeType{Name: "FQDN"}); err == nil {
+		t.Error("the node type without a namespace was registered")
+	}
+	if err := RegisterPredicate(Predicate{Name: "test:pred", To: []string{"Bad:type"}}); err == nil {
+		t.Error("the predicate leading to an invalid custom type was registered")
+	}
+}
+
+// testStore returns a local graph along with the custom store registered for it.
+func testStore(t *testing.T) (*netmap.Graph, *Store) {
+	dsn := filepath.Join(t.TempDir(), "amass.sqlite")
+	g := netmap.NewGraph("local", dsn, "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+
+	s, err := Open("local", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Register(g, s)
+	t.Cleanup(func() { Unregister(g) })
+	return g, s
+}
+
+func TestStoreNodesAndEdges(t *testing.T) {
+	_ = RegisterNode(NodeType{Name: "test:tag"})
+	_ = RegisterPredicate(Predicate{Name: "test:tagged", From: []string{"FQDN"}, To: []string{"test:tag"}})
+
+	g, s := testStore(t)
+	if For(g) != s {
+		t.Fatal("the store was not registered for the graph")
+	}
+
+	ctx := context.Background()
+	if _, err := s.AddNode(ctx, "test:unknown", "x", nil); !errors.Is(err, ErrNotRegistered) {
+		t.Errorf("the node of an unknown type was stored: %v", err)
+	}
+
+	tag, err := s.AddNode(ctx, "test:tag", "prod", map[string]string{"owner": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The node stored again with the same key is updated
+	again, err := s.AddNode(ctx, "test:tag", "prod", map[string]string{"owner": "infra"})
+	if err != nil || again.ID != tag.ID || string(again.Data) != `{"owner":"infra"}` {
+		t.Fatalf("the node was stored again as %+v: %v", again, err)
+	}
+
+	fqdn, err := g.DB.Create(nil, "", &domain.FQDN{Name: "www.owasp.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Link(ctx, tag.ID, "test:tagged", fqdn.ID); err == nil {
+		t.Error("the edge was stored in the wrong direction")
+	}
+	edge, err := s.Link(ctx, fqdn.ID, "test:tagged", tag.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := s.Outgoing(ctx, fqdn.ID); err != nil || len(out) != 1 || out[0].ID != edge.ID {
+		t.Errorf("the outgoing edges were %v: %v", out, err)
+	}
+	if in, err := s.Incoming(ctx, tag.ID, "test:tagged"); err != nil || len(in) != 1 || in[0].From != fqdn.ID {
+		t.Errorf("the incoming edges were %v: %v", in, err)
+	}
+	if n, err := s.FindNode(ctx, "test:tag", "prod"); err != nil || n == nil || n.ID != tag.ID {
+		t.Errorf("FindNode returned %v: %v", n, err)
+	}
+	if nodes, err := s.FindNodes(ctx, time.Now().Add(time.Hour), "test:tag"); err != nil || len(nodes) != 0 {
+		t.Errorf("FindNodes returned the nodes last seen before since: %v", nodes)
+	}
+
+	// The core code keeps working with the custom nodes in the graph
+	if _, err := g.DB.FindById(fqdn.ID, time.Time{}); err != nil {
+		t.Errorf("the asset linked to the custom node cannot be read: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package custom_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/backup"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// The example extension stores the screenshots taken of the web servers and the findings of a
+// vulnerability scanner, and links them to the names found by the enumeration.
+
+type screenshot struct {
+	SHA256 string `json:"sha256"`
+	URL    string `json:"url"`
+}
+
+type finding struct {
+	Template string `json:"template"`
+	Severity string `json:"severity"`
+}
+
+func registerExtension(t *testing.T) {
+	for _, err := range []error{
+		custom.RegisterNode(custom.NodeType{Name: "acme:screenshot", Description: "A screenshot of a web server"}),
+		custom.RegisterNode(custom.NodeType{Name: "acme:nuclei_finding", Description: "A finding of the scanner"}),
+		custom.RegisterPredicate(custom.Predicate{
+			Name: "acme:rendered_as",
+			From: []string{"FQDN"},
+			To:   []string{"acme:screenshot"},
+		}),
+		custom.RegisterPredicate(custom.Predicate{
+			Name: "acme:affected_by",
+			From: []string{"FQDN", "IPAddress"},
+			To:   []string{"acme:nuclei_finding"},
+		}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newGraph(t *testing.T) (*netmap.Graph, *custom.Store) {
+	dsn := filepath.Join(t.TempDir(), "amass.sqlite")
+	g := netmap.NewGraph("local", dsn, "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+
+	s, err := custom.Open("local", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom.Register(g, s)
+	t.Cleanup(func() { custom.Unregister(g) })
+	if err := systems.RegisterAssetStore(g, "local", dsn); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { systems.UnregisterAssetStore(g) })
+	return g, s
+}
+
+func TestExtensionRoundTrip(t *testing.T) {
+	registerExtension(t)
+	ctx := context.Background()
+
+	g, s := newGraph(t)
+	if err := g.UpsertA(ctx, "www.owasp.org", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	www, err := g.DB.FindByContent(&domain.FQDN{Name: "www.owasp.org"}, time.Time{})
+	if err != nil || len(www) != 1 {
+		t.Fatalf("the name was not stored: %v", err)
+	}
+
+	shot, err := s.AddNode(ctx, "acme:screenshot", "e3b0c442", &screenshot{SHA256: "e3b0c442", URL: "https://www.owasp.org/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vuln, err := s.AddNode(ctx, "acme:nuclei_finding", "tls-version", &finding{Template: "tls-version", Severity: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Link(ctx, www[0].ID, "acme:rendered_as", shot.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Link(ctx, www[0].ID, "acme:affected_by", vuln.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The core code skips the custom data while it repairs the graph
+	if _, err := systems.RepairOrphans(g); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	meta, err := backup.Snapshot(ctx, g, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Assets != 5 || meta.Relations != 3 {
+		t.Errorf("the archive holds %d assets and %d relations", meta.Assets, meta.Relations)
+	}
+
+	restored, rs := newGraph(t)
+	if _, err := backup.Restore(ctx, restored, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := restored.DB.FindByContent(&domain.FQDN{Name: "www.owasp.org"}, time.Time{})
+	if err != nil || len(names) != 1 {
+		t.Fatalf("the name was not restored: %v", err)
+	}
+	out, err := rs.Outgoing(ctx, names[0].ID, "acme:rendered_as")
+	if err != nil || len(out) != 1 {
+		t.Fatalf("the custom edge was not restored: %v", err)
+	}
+
+	node, err := rs.NodeByID(ctx, out[0].To)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got screenshot
+	if err := json.Unmarshal(node.Data, &got); err != nil || got.URL != "https://www.owasp.org/" {
+		t.Errorf("the screenshot was restored as %+v: %v", got, err)
+	}
+	if !node.CreatedAt.Equal(shot.CreatedAt) {
+		t.Errorf("the screenshot was restored with the time %v instead of %v", node.CreatedAt, shot.CreatedAt)
+	}
+	if n, err := rs.FindNode(ctx, "acme:nuclei_finding", "tls-version"); err != nil || n == nil {
+		t.Errorf("the finding was not restored: %v", err)
+	}
+
+	// The archive is restored only into the graph databases able to hold the custom data
+	plain := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if _, err := backup.Restore(ctx, plain, bytes.NewReader(archive.Bytes())); err == nil {
+		t.Error("the custom data was restored without a custom store")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package external
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+// The defaults of the external data sources.
+const (
+	DefaultRestarts         = 5
+	DefaultTimeout          = 2 * time.Minute
+	DefaultHandshakeTimeout = 10 * time.Second
+)
+
+// Settings are the options of the external_sources section of the configuration.
+type Settings struct {
+	// Directory holds the executables launched as the external data sources
+	Directory string
+	// Restarts is the number of times a source that crashed is launched again during the run
+	Restarts int
+	// Timeout is the longest a source takes to answer a request
+	Timeout time.Duration
+	// HandshakeTimeout is the longest a source takes to write its handshake once launched
+	HandshakeTimeout time.Duration
+}
+
+// SettingsFromConfig returns the settings of the external data sources. The sources are not discovered
+// unless the directory has been configured.
+func SettingsFromConfig(cfg *config.Config) Settings {
+	s := Settings{
+		Restarts:         DefaultRestarts,
+		Timeout:          DefaultTimeout,
+		HandshakeTimeout: DefaultHandshakeTimeout,
+	}
+	if cfg == nil {
+		return s
+	}
+
+	section, ok := cfg.Options["external_sources"].(map[string]interface{})
+	if !ok {
+		return s
+	}
+	if dir, ok := section["directory"].(string); ok && dir != "" {
+		if !filepath.IsAbs(dir) && cfg.Filepath != "" {
+			dir = filepath.Join(filepath.Dir(cfg.Filepath), dir)
+		}
+		s.Directory = dir
+	}
+	if v, found := section["restarts"]; found {
+		if n := options.Int(v); n >= 0 {
+			s.Restarts = n
+		}
+	}
+	if n := options.Int(section["timeout"]); n > 0 {
+		s.Timeout = time.Duration(n) * time.Second
+	}
+	if n := options.Int(section["handshake_timeout"]); n > 0 {
+		s.HandshakeTimeout = time.Duration(n) * time.Second
+	}
+	return s
+}
+
+// Plugin is an executable found in the directory of the external data sources, along with its handshake.
+type Plugin struct {
+	Path      string
+	Handshake Handshake
+}
+
+// Discover launches each executable within the directory to read its handshake, and stops it again. The
+// executables that fail to complete the handshake are returned with the errors instead.
+func Discover(s Settings) ([]*Plugin, []error) {
+	if s.Directory == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(s.Directory)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var errs []error
+	var plugins []*Plugin
+	names := make(map[string]string)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		path := filepath.Join(s.Directory, entry.Name())
+		p, hs, err := launch(path, s.HandshakeTimeout, nil, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+			continue
+		}
+		p.stop(time.Second)
+
+		if other, found := names[hs.Name]; found {
+			errs = append(errs, fmt.Errorf("%s: the name %s is already declared by %s", path, hs.Name, other))
+			continue
+		}
+		names[hs.Name] = path
+		plugins = append(plugins, &Plugin{Path: path, Handshake: *hs})
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Handshake.Name < plugins[j].Handshake.Name
+	})
+	return plugins, errs
+}
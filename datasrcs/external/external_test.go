@@ -0,0 +1,313 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package external
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// helperEnv selects the behavior of the test binary when it is launched as an external data source.
+const helperEnv = "AMASS_EXTERNAL_HELPER"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(helperEnv); mode != "" {
+		os.Exit(runHelper(mode))
+	}
+	os.Exit(m.Run())
+}
+
+// runHelper implements the external data sources launched by the tests.
+func runHelper(mode string) int {
+	switch mode {
+	case "silent":
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		return 0
+	case "malformed":
+		fmt.Println("not a handshake")
+		return 0
+	}
+
+	hs := Handshake{Name: "Helper", Type: "api", Concurrency: 2}
+	if mode == "shadow" {
+		hs.Type = "scrape"
+	}
+
+	err := Serve(context.Background(), os.Stdin, os.Stdout, hs, func(ctx context.Context, req *Request, send func(*Response)) error {
+		switch req.Domain {
+		case "crash.com":
+			os.Exit(2)
+		case "fail.com":
+			return errors.New("the domain is not supported")
+		case "garbage.com":
+			fmt.Println("{not a response")
+		}
+		send(&Response{Names: []string{"www." + req.Domain, "www.example.com"}})
+		send(&Response{Names: []string{"mail." + req.Domain}, Addresses: []string{"8.8.8.8", "10.0.0.1"}})
+		return nil
+	})
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// helperDir returns the directory holding the scripts that launch the test binary as external data sources.
+func helperDir(t *testing.T, modes ...string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the external data sources are launched through shell scripts")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, mode := range modes {
+		script := fmt.Sprintf("#!/bin/sh\n%s=%s exec %q\n", helperEnv, mode, exe)
+		if err := os.WriteFile(filepath.Join(dir, mode), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestServe(t *testing.T) {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+
+	var inflight, peak int32
+	release := make(chan struct{})
+	go func() {
+		_ = Serve(context.Background(), inr, outw, Handshake{Name: "Test", Type: "api", Concurrency: 2},
+			func(ctx context.Context, req *Request, send func(*Response)) error {
+				n := atomic.AddInt32(&inflight, 1)
+				defer atomic.AddInt32(&inflight, -1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+
+				<-release
+				if req.Domain == "fail.com" {
+					return errors.New("failed")
+				}
+				send(&Response{ID: 99, Names: []string{"www." + req.Domain}, Done: true})
+				return nil
+			})
+		outw.Close()
+	}()
+
+	scanner := bufio.NewScanner(outr)
+	// The handshake is the first line, declaring the protocol version
+	if !scanner.Scan() {
+		t.Fatal("the handshake was not written")
+	}
+	var hs Handshake
+	if err := json.Unmarshal(scanner.Bytes(), &hs); err != nil || hs.Protocol != ProtocolVersion || hs.Concurrency != 2 {
+		t.Fatalf("the handshake %s is not valid: %v", scanner.Text(), err)
+	}
+
+	enc := json.NewEncoder(inw)
+	for i, domain := range []string{"owasp.org", "fail.com", "example.com"} {
+		if err := enc.Encode(&Request{ID: uint64(i + 1), Kind: KindDomain, Domain: domain}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+
+	results := make(map[uint64][]*Response)
+	for scanner.Scan() {
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("the response %s is not valid: %v", scanner.Text(), err)
+		}
+		results[resp.ID] = append(results[resp.ID], &resp)
+		if len(results) == 3 && resp.Done {
+			inw.Close()
+		}
+	}
+
+	if peak > 2 {
+		t.Errorf("%d requests were handled at once, beyond the declared concurrency", peak)
+	}
+	for id, domain := range map[uint64]string{1: "owasp.org", 2: "fail.com", 3: "example.com"} {
+		resps := results[id]
+		if len(resps) == 0 || !resps[len(resps)-1].Done {
+			t.Errorf("the request for %s was not done", domain)
+			continue
+		}
+		// The findings are sent before the response that is done, and cannot mark the request as done
+		for _, resp := range resps[:len(resps)-1] {
+			if resp.Done {
+				t.Errorf("the request for %s was done early", domain)
+			}
+		}
+		if failed := resps[len(resps)-1].Error != ""; failed != (domain == "fail.com") {
+			t.Errorf("the request for %s reported the error %q", domain, resps[len(resps)-1].Error)
+		}
+	}
+}
+
+func TestHandshakeValidate(t *testing.T) {
+	for _, test := range []struct {
+		hs    Handshake
+		valid bool
+	}{
+		{Handshake{Protocol: ProtocolVersion, Name: "Test", Type: "api"}, true},
+		{Handshake{Protocol: ProtocolVersion + 1, Name: "Test", Type: "api"}, false},
+		{Handshake{Protocol: ProtocolVersion, Type: "api"}, false},
+		{Handshake{Protocol: ProtocolVersion, Name: "Test"}, false},
+		{Handshake{Protocol: ProtocolVersion, Name: "Test", Type: "api", Concurrency: -1}, false},
+	} {
+		hs := test.hs
+		if err := hs.validate(); (err == nil) != test.valid {
+			t.Errorf("the handshake %+v returned %v", test.hs, err)
+		} else if err == nil && hs.Concurrency != 1 {
+			t.Errorf("the handshake %+v defaults to a concurrency of %d", test.hs, hs.Concurrency)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := helperDir(t, "names", "silent", "malformed", "shadow")
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not executable"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, errs := Discover(Settings{Directory: dir, HandshakeTimeout: 2 * time.Second})
+	if len(plugins) != 1 {
+		t.Fatalf("%d external data sources were discovered, expected 1", len(plugins))
+	}
+	if hs := plugins[0].Handshake; hs.Name != "Helper" || hs.Type != "api" || hs.Concurrency != 2 {
+		t.Errorf("the handshake was read as %+v", hs)
+	}
+	// The executable declaring the name of another source, and those failing the handshake, are reported
+	if len(errs) != 3 {
+		t.Errorf("%d errors were returned, expected 3: %v", len(errs), errs)
+	}
+
+	if plugins, errs := Discover(Settings{}); plugins != nil || errs != nil {
+		t.Error("the external data sources were discovered without a directory")
+	}
+}
+
+func TestSettingsFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if s := SettingsFromConfig(cfg); s.Directory != "" || s.Restarts != DefaultRestarts || s.Timeout != DefaultTimeout {
+		t.Errorf("the default settings are %+v", s)
+	}
+
+	cfg.Filepath = "/etc/amass/config.yaml"
+	cfg.Options = map[string]interface{}{"external_sources": map[string]interface{}{
+		"directory": "sources",
+		"restarts":  0,
+		"timeout":   30,
+	}}
+	s := SettingsFromConfig(cfg)
+	if s.Directory != "/etc/amass/sources" || s.Restarts != 0 || s.Timeout != 30*time.Second {
+		t.Errorf("the settings were parsed as %+v", s)
+	}
+}
+
+func TestSource(t *testing.T) {
+	dir := helperDir(t, "names")
+	plugins, errs := Discover(Settings{Directory: dir, HandshakeTimeout: 5 * time.Second})
+	if len(plugins) != 1 {
+		t.Fatalf("the external data source was not discovered: %v", errs)
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.AddDomain("crash.com")
+	cfg.AddDomain("garbage.com")
+
+	settings := SettingsFromConfig(cfg)
+	settings.Restarts = 1
+	src := NewSource(plugins[0], settings, &systems.SimpleSystem{Cfg: cfg})
+	src.clock = clock.NewFake(time.Now())
+	if err := src.Start(); err != nil {
+		t.Fatalf("failed to start the source: %v", err)
+	}
+	defer func() { _ = src.Stop() }()
+
+	if src.Description() != "api" {
+		t.Errorf("the source is described as %s", src.Description())
+	}
+
+	collect := func(domain string) []string {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			src.query(context.Background(), &Request{Kind: KindDomain, Domain: domain})
+		}()
+
+		var found []string
+		for {
+			var out interface{}
+			select {
+			case out = <-src.Output():
+			case <-done:
+				if len(src.Output()) == 0 {
+					return found
+				}
+				out = <-src.Output()
+			}
+
+			switch v := out.(type) {
+			case *requests.DNSRequest:
+				found = append(found, v.Name)
+			case *requests.AddrRequest:
+				found = append(found, v.Address)
+			}
+		}
+	}
+
+	// Only the names within scope and the public addresses are delivered
+	if found := strings.Join(collect("owasp.org"), " "); found != "www.owasp.org mail.owasp.org 8.8.8.8" {
+		t.Errorf("the source delivered %q", found)
+	}
+
+	// The crashed source is restarted for the following request
+	if found := collect("crash.com"); len(found) != 0 {
+		t.Errorf("the crashed source delivered %v", found)
+	}
+	if found := collect("garbage.com"); len(found) != 3 {
+		t.Errorf("the restarted source delivered %v", found)
+	}
+	if c := src.ParseErrors(); c.Responses != 1 {
+		t.Errorf("%d lines that are not responses were counted, expected 1", c.Responses)
+	}
+
+	// The source is no longer restarted once the restarts have been used up
+	_ = collect("crash.com")
+	if found := collect("owasp.org"); len(found) != 0 {
+		t.Errorf("the source was restarted beyond the limit and delivered %v", found)
+	}
+
+	st := src.Stats()
+	if st.Requests != 4 || st.Failures != 2 || st.Restarts != 1 || st.Names != 4 || st.Addresses != 2 {
+		t.Errorf("the stats of the source are %+v", st)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package external
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// errExited is reported to the requests in flight when the external data source exits.
+var errExited = errors.New("the process exited")
+
+// call is a request in flight, receiving the responses of the process until it is done or abandoned.
+type call struct {
+	responses chan *Response
+	abandoned chan struct{}
+	once      sync.Once
+}
+
+func (c *call) abandon() {
+	c.once.Do(func() { close(c.abandoned) })
+}
+
+// process is a running external data source.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	wlock sync.Mutex
+	enc   *json.Encoder
+	sync.Mutex
+	next    uint64
+	pending map[uint64]*call
+	done    chan struct{}
+	err     error
+}
+
+// launch starts the executable and reads its handshake, waiting no longer than the timeout. The lines of the
+// process that are not responses are passed to the badLine function, which may be nil.
+func launch(path string, timeout time.Duration, logger *log.Logger, badLine func([]byte, error)) (*process, *Handshake, error) {
+	cmd := exec.Command(path)
+	cmd.Dir = filepath.Dir(path)
+	if logger != nil {
+		cmd.Stderr = &logWriter{prefix: filepath.Base(path), logger: logger}
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	p := &process{
+		cmd:     cmd,
+		stdin:   stdin,
+		enc:     json.NewEncoder(stdin),
+		pending: make(map[uint64]*call),
+		done:    make(chan struct{}),
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	hsch := make(chan error, 1)
+	var hs Handshake
+	go func() {
+		if !scanner.Scan() {
+			hsch <- errors.New("the process did not write the handshake")
+			return
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &hs); err != nil {
+			hsch <- fmt.Errorf("the handshake is malformed: %v", err)
+			return
+		}
+		hsch <- hs.validate()
+	}()
+
+	select {
+	case err = <-hsch:
+	case <-time.After(timeout):
+		err = errors.New("the process did not write the handshake in time")
+	}
+	if err != nil {
+		p.kill()
+		_ = cmd.Wait()
+		return nil, nil, err
+	}
+
+	go p.read(scanner, badLine)
+	return p, &hs, nil
+}
+
+// read delivers the responses of the process to the requests in flight until the process exits.
+func (p *process) read(scanner *bufio.Scanner, badLine func([]byte, error)) {
+	for scanner.Scan() {
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			if badLine != nil {
+				badLine(append([]byte(nil), scanner.Bytes()...), err)
+			}
+			continue
+		}
+
+		p.Lock()
+		c, found := p.pending[resp.ID]
+		if found && resp.Done {
+			delete(p.pending, resp.ID)
+		}
+		p.Unlock()
+		if !found {
+			continue
+		}
+
+		select {
+		case c.responses <- &resp:
+		case <-c.abandoned:
+		}
+		if resp.Done {
+			close(c.responses)
+		}
+	}
+
+	err := p.cmd.Wait()
+	if err == nil {
+		err = errExited
+	}
+
+	p.Lock()
+	p.err = err
+	pending := p.pending
+	p.pending = make(map[uint64]*call)
+	close(p.done)
+	p.Unlock()
+
+	for _, c := range pending {
+		close(c.responses)
+	}
+}
+
+// send writes the request to the process and returns the call receiving its responses.
+func (p *process) send(req *Request) (*call, error) {
+	c := &call{
+		responses: make(chan *Response, 1),
+		abandoned: make(chan struct{}),
+	}
+
+	p.Lock()
+	if p.exited() {
+		p.Unlock()
+		return nil, errExited
+	}
+	p.next++
+	req.ID = p.next
+	p.pending[req.ID] = c
+	p.Unlock()
+
+	p.wlock.Lock()
+	err := p.enc.Encode(req)
+	p.wlock.Unlock()
+	if err != nil {
+		p.forget(req.ID, c)
+		return nil, err
+	}
+	return c, nil
+}
+
+// forget stops delivering the responses of the request to the call.
+func (p *process) forget(id uint64, c *call) {
+	c.abandon()
+
+	p.Lock()
+	defer p.Unlock()
+
+	if cur, found := p.pending[id]; found && cur == c {
+		delete(p.pending, id)
+	}
+}
+
+func (p *process) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+	}
+	return false
+}
+
+// stop closes the standard input of the process, which asks it to exit, and kills it after the grace period.
+func (p *process) stop(grace time.Duration) {
+	_ = p.stdin.Close()
+
+	select {
+	case <-p.done:
+	case <-time.After(grace):
+		p.kill()
+		<-p.done
+	}
+}
+
+func (p *process) kill() {
+	if p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+	}
+}
+
+// logWriter writes each line of the standard error of the process to the log.
+type logWriter struct {
+	sync.Mutex
+	prefix string
+	logger *log.Logger
+	buf    []byte
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSpace(w.buf[:i]); len(line) > 0 {
+			w.logger.Printf("%s: %s", w.prefix, line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLineLength {
+		w.buf = w.buf[:0]
+	}
+	return len(b), nil
+}
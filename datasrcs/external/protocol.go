@@ -0,0 +1,142 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package external runs the data sources implemented as executables outside of the repository. Each
+// executable found in the configured directory is launched and speaks JSON Lines over its standard input
+// and output. The executable first writes the handshake declaring its name and type, and then receives the
+// requests, answering each with response records sharing the identifier of the request, the last of which
+// is marked as done. The standard error of the executable is written to the log.
+package external
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ProtocolVersion is the version of the protocol spoken with the external data sources.
+const ProtocolVersion = 1
+
+// maxLineLength is the longest line read from or by an external data source.
+const maxLineLength = 1 << 20
+
+// The kinds of requests sent to the external data sources.
+const (
+	KindDomain  = "domain"
+	KindAddress = "address"
+)
+
+// Handshake is the first line written by an external data source, declaring how the source is run.
+type Handshake struct {
+	Protocol int    `json:"protocol"`
+	Name     string `json:"name"`
+	// Type is the description of the data source, such as "api" or "scrape"
+	Type string `json:"type"`
+	// Concurrency is the number of requests sent to the source before the earlier requests are done
+	Concurrency int `json:"concurrency,omitempty"`
+	// QPS is the number of requests sent to the source each second, without a limit when zero
+	QPS int `json:"qps,omitempty"`
+}
+
+// Request is a line sent to an external data source, asking for the names within a domain or the names
+// related to an address.
+type Request struct {
+	ID      uint64 `json:"id"`
+	Kind    string `json:"kind"`
+	Domain  string `json:"domain,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// Response is a line written by an external data source while answering the request with the same identifier.
+// The request is answered once the source writes the response that is done, which may also hold findings.
+type Response struct {
+	ID        uint64   `json:"id"`
+	Names     []string `json:"names,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Done      bool     `json:"done,omitempty"`
+}
+
+// validate checks the handshake and fills in the defaults.
+func (h *Handshake) validate() error {
+	if h.Protocol != ProtocolVersion {
+		return fmt.Errorf("the protocol version %d is not supported", h.Protocol)
+	}
+	if h.Name == "" {
+		return errors.New("the handshake does not declare the name")
+	}
+	if h.Type == "" {
+		return errors.New("the handshake does not declare the type")
+	}
+	if h.Concurrency < 0 || h.QPS < 0 {
+		return errors.New("the handshake declares a negative concurrency or QPS")
+	}
+	if h.Concurrency == 0 {
+		h.Concurrency = 1
+	}
+	return nil
+}
+
+// Handler answers a request of an external data source, sending the findings through the send function
+// as they are found. The returned error is reported with the response that is done.
+type Handler func(ctx context.Context, req *Request, send func(*Response)) error
+
+// Serve implements the external data source side of the protocol, so the data sources written in Go only
+// provide the handshake and the Handler. The requests are read from r until it is closed, and up to the
+// declared concurrency of them are handled at a time.
+func Serve(ctx context.Context, r io.Reader, w io.Writer, hs Handshake, h Handler) error {
+	hs.Protocol = ProtocolVersion
+	if err := hs.validate(); err != nil {
+		return err
+	}
+
+	var wlock sync.Mutex
+	enc := json.NewEncoder(w)
+	write := func(v interface{}) {
+		wlock.Lock()
+		defer wlock.Unlock()
+
+		_ = enc.Encode(v)
+	}
+	write(&hs)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sem := make(chan struct{}, hs.Concurrency)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(req *Request) {
+			defer func() { <-sem }()
+			defer wg.Done()
+
+			done := &Response{ID: req.ID, Done: true}
+			if err := h(ctx, req, func(resp *Response) {
+				resp.ID = req.ID
+				resp.Done = false
+				write(resp)
+			}); err != nil {
+				done.Error = err.Error()
+			}
+			write(done)
+		}(&req)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,390 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/datasrcs/quota"
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// stopGrace is how long a source has to exit once its standard input is closed.
+const stopGrace = 5 * time.Second
+
+// errGaveUp is returned once a source has crashed more times than it is restarted.
+var errGaveUp = errors.New("the source crashed too many times and is no longer restarted")
+
+// Stats reports how an external data source has been used during the run.
+type Stats struct {
+	Source    string
+	Requests  int
+	Failures  int
+	Names     int
+	Addresses int
+	Restarts  int
+}
+
+// Source is the data source Service running an external data source process.
+type Source struct {
+	service.BaseService
+	sys      systems.System
+	plugin   *Plugin
+	settings Settings
+	clock    clock.Clock
+	limiter  *rate.Limiter
+	quota    *quota.Tracker
+	parseErr *salvage.Counter
+	slots    chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	sync.Mutex
+	proc    *process
+	crashes int
+	stats   Stats
+}
+
+// NewSource returns the data source running the external executable, initialized but not yet started.
+func NewSource(p *Plugin, s Settings, sys systems.System) *Source {
+	src := &Source{
+		sys:      sys,
+		plugin:   p,
+		settings: s,
+		clock:    clock.System,
+		slots:    make(chan struct{}, p.Handshake.Concurrency),
+	}
+
+	src.ctx, src.cancel = context.WithCancel(context.Background())
+	src.BaseService = *service.NewBaseService(src, p.Handshake.Name)
+	src.parseErr = salvage.NewCounter(src.String())
+	src.stats.Source = src.String()
+	go src.requests()
+	return src
+}
+
+// Description implements the Service interface.
+func (s *Source) Description() string {
+	return s.plugin.Handshake.Type
+}
+
+// OnStart implements the Service interface.
+func (s *Source) OnStart() error {
+	if _, err := s.process(); err != nil {
+		s.sys.Config().Log.Printf("%s: %v", s.String(), err)
+		return &systems.SourceError{Name: s.String(), Temporary: true, Err: err}
+	}
+
+	s.limiter = rate.NewLimiter(s.plugin.Handshake.QPS, 1, s.clock)
+	return nil
+}
+
+// OnStop implements the Service interface.
+func (s *Source) OnStop() error {
+	s.cancel()
+
+	s.Lock()
+	p := s.proc
+	s.proc = nil
+	s.Unlock()
+	if p != nil {
+		p.stop(stopGrace)
+	}
+
+	if cfg := s.sys.Config(); cfg.Verbose {
+		st := s.Stats()
+		cfg.Log.Printf("%s: served %d requests with %d failed, finding %d names and %d addresses, and was restarted %d times",
+			s.String(), st.Requests, st.Failures, st.Names, st.Addresses, st.Restarts)
+	}
+	return s.quota.Stop()
+}
+
+// SetQuotaTracker assigns the Tracker used to account for the API usage of the source.
+func (s *Source) SetQuotaTracker(t *quota.Tracker) {
+	s.quota = t
+}
+
+// Stats returns how the source has been used during the run.
+func (s *Source) Stats() Stats {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.stats
+}
+
+// ParseErrors implements the salvage.Reporter interface.
+func (s *Source) ParseErrors() salvage.Count {
+	return s.parseErr.Count()
+}
+
+// SupportsContext implements the requests.ContextAware interface.
+func (s *Source) SupportsContext() bool {
+	return true
+}
+
+// HandlesReq implements the Service interface.
+func (s *Source) HandlesReq(req interface{}) bool {
+	_, req = requests.UnwrapContext(req)
+
+	switch t := req.(type) {
+	case *requests.DNSRequest:
+		return t != nil && t.Domain != ""
+	case *requests.AddrRequest:
+		return t != nil && t.Address != ""
+	}
+	return false
+}
+
+// requests hands the requests to the source, with no more of them in flight than the handshake declared.
+func (s *Source) requests() {
+	for {
+		select {
+		case <-s.Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case in := <-s.Input():
+			select {
+			case <-s.Done():
+				return
+			case <-s.ctx.Done():
+				return
+			case s.slots <- struct{}{}:
+			}
+
+			go func(in interface{}) {
+				defer func() { <-s.slots }()
+				s.dispatch(in)
+			}(in)
+		}
+	}
+}
+
+func (s *Source) dispatch(in interface{}) {
+	reqCtx, in := requests.UnwrapContext(in)
+	ctx, cancel := s.requestContext(reqCtx)
+	defer cancel()
+
+	switch req := in.(type) {
+	case *requests.DNSRequest:
+		if req != nil && req.Domain != "" {
+			s.sys.Config().Log.Printf("Querying %s for %s subdomains", s.String(), req.Domain)
+			s.query(ctx, &Request{Kind: KindDomain, Domain: req.Domain})
+		}
+	case *requests.AddrRequest:
+		if req != nil && req.Address != "" {
+			s.query(ctx, &Request{Kind: KindAddress, Address: req.Address})
+		}
+	}
+}
+
+// requestContext returns a context that is cancelled when either the source is stopped, the work the
+// request belongs to has been cancelled, or the source has taken too long to answer.
+func (s *Source) requestContext(reqCtx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.settings.Timeout)
+	// The findings must be delivered to the enumeration the request belongs to
+	if job := requests.JobFromContext(reqCtx); job != nil {
+		ctx = requests.WithJob(ctx, job)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-reqCtx.Done():
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
+// query sends the request to the process, within the rate limit and API quota of the source, and
+// submits the findings of each response as they arrive.
+func (s *Source) query(ctx context.Context, req *Request) {
+	if ctx.Err() != nil || !s.quota.Allow(s.String()) {
+		return
+	}
+
+	p, err := s.process()
+	if err != nil {
+		return
+	}
+
+	if s.limiter.TakeContext(ctx) != nil {
+		return
+	}
+	c, err := p.send(req)
+	if err != nil {
+		s.count(func(st *Stats) { st.Failures++ })
+		return
+	}
+	defer p.forget(req.ID, c)
+	s.count(func(st *Stats) { st.Requests++ })
+
+	if err := s.quota.Record(s.String(), 1); err != nil {
+		s.sys.Config().Log.Printf("%s: failed to record the API usage: %v", s.String(), err)
+	}
+
+	q := req.Domain + req.Address
+	for {
+		select {
+		case <-ctx.Done():
+			s.count(func(st *Stats) { st.Failures++ })
+			return
+		case resp, ok := <-c.responses:
+			if !ok {
+				// The channel is closed without the response that is done when the process exits
+				s.count(func(st *Stats) { st.Failures++ })
+				s.sys.Config().Log.Printf("%s: query for %s failed: %v", s.String(), q, errExited)
+				return
+			}
+
+			s.submit(ctx, resp)
+			if resp.Error != "" {
+				s.count(func(st *Stats) { st.Failures++ })
+				s.sys.Config().Log.Printf("%s: query for %s failed: %s", s.String(), q, resp.Error)
+			}
+			if resp.Done {
+				return
+			}
+		}
+	}
+}
+
+// process returns the running process of the source, launching it again after a crash until the
+// restarts have been used up.
+func (s *Source) process() (*process, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.proc != nil && !s.proc.exited() {
+		return s.proc, nil
+	}
+	if s.proc != nil {
+		s.crashes++
+		s.sys.Config().Log.Printf("%s: the process exited: %v", s.String(), s.proc.err)
+		if s.crashes > s.settings.Restarts {
+			s.proc = nil
+			return nil, errGaveUp
+		}
+		// The restarts back off, so a source crashing on launch does not spin
+		s.clock.Sleep(time.Duration(s.crashes) * time.Second)
+		s.stats.Restarts++
+	} else if s.crashes > s.settings.Restarts {
+		return nil, errGaveUp
+	}
+
+	p, hs, err := launch(s.plugin.Path, s.settings.HandshakeTimeout, s.sys.Config().Log, s.badLine)
+	if err != nil {
+		return nil, err
+	}
+	if hs.Name != s.String() {
+		p.stop(stopGrace)
+		return nil, fmt.Errorf("the handshake declares the name %s after being launched as %s", hs.Name, s.String())
+	}
+
+	s.proc = p
+	return p, nil
+}
+
+// badLine counts the lines written by the process that are not responses.
+func (s *Source) badLine(line []byte, err error) {
+	s.parseErr.Record(s.ctx, s.sys.Config(), s.plugin.Path, line, err)
+}
+
+func (s *Source) count(fn func(*Stats)) {
+	s.Lock()
+	defer s.Unlock()
+
+	fn(&s.stats)
+}
+
+// submit delivers the names and addresses of the response that are within scope.
+func (s *Source) submit(ctx context.Context, resp *Response) {
+	cfg := s.jobConfig(ctx)
+	job := requests.JobFromContext(ctx)
+
+	var names, addrs int
+	for _, n := range resp.Names {
+		name := cleanName(n)
+		if name == "" || cfg.WhichDomain(name) == "" {
+			continue
+		}
+
+		if job != nil {
+			job.Findings.Add(&requests.Finding{Name: name, Sources: []string{s.String()}})
+		}
+		s.sendOutput(ctx, &requests.DNSRequest{
+			Name:       name,
+			Domain:     cfg.WhichDomain(name),
+			Parent:     s.String(),
+			Derivation: requests.DerivedFromSource,
+		})
+		names++
+	}
+	for _, a := range resp.Addresses {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		if reserved, _ := amassnet.IsReservedAddress(ip.String()); !reserved {
+			s.sendOutput(ctx, &requests.AddrRequest{Address: ip.String()})
+			addrs++
+		}
+	}
+
+	s.count(func(st *Stats) {
+		st.Names += names
+		st.Addresses += addrs
+	})
+}
+
+func cleanName(name string) string {
+	n, err := amassdns.NormalizeName(name)
+	if err != nil {
+		return ""
+	}
+	return amassdns.RemoveAsteriskLabel(n)
+}
+
+// jobConfig returns the configuration of the enumeration the request belongs to.
+// Requests made outside of an enumeration job fall back to the system configuration.
+func (s *Source) jobConfig(ctx context.Context) *config.Config {
+	if job := requests.JobFromContext(ctx); job != nil && job.Config != nil {
+		return job.Config
+	}
+	return s.sys.Config()
+}
+
+// output returns the channel that receives the findings of the enumeration the request belongs to.
+func (s *Source) output(ctx context.Context) chan interface{} {
+	if ch := requests.JobFromContext(ctx).Output(s.String()); ch != nil {
+		return ch
+	}
+	return s.Output()
+}
+
+// sendOutput delivers the finding unless the request or the source has been cancelled.
+func (s *Source) sendOutput(ctx context.Context, req interface{}) {
+	select {
+	case <-ctx.Done():
+	case <-s.Done():
+	case s.output(ctx) <- req:
+	}
+}
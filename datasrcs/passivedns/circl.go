@@ -0,0 +1,87 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package passivedns
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/config/config"
+)
+
+func init() {
+	Register(Adapter{
+		Name:                "CIRCL",
+		Host:                "www.circl.lu",
+		QPS:                 2,
+		RequiresCredentials: true,
+		New: func(creds *config.Credentials) (Provider, error) {
+			if creds == nil || creds.Username == "" || creds.Password == "" {
+				return nil, ErrNoCredentials
+			}
+			return &circl{
+				base: "https://www.circl.lu/pdns/query/",
+				auth: &http.BasicAuth{Username: creds.Username, Password: creds.Password},
+			}, nil
+		},
+	})
+}
+
+// circl queries the CIRCL Passive DNS API, which returns all the records of a query in a single response.
+type circl struct {
+	base string
+	auth *http.BasicAuth
+}
+
+type circlRecord struct {
+	Name      string `json:"rrname"`
+	Type      string `json:"rrtype"`
+	Data      string `json:"rdata"`
+	FirstSeen int64  `json:"time_first"`
+	LastSeen  int64  `json:"time_last"`
+}
+
+func (c *circl) QueryDomain(ctx context.Context, domain, cursor string) (*Page, error) {
+	return c.query(ctx, domain)
+}
+
+func (c *circl) QueryIP(ctx context.Context, addr, cursor string) (*Page, error) {
+	return c.query(ctx, addr)
+}
+
+func (c *circl) query(ctx context.Context, q string) (*Page, error) {
+	body, err := request(ctx, &http.Request{
+		URL:  c.base + url.PathEscape(q),
+		Auth: c.auth,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := new(Page)
+	err = lines(body, func(line string) error {
+		var r circlRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			return err
+		}
+		if r.Name == "" {
+			return nil
+		}
+
+		page.Records = append(page.Records, &Record{
+			Name:      r.Name,
+			Type:      strings.ToUpper(r.Type),
+			Data:      r.Data,
+			FirstSeen: unixTime(r.FirstSeen),
+			LastSeen:  unixTime(r.LastSeen),
+			Raw:       line,
+		})
+		return nil
+	})
+	return salvaged(page, body, err)
+}
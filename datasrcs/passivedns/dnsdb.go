@@ -0,0 +1,133 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package passivedns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// dnsdbPageSize is the number of records requested in each page.
+	dnsdbPageSize = 1000
+	// dnsdbMaxAge limits the records to those observed during the last year, in seconds.
+	dnsdbMaxAge = 365 * 24 * 60 * 60
+)
+
+func init() {
+	Register(Adapter{
+		Name:                "DNSDB",
+		Host:                "api.dnsdb.info",
+		QPS:                 1,
+		RequiresCredentials: true,
+		New: func(creds *config.Credentials) (Provider, error) {
+			if creds == nil || creds.Apikey == "" {
+				return nil, ErrNoCredentials
+			}
+			return &dnsdb{base: "https://api.dnsdb.info/dnsdb/v2", key: creds.Apikey}, nil
+		},
+	})
+}
+
+// dnsdb queries the DNSDB API version 2, which streams the records and reports
+// whether the results were limited, so the following page is requested with an offset.
+type dnsdb struct {
+	base string
+	key  string
+}
+
+// dnsdbLine is an entry of the Streaming API Framing, which either holds a record or a condition.
+type dnsdbLine struct {
+	Cond string `json:"cond"`
+	Obj  *struct {
+		Name      string `json:"rrname"`
+		Type      string `json:"rrtype"`
+		Data      rdata  `json:"rdata"`
+		FirstSeen int64  `json:"time_first"`
+		LastSeen  int64  `json:"time_last"`
+	} `json:"obj"`
+}
+
+// rdata holds the data of the records, which the rrset lookups provide as a list
+// and the rdata lookups provide as a single value.
+type rdata []string
+
+func (r *rdata) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*r = list
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*r = []string{s}
+	return nil
+}
+
+func (d *dnsdb) QueryDomain(ctx context.Context, domain, cursor string) (*Page, error) {
+	return d.query(ctx, "/lookup/rrset/name/*."+url.PathEscape(domain)+"/ANY", cursor)
+}
+
+func (d *dnsdb) QueryIP(ctx context.Context, addr, cursor string) (*Page, error) {
+	return d.query(ctx, "/lookup/rdata/ip/"+url.PathEscape(addr), cursor)
+}
+
+func (d *dnsdb) query(ctx context.Context, path, cursor string) (*Page, error) {
+	offset, _ := strconv.Atoi(cursor)
+
+	body, err := request(ctx, &http.Request{
+		URL: fmt.Sprintf("%s%s?limit=%d&offset=%d&time_last_after=-%d",
+			d.base, path, dnsdbPageSize, offset, dnsdbMaxAge),
+		Header: http.Header{
+			"X-API-Key": d.key,
+			"Accept":    "application/x-ndjson",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := new(Page)
+	var limited bool
+	err = lines(body, func(line string) error {
+		var l dnsdbLine
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			return err
+		}
+		if l.Cond == "limited" {
+			limited = true
+		}
+		if l.Obj == nil || l.Obj.Name == "" {
+			return nil
+		}
+
+		for _, data := range l.Obj.Data {
+			page.Records = append(page.Records, &Record{
+				Name:      l.Obj.Name,
+				Type:      strings.ToUpper(l.Obj.Type),
+				Data:      data,
+				FirstSeen: unixTime(l.Obj.FirstSeen),
+				LastSeen:  unixTime(l.Obj.LastSeen),
+				Raw:       line,
+			})
+		}
+		return nil
+	})
+
+	if limited {
+		page.Next = strconv.Itoa(offset + dnsdbPageSize)
+	}
+	return salvaged(page, body, err)
+}
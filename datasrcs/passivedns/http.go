@@ -0,0 +1,51 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package passivedns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
+	"github.com/owasp-amass/amass/v4/net/http"
+)
+
+// request returns the body of the API response, or an error when the provider rejected the request.
+func request(ctx context.Context, r *http.Request) (string, error) {
+	r.StaticUserAgent = true
+	resp, err := http.RequestWebPage(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return "", fmt.Errorf("the provider returned the status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// lines calls fn with each line of the newline delimited JSON, skipping the empty lines and those that
+// fail to parse. The returned *salvage.Error reports the lines that were skipped for their errors.
+func lines(body string, fn func(line string) error) error {
+	return salvage.Lines(strings.NewReader(body), func(line []byte) error {
+		return fn(string(line))
+	})
+}
+
+// salvaged returns the page of the records parsed from the body, keeping the body when the parse failed.
+func salvaged(page *Page, body string, err error) (*Page, error) {
+	if err != nil {
+		page.Payload = body
+	}
+	return page, err
+}
+
+func unixTime(secs int64) time.Time {
+	if secs <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0).UTC()
+}
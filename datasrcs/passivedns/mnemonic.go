@@ -0,0 +1,125 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package passivedns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/config/config"
+)
+
+// mnemonicPageSize is the number of records requested in each page.
+const mnemonicPageSize = 1000
+
+func init() {
+	Register(Adapter{
+		Name: "Mnemonic",
+		Host: "api.mnemonic.no",
+		QPS:  1,
+		New: func(creds *config.Credentials) (Provider, error) {
+			m := &mnemonic{base: "https://api.mnemonic.no/pdns/v3/"}
+			// The API can be queried anonymously, with a lower daily limit
+			if creds != nil {
+				m.key = creds.Apikey
+			}
+			return m, nil
+		},
+	})
+}
+
+// mnemonic queries the Mnemonic Passive DNS API, which pages the records using offsets.
+type mnemonic struct {
+	base string
+	key  string
+}
+
+type mnemonicResponse struct {
+	ResponseCode int               `json:"responseCode"`
+	Count        int               `json:"count"`
+	Data         []json.RawMessage `json:"data"`
+}
+
+type mnemonicRecord struct {
+	Query     string `json:"query"`
+	Answer    string `json:"answer"`
+	Type      string `json:"rrtype"`
+	FirstSeen int64  `json:"firstSeenTimestamp"`
+	LastSeen  int64  `json:"lastSeenTimestamp"`
+}
+
+func (m *mnemonic) QueryDomain(ctx context.Context, domain, cursor string) (*Page, error) {
+	return m.query(ctx, domain, cursor)
+}
+
+func (m *mnemonic) QueryIP(ctx context.Context, addr, cursor string) (*Page, error) {
+	return m.query(ctx, addr, cursor)
+}
+
+func (m *mnemonic) query(ctx context.Context, q, cursor string) (*Page, error) {
+	offset, _ := strconv.Atoi(cursor)
+
+	headers := http.Header{"Accept": "application/json"}
+	if m.key != "" {
+		headers["Argus-API-Key"] = m.key
+	}
+
+	body, err := request(ctx, &http.Request{
+		URL:    fmt.Sprintf("%s%s?limit=%d&offset=%d", m.base, url.PathEscape(q), mnemonicPageSize, offset),
+		Header: headers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp mnemonicResponse
+	perr := salvage.Unmarshal([]byte(body), &resp)
+	var serr *salvage.Error
+	if perr != nil && !errors.As(perr, &serr) {
+		return nil, perr
+	}
+	// A truncated response can lose the code that follows the data
+	if code := resp.ResponseCode; code != 200 && (code != 0 || serr == nil) {
+		return nil, fmt.Errorf("the API returned the response code %d", resp.ResponseCode)
+	}
+
+	page := new(Page)
+	for _, raw := range resp.Data {
+		var r mnemonicRecord
+		if err := json.Unmarshal(raw, &r); err != nil || r.Query == "" {
+			continue
+		}
+
+		// The timestamps are provided in milliseconds
+		page.Records = append(page.Records, &Record{
+			Name:      r.Query,
+			Type:      strings.ToUpper(r.Type),
+			Data:      r.Answer,
+			FirstSeen: unixMilli(r.FirstSeen),
+			LastSeen:  unixMilli(r.LastSeen),
+			Raw:       string(raw),
+		})
+	}
+
+	if next := offset + len(resp.Data); len(resp.Data) > 0 && next < resp.Count {
+		page.Next = strconv.Itoa(next)
+	}
+	return salvaged(page, body, perr)
+}
+
+func unixMilli(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
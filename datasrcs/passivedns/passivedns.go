@@ -0,0 +1,136 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package passivedns queries the passive DNS providers for the historical resolutions of the names
+// under the domains, and of the addresses, being enumerated. The providers share the same conceptual
+// query, so each one is an adapter implementing the Provider interface, while the pagination, rate
+// limiting and merging of the results are handled once by the Source service.
+package passivedns
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultMaxPages is the number of pages requested for each query when none has been configured.
+const DefaultMaxPages = 10
+
+// ErrNoCredentials is returned by the adapters requiring credentials when none were configured.
+var ErrNoCredentials = errors.New("the credentials were not provided")
+
+// Record is a resolution observed by a passive DNS sensor, between the first and last seen dates.
+type Record struct {
+	Name      string
+	Type      string
+	Data      string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Raw is the response fragment holding the record, which is kept as its evidence
+	Raw string
+}
+
+// Page is a page of the records answering a query.
+type Page struct {
+	Records []*Record
+	// Next is the cursor of the following page, or empty when the page is the last one
+	Next string
+	// Payload holds the response when it was only parsed in part, for troubleshooting
+	Payload string
+}
+
+// Provider is the adapter of a passive DNS API. The cursor is empty for the first page of a query,
+// and is the Next cursor of the previous page otherwise. A response that was only parsed in part
+// returns the page of the salvaged records along with a *salvage.Error.
+type Provider interface {
+	// QueryDomain returns the records of the names under the domain
+	QueryDomain(ctx context.Context, domain, cursor string) (*Page, error)
+	// QueryIP returns the records of the names that resolved to the address
+	QueryIP(ctx context.Context, addr, cursor string) (*Page, error)
+}
+
+// Adapter describes a passive DNS provider to the framework.
+type Adapter struct {
+	// Name is the name of the data source, which selects its credentials and API quota
+	Name string
+	// Host is the API endpoint contacted by the provider
+	Host string
+	// QPS is the number of requests each second allowed by the API
+	QPS int
+	// RequiresCredentials is true when the provider cannot be queried without credentials
+	RequiresCredentials bool
+	// New returns the provider using the credentials, which are nil when none were configured
+	New func(creds *config.Credentials) (Provider, error)
+}
+
+var (
+	adaptersLock sync.Mutex
+	adapters     = make(map[string]Adapter)
+)
+
+// Register makes the passive DNS provider available as a data source, replacing any adapter of the same name.
+func Register(a Adapter) {
+	adaptersLock.Lock()
+	defer adaptersLock.Unlock()
+
+	adapters[strings.ToLower(a.Name)] = a
+}
+
+// Adapters returns the registered passive DNS providers, sorted by name.
+func Adapters() []Adapter {
+	adaptersLock.Lock()
+	defer adaptersLock.Unlock()
+
+	list := make([]Adapter, 0, len(adapters))
+	for _, a := range adapters {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Credentials returns the credentials configured for the data source, or nil when there are none.
+func Credentials(cfg *config.Config, name string) *config.Credentials {
+	if cfg == nil || cfg.DataSrcConfigs == nil {
+		return nil
+	}
+
+	for _, ds := range cfg.DataSrcConfigs.Datasources {
+		if ds == nil || !strings.EqualFold(ds.Name, name) {
+			continue
+		}
+		// The credential sets are chosen in the same order across runs
+		var accounts []string
+		for account := range ds.Creds {
+			accounts = append(accounts, account)
+		}
+		sort.Strings(accounts)
+
+		for _, account := range accounts {
+			if c := ds.Creds[account]; c != nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// MaxPagesFromConfig returns the number of pages requested for each query, set by the max_pages
+// option of the passive_dns section.
+func MaxPagesFromConfig(cfg *config.Config) int {
+	if cfg != nil {
+		if section, ok := cfg.Options["passive_dns"].(map[string]interface{}); ok {
+			if n := options.Int(section["max_pages"]); n > 0 {
+				return n
+			}
+		}
+	}
+	return DefaultMaxPages
+}
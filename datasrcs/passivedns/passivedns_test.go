@@ -0,0 +1,441 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package passivedns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/datasrcs/quota"
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
+	amasshttp "github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// pager is a Provider returning the configured number of pages, or the same cursor forever when repeat is set.
+type pager struct {
+	pages   int
+	repeat  bool
+	cursors []string
+}
+
+func (p *pager) QueryDomain(ctx context.Context, domain, cursor string) (*Page, error) {
+	p.cursors = append(p.cursors, cursor)
+
+	n, _ := strconv.Atoi(cursor)
+	page := &Page{Records: []*Record{{Name: fmt.Sprintf("host%d.%s", n, domain), Type: "A", Data: "192.0.2.1"}}}
+	if p.repeat {
+		page.Next = "again"
+	} else if n+1 < p.pages {
+		page.Next = strconv.Itoa(n + 1)
+	}
+	return page, nil
+}
+
+func (p *pager) QueryIP(ctx context.Context, addr, cursor string) (*Page, error) {
+	return p.QueryDomain(ctx, "example.com", cursor)
+}
+
+func newTestSource(p Provider, maxPages int, qps int) (*Source, *clock.Fake) {
+	cfg := config.NewConfig()
+	cfg.AddDomain("example.com")
+
+	fake := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := &Source{
+		sys:      &systems.SimpleSystem{Cfg: cfg},
+		adapter:  Adapter{Name: "Pager"},
+		provider: p,
+		clock:    fake,
+		limiter:  rate.NewLimiter(qps, 1, fake),
+		maxPages: maxPages,
+		ctx:      context.Background(),
+	}
+	s.BaseService = *service.NewBaseService(s, "Pager")
+	s.parseErr = salvage.NewCounter(s.String())
+	return s, fake
+}
+
+func TestQueryPagination(t *testing.T) {
+	p := &pager{pages: 5}
+	s, fake := newTestSource(p, 3, 2)
+
+	if records := s.query(context.Background(), "example.com", p.QueryDomain); len(records) != 3 {
+		t.Errorf("the query returned %d records, expected the 3 pages allowed", len(records))
+	}
+	if want := "[ 1 2]"; fmt.Sprint(p.cursors) != want {
+		t.Errorf("the pages were requested with the cursors %v, expected %s", p.cursors, want)
+	}
+	// The first request uses the initial token, and the two that follow wait half a second each
+	if slept, n := fake.Slept(); slept != time.Second || n != 2 {
+		t.Errorf("the source waited %s over %d requests, expected 1s over 2", slept, n)
+	}
+
+	p = &pager{pages: 2}
+	s, _ = newTestSource(p, 10, 2)
+	if records := s.query(context.Background(), "example.com", p.QueryDomain); len(records) != 2 {
+		t.Errorf("the query returned %d records beyond the last page", len(records))
+	}
+
+	p = &pager{repeat: true}
+	s, _ = newTestSource(p, 10, 2)
+	if s.query(context.Background(), "example.com", p.QueryDomain); len(p.cursors) != 2 {
+		t.Errorf("the provider was queried %d times while repeating the cursor", len(p.cursors))
+	}
+}
+
+func TestQueryQuota(t *testing.T) {
+	tracker, err := quota.NewTracker("", map[string]quota.Limits{"Pager": {Daily: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &pager{pages: 5}
+	s, _ := newTestSource(p, 10, 2)
+	s.SetQuotaTracker(tracker)
+
+	if s.query(context.Background(), "example.com", p.QueryDomain); len(p.cursors) != 2 {
+		t.Errorf("the provider was queried %d times with a daily quota of 2", len(p.cursors))
+	}
+	if u := tracker.Usage("Pager"); u.DailyUsed != 2 {
+		t.Errorf("the tracker recorded %d requests", u.DailyUsed)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	s, _ := newTestSource(&pager{}, 1, 1)
+	job := requests.NewJob("test", s.sys.Config(), []string{s.String()})
+	ctx := requests.WithJob(context.Background(), job)
+
+	day := func(d int) time.Time { return time.Date(2022, 1, d, 0, 0, 0, 0, time.UTC) }
+	records := []*Record{
+		{Name: "www.example.com.", Type: "A", Data: "93.184.216.34", FirstSeen: day(1), LastSeen: day(5), Raw: `{"a":1}`},
+		{Name: "WWW.example.com", Type: "A", Data: "104.18.1.1", FirstSeen: day(3), LastSeen: day(20)},
+		{Name: "www.example.com", Type: "A", Data: "93.184.216.34", FirstSeen: day(10), LastSeen: day(12)},
+		{Name: "mail.example.com", Type: "CNAME", Data: "mx.example.com.", FirstSeen: day(2), LastSeen: day(4)},
+		{Name: "www.other.org", Type: "A", Data: "203.0.113.1", FirstSeen: day(1), LastSeen: day(2)},
+	}
+
+	done := make(chan []interface{})
+	go func() {
+		var reqs []interface{}
+		for req := range job.Output(s.String()) {
+			reqs = append(reqs, req)
+		}
+		done <- reqs
+	}()
+	s.submit(ctx, records)
+	close(job.Output(s.String()))
+	reqs := <-done
+
+	www := job.Findings.Get("www.example.com")
+	if www == nil {
+		t.Fatal("the finding of www.example.com was not added to the job")
+	}
+	if !www.FirstSeen.Equal(day(1)) || !www.LastSeen.Equal(day(20)) {
+		t.Errorf("www.example.com was seen from %s to %s", www.FirstSeen, www.LastSeen)
+	}
+	if len(www.Resolutions) != 2 || !www.Resolutions[0].FirstSeen.Equal(day(3)) || !www.Resolutions[1].LastSeen.Equal(day(12)) {
+		t.Errorf("www.example.com has the resolutions %+v", www.Resolutions)
+	}
+	if job.Findings.Get("mx.example.com") == nil {
+		t.Error("the target of the alias was not added to the job")
+	}
+	if job.Findings.Get("www.other.org") != nil {
+		t.Error("the name out of scope was added to the job")
+	}
+
+	var names, addrs int
+	for _, req := range reqs {
+		switch v := req.(type) {
+		case *requests.DNSRequest:
+			names++
+			if v.Domain != "example.com" || v.Derivation != requests.DerivedFromSource {
+				t.Errorf("the name request %+v was not derived from the source", v)
+			}
+		case *requests.AddrRequest:
+			addrs++
+		}
+	}
+	if names != 3 || addrs != 2 {
+		t.Errorf("the source sent %d names and %d addresses, expected 3 and 2", names, addrs)
+	}
+}
+
+func TestSubmitAddress(t *testing.T) {
+	s, _ := newTestSource(&pager{}, 1, 1)
+	cfg := s.sys.Config()
+	_, ipnet, _ := net.ParseCIDR("192.0.2.0/24")
+	cfg.Scope.CIDRs = []*net.IPNet{ipnet}
+	job := requests.NewJob("test", cfg, []string{s.String()})
+	ctx := requests.WithJob(context.Background(), job)
+
+	records := []*Record{
+		{Name: "www.example.com", Type: "A", Data: "192.0.2.1"},
+		{Name: "www.other.org", Type: "A", Data: "192.0.2.1"},
+		{Name: "www.other.org", Type: "A", Data: "192.0.2.1"},
+		{Name: "far.other.org", Type: "A", Data: "203.0.113.1"},
+	}
+
+	done := make(chan []interface{})
+	go func() {
+		var reqs []interface{}
+		for req := range job.Output(s.String()) {
+			reqs = append(reqs, req)
+		}
+		done <- reqs
+	}()
+	s.submitAddress(ctx, records)
+	close(job.Output(s.String()))
+	reqs := <-done
+
+	var observed, names int
+	for _, req := range reqs {
+		v, ok := req.(*requests.DNSRequest)
+		if !ok {
+			continue
+		}
+
+		switch v.Name {
+		case "www.other.org":
+			observed++
+			if v.Domain != "" || len(v.Records) != 1 || v.Records[0].Data != "192.0.2.1" {
+				t.Errorf("the name outside of the domains was delivered as %+v", v)
+			}
+		case "www.example.com":
+			names++
+		default:
+			t.Errorf("the name %s outside of the address scope was delivered", v.Name)
+		}
+	}
+	if observed != 1 || names != 1 {
+		t.Errorf("the source delivered %d names outside of the domains and %d within", observed, names)
+	}
+}
+
+func TestAdapters(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/circl/example.com":
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintln(w, `{"rrname":"www.example.com","rrtype":"A","rdata":"192.0.2.1","time_first":1640995200,"time_last":1641081600}`)
+			fmt.Fprintln(w, `{"rrname":"ftp.example.com","rrtype":"CNAME","rdata":"www.example.com","time_first":1640995200,"time_last":1641081600}`)
+		case r.URL.Path == "/mnemonic/example.com":
+			fmt.Fprintf(w, `{"responseCode":200,"count":3,"data":[`+
+				`{"query":"www.example.com","answer":"192.0.2.1","rrtype":"a","firstSeenTimestamp":1640995200000,"lastSeenTimestamp":1641081600000},`+
+				`{"query":"mail.example.com","answer":"192.0.2.2","rrtype":"a","firstSeenTimestamp":1640995200000,"lastSeenTimestamp":1641081600000}]}`)
+		case r.URL.Path == "/dnsdb/lookup/rrset/name/*.example.com/ANY":
+			if r.Header.Get("X-API-Key") != "key" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprintln(w, `{"cond":"begin"}`)
+			fmt.Fprintln(w, `{"obj":{"rrname":"www.example.com.","rrtype":"A","rdata":["192.0.2.1","192.0.2.2"],"time_first":1640995200,"time_last":1641081600}}`)
+			fmt.Fprintln(w, `{"cond":"limited","msg":"Result limit reached"}`)
+		case r.URL.Path == "/dnsdb/lookup/rdata/ip/192.0.2.1":
+			fmt.Fprintln(w, `{"obj":{"rrname":"www.example.com.","rrtype":"A","rdata":"192.0.2.1","time_first":1640995200,"time_last":1641081600}}`)
+			fmt.Fprintln(w, `{"cond":"succeeded"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	first := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		query    func() (*Page, error)
+		records  int
+		next     string
+		lastData string
+	}{
+		{
+			name: "CIRCL",
+			query: func() (*Page, error) {
+				c := &circl{base: ts.URL + "/circl/", auth: &amasshttp.BasicAuth{Username: "user", Password: "pass"}}
+				return c.QueryDomain(context.Background(), "example.com", "")
+			},
+			records:  2,
+			lastData: "www.example.com",
+		},
+		{
+			name: "Mnemonic",
+			query: func() (*Page, error) {
+				m := &mnemonic{base: ts.URL + "/mnemonic/"}
+				return m.QueryDomain(context.Background(), "example.com", "")
+			},
+			records:  2,
+			next:     "2",
+			lastData: "192.0.2.2",
+		},
+		{
+			name: "DNSDB domain",
+			query: func() (*Page, error) {
+				d := &dnsdb{base: ts.URL + "/dnsdb", key: "key"}
+				return d.QueryDomain(context.Background(), "example.com", "")
+			},
+			records:  2,
+			next:     strconv.Itoa(dnsdbPageSize),
+			lastData: "192.0.2.2",
+		},
+		{
+			name: "DNSDB address",
+			query: func() (*Page, error) {
+				d := &dnsdb{base: ts.URL + "/dnsdb", key: "key"}
+				return d.QueryIP(context.Background(), "192.0.2.1", "")
+			},
+			records:  1,
+			lastData: "192.0.2.1",
+		},
+	}
+
+	for _, test := range tests {
+		page, err := test.query()
+		if err != nil {
+			t.Errorf("%s: the query failed: %v", test.name, err)
+			continue
+		}
+		if len(page.Records) != test.records || page.Next != test.next {
+			t.Errorf("%s: the page has %d records and the cursor %q, expected %d and %q",
+				test.name, len(page.Records), page.Next, test.records, test.next)
+			continue
+		}
+		last := page.Records[len(page.Records)-1]
+		if last.Data != test.lastData || !last.FirstSeen.Equal(first) || last.Raw == "" {
+			t.Errorf("%s: the last record %+v was not parsed", test.name, last)
+		}
+	}
+
+	d := &dnsdb{base: ts.URL + "/dnsdb", key: "wrong"}
+	if _, err := d.QueryDomain(context.Background(), "example.com", ""); err == nil {
+		t.Error("DNSDB: the query rejected by the provider did not fail")
+	}
+}
+
+func TestAdaptersSalvage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mnemonic/example.com":
+			// The response was cut off within the third record
+			fmt.Fprint(w, `{"responseCode":200,"count":3,"data":[`+
+				`{"query":"www.example.com","answer":"192.0.2.1","rrtype":"a","firstSeenTimestamp":1640995200000},`+
+				`{"query":"mail.example.com","answer":"192.0.2.2","rrtype":"a","firstSeenTimestamp":1640995200000},`+
+				`{"query":"ftp.exa`)
+		case "/dnsdb/lookup/rrset/name/*.example.com/ANY":
+			fmt.Fprintln(w, `{"cond":"begin"}`)
+			fmt.Fprintln(w, `{"obj":{"rrname":"www.example.com.","rrtype":"A","rdata":["192.0.2.1"],"time_first":1640995200}}`)
+			fmt.Fprintln(w, `{"obj":{"rrname":"mail.example.com.","rrtype":"A","rdata":[192.0.2.2"]}}`)
+			fmt.Fprintln(w, `{"obj":{"rrname":"ftp.example.com.","rrtype":"A","rdata":["192.0.2.3"],"time_first":1640995200}}`)
+			fmt.Fprintln(w, `{"cond":"limited","msg":"Result limit reached"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		p       Provider
+		records []string
+		next    string
+	}{
+		{"Mnemonic", &mnemonic{base: ts.URL + "/mnemonic/"}, []string{"www.example.com", "mail.example.com"}, "2"},
+		{"DNSDB", &dnsdb{base: ts.URL + "/dnsdb", key: "key"}, []string{"www.example.com.", "ftp.example.com."}, strconv.Itoa(dnsdbPageSize)},
+	}
+
+	for _, test := range tests {
+		page, err := test.p.QueryDomain(context.Background(), "example.com", "")
+		var perr *salvage.Error
+		if !errors.As(err, &perr) || page == nil {
+			t.Errorf("%s: the parse error was returned as %v", test.name, err)
+			continue
+		}
+
+		var names []string
+		for _, r := range page.Records {
+			names = append(names, r.Name)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(test.records) || page.Next != test.next || page.Payload == "" {
+			t.Errorf("%s: the records %v and the cursor %q were salvaged, expected %v and %q",
+				test.name, names, page.Next, test.records, test.next)
+		}
+	}
+}
+
+// truncator is a Provider returning the first page parsed in part, followed by a valid page.
+type truncator struct {
+	calls int
+}
+
+func (tr *truncator) QueryDomain(ctx context.Context, domain, cursor string) (*Page, error) {
+	tr.calls++
+	if cursor == "" {
+		page := &Page{
+			Records: []*Record{{Name: "www." + domain, Type: "A", Data: "192.0.2.1"}},
+			Next:    "1",
+			Payload: `{"records":[{"name":"www.example.com"},{"name":"mail.exa`,
+		}
+		return page, &salvage.Error{Offset: 55, Entries: 1, Err: io.ErrUnexpectedEOF}
+	}
+	return &Page{Records: []*Record{{Name: "ftp." + domain, Type: "A", Data: "192.0.2.2"}}}, nil
+}
+
+func (tr *truncator) QueryIP(ctx context.Context, addr, cursor string) (*Page, error) {
+	return nil, errors.New("the query failed")
+}
+
+func TestQueryParseErrors(t *testing.T) {
+	tr := &truncator{}
+	s, _ := newTestSource(tr, 10, 2)
+
+	if records := s.query(context.Background(), "example.com", tr.QueryDomain); len(records) != 2 || tr.calls != 2 {
+		t.Errorf("the query returned %d records over %d pages, expected the salvaged page to be followed", len(records), tr.calls)
+	}
+	if c := s.ParseErrors(); c.Responses != 1 || c.Entries != 1 || c.LastError == "" {
+		t.Errorf("the parse errors were counted as %+v", c)
+	}
+
+	// The other errors still end the query
+	if records := s.query(context.Background(), "192.0.2.1", tr.QueryIP); len(records) != 0 || s.ParseErrors().Responses != 1 {
+		t.Error("the failed query was counted as a parse error")
+	}
+}
+
+func TestAdapterCredentials(t *testing.T) {
+	for _, a := range Adapters() {
+		if _, err := a.New(nil); a.RequiresCredentials != (err != nil) {
+			t.Errorf("%s: the adapter returned %v without credentials", a.Name, err)
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.DataSrcConfigs = &config.DataSourceConfig{
+		Datasources: []*config.DataSource{{
+			Name: "DNSDB",
+			Creds: map[string]*config.Credentials{
+				"second": {Name: "second", Apikey: "b"},
+				"first":  {Name: "first", Apikey: "a"},
+			},
+		}},
+	}
+	if c := Credentials(cfg, "dnsdb"); c == nil || c.Apikey != "a" {
+		t.Errorf("the credentials %+v were selected", c)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package passivedns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/caffix/service"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/datasrcs/quota"
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// Source is the data source Service querying a passive DNS provider through its adapter.
+type Source struct {
+	service.BaseService
+	sys      systems.System
+	adapter  Adapter
+	provider Provider
+	clock    clock.Clock
+	limiter  *rate.Limiter
+	quota    *quota.Tracker
+	maxPages int
+	parseErr *salvage.Counter
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// NewSource returns the data source of the passive DNS provider, initialized but not yet started.
+func NewSource(a Adapter, sys systems.System) *Source {
+	s := &Source{
+		sys:      sys,
+		adapter:  a,
+		clock:    clock.System,
+		maxPages: MaxPagesFromConfig(sys.Config()),
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.BaseService = *service.NewBaseService(s, a.Name)
+	s.parseErr = salvage.NewCounter(s.String())
+	go s.requests()
+	return s
+}
+
+// Description implements the Service interface.
+func (s *Source) Description() string {
+	return "api"
+}
+
+// OnStart implements the Service interface.
+func (s *Source) OnStart() error {
+	creds := Credentials(s.sys.Config(), s.adapter.Name)
+	if s.adapter.RequiresCredentials && creds == nil {
+		s.sys.Config().Log.Printf("%s: the credentials were not provided", s.String())
+		return &systems.SourceError{Name: s.String(), Err: ErrNoCredentials}
+	}
+
+	p, err := s.adapter.New(creds)
+	if err != nil {
+		s.sys.Config().Log.Printf("%s: %v", s.String(), err)
+		return &systems.SourceError{Name: s.String(), Err: err}
+	}
+
+	s.provider = p
+	s.limiter = rate.NewLimiter(s.adapter.QPS, 1, s.clock)
+	return nil
+}
+
+// OnStop implements the Service interface.
+func (s *Source) OnStop() error {
+	s.cancel()
+	return s.quota.Stop()
+}
+
+// SetQuotaTracker assigns the Tracker used to account for the API usage of the provider.
+func (s *Source) SetQuotaTracker(t *quota.Tracker) {
+	s.quota = t
+}
+
+// ParseErrors implements the salvage.Reporter interface.
+func (s *Source) ParseErrors() salvage.Count {
+	return s.parseErr.Count()
+}
+
+// SupportsContext implements the requests.ContextAware interface.
+func (s *Source) SupportsContext() bool {
+	return true
+}
+
+// HandlesReq implements the Service interface.
+func (s *Source) HandlesReq(req interface{}) bool {
+	_, req = requests.UnwrapContext(req)
+
+	switch t := req.(type) {
+	case *requests.DNSRequest:
+		return t != nil && t.Domain != ""
+	case *requests.AddrRequest:
+		return t != nil && t.Address != ""
+	}
+	return false
+}
+
+func (s *Source) requests() {
+	for {
+		select {
+		case <-s.Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case in := <-s.Input():
+			s.dispatch(in)
+		}
+	}
+}
+
+func (s *Source) dispatch(in interface{}) {
+	if s.provider == nil {
+		return
+	}
+
+	reqCtx, in := requests.UnwrapContext(in)
+	ctx, cancel := s.requestContext(reqCtx)
+	defer cancel()
+
+	switch req := in.(type) {
+	case *requests.DNSRequest:
+		if req != nil && req.Domain != "" {
+			s.sys.Config().Log.Printf("Querying %s for %s subdomains", s.String(), req.Domain)
+			s.submit(ctx, s.query(ctx, req.Domain, s.provider.QueryDomain))
+		}
+	case *requests.AddrRequest:
+		if req != nil && req.Address != "" {
+			s.submitAddress(ctx, s.query(ctx, req.Address, s.provider.QueryIP))
+		}
+	}
+}
+
+// requestContext returns a context that is cancelled when either the source
+// is stopped or the work the request belongs to has been cancelled.
+func (s *Source) requestContext(reqCtx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	// The findings must be delivered to the enumeration the request belongs to
+	if job := requests.JobFromContext(reqCtx); job != nil {
+		ctx = requests.WithJob(ctx, job)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-reqCtx.Done():
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
+type queryFunc func(ctx context.Context, q, cursor string) (*Page, error)
+
+// query requests the pages of records answering the query, within the rate limit and API quota of
+// the provider, until the last page or the page cap is reached.
+func (s *Source) query(ctx context.Context, q string, fn queryFunc) []*Record {
+	var records []*Record
+
+	var cursor string
+	seen := make(map[string]struct{})
+	for i := 0; i < s.maxPages; i++ {
+		if ctx.Err() != nil || !s.quota.Allow(s.String()) {
+			break
+		}
+
+		if s.limiter.TakeContext(ctx) != nil {
+			break
+		}
+		page, err := fn(ctx, q, cursor)
+		// The records salvaged from a response that was only parsed in part are kept
+		var perr *salvage.Error
+		if errors.As(err, &perr) && page != nil {
+			s.parseErr.Record(ctx, s.jobConfig(ctx), q, []byte(page.Payload), err)
+		} else if err != nil {
+			s.sys.Config().Log.Printf("%s: query for %s failed: %v", s.String(), q, err)
+			break
+		}
+		if err := s.quota.Record(s.String(), 1); err != nil {
+			s.sys.Config().Log.Printf("%s: failed to record the API usage: %v", s.String(), err)
+		}
+
+		records = append(records, page.Records...)
+		// A provider returning the same cursor again would never reach the last page
+		if _, found := seen[page.Next]; found || page.Next == "" {
+			break
+		}
+		seen[page.Next] = struct{}{}
+		cursor = page.Next
+	}
+	return records
+}
+
+// submit merges the records of each name into a finding, and delivers the names and addresses within scope.
+func (s *Source) submit(ctx context.Context, records []*Record) {
+	cfg := s.jobConfig(ctx)
+	job := requests.JobFromContext(ctx)
+
+	findings := make(map[string]*requests.Finding)
+	var order []string
+	evidence := make(map[string]string)
+	for _, rec := range records {
+		name := cleanName(rec.Name)
+		if name == "" || cfg.WhichDomain(name) == "" {
+			continue
+		}
+
+		f, found := findings[name]
+		if !found {
+			f = &requests.Finding{Name: name, Sources: []string{s.String()}}
+			findings[name] = f
+			order = append(order, name)
+		}
+		if _, found := evidence[name]; !found && rec.Raw != "" {
+			evidence[name] = rec.Raw
+		}
+
+		f.Merge(&requests.Finding{FirstSeen: rec.FirstSeen, LastSeen: rec.LastSeen})
+		switch strings.ToUpper(rec.Type) {
+		case "A", "AAAA":
+			if ip := net.ParseIP(strings.TrimSpace(rec.Data)); ip != nil {
+				f.Merge(&requests.Finding{Resolutions: []requests.Resolution{{
+					Address:   ip.String(),
+					FirstSeen: rec.FirstSeen,
+					LastSeen:  rec.LastSeen,
+				}}})
+			}
+		case "CNAME":
+			// The target of the alias is a finding of its own when it is within scope
+			if target := cleanName(rec.Data); target != "" && cfg.WhichDomain(target) != "" {
+				if _, found := findings[target]; !found {
+					findings[target] = &requests.Finding{Name: target, Sources: []string{s.String()}}
+					order = append(order, target)
+				}
+			}
+		}
+	}
+
+	for _, name := range order {
+		f := findings[name]
+		if job != nil {
+			job.Findings.Add(f)
+			if raw, found := evidence[name]; found && job.Evidence != nil {
+				if _, err := job.Evidence.Add(name, s.String(), []byte(raw)); err != nil {
+					cfg.Log.Printf("%s: failed to store the evidence for %s: %v", s.String(), name, err)
+				}
+			}
+		}
+
+		s.sendOutput(ctx, &requests.DNSRequest{
+			Name:       name,
+			Domain:     cfg.WhichDomain(name),
+			Parent:     s.String(),
+			Derivation: requests.DerivedFromSource,
+		})
+		for _, r := range f.Resolutions {
+			if reserved, _ := amassnet.IsReservedAddress(r.Address); !reserved {
+				s.sendOutput(ctx, &requests.AddrRequest{Address: r.Address, Domain: cfg.WhichDomain(name)})
+			}
+		}
+	}
+}
+
+// submitAddress delivers the names found on an address. When the address is within the address scope,
+// the names outside of the domains are delivered along with the address, so the enumeration can observe
+// them in the netblock, while the names within the domains are submitted as usual.
+func (s *Source) submitAddress(ctx context.Context, records []*Record) {
+	cfg := s.jobConfig(ctx)
+
+	if len(cfg.Scope.Addresses) > 0 || len(cfg.Scope.CIDRs) > 0 {
+		seen := make(map[string]struct{})
+
+		for _, rec := range records {
+			name := cleanName(rec.Name)
+			ip := net.ParseIP(strings.TrimSpace(rec.Data))
+			if name == "" || ip == nil || cfg.WhichDomain(name) != "" || !cfg.IsAddressInScope(ip.String()) {
+				continue
+			}
+
+			k := name + "|" + ip.String()
+			if _, found := seen[k]; found {
+				continue
+			}
+			seen[k] = struct{}{}
+
+			qtype := dns.TypeA
+			if amassnet.IsIPv6(ip) {
+				qtype = dns.TypeAAAA
+			}
+			s.sendOutput(ctx, &requests.DNSRequest{
+				Name:       name,
+				Parent:     s.String(),
+				Derivation: requests.DerivedFromSource,
+				Records:    []requests.DNSAnswer{{Name: name, Type: int(qtype), Data: ip.String()}},
+			})
+		}
+	}
+	s.submit(ctx, records)
+}
+
+func cleanName(name string) string {
+	n, err := amassdns.NormalizeName(name)
+	if err != nil {
+		return ""
+	}
+	return amassdns.RemoveAsteriskLabel(n)
+}
+
+// jobConfig returns the configuration of the enumeration the request belongs to.
+// Requests made outside of an enumeration job fall back to the system configuration.
+func (s *Source) jobConfig(ctx context.Context) *config.Config {
+	if job := requests.JobFromContext(ctx); job != nil && job.Config != nil {
+		return job.Config
+	}
+	return s.sys.Config()
+}
+
+// output returns the channel that receives the findings of the enumeration the request belongs to.
+func (s *Source) output(ctx context.Context) chan interface{} {
+	if ch := requests.JobFromContext(ctx).Output(s.String()); ch != nil {
+		return ch
+	}
+	return s.Output()
+}
+
+// sendOutput delivers the finding unless the request or the source has been cancelled.
+func (s *Source) sendOutput(ctx context.Context, req interface{}) {
+	select {
+	case <-ctx.Done():
+	case <-s.Done():
+	case s.output(ctx) <- req:
+	}
+}
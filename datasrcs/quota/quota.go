@@ -0,0 +1,383 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+// StateFile is the name of the file in the output directory that persists quota usage.
+const StateFile = "quotas.json"
+
+// DefaultFlushInterval is the period between the writes of the changed usage to the state file.
+const DefaultFlushInterval = 5 * time.Second
+
+// Limits contains the quota configured for a single data source.
+// A zero limit means the period is not limited.
+type Limits struct {
+	Daily     int
+	Monthly   int
+	ResetHour int // UTC hour of the day when the provider resets the quota
+	ResetDay  int // Day of the month when the provider resets the monthly quota
+}
+
+// Usage reports the quota consumption of a single data source.
+type Usage struct {
+	Source       string `json:"-"`
+	DailyUsed    int    `json:"daily_used"`
+	DailyLimit   int    `json:"-"`
+	DailyStart   int64  `json:"daily_start"`
+	MonthlyUsed  int    `json:"monthly_used"`
+	MonthlyLimit int    `json:"-"`
+	MonthlyStart int64  `json:"monthly_start"`
+}
+
+// Remaining returns the number of requests left before the quota is exhausted, or -1 when unlimited.
+func (u Usage) Remaining() int {
+	remaining := -1
+
+	if u.DailyLimit > 0 {
+		remaining = nonNegative(u.DailyLimit - u.DailyUsed)
+	}
+	if u.MonthlyLimit > 0 {
+		if r := nonNegative(u.MonthlyLimit - u.MonthlyUsed); remaining == -1 || r < remaining {
+			remaining = r
+		}
+	}
+	return remaining
+}
+
+// Tracker accounts for data source API usage against the configured quotas and persists it across runs.
+// The usage is recorded for every request, so the state file is only written at the flush interval and
+// when the Tracker is stopped.
+type Tracker struct {
+	sync.Mutex
+	path   string
+	limits map[string]Limits
+	usage  map[string]*Usage
+	now    func() time.Time
+	// dirty is true when the usage changed since the state file was written
+	dirty bool
+	// flushErr is the error of the last write made at the interval, returned by the next Record
+	flushErr error
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewTracker returns a Tracker that persists usage in the file at path, loading any existing state.
+// The Tracker writes the file until it is stopped.
+func NewTracker(path string, limits map[string]Limits) (*Tracker, error) {
+	return newTracker(path, limits, DefaultFlushInterval)
+}
+
+func newTracker(path string, limits map[string]Limits, interval time.Duration) (*Tracker, error) {
+	t := &Tracker{
+		path:   path,
+		limits: make(map[string]Limits),
+		usage:  make(map[string]*Usage),
+		now:    time.Now,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	for name, l := range limits {
+		t.limits[key(name)] = l
+	}
+
+	if path == "" {
+		close(t.done)
+		return t, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the quota state file: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &t.usage); err != nil {
+			return nil, fmt.Errorf("failed to parse the quota state file: %v", err)
+		}
+	}
+
+	go t.flushAtInterval(interval)
+	return t, nil
+}
+
+func (t *Tracker) flushAtInterval(interval time.Duration) {
+	defer close(t.done)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-tick.C:
+			if err := t.Flush(); err != nil {
+				t.Lock()
+				t.flushErr = err
+				t.Unlock()
+			}
+		}
+	}
+}
+
+// Flush writes the usage to the state file, unless it has not changed since the last write.
+func (t *Tracker) Flush() error {
+	if t == nil {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.dirty {
+		return nil
+	}
+	if err := t.save(); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// Stop ends the writes made at the interval and writes the usage that changed since the last one. The data
+// sources sharing the Tracker each stop it, so the usage recorded by the sources stopping later is written too.
+func (t *Tracker) Stop() error {
+	if t == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+	return t.Flush()
+}
+
+// FromConfig returns a Tracker using the 'quotas' configuration options and the state file in the output directory.
+func FromConfig(cfg *config.Config) (*Tracker, error) {
+	var path string
+	if dir := config.OutputDirectory(cfg.Dir); dir != "" {
+		path = filepath.Join(dir, StateFile)
+	}
+	return NewTracker(path, LimitsFromConfig(cfg))
+}
+
+// LimitsFromConfig parses the per data source limits from the 'quotas' configuration options.
+func LimitsFromConfig(cfg *config.Config) map[string]Limits {
+	limits := make(map[string]Limits)
+	if cfg == nil || cfg.Options == nil {
+		return limits
+	}
+
+	opts, ok := cfg.Options["quotas"].(map[string]interface{})
+	if !ok {
+		return limits
+	}
+
+	for name, v := range opts {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		limits[name] = Limits{
+			Daily:     options.Int(m["daily"]),
+			Monthly:   options.Int(m["monthly"]),
+			ResetHour: options.Int(m["reset_hour"]),
+			ResetDay:  options.Int(m["reset_day"]),
+		}
+	}
+	return limits
+}
+
+// Allow returns true when the named data source has not exhausted its quota.
+func (t *Tracker) Allow(source string) bool {
+	if t == nil {
+		return true
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if _, limited := t.limits[key(source)]; !limited {
+		return true
+	}
+
+	_, u := t.current(source)
+	return u.Remaining() != 0
+}
+
+// Record adds num requests to the usage of the named data source, which is persisted at the next flush.
+// The error of a failed flush made at the interval is returned by the following Record.
+func (t *Tracker) Record(source string, num int) error {
+	if t == nil {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if _, limited := t.limits[key(source)]; !limited {
+		return nil
+	}
+
+	_, u := t.current(source)
+	u.DailyUsed += num
+	u.MonthlyUsed += num
+	t.dirty = true
+
+	err := t.flushErr
+	t.flushErr = nil
+	return err
+}
+
+// Correct updates the usage of the named data source using the remaining quota reported by the provider.
+func (t *Tracker) Correct(source string, remaining int) error {
+	if t == nil || remaining < 0 {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	l, limited := t.limits[key(source)]
+	if !limited {
+		return nil
+	}
+
+	_, u := t.current(source)
+	if l.Daily > 0 {
+		u.DailyUsed = nonNegative(l.Daily - remaining)
+	}
+	if l.Monthly > 0 && l.Daily == 0 {
+		u.MonthlyUsed = nonNegative(l.Monthly - remaining)
+	}
+	t.dirty = true
+	return nil
+}
+
+// Usage returns the current quota usage of the named data source.
+func (t *Tracker) Usage(source string) Usage {
+	if t == nil {
+		return Usage{}
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	_, u := t.current(source)
+	return *u
+}
+
+// All returns the current quota usage for every data source with configured limits, sorted by name.
+func (t *Tracker) All() []Usage {
+	if t == nil {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	var all []Usage
+	for name := range t.limits {
+		_, u := t.current(name)
+		all = append(all, *u)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Source < all[j].Source
+	})
+	return all
+}
+
+// current returns the limits and usage of the source, rolling the counters over when a reset time has passed.
+func (t *Tracker) current(source string) (Limits, *Usage) {
+	k := key(source)
+	l := t.limits[k]
+
+	u, found := t.usage[k]
+	if !found {
+		u = new(Usage)
+		t.usage[k] = u
+	}
+
+	now := t.now().UTC()
+	if start := dailyStart(now, l).Unix(); u.DailyStart != start {
+		u.DailyStart = start
+		u.DailyUsed = 0
+	}
+	if start := monthlyStart(now, l).Unix(); u.MonthlyStart != start {
+		u.MonthlyStart = start
+		u.MonthlyUsed = 0
+	}
+
+	u.Source = k
+	u.DailyLimit = l.Daily
+	u.MonthlyLimit = l.Monthly
+	return l, u
+}
+
+func (t *Tracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(t.usage)
+	if err != nil {
+		return err
+	}
+	// Write the new state beside the old one and swap them, so a crash cannot truncate the file
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write the quota state file: %v", err)
+	}
+	return os.Rename(tmp, t.path)
+}
+
+func dailyStart(now time.Time, l Limits) time.Time {
+	start := time.Date(now.Year(), now.Month(), now.Day(), l.ResetHour, 0, 0, 0, time.UTC)
+
+	if start.After(now) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+func monthlyStart(now time.Time, l Limits) time.Time {
+	day := l.ResetDay
+	if day < 1 || day > 28 {
+		day = 1
+	}
+
+	start := time.Date(now.Year(), now.Month(), day, l.ResetHour, 0, 0, 0, time.UTC)
+	if start.After(now) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+func nonNegative(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func key(source string) string {
+	return strings.ToLower(strings.TrimSpace(source))
+}
@@ -0,0 +1,175 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package quota
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestTrackerLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StateFile)
+	now := time.Date(2023, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tracker, err := NewTracker(path, map[string]Limits{"Shodan": {Daily: 2, Monthly: 3, ResetHour: 6}})
+	if err != nil {
+		t.Fatalf("Failed to create the tracker: %v", err)
+	}
+	tracker.now = func() time.Time { return now }
+
+	if !tracker.Allow("Unlimited") {
+		t.Errorf("A data source without limits was not allowed")
+	}
+	for i := 0; i < 2; i++ {
+		if !tracker.Allow("shodan") {
+			t.Fatalf("Request %d was not allowed", i+1)
+		}
+		if err := tracker.Record("Shodan", 1); err != nil {
+			t.Fatalf("Failed to record the usage: %v", err)
+		}
+	}
+	if tracker.Allow("Shodan") {
+		t.Errorf("The exhausted daily quota was not enforced")
+	}
+	// The daily quota resets at 06:00 UTC, while the monthly usage carries over
+	now = time.Date(2023, time.March, 11, 6, 30, 0, 0, time.UTC)
+	if u := tracker.Usage("Shodan"); u.DailyUsed != 0 || u.MonthlyUsed != 2 || u.Remaining() != 1 {
+		t.Errorf("Unexpected usage after the daily reset: %+v", u)
+	}
+	if err := tracker.Record("Shodan", 1); err != nil {
+		t.Fatalf("Failed to record the usage: %v", err)
+	}
+	if tracker.Allow("Shodan") {
+		t.Errorf("The exhausted monthly quota was not enforced")
+	}
+	// The usage must survive across runs
+	if err := tracker.Stop(); err != nil {
+		t.Fatalf("Failed to stop the tracker: %v", err)
+	}
+	reloaded, err := NewTracker(path, map[string]Limits{"Shodan": {Daily: 2, Monthly: 3, ResetHour: 6}})
+	if err != nil {
+		t.Fatalf("Failed to reload the tracker: %v", err)
+	}
+	reloaded.now = func() time.Time { return now }
+	if u := reloaded.Usage("Shodan"); u.DailyUsed != 1 || u.MonthlyUsed != 3 {
+		t.Errorf("The usage was not persisted: %+v", u)
+	}
+	// The monthly quota resets on the first day of the month
+	now = time.Date(2023, time.April, 1, 7, 0, 0, 0, time.UTC)
+	if !reloaded.Allow("Shodan") {
+		t.Errorf("The monthly quota did not reset")
+	}
+	_ = reloaded.Stop()
+}
+
+func TestTrackerFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StateFile)
+	limits := map[string]Limits{"Shodan": {Daily: 100}}
+
+	tracker, err := newTracker(path, limits, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to create the tracker: %v", err)
+	}
+	defer func() { _ = tracker.Stop() }()
+
+	// The requests are not written one by one
+	for i := 0; i < 10; i++ {
+		if err := tracker.Record("Shodan", 1); err != nil {
+			t.Fatalf("Failed to record the usage: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("The state file was written by the requests: %v", err)
+	}
+
+	// The changed usage is written at the interval
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(path); err == nil && strings.Contains(string(data), `"daily_used":10`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The usage was not written at the interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The usage recorded after the last flush is written once the tracker is stopped, even by another source
+	stopped, err := newTracker(path, limits, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to reload the tracker: %v", err)
+	}
+	_ = stopped.Record("Shodan", 5)
+	if err := stopped.Stop(); err != nil {
+		t.Fatalf("Failed to stop the tracker: %v", err)
+	}
+	_ = stopped.Record("Shodan", 1)
+	if err := stopped.Stop(); err != nil {
+		t.Fatalf("Failed to stop the tracker again: %v", err)
+	}
+	reloaded, err := NewTracker(path, limits)
+	if err != nil {
+		t.Fatalf("Failed to reload the tracker: %v", err)
+	}
+	defer func() { _ = reloaded.Stop() }()
+	if u := reloaded.Usage("Shodan"); u.DailyUsed != 16 {
+		t.Errorf("The usage was persisted as %+v", u)
+	}
+}
+
+func TestTrackerCorrect(t *testing.T) {
+	tracker, err := NewTracker("", map[string]Limits{"VirusTotal": {Daily: 500}})
+	if err != nil {
+		t.Fatalf("Failed to create the tracker: %v", err)
+	}
+
+	_ = tracker.Record("VirusTotal", 1)
+	if err := tracker.Correct("VirusTotal", 0); err != nil {
+		t.Fatalf("Failed to correct the usage: %v", err)
+	}
+	if tracker.Allow("VirusTotal") {
+		t.Errorf("The usage reported by the provider was not applied")
+	}
+
+	_ = tracker.Correct("VirusTotal", 450)
+	if u := tracker.Usage("VirusTotal"); u.DailyUsed != 50 || u.Remaining() != 450 {
+		t.Errorf("Unexpected usage after the correction: %+v", u)
+	}
+}
+
+func TestLimitsFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["quotas"] = map[string]interface{}{
+		"Shodan": map[string]interface{}{"daily": 100, "monthly": 1000, "reset_hour": 4},
+		"Bad":    "invalid",
+	}
+
+	limits := LimitsFromConfig(cfg)
+	if len(limits) != 1 {
+		t.Fatalf("Expected limits for one data source, got %d", len(limits))
+	}
+	if l := limits["Shodan"]; l.Daily != 100 || l.Monthly != 1000 || l.ResetHour != 4 {
+		t.Errorf("Unexpected limits parsed from the configuration: %+v", l)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+
+	if !tracker.Allow("Shodan") || tracker.Record("Shodan", 1) != nil || tracker.Stop() != nil {
+		t.Error("the nil tracker limited the data source")
+	}
+	if u := tracker.Usage("Shodan"); u != (Usage{}) {
+		t.Errorf("the nil tracker returned the usage %+v", u)
+	}
+	if all := tracker.All(); all != nil {
+		t.Errorf("the nil tracker returned the usage %+v", all)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package salvage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/owasp-amass/amass/v4/evidence"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// Count reports the responses of a data source that were only parsed in part.
+type Count struct {
+	Source string `json:"source"`
+	// Responses is the number of responses that failed to parse in full
+	Responses int `json:"responses"`
+	// Entries is the number of entries lost to the parse errors
+	Entries   int    `json:"entries"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Reporter is implemented by the data sources that count their parse errors.
+type Reporter interface {
+	ParseErrors() Count
+}
+
+// Counter keeps the parse error Count of a data source.
+type Counter struct {
+	sync.Mutex
+	count Count
+}
+
+// NewCounter returns the Counter of the named data source.
+func NewCounter(source string) *Counter {
+	return &Counter{count: Count{Source: source}}
+}
+
+// Count returns the parse errors counted so far.
+func (c *Counter) Count() Count {
+	if c == nil {
+		return Count{}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.count
+}
+
+// Record counts the parse error of the response to the query and logs a warning. When the evidence
+// store of the job keeps the parse errors, the offending payload is stored under the queried name.
+func (c *Counter) Record(ctx context.Context, cfg *config.Config, query string, payload []byte, err error) {
+	if c == nil || err == nil {
+		return
+	}
+
+	entries := 1
+	var perr *Error
+	if errors.As(err, &perr) && perr.Entries > 0 {
+		entries = perr.Entries
+	}
+
+	c.Lock()
+	c.count.Responses++
+	c.count.Entries += entries
+	c.count.LastError = err.Error()
+	source := c.count.Source
+	c.Unlock()
+
+	if cfg != nil {
+		cfg.Log.Printf("%s: salvaged the response for %s: %v", source, query, err)
+	}
+
+	job := requests.JobFromContext(ctx)
+	if job == nil || job.Evidence == nil || query == "" {
+		return
+	}
+	if ec := evidence.ConfigFromOptions(cfg); ec != nil && ec.ParseErrors {
+		if _, aerr := job.Evidence.Add(query, source+" (parse error)", Excerpt(payload, err, ec.ParseErrorSize)); aerr != nil {
+			cfg.Log.Printf("%s: failed to store the payload of the parse error: %v", source, aerr)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package salvage parses the responses of the data sources incrementally, so a truncated or corrupted
+// response yields the entries that precede the parse error, rather than failing the whole query.
+package salvage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxLineSize bounds the lines read from the newline delimited streams.
+const maxLineSize = 4 << 20
+
+// Error reports a response that was only parsed in part. The entries preceding the error were salvaged.
+type Error struct {
+	// Offset is the position in bytes of the response where the parsing failed
+	Offset int64
+	// Entries is the number of entries that were lost, such as the lines that failed to parse
+	Entries int
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Entries > 1 {
+		return fmt.Sprintf("%d entries failed to parse, the first at offset %d: %v", e.Entries, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("parse error at offset %d: %v", e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying parse error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// JSON decodes the JSON document like json.Unmarshal into an interface{}, while keeping the parsed part
+// of a document that is truncated or corrupted. The complete elements of the arrays and the members of
+// the objects that precede the error are returned along with an *Error.
+func JSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	v, _, err := decodeValue(dec)
+	if err == nil {
+		// The document must not be followed by anything else, like json.Unmarshal requires
+		if _, terr := dec.Token(); terr != io.EOF {
+			err = errors.New("invalid data after the top-level value")
+		}
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return v, &Error{Offset: dec.InputOffset(), Entries: 1, Err: err}
+	}
+	return v, nil
+}
+
+// decodeValue returns the next value of the decoder, and whether it was parsed in full.
+func decodeValue(dec *json.Decoder) (interface{}, bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, false, err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, true, nil
+	}
+
+	switch delim {
+	case '[':
+		list := []interface{}{}
+		for dec.More() {
+			elem, complete, err := decodeValue(dec)
+			// An element parsed in part is an entry that was lost, unless it holds entries of its own
+			if _, nested := elem.([]interface{}); complete || nested {
+				list = append(list, elem)
+			}
+			if err != nil {
+				return list, false, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return list, false, err
+		}
+		return list, true, nil
+	case '{':
+		obj := make(map[string]interface{})
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return obj, false, err
+			}
+
+			key, _ := tok.(string)
+			val, complete, err := decodeValue(dec)
+			// The members parsed in part are kept when they hold the salvaged entries
+			if complete || isContainer(val) {
+				obj[key] = val
+			}
+			if err != nil {
+				return obj, false, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return obj, false, err
+		}
+		return obj, true, nil
+	}
+	return nil, false, fmt.Errorf("unexpected delimiter %v", delim)
+}
+
+func isContainer(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// Unmarshal parses the JSON document into v like json.Unmarshal. When the document is truncated or
+// corrupted, v receives the salvaged part of the document and an *Error is returned.
+func Unmarshal(data []byte, v interface{}) error {
+	perr := json.Unmarshal(data, v)
+
+	var serr *json.SyntaxError
+	if perr == nil || !(errors.As(perr, &serr) || errors.Is(perr, io.ErrUnexpectedEOF)) {
+		return perr
+	}
+
+	partial, err := JSON(data)
+	if err == nil || partial == nil {
+		return &Error{Offset: offset(perr), Entries: 1, Err: perr}
+	}
+	// The salvaged document is converted to the type of v, which drops what does not fit
+	if b, merr := json.Marshal(partial); merr == nil {
+		_ = json.Unmarshal(b, v)
+	}
+	return err
+}
+
+func offset(err error) int64 {
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
+		return serr.Offset
+	}
+	return 0
+}
+
+// Lines calls fn with each line of the newline delimited stream, such as NDJSON, skipping the empty lines.
+// The lines that fn fails to parse are skipped as well, and the returned *Error reports the first of them
+// along with their number. A stream that cannot be read to the end also returns an *Error.
+func Lines(r io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+
+	var perr *Error
+	var pos int64
+	for scanner.Scan() {
+		raw := scanner.Bytes()
+		start := pos
+		pos += int64(len(raw)) + 1
+
+		line := bytes.TrimSpace(raw)
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			if perr == nil {
+				perr = &Error{Offset: start, Err: err}
+			}
+			perr.Entries++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if perr == nil {
+			perr = &Error{Offset: pos, Err: err}
+		}
+		perr.Entries++
+	}
+	if perr == nil {
+		return nil
+	}
+	return perr
+}
+
+// Excerpt returns the part of the payload surrounding the parse error, capped at max bytes,
+// and preceded by a line describing the error, so the payload can be kept for troubleshooting.
+func Excerpt(payload []byte, err error, max int) []byte {
+	var off int64
+	var perr *Error
+	if errors.As(err, &perr) {
+		off = perr.Offset
+	}
+
+	start, end := 0, len(payload)
+	if max > 0 && end > max {
+		// The window is centered on the error, so both the valid and the offending data are kept
+		start = int(off) - max/2
+		if start < 0 {
+			start = 0
+		}
+		if end = start + max; end > len(payload) {
+			end = len(payload)
+			start = end - max
+		}
+	}
+
+	header := fmt.Sprintf("%v (bytes %d-%d of %d)\n", err, start, end, len(payload))
+	return append([]byte(header), payload[start:end]...)
+}
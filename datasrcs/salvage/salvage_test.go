@@ -0,0 +1,209 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package salvage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func names(v interface{}) []string {
+	var list []string
+
+	obj, _ := v.(map[string]interface{})
+	results, _ := obj["results"].([]interface{})
+	for _, r := range results {
+		if m, ok := r.(map[string]interface{}); ok {
+			name, _ := m["name"].(string)
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		fixture string
+		names   []string
+	}{
+		// The entry cut off by the truncation is dropped
+		{"truncated.json", []string{"www.example.com", "mail.example.com"}},
+		// The entries following the corruption cannot be reached
+		{"corrupted.json", []string{"www.example.com", "mail.example.com"}},
+	}
+
+	for _, test := range tests {
+		data := fixture(t, test.fixture)
+
+		v, err := JSON(data)
+		var perr *Error
+		if !errors.As(err, &perr) {
+			t.Fatalf("%s: the parse error %v was not reported", test.fixture, err)
+		}
+		if perr.Offset <= 0 || perr.Offset > int64(len(data)) {
+			t.Errorf("%s: the parse error has the offset %d", test.fixture, perr.Offset)
+		}
+		if got := names(v); !reflect.DeepEqual(got, test.names) {
+			t.Errorf("%s: the entries %v were salvaged, expected %v", test.fixture, got, test.names)
+		}
+		if count, _ := v.(map[string]interface{})["count"].(float64); count == 0 {
+			t.Errorf("%s: the member preceding the entries was lost", test.fixture)
+		}
+	}
+
+	// The valid documents are parsed like json.Unmarshal
+	for _, doc := range []string{`{"a":[1,2,{"b":null}],"c":"d"}`, `[]`, `"text"`, `3.5`} {
+		var want interface{}
+		if err := json.Unmarshal([]byte(doc), &want); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := JSON([]byte(doc)); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s was parsed as %v with the error %v", doc, got, err)
+		}
+	}
+	if _, err := JSON([]byte(`{"a":1} {"b":2}`)); err == nil {
+		t.Error("the data following the document was accepted")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	type result struct {
+		Name string `json:"name"`
+		Addr string `json:"addr"`
+	}
+	var resp struct {
+		Count   int      `json:"count"`
+		Results []result `json:"results"`
+	}
+
+	err := Unmarshal(fixture(t, "truncated.json"), &resp)
+	if err == nil {
+		t.Fatal("the truncated document was parsed without an error")
+	}
+	expected := []result{{"www.example.com", "192.0.2.1"}, {"mail.example.com", "192.0.2.2"}}
+	if resp.Count != 4 || !reflect.DeepEqual(resp.Results, expected) {
+		t.Errorf("the document was salvaged as %+v", resp)
+	}
+
+	// The errors other than the parse errors are returned as they are
+	var num int
+	if err := Unmarshal([]byte(`"text"`), &num); err == nil || errors.As(err, new(*Error)) {
+		t.Errorf("the type error was returned as %v", err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	var got []string
+	err := Lines(bytes.NewReader(fixture(t, "corrupted.ndjson")), func(line []byte) error {
+		var r struct {
+			Name string `json:"rrname"`
+		}
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		got = append(got, r.Name)
+		return nil
+	})
+
+	expected := []string{"www.example.com", "ftp.example.com", "vpn.example.com"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("the lines %v were parsed, expected %v", got, expected)
+	}
+
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Entries != 2 {
+		t.Fatalf("the corrupted lines were reported as %v", err)
+	}
+	// The first corrupted line follows the first line
+	if want := int64(len(`{"rrname":"www.example.com","rdata":"192.0.2.1"}`) + 1); perr.Offset != want {
+		t.Errorf("the first corrupted line is at the offset %d, expected %d", perr.Offset, want)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	payload := []byte(strings.Repeat("a", 100) + "!" + strings.Repeat("b", 100))
+	err := &Error{Offset: 100, Entries: 1, Err: errors.New("invalid character '!'")}
+
+	excerpt := Excerpt(payload, err, 20)
+	header, body, _ := bytes.Cut(excerpt, []byte("\n"))
+	if want := strings.Repeat("a", 10) + "!" + strings.Repeat("b", 9); string(body) != want {
+		t.Errorf("the excerpt %q does not surround the error", body)
+	}
+	if !bytes.Contains(header, []byte("bytes 90-110 of 201")) {
+		t.Errorf("the excerpt header %q does not locate the excerpt", header)
+	}
+
+	if _, body, _ := bytes.Cut(Excerpt([]byte("short"), err, 20), []byte("\n")); string(body) != "short" {
+		t.Errorf("the short payload was kept as %q", body)
+	}
+}
+
+type recorder struct {
+	names     []string
+	sources   []string
+	fragments [][]byte
+}
+
+func (r *recorder) Add(name, source string, fragment []byte) (string, error) {
+	r.names = append(r.names, name)
+	r.sources = append(r.sources, source)
+	r.fragments = append(r.fragments, fragment)
+	return "", nil
+}
+
+func TestCounterRecord(t *testing.T) {
+	data := fixture(t, "truncated.json")
+	_, err := JSON(data)
+
+	for _, keep := range []bool{false, true} {
+		cfg := config.NewConfig()
+		cfg.Options["evidence"] = map[string]interface{}{"enabled": true, "parse_errors": keep, "parse_error_size": 64}
+
+		rec := &recorder{}
+		job := requests.NewJob("test", cfg, nil)
+		job.Evidence = rec
+		ctx := requests.WithJob(context.Background(), job)
+
+		c := NewCounter("Source")
+		c.Record(ctx, cfg, "example.com", data, err)
+		c.Record(ctx, cfg, "example.com", data, &Error{Offset: 1, Entries: 3, Err: errors.New("bad lines")})
+		c.Record(ctx, cfg, "example.com", data, nil)
+
+		if got := c.Count(); got.Source != "Source" || got.Responses != 2 || got.Entries != 4 || !strings.Contains(got.LastError, "bad lines") {
+			t.Errorf("the parse errors were counted as %+v", got)
+		}
+
+		if !keep {
+			if len(rec.names) > 0 {
+				t.Error("the payloads were stored without the parse_errors option")
+			}
+			continue
+		}
+		if len(rec.names) != 2 || rec.names[0] != "example.com" || rec.sources[0] != "Source (parse error)" {
+			t.Fatalf("the payloads were stored as %v from %v", rec.names, rec.sources)
+		}
+		if _, body, _ := bytes.Cut(rec.fragments[0], []byte("\n")); len(body) != 64 || !bytes.HasSuffix(data, body) {
+			t.Errorf("the stored payload %q was not capped around the error", body)
+		}
+	}
+}
@@ -5,6 +5,8 @@
 package scripting
 
 import (
+	"context"
+
 	"github.com/caffix/service"
 	"github.com/owasp-amass/amass/v4/format"
 	"github.com/owasp-amass/config/config"
@@ -14,6 +16,12 @@ import (
 // Wrapper so that scripts can obtain the configuration for the current enumeration.
 func (s *Script) config(L *lua.LState) int {
 	cfg := s.sys.Config()
+	// The context argument is optional, since the function is also called from the start callback
+	if ud, ok := L.Get(1).(*lua.LUserData); ok {
+		if ctx, err := extractContext(ud); err == nil {
+			cfg = s.jobConfig(ctx)
+		}
+	}
 
 	r := L.NewTable()
 	r.RawSetString("version", lua.LString(format.Version))
@@ -123,7 +131,16 @@ func (s *Script) dataSourceConfig(L *lua.LState) int {
 		tb.RawSetString("ttl", lua.LNumber(cfg.TTL))
 	}
 
-	if creds := dsc.GetCredentials(cfg.Name); creds != nil {
+	creds := dsc.GetCredentials(cfg.Name)
+	if s.keys != nil {
+		var idx int
+
+		idx, creds = s.keys.acquire()
+		if c := s.sys.Config(); c.Verbose && idx >= 0 {
+			c.Log.Printf("%s: using API key %d", s.String(), idx)
+		}
+	}
+	if creds != nil {
 		c := L.NewTable()
 
 		c.RawSetString("name", lua.LString(creds.Name))
@@ -150,8 +167,8 @@ func (s *Script) dataSourceConfig(L *lua.LState) int {
 func (s *Script) inScope(L *lua.LState) int {
 	result := lua.LFalse
 
-	if _, err := extractContext(L.CheckUserData(1)); err == nil {
-		if sub := L.CheckString(2); sub != "" && s.sys.Config().IsDomainInScope(sub) {
+	if ctx, err := extractContext(L.CheckUserData(1)); err == nil {
+		if sub := L.CheckString(2); sub != "" && s.jobConfig(ctx).IsDomainInScope(sub) {
 			result = lua.LTrue
 		}
 	}
@@ -163,8 +180,8 @@ func (s *Script) inScope(L *lua.LState) int {
 func (s *Script) bruteWordlist(L *lua.LState) int {
 	tb := L.NewTable()
 
-	if _, err := extractContext(L.CheckUserData(1)); err == nil {
-		for _, word := range s.sys.Config().Wordlist {
+	if ctx, err := extractContext(L.CheckUserData(1)); err == nil {
+		for _, word := range s.jobConfig(ctx).Wordlist {
 			tb.Append(lua.LString(word))
 		}
 	}
@@ -177,8 +194,8 @@ func (s *Script) bruteWordlist(L *lua.LState) int {
 func (s *Script) altWordlist(L *lua.LState) int {
 	tb := L.NewTable()
 
-	if _, err := extractContext(L.CheckUserData(1)); err == nil {
-		for _, word := range s.sys.Config().AltWordlist {
+	if ctx, err := extractContext(L.CheckUserData(1)); err == nil {
+		for _, word := range s.jobConfig(ctx).AltWordlist {
 			tb.Append(lua.LString(word))
 		}
 	}
@@ -193,15 +210,18 @@ func (s *Script) setRateLimit(L *lua.LState) int {
 	return 0
 }
 
-func numRateLimitChecks(srv service.Service, num int) {
+func numRateLimitChecks(ctx context.Context, srv service.Service, num int) {
 	for i := 0; i < num; i++ {
+		if contextExpired(ctx) {
+			return
+		}
 		srv.CheckRateLimit()
 	}
 }
 
 // Wrapper so scripts can block until past the data source rate limit.
 func (s *Script) checkRateLimit(L *lua.LState) int {
-	numRateLimitChecks(s, s.seconds)
+	numRateLimitChecks(s.ctx, s, s.seconds)
 	return 0
 }
 
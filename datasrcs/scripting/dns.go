@@ -16,6 +16,7 @@ import (
 	"github.com/miekg/dns"
 	amassnet "github.com/owasp-amass/amass/v4/net"
 	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/policy"
 	"github.com/owasp-amass/amass/v4/requests"
 	"github.com/owasp-amass/resolve"
 	bf "github.com/tylertreat/BoomFilters"
@@ -92,6 +93,10 @@ func (s *Script) resolve(L *lua.LState) int {
 }
 
 func (s *Script) fwdQuery(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	if s.policy(ctx).BlocksName(policy.DNS, name) {
+		return nil, policy.ErrBlocked
+	}
+
 	msg := resolve.QueryMsg(name, qtype)
 	resp, err := s.dnsQuery(ctx, msg, s.sys.Resolvers(), 5)
 	if err != nil {
@@ -173,7 +178,7 @@ func (s *Script) reverseSweep(L *lua.LState) int {
 	}
 
 	size := defaultSweepSize
-	if s.sys.Config().Active {
+	if s.jobConfig(ctx).Active {
 		size = activeSweepSize
 	}
 
@@ -198,6 +203,7 @@ func (s *Script) reverseSweep(L *lua.LState) int {
 	}
 
 	var count int
+	pol := s.policy(ctx)
 	for _, ip := range amassnet.CIDRSubset(cidr, addr, size) {
 		select {
 		case <-ctx.Done():
@@ -205,9 +211,13 @@ func (s *Script) reverseSweep(L *lua.LState) int {
 			return 1
 		default:
 		}
+		// The addresses on the never-touch list are skipped, even when the netblock is in scope
+		if pol.BlocksAddress(policy.DNS, ip.String()) {
+			continue
+		}
 
 		sweepLock.Lock()
-		if a := ip.String(); !sweepFilter.TestAndAdd([]byte(a)) {
+		if a := ip.String(); !sweepFilter.TestAndAdd([]byte(jobKey(ctx, a))) {
 			count++
 			<-sweepMaxCh
 			go s.getPTR(ctx, a, sweepMaxCh)
@@ -264,7 +274,12 @@ func (s *Script) zoneWalk(L *lua.LState) int {
 		return 1
 	}
 
-	domain := s.sys.Config().WhichDomain(name)
+	if pol := s.policy(ctx); pol.BlocksName(policy.DNS, name) || pol.BlocksName(policy.DNS, server) {
+		L.Push(lua.LString("the zone walk of " + name + " is blocked by the never-touch list"))
+		return 1
+	}
+
+	domain := s.jobConfig(ctx).WhichDomain(name)
 	if domain == "" {
 		L.Push(lua.LString("the name " + name + " was not in scope"))
 		return 1
@@ -284,11 +299,11 @@ func (s *Script) zoneWalk(L *lua.LState) int {
 	for _, nsec := range names {
 		name := resolve.RemoveLastDot(nsec.NextDomain)
 
-		if domain := s.sys.Config().WhichDomain(name); domain != "" {
-			s.Output() <- &requests.DNSRequest{
+		if domain := s.jobConfig(ctx).WhichDomain(name); domain != "" {
+			s.sendOutput(ctx, &requests.DNSRequest{
 				Name:   name,
 				Domain: domain,
-			}
+			})
 		}
 	}
 
@@ -318,7 +333,13 @@ func (s *Script) wrapZoneTransfer(L *lua.LState) int {
 		return 2
 	}
 
-	domain := s.sys.Config().WhichDomain(name)
+	if pol := s.policy(ctx); pol.BlocksName(policy.DNS, name) || pol.BlocksName(policy.DNS, server) {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("the zone transfer of " + name + " is blocked by the never-touch list"))
+		return 2
+	}
+
+	domain := s.jobConfig(ctx).WhichDomain(name)
 	if domain == "" {
 		L.Push(lua.LNil)
 		L.Push(lua.LString("the name " + name + " was not in scope"))
@@ -338,12 +359,12 @@ func (s *Script) wrapZoneTransfer(L *lua.LState) int {
 			// Zone Transfers can reveal DNS wildcards
 			if n := amassdns.RemoveAsteriskLabel(req.Name); len(n) < len(req.Name) {
 				// Signal the wildcard discovery
-				s.Output() <- &requests.DNSRequest{
+				s.sendOutput(ctx, &requests.DNSRequest{
 					Name:   "www." + n,
 					Domain: req.Domain,
-				}
+				})
 			} else {
-				s.Output() <- req
+				s.sendOutput(ctx, req)
 			}
 		}
 	}
@@ -0,0 +1,140 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// timestampLayouts are the formats of the dates provided by the data sources, tried in order.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02",
+}
+
+// Wrapper so that scripts can send what they learned about a discovered FQDN to Amass.
+// The second argument is a table with the name field, and the optional addrs, first_seen,
+// last_seen, ref and confidence fields. The optional third argument is the response fragment
+// that yielded the finding.
+func (s *Script) newFinding(L *lua.LState) int {
+	ctx, err := extractContext(L.CheckUserData(1))
+	if err != nil || contextExpired(ctx) {
+		return 0
+	}
+
+	tbl := L.CheckTable(2)
+	if f := s.tableToFinding(L, tbl); f != nil {
+		s.sendFinding(ctx, f, []byte(L.OptString(3, "")))
+	}
+	if raw, _ := getStringField(L, tbl, "name"); raw != "" {
+		if n, err := amassdns.NormalizeName(raw); err == nil {
+			s.certWildcard(ctx, n, []byte(L.OptString(3, "")))
+		}
+	}
+	return 0
+}
+
+func (s *Script) tableToFinding(L *lua.LState, tbl *lua.LTable) *requests.Finding {
+	raw, _ := getStringField(L, tbl, "name")
+	n, err := amassdns.NormalizeName(raw)
+	if err != nil || n == "" {
+		return nil
+	}
+
+	name := s.subre.FindString(n)
+	if name == "" {
+		return nil
+	}
+
+	f := &requests.Finding{
+		Name:      name,
+		FirstSeen: getTimeField(L, tbl, "first_seen"),
+		LastSeen:  getTimeField(L, tbl, "last_seen"),
+		Sources:   []string{s.String()},
+	}
+	f.Ref, _ = getStringField(L, tbl, "ref")
+	if c, ok := getNumberField(L, tbl, "confidence"); ok && c > 0 {
+		f.Confidence = c
+		if f.Confidence > 1 {
+			f.Confidence = 1
+		}
+	}
+	if addrs, ok := L.GetField(tbl, "addrs").(*lua.LTable); ok {
+		addrs.ForEach(func(_, v lua.LValue) {
+			if a, ok := v.(lua.LString); ok && a != "" {
+				f.Addresses = append(f.Addresses, string(a))
+			}
+		})
+	}
+	return f
+}
+
+// sendFinding merges the finding of the in scope name into the job, before submitting the name and its addresses.
+func (s *Script) sendFinding(ctx context.Context, f *requests.Finding, fragment []byte) {
+	if s.jobConfig(ctx).WhichDomain(f.Name) == "" {
+		return
+	}
+
+	if job := requests.JobFromContext(ctx); job != nil {
+		job.Findings.Add(f)
+	}
+
+	s.newNameWithContext(ctx, f.Name, fragment)
+	for _, addr := range f.Addresses {
+		s.sendAddr(ctx, addr, f.Name)
+	}
+}
+
+// getTimeField returns the date held by the field, which is either a string or the seconds since the
+// Unix epoch. Timestamps too large to be in seconds are taken as milliseconds.
+func getTimeField(L *lua.LState, t lua.LValue, key string) time.Time {
+	var ts time.Time
+
+	switch v := L.GetField(t, key).(type) {
+	case lua.LNumber:
+		ts = unixTime(float64(v))
+	case lua.LString:
+		ts = parseTimestamp(string(v))
+	}
+	return ts
+}
+
+func unixTime(n float64) time.Time {
+	if n <= 0 {
+		return time.Time{}
+	}
+	// Dates beyond the year 5138 in seconds are not plausible, so those values are in milliseconds
+	if n >= 1e11 {
+		return time.UnixMilli(int64(n)).UTC()
+	}
+	return time.Unix(int64(n), 0).UTC()
+}
+
+func parseTimestamp(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return unixTime(n)
+	}
+
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
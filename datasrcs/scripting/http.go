@@ -6,15 +6,23 @@ package scripting
 
 import (
 	"context"
+	"encoding/pem"
+	"io"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/owasp-amass/amass/v4/net/dns"
 	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/requests"
 	lua "github.com/yuin/gopher-lua"
 )
 
+// jsFileSource tags the evidence of the names found in the scripts fetched by the crawls.
+const jsFileSource = "JSFile"
+
 // Wrapper that allows scripts to make HTTP client requests.
 func (s *Script) request(L *lua.LState) int {
 	ctx, err := extractContext(L.CheckUserData(1))
@@ -57,10 +65,11 @@ func (s *Script) request(L *lua.LState) int {
 
 	id, _ := getStringField(L, opt, "id")
 	pass, _ := getStringField(L, opt, "pass")
+	static, _ := getBoolField(L, opt, "static_user_agent")
 	resp, err := s.req(ctx, url, body, hdr, &http.BasicAuth{
 		Username: id,
 		Password: pass,
-	})
+	}, static)
 
 	if err != nil || resp == nil {
 		L.Push(lua.LNil)
@@ -169,12 +178,13 @@ func (s *Script) scrape(L *lua.LState) int {
 
 	id, _ := getStringField(L, opt, "id")
 	pass, _ := getStringField(L, opt, "pass")
+	static, _ := getBoolField(L, opt, "static_user_agent")
 
 	sucess := lua.LFalse
 	if resp, err := s.req(ctx, url, body, hdr, &http.BasicAuth{
 		Username: id,
 		Password: pass,
-	}); err == nil {
+	}, static); err == nil {
 		if resp != nil && resp.StatusCode >= 200 && resp.StatusCode < 400 {
 			if num := s.internalSendNames(ctx, resp.Body); num > 0 {
 				sucess = lua.LTrue
@@ -188,23 +198,48 @@ func (s *Script) scrape(L *lua.LState) int {
 	return 1
 }
 
-func (s *Script) req(ctx context.Context, url, data string, hdr http.Header, auth *http.BasicAuth) (*http.Response, error) {
+func (s *Script) req(ctx context.Context, url, data string, hdr http.Header, auth *http.BasicAuth, static bool) (*http.Response, error) {
 	method := "GET"
 	if data != "" {
 		method = "POST"
 	}
+	// Headers from the configuration are injected on top of those provided by the script
+	h := make(http.Header)
+	for k, v := range hdr {
+		h[k] = v
+	}
 
-	numRateLimitChecks(s, s.seconds)
-	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
-	defer cancel()
-
-	resp, err := http.RequestWebPage(ctx, &http.Request{
+	timeout := 20 * time.Second
+	r := &http.Request{
 		URL:    url,
 		Method: method,
-		Header: hdr,
 		Body:   data,
 		Auth:   auth,
-	})
+	}
+	if ho := s.httpOpts; ho != nil {
+		for k, v := range ho.header(queryDomain(ctx)) {
+			h[k] = v
+		}
+		r.UserAgents = ho.userAgents
+
+		r.MaxBodySize = ho.maxBodySize
+		r.MaxRedirects = ho.maxRedirects
+		if ho.timeout > 0 {
+			r.Timeout = ho.timeout
+			timeout = ho.timeout
+		}
+	}
+	r.Header = h
+	r.StaticUserAgent = static
+
+	numRateLimitChecks(ctx, s, s.seconds)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := http.RequestWebPage(ctx, r)
+	if resp != nil && resp.Truncated {
+		s.sys.Config().Log.Printf("%s: %s: the response was truncated by the size or duration limits", s.String(), url)
+	}
 	if err != nil {
 		cfg := s.sys.Config()
 
@@ -212,35 +247,92 @@ func (s *Script) req(ctx context.Context, url, data string, hdr http.Header, aut
 			cfg.Log.Printf("%s: %s: %v", s.String(), url, err)
 		}
 	}
+	if resp != nil && s.quota != nil {
+		s.updateQuota(resp)
+	}
+	if resp != nil && s.keys != nil {
+		rejected := keyRejected(resp.StatusCode)
+
+		if idx := s.keys.carried(r); idx >= 0 {
+			s.keys.used(idx, rejected)
+			if cfg := s.sys.Config(); rejected {
+				cfg.Log.Printf("%s: API key %d was rejected with status %d and is benched for %v", s.String(), idx, resp.StatusCode, keyCooldown)
+			} else if cfg.Verbose {
+				cfg.Log.Printf("%s: %s: served by API key %d", s.String(), url, idx)
+			}
+		}
+	}
 	return resp, err
 }
 
+// quotaHeaders are the response headers used by providers to report the remaining API quota.
+var quotaHeaders = []string{"X-RateLimit-Remaining", "RateLimit-Remaining", "X-Quota-Remaining"}
+
+func (s *Script) updateQuota(resp *http.Response) {
+	name := s.String()
+	if err := s.quota.Record(name, 1); err != nil {
+		s.sys.Config().Log.Printf("%s: %v", name, err)
+	}
+
+	for k, v := range resp.Header {
+		for _, qh := range quotaHeaders {
+			if !strings.EqualFold(k, qh) {
+				continue
+			}
+			if remaining, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				_ = s.quota.Correct(name, remaining)
+			}
+			return
+		}
+	}
+}
+
 // Wrapper so that scripts can crawl for subdomain names in scope.
 func (s *Script) crawl(L *lua.LState) int {
-	cfg := s.sys.Config()
 	ctx, err := extractContext(L.CheckUserData(1))
 	if err != nil {
 		return 0
 	}
+	cfg := s.jobConfig(ctx)
 
 	u := L.CheckString(2)
 	if u == "" {
 		return 0
 	}
 
-	max := L.CheckInt(3)
+	opts, scripts := crawlOptions(cfg, L.CheckInt(3))
+	// The hosts on the never-touch list are never requested, even when they are in scope
+	if pol := s.policy(ctx); pol != nil {
+		opts.Blocked = func(host string) bool {
+			return pol.BlocksName(policy.Web, host)
+		}
+	}
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancel()
+	// The scripts of the landing page embed the hostnames of the APIs used by the web applications
+	if scripts {
+		opts.Script = func(scriptURL string, body io.Reader) {
+			s.scriptNames(ctx, scriptURL, body)
+		}
+	}
 
-	err = http.Crawl(ctx, u, cfg.Domains(), max, func(req *http.Request, resp *http.Response) {
+	err = http.CrawlWithOptions(ctx, u, cfg.Domains(), opts, func(req *http.Request, resp *http.Response) {
 		if u, err := url.Parse(req.URL); err == nil {
-			s.newNameWithContext(ctx, http.CleanName(u.Hostname()))
+			s.newNameWithContext(ctx, http.CleanName(u.Hostname()), []byte(req.URL))
 		}
 		s.internalSendNames(ctx, resp.Body)
 
 		if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
-			for _, name := range http.NamesFromCert(resp.TLS.PeerCertificates[0]) {
-				s.newNameWithContext(ctx, http.CleanName(name))
+			cert := resp.TLS.PeerCertificates[0]
+			// The certificate is kept as the evidence of the names it contains
+			fragment := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+			for _, name := range http.NamesFromCert(cert) {
+				s.newDerivedName(ctx, http.CleanName(name), queryName(ctx), requests.DerivedFromCert, fragment)
+			}
+			// The wildcard entries prove the zones exist, even when none of their names are known
+			for _, wildcard := range http.WildcardsFromCert(cert) {
+				s.newDerivedName(ctx, wildcard, queryName(ctx), requests.DerivedFromCert, fragment)
 			}
 		}
 		for k, v := range resp.Header {
@@ -257,3 +349,22 @@ func (s *Script) crawl(L *lua.LState) int {
 	}
 	return 0
 }
+
+// scriptNames submits the in scope names found in the script, which reference the script URL as their
+// provenance. The evidence of each name holds the script URL and the text surrounding the name.
+func (s *Script) scriptNames(ctx context.Context, scriptURL string, body io.Reader) {
+	seen := make(map[string]struct{})
+
+	err := http.StreamMatches(http.NewTextReader(body), s.subre, func(match, text string) {
+		name := http.CleanDecodedName(match)
+		if _, found := seen[name]; found || name == "" {
+			return
+		}
+
+		seen[name] = struct{}{}
+		s.newNameFrom(ctx, name, scriptURL, requests.DerivedFromJSFile, jsFileSource, []byte(scriptURL+"\n"+text))
+	})
+	if err != nil && s.jobConfig(ctx).Verbose {
+		s.jobConfig(ctx).Log.Printf("%s: %s: %v", s.String(), scriptURL, err)
+	}
+}
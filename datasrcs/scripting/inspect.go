@@ -0,0 +1,100 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Info describes what a script would do when started, without starting it.
+type Info struct {
+	Name string
+	Type string
+	// Ready is true when the check callback accepts the configuration, such as the required credentials
+	Ready bool
+	// Reason explains why the script would not start
+	Reason string
+	// Endpoints holds the hosts named by the URLs within the script
+	Endpoints []string
+}
+
+// networkGlobals are the script functions that send traffic, which are disabled while inspecting.
+var networkGlobals = []string{
+	"request", "scrape", "crawl", "resolve", "reverse_sweep", "zone_walk", "zone_transfer", luaSocketTypeName,
+}
+
+var urlHostRE = regexp.MustCompile(`https?://([a-zA-Z0-9][a-zA-Z0-9.-]*\.[a-zA-Z]{2,})`)
+
+// Inspect loads the script and runs its check callback against the configuration, with all the
+// functions that send traffic disabled, to learn whether it would start and which hosts it contacts.
+func Inspect(script string, cfg *config.Config) (*Info, error) {
+	s := loadScript(script, &systems.SimpleSystem{Cfg: cfg})
+	if s == nil {
+		return nil, errors.New("failed to load the script")
+	}
+	defer func() {
+		s.cancel()
+		s.luaState.Close()
+	}()
+
+	L := s.luaState
+	for _, name := range networkGlobals {
+		L.SetGlobal(name, L.NewFunction(func(L *lua.LState) int {
+			L.RaiseError("network activity is disabled while inspecting the script")
+			return 0
+		}))
+	}
+
+	info := &Info{
+		Name:      s.String(),
+		Type:      s.SourceType,
+		Ready:     true,
+		Endpoints: scriptEndpoints(script),
+	}
+	if s.cbs.Check.Type() == lua.LTNil {
+		return info, nil
+	}
+
+	if err := L.CallByParam(lua.P{
+		Fn:      s.cbs.Check,
+		NRet:    1,
+		Protect: true,
+	}); err != nil {
+		info.Ready = false
+		info.Reason = "the check callback failed: " + err.Error()
+		return info, nil
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	if passed, ok := ret.(lua.LBool); !ok || !bool(passed) {
+		info.Ready = false
+		info.Reason = "the check callback failed for the configuration, such as missing credentials"
+	}
+	return info, nil
+}
+
+func scriptEndpoints(script string) []string {
+	seen := make(map[string]struct{})
+
+	var hosts []string
+	for _, m := range urlHostRE.FindAllStringSubmatch(script, -1) {
+		host := strings.ToLower(m[1])
+
+		if _, found := seen[host]; !found {
+			seen[host] = struct{}{}
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,86 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		ready     bool
+		endpoints []string
+	}{
+		{
+			name: "no check",
+			script: `
+				name = "Open"
+				type = "api"
+
+				function vertical(ctx, domain)
+					request(ctx, {['url']="https://API.example.com/v1/" .. domain})
+					request(ctx, {['url']="http://backup.example.org"})
+				end
+			`,
+			ready:     true,
+			endpoints: []string{"api.example.com", "backup.example.org"},
+		},
+		{
+			name: "missing credentials",
+			script: `
+				name = "Keyed"
+				type = "api"
+
+				function check()
+					local cfg = datasrc_config()
+					return cfg ~= nil and cfg.credentials ~= nil
+				end
+			`,
+			ready: false,
+		},
+		{
+			name: "network in check",
+			script: `
+				name = "Probe"
+				type = "api"
+
+				function check()
+					local resp, err = request(nil, {['url']="https://probe.example.com"})
+					return err == nil
+				end
+			`,
+			ready:     false,
+			endpoints: []string{"probe.example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		info, err := Inspect(test.script, config.NewConfig())
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if info.Ready != test.ready {
+			t.Errorf("%s: the script was ready: %t, expected %t (%s)", test.name, info.Ready, test.ready, info.Reason)
+		}
+		if !info.Ready && info.Reason == "" {
+			t.Errorf("%s: no reason was provided for the script not being ready", test.name)
+		}
+		if len(info.Endpoints) > 0 || len(test.endpoints) > 0 {
+			if !reflect.DeepEqual(info.Endpoints, test.endpoints) {
+				t.Errorf("%s: found the endpoints %v, expected %v", test.name, info.Endpoints, test.endpoints)
+			}
+		}
+	}
+
+	if _, err := Inspect(`type = "api"`, config.NewConfig()); err == nil {
+		t.Error("the script without a name was inspected")
+	}
+}
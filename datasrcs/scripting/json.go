@@ -0,0 +1,105 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
+	lua "github.com/yuin/gopher-lua"
+	luajson "layeh.com/gopher-json"
+)
+
+// jsonLoader loads the json module of the scripts. Its decode functions salvage the entries of the responses
+// that are truncated or corrupted, and count the parse errors of the script, rather than failing the response.
+func (s *Script) jsonLoader(L *lua.LState) int {
+	L.Push(L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"decode":       s.jsonDecode,
+		"decode_lines": s.jsonDecodeLines,
+		"encode":       jsonEncode,
+	}))
+	return 1
+}
+
+// Wrapper so that scripts can decode the JSON responses. A response parsed in part returns the
+// salvaged value along with the error message, and nil is returned when nothing could be salvaged.
+func (s *Script) jsonDecode(L *lua.LState) int {
+	str := L.CheckString(1)
+
+	v, err := salvage.JSON([]byte(str))
+	if err != nil {
+		s.parseError([]byte(str), err)
+	}
+
+	if err != nil && v == nil {
+		L.Push(lua.LNil)
+	} else {
+		L.Push(luajson.DecodeValue(L, v))
+	}
+	if err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	return 1
+}
+
+// Wrapper so that scripts can decode the newline delimited JSON responses. It returns the list of the
+// decoded lines, skipping those that fail to parse, and the error message when lines were skipped.
+func (s *Script) jsonDecodeLines(L *lua.LState) int {
+	str := L.CheckString(1)
+
+	list := L.NewTable()
+	err := salvage.Lines(strings.NewReader(str), func(line []byte) error {
+		var v interface{}
+		if err := json.Unmarshal(line, &v); err != nil {
+			return err
+		}
+
+		list.Append(luajson.DecodeValue(L, v))
+		return nil
+	})
+
+	L.Push(list)
+	if err != nil {
+		s.parseError([]byte(str), err)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	return 1
+}
+
+func jsonEncode(L *lua.LState) int {
+	data, err := luajson.Encode(L.CheckAny(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LString(string(data)))
+	return 1
+}
+
+// parseError counts the response the script failed to parse in full. The scripts decode the responses
+// without passing a context, so the context of the callback being run identifies the query.
+func (s *Script) parseError(payload []byte, err error) {
+	ctx := s.callCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	query := queryName(ctx)
+	if query == "" {
+		query = queryDomain(ctx)
+	}
+	s.parseErrs.Record(ctx, s.jobConfig(ctx), query, payload, err)
+}
+
+// ParseErrors implements the salvage.Reporter interface.
+func (s *Script) ParseErrors() salvage.Count {
+	return s.parseErrs.Count()
+}
@@ -0,0 +1,76 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caffix/stringset"
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+func TestJSONSalvage(t *testing.T) {
+	expected := stringset.New("www.owasp.org", "mail.owasp.org", "ftp.owasp.org", "vpn.owasp.org", "done.owasp.org")
+	defer expected.Close()
+
+	script, sys := setupMockScriptEnv(`
+		name="salvage"
+		type="testing"
+
+		local json = require("json")
+
+		function vertical(ctx, domain)
+			local truncated = [[{"results":[{"name":"www.owasp.org"},{"name":"mail.owasp.org"},{"name":"dev.ow]]
+			local d, err = json.decode(truncated)
+			if err ~= nil and d ~= nil then
+				for _, r in pairs(d.results) do
+					new_name(ctx, r.name)
+				end
+			end
+
+			local lines = '{"name":"ftp.owasp.org"}\n{"name":"api.ow\n{"name":"vpn.owasp.org"}\n'
+			local list, lerr = json.decode_lines(lines)
+			if lerr ~= nil then
+				for _, r in pairs(list) do
+					new_name(ctx, r.name)
+				end
+			end
+
+			if json.decode("<html>") == nil then
+				new_name(ctx, "done.owasp.org")
+			end
+		end
+	`)
+	if script == nil || sys == nil {
+		t.Fatal("failed to initialize the scripting environment")
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	sys.Config().AddDomain("owasp.org")
+	script.Input() <- &requests.DNSRequest{Domain: "owasp.org"}
+
+	timer := time.NewTimer(15 * time.Second)
+	defer timer.Stop()
+
+	for l := expected.Len(); l > 0; l-- {
+		select {
+		case <-timer.C:
+			t.Fatalf("the salvaged names %v were not provided", expected.Slice())
+		case req := <-script.Output():
+			if d, ok := req.(*requests.DNSRequest); !ok || !expected.Has(d.Name) {
+				t.Errorf("%v was not salvaged from the responses", req)
+			} else {
+				expected.Remove(d.Name)
+			}
+		}
+	}
+
+	count := script.(salvage.Reporter).ParseErrors()
+	if count.Source != "salvage" || count.Responses != 3 || count.Entries != 3 {
+		t.Errorf("the parse errors were counted as %+v", count)
+	}
+}
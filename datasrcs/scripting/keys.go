@@ -0,0 +1,160 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/config/config"
+)
+
+// keyCooldown is the amount of time an API key is benched after being rejected by the provider.
+const keyCooldown = 5 * time.Minute
+
+// KeyUsage reports how a single API key of a data source has been used.
+type KeyUsage struct {
+	Index    int
+	Requests int
+	Failures int
+	Benched  bool
+}
+
+type apiKey struct {
+	creds    *config.Credentials
+	until    time.Time
+	requests int
+	failures int
+}
+
+// keyManager rotates the credentials of a data source in round-robin order,
+// skipping the keys that are benched after authentication or rate limit errors.
+type keyManager struct {
+	sync.Mutex
+	keys []*apiKey
+	next int
+	now  func() time.Time
+}
+
+func newKeyManager(cfg *config.Config, source string) *keyManager {
+	ds := cfg.GetDataSourceConfig(source)
+	if ds == nil || len(ds.Creds) == 0 {
+		return nil
+	}
+	// Sort the account names so the key indices are stable across runs
+	var accounts []string
+	for account, creds := range ds.Creds {
+		if creds != nil {
+			accounts = append(accounts, account)
+		}
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+	sort.Strings(accounts)
+
+	km := &keyManager{now: time.Now}
+	for _, account := range accounts {
+		km.keys = append(km.keys, &apiKey{creds: ds.Creds[account]})
+	}
+	return km
+}
+
+// acquire returns the next available key and its index, or -1 when all the keys are benched.
+func (km *keyManager) acquire() (int, *config.Credentials) {
+	km.Lock()
+	defer km.Unlock()
+
+	now := km.now()
+	for i := 0; i < len(km.keys); i++ {
+		idx := (km.next + i) % len(km.keys)
+
+		if k := km.keys[idx]; !now.Before(k.until) {
+			km.next = (idx + 1) % len(km.keys)
+			return idx, k.creds
+		}
+	}
+	return -1, nil
+}
+
+// carried returns the index of the key whose secrets appear in the request, or -1 when it carries none.
+// The key is found in the request, since the scripts acquire keys that are never sent, such as in check.
+func (km *keyManager) carried(r *http.Request) int {
+	var parts []string
+	parts = append(parts, r.URL, r.Body)
+	for k, v := range r.Header {
+		parts = append(parts, k, v)
+	}
+	if r.Auth != nil {
+		parts = append(parts, r.Auth.Username, r.Auth.Password)
+	}
+	content := strings.Join(parts, "\n")
+
+	for i, k := range km.keys {
+		for _, secret := range []string{k.creds.Apikey, k.creds.Secret, k.creds.Password} {
+			if secret != "" && strings.Contains(content, secret) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// used records a request served by the key at the index.
+// The key is benched for the cool-down period when the request was rejected.
+func (km *keyManager) used(idx int, rejected bool) {
+	km.Lock()
+	defer km.Unlock()
+
+	if idx < 0 || idx >= len(km.keys) {
+		return
+	}
+
+	k := km.keys[idx]
+	k.requests++
+	if rejected {
+		k.failures++
+		k.until = km.now().Add(keyCooldown)
+	}
+}
+
+// exhausted returns true when every key is currently benched.
+func (km *keyManager) exhausted() bool {
+	km.Lock()
+	defer km.Unlock()
+
+	now := km.now()
+	for _, k := range km.keys {
+		if !now.Before(k.until) {
+			return false
+		}
+	}
+	return true
+}
+
+func (km *keyManager) usage() []KeyUsage {
+	km.Lock()
+	defer km.Unlock()
+
+	now := km.now()
+	var results []KeyUsage
+	for i, k := range km.keys {
+		results = append(results, KeyUsage{
+			Index:    i,
+			Requests: k.requests,
+			Failures: k.failures,
+			Benched:  now.Before(k.until),
+		})
+	}
+	return results
+}
+
+// keyRejected returns true when the status code indicates the provider refused the API key.
+func keyRejected(code int) bool {
+	return code == 401 || code == 403 || code == 429
+}
@@ -0,0 +1,147 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestKeyManagerRotation(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.DataSrcConfigs = &config.DataSourceConfig{
+		Datasources: []*config.DataSource{{
+			Name: "Shodan",
+			Creds: map[string]*config.Credentials{
+				"second": {Apikey: "key2"},
+				"first":  {Apikey: "key1"},
+				"third":  {Apikey: "key3"},
+			},
+		}},
+	}
+
+	if km := newKeyManager(cfg, "Unknown"); km != nil {
+		t.Errorf("A key manager was returned for a data source without credentials")
+	}
+
+	km := newKeyManager(cfg, "Shodan")
+	if km == nil {
+		t.Fatal("Failed to create the key manager")
+	}
+	now := time.Now()
+	km.now = func() time.Time { return now }
+
+	for i, expected := range []string{"key1", "key2", "key3", "key1"} {
+		idx, creds := km.acquire()
+		if creds == nil || creds.Apikey != expected || idx != i%3 {
+			t.Errorf("Acquire %d returned key %d, expected %s", i, idx, expected)
+		}
+		km.used(idx, false)
+	}
+	// Bench the second key and check that it is skipped
+	if idx, _ := km.acquire(); idx != 1 {
+		t.Fatalf("Expected the second key, got %d", idx)
+	}
+	km.used(1, true)
+	if idx, _ := km.acquire(); idx != 2 {
+		t.Errorf("Expected the third key, got %d", idx)
+	}
+	km.used(2, true)
+	if idx, _ := km.acquire(); idx != 0 {
+		t.Errorf("Expected the first key, got %d", idx)
+	}
+	km.used(0, true)
+
+	if !km.exhausted() {
+		t.Errorf("The key manager was not exhausted with all keys benched")
+	}
+	if idx, creds := km.acquire(); idx != -1 || creds != nil {
+		t.Errorf("A benched key was returned")
+	}
+
+	usage := km.usage()
+	if len(usage) != 3 || usage[0].Requests != 3 || usage[0].Failures != 1 || !usage[1].Benched {
+		t.Errorf("Unexpected key usage: %+v", usage)
+	}
+	// The keys return to the rotation after the cool-down period
+	now = now.Add(keyCooldown)
+	if km.exhausted() {
+		t.Errorf("The keys did not cool down")
+	}
+}
+
+func TestKeyMarkedAgainstCarriedKey(t *testing.T) {
+	served := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		served <- r.URL.Query().Get("key")
+	}))
+	defer ts.Close()
+
+	cfg := config.NewConfig()
+	cfg.DataSrcConfigs = &config.DataSourceConfig{
+		Datasources: []*config.DataSource{{
+			Name: "Keyed",
+			Creds: map[string]*config.Credentials{
+				"first":  {Apikey: "key1"},
+				"second": {Apikey: "key2"},
+			},
+		}},
+	}
+	sys := newMockSystem(cfg)
+	defer func() { _ = sys.Shutdown() }()
+	// The second key is acquired after the first, but the request carries the first
+	s := NewScript(fmt.Sprintf(`
+		name="Keyed"
+		type="testing"
+
+		function check()
+			return datasrc_config() ~= nil
+		end
+
+		function vertical(ctx, domain)
+			local first = datasrc_config()
+			local second = datasrc_config()
+			request(ctx, {url="%s/?key=" .. first.credentials.key})
+		end
+	`, ts.URL), sys)
+	if s == nil || sys.AddAndStart(s) != nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+
+	s.Input() <- &requests.DNSRequest{Domain: "owasp.org"}
+	var key string
+	select {
+	case key = <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The data source did not send the request")
+	}
+	// The usage is recorded once the response has been received
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if usage := s.KeyUsage(); usage[0].Requests+usage[1].Requests > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	usage := s.KeyUsage()
+	idx := 0
+	if key == "key2" {
+		idx = 1
+	}
+	if u := usage[idx]; u.Requests != 1 || u.Failures != 1 || !u.Benched {
+		t.Errorf("The rejection was not recorded against %s, which the request carried: %+v", key, usage)
+	}
+	if u := usage[1-idx]; u.Requests != 0 || u.Benched {
+		t.Errorf("The rejection was recorded against a key the request did not carry: %+v", usage)
+	}
+}
@@ -21,31 +21,83 @@ import (
 	"golang.org/x/net/publicsuffix"
 )
 
-func (s *Script) newNameWithContext(ctx context.Context, name string) {
-	if domain := s.sys.Config().WhichDomain(name); domain != "" {
+func (s *Script) newNameWithContext(ctx context.Context, name string, fragment []byte) {
+	parent, derivation := s.derivation(ctx)
+	s.newDerivedName(ctx, name, parent, derivation, fragment)
+}
+
+func (s *Script) newDerivedName(ctx context.Context, name, parent, derivation string, fragment []byte) {
+	s.newNameFrom(ctx, name, parent, derivation, s.String(), fragment)
+}
+
+// newNameFrom submits the in scope name, and stores its evidence tagged with the source that yielded it.
+func (s *Script) newNameFrom(ctx context.Context, name, parent, derivation, source string, fragment []byte) {
+	if domain := s.jobConfig(ctx).WhichDomain(name); domain != "" {
+		s.recordEvidence(ctx, name, source, fragment)
+
 		select {
 		case <-ctx.Done():
 		case <-s.Done():
-		case s.Output() <- &requests.DNSRequest{
-			Name:   name,
-			Domain: domain,
+		case s.output(ctx) <- &requests.DNSRequest{
+			Name:       name,
+			Domain:     domain,
+			Parent:     parent,
+			Derivation: derivation,
 		}:
 		}
 	}
 }
 
+// recordEvidence stores the response fragment that yielded the name, when the job has an evidence store.
+func (s *Script) recordEvidence(ctx context.Context, name, source string, fragment []byte) {
+	job := requests.JobFromContext(ctx)
+	if job == nil || job.Evidence == nil || len(fragment) == 0 {
+		return
+	}
+
+	if _, err := job.Evidence.Add(name, source, fragment); err != nil {
+		s.jobConfig(ctx).Log.Printf("%s: failed to store the evidence for %s: %v", s.String(), name, err)
+	}
+}
+
+// derivation returns the parent and derivation type of the names discovered by the script.
+// Names generated from an existing finding reference it, while the others come from the data source.
+func (s *Script) derivation(ctx context.Context) (string, string) {
+	switch s.SourceType {
+	case "alt":
+		return queryName(ctx), requests.DerivedFromAlteration
+	case "brute":
+		return queryName(ctx), requests.DerivedFromBrute
+	}
+	return s.String(), requests.DerivedFromSource
+}
+
 // Wrapper so that scripts can send a discovered FQDN to Amass.
+// The optional third argument is the response fragment that yielded the name.
 func (s *Script) newName(L *lua.LState) int {
 	if ctx, err := extractContext(L.CheckUserData(1)); err == nil && !contextExpired(ctx) {
-		if n := L.CheckString(2); n != "" {
+		if n, err := amassdns.NormalizeName(L.CheckString(2)); err == nil && n != "" {
 			if name := s.subre.FindString(n); name != "" {
-				s.newNameWithContext(ctx, name)
+				s.newNameWithContext(ctx, name, []byte(L.OptString(3, "")))
 			}
+			s.certWildcard(ctx, n, []byte(L.OptString(3, "")))
 		}
 	}
 	return 0
 }
 
+// certWildcard submits the zone of the wildcard entry returned by a certificate data source, since the
+// subdomain regular expression drops the asterisk label and the zone would pass as an ordinary name.
+func (s *Script) certWildcard(ctx context.Context, name string, fragment []byte) {
+	if s.SourceType != "cert" {
+		return
+	}
+
+	if zone := amassdns.WildcardZone(name); zone != "" {
+		s.newDerivedName(ctx, "*."+zone, s.String(), requests.DerivedFromCert, fragment)
+	}
+}
+
 // Wrapper so that scripts can send FQDNs found in the content to Amass.
 func (s *Script) sendNames(L *lua.LState) int {
 	var num int
@@ -63,17 +115,34 @@ func (s *Script) sendNames(L *lua.LState) int {
 func (s *Script) internalSendNames(ctx context.Context, content string) int {
 	filter := bf.NewDefaultStableBloomFilter(1000, 0.01)
 	defer filter.Reset()
+	// The names inside encoded URLs and escaped markup are decoded before they are matched
+	content = http.DecodeText(content)
 
 	var count int
-	for _, name := range s.subre.FindAllString(string(content), -1) {
-		if n := http.CleanName(name); n != "" && !filter.TestAndAdd([]byte(n)) {
-			s.newNameWithContext(ctx, n)
+	for _, loc := range s.subre.FindAllStringIndex(content, -1) {
+		if n := http.CleanDecodedName(content[loc[0]:loc[1]]); n != "" && !filter.TestAndAdd([]byte(n)) {
+			s.newNameWithContext(ctx, n, []byte(lineAt(content, loc[0], loc[1])))
 			count++
 		}
 	}
 	return count
 }
 
+// lineAt returns the line of the content holding the match, which is kept as the evidence of the name.
+func lineAt(content string, start, end int) string {
+	if i := strings.LastIndexByte(content[:start], '\n'); i >= 0 {
+		start = i + 1
+	} else {
+		start = 0
+	}
+	if i := strings.IndexByte(content[end:], '\n'); i >= 0 {
+		end += i
+	} else {
+		end = len(content)
+	}
+	return strings.TrimSpace(content[start:end])
+}
+
 func (s *Script) sendDNSRecords(L *lua.LState) int {
 	ctx, err := extractContext(L.CheckUserData(1))
 	if err != nil || contextExpired(ctx) {
@@ -117,11 +186,11 @@ func (s *Script) sendDNSRecords(L *lua.LState) int {
 }
 
 func (s *Script) internalSendDNSRecords(ctx context.Context, name string, records []requests.DNSAnswer) {
-	if domain := s.sys.Config().WhichDomain(name); domain != "" {
+	if domain := s.jobConfig(ctx).WhichDomain(name); domain != "" {
 		select {
 		case <-ctx.Done():
 		case <-s.Done():
-		case s.Output() <- &requests.DNSRequest{
+		case s.output(ctx) <- &requests.DNSRequest{
 			Name:    name,
 			Domain:  domain,
 			Records: records,
@@ -136,7 +205,7 @@ func (s *Script) newPTR(ctx context.Context, record *resolve.ExtractedAnswer) {
 		return
 	}
 	// Check that the name discovered is in scope
-	if d := s.sys.Config().WhichDomain(answer); d == "" {
+	if d := s.jobConfig(ctx).WhichDomain(answer); d == "" {
 		return
 	}
 
@@ -149,7 +218,7 @@ func (s *Script) newPTR(ctx context.Context, record *resolve.ExtractedAnswer) {
 	select {
 	case <-ctx.Done():
 	case <-s.Done():
-	case s.Output() <- &requests.DNSRequest{
+	case s.output(ctx) <- &requests.DNSRequest{
 		Name:   ptr,
 		Domain: domain,
 		Records: []requests.DNSAnswer{{
@@ -163,29 +232,36 @@ func (s *Script) newPTR(ctx context.Context, record *resolve.ExtractedAnswer) {
 
 // Wrapper so that scripts can send discovered IP addresses to Amass.
 func (s *Script) newAddr(L *lua.LState) int {
-	ip := net.ParseIP(L.CheckString(2))
+	addr := L.CheckString(2)
 
+	if ctx, err := extractContext(L.CheckUserData(1)); err == nil && !contextExpired(ctx) {
+		if name := L.CheckString(3); name != "" {
+			s.sendAddr(ctx, addr, name)
+		}
+	}
+	return 0
+}
+
+// sendAddr submits the address, which the name within scope was observed resolving to.
+func (s *Script) sendAddr(ctx context.Context, addr, name string) {
+	ip := net.ParseIP(addr)
 	if ip == nil {
-		return 0
+		return
 	}
 	if reserved, _ := amassnet.IsReservedAddress(ip.String()); reserved {
-		return 0
+		return
 	}
-	if ctx, err := extractContext(L.CheckUserData(1)); err == nil && !contextExpired(ctx) {
-		if name := L.CheckString(3); err == nil && name != "" {
-			if domain := s.sys.Config().WhichDomain(name); domain != "" {
-				select {
-				case <-ctx.Done():
-				case <-s.Done():
-				case s.Output() <- &requests.AddrRequest{
-					Address: ip.String(),
-					Domain:  domain,
-				}:
-				}
-			}
+
+	if domain := s.jobConfig(ctx).WhichDomain(name); domain != "" {
+		select {
+		case <-ctx.Done():
+		case <-s.Done():
+		case s.output(ctx) <- &requests.AddrRequest{
+			Address: ip.String(),
+			Domain:  domain,
+		}:
 		}
 	}
-	return 0
 }
 
 // Wrapper so that scripts can send discovered ASNs to Amass.
@@ -249,7 +325,7 @@ func (s *Script) associated(L *lua.LState) int {
 			select {
 			case <-ctx.Done():
 			case <-s.Done():
-			case s.Output() <- &requests.WhoisRequest{
+			case s.output(ctx) <- &requests.WhoisRequest{
 				Domain:     domain,
 				NewDomains: []string{assoc},
 			}:
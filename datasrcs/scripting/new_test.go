@@ -5,6 +5,8 @@
 package scripting
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -216,3 +218,232 @@ func TestAssociated(t *testing.T) {
 		}
 	}
 }
+
+func TestNewNameDerivation(t *testing.T) {
+	for _, tc := range []struct {
+		stype      string
+		parent     string
+		derivation string
+	}{
+		{"api", "derived_api", requests.DerivedFromSource},
+		{"alt", "www.owasp.org", requests.DerivedFromAlteration},
+		{"brute", "www.owasp.org", requests.DerivedFromBrute},
+	} {
+		script, sys := setupMockScriptEnv(`
+			name="derived_` + tc.stype + `"
+			type="` + tc.stype + `"
+
+			function resolved(ctx, name, domain, records)
+				new_name(ctx, "dev." .. name)
+			end
+		`)
+		if script == nil || sys == nil {
+			t.Fatal("Failed to initialize the scripting environment")
+		}
+
+		domain := "owasp.org"
+		sys.Config().AddDomain(domain)
+		script.Input() <- &requests.ResolvedRequest{
+			Name:    "www.owasp.org",
+			Domain:  domain,
+			Records: []requests.DNSAnswer{{Name: "www.owasp.org", Type: 1, Data: "192.168.1.1"}},
+		}
+
+		select {
+		case req := <-script.Output():
+			d, ok := req.(*requests.DNSRequest)
+			if !ok || d.Name != "dev.www.owasp.org" || d.Parent != tc.parent || d.Derivation != tc.derivation {
+				t.Errorf("%s: unexpected derivation of the name: %+v", tc.stype, req)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: the name was not sent", tc.stype)
+		}
+		_ = sys.Shutdown()
+	}
+}
+
+func TestJobIsolation(t *testing.T) {
+	script, sys := setupMockScriptEnv(`
+		name="jobs"
+		type="testing"
+
+		function vertical(ctx, domain)
+			new_name(ctx, "www.owasp.org")
+			new_name(ctx, "www.utica.edu")
+		end
+	`)
+	if script == nil || sys == nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	var jobs []*requests.Job
+	for i, domain := range []string{"owasp.org", "utica.edu"} {
+		cfg := config.NewConfig()
+		cfg.AddDomain(domain)
+
+		job := requests.NewJob(domain, cfg, []string{script.String()})
+		jobs = append(jobs, job)
+
+		ctx := requests.WithJob(context.Background(), job)
+		script.Input() <- requests.WithContext(ctx, script, &requests.DNSRequest{Name: domain, Domain: domain})
+
+		select {
+		case req := <-job.Output(script.String()):
+			if d, ok := req.(*requests.DNSRequest); !ok || d.Domain != domain {
+				t.Errorf("Job %d received the out of scope finding %v", i, req)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Job %d did not receive the finding", i)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for i, job := range jobs {
+		select {
+		case req := <-job.Output(script.String()):
+			t.Errorf("Job %d received an additional finding %v", i, req)
+		default:
+		}
+	}
+	select {
+	case req := <-script.Output():
+		t.Errorf("A job finding was sent to the shared output channel: %v", req)
+	default:
+	}
+}
+
+type fakeEvidence struct {
+	sync.Mutex
+	fragments map[string]string
+}
+
+func (fe *fakeEvidence) Add(name, source string, fragment []byte) (string, error) {
+	fe.Lock()
+	defer fe.Unlock()
+
+	fe.fragments[name] = source + ": " + string(fragment)
+	return name, nil
+}
+
+func TestEvidence(t *testing.T) {
+	script, sys := setupMockScriptEnv(`
+		name="evidence"
+		type="testing"
+
+		function vertical(ctx, domain)
+			new_name(ctx, "www.owasp.org", "{\"common_name\":\"www.owasp.org\"}")
+			new_name(ctx, "ftp.owasp.org")
+			new_name(ctx, "www.utica.edu", "out of scope")
+			send_names(ctx, "first line\n  <a href=\"https://mail.owasp.org/\">Mail</a>\nlast line")
+		end
+	`)
+	if script == nil || sys == nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	domain := "owasp.org"
+	cfg := config.NewConfig()
+	cfg.AddDomain(domain)
+
+	fe := &fakeEvidence{fragments: make(map[string]string)}
+	job := requests.NewJob(domain, cfg, []string{script.String()})
+	job.Evidence = fe
+
+	ctx := requests.WithJob(context.Background(), job)
+	script.Input() <- requests.WithContext(ctx, script, &requests.DNSRequest{Name: domain, Domain: domain})
+	for i := 0; i < 3; i++ {
+		select {
+		case <-job.Output(script.String()):
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Name %d was not sent", i+1)
+		}
+	}
+
+	fe.Lock()
+	defer fe.Unlock()
+
+	expected := map[string]string{
+		"www.owasp.org":  `evidence: {"common_name":"www.owasp.org"}`,
+		"mail.owasp.org": `evidence: <a href="https://mail.owasp.org/">Mail</a>`,
+	}
+	if len(fe.fragments) != len(expected) {
+		t.Errorf("the evidence %v was stored, expected %v", fe.fragments, expected)
+	}
+	for name, fragment := range expected {
+		if got := fe.fragments[name]; got != fragment {
+			t.Errorf("the evidence for %s was %q, expected %q", name, got, fragment)
+		}
+	}
+}
+
+func TestNewFinding(t *testing.T) {
+	script, sys := setupMockScriptEnv(`
+		name="finding"
+		type="testing"
+
+		function vertical(ctx, domain)
+			new_finding(ctx, {
+				['name']="www.owasp.org",
+				['addrs']={"72.237.4.113", "not an address"},
+				['first_seen']=1672531200,
+				['last_seen']="2023-06-01T12:00:00Z",
+				['ref']="crt.sh:42",
+			}, "{\"id\":42}")
+			new_finding(ctx, {['name']="www.owasp.org", ['first_seen']=1640995200000, ['confidence']=0.5})
+			new_finding(ctx, {['name']="www.utica.edu", ['addrs']={"72.237.4.114"}})
+		end
+	`)
+	if script == nil || sys == nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	domain := "owasp.org"
+	cfg := config.NewConfig()
+	cfg.AddDomain(domain)
+
+	job := requests.NewJob(domain, cfg, []string{script.String()})
+	ctx := requests.WithJob(context.Background(), job)
+	script.Input() <- requests.WithContext(ctx, script, &requests.DNSRequest{Name: domain, Domain: domain})
+
+	var names, addrs int
+	for i := 0; i < 3; i++ {
+		select {
+		case req := <-job.Output(script.String()):
+			switch v := req.(type) {
+			case *requests.DNSRequest:
+				if v.Name != "www.owasp.org" {
+					t.Errorf("the name %s was submitted", v.Name)
+				}
+				names++
+			case *requests.AddrRequest:
+				if v.Address != "72.237.4.113" {
+					t.Errorf("the address %s was submitted", v.Address)
+				}
+				addrs++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Request %d was not sent", i+1)
+		}
+	}
+	if names != 2 || addrs != 1 {
+		t.Errorf("%d names and %d addresses were submitted", names, addrs)
+	}
+
+	f := job.Findings.Get("www.owasp.org")
+	if f == nil {
+		t.Fatal("the finding was not merged into the job")
+	}
+	if !f.FirstSeen.Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) ||
+		!f.LastSeen.Equal(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("the finding was seen from %s to %s", f.FirstSeen, f.LastSeen)
+	}
+	if len(f.Addresses) != 1 || f.Ref != "crt.sh:42" || f.Confidence != 0.5 || len(f.Sources) != 1 || f.Sources[0] != "finding" {
+		t.Errorf("the finding was merged as %+v", f)
+	}
+	if job.Findings.Get("www.utica.edu") != nil {
+		t.Error("the finding of an out of scope name was kept")
+	}
+}
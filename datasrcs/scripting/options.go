@@ -0,0 +1,146 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+// domainPlaceholder is replaced by the queried domain in configured header values.
+const domainPlaceholder = "{{domain}}"
+
+// httpOptions contains the HTTP settings that apply to a single data source.
+type httpOptions struct {
+	headers      http.Header
+	userAgents   *http.UserAgents
+	maxBodySize  int64
+	timeout      time.Duration
+	maxRedirects int
+}
+
+// sourceHTTPOptions returns the HTTP settings configured for the named data source.
+func sourceHTTPOptions(cfg *config.Config, source string) *httpOptions {
+	ho := &httpOptions{headers: make(http.Header)}
+
+	opts := httpConfigOptions(cfg)
+	if opts == nil {
+		return ho
+	}
+
+	// The data sources always sending the default user agent are left without the rotation
+	static := false
+	for _, name := range stringList(opts["static_user_agent"]) {
+		if strings.EqualFold(name, source) {
+			static = true
+			break
+		}
+	}
+	if !static {
+		ho.userAgents = http.NewUserAgents(stringList(opts["user_agents"])...)
+	}
+
+	ho.setLimits(opts)
+	if limits, ok := opts["limits"].(map[string]interface{}); ok {
+		for name, v := range limits {
+			if m, ok := v.(map[string]interface{}); ok && strings.EqualFold(name, source) {
+				ho.setLimits(m)
+			}
+		}
+	}
+
+	if hdrs, ok := opts["headers"].(map[string]interface{}); ok {
+		for name, v := range hdrs {
+			if !strings.EqualFold(name, source) {
+				continue
+			}
+			if m, ok := v.(map[string]interface{}); ok {
+				for k, val := range m {
+					ho.headers[k] = fmt.Sprint(val)
+				}
+			}
+		}
+	}
+	return ho
+}
+
+// setLimits assigns the response caps found in the options, where the timeout is in seconds.
+func (ho *httpOptions) setLimits(opts map[string]interface{}) {
+	if n := options.Int(opts["max_body_size"]); n > 0 {
+		ho.maxBodySize = int64(n)
+	}
+	if n := options.Int(opts["timeout"]); n > 0 {
+		ho.timeout = time.Duration(n) * time.Second
+	}
+	if v, found := opts["max_redirects"]; found {
+		// a value of zero disables following redirects
+		if ho.maxRedirects = options.Int(v); ho.maxRedirects <= 0 {
+			ho.maxRedirects = -1
+		}
+	}
+}
+
+// header returns the configured headers with the queried domain substituted into the values.
+func (ho *httpOptions) header(domain string) http.Header {
+	hdr := make(http.Header, len(ho.headers))
+
+	for k, v := range ho.headers {
+		hdr[k] = strings.ReplaceAll(v, domainPlaceholder, domain)
+	}
+	return hdr
+}
+
+// crawlOptions returns the limits of the crawls and of the script fetching found in the 'web_probe'
+// configuration options. The scripts are only fetched in the active mode, unless disabled by the options.
+func crawlOptions(cfg *config.Config, max int) (*http.CrawlOptions, bool) {
+	co := &http.CrawlOptions{MaxLinks: max}
+	if cfg == nil {
+		return co, false
+	}
+
+	scripts := cfg.Active
+	if opts, ok := cfg.Options["web_probe"].(map[string]interface{}); ok {
+		co.Concurrency = options.Int(opts["concurrency"])
+		co.PerHost = options.Int(opts["per_host"])
+		co.MaxScripts = options.Int(opts["max_scripts"])
+		co.MaxScriptSize = int64(options.Int(opts["max_script_size"]))
+		if enabled, ok := opts["scripts"].(bool); ok && !enabled {
+			scripts = false
+		}
+	}
+	return co, scripts
+}
+
+func httpConfigOptions(cfg *config.Config) map[string]interface{} {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, _ := cfg.Options["http"].(map[string]interface{})
+	return opts
+}
+
+func stringList(v interface{}) []string {
+	var list []string
+
+	switch t := v.(type) {
+	case string:
+		list = append(list, t)
+	case []string:
+		list = append(list, t...)
+	case []interface{}:
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,198 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package scripting
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	amasshttp "github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestHTTPOptions(t *testing.T) {
+	hdrs := make(chan http.Header, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdrs <- r.Header.Clone()
+	}))
+	defer ts.Close()
+
+	cfg := config.NewConfig()
+	cfg.Options["http"] = map[string]interface{}{
+		"user_agents": []interface{}{"rotated-agent"},
+		"headers": map[string]interface{}{
+			"headers": map[string]interface{}{"Referer": "https://{{domain}}/search"},
+		},
+	}
+
+	sys := newMockSystem(cfg)
+	defer func() { _ = sys.Shutdown() }()
+
+	s := NewScript(fmt.Sprintf(`
+		name="headers"
+		type="testing"
+
+		function vertical(ctx, domain)
+			request(ctx, {url="%s"})
+			request(ctx, {url="%s", static_user_agent=true})
+		end
+	`, ts.URL, ts.URL), sys)
+	if s == nil || sys.AddAndStart(s) != nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+
+	domain := "owasp.org"
+	sys.Config().AddDomain(domain)
+	s.Input() <- &requests.DNSRequest{Domain: domain}
+
+	for _, want := range []string{"rotated-agent", amasshttp.UserAgent} {
+		select {
+		case h := <-hdrs:
+			if ref := h.Get("Referer"); ref != "https://owasp.org/search" {
+				t.Errorf("Expected the templated Referer header, got %s", ref)
+			}
+			if ua := h.Get("User-Agent"); ua != want {
+				t.Errorf("Expected the user agent %s, got %s", want, ua)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("The data source did not send the expected requests")
+		}
+	}
+}
+
+func TestSourceUserAgents(t *testing.T) {
+	first := config.NewConfig()
+	first.Options["http"] = map[string]interface{}{
+		"user_agents":       []interface{}{"first-agent"},
+		"static_user_agent": []interface{}{"Static"},
+	}
+	second := config.NewConfig()
+	second.Options["http"] = map[string]interface{}{"user_agents": []interface{}{"second-agent"}}
+
+	if ua := sourceHTTPOptions(first, "Rotated").userAgents.Next(); ua != "first-agent" {
+		t.Errorf("The first configuration provided the user agent %s", ua)
+	}
+	if ua := sourceHTTPOptions(second, "Rotated").userAgents.Next(); ua != "second-agent" {
+		t.Errorf("The second configuration provided the user agent %s", ua)
+	}
+	if ua := sourceHTTPOptions(first, "static").userAgents.Next(); ua != amasshttp.UserAgent {
+		t.Errorf("The static data source was assigned the user agent %s", ua)
+	}
+}
+
+func TestHTTPLimits(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "www.owasp.org ")
+		for {
+			if _, err := fmt.Fprint(w, strings.Repeat("A", 1024)); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.NewConfig()
+	cfg.Options["http"] = map[string]interface{}{
+		"max_body_size": 1 << 20,
+		"timeout":       30,
+		"limits": map[string]interface{}{
+			"limits": map[string]interface{}{"max_body_size": 4096, "max_redirects": 0},
+		},
+	}
+
+	if ho := sourceHTTPOptions(cfg, "other"); ho.maxBodySize != 1<<20 || ho.timeout != 30*time.Second || ho.maxRedirects != 0 {
+		t.Errorf("Unexpected global limits: %+v", ho)
+	}
+	if ho := sourceHTTPOptions(cfg, "Limits"); ho.maxBodySize != 4096 || ho.timeout != 30*time.Second || ho.maxRedirects != -1 {
+		t.Errorf("Unexpected data source limits: %+v", ho)
+	}
+
+	sys := newMockSystem(cfg)
+	defer func() { _ = sys.Shutdown() }()
+
+	s := NewScript(fmt.Sprintf(`
+		name="limits"
+		type="testing"
+
+		function vertical(ctx, domain)
+			scrape(ctx, {url="%s"})
+		end
+	`, ts.URL), sys)
+	if s == nil || sys.AddAndStart(s) != nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+
+	domain := "owasp.org"
+	sys.Config().AddDomain(domain)
+	s.Input() <- &requests.DNSRequest{Domain: domain}
+
+	select {
+	case req := <-s.Output():
+		if d, ok := req.(*requests.DNSRequest); !ok || d.Name != "www.owasp.org" {
+			t.Errorf("Unexpected name extracted from the truncated response: %v", req)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The name was not extracted from the truncated response")
+	}
+}
+
+func TestCrawlScriptNames(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><script src="/bundle.js"></script></head><body></body></html>`)
+	})
+	mux.HandleFunc("/bundle.js", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `!function(){var e="https://api.owasp.org/graphql",t="cdn.other.com";}();`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cfg := config.NewConfig()
+	cfg.Active = true
+	cfg.Options["web_probe"] = map[string]interface{}{"max_scripts": 5, "per_host": 1}
+	if opts, scripts := crawlOptions(cfg, 10); !scripts || opts.MaxScripts != 5 || opts.PerHost != 1 || opts.MaxLinks != 10 {
+		t.Errorf("Unexpected crawl options: %+v", opts)
+	}
+
+	sys := newMockSystem(cfg)
+	defer func() { _ = sys.Shutdown() }()
+
+	s := NewScript(fmt.Sprintf(`
+		name="jsfiles"
+		type="crawl"
+
+		function vertical(ctx, domain)
+			crawl(ctx, "%s", 1)
+		end
+	`, ts.URL), sys)
+	if s == nil || sys.AddAndStart(s) != nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+
+	sys.Config().AddDomains("owasp.org", "127.0.0.1")
+	s.Input() <- &requests.DNSRequest{Domain: "owasp.org"}
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case req := <-s.Output():
+			d, ok := req.(*requests.DNSRequest)
+			if !ok || d.Name != "api.owasp.org" {
+				continue
+			}
+			if d.Parent != ts.URL+"/bundle.js" || d.Derivation != requests.DerivedFromJSFile {
+				t.Errorf("the name found in the script was derived from %s by %s", d.Parent, d.Derivation)
+			}
+			return
+		case <-timeout:
+			t.Fatal("the name in the script was not discovered")
+		}
+	}
+}
@@ -13,12 +13,14 @@ import (
 
 	"github.com/caffix/service"
 	luaurl "github.com/cjoudrey/gluaurl"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/datasrcs/quota"
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
 	"github.com/owasp-amass/amass/v4/net/dns"
 	"github.com/owasp-amass/amass/v4/requests"
 	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/config/config"
 	lua "github.com/yuin/gopher-lua"
-	luajson "layeh.com/gopher-json"
 )
 
 // Script callback functions
@@ -46,13 +48,31 @@ type Script struct {
 	cbs        *callbacks
 	cbsLock    sync.Mutex
 	subre      *regexp.Regexp
+	httpOpts   *httpOptions
+	quota      *quota.Tracker
+	quotaSkip  bool
+	keys       *keyManager
+	keySkip    bool
 	seconds    int
+	parseErrs  *salvage.Counter
+	callCtx    context.Context
 	ctx        context.Context
 	cancel     context.CancelFunc
 }
 
 // NewScript returns the object initialized, but not yet started.
 func NewScript(script string, sys systems.System) *Script {
+	s := loadScript(script, sys)
+	if s == nil {
+		return nil
+	}
+
+	go s.requests()
+	return s
+}
+
+// loadScript returns the script loaded into its Lua state, without the routine that serves its requests.
+func loadScript(script string, sys systems.System) *Script {
 	re, err := regexp.Compile(dns.AnySubdomainRegexString())
 	if err != nil {
 		return nil
@@ -87,8 +107,10 @@ func NewScript(script string, sys systems.System) *Script {
 	}
 
 	s.BaseService = *service.NewBaseService(s, name)
+	s.httpOpts = sourceHTTPOptions(sys.Config(), name)
+	s.keys = newKeyManager(sys.Config(), name)
+	s.parseErrs = salvage.NewCounter(name)
 	s.assignCallbacks()
-	go s.requests()
 	return s
 }
 
@@ -105,7 +127,7 @@ func (s *Script) newLuaState(cfg *config.Config) *lua.LState {
 
 	registerSocketType(L)
 	L.PreloadModule("url", luaurl.Loader)
-	L.PreloadModule("json", luajson.Loader)
+	L.PreloadModule("json", s.jsonLoader)
 	L.SetGlobal("config", L.NewFunction(s.config))
 	L.SetGlobal("datasrc_config", L.NewFunction(s.dataSourceConfig))
 	L.SetGlobal("brute_wordlist", L.NewFunction(s.bruteWordlist))
@@ -115,6 +137,7 @@ func (s *Script) newLuaState(cfg *config.Config) *lua.LState {
 	L.SetGlobal("submatch", L.NewFunction(s.submatch))
 	L.SetGlobal("mtime", L.NewFunction(s.modDateTime))
 	L.SetGlobal("new_name", L.NewFunction(s.newName))
+	L.SetGlobal("new_finding", L.NewFunction(s.newFinding))
 	L.SetGlobal("send_names", L.NewFunction(s.sendNames))
 	L.SetGlobal("send_dns_records", L.NewFunction(s.sendDNSRecords))
 	L.SetGlobal("new_addr", L.NewFunction(s.newAddr))
@@ -193,8 +216,57 @@ func (s *Script) OnStart() error {
 
 // OnStop implements the Service interface.
 func (s *Script) OnStop() error {
+	// Cancel the in-flight work first, so the script can promptly process the stop
+	s.cancel()
 	s.stop <- struct{}{}
-	return nil
+	// The usage recorded since the last flush of the shared tracker is written
+	return s.quota.Stop()
+}
+
+// SetQuotaTracker assigns the Tracker used to account for the API usage of the script.
+func (s *Script) SetQuotaTracker(t *quota.Tracker) {
+	s.quota = t
+}
+
+// quotaExhausted returns true when the script has used all of its API quota, logging it the first time.
+func (s *Script) quotaExhausted() bool {
+	if s.quota == nil || s.quota.Allow(s.String()) {
+		s.quotaSkip = false
+		return false
+	}
+
+	if !s.quotaSkip {
+		s.quotaSkip = true
+		s.sys.Config().Log.Printf("%s: the API quota has been exhausted, skipping requests until it resets", s.String())
+	}
+	return true
+}
+
+// keysExhausted returns true when all the API keys of the script are benched, logging it the first time.
+func (s *Script) keysExhausted() bool {
+	if s.keys == nil || !s.keys.exhausted() {
+		s.keySkip = false
+		return false
+	}
+
+	if !s.keySkip {
+		s.keySkip = true
+		s.sys.Config().Log.Printf("%s: all API keys have been rejected, skipping requests until one cools down", s.String())
+	}
+	return true
+}
+
+// KeyUsage returns the number of requests served and rejected per API key of the script.
+func (s *Script) KeyUsage() []KeyUsage {
+	if s.keys == nil {
+		return nil
+	}
+	return s.keys.usage()
+}
+
+// SupportsContext implements the requests.ContextAware interface.
+func (s *Script) SupportsContext() bool {
+	return true
 }
 
 // HandlesReq implements the Service interface.
@@ -203,6 +275,7 @@ func (s *Script) HandlesReq(req interface{}) bool {
 	defer s.cbsLock.Unlock()
 
 	var handles bool
+	_, req = requests.UnwrapContext(req)
 	switch t := req.(type) {
 	case *requests.DNSRequest:
 		if s.cbs.Vertical.Type() != lua.LTNil && t != nil && t.Domain != "" {
@@ -233,6 +306,9 @@ func (s *Script) HandlesReq(req interface{}) bool {
 }
 
 func (s *Script) requests() {
+	// The Lua state is released on every path out of the loop
+	defer s.stopScript()
+
 	for {
 		select {
 		case <-s.Done():
@@ -242,7 +318,7 @@ func (s *Script) requests() {
 		case <-s.start:
 			s.startScript()
 		case <-s.stop:
-			s.stopScript()
+			return
 		case in := <-s.Input():
 			s.dispatch(in)
 		}
@@ -258,7 +334,7 @@ func (s *Script) startScript() {
 		})
 		if err != nil {
 			s.sys.Config().Log.Printf("%s: start callback: %v", s.String(), err)
-			s.startRet <- err
+			s.startRet <- &systems.SourceError{Name: s.String(), Temporary: systems.IsTransient(err), Err: err}
 			return
 		}
 	}
@@ -286,7 +362,7 @@ func (s *Script) checkConfig() error {
 		estr := fmt.Sprintf("%s: check callback: %v", s.String(), err)
 
 		s.sys.Config().Log.Print(estr)
-		return errors.New(estr)
+		return &systems.SourceError{Name: s.String(), Temporary: systems.IsTransient(err), Err: errors.New(estr)}
 	}
 
 	ret := L.Get(-1)
@@ -299,12 +375,19 @@ func (s *Script) checkConfig() error {
 
 	estr := fmt.Sprintf("%s: check callback failed for the configuration", s.String())
 	s.sys.Config().Log.Print(estr)
-	return errors.New(estr)
+	// Retrying does not help a configuration that lacks the required settings, such as the credentials
+	return &systems.SourceError{Name: s.String(), Temporary: false, Err: errors.New(estr)}
 }
 
 func (s *Script) stopScript() {
 	s.cancel()
 
+	if cfg := s.sys.Config(); cfg.Verbose {
+		for _, u := range s.KeyUsage() {
+			cfg.Log.Printf("%s: API key %d served %d requests with %d rejected", s.String(), u.Index, u.Requests, u.Failures)
+		}
+	}
+
 	if L := s.luaState; s.cbs.Stop.Type() != lua.LTNil {
 		err := L.CallByParam(lua.P{
 			Fn:      s.cbs.Stop,
@@ -321,7 +404,38 @@ func (s *Script) stopScript() {
 	s.luaState = nil
 }
 
+// requestContext returns a context that is cancelled when either the script
+// is stopped or the work the request belongs to has been cancelled.
+func (s *Script) requestContext(reqCtx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	// The findings must be delivered to the enumeration the request belongs to
+	if job := requests.JobFromContext(reqCtx); job != nil {
+		ctx = requests.WithJob(ctx, job)
+	}
+	// The bytes of the HTTP requests are accounted for on behalf of the script
+	if m := bandwidth.FromContext(reqCtx); m != nil {
+		ctx = bandwidth.WithSource(bandwidth.WithMeter(ctx, m), s.String())
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-reqCtx.Done():
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
 func (s *Script) dispatch(in interface{}) {
+	if s.quotaExhausted() || s.keysExhausted() {
+		return
+	}
+
+	reqCtx, in := requests.UnwrapContext(in)
+	ctx, cancel := s.requestContext(reqCtx)
+	defer cancel()
+
 	s.cbsLock.Lock()
 
 	switch req := in.(type) {
@@ -330,28 +444,28 @@ func (s *Script) dispatch(in interface{}) {
 			callback := s.cbs.Vertical
 			s.cbsLock.Unlock()
 			s.CheckRateLimit()
-			s.dnsRequest(s.ctx, callback, req)
+			s.dnsRequest(ctx, callback, req)
 		}
 	case *requests.ResolvedRequest:
 		if s.cbs.Resolved.Type() != lua.LTNil && req != nil && req.Name != "" && len(req.Records) > 0 {
 			callback := s.cbs.Resolved
 			s.cbsLock.Unlock()
 			s.CheckRateLimit()
-			s.resolvedRequest(s.ctx, callback, req)
+			s.resolvedRequest(ctx, callback, req)
 		}
 	case *requests.SubdomainRequest:
 		if s.cbs.Subdomain.Type() != lua.LTNil && req != nil && req.Name != "" {
 			callback := s.cbs.Subdomain
 			s.cbsLock.Unlock()
 			s.CheckRateLimit()
-			s.subdomainRequest(s.ctx, callback, req)
+			s.subdomainRequest(ctx, callback, req)
 		}
 	case *requests.AddrRequest:
 		if s.cbs.Address.Type() != lua.LTNil && req != nil && req.Address != "" {
 			callback := s.cbs.Address
 			s.cbsLock.Unlock()
 			s.CheckRateLimit()
-			s.addrRequest(s.ctx, callback, req)
+			s.addrRequest(ctx, callback, req)
 		}
 	case *requests.ASNRequest:
 		if s.cbs.Asn.Type() != lua.LTNil && req != nil && (req.Address != "" || req.ASN != 0) {
@@ -360,7 +474,7 @@ func (s *Script) dispatch(in interface{}) {
 			// check that the cache entry has not already been made by a previous request
 			if s.sys.Cache().AddrSearch(req.Address) == nil {
 				s.CheckRateLimit()
-				s.asnRequest(s.ctx, callback, req)
+				s.asnRequest(ctx, callback, req)
 			}
 		}
 	case *requests.WhoisRequest:
@@ -368,7 +482,7 @@ func (s *Script) dispatch(in interface{}) {
 			callback := s.cbs.Horizontal
 			s.cbsLock.Unlock()
 			s.CheckRateLimit()
-			s.whoisRequest(s.ctx, callback, req)
+			s.whoisRequest(ctx, callback, req)
 		}
 	default:
 		s.cbsLock.Unlock()
@@ -388,7 +502,7 @@ func (s *Script) dnsRequest(ctx context.Context, callback lua.LValue, req *reque
 		Fn:      callback,
 		NRet:    0,
 		Protect: true,
-	}, s.contextToUserData(ctx), lua.LString(req.Domain))
+	}, s.contextToUserData(withQueryName(withQueryDomain(ctx, req.Domain), req.Domain)), lua.LString(req.Domain))
 	if err != nil {
 		s.sys.Config().Log.Printf("%s: vertical callback: %v", s.String(), err)
 	}
@@ -415,7 +529,7 @@ func (s *Script) resolvedRequest(ctx context.Context, callback lua.LValue, req *
 		Fn:      callback,
 		NRet:    0,
 		Protect: true,
-	}, s.contextToUserData(ctx), lua.LString(req.Name), lua.LString(req.Domain), records)
+	}, s.contextToUserData(withQueryName(withQueryDomain(ctx, req.Domain), req.Name)), lua.LString(req.Name), lua.LString(req.Domain), records)
 	if err != nil {
 		s.sys.Config().Log.Printf("%s: resolved callback: %v", s.String(), err)
 	}
@@ -432,7 +546,7 @@ func (s *Script) subdomainRequest(ctx context.Context, callback lua.LValue, req
 		Fn:      callback,
 		NRet:    0,
 		Protect: true,
-	}, s.contextToUserData(ctx), lua.LString(req.Name), lua.LString(req.Domain), lua.LNumber(req.Times))
+	}, s.contextToUserData(withQueryName(withQueryDomain(ctx, req.Domain), req.Name)), lua.LString(req.Name), lua.LString(req.Domain), lua.LNumber(req.Times))
 	if err != nil {
 		s.sys.Config().Log.Printf("%s: subdomain callback: %v", s.String(), err)
 	}
@@ -449,7 +563,7 @@ func (s *Script) addrRequest(ctx context.Context, callback lua.LValue, req *requ
 		Fn:      callback,
 		NRet:    0,
 		Protect: true,
-	}, s.contextToUserData(ctx), lua.LString(req.Address))
+	}, s.contextToUserData(withQueryName(ctx, req.Address)), lua.LString(req.Address))
 	if err != nil {
 		s.sys.Config().Log.Printf("%s: address callback: %v", s.String(), err)
 	}
@@ -483,7 +597,7 @@ func (s *Script) whoisRequest(ctx context.Context, callback lua.LValue, req *req
 		Fn:      callback,
 		NRet:    0,
 		Protect: true,
-	}, s.contextToUserData(ctx), lua.LString(req.Domain))
+	}, s.contextToUserData(withQueryDomain(ctx, req.Domain)), lua.LString(req.Domain))
 	if err != nil {
 		s.sys.Config().Log.Printf("%s: horizontal callback: %v", s.String(), err)
 	}
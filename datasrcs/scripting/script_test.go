@@ -5,6 +5,13 @@
 package scripting
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
 	"github.com/caffix/netmap"
 	"github.com/caffix/service"
 	"github.com/owasp-amass/amass/v4/requests"
@@ -39,3 +46,83 @@ func newMockSystem(cfg *config.Config) systems.System {
 	_ = ss.Trusted.AddResolvers(20, "8.8.8.8")
 	return ss
 }
+
+func hangingServer() (*httptest.Server, chan struct{}, chan struct{}) {
+	started := make(chan struct{}, 1)
+	aborted := make(chan struct{}, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+		aborted <- struct{}{}
+	}))
+	return ts, started, aborted
+}
+
+func hangingScript(url string) string {
+	return fmt.Sprintf(`
+		name="hanging"
+		type="testing"
+
+		function vertical(ctx, domain)
+			request(ctx, {url="%s"})
+		end
+	`, url)
+}
+
+func TestShutdownCancelsInFlightRequests(t *testing.T) {
+	ts, started, aborted := hangingServer()
+	defer ts.Close()
+
+	src, sys := setupMockScriptEnv(hangingScript(ts.URL))
+	if src == nil || sys == nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+
+	src.Input() <- &requests.DNSRequest{Domain: "owasp.org"}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The data source did not send the request")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = sys.Shutdown()
+		close(done)
+	}()
+
+	for _, ch := range []chan struct{}{done, aborted} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The in-flight request was not cancelled by the shutdown")
+		}
+	}
+}
+
+func TestContextRequestCancellation(t *testing.T) {
+	ts, started, aborted := hangingServer()
+	defer ts.Close()
+
+	src, sys := setupMockScriptEnv(hangingScript(ts.URL))
+	if src == nil || sys == nil {
+		t.Fatal("Failed to initialize the scripting environment")
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src.Input() <- requests.WithContext(ctx, src, &requests.DNSRequest{Domain: "owasp.org"})
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The data source did not send the request")
+	}
+
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The in-flight request was not cancelled with the request context")
+	}
+}
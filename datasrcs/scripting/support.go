@@ -10,6 +10,9 @@ import (
 	"os"
 	"regexp"
 
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -17,8 +20,87 @@ type contextWrapper struct {
 	Ctx context.Context
 }
 
+type queryDomainKey struct{}
+
+// withQueryDomain returns a context carrying the domain name the data source was queried for.
+func withQueryDomain(ctx context.Context, domain string) context.Context {
+	return context.WithValue(ctx, queryDomainKey{}, domain)
+}
+
+// queryDomain returns the domain name the data source was queried for, if any.
+func queryDomain(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	domain, _ := ctx.Value(queryDomainKey{}).(string)
+	return domain
+}
+
+type queryNameKey struct{}
+
+// withQueryName returns a context carrying the name or address the data source was queried for.
+func withQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey{}, name)
+}
+
+// queryName returns the name or address the data source was queried for, if any.
+func queryName(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	name, _ := ctx.Value(queryNameKey{}).(string)
+	return name
+}
+
+// jobConfig returns the configuration of the enumeration the request belongs to.
+// Requests made outside of an enumeration job fall back to the system configuration.
+func (s *Script) jobConfig(ctx context.Context) *config.Config {
+	if job := requests.JobFromContext(ctx); job != nil && job.Config != nil {
+		return job.Config
+	}
+	return s.sys.Config()
+}
+
+// policy returns the never-touch list of the enumeration the request belongs to, or nil when none was named.
+func (s *Script) policy(ctx context.Context) *policy.Policy {
+	if job := requests.JobFromContext(ctx); job != nil {
+		return job.Policy
+	}
+	return nil
+}
+
+// output returns the channel that receives the findings of the enumeration the request belongs to.
+func (s *Script) output(ctx context.Context) chan interface{} {
+	if ch := requests.JobFromContext(ctx).Output(s.String()); ch != nil {
+		return ch
+	}
+	return s.Output()
+}
+
+// sendOutput delivers the finding unless the request or the script has been cancelled.
+func (s *Script) sendOutput(ctx context.Context, req interface{}) {
+	select {
+	case <-ctx.Done():
+	case <-s.Done():
+	case s.output(ctx) <- req:
+	}
+}
+
+// jobKey returns the key scoped to the enumeration the request belongs to.
+func jobKey(ctx context.Context, key string) string {
+	if job := requests.JobFromContext(ctx); job != nil {
+		return job.ID + "|" + key
+	}
+	return key
+}
+
 // Converts Go Context to Lua UserData.
 func (s *Script) contextToUserData(ctx context.Context) *lua.LUserData {
+	// The functions that scripts call without a context, such as json.decode, use the one of the running callback
+	s.callCtx = ctx
+
 	L := s.luaState
 	ud := L.NewUserData()
 
@@ -142,6 +224,15 @@ func getStringField(L *lua.LState, t lua.LValue, key string) (string, bool) {
 	return "", false
 }
 
+func getBoolField(L *lua.LState, t lua.LValue, key string) (bool, bool) {
+	if lv := L.GetField(t, key); lv != nil {
+		if b, ok := lv.(lua.LBool); ok {
+			return bool(b), true
+		}
+	}
+	return false, false
+}
+
 func getNumberField(L *lua.LState, t lua.LValue, key string) (float64, bool) {
 	if lv := L.GetField(t, key); lv != nil {
 		if n, ok := lv.(lua.LNumber); ok {
@@ -9,6 +9,10 @@ import (
 
 	"github.com/caffix/service"
 	"github.com/caffix/stringset"
+	"github.com/owasp-amass/amass/v4/datasrcs/external"
+	"github.com/owasp-amass/amass/v4/datasrcs/passivedns"
+	"github.com/owasp-amass/amass/v4/datasrcs/quota"
+	"github.com/owasp-amass/amass/v4/datasrcs/salvage"
 	"github.com/owasp-amass/amass/v4/datasrcs/scripting"
 	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/config/config"
@@ -18,13 +22,46 @@ import (
 func GetAllSources(sys systems.System) []service.Service {
 	var srvs []service.Service
 
+	tracker, err := quota.FromConfig(sys.Config())
+	if err != nil {
+		sys.Config().Log.Printf("Failed to load the API quota state: %v", err)
+	}
+
 	if scripts, err := sys.Config().AcquireScripts(); err == nil {
 		for _, script := range scripts {
 			if s := scripting.NewScript(script, sys); s != nil {
+				s.SetQuotaTracker(tracker)
 				srvs = append(srvs, s)
 			}
 		}
 	}
+	// The passive DNS providers share the implementation of a single data source
+	for _, a := range passivedns.Adapters() {
+		s := passivedns.NewSource(a, sys)
+		s.SetQuotaTracker(tracker)
+		srvs = append(srvs, s)
+	}
+	// The external data sources cannot replace the sources implemented within the repository
+	names := stringset.New()
+	defer names.Close()
+	for _, s := range srvs {
+		names.Insert(s.String())
+	}
+	settings := external.SettingsFromConfig(sys.Config())
+	plugins, errs := external.Discover(settings)
+	for _, err := range errs {
+		sys.Config().Log.Printf("Failed to discover the external data source: %v", err)
+	}
+	for _, p := range plugins {
+		if names.Has(p.Handshake.Name) {
+			sys.Config().Log.Printf("The external data source %s is named like an existing data source", p.Path)
+			continue
+		}
+
+		s := external.NewSource(p, settings, sys)
+		s.SetQuotaTracker(tracker)
+		srvs = append(srvs, s)
+	}
 
 	sort.Slice(srvs, func(i, j int) bool {
 		return srvs[i].String() < srvs[j].String()
@@ -32,6 +69,87 @@ func GetAllSources(sys systems.System) []service.Service {
 	return srvs
 }
 
+// InspectSources describes the data sources selected by the configuration, including whether each would
+// start, without starting any of them or sending any traffic.
+func InspectSources(cfg *config.Config) ([]*scripting.Info, error) {
+	scripts, err := cfg.AcquireScripts()
+	if err != nil {
+		return nil, err
+	}
+
+	specified := stringset.New(cfg.SourceFilter.Sources...)
+	defer specified.Close()
+
+	var infos []*scripting.Info
+	for _, script := range scripts {
+		info, err := scripting.Inspect(script, cfg)
+		if err != nil {
+			continue
+		}
+
+		if listed := specified.Has(info.Name); specified.Len() > 0 && listed != cfg.SourceFilter.Include {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	for _, a := range passivedns.Adapters() {
+		if listed := specified.Has(a.Name); specified.Len() > 0 && listed != cfg.SourceFilter.Include {
+			continue
+		}
+		infos = append(infos, inspectPassiveDNS(a, cfg))
+	}
+	plugins, _ := external.Discover(external.SettingsFromConfig(cfg))
+	for _, p := range plugins {
+		if listed := specified.Has(p.Handshake.Name); specified.Len() > 0 && listed != cfg.SourceFilter.Include {
+			continue
+		}
+		infos = append(infos, &scripting.Info{
+			Name:  p.Handshake.Name,
+			Type:  p.Handshake.Type,
+			Ready: true,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// inspectPassiveDNS describes the passive DNS provider, which is ready when the adapter accepts the configured credentials.
+func inspectPassiveDNS(a passivedns.Adapter, cfg *config.Config) *scripting.Info {
+	info := &scripting.Info{
+		Name:      a.Name,
+		Type:      "api",
+		Ready:     true,
+		Endpoints: []string{a.Host},
+	}
+
+	if _, err := a.New(passivedns.Credentials(cfg, a.Name)); err != nil {
+		info.Ready = false
+		info.Reason = err.Error()
+	}
+	return info
+}
+
+// ParseErrors returns the parse error counts of the data sources with responses that failed to parse in full.
+func ParseErrors(srcs []service.Service) []salvage.Count {
+	var counts []salvage.Count
+
+	for _, src := range srcs {
+		if r, ok := src.(salvage.Reporter); ok {
+			if c := r.ParseErrors(); c.Responses > 0 {
+				counts = append(counts, c)
+			}
+		}
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Source < counts[j].Source
+	})
+	return counts
+}
+
 // SelectedDataSources uses the config and available data sources to return the selected data sources.
 func SelectedDataSources(cfg *config.Config, avail []service.Service) []service.Service {
 	specified := stringset.New()
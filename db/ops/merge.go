@@ -0,0 +1,368 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/asset-db/repository"
+	"github.com/owasp-amass/config/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// mergeBatchSize is the number of rows read from a graph database at a time during a merge.
+const mergeBatchSize = 1000
+
+// EventMapping is the identifier given in the destination to an event merged from a source.
+type EventMapping struct {
+	Dir  string `json:"dir"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Duplicate is set when the destination already held the same event, which was not copied again
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// MergeReport counts the findings copied into the destination by MergeEvents.
+type MergeReport struct {
+	// AssetsAdded counts the assets that were new to the destination
+	AssetsAdded int `json:"assets_added"`
+	// AssetsMerged counts the assets already held by the destination, whose times were widened
+	AssetsMerged    int             `json:"assets_merged"`
+	RelationsAdded  int             `json:"relations_added"`
+	RelationsMerged int             `json:"relations_merged"`
+	Events          []*EventMapping `json:"events"`
+}
+
+// MergeEvents consolidates the output directories of several scan hosts into the destination, which is
+// created when missing. An asset or relation held by more than one store is kept once, with the earliest
+// creation time and the latest time it was seen, so the times of the findings are preserved. Each event is
+// recorded with the output directory and identifier it came from, and an event whose identifier is taken
+// in the destination by a different event receives a new identifier. Merging the same source twice copies nothing new.
+func MergeEvents(ctx context.Context, dst string, srcs ...string) (*MergeReport, error) {
+	dst = config.OutputDirectory(dst)
+	if abs, err := filepath.Abs(dst); err == nil {
+		dst = abs
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the destination directory: %v", err)
+	}
+
+	// The graph is opened first to create the schema and hold the lock on the destination
+	_, release, err := openDirGraph(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	db, err := openSQL(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSQL(db)
+
+	m := &merger{
+		ctx:       ctx,
+		dst:       db,
+		assets:    make(map[string]*repository.Asset),
+		relations: make(map[relationKey]*repository.Relation),
+		report:    new(MergeReport),
+	}
+	if err := m.indexDestination(); err != nil {
+		return nil, err
+	}
+
+	for _, src := range srcs {
+		src = config.OutputDirectory(src)
+		if abs, err := filepath.Abs(src); err == nil {
+			src = abs
+		}
+		if src == dst {
+			return m.report, fmt.Errorf("the output directory %s cannot be merged into itself", src)
+		}
+
+		if err := m.mergeStore(src); err != nil {
+			return m.report, fmt.Errorf("failed to merge %s: %v", src, err)
+		}
+		if err := m.mergeEvents(dst, src); err != nil {
+			return m.report, fmt.Errorf("failed to merge the events of %s: %v", src, err)
+		}
+	}
+	return m.report, nil
+}
+
+type relationKey struct {
+	rtype    string
+	from, to int64
+}
+
+// merger holds the index of the destination assets and relations during a merge.
+type merger struct {
+	ctx context.Context
+	dst *gorm.DB
+	// assets maps the type and content of each destination asset to its row
+	assets    map[string]*repository.Asset
+	relations map[relationKey]*repository.Relation
+	report    *MergeReport
+}
+
+func (m *merger) indexDestination() error {
+	var assets []repository.Asset
+	if err := m.dst.WithContext(m.ctx).FindInBatches(&assets, mergeBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range assets {
+			a := assets[i]
+			if key, _, err := assetKey(&a); err == nil {
+				m.assets[key] = &a
+			}
+		}
+		return nil
+	}).Error; err != nil {
+		return err
+	}
+
+	var rels []repository.Relation
+	return m.dst.WithContext(m.ctx).FindInBatches(&rels, mergeBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range rels {
+			r := rels[i]
+			m.relations[relationKey{rtype: r.Type, from: r.FromAssetID, to: r.ToAssetID}] = &r
+		}
+		return nil
+	}).Error
+}
+
+// mergeStore copies the assets and relations of the source graph into the destination.
+func (m *merger) mergeStore(src string) error {
+	// The source is locked while it is read, so no enumeration writes to it
+	_, release, err := openGraph(src)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	db, err := openSQL(src)
+	if err != nil {
+		return err
+	}
+	defer closeSQL(db)
+
+	// ids maps the identifiers of the source assets to those of the destination
+	ids := make(map[int64]int64)
+	var assets []repository.Asset
+	if err := db.WithContext(m.ctx).FindInBatches(&assets, mergeBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range assets {
+			if err := m.mergeAsset(&assets[i], ids); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error; err != nil {
+		return err
+	}
+
+	var rels []repository.Relation
+	return db.WithContext(m.ctx).FindInBatches(&rels, mergeBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range rels {
+			if err := m.mergeRelation(&rels[i], ids); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func (m *merger) mergeAsset(a *repository.Asset, ids map[int64]int64) error {
+	key, content, err := assetKey(a)
+	if err != nil {
+		// The assets outside of the model cannot be compared, and are left behind
+		return nil
+	}
+
+	if existing, found := m.assets[key]; found {
+		ids[a.ID] = existing.ID
+		m.report.AssetsMerged++
+		return m.widen(existing, &existing.CreatedAt, &existing.LastSeen, a.CreatedAt, a.LastSeen)
+	}
+
+	row := &repository.Asset{
+		CreatedAt: a.CreatedAt,
+		LastSeen:  a.LastSeen,
+		Type:      a.Type,
+		Content:   content,
+	}
+	if err := m.dst.WithContext(m.ctx).Create(row).Error; err != nil {
+		return err
+	}
+	m.assets[key] = row
+	ids[a.ID] = row.ID
+	m.report.AssetsAdded++
+	return nil
+}
+
+func (m *merger) mergeRelation(r *repository.Relation, ids map[int64]int64) error {
+	from, ok1 := ids[r.FromAssetID]
+	to, ok2 := ids[r.ToAssetID]
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	key := relationKey{rtype: r.Type, from: from, to: to}
+	if existing, found := m.relations[key]; found {
+		m.report.RelationsMerged++
+		return m.widen(existing, &existing.CreatedAt, &existing.LastSeen, r.CreatedAt, r.LastSeen)
+	}
+
+	row := &repository.Relation{
+		CreatedAt:   r.CreatedAt,
+		LastSeen:    r.LastSeen,
+		Type:        r.Type,
+		FromAssetID: from,
+		ToAssetID:   to,
+	}
+	if err := m.dst.WithContext(m.ctx).Omit("FromAsset", "ToAsset").Create(row).Error; err != nil {
+		return err
+	}
+	m.relations[key] = row
+	m.report.RelationsAdded++
+	return nil
+}
+
+// widen extends the times of the destination row to cover those of the source row.
+func (m *merger) widen(row interface{}, created, seen *time.Time, srcCreated, srcSeen time.Time) error {
+	updates := make(map[string]interface{})
+	if srcCreated.Before(*created) {
+		*created = srcCreated
+		updates["created_at"] = srcCreated
+	}
+	if srcSeen.After(*seen) {
+		*seen = srcSeen
+		updates["last_seen"] = srcSeen
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return m.dst.WithContext(m.ctx).Model(row).Updates(updates).Error
+}
+
+// mergeEvents copies the snapshots of the source events, recording where each event came from.
+func (m *merger) mergeEvents(dst, src string) error {
+	snaps, err := listSnapshots(src)
+	if err != nil || len(snaps) == 0 {
+		return err
+	}
+
+	store, err := snapshot.Open(filepath.Join(dst, snapshot.DirName))
+	if err != nil {
+		return err
+	}
+
+	for _, snap := range snaps {
+		mapping := &EventMapping{Dir: src, From: snap.ID, To: snap.ID}
+		m.report.Events = append(m.report.Events, mapping)
+
+		// The events merged before keep the origin recorded by the earlier merge
+		if _, found := snap.Settings[mergedKey]; !found {
+			if snap.Settings == nil {
+				snap.Settings = make(map[string]interface{})
+			}
+			snap.Settings[mergedKey] = map[string]interface{}{"dir": src, "id": snap.ID}
+		}
+
+		for n := 1; ; n++ {
+			existing, err := store.Load(mapping.To)
+			if err == snapshot.ErrNotFound {
+				break
+			} else if err != nil {
+				return err
+			}
+			if sameEvent(existing, snap) {
+				mapping.Duplicate = true
+				break
+			}
+			mapping.To = fmt.Sprintf("%s-m%d", snap.ID, n)
+		}
+		if mapping.Duplicate {
+			continue
+		}
+
+		snap.ID = mapping.To
+		if err := store.Save(snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameEvent returns true when the snapshots record the same event, regardless of where it was merged from.
+func sameEvent(a, b *snapshot.Snapshot) bool {
+	if !a.Start.Equal(b.Start) {
+		return false
+	}
+
+	strip := func(s *snapshot.Snapshot) *snapshot.Snapshot {
+		c := *s
+		c.Settings = make(map[string]interface{}, len(s.Settings))
+		for k, v := range s.Settings {
+			if k != mergedKey {
+				c.Settings[k] = v
+			}
+		}
+		return &c
+	}
+	return len(snapshot.Diff(strip(a), strip(b))) == 0
+}
+
+// assetKey returns the key identifying the asset across stores, and its canonical content.
+func assetKey(a *repository.Asset) (string, []byte, error) {
+	// The custom nodes are identified by their keys
+	if custom.IsCustom(a.Type) {
+		key, data, err := custom.ParseContent(a.Content)
+		if err != nil {
+			return "", nil, err
+		}
+
+		n := &custom.Node{Type: a.Type, Key: key, Data: data}
+		content, err := n.Content()
+		if err != nil {
+			return "", nil, err
+		}
+		return a.Type + "|" + key, content, nil
+	}
+
+	asset, err := a.Parse()
+	if err != nil {
+		return "", nil, err
+	}
+
+	content, err := asset.JSON()
+	if err != nil {
+		return "", nil, err
+	}
+	return a.Type + "|" + string(content), content, nil
+}
+
+// openSQL opens a separate connection to the local graph database of the output directory.
+func openSQL(dir string) (*gorm.DB, error) {
+	// The writes wait for the connection held by the graph instead of failing
+	dsn := filepath.Join(dir, GraphFile) + "?_pragma=busy_timeout(5000)"
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the graph database of %s: %v", dir, err)
+	}
+	return db, nil
+}
+
+func closeSQL(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ops performs the operations on the findings stored in an output directory without the subcommands,
+// such as listing the events, streaming their names, summarizing them, deleting them and merging the output
+// directories of several scan hosts into one. An event is an enumeration recorded by its configuration
+// snapshot, and the graph has no place for the events, so the names of an event are those within its domains
+// seen since it started. The graph database of the output directory is locked while an operation runs.
+package ops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/config/config"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// GraphFile is the name of the graph database file in the output directory.
+const GraphFile = "amass.sqlite"
+
+// ErrNoGraph is returned for an output directory without a graph database.
+var ErrNoGraph = errors.New("the output directory holds no graph database")
+
+// ErrEventNotFound is returned when the output directory holds no event with the identifier.
+var ErrEventNotFound = errors.New("the event was not found")
+
+// Origin is the event an event was merged from.
+type Origin struct {
+	// Dir is the output directory the event was merged from
+	Dir string `json:"dir"`
+	ID  string `json:"id"`
+}
+
+// Event describes an enumeration recorded in an output directory.
+type Event struct {
+	ID      string    `json:"id"`
+	Domains []string  `json:"domains"`
+	Start   time.Time `json:"start"`
+	Version string    `json:"version"`
+	// Sources holds the data sources queried by the enumeration
+	Sources []string `json:"sources,omitempty"`
+	// MergedFrom is set for the events merged from another output directory
+	MergedFrom *Origin `json:"merged_from,omitempty"`
+}
+
+// Summary counts the findings of an event.
+type Summary struct {
+	Event     *Event `json:"event"`
+	Names     int    `json:"names"`
+	Addresses int    `json:"addresses"`
+	Netblocks int    `json:"netblocks"`
+	ASNs      int    `json:"asns"`
+	// Domains holds the number of names within each domain of the event
+	Domains map[string]int `json:"domains"`
+}
+
+// mergedKey is the setting of the snapshot recording the event it was merged from.
+const mergedKey = "merged_from"
+
+// ListEvents returns the events recorded in the output directory, sorted by their start time.
+func ListEvents(dir string) ([]*Event, error) {
+	snaps, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0, len(snaps))
+	for _, snap := range snaps {
+		events = append(events, toEvent(snap))
+	}
+	return events, nil
+}
+
+// EventNames calls fn with each name of the event within the domain, or within all the domains of the
+// event when the domain is empty. The names are streamed from the graph, and an error returned by fn
+// stops the iteration.
+func EventNames(ctx context.Context, dir, event, d string, fn func(name string) error) error {
+	ev, err := findEvent(dir, event)
+	if err != nil {
+		return err
+	}
+
+	domains := ev.Domains
+	if d != "" {
+		domains = nil
+		for _, ed := range ev.Domains {
+			if strings.EqualFold(ed, d) {
+				domains = []string{ed}
+			}
+		}
+		if len(domains) == 0 {
+			return fmt.Errorf("the event %s did not enumerate %s", event, d)
+		}
+	}
+
+	g, release, err := openGraph(dir)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, dom := range domains {
+		it := cursor.NamesIterator(ctx, g, ev.Start, dom)
+		for it.Next() {
+			if err := fn(it.Name()); err != nil {
+				return err
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// EventSummary returns the number of names of the event, and of the addresses, netblocks and autonomous
+// systems they resolve to.
+func EventSummary(ctx context.Context, dir, event string) (*Summary, error) {
+	ev, err := findEvent(dir, event)
+	if err != nil {
+		return nil, err
+	}
+
+	g, release, err := openGraph(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	s := &Summary{Event: ev, Domains: make(map[string]int)}
+	addrs := make(map[netip.Addr]struct{})
+	for _, dom := range ev.Domains {
+		var names []string
+		it := cursor.NamesIterator(ctx, g, ev.Start, dom)
+		for it.Next() {
+			names = append(names, it.Name())
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		s.Domains[dom] = len(names)
+		s.Names += len(names)
+
+		if len(names) == 0 {
+			continue
+		}
+		pairs, err := g.NamesToAddrs(ctx, ev.Start, names...)
+		if err != nil {
+			continue
+		}
+		for _, p := range pairs {
+			if p.Addr != nil && p.Addr.Address.IsValid() {
+				addrs[p.Addr.Address] = struct{}{}
+			}
+		}
+	}
+	s.Addresses = len(addrs)
+
+	netblocks := make(map[string]struct{})
+	asns := make(map[int]struct{})
+	for addr := range addrs {
+		ip := network.IPAddress{Address: addr, Type: "IPv4"}
+		if addr.Is6() {
+			ip.Type = "IPv6"
+		}
+		found, err := g.DB.FindByContent(ip, time.Time{})
+		if err != nil || len(found) == 0 {
+			continue
+		}
+		rels, err := g.DB.IncomingRelations(found[0], ev.Start, "contains")
+		if err != nil {
+			continue
+		}
+		for _, rel := range rels {
+			nb, err := g.DB.FindById(rel.FromAsset.ID, time.Time{})
+			if err != nil {
+				continue
+			}
+			if n, ok := nb.Asset.(network.Netblock); ok {
+				netblocks[n.Cidr.String()] = struct{}{}
+			}
+			if ann, err := g.DB.IncomingRelations(nb, ev.Start, "announces"); err == nil {
+				for _, a := range ann {
+					if as, err := g.DB.FindById(a.FromAsset.ID, time.Time{}); err == nil {
+						if asn, ok := as.Asset.(network.AutonomousSystem); ok {
+							asns[asn.Number] = struct{}{}
+						}
+					}
+				}
+			}
+		}
+	}
+	s.Netblocks = len(netblocks)
+	s.ASNs = len(asns)
+	return s, ctx.Err()
+}
+
+// DeleteEvent removes the event from the output directory, along with the names it found that no later
+// event of the same domains saw again, and returns the number of names removed. The addresses and the
+// other infrastructure first stored by the event are removed once no name refers to them.
+func DeleteEvent(ctx context.Context, dir, event string) (int, error) {
+	snaps, err := listSnapshots(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var ev *snapshot.Snapshot
+	for _, snap := range snaps {
+		if snap.ID == event {
+			ev = snap
+		}
+	}
+	if ev == nil {
+		return 0, ErrEventNotFound
+	}
+
+	g, release, err := openGraph(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var removed int
+	for _, dom := range ev.Domains {
+		// The names seen by a later event of the domain belong to that event as well
+		var until time.Time
+		for _, snap := range snaps {
+			if snap.ID != ev.ID && snap.Start.After(ev.Start) && containsDomain(snap.Domains, dom) &&
+				(until.IsZero() || snap.Start.Before(until)) {
+				until = snap.Start
+			}
+		}
+
+		assets, err := g.DB.FindByScope([]oam.Asset{domain.FQDN{Name: dom}}, ev.Start)
+		if err != nil {
+			continue
+		}
+		for _, a := range assets {
+			if err := ctx.Err(); err != nil {
+				return removed, err
+			}
+			if _, ok := a.Asset.(domain.FQDN); !ok || a.CreatedAt.Before(ev.Start) {
+				continue
+			}
+			if !until.IsZero() && !a.LastSeen.Before(until) {
+				continue
+			}
+
+			infra := relatedAssets(g, a)
+			if err := g.DB.DeleteAsset(a.ID); err != nil {
+				return removed, fmt.Errorf("failed to remove the name %s: %v", a.Asset.(domain.FQDN).Name, err)
+			}
+			removed++
+			removeOrphans(g, infra, ev.Start)
+		}
+	}
+
+	store, err := snapshot.Open(filepath.Join(config.OutputDirectory(dir), snapshot.DirName))
+	if err != nil {
+		return removed, err
+	}
+	return removed, store.Delete(ev.ID)
+}
+
+// relatedAssets returns the assets the name refers to.
+func relatedAssets(g *netmap.Graph, a *types.Asset) []*types.Asset {
+	rels, err := g.DB.OutgoingRelations(a, time.Time{})
+	if err != nil {
+		return nil
+	}
+
+	var assets []*types.Asset
+	for _, rel := range rels {
+		// The custom nodes are left to the programs that registered them
+		if custom.IsCustom(rel.Type) {
+			continue
+		}
+		assets = append(assets, rel.ToAsset)
+	}
+	return assets
+}
+
+// removeOrphans removes the assets first stored since the time that no longer have a relation to them,
+// and follows the infrastructure they refer to in turn.
+func removeOrphans(g *netmap.Graph, assets []*types.Asset, since time.Time) {
+	for len(assets) > 0 {
+		a := assets[0]
+		assets = assets[1:]
+
+		full, err := g.DB.FindById(a.ID, time.Time{})
+		if err != nil || full.CreatedAt.Before(since) {
+			continue
+		}
+		if _, ok := full.Asset.(domain.FQDN); ok {
+			continue
+		}
+		if in, err := g.DB.IncomingRelations(full, time.Time{}); err != nil || len(in) > 0 {
+			continue
+		}
+
+		assets = append(assets, relatedAssets(g, full)...)
+		_ = g.DB.DeleteAsset(full.ID)
+	}
+}
+
+func containsDomain(domains []string, d string) bool {
+	for _, dom := range domains {
+		if strings.EqualFold(dom, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// findEvent returns the event of the output directory with the identifier.
+func findEvent(dir, id string) (*Event, error) {
+	events, err := ListEvents(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ev := range events {
+		if ev.ID == id {
+			return ev, nil
+		}
+	}
+	return nil, ErrEventNotFound
+}
+
+// listSnapshots returns the snapshots of the output directory, without creating the directory of the snapshots.
+func listSnapshots(dir string) ([]*snapshot.Snapshot, error) {
+	path := filepath.Join(config.OutputDirectory(dir), snapshot.DirName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	store, err := snapshot.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return store.List()
+}
+
+func toEvent(snap *snapshot.Snapshot) *Event {
+	ev := &Event{
+		ID:      snap.ID,
+		Domains: snap.Domains,
+		Start:   snap.Start,
+		Version: snap.Version,
+	}
+
+	if srcs, ok := snap.Settings["sources"].([]interface{}); ok {
+		for _, s := range srcs {
+			if name, ok := s.(string); ok {
+				ev.Sources = append(ev.Sources, name)
+			}
+		}
+		sort.Strings(ev.Sources)
+	}
+	if m, ok := snap.Settings[mergedKey].(map[string]interface{}); ok {
+		o := &Origin{}
+		o.Dir, _ = m["dir"].(string)
+		o.ID, _ = m["id"].(string)
+		ev.MergedFrom = o
+	}
+	return ev
+}
+
+// openGraph opens the graph database of the output directory, which must already hold one.
+func openGraph(dir string) (*netmap.Graph, func(), error) {
+	if _, err := os.Stat(filepath.Join(config.OutputDirectory(dir), GraphFile)); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNoGraph, dir)
+	}
+	return openDirGraph(dir)
+}
+
+// openDirGraph opens the graph database of the output directory, creating it when missing.
+func openDirGraph(dir string) (*netmap.Graph, func(), error) {
+	cfg := config.NewConfig()
+	cfg.Dir = dir
+	cfg.Log = log.New(io.Discard, "", 0)
+	return systems.OpenPrimaryGraph(cfg)
+}
@@ -0,0 +1,265 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package ops
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/asset-db/repository"
+	"github.com/owasp-amass/config/config"
+)
+
+type fixture struct {
+	start   time.Time
+	domains []string
+	sources []string
+	names   map[string]string
+}
+
+// buildStore creates an output directory holding the events, with the names of each event
+// resolving to the addresses provided.
+func buildStore(t *testing.T, events ...fixture) string {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	g, release, err := openDirGraph(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range events {
+		for name, addr := range ev.names {
+			if err := g.UpsertA(ctx, name, addr); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.UpsertInfrastructure(ctx, 64496, "TEST-AS", addr, addr+"/32"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	release()
+
+	store, err := snapshot.Open(filepath.Join(dir, snapshot.DirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range events {
+		cfg := config.NewConfig()
+		cfg.AddDomains(ev.domains...)
+		cfg.CollectionStartTime = ev.start
+		snap, err := snapshot.New(cfg, ev.sources)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// setTimes changes the times of the name in the graph of the output directory.
+func setTimes(t *testing.T, dir, name string, created, seen time.Time) {
+	db, err := openSQL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSQL(db)
+
+	if err := db.Model(&repository.Asset{}).Where("content->>'name' = ?", name).
+		Updates(map[string]interface{}{"created_at": created, "last_seen": seen}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nameTimes(t *testing.T, dir, name string) (time.Time, time.Time) {
+	db, err := openSQL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSQL(db)
+
+	var a repository.Asset
+	if err := db.Where("content->>'name' = ?", name).First(&a).Error; err != nil {
+		t.Fatalf("the name %s was not stored: %v", name, err)
+	}
+	return a.CreatedAt, a.LastSeen
+}
+
+func eventNames(t *testing.T, dir, event, d string) []string {
+	var names []string
+	if err := EventNames(context.Background(), dir, event, d, func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestEvents(t *testing.T) {
+	start := time.Now().Add(-time.Hour).UTC()
+	dir := buildStore(t, fixture{
+		start:   start,
+		domains: []string{"owasp.org", "example.com"},
+		sources: []string{"crtsh", "DNS"},
+		names: map[string]string{
+			"www.owasp.org":   "192.0.2.1",
+			"mail.owasp.org":  "192.0.2.2",
+			"www.example.com": "192.0.2.1",
+		},
+	})
+
+	events, err := ListEvents(dir)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("the events listed were %v: %v", events, err)
+	}
+	ev := events[0]
+	if ev.ID != snapshot.ID(ev.Domains, start) || len(ev.Sources) != 2 || ev.MergedFrom != nil {
+		t.Errorf("the metadata of the event was %+v", ev)
+	}
+
+	// The graph holds the root domain names stored with the names beneath them
+	if names := eventNames(t, dir, ev.ID, "owasp.org"); len(names) != 3 || names[0] != "mail.owasp.org" {
+		t.Errorf("the names of the event within owasp.org were %v", names)
+	}
+	if names := eventNames(t, dir, ev.ID, ""); len(names) != 5 {
+		t.Errorf("the names of the event were %v", names)
+	}
+	if err := EventNames(context.Background(), dir, ev.ID, "example.net", func(string) error { return nil }); err == nil {
+		t.Error("the names of a domain outside of the event were streamed")
+	}
+	if err := EventNames(context.Background(), dir, "missing", "", func(string) error { return nil }); err != ErrEventNotFound {
+		t.Errorf("a missing event returned %v, expected ErrEventNotFound", err)
+	}
+
+	s, err := EventSummary(context.Background(), dir, ev.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Names != 5 || s.Domains["owasp.org"] != 3 || s.Addresses != 2 || s.Netblocks != 2 || s.ASNs != 1 {
+		t.Errorf("the summary of the event was %+v", s)
+	}
+
+	removed, err := DeleteEvent(context.Background(), dir, ev.ID)
+	if err != nil || removed != 5 {
+		t.Errorf("deleting the event removed %d names: %v", removed, err)
+	}
+	if events, err := ListEvents(dir); err != nil || len(events) != 0 {
+		t.Errorf("the deleted event was still listed: %v", events)
+	}
+}
+
+func TestDeleteEventKeepsLaterNames(t *testing.T) {
+	first := time.Now().Add(-2 * time.Hour).UTC()
+	dir := buildStore(t,
+		fixture{start: first, domains: []string{"owasp.org"}, names: map[string]string{
+			"www.owasp.org": "192.0.2.1",
+			"old.owasp.org": "192.0.2.9",
+		}},
+		fixture{start: first.Add(time.Hour), domains: []string{"owasp.org"}, names: map[string]string{"dev.owasp.org": "192.0.2.2"}},
+	)
+	// The first event found a name that was not seen again
+	setTimes(t, dir, "old.owasp.org", first, first.Add(time.Minute))
+
+	events, err := ListEvents(dir)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("the events listed were %v: %v", events, err)
+	}
+	// The other names stored by the fixture were seen again during the second event
+	if removed, err := DeleteEvent(context.Background(), dir, events[0].ID); err != nil || removed != 1 {
+		t.Errorf("deleting the first event removed %d names: %v", removed, err)
+	}
+	if names := eventNames(t, dir, events[1].ID, ""); len(names) != 3 {
+		t.Errorf("the names of the later event were %v", names)
+	}
+}
+
+func TestMergeEvents(t *testing.T) {
+	start := time.Now().Add(-time.Hour).UTC()
+	shared := fixture{
+		start:   start,
+		domains: []string{"owasp.org"},
+		sources: []string{"crtsh"},
+		names:   map[string]string{"www.owasp.org": "192.0.2.1", "a.owasp.org": "192.0.2.2"},
+	}
+	hostA := buildStore(t, shared)
+	// The second host ran an event of the same domain starting at the same time, and an event of its own
+	collide := shared
+	collide.sources = []string{"DNS"}
+	collide.names = map[string]string{"www.owasp.org": "192.0.2.1", "b.owasp.org": "192.0.2.3"}
+	hostB := buildStore(t, collide, fixture{
+		start:   start.Add(time.Minute),
+		domains: []string{"example.com"},
+		names:   map[string]string{"www.example.com": "192.0.2.4"},
+	})
+
+	old := start.Add(-24 * time.Hour)
+	setTimes(t, hostB, "www.owasp.org", old, old.Add(time.Hour))
+	_, seenA := nameTimes(t, hostA, "www.owasp.org")
+
+	dst := filepath.Join(t.TempDir(), "merged")
+	report, err := MergeEvents(context.Background(), dst, hostA, hostB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The name www.owasp.org, its root domain name, address, netblock and autonomous system,
+	// along with the organization of the autonomous system, are held by both stores
+	if report.AssetsAdded != 16 || report.AssetsMerged != 6 {
+		t.Errorf("the merge added %d assets and merged %d", report.AssetsAdded, report.AssetsMerged)
+	}
+	if report.RelationsMerged == 0 || report.RelationsAdded == 0 {
+		t.Errorf("the merge added %d relations and merged %d", report.RelationsAdded, report.RelationsMerged)
+	}
+
+	// The name found by both hosts keeps the earliest creation and the latest time it was seen
+	if created, seen := nameTimes(t, dst, "www.owasp.org"); !created.Equal(old) || !seen.Equal(seenA) {
+		t.Errorf("the merged name has the times %v and %v", created, seen)
+	}
+
+	events, err := ListEvents(dst)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("the merged events were %v: %v", events, err)
+	}
+	ids := make(map[string]*Event)
+	for _, ev := range events {
+		ids[ev.ID] = ev
+		if ev.MergedFrom == nil || ev.MergedFrom.Dir == "" {
+			t.Errorf("the merged event %s has no origin", ev.ID)
+		}
+	}
+	id := snapshot.ID([]string{"owasp.org"}, start)
+	if ev := ids[id]; ev == nil || ev.MergedFrom.ID != id || ev.Sources[0] != "crtsh" {
+		t.Errorf("the event of the first host was not kept: %+v", ev)
+	}
+	if ev := ids[id+"-m1"]; ev == nil || ev.MergedFrom.ID != id || ev.Sources[0] != "DNS" {
+		t.Errorf("the colliding event of the second host was not remapped: %+v", ev)
+	}
+	if names := eventNames(t, dst, id, ""); len(names) != 4 {
+		t.Errorf("the names of the merged domain were %v", names)
+	}
+
+	// Merging a source again copies nothing new
+	again, err := MergeEvents(context.Background(), dst, hostB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.AssetsAdded != 0 || again.RelationsAdded != 0 {
+		t.Errorf("merging the source again added %d assets and %d relations", again.AssetsAdded, again.RelationsAdded)
+	}
+	for _, m := range again.Events {
+		if !m.Duplicate {
+			t.Errorf("the event %s was merged again as %s", m.From, m.To)
+		}
+	}
+	if _, err := MergeEvents(context.Background(), dst, dst); err == nil {
+		t.Error("the destination was merged into itself")
+	}
+}
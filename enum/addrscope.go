@@ -0,0 +1,470 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+	"golang.org/x/net/publicsuffix"
+)
+
+// NetblocksFile is the name of the file under the output directory holding the names observed in each netblock.
+const NetblocksFile = "netblocks.json"
+
+const (
+	// DefaultMinObservations is the number of distinct addresses an apex domain is observed on before it is promoted
+	DefaultMinObservations = 3
+	// DefaultSweepWorkers is the number of addresses swept at once
+	DefaultSweepWorkers = 50
+)
+
+// The sources of the names observed in the address scope, besides the data sources.
+const (
+	ObservedByPTR  = "ptr"
+	ObservedByCert = "cert"
+	ObservedByDNS  = "dns"
+)
+
+// ObservedName is a name observed on an address of the address scope.
+type ObservedName struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Sources []string `json:"sources"`
+}
+
+// NetblockObservations holds the names observed on the addresses of a netblock in the address scope.
+type NetblockObservations struct {
+	Netblock string         `json:"netblock"`
+	Names    []ObservedName `json:"names"`
+}
+
+// ApexObservation is an apex domain observed in the address scope, along with the number of distinct addresses.
+type ApexObservation struct {
+	Domain    string `json:"domain"`
+	Addresses int    `json:"addresses"`
+	Promoted  bool   `json:"promoted,omitempty"`
+}
+
+// AddressScopeReport is the output of an enumeration of the address scope, organized per netblock.
+type AddressScopeReport struct {
+	Netblocks []NetblockObservations `json:"netblocks"`
+	Domains   []ApexObservation      `json:"domains"`
+}
+
+// addressScope enumerates the addresses and netblocks given as the sole scope of the enumeration. The
+// addresses are swept for PTR records, certificates and passive DNS data, and the names observed on them
+// are kept per netblock. The apex domains observed on enough addresses are optionally promoted into the scope.
+type addressScope struct {
+	sync.Mutex
+	netblocks []*net.IPNet
+	ptr       bool
+	certs     bool
+	passive   bool
+	promote   bool
+	minObs    int
+	workers   int
+	sweeping  bool
+	// The names observed in each netblock, keyed by the name and the address
+	names map[string]map[string]*ObservedName
+	// The derivation of each name, used when the apex domain is promoted
+	derivs   map[string]string
+	apexes   map[string]map[string]struct{}
+	promoted map[string]bool
+}
+
+// AddressScopeOnly returns true when the configuration holds addresses or netblocks and no domain names,
+// which makes the addresses the sole scope of the enumeration.
+func AddressScopeOnly(cfg *config.Config) bool {
+	if cfg == nil || len(cfg.Domains()) > 0 {
+		return false
+	}
+	return len(cfg.Scope.Addresses) > 0 || len(cfg.Scope.CIDRs) > 0
+}
+
+// addressScopeFromConfig parses the 'address_scope' configuration options, and returns nil
+// unless the addresses are the sole scope of the enumeration.
+func addressScopeFromConfig(cfg *config.Config) *addressScope {
+	if !AddressScopeOnly(cfg) {
+		return nil
+	}
+
+	as := &addressScope{
+		ptr:      true,
+		passive:  true,
+		certs:    cfg.Active,
+		minObs:   DefaultMinObservations,
+		workers:  DefaultSweepWorkers,
+		names:    make(map[string]map[string]*ObservedName),
+		derivs:   make(map[string]string),
+		apexes:   make(map[string]map[string]struct{}),
+		promoted: make(map[string]bool),
+	}
+	for _, addr := range cfg.Scope.Addresses {
+		bits := 8 * net.IPv4len
+		if amassnet.IsIPv6(addr) {
+			bits = 8 * net.IPv6len
+		}
+		as.netblocks = append(as.netblocks, &net.IPNet{IP: addr, Mask: net.CIDRMask(bits, bits)})
+	}
+	as.netblocks = append(as.netblocks, cfg.Scope.CIDRs...)
+
+	if opts, ok := cfg.Options["address_scope"].(map[string]interface{}); ok {
+		if v, ok := opts["ptr"].(bool); ok {
+			as.ptr = v
+		}
+		if v, ok := opts["passive"].(bool); ok {
+			as.passive = v
+		}
+		// The certificates are only pulled from the addresses during active enumerations
+		if v, ok := opts["certs"].(bool); ok {
+			as.certs = v && cfg.Active
+		}
+		as.promote, _ = opts["promote"].(bool)
+		if n := options.Int(opts["min_observations"]); n > 0 {
+			as.minObs = n
+		}
+		if n := options.Int(opts["workers"]); n > 0 {
+			as.workers = n
+		}
+	}
+	return as
+}
+
+// netblock returns the most specific netblock of the scope holding the address, or an empty string.
+func (as *addressScope) netblock(ip net.IP) string {
+	var best *net.IPNet
+	bestLen := -1
+
+	for _, n := range as.netblocks {
+		if !n.Contains(ip) {
+			continue
+		}
+		if ones, _ := n.Mask.Size(); ones > bestLen {
+			best, bestLen = n, ones
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.String()
+}
+
+// observe records the name observed on the address, and returns the apex domain of the name when
+// it has just been observed on enough distinct addresses to be promoted into the scope.
+func (as *addressScope) observe(name, addr, source, derivation string) string {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return ""
+	}
+	name = strings.Trim(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name = amassdns.RemoveAsteriskLabel(name); name == "" {
+		return ""
+	}
+
+	block := as.netblock(ip)
+	if block == "" {
+		return ""
+	}
+
+	as.Lock()
+	defer as.Unlock()
+
+	set, found := as.names[block]
+	if !found {
+		set = make(map[string]*ObservedName)
+		as.names[block] = set
+	}
+
+	k := name + "|" + ip.String()
+	obs, found := set[k]
+	if !found {
+		obs = &ObservedName{Name: name, Address: ip.String()}
+		set[k] = obs
+	}
+	if !containsString(obs.Sources, source) {
+		obs.Sources = append(obs.Sources, source)
+	}
+	if _, found := as.derivs[name]; !found {
+		as.derivs[name] = derivation
+	}
+
+	apex, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return ""
+	}
+	addrs, found := as.apexes[apex]
+	if !found {
+		addrs = make(map[string]struct{})
+		as.apexes[apex] = addrs
+	}
+	addrs[ip.String()] = struct{}{}
+
+	if as.promote && !as.promoted[apex] && len(addrs) >= as.minObs {
+		as.promoted[apex] = true
+		return apex
+	}
+	return ""
+}
+
+// namesUnder returns the names observed under the apex domain, along with their derivations.
+func (as *addressScope) namesUnder(apex string) map[string]string {
+	as.Lock()
+	defer as.Unlock()
+
+	names := make(map[string]string)
+	for name, deriv := range as.derivs {
+		if name == apex || strings.HasSuffix(name, "."+apex) {
+			names[name] = deriv
+		}
+	}
+	return names
+}
+
+func (as *addressScope) setSweeping(sweeping bool) {
+	as.Lock()
+	defer as.Unlock()
+
+	as.sweeping = sweeping
+}
+
+// busy returns true while the addresses are being swept.
+func (as *addressScope) busy() bool {
+	if as == nil {
+		return false
+	}
+
+	as.Lock()
+	defer as.Unlock()
+
+	return as.sweeping
+}
+
+// hosts returns the addresses of the scope to be swept. The IPv6 netblocks are skipped, since
+// they are simply too large, while the IPv6 addresses given individually are swept.
+func (as *addressScope) hosts(log func(string, ...interface{})) []net.IP {
+	var ips []net.IP
+
+	for _, n := range as.netblocks {
+		if ones, bits := n.Mask.Size(); ones == bits {
+			ips = append(ips, n.IP)
+			continue
+		}
+		if amassnet.IsIPv6(n.IP) {
+			log("Skipping the sweep of the IPv6 netblock %s", n.String())
+			continue
+		}
+		ips = append(ips, amassnet.AllHosts(n)...)
+	}
+	return ips
+}
+
+// observeAddress records the name observed on the address when the addresses are the sole scope,
+// and brings the apex domain of the name into the scope once it has been observed enough.
+func (e *Enumeration) observeAddress(name, addr, source, derivation string) {
+	if e.addrScope == nil {
+		return
+	}
+
+	e.completion.activity(source, true)
+	if apex := e.addrScope.observe(name, addr, source, derivation); apex != "" {
+		e.promoteApex(apex)
+	}
+}
+
+// observeRequest records the name delivered by a data source outside of the domains in scope, along with
+// the addresses in its records, and returns false when the name was not observed in the address scope.
+func (e *Enumeration) observeRequest(req *requests.DNSRequest) bool {
+	if e.addrScope == nil {
+		return false
+	}
+
+	var observed bool
+	for _, r := range req.Records {
+		if t := uint16(r.Type); t != dns.TypeA && t != dns.TypeAAAA {
+			continue
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Data)); ip != nil && e.addrScope.netblock(ip) != "" {
+			e.observeAddress(req.Name, r.Data, findingSource(req), req.Derivation)
+			observed = true
+		}
+	}
+	return observed
+}
+
+// promoteApex brings the apex domain into the scope of the enumeration, along with the names already observed under it.
+func (e *Enumeration) promoteApex(apex string) {
+	e.Config.AddDomain(apex)
+	if e.Config.WhichDomain(apex) == "" {
+		return
+	}
+
+	names := e.addrScope.namesUnder(apex)
+	e.Config.Log.Printf("Promoting %s into the scope of the enumeration: it was observed on %d addresses, with %d names under it",
+		apex, e.addrScope.minObs, len(names))
+
+	req := &requests.DNSRequest{
+		Name:       apex,
+		Domain:     apex,
+		Derivation: requests.DerivedFromSeed,
+	}
+	e.prov.add(apex, "", requests.DerivedFromSeed)
+	e.nameSrc.newName(req)
+	e.sendRequests(req.Clone().(*requests.DNSRequest))
+	e.regs.domain(apex)
+
+	for name, deriv := range names {
+		if name != apex {
+			e.nameSrc.newName(&requests.DNSRequest{Name: name, Domain: apex, Derivation: deriv})
+		}
+	}
+}
+
+// sweepAddressScope looks for the names on each address of the scope, through the PTR records, the certificates
+// served on the ports in scope and the passive DNS data sources.
+func (e *Enumeration) sweepAddressScope(ctx context.Context) {
+	as := e.addrScope
+	if as == nil {
+		return
+	}
+
+	as.setSweeping(true)
+	defer as.setSweeping(false)
+
+	hosts := as.hosts(e.Config.Log.Printf)
+	// The addresses are swept by the shard they are assigned to
+	if e.shard != nil {
+		var owned []net.IP
+		for _, ip := range hosts {
+			if e.shard.owns(ip.String()) {
+				owned = append(owned, ip)
+			} else {
+				e.shard.skip()
+			}
+		}
+		hosts = owned
+	}
+	e.Config.Log.Printf("Sweeping %d addresses in %d netblocks of the address scope", len(hosts), len(as.netblocks))
+
+	ch := make(chan net.IP, as.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < as.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ip := range ch {
+				e.sweepAddress(ctx, ip)
+			}
+		}()
+	}
+
+loop:
+	for _, ip := range hosts {
+		select {
+		case <-ctx.Done():
+			break loop
+		case ch <- ip:
+		}
+	}
+	close(ch)
+	wg.Wait()
+}
+
+func (e *Enumeration) sweepAddress(ctx context.Context, ip net.IP) {
+	as := e.addrScope
+	addr := ip.String()
+
+	if as.passive {
+		e.sendRequests(&requests.AddrRequest{Address: addr, InScope: true})
+	}
+	if as.ptr && !e.Policy.BlocksAddress(policy.DNS, addr) {
+		if arpa, err := dns.ReverseAddr(addr); err == nil {
+			if resp, err := e.dnsQuery(ctx, arpa, dns.TypePTR, e.trustedPool(), 3); err == nil && resp != nil {
+				for _, a := range resolve.AnswersByType(resolve.ExtractAnswers(resp), dns.TypePTR) {
+					e.observeAddress(resolve.RemoveLastDot(a.Data), addr, ObservedByPTR, requests.DerivedFromPTR)
+				}
+			}
+		}
+	}
+	if as.certs && !e.Policy.BlocksAddress(policy.Port, addr) {
+		for _, name := range http.PullCertificateNames(ctx, addr, e.Config.Scope.Ports) {
+			e.observeAddress(name, addr, ObservedByCert, requests.DerivedFromCert)
+		}
+	}
+}
+
+// AddressScopeReport returns the names observed in each netblock of the address scope and the apex domains
+// observed, or nil when the addresses were not the sole scope of the enumeration.
+func (e *Enumeration) AddressScopeReport() *AddressScopeReport {
+	as := e.addrScope
+	if as == nil {
+		return nil
+	}
+
+	as.Lock()
+	defer as.Unlock()
+
+	report := &AddressScopeReport{
+		Netblocks: []NetblockObservations{},
+		Domains:   []ApexObservation{},
+	}
+	for block, set := range as.names {
+		nb := NetblockObservations{Netblock: block}
+
+		for _, obs := range set {
+			o := *obs
+			o.Sources = append([]string(nil), obs.Sources...)
+			sort.Strings(o.Sources)
+			nb.Names = append(nb.Names, o)
+		}
+		sort.Slice(nb.Names, func(i, j int) bool {
+			if nb.Names[i].Name != nb.Names[j].Name {
+				return nb.Names[i].Name < nb.Names[j].Name
+			}
+			return nb.Names[i].Address < nb.Names[j].Address
+		})
+		report.Netblocks = append(report.Netblocks, nb)
+	}
+	sort.Slice(report.Netblocks, func(i, j int) bool {
+		return report.Netblocks[i].Netblock < report.Netblocks[j].Netblock
+	})
+
+	for apex, addrs := range as.apexes {
+		report.Domains = append(report.Domains, ApexObservation{
+			Domain:    apex,
+			Addresses: len(addrs),
+			Promoted:  as.promoted[apex],
+		})
+	}
+	sort.Slice(report.Domains, func(i, j int) bool {
+		if report.Domains[i].Addresses != report.Domains[j].Addresses {
+			return report.Domains[i].Addresses > report.Domains[j].Addresses
+		}
+		return report.Domains[i].Domain < report.Domains[j].Domain
+	})
+	return report
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func addressScopeConfig(t *testing.T, cidrs ...string) *config.Config {
+	cfg := config.NewConfig()
+
+	for _, c := range cidrs {
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Scope.CIDRs = append(cfg.Scope.CIDRs, ipnet)
+	}
+	return cfg
+}
+
+func TestAddressScopeConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if AddressScopeOnly(cfg) || addressScopeFromConfig(cfg) != nil {
+		t.Errorf("the address scope was enabled without addresses")
+	}
+
+	cfg = addressScopeConfig(t, "192.0.2.0/24")
+	cfg.Scope.Addresses = []net.IP{net.ParseIP("198.51.100.7")}
+	cfg.Options["address_scope"] = map[string]interface{}{"ptr": false, "certs": true, "promote": true, "min_observations": 2}
+	as := addressScopeFromConfig(cfg)
+	if as == nil || as.ptr || !as.passive || as.certs || !as.promote || as.minObs != 2 || len(as.netblocks) != 2 {
+		t.Fatalf("the options were not parsed: %+v", as)
+	}
+	// The certificates are only pulled in the active mode, and the addresses are swept on their own
+	if hosts := as.hosts(t.Logf); len(hosts) != 255 {
+		t.Errorf("%d addresses would be swept", len(hosts))
+	}
+
+	cfg.AddDomain("owasp.org")
+	if AddressScopeOnly(cfg) {
+		t.Errorf("the address scope was the sole scope alongside a domain name")
+	}
+}
+
+func TestAddressScopeObserve(t *testing.T) {
+	cfg := addressScopeConfig(t, "192.0.2.0/24", "192.0.2.128/25", "2001:db8::/32")
+	e := &Enumeration{Config: cfg, addrScope: addressScopeFromConfig(cfg)}
+
+	e.observeAddress("www.owasp.org.", "192.0.2.10", ObservedByPTR, requests.DerivedFromPTR)
+	e.observeAddress("www.owasp.org", "192.0.2.10", ObservedByCert, requests.DerivedFromCert)
+	e.observeAddress("*.mail.owasp.org", "2001:db8::25", ObservedByCert, requests.DerivedFromCert)
+	e.observeAddress("vpn.example.com", "192.0.2.200", ObservedByPTR, requests.DerivedFromPTR)
+	e.observeAddress("outside.example.com", "203.0.113.1", ObservedByPTR, requests.DerivedFromPTR)
+	// The name delivered by a data source carries the addresses it was found on
+	if !e.observeRequest(&requests.DNSRequest{
+		Name:       "api.example.com",
+		Parent:     "CIRCL",
+		Derivation: requests.DerivedFromSource,
+		Records:    []requests.DNSAnswer{{Name: "api.example.com", Type: int(dns.TypeA), Data: "192.0.2.201"}},
+	}) {
+		t.Errorf("the name found on an address in scope was not observed")
+	}
+
+	report := e.AddressScopeReport()
+	if len(report.Netblocks) != 3 {
+		t.Fatalf("the names were observed in %d netblocks", len(report.Netblocks))
+	}
+	// The most specific netblock holds the address
+	for _, nb := range report.Netblocks {
+		switch nb.Netblock {
+		case "192.0.2.0/24":
+			if len(nb.Names) != 1 || nb.Names[0].Name != "www.owasp.org" || len(nb.Names[0].Sources) != 2 {
+				t.Errorf("the netblock %s holds %+v", nb.Netblock, nb.Names)
+			}
+		case "192.0.2.128/25":
+			if len(nb.Names) != 2 || nb.Names[0].Name != "api.example.com" || nb.Names[0].Sources[0] != "CIRCL" {
+				t.Errorf("the netblock %s holds %+v", nb.Netblock, nb.Names)
+			}
+		case "2001:db8::/32":
+			if len(nb.Names) != 1 || nb.Names[0].Name != "mail.owasp.org" {
+				t.Errorf("the netblock %s holds %+v", nb.Netblock, nb.Names)
+			}
+		default:
+			t.Errorf("the names were observed in the netblock %s", nb.Netblock)
+		}
+	}
+
+	if len(report.Domains) != 2 || report.Domains[0].Domain != "example.com" || report.Domains[0].Addresses != 2 {
+		t.Errorf("the apex domains observed are %+v", report.Domains)
+	}
+}
+
+func TestAddressScopePromotion(t *testing.T) {
+	cfg := addressScopeConfig(t, "192.0.2.0/24")
+	cfg.Options["address_scope"] = map[string]interface{}{"promote": true, "min_observations": 3}
+	as := addressScopeFromConfig(cfg)
+
+	for i, addr := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		if apex := as.observe("host.owasp.org", addr, ObservedByPTR, requests.DerivedFromPTR); apex != "" {
+			t.Errorf("%s was promoted after %d observations", apex, i+1)
+		}
+	}
+	if apex := as.observe("www.owasp.org", "192.0.2.3", ObservedByPTR, requests.DerivedFromPTR); apex != "owasp.org" {
+		t.Errorf("the apex domain observed on three addresses was not promoted")
+	}
+	if apex := as.observe("dev.owasp.org", "192.0.2.4", ObservedByPTR, requests.DerivedFromPTR); apex != "" {
+		t.Errorf("the apex domain was promoted twice")
+	}
+	if names := as.namesUnder("owasp.org"); len(names) != 3 || names["www.owasp.org"] != requests.DerivedFromPTR {
+		t.Errorf("the names under the apex domain are %v", names)
+	}
+
+	// The apex domains are only promoted when requested
+	delete(cfg.Options, "address_scope")
+	as = addressScopeFromConfig(cfg)
+	for _, addr := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+		if apex := as.observe("host.owasp.org", addr, ObservedByPTR, requests.DerivedFromPTR); apex != "" {
+			t.Errorf("%s was promoted without the option", apex)
+		}
+	}
+}
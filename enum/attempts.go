@@ -0,0 +1,56 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// generatedCandidate returns true for the names generated by the brute forcing and the name alterations,
+// which are guesses rather than findings of the data sources.
+func generatedCandidate(req *requests.DNSRequest) bool {
+	return req != nil && (req.Derivation == requests.DerivedFromBrute || req.Derivation == requests.DerivedFromAlteration)
+}
+
+// attemptedBefore returns true when the generated candidate failed to resolve during an earlier enumeration
+// within the retry age, so it is not queried again.
+func (e *Enumeration) attemptedBefore(req *requests.DNSRequest) bool {
+	if e.Attempts == nil || !generatedCandidate(req) {
+		return false
+	}
+	return e.Attempts.Recent(req.Domain, req.Name, e.Config.CollectionStartTime)
+}
+
+// attempted remembers the generated candidate that does not exist, along with the start of the enumeration.
+// The candidates that resolved are held by the graph, and the timeouts say nothing of the name.
+func (e *Enumeration) attempted(req *requests.DNSRequest, d Disposition) {
+	if e.Attempts == nil || d != DispositionNXDomain || !generatedCandidate(req) {
+		return
+	}
+
+	if err := e.Attempts.Add(req.Domain, req.Name, e.Config.CollectionStartTime); err != nil {
+		e.Config.Log.Printf("Failed to remember the attempted candidate %s: %v", req.Name, err)
+	}
+}
+
+// finishAttempts writes the attempted candidates, and logs how many of them were skipped and remembered.
+func (e *Enumeration) finishAttempts() {
+	if e.Attempts == nil {
+		return
+	}
+
+	if err := e.Attempts.Flush(); err != nil {
+		e.Config.Log.Printf("%v", err)
+	}
+
+	stats := e.Attempts.Stats()
+	if stats.Discarded > 0 {
+		e.Config.Log.Printf("Discarded %d unreadable files of the attempted candidates", stats.Discarded)
+	}
+	if stats.Skipped > 0 || stats.Added > 0 {
+		e.Config.Log.Printf("Skipped %d candidates that failed within the last %s, and remembered %d new failures",
+			stats.Skipped, e.Attempts.RetryAge(), stats.Added)
+	}
+}
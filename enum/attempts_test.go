@@ -0,0 +1,69 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/attempts"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+func TestAttemptedCandidates(t *testing.T) {
+	dir := t.TempDir()
+	earlier := time.Now().Add(-48 * time.Hour)
+
+	// The earlier enumeration remembers the generated candidates that do not exist
+	set, err := attempts.Open(dir, &attempts.Config{RetryAge: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := policyEnumeration(t)
+	e.Config.CollectionStartTime = earlier
+	e.Attempts = set
+	for _, c := range []struct {
+		req  *requests.DNSRequest
+		disp Disposition
+	}{
+		{&requests.DNSRequest{Name: "dev.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute}, DispositionNXDomain},
+		{&requests.DNSRequest{Name: "dev1.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromAlteration}, DispositionNXDomain},
+		{&requests.DNSRequest{Name: "slow.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute}, DispositionTimeout},
+		{&requests.DNSRequest{Name: "old.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromSource}, DispositionNXDomain},
+	} {
+		e.attempted(c.req, c.disp)
+	}
+	if err := set.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err = attempts.Open(dir, &attempts.Config{RetryAge: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+	e = policyEnumeration(t)
+	e.Attempts = set
+
+	for _, req := range []*requests.DNSRequest{
+		{Name: "dev.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute},
+		{Name: "dev1.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromAlteration},
+		{Name: "slow.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute},
+		{Name: "old.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute},
+		// The data sources provide the names they found, which are always resolved
+		{Name: "dev.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromSource},
+	} {
+		e.nameSrc.newName(req)
+	}
+	if n := e.nameSrc.queue.Len(); n != 3 {
+		t.Errorf("%d names were brought into the enumeration", n)
+	}
+	if rec, found := e.WhyNot("dev1.owasp.org"); !found || rec.Disposition != DispositionAttempted {
+		t.Errorf("the candidate that failed before has the disposition %+v", rec)
+	}
+	if st := set.Stats(); st.Skipped != 2 {
+		t.Errorf("%d candidates were skipped", st.Skipped)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// authorityStore keeps the addresses answered with the AA bit set, since the graph has no place for the
+// property of the records.
+type authorityStore struct {
+	sync.Mutex
+	addrs map[string]map[string]struct{}
+}
+
+func newAuthorityStore() *authorityStore {
+	return &authorityStore{addrs: make(map[string]map[string]struct{})}
+}
+
+func (as *authorityStore) add(name string, records []requests.DNSAnswer) {
+	if as == nil {
+		return
+	}
+
+	as.Lock()
+	defer as.Unlock()
+
+	name = strings.ToLower(name)
+	for _, rec := range records {
+		if t := uint16(rec.Type); !rec.Authoritative || (t != dns.TypeA && t != dns.TypeAAAA) {
+			continue
+		}
+		if as.addrs[name] == nil {
+			as.addrs[name] = make(map[string]struct{})
+		}
+		as.addrs[name][rec.Data] = struct{}{}
+	}
+}
+
+func (as *authorityStore) has(name, addr string) bool {
+	as.Lock()
+	defer as.Unlock()
+
+	_, found := as.addrs[strings.ToLower(name)][addr]
+	return found
+}
+
+// AuthoritativeAddress returns true when an authoritative server of the zone answered the address of the name.
+func (e *Enumeration) AuthoritativeAddress(name, addr string) bool {
+	if e.authority == nil {
+		return false
+	}
+	return e.authority.has(name, addr)
+}
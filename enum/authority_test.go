@@ -0,0 +1,31 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+func TestAuthoritativeAddress(t *testing.T) {
+	e := &Enumeration{authority: newAuthorityStore()}
+	e.authority.add("WWW.owasp.org", []requests.DNSAnswer{
+		{Name: "www.owasp.org", Type: int(dns.TypeA), Data: "192.0.2.1", Authoritative: true},
+		{Name: "www.owasp.org", Type: int(dns.TypeAAAA), Data: "2001:db8::1"},
+		{Name: "www.owasp.org", Type: int(dns.TypeCNAME), Data: "owasp.org", Authoritative: true},
+	})
+
+	if !e.AuthoritativeAddress("www.owasp.org", "192.0.2.1") {
+		t.Error("the address answered with the AA bit was not authoritative")
+	}
+	if e.AuthoritativeAddress("www.owasp.org", "2001:db8::1") || e.AuthoritativeAddress("owasp.org", "192.0.2.1") {
+		t.Error("the address answered by a recursive resolver was authoritative")
+	}
+	if (&Enumeration{}).AuthoritativeAddress("www.owasp.org", "192.0.2.1") {
+		t.Error("the enumeration without the store returned an authoritative address")
+	}
+}
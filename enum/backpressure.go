@@ -0,0 +1,217 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// The defaults of the 'graph_backpressure' configuration options.
+const (
+	// DefaultBackpressureBuffer is the number of writes waiting for the graph database that slows the dispatch
+	DefaultBackpressureBuffer = 1000
+	// DefaultBackpressureLatency is the recent write latency that slows the dispatch
+	DefaultBackpressureLatency = 250 * time.Millisecond
+	// DefaultBackpressureMaxDelay is the longest wait before the dispatch of a candidate
+	DefaultBackpressureMaxDelay = time.Second
+)
+
+// backpressureRelease is the fraction of the thresholds the pressure must drop under before the dispatch
+// runs at full speed again, so the throughput does not oscillate around the thresholds.
+const backpressureRelease = 0.5
+
+// latencyWeight is the weight of the newest write in the recent write latency.
+const latencyWeight = 0.25
+
+// writePressure slows the dispatch of the candidate names while the graph database falls behind the writes of
+// the enumeration, instead of letting the writes waiting for the database grow until the memory limit is hit.
+type writePressure struct {
+	sync.Mutex
+	clock    clock.Clock
+	high     int
+	latency  time.Duration
+	maxDelay time.Duration
+	// buffer returns the number of writes waiting for the graph database
+	buffer    func() int
+	recent    time.Duration
+	throttled bool
+	engaged   int
+	delayed   time.Duration
+}
+
+// BackpressureStats describes how much the graph database slowed the dispatch of the candidate names.
+type BackpressureStats struct {
+	// Engaged is the number of times the dispatch was slowed
+	Engaged int `json:"engaged"`
+	// Delayed is the total time the dispatch waited for the graph database
+	Delayed time.Duration `json:"delayed"`
+}
+
+// backpressureFromConfig parses the 'graph_backpressure' configuration options, and returns nil when the
+// dispatch is not coupled to the graph database. The coupling is enabled by default only for the remote
+// graph database systems, since the local and in-memory databases keep up with the enumeration.
+func backpressureFromConfig(cfg *config.Config, system string, c clock.Clock) *writePressure {
+	enabled := system != "" && system != "local" && system != systems.MemoryGraphSystem
+
+	var opts map[string]interface{}
+	if cfg != nil && cfg.Options != nil {
+		opts, _ = cfg.Options["graph_backpressure"].(map[string]interface{})
+	}
+	if v, ok := opts["enabled"].(bool); ok {
+		enabled = v
+	}
+	if !enabled {
+		return nil
+	}
+
+	wp := &writePressure{
+		clock:    c,
+		high:     DefaultBackpressureBuffer,
+		latency:  DefaultBackpressureLatency,
+		maxDelay: DefaultBackpressureMaxDelay,
+	}
+	if n := options.Int(opts["buffer"]); n > 0 {
+		wp.high = n
+	}
+	if n := options.Int(opts["latency"]); n > 0 {
+		wp.latency = time.Duration(n) * time.Millisecond
+	}
+	if n := options.Int(opts["max_delay"]); n > 0 {
+		wp.maxDelay = time.Duration(n) * time.Millisecond
+	}
+	return wp
+}
+
+// observe adds the latency of a write to the graph database to the recent write latency.
+func (wp *writePressure) observe(d time.Duration) {
+	if wp == nil {
+		return
+	}
+
+	wp.Lock()
+	defer wp.Unlock()
+
+	if wp.recent == 0 {
+		wp.recent = d
+		return
+	}
+	wp.recent += time.Duration(latencyWeight * float64(d-wp.recent))
+}
+
+// now returns the time a write to the graph database begins, to be passed to timed once it is done.
+func (wp *writePressure) now() time.Time {
+	if wp == nil {
+		return time.Time{}
+	}
+	return wp.clock.Now()
+}
+
+// timed observes the latency of the write that began at the start time.
+func (wp *writePressure) timed(start time.Time) {
+	if wp != nil {
+		wp.observe(wp.clock.Now().Sub(start))
+	}
+}
+
+// level returns the pressure of the graph database, which reaches 1 when the writes waiting for the
+// database or the recent write latency reach their thresholds. The lock must be held by the caller.
+func (wp *writePressure) level() float64 {
+	var level float64
+
+	if wp.buffer != nil {
+		level = float64(wp.buffer()) / float64(wp.high)
+	}
+	if l := float64(wp.recent) / float64(wp.latency); l > level {
+		level = l
+	}
+	return level
+}
+
+// delay returns how long the dispatch of the next candidate waits. The dispatch is slowed once the pressure
+// reaches 1, in proportion to the pressure up to twice the thresholds, and runs at full speed again only
+// once the pressure has dropped under half the thresholds.
+func (wp *writePressure) delay() time.Duration {
+	if wp == nil {
+		return 0
+	}
+
+	wp.Lock()
+	defer wp.Unlock()
+
+	level := wp.level()
+	if !wp.throttled && level >= 1 {
+		wp.throttled = true
+		wp.engaged++
+	} else if wp.throttled && level <= backpressureRelease {
+		wp.throttled = false
+	}
+	if !wp.throttled {
+		return 0
+	}
+
+	if level > 2 {
+		level = 2
+	}
+	d := time.Duration(float64(wp.maxDelay) * level / 2)
+	wp.delayed += d
+	return d
+}
+
+// wait blocks the dispatch of the next candidate for the delay asked by the graph database.
+func (wp *writePressure) wait(ctx context.Context, done chan struct{}) {
+	d := wp.delay()
+	if d <= 0 {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	case <-wp.clock.After(d):
+	}
+}
+
+// stats returns how much the dispatch was slowed so far.
+func (wp *writePressure) stats() BackpressureStats {
+	if wp == nil {
+		return BackpressureStats{}
+	}
+
+	wp.Lock()
+	defer wp.Unlock()
+
+	return BackpressureStats{Engaged: wp.engaged, Delayed: wp.delayed}
+}
+
+// Backpressure returns how much the graph database slowed the dispatch of the candidate names.
+func (e *Enumeration) Backpressure() BackpressureStats {
+	return e.pressure.stats()
+}
+
+// startBackpressure couples the dispatch to the writes waiting for their infrastructure in the data manager.
+func (e *Enumeration) startBackpressure() {
+	if e.pressure == nil {
+		return
+	}
+
+	e.pressure.Lock()
+	e.pressure.buffer = func() int { return e.store.queue.Len() }
+	e.pressure.Unlock()
+}
+
+// reportBackpressure logs how much the graph database slowed the enumeration.
+func (e *Enumeration) reportBackpressure() {
+	if s := e.pressure.stats(); s.Engaged > 0 {
+		e.Config.Log.Printf("The graph database slowed the dispatch of the names %d times, for %s in total",
+			s.Engaged, s.Delayed.Round(time.Millisecond))
+	}
+}
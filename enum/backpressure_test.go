@@ -0,0 +1,172 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/memory"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestBackpressureFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if backpressureFromConfig(cfg, "local", clock.System) != nil {
+		t.Error("the dispatch was coupled to the local graph database by default")
+	}
+	if backpressureFromConfig(cfg, "memory", clock.System) != nil {
+		t.Error("the dispatch was coupled to the in-memory graph database by default")
+	}
+
+	wp := backpressureFromConfig(cfg, "postgres", clock.System)
+	if wp == nil || wp.high != DefaultBackpressureBuffer || wp.latency != DefaultBackpressureLatency {
+		t.Fatalf("the defaults were not used for the remote graph database: %+v", wp)
+	}
+
+	cfg.Options = map[string]interface{}{"graph_backpressure": map[string]interface{}{"enabled": false}}
+	if backpressureFromConfig(cfg, "postgres", clock.System) != nil {
+		t.Error("the coupling was not disabled")
+	}
+
+	cfg.Options = map[string]interface{}{"graph_backpressure": map[string]interface{}{
+		"enabled":   true,
+		"buffer":    200,
+		"latency":   100,
+		"max_delay": 500,
+	}}
+	wp = backpressureFromConfig(cfg, "local", clock.System)
+	if wp == nil || wp.high != 200 || wp.latency != 100*time.Millisecond || wp.maxDelay != 500*time.Millisecond {
+		t.Errorf("the options were not parsed: %+v", wp)
+	}
+}
+
+func TestBackpressureHysteresis(t *testing.T) {
+	var buffered int
+	wp := &writePressure{
+		clock:    clock.NewFake(time.Now()),
+		high:     100,
+		latency:  time.Second,
+		maxDelay: time.Second,
+		buffer:   func() int { return buffered },
+	}
+
+	for _, step := range []struct {
+		buffered int
+		delay    time.Duration
+	}{
+		{buffered: 90},
+		// The dispatch slows down in proportion to the pressure
+		{buffered: 100, delay: 500 * time.Millisecond},
+		{buffered: 150, delay: 750 * time.Millisecond},
+		{buffered: 400, delay: time.Second},
+		// The dispatch stays slowed until the pressure drops under half the threshold
+		{buffered: 80, delay: 400 * time.Millisecond},
+		{buffered: 60, delay: 300 * time.Millisecond},
+		{buffered: 50},
+		{buffered: 80},
+	} {
+		buffered = step.buffered
+		if d := wp.delay(); d != step.delay {
+			t.Errorf("with %d writes buffered, the delay was %s instead of %s", step.buffered, d, step.delay)
+		}
+	}
+	if s := wp.stats(); s.Engaged != 1 {
+		t.Errorf("the dispatch was slowed %d times", s.Engaged)
+	}
+
+	// The latency of the writes slows the dispatch as well
+	buffered = 0
+	for i := 0; i < 10; i++ {
+		wp.observe(2 * time.Second)
+	}
+	if d := wp.delay(); d < 900*time.Millisecond {
+		t.Errorf("the slow writes delayed the dispatch by %s", d)
+	}
+	if s := wp.stats(); s.Engaged != 2 {
+		t.Errorf("the dispatch was slowed %d times", s.Engaged)
+	}
+
+	var nilwp *writePressure
+	nilwp.observe(time.Second)
+	if nilwp.delay() != 0 {
+		t.Error("the nil coupling delayed the dispatch")
+	}
+}
+
+// TestBackpressureSlowBackend dispatches the candidates faster than a slow graph database writes their
+// records, and checks the writes waiting for the database stay bounded under the memory limit.
+func TestBackpressureSlowBackend(t *testing.T) {
+	const (
+		candidates    = 300
+		writesPerName = 3
+		writeLatency  = time.Millisecond
+		high          = 60
+	)
+
+	var buffered int64
+	wp := &writePressure{
+		clock:    clock.System,
+		high:     high,
+		latency:  time.Second,
+		maxDelay: 20 * time.Millisecond,
+		buffer:   func() int { return int(atomic.LoadInt64(&buffered)) },
+	}
+	size := func() uint64 { return uint64(atomic.LoadInt64(&buffered)) * requestSize }
+	// The memory limit holds four times the writes that slow the dispatch
+	mon := memory.NewMonitor(4*high*requestSize, size)
+	mon.Register(MemoryGraph, size)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// The fake backend writes the records one at a time
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			if atomic.LoadInt64(&buffered) == 0 {
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Millisecond):
+				}
+				continue
+			}
+
+			start := wp.now()
+			time.Sleep(writeLatency)
+			wp.timed(start)
+			atomic.AddInt64(&buffered, -1)
+		}
+	}()
+
+	var most int64
+	for i := 0; i < candidates; i++ {
+		wp.wait(ctx, done)
+		atomic.AddInt64(&buffered, writesPerName)
+
+		if n := atomic.LoadInt64(&buffered); n > most {
+			most = n
+		}
+		mon.Sample()
+		if mon.HighMemoryConsumption() || mon.Pressure(MemoryGraph) {
+			t.Fatalf("the memory monitor tripped with %d writes buffered", atomic.LoadInt64(&buffered))
+		}
+	}
+	close(done)
+	<-finished
+
+	// The scheduling of the goroutines lets the buffer go slightly beyond the threshold
+	if most > 2*high {
+		t.Errorf("%d writes were buffered, with the threshold of %d", most, high)
+	}
+	if s := wp.stats(); s.Engaged == 0 {
+		t.Error("the slow backend never slowed the dispatch")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/transport"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// BandwidthFile is the name of the file under the output directory holding the bytes sent and received by the enumeration.
+const BandwidthFile = "bandwidth.json"
+
+// The components of the enumeration the bytes are accounted for.
+const (
+	componentResolvers     = "resolvers"
+	componentTrusted       = "trusted_resolvers"
+	componentAuthoritative = "authoritative"
+)
+
+// bandwidthBudgetFromConfig returns the number of bytes allowed by the 'bandwidth.budget' option, which
+// is given in megabytes, or zero when the bytes are not limited.
+func bandwidthBudgetFromConfig(cfg *config.Config) int64 {
+	if cfg == nil || cfg.Options == nil {
+		return 0
+	}
+
+	opts, ok := cfg.Options["bandwidth"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	if mb, ok := floatOption(opts["budget"]); ok && mb > 0 {
+		return int64(mb * (1 << 20))
+	}
+	return 0
+}
+
+// meteredPool accounts for the bytes of the queries sent to the pool and of their responses. The sockets of
+// the resolver pools cannot be reached, so the messages are measured as they are packed for the wire, with the
+// names compressed, and the retries made within the pool are not seen.
+type meteredPool struct {
+	Pool
+	meter     *bandwidth.Meter
+	component string
+}
+
+func (mp *meteredPool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	mp.sent(msg)
+
+	resps := make(chan *dns.Msg, 1)
+	go func() {
+		resp := <-resps
+		mp.received(resp)
+		ch <- resp
+	}()
+	mp.Pool.Query(ctx, msg, resps)
+}
+
+func (mp *meteredPool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	mp.sent(msg)
+
+	resp, err := mp.Pool.QueryBlocking(ctx, msg)
+	if err == nil {
+		mp.received(resp)
+	}
+	return resp, err
+}
+
+func (mp *meteredPool) sent(msg *dns.Msg) {
+	mp.meter.Add(bandwidth.DNS, mp.component, "", int64(bandwidth.MsgSize(msg)), 0)
+}
+
+// received accounts for the response, unless the pool gave up on the query without one.
+func (mp *meteredPool) received(resp *dns.Msg) {
+	if resp == nil || resp.Rcode == resolve.RcodeNoResponse {
+		return
+	}
+	mp.meter.Add(bandwidth.DNS, mp.component, "", 0, int64(bandwidth.MsgSize(resp)))
+}
+
+// metered returns the pool with its bytes accounted for on behalf of the component.
+func (e *Enumeration) metered(pool Pool, component string) Pool {
+	// The pipelined transport counts the bytes crossing its sockets
+	if _, ok := pool.(*transport.Pool); ok {
+		return pool
+	}
+	return &meteredPool{Pool: pool, meter: e.meter, component: component}
+}
+
+// trustedPool returns the trusted resolvers of the System, with their bytes accounted for and the identical queries in flight coalesced.
+func (e *Enumeration) trustedPool() Pool {
+	return e.coalescePool(e.metered(e.Sys.TrustedResolvers(), componentTrusted), componentTrusted)
+}
+
+// byteBudget returns the number of bytes the enumeration may send and receive, which is unlimited when zero.
+func (e *Enumeration) byteBudget() int64 {
+	if e.Budget.Bytes > 0 {
+		return e.Budget.Bytes
+	}
+	return bandwidthBudgetFromConfig(e.Config)
+}
+
+// startBandwidth begins accounting for the bytes of the enumeration, which is cancelled once the byte budget is exhausted.
+func (e *Enumeration) startBandwidth(ctx context.Context, cancel func(*requests.CancelCause)) {
+	e.meter.SetBudget(e.byteBudget())
+
+	done := e.meter.Done()
+	if done == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			cancel(requests.NewCancelCause(requests.CancelBudget,
+				fmt.Sprintf("the byte budget of %d bytes was exhausted", e.meter.Budget())))
+		}
+	}()
+}
+
+// Bandwidth returns the bytes sent and received by the enumeration so far, over DNS and HTTP.
+func (e *Enumeration) Bandwidth() bandwidth.Stats {
+	return e.meter.Stats()
+}
+
+// reportBandwidth logs the bytes sent and received over each protocol.
+func (e *Enumeration) reportBandwidth() {
+	stats := e.meter.Stats()
+	if stats.Total.Total() == 0 {
+		return
+	}
+
+	var protocols []string
+	for _, name := range stats.ProtocolNames() {
+		c := stats.Protocols[name]
+		protocols = append(protocols, fmt.Sprintf("%s sent %d and received %d", strings.ToUpper(name), c.Sent, c.Received))
+	}
+	e.Config.Log.Printf("The enumeration sent %d bytes and received %d bytes: %s",
+		stats.Total.Sent, stats.Total.Received, strings.Join(protocols, ", "))
+}
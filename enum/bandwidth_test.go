@@ -0,0 +1,101 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+func TestBandwidthBudgetFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if b := bandwidthBudgetFromConfig(cfg); b != 0 {
+		t.Errorf("the budget was %d without the option", b)
+	}
+
+	cfg.Options = map[string]interface{}{"bandwidth": map[string]interface{}{"budget": 1.5}}
+	if b := bandwidthBudgetFromConfig(cfg); b != 3<<19 {
+		t.Errorf("the budget was %d bytes, expected %d", b, 3<<19)
+	}
+
+	// The budget of the scope takes precedence over the option
+	e := &Enumeration{Config: cfg, Budget: Budget{Bytes: 100}}
+	if b := e.byteBudget(); b != 100 {
+		t.Errorf("the budget of the enumeration was %d", b)
+	}
+}
+
+func TestMeteredPool(t *testing.T) {
+	e := &Enumeration{meter: bandwidth.NewMeter(0)}
+	pool := e.metered(&zonePool{addrs: map[string]string{"www.owasp.org.": "192.0.2.1"}}, componentTrusted)
+
+	msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+	resp, err := pool.QueryBlocking(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan *dns.Msg, 1)
+	pool.Query(context.Background(), msg, ch)
+	if r := <-ch; r == nil || len(r.Answer) != 1 {
+		t.Fatalf("the response was %v", r)
+	}
+
+	expected := bandwidth.Counter{
+		Sent:     int64(2 * bandwidth.MsgSize(msg)),
+		Received: int64(2 * bandwidth.MsgSize(resp)),
+	}
+	if c := e.Bandwidth().Components[componentTrusted]; c != expected {
+		t.Errorf("the pool accounted for %+v, expected %+v", c, expected)
+	}
+
+	// The queries the pool gave up on received no bytes
+	msg.Rcode = resolve.RcodeNoResponse
+	(&meteredPool{meter: e.meter, component: componentTrusted}).received(msg)
+	if c := e.Bandwidth().Protocols[bandwidth.DNS]; c != expected {
+		t.Errorf("the unanswered query was accounted for: %+v", c)
+	}
+}
+
+func TestBandwidthBudgetExhausted(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Log = log.New(io.Discard, "", 0)
+	e := &Enumeration{
+		Config:     cfg,
+		Budget:     Budget{Bytes: 1000},
+		meter:      bandwidth.NewMeter(0),
+		completion: &completion{clock: clock.NewFake(time.Now())},
+	}
+
+	ctx, cancel := requests.WithCancelCause(context.Background())
+	defer cancel(nil)
+	e.ctx = ctx
+	e.startBandwidth(ctx, cancel)
+
+	e.meter.Add(bandwidth.HTTP, "data_sources", "crtsh", 200, 900)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the enumeration was not stopped once the byte budget was exhausted")
+	}
+
+	e.finishReason(context.Background())
+	if e.Termination() != TerminationBudget {
+		t.Errorf("the termination was recorded as %s", e.Termination())
+	}
+	if cause := e.TerminationReason(); cause == nil || cause.Detail != "the byte budget of 1000 bytes was exhausted" {
+		t.Errorf("the enumeration was cut short by %+v", cause)
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/caffix/queue"
+	"github.com/caffix/service"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+// The seed and size of the benchmark scenario, which are fixed so the results are comparable run to run.
+const (
+	benchSeed       = 20230101
+	benchCandidates = 10000
+	benchDomain     = "bench.example"
+	benchQPS        = 10000
+	benchTimeout    = 30 * time.Minute
+)
+
+// The graph database makes the storage of each name slower as the graph grows, so smaller runs can be requested.
+var benchNames = flag.Int("bench.names", benchCandidates, "number of candidate names in the miniature enumeration")
+
+// benchScenario is the seeded set of candidate names provided by the mock source, along with
+// the answers scripted for them. The names under the wildcard label all resolve to the same address.
+type benchScenario struct {
+	domain     string
+	candidates []string
+	answers    map[string]string
+	wildcard   string
+}
+
+func newBenchScenario(seed int64, n int) *benchScenario {
+	rng := rand.New(rand.NewSource(seed))
+	sc := &benchScenario{
+		domain:   benchDomain,
+		answers:  make(map[string]string),
+		wildcard: "wild." + benchDomain,
+	}
+
+	words := []string{"www", "mail", "api", "dev", "vpn", "cdn", "db", "app", "test", "portal"}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%s%d-%x.%s", words[rng.Intn(len(words))], i, rng.Uint32(), sc.domain)
+
+		switch p := rng.Intn(100); {
+		case p < 10:
+			// Ends up matching the wildcard, which hides the name
+			name = fmt.Sprintf("h%d-%x.%s", i, rng.Uint32(), sc.wildcard)
+		case p < 70:
+			sc.answers[name] = fmt.Sprintf("10.%d.%d.%d", rng.Intn(256), rng.Intn(256), 1+rng.Intn(254))
+		}
+		sc.candidates = append(sc.candidates, name)
+	}
+	return sc
+}
+
+// startFakeResolver serves the scripted answers of the scenario on a local UDP port.
+func startFakeResolver(tb testing.TB, sc *benchScenario) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler:           dns.HandlerFunc(sc.serveDNS),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+
+	return pc.LocalAddr().String(), func() { _ = srv.Shutdown() }
+}
+
+func (sc *benchScenario) serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+
+	if len(req.Question) == 1 {
+		q := req.Question[0]
+		name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+
+		addr, found := sc.answers[name]
+		if !found && strings.HasSuffix(name, "."+sc.wildcard) {
+			addr, found = "10.255.255.254", true
+		}
+
+		switch {
+		case found && q.Qtype == dns.TypeA:
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   net.ParseIP(addr),
+			})
+		case !found && name != sc.domain && name != sc.wildcard:
+			m.Rcode = dns.RcodeNameError
+		}
+	}
+	_ = w.WriteMsg(m)
+}
+
+// benchSource is the mock data source that provides the candidate names of the scenario.
+type benchSource struct {
+	*service.BaseService
+	names []string
+}
+
+func newBenchSource(names []string) *benchSource {
+	bs := &benchSource{names: names}
+	bs.BaseService = service.NewBaseService(bs, "Bench")
+	return bs
+}
+
+func (bs *benchSource) Description() string { return "api" }
+
+func (bs *benchSource) OnStart() error {
+	go func() {
+		for {
+			select {
+			case <-bs.Done():
+				return
+			case in := <-bs.Input():
+				ctx, req := requests.UnwrapContext(in)
+				if dns, ok := req.(*requests.DNSRequest); ok && dns.Name == dns.Domain {
+					bs.provide(ctx, dns.Domain)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (bs *benchSource) provide(ctx context.Context, domain string) {
+	for _, name := range bs.names {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bs.Done():
+			return
+		case bs.Output() <- &requests.DNSRequest{Name: name, Domain: domain}:
+		}
+	}
+}
+
+// benchResult holds the measurements of a single miniature enumeration.
+type benchResult struct {
+	names      int
+	elapsed    time.Duration
+	mallocs    uint64
+	peakHeap   uint64
+	goroutines int
+}
+
+// runMiniEnumeration performs a complete enumeration of the scenario against the fake resolver,
+// and returns once every resolvable candidate has been reported. The untrusted queries are sent
+// over the pipelined transport when pipelined is set.
+func runMiniEnumeration(tb testing.TB, sc *benchScenario, addr string, pipelined bool) benchResult {
+	cfg := config.NewConfig()
+	cfg.Rand = rand.New(rand.NewSource(benchSeed))
+	cfg.AddDomain(sc.domain)
+	cfg.ResolversQPS = benchQPS
+	cfg.TrustedQPS = benchQPS
+	if pipelined {
+		cfg.Resolvers = []string{addr}
+		cfg.Options["dns"] = map[string]interface{}{"pipelined": true}
+	}
+
+	pool := resolve.NewResolvers()
+	_ = pool.AddResolvers(benchQPS, addr)
+	trusted := resolve.NewResolvers()
+	_ = trusted.AddResolvers(benchQPS, addr)
+	trusted.SetDetectionResolver(benchQPS, addr)
+	defer pool.Stop()
+	defer trusted.Stop()
+
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	sys := &systems.SimpleSystem{
+		Cfg:      cfg,
+		Pool:     pool,
+		Trusted:  trusted,
+		Graph:    g,
+		ASNCache: requests.NewASNCache(),
+	}
+	if err := sys.AddAndStart(newBenchSource(sc.candidates)); err != nil {
+		tb.Fatal(err)
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	e := NewEnumeration(cfg, sys, g)
+	e.Output = make(chan *requests.Output, 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), benchTimeout)
+	defer cancel()
+
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	baseHeap, baseMallocs := m.HeapAlloc, m.Mallocs
+
+	var wg sync.WaitGroup
+	var res benchResult
+	// The enumeration is over once every resolvable candidate has been reported
+	collected := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(collected)
+
+		seen := make(map[string]struct{}, len(sc.answers))
+		for len(seen) < len(sc.answers) {
+			select {
+			case <-ctx.Done():
+				return
+			case out := <-e.Output:
+				if _, found := sc.answers[out.Name]; found {
+					seen[out.Name] = struct{}{}
+				}
+			}
+		}
+		res.names = len(seen)
+		cancel()
+	}()
+	go func() {
+		defer wg.Done()
+
+		t := time.NewTicker(10 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-collected:
+				return
+			case <-t.C:
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				if m.HeapAlloc > baseHeap && m.HeapAlloc-baseHeap > res.peakHeap {
+					res.peakHeap = m.HeapAlloc - baseHeap
+				}
+				if n := runtime.NumGoroutine(); n > res.goroutines {
+					res.goroutines = n
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	_ = e.Start(ctx)
+	<-collected
+	res.elapsed = time.Since(start)
+	wg.Wait()
+
+	runtime.ReadMemStats(&m)
+	res.mallocs = m.Mallocs - baseMallocs
+	if res.names < len(sc.answers) {
+		tb.Fatalf("the enumeration reported %d of the %d resolvable names", res.names, len(sc.answers))
+	}
+	return res
+}
+
+func TestBenchScenarioDeterministic(t *testing.T) {
+	first := newBenchScenario(benchSeed, 100)
+	second := newBenchScenario(benchSeed, 100)
+
+	if !reflect.DeepEqual(first, second) {
+		t.Error("the scenario differs between runs with the same seed")
+	}
+	if other := newBenchScenario(benchSeed+1, 100); reflect.DeepEqual(first.candidates, other.candidates) {
+		t.Error("the scenario did not change with the seed")
+	}
+	if len(first.answers) == 0 || len(first.answers) == len(first.candidates) {
+		t.Errorf("the scenario scripted answers for %d of the %d candidates", len(first.answers), len(first.candidates))
+	}
+}
+
+// BenchmarkEnumeration runs a complete miniature enumeration against the fake resolver and the mock
+// source. It is skipped in the short mode, since each iteration resolves thousands of names.
+func BenchmarkEnumeration(b *testing.B) {
+	benchmarkEnumeration(b, false)
+}
+
+// BenchmarkEnumerationPipelined runs the miniature enumeration with the untrusted queries sent over the pipelined transport.
+func BenchmarkEnumerationPipelined(b *testing.B) {
+	benchmarkEnumeration(b, true)
+}
+
+func benchmarkEnumeration(b *testing.B, pipelined bool) {
+	if testing.Short() {
+		b.Skip("the miniature enumeration is skipped in the short mode")
+	}
+
+	sc := newBenchScenario(benchSeed, *benchNames)
+	addr, stop := startFakeResolver(b, sc)
+	defer stop()
+
+	var total benchResult
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res := runMiniEnumeration(b, sc, addr, pipelined)
+
+		total.names += res.names
+		total.elapsed += res.elapsed
+		total.mallocs += res.mallocs
+		if res.peakHeap > total.peakHeap {
+			total.peakHeap = res.peakHeap
+		}
+		if res.goroutines > total.goroutines {
+			total.goroutines = res.goroutines
+		}
+	}
+
+	b.ReportMetric(float64(total.names)/total.elapsed.Seconds(), "names/s")
+	b.ReportMetric(float64(total.mallocs)/float64(total.names), "allocs/name")
+	b.ReportMetric(float64(total.peakHeap)/(1<<20), "peak-heap-MB")
+	b.ReportMetric(float64(total.goroutines), "peak-goroutines")
+}
+
+// BenchmarkNameDedupe measures the filter of the enumeration input source, where a third of the names are repeated.
+func BenchmarkNameDedupe(b *testing.B) {
+	sc := newBenchScenario(benchSeed, benchCandidates)
+	names := append([]string(nil), sc.candidates...)
+	names = append(names, sc.candidates[:len(sc.candidates)/3]...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := &enumSource{filter: bf.NewDefaultStableBloomFilter(1000000, 0.01)}
+
+		for _, name := range names {
+			r.accept(name)
+		}
+	}
+}
+
+// BenchmarkWildcardDetection measures the detection of the names that match the scripted wildcard.
+func BenchmarkWildcardDetection(b *testing.B) {
+	sc := newBenchScenario(benchSeed, benchCandidates)
+	addr, stop := startFakeResolver(b, sc)
+	defer stop()
+
+	trusted := resolve.NewResolvers()
+	_ = trusted.AddResolvers(benchQPS, addr)
+	trusted.SetDetectionResolver(benchQPS, addr)
+	defer trusted.Stop()
+
+	var msgs []*dns.Msg
+	for _, name := range sc.candidates {
+		if !strings.HasSuffix(name, "."+sc.wildcard) {
+			continue
+		}
+
+		m := resolve.QueryMsg(name, dns.TypeA)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("10.255.255.254"),
+		})
+		msgs = append(msgs, m)
+	}
+
+	ctx := context.Background()
+	if !trusted.WildcardDetected(ctx, msgs[0], sc.domain) {
+		b.Fatal("the scripted wildcard was not detected")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trusted.WildcardDetected(ctx, msgs[i%len(msgs)], sc.domain)
+	}
+}
+
+// BenchmarkGraphInsert measures the insertion of batches of resolved names into the graph.
+func BenchmarkGraphInsert(b *testing.B) {
+	sc := newBenchScenario(benchSeed, 1000)
+
+	var batch []*requests.DNSRequest
+	for _, name := range sc.candidates {
+		if addr, found := sc.answers[name]; found {
+			batch = append(batch, &requests.DNSRequest{
+				Name:    name,
+				Domain:  sc.domain,
+				Records: []requests.DNSAnswer{{Name: name, Type: int(dns.TypeA), Data: addr}},
+			})
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain(sc.domain)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		g := netmap.NewGraph("memory", "", "")
+		e := &Enumeration{Config: cfg, graph: g, prov: newProvenanceGraph()}
+		e.nameSrc = &enumSource{
+			enum:    e,
+			queue:   queue.NewQueue(),
+			filter:  bf.NewDefaultStableBloomFilter(1000000, 0.01),
+			done:    make(chan struct{}),
+			release: make(chan struct{}, 10),
+			max:     10,
+			rejects: make(map[string]int),
+		}
+		dm := &dataManager{enum: e}
+		b.StartTimer()
+
+		for _, req := range batch {
+			if err := dm.dnsRequest(ctx, req, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.StopTimer()
+		g.Remove()
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(len(batch)), "names/op")
+}
@@ -0,0 +1,392 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// The defaults of the 'brute_feedback' configuration options.
+const (
+	// DefaultFeedbackWindow is the number of candidate outcomes in a zone evaluated together
+	DefaultFeedbackWindow = 100
+	// DefaultFeedbackTimeoutRate is the percentage of timeouts in a window that slows the zone down
+	DefaultFeedbackTimeoutRate = 20
+	DefaultFeedbackMinQPS      = 1
+	// DefaultFeedbackTTLAgreement is the number of NXDOMAIN responses in a row with the same negative TTL
+	// that suggests the zone is flat
+	DefaultFeedbackTTLAgreement = 20
+	// DefaultFeedbackMisses is the number of consecutive misses beyond the expected yield that truncates the wordlist
+	DefaultFeedbackMisses = 500
+)
+
+// bruteFeedback adapts the brute forcing of each zone to the behavior of its resolution. A zone whose
+// candidates keep timing out is dispatched at a reduced rate, and the remaining wordlist of a flat zone
+// is optionally skipped once the misses run well beyond the yield observed in the zone.
+type bruteFeedback struct {
+	sync.Mutex
+	clock       clock.Clock
+	log         *log.Logger
+	window      int
+	timeoutRate int
+	minQPS      int
+	agreement   int
+	misses      int
+	truncate    bool
+	pending     int
+	zones       map[string]*zoneFeedback
+}
+
+// zoneFeedback holds the outcomes observed for the brute force candidates of a zone.
+type zoneFeedback struct {
+	// The outcomes and timeouts of the current window, and when the window started
+	outcomes int
+	timeouts int
+	start    time.Time
+	// The dispatch rate of a slowed zone, the rate it was slowed from, and the candidates held back
+	qps     int
+	base    int
+	limiter *rate.Limiter
+	held    queue.Queue
+	pumping bool
+	slowed  int
+	// The negative TTL of the NXDOMAIN responses and the number of them in a row that agreed
+	negTTL uint32
+	agreed int
+	// The candidates answered, the names found among them, and the misses since the last one found
+	answered  int
+	hits      int
+	streak    int
+	truncated bool
+	skipped   int
+}
+
+// bruteFeedbackFromConfig parses the 'brute_feedback' configuration options. The feedback is only
+// enabled when the section is present, and the wordlists are only truncated when requested.
+func bruteFeedbackFromConfig(cfg *config.Config, c clock.Clock) *bruteFeedback {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["brute_feedback"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if c == nil {
+		c = clock.System
+	}
+
+	fb := &bruteFeedback{
+		clock:       c,
+		log:         cfg.Log,
+		window:      DefaultFeedbackWindow,
+		timeoutRate: DefaultFeedbackTimeoutRate,
+		minQPS:      DefaultFeedbackMinQPS,
+		agreement:   DefaultFeedbackTTLAgreement,
+		misses:      DefaultFeedbackMisses,
+		zones:       make(map[string]*zoneFeedback),
+	}
+	if n := options.Int(opts["window"]); n > 0 {
+		fb.window = n
+	}
+	if n := options.Int(opts["timeout_rate"]); n > 0 && n <= 100 {
+		fb.timeoutRate = n
+	}
+	if n := options.Int(opts["min_qps"]); n > 0 {
+		fb.minQPS = n
+	}
+	if n := options.Int(opts["ttl_agreement"]); n > 0 {
+		fb.agreement = n
+	}
+	if n := options.Int(opts["misses"]); n > 0 {
+		fb.misses = n
+	}
+	fb.truncate, _ = opts["truncate"].(bool)
+	return fb
+}
+
+// bruteZone returns the zone a brute force candidate was generated for, and false for the other names.
+func bruteZone(req *requests.DNSRequest) (string, bool) {
+	if req == nil || req.Derivation != requests.DerivedFromBrute {
+		return "", false
+	}
+
+	parts := strings.SplitN(strings.ToLower(req.Name), ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func (fb *bruteFeedback) zone(name string) *zoneFeedback {
+	z, found := fb.zones[name]
+	if !found {
+		z = &zoneFeedback{
+			start: fb.clock.Now(),
+			held:  queue.NewQueue(),
+		}
+		fb.zones[name] = z
+	}
+	return z
+}
+
+// admit returns true when the candidate can be resolved now. The candidates of a slowed zone are held
+// back and released by the dispatch function at the rate of the zone.
+func (fb *bruteFeedback) admit(ctx context.Context, req *requests.DNSRequest, dispatch func()) bool {
+	if fb == nil {
+		return true
+	}
+
+	name, ok := bruteZone(req)
+	if !ok {
+		return true
+	}
+
+	fb.Lock()
+	z, found := fb.zones[name]
+	if !found || z.limiter == nil {
+		fb.Unlock()
+		return true
+	}
+
+	fb.pending++
+	z.held.Append(dispatch)
+	start := !z.pumping
+	z.pumping = true
+	fb.Unlock()
+
+	if start {
+		go fb.pump(ctx, z)
+	}
+	return false
+}
+
+// pump releases the candidates held back for the zone, waiting on the zone limiter before each of them.
+func (fb *bruteFeedback) pump(ctx context.Context, z *zoneFeedback) {
+	for {
+		fb.Lock()
+		element, ok := z.held.Next()
+		if !ok {
+			z.pumping = false
+			fb.Unlock()
+			return
+		}
+		limiter := z.limiter
+		fb.Unlock()
+
+		if limiter.TakeContext(ctx) != nil {
+			return
+		}
+
+		if dispatch, ok := element.(func()); ok {
+			dispatch()
+		}
+		fb.Lock()
+		fb.pending--
+		fb.Unlock()
+	}
+}
+
+// held returns the number of candidates waiting to be released for the slowed zones.
+func (fb *bruteFeedback) held() int {
+	if fb == nil {
+		return 0
+	}
+
+	fb.Lock()
+	defer fb.Unlock()
+
+	return fb.pending
+}
+
+// truncated returns true when the wordlist of the zone the candidate belongs to was truncated,
+// and counts the candidate as skipped.
+func (fb *bruteFeedback) truncated(req *requests.DNSRequest) bool {
+	if fb == nil {
+		return false
+	}
+
+	name, ok := bruteZone(req)
+	if !ok {
+		return false
+	}
+
+	fb.Lock()
+	defer fb.Unlock()
+
+	if z, found := fb.zones[name]; found && z.truncated {
+		z.skipped++
+		return true
+	}
+	return false
+}
+
+// observe records the outcome of the brute force candidate. The name was found when it was sent along
+// or exists without records, the NXDOMAIN responses are the misses, and the timeouts count against the
+// rate of the zone. The negative TTL is taken from the SOA record of the NXDOMAIN response.
+func (fb *bruteFeedback) observe(req *requests.DNSRequest, disp Disposition, sent bool, negTTL uint32) {
+	if fb == nil {
+		return
+	}
+
+	name, ok := bruteZone(req)
+	if !ok {
+		return
+	}
+
+	fb.Lock()
+	defer fb.Unlock()
+
+	z := fb.zone(name)
+	switch {
+	case sent || disp == DispositionNoRecords:
+		z.answered++
+		z.hits++
+		z.streak = 0
+	case disp == DispositionNXDomain:
+		z.answered++
+		z.streak++
+		if negTTL > 0 && negTTL == z.negTTL {
+			z.agreed++
+		} else if negTTL > 0 {
+			z.negTTL, z.agreed = negTTL, 1
+		}
+	case disp == DispositionTimeout:
+		z.timeouts++
+	}
+
+	if z.outcomes++; z.outcomes >= fb.window {
+		fb.evaluate(name, z)
+	}
+	if fb.truncate && !z.truncated && z.agreed >= fb.agreement {
+		if gap := z.expectedGap(); z.streak > gap+fb.misses {
+			z.truncated = true
+			fb.logf("Truncating the brute forcing of %s: %d consecutive misses exceed the expected gap of %d by more than %d, "+
+				"with %d names found in %d answered candidates and the last %d NXDOMAIN responses carrying the negative TTL %d",
+				name, z.streak, gap, fb.misses, z.hits, z.answered, z.agreed, z.negTTL)
+		}
+	}
+}
+
+// expectedGap returns the number of misses expected between the names found in the zone, from the yield
+// observed before the current run of misses. A zone where nothing was found has no yield to expect.
+func (z *zoneFeedback) expectedGap() int {
+	if z.hits == 0 {
+		return 0
+	}
+	return (z.answered - z.streak) / z.hits
+}
+
+// evaluate adjusts the dispatch rate of the zone once the window is complete. The rate is halved while
+// the timeouts exceed the threshold, and doubled while they stay below half of it, until the zone is
+// released from the limit at the rate it was slowed from.
+func (fb *bruteFeedback) evaluate(name string, z *zoneFeedback) {
+	now := fb.clock.Now()
+	pct := z.timeouts * 100 / z.outcomes
+
+	switch {
+	case pct > fb.timeoutRate:
+		qps := z.qps / 2
+		if z.qps == 0 {
+			// The zone is first slowed to half the rate the window was dispatched at
+			secs := now.Sub(z.start).Seconds()
+			if secs < 1 {
+				secs = 1
+			}
+			z.base = int(float64(z.outcomes) / secs)
+			qps = z.base / 2
+		}
+		if qps < fb.minQPS {
+			qps = fb.minQPS
+		}
+		z.setRate(qps, fb.clock)
+		z.slowed++
+		fb.logf("Slowing the brute forcing of %s to %d queries per second: %d of the last %d candidates timed out",
+			name, qps, z.timeouts, z.outcomes)
+	case z.qps > 0 && pct <= fb.timeoutRate/2:
+		qps := z.qps * 2
+		if qps >= z.base {
+			qps = 0
+		}
+		z.setRate(qps, fb.clock)
+		if qps == 0 {
+			fb.logf("Releasing the brute forcing of %s from the rate limit: %d of the last %d candidates timed out",
+				name, z.timeouts, z.outcomes)
+		} else {
+			fb.logf("Raising the brute forcing of %s to %d queries per second: %d of the last %d candidates timed out",
+				name, qps, z.timeouts, z.outcomes)
+		}
+	}
+
+	z.outcomes = 0
+	z.timeouts = 0
+	z.start = now
+}
+
+func (z *zoneFeedback) setRate(qps int, c clock.Clock) {
+	z.qps = qps
+	z.limiter = rate.NewLimiter(qps, 1, c)
+}
+
+// report logs the zones that were slowed down or truncated, along with the number of candidates skipped.
+func (fb *bruteFeedback) report() {
+	if fb == nil {
+		return
+	}
+
+	fb.Lock()
+	defer fb.Unlock()
+
+	names := make([]string, 0, len(fb.zones))
+	for name := range fb.zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		z := fb.zones[name]
+
+		if z.slowed > 0 {
+			fb.logf("The brute forcing of %s was slowed %d times, and ended at %d queries per second", name, z.slowed, z.qps)
+		}
+		if z.truncated {
+			fb.logf("The brute forcing of %s was truncated after %d answered candidates with %d names found, and %d candidates were skipped",
+				name, z.answered, z.hits, z.skipped)
+		}
+	}
+}
+
+func (fb *bruteFeedback) logf(format string, v ...interface{}) {
+	if fb.log != nil {
+		fb.log.Printf(format, v...)
+	}
+}
+
+// negativeTTL returns the negative caching TTL of the response, which is the lesser of the TTL and the
+// minimum field of the SOA record in the authority section, or zero when the response carries no SOA.
+func negativeTTL(resp *dns.Msg) uint32 {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if soa.Hdr.Ttl < soa.Minttl {
+				return soa.Hdr.Ttl
+			}
+			return soa.Minttl
+		}
+	}
+	return 0
+}
@@ -0,0 +1,187 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func bruteCandidate(i int, zone string) *requests.DNSRequest {
+	return &requests.DNSRequest{
+		Name:       fmt.Sprintf("w%d.%s", i, zone),
+		Domain:     "owasp.org",
+		Derivation: requests.DerivedFromBrute,
+	}
+}
+
+func TestBruteFeedbackConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if fb := bruteFeedbackFromConfig(cfg, nil); fb != nil {
+		t.Errorf("the feedback was enabled without the options")
+	}
+
+	cfg.Options["brute_feedback"] = map[string]interface{}{
+		"window":        50,
+		"timeout_rate":  150,
+		"min_qps":       5,
+		"ttl_agreement": 10,
+		"misses":        200,
+		"truncate":      true,
+	}
+	fb := bruteFeedbackFromConfig(cfg, nil)
+	if fb == nil || fb.window != 50 || fb.timeoutRate != DefaultFeedbackTimeoutRate ||
+		fb.minQPS != 5 || fb.agreement != 10 || fb.misses != 200 || !fb.truncate {
+		t.Errorf("the options were not parsed: %+v", fb)
+	}
+
+	// The other names are never held back or counted
+	var none *bruteFeedback
+	req := &requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org"}
+	if !none.admit(context.Background(), req, nil) || none.truncated(req) || none.held() != 0 {
+		t.Errorf("the nil feedback interfered with the name")
+	}
+	fb.observe(req, DispositionTimeout, false, 0)
+	if len(fb.zones) != 0 {
+		t.Errorf("the name outside of the brute forcing was observed")
+	}
+}
+
+func TestBruteFeedbackSlowdown(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["brute_feedback"] = map[string]interface{}{"window": 10, "timeout_rate": 20, "min_qps": 2}
+	fake := clock.NewFake(time.Now())
+	fb := bruteFeedbackFromConfig(cfg, fake)
+	zone := "dev.owasp.org"
+
+	// The window of ten candidates is dispatched within a second, and half of them time out
+	for i := 0; i < 10; i++ {
+		disp := DispositionNXDomain
+		if i%2 == 0 {
+			disp = DispositionTimeout
+		}
+		fb.observe(bruteCandidate(i, zone), disp, false, 0)
+	}
+	z := fb.zones[zone]
+	if z.qps != 5 || z.limiter == nil {
+		t.Fatalf("the zone was slowed to %d queries per second", z.qps)
+	}
+	// Another zone is not affected by the slowdown
+	if !fb.admit(context.Background(), bruteCandidate(0, "www.owasp.org"), nil) {
+		t.Errorf("the candidate of another zone was held back")
+	}
+
+	var lock sync.Mutex
+	var dispatched int
+	done := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		if fb.admit(context.Background(), bruteCandidate(i, zone), func() {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if dispatched++; dispatched == 5 {
+				close(done)
+			}
+		}) {
+			t.Errorf("the candidate of the slowed zone was not held back")
+		}
+	}
+	<-done
+	// The limiter allows a single candidate at once, so the others wait a fifth of a second each
+	if slept, _ := fake.Slept(); slept < 800*time.Millisecond {
+		t.Errorf("the held candidates were released after %s", slept)
+	}
+
+	// The rate keeps halving down to the minimum while the timeouts continue
+	for w := 0; w < 3; w++ {
+		for i := 0; i < 10; i++ {
+			fb.observe(bruteCandidate(i, zone), DispositionTimeout, false, 0)
+		}
+	}
+	if z.qps != 2 {
+		t.Errorf("the zone was slowed to %d queries per second, expected the minimum", z.qps)
+	}
+	// The rate is doubled back once the timeouts stop, until the limit is removed
+	for w := 0; w < 3; w++ {
+		for i := 0; i < 10; i++ {
+			fb.observe(bruteCandidate(i, zone), DispositionNXDomain, false, 0)
+		}
+	}
+	if z.qps != 0 || z.limiter != nil {
+		t.Errorf("the zone remained limited to %d queries per second", z.qps)
+	}
+	if z.slowed != 4 {
+		t.Errorf("the zone was slowed %d times", z.slowed)
+	}
+}
+
+func TestBruteFeedbackTruncation(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["brute_feedback"] = map[string]interface{}{"ttl_agreement": 5, "misses": 20, "truncate": true}
+	fb := bruteFeedbackFromConfig(cfg, clock.NewFake(time.Now()))
+	flat, deep := "flat.owasp.org", "deep.owasp.org"
+
+	// One name is found in each ten candidates before the misses start
+	var n int
+	for ; n < 100; n++ {
+		fb.observe(bruteCandidate(n, flat), DispositionNXDomain, n%10 == 0, 300)
+	}
+	if gap := fb.zones[flat].expectedGap(); gap != 9 {
+		t.Errorf("the expected gap is %d", gap)
+	}
+	for ; !fb.truncated(bruteCandidate(n, flat)); n++ {
+		fb.observe(bruteCandidate(n, flat), DispositionNXDomain, false, 300)
+		if n > 1000 {
+			t.Fatal("the flat zone was not truncated")
+		}
+	}
+	// The misses run past the gap of nine by more than twenty
+	if z := fb.zones[flat]; z.streak != 30 || z.skipped != 1 {
+		t.Errorf("the zone was truncated after %d misses with %d skipped", z.streak, z.skipped)
+	}
+
+	// The negative TTLs of a zone with delegations below it disagree, so it is never considered flat
+	for i := 0; i < 1000; i++ {
+		fb.observe(bruteCandidate(i, deep), DispositionNXDomain, false, uint32(300+i%2*3300))
+	}
+	if fb.truncated(bruteCandidate(0, deep)) {
+		t.Errorf("the zone with varying negative TTLs was truncated")
+	}
+
+	// The wordlist is only truncated when requested
+	delete(cfg.Options["brute_feedback"].(map[string]interface{}), "truncate")
+	fb = bruteFeedbackFromConfig(cfg, nil)
+	for i := 0; i < 1000; i++ {
+		fb.observe(bruteCandidate(i, flat), DispositionNXDomain, false, 300)
+	}
+	if fb.truncated(bruteCandidate(0, flat)) {
+		t.Errorf("the zone was truncated without the option")
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	resp := new(dns.Msg)
+	if ttl := negativeTTL(resp); ttl != 0 {
+		t.Errorf("the response without a SOA returned %d", ttl)
+	}
+
+	resp.Ns = append(resp.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "owasp.org.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:     "ns1.owasp.org.",
+		Mbox:   "admin.owasp.org.",
+		Minttl: 300,
+	})
+	if ttl := negativeTTL(resp); ttl != 300 {
+		t.Errorf("the negative TTL is %d, expected the SOA minimum", ttl)
+	}
+}
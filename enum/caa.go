@@ -0,0 +1,79 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CAARecord is a Certification Authority Authorization record found for a name.
+type CAARecord struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// caaStore keeps the CAA records of the enumeration, since the graph has no asset to represent them.
+type caaStore struct {
+	sync.Mutex
+	records map[string][]CAARecord
+}
+
+func newCAAStore() *caaStore {
+	return &caaStore{records: make(map[string][]CAARecord)}
+}
+
+func (cs *caaStore) add(name string, rec CAARecord) {
+	name = strings.ToLower(name)
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	for _, r := range cs.records[name] {
+		if r == rec {
+			return
+		}
+	}
+	cs.records[name] = append(cs.records[name], rec)
+}
+
+func (cs *caaStore) get(name string) []CAARecord {
+	cs.Lock()
+	defer cs.Unlock()
+
+	return append([]CAARecord(nil), cs.records[strings.ToLower(name)]...)
+}
+
+// CAARecords returns the CAA records discovered for the name during the enumeration.
+func (e *Enumeration) CAARecords(name string) []CAARecord {
+	return e.caa.get(name)
+}
+
+// parseCAA parses the presentation format of the record data, such as '0 issue "letsencrypt.org"'.
+func parseCAA(data string) (CAARecord, error) {
+	parts := strings.SplitN(strings.TrimSpace(data), " ", 3)
+	if len(parts) != 3 {
+		return CAARecord{}, errors.New("the CAA record data is incomplete")
+	}
+
+	flag, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return CAARecord{}, errors.New("the CAA record has an invalid flag")
+	}
+
+	tag := strings.ToLower(parts[1])
+	if tag == "" {
+		return CAARecord{}, errors.New("the CAA record has an empty tag")
+	}
+
+	value := parts[2]
+	if v, err := strconv.Unquote(value); err == nil {
+		value = v
+	}
+	return CAARecord{Flag: uint8(flag), Tag: tag, Value: value}, nil
+}
@@ -0,0 +1,63 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseCAA(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected CAARecord
+		valid    bool
+	}{
+		{`0 issue "letsencrypt.org"`, CAARecord{Flag: 0, Tag: "issue", Value: "letsencrypt.org"}, true},
+		{`128 ISSUEWILD ";"`, CAARecord{Flag: 128, Tag: "issuewild", Value: ";"}, true},
+		{`0 iodef "mailto:security@owasp.org"`, CAARecord{Flag: 0, Tag: "iodef", Value: "mailto:security@owasp.org"}, true},
+		{`0 issue`, CAARecord{}, false},
+		{`256 issue "letsencrypt.org"`, CAARecord{}, false},
+		{``, CAARecord{}, false},
+	}
+
+	for _, test := range tests {
+		rec, err := parseCAA(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("parseCAA(%q) returned the error %v", test.input, err)
+			continue
+		}
+		if rec != test.expected {
+			t.Errorf("parseCAA(%q) returned %+v, expected %+v", test.input, rec, test.expected)
+		}
+	}
+}
+
+func TestExtractCAAAnswers(t *testing.T) {
+	rr, err := dns.NewRR(`owasp.org. 3600 IN CAA 0 issue "letsencrypt.org"`)
+	if err != nil {
+		t.Fatalf("Failed to build the CAA record: %v", err)
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = append(msg.Answer, rr)
+	ans := extractAnswers(msg)
+	if len(ans) != 1 || ans[0].Type != dns.TypeCAA || ans[0].Name != "owasp.org" {
+		t.Fatalf("The CAA answer was not extracted: %v", ans)
+	}
+
+	rec, err := parseCAA(ans[0].Data)
+	if err != nil || rec.Tag != "issue" || rec.Value != "letsencrypt.org" {
+		t.Errorf("The extracted CAA data %q was parsed as %+v: %v", ans[0].Data, rec, err)
+	}
+
+	cs := newCAAStore()
+	cs.add("OWASP.org", rec)
+	cs.add("owasp.org", rec)
+	if got := cs.get("owasp.org"); len(got) != 1 {
+		t.Errorf("The CAA store returned %v", got)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// CertZonesFile is the name of the file under the output directory listing the zones proven by the certificates.
+const CertZonesFile = "cert_zones.json"
+
+// numOfZoneWildcardTests is the number of unlikely names queried to learn the wildcard answers of a zone.
+const numOfZoneWildcardTests = 3
+
+// CertZone is a zone proven to exist by the wildcard entry of a certificate, such as *.internal.example.com,
+// even when none of the names within the zone are known.
+type CertZone struct {
+	Zone      string    `json:"zone"`
+	Domain    string    `json:"domain"`
+	Sources   []string  `json:"sources"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// zoneWildcard holds the answers to the unlikely names of a zone, which the names found within it are compared to.
+type zoneWildcard struct {
+	sync.Once
+	answers map[string]struct{}
+	dynamic bool
+}
+
+// certZoneStore keeps the zones proven by the certificates, since the graph has no asset to represent them.
+type certZoneStore struct {
+	sync.Mutex
+	zones     map[string]*CertZone
+	wildcards map[string]*zoneWildcard
+	rng       *rand.Rand
+}
+
+// newCertZoneStore returns the store drawing the labels of the wildcard probes from the randomness.
+func newCertZoneStore(rng *rand.Rand) *certZoneStore {
+	return &certZoneStore{
+		zones:     make(map[string]*CertZone),
+		wildcards: make(map[string]*zoneWildcard),
+		rng:       rng,
+	}
+}
+
+// add records the source of the zone and returns true the first time the zone is seen.
+func (cs *certZoneStore) add(zone, domain, source string) bool {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if z, found := cs.zones[zone]; found {
+		for _, s := range z.Sources {
+			if s == source {
+				return false
+			}
+		}
+		if source != "" {
+			z.Sources = append(z.Sources, source)
+		}
+		return false
+	}
+
+	z := &CertZone{
+		Zone:      zone,
+		Domain:    domain,
+		FirstSeen: time.Now(),
+	}
+	if source != "" {
+		z.Sources = []string{source}
+	}
+	cs.zones[zone] = z
+	return true
+}
+
+// zoneOf returns the zone proven by a certificate that immediately holds the name.
+func (cs *certZoneStore) zoneOf(name string) string {
+	_, parent, found := strings.Cut(strings.ToLower(name), ".")
+	if !found {
+		return ""
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	if _, found := cs.zones[parent]; found {
+		return parent
+	}
+	return ""
+}
+
+func (cs *certZoneStore) list() []CertZone {
+	cs.Lock()
+	defer cs.Unlock()
+
+	zones := make([]CertZone, 0, len(cs.zones))
+	for _, z := range cs.zones {
+		c := *z
+		c.Sources = append([]string(nil), z.Sources...)
+		zones = append(zones, c)
+	}
+
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].Zone < zones[j].Zone
+	})
+	return zones
+}
+
+func (cs *certZoneStore) wildcard(zone string) *zoneWildcard {
+	cs.Lock()
+	defer cs.Unlock()
+
+	w, found := cs.wildcards[zone]
+	if !found {
+		w = &zoneWildcard{answers: make(map[string]struct{})}
+		cs.wildcards[zone] = w
+	}
+	return w
+}
+
+// CertificateZones returns the zones proven to exist by the wildcard entries of the certificates during the enumeration.
+func (e *Enumeration) CertificateZones() []CertZone {
+	return e.certZones.list()
+}
+
+// certZone handles the wildcard entry of a certificate. The zone is submitted as a candidate, with the
+// certificate as its provenance, and the brute forcing and SRV probing target the zone once it is known.
+func (e *Enumeration) certZone(req *requests.DNSRequest) {
+	zone := amassdns.WildcardZone(req.Name)
+	if zone == "" {
+		return
+	}
+
+	domain := strings.ToLower(e.Config.WhichDomain(zone))
+	if domain == "" || !e.certZones.add(zone, domain, req.Parent) {
+		return
+	}
+
+	e.nameSrc.newName(&requests.DNSRequest{
+		Name:       zone,
+		Domain:     domain,
+		Parent:     req.Parent,
+		Derivation: requests.DerivedFromCert,
+	})
+	// The root domain names are already probed by the data sources
+	if zone != domain {
+		e.sendRequests(&certZoneProbe{req: &requests.DNSRequest{
+			Name:       zone,
+			Domain:     zone,
+			Parent:     req.Parent,
+			Derivation: requests.DerivedFromCert,
+		}})
+	}
+}
+
+// certZoneProbe carries a zone proven by a certificate to the brute forcing and DNS data sources only.
+type certZoneProbe struct {
+	req *requests.DNSRequest
+}
+
+// certZoneWildcard returns true when the answers to the name, found immediately within a zone proven by a
+// certificate, match the wildcard of the zone. The wildcard detection of the resolvers discards every name
+// of a zone with a wildcard lacking stable answers, which would discard the names that brute forcing the
+// zone was meant to find, so the answers are compared with those of the unlikely names of the zone instead.
+func (e *Enumeration) certZoneWildcard(ctx context.Context, zone string, resp *dns.Msg) bool {
+	w := e.certZones.wildcard(zone)
+
+	w.Do(func() {
+		var first map[string]struct{}
+		for i := 0; i < numOfZoneWildcardTests; i++ {
+			set := e.zoneAnswers(ctx, random.UnlikelyName(e.certZones.rng, zone))
+			if len(set) == 0 {
+				continue
+			}
+			// Answers changing with each name cannot tell the wildcard apart from the names within the zone
+			if first == nil {
+				first = set
+			} else if !intersects(first, set) {
+				w.dynamic = true
+			}
+			for a := range set {
+				w.answers[a] = struct{}{}
+			}
+		}
+	})
+
+	if w.dynamic {
+		return true
+	}
+	return intersects(w.answers, answerSet(resp))
+}
+
+// zoneAnswers returns the data of the answers to the name, queried through the trusted resolvers.
+func (e *Enumeration) zoneAnswers(ctx context.Context, name string) map[string]struct{} {
+	set := make(map[string]struct{})
+
+	for _, t := range FwdQueryTypes {
+		if resp, err := e.dnsQuery(ctx, name, t, e.trustedPool(), 2); err == nil && resp != nil {
+			for a := range answerSet(resp) {
+				set[a] = struct{}{}
+			}
+		}
+	}
+	return set
+}
+
+func answerSet(resp *dns.Msg) map[string]struct{} {
+	set := make(map[string]struct{})
+
+	for _, a := range extractAnswers(resp) {
+		set[strings.ToLower(a.Data)] = struct{}{}
+	}
+	return set
+}
+
+func intersects(a, b map[string]struct{}) bool {
+	for k := range a {
+		if _, found := b[k]; found {
+			return true
+		}
+	}
+	return false
+}
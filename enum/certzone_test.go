@@ -0,0 +1,92 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+
+	"github.com/caffix/queue"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func TestCertZone(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+
+	e := &Enumeration{
+		Config:    cfg,
+		requests:  queue.NewQueue(),
+		certZones: newCertZoneStore(random.New(1).Rand("wildcard")),
+	}
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+
+	for _, req := range []*requests.DNSRequest{
+		{Name: "*.Internal.owasp.org", Domain: "owasp.org", Parent: "Crtsh", Derivation: requests.DerivedFromCert},
+		{Name: "*.internal.owasp.org", Domain: "owasp.org", Parent: "CertSpotter", Derivation: requests.DerivedFromCert},
+		{Name: "*.owasp.org", Domain: "owasp.org", Parent: "Crtsh", Derivation: requests.DerivedFromCert},
+		{Name: "*.example.com", Domain: "example.com", Parent: "Crtsh", Derivation: requests.DerivedFromCert},
+	} {
+		e.nameSrc.newName(req)
+	}
+
+	// The zones are submitted once as the candidate names, with the certificate as their provenance
+	submitted := make(map[string]*requests.DNSRequest)
+	for e.nameSrc.queue.Len() > 0 {
+		element, _ := e.nameSrc.queue.Next()
+		req := element.(*requests.DNSRequest)
+		submitted[req.Name] = req
+	}
+	if len(submitted) != 2 {
+		t.Errorf("%d zones were submitted, expected 2", len(submitted))
+	}
+	if req, found := submitted["internal.owasp.org"]; !found ||
+		req.Derivation != requests.DerivedFromCert || req.Parent != "Crtsh" || req.Domain != "owasp.org" {
+		t.Errorf("the zone internal.owasp.org was submitted as %v", req)
+	}
+
+	// Only the zones below the root domain names are sent to be brute forced and probed
+	if e.requests.Len() != 1 {
+		t.Fatalf("%d zones were sent to the data sources, expected 1", e.requests.Len())
+	}
+	element, _ := e.requests.Next()
+	probe, ok := element.(*certZoneProbe)
+	if !ok || probe.req.Domain != "internal.owasp.org" {
+		t.Fatalf("the zone was sent to the data sources as %v", element)
+	}
+	for _, test := range []struct {
+		src      *fakeSource
+		expected bool
+	}{
+		{newFakeSource("Brute Forcing", "brute"), true},
+		{newFakeSource("DNS SRV", "dns"), true},
+		{newFakeSource("Crtsh", "cert"), false},
+	} {
+		if req, routed := e.routeRequest(test.src, probe); routed != test.expected || (routed && req != probe.req) {
+			t.Errorf("routing the zone to %s returned %t, expected %t", test.src.String(), routed, test.expected)
+		}
+	}
+
+	zones := e.CertificateZones()
+	if len(zones) != 2 || zones[0].Zone != "internal.owasp.org" || len(zones[0].Sources) != 2 {
+		t.Errorf("the certificate zones were listed as %v", zones)
+	}
+	if zone := e.certZones.zoneOf("db1.internal.owasp.org"); zone != "internal.owasp.org" {
+		t.Errorf("db1.internal.owasp.org was found in the zone %q", zone)
+	}
+	if zone := e.certZones.zoneOf("a.db1.internal.owasp.org"); zone != "" {
+		t.Errorf("a.db1.internal.owasp.org was found in the zone %q", zone)
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/cloud"
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/config/config"
+	"golang.org/x/net/publicsuffix"
+)
+
+// CloudNamesFile is the name of the file under the output directory holding the provider names found by the templates.
+const CloudNamesFile = "cloud_names.json"
+
+const (
+	// DefaultCloudThreshold is the number of names a provider must host before its candidates are generated
+	DefaultCloudThreshold = 10
+	// DefaultCloudCandidates is the number of candidates generated for each provider
+	DefaultCloudCandidates = 200
+	// DefaultCloudWorkers is the number of candidates checked at once
+	DefaultCloudWorkers = 10
+	// cloudHeadTimeout caps the HTTP requests checking the existence of the buckets
+	cloudHeadTimeout = 10 * time.Second
+)
+
+// The ways the existence of a provider name was checked.
+const (
+	CloudValidatedDNS  = "dns"
+	CloudValidatedHTTP = "http"
+)
+
+// CloudName is a name of a provider service generated from the naming conventions of the organization,
+// which was found to exist. The names are outside of the scope, so they are reported rather than stored.
+type CloudName struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	// Generator identifies the template that generated the name, such as 'AWS/s3'
+	Generator string `json:"generator"`
+	Validated string `json:"validated"`
+	// StatusCode is the answer of the bucket to the HTTP HEAD request
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+// cloudGenerator applies the candidate templates of the providers hosting enough names in scope to the label
+// stems observed among those names. The bucket services answer every name under their domain, so their candidates
+// are only checked over HTTP in the active mode, and the others are resolved.
+type cloudGenerator struct {
+	sync.Mutex
+	templates *cloud.Templates
+	threshold int
+	max       int
+	workers   int
+	active    bool
+	head      func(ctx context.Context, url string) (int, error)
+	// resolve replaces the queries sent to the trusted resolvers when it is provided
+	resolve   func(ctx context.Context, name string) bool
+	generated map[string]int
+	found     []CloudName
+}
+
+// cloudGeneratorFromConfig parses the 'candidates', 'candidate_threshold' and 'max_candidates' options of the
+// 'cloud' section, and returns nil unless the candidates are enabled in an enumeration resolving the names.
+func cloudGeneratorFromConfig(cfg *config.Config) (*cloudGenerator, error) {
+	if cfg == nil || cfg.Passive {
+		return nil, nil
+	}
+
+	opts, _ := cfg.Options["cloud"].(map[string]interface{})
+	if enabled, ok := opts["candidates"].(bool); !ok || !enabled {
+		return nil, nil
+	}
+
+	templates, err := cloud.TemplatesFromConfig(cfg)
+	if templates == nil {
+		return nil, err
+	}
+
+	cg := &cloudGenerator{
+		templates: templates,
+		threshold: DefaultCloudThreshold,
+		max:       DefaultCloudCandidates,
+		workers:   DefaultCloudWorkers,
+		active:    cfg.Active,
+		head:      headStatus,
+		generated: make(map[string]int),
+	}
+	if n := options.Int(opts["candidate_threshold"]); n > 0 {
+		cg.threshold = n
+	}
+	if n := options.Int(opts["max_candidates"]); n > 0 {
+		cg.max = n
+	}
+	return cg, err
+}
+
+// headStatus returns the status code of the HTTP HEAD request, without following the redirects.
+func headStatus(ctx context.Context, url string) (int, error) {
+	resp, err := http.RequestWebPage(ctx, &http.Request{
+		URL:          url,
+		Method:       "HEAD",
+		Timeout:      cloudHeadTimeout,
+		MaxRedirects: -1,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
+
+func (cg *cloudGenerator) add(cn CloudName) {
+	cg.Lock()
+	defer cg.Unlock()
+
+	cg.found = append(cg.found, cn)
+}
+
+// CloudNames returns the provider names generated by the templates that were found to exist, ordered by the name.
+func (e *Enumeration) CloudNames() []CloudName {
+	cg := e.cloudNames
+	if cg == nil {
+		return nil
+	}
+
+	cg.Lock()
+	defer cg.Unlock()
+
+	found := append([]CloudName(nil), cg.found...)
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Name < found[j].Name
+	})
+	return found
+}
+
+// CloudCandidates returns the number of candidates generated for each provider.
+func (e *Enumeration) CloudCandidates() map[string]int {
+	cg := e.cloudNames
+	if cg == nil {
+		return nil
+	}
+
+	cg.Lock()
+	defer cg.Unlock()
+
+	counts := make(map[string]int, len(cg.generated))
+	for p, n := range cg.generated {
+		counts[p] = n
+	}
+	return counts
+}
+
+// generateCloudNames checks the candidates of each provider the classifier observed on enough names in scope,
+// once all the names have been classified, and records the candidates that exist along with their generator.
+func (e *Enumeration) generateCloudNames(ctx context.Context) {
+	cg := e.cloudNames
+	if cg == nil {
+		return
+	}
+
+	counts := e.InfrastructureCounts()
+	orgs := cloudOrgs(e.Config.Domains())
+	stems := e.cloudStems()
+
+	var candidates []cloud.Candidate
+	for _, p := range cg.templates.Providers {
+		if counts[p.Provider] < cg.threshold {
+			continue
+		}
+
+		var list []cloud.Candidate
+		for _, c := range cg.templates.Expand(p.Provider, orgs, stems, cg.max) {
+			// The existence of the buckets is only checked by the active enumerations
+			if !c.Bucket || cg.active {
+				list = append(list, c)
+			}
+		}
+		if len(list) == 0 {
+			continue
+		}
+		cg.Lock()
+		cg.generated[p.Provider] = len(list)
+		cg.Unlock()
+		e.Config.Log.Printf("Generating %d candidates for the %d names hosted by %s", len(list), counts[p.Provider], p.Provider)
+		candidates = append(candidates, list...)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	ch := make(chan cloud.Candidate, cg.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < cg.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for c := range ch {
+				e.checkCloudCandidate(ctx, c)
+			}
+		}()
+	}
+
+loop:
+	for _, c := range candidates {
+		select {
+		case <-ctx.Done():
+			break loop
+		case ch <- c:
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if n := len(e.CloudNames()); n > 0 {
+		e.Config.Log.Printf("Found %d provider names generated from the naming conventions", n)
+	}
+}
+
+// checkCloudCandidate asks the bucket service for the candidate, or resolves the candidate otherwise. The buckets
+// answering anything other than not found exist, such as those denying access to their content.
+func (e *Enumeration) checkCloudCandidate(ctx context.Context, c cloud.Candidate) {
+	cg := e.cloudNames
+	cn := CloudName{Name: c.Name, Provider: c.Provider, Generator: c.Generator}
+
+	if c.Bucket {
+		if e.Policy.BlocksName(policy.Web, c.Name) {
+			return
+		}
+
+		code, err := cg.head(ctx, "https://"+c.Name+"/")
+		if err != nil || code == 0 || code == 404 {
+			return
+		}
+		cn.Validated, cn.StatusCode = CloudValidatedHTTP, code
+	} else {
+		resolves := cg.resolve
+		if resolves == nil {
+			resolves = e.cloudResolves
+		}
+		if !resolves(ctx, c.Name) {
+			return
+		}
+		cn.Validated = CloudValidatedDNS
+	}
+
+	cg.add(cn)
+}
+
+// cloudResolves returns true when the trusted resolvers answer the candidate with an address.
+func (e *Enumeration) cloudResolves(ctx context.Context, name string) bool {
+	resp, err := e.dnsQuery(ctx, name, dns.TypeA, e.trustedPool(), maxDNSQueryAttempts)
+	return err == nil && resp != nil
+}
+
+// cloudOrgs returns the leftmost label of the registered domain of each domain in scope.
+func cloudOrgs(domains []string) []string {
+	var orgs []string
+	seen := make(map[string]struct{})
+
+	for _, d := range domains {
+		reg, err := publicsuffix.EffectiveTLDPlusOne(strings.Trim(strings.ToLower(d), "."))
+		if err != nil {
+			continue
+		}
+
+		org := strings.SplitN(reg, ".", 2)[0]
+		if _, found := seen[org]; !found {
+			seen[org] = struct{}{}
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs
+}
+
+// cloudStems returns the labels of the names in scope under their domains, along with the parts of the labels
+// separated by hyphens, ordered by the number of names they were observed in.
+func (e *Enumeration) cloudStems() []string {
+	counts := make(map[string]int)
+
+	for _, n := range e.AllInfrastructure() {
+		d := e.Config.WhichDomain(n.Name)
+		if d == "" || n.Name == d {
+			continue
+		}
+
+		seen := make(map[string]struct{})
+		for _, label := range strings.Split(strings.TrimSuffix(n.Name, "."+d), ".") {
+			parts := []string{label}
+			if strings.Contains(label, "-") {
+				parts = append(parts, strings.Split(label, "-")...)
+			}
+
+			for _, stem := range parts {
+				if _, found := seen[stem]; found || !cloudStem(stem) {
+					continue
+				}
+				seen[stem] = struct{}{}
+				counts[stem]++
+			}
+		}
+	}
+
+	stems := make([]string, 0, len(counts))
+	for stem := range counts {
+		stems = append(stems, stem)
+	}
+	sort.Slice(stems, func(i, j int) bool {
+		if counts[stems[i]] != counts[stems[j]] {
+			return counts[stems[i]] > counts[stems[j]]
+		}
+		return stems[i] < stems[j]
+	})
+	return stems
+}
+
+// cloudStem returns false for the labels saying nothing of the naming conventions, such as the numbers
+// and the service labels.
+func cloudStem(label string) bool {
+	if len(label) < 2 || strings.HasPrefix(label, "_") || label == "www" {
+		return false
+	}
+	return strings.Trim(label, "0123456789") != ""
+}
@@ -0,0 +1,145 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/cloud"
+	"github.com/owasp-amass/config/config"
+)
+
+const cloudTestTemplates = `{
+  "providers": [
+    {"provider": "AWS", "templates": [
+      {"name": "s3", "pattern": "{org}-{stem}.s3.amazonaws.com", "bucket": true},
+      {"name": "beanstalk", "pattern": "{org}-{stem}.elasticbeanstalk.com"}
+    ]},
+    {"provider": "Azure", "templates": [
+      {"name": "app-service", "pattern": "{org}-{stem}.azurewebsites.net"}
+    ]}
+  ]
+}`
+
+func TestCloudGeneratorFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if cg, err := cloudGeneratorFromConfig(cfg); cg != nil || err != nil {
+		t.Error("the candidates were generated without being enabled")
+	}
+
+	cfg.Options["cloud"] = map[string]interface{}{"candidates": true, "candidate_threshold": 3, "max_candidates": 50}
+	cg, err := cloudGeneratorFromConfig(cfg)
+	if err != nil || cg == nil || cg.threshold != 3 || cg.max != 50 || cg.templates == nil {
+		t.Fatalf("the options were parsed as %+v, %v", cg, err)
+	}
+
+	cfg.Passive = true
+	if cg, _ := cloudGeneratorFromConfig(cfg); cg != nil {
+		t.Error("the candidates were generated by a passive enumeration")
+	}
+}
+
+func TestGenerateCloudNames(t *testing.T) {
+	tmpl, err := cloud.LoadTemplates(strings.NewReader(cloudTestTemplates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	var lock sync.Mutex
+	var asked []string
+	e := &Enumeration{
+		Config: cfg,
+		infra:  newInfraStore(),
+		cloudNames: &cloudGenerator{
+			templates: tmpl,
+			threshold: 3,
+			max:       4,
+			workers:   2,
+			active:    true,
+			generated: make(map[string]int),
+			head: func(ctx context.Context, url string) (int, error) {
+				if url == "https://owasp-assets.s3.amazonaws.com/" {
+					return 403, nil
+				}
+				return 404, nil
+			},
+			resolve: func(ctx context.Context, name string) bool {
+				lock.Lock()
+				defer lock.Unlock()
+
+				asked = append(asked, name)
+				return name == "owasp-api.elasticbeanstalk.com"
+			},
+		},
+	}
+
+	// AWS hosts enough names, while Azure hosts a single name
+	for i, name := range []string{"assets.owasp.org", "cdn.assets.owasp.org", "api-assets.owasp.org", "api.owasp.org"} {
+		e.infra.set(name, cloud.Classification{Provider: "AWS", Service: fmt.Sprint(i)})
+	}
+	e.infra.set("portal.owasp.org", cloud.Classification{Provider: "Azure"})
+
+	if stems := e.cloudStems(); !reflect.DeepEqual(stems[:2], []string{"assets", "api"}) {
+		t.Errorf("the stems were ordered as %v", stems)
+	}
+
+	e.generateCloudNames(context.Background())
+	if counts := e.CloudCandidates(); !reflect.DeepEqual(counts, map[string]int{"AWS": 4}) {
+		t.Errorf("the candidates generated for each provider were %v", counts)
+	}
+	// The bucket is never resolved, since the bucket service answers every name
+	for _, name := range asked {
+		if strings.HasSuffix(name, ".s3.amazonaws.com") || strings.HasSuffix(name, ".azurewebsites.net") {
+			t.Errorf("the candidate %s was resolved", name)
+		}
+	}
+
+	expected := []CloudName{
+		{Name: "owasp-api.elasticbeanstalk.com", Provider: "AWS", Generator: "AWS/beanstalk", Validated: CloudValidatedDNS},
+		{Name: "owasp-assets.s3.amazonaws.com", Provider: "AWS", Generator: "AWS/s3", Validated: CloudValidatedHTTP, StatusCode: 403},
+	}
+	if got := e.CloudNames(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("the provider names were %+v, expected %+v", got, expected)
+	}
+}
+
+func TestCloudBucketsPassiveChecks(t *testing.T) {
+	tmpl, err := cloud.LoadTemplates(strings.NewReader(cloudTestTemplates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	e := &Enumeration{
+		Config: cfg,
+		infra:  newInfraStore(),
+		cloudNames: &cloudGenerator{
+			templates: tmpl,
+			threshold: 1,
+			max:       10,
+			workers:   1,
+			generated: make(map[string]int),
+			head: func(ctx context.Context, url string) (int, error) {
+				t.Errorf("the bucket %s was checked by an enumeration that is not active", url)
+				return 200, nil
+			},
+			resolve: func(ctx context.Context, name string) bool { return true },
+		},
+	}
+	e.infra.set("assets.owasp.org", cloud.Classification{Provider: "AWS"})
+
+	e.generateCloudNames(context.Background())
+	if got := e.CloudNames(); len(got) != 1 || got[0].Generator != "AWS/beanstalk" {
+		t.Errorf("the provider names were %+v", got)
+	}
+}
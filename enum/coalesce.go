@@ -0,0 +1,406 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// maxCachedAnswers is the number of answers the coalescer keeps for their TTL.
+const maxCachedAnswers = 10000
+
+// CoalesceStats holds the number of queries sent by the enumeration and of those that joined an identical query in flight.
+type CoalesceStats struct {
+	// Queries is the number of queries sent to the resolver pools
+	Queries int64 `json:"queries"`
+	// Coalesced is the number of queries answered by an identical query already in flight
+	Coalesced int64 `json:"coalesced"`
+	// Cached is the number of queries answered by the response of an identical query within its TTL
+	Cached int64 `json:"cached,omitempty"`
+}
+
+// coalescer shares a single query among the callers asking the same question of the same pool at the same time,
+// such as a name guessed by the brute forcing while a certificate and a data source provide it. The answers are
+// kept for their TTL within the bounds of the configuration, so the same question asked later is not sent again.
+type coalescer struct {
+	sync.Mutex
+	flights   map[string]*flight
+	answers   map[string]*cachedAnswer
+	ttls      systems.TTLBounds
+	clock     clock.Clock
+	queries   int64
+	coalesced int64
+	cached    int64
+	// joined is told of the name whose query joined an identical query in flight, when set
+	joined func(name, component string)
+	// answered is told of the name whose query was answered by the cache, when set
+	answered func(name, component string)
+}
+
+// cachedAnswer is the response of a flight, kept until the bounded TTL of its answers has elapsed.
+type cachedAnswer struct {
+	resp    *dns.Msg
+	expires time.Time
+}
+
+// coalescerFromConfig returns the coalescer of the queries, unless the 'dns.coalesce' option disables it.
+func coalescerFromConfig(cfg *config.Config) *coalescer {
+	if cfg != nil && cfg.Options != nil {
+		if opts, ok := cfg.Options["dns"].(map[string]interface{}); ok {
+			if enabled, ok := opts["coalesce"].(bool); ok && !enabled {
+				return nil
+			}
+		}
+	}
+	// The bounds are checked when the System is built, so the defaults are kept otherwise
+	ttls, err := systems.TTLBoundsFromConfig(cfg)
+	if err != nil {
+		ttls = systems.TTLBounds{Floor: systems.DefaultTTLFloor, Ceiling: systems.DefaultTTLCeiling}
+	}
+	return &coalescer{
+		flights: make(map[string]*flight),
+		answers: make(map[string]*cachedAnswer),
+		ttls:    ttls,
+		clock:   clock.System,
+	}
+}
+
+// stats returns the number of queries sent and coalesced so far.
+func (c *coalescer) stats() CoalesceStats {
+	if c == nil {
+		return CoalesceStats{}
+	}
+	return CoalesceStats{
+		Queries:   atomic.LoadInt64(&c.queries),
+		Coalesced: atomic.LoadInt64(&c.coalesced),
+		Cached:    atomic.LoadInt64(&c.cached),
+	}
+}
+
+// pool returns the pool with the identical queries in flight coalesced, separately from the other components.
+func (c *coalescer) pool(pool Pool, component string) Pool {
+	if c == nil {
+		return pool
+	}
+	return &coalescingPool{Pool: pool, c: c, component: component}
+}
+
+// flightKey returns the key of the question, which includes the flags that change the answer, or
+// the empty string when the message cannot be coalesced.
+func flightKey(component string, msg *dns.Msg) string {
+	if msg == nil || len(msg.Question) != 1 {
+		return ""
+	}
+
+	q := msg.Question[0]
+	var do bool
+	if opt := msg.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+	return strings.Join([]string{
+		component,
+		strings.ToLower(resolve.RemoveLastDot(q.Name)),
+		strconv.Itoa(int(q.Qtype)),
+		strconv.Itoa(int(q.Qclass)),
+		strconv.FormatBool(msg.RecursionDesired),
+		strconv.FormatBool(msg.CheckingDisabled),
+		strconv.FormatBool(do),
+	}, "|")
+}
+
+// waiter is a caller of the asynchronous Query method waiting on the response of a flight.
+type waiter struct {
+	id uint16
+	ch chan *dns.Msg
+	// done is closed once the caller gives up, such as a DNS task that stopped draining its channel
+	done <-chan struct{}
+}
+
+// deliver sends the response to the caller, unless it gives up first.
+func (w waiter) deliver(resp *dns.Msg) {
+	select {
+	case w.ch <- resp:
+	case <-w.done:
+	}
+}
+
+// flight is a query on the wire, shared by the callers that asked the same question before it was answered.
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	waiters []waiter
+	// active is the number of callers whose context has not been cancelled
+	active int
+	resp   *dns.Msg
+	err    error
+}
+
+// flightContext carries the values of the context of the caller that started the flight, such as the
+// bandwidth meter, without being cancelled along with it.
+type flightContext struct {
+	context.Context
+	values context.Context
+}
+
+func (fc *flightContext) Value(key interface{}) interface{} {
+	return fc.values.Value(key)
+}
+
+// join returns the flight of the question and true when it was already in flight, or starts it.
+func (c *coalescer) join(ctx context.Context, key string, w *waiter) (*flight, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if f, found := c.flights[key]; found {
+		f.active++
+		if w != nil {
+			f.waiters = append(f.waiters, *w)
+		}
+		atomic.AddInt64(&c.coalesced, 1)
+		return f, true
+	}
+
+	fctx, cancel := context.WithCancel(context.Background())
+	f := &flight{
+		ctx:    &flightContext{Context: fctx, values: ctx},
+		cancel: cancel,
+		done:   make(chan struct{}),
+		active: 1,
+	}
+	if w != nil {
+		f.waiters = append(f.waiters, *w)
+	}
+	c.flights[key] = f
+	atomic.AddInt64(&c.queries, 1)
+	return f, false
+}
+
+// watch releases the interest of the caller once its context is cancelled, and cancels the flight
+// once no caller is waiting on it. The query continues for the other callers.
+func (c *coalescer) watch(ctx context.Context, f *flight) {
+	if ctx.Done() == nil {
+		return
+	}
+
+	go func() {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			c.Lock()
+			f.active--
+			last := f.active == 0
+			c.Unlock()
+
+			if last {
+				f.cancel()
+			}
+		}
+	}()
+}
+
+// finish records the response of the flight, removes it from the flights in progress, and delivers
+// a copy of the response carrying the message ID of each asynchronous caller. The callers not ready
+// to receive it are answered apart, so a caller that gave up never holds the others.
+func (c *coalescer) finish(key string, f *flight, resp *dns.Msg, err error) {
+	c.Lock()
+	delete(c.flights, key)
+	f.resp, f.err = resp, err
+	waiters := f.waiters
+	if err == nil {
+		c.keep(key, resp)
+	}
+	c.Unlock()
+
+	close(f.done)
+	f.cancel()
+	for _, w := range waiters {
+		resp := f.response(w.id)
+
+		select {
+		case w.ch <- resp:
+		default:
+			go w.deliver(resp)
+		}
+	}
+}
+
+// keep caches the successful response holding answers for their least TTL within the bounds. The expired
+// answers are dropped once the cache is full, and the response is not kept while it remains full.
+func (c *coalescer) keep(key string, resp *dns.Msg) {
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return
+	}
+
+	ttl, ok := systems.MessageTTL(resp)
+	if !ok {
+		return
+	}
+
+	now := c.clock.Now()
+	if len(c.answers) >= maxCachedAnswers {
+		for k, a := range c.answers {
+			if !now.Before(a.expires) {
+				delete(c.answers, k)
+			}
+		}
+		if len(c.answers) >= maxCachedAnswers {
+			return
+		}
+	}
+	c.answers[key] = &cachedAnswer{resp: resp, expires: now.Add(c.ttls.Apply(ttl))}
+}
+
+// cachedResponse returns a copy of the response to the question with the message ID of the caller,
+// or nil when no answer is kept for it.
+func (c *coalescer) cachedResponse(key string, id uint16) *dns.Msg {
+	c.Lock()
+	defer c.Unlock()
+
+	a, found := c.answers[key]
+	if !found {
+		return nil
+	}
+	if !c.clock.Now().Before(a.expires) {
+		delete(c.answers, key)
+		return nil
+	}
+
+	atomic.AddInt64(&c.cached, 1)
+	resp := a.resp.Copy()
+	resp.Id = id
+	return resp
+}
+
+// response returns a copy of the response of the flight with the message ID of the caller, so
+// the callers never share a message.
+func (f *flight) response(id uint16) *dns.Msg {
+	if f.resp == nil {
+		return nil
+	}
+
+	resp := f.resp.Copy()
+	resp.Id = id
+	return resp
+}
+
+// coalescingPool sends each question to the pool once while it is in flight, and every caller receives the answer.
+type coalescingPool struct {
+	Pool
+	c         *coalescer
+	component string
+}
+
+func (cp *coalescingPool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	key := flightKey(cp.component, msg)
+	if key == "" {
+		cp.Pool.Query(ctx, msg, ch)
+		return
+	}
+	if resp := cp.c.cachedResponse(key, msg.Id); resp != nil {
+		cp.c.traceCached(msg, cp.component)
+		go func() { ch <- resp }()
+		return
+	}
+
+	f, joined := cp.c.join(ctx, key, &waiter{id: msg.Id, ch: ch, done: ctx.Done()})
+	cp.c.watch(ctx, f)
+	if joined {
+		cp.c.traceJoined(msg, cp.component)
+		return
+	}
+
+	resps := make(chan *dns.Msg, 1)
+	go func() {
+		resp := <-resps
+		cp.c.finish(key, f, resp, nil)
+	}()
+	cp.Pool.Query(f.ctx, msg, resps)
+}
+
+func (cp *coalescingPool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	key := flightKey(cp.component, msg)
+	if key == "" {
+		return cp.Pool.QueryBlocking(ctx, msg)
+	}
+	if resp := cp.c.cachedResponse(key, msg.Id); resp != nil {
+		cp.c.traceCached(msg, cp.component)
+		return resp, nil
+	}
+
+	f, joined := cp.c.join(ctx, key, nil)
+	cp.c.watch(ctx, f)
+	if joined {
+		cp.c.traceJoined(msg, cp.component)
+	} else {
+		go func() {
+			resp, err := cp.Pool.QueryBlocking(f.ctx, msg)
+			cp.c.finish(key, f, resp, err)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return msg, ctx.Err()
+	case <-f.done:
+	}
+
+	resp := f.response(msg.Id)
+	if f.err != nil {
+		return resp, f.err
+	}
+	if resp == nil {
+		return nil, errors.New("query failed")
+	}
+	return resp, nil
+}
+
+// traceJoined tells of the name whose query joined an identical query in flight.
+func (c *coalescer) traceJoined(msg *dns.Msg, component string) {
+	if c.joined != nil {
+		c.joined(strings.ToLower(resolve.RemoveLastDot(msg.Question[0].Name)), component)
+	}
+}
+
+// traceCached tells of the name whose query was answered by the response kept for its TTL.
+func (c *coalescer) traceCached(msg *dns.Msg, component string) {
+	if c.answered != nil {
+		c.answered(strings.ToLower(resolve.RemoveLastDot(msg.Question[0].Name)), component)
+	}
+}
+
+// coalescePool returns the pool of the component with the identical queries in flight coalesced.
+func (e *Enumeration) coalescePool(pool Pool, component string) Pool {
+	return e.coalescer.pool(pool, component)
+}
+
+// Coalesced returns the number of queries sent to the resolver pools, and of those answered by an identical query in flight.
+func (e *Enumeration) Coalesced() CoalesceStats {
+	return e.coalescer.stats()
+}
+
+// reportCoalesced logs the number of queries answered by an identical query in flight or within its TTL.
+func (e *Enumeration) reportCoalesced() {
+	s := e.coalescer.stats()
+	if s.Coalesced > 0 {
+		e.Config.Log.Printf("%d of the DNS queries joined an identical query in flight, and %d were sent to the resolver pools",
+			s.Coalesced, s.Queries)
+	}
+	if s.Cached > 0 {
+		e.Config.Log.Printf("%d of the DNS queries were answered within the TTL of an identical query", s.Cached)
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// gatedPool holds the queries until it is released, like a slow resolver.
+type gatedPool struct {
+	zonePool
+	release chan struct{}
+	sent    int64
+	// cancelled is the number of queries whose context was cancelled while they were held
+	cancelled int64
+}
+
+func newGatedPool() *gatedPool {
+	return &gatedPool{
+		zonePool: zonePool{addrs: map[string]string{"www.owasp.org.": "192.0.2.1"}},
+		release:  make(chan struct{}),
+	}
+}
+
+func (gp *gatedPool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	atomic.AddInt64(&gp.sent, 1)
+	go func() {
+		<-gp.release
+		resp, _ := gp.zonePool.QueryBlocking(ctx, msg)
+		ch <- resp
+	}()
+}
+
+func (gp *gatedPool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	atomic.AddInt64(&gp.sent, 1)
+	select {
+	case <-ctx.Done():
+		atomic.AddInt64(&gp.cancelled, 1)
+		return msg, errors.New("the context expired")
+	case <-gp.release:
+	}
+	return gp.zonePool.QueryBlocking(ctx, msg)
+}
+
+// waitCoalesced waits for the number of queries to join the flights in progress.
+func waitCoalesced(t *testing.T, c *coalescer, n int64) {
+	for deadline := time.Now().Add(5 * time.Second); c.stats().Coalesced < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d queries were coalesced, expected %d", c.stats().Coalesced, n)
+		}
+	}
+}
+
+func TestCoalescedQueries(t *testing.T) {
+	c := coalescerFromConfig(config.NewConfig())
+	gp := newGatedPool()
+	pool := c.pool(gp, componentResolvers)
+
+	const async, blocking = 20, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, async+blocking)
+	for i := 0; i < async; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			msg := resolve.QueryMsg("WWW.owasp.org", dns.TypeA)
+			ch := make(chan *dns.Msg, 1)
+			pool.Query(context.Background(), msg, ch)
+			if resp := <-ch; resp == nil || resp.Id != msg.Id || len(resp.Answer) != 1 {
+				errs <- errors.New("an asynchronous caller did not receive the answer with its message ID")
+			}
+		}()
+	}
+	for i := 0; i < blocking; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+			if resp, err := pool.QueryBlocking(context.Background(), msg); err != nil || resp.Id != msg.Id || len(resp.Answer) != 1 {
+				errs <- errors.New("a blocking caller did not receive the answer with its message ID")
+			}
+		}()
+	}
+
+	waitCoalesced(t, c, async+blocking-1)
+	// Another type is another question
+	ch := make(chan *dns.Msg, 1)
+	pool.Query(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeAAAA), ch)
+	// Another component is another pool
+	trusted := c.pool(gp, componentTrusted)
+	trusted.Query(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA), ch)
+	close(gp.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	<-ch
+	<-ch
+
+	if sent := atomic.LoadInt64(&gp.sent); sent != 3 {
+		t.Errorf("%d queries were sent to the pool, expected 3", sent)
+	}
+	if s := c.stats(); s.Queries != 3 || s.Coalesced != async+blocking-1 {
+		t.Errorf("the statistics were %+v", s)
+	}
+	if len(c.flights) != 0 {
+		t.Errorf("%d flights were left in progress", len(c.flights))
+	}
+}
+
+func TestCoalesceLeaderCancelled(t *testing.T) {
+	c := coalescerFromConfig(config.NewConfig())
+	gp := newGatedPool()
+	pool := c.pool(gp, componentTrusted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := pool.QueryBlocking(ctx, resolve.QueryMsg("www.owasp.org", dns.TypeA))
+		leader <- err
+	}()
+	for atomic.LoadInt64(&gp.sent) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := make(chan *dns.Msg, 1)
+	pool.Query(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA), follower)
+	waitCoalesced(t, c, 1)
+
+	// The caller that started the query gives up, and the query continues for the other caller
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("the cancelled caller returned %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gp.release)
+
+	if resp := <-follower; resp == nil || len(resp.Answer) != 1 {
+		t.Errorf("the remaining caller received %v", resp)
+	}
+	if n := atomic.LoadInt64(&gp.cancelled); n != 0 {
+		t.Errorf("the shared query was cancelled along with its first caller")
+	}
+}
+
+func TestCoalesceCancelledWaiter(t *testing.T) {
+	c := coalescerFromConfig(config.NewConfig())
+	gp := newGatedPool()
+	pool := c.pool(gp, componentResolvers)
+
+	// The first caller gives up and no longer drains its channel
+	ctx, cancel := context.WithCancel(context.Background())
+	stalled := make(chan *dns.Msg)
+	pool.Query(ctx, resolve.QueryMsg("www.owasp.org", dns.TypeA), stalled)
+	for atomic.LoadInt64(&gp.sent) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := make(chan *dns.Msg, 1)
+	pool.Query(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA), follower)
+	waitCoalesced(t, c, 1)
+
+	cancel()
+	close(gp.release)
+	select {
+	case resp := <-follower:
+		if resp == nil || len(resp.Answer) != 1 {
+			t.Errorf("the remaining caller received %v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the caller that gave up blocked the delivery to the other caller")
+	}
+}
+
+func TestCoalesceAllCancelled(t *testing.T) {
+	c := coalescerFromConfig(config.NewConfig())
+	gp := newGatedPool()
+	pool := c.pool(gp, componentTrusted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = pool.QueryBlocking(ctx, resolve.QueryMsg("www.owasp.org", dns.TypeA))
+		}()
+	}
+	waitCoalesced(t, c, 2)
+
+	// The query on the wire is cancelled once no caller is waiting on it
+	cancel()
+	wg.Wait()
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&gp.cancelled) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the query was not cancelled once every caller gave up")
+		}
+	}
+
+	// A later question starts a new query
+	close(gp.release)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		c.Lock()
+		n := len(c.flights)
+		c.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the cancelled flight was left in progress")
+		}
+	}
+	if resp, err := pool.QueryBlocking(context.Background(), resolve.QueryMsg("www.owasp.org", dns.TypeA)); err != nil || len(resp.Answer) != 1 {
+		t.Errorf("the later query returned %v: %v", resp, err)
+	}
+	if s := c.stats(); s.Queries != 2 {
+		t.Errorf("the statistics were %+v", s)
+	}
+}
+
+func TestCoalesceComposesWithMeter(t *testing.T) {
+	e := &Enumeration{
+		Config:    config.NewConfig(),
+		meter:     bandwidth.NewMeter(0),
+		coalescer: coalescerFromConfig(config.NewConfig()),
+	}
+	gp := newGatedPool()
+	pool := e.coalescePool(e.metered(gp, componentTrusted), componentTrusted)
+
+	msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+	chs := []chan *dns.Msg{make(chan *dns.Msg, 1), make(chan *dns.Msg, 1)}
+	for _, ch := range chs {
+		pool.Query(context.Background(), msg.Copy(), ch)
+	}
+	close(gp.release)
+
+	var resp *dns.Msg
+	for _, ch := range chs {
+		resp = <-ch
+	}
+	// The bytes of the shared query are accounted for once
+	expected := bandwidth.Counter{Sent: int64(bandwidth.MsgSize(msg)), Received: int64(bandwidth.MsgSize(resp))}
+	for deadline := time.Now().Add(5 * time.Second); e.Bandwidth().Components[componentTrusted] != expected; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the meter accounted for %+v, expected %+v", e.Bandwidth().Components[componentTrusted], expected)
+		}
+	}
+	if s := e.Coalesced(); s.Queries != 1 || s.Coalesced != 1 {
+		t.Errorf("the statistics were %+v", s)
+	}
+}
+
+// cdnPool answers with the short TTL of a CDN, and counts the queries it receives.
+type cdnPool struct {
+	zonePool
+	sent int64
+}
+
+func (cp *cdnPool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	resp, _ := cp.QueryBlocking(ctx, msg)
+	ch <- resp
+}
+
+func (cp *cdnPool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	atomic.AddInt64(&cp.sent, 1)
+	resp, err := cp.zonePool.QueryBlocking(ctx, msg)
+	for _, rr := range resp.Answer {
+		rr.Header().Ttl = 30
+	}
+	return resp, err
+}
+
+func TestCoalescerCachesForTTLFloor(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["dns"] = map[string]interface{}{"ttl_floor": 300}
+	c := coalescerFromConfig(cfg)
+	fake := clock.NewFake(time.Now())
+	c.clock = fake
+
+	cp := &cdnPool{zonePool: zonePool{addrs: map[string]string{"cdn.owasp.org.": "192.0.2.30"}}}
+	pool := c.pool(cp, componentTrusted)
+	query := func() *dns.Msg {
+		msg := resolve.QueryMsg("cdn.owasp.org", dns.TypeA)
+		resp, err := pool.QueryBlocking(context.Background(), msg)
+		if err != nil || resp.Id != msg.Id || len(resp.Answer) != 1 {
+			t.Fatalf("the query returned %v: %v", resp, err)
+		}
+		return resp
+	}
+
+	// The answer is kept for the floor, rather than the 30 seconds of its TTL
+	query()
+	fake.Jump(31 * time.Second)
+	if resp := query(); resp.Answer[0].Header().Ttl != 30 {
+		t.Errorf("the cached answer carries the TTL %d", resp.Answer[0].Header().Ttl)
+	}
+	fake.Jump(4 * time.Minute)
+	ch := make(chan *dns.Msg)
+	pool.Query(context.Background(), resolve.QueryMsg("cdn.owasp.org", dns.TypeA), ch)
+	if resp := <-ch; resp == nil || len(resp.Answer) != 1 {
+		t.Errorf("the asynchronous query received %v", resp)
+	}
+	if n := atomic.LoadInt64(&cp.sent); n != 1 {
+		t.Errorf("%d queries were sent within the floor", n)
+	}
+
+	fake.Jump(time.Minute)
+	query()
+	if n := atomic.LoadInt64(&cp.sent); n != 2 {
+		t.Errorf("%d queries were sent once the floor had elapsed", n)
+	}
+	if s := c.stats(); s.Cached != 2 || s.Queries != 2 {
+		t.Errorf("the statistics were %+v", s)
+	}
+
+	// The answers without records are never kept
+	for i := 0; i < 2; i++ {
+		if resp, err := pool.QueryBlocking(context.Background(), resolve.QueryMsg("old.owasp.org", dns.TypeA)); err != nil || resp.Rcode != dns.RcodeNameError {
+			t.Fatalf("the query returned %v: %v", resp, err)
+		}
+	}
+	if n := atomic.LoadInt64(&cp.sent); n != 4 {
+		t.Errorf("%d queries were sent, the NXDOMAIN answers were cached", n)
+	}
+}
+
+func TestCoalescerFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["dns"] = map[string]interface{}{"coalesce": false}
+	c := coalescerFromConfig(cfg)
+	if c != nil {
+		t.Fatal("the coalescer was returned once disabled")
+	}
+
+	gp := newGatedPool()
+	if pool := c.pool(gp, componentResolvers); pool != Pool(gp) {
+		t.Error("the disabled coalescer wrapped the pool")
+	}
+	if s := c.stats(); s != (CoalesceStats{}) {
+		t.Errorf("the disabled coalescer had the statistics %+v", s)
+	}
+}
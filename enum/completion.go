@@ -0,0 +1,300 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// The defaults of the 'completion' configuration options.
+const (
+	DefaultQuiescence     = 3 * time.Minute
+	DefaultSourceTrailing = 10 * time.Minute
+)
+
+// Termination is the reason an enumeration finished, which is recorded in the metadata of the event.
+type Termination string
+
+// The reasons an enumeration finishes.
+const (
+	// TerminationCompleted is recorded when all the outstanding work was done
+	TerminationCompleted Termination = "completed"
+	// TerminationQuiescent is recorded when the data sources still had requests outstanding, but
+	// no new findings arrived for the quiescence period or the sources trailed for too long
+	TerminationQuiescent Termination = "quiescent-timeout"
+	// TerminationBudget is recorded when the duration, DNS query or byte budget of the enumeration was exhausted
+	TerminationBudget Termination = "budget"
+	// TerminationCancelled is recorded when the enumeration was cancelled by the caller
+	TerminationCancelled Termination = "cancelled"
+	// TerminationMemory is recorded when the memory consumption exceeded the hard limit
+	TerminationMemory Termination = "memory-limit"
+)
+
+// completion decides when the enumeration has finished. The enumeration is finished once the candidate
+// queue, the pipeline and the resolvers have no work outstanding, and either the data sources have answered
+// all their requests, no new findings have arrived for the quiescence period, or the data sources still
+// holding requests have trailed the rest of the enumeration for longer than allowed.
+type completion struct {
+	sync.Mutex
+	clock      clock.Clock
+	quiescence time.Duration
+	trailing   time.Duration
+	// finding is the time the last new finding arrived
+	finding time.Time
+	// active is the component that was last active, and when
+	active     string
+	activeTime time.Time
+	// idleSince is the time the data sources were last found to be the only work outstanding
+	idleSince time.Time
+	reason    Termination
+	// cause describes why the enumeration was cut short, and is nil when it was not
+	cause *requests.CancelCause
+}
+
+// completionFromConfig parses the 'completion' configuration options, where a period of zero disables the check.
+func completionFromConfig(cfg *config.Config, c clock.Clock) *completion {
+	comp := &completion{
+		clock:      c,
+		quiescence: DefaultQuiescence,
+		trailing:   DefaultSourceTrailing,
+	}
+
+	if cfg != nil {
+		if opts, ok := cfg.Options["completion"].(map[string]interface{}); ok {
+			if v, found := opts["quiescence"]; found {
+				if n := options.Int(v); n >= 0 {
+					comp.quiescence = time.Duration(n) * time.Second
+				}
+			}
+			if v, found := opts["source_trailing"]; found {
+				if n := options.Int(v); n >= 0 {
+					comp.trailing = time.Duration(n) * time.Second
+				}
+			}
+		}
+	}
+
+	now := c.Now()
+	comp.finding, comp.activeTime = now, now
+	return comp
+}
+
+// activity records the component doing work, such as the resolvers or a data source. The activity of a
+// new finding also extends the quiescence period.
+func (c *completion) activity(component string, finding bool) {
+	if c == nil {
+		return
+	}
+
+	now := c.clock.Now()
+	c.Lock()
+	defer c.Unlock()
+
+	c.active, c.activeTime = component, now
+	if finding {
+		c.finding = now
+	}
+}
+
+// check returns true when the enumeration has finished, given whether the candidate queue, the pipeline
+// and the resolvers are busy, and the data sources with requests outstanding.
+func (c *completion) check(busy bool, pending []string) (Termination, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if busy {
+		c.idleSince = time.Time{}
+		return "", false
+	}
+	if len(pending) == 0 {
+		return TerminationCompleted, true
+	}
+
+	now := c.clock.Now()
+	if c.idleSince.IsZero() {
+		c.idleSince = now
+	}
+	if c.quiescence > 0 && now.Sub(c.finding) >= c.quiescence {
+		return TerminationQuiescent, true
+	}
+	if c.trailing > 0 && now.Sub(c.idleSince) >= c.trailing {
+		return TerminationQuiescent, true
+	}
+	return "", false
+}
+
+// lastActive returns the component that was last active, and when.
+func (c *completion) lastActive() (string, time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.active, c.activeTime
+}
+
+// setReason records the reason the enumeration finished, unless one was already recorded.
+func (c *completion) setReason(r Termination) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.reason == "" {
+		c.reason = r
+	}
+}
+
+// cutShort records the cause and the reason of the enumeration that was cut short, unless the enumeration
+// already finished for another reason than completing its work.
+func (c *completion) cutShort(cause *requests.CancelCause) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.reason != "" && c.reason != TerminationCompleted {
+		return false
+	}
+	c.reason, c.cause = terminationOf(cause), cause
+	return true
+}
+
+func (c *completion) cancelCause() *requests.CancelCause {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.cause
+}
+
+func (c *completion) termination() Termination {
+	if c == nil {
+		return ""
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.reason
+}
+
+// Termination returns the reason the enumeration finished, or an empty string while it is running.
+func (e *Enumeration) Termination() Termination {
+	return e.completion.termination()
+}
+
+// TerminationReason returns the cause of the enumeration that was cut short, such as by an exhausted budget,
+// the operator or the memory hard limit, and nil while it is running or when it was not cut short.
+func (e *Enumeration) TerminationReason() *requests.CancelCause {
+	return e.completion.cancelCause()
+}
+
+// terminationOf returns the reason recorded for the enumeration cancelled with the cause.
+func terminationOf(cause *requests.CancelCause) Termination {
+	switch cause.Reason {
+	case requests.CancelBudget:
+		return TerminationBudget
+	case requests.CancelMemory:
+		return TerminationMemory
+	}
+	return TerminationCancelled
+}
+
+// checkCompletion returns true once the enumeration has finished, logging the reason and the component
+// that was last active when the enumeration is cut short by the quiescence or trailing limits.
+func (r *enumSource) checkCompletion() bool {
+	e := r.enum
+
+	busy := r.queue.Len() > 0 || r.pipeline.DataItemCount() > 0 || e.store.queue.Len() > 0 ||
+		e.dnsTask.outstanding() > 0 || e.valTask.outstanding() > 0 || e.bruteFb.held() > 0 || e.addrScope.busy()
+	pending := e.pendingSources()
+
+	reason, done := e.completion.check(busy, pending)
+	if !done {
+		return false
+	}
+
+	if reason == TerminationQuiescent {
+		component, when := e.completion.lastActive()
+		e.Config.Log.Printf("The enumeration is finished with requests outstanding at %s, and %s was last active at %s",
+			strings.Join(pending, ", "), component, when.Format(time.RFC3339))
+	}
+	e.completion.setReason(reason)
+	return true
+}
+
+// finishReason records why the enumeration returned, given the context the enumeration was started with, and
+// logs the cause of the enumeration that was cut short. The cause recorded by the cancellation is preferred, and
+// the other cuts are described from the state of the contexts and the budgets.
+func (e *Enumeration) finishReason(parent context.Context) {
+	cause := requests.CancelCauseFromContext(e.ctx)
+	switch {
+	case cause != nil:
+	case parent.Err() != nil:
+		cause = requests.NewCancelCause(requests.CancelStopped, "the enumeration was cancelled by the caller")
+	case errors.Is(e.ctx.Err(), context.DeadlineExceeded):
+		cause = requests.NewCancelCause(requests.CancelBudget,
+			fmt.Sprintf("the duration budget of %s was exhausted", e.Budget.Duration))
+	case e.Budget.DNSQueries > 0 && e.queriesSpent() > e.Budget.DNSQueries:
+		// The work left undone by the exhausted query or byte budget was discarded, which leaves the queues empty
+		cause = requests.NewCancelCause(requests.CancelBudget,
+			fmt.Sprintf("the DNS query budget of %d queries was exhausted", e.Budget.DNSQueries))
+	case e.meter.Exhausted():
+		cause = requests.NewCancelCause(requests.CancelBudget,
+			fmt.Sprintf("the byte budget of %d bytes was exhausted", e.meter.Budget()))
+	}
+
+	if cause != nil && e.completion.cutShort(cause) && e.Config != nil {
+		e.Config.Log.Printf("The enumeration was cut short (%s): %s", cause.Reason, cause.Detail)
+	}
+	e.completion.setReason(TerminationCompleted)
+}
+
+// cancelWith cuts the enumeration short with the cause.
+func (e *Enumeration) cancelWith(cause *requests.CancelCause) {
+	if e.cancel != nil {
+		e.cancel(cause)
+	}
+}
+
+// saveCursors gives the data sources keeping a cursor the chance to persist it, along with the cause of the
+// enumeration that was cut short, before the System stops them.
+func (e *Enumeration) saveCursors() {
+	e.plock.Lock()
+	srcs := append(append([]service.Service(nil), e.srcs...), e.lateSrcs...)
+	e.plock.Unlock()
+
+	cause := e.TerminationReason()
+	ctx := requests.WithJob(context.Background(), e.job)
+	for _, src := range srcs {
+		if cs, ok := src.(requests.CursorSaver); ok {
+			cs.SaveCursor(ctx, cause)
+		}
+	}
+}
+
+// pendingSources returns the names of the data sources with requests outstanding.
+func (e *Enumeration) pendingSources() []string {
+	e.plock.Lock()
+	defer e.plock.Unlock()
+
+	names := append([]string(nil), e.pendingSrcs...)
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,192 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestCompletionFromConfig(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	cfg := config.NewConfig()
+	if comp := completionFromConfig(cfg, c); comp.quiescence != DefaultQuiescence || comp.trailing != DefaultSourceTrailing {
+		t.Errorf("the default periods are %v and %v", comp.quiescence, comp.trailing)
+	}
+
+	cfg.Options = map[string]interface{}{"completion": map[string]interface{}{"quiescence": 30, "source_trailing": 0}}
+	if comp := completionFromConfig(cfg, c); comp.quiescence != 30*time.Second || comp.trailing != 0 {
+		t.Errorf("the periods were parsed as %v and %v", comp.quiescence, comp.trailing)
+	}
+}
+
+func TestCompletionCheck(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	comp := &completion{clock: c, quiescence: time.Minute, trailing: 5 * time.Minute}
+	comp.activity("Crtsh", true)
+
+	if _, done := comp.check(true, nil); done {
+		t.Error("the enumeration finished with the queues busy")
+	}
+	if reason, done := comp.check(false, nil); !done || reason != TerminationCompleted {
+		t.Errorf("the enumeration without outstanding work returned %s and %t", reason, done)
+	}
+
+	// The data source with a request outstanding holds the enumeration until the quiescence period passes
+	pending := []string{"Slow"}
+	if _, done := comp.check(false, pending); done {
+		t.Error("the enumeration finished before the quiescence period")
+	}
+	c.Jump(30 * time.Second)
+	comp.activity("Slow", true)
+	c.Jump(45 * time.Second)
+	if _, done := comp.check(false, pending); done {
+		t.Error("the new finding did not extend the quiescence period")
+	}
+	c.Jump(15 * time.Second)
+	if reason, done := comp.check(false, pending); !done || reason != TerminationQuiescent {
+		t.Errorf("the quiescent enumeration returned %s and %t", reason, done)
+	}
+
+	// The source dribbling new findings is cut off once it has trailed for the maximum time
+	comp = &completion{clock: c, trailing: 5 * time.Minute}
+	for i := 0; i < 4; i++ {
+		comp.activity("Slow", true)
+		if _, done := comp.check(false, pending); done {
+			t.Fatalf("the enumeration finished after %d minutes of trailing", i)
+		}
+		c.Jump(time.Minute)
+	}
+	// The busy pipeline restarts the trailing time
+	if _, done := comp.check(true, pending); done {
+		t.Error("the enumeration finished with the queues busy")
+	}
+	for i := 0; i < 5; i++ {
+		comp.activity("Slow", true)
+		_, _ = comp.check(false, pending)
+		c.Jump(time.Minute)
+	}
+	if reason, done := comp.check(false, pending); !done || reason != TerminationQuiescent {
+		t.Errorf("the trailing source held the enumeration, returning %s and %t", reason, done)
+	}
+	if component, _ := comp.lastActive(); component != "Slow" {
+		t.Errorf("the last active component was %s", component)
+	}
+}
+
+func TestFinishReason(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	// The causes recorded by the operator and the memory hard limit on the context of the enumeration
+	stopped, stop := requests.WithCancelCause(context.Background())
+	stop(requests.NewCancelCause(requests.CancelStopped, "the interrupt signal was received"))
+	exceeded, exceed := requests.WithCancelCause(context.Background())
+	exceed(requests.NewCancelCause(requests.CancelMemory, "the hard limit was exceeded"))
+
+	for _, test := range []struct {
+		parent   context.Context
+		ctx      context.Context
+		recorded Termination
+		budget   Budget
+		queries  int64
+		expected Termination
+		cause    requests.CancelReason
+		detail   string
+	}{
+		{context.Background(), context.Background(), TerminationCompleted, Budget{}, 0, TerminationCompleted, "", ""},
+		{context.Background(), context.Background(), TerminationQuiescent, Budget{}, 0, TerminationQuiescent, "", ""},
+		{cancelled, cancelled, "", Budget{}, 0, TerminationCancelled, requests.CancelStopped, "the enumeration was cancelled by the caller"},
+		{stopped, stopped, "", Budget{}, 0, TerminationCancelled, requests.CancelStopped, "the interrupt signal was received"},
+		{context.Background(), exceeded, TerminationCompleted, Budget{}, 0, TerminationMemory, requests.CancelMemory, "the hard limit was exceeded"},
+		{context.Background(), expired, "", Budget{Duration: time.Second}, 0, TerminationBudget, requests.CancelBudget, "the duration budget of 1s was exhausted"},
+		{context.Background(), context.Background(), TerminationCompleted, Budget{DNSQueries: 10}, 11, TerminationBudget,
+			requests.CancelBudget, "the DNS query budget of 10 queries was exhausted"},
+		{context.Background(), context.Background(), TerminationCompleted, Budget{DNSQueries: 10}, 10, TerminationCompleted, "", ""},
+		// The enumeration that already finished for another reason keeps it
+		{context.Background(), exceeded, TerminationQuiescent, Budget{}, 0, TerminationQuiescent, "", ""},
+	} {
+		e := &Enumeration{
+			ctx:        test.ctx,
+			Budget:     test.budget,
+			queries:    test.queries,
+			completion: &completion{clock: c, reason: test.recorded},
+		}
+
+		e.finishReason(test.parent)
+		if e.Termination() != test.expected {
+			t.Errorf("the termination was recorded as %s, expected %s", e.Termination(), test.expected)
+		}
+		md := e.Metadata()
+		if md["termination"] != string(test.expected) {
+			t.Errorf("the metadata holds the termination %s", md["termination"])
+		}
+
+		cause := e.TerminationReason()
+		if test.cause == "" {
+			if cause != nil || md["cancel_reason"] != "" {
+				t.Errorf("the enumeration that finished as %s was cut short by %+v", test.expected, cause)
+			}
+			continue
+		}
+		if cause == nil || cause.Reason != test.cause || cause.Detail != test.detail {
+			t.Errorf("the enumeration was cut short by %+v, expected %s: %s", cause, test.cause, test.detail)
+			continue
+		}
+		if md["cancel_reason"] != string(test.cause) || md["cancel_detail"] != test.detail {
+			t.Errorf("the metadata holds the cause %s: %s", md["cancel_reason"], md["cancel_detail"])
+		}
+	}
+}
+
+// cursorSource is a data source recording the causes its cursor was saved with.
+type cursorSource struct {
+	service.BaseService
+	saved []*requests.CancelCause
+	job   *requests.Job
+}
+
+func (s *cursorSource) SaveCursor(ctx context.Context, cause *requests.CancelCause) {
+	s.saved = append(s.saved, cause)
+	s.job = requests.JobFromContext(ctx)
+}
+
+func TestSaveCursors(t *testing.T) {
+	src, late := new(cursorSource), new(cursorSource)
+	e := &Enumeration{
+		srcs:       []service.Service{src, new(service.BaseService)},
+		lateSrcs:   []service.Service{late},
+		job:        requests.NewJob("job", config.NewConfig(), nil),
+		completion: &completion{clock: clock.NewFake(time.Now())},
+	}
+
+	// The completed enumeration saves the cursors without a cause
+	e.saveCursors()
+	if len(src.saved) != 1 || src.saved[0] != nil || src.job != e.job || len(late.saved) != 1 {
+		t.Fatalf("the cursors were saved with %v and %v", src.saved, late.saved)
+	}
+
+	e.completion = &completion{clock: clock.NewFake(time.Now())}
+	ctx, cancel := requests.WithCancelCause(context.Background())
+	e.ctx, e.cancel = ctx, cancel
+	e.cancelWith(requests.NewCancelCause(requests.CancelBudget, "the byte budget of 1000 bytes was exhausted"))
+	e.finishReason(context.Background())
+	e.saveCursors()
+	for _, s := range []*cursorSource{src, late} {
+		if len(s.saved) != 2 || s.saved[1] == nil || s.saved[1].Reason != requests.CancelBudget {
+			t.Errorf("the cursor was saved with %v once the budget was exhausted", s.saved)
+		}
+	}
+}
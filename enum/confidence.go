@@ -0,0 +1,441 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/caffix/queue"
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// ConfidenceFile is the name of the file under the output directory holding the confidence in each name.
+const ConfidenceFile = "confidence.json"
+
+// The defaults of the 'confidence' configuration options.
+const (
+	// DefaultConfidenceSource is the score added by each independent source of the name
+	DefaultConfidenceSource = 0.2
+	// DefaultConfidenceSources is the most the sources of the name can add to the score
+	DefaultConfidenceSources = 0.6
+	// DefaultConfidenceResolved is the score added when the name resolved
+	DefaultConfidenceResolved = 0.4
+	// DefaultConfidenceWildcard is the score removed when the name sits under a DNS wildcard
+	DefaultConfidenceWildcard = 0.3
+)
+
+// defaultTagWeights scales the score added by the sources of each type, since the names scraped from
+// web pages and archives are stale or mangled more often than those provided by the APIs.
+var defaultTagWeights = map[string]float64{
+	"archive": 0.5,
+	"brute":   0.5,
+	"alt":     0.5,
+	"crawl":   0.5,
+	"scrape":  0.5,
+}
+
+// NameConfidence is the confidence in a name along with the evidence it was computed from.
+// The graph has no place for the properties, so the scores are kept beside it.
+type NameConfidence struct {
+	Name       string   `json:"name"`
+	Confidence float64  `json:"confidence"`
+	Sources    []string `json:"sources"`
+	Tags       []string `json:"tags"`
+	Resolved   bool     `json:"resolved"`
+	Wildcard   bool     `json:"wildcard,omitempty"`
+}
+
+// confidenceScorer computes the confidence in each name from its independent sources, whether it
+// resolved and whether it sits under a wildcard. The sources corroborating a name after it was
+// sent on the output channel raise its score, and the change is sent as an update record.
+type confidenceScorer struct {
+	sync.Mutex
+	source    float64
+	sources   float64
+	resolved  float64
+	wildcard  float64
+	tags      map[string]float64
+	min       float64
+	srcTags   map[string]string
+	names     map[string]*nameEvidence
+	updates   queue.Queue
+	done      chan struct{}
+	withheld  int
+	corrected int
+}
+
+// nameEvidence holds the sources of a name and the outcome of its resolution.
+type nameEvidence struct {
+	domain   string
+	sources  map[string]string
+	resolved bool
+	wildcard bool
+	score    float64
+	// The name was sent on the output channel, or held back since it scored below the minimum
+	emitted bool
+	held    *requests.Output
+}
+
+// confidenceFromConfig parses the 'confidence' configuration options. The names are always scored,
+// while the options change the weights and set the minimum confidence of the output.
+func confidenceFromConfig(cfg *config.Config) *confidenceScorer {
+	cs := &confidenceScorer{
+		source:   DefaultConfidenceSource,
+		sources:  DefaultConfidenceSources,
+		resolved: DefaultConfidenceResolved,
+		wildcard: DefaultConfidenceWildcard,
+		tags:     make(map[string]float64, len(defaultTagWeights)),
+		srcTags:  make(map[string]string),
+		names:    make(map[string]*nameEvidence),
+		updates:  queue.NewQueue(),
+		done:     make(chan struct{}),
+	}
+	for tag, w := range defaultTagWeights {
+		cs.tags[tag] = w
+	}
+	if cfg == nil || cfg.Options == nil {
+		return cs
+	}
+
+	opts, ok := cfg.Options["confidence"].(map[string]interface{})
+	if !ok {
+		return cs
+	}
+	if f, ok := floatOption(opts["source"]); ok && f >= 0 {
+		cs.source = f
+	}
+	if f, ok := floatOption(opts["sources"]); ok && f >= 0 {
+		cs.sources = f
+	}
+	if f, ok := floatOption(opts["resolved"]); ok && f >= 0 {
+		cs.resolved = f
+	}
+	if f, ok := floatOption(opts["wildcard"]); ok && f >= 0 {
+		cs.wildcard = f
+	}
+	if f, ok := floatOption(opts["min"]); ok && f > 0 && f <= 1 {
+		cs.min = f
+	}
+	if tags, ok := opts["tags"].(map[string]interface{}); ok {
+		for tag, v := range tags {
+			if f, ok := floatOption(v); ok && f >= 0 {
+				cs.tags[strings.ToLower(tag)] = f
+			}
+		}
+	}
+	return cs
+}
+
+func floatOption(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// register records the type of the data source, such as "api" or "scrape", which tags the names it provides.
+func (cs *confidenceScorer) register(srv service.Service) {
+	if cs == nil || srv == nil {
+		return
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	cs.srcTags[srv.String()] = strings.ToLower(srv.Description())
+}
+
+// evidenceTag returns the independent source of the name and its tag. The names provided by the data sources
+// are tagged by the type of the source, and the others by how they were derived.
+func (cs *confidenceScorer) evidenceTag(req *requests.DNSRequest) (string, string) {
+	src := findingSource(req)
+
+	switch req.Derivation {
+	case requests.DerivedFromSource:
+		if tag, found := cs.srcTags[src]; found && tag != "" {
+			return src, tag
+		}
+		return src, "api"
+	case requests.DerivedFromBrute:
+		return src, "brute"
+	case requests.DerivedFromAlteration:
+		return src, "alt"
+	case requests.DerivedFromA, requests.DerivedFromAAAA, requests.DerivedFromCNAME, requests.DerivedFromPTR,
+		requests.DerivedFromSRV, requests.DerivedFromNS, requests.DerivedFromMX, requests.DerivedFromTXT, requests.DerivedFromSOA:
+		return src, "dns"
+	}
+	return src, req.Derivation
+}
+
+func (cs *confidenceScorer) evidence(name string) *nameEvidence {
+	ev, found := cs.names[name]
+	if !found {
+		ev = &nameEvidence{sources: make(map[string]string)}
+		cs.names[name] = ev
+	}
+	return ev
+}
+
+// corroborate records the source providing the name, including the names already submitted by other sources.
+// A name already sent on the output channel, or held back, is scored again and the change is queued.
+func (cs *confidenceScorer) corroborate(req *requests.DNSRequest) {
+	if cs == nil || req == nil || req.Name == "" {
+		return
+	}
+
+	name := strings.ToLower(req.Name)
+	cs.Lock()
+	defer cs.Unlock()
+
+	src, tag := cs.evidenceTag(req)
+	ev := cs.evidence(name)
+	if _, found := ev.sources[src]; found {
+		return
+	}
+	ev.sources[src] = tag
+	if ev.domain == "" {
+		ev.domain = req.Domain
+	}
+	if !ev.emitted && ev.held == nil {
+		return
+	}
+
+	score := cs.score(ev)
+	if score == ev.score {
+		return
+	}
+	ev.score = score
+
+	if ev.emitted {
+		cs.corrected++
+		cs.updates.Append(&requests.Output{
+			Name:       name,
+			Domain:     ev.domain,
+			Confidence: score,
+			Sources:    sortedSources(ev),
+			Update:     true,
+		})
+	} else if score >= cs.min {
+		// The name held back has been corroborated enough to be sent for the first time
+		out := ev.held
+		ev.held = nil
+		ev.emitted = true
+		out.Confidence = score
+		out.Sources = sortedSources(ev)
+		cs.withheld--
+		cs.updates.Append(out)
+	}
+}
+
+// markWildcard records that the name sits under a DNS wildcard, which the resolution did not rule it out of.
+func (cs *confidenceScorer) markWildcard(name string) {
+	if cs == nil {
+		return
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	cs.evidence(strings.ToLower(name)).wildcard = true
+}
+
+// emit scores the name on its way to the output channel, and returns false when it is held back
+// for scoring below the minimum confidence.
+func (cs *confidenceScorer) emit(out *requests.Output, resolved bool) bool {
+	if cs == nil {
+		return true
+	}
+
+	name := strings.ToLower(out.Name)
+	cs.Lock()
+	defer cs.Unlock()
+
+	ev := cs.evidence(name)
+	if ev.domain == "" {
+		ev.domain = out.Domain
+	}
+	ev.resolved = ev.resolved || resolved
+	ev.score = cs.score(ev)
+	out.Confidence = ev.score
+	out.Sources = sortedSources(ev)
+
+	if ev.score < cs.min {
+		if ev.held == nil {
+			cs.withheld++
+		}
+		ev.held = out
+		return false
+	}
+	if ev.held != nil {
+		ev.held = nil
+		cs.withheld--
+	}
+	ev.emitted = true
+	return true
+}
+
+// score returns the confidence in the name, between 0 and 1. Each independent source adds the weight of
+// its tag, up to the limit of the sources, and the penalty of the wildcard is removed from the total.
+func (cs *confidenceScorer) score(ev *nameEvidence) float64 {
+	var sum float64
+	for _, tag := range ev.sources {
+		w := 1.0
+		if tw, found := cs.tags[tag]; found {
+			w = tw
+		}
+		sum += cs.source * w
+	}
+	if sum > cs.sources {
+		sum = cs.sources
+	}
+	if ev.resolved {
+		sum += cs.resolved
+	}
+	if ev.wildcard {
+		sum -= cs.wildcard
+	}
+	// The scores are rounded, so the sums of the weights compare as expected
+	return math.Max(0, math.Min(1, math.Round(sum*1000)/1000))
+}
+
+func sortedSources(ev *nameEvidence) []string {
+	list := make([]string, 0, len(ev.sources))
+	for src := range ev.sources {
+		list = append(list, src)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// process sends the queued update records on the output channel until the context expires,
+// or the queue is empty once the enumeration is done.
+func (cs *confidenceScorer) process(ctx context.Context, out chan *requests.Output) {
+	send := func() {
+		element, ok := cs.updates.Next()
+		if !ok {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case out <- element.(*requests.Output):
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cs.done:
+			for cs.updates.Len() > 0 && ctx.Err() == nil {
+				send()
+			}
+			return
+		case <-cs.updates.Signal():
+			send()
+		}
+	}
+}
+
+// Confidence returns the confidence in the name and the evidence it was computed from.
+func (e *Enumeration) Confidence(name string) (NameConfidence, bool) {
+	cs := e.confidence
+	if cs == nil {
+		return NameConfidence{}, false
+	}
+
+	name = strings.ToLower(name)
+	cs.Lock()
+	defer cs.Unlock()
+
+	ev, found := cs.names[name]
+	if !found {
+		return NameConfidence{}, false
+	}
+	return cs.nameConfidence(name, ev), true
+}
+
+func (cs *confidenceScorer) nameConfidence(name string, ev *nameEvidence) NameConfidence {
+	nc := NameConfidence{
+		Name:       name,
+		Confidence: cs.score(ev),
+		Sources:    sortedSources(ev),
+		Resolved:   ev.resolved,
+		Wildcard:   ev.wildcard,
+	}
+
+	tags := make(map[string]struct{})
+	for _, tag := range ev.sources {
+		tags[tag] = struct{}{}
+	}
+	for tag := range tags {
+		nc.Tags = append(nc.Tags, tag)
+	}
+	sort.Strings(nc.Tags)
+	return nc
+}
+
+// AllConfidence returns the confidence in each name scored on its way to the output during the enumeration.
+func (e *Enumeration) AllConfidence() []NameConfidence {
+	cs := e.confidence
+	if cs == nil {
+		return nil
+	}
+
+	cs.Lock()
+	list := make([]NameConfidence, 0, len(cs.names))
+	for name, ev := range cs.names {
+		if ev.emitted || ev.held != nil {
+			list = append(list, cs.nameConfidence(name, ev))
+		}
+	}
+	cs.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// BelowConfidence returns true when the name was scored below the minimum confidence set for the output.
+// The names that were never scored, such as those found by earlier enumerations, are not filtered.
+func (e *Enumeration) BelowConfidence(name string) bool {
+	cs := e.confidence
+	if cs == nil || cs.min <= 0 {
+		return false
+	}
+
+	c, found := e.Confidence(name)
+	return found && c.Confidence < cs.min
+}
+
+// report logs the names held back for their confidence, and the scores raised after the names were sent.
+func (cs *confidenceScorer) report(l *log.Logger) {
+	if cs == nil || l == nil {
+		return
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.withheld > 0 {
+		l.Printf("%d names scored below the minimum confidence of %.2f and were left out of the output", cs.withheld, cs.min)
+	}
+	if cs.corrected > 0 {
+		l.Printf("%d update records carried the confidence raised by the sources corroborating names already sent", cs.corrected)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func sourceRequest(name, src string) *requests.DNSRequest {
+	return &requests.DNSRequest{
+		Name:       name,
+		Domain:     "owasp.org",
+		Parent:     src,
+		Derivation: requests.DerivedFromSource,
+	}
+}
+
+func TestConfidenceConfig(t *testing.T) {
+	cs := confidenceFromConfig(config.NewConfig())
+	if cs.min != 0 || cs.source != DefaultConfidenceSource || cs.tags["scrape"] != 0.5 {
+		t.Errorf("the defaults were not set: %+v", cs)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options["confidence"] = map[string]interface{}{
+		"source":   0.25,
+		"resolved": 1,
+		"min":      0.5,
+		"tags":     map[string]interface{}{"Scrape": 0.1, "cert": 2},
+	}
+	cs = confidenceFromConfig(cfg)
+	if cs.source != 0.25 || cs.resolved != 1 || cs.min != 0.5 || cs.tags["scrape"] != 0.1 ||
+		cs.tags["cert"] != 2 || cs.sources != DefaultConfidenceSources {
+		t.Errorf("the options were not parsed: %+v", cs)
+	}
+}
+
+func TestConfidenceScore(t *testing.T) {
+	cs := confidenceFromConfig(config.NewConfig())
+	cs.srcTags["Wayback"] = "archive"
+	cs.srcTags["Crtsh"] = "cert"
+	cs.srcTags["Shodan"] = "api"
+
+	// A name found by a single archive that did not resolve
+	cs.corroborate(sourceRequest("old.owasp.org", "Wayback"))
+	old := &requests.Output{Name: "old.owasp.org", Domain: "owasp.org"}
+	cs.emit(old, false)
+	// A name resolved and confirmed by two independent sources
+	cs.corroborate(sourceRequest("www.owasp.org", "Crtsh"))
+	cs.corroborate(sourceRequest("www.owasp.org", "Shodan"))
+	www := &requests.Output{Name: "www.owasp.org", Domain: "owasp.org"}
+	cs.emit(www, true)
+
+	if old.Confidence != 0.1 || www.Confidence != 0.8 || len(www.Sources) != 2 {
+		t.Errorf("the names scored %.2f and %.2f %v", old.Confidence, www.Confidence, www.Sources)
+	}
+
+	// The same source providing the name again adds nothing, and the wildcard removes its penalty
+	cs.corroborate(sourceRequest("app.owasp.org", "Shodan"))
+	cs.corroborate(sourceRequest("app.owasp.org", "Shodan"))
+	cs.markWildcard("app.owasp.org")
+	app := &requests.Output{Name: "app.owasp.org", Domain: "owasp.org"}
+	cs.emit(app, true)
+	if app.Confidence != 0.3 {
+		t.Errorf("the name under the wildcard scored %.2f", app.Confidence)
+	}
+}
+
+func TestConfidenceUpdates(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["confidence"] = map[string]interface{}{"min": 0.5}
+	cs := confidenceFromConfig(cfg)
+	e := &Enumeration{Config: cfg, confidence: cs}
+
+	cs.corroborate(sourceRequest("www.owasp.org", "Shodan"))
+	if !cs.emit(&requests.Output{Name: "www.owasp.org", Domain: "owasp.org"}, true) {
+		t.Fatal("the name above the minimum confidence was held back")
+	}
+	cs.corroborate(sourceRequest("dev.owasp.org", "Shodan"))
+	if cs.emit(&requests.Output{Name: "dev.owasp.org", Domain: "owasp.org"}, false) {
+		t.Fatal("the name below the minimum confidence was sent")
+	}
+	if !e.BelowConfidence("dev.owasp.org") || e.BelowConfidence("www.owasp.org") || e.BelowConfidence("unknown.owasp.org") {
+		t.Errorf("the minimum confidence filtered the wrong names")
+	}
+
+	// The late sources raise the scores, and the name held back is released once it reaches the minimum
+	cs.corroborate(sourceRequest("www.owasp.org", "Censys"))
+	cs.corroborate(sourceRequest("dev.owasp.org", "Censys"))
+	if cs.updates.Len() != 1 {
+		t.Fatalf("%d records were queued before the held name reached the minimum", cs.updates.Len())
+	}
+	cs.corroborate(sourceRequest("dev.owasp.org", "URLScan"))
+
+	out := make(chan *requests.Output, 10)
+	close(cs.done)
+	cs.process(context.Background(), out)
+	close(out)
+
+	var records []*requests.Output
+	for o := range out {
+		records = append(records, o)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records were sent", len(records))
+	}
+	if r := records[0]; r.Name != "www.owasp.org" || !r.Update || r.Confidence != 0.8 {
+		t.Errorf("the update record is %+v", r)
+	}
+	if r := records[1]; r.Name != "dev.owasp.org" || r.Update || r.Confidence != 0.6 || len(r.Sources) != 3 {
+		t.Errorf("the released record is %+v", r)
+	}
+
+	if scores := e.AllConfidence(); len(scores) != 2 || scores[0].Name != "dev.owasp.org" || scores[0].Tags[0] != "api" {
+		t.Errorf("the scores are %+v", scores)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/journal"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/config/config"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/resolve"
+)
+
+// DefaultNSProviders maps the nameserver name suffixes to the DNS providers operating them.
+var DefaultNSProviders = map[string]string{
+	"ns.cloudflare.com":     "Cloudflare",
+	"azure-dns.com":         "Microsoft Azure DNS",
+	"azure-dns.net":         "Microsoft Azure DNS",
+	"azure-dns.org":         "Microsoft Azure DNS",
+	"azure-dns.info":        "Microsoft Azure DNS",
+	"googledomains.com":     "Google Cloud DNS",
+	"domaincontrol.com":     "GoDaddy",
+	"registrar-servers.com": "Namecheap",
+	"dnsimple.com":          "DNSimple",
+	"dnsmadeeasy.com":       "DNS Made Easy",
+	"nsone.net":             "NS1",
+	"ultradns.com":          "UltraDNS",
+	"ultradns.net":          "UltraDNS",
+	"ultradns.org":          "UltraDNS",
+	"ultradns.biz":          "UltraDNS",
+	"akam.net":              "Akamai",
+	"dynect.net":            "Oracle Dyn",
+	"digitalocean.com":      "DigitalOcean",
+	"linode.com":            "Linode",
+	"hetzner.com":           "Hetzner",
+	"dns.he.net":            "Hurricane Electric",
+	"gandi.net":             "Gandi",
+	"ovh.net":               "OVH",
+	"name.com":              "Name.com",
+	"wixdns.net":            "Wix",
+	"vercel-dns.com":        "Vercel",
+}
+
+func init() {
+	// Route 53 spreads the delegations across numbered domains in several TLDs
+	for i := 0; i < 64; i++ {
+		for _, tld := range []string{"com", "net", "org", "co.uk"} {
+			DefaultNSProviders[fmt.Sprintf("awsdns-%02d.%s", i, tld)] = "Amazon Route 53"
+		}
+	}
+}
+
+// Delegation is a zone cut found under a target domain.
+type Delegation struct {
+	Parent      string   `json:"parent"`
+	Child       string   `json:"child"`
+	Nameservers []string `json:"nameservers"`
+	Provider    string   `json:"provider,omitempty"`
+	CAA         bool     `json:"caa"`
+	DS          bool     `json:"ds"`
+	// Unresolved lists the nameservers without addresses
+	Unresolved []string `json:"unresolved,omitempty"`
+	// ServFail is true when the child zone could not be resolved through its nameservers
+	ServFail bool `json:"servfail"`
+	// Takeover is true when the delegation is likely to be claimable by a third party
+	Takeover bool `json:"takeover"`
+}
+
+type delegationQueryFunc func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+
+// delegationAuditor discovers the zone cuts under the target domains and audits the delegations.
+type delegationAuditor struct {
+	sync.Mutex
+	graph       *netmap.Graph
+	stored      *storedTypes
+	journal     *journal.Journal
+	providers   map[string]string
+	query       delegationQueryFunc
+	delegations map[string]*Delegation
+}
+
+func newDelegationAuditor(e *Enumeration) *delegationAuditor {
+	return &delegationAuditor{
+		graph:       e.graph,
+		stored:      e.stored,
+		providers:   nsProvidersFromConfig(e.Config),
+		query:       e.trustedQuery,
+		delegations: make(map[string]*Delegation),
+	}
+}
+
+// nsProvidersFromConfig returns the built-in provider table extended by the 'dns.ns_providers' configuration option.
+func nsProvidersFromConfig(cfg *config.Config) map[string]string {
+	providers := make(map[string]string, len(DefaultNSProviders))
+	for suffix, name := range DefaultNSProviders {
+		providers[suffix] = name
+	}
+
+	var opts map[string]interface{}
+	if cfg != nil && cfg.Options != nil {
+		opts, _ = cfg.Options["dns"].(map[string]interface{})
+	}
+	if m, ok := opts["ns_providers"].(map[string]interface{}); ok {
+		for suffix, v := range m {
+			if name, ok := v.(string); ok && name != "" {
+				providers[strings.Trim(strings.ToLower(suffix), ".")] = name
+			}
+		}
+	}
+	return providers
+}
+
+// auditDomains walks the names stored in the graph for each domain and audits the zone cuts found.
+func (da *delegationAuditor) auditDomains(ctx context.Context, domains []string, since time.Time) {
+	for _, d := range domains {
+		for _, name := range da.candidates(d, since) {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			if del := da.audit(ctx, d, name); del != nil {
+				da.Lock()
+				da.delegations[del.Child] = del
+				da.Unlock()
+			}
+		}
+	}
+}
+
+// candidates returns the domain and each label boundary below it, in order of depth, for the stored names.
+func (da *delegationAuditor) candidates(d string, since time.Time) []string {
+	d = strings.ToLower(d)
+	set := map[string]struct{}{d: {}}
+
+	if !since.IsZero() {
+		since = since.UTC()
+	}
+	if assets, err := da.graph.DB.FindByScope([]oam.Asset{domain.FQDN{Name: d}}, since); err == nil {
+		for _, a := range assets {
+			fqdn, ok := a.Asset.(domain.FQDN)
+			if !ok {
+				continue
+			}
+
+			name := strings.ToLower(fqdn.Name)
+			for name != d && strings.HasSuffix(name, "."+d) {
+				set[name] = struct{}{}
+				name = name[strings.Index(name, ".")+1:]
+			}
+		}
+	}
+
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ci, cj := strings.Count(names[i], "."), strings.Count(names[j], "."); ci != cj {
+			return ci < cj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// audit returns the delegation when the name is a zone cut, which is where it owns a set of NS records.
+func (da *delegationAuditor) audit(ctx context.Context, d, name string) *Delegation {
+	// The names within a broken zone fail the same way as the zone cut
+	if da.withinFailedZone(name) {
+		return nil
+	}
+
+	resp, err := da.query(ctx, name, dns.TypeNS)
+	if err != nil || resp == nil {
+		return nil
+	}
+
+	var nameservers []string
+	servfail := resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused
+	if resp.Rcode == dns.RcodeSuccess {
+		for _, a := range resolve.AnswersByType(extractAnswers(resp), dns.TypeNS) {
+			if strings.EqualFold(a.Name, name) {
+				nameservers = append(nameservers, strings.ToLower(a.Data))
+			}
+		}
+	} else if servfail {
+		// A lame delegation cannot be resolved, so the nameservers learned earlier are used
+		nameservers = da.storedNameservers(name)
+	}
+	if len(nameservers) == 0 {
+		return nil
+	}
+	sort.Strings(nameservers)
+
+	del := &Delegation{
+		Parent:      da.parentZone(d, name),
+		Child:       name,
+		Nameservers: nameservers,
+		Provider:    da.provider(nameservers),
+		CAA:         da.hasRecord(ctx, name, dns.TypeCAA),
+		DS:          da.hasRecord(ctx, name, dns.TypeDS),
+	}
+
+	for _, ns := range nameservers {
+		if da.stored.store(ctx, da.graph, name, dns.TypeNS) {
+			if err := da.graph.UpsertNS(ctx, name, ns); err != nil {
+				_ = da.journal.Append(journal.Record{Op: journal.OpNS, Name: name, Target: ns})
+				continue
+			}
+		}
+		if len(da.answers(ctx, ns, dns.TypeA)) == 0 && len(da.answers(ctx, ns, dns.TypeAAAA)) == 0 {
+			del.Unresolved = append(del.Unresolved, ns)
+		}
+	}
+	del.ServFail = servfail
+	if !servfail {
+		if resp, err := da.query(ctx, name, dns.TypeSOA); err == nil && resp != nil {
+			del.ServFail = resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused
+		}
+	}
+	del.Takeover = len(del.Unresolved) > 0 || del.ServFail
+	return del
+}
+
+func (da *delegationAuditor) withinFailedZone(name string) bool {
+	da.Lock()
+	defer da.Unlock()
+
+	for child, del := range da.delegations {
+		if del.ServFail && strings.HasSuffix(name, "."+child) {
+			return true
+		}
+	}
+	return false
+}
+
+func (da *delegationAuditor) storedNameservers(name string) []string {
+	assets, err := da.graph.DB.FindByContent(&domain.FQDN{Name: name}, time.Time{})
+	if err != nil || len(assets) == 0 {
+		return nil
+	}
+
+	rels, err := da.graph.DB.OutgoingRelations(assets[0], time.Time{}, "ns_record")
+	if err != nil {
+		return nil
+	}
+
+	var nameservers []string
+	for _, rel := range rels {
+		if to, err := da.graph.DB.FindById(rel.ToAsset.ID, time.Time{}); err == nil {
+			if fqdn, ok := to.Asset.(domain.FQDN); ok {
+				nameservers = append(nameservers, strings.ToLower(fqdn.Name))
+			}
+		}
+	}
+	return nameservers
+}
+
+// hasRecord returns true when the name owns a record of the type, including those the resolve package does not extract.
+func (da *delegationAuditor) hasRecord(ctx context.Context, name string, qtype uint16) bool {
+	resp, err := da.query(ctx, name, qtype)
+	if err != nil || resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return false
+	}
+
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == qtype && strings.EqualFold(resolve.RemoveLastDot(rr.Header().Name), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (da *delegationAuditor) answers(ctx context.Context, name string, qtype uint16) []*resolve.ExtractedAnswer {
+	resp, err := da.query(ctx, name, qtype)
+	if err != nil || resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return nil
+	}
+	return resolve.AnswersByType(extractAnswers(resp), qtype)
+}
+
+// parentZone returns the closest enclosing zone cut that has already been found.
+func (da *delegationAuditor) parentZone(d, name string) string {
+	da.Lock()
+	defer da.Unlock()
+
+	for parent := name; strings.Contains(parent, "."); {
+		parent = parent[strings.Index(parent, ".")+1:]
+		if _, found := da.delegations[parent]; found || parent == d {
+			return parent
+		}
+		if !strings.HasSuffix(parent, "."+d) {
+			return parent
+		}
+	}
+	return ""
+}
+
+// provider returns the DNS provider of the nameservers, using the longest matching suffix of the table.
+func (da *delegationAuditor) provider(nameservers []string) string {
+	var match, provider string
+
+	for _, ns := range nameservers {
+		for suffix, name := range da.providers {
+			if (ns == suffix || strings.HasSuffix(ns, "."+suffix)) && len(suffix) > len(match) {
+				match = suffix
+				provider = name
+			}
+		}
+	}
+	return provider
+}
+
+func (da *delegationAuditor) list() []*Delegation {
+	da.Lock()
+	defer da.Unlock()
+
+	var dels []*Delegation
+	for _, del := range da.delegations {
+		dels = append(dels, del)
+	}
+	sort.Slice(dels, func(i, j int) bool { return dels[i].Child < dels[j].Child })
+	return dels
+}
+
+func (e *Enumeration) trustedQuery(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	if e.Policy.BlocksName(policy.DNS, name) {
+		return nil, policy.ErrBlocked
+	}
+	if !e.spendQuery() {
+		return nil, errors.New("the DNS query budget has been exhausted")
+	}
+	return e.trustedPool().QueryBlocking(ctx, resolve.QueryMsg(name, qtype))
+}
+
+// Delegations returns the zone cuts found under the target domains, sorted by the child zone.
+func (e *Enumeration) Delegations() []*Delegation {
+	return e.dels.list()
+}
@@ -0,0 +1,128 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+)
+
+type fakeZoneDNS struct {
+	records  map[string][]string
+	servfail map[string]bool
+}
+
+func (f *fakeZoneDNS) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+
+	if f.servfail[name] {
+		msg.Rcode = dns.RcodeServerFailure
+		return msg, nil
+	}
+	for _, rec := range f.records[name+"|"+dns.TypeToString[qtype]] {
+		if rr, err := dns.NewRR(dns.Fqdn(name) + " 300 IN " + dns.TypeToString[qtype] + " " + rec); err == nil {
+			msg.Answer = append(msg.Answer, rr)
+		}
+	}
+	return msg, nil
+}
+
+func TestAuditDelegations(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	for _, name := range []string{"www.owasp.org", "a.dev.owasp.org", "shop.owasp.org", "x.broken.owasp.org"} {
+		if _, err := g.UpsertFQDN(ctx, name); err != nil {
+			t.Fatalf("Failed to insert %s: %v", name, err)
+		}
+	}
+	if err := g.UpsertNS(ctx, "broken.owasp.org", "ns1.expired-dns.net"); err != nil {
+		t.Fatalf("Failed to insert the NS record: %v", err)
+	}
+
+	fake := &fakeZoneDNS{
+		records: map[string][]string{
+			"owasp.org|NS":          {"ns1.owasp.org.", "ns2.owasp.org."},
+			"owasp.org|CAA":         {`0 issue "letsencrypt.org"`},
+			"owasp.org|DS":          {"12345 13 2 3C5D0D0F4F8B2F7E493D5B4A9E4ED53A3C0E8A3D1F6C1B1A2F3E4D5C6B7A8990"},
+			"ns1.owasp.org|A":       {"192.0.2.1"},
+			"ns2.owasp.org|AAAA":    {"2001:db8::2"},
+			"dev.owasp.org|NS":      {"ns-12.awsdns-01.com.", "ns-900.awsdns-40.net."},
+			"ns-12.awsdns-01.com|A": {"192.0.2.10"},
+			"shop.owasp.org|NS":     {"ns.dangling-provider.example."},
+			"www.owasp.org|CNAME":   {"owasp.github.io."},
+		},
+		servfail: map[string]bool{"broken.owasp.org": true, "x.broken.owasp.org": true},
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{
+		"dns": map[string]interface{}{
+			"ns_providers": map[string]interface{}{"dangling-provider.example": "Dangling DNS"},
+		},
+	}
+	da := &delegationAuditor{
+		graph:       g,
+		providers:   nsProvidersFromConfig(cfg),
+		query:       fake.query,
+		delegations: make(map[string]*Delegation),
+	}
+	da.auditDomains(ctx, []string{"owasp.org"}, time.Time{})
+
+	dels := da.list()
+	var children []string
+	for _, del := range dels {
+		children = append(children, del.Child)
+	}
+	if expected := []string{"broken.owasp.org", "dev.owasp.org", "owasp.org", "shop.owasp.org"}; !reflect.DeepEqual(children, expected) {
+		t.Fatalf("Found the zone cuts %v, expected %v", children, expected)
+	}
+
+	apex, broken, dev, shop := dels[2], dels[0], dels[1], dels[3]
+	if apex.Parent != "org" || !apex.CAA || !apex.DS || apex.Takeover || apex.Provider != "" {
+		t.Errorf("The apex delegation was %+v", apex)
+	}
+	if dev.Parent != "owasp.org" || dev.Provider != "Amazon Route 53" || dev.CAA || dev.DS {
+		t.Errorf("The dev delegation was %+v", dev)
+	}
+	if !reflect.DeepEqual(dev.Unresolved, []string{"ns-900.awsdns-40.net"}) || !dev.Takeover {
+		t.Errorf("The unresolved nameservers of the dev delegation were %v", dev.Unresolved)
+	}
+	if shop.Provider != "Dangling DNS" || !shop.Takeover {
+		t.Errorf("The shop delegation was %+v", shop)
+	}
+	if !broken.ServFail || !broken.Takeover || !reflect.DeepEqual(broken.Nameservers, []string{"ns1.expired-dns.net"}) {
+		t.Errorf("The broken delegation was %+v", broken)
+	}
+}
+
+func TestNSProvider(t *testing.T) {
+	da := &delegationAuditor{providers: nsProvidersFromConfig(nil)}
+
+	tests := []struct {
+		nameservers []string
+		expected    string
+	}{
+		{[]string{"kate.ns.cloudflare.com"}, "Cloudflare"},
+		{[]string{"ns-1.awsdns-63.co.uk"}, "Amazon Route 53"},
+		{[]string{"ns1-01.azure-dns.com"}, "Microsoft Azure DNS"},
+		{[]string{"ns1.owasp.org"}, ""},
+		{[]string{"notcloudflare.com"}, ""},
+	}
+
+	for _, test := range tests {
+		if got := da.provider(test.nameservers); got != test.expected {
+			t.Errorf("The provider of %v was %q, expected %q", test.nameservers, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/journal"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/config/config"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// DeltaFile is the name of the file under the output directory listing the findings carried forward by a delta enumeration.
+const DeltaFile = "carried_forward.json"
+
+// DeltaLatest selects the latest earlier event that enumerated the domains as the baseline.
+const DeltaLatest = "latest"
+
+// DefaultDeltaFreshness is how long the data sources queried by the baseline event are not queried again.
+const DefaultDeltaFreshness = 24 * time.Hour
+
+// DefaultDeltaRecent is how long after they were first found the names are still considered recently changed.
+const DefaultDeltaRecent = 7 * 24 * time.Hour
+
+// deltaAlwaysQueried holds the types of the data sources without a freshness window unless one is configured.
+// The certificate transparency logs provide the fresh names, and the brute forcing and alterations query no source.
+var deltaAlwaysQueried = map[string]struct{}{
+	"alt":   {},
+	"brute": {},
+	"cert":  {},
+}
+
+// DeltaReport describes what a delta enumeration took from its baseline event.
+type DeltaReport struct {
+	// Event is the identifier of the snapshot of the delta enumeration
+	Event string `json:"event,omitempty"`
+	// Baseline is the identifier of the snapshot of the event the enumeration started from
+	Baseline      string    `json:"baseline"`
+	BaselineStart time.Time `json:"baseline_start"`
+	// Known is the number of names found by the baseline event, which were not brute forced or altered again
+	Known int `json:"known"`
+	// Recent holds the names of the baseline event first found within the recent window, which were enumerated again
+	Recent []string `json:"recent,omitempty"`
+	// Skipped holds the data sources not queried again for each root domain name, as they were still fresh
+	Skipped map[string][]string `json:"skipped_sources,omitempty"`
+	// CarriedForward holds the names of the baseline event copied into the delta enumeration unchanged
+	CarriedForward []string `json:"carried_forward"`
+}
+
+// deltaMode enumerates only the names that are new since a previous event, while the event still records
+// the full picture by carrying the unchanged findings of the previous event forward.
+type deltaMode struct {
+	sync.Mutex
+	selector  string
+	freshness time.Duration
+	sources   map[string]time.Duration
+	recentWin time.Duration
+	baseline  *snapshot.Snapshot
+	queried   map[string]struct{}
+	// current holds the names in scope seen since the baseline event started, and recent those first
+	// found within the recent window
+	current map[string]struct{}
+	recent  map[string]struct{}
+	seeded  map[string]struct{}
+	skipped map[string]map[string]struct{}
+	carried map[string]struct{}
+}
+
+// deltaFromConfig parses the 'delta' configuration options. The mode is only enabled when the section is
+// present, so the enumeration is otherwise left unchanged.
+func deltaFromConfig(cfg *config.Config) *deltaMode {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["delta"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, ok := opts["enabled"].(bool); ok && !enabled {
+		return nil
+	}
+
+	d := &deltaMode{
+		selector:  DeltaLatest,
+		freshness: DefaultDeltaFreshness,
+		sources:   make(map[string]time.Duration),
+		recentWin: DefaultDeltaRecent,
+		recent:    make(map[string]struct{}),
+		current:   make(map[string]struct{}),
+		seeded:    make(map[string]struct{}),
+		skipped:   make(map[string]map[string]struct{}),
+		carried:   make(map[string]struct{}),
+	}
+	if s, ok := opts["baseline"].(string); ok && strings.TrimSpace(s) != "" {
+		d.selector = strings.TrimSpace(s)
+	}
+	if h, ok := floatOption(opts["freshness"]); ok && h >= 0 {
+		d.freshness = time.Duration(h * float64(time.Hour))
+	}
+	if h, ok := floatOption(opts["recent"]); ok && h >= 0 {
+		d.recentWin = time.Duration(h * float64(time.Hour))
+	}
+	if srcs, ok := opts["sources"].(map[string]interface{}); ok {
+		for name, v := range srcs {
+			if h, ok := floatOption(v); ok && h >= 0 {
+				d.sources[strings.ToLower(name)] = time.Duration(h * float64(time.Hour))
+			}
+		}
+	}
+	return d
+}
+
+// baselineSnapshot returns the snapshot selected by its identifier, or the latest one that enumerated
+// any of the domains when the selector is DeltaLatest. The snapshot of the current event is skipped.
+func baselineSnapshot(store *snapshot.Store, selector string, domains []string, current string) (*snapshot.Snapshot, error) {
+	if selector != DeltaLatest {
+		return store.Load(selector)
+	}
+
+	snaps, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(snaps) - 1; i >= 0; i-- {
+		snap := snaps[i]
+		if snap.ID == current {
+			continue
+		}
+		for _, d := range snap.Domains {
+			for _, domain := range domains {
+				if strings.EqualFold(d, domain) {
+					return snap, nil
+				}
+			}
+		}
+	}
+	return nil, snapshot.ErrNotFound
+}
+
+// setBaseline selects the event the enumeration starts from, and the data sources it queried.
+func (d *deltaMode) setBaseline(snap *snapshot.Snapshot) {
+	d.baseline = snap
+	d.queried = make(map[string]struct{})
+
+	for _, name := range stringList(snap.Settings["sources"]) {
+		d.queried[strings.ToLower(name)] = struct{}{}
+	}
+}
+
+// observe classifies a name in scope held by the graph. The names seen since the baseline event started
+// belong to its picture, and the names first found within the recent window are enumerated again.
+func (d *deltaMode) observe(name string, a *types.Asset, now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	if !a.LastSeen.After(d.baseline.Start) {
+		return
+	}
+
+	d.current[name] = struct{}{}
+	if d.recentWin > 0 && a.CreatedAt.After(now.Add(-d.recentWin)) {
+		d.recent[name] = struct{}{}
+	}
+}
+
+// loadNames classifies the names in scope held by the graph.
+func (d *deltaMode) loadNames(g *netmap.Graph, domains []string, now time.Time) {
+	for _, dom := range domains {
+		assets, err := g.DB.FindByScope([]oam.Asset{domain.FQDN{Name: dom}}, time.Time{})
+		if err != nil {
+			continue
+		}
+
+		for _, a := range assets {
+			if fqdn, ok := a.Asset.(domain.FQDN); ok {
+				d.observe(strings.ToLower(fqdn.Name), a, now)
+			}
+		}
+	}
+}
+
+// seed adds the names found by the baseline event to the filter of the submitted names, so they are not
+// brute forced or altered again. The root domain names and the recently changed names are left out.
+func (d *deltaMode) seed(cfg *config.Config, accept func(string) bool) int {
+	d.Lock()
+	defer d.Unlock()
+
+	roots := make(map[string]struct{})
+	for _, dom := range cfg.Domains() {
+		roots[strings.ToLower(dom)] = struct{}{}
+	}
+
+	for name := range d.current {
+		if _, found := roots[name]; found {
+			continue
+		}
+		if _, found := d.recent[name]; found {
+			continue
+		}
+		d.seeded[name] = struct{}{}
+		accept(name)
+	}
+	return len(d.seeded)
+}
+
+// isSeeded returns true when the name was added to the filter of the submitted names.
+func (d *deltaMode) isSeeded(name string) bool {
+	if d == nil {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	_, found := d.seeded[strings.ToLower(name)]
+	return found
+}
+
+// fresh returns true when the data source queried by the baseline event for the root domain name is
+// still within its freshness window, in which case it is not queried again.
+func (d *deltaMode) fresh(domain, src, srcType string, now time.Time) bool {
+	if d == nil || d.baseline == nil {
+		return false
+	}
+
+	name := strings.ToLower(src)
+	window, found := d.sources[name]
+	if !found {
+		if _, always := deltaAlwaysQueried[strings.ToLower(srcType)]; always {
+			return false
+		}
+		window = d.freshness
+	}
+	if _, queried := d.queried[name]; !queried || window <= 0 || now.Sub(d.baseline.Start) >= window {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	set, found := d.skipped[domain]
+	if !found {
+		set = make(map[string]struct{})
+		d.skipped[domain] = set
+	}
+	set[src] = struct{}{}
+	return true
+}
+
+// report returns what the delta enumeration took from its baseline event.
+func (d *deltaMode) report() *DeltaReport {
+	if d == nil || d.baseline == nil {
+		return nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	r := &DeltaReport{
+		Baseline:       d.baseline.ID,
+		BaselineStart:  d.baseline.Start,
+		Known:          len(d.seeded),
+		Recent:         sortedKeys(d.recent),
+		CarriedForward: sortedKeys(d.carried),
+	}
+	if len(d.skipped) > 0 {
+		r.Skipped = make(map[string][]string, len(d.skipped))
+		for dom, set := range d.skipped {
+			r.Skipped[dom] = sortedKeys(set)
+		}
+	}
+	return r
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	var keys []string
+
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// startDelta loads the baseline event of the delta enumeration. The enumeration is a full one when the
+// baseline event cannot be found.
+func (e *Enumeration) startDelta() {
+	if e.delta == nil {
+		return
+	}
+	if e.Snapshots == nil {
+		e.Config.Log.Printf("Delta mode: the configuration snapshots are not available, so a full enumeration is performed")
+		e.delta = nil
+		return
+	}
+
+	var current string
+	if e.snapshot != nil {
+		current = e.snapshot.ID
+	}
+	snap, err := baselineSnapshot(e.Snapshots, e.delta.selector, e.Config.Domains(), current)
+	if err != nil {
+		if errors.Is(err, snapshot.ErrNotFound) {
+			e.Config.Log.Printf("Delta mode: the baseline event %s was not found, so a full enumeration is performed", e.delta.selector)
+		} else {
+			e.Config.Log.Printf("Delta mode: failed to load the baseline event %s: %v", e.delta.selector, err)
+		}
+		e.delta = nil
+		return
+	}
+
+	e.delta.setBaseline(snap)
+	e.delta.loadNames(e.graph, e.Config.Domains(), e.clock.Now())
+}
+
+// seedDelta adds the names found by the baseline event to the filter of the submitted names.
+func (e *Enumeration) seedDelta() {
+	if e.delta == nil {
+		return
+	}
+
+	accept := e.nameSrc.accept
+	if ws := e.working; ws != nil {
+		accept = func(name string) bool {
+			if d := e.Config.WhichDomain(name); d != "" {
+				return ws.submit(name, d, false)
+			}
+			return e.nameSrc.accept(name)
+		}
+	}
+
+	n := e.delta.seed(e.Config, accept)
+	e.Config.Log.Printf("Delta mode: starting from the event %s, with %d known names and %d recently changed names enumerated again",
+		e.delta.baseline.ID, n, len(e.delta.recent))
+}
+
+// carryForward copies the findings of the baseline event that the delta enumeration did not find again
+// into the event, so the event records the full current picture. The recently changed names were
+// enumerated again, so they are only recorded when they were found.
+func (e *Enumeration) carryForward(ctx context.Context) {
+	d := e.delta
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	var names []string
+	for name := range d.current {
+		if _, found := d.recent[name]; !found {
+			names = append(names, name)
+		}
+	}
+	d.Unlock()
+	sort.Strings(names)
+
+	start := e.Config.CollectionStartTime.UTC()
+	since := d.baseline.Start.UTC()
+	var carried []string
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		// The names found again by the enumeration are already part of the event
+		if a, err := e.graph.DB.FindByContent(&domain.FQDN{Name: name}, start); err == nil && len(a) > 0 {
+			continue
+		}
+
+		if err := carryName(e.graph, name, since); err != nil {
+			e.journalWrite(journal.Record{Op: journal.OpFQDN, Name: name}, err)
+			e.Config.Log.Printf("Delta mode: failed to carry %s forward: %v", name, err)
+			continue
+		}
+		carried = append(carried, name)
+	}
+
+	d.Lock()
+	for _, name := range carried {
+		d.carried[name] = struct{}{}
+	}
+	d.Unlock()
+	if len(carried) > 0 {
+		e.Config.Log.Printf("Delta mode: carried %d unchanged names forward from the event %s", len(carried), d.baseline.ID)
+	}
+}
+
+// carryName refreshes the name in the graph along with the relations it had since the baseline event started.
+func carryName(g *netmap.Graph, name string, since time.Time) error {
+	from, err := g.DB.Create(nil, "", &domain.FQDN{Name: name})
+	if err != nil {
+		return err
+	}
+
+	rels, err := g.DB.OutgoingRelations(from, since)
+	if err != nil {
+		return err
+	}
+	for _, r := range rels {
+		// The custom edges belong to the programs that registered them, which refresh them
+		if r.ToAsset == nil || custom.IsCustom(r.Type) {
+			continue
+		}
+
+		to, err := g.DB.FindById(r.ToAsset.ID, time.Time{})
+		if err != nil {
+			return err
+		}
+		if _, err := g.DB.Create(from, r.Type, to.Asset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CarriedForward returns true when the name was copied from the baseline event of the delta enumeration unchanged.
+func (e *Enumeration) CarriedForward(name string) bool {
+	d := e.delta
+	if d == nil {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	_, found := d.carried[strings.ToLower(name)]
+	return found
+}
+
+// Delta returns what the delta enumeration took from its baseline event, or nil when it was a full enumeration.
+func (e *Enumeration) Delta() *DeltaReport {
+	r := e.delta.report()
+	if r != nil && e.snapshot != nil {
+		r.Event = e.snapshot.ID
+	}
+	return r
+}
@@ -0,0 +1,231 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestDeltaFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if d := deltaFromConfig(cfg); d != nil {
+		t.Errorf("the delta mode was enabled without the section")
+	}
+
+	cfg.Options = map[string]interface{}{"delta": map[string]interface{}{}}
+	d := deltaFromConfig(cfg)
+	if d == nil || d.selector != DeltaLatest || d.freshness != DefaultDeltaFreshness || d.recentWin != DefaultDeltaRecent {
+		t.Errorf("the default settings were %+v", d)
+	}
+
+	cfg.Options = map[string]interface{}{"delta": map[string]interface{}{
+		"baseline":  "1700000000000000000-0a1b2c3d",
+		"freshness": 12,
+		"recent":    48.0,
+		"sources":   map[string]interface{}{"AlienVault": 6},
+	}}
+	d = deltaFromConfig(cfg)
+	if d == nil || d.selector != "1700000000000000000-0a1b2c3d" || d.freshness != 12*time.Hour ||
+		d.recentWin != 48*time.Hour || d.sources["alienvault"] != 6*time.Hour {
+		t.Errorf("the settings were %+v", d)
+	}
+
+	cfg.Options = map[string]interface{}{"delta": map[string]interface{}{"enabled": false}}
+	if d := deltaFromConfig(cfg); d != nil {
+		t.Errorf("the disabled delta mode returned the settings %+v", d)
+	}
+}
+
+func TestDeltaFreshness(t *testing.T) {
+	now := time.Now()
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"delta": map[string]interface{}{
+		"sources": map[string]interface{}{"Crtsh": 6, "Shodan": 1},
+	}}
+
+	d := deltaFromConfig(cfg)
+	d.setBaseline(&snapshot.Snapshot{
+		ID:       "baseline",
+		Start:    now.Add(-2 * time.Hour),
+		Settings: map[string]interface{}{"sources": []interface{}{"AlienVault", "Crtsh", "CertSpotter", "Shodan"}},
+	})
+
+	tests := []struct {
+		src      string
+		srcType  string
+		expected bool
+	}{
+		{"AlienVault", "api", true},
+		// The certificate transparency logs are queried for the fresh names
+		{"CertSpotter", "cert", false},
+		{"Crtsh", "cert", true},
+		{"Shodan", "api", false},
+		// The data sources the baseline event did not query have no results to reuse
+		{"URLScan", "api", false},
+		{"Brute Forcing", "brute", false},
+	}
+	for _, test := range tests {
+		if fresh := d.fresh("owasp.org", test.src, test.srcType, now); fresh != test.expected {
+			t.Errorf("%s was fresh: %t, expected %t", test.src, fresh, test.expected)
+		}
+	}
+
+	want := map[string][]string{"owasp.org": {"AlienVault", "Crtsh"}}
+	if r := d.report(); !reflect.DeepEqual(r.Skipped, want) {
+		t.Errorf("the skipped data sources were %v, expected %v", r.Skipped, want)
+	}
+	if d.fresh("owasp.org", "AlienVault", "api", now.Add(23*time.Hour)) {
+		t.Errorf("the data source was still fresh after its window")
+	}
+
+	// Only the requests for the root domain names are skipped
+	e := &Enumeration{delta: d, clock: clock.NewFake(now)}
+	api := newFakeSource("AlienVault", "api")
+	if _, ok := e.routeRequest(api, &requests.DNSRequest{Name: "owasp.org", Domain: "owasp.org"}); ok {
+		t.Errorf("the fresh data source was queried for the root domain name")
+	}
+	if _, ok := e.routeRequest(api, &requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org"}); !ok {
+		t.Errorf("the fresh data source was not queried for the name")
+	}
+}
+
+func TestDeltaRecentNames(t *testing.T) {
+	now := time.Now()
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.Options = map[string]interface{}{"delta": map[string]interface{}{"recent": 24}}
+
+	d := deltaFromConfig(cfg)
+	d.setBaseline(&snapshot.Snapshot{ID: "baseline", Start: now.Add(-time.Hour)})
+	for name, a := range map[string]*types.Asset{
+		"owasp.org":      {CreatedAt: now.Add(-30 * 24 * time.Hour), LastSeen: now},
+		"www.owasp.org":  {CreatedAt: now.Add(-30 * 24 * time.Hour), LastSeen: now},
+		"new.owasp.org":  {CreatedAt: now.Add(-2 * time.Hour), LastSeen: now},
+		"gone.owasp.org": {CreatedAt: now.Add(-30 * 24 * time.Hour), LastSeen: now.Add(-48 * time.Hour)},
+	} {
+		d.observe(name, a, now)
+	}
+
+	var seeded []string
+	d.seed(cfg, func(name string) bool {
+		seeded = append(seeded, name)
+		return true
+	})
+	// The root domain name is enumerated again, and the names missing from the baseline are not its findings
+	if want := []string{"www.owasp.org"}; !reflect.DeepEqual(seeded, want) {
+		t.Errorf("the names seeded were %v, expected %v", seeded, want)
+	}
+	if r := d.report(); r.Known != 1 || !reflect.DeepEqual(r.Recent, []string{"new.owasp.org"}) {
+		t.Errorf("the report was %+v", r)
+	}
+	if !d.isSeeded("WWW.owasp.org") || d.isSeeded("new.owasp.org") {
+		t.Errorf("the recently changed names were seeded")
+	}
+}
+
+func TestDeltaCarryForward(t *testing.T) {
+	dir := t.TempDir()
+	g := netmap.NewGraph("local", filepath.Join(dir, "amass.sqlite"), "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	store, err := snapshot.Open(filepath.Join(dir, snapshot.DirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.Options = map[string]interface{}{"delta": map[string]interface{}{"recent": 0}}
+	// The baseline event found the names
+	base := &snapshot.Snapshot{ID: snapshot.ID(cfg.Domains(), time.Now().Add(-time.Hour)), Domains: cfg.Domains(), Start: time.Now().Add(-time.Hour)}
+	if err := store.Save(base); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"www.owasp.org", "api.owasp.org"} {
+		if err := g.UpsertA(ctx, name, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg.CollectionStartTime = time.Now()
+	e := &Enumeration{Config: cfg, graph: g, Snapshots: store, clock: clock.System, delta: deltaFromConfig(cfg)}
+	e.startDelta()
+	if e.delta == nil || e.delta.baseline.ID != base.ID {
+		t.Fatal("the baseline event was not loaded")
+	}
+	e.delta.seed(cfg, func(string) bool { return true })
+	if !e.delta.isSeeded("www.owasp.org") || !e.delta.isSeeded("api.owasp.org") {
+		t.Errorf("the names of the baseline event were not loaded")
+	}
+
+	// The timestamps of the graph are stored with a resolution of a second
+	time.Sleep(1100 * time.Millisecond)
+	if err := g.UpsertA(ctx, "www.owasp.org", "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	e.carryForward(ctx)
+	if !e.CarriedForward("api.owasp.org") || e.CarriedForward("www.owasp.org") {
+		t.Errorf("the names carried forward were %v", e.Delta().CarriedForward)
+	}
+	pairs, err := g.NamesToAddrs(ctx, cfg.CollectionStartTime.UTC(), "api.owasp.org")
+	if err != nil || len(pairs) != 1 {
+		t.Errorf("the event holds the addresses %v, %v", pairs, err)
+	}
+	if a, err := g.DB.FindByContent(&domain.FQDN{Name: "api.owasp.org"}, cfg.CollectionStartTime.UTC()); err != nil || len(a) == 0 {
+		t.Errorf("the name carried forward is not part of the event")
+	}
+}
+
+func TestCarryNameSkipsCustomEdges(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "amass.sqlite")
+	g := netmap.NewGraph("local", dsn, "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	cs, err := custom.Open("local", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cs.Close() }()
+	_ = custom.RegisterNode(custom.NodeType{Name: "test:label"})
+	_ = custom.RegisterPredicate(custom.Predicate{Name: "test:labeled"})
+
+	ctx := context.Background()
+	since := time.Now().Add(-time.Minute)
+	if err := g.UpsertA(ctx, "www.owasp.org", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	www, err := g.DB.FindByContent(&domain.FQDN{Name: "www.owasp.org"}, time.Time{})
+	if err != nil || len(www) != 1 {
+		t.Fatalf("the name was not stored: %v", err)
+	}
+	label, err := cs.AddNode(ctx, "test:label", "prod", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Link(ctx, www[0].ID, "test:labeled", label.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := carryName(g, "www.owasp.org", since); err != nil {
+		t.Errorf("the custom edge stopped the name from being carried forward: %v", err)
+	}
+}
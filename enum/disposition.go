@@ -0,0 +1,204 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// DispositionsFile is the name of the file under the output directory receiving the dispositions.
+const DispositionsFile = "dispositions.jsonl"
+
+// DefaultDispositionLogSize is the number of dispositions kept in memory by default.
+const DefaultDispositionLogSize = 10000
+
+// Disposition is the terminal outcome of a candidate name in the enumeration.
+type Disposition string
+
+// The dispositions of the candidate names.
+const (
+	DispositionResolved Disposition = "resolved"
+	DispositionNXDomain Disposition = "nxdomain"
+	// DispositionNoRecords is a name that exists without records of the queried types
+	DispositionNoRecords Disposition = "no-records"
+	DispositionTimeout   Disposition = "timeout"
+	// DispositionServfail is a name the resolvers kept failing to answer with an error code
+	DispositionServfail Disposition = "servfail"
+	DispositionWildcard Disposition = "wildcard-filtered"
+	DispositionScope    Disposition = "scope-filtered"
+	DispositionInvalid  Disposition = "invalid"
+	DispositionDeduped  Disposition = "deduped"
+	DispositionBudget   Disposition = "budget-exhausted"
+	// DispositionPolicy is a name on the never-touch list, which is blocked regardless of the scope
+	DispositionPolicy Disposition = "policy-blocked"
+	// DispositionFiltered is a name dropped by a custom name filter registered with the System
+	DispositionFiltered Disposition = "custom-filtered"
+	// DispositionTruncated is a brute force candidate skipped once the wordlist of its zone was truncated
+	DispositionTruncated Disposition = "brute-truncated"
+	// DispositionQuarantined is a name outside of the scope set apart for a review by the quarantine rules
+	DispositionQuarantined Disposition = "quarantined"
+	// DispositionAttempted is a generated candidate that failed to resolve during an earlier enumeration
+	DispositionAttempted Disposition = "attempted-before"
+	// DispositionSharded is a generated candidate assigned to another of the processes sharing the work
+	DispositionSharded Disposition = "sharded"
+)
+
+// DispositionRecord describes how the enumeration was done with a candidate name.
+type DispositionRecord struct {
+	Name        string      `json:"name"`
+	Disposition Disposition `json:"disposition"`
+	Reason      string      `json:"reason,omitempty"`
+	Time        time.Time   `json:"time"`
+}
+
+// dispositionLog keeps the latest dispositions in a ring buffer, so the memory used is bounded
+// by the size of the buffer. A name that was already disposed of is not replaced by a duplicate.
+type dispositionLog struct {
+	sync.Mutex
+	ring  []DispositionRecord
+	next  int
+	index map[string]int
+	enc   *json.Encoder
+}
+
+// dispositionLogFromConfig parses the 'dispositions' configuration options, and returns nil
+// when the size of the ring buffer is set to zero.
+func dispositionLogFromConfig(cfg *config.Config) *dispositionLog {
+	size := DefaultDispositionLogSize
+
+	if v, found := dispositionOptions(cfg)["size"]; found {
+		size = options.Int(v)
+	}
+	if size <= 0 {
+		return nil
+	}
+	return newDispositionLog(size)
+}
+
+// DispositionsToFile returns true when the 'file' option of the 'dispositions' section requests
+// the dispositions to be written to the DispositionsFile under the output directory.
+func DispositionsToFile(cfg *config.Config) bool {
+	enabled, _ := dispositionOptions(cfg)["file"].(bool)
+	return enabled
+}
+
+func dispositionOptions(cfg *config.Config) map[string]interface{} {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, _ := cfg.Options["dispositions"].(map[string]interface{})
+	return opts
+}
+
+func newDispositionLog(size int) *dispositionLog {
+	return &dispositionLog{
+		ring:  make([]DispositionRecord, 0, size),
+		index: make(map[string]int, size),
+	}
+}
+
+// setOutput sends each disposition to the writer as a line of JSON, including those evicted from the buffer.
+func (dl *dispositionLog) setOutput(w io.Writer) {
+	if dl == nil {
+		return
+	}
+
+	dl.Lock()
+	defer dl.Unlock()
+
+	dl.enc = nil
+	if w != nil {
+		dl.enc = json.NewEncoder(w)
+	}
+}
+
+// add records the disposition of the name, evicting the oldest record once the buffer is full.
+func (dl *dispositionLog) add(name string, d Disposition, reason string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if dl == nil || name == "" {
+		return
+	}
+
+	rec := DispositionRecord{
+		Name:        name,
+		Disposition: d,
+		Reason:      reason,
+		Time:        time.Now(),
+	}
+
+	dl.Lock()
+	defer dl.Unlock()
+
+	if dl.enc != nil {
+		_ = dl.enc.Encode(&rec)
+	}
+	// The duplicates of a name say nothing about why the name itself did not make it
+	if _, found := dl.index[name]; found && d == DispositionDeduped {
+		return
+	}
+
+	idx := dl.next
+	if len(dl.ring) < cap(dl.ring) {
+		dl.ring = append(dl.ring, rec)
+	} else {
+		if old := dl.ring[idx].Name; dl.index[old] == idx {
+			delete(dl.index, old)
+		}
+		dl.ring[idx] = rec
+	}
+	dl.index[name] = idx
+	dl.next = (idx + 1) % cap(dl.ring)
+}
+
+// lookup returns the latest disposition of the name still held by the buffer.
+func (dl *dispositionLog) lookup(name string) (DispositionRecord, bool) {
+	if dl == nil {
+		return DispositionRecord{}, false
+	}
+
+	dl.Lock()
+	defer dl.Unlock()
+
+	idx, found := dl.index[strings.ToLower(strings.TrimSpace(name))]
+	if !found {
+		return DispositionRecord{}, false
+	}
+	return dl.ring[idx], true
+}
+
+// WhyNot returns the terminal disposition of the FQDN in the current enumeration. The second return value
+// is false when the name was never attempted, or its disposition has been evicted from the ring buffer.
+func (e *Enumeration) WhyNot(fqdn string) (DispositionRecord, bool) {
+	return e.dlog.lookup(fqdn)
+}
+
+// dispose records the terminal disposition of the candidate name.
+func (e *Enumeration) dispose(name string, d Disposition, reason string) {
+	e.trace(name, systems.TraceScope, "disposed", "disposition", string(d), "reason", reason)
+	e.dlog.add(name, d, reason)
+	e.working.disposed(name, d)
+}
+
+func rcodeString(rcode int) string {
+	if rcode == resolve.RcodeNoResponse {
+		return "no response"
+	}
+	if s, found := dns.RcodeToString[rcode]; found {
+		return s
+	}
+	return fmt.Sprintf("rcode %d", rcode)
+}
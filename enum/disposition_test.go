@@ -0,0 +1,185 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/blacklist"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func TestDispositionLog(t *testing.T) {
+	var buf bytes.Buffer
+	dl := newDispositionLog(3)
+	dl.setOutput(&buf)
+
+	dl.add("www.owasp.org", DispositionResolved, "")
+	dl.add("WWW.owasp.org", DispositionDeduped, "the name was already submitted")
+	if rec, found := dl.lookup("www.owasp.org"); !found || rec.Disposition != DispositionResolved {
+		t.Errorf("the duplicate replaced the disposition %v", rec)
+	}
+
+	for i := 0; i < 3; i++ {
+		dl.add(fmt.Sprintf("host%d.owasp.org", i), DispositionNXDomain, "")
+	}
+	if _, found := dl.lookup("www.owasp.org"); found {
+		t.Error("the oldest disposition was not evicted from the ring buffer")
+	}
+	if len(dl.index) != 3 || len(dl.ring) != 3 {
+		t.Errorf("the ring buffer holds %d records and indexes %d names", len(dl.ring), len(dl.index))
+	}
+	// A name disposed of again is indexed by its latest record, and the stale slot is not deleted with it
+	dl.add("host1.owasp.org", DispositionTimeout, "")
+	dl.add("extra.owasp.org", DispositionScope, "")
+	if rec, found := dl.lookup("host1.owasp.org"); !found || rec.Disposition != DispositionTimeout {
+		t.Errorf("the latest disposition of host1.owasp.org is %v", rec)
+	}
+
+	// The file receives every disposition, including the duplicates and those evicted
+	var lines int
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var rec DispositionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Name == "" {
+			t.Errorf("the line %q is not a disposition", scanner.Text())
+		}
+		lines++
+	}
+	if lines != 7 {
+		t.Errorf("%d dispositions were written, expected 7", lines)
+	}
+
+	var nilLog *dispositionLog
+	nilLog.add("www.owasp.org", DispositionResolved, "")
+	if _, found := nilLog.lookup("www.owasp.org"); found {
+		t.Error("the disabled log returned a disposition")
+	}
+}
+
+func TestDispositionLogFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if dl := dispositionLogFromConfig(cfg); dl == nil || cap(dl.ring) != DefaultDispositionLogSize {
+		t.Error("the default ring buffer was not returned")
+	}
+	if DispositionsToFile(cfg) {
+		t.Error("the file was requested by default")
+	}
+
+	cfg.Options = map[string]interface{}{"dispositions": map[string]interface{}{"size": 50, "file": true}}
+	if dl := dispositionLogFromConfig(cfg); dl == nil || cap(dl.ring) != 50 {
+		t.Error("the size of the ring buffer was not parsed")
+	}
+	if !DispositionsToFile(cfg) {
+		t.Error("the file option was not parsed")
+	}
+
+	cfg.Options = map[string]interface{}{"dispositions": map[string]interface{}{"size": 0}}
+	if dl := dispositionLogFromConfig(cfg); dl != nil {
+		t.Error("the log was returned with a size of zero")
+	}
+}
+
+func TestDispositionFilterPoints(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.BlacklistSubdomain("internal.owasp.org")
+	cfg.BlacklistSubdomain("*.dev.owasp.org")
+	cfg.BlacklistSubdomain("!api.dev.owasp.org")
+	bl, err := blacklist.FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Enumeration{
+		Config:    cfg,
+		Budget:    Budget{DNSQueries: 1},
+		dlog:      newDispositionLog(100),
+		blacklist: bl,
+	}
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+	for _, name := range []string{"bad name.owasp.org", "internal.owasp.org", "web.dev.owasp.org", "api.dev.owasp.org", "www.owasp.org", "www.owasp.org"} {
+		e.nameSrc.newName(&requests.DNSRequest{Name: name, Domain: "owasp.org"})
+	}
+
+	dt := &dnsTask{
+		trust:   "trusted",
+		trusted: true,
+		enum:    e,
+		reqs:    make(map[string]*req),
+		release: make(chan struct{}, 10),
+	}
+	msg := resolve.QueryMsg("ghost.owasp.org", dns.TypeA)
+	dt.addReq(key(msg.Id, msg.Question[0].Name), &req{
+		Ctx:   context.Background(),
+		Data:  &requests.DNSRequest{Name: "ghost.owasp.org", Domain: "owasp.org"},
+		Qtype: dns.TypeA,
+		Types: []uint16{dns.TypeA},
+	})
+	msg.Rcode = dns.RcodeNameError
+	dt.processResp(msg)
+
+	msg = resolve.QueryMsg("gone.owasp.org", dns.TypeA)
+	dt.addReq(key(msg.Id, msg.Question[0].Name), &req{
+		Ctx:      context.Background(),
+		Data:     &requests.DNSRequest{Name: "gone.owasp.org", Domain: "owasp.org"},
+		Types:    []uint16{dns.TypeA},
+		Attempts: maxDNSQueryAttempts,
+		Rcode:    resolve.RcodeNoResponse,
+	})
+	dt.retry(msg, msg.Id, dt.getReq(key(msg.Id, msg.Question[0].Name)))
+
+	// The budget allows a single query, which has been spent
+	_ = e.spendQuery()
+	_, _ = dt.Process(context.Background(), &requests.DNSRequest{Name: "late.owasp.org", Domain: "owasp.org"}, nil)
+
+	sub := &subdomainTask{enum: e}
+	_, _ = sub.Process(context.Background(), &requests.DNSRequest{Name: "www.example.com", Domain: "example.com"}, nil)
+	_, _ = sub.Process(context.Background(), &requests.DNSRequest{Name: "_sip._tcp.owasp.org", Domain: "owasp.org"}, nil)
+
+	for name, expected := range map[string]Disposition{
+		"bad name.owasp.org":  DispositionInvalid,
+		"internal.owasp.org":  DispositionScope,
+		"web.dev.owasp.org":   DispositionScope,
+		"ghost.owasp.org":     DispositionNXDomain,
+		"gone.owasp.org":      DispositionTimeout,
+		"late.owasp.org":      DispositionBudget,
+		"www.example.com":     DispositionScope,
+		"_sip._tcp.owasp.org": DispositionResolved,
+	} {
+		if rec, found := e.WhyNot(name); !found || rec.Disposition != expected {
+			t.Errorf("%s has the disposition %v, expected %s", name, rec, expected)
+		}
+	}
+	// The exception keeps the name matching the glob of the blacklist in scope
+	if rec, found := e.WhyNot("api.dev.owasp.org"); found {
+		t.Errorf("the exception to the blacklist has the disposition %v", rec)
+	}
+	// The first submission of a name is not attempted yet, and the duplicate is recorded in its place
+	if rec, found := e.WhyNot("www.owasp.org"); !found || rec.Disposition != DispositionDeduped {
+		t.Errorf("the duplicate of www.owasp.org has the disposition %v", rec)
+	}
+	if _, found := e.WhyNot("never.owasp.org"); found {
+		t.Error("a disposition was returned for a name that was never attempted")
+	}
+}
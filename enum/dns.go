@@ -15,7 +15,9 @@ import (
 	"github.com/caffix/pipeline"
 	"github.com/caffix/queue"
 	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/policy"
 	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/resolve"
 )
 
@@ -26,24 +28,30 @@ const (
 	maximumBackoffDelay time.Duration = 4 * time.Second
 )
 
-// FwdQueryTypes include the DNS record types that are queried for a discovered name.
+// FwdQueryTypes include the DNS record types that are queried for a discovered name by default.
 var FwdQueryTypes = []uint16{
 	dns.TypeCNAME,
 	dns.TypeA,
 	dns.TypeAAAA,
 }
 
-var fwdQueryTypesLookup = map[uint16]int{dns.TypeCNAME: 0, dns.TypeA: 1, dns.TypeAAAA: 2}
-
 type req struct {
 	Ctx        context.Context
 	Data       pipeline.Data
 	Qtype      uint16
+	Types      []uint16
 	Attempts   int
 	Servfails  int
 	InScope    bool
 	Sent       bool
 	HasRecords bool
+	// Rcode is the response code of the last failed query
+	Rcode int
+	// Disposition is recorded for the name when the request is dropped
+	Disposition Disposition
+	Reason      string
+	// NegTTL is the negative caching TTL of the NXDOMAIN response
+	NegTTL uint32
 }
 
 // dnsTask is the task that handles all DNS name resolution requests within the pipeline.
@@ -54,7 +62,7 @@ type dnsTask struct {
 	trusted   bool
 	enum      *Enumeration
 	done      chan struct{}
-	pool      *resolve.Resolvers
+	pool      Pool
 	params    pipeline.TaskParams
 	reqs      map[string]*req
 	resps     chan *dns.Msg
@@ -65,11 +73,14 @@ type dnsTask struct {
 // newDNSTask returns a dNSTask specific to the provided Enumeration.
 func newDNSTask(e *Enumeration, trusted bool) *dnsTask {
 	trust := "untrusted"
-	pool := e.Sys.Resolvers()
+	pool := e.untrustedPool()
 	qps := e.Config.ResolversQPS
 	if trusted {
 		trust = "trusted"
-		pool = e.Sys.TrustedResolvers()
+		pool = e.trustedPool()
+		if e.zones != nil {
+			pool = e.zones.NewPool(pool)
+		}
 		qps = e.Config.TrustedQPS
 	}
 	plen := pool.Len() * qps
@@ -169,26 +180,48 @@ func (dt *dnsTask) Process(ctx context.Context, data pipeline.Data, tp pipeline.
 	})
 
 	if v, ok := data.(*requests.DNSRequest); ok {
-		qtype := FwdQueryTypes[0]
-		msg := resolve.QueryMsg(v.Name, qtype)
-		k := key(msg.Id, msg.Question[0].Name)
-
-		if dt.addReqWithIncrement(k, &req{
-			Ctx:        ctx,
-			Data:       data.Clone(),
-			Qtype:      qtype,
-			Attempts:   1,
-			HasRecords: len(v.Records) > 0,
-		}) {
-			dt.pool.Query(ctx, msg, dt.resps)
-		} else {
-			dt.enum.Config.Log.Printf("Failed to enter %s into the request registry on the %s DNS task", msg.Question[0].Name, dt.trust)
+		// The brute force candidates of the zones that keep timing out are released at the rate of the zone
+		if !dt.trusted && !dt.enum.bruteFb.admit(ctx, v, func() { dt.resolve(ctx, v) }) {
+			return nil, nil
 		}
+		dt.resolve(ctx, v)
 		return nil, nil
 	}
 	return data, nil
 }
 
+func (dt *dnsTask) resolve(ctx context.Context, v *requests.DNSRequest) {
+	if !dt.trusted && dt.enum.bruteFb.truncated(v) {
+		dt.enum.dispose(v.Name, DispositionTruncated, "the brute forcing of the zone was truncated")
+		return
+	}
+	// New names are no longer resolved once the query budget has been exhausted
+	if !dt.enum.spendQuery() {
+		dt.enum.dispose(v.Name, DispositionBudget, "the DNS query budget was exhausted before the "+dt.trust+" resolution")
+		return
+	}
+
+	types := dt.enum.qtypes.forName(v, dt.trusted)
+	qtype := types[0]
+	msg := resolve.QueryMsg(v.Name, qtype)
+	k := key(msg.Id, msg.Question[0].Name)
+
+	if dt.addReqWithIncrement(k, &req{
+		Ctx:        ctx,
+		Data:       v.Clone(),
+		Qtype:      qtype,
+		Types:      types,
+		Attempts:   1,
+		HasRecords: len(v.Records) > 0,
+	}) {
+		dt.enum.tiers.query(dt.trusted)
+		dt.enum.traceQuery(v.Name, qtype, dt.trust, 1)
+		dt.pool.Query(ctx, msg, dt.resps)
+	} else {
+		dt.enum.Config.Log.Printf("Failed to enter %s into the request registry on the %s DNS task", msg.Question[0].Name, dt.trust)
+	}
+}
+
 func (dt *dnsTask) nextStage(ctx context.Context, data pipeline.Data) {
 	dt.Lock()
 	params := dt.params
@@ -231,6 +264,18 @@ func (dt *dnsTask) addReq(key string, entry *req) bool {
 	return false
 }
 
+// outstanding returns the number of names with DNS queries in flight.
+func (dt *dnsTask) outstanding() int {
+	if dt == nil {
+		return 0
+	}
+
+	dt.Lock()
+	defer dt.Unlock()
+
+	return len(dt.reqs)
+}
+
 func (dt *dnsTask) addReqWithIncrement(key string, entry *req) bool {
 	added := dt.addReq(key, entry)
 
@@ -256,8 +301,19 @@ func (dt *dnsTask) delReqWithDecrement(key string) {
 	if req := dt.delReq(key); req != nil {
 		dt.release <- struct{}{}
 
+		if d, ok := req.Data.(*requests.DNSRequest); ok && !dt.trusted {
+			dt.enum.bruteFb.observe(d, req.Disposition, req.Sent, req.NegTTL)
+			if !req.Sent {
+				dt.enum.tiers.screened(d.Name, false)
+			}
+		} else if ok {
+			dt.enum.confirm(d.Name, req.Disposition, req.HasRecords)
+		}
 		if !req.Sent && (req.InScope || req.HasRecords) {
 			dt.nextStage(req.Ctx, req.Data)
+		} else if d, ok := req.Data.(*requests.DNSRequest); ok && !req.Sent && req.Disposition != "" {
+			dt.enum.dispose(d.Name, req.Disposition, req.Reason)
+			dt.enum.attempted(d, req.Disposition)
 		}
 	}
 }
@@ -278,6 +334,8 @@ func (dt *dnsTask) processResponses() {
 }
 
 func (dt *dnsTask) processResp(resp *dns.Msg) {
+	dt.enum.completion.activity(dt.trust+" resolvers", false)
+	dt.enum.traceAnswer(resp, dt.trust)
 	k := key(resp.Id, resp.Question[0].Name)
 
 	entry := dt.getReq(k)
@@ -289,6 +347,9 @@ func (dt *dnsTask) processResp(resp *dns.Msg) {
 	switch resp.Rcode {
 	// check if the response indicates that the name doesn't exist
 	case dns.RcodeNameError:
+		entry.Disposition = DispositionNXDomain
+		entry.Reason = "the " + dt.trust + " resolvers returned NXDOMAIN"
+		entry.NegTTL = negativeTTL(resp)
 		dt.delReqWithDecrement(k)
 		return
 	// the rest are errors that should not continue across many resolvers
@@ -318,6 +379,7 @@ func (dt *dnsTask) processResp(resp *dns.Msg) {
 		if resp.Rcode == dns.RcodeSuccess {
 			dt.processFwdRequest(ctx, resp, name, qtype, v, entry)
 		} else {
+			entry.Rcode = resp.Rcode
 			go dt.retry(resolve.QueryMsg(v.Name, qtype), resp.Id, entry)
 		}
 	default:
@@ -332,10 +394,18 @@ func (dt *dnsTask) retry(msg *dns.Msg, id uint16, entry *req) {
 	if entry.Attempts <= maxDNSQueryAttempts && entry.Servfails < maxRcodeServerFails {
 		dt.delReq(k)
 		dt.addReq(key(msg.Id, msg.Question[0].Name), entry)
-		time.Sleep(resolve.TruncatedExponentialBackoff(entry.Attempts-1, initialBackoffDelay, maximumBackoffDelay))
+		dt.enum.clock.Sleep(resolve.TruncatedExponentialBackoff(entry.Attempts-1, initialBackoffDelay, maximumBackoffDelay))
+		_ = dt.enum.spendQuery()
+		dt.enum.tiers.query(dt.trusted)
+		dt.enum.traceQuery(resolve.RemoveLastDot(msg.Question[0].Name), msg.Question[0].Qtype, dt.trust, entry.Attempts)
 		dt.pool.Query(entry.Ctx, msg, dt.resps)
 	} else {
 		dt.enum.Config.Log.Printf("%s was dropped after failing to resolve %d times on the %s DNS task", msg.Question[0].Name, entry.Attempts-1, dt.trust)
+		entry.Disposition = DispositionServfail
+		if entry.Rcode == resolve.RcodeNoResponse {
+			entry.Disposition = DispositionTimeout
+		}
+		entry.Reason = fmt.Sprintf("the %s resolvers failed %d times, last with %s", dt.trust, entry.Attempts-1, rcodeString(entry.Rcode))
 		dt.delReqWithDecrement(k)
 	}
 }
@@ -343,21 +413,26 @@ func (dt *dnsTask) retry(msg *dns.Msg, id uint16, entry *req) {
 func (dt *dnsTask) nextType(ctx context.Context, name string, id, qtype uint16, entry *req) {
 	k := key(id, name)
 
-	if idx, found := fwdQueryTypesLookup[qtype]; found && idx+1 < len(FwdQueryTypes) {
+	if idx := indexOfType(entry.Types, qtype); idx >= 0 && idx+1 < len(entry.Types) {
 		entry.Attempts = 1
 		entry.Servfails = 0
-		entry.Qtype = FwdQueryTypes[idx+1]
+		entry.Qtype = entry.Types[idx+1]
 		msg := resolve.QueryMsg(name, entry.Qtype)
 		dt.delReq(k)
 		dt.addReq(key(msg.Id, msg.Question[0].Name), entry)
+		_ = dt.enum.spendQuery()
+		dt.enum.tiers.query(dt.trusted)
+		dt.enum.traceQuery(name, entry.Qtype, dt.trust, 1)
 		dt.pool.Query(ctx, msg, dt.resps)
 	} else {
+		entry.Disposition = DispositionNoRecords
+		entry.Reason = "the " + dt.trust + " resolvers returned no records of the queried types"
 		dt.delReqWithDecrement(k)
 	}
 }
 
 func (dt *dnsTask) processFwdRequest(ctx context.Context, resp *dns.Msg, name string, qtype uint16, req *requests.DNSRequest, entry *req) {
-	ans := resolve.ExtractAnswers(resp)
+	ans := extractAnswers(resp)
 	if len(ans) == 0 {
 		dt.nextType(ctx, name, resp.Id, qtype, entry)
 		return
@@ -371,6 +446,8 @@ func (dt *dnsTask) processFwdRequest(ctx context.Context, resp *dns.Msg, name st
 
 	k := key(resp.Id, resp.Question[0].Name)
 	if !dt.trusted {
+		// the positive answer is held for its confirmation before the trusted resolvers can return
+		dt.enum.tiers.screened(name, true)
 		dt.nextStage(ctx, req)
 		entry.Sent = true
 		dt.delReqWithDecrement(k)
@@ -378,14 +455,21 @@ func (dt *dnsTask) processFwdRequest(ctx context.Context, resp *dns.Msg, name st
 	}
 
 	if dt.enum.wildcardDetected(ctx, req, resp) {
+		entry.Disposition = DispositionWildcard
+		entry.Reason = "the answer matches the wildcard of " + req.Domain
 		dt.delReqWithDecrement(k)
 		return
 	}
 
-	req.Records = append(req.Records, convertAnswers(rr)...)
+	records := answerTTLs(convertAnswers(rr), resp)
+	for i := range records {
+		records[i].Authoritative = resp.Authoritative
+	}
+	dt.enum.authority.add(req.Name, records)
+	req.Records = append(req.Records, records...)
 	entry.HasRecords = len(req.Records) > 0
 	// are there additional record types to query for?
-	if idx, found := fwdQueryTypesLookup[qtype]; found && qtype != dns.TypeCNAME && idx+1 < len(FwdQueryTypes) {
+	if idx := indexOfType(entry.Types, qtype); idx >= 0 && qtype != dns.TypeCNAME && idx+1 < len(entry.Types) {
 		dt.nextType(ctx, name, resp.Id, qtype, entry)
 		return
 	}
@@ -414,7 +498,7 @@ func (dt *dnsTask) subdomainQueries(ctx context.Context, req *requests.DNSReques
 
 func (dt *dnsTask) queryNS(ctx context.Context, name, domain string, ch chan []requests.DNSAnswer, tp pipeline.TaskParams) {
 	// Obtain the DNS answers for the NS records related to the domain
-	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeNS, dt.enum.Sys.TrustedResolvers(), maxDNSQueryAttempts); err == nil {
+	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeNS, dt.enum.trustedPool(), maxDNSQueryAttempts); err == nil {
 		if ans := resolve.ExtractAnswers(resp); len(ans) > 0 {
 			if rr := resolve.AnswersByType(ans, dns.TypeNS); len(rr) > 0 {
 				var records []requests.DNSAnswer
@@ -438,7 +522,7 @@ func (dt *dnsTask) queryNS(ctx context.Context, name, domain string, ch chan []r
 
 func (dt *dnsTask) queryMX(ctx context.Context, name string, ch chan []requests.DNSAnswer, tp pipeline.TaskParams) {
 	// Obtain the DNS answers for the MX records related to the domain
-	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeMX, dt.enum.Sys.TrustedResolvers(), maxDNSQueryAttempts); err == nil {
+	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeMX, dt.enum.trustedPool(), maxDNSQueryAttempts); err == nil {
 		if ans := resolve.ExtractAnswers(resp); len(ans) > 0 {
 			if rr := resolve.AnswersByType(ans, dns.TypeMX); len(rr) > 0 {
 				ch <- convertAnswers(rr)
@@ -451,17 +535,12 @@ func (dt *dnsTask) queryMX(ctx context.Context, name string, ch chan []requests.
 
 func (dt *dnsTask) querySOA(ctx context.Context, name string, ch chan []requests.DNSAnswer, tp pipeline.TaskParams) {
 	// Obtain the DNS answers for the SOA records related to the domain
-	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeSOA, dt.enum.Sys.TrustedResolvers(), maxDNSQueryAttempts); err == nil {
+	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeSOA, dt.enum.trustedPool(), maxDNSQueryAttempts); err == nil {
 		if ans := resolve.ExtractAnswers(resp); len(ans) > 0 {
 			if rr := resolve.AnswersByType(ans, dns.TypeSOA); len(rr) > 0 {
-				var records []requests.DNSAnswer
-
-				for _, a := range rr {
-					pieces := strings.Split(a.Data, ",")
-					a.Data = pieces[len(pieces)-1]
-					records = append(records, convertAnswers([]*resolve.ExtractedAnswer{a})...)
-				}
-				ch <- records
+				// The store stage extracts the primary name server and the mailbox from the record data
+				ch <- convertAnswers(rr)
+				return
 			}
 		}
 	}
@@ -470,7 +549,7 @@ func (dt *dnsTask) querySOA(ctx context.Context, name string, ch chan []requests
 
 func (dt *dnsTask) querySPF(ctx context.Context, name string, ch chan []requests.DNSAnswer, tp pipeline.TaskParams) {
 	// Obtain the DNS answers for the SPF records related to the domain
-	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeSPF, dt.enum.Sys.TrustedResolvers(), maxDNSQueryAttempts); err == nil {
+	if resp, err := dt.enum.dnsQuery(ctx, name, dns.TypeSPF, dt.enum.trustedPool(), maxDNSQueryAttempts); err == nil {
 		if ans := resolve.ExtractAnswers(resp); len(ans) > 0 {
 			if rr := resolve.AnswersByType(ans, dns.TypeSPF); len(rr) > 0 {
 				ch <- convertAnswers(rr)
@@ -482,7 +561,7 @@ func (dt *dnsTask) querySPF(ctx context.Context, name string, ch chan []requests
 }
 
 func (e *Enumeration) fwdQuery(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
-	resp, err := e.dnsQuery(ctx, name, qtype, e.Sys.Resolvers(), maxDNSQueryAttempts)
+	resp, err := e.dnsQuery(ctx, name, qtype, e.untrustedPool(), maxDNSQueryAttempts)
 	if err != nil {
 		return resp, err
 	}
@@ -490,14 +569,40 @@ func (e *Enumeration) fwdQuery(ctx context.Context, name string, qtype uint16) (
 		return nil, errors.New("query failed")
 	}
 
-	resp, err = e.dnsQuery(ctx, name, qtype, e.Sys.TrustedResolvers(), maxDNSQueryAttempts)
+	resp, err = e.dnsQuery(ctx, name, qtype, e.trustedPool(), maxDNSQueryAttempts)
 	if resp == nil && err == nil {
 		err = errors.New("query failed")
 	}
 	return resp, err
 }
 
-func (e *Enumeration) dnsQuery(ctx context.Context, name string, qtype uint16, r *resolve.Resolvers, attempts int) (*dns.Msg, error) {
+// untrustedPool returns the remote workers when they have been assigned to the enumeration, sends the
+// queries to the authoritative servers in the authoritative mode, and is limited to the maximum DNS
+// queries per second and jittered in OPSEC mode when set.
+func (e *Enumeration) untrustedPool() Pool {
+	var pool Pool = e.Sys.Resolvers()
+	if e.Resolvers != nil {
+		pool = e.Resolvers
+	}
+	pool = e.metered(pool, componentResolvers)
+	if e.zones != nil {
+		pool = e.zones.NewPool(pool)
+	}
+	if e.jitter != nil {
+		pool = &jitterPool{Pool: pool, jitter: e.jitter}
+	}
+	if e.limiter != nil {
+		pool = &limitedPool{Pool: pool, limiter: e.limiter}
+	}
+	// The queries joining an identical query in flight are neither rate limited nor metered
+	return e.coalescePool(pool, componentResolvers)
+}
+
+func (e *Enumeration) dnsQuery(ctx context.Context, name string, qtype uint16, r Pool, attempts int) (*dns.Msg, error) {
+	if e.Policy.BlocksName(policy.DNS, name) {
+		return nil, policy.ErrBlocked
+	}
+
 	msg := resolve.QueryMsg(name, qtype)
 
 	for num := 0; num < attempts; num++ {
@@ -506,7 +611,11 @@ func (e *Enumeration) dnsQuery(ctx context.Context, name string, qtype uint16, r
 			return nil, errors.New("context expired")
 		default:
 		}
+		if !e.spendQuery() {
+			return nil, errors.New("the DNS query budget has been exhausted")
+		}
 
+		e.traceQuery(name, qtype, "blocking", num+1)
 		resp, err := r.QueryBlocking(ctx, msg)
 		if err != nil {
 			continue
@@ -525,7 +634,48 @@ func (e *Enumeration) dnsQuery(ctx context.Context, name string, qtype uint16, r
 }
 
 func (e *Enumeration) wildcardDetected(ctx context.Context, req *requests.DNSRequest, resp *dns.Msg) bool {
-	return e.Sys.TrustedResolvers().WildcardDetected(ctx, resp, req.Domain)
+	if !e.Sys.TrustedResolvers().WildcardDetected(ctx, resp, req.Domain) {
+		e.trace(req.Name, systems.TraceWildcard, "no wildcard matched", "domain", req.Domain)
+		return false
+	}
+	// The names within the zones proven by the certificates are kept unless they match the wildcard of the zone
+	if zone := e.certZones.zoneOf(req.Name); zone != "" {
+		if e.certZoneWildcard(ctx, zone, resp) {
+			e.trace(req.Name, systems.TraceWildcard, "matched the wildcard of the certificate zone", "zone", zone)
+			return true
+		}
+		// The name is kept, but it sits under the wildcard of the zone
+		e.confidence.markWildcard(req.Name)
+		e.trace(req.Name, systems.TraceWildcard, "kept under the wildcard of the certificate zone", "zone", zone)
+		return false
+	}
+	// The names standing apart from the wildcard of a platform answering for every name are kept
+	if e.clusters.distinct(ctx, req.Name, resp) {
+		e.confidence.markWildcard(req.Name)
+		e.trace(req.Name, systems.TraceWildcard, "kept apart from the wildcard cluster", "domain", req.Domain)
+		return false
+	}
+	e.trace(req.Name, systems.TraceWildcard, "matched the wildcard", "domain", req.Domain)
+	return true
+}
+
+// extractAnswers returns the answers of the message, including the CAA records ignored by the resolve package.
+func extractAnswers(msg *dns.Msg) []*resolve.ExtractedAnswer {
+	ans := resolve.ExtractAnswers(msg)
+	if msg == nil {
+		return ans
+	}
+
+	for _, rr := range msg.Answer {
+		if caa, ok := rr.(*dns.CAA); ok {
+			ans = append(ans, &resolve.ExtractedAnswer{
+				Name: strings.ToLower(resolve.RemoveLastDot(caa.Hdr.Name)),
+				Type: dns.TypeCAA,
+				Data: fmt.Sprintf("%d %s %q", caa.Flag, caa.Tag, caa.Value),
+			})
+		}
+	}
+	return ans
 }
 
 func convertAnswers(ans []*resolve.ExtractedAnswer) []requests.DNSAnswer {
@@ -540,3 +690,24 @@ func convertAnswers(ans []*resolve.ExtractedAnswer) []requests.DNSAnswer {
 	}
 	return answers
 }
+
+// answerTTLs sets the raw TTL of each record, which is the least TTL of the records of the message
+// sharing its name and type.
+func answerTTLs(records []requests.DNSAnswer, msg *dns.Msg) []requests.DNSAnswer {
+	if msg == nil {
+		return records
+	}
+
+	for i, rec := range records {
+		for _, rr := range msg.Answer {
+			hdr := rr.Header()
+			if int(hdr.Rrtype) != rec.Type || !strings.EqualFold(resolve.RemoveLastDot(hdr.Name), rec.Name) {
+				continue
+			}
+			if ttl := int(hdr.Ttl); records[i].TTL == 0 || ttl < records[i].TTL {
+				records[i].TTL = ttl
+			}
+		}
+	}
+	return records
+}
@@ -6,48 +6,269 @@ package enum
 
 import (
 	"context"
+	"io"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caffix/netmap"
 	"github.com/caffix/pipeline"
 	"github.com/caffix/queue"
 	"github.com/caffix/service"
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/attempts"
+	"github.com/owasp-amass/amass/v4/authoritative"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/blacklist"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/cloud"
 	"github.com/owasp-amass/amass/v4/datasrcs"
+	"github.com/owasp-amass/amass/v4/evidence"
+	"github.com/owasp-amass/amass/v4/geo"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/journal"
+	"github.com/owasp-amass/amass/v4/memory"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/opsec"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/provenance"
+	"github.com/owasp-amass/amass/v4/quarantine"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/amass/v4/rdap"
 	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/snapshot"
 	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/config/config"
 	oam "github.com/owasp-amass/open-asset-model"
 	"github.com/owasp-amass/open-asset-model/domain"
 )
 
+// lateSourceInterval is how often the enumeration checks for the data sources that joined the System after it began.
+const lateSourceInterval = time.Second
+
+// maxReplayedRequests bounds the requests kept for the data sources joining the enumeration after it began.
+const maxReplayedRequests = 10000
+
+// Budget limits the resources consumed by an enumeration, which keeps
+// the enumerations sharing a System from starving one another.
+type Budget struct {
+	// Duration is the maximum amount of time the enumeration will run
+	Duration time.Duration
+	// DNSQueries is the maximum number of DNS queries the enumeration will send
+	DNSQueries int64
+	// Bytes is the maximum number of bytes the enumeration will send and receive over DNS and HTTP
+	Bytes int64
+}
+
+// Pool is implemented by the resolver pools that perform the untrusted DNS queries
+// of an enumeration, such as the local pool or a set of remote workers.
+type Pool interface {
+	Len() int
+	Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg)
+	QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+}
+
 // Enumeration is the object type used to execute a DNS enumeration.
+// Many enumerations can share a System, since each one carries its own
+// scope, graph, budget and data source output channels.
 type Enumeration struct {
-	Config   *config.Config
-	Sys      systems.System
-	ctx      context.Context
-	graph    *netmap.Graph
-	srcs     []service.Service
-	done     chan struct{}
-	nameSrc  *enumSource
-	subTask  *subdomainTask
-	dnsTask  *dnsTask
-	valTask  *dnsTask
-	store    *dataManager
-	requests queue.Queue
-	plock    sync.Mutex
-	pending  bool
+	Config *config.Config
+	Sys    systems.System
+	Budget Budget
+	// Resolvers replaces the untrusted resolver pool of the System when set
+	Resolvers Pool
+	// Output receives the names resolved within scope when set, and is not closed by the enumeration
+	Output chan *requests.Output
+	// Evidence stores the data source response fragments that yielded the names when set
+	Evidence *evidence.Store
+	// Journal keeps the writes that failed to reach a remote graph database when set, and they are
+	// replayed into the graph during the enumeration and once it is finished
+	Journal *journal.Journal
+	// Attempts remembers the brute force and alteration candidates that failed to resolve when set, and
+	// they are not queried again until their retry age has passed
+	Attempts *attempts.Set
+	// RDAP looks up the registration data of the root domain names and the netblocks of the addresses when set
+	RDAP *rdap.Client
+	// Registrations keeps the registration data obtained through RDAP, and is required by the lookups
+	Registrations *rdap.Store
+	// Cloud classifies the provider and service hosting each name, and is the embedded ruleset unless the
+	// cloud options name another
+	Cloud *cloud.Ruleset
+	// Geo sets the country and region of the addresses in the output, and is set from the geolocation options
+	Geo *geo.Enricher
+	// History keeps the periods the names were observed resolving to their addresses in the graph when set,
+	// and is required to store the historical resolutions provided by the data sources
+	History *history.Backend
+	// Quarantine persists the suspicious findings set apart by the quarantine rules, along with the decisions
+	// made on them, when set
+	Quarantine *quarantine.Backend
+	// Derivations persists the provenance of the findings when set, so the derivations of each domain are
+	// released from memory once the domain is complete
+	Derivations *provenance.Backend
+	// Snapshots keeps the effective configuration of the enumeration, with the secrets redacted, when set
+	Snapshots *snapshot.Store
+	// Policy blocks the active probes toward the never-touch list before the scope is consulted, and is
+	// loaded from the policy options
+	Policy *policy.Policy
+	// Dispositions receives the terminal disposition of each candidate name as a line of JSON when set
+	Dispositions io.Writer
+	// Imported holds the names imported from external lists, which are brought into the enumeration at the start
+	Imported   []*requests.DNSRequest
+	ctx        context.Context
+	cancel     func(*requests.CancelCause)
+	graph      *netmap.Graph
+	srcs       []service.Service
+	joined     chan service.Service
+	lateSrcs   []service.Service
+	done       chan struct{}
+	nameSrc    *enumSource
+	subTask    *subdomainTask
+	dnsTask    *dnsTask
+	valTask    *dnsTask
+	store      *dataManager
+	requests   queue.Queue
+	prov       *provenanceGraph
+	job        *requests.Job
+	qtypes     *queryTypes
+	recursion  *recursionGate
+	bruteFb    *bruteFeedback
+	addrScope  *addressScope
+	dlog       *dispositionLog
+	stored     *storedTypes
+	caa        *caaStore
+	authority  *authorityStore
+	certZones  *certZoneStore
+	clusters   *wildcardClusterer
+	mail       *mailMapper
+	dels       *delegationAuditor
+	regs       *registrationLookups
+	horizon    *horizonComparer
+	infra      *infraStore
+	confidence *confidenceScorer
+	snapshot   *snapshot.Snapshot
+	delta      *deltaMode
+	tiers      *tierStats
+	sources    *sourceMismatches
+	zones      *authoritative.Zones
+	working    *workingSet
+	clock      clock.Clock
+	limiter    *rate.Limiter
+	seed       *random.Source
+	opsec      *opsec.Settings
+	jitter     *opsec.Jitter
+	queries    int64
+	meter      *bandwidth.Meter
+	coalescer  *coalescer
+	ttls       systems.TTLBounds
+	writes     *writeCoalescer
+	pressure   *writePressure
+	tracer     systems.NameTracer
+	vhosts     *vhostProber
+	posture    *postureProber
+	cloudNames *cloudGenerator
+	shard      *shard
+	shardErr   error
+	// blacklist holds the compiled rules of the scope blacklist, evaluated for each candidate name
+	blacklist    *blacklist.List
+	blacklistErr error
+	quarantine   *quarantineRules
+	// optionsErr is the first error parsing the cloud and TTL options, since the zero values would be used instead
+	optionsErr error
+	// completion decides when the enumeration has finished, and records the reason
+	completion *completion
+	// memory asks the subsystems holding the most memory to back off once the limit is exceeded
+	memory      *memory.Monitor
+	memInterval time.Duration
+	// memHardLimit is the consumption in bytes at which the enumeration is cancelled, where 0 disables it
+	memHardLimit uint64
+	plock        sync.Mutex
+	pendingSrcs  []string
 }
 
 // NewEnumeration returns an initialized Enumeration that has not been started yet.
 func NewEnumeration(cfg *config.Config, sys systems.System, graph *netmap.Graph) *Enumeration {
-	return &Enumeration{
-		Config:   cfg,
-		Sys:      sys,
-		graph:    graph,
-		srcs:     datasrcs.SelectedDataSources(cfg, sys.DataSources()),
-		requests: queue.NewQueue(),
+	srcs := datasrcs.SelectedDataSources(cfg, sys.DataSources())
+
+	var names []string
+	for _, src := range srcs {
+		names = append(names, src.String())
+	}
+
+	seed := random.FromConfig(cfg)
+	e := &Enumeration{
+		Config:     cfg,
+		Sys:        sys,
+		graph:      graph,
+		srcs:       srcs,
+		requests:   queue.NewQueue(),
+		prov:       newProvenanceGraph(),
+		job:        requests.NewJob(uuid.New().String(), cfg, names),
+		qtypes:     queryTypesFromConfig(cfg),
+		recursion:  recursionGateFromConfig(cfg),
+		bruteFb:    bruteFeedbackFromConfig(cfg, clock.System),
+		addrScope:  addressScopeFromConfig(cfg),
+		dlog:       dispositionLogFromConfig(cfg),
+		stored:     storedTypesFromConfig(cfg, sys.GraphSystem(graph)),
+		caa:        newCAAStore(),
+		authority:  newAuthorityStore(),
+		infra:      newInfraStore(),
+		confidence: confidenceFromConfig(cfg),
+		certZones:  newCertZoneStore(seed.Rand("wildcard")),
+		clusters:   wildcardClustersFromConfig(cfg, seed.Rand("wildcard_clustering")),
+		seed:       seed,
+		clock:      clock.System,
+		limiter:    dnsLimiterFromConfig(cfg, clock.System),
+		completion: completionFromConfig(cfg, clock.System),
+		delta:      deltaFromConfig(cfg),
+		tiers:      tiersFromConfig(cfg),
+		working:    workingSetFromConfig(cfg, clock.System),
+		meter:      bandwidth.NewMeter(0),
+		coalescer:  coalescerFromConfig(cfg),
+		writes:     writeCoalescerFromConfig(cfg, clock.System),
+		pressure:   backpressureFromConfig(cfg, sys.GraphSystem(graph), clock.System),
+		vhosts:     vhostProberFromConfig(cfg),
+		posture:    postureProberFromConfig(cfg),
+		quarantine: quarantineFromConfig(cfg),
+	}
+	e.memory, e.memInterval = memoryMonitorFromConfig(cfg, sys.GetMemoryUsage)
+	e.memHardLimit = memoryHardLimitFromConfig(cfg)
+	e.shard, e.shardErr = shardFromConfig(cfg)
+	e.blacklist, e.blacklistErr = blacklist.FromConfig(cfg)
+	var cloudErr, cloudNamesErr, ttlErr error
+	e.Cloud, cloudErr = cloud.FromConfig(cfg)
+	e.cloudNames, cloudNamesErr = cloudGeneratorFromConfig(cfg)
+	e.ttls, ttlErr = systems.TTLBoundsFromConfig(cfg)
+	for _, err := range []error{cloudErr, cloudNamesErr, ttlErr} {
+		if err != nil && e.optionsErr == nil {
+			e.optionsErr = err
+		}
+	}
+	e.Policy = policyFromConfig(cfg, sys)
+	if gcfg := geo.ConfigFromOptions(cfg); gcfg != nil {
+		e.Geo = geo.FromConfig(gcfg, cfg.Log)
 	}
+	if e.opsec = opsec.FromConfig(cfg); e.opsec != nil {
+		e.jitter = e.opsec.NewJitter()
+	}
+	if e.clusters != nil {
+		e.clusters.query = e.clusterQuery
+	}
+	// The events of the names traced by the System are captured along the resolution, filtering and storage
+	e.tracer, _ = sys.(systems.NameTracer)
+	if e.coalescer != nil {
+		e.coalescer.joined = func(name, component string) {
+			e.trace(name, systems.TraceCache, "joined an identical query in flight", "component", component)
+		}
+		e.coalescer.answered = func(name, component string) {
+			e.trace(name, systems.TraceCache, "answered within the TTL of an identical query", "component", component)
+		}
+	}
+	e.mail = newMailMapper(e)
+	e.dels = newDelegationAuditor(e)
+	return e
 }
 
 // Start begins the vertical domain correlation process.
@@ -58,16 +279,113 @@ func (e *Enumeration) Start(ctx context.Context) error {
 	if err := e.Config.CheckSettings(); err != nil {
 		return err
 	}
+	if e.shardErr != nil {
+		return e.shardErr
+	}
+	if e.blacklistErr != nil {
+		return e.blacklistErr
+	}
+	if e.optionsErr != nil {
+		return e.optionsErr
+	}
+	// The domains of the adjacent names promoted from the quarantine by earlier runs are enumerated as well
+	e.addPromotedDomains()
+	e.prov.persist(e.Derivations, e.Config.WhichDomain, e.Config.Log.Printf)
+	// The graph is not repaired while the enumeration writes to it, since the other enumerations share it
+	defer systems.AcquireGraphWriter(e.graph)()
+	e.saveSnapshot()
+	e.startDelta()
+	e.dlog.setOutput(e.Dispositions)
+	if e.seed != nil {
+		e.Config.Log.Printf("The run can be reproduced with the seed %d", e.seed.Seed)
+	}
+	e.startOPSEC()
+	defer e.reportOPSEC()
 	// This context, used throughout the enumeration, will provide the
 	// ability to pass the configuration and event bus to all the components
-	var cancel context.CancelFunc
-	e.ctx, cancel = context.WithCancel(ctx)
-	defer cancel()
+	parent := ctx
+	if e.Budget.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Budget.Duration)
+		defer cancel()
+	}
+	// The components cutting the enumeration short record the cause on the context
+	ctx, e.cancel = requests.WithCancelCause(ctx)
+	defer e.cancel(nil)
+	e.startBandwidth(ctx, e.cancel)
+	defer e.reportBandwidth()
+	defer e.reportCoalesced()
+	// The data sources deliver the findings through the job, isolating them from other enumerations
+	if e.Evidence != nil {
+		e.job.Evidence = e.Evidence
+	}
+	e.job.Policy = e.Policy
+	e.mail.journal = e.Journal
+	e.dels.journal = e.Journal
+	// The HTTP requests made with the context, including those of the data sources, are accounted for by the meter
+	e.ctx = bandwidth.WithMeter(requests.WithJob(ctx, e.job), e.meter)
+	// The data sources still being started by the System join the enumeration once they are up
+	if !e.Sys.StartupProgress().Done() {
+		e.joined = make(chan service.Service)
+	}
 	go e.manageDataSrcRequests()
+	// The registration data is looked up beside the pipeline, and the lookups are finished before returning
+	if e.RDAP != nil && e.Registrations != nil {
+		e.regs = newRegistrationLookups(e)
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			e.regs.process(e.ctx)
+		}()
+		defer func() {
+			close(e.regs.done)
+			<-finished
+		}()
+	}
+
+	// The resolver groups compare their answers for the confirmed names beside the pipeline
+	if e.horizon = newHorizonComparer(e); e.horizon != nil {
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			e.horizon.process(e.ctx)
+		}()
+		defer func() {
+			close(e.horizon.done)
+			<-finished
+			e.horizon.stop()
+		}()
+	}
+
+	// The update records of the names corroborated after they were sent follow them on the output channel
+	if e.Output != nil && e.confidence != nil {
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			e.confidence.process(e.ctx, e.Output)
+		}()
+		defer func() {
+			close(e.confidence.done)
+			<-finished
+		}()
+	}
+
+	// The pipelined transport is closed once the DNS tasks have stopped sending queries
+	if pool := e.pipelinedPool(); pool != nil {
+		e.Resolvers = pool
+		defer func() {
+			pool.Close()
+			e.Resolvers = nil
+		}()
+	}
 
+	// The authoritative servers are shared by the pools of both DNS tasks, so each server is queried at its own rate
+	e.zones = e.authoritativeZones()
 	e.dnsTask = newDNSTask(e, false)
 	e.valTask = newDNSTask(e, true)
 	e.store = newDataManager(e)
+	e.startBackpressure()
+	defer e.reportBackpressure()
 	e.subTask = newSubdomainTask(e)
 	defer e.subTask.Stop()
 	defer e.dnsTask.stop()
@@ -84,9 +402,37 @@ func (e *Enumeration) Start(ctx context.Context) error {
 	// The pipeline input source will receive all the names
 	e.nameSrc = newEnumSource(p, e)
 	defer e.nameSrc.Stop()
+	e.seedDelta()
+	if e.joined != nil {
+		go e.watchLateSources()
+	}
+	if e.memory != nil {
+		e.registerMemory()
+		go e.watchMemory()
+	}
+	// The structures of each domain are flushed and released once the domain is complete
+	if ws := e.working; ws != nil {
+		ws.known = e.storedName
+		ws.onComplete(e.subTask.release)
+		ws.onComplete(e.zones.Forget)
+		ws.onComplete(e.prov.release)
+		stop, finished := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(finished)
+			e.watchDomains(stop)
+		}()
+		// The hooks are stopped before the subdomain task releases its structures
+		defer func() {
+			close(stop)
+			<-finished
+		}()
+	}
 
 	e.submitASNs()
 	e.submitDomainNames()
+	for _, domain := range e.Config.Domains() {
+		e.regs.domain(domain)
+	}
 	/*
 	 * Now that the pipeline input source has been setup, names provided
 	 * by the user and names acquired from the graph database can be brought
@@ -94,21 +440,148 @@ func (e *Enumeration) Start(ctx context.Context) error {
 	 */
 	go e.submitKnownNames()
 	go e.submitProvidedNames()
+	go e.submitImportedNames()
+	// Mapping the mail infrastructure sends many queries to the target's name servers
+	var mailDone sync.WaitGroup
+	if e.Config.Active {
+		mailDone.Add(1)
+		go func() {
+			defer mailDone.Done()
+			e.mail.mapDomains(e.ctx, e.Config.Domains())
+		}()
+	}
+
+	// The addresses given as the sole scope are swept for the names hosted on them
+	var sweepDone sync.WaitGroup
+	if e.addrScope != nil {
+		sweepDone.Add(1)
+		go func() {
+			defer sweepDone.Done()
+			e.sweepAddressScope(e.ctx)
+		}()
+	}
+
+	// The writes journaled while the remote graph database was unreachable are replayed once it returns
+	var replayDone sync.WaitGroup
+	stopReplay := make(chan struct{})
+	if e.Journal != nil {
+		replayDone.Add(1)
+		go func() {
+			defer replayDone.Done()
+			e.replayJournal(stopReplay)
+		}()
+	}
+
+	// The last seen times of the edges observed again are written at the interval
+	var flushDone sync.WaitGroup
+	stopFlush := make(chan struct{})
+	if e.writes != nil {
+		flushDone.Add(1)
+		go func() {
+			defer flushDone.Done()
+			e.flushWrites(stopFlush)
+		}()
+	}
 
 	err := p.ExecuteBuffered(e.ctx, e.nameSrc, e.makeOutputSink(), 50)
+	e.finishReason(parent)
+	e.saveCursors()
+	mailDone.Wait()
+	sweepDone.Wait()
 	// Ensure all data has been stored
 	<-e.store.Stop()
+	close(stopFlush)
+	flushDone.Wait()
+	e.finishWrites()
+	e.finishQuarantine()
+	// The names answered from unexpected addresses are flagged once their records have been stored
+	e.flagSourceMismatches(context.Background())
+	// The candidates of the providers hosting enough names are checked once all the names have been classified
+	e.generateCloudNames(e.ctx)
+	// The zone cuts are found by walking the names discovered by the enumeration
+	if e.Config.Active {
+		e.dels.auditDomains(e.ctx, e.Config.Domains(), e.Config.CollectionStartTime)
+		// The addresses are asked for the other names of their netblocks once all of them have been found
+		e.discoverVirtualHosts(e.ctx)
+		// The security headers are asked of each name served over HTTPS
+		e.probeWebPosture(e.ctx)
+	}
+	// The event records the full picture, even when the enumeration context has expired
+	e.carryForward(context.Background())
+	close(stopReplay)
+	replayDone.Wait()
+	e.finishJournal()
+	e.finishAttempts()
+	e.bruteFb.report()
+	e.shard.report(e.Config.Log.Printf)
+	e.confidence.report(e.Config.Log)
+	e.tiers.report(e.Config.Log)
+	e.sources.report(e.Config.Log.Printf)
+	e.reportAuthoritative()
+	if e.Config.Verbose {
+		for src, n := range e.nameSrc.rejections() {
+			e.Config.Log.Printf("Rejected %d syntactically invalid names provided by %s", n, src)
+		}
+	}
 	return err
 }
 
+// Rejections returns the number of syntactically invalid names each source provided during the enumeration.
+func (e *Enumeration) Rejections() map[string]int {
+	if e.nameSrc == nil {
+		return nil
+	}
+	return e.nameSrc.rejections()
+}
+
+// blacklisted evaluates the compiled rules of the scope blacklist, and falls back to the blacklist
+// of the configuration for the enumerations that were not built by NewEnumeration.
+func (e *Enumeration) blacklisted(name string) bool {
+	if e.blacklist == nil {
+		return e.Config.Blacklisted(name)
+	}
+	return e.blacklist.Blacklisted(name)
+}
+
+// Snapshot returns the effective configuration recorded at the start of the enumeration, or nil when none was recorded.
+func (e *Enumeration) Snapshot() *snapshot.Snapshot {
+	return e.snapshot
+}
+
+// saveSnapshot records the effective configuration of the enumeration, so the results can be traced back to it.
+// The graph schema has no properties, so the snapshot is stored beside the graph, keyed by the event.
+func (e *Enumeration) saveSnapshot() {
+	if e.Snapshots == nil {
+		return
+	}
+
+	var names []string
+	for _, src := range e.srcs {
+		names = append(names, src.String())
+	}
+
+	snap, err := snapshot.New(e.Config, names)
+	if err != nil {
+		e.Config.Log.Printf("Failed to record the configuration snapshot: %v", err)
+		return
+	}
+	if err := e.Snapshots.Save(snap); err != nil {
+		e.Config.Log.Printf("Failed to store the configuration snapshot: %v", err)
+		return
+	}
+	e.snapshot = snap
+}
+
 // Release the root domain names to the input source and each data source.
 func (e *Enumeration) submitDomainNames() {
 	for _, domain := range e.Config.Domains() {
 		req := &requests.DNSRequest{
-			Name:   domain,
-			Domain: domain,
+			Name:       domain,
+			Domain:     domain,
+			Derivation: requests.DerivedFromSeed,
 		}
 
+		e.prov.add(domain, "", requests.DerivedFromSeed)
 		e.nameSrc.newName(req)
 		e.sendRequests(req.Clone().(*requests.DNSRequest))
 	}
@@ -139,6 +612,20 @@ func (e *Enumeration) manageDataSrcRequests() {
 
 	finished := make(chan string, len(e.srcs)*2)
 	requestsMap := make(map[string][]interface{})
+	dispatch := func(name string, src service.Service, element interface{}) {
+		if req, ok := e.routeRequest(src, element); ok && src.HandlesReq(req) {
+			if len(requestsMap[name]) == 0 && !pending[name] {
+				go e.fireRequest(src, req, finished)
+				pending[name] = true
+			} else {
+				requestsMap[name] = append(requestsMap[name], req)
+			}
+		}
+	}
+
+	// The requests are kept until the startup is done, so they can be replayed to the joining data sources
+	joined := e.joined
+	var history []interface{}
 loop:
 	for {
 		select {
@@ -146,23 +633,36 @@ loop:
 			break loop
 		case <-e.ctx.Done():
 			break loop
+		case src, ok := <-joined:
+			if !ok {
+				joined = nil
+				history = nil
+				continue loop
+			}
+
+			name := src.String()
+			nameToSrc[name] = src
+			pending[name] = false
+			for _, element := range history {
+				dispatch(name, src, element)
+			}
 		case <-e.requests.Signal():
 			element, ok := e.requests.Next()
 			if !ok {
 				continue loop
 			}
+			if joined != nil && len(history) < maxReplayedRequests {
+				history = append(history, element)
+			}
 
 			for name := range nameToSrc {
-				if src := nameToSrc[name]; src != nil && src.HandlesReq(element) {
-					if len(requestsMap[name]) == 0 && !pending[name] {
-						go e.fireRequest(src, element, finished)
-						pending[name] = true
-					} else {
-						requestsMap[name] = append(requestsMap[name], element)
-					}
+				if src := nameToSrc[name]; src != nil {
+					dispatch(name, src, element)
 				}
 			}
+			e.setRequestsPending(pending)
 		case name := <-finished:
+			e.completion.activity(name, false)
 			if len(requestsMap[name]) == 0 {
 				pending[name] = false
 				e.setRequestsPending(pending)
@@ -176,48 +676,199 @@ loop:
 	e.requests.Process(func(e interface{}) {})
 }
 
-func (e *Enumeration) requestsPending() bool {
-	e.plock.Lock()
-	defer e.plock.Unlock()
+// queriesSpent returns the number of DNS queries accounted for by the enumeration.
+func (e *Enumeration) queriesSpent() int64 {
+	return atomic.LoadInt64(&e.queries)
+}
+
+// spendQuery accounts for a DNS query and returns false once the query budget has been exhausted.
+func (e *Enumeration) spendQuery() bool {
+	n := atomic.AddInt64(&e.queries, 1)
 
-	return e.pending
+	if max := e.Budget.DNSQueries; max > 0 && n > max {
+		if n == max+1 {
+			e.Config.Log.Printf("The DNS query budget of %d queries has been exhausted", max)
+		}
+		return false
+	}
+	return true
 }
 
+// setRequestsPending records the data sources with requests outstanding.
 func (e *Enumeration) setRequestsPending(p map[string]bool) {
-	var pending bool
+	var pending []string
 
-	for _, b := range p {
+	for name, b := range p {
 		if b {
-			pending = true
-			break
+			pending = append(pending, name)
 		}
 	}
 
 	e.plock.Lock()
-	e.pending = pending
+	e.pendingSrcs = pending
 	e.plock.Unlock()
 }
 
+// watchLateSources adds the data sources started by the System after the enumeration began,
+// and returns once the System has started all of them.
+func (e *Enumeration) watchLateSources() {
+	defer close(e.joined)
+
+	known := make(map[string]struct{}, len(e.srcs))
+	for _, src := range e.srcs {
+		known[src.String()] = struct{}{}
+	}
+
+	for {
+		// The progress is read first, so the data sources added before the startup was done are not missed
+		done := e.Sys.StartupProgress().Done()
+
+		for _, src := range datasrcs.SelectedDataSources(e.Config, e.Sys.DataSources()) {
+			name := src.String()
+			if _, found := known[name]; found {
+				continue
+			}
+			known[name] = struct{}{}
+			e.plock.Lock()
+			e.lateSrcs = append(e.lateSrcs, src)
+			e.plock.Unlock()
+
+			ch := src.Output()
+			if ca, ok := src.(requests.ContextAware); ok && ca.SupportsContext() {
+				ch = e.job.AddSource(name)
+			}
+			go e.nameSrc.monitorDataSrcOutput(src, ch)
+
+			select {
+			case <-e.done:
+				return
+			case <-e.ctx.Done():
+				return
+			case e.joined <- src:
+			}
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-e.done:
+			return
+		case <-e.ctx.Done():
+			return
+		case <-e.clock.After(lateSourceInterval):
+		}
+	}
+}
+
 func (e *Enumeration) fireRequest(srv service.Service, req interface{}, finished chan string) {
 	select {
 	case <-e.done:
 	case <-e.ctx.Done():
 	case <-srv.Done():
-	case srv.Input() <- req:
+	case srv.Input() <- requests.WithContext(e.ctx, srv, req):
 	}
 	finished <- srv.String()
 }
 
 func (e *Enumeration) makeOutputSink() pipeline.SinkFunc {
 	return pipeline.SinkFunc(func(ctx context.Context, data pipeline.Data) error {
+		req, ok := data.(*requests.DNSRequest)
+		if !ok || req == nil || e.Output == nil {
+			return nil
+		}
+
+		out := requestToOutput(req)
+		out.Evidence = e.EvidenceHashes(req.Name)
+		if f := e.Finding(req.Name); f != nil {
+			if !f.FirstSeen.IsZero() {
+				out.FirstSeen = &f.FirstSeen
+			}
+			if !f.LastSeen.IsZero() {
+				out.LastSeen = &f.LastSeen
+			}
+		}
+		out.Historical = e.HistoricalAddresses(req.Name, out.Addresses)
+		e.Geo.Enrich(ctx, out.Addresses)
+		e.setInfrastructure(out)
+		// The suspicious findings are set apart for a review, instead of being sent with the results
+		if e.quarantineOutput(out) {
+			return nil
+		}
+		// The names scoring below the minimum confidence are held back until later sources corroborate them
+		if !e.confidence.emit(out, len(req.Records) > 0) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+		case e.Output <- out:
+		}
 		return nil
 	})
 }
 
-func (e *Enumeration) submitKnownNames() {
-	for _, g := range e.Sys.GraphDatabases() {
-		e.readNamesFromDatabase(g)
+// EvidenceHashes returns the hashes of the evidence stored for the FQDN, when the evidence store is enabled.
+func (e *Enumeration) EvidenceHashes(fqdn string) []string {
+	if e.Evidence == nil {
+		return nil
+	}
+	return e.Evidence.Hashes(fqdn)
+}
+
+// Finding returns the details of the FQDN merged from the findings of the data sources, or nil when none were provided.
+func (e *Enumeration) Finding(fqdn string) *requests.Finding {
+	if e.job == nil {
+		return nil
+	}
+	return e.job.Findings.Get(fqdn)
+}
+
+// HistoricalAddresses returns the periods the data sources observed the FQDN resolving to the addresses
+// missing from the current ones, so the addresses that no longer resolve are told apart.
+func (e *Enumeration) HistoricalAddresses(fqdn string, current []requests.AddressInfo) []requests.Resolution {
+	f := e.Finding(fqdn)
+	if f == nil {
+		return nil
+	}
+
+	var historical []requests.Resolution
+	for _, r := range f.Resolutions {
+		var found bool
+		for _, a := range current {
+			if a.Address != nil && a.Address.String() == r.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			historical = append(historical, r)
+		}
+	}
+	return historical
+}
+
+func requestToOutput(req *requests.DNSRequest) *requests.Output {
+	out := &requests.Output{
+		Name:       req.Name,
+		Domain:     req.Domain,
+		Parent:     req.Parent,
+		Derivation: req.Derivation,
 	}
+
+	for _, rec := range req.Records {
+		if t := uint16(rec.Type); t != dns.TypeA && t != dns.TypeAAAA {
+			continue
+		}
+		if ip := net.ParseIP(rec.Data); ip != nil {
+			out.Addresses = append(out.Addresses, requests.AddressInfo{Address: ip, Authoritative: rec.Authoritative})
+		}
+	}
+	return out
+}
+
+func (e *Enumeration) submitKnownNames() {
+	e.readNamesFromDatabase(e.graph)
 }
 
 func (e *Enumeration) readNamesFromDatabase(db *netmap.Graph) {
@@ -236,19 +887,32 @@ func (e *Enumeration) readNamesFromDatabase(db *netmap.Graph) {
 				}
 
 				domain := e.Config.WhichDomain(fqdn.Name)
-				if domain == "" {
+				// The names of the baseline event are carried forward by a delta enumeration
+				if domain == "" || e.delta.isSeeded(fqdn.Name) {
 					continue
 				}
 
 				e.nameSrc.newName(&requests.DNSRequest{
-					Name:   fqdn.Name,
-					Domain: domain,
+					Name:       fqdn.Name,
+					Domain:     domain,
+					Derivation: requests.DerivedFromGraph,
 				})
 			}
 		}
 	}
 }
 
+func (e *Enumeration) submitImportedNames() {
+	for _, req := range e.Imported {
+		select {
+		case <-e.done:
+			return
+		default:
+		}
+		e.nameSrc.newName(req)
+	}
+}
+
 func (e *Enumeration) submitProvidedNames() {
 	for _, name := range e.Config.ProvidedNames {
 		select {
@@ -256,10 +920,14 @@ func (e *Enumeration) submitProvidedNames() {
 			return
 		default:
 		}
+		if n, err := amassdns.NormalizeName(name); err == nil {
+			name = n
+		}
 		if domain := e.Config.WhichDomain(name); domain != "" {
 			e.nameSrc.newName(&requests.DNSRequest{
-				Name:   name,
-				Domain: domain,
+				Name:       name,
+				Domain:     domain,
+				Derivation: requests.DerivedFromProvided,
 			})
 		}
 	}
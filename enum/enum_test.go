@@ -0,0 +1,162 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/evidence"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func TestSpendQuery(t *testing.T) {
+	e := &Enumeration{
+		Config: config.NewConfig(),
+		Budget: Budget{DNSQueries: 3},
+	}
+
+	for i := 0; i < 3; i++ {
+		if !e.spendQuery() {
+			t.Errorf("Query %d was refused within the budget", i+1)
+		}
+	}
+	if e.spendQuery() {
+		t.Errorf("A query was allowed after the budget was exhausted")
+	}
+
+	unlimited := &Enumeration{Config: config.NewConfig()}
+	for i := 0; i < 100; i++ {
+		if !unlimited.spendQuery() {
+			t.Fatalf("Query %d was refused without a budget", i+1)
+		}
+	}
+}
+
+func TestRejections(t *testing.T) {
+	e := &Enumeration{Config: config.NewConfig()}
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+
+	for _, req := range []*requests.DNSRequest{
+		{Name: "\"www.owasp.org\",", Domain: "owasp.org", Derivation: requests.DerivedFromSource, Parent: "Scraper"},
+		{Name: "_dmarc.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromSource, Parent: "Scraper"},
+		{Name: "_sip._tcp.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromSource, Parent: "DNS SRV", Records: []requests.DNSAnswer{
+			{Name: "_sip._tcp.owasp.org", Type: int(dns.TypeSRV), Data: "sip.owasp.org"},
+		}},
+		{Name: "bad name.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromSource, Parent: "Scraper"},
+		{Name: "my_host.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromSource, Parent: "Scraper"},
+		{Name: "-www.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute},
+	} {
+		e.nameSrc.newName(req)
+	}
+
+	if n := e.nameSrc.queue.Len(); n != 2 {
+		t.Errorf("Accepted %d names, expected 2", n)
+	}
+	if r := e.Rejections(); r["Scraper"] != 3 || r[requests.DerivedFromBrute] != 1 {
+		t.Errorf("Rejections returned %v", r)
+	}
+}
+
+func TestOutputSink(t *testing.T) {
+	store, err := evidence.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := store.Add("www.owasp.org", "Crtsh", []byte(`{"common_name":"www.owasp.org"}`))
+
+	first := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	job := requests.NewJob("test", config.NewConfig(), nil)
+	job.Findings.Add(&requests.Finding{Name: "www.owasp.org", FirstSeen: first, Sources: []string{"Crtsh"}})
+	job.Findings.Add(&requests.Finding{
+		Name: "www.owasp.org",
+		Resolutions: []requests.Resolution{
+			{Address: "192.0.2.1", FirstSeen: first},
+			{Address: "198.51.100.7", FirstSeen: first, LastSeen: first.AddDate(0, 1, 0)},
+		},
+		Sources: []string{"DNSDB"},
+	})
+
+	e := &Enumeration{
+		Config:   config.NewConfig(),
+		Output:   make(chan *requests.Output, 1),
+		Evidence: store,
+		job:      job,
+	}
+	sink := e.makeOutputSink()
+
+	req := &requests.DNSRequest{
+		Name:   "www.owasp.org",
+		Domain: "owasp.org",
+		Records: []requests.DNSAnswer{
+			{Name: "www.owasp.org", Type: 5, Data: "owasp.github.io"},
+			{Name: "www.owasp.org", Type: 1, Data: "192.0.2.1"},
+			{Name: "www.owasp.org", Type: 28, Data: "2001:db8::1"},
+		},
+		Parent:     "owasp.org",
+		Derivation: requests.DerivedFromBrute,
+	}
+	if err := sink(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	out := <-e.Output
+	if out.Name != req.Name || out.Domain != req.Domain || out.Parent != req.Parent || out.Derivation != req.Derivation {
+		t.Errorf("the output %+v does not match the request", out)
+	}
+	if len(out.Addresses) != 2 || out.Addresses[0].Address.String() != "192.0.2.1" || out.Addresses[1].Address.String() != "2001:db8::1" {
+		t.Errorf("the output has the addresses %v", out.Addresses)
+	}
+	if len(out.Evidence) != 1 || out.Evidence[0] != hash {
+		t.Errorf("the output has the evidence %v, expected %s", out.Evidence, hash)
+	}
+	if out.FirstSeen == nil || !out.FirstSeen.Equal(first) || out.LastSeen != nil {
+		t.Errorf("the output was seen from %v to %v, expected from %s", out.FirstSeen, out.LastSeen, first)
+	}
+	// Only the addresses the name no longer resolves to are historical
+	if len(out.Historical) != 1 || out.Historical[0].Address != "198.51.100.7" {
+		t.Errorf("the output has the historical addresses %v", out.Historical)
+	}
+	// Data other than names are not sent
+	if err := sink(context.Background(), &requests.AddrRequest{Address: "192.0.2.1"}); err != nil || len(e.Output) != 0 {
+		t.Errorf("the sink sent an address request to the output")
+	}
+}
+
+func TestStartRejectsInvalidOptions(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.Options["dns"] = map[string]interface{}{"ttl_floor": 3600, "ttl_ceiling": 60}
+
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+	sys := &systems.SimpleSystem{Cfg: cfg, Graph: g, ASNCache: requests.NewASNCache()}
+	if err := sys.AddAndStart(newBenchSource(nil)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	e := NewEnumeration(cfg, sys, g)
+	var cerr *systems.ConfigError
+	if err := e.Start(context.Background()); !errors.As(err, &cerr) || cerr.Field != "dns.ttl_floor" {
+		t.Errorf("the enumeration started with the floor above the ceiling: %v", err)
+	}
+}
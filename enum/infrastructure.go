@@ -0,0 +1,147 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/cloud"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// InfrastructureFile is the name of the file under the output directory holding the provider hosting each name.
+const InfrastructureFile = "infrastructure.json"
+
+// NameInfrastructure is the provider and service hosting a name.
+type NameInfrastructure struct {
+	Name string `json:"name"`
+	cloud.Classification
+}
+
+// infraStore keeps the classification of each name, since the graph has no place for the properties.
+type infraStore struct {
+	sync.Mutex
+	names map[string]cloud.Classification
+}
+
+func newInfraStore() *infraStore {
+	return &infraStore{names: make(map[string]cloud.Classification)}
+}
+
+// set records the classification, without replacing a known provider with an unknown one.
+func (is *infraStore) set(name string, c cloud.Classification) {
+	name = strings.ToLower(name)
+
+	is.Lock()
+	defer is.Unlock()
+
+	if prev, found := is.names[name]; found && prev.Provider != cloud.Unknown && c.Provider == cloud.Unknown {
+		return
+	}
+	is.names[name] = c
+}
+
+func (is *infraStore) get(name string) (cloud.Classification, bool) {
+	if is == nil {
+		return cloud.Classification{}, false
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	c, found := is.names[strings.ToLower(name)]
+	return c, found
+}
+
+// classify records where the name is hosted, from the terminal target of its CNAME chain and
+// the addresses found among the records of the request.
+func (e *Enumeration) classify(req *requests.DNSRequest) {
+	if e.Cloud == nil || e.infra == nil {
+		return
+	}
+
+	target, addrs := terminalRecords(req.Name, req.Records)
+	if target == "" && len(addrs) == 0 {
+		return
+	}
+	e.infra.set(req.Name, e.Cloud.Classify(target, addrs))
+}
+
+// terminalRecords follows the CNAME chain of the name to its terminal target, which is empty when the name
+// has no CNAME record, and returns it along with the addresses among the records.
+func terminalRecords(name string, records []requests.DNSAnswer) (string, []net.IP) {
+	cnames := make(map[string]string)
+	var addrs []net.IP
+
+	for _, r := range records {
+		switch uint16(r.Type) {
+		case dns.TypeCNAME:
+			from := strings.Trim(strings.ToLower(r.Name), ".")
+			cnames[from] = strings.Trim(strings.ToLower(r.Data), ".")
+		case dns.TypeA, dns.TypeAAAA:
+			if ip := net.ParseIP(strings.TrimSpace(r.Data)); ip != nil {
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+
+	var target string
+	cur := strings.Trim(strings.ToLower(name), ".")
+	// The chain is bounded by the number of records, so a loop cannot hold it up
+	for i := 0; i < len(cnames); i++ {
+		next, found := cnames[cur]
+		if !found {
+			break
+		}
+		target, cur = next, next
+	}
+	return target, addrs
+}
+
+// Infrastructure returns the provider and service hosting the name, and false when it was not classified.
+func (e *Enumeration) Infrastructure(name string) (cloud.Classification, bool) {
+	return e.infra.get(name)
+}
+
+// AllInfrastructure returns the provider and service hosting each name classified during the enumeration.
+func (e *Enumeration) AllInfrastructure() []NameInfrastructure {
+	if e.infra == nil {
+		return nil
+	}
+
+	e.infra.Lock()
+	list := make([]NameInfrastructure, 0, len(e.infra.names))
+	for name, c := range e.infra.names {
+		list = append(list, NameInfrastructure{Name: name, Classification: c})
+	}
+	e.infra.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// InfrastructureCounts returns the number of names hosted by each provider, including the unknown ones.
+func (e *Enumeration) InfrastructureCounts() map[string]int {
+	counts := make(map[string]int)
+
+	for _, n := range e.AllInfrastructure() {
+		counts[n.Provider]++
+	}
+	return counts
+}
+
+// setInfrastructure adds the provider and service hosting the name to the output.
+func (e *Enumeration) setInfrastructure(out *requests.Output) {
+	if c, found := e.Infrastructure(out.Name); found {
+		out.Provider = c.Provider
+		out.Service = c.Service
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/cloud"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestClassify(t *testing.T) {
+	rules, err := cloud.FromConfig(config.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Enumeration{Config: config.NewConfig(), Cloud: rules, infra: newInfraStore()}
+
+	e.classify(&requests.DNSRequest{
+		Name: "www.owasp.org",
+		Records: []requests.DNSAnswer{
+			{Name: "www.owasp.org.", Type: int(dns.TypeCNAME), Data: "www.owasp.org.edgekey.net."},
+			{Name: "www.owasp.org.edgekey.net.", Type: int(dns.TypeCNAME), Data: "e1234.a.akamaiedge.net."},
+			{Name: "e1234.a.akamaiedge.net.", Type: int(dns.TypeA), Data: "192.0.2.10"},
+		},
+	})
+	e.classify(&requests.DNSRequest{
+		Name:    "vpn.owasp.org",
+		Records: []requests.DNSAnswer{{Name: "vpn.owasp.org", Type: int(dns.TypeA), Data: "192.0.2.20"}},
+	})
+	// The later unknown classification does not replace the provider already found
+	e.classify(&requests.DNSRequest{
+		Name:    "www.owasp.org",
+		Records: []requests.DNSAnswer{{Name: "www.owasp.org", Type: int(dns.TypeA), Data: "192.0.2.10"}},
+	})
+
+	if c, found := e.Infrastructure("www.owasp.org"); !found || c.Provider != "Akamai" || c.Evidence != "e1234.a.akamaiedge.net" {
+		t.Errorf("www.owasp.org was classified as %+v", c)
+	}
+	if counts := e.InfrastructureCounts(); counts["Akamai"] != 1 || counts[cloud.Unknown] != 1 {
+		t.Errorf("the counts are %v", counts)
+	}
+
+	out := &requests.Output{Name: "www.owasp.org"}
+	e.setInfrastructure(out)
+	if out.Provider != "Akamai" || out.Service != "CDN" {
+		t.Errorf("the output holds %s %s", out.Provider, out.Service)
+	}
+}
+
+func TestTerminalRecords(t *testing.T) {
+	// The CNAME loop ends once every record has been followed
+	target, addrs := terminalRecords("a.owasp.org", []requests.DNSAnswer{
+		{Name: "a.owasp.org", Type: int(dns.TypeCNAME), Data: "b.owasp.org"},
+		{Name: "b.owasp.org", Type: int(dns.TypeCNAME), Data: "a.owasp.org"},
+	})
+	if target == "" || len(addrs) != 0 {
+		t.Errorf("the loop returned %s and %v", target, addrs)
+	}
+
+	if target, _ := terminalRecords("www.owasp.org", nil); target != "" {
+		t.Errorf("the name without records returned the target %s", target)
+	}
+}
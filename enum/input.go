@@ -6,13 +6,18 @@ package enum
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/caffix/pipeline"
 	"github.com/caffix/queue"
 	"github.com/caffix/service"
+	"github.com/miekg/dns"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/policy"
 	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
 	bf "github.com/tylertreat/BoomFilters"
 )
 
@@ -28,6 +33,10 @@ type enumSource struct {
 	doneOnce sync.Once
 	release  chan struct{}
 	max      int
+	rlock    sync.Mutex
+	rejects  map[string]int
+	// shuffle randomizes the order the candidates are dispatched in OPSEC mode
+	shuffle *shuffler
 }
 
 // newEnumSource returns an initialized input source for the enumeration pipeline.
@@ -42,6 +51,7 @@ func newEnumSource(p *pipeline.Pipeline, e *Enumeration) *enumSource {
 		done:     make(chan struct{}),
 		release:  make(chan struct{}, size),
 		max:      size,
+		rejects:  make(map[string]int),
 	}
 	// Monitor the enumeration for completion or termination
 	go func() {
@@ -53,8 +63,17 @@ func newEnumSource(p *pipeline.Pipeline, e *Enumeration) *enumSource {
 		}
 	}()
 
+	if e.opsec != nil {
+		r.shuffle = &shuffler{rng: e.opsec.Rand("dispatch")}
+	}
+
 	for _, src := range e.srcs {
-		go r.monitorDataSrcOutput(src)
+		// Data sources aware of the request context deliver findings through the job
+		ch := src.Output()
+		if ca, ok := src.(requests.ContextAware); ok && ca.SupportsContext() {
+			ch = e.job.Output(src.String())
+		}
+		go r.monitorDataSrcOutput(src, ch)
 	}
 	for i := 0; i < size; i++ {
 		r.release <- struct{}{}
@@ -80,25 +99,119 @@ func (r *enumSource) newName(req *requests.DNSRequest) {
 		return
 	default:
 	}
+	r.enum.trace(req.Name, systems.TraceScope, "submitted", "source", findingSource(req), "domain", req.Domain)
 
-	if req.Name == "" || !req.Valid() {
+	// The custom filters of the System drop the names before anything else is done with them
+	if f, ok := r.enum.Sys.(systems.NameFilterer); ok {
+		if name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Name), ".")); !f.FilterName(name, findingSource(req)) {
+			r.enum.dispose(name, DispositionFiltered, "the name was dropped by a custom name filter")
+			r.releaseOutput(1)
+			return
+		}
+	}
+	// A wildcard entry of a certificate proves the zone exists, even when none of its names are known
+	if req.Derivation == requests.DerivedFromCert && amassdns.WildcardZone(req.Name) != "" {
+		r.enum.certZone(req)
+		r.releaseOutput(1)
+		return
+	}
+	// A name outside of the domains is delivered with the addresses it was found on, when they are in the address scope
+	if req.Domain == "" && len(req.Records) > 0 {
+		if r.enum.quarantineAdjacent(req) {
+			r.enum.dispose(req.Name, DispositionQuarantined, "the name is outside of the scope, under a domain adjacent to it")
+		} else if !r.enum.observeRequest(req) {
+			r.enum.dispose(req.Name, DispositionScope, "the name is outside of the domains in scope")
+		}
 		r.releaseOutput(1)
 		return
 	}
 	// Clean up the newly discovered name and domain
+	req.Name = amassdns.RepairName(req.Name)
 	requests.SanitizeDNSRequest(req)
 
-	if r.enum.Config.Blacklisted(req.Name) {
+	// Service labels, such as _sip._tcp, are only accepted with the SRV records answering the name
+	if req.Name == "" || !req.Valid() || amassdns.ValidateName(req.Name, hasSRVRecord(req)) != nil {
+		r.reject(req)
+		r.enum.dispose(req.Name, DispositionInvalid, "the name is not syntactically valid")
+		r.releaseOutput(1)
+		return
+	}
+	// The never-touch list is consulted before the scope, so the names it holds are never resolved
+	if r.enum.Policy.BlocksName(policy.DNS, req.Name) {
+		r.enum.dispose(req.Name, DispositionPolicy, "the name is on the never-touch list")
 		r.releaseOutput(1)
 		return
 	}
-	if !r.accept(req.Name) {
+	if r.enum.blacklisted(req.Name) {
+		r.enum.dispose(req.Name, DispositionScope, "the name is blacklisted")
 		r.releaseOutput(1)
 		return
 	}
+	// The generated candidates are only queried by the shard they are assigned to
+	if generatedCandidate(req) && !r.enum.shard.owns(req.Name) {
+		r.enum.shard.skip()
+		r.enum.dispose(req.Name, DispositionSharded, "the candidate is assigned to another shard")
+		r.releaseOutput(1)
+		return
+	}
+	// The generated candidates that failed during an earlier enumeration wait for their retry age
+	if r.enum.attemptedBefore(req) {
+		r.enum.dispose(req.Name, DispositionAttempted, "the candidate failed to resolve during an earlier enumeration")
+		r.releaseOutput(1)
+		return
+	}
+	// Every source providing the name corroborates it, including those providing it after the first
+	r.enum.confidence.corroborate(req)
+	if !r.acceptName(req.Name, req.Domain) {
+		r.enum.dispose(req.Name, DispositionDeduped, "the name was already submitted")
+		r.releaseOutput(1)
+		return
+	}
+	r.enum.completion.activity(findingSource(req), true)
+	r.enum.trace(req.Name, systems.TraceScope, "queued for resolution", "domain", req.Domain)
 	r.queue.Append(req)
 }
 
+// findingSource returns the component that provided the name, such as the data source or the brute forcing.
+func findingSource(req *requests.DNSRequest) string {
+	if req.Derivation == requests.DerivedFromSource && req.Parent != "" {
+		return req.Parent
+	}
+	return req.Derivation
+}
+
+// hasSRVRecord returns true when the request carries an SRV record, which is the only way
+// for a name with service labels to enter the enumeration, since such names are never host candidates.
+func hasSRVRecord(req *requests.DNSRequest) bool {
+	for _, rec := range req.Records {
+		if uint16(rec.Type) == dns.TypeSRV {
+			return true
+		}
+	}
+	return false
+}
+
+// reject counts the invalid name against the source that provided it.
+func (r *enumSource) reject(req *requests.DNSRequest) {
+	src := findingSource(req)
+
+	r.rlock.Lock()
+	r.rejects[src]++
+	r.rlock.Unlock()
+}
+
+// rejections returns the number of invalid names provided by each source.
+func (r *enumSource) rejections() map[string]int {
+	r.rlock.Lock()
+	defer r.rlock.Unlock()
+
+	counts := make(map[string]int, len(r.rejects))
+	for src, n := range r.rejects {
+		counts[src] = n
+	}
+	return counts
+}
+
 func (r *enumSource) newAddr(req *requests.AddrRequest) {
 	select {
 	case <-r.done:
@@ -106,7 +219,8 @@ func (r *enumSource) newAddr(req *requests.AddrRequest) {
 	default:
 	}
 
-	if req.Valid() && req.InScope && r.accept(req.Address) {
+	// The addresses on the never-touch list are kept away from the sweeps, even when the scope includes them
+	if req.Valid() && !r.enum.Policy.BlocksAddress(policy.DNS, req.Address) && req.InScope && r.accept(req.Address) {
 		r.queue.Append(req)
 	}
 }
@@ -115,12 +229,26 @@ func (r *enumSource) accept(s string) bool {
 	return !r.filter.TestAndAdd([]byte(s))
 }
 
+// acceptName returns true when the name was not submitted before, using the filter of its domain
+// when the enumeration keeps a working set for each domain.
+func (r *enumSource) acceptName(name, domain string) bool {
+	if ws := r.enum.working; ws != nil && domain != "" {
+		return ws.submit(name, domain, true)
+	}
+	return r.accept(name)
+}
+
 // Next implements the pipeline InputSource interface.
 func (r *enumSource) Next(ctx context.Context) bool {
+	// The dispatch slows down while the graph database falls behind the writes
+	r.enum.pressure.wait(ctx, r.done)
 	// Low if below 75%
 	if p := (float32(r.queue.Len()) / float32(r.max)) * 100; p < 75 {
 		r.fillQueue()
 	}
+	if r.shuffle.pending() {
+		return true
+	}
 
 	t := time.NewTimer(waitForDuration)
 	defer t.Stop()
@@ -133,12 +261,9 @@ func (r *enumSource) Next(ctx context.Context) bool {
 			r.markDone()
 			return false
 		case <-t.C:
-			count := r.pipeline.DataItemCount()
-			if !r.enum.requestsPending() && count <= 0 {
-				if r.enum.store.queue.Len() == 0 {
-					r.markDone()
-					return false
-				}
+			if r.checkCompletion() {
+				r.markDone()
+				return false
 			}
 			r.fillQueue()
 			t.Reset(waitForDuration)
@@ -152,18 +277,35 @@ func (r *enumSource) Next(ctx context.Context) bool {
 func (r *enumSource) Data() pipeline.Data {
 	var data pipeline.Data
 
+	if r.shuffle != nil {
+		return r.shuffle.next(r.nextQueued)
+	}
 	if element, ok := r.queue.Next(); ok {
 		data = element.(pipeline.Data)
 	}
 	return data
 }
 
+func (r *enumSource) nextQueued() (pipeline.Data, bool) {
+	element, ok := r.queue.Next()
+	if !ok {
+		return nil, false
+	}
+
+	data, ok := element.(pipeline.Data)
+	return data, ok
+}
+
 // Error implements the pipeline InputSource interface.
 func (r *enumSource) Error() error {
 	return nil
 }
 
 func (r *enumSource) fillQueue() {
+	// The data sources and brute forcing wait for the release while the queue holds too much memory
+	if r.enum.memoryPressure(MemoryScheduler) {
+		return
+	}
 	if unfilled := r.max - r.queue.Len(); unfilled > 0 {
 		if fill := unfilled - len(r.release); fill > 0 {
 			r.releaseOutput(fill)
@@ -182,14 +324,16 @@ loop:
 	}
 }
 
-func (r *enumSource) monitorDataSrcOutput(srv service.Service) {
+func (r *enumSource) monitorDataSrcOutput(srv service.Service, ch chan interface{}) {
+	r.enum.confidence.register(srv)
+
 	for {
 		select {
 		case <-r.done:
 			return
 		case <-srv.Done():
 			return
-		case in := <-srv.Output():
+		case in := <-ch:
 			select {
 			case <-r.done:
 				return
@@ -200,6 +344,10 @@ func (r *enumSource) monitorDataSrcOutput(srv service.Service) {
 
 			switch req := in.(type) {
 			case *requests.DNSRequest:
+				if req.Derivation == "" {
+					req.Parent = srv.String()
+					req.Derivation = requests.DerivedFromSource
+				}
 				r.newName(req)
 			case *requests.AddrRequest:
 				r.newAddr(req)
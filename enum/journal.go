@@ -0,0 +1,103 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/owasp-amass/amass/v4/journal"
+)
+
+// journalWrite keeps the write of the graph in the journal when it failed, so it is replayed once the
+// graph database can be reached again.
+func (e *Enumeration) journalWrite(rec journal.Record, err error) {
+	if err == nil || e.Journal == nil {
+		return
+	}
+	if jerr := e.Journal.Append(rec); jerr != nil {
+		e.Config.Log.Printf("Failed to journal the write of %s: %v", rec.Name, jerr)
+	}
+}
+
+// journalInfrastructure keeps the infrastructure of the address in the journal when writing it failed.
+func (e *Enumeration) journalInfrastructure(asn int, desc, addr, cidr string, err error) {
+	e.journalWrite(journal.Record{
+		Op:   journal.OpInfrastructure,
+		Name: addr,
+		ASN:  asn,
+		Desc: desc,
+		CIDR: cidr,
+	}, err)
+}
+
+// addrOp returns the journal operation writing the address record of the name.
+func addrOp(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		return journal.OpAAAA
+	}
+	return journal.OpA
+}
+
+// replayJournal attempts to replay the journal into the graph at the configured interval, until stopped.
+func (e *Enumeration) replayJournal(stop chan struct{}) {
+	interval := journal.DefaultInterval
+	if jcfg := journal.ConfigFromOptions(e.Config); jcfg != nil {
+		interval = jcfg.Interval
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-e.ctx.Done():
+			return
+		case <-e.clock.After(interval):
+		}
+
+		if e.Journal.Len() > 0 {
+			res, err := e.Journal.Replay(e.ctx, e.graph)
+			e.logReplay(res, err)
+		}
+	}
+}
+
+// finishJournal replays the journal once the findings have been stored, and reports the records left in it.
+func (e *Enumeration) finishJournal() {
+	if e.Journal == nil {
+		return
+	}
+
+	if e.Journal.Len() > 0 {
+		// The enumeration context may have expired, while the findings are still worth writing
+		res, err := e.Journal.Replay(context.Background(), e.graph)
+		e.logReplay(res, err)
+	}
+
+	stats := e.Journal.Stats()
+	if stats.Dropped > 0 {
+		e.Config.Log.Printf("Dropped the %d oldest records of the graph journal to keep it within its size", stats.Dropped)
+	}
+	if stats.Records > 0 {
+		e.Config.Log.Printf("%d writes remain in the graph journal %s, and are replayed by 'amass import -replay'",
+			stats.Records, e.Journal.Path())
+	}
+}
+
+func (e *Enumeration) logReplay(res *journal.Result, err error) {
+	if res != nil {
+		if res.Replayed > 0 || res.Existing > 0 || res.Rejected > 0 {
+			e.Config.Log.Printf("Replayed %d journaled writes into the graph database, %d were already present and %d were refused",
+				res.Replayed, res.Existing, res.Rejected)
+		}
+		if res.Corrupt > 0 {
+			e.Config.Log.Printf("Skipped %d corrupt records of the graph journal at lines %v", res.Corrupt, res.CorruptLines)
+		}
+	}
+	if err != nil && (!errors.Is(err, journal.ErrUnreachable) || e.Config.Verbose) {
+		e.Config.Log.Printf("Failed to replay the graph journal: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/journal"
+	"github.com/owasp-amass/amass/v4/requests"
+	assetdb "github.com/owasp-amass/asset-db"
+	"github.com/owasp-amass/asset-db/repository"
+	"github.com/owasp-amass/config/config"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func TestJournalFailedWrites(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "amass.sqlite")
+	if g := netmap.NewGraph("local", path, ""); g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	// The graph refuses the writes, like a remote database that went down
+	down := &netmap.Graph{DB: assetdb.New(repository.SQLite, "file:"+path+"?mode=ro")}
+
+	j, err := journal.Open(filepath.Join(dir, journal.FileName), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = j.Close() }()
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	e := &Enumeration{Config: cfg, graph: down, prov: newProvenanceGraph(), Journal: j}
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+	dm := &dataManager{enum: e}
+
+	req := &requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+		{Name: "www.owasp.org", Type: int(dns.TypeA), Data: "192.0.2.1"},
+		{Name: "www.owasp.org", Type: int(dns.TypeAAAA), Data: "2001:db8::1"},
+	}}
+	if err := dm.dnsRequest(context.Background(), req, nil); err == nil {
+		t.Fatal("the records were stored in the read-only graph")
+	}
+	if n := j.Len(); n != 2 {
+		t.Fatalf("the journal holds %d records, expected 2", n)
+	}
+
+	// The graph is back, and receives the journaled records
+	e.graph = netmap.NewGraph("local", path, "")
+	e.finishJournal()
+	if n := j.Len(); n != 0 {
+		t.Errorf("the journal holds %d records after the replay", n)
+	}
+	pairs, err := e.graph.NamesToAddrs(context.Background(), time.Time{}, "www.owasp.org")
+	if err != nil || len(pairs) != 2 {
+		t.Errorf("the graph holds the addresses %v, %v", pairs, err)
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/journal"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+	"github.com/owasp-amass/resolve"
+)
+
+// DefaultDKIMSelectors are the DKIM selectors checked for each domain when none have been configured.
+var DefaultDKIMSelectors = []string{
+	"default", "dkim", "mail", "smtp", "email", "k1", "k2", "k3", "s1", "s2",
+	"selector1", "selector2", "google", "mandrill", "mailjet", "mxvault", "zoho",
+	"sendgrid", "smtpapi", "amazonses", "everlytickey1", "everlytickey2", "fm1",
+	"fm2", "fm3", "protonmail", "protonmail2", "protonmail3", "sig1", "mailchimp",
+}
+
+// MailHost is a mail exchanger of a domain along with its addresses.
+type MailHost struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// DKIMSelector is a DKIM selector that publishes a key for a domain.
+type DKIMSelector struct {
+	Selector string `json:"selector"`
+	Name     string `json:"name"`
+	Record   string `json:"record,omitempty"`
+}
+
+// MailSummary describes the mail flow of a domain. The graph has no place for the policy strings,
+// so they are only provided by enumerations that mapped the domain.
+type MailSummary struct {
+	Domain      string         `json:"domain"`
+	MX          []MailHost     `json:"mx,omitempty"`
+	SPF         string         `json:"spf,omitempty"`
+	SPFSenders  []string       `json:"spf_senders,omitempty"`
+	DKIM        []DKIMSelector `json:"dkim,omitempty"`
+	DMARC       string         `json:"dmarc,omitempty"`
+	DMARCPolicy string         `json:"dmarc_policy,omitempty"`
+}
+
+type mailQueryFunc func(ctx context.Context, name string, qtype uint16) ([]requests.DNSAnswer, error)
+
+// mailMapper performs the DNS queries that map the mail infrastructure of the in scope domains.
+type mailMapper struct {
+	sync.Mutex
+	graph     *netmap.Graph
+	stored    *storedTypes
+	journal   *journal.Journal
+	selectors []string
+	query     mailQueryFunc
+	summaries map[string]*MailSummary
+	rng       *rand.Rand
+}
+
+func newMailMapper(e *Enumeration) *mailMapper {
+	return &mailMapper{
+		graph:     e.graph,
+		stored:    e.stored,
+		selectors: dkimSelectorsFromConfig(e.Config),
+		query:     e.mailQuery,
+		summaries: make(map[string]*MailSummary),
+		rng:       e.seed.Rand("mail"),
+	}
+}
+
+// dkimSelectorsFromConfig returns the 'mail.dkim_selectors' configuration option or the default selectors.
+func dkimSelectorsFromConfig(cfg *config.Config) []string {
+	var opts map[string]interface{}
+	if cfg != nil && cfg.Options != nil {
+		opts, _ = cfg.Options["mail"].(map[string]interface{})
+	}
+
+	var selectors []string
+	for _, s := range stringList(opts["dkim_selectors"]) {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			selectors = append(selectors, s)
+		}
+	}
+	if len(selectors) == 0 {
+		return DefaultDKIMSelectors
+	}
+	return selectors
+}
+
+// mapDomains maps the mail infrastructure of each domain until the context is cancelled.
+func (m *mailMapper) mapDomains(ctx context.Context, domains []string) {
+	for _, d := range domains {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		s := m.mapDomain(ctx, d)
+		m.Lock()
+		m.summaries[s.Domain] = s
+		m.Unlock()
+	}
+}
+
+func (m *mailMapper) summary(d string) *MailSummary {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.summaries[strings.ToLower(d)]
+}
+
+func (m *mailMapper) mapDomain(ctx context.Context, d string) *MailSummary {
+	d = strings.ToLower(d)
+	s := &MailSummary{Domain: d}
+
+	ans, _ := m.query(ctx, d, dns.TypeMX)
+	for _, a := range ans {
+		host := strings.ToLower(resolve.RemoveLastDot(a.Data))
+		if host == "" {
+			continue
+		}
+		if m.stored.store(ctx, m.graph, d, dns.TypeMX) {
+			if err := m.graph.UpsertMX(ctx, d, host); err != nil {
+				_ = m.journal.Append(journal.Record{Op: journal.OpMX, Name: d, Target: host})
+				continue
+			}
+		}
+
+		mh := MailHost{Name: host}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			addrs, _ := m.query(ctx, host, qtype)
+			for _, addr := range addrs {
+				// The address is still part of the summary when it is not stored
+				if !m.stored.store(ctx, m.graph, host, qtype) {
+					mh.Addresses = append(mh.Addresses, addr.Data)
+					continue
+				}
+
+				var err error
+				if qtype == dns.TypeA {
+					err = m.graph.UpsertA(ctx, host, addr.Data)
+				} else {
+					err = m.graph.UpsertAAAA(ctx, host, addr.Data)
+				}
+				if err != nil {
+					_ = m.journal.Append(journal.Record{Op: addrOp(addr.Data), Name: host, Target: addr.Data})
+				} else {
+					mh.Addresses = append(mh.Addresses, addr.Data)
+				}
+			}
+		}
+		s.MX = append(s.MX, mh)
+	}
+
+	if rec := m.txtRecord(ctx, d, "v=spf1"); rec != "" {
+		s.SPF = rec
+		s.SPFSenders = spfSenders(rec)
+	}
+
+	dmarc := "_dmarc." + d
+	if rec := m.txtRecord(ctx, dmarc, "v=dmarc1"); rec != "" {
+		s.DMARC = rec
+		s.DMARCPolicy = tagValue(rec, "p")
+		_ = m.upsertNode(ctx, d, dmarc)
+	}
+	// A zone answering for any selector would make every guess a false positive
+	probe := "amass" + random.UnlikelyName(m.rng, "_domainkey."+d)
+	if ans, err := m.query(ctx, probe, dns.TypeTXT); err == nil && len(ans) > 0 {
+		return s
+	}
+	for _, sel := range m.selectors {
+		select {
+		case <-ctx.Done():
+			return s
+		default:
+		}
+
+		name := sel + "._domainkey." + d
+		if rec := m.dkimRecord(ctx, name); rec != "" {
+			s.DKIM = append(s.DKIM, DKIMSelector{Selector: sel, Name: name, Record: rec})
+			_ = m.upsertNode(ctx, d, name)
+		}
+	}
+	return s
+}
+
+// txtRecord returns the first TXT record of the name starting with the prefix.
+func (m *mailMapper) txtRecord(ctx context.Context, name, prefix string) string {
+	ans, err := m.query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return ""
+	}
+
+	for _, a := range ans {
+		if rec := strings.TrimSpace(a.Data); strings.HasPrefix(strings.ToLower(rec), prefix) {
+			return rec
+		}
+	}
+	return ""
+}
+
+func (m *mailMapper) dkimRecord(ctx context.Context, name string) string {
+	ans, err := m.query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return ""
+	}
+
+	for _, a := range ans {
+		rec := strings.TrimSpace(a.Data)
+		if strings.HasPrefix(strings.ToLower(rec), "v=dkim1") || tagValue(rec, "p") != "" {
+			return rec
+		}
+	}
+	return ""
+}
+
+// upsertNode connects the name to the domain, since the graph has no relation for the mail policy records.
+func (m *mailMapper) upsertNode(ctx context.Context, d, name string) error {
+	parent, err := m.graph.UpsertFQDN(ctx, d)
+	if err != nil {
+		_ = m.journal.Append(journal.Record{Op: journal.OpNode, Name: d, Target: name})
+		return err
+	}
+
+	if _, err = m.graph.DB.Create(parent, "node", &domain.FQDN{Name: name}); err != nil {
+		_ = m.journal.Append(journal.Record{Op: journal.OpNode, Name: d, Target: name})
+	}
+	return err
+}
+
+func (e *Enumeration) mailQuery(ctx context.Context, name string, qtype uint16) ([]requests.DNSAnswer, error) {
+	resp, err := e.dnsQuery(ctx, name, qtype, e.trustedPool(), maxDNSQueryAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, errors.New("query failed")
+	}
+	return convertAnswers(resolve.AnswersByType(extractAnswers(resp), qtype)), nil
+}
+
+// MailSummary returns the mail infrastructure of the domain stored in the graph, along with
+// the policy strings obtained when this enumeration mapped the domain.
+func (e *Enumeration) MailSummary(ctx context.Context, d string) (*MailSummary, error) {
+	s, err := MailSummaryFromGraph(ctx, e.graph, d, e.Config.CollectionStartTime)
+	if err != nil || e.mail == nil {
+		return s, err
+	}
+
+	if mapped := e.mail.summary(d); mapped != nil {
+		s.SPF = mapped.SPF
+		s.SPFSenders = mapped.SPFSenders
+		s.DMARC = mapped.DMARC
+		s.DMARCPolicy = mapped.DMARCPolicy
+		for i, sel := range s.DKIM {
+			for _, m := range mapped.DKIM {
+				if m.Name == sel.Name {
+					s.DKIM[i].Record = m.Record
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// MailSummaryFromGraph returns the mail exchangers, their addresses and the DKIM selectors
+// of the domain that have been seen since the provided time.
+func MailSummaryFromGraph(ctx context.Context, g *netmap.Graph, d string, since time.Time) (*MailSummary, error) {
+	if g == nil || g.DB == nil {
+		return nil, errors.New("MailSummaryFromGraph: the graph has not been initialized")
+	}
+
+	if !since.IsZero() {
+		since = since.UTC()
+	}
+	d = strings.ToLower(d)
+	assets, err := g.DB.FindByContent(&domain.FQDN{Name: d}, since)
+	if err != nil || len(assets) == 0 {
+		return nil, fmt.Errorf("MailSummaryFromGraph: the domain %s was not found", d)
+	}
+
+	s := &MailSummary{Domain: d}
+	if rels, err := g.DB.OutgoingRelations(assets[0], since, "mx_record"); err == nil {
+		for _, rel := range rels {
+			to, err := g.DB.FindById(rel.ToAsset.ID, since)
+			if err != nil {
+				continue
+			}
+			if fqdn, ok := to.Asset.(domain.FQDN); ok {
+				s.MX = append(s.MX, MailHost{
+					Name:      fqdn.Name,
+					Addresses: hostAddresses(g, to.ID, since),
+				})
+			}
+		}
+	}
+
+	if rels, err := g.DB.OutgoingRelations(assets[0], since, "node"); err == nil {
+		for _, rel := range rels {
+			select {
+			case <-ctx.Done():
+				return s, ctx.Err()
+			default:
+			}
+
+			to, err := g.DB.FindById(rel.ToAsset.ID, since)
+			if err != nil {
+				continue
+			}
+			if fqdn, ok := to.Asset.(domain.FQDN); ok {
+				if sel := strings.TrimSuffix(fqdn.Name, "._domainkey."+d); sel != fqdn.Name {
+					s.DKIM = append(s.DKIM, DKIMSelector{Selector: sel, Name: fqdn.Name})
+				}
+			}
+		}
+	}
+
+	sort.Slice(s.MX, func(i, j int) bool { return s.MX[i].Name < s.MX[j].Name })
+	sort.Slice(s.DKIM, func(i, j int) bool { return s.DKIM[i].Name < s.DKIM[j].Name })
+	return s, nil
+}
+
+func hostAddresses(g *netmap.Graph, id string, since time.Time) []string {
+	host, err := g.DB.FindById(id, since)
+	if err != nil {
+		return nil
+	}
+
+	rels, err := g.DB.OutgoingRelations(host, since, "a_record", "aaaa_record")
+	if err != nil {
+		return nil
+	}
+
+	var addrs []string
+	for _, rel := range rels {
+		if to, err := g.DB.FindById(rel.ToAsset.ID, since); err == nil {
+			if ip, ok := to.Asset.(network.IPAddress); ok {
+				addrs = append(addrs, ip.Address.String())
+			}
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// spfSenders returns the mechanisms of the SPF record that authorize senders, without their qualifiers.
+func spfSenders(rec string) []string {
+	var senders []string
+
+	for _, term := range strings.Fields(rec)[1:] {
+		term = strings.ToLower(term)
+
+		switch term[0] {
+		case '-', '~', '?':
+			continue
+		case '+':
+			term = term[1:]
+		}
+		if term == "" {
+			continue
+		}
+
+		mech := term
+		if i := strings.IndexAny(term, ":=/"); i >= 0 {
+			mech = term[:i]
+		}
+		switch mech {
+		case "a", "mx", "ip4", "ip6", "include", "exists", "ptr", "redirect", "all":
+			senders = append(senders, term)
+		}
+	}
+	return senders
+}
+
+// tagValue returns the value of the tag in a record made of semicolon separated tag=value pairs.
+func tagValue(rec, tag string) string {
+	for _, pair := range strings.Split(rec, ";") {
+		if kv := strings.SplitN(strings.TrimSpace(pair), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], tag) {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+type fakeMailDNS map[string][]string
+
+func (f fakeMailDNS) query(ctx context.Context, name string, qtype uint16) ([]requests.DNSAnswer, error) {
+	var answers []requests.DNSAnswer
+
+	for _, data := range f[name+"|"+dns.TypeToString[qtype]] {
+		answers = append(answers, requests.DNSAnswer{Name: name, Type: int(qtype), Data: data})
+	}
+	if len(answers) == 0 {
+		return nil, errors.New("no record of this type")
+	}
+	return answers, nil
+}
+
+func TestMapMailDomain(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	fake := fakeMailDNS{
+		"owasp.org|MX":                        {"aspmx.l.google.com", "alt1.aspmx.l.google.com"},
+		"aspmx.l.google.com|A":                {"142.250.27.26"},
+		"aspmx.l.google.com|AAAA":             {"2a00:1450:4025:401::1a"},
+		"alt1.aspmx.l.google.com|A":           {"142.250.153.26"},
+		"owasp.org|TXT":                       {"google-site-verification=abc", "v=spf1 include:_spf.google.com ip4:192.0.2.0/24 -ip4:192.0.2.1 ~all"},
+		"_dmarc.owasp.org|TXT":                {"v=DMARC1; p=quarantine; rua=mailto:dmarc@owasp.org"},
+		"google._domainkey.owasp.org|TXT":     {"v=DKIM1; k=rsa; p=MIIBIjANBgkq"},
+		"selector1._domainkey.owasp.org|TXT":  {"k=rsa; p=MIGfMA0GCSqG"},
+		"unrelated._domainkey.owasp.org|TXT":  {"not a key"},
+		"selector2._domainkey.owasp.org|AAAA": {"2001:db8::1"},
+	}
+
+	m := &mailMapper{
+		graph:     g,
+		selectors: []string{"google", "selector1", "selector2", "unrelated"},
+		query:     fake.query,
+		summaries: make(map[string]*MailSummary),
+		rng:       random.New(1).Rand("mail"),
+	}
+	start := time.Now().Add(-time.Minute)
+	m.mapDomains(context.Background(), []string{"OWASP.org"})
+
+	s := m.summary("owasp.org")
+	if s == nil {
+		t.Fatal("The domain was not mapped")
+	}
+	if len(s.MX) != 2 || s.MX[0].Name != "aspmx.l.google.com" || len(s.MX[0].Addresses) != 2 {
+		t.Errorf("The mail exchangers were %+v", s.MX)
+	}
+	if !strings.HasPrefix(s.SPF, "v=spf1") {
+		t.Errorf("The SPF record was %q", s.SPF)
+	}
+	if senders := []string{"include:_spf.google.com", "ip4:192.0.2.0/24"}; !reflect.DeepEqual(s.SPFSenders, senders) {
+		t.Errorf("The SPF senders were %v, expected %v", s.SPFSenders, senders)
+	}
+	if s.DMARCPolicy != "quarantine" {
+		t.Errorf("The DMARC policy was %q", s.DMARCPolicy)
+	}
+	if len(s.DKIM) != 2 || s.DKIM[0].Selector != "google" || s.DKIM[1].Selector != "selector1" {
+		t.Errorf("The DKIM selectors were %+v", s.DKIM)
+	}
+
+	stored, err := MailSummaryFromGraph(context.Background(), g, "owasp.org", start)
+	if err != nil {
+		t.Fatalf("MailSummaryFromGraph failed: %v", err)
+	}
+	if len(stored.MX) != 2 || stored.MX[0].Name != "alt1.aspmx.l.google.com" {
+		t.Errorf("The stored mail exchangers were %+v", stored.MX)
+	}
+	if addrs := []string{"142.250.27.26", "2a00:1450:4025:401::1a"}; !reflect.DeepEqual(stored.MX[1].Addresses, addrs) {
+		t.Errorf("The stored addresses were %v, expected %v", stored.MX[1].Addresses, addrs)
+	}
+	if len(stored.DKIM) != 2 || stored.DKIM[0].Name != "google._domainkey.owasp.org" || stored.DKIM[0].Record != "" {
+		t.Errorf("The stored DKIM selectors were %+v", stored.DKIM)
+	}
+}
+
+func TestMapMailWildcardSelectors(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	m := &mailMapper{
+		graph:     g,
+		selectors: []string{"google"},
+		query: func(ctx context.Context, name string, qtype uint16) ([]requests.DNSAnswer, error) {
+			if strings.HasSuffix(name, "._domainkey.owasp.org") {
+				return []requests.DNSAnswer{{Name: name, Type: int(qtype), Data: "v=DKIM1; p=abc"}}, nil
+			}
+			return nil, errors.New("no record of this type")
+		},
+		summaries: make(map[string]*MailSummary),
+		rng:       random.New(1).Rand("mail"),
+	}
+
+	if s := m.mapDomain(context.Background(), "owasp.org"); len(s.DKIM) != 0 {
+		t.Errorf("The selectors of a wildcard zone were reported: %+v", s.DKIM)
+	}
+}
+
+func TestSPFSenders(t *testing.T) {
+	tests := []struct {
+		record   string
+		expected []string
+	}{
+		{"v=spf1 -all", nil},
+		{"v=spf1 a mx +ip6:2001:db8::/32 ?include:maybe.example.com -all", []string{"a", "mx", "ip6:2001:db8::/32"}},
+		{"v=spf1 a:mail.owasp.org mx/24 exists:%{i}.spf.owasp.org redirect=_spf.owasp.org", []string{"a:mail.owasp.org", "mx/24", "exists:%{i}.spf.owasp.org", "redirect=_spf.owasp.org"}},
+		{"v=spf1 exp=explain.owasp.org +all", []string{"all"}},
+	}
+
+	for _, test := range tests {
+		if got := spfSenders(test.record); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("spfSenders(%q) returned %v, expected %v", test.record, got, test.expected)
+		}
+	}
+}
+
+func TestTagValue(t *testing.T) {
+	rec := "v=DMARC1; P=reject ; sp=none; rua=mailto:a@owasp.org"
+
+	if v := tagValue(rec, "p"); v != "reject" {
+		t.Errorf("The p tag was %q", v)
+	}
+	if v := tagValue(rec, "sp"); v != "none" {
+		t.Errorf("The sp tag was %q", v)
+	}
+	if v := tagValue(rec, "pct"); v != "" {
+		t.Errorf("The missing pct tag was %q", v)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/memory"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultMemoryInterval is the period between the samples of the memory consumption.
+const DefaultMemoryInterval = 5 * time.Second
+
+// The subsystems of the enumeration registered with the memory monitor.
+const (
+	// MemoryScheduler is the queue of candidate names and the requests waiting for the data sources
+	MemoryScheduler = "scheduler"
+	// MemoryGraph is the buffer of the addresses waiting for their infrastructure to be written to the graph,
+	// and the graph itself when it is kept in memory
+	MemoryGraph = "graph"
+	// MemoryDedupe is the filters of the names and addresses already submitted, including those of each domain
+	MemoryDedupe = "dedupe"
+)
+
+// requestSize is the estimated number of bytes held by a queued request.
+const requestSize = 512
+
+// memoryMonitorFromConfig parses the 'memory' configuration options, and returns
+// a nil Monitor when no limit has been set, which leaves the subsystems unthrottled.
+// A Monitor without the soft limit only samples the consumption for the hard limit.
+func memoryMonitorFromConfig(cfg *config.Config, total func() uint64) (*memory.Monitor, time.Duration) {
+	if cfg == nil {
+		return nil, 0
+	}
+
+	opts, ok := cfg.Options["memory"].(map[string]interface{})
+	if !ok {
+		return nil, 0
+	}
+
+	limit := options.Int(opts["limit"])
+	if limit < 0 {
+		limit = 0
+	}
+	if limit == 0 && memoryHardLimitFromConfig(cfg) == 0 {
+		return nil, 0
+	}
+
+	interval := DefaultMemoryInterval
+	if n := options.Int(opts["interval"]); n > 0 {
+		interval = time.Duration(n) * time.Second
+	}
+	return memory.NewMonitor(uint64(limit)<<20, total), interval
+}
+
+// memoryHardLimitFromConfig returns the bytes allowed by the 'memory.hard_limit' option, which is given
+// in megabytes, or zero when the enumeration is never stopped for its memory consumption.
+func memoryHardLimitFromConfig(cfg *config.Config) uint64 {
+	if cfg == nil {
+		return 0
+	}
+
+	opts, ok := cfg.Options["memory"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	if n := options.Int(opts["hard_limit"]); n > 0 {
+		return uint64(n) << 20
+	}
+	return 0
+}
+
+// registerMemory attributes the memory of the enumeration to its subsystems.
+func (e *Enumeration) registerMemory() {
+	e.memory.Register(MemoryScheduler, func() uint64 {
+		return uint64(e.nameSrc.queue.Len()+e.requests.Len()) * requestSize
+	})
+	e.memory.Register(MemoryGraph, func() uint64 {
+		size := uint64(e.store.queue.Len()) * requestSize
+		for _, g := range e.Sys.GraphDatabases() {
+			size += systems.GraphMemory(g)
+		}
+		return size
+	})
+	e.memory.Register(MemoryDedupe, func() uint64 {
+		return uint64(e.nameSrc.filter.Cells() + e.store.filter.Cells() + e.working.cells())
+	})
+}
+
+// watchMemory samples the memory consumption until the enumeration is finished,
+// and logs the subsystems put under pressure whenever they change. The enumeration
+// is cancelled once the consumption exceeds the hard limit.
+func (e *Enumeration) watchMemory() {
+	var last string
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-e.ctx.Done():
+			return
+		case <-e.clock.After(e.memInterval):
+		}
+
+		e.memory.Sample()
+		if consumed := e.memory.Consumed(); e.memHardLimit > 0 && consumed > e.memHardLimit {
+			e.cancelWith(requests.NewCancelCause(requests.CancelMemory,
+				fmt.Sprintf("%d MB of heap were consumed, exceeding the hard limit of %d MB", consumed>>20, e.memHardLimit>>20)))
+			return
+		}
+
+		var offenders string
+		for _, u := range e.memory.Usage() {
+			if u.Pressure {
+				if offenders != "" {
+					offenders += ", "
+				}
+				offenders += u.Name
+			}
+		}
+		if offenders != last && e.Config.Verbose {
+			if offenders == "" {
+				e.Config.Log.Printf("The memory consumption is back under the limit")
+			} else {
+				e.Config.Log.Printf("The memory consumption exceeds the limit, and the %s subsystems are backing off", offenders)
+			}
+		}
+		last = offenders
+	}
+}
+
+// memoryPressure returns true when the subsystem has been asked to back off.
+func (e *Enumeration) memoryPressure(name string) bool {
+	return e != nil && e.memory.Pressure(name)
+}
+
+// waitForMemory blocks while the subsystem is under pressure, or until the context expires.
+func (e *Enumeration) waitForMemory(ctx context.Context, name string) {
+	for e.memoryPressure(name) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.done:
+			return
+		case <-e.clock.After(e.memInterval):
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caffix/queue"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func TestMemoryMonitorFromConfig(t *testing.T) {
+	total := func() uint64 { return 0 }
+
+	if m, _ := memoryMonitorFromConfig(config.NewConfig(), total); m != nil {
+		t.Error("the memory monitor was enabled without a limit")
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"memory": map[string]interface{}{"limit": 512, "interval": 2}}
+	if m, interval := memoryMonitorFromConfig(cfg, total); m == nil || interval != 2*time.Second {
+		t.Errorf("the options were parsed with the interval %v", interval)
+	}
+
+	// The hard limit samples the consumption without the soft limit
+	cfg.Options = map[string]interface{}{"memory": map[string]interface{}{"hard_limit": 1024}}
+	if m, _ := memoryMonitorFromConfig(cfg, total); m == nil || memoryHardLimitFromConfig(cfg) != 1024<<20 {
+		t.Error("the hard limit did not enable the memory monitor")
+	}
+}
+
+func TestMemoryHardLimit(t *testing.T) {
+	var total uint64
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"memory": map[string]interface{}{"limit": 1, "hard_limit": 2}}
+
+	ctx, cancel := requests.WithCancelCause(context.Background())
+	defer cancel(nil)
+	e := &Enumeration{
+		Config:       cfg,
+		ctx:          ctx,
+		cancel:       cancel,
+		clock:        clock.NewFake(time.Now()),
+		done:         make(chan struct{}),
+		completion:   &completion{clock: clock.NewFake(time.Now())},
+		memHardLimit: memoryHardLimitFromConfig(cfg),
+	}
+	e.memory, e.memInterval = memoryMonitorFromConfig(cfg, func() uint64 { return atomic.LoadUint64(&total) })
+
+	// Exceeding the soft limit only asks the subsystems to back off
+	atomic.StoreUint64(&total, 3<<19)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		e.watchMemory()
+	}()
+	select {
+	case <-finished:
+		t.Fatal("the enumeration was cancelled under the hard limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	atomic.StoreUint64(&total, 3<<20)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the enumeration was not cancelled once the hard limit was exceeded")
+	}
+
+	e.finishReason(context.Background())
+	if cause := e.TerminationReason(); e.Termination() != TerminationMemory || cause == nil ||
+		cause.Detail != "3 MB of heap were consumed, exceeding the hard limit of 2 MB" {
+		t.Errorf("the enumeration finished as %s, cut short by %+v", e.Termination(), cause)
+	}
+}
+
+func TestMemoryThrottling(t *testing.T) {
+	var total uint64
+	sizes := map[string]uint64{MemoryScheduler: 100, MemoryGraph: 100, MemoryDedupe: 100}
+
+	e := &Enumeration{
+		Config:      config.NewConfig(),
+		clock:       clock.NewFake(time.Now()),
+		done:        make(chan struct{}),
+		memInterval: time.Second,
+	}
+	e.memory, _ = memoryMonitorFromConfig(&config.Config{Options: map[string]interface{}{
+		"memory": map[string]interface{}{"limit": 1},
+	}}, func() uint64 { return total })
+	for _, name := range []string{MemoryScheduler, MemoryGraph, MemoryDedupe} {
+		name := name
+		e.memory.Register(name, func() uint64 { return sizes[name] })
+	}
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+
+	// The graph write buffer is the offender, so the scheduler keeps releasing the findings
+	sizes[MemoryGraph] = 2 << 20
+	total = 2 << 20
+	e.memory.Sample()
+	e.nameSrc.fillQueue()
+	if n := len(e.nameSrc.release); n != 10 {
+		t.Errorf("the scheduler released %d findings while the graph was the offender", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		e.waitForMemory(ctx, MemoryGraph)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("the graph writes did not back off")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	<-waited
+
+	// Once the scheduler queues are the offender, the findings are held back instead
+	for len(e.nameSrc.release) > 0 {
+		<-e.nameSrc.release
+	}
+	sizes[MemoryGraph] = 100
+	sizes[MemoryScheduler] = 2 << 20
+	e.memory.Sample()
+	e.nameSrc.fillQueue()
+	if n := len(e.nameSrc.release); n != 0 {
+		t.Errorf("the scheduler released %d findings under pressure", n)
+	}
+	e.waitForMemory(context.Background(), MemoryGraph)
+
+	total = 0
+	e.memory.Sample()
+	e.nameSrc.fillQueue()
+	if n := len(e.nameSrc.release); n != 10 {
+		t.Errorf("the scheduler released %d findings below the limit", n)
+	}
+}
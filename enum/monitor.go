@@ -76,6 +76,7 @@ func (e *Enumeration) checkForMissedWildcards(addr string) {
 		}
 
 		e.Config.BlacklistSubdomain(sub)
+		_ = e.blacklist.Add(sub)
 		for _, id := range assets {
 			_ = e.graph.DB.DeleteAsset(id)
 		}
@@ -7,6 +7,7 @@ package enum
 import (
 	"context"
 	"strings"
+	"sync"
 
 	"github.com/caffix/pipeline"
 	"github.com/caffix/stringset"
@@ -22,7 +23,9 @@ type subdomainTask struct {
 	cnames          *stringset.Set
 	withinWildcards *stringset.Set
 	timesChan       chan *timesReq
+	releaseChan     chan string
 	done            chan struct{}
+	alock           sync.Mutex
 	possibleApexes  map[string]struct{}
 }
 
@@ -33,6 +36,7 @@ func newSubdomainTask(e *Enumeration) *subdomainTask {
 		cnames:          stringset.New(),
 		withinWildcards: stringset.New(),
 		timesChan:       make(chan *timesReq, 10),
+		releaseChan:     make(chan string, 10),
 		done:            make(chan struct{}, 2),
 		possibleApexes:  make(map[string]struct{}),
 	}
@@ -61,9 +65,18 @@ func (r *subdomainTask) Process(ctx context.Context, data pipeline.Data, tp pipe
 	if !ok {
 		return data, nil
 	}
-	if req == nil || !r.enum.Config.IsDomainInScope(req.Name) {
+	if req == nil {
 		return nil, nil
 	}
+	if !r.enum.Config.IsDomainInScope(req.Name) {
+		if r.enum.quarantineAdjacent(req) {
+			r.enum.dispose(req.Name, DispositionQuarantined, "the resolved name is outside of the scope, under a domain adjacent to it")
+			return nil, nil
+		}
+		r.enum.dispose(req.Name, DispositionScope, "the resolved name is outside of the scope")
+		return nil, nil
+	}
+	r.enum.dispose(req.Name, DispositionResolved, "")
 	// Do not further evaluate service subdomains
 	for _, label := range strings.Split(req.Name, ".") {
 		l := strings.ToLower(label)
@@ -117,8 +130,18 @@ func (r *subdomainTask) checkForSubdomains(ctx context.Context, req *requests.DN
 	}
 
 	r.enum.sendRequests(subreq)
+	// The gate decides when the brute forcing recurses into the subdomain
+	if rg := r.enum.recursion; rg != nil && sub != req.Domain && rg.observe(req.Name, sub, req.Derivation) {
+		r.enum.sendRequests(&bruteRecursion{req: &requests.SubdomainRequest{
+			Name:   sub,
+			Domain: req.Domain,
+			Times:  r.enum.Config.MinForRecursive,
+		}})
+	}
 	if times == 1 {
+		r.alock.Lock()
 		r.possibleApexes[sub] = struct{}{}
+		r.alock.Unlock()
 		pipeline.SendData(ctx, "root", subreq, tp)
 	}
 	return true
@@ -162,6 +185,12 @@ func (r *subdomainTask) timesManager() {
 		select {
 		case <-r.done:
 			return
+		case d := <-r.releaseChan:
+			for sub := range subdomains {
+				if withinDomain(sub, d) {
+					delete(subdomains, sub)
+				}
+			}
 		case req := <-r.timesChan:
 			times, found := subdomains[req.Sub]
 			if found {
@@ -176,10 +205,50 @@ func (r *subdomainTask) timesManager() {
 	}
 }
 
+// release links the names of the complete domain to their apexes in the graph, and forgets the wildcard,
+// CNAME and subdomain counts of the domain, so a name found later is evaluated again.
+func (r *subdomainTask) release(d string) {
+	possible := make(map[string]struct{})
+
+	r.alock.Lock()
+	for sub := range r.possibleApexes {
+		if withinDomain(sub, d) {
+			possible[sub] = struct{}{}
+			delete(r.possibleApexes, sub)
+		}
+	}
+	r.alock.Unlock()
+
+	r.linkDomain(d, r.findApexes(possible))
+	for _, set := range []*stringset.Set{r.cnames, r.withinWildcards} {
+		for _, sub := range set.Slice() {
+			if withinDomain(sub, d) {
+				set.Remove(sub)
+			}
+		}
+	}
+
+	select {
+	case <-r.done:
+	case r.releaseChan <- d:
+	}
+}
+
 func (r *subdomainTask) linkNodesToApexes() {
+	r.alock.Lock()
+	apexes := r.findApexes(r.possibleApexes)
+	r.alock.Unlock()
+
+	for _, d := range r.enum.Config.Domains() {
+		r.linkDomain(d, apexes)
+	}
+}
+
+// findApexes returns the possible apexes that were found to be zones in the graph.
+func (r *subdomainTask) findApexes(possible map[string]struct{}) map[string]*types.Asset {
 	apexes := make(map[string]*types.Asset)
 
-	for k := range r.possibleApexes {
+	for k := range possible {
 		res, err := r.enum.graph.DB.FindByContent(domain.FQDN{Name: k}, r.enum.Config.CollectionStartTime)
 		if err != nil || len(res) == 0 {
 			continue
@@ -190,31 +259,42 @@ func (r *subdomainTask) linkNodesToApexes() {
 			apexes[k] = apex
 		}
 	}
+	return apexes
+}
 
-	for _, d := range r.enum.Config.Domains() {
-		names, err := r.enum.graph.DB.FindByScope([]oam.Asset{domain.FQDN{Name: d}}, r.enum.Config.CollectionStartTime)
-		if err != nil || len(names) == 0 {
+// linkDomain links the names of the domain to the apexes they are nodes in.
+func (r *subdomainTask) linkDomain(d string, apexes map[string]*types.Asset) {
+	if len(apexes) == 0 {
+		return
+	}
+
+	names, err := r.enum.graph.DB.FindByScope([]oam.Asset{domain.FQDN{Name: d}}, r.enum.Config.CollectionStartTime)
+	if err != nil || len(names) == 0 {
+		return
+	}
+
+	for _, name := range names {
+		n, ok := name.Asset.(domain.FQDN)
+		if !ok {
 			continue
 		}
-
-		for _, name := range names {
-			n, ok := name.Asset.(domain.FQDN)
-			if !ok {
-				continue
-			}
-			// determine which domain apex this name is a node in
-			best := len(n.Name)
-			var apex *types.Asset
-			for fqdn, a := range apexes {
-				if idx := strings.Index(n.Name, fqdn); idx != -1 && idx != 0 && idx < best {
-					best = idx
-					apex = a
-				}
+		// determine which domain apex this name is a node in
+		best := len(n.Name)
+		var apex *types.Asset
+		for fqdn, a := range apexes {
+			if idx := strings.Index(n.Name, fqdn); idx != -1 && idx != 0 && idx < best {
+				best = idx
+				apex = a
 			}
+		}
 
-			if apex != nil {
-				_, _ = r.enum.graph.DB.Create(apex, "node", n)
-			}
+		if apex != nil {
+			_, _ = r.enum.graph.DB.Create(apex, "node", n)
 		}
 	}
 }
+
+// withinDomain returns true when the name is the domain or one of its subdomains.
+func withinDomain(name, d string) bool {
+	return name == d || strings.HasSuffix(name, "."+d)
+}
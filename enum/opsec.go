@@ -0,0 +1,145 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/caffix/pipeline"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/opsec"
+	"github.com/owasp-amass/amass/v4/profile"
+)
+
+// shuffleWindow is the number of queued candidates that the next dispatched candidate is drawn from.
+const shuffleWindow = 64
+
+// jitterPool delays each query sent to the pool by a random duration. The delays of the queries
+// sent without blocking run concurrently, so they add latency without serializing the queries.
+type jitterPool struct {
+	Pool
+	jitter *opsec.Jitter
+}
+
+func (jp *jitterPool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	d := jp.jitter.Next()
+	if d <= 0 {
+		jp.Pool.Query(ctx, msg, ch)
+		return
+	}
+
+	time.AfterFunc(d, func() {
+		jp.Pool.Query(ctx, msg, ch)
+	})
+}
+
+func (jp *jitterPool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if d := jp.jitter.Next(); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+	return jp.Pool.QueryBlocking(ctx, msg)
+}
+
+// shuffler releases the queued candidates of the enumeration in a random order.
+type shuffler struct {
+	rng *rand.Rand
+	buf []pipeline.Data
+}
+
+func (s *shuffler) pending() bool {
+	return s != nil && len(s.buf) > 0
+}
+
+// next draws a candidate from the window filled by the fill function.
+func (s *shuffler) next(fill func() (pipeline.Data, bool)) pipeline.Data {
+	for len(s.buf) < shuffleWindow {
+		data, ok := fill()
+		if !ok {
+			break
+		}
+		s.buf = append(s.buf, data)
+	}
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	i := s.rng.Intn(len(s.buf))
+	data := s.buf[i]
+	last := len(s.buf) - 1
+	s.buf[i] = s.buf[last]
+	s.buf[last] = nil
+	s.buf = s.buf[:last]
+	return data
+}
+
+// Metadata returns the settings recorded with the enumeration event, such as the seed that allows the run
+// to be reproduced, the profile of the configuration and the reason the run finished, or nil when there are none.
+func (e *Enumeration) Metadata() map[string]string {
+	var md map[string]string
+
+	if e.seed != nil {
+		md = e.seed.Metadata()
+	}
+	if e.opsec != nil {
+		if md == nil {
+			md = make(map[string]string)
+		}
+		for k, v := range e.opsec.Metadata() {
+			md[k] = v
+		}
+	}
+	if p := profile.FromConfig(e.Config); p != nil {
+		if md == nil {
+			md = make(map[string]string)
+		}
+		md["profile"] = p.Name
+	}
+	if reason := e.Termination(); reason != "" {
+		if md == nil {
+			md = make(map[string]string)
+		}
+		md["termination"] = string(reason)
+	}
+	if cause := e.TerminationReason(); cause != nil {
+		md["cancel_reason"] = string(cause.Reason)
+		md["cancel_detail"] = cause.Detail
+	}
+	return md
+}
+
+// startOPSEC randomizes the brute forcing wordlist and records the seed of the run.
+func (e *Enumeration) startOPSEC() {
+	if e.opsec == nil {
+		return
+	}
+
+	e.Config.Wordlist = opsec.Shuffle(e.Config.Wordlist, e.opsec.Rand("wordlist"))
+	e.Config.Log.Printf("OPSEC: the run can be reproduced with the seed %d", e.opsec.Seed)
+}
+
+// reportOPSEC logs the delays added to the queries, which bound the impact on the throughput.
+func (e *Enumeration) reportOPSEC() {
+	if e.opsec == nil || e.jitter == nil {
+		return
+	}
+
+	count, total := e.jitter.Stats()
+	if count == 0 {
+		return
+	}
+
+	avg := total / time.Duration(count)
+	e.Config.Log.Printf("OPSEC: %d queries were delayed by %v on average and at most %v, adding %v of latency in total",
+		count, avg, e.opsec.JitterMax, total)
+}
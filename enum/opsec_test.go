@@ -0,0 +1,59 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/caffix/pipeline"
+)
+
+func TestShuffler(t *testing.T) {
+	var queued []pipeline.Data
+	for i := 0; i < 2*shuffleWindow; i++ {
+		queued = append(queued, &testData{id: i})
+	}
+
+	fill := func() (pipeline.Data, bool) {
+		if len(queued) == 0 {
+			return nil, false
+		}
+		data := queued[0]
+		queued = queued[1:]
+		return data, true
+	}
+
+	s := &shuffler{rng: rand.New(rand.NewSource(42))}
+	seen := make(map[int]struct{})
+	inOrder := true
+	for i := 0; i < 2*shuffleWindow; i++ {
+		data := s.next(fill)
+		if data == nil {
+			t.Fatalf("the shuffler returned nil after %d candidates", i)
+		}
+
+		id := data.(*testData).id
+		if id != i {
+			inOrder = false
+		}
+		seen[id] = struct{}{}
+	}
+	if inOrder {
+		t.Error("the candidates were released in the order they were queued")
+	}
+	if len(seen) != 2*shuffleWindow {
+		t.Errorf("%d of the %d candidates were released", len(seen), 2*shuffleWindow)
+	}
+	if s.pending() || s.next(fill) != nil {
+		t.Error("the shuffler released candidates after the queue was drained")
+	}
+}
+
+type testData struct {
+	id int
+}
+
+func (d *testData) Clone() pipeline.Data { return &testData{id: d.id} }
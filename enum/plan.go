@@ -0,0 +1,266 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/caffix/stringset"
+	"github.com/owasp-amass/amass/v4/datasrcs"
+	"github.com/owasp-amass/amass/v4/opsec"
+	"github.com/owasp-amass/amass/v4/remote"
+	"github.com/owasp-amass/amass/v4/resources"
+	"github.com/owasp-amass/amass/v4/wordlists"
+	"github.com/owasp-amass/config/config"
+)
+
+// ActivityPlan describes what an enumeration would do with the configuration.
+type ActivityPlan struct {
+	Domains []string
+	// Blockers are the problems that would keep the enumeration from starting
+	Blockers []string
+	// Warnings are the parts of the plan that could not be determined in advance
+	Warnings   []string
+	Techniques PlannedTechniques
+	Sources    []PlannedSource
+	Queries    QueryEstimate
+	Endpoints  []PlannedEndpoint
+}
+
+// PlannedTechniques holds the techniques enabled by the configuration.
+type PlannedTechniques struct {
+	Passive         bool
+	Active          bool
+	BruteForcing    bool
+	Recursive       bool
+	MinForRecursive int
+	MaxDepth        int
+	Alterations     bool
+	OPSEC           bool
+}
+
+// PlannedSource describes a data source selected by the configuration.
+type PlannedSource struct {
+	Name string
+	Type string
+	// Start is false when the source would fail its check, such as for missing credentials
+	Start  bool
+	Reason string
+	// Endpoints holds the hosts named by the source
+	Endpoints []string
+}
+
+// PlannedEndpoint is an external system that would be contacted.
+type PlannedEndpoint struct {
+	Address string
+	Purpose string
+}
+
+// QueryEstimate is the least number of DNS queries sent by the enumeration. Names discovered during the
+// enumeration add the PerName queries each, along with the alterations and recursive brute forcing of them.
+type QueryEstimate struct {
+	Words      int
+	BruteForce int
+	Known      int
+	PerName    int
+	Total      int
+	// Duration is the least time needed to send the Total queries at the maximum DNS query rate
+	Duration time.Duration
+}
+
+// Plan computes what an enumeration would do with the configuration, without starting the system or
+// sending any traffic. Only the wordlist files are read, and the configuration is left unchanged.
+func Plan(cfg *config.Config) *ActivityPlan {
+	p := &ActivityPlan{Domains: cfg.Domains()}
+
+	words, altWords := cfg.Wordlist, cfg.AltWordlist
+	if err := cfg.CheckSettings(); err != nil {
+		p.Blockers = append(p.Blockers, err.Error())
+	}
+	cfg.Wordlist, cfg.AltWordlist = words, altWords
+
+	if len(p.Domains) == 0 {
+		p.Blockers = append(p.Blockers, "no root domain names were provided")
+	}
+
+	p.Techniques = PlannedTechniques{
+		Passive:         cfg.Passive,
+		Active:          cfg.Active,
+		BruteForcing:    cfg.BruteForcing,
+		Recursive:       cfg.BruteForcing && cfg.Recursive,
+		MinForRecursive: cfg.MinForRecursive,
+		MaxDepth:        cfg.MaxDepth,
+		Alterations:     cfg.Alterations,
+		OPSEC:           opsec.FromConfig(cfg) != nil,
+	}
+
+	p.planSources(cfg)
+	if !cfg.Passive {
+		p.planQueries(cfg)
+		p.planResolvers(cfg)
+	}
+	p.planSystems(cfg)
+
+	p.Endpoints = uniqueEndpoints(p.Endpoints)
+	sort.Slice(p.Endpoints, func(i, j int) bool {
+		if p.Endpoints[i].Purpose != p.Endpoints[j].Purpose {
+			return p.Endpoints[i].Purpose < p.Endpoints[j].Purpose
+		}
+		return p.Endpoints[i].Address < p.Endpoints[j].Address
+	})
+	return p
+}
+
+func (p *ActivityPlan) planSources(cfg *config.Config) {
+	infos, err := datasrcs.InspectSources(cfg)
+	if err != nil {
+		p.Blockers = append(p.Blockers, fmt.Sprintf("failed to acquire the data source scripts: %v", err))
+		return
+	}
+
+	hosts := stringset.New()
+	defer hosts.Close()
+
+	for _, info := range infos {
+		p.Sources = append(p.Sources, PlannedSource{
+			Name:      info.Name,
+			Type:      info.Type,
+			Start:     info.Ready,
+			Reason:    info.Reason,
+			Endpoints: info.Endpoints,
+		})
+		if info.Ready {
+			hosts.InsertMany(info.Endpoints...)
+		}
+	}
+
+	for _, host := range hosts.Slice() {
+		p.Endpoints = append(p.Endpoints, PlannedEndpoint{Address: host, Purpose: "data source"})
+	}
+}
+
+func (p *ActivityPlan) planQueries(cfg *config.Config) {
+	qt := queryTypesFromConfig(cfg)
+	est := &p.Queries
+	est.PerName = len(qt.resolved)
+
+	if cfg.BruteForcing {
+		words, err := p.bruteWordlist(cfg)
+		if err != nil {
+			p.Blockers = append(p.Blockers, err.Error())
+		}
+
+		est.Words = len(words)
+		est.BruteForce = est.Words * len(p.Domains) * len(qt.brute)
+		if cfg.Recursive {
+			p.Warnings = append(p.Warnings, "the recursive brute forcing depends on the discovered subdomains and is not estimated")
+		}
+	}
+	if cfg.Alterations {
+		p.Warnings = append(p.Warnings, "the alterations depend on the discovered names and are not estimated")
+	}
+
+	est.Known = (len(p.Domains) + len(cfg.ProvidedNames)) * est.PerName
+	est.Total = est.BruteForce + est.Known
+	if cfg.MaxDNSQueries > 0 {
+		est.Duration = time.Duration(est.Total) * time.Second / time.Duration(cfg.MaxDNSQueries)
+	}
+}
+
+// bruteWordlist returns the words used for brute forcing, with the masks expanded, which are
+// read from the 'bruteforce' wordlist files or the embedded wordlist when none have been loaded.
+func (p *ActivityPlan) bruteWordlist(cfg *config.Config) ([]string, error) {
+	words := cfg.Wordlist
+
+	if len(words) == 0 {
+		var paths []string
+		if opts, ok := cfg.Options["bruteforce"].(map[string]interface{}); ok {
+			paths = stringList(opts["wordlists"])
+		}
+
+		for _, path := range paths {
+			abs, err := cfg.AbsPathFromConfigDir(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the absolute path of the wordlist %s: %v", path, err)
+			}
+
+			list, err := wordlists.Load(abs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the wordlist %s: %v", abs, err)
+			}
+			words = append(words, list.Labels()...)
+		}
+	}
+	if len(words) == 0 {
+		if f, err := resources.GetResourceFile("namelist.txt"); err == nil {
+			if list, err := wordlists.Read(f); err == nil {
+				words = list.Labels()
+			}
+		}
+	}
+	return config.ExpandMaskWordlist(words)
+}
+
+func (p *ActivityPlan) planResolvers(cfg *config.Config) {
+	if len(cfg.Resolvers) == 0 {
+		p.Endpoints = append(p.Endpoints, PlannedEndpoint{Address: "public-dns.info", Purpose: "resolver list"})
+		p.Warnings = append(p.Warnings, "the untrusted resolvers are downloaded from public-dns.info at the start")
+	}
+	for _, addr := range cfg.Resolvers {
+		p.Endpoints = append(p.Endpoints, PlannedEndpoint{Address: resolverAddr(addr), Purpose: "untrusted resolver"})
+	}
+
+	trusted := cfg.TrustedResolvers
+	if len(trusted) == 0 {
+		trusted = config.DefaultBaselineResolvers
+	}
+	// The wildcard detection queries are sent to 8.8.8.8 along with the trusted resolvers
+	for _, addr := range append([]string{"8.8.8.8"}, trusted...) {
+		p.Endpoints = append(p.Endpoints, PlannedEndpoint{Address: resolverAddr(addr), Purpose: "trusted resolver"})
+	}
+}
+
+func (p *ActivityPlan) planSystems(cfg *config.Config) {
+	for _, db := range cfg.GraphDBs {
+		if db == nil || db.Host == "" {
+			continue
+		}
+
+		addr := db.Host
+		if db.Port != "" {
+			addr = net.JoinHostPort(db.Host, db.Port)
+		}
+		p.Endpoints = append(p.Endpoints, PlannedEndpoint{Address: addr, Purpose: db.System + " database"})
+	}
+
+	if wcfg := remote.ConfigFromOptions(cfg); wcfg != nil && !cfg.Passive {
+		for _, n := range wcfg.Nodes {
+			p.Endpoints = append(p.Endpoints, PlannedEndpoint{Address: n.Address, Purpose: "worker"})
+		}
+	}
+}
+
+func resolverAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, "53")
+}
+
+func uniqueEndpoints(endpoints []PlannedEndpoint) []PlannedEndpoint {
+	seen := make(map[PlannedEndpoint]struct{}, len(endpoints))
+
+	var unique []PlannedEndpoint
+	for _, ep := range endpoints {
+		if _, found := seen[ep]; !found {
+			seen[ep] = struct{}{}
+			unique = append(unique, ep)
+		}
+	}
+	return unique
+}
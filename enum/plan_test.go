@@ -0,0 +1,102 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestPlanBlockers(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Passive = true
+	cfg.BruteForcing = true
+
+	p := Plan(cfg)
+	if len(p.Blockers) != 2 {
+		t.Fatalf("expected the settings and missing domains as blockers, got %v", p.Blockers)
+	}
+	if !strings.Contains(p.Blockers[0], "brute forcing") {
+		t.Errorf("the settings problem was not surfaced: %s", p.Blockers[0])
+	}
+	if p.Queries.Total != 0 {
+		t.Errorf("%d DNS queries were planned in the passive mode", p.Queries.Total)
+	}
+}
+
+func TestPlanQueries(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomains("owasp.org", "example.com")
+	cfg.ProvidedNames = []string{"www.owasp.org"}
+	cfg.BruteForcing = true
+	cfg.Recursive = false
+	cfg.Wordlist = []string{"www", "mail", "dev?d"}
+	cfg.MaxDNSQueries = 10
+	cfg.Resolvers = []string{"192.0.2.1", "192.0.2.2:5353"}
+
+	p := Plan(cfg)
+	if len(p.Blockers) != 0 {
+		t.Fatalf("unexpected blockers: %v", p.Blockers)
+	}
+	// The masks are expanded for the estimate, while the configuration is left unchanged
+	if len(cfg.Wordlist) != 3 {
+		t.Errorf("the plan changed the wordlist to %d words", len(cfg.Wordlist))
+	}
+
+	q := p.Queries
+	if q.Words != 12 || q.BruteForce != 12*2*len(BruteQueryTypes) {
+		t.Errorf("estimated %d brute forcing queries for %d words", q.BruteForce, q.Words)
+	}
+	if q.PerName != len(FwdQueryTypes) || q.Known != 3*len(FwdQueryTypes) {
+		t.Errorf("estimated %d queries for the known names and %d for each name", q.Known, q.PerName)
+	}
+	if q.Total != q.BruteForce+q.Known || q.Duration != time.Duration(q.Total)*time.Second/10 {
+		t.Errorf("estimated %d queries taking %v", q.Total, q.Duration)
+	}
+
+	found := make(map[string]string)
+	for _, ep := range p.Endpoints {
+		found[ep.Address] = ep.Purpose
+	}
+	for addr, purpose := range map[string]string{
+		"192.0.2.1:53":   "untrusted resolver",
+		"192.0.2.2:5353": "untrusted resolver",
+		"8.8.8.8:53":     "trusted resolver",
+	} {
+		if found[addr] != purpose {
+			t.Errorf("the endpoint %s was planned as %q, expected %q", addr, found[addr], purpose)
+		}
+	}
+	if _, ok := found["public-dns.info"]; ok {
+		t.Error("the public resolver list was planned although the resolvers are configured")
+	}
+}
+
+func TestPlanSources(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.SourceFilter.Include = true
+	cfg.SourceFilter.Sources = []string{"Crtsh", "Shodan"}
+
+	p := Plan(cfg)
+	if len(p.Sources) != 2 {
+		t.Fatalf("expected the two included data sources, got %d", len(p.Sources))
+	}
+	for _, src := range p.Sources {
+		switch src.Name {
+		case "Crtsh":
+			if !src.Start || len(src.Endpoints) == 0 {
+				t.Errorf("the Crtsh data source was planned to start: %t with the endpoints %v", src.Start, src.Endpoints)
+			}
+		case "Shodan":
+			if src.Start {
+				t.Error("the Shodan data source was planned to start without credentials")
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// policyFromConfig returns the never-touch list named by the 'policy' options, or nil when none was named.
+// The autonomous systems of the addresses are found in the ASN cache of the System.
+func policyFromConfig(cfg *config.Config, sys systems.System) *policy.Policy {
+	p, err := policy.FromConfig(cfg)
+	if err != nil {
+		cfg.Log.Printf("%v: every active probe will be blocked", err)
+	}
+
+	p.SetASNLookup(func(addr string) int {
+		if sys == nil || sys.Cache() == nil {
+			return 0
+		}
+		if asn := sys.Cache().AddrSearch(addr); asn != nil {
+			return asn.ASN
+		}
+		return 0
+	})
+	return p
+}
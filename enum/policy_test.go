@@ -0,0 +1,139 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func policyEnumeration(t *testing.T, cidrs ...string) *Enumeration {
+	p, err := policy.Load(strings.NewReader(`{"rules": [
+		{"name": "hospital", "cidrs": ["198.51.100.7"], "domains": ["clinic.owasp.org"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := addressScopeConfig(t, cidrs...)
+	cfg.Active = true
+	e := &Enumeration{
+		Config:    cfg,
+		Policy:    p,
+		addrScope: addressScopeFromConfig(cfg),
+		dlog:      newDispositionLog(100),
+	}
+	// The stable filter evicts at random, so it is sized for the repeated names to be caught
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+	return e
+}
+
+func TestPolicyBeforeScope(t *testing.T) {
+	// The netblock of the scope includes the address on the never-touch list
+	e := policyEnumeration(t, "198.51.100.0/24")
+	if as := e.addrScope; as == nil || as.netblock(net.ParseIP("198.51.100.7")) == "" {
+		t.Fatal("the address was not included by the scope")
+	}
+
+	// The sweep neither queries the PTR record nor pulls the certificates of the address
+	e.addrScope.passive = false
+	e.sweepAddress(context.Background(), net.ParseIP("198.51.100.7"))
+	// Nor is the address brought into the enumeration for the data sources to sweep
+	e.nameSrc.newAddr(&requests.AddrRequest{Address: "198.51.100.7", InScope: true, Domain: "owasp.org"})
+	e.nameSrc.newAddr(&requests.AddrRequest{Address: "198.51.100.8", InScope: true, Domain: "owasp.org"})
+	if n := e.nameSrc.queue.Len(); n != 1 {
+		t.Errorf("%d addresses were brought into the enumeration", n)
+	}
+
+	report := e.Policy.Report()
+	if report.Blocked != 3 {
+		t.Fatalf("%d attempts were blocked", report.Blocked)
+	}
+	components := make(map[string]int64)
+	for _, v := range report.Violations {
+		if v.Target != "198.51.100.7" || v.Rule != "hospital" {
+			t.Errorf("the violation is %+v", v)
+		}
+		components[v.Component] += v.Attempts
+	}
+	if components[policy.DNS] != 2 || components[policy.Port] != 1 {
+		t.Errorf("the blocked attempts of the components are %v", components)
+	}
+}
+
+func TestPolicyNames(t *testing.T) {
+	e := policyEnumeration(t)
+	e.Config.AddDomain("owasp.org")
+
+	// The name in scope is on the never-touch list, so it is never resolved
+	e.nameSrc.newName(&requests.DNSRequest{Name: "www.clinic.owasp.org", Domain: "owasp.org"})
+	e.nameSrc.newName(&requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org"})
+	if n := e.nameSrc.queue.Len(); n != 1 {
+		t.Errorf("%d names were brought into the enumeration", n)
+	}
+	if d, found := e.dlog.lookup("www.clinic.owasp.org"); !found || d.Disposition != DispositionPolicy {
+		t.Errorf("the blocked name was recorded as %+v", d)
+	}
+
+	// The queries toward the names are refused before the resolvers are used
+	if _, err := e.dnsQuery(context.Background(), "clinic.owasp.org", dns.TypeNS, nil, 1); err != policy.ErrBlocked {
+		t.Errorf("the query toward the blocked name returned %v", err)
+	}
+	if _, err := e.trustedQuery(context.Background(), "clinic.owasp.org", dns.TypeNS); err != policy.ErrBlocked {
+		t.Errorf("the trusted query toward the blocked name returned %v", err)
+	}
+}
+
+// filteringSystem holds the custom name filters of the System.
+type filteringSystem struct {
+	systems.System
+	filters *systems.NameFilters
+}
+
+func (f *filteringSystem) AddNameFilter(fn func(name string, src string) bool) { f.filters.Add(fn) }
+
+func (f *filteringSystem) FilterName(name, src string) bool { return f.filters.Allow(name, src) }
+
+func (f *filteringSystem) NameFilterStats() []systems.NameFilterStats { return f.filters.Stats() }
+
+func TestCustomNameFilters(t *testing.T) {
+	e := policyEnumeration(t)
+	sys := &filteringSystem{filters: systems.NewNameFilters(nil, 0)}
+	re, err := systems.RegexNameFilter(`^customer-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.AddNameFilter(re)
+	e.Sys = sys
+
+	e.nameSrc.newName(&requests.DNSRequest{Name: "Customer-Portal.owasp.org.", Domain: "owasp.org", Derivation: requests.DerivedFromBrute})
+	e.nameSrc.newName(&requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute})
+	if n := e.nameSrc.queue.Len(); n != 1 {
+		t.Errorf("%d names were brought into the enumeration", n)
+	}
+	if rec, found := e.WhyNot("customer-portal.owasp.org"); !found || rec.Disposition != DispositionFiltered {
+		t.Errorf("the filtered name has the disposition %+v", rec)
+	}
+	if stats := sys.NameFilterStats(); stats[0].Calls != 2 || stats[0].Dropped != 1 {
+		t.Errorf("the filter counted %+v", stats[0])
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// WebPostureFile is the name of the file under the output directory holding the web posture of the names.
+const WebPostureFile = "web_posture.json"
+
+// DefaultPosturePort is the port the names are asked for their web posture on.
+const DefaultPosturePort = 443
+
+// The custom node type and predicate storing the web posture of the names in the graph database.
+const (
+	// PostureNodeType is the node holding the web posture of a name, keyed by the name
+	PostureNodeType = "amass:web_posture"
+	// PosturePredicate links the FQDN to the node holding its web posture
+	PosturePredicate = "amass:has_web_posture"
+)
+
+func init() {
+	_ = custom.RegisterNode(custom.NodeType{
+		Name:        PostureNodeType,
+		Description: "The security headers served for a name over HTTPS",
+	})
+	_ = custom.RegisterPredicate(custom.Predicate{
+		Name: PosturePredicate,
+		From: []string{"FQDN"},
+		To:   []string{PostureNodeType},
+	})
+}
+
+// WebPosture is the web security posture served for a name, asked from one of the addresses it resolves to.
+type WebPosture struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	http.SecurityPosture
+}
+
+// PostureSummary counts the names of the enumeration missing each part of the web posture.
+type PostureSummary struct {
+	Names              int `json:"names"`
+	MissingHSTS        int `json:"missing_hsts"`
+	MissingCSP         int `json:"missing_csp"`
+	MissingFrameOption int `json:"missing_x_frame_options"`
+	InsecureCookies    int `json:"insecure_cookies"`
+}
+
+// postureProber asks the names resolving to an address for the security headers of their root page. The names
+// sharing an address are each asked with their own server name, since the posture differs for each virtual host.
+type postureProber struct {
+	sync.Mutex
+	port    int
+	workers int
+	perHost int
+	probe   func(ctx context.Context, addr string, port int, serverName string) (*http.SecurityPosture, error)
+	found   map[string]*WebPosture
+}
+
+// postureProberFromConfig parses the 'web_probe' configuration options, and returns nil unless the posture
+// is collected in an active enumeration. The probes are bounded by the concurrency caps of the web probes.
+func postureProberFromConfig(cfg *config.Config) *postureProber {
+	if cfg == nil || !cfg.Active {
+		return nil
+	}
+
+	opts, _ := cfg.Options["web_probe"].(map[string]interface{})
+	if enabled, ok := opts["posture"].(bool); ok && !enabled {
+		return nil
+	}
+
+	pp := &postureProber{
+		port:    DefaultPosturePort,
+		workers: http.DefaultCrawlConcurrency,
+		perHost: http.DefaultCrawlPerHost,
+		probe:   http.RequestPosture,
+		found:   make(map[string]*WebPosture),
+	}
+	if n := options.Int(opts["posture_port"]); n > 0 && n <= 65535 {
+		pp.port = n
+	}
+	if n := options.Int(opts["concurrency"]); n > 0 {
+		pp.workers = n
+	}
+	if n := options.Int(opts["per_host"]); n > 0 {
+		pp.perHost = n
+	}
+	return pp
+}
+
+func (pp *postureProber) add(wp *WebPosture) {
+	pp.Lock()
+	defer pp.Unlock()
+
+	pp.found[wp.Name] = wp
+}
+
+// WebPosture returns the web posture of the names that answered over HTTPS, ordered by the name.
+func (e *Enumeration) WebPosture() []WebPosture {
+	pp := e.posture
+	if pp == nil {
+		return nil
+	}
+
+	pp.Lock()
+	defer pp.Unlock()
+
+	found := make([]WebPosture, 0, len(pp.found))
+	for _, wp := range pp.found {
+		found = append(found, *wp)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+	return found
+}
+
+// WebPostureSummary counts the names missing each part of the web posture.
+func (e *Enumeration) WebPostureSummary() PostureSummary {
+	var s PostureSummary
+
+	for _, wp := range e.WebPosture() {
+		s.Names++
+		if !wp.HSTS {
+			s.MissingHSTS++
+		}
+		if !wp.CSP {
+			s.MissingCSP++
+		}
+		if wp.XFrameOptions == "" {
+			s.MissingFrameOption++
+		}
+		if wp.InsecureCookies() > 0 {
+			s.InsecureCookies++
+		}
+	}
+	return s
+}
+
+// postureTarget is a name resolving to an address during the enumeration.
+type postureTarget struct {
+	name string
+	addr string
+}
+
+// postureTargets returns the names in scope resolving to an address during the enumeration, each along with
+// the lowest of its addresses, so the same address is asked each time.
+func (e *Enumeration) postureTargets(ctx context.Context) []postureTarget {
+	var names []string
+	for it := cursor.SortedNamesIterator(ctx, e.graph, time.Time{}, e.Config.Domains()...); it.Next(); {
+		if name := it.Name(); e.Config.WhichDomain(name) != "" && !e.blacklisted(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	addrs := make(map[string]string)
+	start := e.Config.CollectionStartTime
+	if pairs, err := history.NamesToAddrs(ctx, e.graph, e.History, history.Current, start, start, names...); err == nil {
+		for _, p := range pairs {
+			if p.FQDN == nil || p.Addr == nil || !p.Addr.Address.IsValid() {
+				continue
+			}
+
+			addr, name := p.Addr.Address.String(), strings.ToLower(p.FQDN.Name)
+			if cur, found := addrs[name]; !found ||
+				bytes.Compare(net.ParseIP(addr).To16(), net.ParseIP(cur).To16()) < 0 {
+				addrs[name] = addr
+			}
+		}
+	}
+
+	targets := make([]postureTarget, 0, len(addrs))
+	for name, addr := range addrs {
+		targets = append(targets, postureTarget{name: name, addr: addr})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	return targets
+}
+
+// probeWebPosture asks each name resolving to an address for the security headers of its root page, and
+// stores the posture of the names that answered on their FQDN in the graph database.
+func (e *Enumeration) probeWebPosture(ctx context.Context) {
+	pp := e.posture
+	if pp == nil {
+		return
+	}
+
+	// The requests in flight to each address are capped like those of the crawls
+	var slock sync.Mutex
+	slots := make(map[string]chan struct{})
+	slot := func(addr string) chan struct{} {
+		slock.Lock()
+		defer slock.Unlock()
+
+		if _, found := slots[addr]; !found {
+			slots[addr] = make(chan struct{}, pp.perHost)
+		}
+		return slots[addr]
+	}
+
+	ch := make(chan postureTarget, pp.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < pp.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for t := range ch {
+				s := slot(t.addr)
+				select {
+				case <-ctx.Done():
+					continue
+				case s <- struct{}{}:
+				}
+				e.probePosture(ctx, t)
+				<-s
+			}
+		}()
+	}
+
+loop:
+	for _, t := range e.postureTargets(ctx) {
+		if e.Policy.BlocksName(policy.Web, t.name) || e.Policy.BlocksAddress(policy.Port, t.addr) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			break loop
+		case ch <- t:
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if n := len(e.WebPosture()); n > 0 {
+		e.Config.Log.Printf("Collected the web posture of %d names", n)
+	}
+}
+
+// probePosture asks the address for the posture of the name, and stores it in the graph database.
+func (e *Enumeration) probePosture(ctx context.Context, t postureTarget) {
+	pp := e.posture
+
+	sp, err := pp.probe(ctx, t.addr, pp.port, t.name)
+	if err != nil || sp == nil {
+		// The name is not served over HTTPS by the address
+		return
+	}
+
+	wp := &WebPosture{Name: t.name, Address: t.addr, Port: pp.port, SecurityPosture: *sp}
+	pp.add(wp)
+	if err := e.storePosture(ctx, wp); err != nil {
+		e.Config.Log.Printf("Failed to store the web posture of %s: %v", t.name, err)
+	}
+}
+
+// storePosture stores the posture as a custom node linked to the FQDN of the name, when the graph database
+// is able to hold the custom nodes.
+func (e *Enumeration) storePosture(ctx context.Context, wp *WebPosture) error {
+	s := custom.For(e.graph)
+	if s == nil {
+		return nil
+	}
+
+	assets, err := e.graph.DB.FindByContent(&domain.FQDN{Name: wp.Name}, time.Time{})
+	if err != nil || len(assets) == 0 {
+		return err
+	}
+
+	node, err := s.AddNode(ctx, PostureNodeType, wp.Name, wp)
+	if err != nil {
+		return err
+	}
+	_, err = s.Link(ctx, assets[0].ID, PosturePredicate, node.ID)
+	return err
+}
@@ -0,0 +1,175 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// startPostureServer serves www.owasp.org with the security headers, and api.owasp.org without them,
+// from the same loopback address.
+func startPostureServer(t *testing.T) int {
+	cert := selfSignedCert(t, "www.owasp.org")
+
+	srv := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Host == "www.owasp.org" {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+			w.Header().Set("Content-Security-Policy", "default-src 'self'")
+			w.Header().Set("X-Frame-Options", "deny")
+			w.Header().Add("Set-Cookie", "session=1; Secure; HttpOnly; SameSite=Strict")
+		} else {
+			w.Header().Set("Strict-Transport-Security", "max-age=0")
+			w.Header().Add("Set-Cookie", "tracking=1")
+		}
+		_, _ = w.Write([]byte("the application"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func postureEnumeration(t *testing.T, opts map[string]interface{}) *Enumeration {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "amass.sqlite")
+	g := netmap.NewGraph("local", dsn, "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	t.Cleanup(func() { g.Remove() })
+
+	s, err := custom.Open("local", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom.Register(g, s)
+	t.Cleanup(func() { custom.Unregister(g) })
+
+	store, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.Active = true
+	cfg.CollectionStartTime = time.Now().Add(-time.Minute)
+	cfg.Options = map[string]interface{}{"web_probe": opts}
+	e := &Enumeration{
+		Config:  cfg,
+		History: store.Backend("local"),
+		graph:   g,
+		posture: postureProberFromConfig(cfg),
+	}
+
+	if _, err := g.UpsertFQDN(ctx, "owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	// The names sharing the address are served as distinct virtual hosts, and the old name no longer resolves
+	now := time.Now()
+	past := history.Period{FirstSeen: now.AddDate(-2, 0, 0), LastSeen: now.AddDate(-1, 0, 0)}
+	for name, p := range map[string]struct {
+		addr   string
+		period history.Period
+	}{
+		"www.owasp.org": {"127.0.0.1", history.Period{FirstSeen: now, LastSeen: now}},
+		"api.owasp.org": {"127.0.0.1", history.Period{FirstSeen: now, LastSeen: now}},
+		"old.owasp.org": {"127.0.0.1", past},
+	} {
+		if err := history.UpsertAddress(ctx, g, e.History, name, p.addr, p.period); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+func TestPostureProberFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if postureProberFromConfig(cfg) != nil {
+		t.Error("the posture was collected in a passive enumeration")
+	}
+
+	cfg.Active = true
+	pp := postureProberFromConfig(cfg)
+	if pp == nil || pp.port != DefaultPosturePort || pp.workers != 5 || pp.perHost != 2 {
+		t.Fatalf("the defaults were not used: %+v", pp)
+	}
+
+	cfg.Options = map[string]interface{}{"web_probe": map[string]interface{}{"concurrency": 3, "per_host": 1}}
+	if pp = postureProberFromConfig(cfg); pp == nil || pp.workers != 3 || pp.perHost != 1 {
+		t.Errorf("the caps of the web probes were not used: %+v", pp)
+	}
+
+	cfg.Options = map[string]interface{}{"web_probe": map[string]interface{}{"posture": false}}
+	if postureProberFromConfig(cfg) != nil {
+		t.Error("the posture was collected while disabled")
+	}
+}
+
+func TestProbeWebPosture(t *testing.T) {
+	port := startPostureServer(t)
+	e := postureEnumeration(t, map[string]interface{}{"posture_port": port, "per_host": 1})
+
+	e.probeWebPosture(context.Background())
+	found := e.WebPosture()
+	if len(found) != 2 {
+		t.Fatalf("collected the web posture %+v", found)
+	}
+
+	api, www := found[0], found[1]
+	if www.Name != "www.owasp.org" || !www.HSTS || !www.HSTSIncludeSubdomains || !www.CSP || www.XFrameOptions != "DENY" {
+		t.Errorf("the posture of www.owasp.org was %+v", www)
+	}
+	if len(www.Cookies) != 1 || !www.Cookies[0].Secure || !www.Cookies[0].HTTPOnly || www.Cookies[0].SameSite != "Strict" {
+		t.Errorf("the cookies of www.owasp.org were %+v", www.Cookies)
+	}
+	// The name sharing the address was asked with its own server name
+	if api.Name != "api.owasp.org" || api.HSTS || api.CSP || api.XFrameOptions != "" || api.InsecureCookies() != 1 {
+		t.Errorf("the posture of api.owasp.org was %+v", api)
+	}
+
+	s := e.WebPostureSummary()
+	if s.Names != 2 || s.MissingHSTS != 1 || s.MissingCSP != 1 || s.MissingFrameOption != 1 || s.InsecureCookies != 1 {
+		t.Errorf("the summary was %+v", s)
+	}
+
+	// The posture is stored on the name
+	ctx := context.Background()
+	assets, err := e.graph.DB.FindByContent(&domain.FQDN{Name: "www.owasp.org"}, time.Time{})
+	if err != nil || len(assets) != 1 {
+		t.Fatalf("the name was not found: %v", err)
+	}
+	cs := custom.For(e.graph)
+	edges, err := cs.Outgoing(ctx, assets[0].ID, PosturePredicate)
+	if err != nil || len(edges) != 1 {
+		t.Fatalf("the posture was not linked to the name: %v", err)
+	}
+	node, err := cs.NodeByID(ctx, edges[0].To)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored WebPosture
+	if err := json.Unmarshal(node.Data, &stored); err != nil || !stored.HSTS || stored.HSTSMaxAge != 31536000 {
+		t.Errorf("the posture was stored as %+v: %v", stored, err)
+	}
+}
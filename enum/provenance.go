@@ -0,0 +1,194 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/owasp-amass/amass/v4/provenance"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// Provenance is a single step in the chain explaining how a finding was discovered.
+type Provenance = provenance.Step
+
+type provenanceNode struct {
+	Provenance
+	event string
+}
+
+// provenanceGraph records the first derivation of each finding. A finding can only
+// reference a parent that was recorded before it, which makes cycles impossible.
+// When a store is set, the derivations are also appended to it, so the nodes of a
+// domain can be released once the domain is complete.
+type provenanceGraph struct {
+	sync.Mutex
+	nodes    map[string]*provenanceNode
+	released map[string]struct{}
+	loaded   map[string]*provenance.Chains
+	events   func(name string) string
+	store    *provenance.Backend
+	log      func(format string, v ...interface{})
+}
+
+func newProvenanceGraph() *provenanceGraph {
+	return &provenanceGraph{
+		nodes:    make(map[string]*provenanceNode),
+		released: make(map[string]struct{}),
+		loaded:   make(map[string]*provenance.Chains),
+	}
+}
+
+// persist appends the derivations recorded from now on to the store, identifying the
+// events of the findings with the events function.
+func (pg *provenanceGraph) persist(store *provenance.Backend, events func(name string) string, log func(format string, v ...interface{})) {
+	pg.Lock()
+	defer pg.Unlock()
+
+	pg.store = store
+	pg.events = events
+	pg.log = log
+}
+
+// add records the derivation of the finding, unless one has already been recorded.
+func (pg *provenanceGraph) add(name, parent, derivation string) {
+	name = strings.ToLower(name)
+	parent = strings.ToLower(parent)
+	if derivation == "" {
+		derivation = requests.DerivedFromSource
+	}
+
+	pg.Lock()
+	defer pg.Unlock()
+
+	if _, found := pg.nodes[name]; found || name == "" {
+		return
+	}
+
+	event := pg.event(name)
+	p, found := pg.nodes[parent]
+	// Addresses belong to the event of the name they were resolved from
+	if event == "" && found {
+		event = p.event
+	}
+	// Names from data sources reference the source, which ends the chain, and the
+	// parents of released domains were recorded before the domains were complete
+	if !provenance.Root(derivation) && (!found || parent == name) {
+		if _, released := pg.released[pg.event(parent)]; !released || pg.store == nil || parent == name {
+			parent = ""
+		}
+	}
+
+	node := &provenanceNode{
+		Provenance: Provenance{
+			Name:       name,
+			Parent:     parent,
+			Derivation: derivation,
+		},
+		event: event,
+	}
+	if pg.store != nil && event != "" {
+		if err := pg.store.Add(event, node.Provenance); err != nil && pg.log != nil {
+			pg.log("Provenance: %v", err)
+		}
+		delete(pg.loaded, event)
+	}
+	// The findings of a released domain are only kept by the store
+	if _, released := pg.released[event]; !released || pg.store == nil {
+		pg.nodes[name] = node
+	}
+}
+
+func (pg *provenanceGraph) event(name string) string {
+	if pg.events == nil || name == "" {
+		return ""
+	}
+	return strings.ToLower(pg.events(name))
+}
+
+// release drops the derivations recorded for the domain, which remain available from the store.
+func (pg *provenanceGraph) release(domain string) {
+	pg.Lock()
+	defer pg.Unlock()
+
+	if pg.store == nil {
+		return
+	}
+
+	domain = strings.ToLower(domain)
+	pg.released[domain] = struct{}{}
+	delete(pg.loaded, domain)
+	for name, node := range pg.nodes {
+		if node.event == domain {
+			delete(pg.nodes, name)
+		}
+	}
+}
+
+// chain returns the derivations from the finding back to a seed domain or external source.
+func (pg *provenanceGraph) chain(name string) []Provenance {
+	pg.Lock()
+	defer pg.Unlock()
+
+	var results []Provenance
+	for n := strings.ToLower(name); n != ""; {
+		p, found := pg.nodes[n]
+		if !found {
+			break
+		}
+
+		results = append(results, p.Provenance)
+		if provenance.Root(p.Derivation) {
+			break
+		}
+		n = p.Parent
+	}
+	return results
+}
+
+// stored returns the chain of the finding read from the store. The derivations of each
+// event are read once, when the first finding of the event is requested.
+func (pg *provenanceGraph) stored(name string) []Provenance {
+	pg.Lock()
+	defer pg.Unlock()
+
+	event := pg.event(name)
+	if pg.store == nil || event == "" {
+		return nil
+	}
+
+	chains, found := pg.loaded[event]
+	if !found {
+		var err error
+
+		chains, err = pg.store.Load(event)
+		if err != nil && pg.log != nil {
+			pg.log("Provenance: %v", err)
+		}
+		pg.loaded[event] = chains
+	}
+	return chains.Chain(name)
+}
+
+// Provenance returns the chain of derivations that explains how the FQDN was discovered,
+// starting with the FQDN and ending with a seed domain or an external data source. The
+// derivations of the domains already released are read from the Derivations store.
+func (e *Enumeration) Provenance(fqdn string) []Provenance {
+	if e.prov == nil {
+		return nil
+	}
+
+	results := e.prov.chain(fqdn)
+	next := fqdn
+	if n := len(results); n > 0 {
+		last := results[n-1]
+		if last.Parent == "" || provenance.Root(last.Derivation) {
+			return results
+		}
+		next = last.Parent
+	}
+	return append(results, e.prov.stored(next)...)
+}
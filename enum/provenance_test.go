@@ -0,0 +1,101 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/provenance"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestProvenanceChain(t *testing.T) {
+	pg := newProvenanceGraph()
+
+	pg.add("owasp.org", "", requests.DerivedFromSeed)
+	pg.add("www.owasp.org", "crtsh", requests.DerivedFromSource)
+	pg.add("192.168.1.1", "www.owasp.org", requests.DerivedFromA)
+	pg.add("vpn.owasp.org", "192.168.1.1", requests.DerivedFromCert)
+	pg.add("vpn1.owasp.org", "vpn.owasp.org", requests.DerivedFromAlteration)
+	// The first derivation recorded for a name is kept
+	pg.add("vpn.owasp.org", "vpn1.owasp.org", requests.DerivedFromAlteration)
+	// A parent that was not recorded earlier is dropped, so cycles cannot be created
+	pg.add("mail.owasp.org", "smtp.owasp.org", requests.DerivedFromCNAME)
+	pg.add("smtp.owasp.org", "mail.owasp.org", requests.DerivedFromMX)
+
+	expected := []Provenance{
+		{Name: "vpn1.owasp.org", Parent: "vpn.owasp.org", Derivation: requests.DerivedFromAlteration},
+		{Name: "vpn.owasp.org", Parent: "192.168.1.1", Derivation: requests.DerivedFromCert},
+		{Name: "192.168.1.1", Parent: "www.owasp.org", Derivation: requests.DerivedFromA},
+		{Name: "www.owasp.org", Parent: "crtsh", Derivation: requests.DerivedFromSource},
+	}
+	chain := pg.chain("VPN1.owasp.org")
+	if len(chain) != len(expected) {
+		t.Fatalf("Expected a chain of length %d, got %v", len(expected), chain)
+	}
+	for i, p := range expected {
+		if chain[i] != p {
+			t.Errorf("Step %d: expected %v, got %v", i, p, chain[i])
+		}
+	}
+
+	if chain := pg.chain("smtp.owasp.org"); len(chain) != 2 || chain[1].Parent != "" {
+		t.Errorf("Unexpected chain for a name derived from an unrecorded parent: %v", chain)
+	}
+	if chain := pg.chain("owasp.org"); len(chain) != 1 || chain[0].Derivation != requests.DerivedFromSeed {
+		t.Errorf("Unexpected chain for the seed domain: %v", chain)
+	}
+	if chain := pg.chain("unknown.owasp.org"); len(chain) != 0 {
+		t.Errorf("A chain was returned for an unknown name: %v", chain)
+	}
+}
+
+func TestProvenanceReleased(t *testing.T) {
+	store, err := provenance.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+
+	cfg := config.NewConfig()
+	cfg.AddDomains("owasp.org", "owasp.com")
+	e := &Enumeration{Config: cfg, prov: newProvenanceGraph(), Derivations: store.Backend("local")}
+	e.prov.persist(e.Derivations, cfg.WhichDomain, nil)
+
+	e.prov.add("owasp.org", "", requests.DerivedFromSeed)
+	e.prov.add("www.owasp.org", "crtsh", requests.DerivedFromSource)
+	e.prov.add("192.168.1.1", "www.owasp.org", requests.DerivedFromA)
+	e.prov.add("owasp.com", "", requests.DerivedFromSeed)
+	e.prov.add("www.owasp.com", "owasp.com", requests.DerivedFromBrute)
+
+	e.prov.release("owasp.org")
+	// Only the derivations of the domain still enumerated are held in memory
+	if len(e.prov.nodes) != 2 {
+		t.Errorf("The provenance graph holds %d nodes after the release, expected 2", len(e.prov.nodes))
+	}
+	if chain := e.prov.chain("www.owasp.org"); len(chain) != 0 {
+		t.Errorf("The chain of a released name is held in memory: %v", chain)
+	}
+	// A late finding derived from a name of the released domain keeps its parent
+	e.prov.add("vpn.owasp.com", "www.owasp.org", requests.DerivedFromCNAME)
+
+	chain := e.Provenance("vpn.owasp.com")
+	expected := []Provenance{
+		{Name: "vpn.owasp.com", Parent: "www.owasp.org", Derivation: requests.DerivedFromCNAME},
+		{Name: "www.owasp.org", Parent: "crtsh", Derivation: requests.DerivedFromSource},
+	}
+	if len(chain) != len(expected) {
+		t.Fatalf("Expected a chain of length %d, got %v", len(expected), chain)
+	}
+	for i, p := range expected {
+		if chain[i] != p {
+			t.Errorf("Step %d: expected %v, got %v", i, p, chain[i])
+		}
+	}
+	if chain := e.Provenance("owasp.org"); len(chain) != 1 || chain[0].Derivation != requests.DerivedFromSeed {
+		t.Errorf("Unexpected chain for the released seed domain: %v", chain)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// BruteQueryTypes include the DNS record types queried to prove that a guessed name exists.
+var BruteQueryTypes = []uint16{
+	dns.TypeCNAME,
+	dns.TypeA,
+}
+
+// queryTypes holds the record types queried for the names of an enumeration.
+type queryTypes struct {
+	// resolved are queried once a name has been proven to exist
+	resolved []uint16
+	// brute are queried to prove that brute forced and altered names exist
+	brute []uint16
+}
+
+// queryTypesFromConfig parses the 'dns' configuration options, where 'record_types' lists the types
+// queried for names that exist and 'brute_record_types' the types queried for guessed names.
+func queryTypesFromConfig(cfg *config.Config) *queryTypes {
+	var opts map[string]interface{}
+	if cfg != nil && cfg.Options != nil {
+		opts, _ = cfg.Options["dns"].(map[string]interface{})
+	}
+
+	qt := &queryTypes{
+		resolved: parseQueryTypes(stringList(opts["record_types"])),
+		brute:    parseQueryTypes(stringList(opts["brute_record_types"])),
+	}
+	if len(qt.resolved) == 0 && cfg != nil {
+		qt.resolved = parseQueryTypes(cfg.RecordTypes)
+	}
+	if len(qt.resolved) == 0 {
+		qt.resolved = FwdQueryTypes
+	}
+	if len(qt.brute) == 0 {
+		qt.brute = BruteQueryTypes
+	}
+	return qt
+}
+
+// forName returns the record types to be queried for the name. Guessed names are only
+// checked for the smaller set of types until the trusted resolvers have confirmed them.
+func (qt *queryTypes) forName(req *requests.DNSRequest, trusted bool) []uint16 {
+	if !trusted && (req.Derivation == requests.DerivedFromBrute || req.Derivation == requests.DerivedFromAlteration) {
+		return qt.brute
+	}
+	return qt.resolved
+}
+
+// parseQueryTypes converts the record type names into the types to be queried. The CNAME type
+// is always queried first, since an alias makes the other records belong to the target.
+func parseQueryTypes(names []string) []uint16 {
+	if len(names) == 0 {
+		return nil
+	}
+
+	types := []uint16{dns.TypeCNAME}
+	seen := map[uint16]struct{}{dns.TypeCNAME: {}}
+	for _, name := range names {
+		qtype, found := dns.StringToType[strings.ToUpper(strings.TrimSpace(name))]
+		if !found {
+			continue
+		}
+		if _, dup := seen[qtype]; !dup {
+			seen[qtype] = struct{}{}
+			types = append(types, qtype)
+		}
+	}
+	if len(types) == 1 {
+		return nil
+	}
+	return types
+}
+
+func stringList(v interface{}) []string {
+	var list []string
+
+	switch t := v.(type) {
+	case string:
+		list = append(list, t)
+	case []string:
+		list = append(list, t...)
+	case []interface{}:
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+func indexOfType(types []uint16, qtype uint16) int {
+	for i, t := range types {
+		if t == qtype {
+			return i
+		}
+	}
+	return -1
+}
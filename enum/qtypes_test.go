@@ -0,0 +1,75 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestParseQueryTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		expected []uint16
+	}{
+		{"Empty", nil, nil},
+		{"Address types", []string{"A", "AAAA"}, []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA}},
+		{"Mail security", []string{"mx", "txt", "caa"}, []uint16{dns.TypeCNAME, dns.TypeMX, dns.TypeTXT, dns.TypeCAA}},
+		{"CNAME moved first", []string{"A", "CNAME"}, []uint16{dns.TypeCNAME, dns.TypeA}},
+		{"Duplicates", []string{"A", "a", " A "}, []uint16{dns.TypeCNAME, dns.TypeA}},
+		{"Unknown types", []string{"BOGUS", "A"}, []uint16{dns.TypeCNAME, dns.TypeA}},
+		{"Only unknown types", []string{"BOGUS"}, nil},
+	}
+
+	for _, test := range tests {
+		if got := parseQueryTypes(test.input); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: parseQueryTypes returned %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestQueryTypesFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+
+	qt := queryTypesFromConfig(cfg)
+	if !reflect.DeepEqual(qt.resolved, FwdQueryTypes) || !reflect.DeepEqual(qt.brute, BruteQueryTypes) {
+		t.Errorf("The defaults were not used without a configuration: %v, %v", qt.resolved, qt.brute)
+	}
+
+	cfg.Options = map[string]interface{}{
+		"dns": map[string]interface{}{
+			"record_types":       []interface{}{"A", "AAAA", "MX", "TXT", "CAA"},
+			"brute_record_types": "A",
+		},
+	}
+	qt = queryTypesFromConfig(cfg)
+
+	resolved := []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA, dns.TypeMX, dns.TypeTXT, dns.TypeCAA}
+	if !reflect.DeepEqual(qt.resolved, resolved) {
+		t.Errorf("The resolved types were %v, expected %v", qt.resolved, resolved)
+	}
+	brute := []uint16{dns.TypeCNAME, dns.TypeA}
+	if !reflect.DeepEqual(qt.brute, brute) {
+		t.Errorf("The brute force types were %v, expected %v", qt.brute, brute)
+	}
+
+	guessed := &requests.DNSRequest{Name: "dev.owasp.org", Derivation: requests.DerivedFromBrute}
+	if got := qt.forName(guessed, false); !reflect.DeepEqual(got, brute) {
+		t.Errorf("The guessed name was queried for %v before being confirmed", got)
+	}
+	if got := qt.forName(guessed, true); !reflect.DeepEqual(got, resolved) {
+		t.Errorf("The confirmed name was queried for %v, expected %v", got, resolved)
+	}
+
+	found := &requests.DNSRequest{Name: "www.owasp.org", Derivation: requests.DerivedFromSource}
+	if got := qt.forName(found, false); !reflect.DeepEqual(got, resolved) {
+		t.Errorf("The discovered name was queried for %v, expected %v", got, resolved)
+	}
+}
@@ -0,0 +1,422 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/owasp-amass/amass/v4/quarantine"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	"golang.org/x/net/publicsuffix"
+)
+
+// QuarantineFile is the name of the file under the output directory holding the findings quarantined by the enumeration.
+const QuarantineFile = "quarantined.json"
+
+// defaultLowReputation holds the types of the sources whose names are quarantined when no other source provides them.
+var defaultLowReputation = []string{"archive", "scrape"}
+
+// quarantineRules sets the suspicious findings apart from the output for a review, instead of sending them
+// with the results or discarding them. The decisions made on the findings by earlier runs are honoured.
+type quarantineRules struct {
+	sync.Mutex
+	singleSource bool
+	lowRep       map[string]struct{}
+	wildcard     bool
+	adjacent     bool
+	held         map[string]*heldFinding
+	rejected     int
+}
+
+// heldFinding is a finding quarantined during the enumeration, along with the output it was kept from.
+type heldFinding struct {
+	entry quarantine.Entry
+	out   *requests.Output
+}
+
+// quarantineFromConfig parses the 'quarantine' configuration options, and returns nil unless the quarantine
+// is enabled. Each rule is on by default, and the single source rule only applies to the types of sources
+// most often providing stale names.
+func quarantineFromConfig(cfg *config.Config) *quarantineRules {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["quarantine"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, ok := opts["enabled"].(bool); !ok || !enabled {
+		return nil
+	}
+
+	qr := &quarantineRules{
+		singleSource: true,
+		lowRep:       make(map[string]struct{}),
+		wildcard:     true,
+		adjacent:     true,
+		held:         make(map[string]*heldFinding),
+	}
+	if v, ok := opts["single_source"].(bool); ok {
+		qr.singleSource = v
+	}
+	if v, ok := opts["wildcard"].(bool); ok {
+		qr.wildcard = v
+	}
+	if v, ok := opts["adjacent"].(bool); ok {
+		qr.adjacent = v
+	}
+
+	tags := defaultLowReputation
+	if list := stringList(opts["low_reputation"]); len(list) > 0 {
+		tags = list
+	}
+	for _, tag := range tags {
+		qr.lowRep[strings.ToLower(strings.TrimSpace(tag))] = struct{}{}
+	}
+	return qr
+}
+
+// classify returns the rule matching the evidence of the name resolved within the scope, along with the reason.
+func (qr *quarantineRules) classify(nc NameConfidence) (string, string) {
+	if qr.wildcard && nc.Wildcard {
+		return quarantine.RuleWildcard, "the name sits under a DNS wildcard, which the resolution did not rule it out of"
+	}
+	if qr.singleSource && len(nc.Sources) == 1 && len(nc.Tags) == 1 {
+		if _, found := qr.lowRep[nc.Tags[0]]; found {
+			return quarantine.RuleSingleSource, fmt.Sprintf("the name was only provided by %s, a source of the %s type", nc.Sources[0], nc.Tags[0])
+		}
+	}
+	return "", ""
+}
+
+// quarantineOutput returns true when the name on its way to the output channel is quarantined, or was rejected.
+func (e *Enumeration) quarantineOutput(out *requests.Output) bool {
+	qr := e.quarantine
+	if qr == nil {
+		return false
+	}
+
+	nc, _ := e.Confidence(out.Name)
+	rule, reason := qr.classify(nc)
+	if rule == "" {
+		return false
+	}
+
+	return e.hold(quarantine.Entry{
+		Event:     e.Config.WhichDomain(out.Name),
+		Name:      strings.ToLower(out.Name),
+		Rule:      rule,
+		Reason:    reason,
+		Sources:   nc.Sources,
+		Addresses: outputAddresses(out),
+	}, out)
+}
+
+// quarantineAdjacent returns true when the name outside of the scope is quarantined, or was rejected. The names
+// under a domain sharing its label with a domain in scope, such as owasp.com for owasp.org, likely belong to the
+// same organization. A name already promoted brings its domain into the scope.
+func (e *Enumeration) quarantineAdjacent(req *requests.DNSRequest) bool {
+	qr := e.quarantine
+	if qr == nil || !qr.adjacent || req == nil {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Name), "."))
+	event, apex := e.adjacentEvent(name)
+	if event == "" {
+		return false
+	}
+
+	out := requestToOutput(req)
+	out.Name, out.Domain = name, apex
+	return e.hold(quarantine.Entry{
+		Event:     event,
+		Name:      name,
+		Domain:    apex,
+		Rule:      quarantine.RuleAdjacent,
+		Reason:    fmt.Sprintf("the name is outside of the scope, under %s sharing its label with %s", apex, event),
+		Sources:   []string{findingSource(req)},
+		Addresses: outputAddresses(out),
+	}, out)
+}
+
+// adjacentEvent returns the domain in scope sharing its label with the registrable domain of the name outside
+// of the scope, along with the registrable domain.
+func (e *Enumeration) adjacentEvent(name string) (string, string) {
+	if name == "" || e.Config.WhichDomain(name) != "" {
+		return "", ""
+	}
+
+	apex, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return "", ""
+	}
+	label, _, _ := strings.Cut(apex, ".")
+
+	for _, d := range e.Config.Domains() {
+		dapex, err := publicsuffix.EffectiveTLDPlusOne(d)
+		if err != nil || dapex == apex {
+			continue
+		}
+		if l, _, _ := strings.Cut(dapex, "."); l == label {
+			return d, apex
+		}
+	}
+	return "", ""
+}
+
+// hold quarantines the finding unless a decision was made on it, and returns true when it is kept from the output.
+func (e *Enumeration) hold(en quarantine.Entry, out *requests.Output) bool {
+	qr := e.quarantine
+	if en.Event == "" {
+		return false
+	}
+
+	state, err := e.Quarantine.Add(en)
+	if err != nil {
+		e.Config.Log.Printf("Quarantine: %v", err)
+		return false
+	}
+
+	switch state {
+	case quarantine.Promoted:
+		if en.Rule == quarantine.RuleAdjacent {
+			e.promoteAdjacent(en.Domain, en.Name)
+		}
+		return false
+	case quarantine.Rejected:
+		qr.Lock()
+		qr.rejected++
+		qr.Unlock()
+		return true
+	}
+
+	qr.Lock()
+	defer qr.Unlock()
+
+	if _, found := qr.held[en.Name]; !found {
+		qr.held[en.Name] = &heldFinding{entry: en, out: out}
+	}
+	return true
+}
+
+// promoteAdjacent brings the domain outside of the scope into it, so the enumeration finds the names under it,
+// starting with the names promoted from the quarantine.
+func (e *Enumeration) promoteAdjacent(apex string, names ...string) {
+	if apex == "" || e.Config.WhichDomain(apex) != "" {
+		return
+	}
+
+	e.Config.AddDomain(apex)
+	e.Config.Log.Printf("Promoting %s into the scope of the enumeration from the quarantine", apex)
+	if !e.running() {
+		return
+	}
+
+	req := &requests.DNSRequest{
+		Name:       apex,
+		Domain:     apex,
+		Derivation: requests.DerivedFromSeed,
+	}
+	e.prov.add(apex, "", requests.DerivedFromSeed)
+	e.nameSrc.newName(req)
+	e.sendRequests(req.Clone().(*requests.DNSRequest))
+	e.regs.domain(apex)
+
+	for _, name := range names {
+		if name != apex {
+			e.nameSrc.newName(&requests.DNSRequest{Name: name, Domain: apex, Derivation: requests.DerivedFromSeed})
+		}
+	}
+}
+
+// addPromotedDomains brings the domains of the adjacent names promoted by earlier runs into the scope.
+func (e *Enumeration) addPromotedDomains() {
+	if e.quarantine == nil {
+		return
+	}
+
+	for _, d := range e.Config.Domains() {
+		for _, en := range e.Quarantine.List(d) {
+			if en.State == quarantine.Promoted && en.Rule == quarantine.RuleAdjacent && en.Domain != "" {
+				e.Config.AddDomain(en.Domain)
+			}
+		}
+	}
+}
+
+// running returns true while the enumeration has been started and is not finished.
+func (e *Enumeration) running() bool {
+	if e.done == nil || e.nameSrc == nil {
+		return false
+	}
+
+	select {
+	case <-e.done:
+		return false
+	default:
+	}
+	return true
+}
+
+// Promote moves the name quarantined by the enumeration into the results, and persists the decision. While the
+// enumeration is running, the name is sent on the output channel, and the domain of an adjacent name is brought
+// into the scope so the names under it are enumerated as well.
+func (e *Enumeration) Promote(name string) error {
+	hf, event := e.release(name)
+	if event == "" {
+		return fmt.Errorf("the name %s was not quarantined by the enumeration", name)
+	}
+	if err := e.Quarantine.Promote(event, name); err != nil && e.Quarantine != nil {
+		return err
+	}
+	if hf == nil {
+		return nil
+	}
+
+	if hf.entry.Rule == quarantine.RuleAdjacent {
+		e.promoteAdjacent(hf.entry.Domain, hf.entry.Name)
+		return nil
+	}
+	if e.running() && e.Output != nil && e.confidence != nil && e.confidence.emit(hf.out, len(hf.out.Addresses) > 0) {
+		e.confidence.updates.Append(hf.out)
+	}
+	return nil
+}
+
+// Reject excludes the name quarantined by the enumeration from the results for good, and persists the decision.
+func (e *Enumeration) Reject(name string) error {
+	hf, event := e.release(name)
+	if event == "" {
+		return fmt.Errorf("the name %s was not quarantined by the enumeration", name)
+	}
+	if err := e.Quarantine.Reject(event, name); err != nil && e.Quarantine != nil {
+		return err
+	}
+	if hf != nil {
+		e.quarantine.Lock()
+		e.quarantine.rejected++
+		e.quarantine.Unlock()
+	}
+	return nil
+}
+
+// release removes the name from the findings held by the enumeration, and returns the event that quarantined it.
+func (e *Enumeration) release(name string) (*heldFinding, string) {
+	qr := e.quarantine
+	if qr == nil {
+		return nil, ""
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	qr.Lock()
+	hf, found := qr.held[name]
+	delete(qr.held, name)
+	qr.Unlock()
+	if found {
+		return hf, hf.entry.Event
+	}
+
+	event := e.Config.WhichDomain(name)
+	if event == "" {
+		event, _ = e.adjacentEvent(name)
+	}
+	if _, found := e.Quarantine.State(event, name); !found {
+		return nil, ""
+	}
+	return nil, event
+}
+
+// Quarantined returns true when the name was quarantined during the enumeration, or by an earlier run and not
+// promoted since, so the name is left out of the results.
+func (e *Enumeration) Quarantined(name string) bool {
+	qr := e.quarantine
+	if qr == nil {
+		return false
+	}
+	name = strings.ToLower(name)
+
+	qr.Lock()
+	_, held := qr.held[name]
+	qr.Unlock()
+	if held {
+		return true
+	}
+
+	state, found := e.Quarantine.State(e.Config.WhichDomain(name), name)
+	return found && state != quarantine.Promoted
+}
+
+// QuarantinedFindings returns the findings quarantined during the enumeration, sorted by the name.
+func (e *Enumeration) QuarantinedFindings() []quarantine.Entry {
+	qr := e.quarantine
+	if qr == nil {
+		return nil
+	}
+
+	qr.Lock()
+	list := make([]quarantine.Entry, 0, len(qr.held))
+	for _, hf := range qr.held {
+		list = append(list, hf.entry)
+	}
+	qr.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// finishQuarantine sends the names provided by a single source on the output channel, once other sources have
+// corroborated them during the enumeration, and logs the findings left in the quarantine.
+func (e *Enumeration) finishQuarantine() {
+	qr := e.quarantine
+	if qr == nil {
+		return
+	}
+
+	var released []*heldFinding
+	qr.Lock()
+	for name, hf := range qr.held {
+		if hf.entry.Rule != quarantine.RuleSingleSource {
+			continue
+		}
+		if nc, _ := e.Confidence(name); nc.Name != "" {
+			if rule, _ := qr.classify(nc); rule == "" {
+				delete(qr.held, name)
+				released = append(released, hf)
+			}
+		}
+	}
+	held, rejected := len(qr.held), qr.rejected
+	qr.Unlock()
+
+	for _, hf := range released {
+		e.Quarantine.Release(hf.entry.Event, hf.entry.Name)
+		if e.Output != nil && e.confidence != nil && e.confidence.emit(hf.out, len(hf.out.Addresses) > 0) {
+			e.confidence.updates.Append(hf.out)
+		}
+	}
+	if held > 0 {
+		e.Config.Log.Printf("%d suspicious findings were quarantined for a review", held)
+	}
+	if rejected > 0 {
+		e.Config.Log.Printf("%d findings rejected during a review were left out of the output", rejected)
+	}
+}
+
+func outputAddresses(out *requests.Output) []string {
+	var addrs []string
+	for _, a := range out.Addresses {
+		if a.Address != nil {
+			addrs = append(addrs, a.Address.String())
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
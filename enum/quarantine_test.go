@@ -0,0 +1,180 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/quarantine"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestQuarantineFromConfig(t *testing.T) {
+	if quarantineFromConfig(config.NewConfig()) != nil {
+		t.Error("the quarantine was enabled without its options")
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"quarantine": map[string]interface{}{"enabled": true}}
+	qr := quarantineFromConfig(cfg)
+	if qr == nil || !qr.singleSource || !qr.wildcard || !qr.adjacent || len(qr.lowRep) != len(defaultLowReputation) {
+		t.Fatalf("the default rules were not used: %+v", qr)
+	}
+
+	cfg.Options = map[string]interface{}{"quarantine": map[string]interface{}{
+		"enabled":        true,
+		"wildcard":       false,
+		"low_reputation": []interface{}{"Crawl"},
+	}}
+	qr = quarantineFromConfig(cfg)
+	if qr == nil || qr.wildcard || !qr.adjacent {
+		t.Fatalf("the rules were not parsed: %+v", qr)
+	}
+	if _, found := qr.lowRep["crawl"]; !found || len(qr.lowRep) != 1 {
+		t.Errorf("the low reputation types were not parsed: %v", qr.lowRep)
+	}
+}
+
+// quarantineEnumeration returns an enumeration quarantining the findings into the store.
+func quarantineEnumeration(t *testing.T, store *quarantine.Store) *Enumeration {
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.Options = map[string]interface{}{"quarantine": map[string]interface{}{"enabled": true}}
+
+	e := &Enumeration{
+		Config:     cfg,
+		Output:     make(chan *requests.Output, 10),
+		Quarantine: store.Backend("local"),
+		confidence: confidenceFromConfig(cfg),
+		quarantine: quarantineFromConfig(cfg),
+	}
+	e.confidence.srcTags["Wayback"] = "archive"
+	e.confidence.srcTags["Crtsh"] = "cert"
+	e.confidence.srcTags["Shodan"] = "api"
+	return e
+}
+
+func TestQuarantineOutput(t *testing.T) {
+	store, err := quarantine.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := quarantineEnumeration(t, store)
+	cs := e.confidence
+
+	cs.corroborate(sourceRequest("old.owasp.org", "Wayback"))
+	cs.corroborate(sourceRequest("app.owasp.org", "Crtsh"))
+	cs.markWildcard("app.owasp.org")
+	cs.corroborate(sourceRequest("www.owasp.org", "Crtsh"))
+	// A single source of a reputable type is not suspicious
+	cs.corroborate(sourceRequest("api.owasp.org", "Shodan"))
+
+	for name, quarantined := range map[string]bool{
+		"old.owasp.org": true,
+		"app.owasp.org": true,
+		"www.owasp.org": false,
+		"api.owasp.org": false,
+	} {
+		if got := e.quarantineOutput(&requests.Output{Name: name, Domain: "owasp.org"}); got != quarantined {
+			t.Errorf("the quarantine of %s was %t, expected %t", name, got, quarantined)
+		}
+		if e.Quarantined(name) != quarantined {
+			t.Errorf("%s was not reported as quarantined", name)
+		}
+	}
+
+	list := e.QuarantinedFindings()
+	if len(list) != 2 || list[0].Rule != quarantine.RuleWildcard || list[1].Rule != quarantine.RuleSingleSource {
+		t.Fatalf("the findings quarantined were %+v", list)
+	}
+	if got := e.Quarantine.List("owasp.org"); len(got) != 2 {
+		t.Errorf("the store holds %d findings", len(got))
+	}
+
+	// The name corroborated by another source after it was quarantined is sent once the enumeration is done
+	cs.corroborate(sourceRequest("old.owasp.org", "Shodan"))
+	e.finishQuarantine()
+	if e.Quarantined("old.owasp.org") || cs.updates.Len() != 1 {
+		t.Errorf("the corroborated name was not released from the quarantine")
+	}
+}
+
+func TestQuarantineDecisions(t *testing.T) {
+	store, err := quarantine.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := quarantineEnumeration(t, store)
+	e.confidence.corroborate(sourceRequest("old.owasp.org", "Wayback"))
+	e.confidence.corroborate(sourceRequest("test.owasp.org", "Wayback"))
+	_ = e.quarantineOutput(&requests.Output{Name: "old.owasp.org", Domain: "owasp.org"})
+	_ = e.quarantineOutput(&requests.Output{Name: "test.owasp.org", Domain: "owasp.org"})
+
+	if err := e.Promote("old.owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reject("test.owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Promote("www.owasp.org"); err == nil {
+		t.Error("the name that was never quarantined was promoted")
+	}
+	if e.Quarantined("old.owasp.org") || !e.Quarantined("test.owasp.org") {
+		t.Error("the decisions did not change the names left out of the results")
+	}
+
+	// The next run honours the decisions
+	e = quarantineEnumeration(t, store)
+	e.confidence.corroborate(sourceRequest("old.owasp.org", "Wayback"))
+	e.confidence.corroborate(sourceRequest("test.owasp.org", "Wayback"))
+	if e.quarantineOutput(&requests.Output{Name: "old.owasp.org", Domain: "owasp.org"}) {
+		t.Error("the promoted name was quarantined again")
+	}
+	if !e.quarantineOutput(&requests.Output{Name: "test.owasp.org", Domain: "owasp.org"}) {
+		t.Error("the rejected name was sent with the results")
+	}
+	if n := len(e.QuarantinedFindings()); n != 0 {
+		t.Errorf("%d names with decisions were quarantined again", n)
+	}
+}
+
+func TestQuarantineAdjacent(t *testing.T) {
+	store, err := quarantine.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := quarantineEnumeration(t, store)
+
+	req := &requests.DNSRequest{Name: "www.owasp.com", Records: []requests.DNSAnswer{
+		{Name: "www.owasp.com", Type: int(dns.TypeA), Data: "192.0.2.1"},
+	}}
+	if !e.quarantineAdjacent(req) {
+		t.Fatal("the adjacent name was not quarantined")
+	}
+	if e.quarantineAdjacent(&requests.DNSRequest{Name: "www.example.com"}) {
+		t.Error("the unrelated name was quarantined")
+	}
+
+	en, found := e.Quarantine.Get("owasp.org", "www.owasp.com")
+	if !found || en.Domain != "owasp.com" || en.Rule != quarantine.RuleAdjacent || len(en.Addresses) != 1 {
+		t.Fatalf("the adjacent name was quarantined as %+v", en)
+	}
+
+	// The promotion brings the domain into the scope, and the next run enumerates it from the start
+	if err := e.Promote("www.owasp.com"); err != nil {
+		t.Fatal(err)
+	}
+	if e.Config.WhichDomain("www.owasp.com") != "owasp.com" {
+		t.Error("the domain of the promoted name was not brought into the scope")
+	}
+
+	e = quarantineEnumeration(t, store)
+	e.addPromotedDomains()
+	if e.Config.WhichDomain("www.owasp.com") != "owasp.com" {
+		t.Error("the domain promoted by the earlier run was not brought into the scope")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// runSeededEnumeration performs a complete enumeration of the scenario with the seed, and returns
+// its output as JSON Lines ordered by name, along with the metadata of the run.
+func runSeededEnumeration(t *testing.T, sc *benchScenario, addr string, seed int64) ([]byte, map[string]string) {
+	cfg := config.NewConfig()
+	cfg.AddDomain(sc.domain)
+	cfg.ResolversQPS = benchQPS
+	cfg.TrustedQPS = benchQPS
+	cfg.Options["seed"] = seed
+	// The dispatch order and the delays of the queries are drawn from the seed as well
+	cfg.Options["opsec"] = map[string]interface{}{"enabled": true, "jitter_max": 5, "source_stagger": 0}
+
+	pool := resolve.NewResolvers()
+	_ = pool.AddResolvers(benchQPS, addr)
+	trusted := resolve.NewResolvers()
+	_ = trusted.AddResolvers(benchQPS, addr)
+	trusted.SetDetectionResolver(benchQPS, addr)
+	defer pool.Stop()
+	defer trusted.Stop()
+
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	sys := &systems.SimpleSystem{
+		Cfg:      cfg,
+		Pool:     pool,
+		Trusted:  trusted,
+		Graph:    g,
+		ASNCache: requests.NewASNCache(),
+	}
+	if err := sys.AddAndStart(newBenchSource(sc.candidates)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sys.Shutdown() }()
+
+	e := NewEnumeration(cfg, sys, g)
+	e.Output = make(chan *requests.Output, 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	outputs := make(map[string]*requests.Output)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for out := range e.Output {
+			outputs[out.Name] = out
+		}
+	}()
+	err := e.Start(ctx)
+	// The outputs still buffered once the enumeration is done are received as well
+	close(e.Output)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, name := range names {
+		_ = enc.Encode(outputs[name])
+	}
+	return buf.Bytes(), e.Metadata()
+}
+
+func TestReproducibleRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the enumerations are skipped in the short mode")
+	}
+
+	sc := newBenchScenario(benchSeed, 100)
+	addr, stop := startFakeResolver(t, sc)
+	defer stop()
+
+	first, md := runSeededEnumeration(t, sc, addr, 42)
+	second, _ := runSeededEnumeration(t, sc, addr, 42)
+	if len(first) == 0 {
+		t.Fatal("the enumeration produced no output")
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("the runs with the same seed produced different output:\n%s\n%s", first, second)
+	}
+	if md["seed"] != "42" || md["opsec_seed"] != "42" {
+		t.Errorf("the seed was recorded as %v", md)
+	}
+}
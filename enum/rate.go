@@ -0,0 +1,50 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/config/config"
+)
+
+// dnsLimiterFromConfig returns the limiter keeping the untrusted queries within the maximum DNS
+// queries per second, and the 'dns.burst' option bounds the queries sent at once after a pause.
+func dnsLimiterFromConfig(cfg *config.Config, c clock.Clock) *rate.Limiter {
+	if cfg == nil || cfg.MaxDNSQueries <= 0 {
+		return nil
+	}
+
+	var burst int
+	if cfg.Options != nil {
+		if opts, ok := cfg.Options["dns"].(map[string]interface{}); ok {
+			burst = options.Int(opts["burst"])
+		}
+	}
+	return rate.NewLimiter(cfg.MaxDNSQueries, burst, c)
+}
+
+// limitedPool waits on the limiter before sending each query to the pool.
+type limitedPool struct {
+	Pool
+	limiter *rate.Limiter
+}
+
+func (lp *limitedPool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	// The pool answers a query on a context that is done without sending it
+	_ = lp.limiter.TakeContext(ctx)
+	lp.Pool.Query(ctx, msg, ch)
+}
+
+func (lp *limitedPool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if err := lp.limiter.TakeContext(ctx); err != nil {
+		return msg, err
+	}
+	return lp.Pool.QueryBlocking(ctx, msg)
+}
@@ -0,0 +1,88 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// timedPool records the time on the clock when each query is sent.
+type timedPool struct {
+	clock *clock.Fake
+	sent  []time.Time
+}
+
+func (tp *timedPool) Len() int { return 1 }
+
+func (tp *timedPool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	tp.sent = append(tp.sent, tp.clock.Now())
+}
+
+func (tp *timedPool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	tp.sent = append(tp.sent, tp.clock.Now())
+	return msg, nil
+}
+
+func TestDNSLimiterFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if l := dnsLimiterFromConfig(cfg, nil); l != nil {
+		t.Error("a limiter was returned without a maximum number of DNS queries")
+	}
+
+	cfg.MaxDNSQueries = 500
+	cfg.Options = map[string]interface{}{"dns": map[string]interface{}{"burst": 25}}
+	if l := dnsLimiterFromConfig(cfg, nil); l == nil {
+		t.Error("no limiter was returned for the maximum number of DNS queries")
+	}
+}
+
+func TestLimitedPoolClockJump(t *testing.T) {
+	qps, burst := 200, 20
+	cfg := config.NewConfig()
+	cfg.MaxDNSQueries = qps
+	cfg.Options = map[string]interface{}{"dns": map[string]interface{}{"burst": burst}}
+
+	c := clock.NewFake(time.Now())
+	tp := &timedPool{clock: c}
+	pool := &limitedPool{Pool: tp, limiter: dnsLimiterFromConfig(cfg, c)}
+
+	ctx := context.Background()
+	for i := 0; i < qps; i++ {
+		pool.Query(ctx, resolve.QueryMsg("www.owasp.org", dns.TypeA), nil)
+	}
+	// Simulate the host being paused by a live migration
+	c.Jump(10 * time.Minute)
+	jumped := c.Now()
+
+	tp.sent = nil
+	for i := 0; i < 5*qps; i++ {
+		if _, err := pool.QueryBlocking(ctx, resolve.QueryMsg("www.owasp.org", dns.TypeA)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var immediate int
+	for _, s := range tp.sent {
+		if s.Equal(jumped) {
+			immediate++
+		}
+	}
+	if immediate > burst {
+		t.Errorf("%d queries were sent at once after the clock jump, exceeding the burst of %d", immediate, burst)
+	}
+	// After the burst has been spent, the queries are spaced by the configured rate
+	for i := burst; i < len(tp.sent); i++ {
+		if i > burst && tp.sent[i].Sub(tp.sent[i-1]) < time.Second/time.Duration(qps) {
+			t.Fatalf("query %d was sent %v after the previous one, faster than %d QPS", i, tp.sent[i].Sub(tp.sent[i-1]), qps)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultMinChildren is the number of distinct child names from data sources that makes a subdomain worth recursing into.
+const DefaultMinChildren = 3
+
+// DefaultRecursionAllow holds the label patterns that are recursed into as soon as they are discovered.
+var DefaultRecursionAllow = []string{
+	`^(dev|develop|development)\d*$`,
+	`^(stage|staging|stg|uat|qa|test)\d*$`,
+	`^(internal|intranet|int|corp|corporate)$`,
+}
+
+// DefaultRecursionDeny holds the label patterns that are never recursed into, such as content delivery
+// networks and mail protection providers, which host large numbers of names that belong to their customers.
+var DefaultRecursionDeny = []string{
+	`^cdn\d*$`,
+	`^(akamai|akamaiedge|edgekey|edgesuite|cloudfront|fastly|edgecast)$`,
+	`^(pphosted|mimecast|mailcontrol|messagelabs|barracuda|mail-protection|protection)$`,
+}
+
+// recursionGate decides which subdomains the brute forcing recurses into, since recursing into every
+// label explodes the search space. A subdomain is opened once it has enough distinct child names found
+// by means other than brute forcing, or immediately when its label matches the allowlist.
+type recursionGate struct {
+	sync.Mutex
+	cfg         *config.Config
+	minChildren int
+	allow       []*regexp.Regexp
+	deny        []*regexp.Regexp
+	children    map[string]map[string]struct{}
+	decided     map[string]bool
+}
+
+// recursionGateFromConfig parses the 'recursion' configuration options. The gate is only
+// enabled when the section is present, so the recursion is otherwise left unchanged.
+func recursionGateFromConfig(cfg *config.Config) *recursionGate {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["recursion"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	rg := &recursionGate{
+		cfg:         cfg,
+		minChildren: DefaultMinChildren,
+		allow:       compilePatterns(cfg, stringList(opts["allow"])),
+		deny:        compilePatterns(cfg, stringList(opts["deny"])),
+		children:    make(map[string]map[string]struct{}),
+		decided:     make(map[string]bool),
+	}
+	if n := options.Int(opts["min_children"]); n > 0 {
+		rg.minChildren = n
+	}
+	if _, found := opts["allow"]; !found {
+		rg.allow = compilePatterns(cfg, DefaultRecursionAllow)
+	}
+	if _, found := opts["deny"]; !found {
+		rg.deny = compilePatterns(cfg, DefaultRecursionDeny)
+	}
+	return rg
+}
+
+// observe records the name discovered under the subdomain and returns true the first
+// time the subdomain is opened for the brute forcing to recurse into.
+func (rg *recursionGate) observe(name, sub, derivation string) bool {
+	name = strings.ToLower(name)
+	sub = strings.ToLower(sub)
+
+	rg.Lock()
+	defer rg.Unlock()
+
+	if _, done := rg.decided[sub]; done {
+		return false
+	}
+
+	label := strings.SplitN(sub, ".", 2)[0]
+	if re := matchPattern(rg.deny, label); re != nil {
+		rg.decide(sub, false, "the label matches the denylist pattern "+re.String())
+		return false
+	}
+	if re := matchPattern(rg.allow, label); re != nil {
+		rg.decide(sub, true, "the label matches the allowlist pattern "+re.String())
+		return true
+	}
+	// Names guessed by the brute forcing do not make a subdomain more interesting
+	if derivation == requests.DerivedFromBrute {
+		return false
+	}
+
+	set, found := rg.children[sub]
+	if !found {
+		set = make(map[string]struct{})
+		rg.children[sub] = set
+	}
+	set[name] = struct{}{}
+
+	if len(set) >= rg.minChildren {
+		rg.decide(sub, true, fmt.Sprintf("it has %d distinct child names from sources other than brute forcing", len(set)))
+		return true
+	}
+	return false
+}
+
+func (rg *recursionGate) decide(sub string, open bool, reason string) {
+	rg.decided[sub] = open
+	delete(rg.children, sub)
+
+	if rg.cfg.Verbose && rg.cfg.Log != nil {
+		if open {
+			rg.cfg.Log.Printf("Recursive brute forcing of %s: %s", sub, reason)
+		} else {
+			rg.cfg.Log.Printf("Skipping recursive brute forcing of %s: %s", sub, reason)
+		}
+	}
+}
+
+// bruteRecursion carries a subdomain opened by the recursion gate to the brute forcing data sources only.
+type bruteRecursion struct {
+	req *requests.SubdomainRequest
+}
+
+// routeRequest returns the request to be sent to the data source and whether it should be sent.
+// While the recursion gate is enabled, the brute forcing only recurses into the subdomains it opens.
+func (e *Enumeration) routeRequest(src service.Service, element interface{}) (interface{}, bool) {
+	brute := src.Description() == "brute"
+
+	switch v := element.(type) {
+	case *bruteRecursion:
+		return v.req, brute
+	case *certZoneProbe:
+		return v.req, brute || src.Description() == "dns"
+	case *requests.SubdomainRequest, *requests.ResolvedRequest:
+		return element, e.recursion == nil || !brute
+	case *requests.DNSRequest:
+		// A delta enumeration does not query the data sources again for the root domain names while they are fresh
+		if e.delta != nil && v.Name == v.Domain && e.delta.fresh(v.Domain, src.String(), src.Description(), e.clock.Now()) {
+			return element, false
+		}
+		// The data sources are queried for the root domain name by the shard it is assigned to, while the
+		// brute forcing and alterations generate the candidates in every shard and keep those of their own
+		if v.Name == v.Domain && !brute && src.Description() != "alt" && !e.shard.owns(v.Domain) {
+			return element, false
+		}
+	}
+	return element, true
+}
+
+func compilePatterns(cfg *config.Config, patterns []string) []*regexp.Regexp {
+	var res []*regexp.Regexp
+
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			if cfg.Log != nil {
+				cfg.Log.Printf("Ignoring the recursion pattern %s: %v", p, err)
+			}
+			continue
+		}
+		res = append(res, re)
+	}
+	return res
+}
+
+func matchPattern(patterns []*regexp.Regexp, label string) *regexp.Regexp {
+	for _, re := range patterns {
+		if re.MatchString(label) {
+			return re
+		}
+	}
+	return nil
+}
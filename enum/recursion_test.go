@@ -0,0 +1,191 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+type fixtureName struct {
+	name       string
+	derivation string
+}
+
+// recursionFixture returns the names discovered by the data sources of a large organization,
+// and the names that can only be found by brute forcing the subdomains they belong to.
+func recursionFixture() ([]fixtureName, []string) {
+	var names []fixtureName
+	add := func(name string) {
+		names = append(names, fixtureName{name: name, derivation: requests.DerivedFromSource})
+	}
+
+	// Customer sites hosted by a CDN, each reported as a single name
+	for i := 0; i < 400; i++ {
+		add(fmt.Sprintf("customer%d.cdn.owasp.org", i))
+	}
+	// Marketing sites, each reported with a single child name
+	for i := 0; i < 300; i++ {
+		add(fmt.Sprintf("www.campaign%d.owasp.org", i))
+	}
+	// Mail protection records of the regional offices
+	for i := 0; i < 50; i++ {
+		add(fmt.Sprintf("mx%d.pphosted.owasp.org", i))
+	}
+	// Regional networks with a few names each
+	for _, region := range []string{"eu", "us", "apac"} {
+		for _, host := range []string{"vpn", "portal", "sso"} {
+			add(host + "." + region + ".owasp.org")
+		}
+	}
+	add("api.dev.owasp.org")
+	add("jenkins.corp.owasp.org")
+	// Brute forced names never make a subdomain interesting
+	for i := 0; i < 10; i++ {
+		names = append(names, fixtureName{
+			name:       fmt.Sprintf("host%d.guessed.owasp.org", i),
+			derivation: requests.DerivedFromBrute,
+		})
+	}
+
+	hidden := []string{
+		"admin.dev.owasp.org",
+		"git.corp.owasp.org",
+		"mail.eu.owasp.org",
+		"intranet.us.owasp.org",
+		"wiki.apac.owasp.org",
+	}
+	return names, hidden
+}
+
+func TestRecursionGateFixture(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["recursion"] = map[string]interface{}{"min_children": 3}
+	rg := recursionGateFromConfig(cfg)
+	if rg == nil {
+		t.Fatal("the recursion gate was not enabled")
+	}
+
+	names, hidden := recursionFixture()
+	subs := make(map[string]struct{})
+	opened := make(map[string]struct{})
+	for _, n := range names {
+		sub := strings.SplitN(n.name, ".", 2)[1]
+
+		subs[sub] = struct{}{}
+		if rg.observe(n.name, sub, n.derivation) {
+			if _, dup := opened[sub]; dup {
+				t.Errorf("the subdomain %s was opened more than once", sub)
+			}
+			opened[sub] = struct{}{}
+		}
+	}
+
+	// Without the gate, every subdomain with a discovered name is brute forced
+	wordlist := 1000
+	before, after := len(subs)*wordlist, len(opened)*wordlist
+	if after*20 > before {
+		t.Errorf("the gate reduced the brute force candidates from %d to %d", before, after)
+	}
+	// The names hidden under the discovered subdomains can still be found
+	for _, name := range hidden {
+		if _, found := opened[strings.SplitN(name, ".", 2)[1]]; !found {
+			t.Errorf("the subdomain of %s was not opened", name)
+		}
+	}
+	for _, sub := range []string{"cdn.owasp.org", "pphosted.owasp.org", "guessed.owasp.org", "campaign1.owasp.org"} {
+		if _, found := opened[sub]; found {
+			t.Errorf("the subdomain %s was opened", sub)
+		}
+	}
+}
+
+func TestRecursionGateConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if rg := recursionGateFromConfig(cfg); rg != nil {
+		t.Errorf("the recursion gate was enabled without the options")
+	}
+
+	cfg.Options["recursion"] = map[string]interface{}{
+		"min_children": 2,
+		"allow":        []interface{}{"^lab$"},
+		"deny":         []interface{}{"^static$", "("},
+	}
+	rg := recursionGateFromConfig(cfg)
+	if rg == nil || rg.minChildren != 2 || len(rg.allow) != 1 || len(rg.deny) != 1 {
+		t.Fatalf("the options were not parsed: %+v", rg)
+	}
+
+	tests := []struct {
+		name     string
+		sub      string
+		expected bool
+	}{
+		{"a.LAB.owasp.org", "LAB.owasp.org", true},
+		{"a.static.owasp.org", "static.owasp.org", false},
+		{"b.static.owasp.org", "static.owasp.org", false},
+		{"www.dev.owasp.org", "dev.owasp.org", false},
+		{"ftp.dev.owasp.org", "dev.owasp.org", true},
+		{"vpn.dev.owasp.org", "dev.owasp.org", false},
+	}
+	for _, test := range tests {
+		if got := rg.observe(test.name, test.sub, requests.DerivedFromSource); got != test.expected {
+			t.Errorf("observing %s returned %t, expected %t", test.name, got, test.expected)
+		}
+	}
+}
+
+type fakeSource struct {
+	*service.BaseService
+	stype string
+}
+
+func newFakeSource(name, stype string) *fakeSource {
+	fs := &fakeSource{stype: stype}
+	fs.BaseService = service.NewBaseService(fs, name)
+	return fs
+}
+
+func (fs *fakeSource) Description() string { return fs.stype }
+
+func TestRouteRequest(t *testing.T) {
+	brute := newFakeSource("Brute Forcing", "brute")
+	api := newFakeSource("API", "api")
+	sub := &requests.SubdomainRequest{Name: "dev.owasp.org", Domain: "owasp.org", Times: 1}
+	opened := &bruteRecursion{req: sub}
+
+	e := &Enumeration{}
+	if _, ok := e.routeRequest(brute, sub); !ok {
+		t.Errorf("the subdomain was not sent to the brute forcing without the gate")
+	}
+
+	e.recursion = &recursionGate{}
+	tests := []struct {
+		src      service.Service
+		element  interface{}
+		expected bool
+	}{
+		{brute, sub, false},
+		{brute, &requests.ResolvedRequest{Name: "www.owasp.org"}, false},
+		{brute, &requests.DNSRequest{Name: "owasp.org"}, true},
+		{brute, opened, true},
+		{api, sub, true},
+		{api, opened, false},
+	}
+	for i, test := range tests {
+		req, ok := e.routeRequest(test.src, test.element)
+		if ok != test.expected {
+			t.Errorf("test %d: routing the request returned %t, expected %t", i, ok, test.expected)
+		}
+		if ok && test.element == opened && req != sub {
+			t.Errorf("test %d: the opened subdomain was not unwrapped", i)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"sync"
+
+	"github.com/caffix/queue"
+	"github.com/owasp-amass/amass/v4/rdap"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+type registrationLookup struct {
+	domain string
+	prefix string
+}
+
+// registrationLookups obtains the registration data of the root domain names and the netblocks of
+// the discovered addresses, without holding up the pipeline. Each one is looked up once per run.
+type registrationLookups struct {
+	sync.Mutex
+	client *rdap.Client
+	store  *rdap.Store
+	queue  queue.Queue
+	seen   map[string]struct{}
+	log    func(format string, v ...interface{})
+	done   chan struct{}
+}
+
+func newRegistrationLookups(e *Enumeration) *registrationLookups {
+	return &registrationLookups{
+		client: e.RDAP,
+		store:  e.Registrations,
+		queue:  queue.NewQueue(),
+		seen:   make(map[string]struct{}),
+		log:    e.Config.Log.Printf,
+		done:   make(chan struct{}),
+	}
+}
+
+// domain queues the lookup of the registration data of the domain name.
+func (r *registrationLookups) domain(name string) {
+	if r != nil && r.first("domain|"+name) {
+		r.queue.Append(&registrationLookup{domain: name})
+	}
+}
+
+// netblock queues the lookup of the registration data of the netblock announced by the ASN.
+// The placeholder netblocks of the addresses without a known ASN are skipped.
+func (r *registrationLookups) netblock(asn *requests.ASNRequest) {
+	if r != nil && asn.ASN != 0 && asn.Prefix != "" && r.first("netblock|"+asn.Prefix) {
+		r.queue.Append(&registrationLookup{prefix: asn.Prefix})
+	}
+}
+
+func (r *registrationLookups) first(key string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, found := r.seen[key]; found {
+		return false
+	}
+	r.seen[key] = struct{}{}
+	return true
+}
+
+// process performs the queued lookups until the context expires, or the queue is empty once the enumeration is done.
+func (r *registrationLookups) process(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.done:
+			for r.queue.Len() > 0 && ctx.Err() == nil {
+				r.next(ctx)
+			}
+			return
+		case <-r.queue.Signal():
+			r.next(ctx)
+		}
+	}
+}
+
+func (r *registrationLookups) next(ctx context.Context) {
+	e, ok := r.queue.Next()
+	if !ok {
+		return
+	}
+
+	l := e.(*registrationLookup)
+	if l.domain != "" {
+		reg, err := r.client.Domain(ctx, l.domain)
+		if err != nil {
+			r.log("Failed to obtain the registration data of %s: %v", l.domain, err)
+			return
+		}
+		r.store.SetDomain(l.domain, reg)
+		return
+	}
+
+	reg, err := r.client.Netblock(ctx, l.prefix)
+	if err != nil {
+		r.log("Failed to obtain the registration data of %s: %v", l.prefix, err)
+		return
+	}
+	r.store.SetNetblock(l.prefix, reg)
+}
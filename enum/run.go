@@ -0,0 +1,139 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+func init() {
+	systems.RegisterEnumerationStarter(startEnumeration)
+}
+
+// run is an Enumeration started through the System interface, which owns the output channel of the enumeration.
+type run struct {
+	enum     *Enumeration
+	cancel   func(*requests.CancelCause)
+	out      chan *requests.Output
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	findings int64
+	err      error
+}
+
+// startEnumeration begins the enumeration of the configuration on the System, and is used by the Systems.
+func startEnumeration(ctx context.Context, sys systems.System, cfg *config.Config, scope systems.Scope) (systems.Enumeration, error) {
+	graphs := sys.GraphDatabases()
+	if len(graphs) == 0 || graphs[0] == nil {
+		return nil, errors.New("the system has no graph database")
+	}
+	// The settings are checked before returning, so the caller learns about them without waiting for the run
+	if err := cfg.CheckSettings(); err != nil {
+		return nil, err
+	}
+
+	e := NewEnumeration(cfg, sys, graphs[0])
+	if err := e.Policy.Err(); err != nil {
+		return nil, err
+	}
+	e.Budget = Budget{
+		Duration:   scope.Duration,
+		DNSQueries: scope.DNSQueries,
+		Bytes:      scope.Bytes,
+	}
+	e.Output = make(chan *requests.Output, 100)
+
+	ctx, cancel := requests.WithCancelCause(ctx)
+	r := &run{
+		enum:    e,
+		cancel:  cancel,
+		out:     make(chan *requests.Output, 100),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	forwarded := make(chan struct{})
+	go r.forward(forwarded)
+	go func() {
+		r.err = e.Start(ctx)
+		cancel(nil)
+		close(e.Output)
+		<-forwarded
+		close(r.out)
+		close(r.done)
+	}()
+	return r, nil
+}
+
+// forward counts the findings of the enumeration and passes them on, until the enumeration is stopped.
+func (r *run) forward(finished chan struct{}) {
+	defer close(finished)
+
+	for o := range r.enum.Output {
+		// The update records raise the confidence in the findings already counted
+		if !o.Update {
+			atomic.AddInt64(&r.findings, 1)
+		}
+
+		select {
+		case <-r.stopped:
+		case r.out <- o:
+		}
+	}
+}
+
+// Done implements the systems.Enumeration interface.
+func (r *run) Done() <-chan struct{} {
+	return r.done
+}
+
+// Progress implements the systems.Enumeration interface.
+func (r *run) Progress() systems.EnumerationProgress {
+	return systems.EnumerationProgress{
+		Findings:  int(atomic.LoadInt64(&r.findings)),
+		Queries:   atomic.LoadInt64(&r.enum.queries),
+		Blocked:   r.enum.Policy.Blocked(),
+		Coalesced: r.enum.Coalesced().Coalesced,
+		Sources:   r.enum.Sys.StartupProgress(),
+		Bandwidth: r.enum.Bandwidth(),
+	}
+}
+
+// Output implements the systems.Enumeration interface.
+func (r *run) Output() <-chan *requests.Output {
+	return r.out
+}
+
+// Stop implements the systems.Enumeration interface.
+func (r *run) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopped)
+		r.cancel(requests.NewCancelCause(requests.CancelStopped, "the enumeration was stopped"))
+	})
+	<-r.done
+}
+
+// TerminationReason returns the cause of the enumeration that was cut short, such as when it was stopped.
+func (r *run) TerminationReason() *requests.CancelCause {
+	return r.enum.TerminationReason()
+}
+
+// Err implements the systems.Enumeration interface.
+func (r *run) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+	}
+	return nil
+}
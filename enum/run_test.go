@@ -0,0 +1,128 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// newRunSystem returns a SimpleSystem resolving the names of the scenario through the fake resolver,
+// with the data source providing the candidates of the scenario, and the function shutting it down.
+func newRunSystem(t *testing.T, sc *benchScenario, g *netmap.Graph) (*systems.SimpleSystem, func()) {
+	addr, stop := startFakeResolver(t, sc)
+
+	cfg := config.NewConfig()
+	cfg.ResolversQPS = benchQPS
+	cfg.TrustedQPS = benchQPS
+	pool := resolve.NewResolvers()
+	_ = pool.AddResolvers(benchQPS, addr)
+	trusted := resolve.NewResolvers()
+	_ = trusted.AddResolvers(benchQPS, addr)
+	trusted.SetDetectionResolver(benchQPS, addr)
+
+	sys := &systems.SimpleSystem{
+		Cfg:      cfg,
+		Pool:     pool,
+		Trusted:  trusted,
+		Graph:    g,
+		ASNCache: requests.NewASNCache(),
+	}
+	if err := sys.AddAndStart(newBenchSource(sc.candidates)); err != nil {
+		t.Fatal(err)
+	}
+	return sys, func() {
+		_ = sys.Shutdown()
+		trusted.Stop()
+		stop()
+	}
+}
+
+func TestStartEnumerationThroughSystem(t *testing.T) {
+	sc := newBenchScenario(benchSeed, 100)
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+	sys, shutdown := newRunSystem(t, sc, g)
+	defer shutdown()
+
+	e, err := sys.StartEnumeration(context.Background(), systems.Scope{Domains: []string{sc.domain}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]struct{})
+	timeout := time.After(time.Minute)
+	for len(seen) < len(sc.answers) {
+		select {
+		case <-timeout:
+			t.Fatalf("only %d of the %d resolvable names were found", len(seen), len(sc.answers))
+		case out := <-e.Output():
+			if _, found := sc.answers[out.Name]; found {
+				seen[out.Name] = struct{}{}
+			}
+		}
+	}
+	if p := e.Progress(); p.Findings < len(sc.answers) || p.Queries == 0 {
+		t.Errorf("the progress reports %d findings and %d queries", p.Findings, p.Queries)
+	}
+
+	e.Stop()
+	select {
+	case <-e.Done():
+	default:
+		t.Fatal("the enumeration was not done once stopped")
+	}
+	for range e.Output() {
+	}
+}
+
+func TestConcurrentEnumerationsSharingGraph(t *testing.T) {
+	sc := newBenchScenario(benchSeed, 100)
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+	sys, shutdown := newRunSystem(t, sc, g)
+	defer shutdown()
+
+	first, err := sys.StartEnumeration(context.Background(), systems.Scope{Domains: []string{sc.domain}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-first.Output():
+	case <-time.After(time.Minute):
+		t.Fatal("the first enumeration found no names")
+	}
+	// The first enumeration has written the address, but not yet linked it
+	ip, err := g.UpsertAddress(context.Background(), "10.99.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := systems.RepairOrphans(g); err != systems.ErrGraphInUse {
+		t.Errorf("the graph written by the enumeration was repaired: %v", err)
+	}
+
+	second, err := sys.StartEnumeration(context.Background(), systems.Scope{Domains: []string{sc.domain}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []systems.Enumeration{first, second} {
+		go func(e systems.Enumeration) {
+			for range e.Output() {
+			}
+		}(e)
+		e.Stop()
+	}
+
+	if _, err := g.DB.FindById(ip.ID, time.Time{}); err != nil {
+		t.Errorf("the address written during the first enumeration was removed by the second: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+// shard is the part of the work handled by one of the processes enumerating the same scope. The brute force
+// candidates, the name alterations and the addresses swept are assigned to the shards by the hash of the name
+// or address, and the data sources are queried for each root domain name by the shard the domain is assigned to.
+type shard struct {
+	index   int
+	count   int
+	skipped int64
+}
+
+// shardFromConfig parses the 'shard' configuration options, and returns nil when the work is not sharded.
+func shardFromConfig(cfg *config.Config) (*shard, error) {
+	if cfg == nil || cfg.Options == nil {
+		return nil, nil
+	}
+
+	opts, ok := cfg.Options["shard"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	index, count := options.Int(opts["index"]), options.Int(opts["count"])
+	if count <= 1 && index == 0 {
+		return nil, nil
+	}
+	if count <= 1 || index < 0 || index >= count {
+		return nil, fmt.Errorf("the shard %d of %d does not exist", index, count)
+	}
+	return &shard{index: index, count: count}, nil
+}
+
+// ParseShard parses the shard provided as INDEX/COUNT, such as 0/4 for the first of four shards.
+func ParseShard(s string) (int, int, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("the shard %q is not in the INDEX/COUNT format", s)
+	}
+
+	index, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("the shard index %q is not a number", parts[0])
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("the shard count %q is not a number", parts[1])
+	}
+	if count <= 1 || index < 0 || index >= count {
+		return 0, 0, fmt.Errorf("the shard %d of %d does not exist", index, count)
+	}
+	return index, count, nil
+}
+
+// ShardOf returns the shard the name or address is assigned to among the count of shards. The assignment is
+// the 64-bit FNV-1a hash of the lowercase key without the trailing dot, modulo the count, and never changes
+// between versions, so the runs started again shard the work identically.
+func ShardOf(key string, count int) int {
+	if count <= 1 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strings.ToLower(strings.TrimSuffix(strings.TrimSpace(key), "."))))
+	return int(h.Sum64() % uint64(count))
+}
+
+// ShardDirectory returns the subdirectory of the output directory holding the findings of the shard,
+// or an empty string when the work is not sharded.
+func ShardDirectory(cfg *config.Config) string {
+	s, err := shardFromConfig(cfg)
+	if err != nil || s == nil {
+		return ""
+	}
+	return fmt.Sprintf("shard-%d-of-%d", s.index, s.count)
+}
+
+// owns returns true when the name or address is assigned to the shard, which is always the case without sharding.
+func (s *shard) owns(key string) bool {
+	return s == nil || ShardOf(key, s.count) == s.index
+}
+
+// skip counts the candidate left to the other shards.
+func (s *shard) skip() {
+	if s != nil {
+		atomic.AddInt64(&s.skipped, 1)
+	}
+}
+
+// report logs the work left to the other shards.
+func (s *shard) report(log func(format string, v ...interface{})) {
+	if s == nil {
+		return
+	}
+	log("Enumerated the shard %d of %d, leaving %d candidates to the other shards",
+		s.index, s.count, atomic.LoadInt64(&s.skipped))
+}
@@ -0,0 +1,138 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestShardFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if s, err := shardFromConfig(cfg); s != nil || err != nil {
+		t.Errorf("the work was sharded without the options: %+v, %v", s, err)
+	}
+	if dir := ShardDirectory(cfg); dir != "" {
+		t.Errorf("the unsharded findings were written to %q", dir)
+	}
+
+	cfg.Options = map[string]interface{}{"shard": map[string]interface{}{"index": 2, "count": 4}}
+	if s, err := shardFromConfig(cfg); err != nil || s == nil || s.index != 2 || s.count != 4 {
+		t.Errorf("the shard was parsed as %+v: %v", s, err)
+	}
+	if dir := ShardDirectory(cfg); dir != "shard-2-of-4" {
+		t.Errorf("the findings of the shard were written to %q", dir)
+	}
+
+	cfg.Options = map[string]interface{}{"shard": map[string]interface{}{"index": 4, "count": 4}}
+	if _, err := shardFromConfig(cfg); err == nil {
+		t.Error("the shard beyond the count was accepted")
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	if index, count, err := ParseShard(" 3/8 "); err != nil || index != 3 || count != 8 {
+		t.Errorf("the shard was parsed as %d of %d: %v", index, count, err)
+	}
+	for _, s := range []string{"", "3", "a/4", "1/b", "4/4", "-1/4", "0/1", "1/2/3"} {
+		if _, _, err := ParseShard(s); err == nil {
+			t.Errorf("the shard %q was accepted", s)
+		}
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	// The assignments never change, so the runs started again shard the work identically
+	for key, expected := range map[string]int{
+		"owasp.org":      1,
+		"WWW.owasp.org.": 0,
+		"example.com":    2,
+		"192.0.2.1":      0,
+	} {
+		if got := ShardOf(key, 4); got != expected {
+			t.Errorf("%s was assigned to the shard %d, expected %d", key, got, expected)
+		}
+	}
+
+	// Each name is owned by exactly one of the shards
+	shards := []*shard{{index: 0, count: 3}, {index: 1, count: 3}, {index: 2, count: 3}}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("host%d.owasp.org", i)
+
+		var owners int
+		for _, s := range shards {
+			if s.owns(name) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("%s was owned by %d shards", name, owners)
+		}
+	}
+
+	var unsharded *shard
+	if !unsharded.owns("www.owasp.org") {
+		t.Error("the name was not owned without sharding")
+	}
+}
+
+func TestShardedCandidates(t *testing.T) {
+	var queued int
+	for index := 0; index < 3; index++ {
+		e := policyEnumeration(t)
+		e.shard = &shard{index: index, count: 3}
+
+		for i := 0; i < 30; i++ {
+			e.nameSrc.newName(&requests.DNSRequest{
+				Name:       fmt.Sprintf("host%d.owasp.org", i),
+				Domain:     "owasp.org",
+				Derivation: requests.DerivedFromBrute,
+			})
+		}
+		// The names found by the data sources are resolved by every shard
+		e.nameSrc.newName(&requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromSource})
+
+		n := e.nameSrc.queue.Len()
+		if int(e.shard.skipped)+n != 31 {
+			t.Errorf("the shard %d queued %d names and skipped %d", index, n, e.shard.skipped)
+		}
+		queued += n - 1
+
+		for i := 0; i < 30; i++ {
+			name := fmt.Sprintf("host%d.owasp.org", i)
+			if rec, found := e.WhyNot(name); found && rec.Disposition == DispositionSharded && e.shard.owns(name) {
+				t.Errorf("the shard %d skipped its own candidate %s", index, name)
+			}
+		}
+	}
+	if queued != 30 {
+		t.Errorf("the shards queued %d of the 30 candidates", queued)
+	}
+}
+
+func TestShardedRootDomains(t *testing.T) {
+	brute := newFakeSource("Brute Forcing", "brute")
+	api := newFakeSource("API", "api")
+	apex := &requests.DNSRequest{Name: "owasp.org", Domain: "owasp.org"}
+
+	// The root domain name owasp.org is assigned to the shard 1 of 4
+	owner := &Enumeration{shard: &shard{index: 1, count: 4}}
+	other := &Enumeration{shard: &shard{index: 0, count: 4}}
+	if _, ok := owner.routeRequest(api, apex); !ok {
+		t.Error("the data source was not queried by the shard of the root domain name")
+	}
+	if _, ok := other.routeRequest(api, apex); ok {
+		t.Error("the data source was queried by another shard")
+	}
+	if _, ok := other.routeRequest(brute, apex); !ok {
+		t.Error("the brute forcing was not started by another shard")
+	}
+	if _, ok := other.routeRequest(api, &requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org"}); !ok {
+		t.Error("the name was not sent to the data source by another shard")
+	}
+}
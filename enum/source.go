@@ -0,0 +1,176 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/resolve"
+)
+
+// The custom node type and predicate flagging the names answered from another address than the resolver queried.
+const (
+	// SourceMismatchNodeType is the node holding the resolver and the address an accepted answer arrived from
+	SourceMismatchNodeType = "amass:source_mismatch"
+	// SourceMismatchPredicate links the FQDN to the node flagging its answer
+	SourceMismatchPredicate = "amass:answered_from_unexpected_source"
+)
+
+func init() {
+	_ = custom.RegisterNode(custom.NodeType{
+		Name:        SourceMismatchNodeType,
+		Description: "An answer accepted from another address than the resolver queried",
+	})
+	_ = custom.RegisterPredicate(custom.Predicate{
+		Name: SourceMismatchPredicate,
+		From: []string{"FQDN"},
+		To:   []string{SourceMismatchNodeType},
+	})
+}
+
+// SourceMismatch is an answer of the pipelined transport that arrived from another address than the resolver queried.
+type SourceMismatch struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Resolver string `json:"resolver"`
+	From     string `json:"from"`
+}
+
+// sourceMismatches counts the responses from unexpected addresses for each resolver, and keeps the names
+// answered by those that were accepted, so they are flagged on the graph once stored.
+type sourceMismatches struct {
+	sync.Mutex
+	counts   map[string]int
+	accepted map[string]*SourceMismatch
+}
+
+func newSourceMismatches() *sourceMismatches {
+	return &sourceMismatches{
+		counts:   make(map[string]int),
+		accepted: make(map[string]*SourceMismatch),
+	}
+}
+
+// observe records the response, and returns the name it answers.
+func (sm *sourceMismatches) observe(resp *dns.Msg, server, from string, accepted bool) string {
+	name := strings.ToLower(resolve.RemoveLastDot(resp.Question[0].Name))
+
+	sm.Lock()
+	defer sm.Unlock()
+
+	sm.counts[server]++
+	if accepted {
+		sm.accepted[name] = &SourceMismatch{
+			Name:     name,
+			Type:     dns.TypeToString[resp.Question[0].Qtype],
+			Resolver: server,
+			From:     from,
+		}
+	}
+	return name
+}
+
+// flagged returns the accepted answers, ordered by name.
+func (sm *sourceMismatches) flagged() []*SourceMismatch {
+	if sm == nil {
+		return nil
+	}
+
+	sm.Lock()
+	defer sm.Unlock()
+
+	found := make([]*SourceMismatch, 0, len(sm.accepted))
+	for _, m := range sm.accepted {
+		c := *m
+		found = append(found, &c)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+	return found
+}
+
+// report logs the responses from unexpected addresses received for each resolver, the most first.
+func (sm *sourceMismatches) report(log func(format string, v ...interface{})) {
+	if sm == nil {
+		return
+	}
+
+	sm.Lock()
+	defer sm.Unlock()
+
+	servers := make([]string, 0, len(sm.counts))
+	for server := range sm.counts {
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if a, b := sm.counts[servers[i]], sm.counts[servers[j]]; a != b {
+			return a > b
+		}
+		return servers[i] < servers[j]
+	})
+	for _, server := range servers {
+		log("Received %d responses for the queries sent to %s from other addresses", sm.counts[server], server)
+	}
+	if len(sm.accepted) > 0 {
+		log("Accepted the answers of %d names from unexpected addresses, which are flagged in the graph", len(sm.accepted))
+	}
+}
+
+// observeSourceMismatch records the response that arrived from another address than the resolver queried. The
+// dropped responses lower the reputation of the resolver, while those accepted for the anycast resolvers are
+// expected, and only flagged on the names they answered.
+func (e *Enumeration) observeSourceMismatch(resp *dns.Msg, server, from string, accepted bool) {
+	if e.sources == nil || resp == nil || len(resp.Question) == 0 {
+		return
+	}
+
+	name := e.sources.observe(resp, server, from, accepted)
+	if !accepted {
+		if obs, ok := e.Sys.(systems.SourceMismatchObserver); ok {
+			obs.ObserveSourceMismatch(server)
+		}
+	}
+	if e.tracing(name) {
+		e.trace(name, systems.TraceAnswer, "answered from an unexpected address", "resolver", server,
+			"from", from, "accepted", strconv.FormatBool(accepted))
+	}
+}
+
+// SourceMismatches returns the answers accepted from another address than the resolver queried, ordered by name.
+func (e *Enumeration) SourceMismatches() []*SourceMismatch {
+	return e.sources.flagged()
+}
+
+// flagSourceMismatches links the names stored with an answer accepted from an unexpected address to a custom
+// node holding the resolver and the address, when the graph database is able to hold the custom nodes.
+func (e *Enumeration) flagSourceMismatches(ctx context.Context) {
+	s := custom.For(e.graph)
+	if s == nil {
+		return
+	}
+
+	for _, m := range e.sources.flagged() {
+		assets, err := e.graph.DB.FindByContent(&domain.FQDN{Name: m.Name}, time.Time{})
+		if err != nil || len(assets) == 0 {
+			continue
+		}
+
+		node, err := s.AddNode(ctx, SourceMismatchNodeType, m.Name, m)
+		if err == nil {
+			_, err = s.Link(ctx, assets[0].ID, SourceMismatchPredicate, node.ID)
+		}
+		if err != nil {
+			e.Config.Log.Printf("Failed to flag the answer of %s from an unexpected address: %v", m.Name, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestObserveSourceMismatch(t *testing.T) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "amass.sqlite")
+	g := netmap.NewGraph("local", dsn, "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	t.Cleanup(func() { g.Remove() })
+
+	s, err := custom.Open("local", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom.Register(g, s)
+	t.Cleanup(func() { custom.Unregister(g) })
+
+	var logs strings.Builder
+	cfg := config.NewConfig()
+	cfg.Log.SetOutput(&logs)
+	sys := &observingSystem{}
+	e := &Enumeration{Config: cfg, Sys: sys, graph: g, sources: newSourceMismatches()}
+
+	// The dropped response counts against the resolver, while the accepted one flags the name
+	e.observeSourceMismatch(positiveAnswer("www.owasp.org"), "192.0.2.53:53", "198.51.100.9:53", false)
+	e.observeSourceMismatch(positiveAnswer("API.owasp.org"), "192.0.2.54:53", "192.0.2.99:53", true)
+	e.observeSourceMismatch(positiveAnswer("gone.owasp.org"), "192.0.2.54:53", "192.0.2.99:53", true)
+	if !reflect.DeepEqual(sys.mismatched, []string{"192.0.2.53:53"}) {
+		t.Errorf("the resolvers reported to the System were %v", sys.mismatched)
+	}
+
+	flagged := e.SourceMismatches()
+	if len(flagged) != 2 || flagged[0].Name != "api.owasp.org" || flagged[0].Resolver != "192.0.2.54:53" || flagged[0].Type != "A" {
+		t.Fatalf("the flagged answers were %+v", flagged)
+	}
+
+	// Only the names stored in the graph are flagged
+	for _, name := range []string{"owasp.org", "api.owasp.org"} {
+		if _, err := g.UpsertFQDN(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.flagSourceMismatches(ctx)
+
+	assets, err := g.DB.FindByContent(&domain.FQDN{Name: "api.owasp.org"}, time.Time{})
+	if err != nil || len(assets) != 1 {
+		t.Fatalf("the name was not found: %v", err)
+	}
+	edges, err := s.Outgoing(ctx, assets[0].ID, SourceMismatchPredicate)
+	if err != nil || len(edges) != 1 {
+		t.Fatalf("the answer was not flagged on the name: %v", err)
+	}
+	node, err := s.NodeByID(ctx, edges[0].To)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored SourceMismatch
+	if err := json.Unmarshal(node.Data, &stored); err != nil || stored.From != "192.0.2.99:53" {
+		t.Errorf("the answer was flagged as %+v: %v", stored, err)
+	}
+
+	e.sources.report(cfg.Log.Printf)
+	if !strings.Contains(logs.String(), "Received 2 responses for the queries sent to 192.0.2.54:53 from other addresses") {
+		t.Errorf("the mismatches were not reported: %s", logs.String())
+	}
+
+	// The enumerations without the pipelined transport observe nothing
+	none := &Enumeration{Config: cfg, Sys: sys}
+	none.observeSourceMismatch(positiveAnswer("www.owasp.org"), "192.0.2.53:53", "198.51.100.9:53", false)
+	if len(none.SourceMismatches()) != 0 || len(sys.mismatched) != 1 {
+		t.Error("the mismatch was observed without the pipelined transport")
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// SplitHorizonFile is the name of the file under the output directory holding the answers of each resolver group.
+const SplitHorizonFile = "split_horizon.json"
+
+// DefaultResolverGroup is the group of the resolver entries without a label.
+const DefaultResolverGroup = "default"
+
+// splitHorizonTypes are the record types each resolver group is asked for.
+var splitHorizonTypes = []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME}
+
+// splitHorizonAttempts is the number of times a query failing to receive an answer is sent to a group.
+const splitHorizonAttempts = 3
+
+// The differences between the answers of the resolver groups.
+const (
+	// SplitHorizonDiffers is reported for the names all the groups resolve, but to different answers
+	SplitHorizonDiffers = "differs"
+	// SplitHorizonPartial is reported for the names only some of the groups resolve
+	SplitHorizonPartial = "partial"
+)
+
+// SplitHorizonAnswers are the answers each resolver group provided for a confirmed name, such as "A 10.0.0.5".
+// The graph has no place for the group that produced an answer, so the answers are kept beside it.
+type SplitHorizonAnswers struct {
+	Name   string              `json:"name"`
+	Groups map[string][]string `json:"groups"`
+}
+
+// SplitHorizonDiff is a name the resolver groups answered differently for.
+type SplitHorizonDiff struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Missing are the groups that did not resolve the name
+	Missing []string            `json:"missing,omitempty"`
+	Groups  map[string][]string `json:"groups"`
+}
+
+// resolverGroupsFromConfig parses the 'split_horizon.resolvers' configuration option, where each entry is
+// the address of a resolver or a map holding the address and the group label. The entries without a label
+// belong to the default group. The addresses are returned with the port added.
+func resolverGroupsFromConfig(cfg *config.Config) map[string][]string {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["split_horizon"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	entries, ok := opts["resolvers"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	groups := make(map[string][]string)
+	for _, entry := range entries {
+		var addr string
+		group := DefaultResolverGroup
+
+		switch v := entry.(type) {
+		case string:
+			addr = v
+		case map[string]interface{}:
+			addr, _ = v["address"].(string)
+			if g, ok := v["group"].(string); ok && strings.TrimSpace(g) != "" {
+				group = strings.ToLower(strings.TrimSpace(g))
+			}
+		}
+		if addr = resolverAddress(addr); addr != "" {
+			groups[group] = append(groups[group], addr)
+		}
+	}
+	return groups
+}
+
+// resolverAddress returns the address with the port added, or an empty string when it is not valid.
+func resolverAddress(addr string) string {
+	ip, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		ip, port = strings.TrimSpace(addr), "53"
+	}
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return ""
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+type horizonQueryFunc func(ctx context.Context, group, name string, qtype uint16) ([]requests.DNSAnswer, error)
+
+// horizonComparer asks each resolver group for the records of the confirmed names, without holding up
+// the pipeline. Each name is resolved once per run by every group.
+type horizonComparer struct {
+	sync.Mutex
+	groups  []string
+	pools   map[string]Pool
+	query   horizonQueryFunc
+	queue   queue.Queue
+	seen    map[string]struct{}
+	answers map[string]map[string][]string
+	done    chan struct{}
+}
+
+// newHorizonComparer returns the comparer of the resolver groups, or nil unless
+// at least two groups have been configured, which leaves the enumeration unchanged.
+func newHorizonComparer(e *Enumeration) *horizonComparer {
+	groups := resolverGroupsFromConfig(e.Config)
+	if len(groups) < 2 {
+		return nil
+	}
+
+	qps := e.Config.TrustedQPS
+	if qps <= 0 {
+		qps = config.DefaultQueriesPerBaselineResolver
+	}
+
+	h := &horizonComparer{
+		pools:   make(map[string]Pool, len(groups)),
+		queue:   queue.NewQueue(),
+		seen:    make(map[string]struct{}),
+		answers: make(map[string]map[string][]string),
+		done:    make(chan struct{}),
+	}
+	for group, addrs := range groups {
+		pool := resolve.NewResolvers()
+		_ = pool.AddResolvers(qps, addrs...)
+		h.groups = append(h.groups, group)
+		h.pools[group] = pool
+	}
+	sort.Strings(h.groups)
+
+	h.query = func(ctx context.Context, group, name string, qtype uint16) ([]requests.DNSAnswer, error) {
+		// Each query is counted against the budget of the enumeration, whichever group sends it
+		resp, err := e.dnsQuery(ctx, name, qtype, h.pools[group], splitHorizonAttempts)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			return nil, errors.New("query failed")
+		}
+		return convertAnswers(resolve.AnswersByType(extractAnswers(resp), qtype)), nil
+	}
+	return h
+}
+
+// submit queues the confirmed name to be resolved by each group.
+func (h *horizonComparer) submit(name string) {
+	if h == nil {
+		return
+	}
+
+	name = strings.ToLower(strings.Trim(name, "."))
+	h.Lock()
+	_, found := h.seen[name]
+	h.seen[name] = struct{}{}
+	h.Unlock()
+
+	if !found {
+		h.queue.Append(name)
+	}
+}
+
+// process resolves the queued names until the context expires, or the queue is empty once the enumeration is done.
+func (h *horizonComparer) process(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			for h.queue.Len() > 0 && ctx.Err() == nil {
+				h.next(ctx)
+			}
+			return
+		case <-h.queue.Signal():
+			h.next(ctx)
+		}
+	}
+}
+
+func (h *horizonComparer) next(ctx context.Context) {
+	element, ok := h.queue.Next()
+	if !ok {
+		return
+	}
+
+	name := element.(string)
+	groups := make(map[string][]string, len(h.groups))
+	for _, group := range h.groups {
+		var answers []string
+
+		for _, qtype := range splitHorizonTypes {
+			ans, err := h.query(ctx, group, name, qtype)
+			if err != nil {
+				continue
+			}
+			for _, a := range ans {
+				if data := strings.ToLower(resolve.RemoveLastDot(a.Data)); data != "" {
+					answers = append(answers, dns.TypeToString[uint16(a.Type)]+" "+data)
+				}
+			}
+		}
+		sort.Strings(answers)
+		groups[group] = dedupeSorted(answers)
+	}
+
+	h.Lock()
+	h.answers[name] = groups
+	h.Unlock()
+}
+
+func dedupeSorted(list []string) []string {
+	var out []string
+
+	for i, s := range list {
+		if i == 0 || s != list[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// stop releases the resolvers of the groups.
+func (h *horizonComparer) stop() {
+	for _, pool := range h.pools {
+		if s, ok := pool.(interface{ Stop() }); ok {
+			s.Stop()
+		}
+	}
+}
+
+// all returns the answers of each group for the resolved names, sorted by the name.
+func (h *horizonComparer) all() []SplitHorizonAnswers {
+	if h == nil {
+		return nil
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	list := make([]SplitHorizonAnswers, 0, len(h.answers))
+	for name, groups := range h.answers {
+		list = append(list, SplitHorizonAnswers{Name: name, Groups: groups})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// SplitHorizonAnswers returns the answers each resolver group provided for the confirmed names,
+// or nil when fewer than two groups were configured.
+func (e *Enumeration) SplitHorizonAnswers() []SplitHorizonAnswers {
+	return e.horizon.all()
+}
+
+// SplitHorizonReport returns the names the resolver groups answered differently for,
+// along with the names only some of the groups resolved.
+func (e *Enumeration) SplitHorizonReport() []SplitHorizonDiff {
+	return SplitHorizonReport(e.SplitHorizonAnswers())
+}
+
+// SplitHorizonReport compares the answers of the resolver groups for each name.
+func SplitHorizonReport(list []SplitHorizonAnswers) []SplitHorizonDiff {
+	var diffs []SplitHorizonDiff
+
+	for _, a := range list {
+		groups := make([]string, 0, len(a.Groups))
+		for group := range a.Groups {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+
+		var missing []string
+		differs := false
+		var first []string
+		for i, group := range groups {
+			answers := a.Groups[group]
+			if len(answers) == 0 {
+				missing = append(missing, group)
+			}
+			if i == 0 {
+				first = answers
+			} else if strings.Join(answers, "\n") != strings.Join(first, "\n") {
+				differs = true
+			}
+		}
+
+		switch {
+		case len(missing) == len(groups):
+		case len(missing) > 0:
+			diffs = append(diffs, SplitHorizonDiff{Name: a.Name, Kind: SplitHorizonPartial, Missing: missing, Groups: a.Groups})
+		case differs:
+			diffs = append(diffs, SplitHorizonDiff{Name: a.Name, Kind: SplitHorizonDiffers, Groups: a.Groups})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,135 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+)
+
+// zonePool answers the A queries from its own view of the zone, and NXDOMAIN for the names missing from it.
+type zonePool struct {
+	addrs map[string]string
+}
+
+func (zp *zonePool) Len() int { return 1 }
+
+func (zp *zonePool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	resp, _ := zp.QueryBlocking(ctx, msg)
+	ch <- resp
+}
+
+func (zp *zonePool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+
+	q := msg.Question[0]
+	addr, found := zp.addrs[q.Name]
+	if !found {
+		resp.Rcode = dns.RcodeNameError
+		return resp, nil
+	}
+	if q.Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(addr),
+		})
+	}
+	return resp, nil
+}
+
+func TestResolverGroupsFromConfig(t *testing.T) {
+	if groups := resolverGroupsFromConfig(config.NewConfig()); len(groups) != 0 {
+		t.Errorf("the groups %v were returned without the option", groups)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"split_horizon": map[string]interface{}{"resolvers": []interface{}{
+		"8.8.8.8",
+		map[string]interface{}{"address": "10.0.0.53", "group": "Internal"},
+		map[string]interface{}{"address": "10.0.0.54:5353", "group": "internal"},
+		map[string]interface{}{"address": "1.1.1.1"},
+		map[string]interface{}{"address": "not an address", "group": "external"},
+	}}}
+
+	groups := resolverGroupsFromConfig(cfg)
+	if len(groups) != 2 || len(groups[DefaultResolverGroup]) != 2 || len(groups["internal"]) != 2 ||
+		groups["internal"][1] != "10.0.0.54:5353" || groups[DefaultResolverGroup][0] != "8.8.8.8:53" {
+		t.Errorf("the groups were parsed as %v", groups)
+	}
+
+	// A single group leaves the enumeration unchanged
+	cfg.Options = map[string]interface{}{"split_horizon": map[string]interface{}{"resolvers": []interface{}{"8.8.8.8", "1.1.1.1"}}}
+	if h := newHorizonComparer(&Enumeration{Config: cfg}); h != nil {
+		t.Error("the comparer was created for a single group")
+	}
+}
+
+func TestHorizonComparer(t *testing.T) {
+	e := &Enumeration{Config: config.NewConfig(), Budget: Budget{DNSQueries: 100}}
+	e.Config.Options = map[string]interface{}{"split_horizon": map[string]interface{}{"resolvers": []interface{}{
+		map[string]interface{}{"address": "10.0.0.53", "group": "internal"},
+		map[string]interface{}{"address": "8.8.8.8", "group": "external"},
+	}}}
+
+	h := newHorizonComparer(e)
+	if h == nil {
+		t.Fatal("the comparer was not created for two groups")
+	}
+	h.stop()
+	h.pools["internal"] = &zonePool{addrs: map[string]string{
+		"www.owasp.org.":  "10.0.0.5",
+		"vpn.owasp.org.":  "10.0.0.6",
+		"mail.owasp.org.": "192.0.2.25",
+	}}
+	h.pools["external"] = &zonePool{addrs: map[string]string{
+		"www.owasp.org.":  "192.0.2.80",
+		"mail.owasp.org.": "192.0.2.25",
+		"shop.owasp.org.": "192.0.2.81",
+	}}
+	e.horizon = h
+
+	for _, name := range []string{"www.owasp.org", "vpn.owasp.org", "mail.owasp.org", "shop.owasp.org", "WWW.owasp.org."} {
+		h.submit(name)
+	}
+	close(h.done)
+	h.process(context.Background())
+
+	if answers := e.SplitHorizonAnswers(); len(answers) != 4 || answers[3].Groups["internal"][0] != "A 10.0.0.5" {
+		t.Fatalf("the answers were recorded as %v", answers)
+	}
+
+	diffs := e.SplitHorizonReport()
+	if len(diffs) != 3 {
+		t.Fatalf("the report holds %v", diffs)
+	}
+	for _, d := range diffs {
+		switch d.Name {
+		case "www.owasp.org":
+			if d.Kind != SplitHorizonDiffers {
+				t.Errorf("www.owasp.org was reported as %s", d.Kind)
+			}
+		case "vpn.owasp.org":
+			if d.Kind != SplitHorizonPartial || len(d.Missing) != 1 || d.Missing[0] != "external" {
+				t.Errorf("vpn.owasp.org was reported as %s missing from %v", d.Kind, d.Missing)
+			}
+		case "shop.owasp.org":
+			if d.Kind != SplitHorizonPartial || d.Missing[0] != "internal" {
+				t.Errorf("shop.owasp.org was reported as %s missing from %v", d.Kind, d.Missing)
+			}
+		default:
+			t.Errorf("%s was reported with the same answers", d.Name)
+		}
+	}
+
+	// Both groups are counted against the budget, with a query of each type sent to each group
+	if spent := e.queriesSpent(); spent != int64(4*2*len(splitHorizonTypes)) {
+		t.Errorf("the groups spent %d queries", spent)
+	}
+}
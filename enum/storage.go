@@ -0,0 +1,111 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+)
+
+// storedTypes selects the DNS record types persisted to the graph of an enumeration,
+// and counts the records of the other types that were not stored.
+type storedTypes struct {
+	sync.Mutex
+	// types is nil when all the record types are stored
+	types   map[uint16]struct{}
+	skipped map[uint16]int
+}
+
+// storedTypesFromConfig parses the 'graph_record_types' option, which maps the graph database systems to
+// the record types stored in them. All the types are stored in the systems that are not listed, or "all".
+func storedTypesFromConfig(cfg *config.Config, system string) *storedTypes {
+	st := &storedTypes{skipped: make(map[uint16]int)}
+	if cfg == nil || cfg.Options == nil || system == "" {
+		return st
+	}
+
+	opts, ok := cfg.Options["graph_record_types"].(map[string]interface{})
+	if !ok {
+		return st
+	}
+
+	for sys, v := range opts {
+		if !strings.EqualFold(sys, system) {
+			continue
+		}
+
+		types := make(map[uint16]struct{})
+		for _, name := range stringList(v) {
+			name = strings.ToUpper(strings.TrimSpace(name))
+			if name == "ALL" || name == "*" {
+				return st
+			}
+			if t, found := dns.StringToType[name]; found {
+				types[t] = struct{}{}
+			}
+		}
+		st.types = types
+		break
+	}
+	return st
+}
+
+// allows returns true when records of the type are stored, and otherwise counts the skipped record.
+func (st *storedTypes) allows(qtype uint16) bool {
+	if st == nil || st.types == nil {
+		return true
+	}
+	if _, found := st.types[qtype]; found {
+		return true
+	}
+
+	st.Lock()
+	st.skipped[qtype]++
+	st.Unlock()
+	return false
+}
+
+// counts returns the number of skipped records keyed by the record type name.
+func (st *storedTypes) counts() map[string]int {
+	counts := make(map[string]int)
+	if st == nil {
+		return counts
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	for t, n := range st.skipped {
+		counts[dns.TypeToString[t]] = n
+	}
+	return counts
+}
+
+// storesRecord returns true when the record of the name should be inserted into the graph.
+func (e *Enumeration) storesRecord(ctx context.Context, name string, qtype uint16) bool {
+	return e.stored.store(ctx, e.graph, name, qtype)
+}
+
+// store returns true when the record of the name should be inserted into the graph. Otherwise, the name
+// is stored without the record, so it remains part of the enumeration findings.
+func (st *storedTypes) store(ctx context.Context, g *netmap.Graph, name string, qtype uint16) bool {
+	if st.allows(qtype) {
+		return true
+	}
+
+	_, _ = g.UpsertFQDN(ctx, name)
+	return false
+}
+
+// SkippedRecords returns the number of DNS records that were not stored in the graph, keyed by the
+// record type, since the 'graph_record_types' option excludes their type for the graph database system.
+func (e *Enumeration) SkippedRecords() map[string]int {
+	return e.stored.counts()
+}
@@ -0,0 +1,104 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestStoredTypesFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{
+		"graph_record_types": map[string]interface{}{
+			"local":    []interface{}{"A", "aaaa", "CNAME", "BOGUS"},
+			"postgres": "all",
+		},
+	}
+
+	tests := []struct {
+		system  string
+		allowed []uint16
+		denied  []uint16
+	}{
+		{"local", []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME}, []uint16{dns.TypeNS, dns.TypeMX, dns.TypeTXT}},
+		{"LOCAL", []uint16{dns.TypeA}, []uint16{dns.TypeNS}},
+		{"postgres", []uint16{dns.TypeA, dns.TypeNS, dns.TypeTXT}, nil},
+		{"memory", []uint16{dns.TypeA, dns.TypeNS, dns.TypeTXT}, nil},
+		{"", []uint16{dns.TypeA, dns.TypeNS, dns.TypeTXT}, nil},
+	}
+
+	for _, test := range tests {
+		st := storedTypesFromConfig(cfg, test.system)
+
+		for _, qtype := range test.allowed {
+			if !st.allows(qtype) {
+				t.Errorf("%s: the %s records are not stored", test.system, dns.TypeToString[qtype])
+			}
+		}
+		for _, qtype := range test.denied {
+			if st.allows(qtype) {
+				t.Errorf("%s: the %s records are stored", test.system, dns.TypeToString[qtype])
+			}
+		}
+	}
+}
+
+func TestStoredTypesDefaults(t *testing.T) {
+	st := storedTypesFromConfig(config.NewConfig(), "local")
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeCNAME, dns.TypeNS, dns.TypeMX, dns.TypeSRV, dns.TypePTR} {
+		if !st.allows(qtype) {
+			t.Errorf("the %s records are not stored by default", dns.TypeToString[qtype])
+		}
+	}
+	if n := len(st.counts()); n != 0 {
+		t.Errorf("%d record types were counted as skipped by default", n)
+	}
+}
+
+func TestStoredTypesStore(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{
+		"graph_record_types": map[string]interface{}{"memory": []interface{}{"A"}},
+	}
+	st := storedTypesFromConfig(cfg, "memory")
+
+	g := netmap.NewGraph("memory", "", "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	defer g.Remove()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if st.store(ctx, g, "owasp.org", dns.TypeNS) {
+			t.Error("the NS record was stored")
+		}
+	}
+	if st.store(ctx, g, "www.owasp.org", dns.TypeMX) {
+		t.Error("the MX record was stored")
+	}
+	if !st.store(ctx, g, "www.owasp.org", dns.TypeA) {
+		t.Error("the A record was not stored")
+	}
+
+	counts := st.counts()
+	if len(counts) != 2 || counts["NS"] != 3 || counts["MX"] != 1 {
+		t.Errorf("the skipped records were counted as %v", counts)
+	}
+	// The names of the skipped records remain part of the findings
+	for _, name := range []string{"owasp.org", "www.owasp.org"} {
+		if assets, err := g.DB.FindByContent(domain.FQDN{Name: name}, time.Time{}); err != nil || len(assets) == 0 {
+			t.Errorf("the name %s was not stored without its record", name)
+		}
+	}
+}
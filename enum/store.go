@@ -16,9 +16,12 @@ import (
 	"github.com/caffix/pipeline"
 	"github.com/caffix/queue"
 	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/journal"
 	amassnet "github.com/owasp-amass/amass/v4/net"
 	amassdns "github.com/owasp-amass/amass/v4/net/dns"
 	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/resolve"
 	bf "github.com/tylertreat/BoomFilters"
 	"golang.org/x/net/publicsuffix"
@@ -60,15 +63,17 @@ func (dm *dataManager) Process(ctx context.Context, data pipeline.Data, tp pipel
 		return nil, nil
 	default:
 	}
+	// The pipeline is held while the addresses waiting for their infrastructure hold too much memory
+	dm.enum.waitForMemory(ctx, MemoryGraph)
 
-	var id string
+	var id, domain string
 	switch v := data.(type) {
 	case *requests.DNSRequest:
 		if v == nil {
 			return nil, nil
 		}
 
-		id = v.Name
+		id, domain = v.Name, v.Domain
 		if err := dm.dnsRequest(ctx, v, tp); err != nil {
 			dm.enum.Config.Log.Print(err.Error())
 		}
@@ -83,16 +88,34 @@ func (dm *dataManager) Process(ctx context.Context, data pipeline.Data, tp pipel
 		}
 	}
 
-	if id != "" && dm.filter.TestAndAdd([]byte(id)) {
+	if id != "" && dm.stored(id, domain) {
+		dm.enum.trace(id, systems.TraceCache, "already stored")
 		return nil, nil
 	}
 	return data, nil
 }
 
+// stored returns true when the name or address was already stored, using the filter of the domain
+// when the enumeration keeps a working set for each domain.
+func (dm *dataManager) stored(id, domain string) bool {
+	if ws := dm.enum.working; ws != nil && domain != "" {
+		return ws.store(id, domain)
+	}
+	return dm.filter.TestAndAdd([]byte(id))
+}
+
 func (dm *dataManager) dnsRequest(ctx context.Context, req *requests.DNSRequest, tp pipeline.TaskParams) error {
-	if dm.enum.Config.Blacklisted(req.Name) {
+	if dm.enum.blacklisted(req.Name) {
 		return nil
 	}
+	// The graph schema has no properties, so the unicode form of the name is kept in the history
+	dm.enum.History.SetDisplayName(req.Name, amassdns.UnicodeName(req.Name))
+	// Record how the name was discovered before the names derived from its records
+	dm.enum.prov.add(req.Name, req.Parent, req.Derivation)
+	dm.enum.classify(req)
+	if len(req.Records) > 0 && dm.enum.Config.IsDomainInScope(req.Name) {
+		dm.enum.horizon.submit(req.Name)
+	}
 	// Check for CNAME records first
 	for i, r := range req.Records {
 		req.Records[i].Name = strings.Trim(strings.ToLower(r.Name), ".")
@@ -132,11 +155,54 @@ func (dm *dataManager) dnsRequest(ctx context.Context, req *requests.DNSRequest,
 			e = dm.insertSOA(ctx, req, i, tp)
 		case dns.TypeSPF:
 			e = dm.insertSPF(ctx, req, i, tp)
+		case dns.TypeCAA:
+			e = dm.insertCAA(ctx, req, i, tp)
 		}
 		if err == nil {
 			err = e
 		}
 	}
+	if e := dm.insertHistorical(ctx, req); err == nil {
+		err = e
+	}
+	return err
+}
+
+// insertHistorical inserts the addresses the data sources observed the name resolving to, along with the
+// periods they were observed, so the output can tell the resolutions that ended apart from the current ones.
+// Without the history, the edges would be indistinguishable from the current ones, so none are inserted.
+func (dm *dataManager) insertHistorical(ctx context.Context, req *requests.DNSRequest) error {
+	// Service labels, such as _dmarc, never belong to host names
+	if dm.enum.History == nil || amassdns.ValidateName(req.Name, false) != nil {
+		return nil
+	}
+
+	f := dm.enum.Finding(req.Name)
+	if f == nil {
+		return nil
+	}
+
+	var err error
+	for _, r := range f.Resolutions {
+		ip := net.ParseIP(r.Address)
+		if ip == nil || r.LastSeen.IsZero() {
+			continue
+		}
+
+		qtype := dns.TypeAAAA
+		if ip.To4() != nil {
+			qtype = dns.TypeA
+		}
+		if !dm.enum.storesRecord(ctx, req.Name, qtype) {
+			continue
+		}
+
+		p := history.Period{FirstSeen: r.FirstSeen, LastSeen: r.LastSeen}
+		e := dm.enum.writeRecord(ctx, journal.Record{Op: addrOp(r.Address), Name: req.Name, Target: ip.String()}, p)
+		if e != nil && err == nil {
+			err = fmt.Errorf("failed to insert the historical address of %s: %v", req.Name, e)
+		}
+	}
 	return err
 }
 
@@ -152,44 +218,73 @@ func (dm *dataManager) insertCNAME(ctx context.Context, req *requests.DNSRequest
 	}
 	// Important - Allows chained CNAME records to be resolved until an A/AAAA record
 	dm.enum.nameSrc.newName(&requests.DNSRequest{
-		Name:   target,
-		Domain: strings.ToLower(domain),
+		Name:       target,
+		Domain:     strings.ToLower(domain),
+		Parent:     req.Name,
+		Derivation: requests.DerivedFromCNAME,
 	})
-	if err := dm.enum.graph.UpsertCNAME(ctx, req.Name, target); err != nil {
+	if !dm.enum.storesRecord(ctx, req.Name, dns.TypeCNAME) {
+		return nil
+	}
+	if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpCNAME, Name: req.Name, Target: target}, history.Period{}); err != nil {
 		return fmt.Errorf("failed to insert CNAME: %v", err)
 	}
 	return nil
 }
 
 func (dm *dataManager) insertA(ctx context.Context, req *requests.DNSRequest, recidx int, tp pipeline.TaskParams) error {
+	// Service labels, such as _dmarc, never belong to host names
+	if err := amassdns.ValidateName(req.Name, false); err != nil {
+		return fmt.Errorf("failed to insert A record: %v", err)
+	}
+
 	addr := strings.TrimSpace(req.Records[recidx].Data)
 	if addr == "" {
 		return errors.New("failed to extract an IP address from the DNS answer data")
 	}
 	dm.enum.checkForMissedWildcards(addr)
+	dm.enum.prov.add(addr, req.Name, requests.DerivedFromA)
+	dm.enum.observeAddress(req.Name, addr, ObservedByDNS, req.Derivation)
 	dm.enum.nameSrc.newAddr(&requests.AddrRequest{
 		Address: addr,
 		InScope: true,
 		Domain:  req.Domain,
 	})
-	if err := dm.enum.graph.UpsertA(ctx, req.Name, addr); err != nil {
+	if !dm.enum.storesRecord(ctx, req.Name, dns.TypeA) {
+		return nil
+	}
+	// The resolution was observed by the enumeration, so the edge is current for the bounded TTL of the record
+	p := dm.enum.observedPeriod(time.Now(), req.Records[recidx].TTL)
+	if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpA, Name: req.Name, Target: addr}, p); err != nil {
 		return fmt.Errorf("failed to insert A record: %v", err)
 	}
 	return nil
 }
 
 func (dm *dataManager) insertAAAA(ctx context.Context, req *requests.DNSRequest, recidx int, tp pipeline.TaskParams) error {
+	// Service labels, such as _dmarc, never belong to host names
+	if err := amassdns.ValidateName(req.Name, false); err != nil {
+		return fmt.Errorf("failed to insert AAAA record: %v", err)
+	}
+
 	addr := strings.TrimSpace(req.Records[recidx].Data)
 	if addr == "" {
 		return errors.New("failed to extract an IP address from the DNS answer data")
 	}
 	dm.enum.checkForMissedWildcards(addr)
+	dm.enum.prov.add(addr, req.Name, requests.DerivedFromAAAA)
+	dm.enum.observeAddress(req.Name, addr, ObservedByDNS, req.Derivation)
 	dm.enum.nameSrc.newAddr(&requests.AddrRequest{
 		Address: addr,
 		InScope: true,
 		Domain:  req.Domain,
 	})
-	if err := dm.enum.graph.UpsertAAAA(ctx, req.Name, addr); err != nil {
+	if !dm.enum.storesRecord(ctx, req.Name, dns.TypeAAAA) {
+		return nil
+	}
+	// The resolution was observed by the enumeration, so the edge is current for the bounded TTL of the record
+	p := dm.enum.observedPeriod(time.Now(), req.Records[recidx].TTL)
+	if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpAAAA, Name: req.Name, Target: addr}, p); err != nil {
 		return fmt.Errorf("failed to insert AAAA record: %v", err)
 	}
 	return nil
@@ -207,10 +302,15 @@ func (dm *dataManager) insertPTR(ctx context.Context, req *requests.DNSRequest,
 	}
 	// Important - Allows the target DNS name to be resolved in the forward direction
 	dm.enum.nameSrc.newName(&requests.DNSRequest{
-		Name:   target,
-		Domain: domain,
+		Name:       target,
+		Domain:     domain,
+		Parent:     req.Name,
+		Derivation: requests.DerivedFromPTR,
 	})
-	if err := dm.enum.graph.UpsertPTR(ctx, req.Name, target); err != nil {
+	if !dm.enum.storesRecord(ctx, req.Name, dns.TypePTR) {
+		return nil
+	}
+	if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpPTR, Name: req.Name, Target: target}, history.Period{}); err != nil {
 		return fmt.Errorf("failed to insert PTR record: %v", err)
 	}
 	return nil
@@ -222,13 +322,11 @@ func (dm *dataManager) insertSRV(ctx context.Context, req *requests.DNSRequest,
 	if target == "" || service == "" {
 		return errors.New("failed to extract service info from the DNS answer data")
 	}
-	if domain := dm.enum.Config.WhichDomain(target); domain != "" {
-		dm.enum.nameSrc.newName(&requests.DNSRequest{
-			Name:   target,
-			Domain: domain,
-		})
+	dm.submitTarget(ctx, target, service, requests.DerivedFromSRV)
+	if !dm.enum.storesRecord(ctx, service, dns.TypeSRV) {
+		return nil
 	}
-	if err := dm.enum.graph.UpsertSRV(ctx, service, target); err != nil {
+	if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpSRV, Name: service, Target: target}, history.Period{}); err != nil {
 		return fmt.Errorf("failed to insert SRV record: %v", err)
 	}
 	return nil
@@ -240,17 +338,14 @@ func (dm *dataManager) insertNS(ctx context.Context, req *requests.DNSRequest, r
 		return errors.New("failed to extract NS info from the DNS answer data")
 	}
 
-	domain, err := publicsuffix.EffectiveTLDPlusOne(target)
-	if err != nil || domain == "" {
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(target); err != nil || domain == "" {
 		return errors.New("failed to extract a domain name from the FQDN")
 	}
-	if d := strings.ToLower(domain); target != d {
-		dm.enum.nameSrc.newName(&requests.DNSRequest{
-			Name:   target,
-			Domain: d,
-		})
+	dm.submitTarget(ctx, target, req.Name, requests.DerivedFromNS)
+	if !dm.enum.storesRecord(ctx, req.Name, dns.TypeNS) {
+		return nil
 	}
-	if err := dm.enum.graph.UpsertNS(ctx, req.Name, target); err != nil {
+	if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpNS, Name: req.Name, Target: target}, history.Period{}); err != nil {
 		return fmt.Errorf("failed to insert NS record: %v", err)
 	}
 	return nil
@@ -262,17 +357,14 @@ func (dm *dataManager) insertMX(ctx context.Context, req *requests.DNSRequest, r
 		return errors.New("failed to extract a FQDN from the DNS answer data")
 	}
 
-	domain, err := publicsuffix.EffectiveTLDPlusOne(target)
-	if err != nil || domain == "" {
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(target); err != nil || domain == "" {
 		return errors.New("failed to extract a domain name from the FQDN")
 	}
-	if d := strings.ToLower(domain); target != d {
-		dm.enum.nameSrc.newName(&requests.DNSRequest{
-			Name:   target,
-			Domain: d,
-		})
+	dm.submitTarget(ctx, target, req.Name, requests.DerivedFromMX)
+	if !dm.enum.storesRecord(ctx, req.Name, dns.TypeMX) {
+		return nil
 	}
-	if err := dm.enum.graph.UpsertMX(ctx, req.Name, target); err != nil {
+	if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpMX, Name: req.Name, Target: target}, history.Period{}); err != nil {
 		return fmt.Errorf("failed to insert MX record: %v", err)
 	}
 	return nil
@@ -280,26 +372,81 @@ func (dm *dataManager) insertMX(ctx context.Context, req *requests.DNSRequest, r
 
 func (dm *dataManager) insertTXT(ctx context.Context, req *requests.DNSRequest, recidx int, tp pipeline.TaskParams) error {
 	if dm.enum.Config.IsDomainInScope(req.Name) {
-		dm.findNamesAndAddresses(ctx, req.Records[recidx].Data, req.Domain, tp)
+		dm.findNamesAndAddresses(ctx, req.Records[recidx].Data, req.Domain, req.Name, requests.DerivedFromTXT, tp)
 	}
 	return nil
 }
 
 func (dm *dataManager) insertSOA(ctx context.Context, req *requests.DNSRequest, recidx int, tp pipeline.TaskParams) error {
+	data := req.Records[recidx].Data
+	// The record data holds the primary name server of the zone followed by the mailbox
+	if pieces := strings.Split(data, ","); len(pieces) > 1 {
+		data = strings.Join(pieces[1:], ",")
+
+		if mname := resolve.RemoveLastDot(strings.TrimSpace(pieces[0])); mname != "" {
+			dm.submitTarget(ctx, mname, req.Name, requests.DerivedFromSOA)
+			// The graph taxonomy has no SOA relation, so the primary is linked as a name server of the zone
+			if dm.enum.storesRecord(ctx, req.Name, dns.TypeSOA) {
+				if err := dm.enum.writeRecord(ctx, journal.Record{Op: journal.OpNS, Name: req.Name, Target: mname}, history.Period{}); err != nil {
+					return fmt.Errorf("failed to insert SOA record: %v", err)
+				}
+			}
+		}
+	}
+
 	if dm.enum.Config.IsDomainInScope(req.Name) {
-		dm.findNamesAndAddresses(ctx, req.Records[recidx].Data, req.Domain, tp)
+		dm.findNamesAndAddresses(ctx, data, req.Domain, req.Name, requests.DerivedFromSOA, tp)
 	}
 	return nil
 }
 
 func (dm *dataManager) insertSPF(ctx context.Context, req *requests.DNSRequest, recidx int, tp pipeline.TaskParams) error {
 	if dm.enum.Config.IsDomainInScope(req.Name) {
-		dm.findNamesAndAddresses(ctx, req.Records[recidx].Data, req.Domain, tp)
+		dm.findNamesAndAddresses(ctx, req.Records[recidx].Data, req.Domain, req.Name, requests.DerivedFromTXT, tp)
 	}
 	return nil
 }
 
-func (dm *dataManager) findNamesAndAddresses(ctx context.Context, data, domain string, tp pipeline.TaskParams) {
+func (dm *dataManager) insertCAA(ctx context.Context, req *requests.DNSRequest, recidx int, tp pipeline.TaskParams) error {
+	rec, err := parseCAA(req.Records[recidx].Data)
+	if err != nil {
+		return fmt.Errorf("failed to insert CAA record: %v", err)
+	}
+
+	dm.enum.caa.add(req.Name, rec)
+	return nil
+}
+
+// submitTarget submits the host named by a record of the parent, such as a name server or mail exchange, as a
+// candidate when it is in scope. The hosts outside of the scope remain nodes at the boundary of the graph.
+func (dm *dataManager) submitTarget(ctx context.Context, target, parent, derivation string) {
+	target = strings.ToLower(target)
+	// A record naming its own owner reveals nothing new, and resolving it again would loop
+	if target == "" || target == parent {
+		return
+	}
+
+	domain := strings.ToLower(dm.enum.Config.WhichDomain(target))
+	if domain == "" {
+		dm.enum.prov.add(target, parent, derivation)
+		_, err := dm.enum.graph.UpsertFQDN(ctx, target)
+		dm.enum.journalWrite(journal.Record{Op: journal.OpFQDN, Name: target}, err)
+		return
+	}
+	// The root domain names have been submitted at the start of the enumeration
+	if target == domain {
+		return
+	}
+
+	dm.enum.nameSrc.newName(&requests.DNSRequest{
+		Name:       target,
+		Domain:     domain,
+		Parent:     parent,
+		Derivation: derivation,
+	})
+}
+
+func (dm *dataManager) findNamesAndAddresses(ctx context.Context, data, domain, parent, derivation string, tp pipeline.TaskParams) {
 	ipre := regexp.MustCompile(amassnet.IPv4RE)
 	for _, ip := range ipre.FindAllString(data, -1) {
 		dm.enum.nameSrc.newAddr(&requests.AddrRequest{
@@ -312,8 +459,10 @@ func (dm *dataManager) findNamesAndAddresses(ctx context.Context, data, domain s
 	for _, name := range subre.FindAllString(data, -1) {
 		if domain := strings.ToLower(dm.enum.Config.WhichDomain(name)); domain != "" {
 			dm.enum.nameSrc.newName(&requests.DNSRequest{
-				Name:   name,
-				Domain: domain,
+				Name:       name,
+				Domain:     domain,
+				Parent:     parent,
+				Derivation: derivation,
 			})
 		}
 	}
@@ -332,6 +481,7 @@ func (dm *dataManager) addrRequest(ctx context.Context, req *requests.AddrReques
 	if yes, prefix := amassnet.IsReservedAddress(req.Address); yes {
 		var err error
 		if e := dm.enum.graph.UpsertInfrastructure(ctx, 0, amassnet.ReservedCIDRDescription, req.Address, prefix); e != nil {
+			dm.enum.journalInfrastructure(0, amassnet.ReservedCIDRDescription, req.Address, prefix, e)
 			err = e
 		}
 		return err
@@ -339,8 +489,10 @@ func (dm *dataManager) addrRequest(ctx context.Context, req *requests.AddrReques
 	if r := dm.enum.Sys.Cache().AddrSearch(req.Address); r != nil {
 		var err error
 		if e := dm.enum.graph.UpsertInfrastructure(ctx, r.ASN, r.Description, req.Address, r.Prefix); e != nil {
+			dm.enum.journalInfrastructure(r.ASN, r.Description, req.Address, r.Prefix, e)
 			err = e
 		}
+		dm.enum.regs.netblock(r)
 		return err
 	}
 
@@ -373,7 +525,8 @@ func (dm *dataManager) nextInfraInfo() {
 	ctx := context.Background()
 	req := e.(*requests.AddrRequest)
 	if r := dm.enum.Sys.Cache().AddrSearch(req.Address); r != nil {
-		_ = dm.enum.graph.UpsertInfrastructure(ctx, r.ASN, r.Description, req.Address, r.Prefix)
+		dm.upsertInfrastructure(ctx, r.ASN, r.Description, req.Address, r.Prefix)
+		dm.enum.regs.netblock(r)
 		return
 	}
 
@@ -388,7 +541,8 @@ loop:
 
 		time.Sleep(2 * time.Second)
 		if r := dm.enum.Sys.Cache().AddrSearch(req.Address); r != nil {
-			_ = dm.enum.graph.UpsertInfrastructure(ctx, r.ASN, r.Description, req.Address, r.Prefix)
+			dm.upsertInfrastructure(ctx, r.ASN, r.Description, req.Address, r.Prefix)
+			dm.enum.regs.netblock(r)
 			return
 		}
 	}
@@ -396,7 +550,7 @@ loop:
 	asn := 0
 	desc := "Unknown"
 	prefix := fakePrefix(req.Address)
-	_ = dm.enum.graph.UpsertInfrastructure(ctx, asn, desc, req.Address, prefix)
+	dm.upsertInfrastructure(ctx, asn, desc, req.Address, prefix)
 
 	first, cidr, _ := net.ParseCIDR(prefix)
 	dm.enum.Sys.Cache().Update(&requests.ASNRequest{
@@ -420,3 +574,12 @@ func fakePrefix(addr string) string {
 	mask := net.CIDRMask(bits, total)
 	return fmt.Sprintf("%s/%d", ip.Mask(mask).String(), bits)
 }
+
+// upsertInfrastructure writes the infrastructure of the address, journaling the write when it fails, and
+// reports the latency of the graph database to the dispatch of the candidate names.
+func (dm *dataManager) upsertInfrastructure(ctx context.Context, asn int, desc, addr, prefix string) {
+	start := dm.enum.pressure.now()
+	err := dm.enum.graph.UpsertInfrastructure(ctx, asn, desc, addr, prefix)
+	dm.enum.pressure.timed(start)
+	dm.enum.journalInfrastructure(asn, desc, addr, prefix, err)
+}
@@ -0,0 +1,207 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func TestRecordTargetsSubmitted(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	e := &Enumeration{Config: cfg, graph: g, prov: newProvenanceGraph()}
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+	dm := &dataManager{enum: e}
+
+	// The fake zone delegates to name servers within and outside of the zone
+	zone := []*requests.DNSRequest{
+		{Name: "owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+			{Name: "owasp.org", Type: int(dns.TypeNS), Data: "ns1.owasp.org"},
+			{Name: "owasp.org", Type: int(dns.TypeNS), Data: "ns1.cloudflare.com."},
+			{Name: "owasp.org", Type: int(dns.TypeMX), Data: "mail.owasp.org."},
+			{Name: "owasp.org", Type: int(dns.TypeMX), Data: "owasp.org"},
+			{Name: "owasp.org", Type: int(dns.TypeSOA), Data: "ns0.owasp.org.,hostmaster.owasp.org."},
+		}},
+		{Name: "_sip._tcp.owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+			{Name: "_sip._tcp.owasp.org", Type: int(dns.TypeSRV), Data: "sip.owasp.org"},
+		}},
+		// The name server of the zone names itself, and is named again by the zone
+		{Name: "ns1.owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+			{Name: "ns1.owasp.org", Type: int(dns.TypeSOA), Data: "ns1.owasp.org.,hostmaster.owasp.org."},
+		}},
+		{Name: "owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+			{Name: "owasp.org", Type: int(dns.TypeNS), Data: "ns1.owasp.org"},
+		}},
+	}
+	for _, req := range zone {
+		if err := dm.dnsRequest(context.Background(), req, nil); err != nil {
+			t.Fatalf("the records of %s were not stored: %v", req.Name, err)
+		}
+	}
+
+	var names []string
+	derivations := make(map[string]string)
+	for e.nameSrc.queue.Len() > 0 {
+		element, _ := e.nameSrc.queue.Next()
+		req := element.(*requests.DNSRequest)
+
+		names = append(names, req.Name)
+		derivations[req.Name] = req.Derivation
+	}
+	sort.Strings(names)
+
+	expected := []string{"hostmaster.owasp.org", "mail.owasp.org", "ns0.owasp.org", "ns1.owasp.org", "sip.owasp.org"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("the candidates %v were submitted, expected %v", names, expected)
+	}
+	for name, derivation := range map[string]string{
+		"ns1.owasp.org":  requests.DerivedFromNS,
+		"mail.owasp.org": requests.DerivedFromMX,
+		"ns0.owasp.org":  requests.DerivedFromSOA,
+		"sip.owasp.org":  requests.DerivedFromSRV,
+	} {
+		if derivations[name] != derivation {
+			t.Errorf("%s was derived from %q, expected %q", name, derivations[name], derivation)
+		}
+	}
+
+	ctx := context.Background()
+	if !g.IsNSNode(ctx, "ns1.owasp.org", time.Time{}) || !g.IsMXNode(ctx, "mail.owasp.org", time.Time{}) {
+		t.Error("the targets are not linked to the records that revealed them")
+	}
+	// The name server outside of the scope is kept at the boundary of the graph
+	if !g.IsNSNode(ctx, "ns1.cloudflare.com", time.Time{}) {
+		t.Error("the out of scope name server is missing from the graph")
+	}
+	if p := e.prov.chain("ns1.cloudflare.com"); len(p) == 0 || p[0].Derivation != requests.DerivedFromNS {
+		t.Errorf("the out of scope name server has the provenance %v", p)
+	}
+
+	// The primary name server of the SOA record is linked as a name server of the zone
+	if !g.IsNSNode(ctx, "ns0.owasp.org", time.Time{}) {
+		t.Error("the primary name server of the SOA record is not linked to the zone")
+	}
+}
+
+func TestHistoricalAddressesStored(t *testing.T) {
+	ctx := context.Background()
+	for _, keep := range []bool{true, false} {
+		g := netmap.NewGraph("memory", "", "")
+		defer g.Remove()
+
+		cfg := config.NewConfig()
+		cfg.AddDomain("owasp.org")
+		job := requests.NewJob("test", cfg, nil)
+		job.Findings.Add(&requests.Finding{
+			Name: "www.owasp.org",
+			Resolutions: []requests.Resolution{
+				{Address: "192.0.2.1", FirstSeen: time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC), LastSeen: time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{Address: "192.0.2.2", FirstSeen: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), LastSeen: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		})
+
+		e := &Enumeration{Config: cfg, graph: g, prov: newProvenanceGraph(), job: job}
+		if keep {
+			store, err := history.Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.History = store.Backend("memory")
+		}
+		e.nameSrc = &enumSource{
+			enum:    e,
+			queue:   queue.NewQueue(),
+			filter:  bf.NewDefaultStableBloomFilter(1000000, 0.01),
+			done:    make(chan struct{}),
+			release: make(chan struct{}, 10),
+			max:     10,
+			rejects: make(map[string]int),
+		}
+		dm := &dataManager{enum: e}
+
+		start := time.Now()
+		// The name still resolves to one of the addresses observed by the data sources
+		req := &requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+			{Name: "www.owasp.org", Type: int(dns.TypeA), Data: "192.0.2.2"},
+		}}
+		if err := dm.dnsRequest(ctx, req, nil); err != nil {
+			t.Fatalf("the records of %s were not stored: %v", req.Name, err)
+		}
+
+		addrs := func(v history.Validity) []string {
+			pairs, _ := history.NamesToAddrs(ctx, g, e.History, v, start, time.Time{}, "www.owasp.org")
+
+			var list []string
+			for _, p := range pairs {
+				list = append(list, p.Addr.Address.String())
+			}
+			sort.Strings(list)
+			return list
+		}
+		if !keep {
+			// Without the history, the historical addresses would be taken as current
+			if got := addrs(history.Ever); !reflect.DeepEqual(got, []string{"192.0.2.2"}) {
+				t.Errorf("the addresses %v were stored without the history", got)
+			}
+			continue
+		}
+
+		if got := addrs(history.Ever); !reflect.DeepEqual(got, []string{"192.0.2.1", "192.0.2.2"}) {
+			t.Errorf("the addresses %v were observed", got)
+		}
+		if got := addrs(history.Current); !reflect.DeepEqual(got, []string{"192.0.2.2"}) {
+			t.Errorf("the addresses %v are current", got)
+		}
+		if p, _ := e.History.Period("www.owasp.org", "192.0.2.2"); p.FirstSeen.Year() != 2020 || p.LastSeen.Before(start) {
+			t.Errorf("the period %+v of the current address was not widened", p)
+		}
+	}
+}
+
+func TestDisplayNameStored(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("xn--mnchen-3ya.de")
+	store, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Enumeration{Config: cfg, graph: g, prov: newProvenanceGraph(), History: store.Backend("memory")}
+	dm := &dataManager{enum: e}
+
+	req := &requests.DNSRequest{Name: "www.xn--mnchen-3ya.de", Domain: "xn--mnchen-3ya.de"}
+	if err := dm.dnsRequest(context.Background(), req, nil); err != nil {
+		t.Fatalf("%s was not stored: %v", req.Name, err)
+	}
+	if d, found := e.History.DisplayName(req.Name); !found || d != "www.münchen.de" {
+		t.Errorf("the display name %q was not stored with the name", d)
+	}
+}
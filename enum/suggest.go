@@ -0,0 +1,306 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+	"golang.org/x/net/publicsuffix"
+)
+
+// The kinds of infrastructure shared between the seeds and a suggested domain.
+const (
+	SharedNameserver = "nameserver"
+	SharedMailServer = "mail_server"
+	SharedNetblock   = "netblock"
+)
+
+// Edge is a relationship of the graph, identified by the names of its assets.
+type Edge struct {
+	From     string `json:"from"`
+	Relation string `json:"relation"`
+	To       string `json:"to"`
+}
+
+// SharedInfrastructure is a name server, mail server or netblock used by both the seeds and the suggested domain.
+type SharedInfrastructure struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+	// Evidence holds the edges of the graph that link the seeds and the suggested domain to the infrastructure
+	Evidence []Edge `json:"evidence"`
+}
+
+// ScopeSuggestion proposes adding the apex domain to the scope, since it shares infrastructure with the seeds.
+// The suggestions are never applied to the configuration.
+type ScopeSuggestion struct {
+	Domain string `json:"domain"`
+	// Score is the number of infrastructure points shared with the seeds
+	Score  int                    `json:"score"`
+	Shared []SharedInfrastructure `json:"shared"`
+}
+
+// infraPoint identifies a name server, mail server or netblock.
+type infraPoint struct {
+	kind  string
+	value string
+}
+
+// infraUsage collects the edges linking the seeds and the other apex domains to the infrastructure points.
+type infraUsage struct {
+	seeds []string
+	// seedEdges holds the edges from the names within the seeds to each point
+	seedEdges map[infraPoint][]Edge
+	// others holds the edges from the names of each other apex domain to each point
+	others map[infraPoint]map[string][]Edge
+}
+
+func (u *infraUsage) add(p infraPoint, name string, edges ...Edge) {
+	if u.inSeeds(name) {
+		u.seedEdges[p] = append(u.seedEdges[p], edges...)
+		return
+	}
+
+	apex, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil || apex == "" {
+		return
+	}
+	if _, found := u.others[p]; !found {
+		u.others[p] = make(map[string][]Edge)
+	}
+	u.others[p][apex] = append(u.others[p][apex], edges...)
+}
+
+func (u *infraUsage) inSeeds(name string) bool {
+	for _, d := range u.seeds {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// ptrRecord is a PTR record of an address, which is matched with the netblocks of the seeds.
+type ptrRecord struct {
+	addr netip.Addr
+	edge Edge
+}
+
+// SuggestScope analyzes the graph of a finished enumeration of the seed domains, and proposes the apex domains
+// sharing name servers, mail servers or netblocks with the seeds, ranked by the number of shared infrastructure
+// points. The names found in the netblocks of the seeds through PTR records also reveal sibling domains. Only
+// the relationships seen since the provided time are considered.
+func SuggestScope(ctx context.Context, g *netmap.Graph, seeds []string, since time.Time) ([]*ScopeSuggestion, error) {
+	if g == nil || g.DB == nil {
+		return nil, errors.New("SuggestScope: the graph has not been initialized")
+	}
+	if !since.IsZero() {
+		since = since.UTC()
+	}
+
+	u := &infraUsage{
+		seedEdges: make(map[infraPoint][]Edge),
+		others:    make(map[infraPoint]map[string][]Edge),
+	}
+	for _, d := range seeds {
+		if d = strings.ToLower(strings.Trim(d, ".")); d != "" {
+			u.seeds = append(u.seeds, d)
+		}
+	}
+
+	assets, err := g.DB.FindByType(oam.FQDN, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var ptrs []ptrRecord
+	for _, a := range assets {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		fqdn, ok := a.Asset.(domain.FQDN)
+		if !ok {
+			continue
+		}
+
+		rels, err := g.DB.OutgoingRelations(a, since, "ns_record", "mx_record", "a_record", "aaaa_record", "ptr_record")
+		if err != nil {
+			continue
+		}
+		for _, rel := range rels {
+			to, err := g.DB.FindById(rel.ToAsset.ID, since)
+			if err != nil {
+				continue
+			}
+
+			switch v := to.Asset.(type) {
+			case domain.FQDN:
+				edge := Edge{From: fqdn.Name, Relation: rel.Type, To: v.Name}
+
+				switch rel.Type {
+				case "ns_record":
+					u.add(infraPoint{kind: SharedNameserver, value: v.Name}, fqdn.Name, edge)
+				case "mx_record":
+					u.add(infraPoint{kind: SharedMailServer, value: v.Name}, fqdn.Name, edge)
+				case "ptr_record":
+					if addr, ok := arpaAddress(fqdn.Name); ok {
+						ptrs = append(ptrs, ptrRecord{addr: addr, edge: edge})
+					}
+				}
+			case network.IPAddress:
+				edge := Edge{From: fqdn.Name, Relation: rel.Type, To: v.Address.String()}
+
+				for _, nb := range addressNetblocks(g, to, since) {
+					u.add(infraPoint{kind: SharedNetblock, value: nb.String()}, fqdn.Name, edge,
+						Edge{From: nb.String(), Relation: "contains", To: v.Address.String()})
+				}
+			}
+		}
+	}
+	// The PTR records within the netblocks of the seeds name the other hosts of the netblocks
+	for p := range u.seedEdges {
+		if p.kind != SharedNetblock {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(p.value)
+		if err != nil {
+			continue
+		}
+		for _, ptr := range ptrs {
+			if prefix.Contains(ptr.addr) {
+				u.add(p, ptr.edge.To, ptr.edge)
+			}
+		}
+	}
+	return u.suggestions(), nil
+}
+
+// suggestions returns the apex domains sharing the infrastructure points of the seeds, ranked by the number
+// of points they share, and then by name.
+func (u *infraUsage) suggestions() []*ScopeSuggestion {
+	byDomain := make(map[string]*ScopeSuggestion)
+
+	for p, seedEdges := range u.seedEdges {
+		for apex, edges := range u.others[p] {
+			s, found := byDomain[apex]
+			if !found {
+				s = &ScopeSuggestion{Domain: apex}
+				byDomain[apex] = s
+			}
+
+			s.Shared = append(s.Shared, SharedInfrastructure{
+				Kind:     p.kind,
+				Value:    p.value,
+				Evidence: uniqueEdges(append(append([]Edge(nil), seedEdges...), edges...)),
+			})
+		}
+	}
+
+	list := make([]*ScopeSuggestion, 0, len(byDomain))
+	for _, s := range byDomain {
+		sort.Slice(s.Shared, func(i, j int) bool {
+			if s.Shared[i].Kind != s.Shared[j].Kind {
+				return s.Shared[i].Kind < s.Shared[j].Kind
+			}
+			return s.Shared[i].Value < s.Shared[j].Value
+		})
+		s.Score = len(s.Shared)
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].Domain < list[j].Domain
+	})
+	return list
+}
+
+// addressNetblocks returns the netblocks containing the address in the graph.
+func addressNetblocks(g *netmap.Graph, addr *types.Asset, since time.Time) []netip.Prefix {
+	rels, err := g.DB.IncomingRelations(addr, since, "contains")
+	if err != nil {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+	for _, rel := range rels {
+		if from, err := g.DB.FindById(rel.FromAsset.ID, since); err == nil {
+			if nb, ok := from.Asset.(network.Netblock); ok && nb.Cidr.IsValid() {
+				prefixes = append(prefixes, nb.Cidr.Masked())
+			}
+		}
+	}
+	return prefixes
+}
+
+func uniqueEdges(edges []Edge) []Edge {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].Relation != edges[j].Relation {
+			return edges[i].Relation < edges[j].Relation
+		}
+		return edges[i].To < edges[j].To
+	})
+
+	var unique []Edge
+	for i, e := range edges {
+		if i == 0 || e != edges[i-1] {
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// arpaAddress returns the address of the reverse DNS name, such as 1.2.0.192.in-addr.arpa.
+func arpaAddress(name string) (netip.Addr, bool) {
+	name = strings.ToLower(strings.Trim(name, "."))
+
+	if labels := strings.TrimSuffix(name, ".in-addr.arpa"); labels != name {
+		octets := strings.Split(labels, ".")
+		if len(octets) != 4 {
+			return netip.Addr{}, false
+		}
+		for i, j := 0, len(octets)-1; i < j; i, j = i+1, j-1 {
+			octets[i], octets[j] = octets[j], octets[i]
+		}
+		addr, err := netip.ParseAddr(strings.Join(octets, "."))
+		return addr, err == nil && addr.Is4()
+	}
+
+	if labels := strings.TrimSuffix(name, ".ip6.arpa"); labels != name {
+		nibbles := strings.Split(labels, ".")
+		if len(nibbles) != 32 {
+			return netip.Addr{}, false
+		}
+
+		var b strings.Builder
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			b.WriteString(nibbles[i])
+			if i > 0 && i%4 == 0 {
+				b.WriteByte(':')
+			}
+		}
+		addr, err := netip.ParseAddr(b.String())
+		return addr, err == nil && addr.Is6()
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,131 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+)
+
+func TestSuggestScope(t *testing.T) {
+	ctx := context.Background()
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	check := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The seed domain and the infrastructure it uses
+	check(g.UpsertNS(ctx, "owasp.org", "ns1.owasp-dns.net"))
+	check(g.UpsertNS(ctx, "owasp.org", "ns2.owasp-dns.net"))
+	check(g.UpsertMX(ctx, "owasp.org", "mx.owasp-mail.com"))
+	check(g.UpsertA(ctx, "www.owasp.org", "192.0.2.10"))
+	check(g.UpsertInfrastructure(ctx, 64496, "EXAMPLE-NET", "192.0.2.10", "192.0.2.0/24"))
+	// The names within the seed are never suggested
+	check(g.UpsertNS(ctx, "dev.owasp.org", "ns.elsewhere.io"))
+	// The sibling shares both name servers, the mail server and the netblock
+	check(g.UpsertNS(ctx, "sibling.org", "ns1.owasp-dns.net"))
+	check(g.UpsertNS(ctx, "sibling.org", "ns2.owasp-dns.net"))
+	check(g.UpsertMX(ctx, "sibling.org", "mx.owasp-mail.com"))
+	check(g.UpsertA(ctx, "www.sibling.org", "192.0.2.20"))
+	check(g.UpsertInfrastructure(ctx, 64496, "EXAMPLE-NET", "192.0.2.20", "192.0.2.0/24"))
+	// The partner only shares a name server
+	check(g.UpsertNS(ctx, "partner.com", "ns1.owasp-dns.net"))
+	// The cousin is revealed by a PTR record within the netblock of the seed
+	check(g.UpsertPTR(ctx, "30.2.0.192.in-addr.arpa", "host.cousin.net"))
+	// The unrelated domain shares nothing with the seed
+	check(g.UpsertNS(ctx, "unrelated.com", "ns.other.net"))
+	check(g.UpsertA(ctx, "www.unrelated.com", "198.51.100.5"))
+	check(g.UpsertInfrastructure(ctx, 64497, "OTHER-NET", "198.51.100.5", "198.51.100.0/24"))
+	check(g.UpsertPTR(ctx, "9.100.51.198.in-addr.arpa", "host.stranger.net"))
+
+	suggestions, err := SuggestScope(ctx, g, []string{"OWASP.org"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		domain string
+		score  int
+	}{
+		{"sibling.org", 4},
+		{"cousin.net", 1},
+		{"partner.com", 1},
+	}
+	if len(suggestions) != len(expected) {
+		for _, s := range suggestions {
+			t.Logf("%s: %d %+v", s.Domain, s.Score, s.Shared)
+		}
+		t.Fatalf("%d scope expansions were suggested, expected %d", len(suggestions), len(expected))
+	}
+	for i, exp := range expected {
+		if s := suggestions[i]; s.Domain != exp.domain || s.Score != exp.score || len(s.Shared) != exp.score {
+			t.Errorf("suggestion %d is %s with the score %d, expected %s with %d", i, s.Domain, s.Score, exp.domain, exp.score)
+		}
+	}
+
+	sibling := suggestions[0]
+	kinds := []string{SharedMailServer, SharedNameserver, SharedNameserver, SharedNetblock}
+	for i, shared := range sibling.Shared {
+		if shared.Kind != kinds[i] {
+			t.Errorf("the shared infrastructure %d is a %s, expected a %s", i, shared.Kind, kinds[i])
+		}
+	}
+	// The evidence links both the seed and the suggested domain to the netblock
+	netblock := sibling.Shared[3]
+	if netblock.Value != "192.0.2.0/24" {
+		t.Errorf("the shared netblock is %s", netblock.Value)
+	}
+	for _, edge := range []Edge{
+		{From: "www.owasp.org", Relation: "a_record", To: "192.0.2.10"},
+		{From: "192.0.2.0/24", Relation: "contains", To: "192.0.2.10"},
+		{From: "www.sibling.org", Relation: "a_record", To: "192.0.2.20"},
+		{From: "192.0.2.0/24", Relation: "contains", To: "192.0.2.20"},
+	} {
+		if !hasEdge(netblock.Evidence, edge) {
+			t.Errorf("the evidence %+v is missing the edge %+v", netblock.Evidence, edge)
+		}
+	}
+
+	cousin := suggestions[1]
+	if ptr := (Edge{From: "30.2.0.192.in-addr.arpa", Relation: "ptr_record", To: "host.cousin.net"}); !hasEdge(cousin.Shared[0].Evidence, ptr) {
+		t.Errorf("the evidence of the cousin %+v is missing the PTR record", cousin.Shared[0].Evidence)
+	}
+}
+
+func hasEdge(edges []Edge, edge Edge) bool {
+	for _, e := range edges {
+		if e == edge {
+			return true
+		}
+	}
+	return false
+}
+
+func TestArpaAddress(t *testing.T) {
+	tests := []struct {
+		name  string
+		addr  string
+		valid bool
+	}{
+		{"10.2.0.192.in-addr.arpa.", "192.0.2.10", true},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", "2001:db8::1", true},
+		{"2.0.192.in-addr.arpa", "", false},
+		{"www.owasp.org", "", false},
+	}
+
+	for _, test := range tests {
+		addr, ok := arpaAddress(test.name)
+		if ok != test.valid || (ok && addr != netip.MustParseAddr(test.addr)) {
+			t.Errorf("%s: returned %s and %t", test.name, addr, ok)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// TierStats reports the confirmation of the positive answers of the untrusted resolvers by the trusted
+// resolvers, along with the queries it cost.
+type TierStats struct {
+	// UntrustedQueries and TrustedQueries count the queries sent by the screening and the confirmation
+	UntrustedQueries int64 `json:"untrusted_queries"`
+	TrustedQueries   int64 `json:"trusted_queries"`
+	// Screened counts the names the untrusted resolvers answered, which were sent for confirmation
+	Screened  int `json:"screened"`
+	Confirmed int `json:"confirmed"`
+	// Refuted counts the names the trusted resolvers found no records for
+	Refuted int `json:"refuted"`
+	// Unverified counts the names the trusted resolvers failed to answer
+	Unverified int `json:"unverified"`
+	// Disagreements counts the refuted answers of each untrusted resolver
+	Disagreements map[string]int `json:"disagreements,omitempty"`
+	// Unattributed counts the refuted answers without a known untrusted resolver
+	Unattributed int `json:"unattributed,omitempty"`
+}
+
+// tierStats tracks the answers of the untrusted resolvers through their confirmation by the trusted resolvers.
+// It is only kept when the trusted resolvers have been configured, since the default trusted resolvers are
+// no more trustworthy than the public resolvers screening the names.
+type tierStats struct {
+	sync.Mutex
+	stats TierStats
+	// answered holds the untrusted resolver that last answered each name being screened
+	answered map[string]string
+	// pending holds the untrusted resolver of each name waiting for confirmation
+	pending map[string]string
+}
+
+// tiersFromConfig returns the confirmation tracker, or nil when no trusted resolvers have been configured.
+func tiersFromConfig(cfg *config.Config) *tierStats {
+	if trusted, _ := systems.TrustedResolversFromConfig(cfg); len(trusted) == 0 {
+		return nil
+	}
+
+	return &tierStats{
+		stats:    TierStats{Disagreements: make(map[string]int)},
+		answered: make(map[string]string),
+		pending:  make(map[string]string),
+	}
+}
+
+// query counts a query sent by the DNS task of the tier.
+func (ts *tierStats) query(trusted bool) {
+	if ts == nil {
+		return
+	}
+
+	ts.Lock()
+	defer ts.Unlock()
+
+	if trusted {
+		ts.stats.TrustedQueries++
+	} else {
+		ts.stats.UntrustedQueries++
+	}
+}
+
+// answer records the untrusted resolver providing records for the name. It observes the responses
+// of the pipelined transport, which is the only pool telling which resolver sent the response.
+func (ts *tierStats) answer(resp *dns.Msg, server string) {
+	if ts == nil || resp == nil || len(resp.Question) == 0 ||
+		resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return
+	}
+
+	name := strings.ToLower(resolve.RemoveLastDot(resp.Question[0].Name))
+	ts.Lock()
+	ts.answered[name] = server
+	ts.Unlock()
+}
+
+// screened is called once the untrusted resolvers are done with the name, and holds the resolver
+// of the positive answer sent for confirmation.
+func (ts *tierStats) screened(name string, sent bool) {
+	if ts == nil {
+		return
+	}
+
+	name = strings.ToLower(name)
+	ts.Lock()
+	defer ts.Unlock()
+
+	server := ts.answered[name]
+	delete(ts.answered, name)
+	if sent {
+		ts.stats.Screened++
+		ts.pending[name] = server
+	}
+}
+
+// confirmed is called once the trusted resolvers are done with the name, and returns the untrusted
+// resolver whose answer they refuted. Wildcard matches count as confirmed, since the name did resolve.
+func (ts *tierStats) confirmed(name string, d Disposition, stored bool) (string, bool) {
+	if ts == nil {
+		return "", false
+	}
+
+	name = strings.ToLower(name)
+	ts.Lock()
+	defer ts.Unlock()
+
+	server, found := ts.pending[name]
+	if !found {
+		return "", false
+	}
+	delete(ts.pending, name)
+
+	switch {
+	case stored || d == DispositionWildcard:
+		ts.stats.Confirmed++
+	case d == DispositionNXDomain || d == DispositionNoRecords:
+		ts.stats.Refuted++
+		if server == "" {
+			ts.stats.Unattributed++
+		} else {
+			ts.stats.Disagreements[server]++
+		}
+		return server, true
+	default:
+		ts.stats.Unverified++
+	}
+	return "", false
+}
+
+func (ts *tierStats) snapshot() *TierStats {
+	ts.Lock()
+	defer ts.Unlock()
+
+	stats := ts.stats
+	stats.Disagreements = make(map[string]int, len(ts.stats.Disagreements))
+	for server, n := range ts.stats.Disagreements {
+		stats.Disagreements[server] = n
+	}
+	return &stats
+}
+
+// report logs the outcome of the confirmations, the queries they cost and the untrusted resolvers refuted most.
+func (ts *tierStats) report(l *log.Logger) {
+	if ts == nil || l == nil {
+		return
+	}
+
+	stats := ts.snapshot()
+	var cost float64
+	if stats.UntrustedQueries > 0 {
+		cost = 100 * float64(stats.TrustedQueries) / float64(stats.UntrustedQueries)
+	}
+	l.Printf("The trusted resolvers confirmed %d of the %d names answered by the untrusted resolvers, refuted %d and left %d unverified, "+
+		"with %d queries adding %.1f%% to the %d untrusted queries", stats.Confirmed, stats.Screened, stats.Refuted,
+		stats.Unverified, stats.TrustedQueries, cost, stats.UntrustedQueries)
+
+	servers := make([]string, 0, len(stats.Disagreements))
+	for server := range stats.Disagreements {
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if a, b := stats.Disagreements[servers[i]], stats.Disagreements[servers[j]]; a != b {
+			return a > b
+		}
+		return servers[i] < servers[j]
+	})
+	for _, server := range servers {
+		l.Printf("The trusted resolvers refuted %d answers of the untrusted resolver %s", stats.Disagreements[server], server)
+	}
+	if stats.Unattributed > 0 {
+		l.Printf("The trusted resolvers refuted %d answers of unknown untrusted resolvers, which are only known with the pipelined transport",
+			stats.Unattributed)
+	}
+}
+
+// confirm records the outcome of the trusted resolution of the name. The answer the trusted resolvers
+// refuted is logged, and lowers the reputation of the untrusted resolver.
+func (e *Enumeration) confirm(name string, d Disposition, stored bool) {
+	server, refuted := e.tiers.confirmed(name, d, stored)
+	if !refuted {
+		return
+	}
+
+	if server == "" {
+		server = "an unknown untrusted resolver"
+	} else if obs, ok := e.Sys.(systems.DisagreementObserver); ok {
+		obs.ObserveDisagreement(server)
+	}
+	e.Config.Log.Printf("The answer of %s for %s was refuted by the trusted resolvers (%s)", server, name, d)
+}
+
+// ResolverTiers returns the confirmation of the untrusted answers by the trusted resolvers, or nil when
+// no trusted resolvers have been configured.
+func (e *Enumeration) ResolverTiers() *TierStats {
+	if e.tiers == nil {
+		return nil
+	}
+	return e.tiers.snapshot()
+}
@@ -0,0 +1,122 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// observingSystem records the disagreements and the source mismatches reported to the System.
+type observingSystem struct {
+	systems.System
+	refuted    []string
+	mismatched []string
+}
+
+func (o *observingSystem) ObserveDisagreement(addr string) {
+	o.refuted = append(o.refuted, addr)
+}
+
+func (o *observingSystem) ObserveSourceMismatch(addr string) {
+	o.mismatched = append(o.mismatched, addr)
+}
+
+func positiveAnswer(name string) *dns.Msg {
+	resp := resolve.QueryMsg(name, dns.TypeA)
+	resp.Rcode = dns.RcodeSuccess
+	rr, _ := dns.NewRR(dns.Fqdn(name) + " 300 IN A 192.0.2.1")
+	resp.Answer = append(resp.Answer, rr)
+	return resp
+}
+
+func TestTiersFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if ts := tiersFromConfig(cfg); ts != nil {
+		t.Error("the answers were confirmed without trusted resolvers")
+	}
+
+	cfg.Options = map[string]interface{}{"trusted_resolvers": []interface{}{"192.0.2.53"}}
+	if ts := tiersFromConfig(cfg); ts == nil {
+		t.Error("the answers were not confirmed with the trusted resolvers of the option")
+	}
+
+	cfg = config.NewConfig()
+	cfg.AddTrustedResolvers("192.0.2.53")
+	if ts := tiersFromConfig(cfg); ts == nil {
+		t.Error("the answers were not confirmed with the trusted resolvers of the command line")
+	}
+}
+
+func TestTierConfirmation(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"trusted_resolvers": []interface{}{"192.0.2.53"}}
+	var logs strings.Builder
+	cfg.Log.SetOutput(&logs)
+
+	sys := &observingSystem{}
+	e := &Enumeration{Config: cfg, Sys: sys, tiers: tiersFromConfig(cfg)}
+
+	// The pipelined transport tells which untrusted resolver answered
+	e.tiers.answer(positiveAnswer("www.owasp.org"), "192.0.2.1:53")
+	e.tiers.answer(positiveAnswer("poisoned.owasp.org"), "192.0.2.2:53")
+	for _, name := range []string{"www.owasp.org", "poisoned.owasp.org", "gone.owasp.org", "slow.owasp.org", "wild.owasp.org"} {
+		e.tiers.query(false)
+		e.tiers.screened(name, true)
+	}
+	e.tiers.screened("missing.owasp.org", false)
+	for i := 0; i < 6; i++ {
+		e.tiers.query(true)
+	}
+
+	e.confirm("www.owasp.org", "", true)
+	e.confirm("poisoned.owasp.org", DispositionNXDomain, false)
+	e.confirm("gone.owasp.org", DispositionNoRecords, false)
+	e.confirm("slow.owasp.org", DispositionTimeout, false)
+	e.confirm("wild.owasp.org", DispositionWildcard, false)
+	// The names that were not screened by the untrusted resolvers are not counted
+	e.confirm("owasp.org", DispositionNXDomain, false)
+
+	stats := e.ResolverTiers()
+	expected := &TierStats{
+		UntrustedQueries: 5,
+		TrustedQueries:   6,
+		Screened:         5,
+		Confirmed:        2,
+		Refuted:          2,
+		Unverified:       1,
+		Disagreements:    map[string]int{"192.0.2.2:53": 1},
+		Unattributed:     1,
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("the statistics were %+v, expected %+v", stats, expected)
+	}
+	if !reflect.DeepEqual(sys.refuted, []string{"192.0.2.2:53"}) {
+		t.Errorf("the disagreements observed by the System were %v", sys.refuted)
+	}
+	if len(e.tiers.answered) != 0 || len(e.tiers.pending) != 0 {
+		t.Errorf("the tracker kept %d answers and %d pending names", len(e.tiers.answered), len(e.tiers.pending))
+	}
+
+	e.tiers.report(cfg.Log)
+	for _, msg := range []string{"poisoned.owasp.org was refuted", "refuted 1 answers of the untrusted resolver 192.0.2.2:53", "adding 120.0%"} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Errorf("the logs did not contain %q:\n%s", msg, logs.String())
+		}
+	}
+
+	// Without trusted resolvers, the resolution is single-tier
+	e = &Enumeration{Config: config.NewConfig(), Sys: sys}
+	e.confirm("poisoned.owasp.org", DispositionNXDomain, false)
+	if e.ResolverTiers() != nil || len(sys.refuted) != 1 {
+		t.Error("the disagreements were tracked without trusted resolvers")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/resolve"
+)
+
+// tracing returns true when the System traces the name. The events with attributes that cost something
+// to build check it first, so the names that are not traced cost a single atomic load.
+func (e *Enumeration) tracing(name string) bool {
+	return e != nil && e.tracer != nil && e.tracer.Tracing(name)
+}
+
+// trace captures the event of the stage for the name when it is traced. The attributes are pairs of keys and values.
+func (e *Enumeration) trace(name, stage, event string, attrs ...string) {
+	if !e.tracing(name) {
+		return
+	}
+
+	rec := systems.TraceRecord{Name: name, Stage: stage, Event: event}
+	if e.clock != nil {
+		rec.Time = e.clock.Now()
+	}
+	if len(attrs) > 1 {
+		rec.Attrs = make(map[string]string, len(attrs)/2)
+		for i := 0; i+1 < len(attrs); i += 2 {
+			if attrs[i+1] != "" {
+				rec.Attrs[attrs[i]] = attrs[i+1]
+			}
+		}
+	}
+	e.tracer.AddTrace(rec)
+}
+
+// traceQuery captures the query sent for the name by the resolvers of the tier.
+func (e *Enumeration) traceQuery(name string, qtype uint16, tier string, attempt int) {
+	if !e.tracing(name) {
+		return
+	}
+	e.trace(name, systems.TraceQuery, "sent", "type", dns.TypeToString[qtype],
+		"resolvers", tier, "attempt", strconv.Itoa(attempt))
+}
+
+// traceAnswer captures the response received for the name from the resolvers of the tier.
+func (e *Enumeration) traceAnswer(resp *dns.Msg, tier string) {
+	if resp == nil || len(resp.Question) == 0 {
+		return
+	}
+
+	name := strings.ToLower(resolve.RemoveLastDot(resp.Question[0].Name))
+	if !e.tracing(name) {
+		return
+	}
+	e.trace(name, systems.TraceAnswer, "received", "type", dns.TypeToString[resp.Question[0].Qtype],
+		"resolvers", tier, "rcode", rcodeString(resp.Rcode), "answers", strconv.Itoa(len(resp.Answer)))
+}
+
+// traceResolver captures the resolver that answered the query for the name, which only the pipelined transport tells.
+func (e *Enumeration) traceResolver(resp *dns.Msg, server string) {
+	if resp == nil || len(resp.Question) == 0 {
+		return
+	}
+
+	name := strings.ToLower(resolve.RemoveLastDot(resp.Question[0].Name))
+	if !e.tracing(name) {
+		return
+	}
+	e.trace(name, systems.TraceAnswer, "answered by the resolver", "resolver", server,
+		"type", dns.TypeToString[resp.Question[0].Qtype], "rcode", rcodeString(resp.Rcode))
+}
+
+// Traces returns the events captured for the name by the System, or nil when the System does not trace names.
+func (e *Enumeration) Traces(name string) []systems.TraceRecord {
+	if e.tracer == nil {
+		return nil
+	}
+	return e.tracer.Traces(name)
+}
@@ -0,0 +1,97 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/resolve"
+)
+
+type tracingSystem struct {
+	systems.System
+	traces *systems.NameTraces
+}
+
+func (s *tracingSystem) TraceName(pattern string) { s.traces.Add(pattern) }
+
+func (s *tracingSystem) UntraceName(pattern string) { s.traces.Remove(pattern) }
+
+func (s *tracingSystem) Tracing(name string) bool { return s.traces.Match(name) }
+
+func (s *tracingSystem) AddTrace(rec systems.TraceRecord) { s.traces.Record(rec) }
+
+func (s *tracingSystem) Traces(name string) []systems.TraceRecord { return s.traces.Records(name) }
+
+func TestTraceName(t *testing.T) {
+	e := policyEnumeration(t)
+	sys := &tracingSystem{traces: systems.NewNameTraces(100)}
+	sys.TraceName("*.dev.owasp.org")
+	e.Sys = sys
+	e.tracer = sys
+
+	e.nameSrc.newName(&requests.DNSRequest{Name: "api.dev.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute})
+	e.nameSrc.newName(&requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute})
+	e.nameSrc.newName(&requests.DNSRequest{Name: "api.dev.owasp.org", Domain: "owasp.org", Derivation: requests.DerivedFromBrute})
+
+	msg := resolve.QueryMsg("api.dev.owasp.org", dns.TypeA)
+	msg.Rcode = dns.RcodeNameError
+	e.traceQuery("api.dev.owasp.org", dns.TypeA, "trusted", 1)
+	e.traceAnswer(msg, "trusted")
+
+	if recs := e.Traces("www.owasp.org"); len(recs) != 0 {
+		t.Errorf("the name that is not traced has %d records", len(recs))
+	}
+
+	var events []string
+	for _, rec := range e.Traces("api.dev.owasp.org") {
+		events = append(events, rec.Stage+": "+rec.Event)
+	}
+	want := []string{
+		"scope: submitted",
+		"scope: queued for resolution",
+		"scope: submitted",
+		"scope: disposed",
+		"query: sent",
+		"answer: received",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("the trace holds the events %v", events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("the event %d was %q instead of %q", i, events[i], want[i])
+		}
+	}
+
+	recs := e.Traces("api.dev.owasp.org")
+	if d := recs[3].Attrs["disposition"]; d != string(DispositionDeduped) {
+		t.Errorf("the repeated name was disposed as %q", d)
+	}
+	if rcode := recs[5].Attrs["rcode"]; rcode != "NXDOMAIN" {
+		t.Errorf("the answer was traced with the rcode %q", rcode)
+	}
+}
+
+func TestTraceNameCost(t *testing.T) {
+	e := policyEnumeration(t)
+	sys := &tracingSystem{traces: systems.NewNameTraces(100)}
+	e.tracer = sys
+
+	// The names that are not traced cost nothing beyond the check, even while other names are traced
+	for _, pattern := range []string{"", "api.owasp.org"} {
+		if pattern != "" {
+			sys.TraceName(pattern)
+		}
+		if n := testing.AllocsPerRun(100, func() {
+			e.trace("www.owasp.org", systems.TraceScope, "submitted", "source", "crtsh", "domain", "owasp.org")
+		}); n != 0 {
+			t.Errorf("the trace of the name that is not traced allocated %.0f times", n)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/authoritative"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/amass/v4/transport"
+)
+
+// pipelinedPool returns the transport selected by the 'dns.pipelined' option, which sends the untrusted
+// queries to the resolvers of the System in place of its pool, or nil when it is not enabled. The pool set
+// by the caller, such as the remote workers, is kept, and the pool of the System is used when the sockets
+// of the transport cannot be opened.
+func (e *Enumeration) pipelinedPool() *transport.Pool {
+	opts := transport.OptionsFromConfig(e.Config)
+	if opts == nil || e.Resolvers != nil {
+		return nil
+	}
+
+	sock, err := systems.SocketOptionsFromConfig(e.Config)
+	if err != nil {
+		e.Config.Log.Printf("Failed to open the pipelined DNS transport: %v", err)
+		return nil
+	}
+	opts.Socket = sock
+	// The transport tells which untrusted resolver provided the answers refuted by the trusted resolvers
+	opts.Observe = func(resp *dns.Msg, server string) {
+		if e.tiers != nil {
+			e.tiers.answer(resp, server)
+		}
+		// The traces of the names tell which resolver answered them
+		e.traceResolver(resp, server)
+	}
+	opts.Bytes = e.meter.Func(bandwidth.DNS, componentResolvers)
+	// The responses from unexpected addresses count against the resolvers, or flag the names when accepted
+	e.sources = newSourceMismatches()
+	opts.SourceMismatch = e.observeSourceMismatch
+
+	pool, err := transport.New(e.Config.Resolvers, opts)
+	if err != nil {
+		e.Config.Log.Printf("Failed to open the pipelined DNS transport: %v", err)
+		return nil
+	}
+	return pool
+}
+
+// authoritativeZones returns the cache of the zone cuts selected by the 'authoritative' section, which
+// directs the queries of both DNS tasks to the authoritative servers, or nil when the mode is not enabled.
+// The nameservers on the never-touch list are left out, so their zones are resolved recursively.
+func (e *Enumeration) authoritativeZones() *authoritative.Zones {
+	opts := authoritative.OptionsFromConfig(e.Config)
+	if opts == nil {
+		return nil
+	}
+
+	sock, err := systems.SocketOptionsFromConfig(e.Config)
+	if err != nil {
+		e.Config.Log.Printf("Failed to query the authoritative servers: %v", err)
+		return nil
+	}
+	opts.Socket = sock
+	opts.Allow = func(addr string) bool {
+		return !e.Policy.BlocksAddress(policy.DNS, addr)
+	}
+	opts.Bytes = e.meter.Func(bandwidth.DNS, componentAuthoritative)
+	return authoritative.NewZones(e.trustedPool(), opts)
+}
+
+// reportAuthoritative logs the queries answered by the authoritative servers and those sent to the recursive resolvers.
+func (e *Enumeration) reportAuthoritative() {
+	if e.zones == nil {
+		return
+	}
+
+	stats := e.zones.Stats()
+	e.Config.Log.Printf("Sent %d queries to the %d authoritative servers of %d zone cuts, %d answers carried the AA bit, "+
+		"%d referrals were followed and %d queries fell back to the recursive resolvers",
+		stats.Direct, stats.Servers, stats.Zones, stats.Authoritative, stats.Referrals, stats.Fallbacks)
+}
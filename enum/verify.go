@@ -0,0 +1,211 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/journal"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// verifyWorkers is the number of names verified at once.
+const verifyWorkers = 10
+
+func init() {
+	systems.RegisterNameVerifier(verifyNames)
+}
+
+// verifyNames resolves each name within the scope of the configuration with the trusted resolvers, for the
+// configured record types, and writes the records into a new event. Neither the data sources nor the brute
+// forcing and name alterations are used, so only the names provided are resolved.
+func verifyNames(ctx context.Context, sys systems.System, cfg *config.Config, names []string) (*systems.Verification, error) {
+	graphs := sys.GraphDatabases()
+	if len(graphs) == 0 || graphs[0] == nil {
+		return nil, errors.New("the system has no graph database")
+	}
+	if err := cfg.CheckSettings(); err != nil {
+		return nil, err
+	}
+
+	e := NewEnumeration(cfg, sys, graphs[0])
+	if err := e.Policy.Err(); err != nil {
+		return nil, err
+	}
+	if e.blacklistErr != nil {
+		return nil, e.blacklistErr
+	}
+	if e.optionsErr != nil {
+		return nil, e.optionsErr
+	}
+	defer systems.AcquireGraphWriter(e.graph)()
+	// The event records that no data source was queried
+	e.srcs = nil
+	if dir := config.OutputDirectory(cfg.Dir); dir != "" {
+		if snaps, err := snapshot.Open(filepath.Join(dir, snapshot.DirName)); err == nil {
+			e.Snapshots = snaps
+		}
+	}
+	e.saveSnapshot()
+	e.ctx = bandwidth.WithMeter(ctx, e.meter)
+
+	v := &systems.Verification{
+		Event: snapshot.ID(cfg.Domains(), cfg.CollectionStartTime),
+		Start: cfg.CollectionStartTime,
+	}
+	if e.snapshot != nil {
+		v.Event = e.snapshot.ID
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		name = strings.Trim(strings.ToLower(strings.TrimSpace(name)), ".")
+		if _, found := seen[name]; found || name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+		v.Results = append(v.Results, systems.VerifyResult{Name: name})
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, verifyWorkers)
+	for i := range v.Results {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(res *systems.VerifyResult) {
+			defer func() { <-sem; wg.Done() }()
+			e.verifyName(e.ctx, res)
+		}(&v.Results[i])
+	}
+	wg.Wait()
+	return v, ctx.Err()
+}
+
+// verifyName checks the scope of the name, resolves the configured record types and stores the records,
+// unless the answers matched the wildcard of the domain.
+func (e *Enumeration) verifyName(ctx context.Context, res *systems.VerifyResult) {
+	if err := amassdns.ValidateName(res.Name, true); err != nil {
+		res.Error = err.Error()
+		return
+	}
+
+	res.Domain = e.Config.WhichDomain(res.Name)
+	res.InScope = res.Domain != "" && !e.blacklisted(res.Name)
+	if !res.InScope {
+		res.Domain = ""
+		return
+	}
+
+	req := &requests.DNSRequest{Name: res.Name, Domain: res.Domain}
+	for _, qtype := range e.qtypes.resolved {
+		resp, err := e.dnsQuery(ctx, res.Name, qtype, e.trustedPool(), maxDNSQueryAttempts)
+		if errors.Is(err, policy.ErrBlocked) {
+			res.Error = err.Error()
+			return
+		}
+		if ctx.Err() != nil {
+			res.Error = ctx.Err().Error()
+			return
+		}
+		if err != nil || resp == nil {
+			continue
+		}
+
+		rr := resolve.AnswersByType(extractAnswers(resp), qtype)
+		if len(rr) == 0 {
+			continue
+		}
+		if len(req.Records) == 0 && e.wildcardDetected(ctx, req, resp) {
+			res.Wildcard = true
+			break
+		}
+
+		req.Records = append(req.Records, answerTTLs(convertAnswers(rr), resp)...)
+		// An alias makes the other records belong to the target
+		if qtype == dns.TypeCNAME {
+			break
+		}
+	}
+
+	res.Exists = res.Wildcard || len(req.Records) > 0
+	if res.Wildcard {
+		return
+	}
+	res.Records = req.Records
+	if err := e.storeVerified(ctx, req); err != nil {
+		res.Error = err.Error()
+	}
+}
+
+// storeVerified writes the records of the verified name, which places them in the event of the verification.
+func (e *Enumeration) storeVerified(ctx context.Context, req *requests.DNSRequest) error {
+	var err error
+
+	for _, rec := range req.Records {
+		name := strings.Trim(strings.ToLower(rec.Name), ".")
+		data := strings.Trim(strings.ToLower(rec.Data), ".")
+		qtype := uint16(rec.Type)
+		if data == "" || !e.storesRecord(ctx, name, qtype) {
+			continue
+		}
+
+		var op string
+		var werr error
+		switch qtype {
+		case dns.TypeCNAME:
+			op, werr = journal.OpCNAME, e.graph.UpsertCNAME(ctx, name, data)
+		case dns.TypeA, dns.TypeAAAA:
+			if amassdns.ValidateName(name, false) != nil {
+				continue
+			}
+			if qtype == dns.TypeA {
+				op, werr = journal.OpA, e.graph.UpsertA(ctx, name, data)
+			} else {
+				op, werr = journal.OpAAAA, e.graph.UpsertAAAA(ctx, name, data)
+			}
+			if werr == nil {
+				now := time.Now()
+				e.History.Observe(name, data, history.Period{FirstSeen: now, LastSeen: now})
+			}
+		case dns.TypeNS:
+			op, werr = journal.OpNS, e.graph.UpsertNS(ctx, name, data)
+		case dns.TypeMX:
+			op, werr = journal.OpMX, e.graph.UpsertMX(ctx, name, data)
+		case dns.TypeSRV:
+			op, werr = journal.OpSRV, e.graph.UpsertSRV(ctx, name, data)
+		default:
+			continue
+		}
+		if werr != nil {
+			e.journalWrite(journal.Record{Op: op, Name: name, Target: data}, werr)
+			if err == nil {
+				err = fmt.Errorf("failed to insert the %s record of %s: %v", dns.TypeToString[qtype], name, werr)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/resolve"
+)
+
+func TestVerifyThroughSystem(t *testing.T) {
+	sc := newBenchScenario(benchSeed, 100)
+	addr, stop := startFakeResolver(t, sc)
+	defer stop()
+
+	cfg := config.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.TrustedQPS = benchQPS
+	cfg.AddDomain(sc.domain)
+	cfg.BlacklistSubdomain("blocked." + sc.domain)
+	trusted := resolve.NewResolvers()
+	_ = trusted.AddResolvers(benchQPS, addr)
+	trusted.SetDetectionResolver(benchQPS, addr)
+	defer trusted.Stop()
+
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	sys := &systems.SimpleSystem{
+		Cfg:      cfg,
+		Trusted:  trusted,
+		Graph:    g,
+		ASNCache: requests.NewASNCache(),
+	}
+	// The data source is never queried by the verification
+	src := newBenchSource(sc.candidates)
+	if err := sys.AddAndStart(src); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = src.Stop() }()
+
+	var exists string
+	for name := range sc.answers {
+		exists = name
+		break
+	}
+	wild := "verify-wildcard." + sc.wildcard
+	missing := "missing-name." + sc.domain
+	names := []string{exists, " " + exists + ". ", wild, missing, "www.example.com", "blocked." + sc.domain, "bad_name!." + sc.domain}
+
+	v, err := sys.Verify(context.Background(), names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Results) != len(names)-1 {
+		t.Fatalf("%d results were returned for %d distinct names", len(v.Results), len(names)-1)
+	}
+
+	results := make(map[string]systems.VerifyResult)
+	for _, res := range v.Results {
+		results[res.Name] = res
+	}
+	if res := results[exists]; !res.InScope || !res.Exists || res.Wildcard || res.Domain != sc.domain ||
+		len(res.Records) != 1 || res.Records[0].Type != int(dns.TypeA) || res.Records[0].Data != sc.answers[exists] {
+		t.Errorf("the existing name resulted in %+v", res)
+	}
+	if res := results[wild]; !res.InScope || !res.Wildcard || len(res.Records) != 0 {
+		t.Errorf("the wildcard name resulted in %+v", res)
+	}
+	if res := results[missing]; !res.InScope || res.Exists || res.Error != "" {
+		t.Errorf("the missing name resulted in %+v", res)
+	}
+	for _, name := range []string{"www.example.com", "blocked." + sc.domain} {
+		if res := results[name]; res.InScope || res.Exists || res.Domain != "" {
+			t.Errorf("the name outside of the scope resulted in %+v", res)
+		}
+	}
+	if res := results["bad_name!."+sc.domain]; res.Error == "" || res.Exists {
+		t.Errorf("the invalid name resulted in %+v", res)
+	}
+	if found := v.Found(); len(found) != 1 || found[0].Name != exists {
+		t.Errorf("the names found were %+v", found)
+	}
+
+	// The records are written into the event of the verification
+	since := v.Start.Add(-time.Second).UTC()
+	for name, expected := range map[string]bool{exists: true, wild: false, missing: false} {
+		if assets, err := g.DB.FindByContent(domain.FQDN{Name: name}, since); err != nil || (len(assets) > 0) != expected {
+			t.Errorf("the graph held %d assets for %s: %v", len(assets), name, err)
+		}
+	}
+
+	store, err := snapshot.Open(filepath.Join(cfg.Dir, snapshot.DirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := store.Load(v.Event)
+	if err != nil {
+		t.Fatalf("the event %s was not recorded: %v", v.Event, err)
+	}
+	if !snap.Start.Equal(v.Start) {
+		t.Errorf("the event started at %v, expected %v", snap.Start, v.Start)
+	}
+}
+
+func TestVerifyCancelled(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.AddDomain("owasp.org")
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sys := &systems.SimpleSystem{Cfg: cfg, Trusted: resolve.NewResolvers(), Graph: g}
+	src := newBenchSource(nil)
+	if err := sys.AddAndStart(src); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = src.Stop() }()
+	v, err := sys.Verify(ctx, []string{"www.owasp.org"})
+	if err == nil {
+		t.Error("the cancelled verification returned no error")
+	}
+	if v == nil || len(v.Results) != 1 || v.Results[0].Exists {
+		t.Errorf("the cancelled verification returned %+v", v)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/config/config"
+)
+
+// VirtualHostsFile is the name of the file under the output directory holding the virtual hosts found on the addresses.
+const VirtualHostsFile = "vhosts.json"
+
+const (
+	// DefaultVHostPort is the port the addresses are asked for the virtual hosts on
+	DefaultVHostPort = 443
+	// DefaultVHostsPerAddress is the number of server names tried on each address
+	DefaultVHostsPerAddress = 10
+	// DefaultVHostBudget is the number of TLS handshakes made by the discovery across all the addresses
+	DefaultVHostBudget = 1000
+	// DefaultVHostWorkers is the number of addresses probed at once
+	DefaultVHostWorkers = 10
+)
+
+// VirtualHost is a name served by an address the name does not resolve to during the enumeration,
+// found by presenting the name to the address in the TLS handshake and the Host header.
+type VirtualHost struct {
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Netblock string `json:"netblock"`
+	// ValidCert is true when the certificate presented for the name covers it
+	ValidCert bool `json:"valid_cert"`
+	// DistinctContent is true when the content served for the name differs from the content served without it
+	DistinctContent bool `json:"distinct_content"`
+	StatusCode      int  `json:"status_code,omitempty"`
+	// Orphaned is true when the name resolves to no address at all during the enumeration
+	Orphaned bool `json:"orphaned"`
+}
+
+// vhostProber asks the addresses found by an active enumeration for the other names in scope that were associated
+// with their netblocks. The combinations grow quadratically, so the names tried on each address and the handshakes
+// made overall are capped.
+type vhostProber struct {
+	sync.Mutex
+	port    int
+	perAddr int
+	budget  int64
+	workers int
+	spent   int64
+	probe   func(ctx context.Context, addr string, port int, serverName string) (*http.VirtualHostResponse, error)
+	found   []VirtualHost
+}
+
+// vhostProberFromConfig parses the 'vhost_discovery' configuration options, and returns nil unless the
+// discovery is enabled in an active enumeration.
+func vhostProberFromConfig(cfg *config.Config) *vhostProber {
+	if cfg == nil || !cfg.Active {
+		return nil
+	}
+
+	opts, ok := cfg.Options["vhost_discovery"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, ok := opts["enabled"].(bool); !ok || !enabled {
+		return nil
+	}
+
+	vp := &vhostProber{
+		port:    DefaultVHostPort,
+		perAddr: DefaultVHostsPerAddress,
+		budget:  DefaultVHostBudget,
+		workers: DefaultVHostWorkers,
+		probe:   http.RequestVirtualHost,
+	}
+	if n := options.Int(opts["port"]); n > 0 && n <= 65535 {
+		vp.port = n
+	}
+	if n := options.Int(opts["max_per_address"]); n > 0 {
+		vp.perAddr = n
+	}
+	if n := options.Int(opts["budget"]); n > 0 {
+		vp.budget = int64(n)
+	}
+	if n := options.Int(opts["workers"]); n > 0 {
+		vp.workers = n
+	}
+	return vp
+}
+
+// take spends a handshake of the budget, and returns false once the budget is exhausted.
+func (vp *vhostProber) take() bool {
+	return atomic.AddInt64(&vp.spent, 1) <= vp.budget
+}
+
+func (vp *vhostProber) add(vh VirtualHost) {
+	vp.Lock()
+	defer vp.Unlock()
+
+	vp.found = append(vp.found, vh)
+}
+
+// VirtualHosts returns the names served by the addresses they do not resolve to, ordered by the address and the name.
+func (e *Enumeration) VirtualHosts() []VirtualHost {
+	vp := e.vhosts
+	if vp == nil {
+		return nil
+	}
+
+	vp.Lock()
+	defer vp.Unlock()
+
+	found := append([]VirtualHost(nil), vp.found...)
+	sort.Slice(found, func(i, j int) bool {
+		if c := bytes.Compare(net.ParseIP(found[i].Address).To16(), net.ParseIP(found[j].Address).To16()); c != 0 {
+			return c < 0
+		}
+		return found[i].Name < found[j].Name
+	})
+	return found
+}
+
+// vhostTargets holds the addresses found by the enumeration, along with the names tried on each of them.
+type vhostTargets struct {
+	addrs      []string
+	candidates map[string][]string
+	netblocks  map[string]string
+	// resolving holds the names that resolve to an address during the enumeration
+	resolving map[string]struct{}
+}
+
+// vhostTargets selects the names tried on each address found by the enumeration, which are the names in scope
+// that resolved to an address of the same netblock at any time, besides those resolving to the address now.
+// The names that resolved to the address itself in the past are tried first.
+func (e *Enumeration) vhostTargets(ctx context.Context) *vhostTargets {
+	var names []string
+	for it := cursor.SortedNamesIterator(ctx, e.graph, time.Time{}, e.Config.Domains()...); it.Next(); {
+		if name := it.Name(); e.Config.WhichDomain(name) != "" && !e.blacklisted(name) {
+			names = append(names, name)
+		}
+	}
+
+	t := &vhostTargets{
+		candidates: make(map[string][]string),
+		netblocks:  make(map[string]string),
+		resolving:  make(map[string]struct{}),
+	}
+	if len(names) == 0 {
+		return t
+	}
+
+	current := make(map[string]map[string]struct{})
+	start := e.Config.CollectionStartTime
+	if pairs, err := history.NamesToAddrs(ctx, e.graph, e.History, history.Current, start, start, names...); err == nil {
+		for _, p := range pairs {
+			if p.FQDN == nil || p.Addr == nil || !p.Addr.Address.IsValid() {
+				continue
+			}
+
+			addr, name := p.Addr.Address.String(), strings.ToLower(p.FQDN.Name)
+			if _, found := current[addr]; !found {
+				current[addr] = make(map[string]struct{})
+				t.addrs = append(t.addrs, addr)
+			}
+			current[addr][name] = struct{}{}
+			t.resolving[name] = struct{}{}
+		}
+	}
+	sort.Slice(t.addrs, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(t.addrs[i]).To16(), net.ParseIP(t.addrs[j]).To16()) < 0
+	})
+
+	// The names associated with each netblock, and with each address, at any time
+	blocks := make(map[string]map[string]struct{})
+	past := make(map[string]map[string]struct{})
+	if pairs, err := e.graph.NamesToAddrs(ctx, time.Time{}, names...); err == nil {
+		for _, p := range pairs {
+			if p.FQDN == nil || p.Addr == nil || !p.Addr.Address.IsValid() {
+				continue
+			}
+
+			addr, name := p.Addr.Address.String(), strings.ToLower(p.FQDN.Name)
+			block := e.vhostNetblock(addr)
+			if _, found := blocks[block]; !found {
+				blocks[block] = make(map[string]struct{})
+			}
+			blocks[block][name] = struct{}{}
+			if _, found := past[addr]; !found {
+				past[addr] = make(map[string]struct{})
+			}
+			past[addr][name] = struct{}{}
+		}
+	}
+
+	for _, addr := range t.addrs {
+		block := e.vhostNetblock(addr)
+		t.netblocks[addr] = block
+
+		var list []string
+		for name := range blocks[block] {
+			if _, found := current[addr][name]; !found {
+				list = append(list, name)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			_, pi := past[addr][list[i]]
+			_, pj := past[addr][list[j]]
+			if pi != pj {
+				return pi
+			}
+			return list[i] < list[j]
+		})
+		t.candidates[addr] = list
+	}
+	return t
+}
+
+// vhostNetblock returns the netblock announcing the address, or the /24 or /64 holding it when the netblock is unknown.
+func (e *Enumeration) vhostNetblock(addr string) string {
+	if e.Sys != nil {
+		if r := e.Sys.Cache().AddrSearch(addr); r != nil && r.Prefix != "" {
+			return r.Prefix
+		}
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// discoverVirtualHosts asks each address found by the enumeration for the names selected for it, and records the
+// names the address serves with a certificate covering them or with content of their own.
+func (e *Enumeration) discoverVirtualHosts(ctx context.Context) {
+	vp := e.vhosts
+	if vp == nil {
+		return
+	}
+
+	t := e.vhostTargets(ctx)
+	ch := make(chan string, vp.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < vp.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for addr := range ch {
+				e.probeVirtualHosts(ctx, addr, t)
+			}
+		}()
+	}
+
+loop:
+	for _, addr := range t.addrs {
+		if len(t.candidates[addr]) == 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			break loop
+		case ch <- addr:
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if spent := atomic.LoadInt64(&vp.spent); spent > vp.budget {
+		e.Config.Log.Printf("The virtual host discovery exhausted its budget of %d handshakes", vp.budget)
+	}
+	if n := len(e.VirtualHosts()); n > 0 {
+		e.Config.Log.Printf("Found %d virtual hosts served by addresses their names do not resolve to", n)
+	}
+}
+
+// probeVirtualHosts compares the answers of the address for the names with its answers for no name. The content
+// of the address is only compared when it serves the same content twice, since dynamic pages always differ.
+func (e *Enumeration) probeVirtualHosts(ctx context.Context, addr string, t *vhostTargets) {
+	vp := e.vhosts
+	if e.Policy.BlocksAddress(policy.Port, addr) || !vp.take() {
+		return
+	}
+
+	base, err := vp.probe(ctx, addr, vp.port, "")
+	if err != nil || base == nil {
+		// The port is closed
+		return
+	}
+
+	stable := false
+	if base.StatusCode != 0 && vp.take() {
+		if again, err := vp.probe(ctx, addr, vp.port, ""); err == nil && again != nil {
+			stable = again.StatusCode == base.StatusCode && again.Digest == base.Digest
+		}
+	}
+
+	candidates := t.candidates[addr]
+	if len(candidates) > vp.perAddr {
+		candidates = candidates[:vp.perAddr]
+	}
+	for _, name := range candidates {
+		if ctx.Err() != nil {
+			return
+		}
+		if e.Policy.BlocksName(policy.Web, name) {
+			continue
+		}
+		if !vp.take() {
+			return
+		}
+
+		resp, err := vp.probe(ctx, addr, vp.port, name)
+		if err != nil || resp == nil {
+			continue
+		}
+
+		valid := certCovers(resp, name)
+		distinct := stable && resp.StatusCode != 0 &&
+			(resp.StatusCode != base.StatusCode || resp.Digest != base.Digest)
+		// The default certificate covering the name says nothing of the name being served
+		if !distinct && (!valid || certCovers(base, name)) {
+			continue
+		}
+
+		_, resolves := t.resolving[name]
+		vp.add(VirtualHost{
+			Address:         addr,
+			Name:            name,
+			Netblock:        t.netblocks[addr],
+			ValidCert:       valid,
+			DistinctContent: distinct,
+			StatusCode:      resp.StatusCode,
+			Orphaned:        !resolves,
+		})
+	}
+}
+
+func certCovers(resp *http.VirtualHostResponse, name string) bool {
+	return resp != nil && resp.Certificate != nil && resp.Certificate.VerifyHostname(name) == nil
+}
@@ -0,0 +1,188 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/config/config"
+)
+
+// selfSignedCert returns a certificate covering the name.
+func selfSignedCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startVirtualHosts serves www.owasp.org by default, and old.owasp.org with its own certificate and content
+// when asked for it, on the loopback address.
+func startVirtualHosts(t *testing.T) int {
+	certs := map[string]tls.Certificate{
+		"www.owasp.org": selfSignedCert(t, "www.owasp.org"),
+		"old.owasp.org": selfSignedCert(t, "old.owasp.org"),
+	}
+
+	srv := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Host == "old.owasp.org" {
+			_, _ = w.Write([]byte("the old application"))
+			return
+		}
+		_, _ = w.Write([]byte("the default site"))
+	}))
+	srv.TLS = &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if c, found := certs[hello.ServerName]; found {
+				return &c, nil
+			}
+			c := certs["www.owasp.org"]
+			return &c, nil
+		},
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func vhostEnumeration(t *testing.T, opts map[string]interface{}) *Enumeration {
+	ctx := context.Background()
+	g := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	t.Cleanup(func() { g.Remove() })
+
+	store, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.Active = true
+	cfg.CollectionStartTime = time.Now().Add(-time.Minute)
+	cfg.Options = map[string]interface{}{"vhost_discovery": opts}
+	e := &Enumeration{
+		Config:  cfg,
+		History: store.Backend("local"),
+		graph:   g,
+		vhosts:  vhostProberFromConfig(cfg),
+	}
+
+	// The enumeration stores the root domain name before the names within it
+	if _, err := g.UpsertFQDN(ctx, "owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	// The old names resolved to the neighbours of the address in the past
+	now := time.Now()
+	past := history.Period{FirstSeen: now.AddDate(-2, 0, 0), LastSeen: now.AddDate(-1, 0, 0)}
+	for name, p := range map[string]struct {
+		addr   string
+		period history.Period
+	}{
+		"www.owasp.org":  {"127.0.0.1", history.Period{FirstSeen: now, LastSeen: now}},
+		"old.owasp.org":  {"127.0.0.2", past},
+		"gone.owasp.org": {"127.0.0.3", past},
+	} {
+		if err := history.UpsertAddress(ctx, g, e.History, name, p.addr, p.period); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+func TestVHostProberFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"vhost_discovery": map[string]interface{}{"enabled": true}}
+	if vhostProberFromConfig(cfg) != nil {
+		t.Error("the discovery was enabled in a passive enumeration")
+	}
+
+	cfg.Active = true
+	vp := vhostProberFromConfig(cfg)
+	if vp == nil || vp.port != DefaultVHostPort || vp.perAddr != DefaultVHostsPerAddress || vp.budget != DefaultVHostBudget {
+		t.Fatalf("the defaults were not used: %+v", vp)
+	}
+
+	// Enabling the web probes does not enable the discovery
+	cfg.Options = map[string]interface{}{"web_probe": map[string]interface{}{"scripts": true}}
+	if vhostProberFromConfig(cfg) != nil {
+		t.Error("the discovery was enabled without its own option")
+	}
+}
+
+func TestDiscoverVirtualHosts(t *testing.T) {
+	port := startVirtualHosts(t)
+	e := vhostEnumeration(t, map[string]interface{}{"enabled": true, "port": port})
+
+	e.discoverVirtualHosts(context.Background())
+	vhosts := e.VirtualHosts()
+	if len(vhosts) != 1 {
+		t.Fatalf("found the virtual hosts %+v", vhosts)
+	}
+
+	vh := vhosts[0]
+	if vh.Name != "old.owasp.org" || vh.Address != "127.0.0.1" || vh.Netblock != "127.0.0.0/24" {
+		t.Errorf("found the virtual host %+v", vh)
+	}
+	if !vh.ValidCert || !vh.DistinctContent || !vh.Orphaned {
+		t.Errorf("the virtual host %+v was not orphaned with its own certificate and content", vh)
+	}
+	// The baseline twice, along with both names of the netblock
+	if spent := e.vhosts.spent; spent != 4 {
+		t.Errorf("%d handshakes were made", spent)
+	}
+}
+
+func TestDiscoverVirtualHostsCaps(t *testing.T) {
+	port := startVirtualHosts(t)
+
+	// The names are tried in order, so the cap on each address leaves out old.owasp.org
+	e := vhostEnumeration(t, map[string]interface{}{"enabled": true, "port": port, "max_per_address": 1})
+	e.discoverVirtualHosts(context.Background())
+	if vhosts := e.VirtualHosts(); len(vhosts) != 0 {
+		t.Errorf("the cap on the names of each address was exceeded: %+v", vhosts)
+	}
+
+	// The budget is spent on the baseline of the address
+	e = vhostEnumeration(t, map[string]interface{}{"enabled": true, "port": port, "budget": 2})
+	e.discoverVirtualHosts(context.Background())
+	if vhosts := e.VirtualHosts(); len(vhosts) != 0 {
+		t.Errorf("the budget of the handshakes was exceeded: %+v", vhosts)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"html"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	amasshttp "github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/config/config"
+)
+
+// WildcardClustersFile is the name of the file under the output directory listing the names kept within the wildcard zones.
+const WildcardClustersFile = "wildcard_clusters.json"
+
+// The features of the responses compared by the clustering of the wildcard zones.
+const (
+	// ClusterAnswers compares the addresses the names resolve to
+	ClusterAnswers = "answers"
+	// ClusterCNAME compares the CNAME chains of the names
+	ClusterCNAME = "cname"
+	// ClusterHTTPStatus compares the status codes of the web servers, in the active mode
+	ClusterHTTPStatus = "http_status"
+	// ClusterHTTPTitle compares the titles of the landing pages, in the active mode
+	ClusterHTTPTitle = "http_title"
+)
+
+// DefaultClusterThreshold is the share of the compared features that must differ from the wildcard baseline.
+const DefaultClusterThreshold = 0.5
+
+// DefaultClusterProbes is the number of unlikely names queried to learn the wildcard baseline of a zone.
+const DefaultClusterProbes = 5
+
+// clusterHTTPTimeout caps the requests for the landing pages of the names.
+const clusterHTTPTimeout = 10 * time.Second
+
+// clusterFeatures are the features compared by default, in the order they are reported.
+var clusterFeatures = []string{ClusterAnswers, ClusterCNAME, ClusterHTTPStatus, ClusterHTTPTitle}
+
+var titleRE = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// ClusterDifference is a feature of the response to a name that differs from the wildcard baseline of its zone.
+type ClusterDifference struct {
+	Feature string   `json:"feature"`
+	Value   []string `json:"value"`
+	// Baseline holds the values the unlikely names of the zone responded with
+	Baseline []string `json:"baseline"`
+}
+
+// WildcardCluster is a name kept within a wildcard zone, since its response stood apart from the wildcard.
+type WildcardCluster struct {
+	Name        string              `json:"name"`
+	Zone        string              `json:"zone"`
+	Score       float64             `json:"score"`
+	Differences []ClusterDifference `json:"differences"`
+}
+
+// clusterResponse holds the features of the response to a name.
+type clusterResponse struct {
+	values map[string][]string
+}
+
+// clusterBaseline holds the features of the responses to the unlikely names of a zone.
+type clusterBaseline struct {
+	sync.Once
+	values map[string]map[string]struct{}
+}
+
+type clusterQueryFunc func(ctx context.Context, name string) []*dns.Msg
+
+type clusterFetchFunc func(ctx context.Context, name string) (status int, title string, ok bool)
+
+// wildcardClusterer compares the responses to the names of the wildcard zones with those of the unlikely
+// names of the zone, so the names hosted on platforms answering for every name are kept when they stand
+// apart from the wildcard, instead of all of them being discarded.
+type wildcardClusterer struct {
+	sync.Mutex
+	features  []string
+	threshold float64
+	probes    int
+	rng       *rand.Rand
+	query     clusterQueryFunc
+	// fetch is nil unless the web servers are probed
+	fetch     clusterFetchFunc
+	baselines map[string]*clusterBaseline
+	kept      map[string]*WildcardCluster
+}
+
+// wildcardClustersFromConfig parses the 'wildcard_clustering' configuration options. The clustering is only
+// enabled when the section is present, and the HTTP features are only compared in the active mode.
+func wildcardClustersFromConfig(cfg *config.Config, rng *rand.Rand) *wildcardClusterer {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["wildcard_clustering"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, ok := opts["enabled"].(bool); ok && !enabled {
+		return nil
+	}
+
+	wc := &wildcardClusterer{
+		threshold: DefaultClusterThreshold,
+		probes:    DefaultClusterProbes,
+		rng:       rng,
+		baselines: make(map[string]*clusterBaseline),
+		kept:      make(map[string]*WildcardCluster),
+	}
+	if f, ok := floatOption(opts["threshold"]); ok && f > 0 && f <= 1 {
+		wc.threshold = f
+	}
+	if n := options.Int(opts["probes"]); n > 0 {
+		wc.probes = n
+	}
+
+	features := clusterFeatures
+	if list := stringList(opts["features"]); len(list) > 0 {
+		features = nil
+		for _, f := range list {
+			f = strings.ToLower(strings.TrimSpace(f))
+			if isClusterFeature(f) && !containsString(features, f) {
+				features = append(features, f)
+			}
+		}
+	}
+	for _, f := range features {
+		if cfg.Active || (f != ClusterHTTPStatus && f != ClusterHTTPTitle) {
+			wc.features = append(wc.features, f)
+		}
+	}
+	if len(wc.features) == 0 {
+		return nil
+	}
+	if cfg.Active && (containsString(wc.features, ClusterHTTPStatus) || containsString(wc.features, ClusterHTTPTitle)) {
+		wc.fetch = fetchLandingPage
+	}
+	return wc
+}
+
+func isClusterFeature(f string) bool {
+	return containsString(clusterFeatures, f)
+}
+
+// distinct returns true when the responses to the name, found immediately within the wildcard zone, differ
+// from the wildcard baseline of the zone in at least the threshold share of the compared features. The
+// differences of the names kept are recorded as their evidence. The HTTP features are not compared for the
+// names without a web server responding, so a failed request does not make the name stand apart.
+func (wc *wildcardClusterer) distinct(ctx context.Context, name string, resps ...*dns.Msg) bool {
+	if wc == nil {
+		return false
+	}
+
+	name = strings.ToLower(strings.Trim(name, "."))
+	_, zone, found := strings.Cut(name, ".")
+	if !found {
+		return false
+	}
+
+	base := wc.baseline(ctx, zone)
+	if len(base.values) == 0 {
+		// Nothing was learned about the wildcard, so the name cannot be told apart from it
+		return false
+	}
+
+	resp := wc.observe(ctx, name, resps...)
+	var compared int
+	var diffs []ClusterDifference
+	for _, f := range wc.features {
+		value, found := resp.values[f]
+		if !found {
+			continue
+		}
+		compared++
+		if matchesBaseline(value, base.values[f]) {
+			continue
+		}
+		diffs = append(diffs, ClusterDifference{
+			Feature:  f,
+			Value:    value,
+			Baseline: sortedKeys(base.values[f]),
+		})
+	}
+
+	if compared == 0 {
+		return false
+	}
+	score := float64(len(diffs)) / float64(compared)
+	if len(diffs) == 0 || score < wc.threshold {
+		return false
+	}
+
+	wc.Lock()
+	wc.kept[name] = &WildcardCluster{
+		Name:        name,
+		Zone:        zone,
+		Score:       score,
+		Differences: diffs,
+	}
+	wc.Unlock()
+	return true
+}
+
+// baseline returns the features of the responses to the unlikely names of the zone, which are learned once.
+func (wc *wildcardClusterer) baseline(ctx context.Context, zone string) *clusterBaseline {
+	wc.Lock()
+	base, found := wc.baselines[zone]
+	if !found {
+		base = &clusterBaseline{}
+		wc.baselines[zone] = base
+	}
+	wc.Unlock()
+
+	base.Do(func() {
+		values := make(map[string]map[string]struct{})
+		for i := 0; i < wc.probes; i++ {
+			wc.Lock()
+			name := random.UnlikelyName(wc.rng, zone)
+			wc.Unlock()
+
+			resp := wc.observe(ctx, name, wc.query(ctx, name)...)
+			if len(resp.values[ClusterAnswers]) == 0 && len(resp.values[ClusterCNAME]) == 0 {
+				continue
+			}
+			for _, f := range wc.features {
+				vals, found := resp.values[f]
+				if !found {
+					continue
+				}
+				set, found := values[f]
+				if !found {
+					set = make(map[string]struct{})
+					values[f] = set
+				}
+				for _, v := range vals {
+					set[v] = struct{}{}
+				}
+				// A probe lacking the feature is part of the baseline as well
+				if len(vals) == 0 {
+					set[""] = struct{}{}
+				}
+			}
+		}
+		base.values = values
+	})
+	return base
+}
+
+// observe extracts the compared features from the responses to the name, and requests its landing page.
+func (wc *wildcardClusterer) observe(ctx context.Context, name string, resps ...*dns.Msg) *clusterResponse {
+	answers := make(map[string]struct{})
+	cnames := make(map[string]struct{})
+	for _, resp := range resps {
+		for _, a := range extractAnswers(resp) {
+			data := strings.ToLower(strings.Trim(a.Data, "."))
+			switch a.Type {
+			case dns.TypeA, dns.TypeAAAA:
+				answers[data] = struct{}{}
+			case dns.TypeCNAME:
+				cnames[data] = struct{}{}
+			}
+		}
+	}
+
+	r := &clusterResponse{values: map[string][]string{
+		ClusterAnswers: sortedKeys(answers),
+		ClusterCNAME:   sortedKeys(cnames),
+	}}
+	// The HTTP features are left out when no web server responded for the name
+	if wc.fetch != nil {
+		if code, title, ok := wc.fetch(ctx, name); ok {
+			r.values[ClusterHTTPStatus] = []string{strconv.Itoa(code)}
+			r.values[ClusterHTTPTitle] = nonEmpty(title)
+		}
+	}
+	return r
+}
+
+// matchesBaseline returns true when the value was seen in the responses to the unlikely names of the zone.
+func matchesBaseline(value []string, base map[string]struct{}) bool {
+	if len(value) == 0 {
+		_, found := base[""]
+		return found
+	}
+	for _, v := range value {
+		if _, found := base[v]; found {
+			return true
+		}
+	}
+	return false
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// all returns the names kept within the wildcard zones, sorted by the name.
+func (wc *wildcardClusterer) all() []WildcardCluster {
+	if wc == nil {
+		return nil
+	}
+
+	wc.Lock()
+	defer wc.Unlock()
+
+	kept := make([]WildcardCluster, 0, len(wc.kept))
+	for _, c := range wc.kept {
+		kept = append(kept, *c)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+	return kept
+}
+
+// WildcardClusters returns the names kept within the wildcard zones during the enumeration, along with the
+// features of their responses that differed from the wildcard.
+func (e *Enumeration) WildcardClusters() []WildcardCluster {
+	return e.clusters.all()
+}
+
+// clusterQuery returns the responses to the forward queries of the name, sent through the trusted resolvers.
+func (e *Enumeration) clusterQuery(ctx context.Context, name string) []*dns.Msg {
+	var resps []*dns.Msg
+
+	for _, t := range FwdQueryTypes {
+		if resp, err := e.dnsQuery(ctx, name, t, e.trustedPool(), 2); err == nil && resp != nil {
+			resps = append(resps, resp)
+		}
+	}
+	return resps
+}
+
+// fetchLandingPage returns the status code and title of the landing page served for the name.
+func fetchLandingPage(ctx context.Context, name string) (int, string, bool) {
+	for _, scheme := range []string{"https", "http"} {
+		resp, err := amasshttp.RequestWebPage(ctx, &amasshttp.Request{
+			URL:          scheme + "://" + name + "/",
+			Timeout:      clusterHTTPTimeout,
+			MaxBodySize:  64 * 1024,
+			MaxRedirects: -1,
+		})
+		if err != nil || resp == nil {
+			continue
+		}
+
+		var title string
+		if m := titleRE.FindStringSubmatch(resp.Body); len(m) == 2 {
+			title = strings.Join(strings.Fields(html.UnescapeString(m[1])), " ")
+		}
+		return resp.StatusCode, title, true
+	}
+	return 0, "", false
+}
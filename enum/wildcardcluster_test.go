@@ -0,0 +1,183 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestWildcardClustersFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if wc := wildcardClustersFromConfig(cfg, nil); wc != nil {
+		t.Error("the clustering was enabled without the section")
+	}
+
+	cfg.Options = map[string]interface{}{"wildcard_clustering": map[string]interface{}{}}
+	wc := wildcardClustersFromConfig(cfg, nil)
+	if wc == nil || wc.threshold != DefaultClusterThreshold || wc.probes != DefaultClusterProbes {
+		t.Fatal("the clustering was not enabled with the defaults")
+	}
+	// The web servers are only probed in the active mode
+	if !reflect.DeepEqual(wc.features, []string{ClusterAnswers, ClusterCNAME}) || wc.fetch != nil {
+		t.Errorf("the features %v were compared in the passive mode", wc.features)
+	}
+
+	cfg.Active = true
+	cfg.Options = map[string]interface{}{"wildcard_clustering": map[string]interface{}{
+		"features":  []interface{}{"CNAME", "http_title", "bogus", "cname"},
+		"threshold": 1,
+		"probes":    3,
+	}}
+	wc = wildcardClustersFromConfig(cfg, nil)
+	if wc == nil || !reflect.DeepEqual(wc.features, []string{ClusterCNAME, ClusterHTTPTitle}) ||
+		wc.threshold != 1 || wc.probes != 3 || wc.fetch == nil {
+		t.Errorf("the options were not parsed: %+v", wc)
+	}
+
+	cfg.Options = map[string]interface{}{"wildcard_clustering": map[string]interface{}{"enabled": false}}
+	if wc := wildcardClustersFromConfig(cfg, nil); wc != nil {
+		t.Error("the clustering was enabled with the section disabled")
+	}
+}
+
+// fakeWildcardZone answers for every name of the zone like a hosting platform, while a few real names
+// are hosted apart from the wildcard.
+type fakeWildcardZone struct {
+	real map[string]fakeHost
+}
+
+type fakeHost struct {
+	cname  string
+	addr   string
+	status int
+	title  string
+}
+
+func (z *fakeWildcardZone) host(name string) (fakeHost, bool) {
+	if h, found := z.real[name]; found {
+		return h, h.status != 0
+	}
+
+	// The platform rotates its addresses, and some of its requests fail
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	sum := hash.Sum32()
+	return fakeHost{
+		cname:  "wild.platform.net",
+		addr:   fmt.Sprintf("203.0.113.%d", 10+sum%2),
+		status: 404,
+		title:  "Not Found",
+	}, sum%100 != 0
+}
+
+func (z *fakeWildcardZone) answer(name string) *dns.Msg {
+	h, _ := z.host(name)
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeA)
+
+	owner := dns.Fqdn(name)
+	if h.cname != "" {
+		msg.Answer = append(msg.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+			Target: dns.Fqdn(h.cname),
+		})
+		owner = dns.Fqdn(h.cname)
+	}
+	msg.Answer = append(msg.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP(h.addr),
+	})
+	return msg
+}
+
+func TestWildcardClustering(t *testing.T) {
+	zone := &fakeWildcardZone{real: map[string]fakeHost{
+		// Hosted on the platform under its own name
+		"portal.example.com": {cname: "acme.platform.net", addr: "203.0.113.10", status: 200, title: "Acme Portal"},
+		// Hosted elsewhere
+		"vpn.example.com": {addr: "198.51.100.7", status: 200, title: "VPN Login"},
+		// Hosted elsewhere without a web server
+		"legacy.example.com": {addr: "198.51.100.8"},
+	}}
+
+	cfg := config.NewConfig()
+	cfg.Active = true
+	cfg.Options = map[string]interface{}{"wildcard_clustering": map[string]interface{}{}}
+	wc := wildcardClustersFromConfig(cfg, random.New(1).Rand("wildcard_clustering"))
+	wc.query = func(ctx context.Context, name string) []*dns.Msg {
+		return []*dns.Msg{zone.answer(name)}
+	}
+	wc.fetch = func(ctx context.Context, name string) (int, string, bool) {
+		h, ok := zone.host(name)
+		return h.status, h.title, ok
+	}
+
+	// The real names hide among the names of the platform wildcard
+	hidden := map[int]string{100: "portal.example.com", 5000: "vpn.example.com", 9900: "legacy.example.com"}
+	var names []string
+	for i := 0; i < 10000; i++ {
+		names = append(names, fmt.Sprintf("host%d.example.com", i))
+		if name, found := hidden[i]; found {
+			names = append(names, name)
+		}
+	}
+
+	var kept []string
+	for _, name := range names {
+		if wc.distinct(context.Background(), name, zone.answer(name)) {
+			kept = append(kept, name)
+		}
+	}
+	if !reflect.DeepEqual(kept, []string{"portal.example.com", "vpn.example.com", "legacy.example.com"}) {
+		t.Fatalf("the names kept within the wildcard zone were %v", kept)
+	}
+
+	clusters := wc.all()
+	if len(clusters) != 3 {
+		t.Fatalf("%d names were recorded with their evidence", len(clusters))
+	}
+	portal := clusters[1]
+	var features []string
+	for _, d := range portal.Differences {
+		features = append(features, d.Feature)
+	}
+	if portal.Name != "portal.example.com" || portal.Zone != "example.com" ||
+		!reflect.DeepEqual(features, []string{ClusterCNAME, ClusterHTTPStatus, ClusterHTTPTitle}) {
+		t.Errorf("the evidence of the name was %+v", portal)
+	}
+	if d := portal.Differences[0]; !reflect.DeepEqual(d.Value, []string{"acme.platform.net"}) ||
+		!reflect.DeepEqual(d.Baseline, []string{"wild.platform.net"}) {
+		t.Errorf("the CNAME difference was %+v", d)
+	}
+	// The web server is not compared for the name without one
+	if legacy := clusters[0]; legacy.Score != 1 || len(legacy.Differences) != 2 {
+		t.Errorf("the evidence of the name without a web server was %+v", legacy)
+	}
+}
+
+func TestWildcardClusteringWithoutBaseline(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"wildcard_clustering": map[string]interface{}{}}
+	wc := wildcardClustersFromConfig(cfg, random.New(1).Rand("wildcard_clustering"))
+	wc.query = func(ctx context.Context, name string) []*dns.Msg { return nil }
+
+	zone := &fakeWildcardZone{}
+	if wc.distinct(context.Background(), "www.example.com", zone.answer("www.example.com")) {
+		t.Error("the name was kept without the wildcard baseline of its zone")
+	}
+	var nilClusterer *wildcardClusterer
+	if nilClusterer.distinct(context.Background(), "www.example.com") || nilClusterer.all() != nil {
+		t.Error("the disabled clustering kept the name")
+	}
+}
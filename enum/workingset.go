@@ -0,0 +1,270 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+// DefaultDomainIdle is how long a domain goes without work before it is declared complete.
+const DefaultDomainIdle = 2 * time.Minute
+
+// domainFilterCells is the size of the dedupe filters held for each domain.
+const domainFilterCells = 250000
+
+// domainCheckInterval is how often the domains are checked for completion.
+const domainCheckInterval = 10 * time.Second
+
+// workingSet holds the dedupe filters of each domain in a multi-domain enumeration, so the memory of a domain
+// is released once the domain is complete, instead of being held until the last domain is finished. A domain
+// is complete when none of its names are waiting for a disposition, and it has gone without work for the
+// idle period. The late names of a complete domain, such as those of a slow data source, are checked
+// against the graph, since the filters that remembered them were released.
+type workingSet struct {
+	sync.Mutex
+	clock   clock.Clock
+	idle    time.Duration
+	domains map[string]*domainSet
+	// pending maps the names waiting for their disposition to their domain
+	pending map[string]string
+	// hooks flush and release the structures the other components hold for a complete domain
+	hooks []func(domain string)
+	// known returns true when the name was stored in the graph during the enumeration
+	known     func(name string) bool
+	completed []string
+}
+
+// domainSet is the working set of a domain.
+type domainSet struct {
+	submitted *bf.StableBloomFilter
+	stored    *bf.StableBloomFilter
+	pending   int
+	last      time.Time
+	complete  bool
+	// released is kept once the domain was complete, so the names found later are checked against the graph
+	released bool
+}
+
+// workingSetFromConfig parses the 'working_set' configuration options, and returns nil for the enumerations
+// of a single domain, or when the idle period is set to zero, which keeps the filters shared by all the domains.
+func workingSetFromConfig(cfg *config.Config, c clock.Clock) *workingSet {
+	if cfg == nil || len(cfg.Domains()) < 2 {
+		return nil
+	}
+
+	idle := DefaultDomainIdle
+	if opts, ok := cfg.Options["working_set"].(map[string]interface{}); ok {
+		if v, found := opts["idle"]; found {
+			idle = time.Duration(options.Int(v)) * time.Second
+		}
+	}
+	if idle <= 0 {
+		return nil
+	}
+
+	return &workingSet{
+		clock:   c,
+		idle:    idle,
+		domains: make(map[string]*domainSet),
+		pending: make(map[string]string),
+	}
+}
+
+// onComplete registers the hook called with each domain declared complete.
+func (ws *workingSet) onComplete(hook func(domain string)) {
+	if ws == nil {
+		return
+	}
+
+	ws.Lock()
+	defer ws.Unlock()
+
+	ws.hooks = append(ws.hooks, hook)
+}
+
+// domainSet returns the working set of the domain, which is allocated again when the domain was complete.
+// The caller must hold the lock.
+func (ws *workingSet) domainSet(d string) *domainSet {
+	ds, found := ws.domains[d]
+	if !found {
+		ds = &domainSet{}
+		ws.domains[d] = ds
+	}
+	if ds.submitted == nil {
+		ds.submitted = bf.NewDefaultStableBloomFilter(domainFilterCells, 0.01)
+		ds.stored = bf.NewDefaultStableBloomFilter(domainFilterCells, 0.01)
+		ds.complete = false
+	}
+	ds.last = ws.clock.Now()
+	return ds
+}
+
+// released returns true when the domain was declared complete at some point of the enumeration.
+func (ws *workingSet) released(d string) bool {
+	ws.Lock()
+	defer ws.Unlock()
+
+	ds, found := ws.domains[d]
+	return found && ds.released
+}
+
+// submit returns true when the name of the domain was not submitted before. A pending name is
+// expected to receive a disposition, and holds the domain open until it does.
+func (ws *workingSet) submit(name, d string, pending bool) bool {
+	name, d = strings.ToLower(name), strings.ToLower(d)
+	// The graph is consulted without holding the lock
+	if ws.released(d) && ws.known != nil && ws.known(name) {
+		return false
+	}
+
+	ws.Lock()
+	defer ws.Unlock()
+
+	ds := ws.domainSet(d)
+	if ds.submitted.TestAndAdd([]byte(name)) {
+		return false
+	}
+	if pending {
+		if _, found := ws.pending[name]; !found {
+			ws.pending[name] = d
+			ds.pending++
+		}
+	}
+	return true
+}
+
+// store returns true when the name of the domain was already stored.
+func (ws *workingSet) store(name, d string) bool {
+	ws.Lock()
+	defer ws.Unlock()
+
+	return ws.domainSet(strings.ToLower(d)).stored.TestAndAdd([]byte(strings.ToLower(name)))
+}
+
+// disposed records the disposition of the name, which no longer holds its domain open.
+func (ws *workingSet) disposed(name string, d Disposition) {
+	// The duplicates of a name say nothing about the name that is still pending
+	if ws == nil || d == DispositionDeduped {
+		return
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	ws.Lock()
+	defer ws.Unlock()
+
+	dom, found := ws.pending[name]
+	if !found {
+		return
+	}
+	delete(ws.pending, name)
+	if ds, found := ws.domains[dom]; found {
+		ds.pending--
+		ds.last = ws.clock.Now()
+	}
+}
+
+// sweep declares the domains complete that have no pending names and have been idle long enough,
+// releases their filters and calls the hooks, and returns the domains declared complete.
+func (ws *workingSet) sweep() []string {
+	if ws == nil {
+		return nil
+	}
+
+	now := ws.clock.Now()
+	ws.Lock()
+	var done []string
+	for d, ds := range ws.domains {
+		if ds.complete || ds.pending > 0 || now.Sub(ds.last) < ws.idle {
+			continue
+		}
+
+		ds.submitted, ds.stored = nil, nil
+		ds.complete, ds.released = true, true
+		done = append(done, d)
+	}
+	sort.Strings(done)
+	ws.completed = append(ws.completed, done...)
+	hooks := append([]func(string){}, ws.hooks...)
+	ws.Unlock()
+
+	for _, d := range done {
+		for _, hook := range hooks {
+			hook(d)
+		}
+	}
+	return done
+}
+
+// cells returns the number of cells held by the filters of the domains.
+func (ws *workingSet) cells() uint {
+	if ws == nil {
+		return 0
+	}
+
+	ws.Lock()
+	defer ws.Unlock()
+
+	var n uint
+	for _, ds := range ws.domains {
+		if ds.submitted != nil {
+			n += ds.submitted.Cells() + ds.stored.Cells()
+		}
+	}
+	return n
+}
+
+// completedDomains returns the domains declared complete, in the order they were.
+func (ws *workingSet) completedDomains() []string {
+	if ws == nil {
+		return nil
+	}
+
+	ws.Lock()
+	defer ws.Unlock()
+
+	return append([]string(nil), ws.completed...)
+}
+
+// watchDomains declares the domains complete until stop is closed, and notifies
+// the memory monitor of the released filters.
+func (e *Enumeration) watchDomains(stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-e.ctx.Done():
+			return
+		case <-e.clock.After(domainCheckInterval):
+		}
+
+		done := e.working.sweep()
+		if len(done) == 0 {
+			continue
+		}
+		e.memory.Sample()
+		if e.Config.Verbose {
+			e.Config.Log.Printf("The enumeration of %s is complete, and its working set was released", strings.Join(done, ", "))
+		}
+	}
+}
+
+// storedName returns true when the name was stored in the graph during the enumeration.
+func (e *Enumeration) storedName(name string) bool {
+	if e.graph == nil || e.graph.DB == nil {
+		return false
+	}
+
+	assets, err := e.graph.DB.FindByContent(domain.FQDN{Name: name}, e.Config.CollectionStartTime.UTC())
+	return err == nil && len(assets) > 0
+}
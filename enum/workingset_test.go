@@ -0,0 +1,131 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestWorkingSetFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomains("owasp.org")
+	if ws := workingSetFromConfig(cfg, clock.System); ws != nil {
+		t.Error("the working set was kept for each domain of a single domain enumeration")
+	}
+
+	cfg.AddDomains("example.com")
+	if ws := workingSetFromConfig(cfg, clock.System); ws == nil || ws.idle != DefaultDomainIdle {
+		t.Errorf("the working set was not kept with the default idle period")
+	}
+
+	cfg.Options = map[string]interface{}{"working_set": map[string]interface{}{"idle": 30}}
+	if ws := workingSetFromConfig(cfg, clock.System); ws == nil || ws.idle != 30*time.Second {
+		t.Errorf("the idle period was not parsed")
+	}
+
+	cfg.Options = map[string]interface{}{"working_set": map[string]interface{}{"idle": 0}}
+	if ws := workingSetFromConfig(cfg, clock.System); ws != nil {
+		t.Error("the working set was kept with the idle period set to zero")
+	}
+}
+
+func TestWorkingSetRelease(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomains("a.com", "b.com", "c.com")
+	fake := clock.NewFake(time.Now())
+	ws := workingSetFromConfig(cfg, fake)
+
+	var flushed []string
+	ws.onComplete(func(d string) { flushed = append(flushed, d) })
+	graph := map[string]bool{"www.a.com": true}
+	ws.known = func(name string) bool { return graph[name] }
+
+	for _, d := range cfg.Domains() {
+		for i := 0; i < 10; i++ {
+			if !ws.submit(fmt.Sprintf("host%d.%s", i, d), d, true) {
+				t.Fatalf("the first submission of a name of %s was rejected", d)
+			}
+		}
+	}
+	if ws.submit("host1.a.com", "a.com", true) {
+		t.Error("the duplicate name was accepted")
+	}
+	full := ws.cells()
+
+	// The names of a.com are done, while b.com and c.com still have names pending
+	for i := 0; i < 10; i++ {
+		ws.disposed(fmt.Sprintf("host%d.a.com", i), DispositionResolved)
+	}
+	ws.disposed("host1.b.com", DispositionDeduped)
+	for i := 1; i < 10; i++ {
+		ws.disposed(fmt.Sprintf("host%d.b.com", i), DispositionNXDomain)
+	}
+	if done := ws.sweep(); len(done) != 0 {
+		t.Errorf("the domains %v were complete before the idle period", done)
+	}
+
+	fake.Jump(DefaultDomainIdle)
+	if done := ws.sweep(); !reflect.DeepEqual(done, []string{"a.com"}) {
+		t.Errorf("the domains %v were complete, expected a.com", done)
+	}
+	// The memory is released in steps, as the domains are complete
+	step := ws.cells()
+	if step >= full {
+		t.Errorf("the filters of the complete domain were not released: %d cells of %d", step, full)
+	}
+
+	ws.disposed("host0.b.com", DispositionTimeout)
+	fake.Jump(DefaultDomainIdle)
+	if done := ws.sweep(); !reflect.DeepEqual(done, []string{"b.com"}) || ws.cells() >= step {
+		t.Errorf("the domains %v were complete, expected b.com", done)
+	}
+	if !reflect.DeepEqual(flushed, []string{"a.com", "b.com"}) || !reflect.DeepEqual(ws.completedDomains(), flushed) {
+		t.Errorf("the hooks were called for %v", flushed)
+	}
+
+	// The late names of a complete domain are checked against the graph
+	if ws.submit("www.a.com", "a.com", true) {
+		t.Error("the late name stored in the graph was accepted")
+	}
+	if !ws.submit("late.a.com", "a.com", true) || ws.submit("late.a.com", "a.com", true) {
+		t.Error("the new late name was not handled by the filter of the reopened domain")
+	}
+	ws.disposed("late.a.com", DispositionResolved)
+	fake.Jump(DefaultDomainIdle)
+	if done := ws.sweep(); !reflect.DeepEqual(done, []string{"a.com"}) {
+		t.Errorf("the reopened domain was not complete again: %v", done)
+	}
+}
+
+func TestSubdomainRelease(t *testing.T) {
+	cfg := config.NewConfig()
+	r := newSubdomainTask(&Enumeration{Config: cfg})
+	defer close(r.done)
+
+	r.cnames.InsertMany("cdn.owasp.org", "cdn.example.com")
+	r.withinWildcards.InsertMany("wild.owasp.org", "wild.example.com")
+	r.timesForSubdomain("dev.owasp.org")
+	r.timesForSubdomain("dev.example.com")
+
+	r.release("owasp.org")
+	if r.cnames.Has("cdn.owasp.org") || r.withinWildcards.Has("wild.owasp.org") {
+		t.Error("the wildcard and CNAME state of the complete domain was kept")
+	}
+	if !r.cnames.Has("cdn.example.com") || !r.withinWildcards.Has("wild.example.com") {
+		t.Error("the state of the other domain was released")
+	}
+	if times := r.timesForSubdomain("dev.owasp.org"); times != 1 {
+		t.Errorf("the subdomain of the complete domain was counted %d times", times)
+	}
+	if times := r.timesForSubdomain("dev.example.com"); times != 2 {
+		t.Errorf("the subdomain of the other domain was counted %d times", times)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/journal"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultWriteInterval is the least time between two writes of the same record edge to the graph database.
+const DefaultWriteInterval = time.Minute
+
+// WriteStats holds the number of record writes requested by the enumeration and of those sent to the graph database.
+type WriteStats struct {
+	// Requested is the number of times the records were observed and submitted for writing
+	Requested int64 `json:"requested"`
+	// Written is the number of upserts sent to the graph database
+	Written int64 `json:"written"`
+}
+
+// writeCoalescer buffers the writes of the record edges observed again, such as those of the names provided
+// over and over by the certificate streams and passive DNS sources. Writing an edge the graph already holds
+// only moves its last seen time, which costs a round trip to a remote database for nothing else.
+type writeCoalescer struct {
+	sync.Mutex
+	clock     clock.Clock
+	interval  time.Duration
+	edges     map[string]*edgeWrite
+	requested int64
+	written   int64
+}
+
+// edgeWrite is a record edge written during the interval, along with the observations made since the write.
+type edgeWrite struct {
+	written time.Time
+	pending bool
+	period  history.Period
+	write   func(context.Context, history.Period) error
+}
+
+// writeCoalescerFromConfig parses the 'graph_writes' configuration options, and returns nil when the
+// interval is zero, so every observation is written.
+func writeCoalescerFromConfig(cfg *config.Config, c clock.Clock) *writeCoalescer {
+	interval := DefaultWriteInterval
+
+	if cfg != nil && cfg.Options != nil {
+		if opts, ok := cfg.Options["graph_writes"].(map[string]interface{}); ok {
+			if v, found := opts["interval"]; found {
+				if n := options.Int(v); n >= 0 {
+					interval = time.Duration(n) * time.Second
+				}
+			}
+		}
+	}
+	if interval == 0 {
+		return nil
+	}
+
+	return &writeCoalescer{
+		clock:    c,
+		interval: interval,
+		edges:    make(map[string]*edgeWrite),
+	}
+}
+
+// submit writes the edge right away when it was not written during the interval, since the edge may be new to
+// the graph. Otherwise, the observation is kept until the interval has elapsed, and the period written then
+// spans all the observations, so the last seen time is the newest one.
+func (w *writeCoalescer) submit(ctx context.Context, key string, p history.Period, write func(context.Context, history.Period) error) error {
+	if w == nil {
+		return write(ctx, p)
+	}
+
+	now := w.clock.Now()
+	w.Lock()
+	w.requested++
+	ew, found := w.edges[key]
+	if found && now.Sub(ew.written) < w.interval {
+		ew.observe(p)
+		ew.write = write
+		w.Unlock()
+		return nil
+	}
+	if found && ew.pending {
+		ew.observe(p)
+		p = ew.period
+	}
+	w.edges[key] = &edgeWrite{written: now, write: write}
+	w.written++
+	w.Unlock()
+
+	err := write(ctx, p)
+	if err != nil {
+		// The edge is written again by its next observation
+		w.Lock()
+		delete(w.edges, key)
+		w.Unlock()
+	}
+	return err
+}
+
+// observe widens the pending period to include the observation.
+func (ew *edgeWrite) observe(p history.Period) {
+	if !ew.pending {
+		ew.pending, ew.period = true, p
+		return
+	}
+	ew.period.Widen(p)
+}
+
+// flush writes the observations of the edges whose interval has elapsed, or all of them when the enumeration
+// is finished. The edges left without observations are forgotten, so their next observation is written.
+func (w *writeCoalescer) flush(ctx context.Context, all bool) {
+	if w == nil {
+		return
+	}
+
+	type flushed struct {
+		period history.Period
+		write  func(context.Context, history.Period) error
+	}
+
+	now := w.clock.Now()
+	var writes []flushed
+	w.Lock()
+	for key, ew := range w.edges {
+		if !all && now.Sub(ew.written) < w.interval {
+			continue
+		}
+		if !ew.pending {
+			delete(w.edges, key)
+			continue
+		}
+
+		writes = append(writes, flushed{period: ew.period, write: ew.write})
+		ew.written, ew.pending, ew.period = now, false, history.Period{}
+		w.written++
+	}
+	w.Unlock()
+	// The writes that fail are journaled by the write functions
+	for _, f := range writes {
+		_ = f.write(ctx, f.period)
+	}
+}
+
+// stats returns the number of writes requested and sent so far.
+func (w *writeCoalescer) stats() WriteStats {
+	if w == nil {
+		return WriteStats{}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	return WriteStats{Requested: w.requested, Written: w.written}
+}
+
+// WriteStats returns the number of record writes requested by the enumeration and of those sent to the graph database.
+func (e *Enumeration) WriteStats() WriteStats {
+	return e.writes.stats()
+}
+
+// flushWrites writes the buffered observations once their interval has elapsed, until stopped.
+func (e *Enumeration) flushWrites(stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-e.ctx.Done():
+			return
+		case <-e.clock.After(e.writes.interval):
+		}
+
+		e.writes.flush(e.ctx, false)
+	}
+}
+
+// finishWrites writes the observations still buffered once the findings have been stored.
+func (e *Enumeration) finishWrites() {
+	// The enumeration context may have expired, while the last seen times are still worth writing
+	e.writes.flush(context.Background(), true)
+
+	if s := e.writes.stats(); s.Requested > s.Written {
+		e.Config.Log.Printf("%d of the record writes observed the same edge again, and %d were sent to the graph database",
+			s.Requested-s.Written, s.Written)
+	}
+}
+
+// observedPeriod returns the period of the record observed at the time, which remains current until its
+// raw TTL, bounded by the configuration, has elapsed. The raw TTL is kept for reporting.
+func (e *Enumeration) observedPeriod(now time.Time, ttl int) history.Period {
+	p := history.Period{FirstSeen: now, LastSeen: now}
+	if ttl > 0 {
+		p.TTL, p.ValidUntil = ttl, now.Add(e.ttls.Apply(ttl))
+	}
+	return p
+}
+
+// writeRecord upserts the record edge from the name to the target through the write coalescer, and
+// journals the write when it fails. The period widens the history of the address records.
+func (e *Enumeration) writeRecord(ctx context.Context, rec journal.Record, p history.Period) error {
+	key := strings.Join([]string{rec.Op, rec.Name, rec.Target}, "|")
+	e.trace(rec.Name, systems.TraceStore, "observed", "op", rec.Op, "target", rec.Target)
+
+	return e.writes.submit(ctx, key, p, func(ctx context.Context, p history.Period) error {
+		start := e.pressure.now()
+		err := e.upsertRecord(ctx, rec)
+		e.pressure.timed(start)
+		if e.tracing(rec.Name) {
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			e.trace(rec.Name, systems.TraceStore, "written", "op", rec.Op, "target", rec.Target, "error", msg)
+		}
+		if err == nil && (rec.Op == journal.OpA || rec.Op == journal.OpAAAA) && !p.LastSeen.IsZero() {
+			e.History.Observe(rec.Name, rec.Target, p)
+		}
+		e.journalWrite(rec, err)
+		return err
+	})
+}
+
+func (e *Enumeration) upsertRecord(ctx context.Context, rec journal.Record) error {
+	switch rec.Op {
+	case journal.OpA:
+		return e.graph.UpsertA(ctx, rec.Name, rec.Target)
+	case journal.OpAAAA:
+		return e.graph.UpsertAAAA(ctx, rec.Name, rec.Target)
+	case journal.OpCNAME:
+		return e.graph.UpsertCNAME(ctx, rec.Name, rec.Target)
+	case journal.OpPTR:
+		return e.graph.UpsertPTR(ctx, rec.Name, rec.Target)
+	case journal.OpSRV:
+		return e.graph.UpsertSRV(ctx, rec.Name, rec.Target)
+	case journal.OpNS:
+		return e.graph.UpsertNS(ctx, rec.Name, rec.Target)
+	case journal.OpMX:
+		return e.graph.UpsertMX(ctx, rec.Name, rec.Target)
+	}
+	return fmt.Errorf("the %s operation does not write a record edge", rec.Op)
+}
@@ -0,0 +1,232 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package enum
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/caffix/queue"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/history"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	bf "github.com/tylertreat/BoomFilters"
+)
+
+func TestWriteCoalescerFromConfig(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	if w := writeCoalescerFromConfig(config.NewConfig(), c); w == nil || w.interval != DefaultWriteInterval {
+		t.Errorf("the default interval was not used")
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"graph_writes": map[string]interface{}{"interval": 30}}
+	if w := writeCoalescerFromConfig(cfg, c); w == nil || w.interval != 30*time.Second {
+		t.Errorf("the interval of 30 seconds was not parsed")
+	}
+
+	cfg.Options = map[string]interface{}{"graph_writes": map[string]interface{}{"interval": 0}}
+	if w := writeCoalescerFromConfig(cfg, c); w != nil {
+		t.Error("an interval of zero did not disable the coalescing")
+	}
+	// Without the coalescer, every observation is written
+	var w *writeCoalescer
+	var writes int
+	for i := 0; i < 3; i++ {
+		_ = w.submit(context.Background(), "key", history.Period{}, func(context.Context, history.Period) error {
+			writes++
+			return nil
+		})
+	}
+	if writes != 3 {
+		t.Errorf("%d of the 3 observations were written", writes)
+	}
+}
+
+// chattyEnumeration returns an enumeration storing into its own graph, with the history kept.
+func chattyEnumeration(t *testing.T, c clock.Clock, interval int) (*Enumeration, *netmap.Graph) {
+	g := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	t.Cleanup(func() { g.Remove() })
+
+	store, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+	cfg.Options = map[string]interface{}{"graph_writes": map[string]interface{}{"interval": interval}}
+	e := &Enumeration{
+		Config:  cfg,
+		History: store.Backend("local"),
+		graph:   g,
+		prov:    newProvenanceGraph(),
+		job:     requests.NewJob("test", cfg, nil),
+		clock:   c,
+		writes:  writeCoalescerFromConfig(cfg, c),
+	}
+	e.nameSrc = &enumSource{
+		enum:    e,
+		queue:   queue.NewQueue(),
+		filter:  bf.NewDefaultStableBloomFilter(1000000, 0.01),
+		done:    make(chan struct{}),
+		release: make(chan struct{}, 10),
+		max:     10,
+		rejects: make(map[string]int),
+	}
+	return e, g
+}
+
+// chattySource delivers the same name again every second, as a certificate stream or a passive DNS source
+// does, each time with the historical address seen a second later than before.
+func chattySource(t *testing.T, e *Enumeration, c *clock.Fake, hits int) time.Time {
+	ctx := context.Background()
+	dm := &dataManager{enum: e}
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var newest time.Time
+	for i := 0; i < hits; i++ {
+		c.Jump(time.Second)
+		newest = base.Add(time.Duration(i) * time.Second)
+		e.job.Findings.Add(&requests.Finding{
+			Name:        "www.owasp.org",
+			Resolutions: []requests.Resolution{{Address: "192.0.2.1", FirstSeen: base, LastSeen: newest}},
+		})
+
+		req := &requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+			{Name: "www.owasp.org", Type: int(dns.TypeA), Data: "192.0.2.2"},
+		}}
+		if err := dm.dnsRequest(ctx, req, nil); err != nil {
+			t.Fatalf("the records of %s were not stored: %v", req.Name, err)
+		}
+	}
+	return newest
+}
+
+func TestChattySourceWrites(t *testing.T) {
+	const hits = 300
+	ctx := context.Background()
+	c := clock.NewFake(time.Now())
+
+	e, g := chattyEnumeration(t, c, 60)
+	newest := chattySource(t, e, c, hits)
+	// Without the coalescing, each requested write is an upsert sent to the graph database
+	before := e.WriteStats()
+	if before.Requested != 2*hits {
+		t.Errorf("%d writes were requested, expected %d", before.Requested, 2*hits)
+	}
+	// Each edge is written at most once a minute, instead of once a second
+	if limit := int64(2 * (hits/60 + 1)); before.Written > limit {
+		t.Errorf("%d writes were sent to the graph database, expected at most %d", before.Written, limit)
+	}
+	t.Logf("the coalescing reduced the %d backend writes to %d", before.Requested, before.Written)
+
+	// A new address is a structural change, and is written right away
+	dm := &dataManager{enum: e}
+	req := &requests.DNSRequest{Name: "www.owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+		{Name: "www.owasp.org", Type: int(dns.TypeA), Data: "192.0.2.3"},
+	}}
+	if err := dm.dnsRequest(ctx, req, nil); err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := g.NamesToAddrs(ctx, time.Time{}, "www.owasp.org")
+	if err != nil || len(pairs) != 3 {
+		t.Errorf("the graph holds %d addresses of the name, expected 3: %v", len(pairs), err)
+	}
+
+	// The final write of the buffered observations stores the newest one
+	e.finishWrites()
+	if p, found := e.History.Period("www.owasp.org", "192.0.2.1"); !found || !p.LastSeen.Equal(newest) {
+		t.Errorf("the stored last seen time %v is not the newest observation %v", p.LastSeen, newest)
+	}
+	if s := e.WriteStats(); s.Written <= before.Written {
+		t.Error("the buffered observations were not written once the enumeration finished")
+	}
+	if s := e.writes.stats(); s.Written >= s.Requested/10 {
+		t.Errorf("the %d writes sent were not an order of magnitude fewer than the %d requested", s.Written, s.Requested)
+	}
+}
+
+func TestWriteCoalescerFlush(t *testing.T) {
+	ctx := context.Background()
+	c := clock.NewFake(time.Now())
+	w := &writeCoalescer{clock: c, interval: time.Minute, edges: make(map[string]*edgeWrite)}
+
+	var written []history.Period
+	write := func(_ context.Context, p history.Period) error {
+		written = append(written, p)
+		return nil
+	}
+
+	t1, t2 := c.Now(), c.Now().Add(10*time.Second)
+	_ = w.submit(ctx, "a", history.Period{FirstSeen: t1, LastSeen: t1}, write)
+	_ = w.submit(ctx, "a", history.Period{FirstSeen: t2, LastSeen: t2}, write)
+	_ = w.submit(ctx, "a", history.Period{FirstSeen: t1, LastSeen: t1}, write)
+	if len(written) != 1 {
+		t.Fatalf("%d writes were made within the interval", len(written))
+	}
+
+	// The flush before the interval has elapsed writes nothing
+	w.flush(ctx, false)
+	if len(written) != 1 {
+		t.Fatalf("the observations were written before the interval elapsed")
+	}
+
+	c.Jump(time.Minute)
+	w.flush(ctx, false)
+	if len(written) != 2 || !written[1].LastSeen.Equal(t2) || !written[1].FirstSeen.Equal(t1) {
+		t.Fatalf("the flush wrote %v", written)
+	}
+
+	// The edge without observations is forgotten, so the next one is written right away
+	c.Jump(time.Minute)
+	w.flush(ctx, false)
+	if len(w.edges) != 0 {
+		t.Errorf("%d edges are still held", len(w.edges))
+	}
+	_ = w.submit(ctx, "a", history.Period{}, write)
+	if len(written) != 3 {
+		t.Errorf("the observation of the forgotten edge was not written")
+	}
+}
+
+func TestRecordTTLBounded(t *testing.T) {
+	ctx := context.Background()
+	e, _ := chattyEnumeration(t, clock.NewFake(time.Now()), 0)
+	e.ttls = systems.TTLBounds{Floor: 5 * time.Minute, Ceiling: 24 * time.Hour}
+
+	// The CDN answers with a TTL of 30 seconds
+	dm := &dataManager{enum: e}
+	req := &requests.DNSRequest{Name: "cdn.owasp.org", Domain: "owasp.org", Records: []requests.DNSAnswer{
+		{Name: "cdn.owasp.org", Type: int(dns.TypeA), TTL: 30, Data: "192.0.2.30"},
+	}}
+	if err := dm.dnsRequest(ctx, req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p, found := e.History.Period("cdn.owasp.org", "192.0.2.30")
+	if !found || p.TTL != 30 {
+		t.Fatalf("the raw TTL was not kept: %+v", p)
+	}
+	if d := p.ValidUntil.Sub(p.LastSeen); d != 5*time.Minute {
+		t.Errorf("the edge remains current for %s, expected the floor", d)
+	}
+	if !e.History.ValidAt("cdn.owasp.org", "192.0.2.30", p.LastSeen.Add(time.Minute)) {
+		t.Error("the edge was not current once the raw TTL had elapsed")
+	}
+	if e.History.ValidAt("cdn.owasp.org", "192.0.2.30", p.LastSeen.Add(6*time.Minute)) {
+		t.Error("the edge was current once the floor had elapsed")
+	}
+}
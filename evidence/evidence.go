@@ -0,0 +1,371 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package evidence
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// DirName is the name of the directory under the output directory that holds the evidence.
+	DirName = "evidence"
+	// IndexFile is the name of the file that maps the names to their evidence.
+	IndexFile = "index.json"
+	// DefaultMaxSize is the size budget in bytes of the compressed evidence.
+	DefaultMaxSize int64 = 100 << 20
+	// MaxFragmentSize is the largest response fragment stored, since larger ones are truncated.
+	MaxFragmentSize = 64 << 10
+	// DefaultParseErrorSize is the number of bytes kept of the responses that failed to parse.
+	DefaultParseErrorSize = 4 << 10
+)
+
+// ErrNotFound is returned when no stored evidence has the hash.
+var ErrNotFound = errors.New("the evidence was not found")
+
+// Config is the configuration of the evidence store.
+type Config struct {
+	// MaxSize is the size budget in bytes, after which the least recently used evidence is evicted
+	MaxSize int64
+	// ParseErrors keeps the responses the data sources failed to parse, for troubleshooting
+	ParseErrors bool
+	// ParseErrorSize is the number of bytes kept of each response that failed to parse
+	ParseErrorSize int
+}
+
+// ConfigFromOptions returns the evidence store settings found in the configuration options,
+// or nil when the evidence store has not been enabled.
+func ConfigFromOptions(cfg *config.Config) *Config {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["evidence"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, _ := opts["enabled"].(bool); !enabled {
+		return nil
+	}
+
+	c := &Config{MaxSize: DefaultMaxSize, ParseErrorSize: DefaultParseErrorSize}
+	// The size budget is provided in megabytes
+	if mb := options.Int(opts["max_size"]); mb > 0 {
+		c.MaxSize = int64(mb) << 20
+	}
+	c.ParseErrors, _ = opts["parse_errors"].(bool)
+	if n := options.Int(opts["parse_error_size"]); n > 0 {
+		c.ParseErrorSize = n
+	}
+	if c.ParseErrorSize > MaxFragmentSize {
+		c.ParseErrorSize = MaxFragmentSize
+	}
+	return c
+}
+
+// Ref references the evidence of a name provided by a data source.
+type Ref struct {
+	Hash   string `json:"hash"`
+	Source string `json:"source"`
+}
+
+type blob struct {
+	Size     int64     `json:"size"`
+	Accessed time.Time `json:"accessed"`
+	Names    []string  `json:"names,omitempty"`
+}
+
+type index struct {
+	Blobs map[string]*blob `json:"blobs"`
+	Names map[string][]Ref `json:"names"`
+}
+
+// Store keeps the compressed data source response fragments that yielded each name, keyed by their hash.
+// The graph schema has no properties, so the references from the names to the hashes are kept by the store.
+type Store struct {
+	sync.Mutex
+	dir  string
+	max  int64
+	size int64
+	idx  index
+}
+
+// Open returns the evidence store kept in the directory, with the size budget in bytes.
+func Open(dir string, max int64) (*Store, error) {
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the evidence directory: %v", err)
+	}
+
+	s := &Store{
+		dir: dir,
+		max: max,
+		idx: index{
+			Blobs: make(map[string]*blob),
+			Names: make(map[string][]Ref),
+		},
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, IndexFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the evidence index: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.idx); err != nil {
+			return nil, fmt.Errorf("failed to parse the evidence index: %v", err)
+		}
+		if s.idx.Blobs == nil {
+			s.idx.Blobs = make(map[string]*blob)
+		}
+		if s.idx.Names == nil {
+			s.idx.Names = make(map[string][]Ref)
+		}
+	}
+	// Drop the references to blobs that were removed from the directory
+	for hash, b := range s.idx.Blobs {
+		if _, err := os.Stat(s.path(hash)); err != nil {
+			s.remove(hash)
+			continue
+		}
+		s.size += b.Size
+	}
+	s.evict("")
+	return s, nil
+}
+
+// Add stores the fragment that yielded the name from the source and returns its hash.
+func (s *Store) Add(name, source string, fragment []byte) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || len(fragment) == 0 {
+		return "", errors.New("the name and fragment are required")
+	}
+	if len(fragment) > MaxFragmentSize {
+		fragment = fragment[:MaxFragmentSize]
+	}
+
+	sum := sha256.Sum256(fragment)
+	hash := hex.EncodeToString(sum[:])
+
+	s.Lock()
+	defer s.Unlock()
+
+	b, found := s.idx.Blobs[hash]
+	if !found {
+		size, err := s.write(hash, fragment)
+		if err != nil {
+			return "", err
+		}
+
+		b = &blob{Size: size}
+		s.idx.Blobs[hash] = b
+		s.size += size
+	}
+	b.Accessed = time.Now()
+
+	ref := Ref{Hash: hash, Source: source}
+	if !hasRef(s.idx.Names[name], ref) {
+		s.idx.Names[name] = append(s.idx.Names[name], ref)
+		if !hasString(b.Names, name) {
+			b.Names = append(b.Names, name)
+		}
+	}
+	s.evict(hash)
+	return hash, nil
+}
+
+// Refs returns the references to the evidence stored for the name.
+func (s *Store) Refs(name string) []Ref {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]Ref(nil), s.idx.Names[strings.ToLower(name)]...)
+}
+
+// Hashes returns the hashes of the evidence stored for the name.
+func (s *Store) Hashes(name string) []string {
+	var hashes []string
+
+	for _, ref := range s.Refs(name) {
+		if !hasString(hashes, ref.Hash) {
+			hashes = append(hashes, ref.Hash)
+		}
+	}
+	return hashes
+}
+
+// Lookup resolves the hash back to the stored fragment.
+func (s *Store) Lookup(hash string) ([]byte, error) {
+	hash = strings.ToLower(hash)
+	if !validHash(hash) {
+		return nil, ErrNotFound
+	}
+
+	s.Lock()
+	b, found := s.idx.Blobs[hash]
+	if found {
+		b.Accessed = time.Now()
+	}
+	s.Unlock()
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(s.path(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the evidence %s: %v", hash, err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress the evidence %s: %v", hash, err)
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// Size returns the number of bytes used by the compressed evidence.
+func (s *Store) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.size
+}
+
+// Close persists the index of the store.
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := json.Marshal(&s.idx)
+	if err != nil {
+		return err
+	}
+	// Replace the file atomically, so an interrupted write never loses the previous index
+	path := filepath.Join(s.dir, IndexFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash+".gz")
+}
+
+func (s *Store) write(hash string, fragment []byte) (int64, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(fragment); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.WriteFile(s.path(hash), buf.Bytes(), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write the evidence %s: %v", hash, err)
+	}
+	return int64(buf.Len()), nil
+}
+
+// evict removes the least recently used evidence until the store is within the size budget.
+// The evidence identified by keep is never evicted.
+func (s *Store) evict(keep string) {
+	if s.size <= s.max {
+		return
+	}
+
+	hashes := make([]string, 0, len(s.idx.Blobs))
+	for hash := range s.idx.Blobs {
+		if hash != keep {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return s.idx.Blobs[hashes[i]].Accessed.Before(s.idx.Blobs[hashes[j]].Accessed)
+	})
+
+	for _, hash := range hashes {
+		if s.size <= s.max {
+			break
+		}
+
+		s.size -= s.idx.Blobs[hash].Size
+		_ = os.Remove(s.path(hash))
+		s.remove(hash)
+	}
+}
+
+// remove drops the blob from the index along with the references to it.
+func (s *Store) remove(hash string) {
+	b, found := s.idx.Blobs[hash]
+	if !found {
+		return
+	}
+	delete(s.idx.Blobs, hash)
+
+	for _, name := range b.Names {
+		var refs []Ref
+
+		for _, ref := range s.idx.Names[name] {
+			if ref.Hash != hash {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) == 0 {
+			delete(s.idx.Names, name)
+		} else {
+			s.idx.Names[name] = refs
+		}
+	}
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func hasRef(refs []Ref, ref Ref) bool {
+	for _, r := range refs {
+		if r == ref {
+			return true
+		}
+	}
+	return false
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package evidence
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestAddAndLookup(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragment := []byte(`{"common_name":"www.owasp.org","issuer_name":"C=US, O=Let's Encrypt"}`)
+	hash, err := s.Add("WWW.owasp.org", "Crtsh", fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same fragment provided by another source is stored once
+	if h, err := s.Add("www.owasp.org", "CertSpotter", fragment); err != nil || h != hash {
+		t.Errorf("the duplicate fragment was given the hash %s, expected %s", h, hash)
+	}
+	if _, err := s.Add("owasp.org", "Crtsh", fragment); err != nil {
+		t.Fatal(err)
+	}
+
+	if refs := s.Refs("www.owasp.org"); len(refs) != 2 || refs[0].Source != "Crtsh" || refs[1].Source != "CertSpotter" {
+		t.Errorf("the name has the references %v", refs)
+	}
+	if hashes := s.Hashes("www.owasp.org"); len(hashes) != 1 || hashes[0] != hash {
+		t.Errorf("the name has the hashes %v", hashes)
+	}
+
+	data, err := s.Lookup(hash)
+	if err != nil || string(data) != string(fragment) {
+		t.Errorf("the lookup returned %q, %v", data, err)
+	}
+	for _, h := range []string{"", "../index.json", hash[:63] + "z"} {
+		if _, err := s.Lookup(h); err != ErrNotFound {
+			t.Errorf("the lookup of %q returned %v, expected %v", h, err, ErrNotFound)
+		}
+	}
+}
+
+func TestEviction(t *testing.T) {
+	s, err := Open(t.TempDir(), 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Random data does not compress, so each fragment uses more than a third of the budget
+	var hashes []string
+	for _, name := range []string{"a.owasp.org", "b.owasp.org", "c.owasp.org"} {
+		fragment := make([]byte, 1200)
+		_, _ = rand.Read(fragment)
+
+		hash, err := s.Add(name, "test", fragment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+
+		if len(hashes) == 2 {
+			// Using the first fragment makes the second the least recently used
+			time.Sleep(10 * time.Millisecond)
+			if _, err := s.Lookup(hashes[0]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if s.Size() > 3000 {
+		t.Errorf("the store uses %d bytes, which exceeds the budget", s.Size())
+	}
+	if _, err := s.Lookup(hashes[1]); err != ErrNotFound {
+		t.Errorf("the least recently used evidence was not evicted: %v", err)
+	}
+	if refs := s.Refs("b.owasp.org"); len(refs) != 0 {
+		t.Errorf("the references to the evicted evidence remain: %v", refs)
+	}
+	for _, i := range []int{0, 2} {
+		if _, err := s.Lookup(hashes[i]); err != nil {
+			t.Errorf("the evidence %d was evicted: %v", i, err)
+		}
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, _ := s.Add("www.owasp.org", "Crtsh", []byte("www.owasp.org"))
+	removed, _ := s.Add("ftp.owasp.org", "Crtsh", []byte("ftp.owasp.org"))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Blobs removed from the directory are dropped from the index
+	if err := os.Remove(filepath.Join(dir, removed+".gz")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes := s.Hashes("www.owasp.org"); len(hashes) != 1 || hashes[0] != kept {
+		t.Errorf("the references were not restored: %v", hashes)
+	}
+	if refs := s.Refs("ftp.owasp.org"); len(refs) != 0 {
+		t.Errorf("the references to the removed evidence remain: %v", refs)
+	}
+	if data, err := s.Lookup(kept); err != nil || string(data) != "www.owasp.org" {
+		t.Errorf("the lookup returned %q, %v", data, err)
+	}
+}
+
+func TestConfigFromOptions(t *testing.T) {
+	cfg := config.NewConfig()
+	if c := ConfigFromOptions(cfg); c != nil {
+		t.Errorf("the store was enabled without the options: %+v", c)
+	}
+
+	cfg.Options["evidence"] = map[string]interface{}{"enabled": false, "max_size": 10}
+	if c := ConfigFromOptions(cfg); c != nil {
+		t.Errorf("the disabled store was enabled: %+v", c)
+	}
+
+	cfg.Options["evidence"] = map[string]interface{}{"enabled": true}
+	if c := ConfigFromOptions(cfg); c == nil || c.MaxSize != DefaultMaxSize {
+		t.Errorf("the default size budget was not used: %+v", c)
+	}
+
+	cfg.Options["evidence"] = map[string]interface{}{"enabled": true, "max_size": 10}
+	if c := ConfigFromOptions(cfg); c == nil || c.MaxSize != 10<<20 {
+		t.Errorf("the size budget was not parsed: %+v", c)
+	}
+	if c := ConfigFromOptions(cfg); c.ParseErrors || c.ParseErrorSize != DefaultParseErrorSize {
+		t.Errorf("the parse errors are kept by default: %+v", c)
+	}
+
+	cfg.Options["evidence"] = map[string]interface{}{"enabled": true, "parse_errors": true, "parse_error_size": 1 << 20}
+	if c := ConfigFromOptions(cfg); c == nil || !c.ParseErrors || c.ParseErrorSize != MaxFragmentSize {
+		t.Errorf("the parse error options were not parsed: %+v", c)
+	}
+}
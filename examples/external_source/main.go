@@ -0,0 +1,90 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// The reference implementation of an external data source. The names listed in the file named by the
+// NAMES_FILE environment variable, or the names.txt file within the working directory, are returned for
+// the domains they belong to, and the addresses are answered with their reverse DNS names. Build the
+// executable into the directory of the external data sources to have it launched during the enumerations:
+//
+//	go build -o /path/to/sources/names ./examples/external_source
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/datasrcs/external"
+)
+
+func main() {
+	path := os.Getenv("NAMES_FILE")
+	if path == "" {
+		path = "names.txt"
+	}
+
+	names, err := readNames(path)
+	if err != nil {
+		// The standard error of the source is written to the log of the enumeration
+		fmt.Fprintf(os.Stderr, "failed to read the names: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	hs := external.Handshake{
+		Name:        "NamesFile",
+		Type:        "api",
+		Concurrency: 2,
+	}
+	if err := external.Serve(ctx, os.Stdin, os.Stdout, hs, handler(names)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func handler(names []string) external.Handler {
+	return func(ctx context.Context, req *external.Request, send func(*external.Response)) error {
+		switch req.Kind {
+		case external.KindDomain:
+			var found []string
+			for _, name := range names {
+				if name == req.Domain || strings.HasSuffix(name, "."+req.Domain) {
+					found = append(found, name)
+				}
+			}
+			if len(found) > 0 {
+				send(&external.Response{Names: found})
+			}
+		case external.KindAddress:
+			ptrs, err := net.DefaultResolver.LookupAddr(ctx, req.Address)
+			if err != nil {
+				return err
+			}
+			send(&external.Response{Names: ptrs, Addresses: []string{req.Address}})
+		}
+		return nil
+	}
+}
+
+func readNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if name := strings.ToLower(strings.TrimSpace(scanner.Text())); name != "" && !strings.HasPrefix(name, "#") {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
@@ -0,0 +1,431 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package incremental writes the findings to the JSON Lines and CSV output files as they are found, so the
+// files remain parseable when the process dies. Each record is appended as a single line, and the hash of its
+// name is appended to a sidecar index along with the offset at which the record ends. When a run is resumed,
+// the trailing partial record left by the crash is truncated, the index is loaded, and the records the index
+// had not caught up with are read back, so the names already written are not written again.
+package incremental
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// IndexSuffix is appended to the path of the output file to name its sidecar index.
+const IndexSuffix = ".idx"
+
+// DefaultSyncInterval is the period between the flushes of the files to the disk.
+const DefaultSyncInterval = 5 * time.Second
+
+// CSVHeader holds the columns of the CSV records, which the import files also accept.
+var CSVHeader = []string{"name", "domain", "addresses", "asns", "cidrs", "sources", "confidence"}
+
+// Options controls how the output file is opened and flushed.
+type Options struct {
+	// Resume appends to the file of an interrupted run, instead of truncating it
+	Resume bool
+	// SyncInterval is the period between the flushes to the disk, which defaults to DefaultSyncInterval
+	SyncInterval time.Duration
+}
+
+// Writer appends the record of each name to the output file once.
+type Writer struct {
+	sync.Mutex
+	file     *os.File
+	index    *os.File
+	offset   int64
+	seen     map[uint64]struct{}
+	encode   func(o *requests.Output) ([]byte, error)
+	name     func(line []byte) (string, bool)
+	interval time.Duration
+	lastSync time.Time
+	dirty    bool
+}
+
+// NewJSONWriter returns the Writer of the JSON Lines records of the schema version to the file at path.
+func NewJSONWriter(path string, version int, opts Options) (*Writer, error) {
+	if _, err := schema.Convert(version, &requests.Output{}); err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		encode: func(o *requests.Output) ([]byte, error) {
+			rec, err := schema.Convert(version, o)
+			if err != nil {
+				return nil, err
+			}
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return nil, err
+			}
+			return append(data, '\n'), nil
+		},
+		name: jsonName,
+	}
+	if err := w.open(path, opts, nil); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewCSVWriter returns the Writer of the CSV records to the file at path. The header is written once,
+// when the file holds no records.
+func NewCSVWriter(path string, opts Options) (*Writer, error) {
+	header, err := csvLine(CSVHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{encode: csvRecord, name: csvName}
+	if err := w.open(path, opts, header); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func jsonName(line []byte) (string, bool) {
+	var rec struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(line, &rec); err != nil || rec.Name == "" {
+		return "", false
+	}
+	return rec.Name, true
+}
+
+func csvName(line []byte) (string, bool) {
+	fields, err := csv.NewReader(bytes.NewReader(line)).Read()
+	if err != nil || len(fields) == 0 || fields[0] == "" || fields[0] == CSVHeader[0] {
+		return "", false
+	}
+	return fields[0], true
+}
+
+func csvRecord(o *requests.Output) ([]byte, error) {
+	var addrs, asns, cidrs []string
+	for _, a := range o.Addresses {
+		addrs = append(addrs, a.Address.String())
+		asns = append(asns, strconv.Itoa(a.ASN))
+		cidrs = append(cidrs, a.CIDRStr)
+	}
+
+	var confidence string
+	if o.Confidence > 0 {
+		confidence = strconv.FormatFloat(o.Confidence, 'f', 2, 64)
+	}
+	return csvLine([]string{
+		o.Name,
+		o.Domain,
+		strings.Join(addrs, ";"),
+		strings.Join(asns, ";"),
+		strings.Join(cidrs, ";"),
+		strings.Join(o.Sources, ";"),
+		confidence,
+	})
+}
+
+// csvLine encodes the fields as a single line, so a record never spans several lines of the file.
+func csvLine(fields []string) ([]byte, error) {
+	for i, f := range fields {
+		fields[i] = strings.NewReplacer("\r", " ", "\n", " ").Replace(f)
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if err := cw.Write(fields); err != nil {
+		return nil, err
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// IndexPath returns the path of the sidecar index of the output file.
+func IndexPath(path string) string {
+	return path + IndexSuffix
+}
+
+func nameHash(name string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strings.ToLower(strings.TrimSuffix(name, "."))))
+	return h.Sum64()
+}
+
+func (w *Writer) open(path string, opts Options, header []byte) error {
+	w.interval = opts.SyncInterval
+	if w.interval <= 0 {
+		w.interval = DefaultSyncInterval
+	}
+	w.seen = make(map[uint64]struct{})
+	w.lastSync = time.Now()
+
+	flags := os.O_RDWR | os.O_CREATE
+	if !opts.Resume {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	idx, err := os.OpenFile(IndexPath(path), flags, 0644)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.file, w.index = f, idx
+
+	if err := w.recover(); err != nil {
+		_ = w.close()
+		return fmt.Errorf("failed to recover the output file %s: %w", path, err)
+	}
+	if w.offset == 0 && header != nil {
+		if err := w.append(header); err != nil {
+			_ = w.close()
+			return err
+		}
+	}
+	// The recovered index entries are flushed along with the header
+	w.dirty = true
+	return w.sync()
+}
+
+// recover truncates the trailing partial record of the output file, loads the index entries of the
+// records that remain, and indexes the records the index had not caught up with.
+func (w *Writer) recover() error {
+	end, err := lastLineEnd(w.file)
+	if err != nil {
+		return err
+	}
+	if err := w.file.Truncate(end); err != nil {
+		return err
+	}
+
+	covered, err := w.loadIndex(end)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(covered, io.SeekStart); err != nil {
+		return err
+	}
+
+	offset := covered
+	r := bufio.NewReader(io.LimitReader(w.file, end-covered))
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+
+		offset += int64(len(line))
+		if name, ok := w.name(bytes.TrimSpace(line)); ok {
+			if err := w.indexName(name, offset); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.offset = end
+	_, err = w.file.Seek(end, io.SeekStart)
+	return err
+}
+
+// lastLineEnd returns the offset following the last newline of the file.
+func lastLineEnd(f *os.File) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 4096)
+	for end := st.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// loadIndex reads the entries of the records ending within the size of the output file, truncates the
+// partial and stale entries, and returns the offset at which the indexed records end.
+func (w *Writer) loadIndex(size int64) (int64, error) {
+	if _, err := w.index.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var covered, valid int64
+	r := bufio.NewReader(w.index)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// The partial entry is left out
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 {
+			break
+		}
+		h, herr := strconv.ParseUint(fields[0], 16, 64)
+		off, oerr := strconv.ParseInt(fields[1], 10, 64)
+		// The entries of the records lost with the output file are dropped, along with those following them
+		if herr != nil || oerr != nil || off <= covered || off > size {
+			break
+		}
+
+		w.seen[h] = struct{}{}
+		covered = off
+		valid += int64(len(line))
+	}
+
+	if err := w.index.Truncate(valid); err != nil {
+		return 0, err
+	}
+	_, err := w.index.Seek(valid, io.SeekStart)
+	return covered, err
+}
+
+func (w *Writer) indexName(name string, end int64) error {
+	h := nameHash(name)
+	w.seen[h] = struct{}{}
+
+	_, err := fmt.Fprintf(w.index, "%016x %d\n", h, end)
+	return err
+}
+
+func (w *Writer) append(data []byte) error {
+	n, err := w.file.Write(data)
+	w.offset += int64(n)
+	w.dirty = true
+	return err
+}
+
+// Write appends the record of the finding, unless its name has already been written, and returns true
+// when the record was written.
+func (w *Writer) Write(o *requests.Output) (bool, error) {
+	if w == nil || o == nil || o.Name == "" {
+		return false, nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if _, found := w.seen[nameHash(o.Name)]; found {
+		return false, nil
+	}
+
+	data, err := w.encode(o)
+	if err != nil {
+		return false, err
+	}
+	// The record is written before its index entry, so the entry never refers to a missing record
+	if err := w.append(data); err != nil {
+		return false, err
+	}
+	if err := w.indexName(o.Name, w.offset); err != nil {
+		return true, err
+	}
+	if time.Since(w.lastSync) >= w.interval {
+		return true, w.sync()
+	}
+	return true, nil
+}
+
+// Written returns true when the record of the name is in the file.
+func (w *Writer) Written(name string) bool {
+	if w == nil {
+		return false
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	_, found := w.seen[nameHash(name)]
+	return found
+}
+
+// Len returns the number of names written to the file, including those of the resumed runs.
+func (w *Writer) Len() int {
+	if w == nil {
+		return 0
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	return len(w.seen)
+}
+
+// Sync flushes the file and its index to the disk.
+func (w *Writer) Sync() error {
+	if w == nil {
+		return nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	return w.sync()
+}
+
+func (w *Writer) sync() error {
+	w.lastSync = time.Now()
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	// The records reach the disk before the index entries that refer to them
+	return firstError(w.file.Sync(), w.index.Sync())
+}
+
+// Close flushes the file and its index, and closes them.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	return firstError(w.sync(), w.close())
+}
+
+func (w *Writer) close() error {
+	return firstError(w.file.Close(), w.index.Close())
+}
+
+// firstError returns the first of the errors that is not nil, once all of them have been produced.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package incremental
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+func testOutput(i int) *requests.Output {
+	return &requests.Output{
+		Name:   fmt.Sprintf("host%d.owasp.org", i),
+		Domain: "owasp.org",
+		Addresses: []requests.AddressInfo{{
+			Address: net.IPv4(192, 0, 2, byte(i)),
+			CIDRStr: "192.0.2.0/24",
+			ASN:     64496,
+		}},
+		Sources:    []string{"crtsh", "DNS"},
+		Confidence: 0.75,
+	}
+}
+
+type newWriter func(path string, opts Options) (*Writer, error)
+
+var writers = map[string]newWriter{
+	"json": func(path string, opts Options) (*Writer, error) { return NewJSONWriter(path, schema.Latest, opts) },
+	"csv":  NewCSVWriter,
+}
+
+// writeAll writes the findings numbered from first to last, and closes the Writer.
+func writeAll(t *testing.T, nw newWriter, path string, opts Options, first, last int) {
+	w, err := nw(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := first; i <= last; i++ {
+		if _, err := w.Write(testOutput(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sortedLines returns the lines of the file, which must all be complete records, in sorted order.
+func sortedLines(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		t.Errorf("the file %s ends with a partial record", filepath.Base(path))
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestWriterDedupe(t *testing.T) {
+	for format, nw := range writers {
+		path := filepath.Join(t.TempDir(), "findings."+format)
+
+		w, err := nw(path, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, expected := range []bool{true, true, false} {
+			written, err := w.Write(testOutput(i%2 + 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != expected {
+				t.Errorf("%s: the record %d was written %v", format, i, written)
+			}
+		}
+		// The names are compared regardless of their case
+		if written, _ := w.Write(&requests.Output{Name: "HOST2.owasp.org"}); written || !w.Written("host2.owasp.org") {
+			t.Errorf("%s: the name was written twice", format)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		records := 2
+		if format == "csv" {
+			records++
+		}
+		if lines := sortedLines(t, path); len(lines) != records {
+			t.Errorf("%s: the file held %d lines: %v", format, len(lines), lines)
+		}
+	}
+}
+
+func TestCSVRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "findings.csv")
+	writeAll(t, NewCSVWriter, path, Options{}, 1, 1)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		CSVHeader,
+		{"host1.owasp.org", "owasp.org", "192.0.2.1", "64496", "192.0.2.0/24", "crtsh;DNS", "0.75"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("the records were %v, expected %v", records, expected)
+	}
+}
+
+func TestResumeRecoversPartialRecord(t *testing.T) {
+	for format, nw := range writers {
+		dir := t.TempDir()
+		uninterrupted := filepath.Join(dir, "uninterrupted."+format)
+		writeAll(t, nw, uninterrupted, Options{}, 1, 10)
+
+		path := filepath.Join(dir, "resumed."+format)
+		writeAll(t, nw, path, Options{}, 1, 6)
+		// The process died while writing a record, before the index caught up with the last two records
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.WriteString(`"host7.owasp.org","owa`)
+		f.Close()
+
+		idx, err := os.ReadFile(IndexPath(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := strings.SplitAfter(string(idx), "\n")
+		partial := strings.Join(entries[:4], "") + entries[4][:5]
+		if err := os.WriteFile(IndexPath(path), []byte(partial), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := nw(path, Options{Resume: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Len() != 6 || !w.Written("host6.owasp.org") || w.Written("host7.owasp.org") {
+			t.Errorf("%s: the resumed run knew %d names", format, w.Len())
+		}
+		for i := 1; i <= 10; i++ {
+			written, err := w.Write(testOutput(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != (i > 6) {
+				t.Errorf("%s: the record of host%d was written %v", format, i, written)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, expected := sortedLines(t, path), sortedLines(t, uninterrupted); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: the resumed output differed:\n%v\nexpected:\n%v", format, got, expected)
+		}
+		// The index is complete again, so another resume knows every name
+		w, err = nw(path, Options{Resume: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Len() != 10 {
+			t.Errorf("%s: the index held %d names", format, w.Len())
+		}
+		_ = w.Close()
+	}
+}
+
+func TestCSVHeaderOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "findings.csv")
+	// The process died while writing the header
+	if err := os.WriteFile(path, []byte("name,dom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeAll(t, NewCSVWriter, path, Options{Resume: true}, 1, 2)
+	writeAll(t, NewCSVWriter, path, Options{Resume: true}, 2, 3)
+	writeAll(t, NewCSVWriter, path, Options{Resume: true}, 1, 3)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("name,domain,")); n != 1 || !bytes.HasPrefix(data, []byte("name,domain,")) {
+		t.Errorf("the header was written %d times:\n%s", n, data)
+	}
+	if lines := sortedLines(t, path); len(lines) != 4 {
+		t.Errorf("the file held %d lines", len(lines))
+	}
+
+	// A run that is not resumed starts the file over
+	writeAll(t, NewCSVWriter, path, Options{}, 5, 5)
+	if lines := sortedLines(t, path); len(lines) != 2 {
+		t.Errorf("the file held %d lines after it was started over", len(lines))
+	}
+}
+
+const (
+	helperEnv   = "AMASS_INCREMENTAL_HELPER"
+	helperNames = 200
+)
+
+// TestHelperWriter is run in a child process by TestKillAndResume, and writes the findings until it is killed.
+func TestHelperWriter(t *testing.T) {
+	spec := os.Getenv(helperEnv)
+	if spec == "" {
+		t.Skip("only run by TestKillAndResume")
+	}
+
+	format, path, _ := strings.Cut(spec, ":")
+	w, err := writers[format](path, Options{SyncInterval: time.Millisecond})
+	if err != nil {
+		os.Exit(1)
+	}
+	for i := 1; i <= helperNames; i++ {
+		_, _ = w.Write(testOutput(i))
+		time.Sleep(time.Millisecond)
+	}
+	// The Writer is never closed, and the process waits to be killed
+	time.Sleep(time.Minute)
+	os.Exit(1)
+}
+
+func TestKillAndResume(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the child process is not run in short mode")
+	}
+
+	for format, nw := range writers {
+		dir := t.TempDir()
+		uninterrupted := filepath.Join(dir, "uninterrupted."+format)
+		writeAll(t, nw, uninterrupted, Options{}, 1, helperNames)
+
+		path := filepath.Join(dir, "killed."+format)
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperWriter$")
+		cmd.Env = append(os.Environ(), helperEnv+"="+format+":"+path)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// The child is killed once it has written a part of the findings
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if st, err := os.Stat(path); err == nil && st.Size() > 4096 {
+				break
+			}
+		}
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+
+		w, err := nw(path, Options{Resume: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Len() == 0 || w.Len() == helperNames {
+			t.Logf("%s: the child wrote %d of the %d names before it was killed", format, w.Len(), helperNames)
+		}
+		for i := 1; i <= helperNames; i++ {
+			if _, err := w.Write(testOutput(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, expected := sortedLines(t, path), sortedLines(t, uninterrupted)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: the resumed output had %d lines, expected %d", format, len(got), len(expected))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package format
+
+import (
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// MergeOutputs combines the findings read from several graph databases into a single record for each name.
+// The record with the most addresses is kept, and the earlier sets win when records are equally complete.
+// The mismatches hold the number of names that each set was missing or had fewer addresses for than another set.
+func MergeOutputs(sets ...[]*requests.Output) (merged []*requests.Output, mismatches []int) {
+	mismatches = make([]int, len(sets))
+	if len(sets) == 1 {
+		return sets[0], mismatches
+	}
+
+	best := make(map[string]*requests.Output)
+	found := make(map[string][]*requests.Output)
+	var names []string
+	for i, set := range sets {
+		for _, o := range set {
+			name := strings.ToLower(o.Name)
+
+			records, seen := found[name]
+			if !seen {
+				records = make([]*requests.Output, len(sets))
+				names = append(names, name)
+			}
+			if records[i] == nil || len(o.Addresses) > len(records[i].Addresses) {
+				records[i] = o
+			}
+			found[name] = records
+
+			if b, ok := best[name]; !ok || len(o.Addresses) > len(b.Addresses) {
+				best[name] = o
+			}
+		}
+	}
+
+	for _, name := range names {
+		b := best[name]
+
+		for i, o := range found[name] {
+			if o == nil || len(o.Addresses) < len(b.Addresses) {
+				mismatches[i]++
+			}
+		}
+		merged = append(merged, b)
+	}
+	return merged, mismatches
+}
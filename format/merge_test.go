@@ -0,0 +1,67 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package format
+
+import (
+	"net"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+func output(name string, addrs ...string) *requests.Output {
+	o := &requests.Output{Name: name, Domain: "owasp.org"}
+
+	for _, addr := range addrs {
+		o.Addresses = append(o.Addresses, requests.AddressInfo{Address: net.ParseIP(addr)})
+	}
+	return o
+}
+
+func TestMergeOutputs(t *testing.T) {
+	primary := []*requests.Output{
+		output("www.owasp.org"),
+		output("ftp.owasp.org", "192.0.2.2"),
+		output("mail.owasp.org", "192.0.2.3"),
+	}
+	secondary := []*requests.Output{
+		output("WWW.owasp.org", "192.0.2.1"),
+		output("ftp.owasp.org", "192.0.2.20"),
+		output("vpn.owasp.org", "192.0.2.4"),
+	}
+
+	merged, mismatches := MergeOutputs(primary, secondary)
+	if len(merged) != 4 {
+		t.Fatalf("MergeOutputs returned %d names, expected 4", len(merged))
+	}
+
+	expected := []struct {
+		name string
+		addr string
+	}{
+		{"WWW.owasp.org", "192.0.2.1"},
+		{"ftp.owasp.org", "192.0.2.2"},
+		{"mail.owasp.org", "192.0.2.3"},
+		{"vpn.owasp.org", "192.0.2.4"},
+	}
+	for i, e := range expected {
+		if o := merged[i]; o.Name != e.name || len(o.Addresses) != 1 || o.Addresses[0].Address.String() != e.addr {
+			t.Errorf("the merged record %d is %s %v, expected %s [%s]", i, o.Name, o.Addresses, e.name, e.addr)
+		}
+	}
+	// The primary lacks the addresses of www and the name vpn, while the secondary lacks the name mail
+	if mismatches[0] != 2 || mismatches[1] != 1 {
+		t.Errorf("MergeOutputs counted the mismatches %v, expected [2 1]", mismatches)
+	}
+}
+
+func TestMergeOutputsSingleSet(t *testing.T) {
+	set := []*requests.Output{output("www.owasp.org"), output("ftp.owasp.org")}
+
+	merged, mismatches := MergeOutputs(set)
+	if len(merged) != 2 || len(mismatches) != 1 || mismatches[0] != 0 {
+		t.Errorf("MergeOutputs changed a single set: %v %v", merged, mismatches)
+	}
+}
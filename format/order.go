@@ -0,0 +1,61 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package format
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// SortOutputs orders the findings by their normalized names, along with the addresses of each finding, so
+// exporting the same event again produces the same files regardless of the order the graph returned them in.
+func SortOutputs(outputs []*requests.Output) {
+	for _, o := range outputs {
+		if o == nil {
+			continue
+		}
+
+		sort.SliceStable(o.Addresses, func(i, j int) bool {
+			return compareAddrs(o.Addresses[i].Address, o.Addresses[j].Address) < 0
+		})
+		sort.SliceStable(o.Historical, func(i, j int) bool {
+			a, b := o.Historical[i], o.Historical[j]
+			if c := compareAddrs(net.ParseIP(a.Address), net.ParseIP(b.Address)); c != 0 {
+				return c < 0
+			}
+			return a.FirstSeen.Before(b.FirstSeen)
+		})
+	}
+
+	sort.SliceStable(outputs, func(i, j int) bool {
+		return outputName(outputs[i]) < outputName(outputs[j])
+	})
+}
+
+func outputName(o *requests.Output) string {
+	if o == nil {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(o.Name, "."))
+}
+
+// compareAddrs orders the IPv4 addresses before the IPv6 addresses, and each family numerically.
+func compareAddrs(a, b net.IP) int {
+	a4, b4 := a.To4(), b.To4()
+
+	switch {
+	case a4 != nil && b4 == nil:
+		return -1
+	case a4 == nil && b4 != nil:
+		return 1
+	case a4 != nil:
+		return bytes.Compare(a4, b4)
+	}
+	return bytes.Compare(a.To16(), b.To16())
+}
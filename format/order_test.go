@@ -0,0 +1,78 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package format
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// fixtureEvent returns the findings of the same event in the order the graph returned them.
+func fixtureEvent(reversed bool) []*requests.Output {
+	outputs := []*requests.Output{
+		output("www.owasp.org", "192.0.2.10", "2001:db8::1", "192.0.2.9"),
+		output("api.owasp.org", "192.0.2.2"),
+		output("Mail.owasp.org."),
+		output("ftp.owasp.org", "2001:db8::2", "192.0.2.3"),
+	}
+	if !reversed {
+		return outputs
+	}
+
+	for i, j := 0, len(outputs)-1; i < j; i, j = i+1, j-1 {
+		outputs[i], outputs[j] = outputs[j], outputs[i]
+	}
+	for _, o := range outputs {
+		for i, j := 0, len(o.Addresses)-1; i < j; i, j = i+1, j-1 {
+			o.Addresses[i], o.Addresses[j] = o.Addresses[j], o.Addresses[i]
+		}
+	}
+	return outputs
+}
+
+func encodeEvent(t *testing.T, outputs []*requests.Output) []byte {
+	var buf bytes.Buffer
+
+	enc, err := schema.NewEncoder(&buf, schema.Latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range outputs {
+		if err := enc.Encode(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestSortOutputs(t *testing.T) {
+	first, second := fixtureEvent(false), fixtureEvent(true)
+	SortOutputs(first)
+	SortOutputs(second)
+
+	if a, b := encodeEvent(t, first), encodeEvent(t, second); !bytes.Equal(a, b) {
+		t.Errorf("the exports of the same event differed:\n%s\n%s", a, b)
+	}
+
+	var names []string
+	for _, o := range first {
+		names = append(names, o.Name)
+	}
+	if expected := []string{"api.owasp.org", "ftp.owasp.org", "Mail.owasp.org.", "www.owasp.org"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("the names were sorted as %v, expected %v", names, expected)
+	}
+
+	var addrs []string
+	for _, a := range first[3].Addresses {
+		addrs = append(addrs, a.Address.String())
+	}
+	if len(addrs) != 3 || addrs[0] != "192.0.2.9" || addrs[1] != "192.0.2.10" || addrs[2] != "2001:db8::1" {
+		t.Errorf("the addresses were sorted as %v", addrs)
+	}
+}
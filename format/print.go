@@ -201,6 +201,8 @@ func OutputLineParts(out *requests.Output, addrs, demo bool) (name, ips string)
 	name = out.Name
 	if demo {
 		name = censorDomain(name)
+	} else if out.DisplayName != "" && out.DisplayName != out.Name {
+		name += " [" + out.DisplayName + "]"
 	}
 	return
 }
@@ -0,0 +1,113 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package schema holds the versioned JSON records of the findings, which every JSON emitter writes, so the
+// field names seen by the downstream consumers only change with a new version. A version is never changed
+// once released: the changes are made in a new version, while the previous ones remain selectable by the
+// 'output_schema' option.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// V1 is the first version of the schema.
+const V1 = 1
+
+// Latest is the version written when none has been configured.
+const Latest = V1
+
+// converters build the record of each version from the findings of the enumeration.
+var converters = map[int]func(o *requests.Output) interface{}{
+	V1: func(o *requests.Output) interface{} { return FromOutputV1(o) },
+}
+
+// Versions returns the versions of the schema that can be written.
+func Versions() []int {
+	versions := make([]int, 0, len(converters))
+	for v := range converters {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// UnknownVersionError is returned for a version of the schema that does not exist.
+type UnknownVersionError struct {
+	Version int
+}
+
+func (e *UnknownVersionError) Error() string {
+	return fmt.Sprintf("the output schema version %d does not exist, the versions are %v", e.Version, Versions())
+}
+
+// VersionFromConfig returns the version selected by the 'output_schema' option, or the latest version
+// when none has been configured.
+func VersionFromConfig(cfg *config.Config) (int, error) {
+	if cfg == nil || cfg.Options == nil {
+		return Latest, nil
+	}
+
+	var version int
+	switch v := cfg.Options["output_schema"].(type) {
+	case nil:
+		return Latest, nil
+	case int:
+		version = v
+	case int64:
+		version = int(v)
+	case float64:
+		version = int(v)
+	default:
+		return 0, fmt.Errorf("the output schema version %v is not a number", v)
+	}
+
+	if _, found := converters[version]; !found {
+		return 0, &UnknownVersionError{Version: version}
+	}
+	return version, nil
+}
+
+// Convert returns the record of the finding in the version of the schema.
+func Convert(version int, o *requests.Output) (interface{}, error) {
+	conv, found := converters[version]
+	if !found {
+		return nil, &UnknownVersionError{Version: version}
+	}
+	return conv(o), nil
+}
+
+// Encoder writes the findings as JSON Lines records of a version of the schema.
+type Encoder struct {
+	version int
+	enc     *json.Encoder
+}
+
+// NewEncoder returns the Encoder writing the records of the version to w.
+func NewEncoder(w io.Writer, version int) (*Encoder, error) {
+	if _, found := converters[version]; !found {
+		return nil, &UnknownVersionError{Version: version}
+	}
+	return &Encoder{version: version, enc: json.NewEncoder(w)}, nil
+}
+
+// Version returns the version of the schema written by the Encoder.
+func (e *Encoder) Version() int {
+	return e.version
+}
+
+// Encode writes the record of the finding, followed by a newline.
+func (e *Encoder) Encode(o *requests.Output) error {
+	rec, err := Convert(e.version, o)
+	if err != nil {
+		return err
+	}
+	return e.enc.Encode(rec)
+}
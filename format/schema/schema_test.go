@@ -0,0 +1,172 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// fixtureOutputs returns a finding with every field set, so a renamed field shows in the golden files,
+// and a finding with the least the enumeration provides.
+func fixtureOutputs() []*requests.Output {
+	first := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	last := first.Add(30 * 24 * time.Hour)
+
+	return []*requests.Output{
+		{
+			Name:        "xn--bcher-kva.owasp.org",
+			DisplayName: "bücher.owasp.org",
+			Domain:      "owasp.org",
+			Addresses: []requests.AddressInfo{{
+				Address:       net.ParseIP("192.0.2.1"),
+				CIDRStr:       "192.0.2.0/24",
+				ASN:           64496,
+				Description:   "EXAMPLE-NET",
+				Country:       "US",
+				Region:        "California",
+				Authoritative: true,
+			}},
+			Parent:         "owasp.org",
+			Derivation:     "cname",
+			Evidence:       []string{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+			FirstSeen:      &first,
+			LastSeen:       &last,
+			Historical:     []requests.Resolution{{Address: "198.51.100.1", FirstSeen: first, LastSeen: last}},
+			Provider:       "Example Cloud",
+			Service:        "CDN",
+			Confidence:     0.8,
+			Sources:        []string{"Crtsh", "DNS"},
+			Update:         true,
+			CarriedForward: true,
+		},
+		{Name: "www.owasp.org", Domain: "owasp.org"},
+	}
+}
+
+func TestGoldenFiles(t *testing.T) {
+	for _, version := range Versions() {
+		var buf bytes.Buffer
+		enc, err := NewEncoder(&buf, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range fixtureOutputs() {
+			if err := enc.Encode(o); err != nil {
+				t.Fatalf("Failed to encode the version %d record: %v", version, err)
+			}
+		}
+
+		golden := filepath.Join("testdata", fmt.Sprintf("v%d.jsonl", version))
+		if *update {
+			if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatalf("Failed to update the golden file: %v", err)
+			}
+		}
+
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("Failed to read the golden file of version %d: %v", version, err)
+		}
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("The version %d records do not match the golden file:\n%s", version, buf.String())
+		}
+	}
+}
+
+// TestGoldenCoversFields ensures the golden files hold every field of each version, so a field cannot be
+// renamed without a golden file noticing.
+func TestGoldenCoversFields(t *testing.T) {
+	// The records of each version, and the records nested within them by their field
+	records := map[int]map[string]interface{}{
+		V1: {"": FindingV1{}, "addresses": AddressV1{}, "historical_addresses": ResolutionV1{}},
+	}
+	if len(records) != len(Versions()) {
+		t.Fatalf("the fields of the versions %v are not all checked", Versions())
+	}
+
+	for version, types := range records {
+		data, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("v%d.jsonl", version)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var full map[string]interface{}
+		if err := json.Unmarshal(bytes.SplitN(data, []byte("\n"), 2)[0], &full); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := full["schemaVersion"].(float64); !ok || int(v) != version {
+			t.Errorf("the version %d record carries the schemaVersion %v", version, full["schemaVersion"])
+		}
+
+		for field, rec := range types {
+			obj := full
+			if field != "" {
+				list, _ := full[field].([]interface{})
+				if len(list) == 0 {
+					t.Errorf("the version %d golden file has no %s", version, field)
+					continue
+				}
+				obj, _ = list[0].(map[string]interface{})
+			}
+			for _, key := range jsonKeys(reflect.TypeOf(rec)) {
+				if _, found := obj[key]; !found {
+					t.Errorf("the version %d golden file is missing the field %s of %T", version, key, rec)
+				}
+			}
+		}
+	}
+}
+
+func jsonKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		if key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestVersionFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if v, err := VersionFromConfig(cfg); err != nil || v != Latest {
+		t.Errorf("the default version was %d, %v", v, err)
+	}
+
+	cfg.Options = map[string]interface{}{"output_schema": 1}
+	if v, err := VersionFromConfig(cfg); err != nil || v != V1 {
+		t.Errorf("the configured version was %d, %v", v, err)
+	}
+
+	cfg.Options = map[string]interface{}{"output_schema": 99}
+	var uerr *UnknownVersionError
+	if _, err := VersionFromConfig(cfg); !errors.As(err, &uerr) || uerr.Version != 99 {
+		t.Errorf("the unknown version returned the error %v", err)
+	}
+	if _, err := NewEncoder(&bytes.Buffer{}, 99); err == nil {
+		t.Error("an encoder was returned for the unknown version")
+	}
+
+	cfg.Options = map[string]interface{}{"output_schema": "latest"}
+	if _, err := VersionFromConfig(cfg); err == nil {
+		t.Error("the version that is not a number was accepted")
+	}
+}
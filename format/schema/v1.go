@@ -0,0 +1,104 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package schema
+
+import (
+	"time"
+
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// FindingV1 is the record of a name found by the enumeration in the first version of the schema.
+type FindingV1 struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Name          string      `json:"name"`
+	DisplayName   string      `json:"display_name,omitempty"`
+	Domain        string      `json:"domain"`
+	Addresses     []AddressV1 `json:"addresses"`
+	Parent        string      `json:"parent,omitempty"`
+	Derivation    string      `json:"derivation,omitempty"`
+	Evidence      []string    `json:"evidence,omitempty"`
+	FirstSeen     *time.Time  `json:"first_seen,omitempty"`
+	LastSeen      *time.Time  `json:"last_seen,omitempty"`
+	// Historical holds the addresses observed by the passive DNS sensors, which did not resolve during the enumeration
+	Historical     []ResolutionV1 `json:"historical_addresses,omitempty"`
+	Provider       string         `json:"provider,omitempty"`
+	Service        string         `json:"service,omitempty"`
+	Confidence     float64        `json:"confidence,omitempty"`
+	Sources        []string       `json:"sources,omitempty"`
+	Update         bool           `json:"update,omitempty"`
+	CarriedForward bool           `json:"carried_forward,omitempty"`
+}
+
+// AddressV1 is an address of the name in the first version of the schema.
+type AddressV1 struct {
+	IP            string `json:"ip"`
+	CIDR          string `json:"cidr"`
+	ASN           int    `json:"asn"`
+	Description   string `json:"desc"`
+	Country       string `json:"country,omitempty"`
+	Region        string `json:"region,omitempty"`
+	Authoritative bool   `json:"authoritative,omitempty"`
+}
+
+// ResolutionV1 is a period the name resolved to the address in the first version of the schema.
+type ResolutionV1 struct {
+	IP        string     `json:"ip"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+// FromOutputV1 converts the finding of the enumeration into the record of the first version of the schema.
+func FromOutputV1(o *requests.Output) *FindingV1 {
+	f := &FindingV1{
+		SchemaVersion:  V1,
+		Name:           o.Name,
+		DisplayName:    o.DisplayName,
+		Domain:         o.Domain,
+		Addresses:      []AddressV1{},
+		Parent:         o.Parent,
+		Derivation:     o.Derivation,
+		Evidence:       o.Evidence,
+		FirstSeen:      o.FirstSeen,
+		LastSeen:       o.LastSeen,
+		Provider:       o.Provider,
+		Service:        o.Service,
+		Confidence:     o.Confidence,
+		Sources:        o.Sources,
+		Update:         o.Update,
+		CarriedForward: o.CarriedForward,
+	}
+
+	for _, a := range o.Addresses {
+		var ip string
+		if a.Address != nil {
+			ip = a.Address.String()
+		}
+		f.Addresses = append(f.Addresses, AddressV1{
+			IP:            ip,
+			CIDR:          a.CIDRStr,
+			ASN:           a.ASN,
+			Description:   a.Description,
+			Country:       a.Country,
+			Region:        a.Region,
+			Authoritative: a.Authoritative,
+		})
+	}
+	for _, r := range o.Historical {
+		f.Historical = append(f.Historical, ResolutionV1{
+			IP:        r.Address,
+			FirstSeen: timePtr(r.FirstSeen),
+			LastSeen:  timePtr(r.LastSeen),
+		})
+	}
+	return f
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,371 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package stix
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/google/uuid"
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// SpecVersion is the version of the STIX specification implemented by the exporter.
+const SpecVersion = "2.1"
+
+// timestampFormat is the STIX timestamp representation with millisecond precision.
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// scoNamespace is the UUIDv5 namespace defined by the specification for deterministic identifiers.
+var scoNamespace = uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+
+// Event identifies the enumeration findings that are exported into the bundle.
+type Event struct {
+	Domains []string
+	// Start is the collection start time, which filters the findings and timestamps the objects
+	Start time.Time
+	// Evidence returns the hashes of the evidence stored for a name when set
+	Evidence func(name string) []string
+	// Metadata holds the settings of the event, such as the seed that allows it to be reproduced
+	Metadata map[string]string
+}
+
+type object interface {
+	identifier() string
+	objectType() string
+	validate() error
+}
+
+type observable struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value,omitempty"`
+	Number      int    `json:"number,omitempty"`
+	Name        string `json:"name,omitempty"`
+	// Evidence is a custom property referencing the data source responses that yielded the name
+	Evidence []string `json:"x_amass_evidence,omitempty"`
+}
+
+func (o *observable) identifier() string { return o.ID }
+
+func (o *observable) objectType() string { return o.Type }
+
+func (o *observable) validate() error {
+	if o.Type == "" || o.SpecVersion == "" || !strings.HasPrefix(o.ID, o.Type+"--") {
+		return fmt.Errorf("the %s observable is missing common properties", o.Type)
+	}
+	if o.Type == "autonomous-system" {
+		if o.Number <= 0 {
+			return errors.New("the autonomous-system observable requires a number")
+		}
+	} else if o.Value == "" {
+		return fmt.Errorf("the %s observable requires a value", o.Type)
+	}
+	return nil
+}
+
+type relationship struct {
+	Type             string `json:"type"`
+	SpecVersion      string `json:"spec_version"`
+	ID               string `json:"id"`
+	Created          string `json:"created"`
+	Modified         string `json:"modified"`
+	RelationshipType string `json:"relationship_type"`
+	SourceRef        string `json:"source_ref"`
+	TargetRef        string `json:"target_ref"`
+}
+
+func (r *relationship) identifier() string { return r.ID }
+
+func (r *relationship) objectType() string { return r.Type }
+
+func (r *relationship) validate() error {
+	if r.Type != "relationship" || r.SpecVersion == "" || !strings.HasPrefix(r.ID, "relationship--") ||
+		r.Created == "" || r.Modified == "" || r.RelationshipType == "" || r.SourceRef == "" || r.TargetRef == "" {
+		return fmt.Errorf("the relationship %s is missing required properties", r.ID)
+	}
+	return nil
+}
+
+type grouping struct {
+	Type        string   `json:"type"`
+	SpecVersion string   `json:"spec_version"`
+	ID          string   `json:"id"`
+	Created     string   `json:"created"`
+	Modified    string   `json:"modified"`
+	Name        string   `json:"name"`
+	Context     string   `json:"context"`
+	ObjectRefs  []string `json:"object_refs"`
+	// Metadata is a custom property holding the settings of the enumeration event
+	Metadata map[string]string `json:"x_amass_metadata,omitempty"`
+}
+
+func (g *grouping) identifier() string { return g.ID }
+
+func (g *grouping) objectType() string { return g.Type }
+
+func (g *grouping) validate() error {
+	if g.Type != "grouping" || g.SpecVersion == "" || !strings.HasPrefix(g.ID, "grouping--") ||
+		g.Created == "" || g.Modified == "" || g.Context == "" || len(g.ObjectRefs) == 0 {
+		return fmt.Errorf("the grouping %s is missing required properties", g.ID)
+	}
+	return nil
+}
+
+// bundle collects the objects exported from the graph, keyed by their identifiers.
+type bundle struct {
+	created string
+	objects map[string]object
+	// keys holds the normalized name of each object, which orders the objects of the same type
+	keys     map[string]string
+	evidence func(name string) []string
+}
+
+// WriteBundle streams the findings of the enumeration event within the graph as a STIX 2.1 bundle.
+// Identifiers are deterministic, so exporting the same findings again does not duplicate objects.
+func WriteBundle(ctx context.Context, w io.Writer, g *netmap.Graph, event Event) error {
+	if g == nil || g.DB == nil {
+		return errors.New("WriteBundle: the graph has not been initialized")
+	}
+	if len(event.Domains) == 0 {
+		return errors.New("WriteBundle: no domain names were provided")
+	}
+
+	b := &bundle{
+		created:  event.Start.UTC().Format(timestampFormat),
+		objects:  make(map[string]object),
+		keys:     make(map[string]string),
+		evidence: event.Evidence,
+	}
+	if err := b.collect(ctx, g, event); err != nil {
+		return fmt.Errorf("WriteBundle: %v", err)
+	}
+
+	var ids []string
+	for id := range b.objects {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errors.New("WriteBundle: no findings were discovered for the event")
+	}
+	// The objects are ordered by their type and name, so the bundle does not depend on the order of the graph
+	sort.Slice(ids, func(i, j int) bool {
+		ti, tj := b.objects[ids[i]].objectType(), b.objects[ids[j]].objectType()
+		if ti != tj {
+			return ti < tj
+		}
+		if ki, kj := b.keys[ids[i]], b.keys[ids[j]]; ki != kj {
+			return ki < kj
+		}
+		return ids[i] < ids[j]
+	})
+
+	domains := append([]string(nil), event.Domains...)
+	sort.Strings(domains)
+	group := &grouping{
+		Type:        "grouping",
+		SpecVersion: SpecVersion,
+		ID:          "grouping--" + deterministicID(`{"domains":"`+strings.Join(domains, ",")+`","start":"`+b.created+`"}`),
+		Created:     b.created,
+		Modified:    b.created,
+		Name:        "Amass enumeration of " + strings.Join(domains, ", "),
+		Context:     "unspecified",
+		ObjectRefs:  ids,
+		Metadata:    event.Metadata,
+	}
+
+	return b.write(w, "bundle--"+deterministicID(group.ID), append([]object{group}, b.sorted(ids)...))
+}
+
+func (b *bundle) sorted(ids []string) []object {
+	objs := make([]object, 0, len(ids))
+
+	for _, id := range ids {
+		objs = append(objs, b.objects[id])
+	}
+	return objs
+}
+
+func (b *bundle) write(w io.Writer, id string, objs []object) error {
+	if _, err := fmt.Fprintf(w, `{"type":"bundle","id":%q,"objects":[`, id); err != nil {
+		return fmt.Errorf("WriteBundle: %v", err)
+	}
+
+	for i, obj := range objs {
+		if err := obj.validate(); err != nil {
+			return fmt.Errorf("WriteBundle: %v", err)
+		}
+
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("WriteBundle: %v", err)
+		}
+		if i > 0 {
+			data = append([]byte(","), data...)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("WriteBundle: %v", err)
+		}
+	}
+
+	if _, err := io.WriteString(w, "]}\n"); err != nil {
+		return fmt.Errorf("WriteBundle: %v", err)
+	}
+	return nil
+}
+
+func (b *bundle) collect(ctx context.Context, g *netmap.Graph, event Event) error {
+	since := event.Start.UTC()
+
+	for _, d := range event.Domains {
+		it := cursor.NamesIterator(ctx, g, since, d)
+
+		for it.Next() {
+			a := it.Value()
+			src := b.domainName(it.Name())
+
+			rels, err := g.DB.OutgoingRelations(a, since, "a_record", "aaaa_record", "cname_record")
+			if err != nil {
+				continue
+			}
+			for _, rel := range rels {
+				to, err := g.DB.FindById(rel.ToAsset.ID, since)
+				if err != nil {
+					continue
+				}
+
+				switch v := to.Asset.(type) {
+				case domain.FQDN:
+					b.relate(src, "resolves-to", b.domainName(v.Name))
+				case network.IPAddress:
+					addr := b.ipAddress(v.Address.String())
+
+					b.relate(src, "resolves-to", addr)
+					b.addressInfrastructure(ctx, g, to, addr, since)
+				}
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addressInfrastructure adds the autonomous systems announcing the netblocks that contain the address.
+func (b *bundle) addressInfrastructure(ctx context.Context, g *netmap.Graph, addr *types.Asset, addrID string, since time.Time) {
+	nbrels, err := g.DB.IncomingRelations(addr, since, "contains")
+	if err != nil {
+		return
+	}
+
+	for _, nbrel := range nbrels {
+		nb, err := g.DB.FindById(nbrel.FromAsset.ID, since)
+		if err != nil {
+			continue
+		}
+
+		asrels, err := g.DB.IncomingRelations(nb, since, "announces")
+		if err != nil {
+			continue
+		}
+		for _, asrel := range asrels {
+			as, err := g.DB.FindById(asrel.FromAsset.ID, since)
+			if err != nil {
+				continue
+			}
+
+			if asn, ok := as.Asset.(network.AutonomousSystem); ok && asn.Number > 0 {
+				desc := g.ReadASDescription(ctx, asn.Number, since)
+
+				b.relate(addrID, "belongs-to", b.autonomousSystem(asn.Number, desc))
+			}
+		}
+	}
+}
+
+func (b *bundle) domainName(name string) string {
+	o := &observable{
+		Type:        "domain-name",
+		SpecVersion: SpecVersion,
+		Value:       strings.ToLower(name),
+	}
+	if b.evidence != nil {
+		o.Evidence = b.evidence(o.Value)
+	}
+	o.ID = o.Type + "--" + deterministicID(`{"value":`+strconv.Quote(o.Value)+`}`)
+	return b.add(o, o.Value)
+}
+
+func (b *bundle) ipAddress(addr string) string {
+	o := &observable{
+		Type:        "ipv4-addr",
+		SpecVersion: SpecVersion,
+		Value:       addr,
+	}
+	key := addr
+	if ip := net.ParseIP(addr); ip != nil {
+		if ip.To4() == nil {
+			o.Type = "ipv6-addr"
+		}
+		// The addresses are ordered numerically
+		key = hex.EncodeToString(ip.To16())
+	}
+	o.ID = o.Type + "--" + deterministicID(`{"value":`+strconv.Quote(o.Value)+`}`)
+	return b.add(o, key)
+}
+
+func (b *bundle) autonomousSystem(asn int, desc string) string {
+	o := &observable{
+		Type:        "autonomous-system",
+		SpecVersion: SpecVersion,
+		Number:      asn,
+		Name:        desc,
+	}
+	o.ID = o.Type + "--" + deterministicID(`{"number":`+strconv.Itoa(asn)+`}`)
+	return b.add(o, fmt.Sprintf("%010d", asn))
+}
+
+func (b *bundle) relate(source, rtype, target string) {
+	b.add(&relationship{
+		Type:             "relationship",
+		SpecVersion:      SpecVersion,
+		ID:               "relationship--" + deterministicID(source+"|"+rtype+"|"+target),
+		Created:          b.created,
+		Modified:         b.created,
+		RelationshipType: rtype,
+		SourceRef:        source,
+		TargetRef:        target,
+	}, b.keys[source]+"|"+rtype+"|"+b.keys[target])
+}
+
+func (b *bundle) add(obj object, key string) string {
+	id := obj.identifier()
+
+	if _, found := b.objects[id]; !found {
+		b.objects[id] = obj
+		b.keys[id] = key
+	}
+	return id
+}
+
+// deterministicID returns the UUIDv5 generated from the canonical representation of an object.
+func deterministicID(canonical string) string {
+	return uuid.NewSHA1(scoNamespace, []byte(canonical)).String()
+}
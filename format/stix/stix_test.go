@@ -0,0 +1,206 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package stix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func fixtureGraph(t *testing.T) *netmap.Graph {
+	g := netmap.NewGraph("memory", "", "")
+	if g == nil {
+		t.Fatal("Failed to create the graph")
+	}
+
+	ctx := context.Background()
+	if err := g.UpsertA(ctx, "www.owasp.org", "192.168.1.1"); err != nil {
+		t.Fatalf("Failed to insert the A record: %v", err)
+	}
+	if err := g.UpsertAAAA(ctx, "www.owasp.org", "2001:db8::1"); err != nil {
+		t.Fatalf("Failed to insert the AAAA record: %v", err)
+	}
+	if err := g.UpsertCNAME(ctx, "owasp.org", "www.owasp.org"); err != nil {
+		t.Fatalf("Failed to insert the CNAME record: %v", err)
+	}
+	if err := g.UpsertInfrastructure(ctx, 64496, "OWASP-EXAMPLE", "192.168.1.1", "192.168.1.0/24"); err != nil {
+		t.Fatalf("Failed to insert the infrastructure: %v", err)
+	}
+	return g
+}
+
+func TestWriteBundle(t *testing.T) {
+	g := fixtureGraph(t)
+	defer g.Remove()
+
+	event := Event{
+		Domains: []string{"owasp.org"},
+		Start:   time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBundle(context.Background(), &buf, g, event); err != nil {
+		t.Fatalf("Failed to write the bundle: %v", err)
+	}
+
+	var parsed struct {
+		Type    string                   `json:"type"`
+		Objects []map[string]interface{} `json:"objects"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil || parsed.Type != "bundle" {
+		t.Fatalf("The bundle is not valid JSON: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "bundle.json")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to update the golden file: %v", err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read the golden file: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("The bundle does not match the golden file:\n%s", buf.String())
+	}
+	// Exporting the same findings again must produce the same identifiers
+	var again bytes.Buffer
+	if err := WriteBundle(context.Background(), &again, g, event); err != nil || !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Errorf("The bundle was not deterministic across exports")
+	}
+}
+
+func TestWriteBundleInsertionOrder(t *testing.T) {
+	event := Event{
+		Domains: []string{"owasp.org"},
+		Start:   time.Now().Add(-time.Minute),
+	}
+
+	var exports [][]byte
+	for _, reversed := range []bool{false, true} {
+		g := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+		if g == nil {
+			t.Fatal("Failed to create the graph")
+		}
+
+		ctx := context.Background()
+		inserts := []func() error{
+			func() error { return g.UpsertA(ctx, "www.owasp.org", "192.168.1.10") },
+			func() error { return g.UpsertA(ctx, "www.owasp.org", "192.168.1.9") },
+			func() error { return g.UpsertAAAA(ctx, "api.owasp.org", "2001:db8::1") },
+			func() error { return g.UpsertCNAME(ctx, "owasp.org", "www.owasp.org") },
+			func() error { return g.UpsertA(ctx, "mail.owasp.org", "192.168.1.2") },
+			func() error {
+				return g.UpsertInfrastructure(ctx, 64496, "OWASP-EXAMPLE", "192.168.1.9", "192.168.1.0/24")
+			},
+		}
+		for i := range inserts {
+			if reversed {
+				i = len(inserts) - 1 - i
+			}
+			if err := inserts[i](); err != nil {
+				t.Fatalf("Failed to insert the findings: %v", err)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := WriteBundle(ctx, &buf, g, event); err != nil {
+			t.Fatalf("Failed to write the bundle: %v", err)
+		}
+		exports = append(exports, buf.Bytes())
+		g.Remove()
+	}
+
+	if !bytes.Equal(exports[0], exports[1]) {
+		t.Errorf("The bundles of the same findings inserted in another order differed:\n%s\n%s", exports[0], exports[1])
+	}
+}
+
+func TestWriteBundleEvidence(t *testing.T) {
+	g := fixtureGraph(t)
+	defer g.Remove()
+
+	event := Event{
+		Domains: []string{"owasp.org"},
+		Start:   time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Evidence: func(name string) []string {
+			if name == "www.owasp.org" {
+				return []string{"abc123"}
+			}
+			return nil
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBundle(context.Background(), &buf, g, event); err != nil {
+		t.Fatalf("Failed to write the bundle: %v", err)
+	}
+
+	var parsed struct {
+		Objects []struct {
+			Type     string   `json:"type"`
+			Value    string   `json:"value"`
+			Evidence []string `json:"x_amass_evidence"`
+		} `json:"objects"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("The bundle is not valid JSON: %v", err)
+	}
+
+	for _, obj := range parsed.Objects {
+		if obj.Type != "domain-name" {
+			continue
+		}
+		if obj.Value == "www.owasp.org" && (len(obj.Evidence) != 1 || obj.Evidence[0] != "abc123") {
+			t.Errorf("The domain name %s has the evidence %v", obj.Value, obj.Evidence)
+		} else if obj.Value != "www.owasp.org" && len(obj.Evidence) > 0 {
+			t.Errorf("The domain name %s has the unexpected evidence %v", obj.Value, obj.Evidence)
+		}
+	}
+}
+
+func TestWriteBundleErrors(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WriteBundle(context.Background(), &buf, nil, Event{Domains: []string{"owasp.org"}}); err == nil {
+		t.Errorf("Failed to detect the missing graph")
+	}
+
+	g := fixtureGraph(t)
+	defer g.Remove()
+
+	if err := WriteBundle(context.Background(), &buf, g, Event{}); err == nil {
+		t.Errorf("Failed to detect the missing domain names")
+	}
+	if err := WriteBundle(context.Background(), &buf, g, Event{Domains: []string{"example.com"}}); err == nil {
+		t.Errorf("Failed to detect the event without findings")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, obj := range []object{
+		&observable{Type: "domain-name", SpecVersion: SpecVersion, ID: "domain-name--1"},
+		&observable{Type: "autonomous-system", SpecVersion: SpecVersion, ID: "autonomous-system--1"},
+		&observable{Type: "ipv4-addr", SpecVersion: SpecVersion, ID: "domain-name--1", Value: "192.168.1.1"},
+		&relationship{Type: "relationship", SpecVersion: SpecVersion, ID: "relationship--1"},
+		&grouping{Type: "grouping", SpecVersion: SpecVersion, ID: "grouping--1", Created: "now", Modified: "now", Context: "unspecified"},
+	} {
+		if err := obj.validate(); err == nil {
+			t.Errorf("Failed to detect the missing required properties of %s", obj.identifier())
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// DefaultTTL is the TTL assigned to every record when the caller does not provide one.
+const DefaultTTL = 3600
+
+// maxTXTChunk is the maximum length of a single character string within a TXT record.
+const maxTXTChunk = 255
+
+// relationTypes maps the graph relations to the DNS record types rendered in the zone.
+var relationTypes = map[string]uint16{
+	"a_record":     dns.TypeA,
+	"aaaa_record":  dns.TypeAAAA,
+	"cname_record": dns.TypeCNAME,
+	"ns_record":    dns.TypeNS,
+	"mx_record":    dns.TypeMX,
+	"srv_record":   dns.TypeSRV,
+	"ptr_record":   dns.TypePTR,
+}
+
+// Write renders the records as a BIND-style zone fragment for the origin domain. Every record
+// is assigned the same TTL, so the output can be compared against an authoritative zone. Records
+// owned by names outside of the zone, and CNAME records pointing outside of it, are emitted as comments.
+func Write(w io.Writer, origin string, ttl int, records []requests.DNSAnswer) error {
+	origin = strings.ToLower(strings.Trim(strings.TrimSpace(origin), "."))
+	if _, ok := dns.IsDomainName(origin); !ok || origin == "" {
+		return fmt.Errorf("Write: %s is not a valid zone origin", origin)
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	var lines, comments []string
+	seen := make(map[string]struct{})
+	for _, rec := range records {
+		rr, err := newRR(rec, uint32(ttl))
+		if err != nil {
+			continue
+		}
+
+		line := rr.String()
+		if _, found := seen[line]; found {
+			continue
+		}
+		seen[line] = struct{}{}
+
+		if inBailiwick(rr, origin) {
+			lines = append(lines, line)
+		} else {
+			comments = append(comments, "; "+line)
+		}
+	}
+	sort.Strings(lines)
+	sort.Strings(comments)
+
+	if _, err := fmt.Fprintf(w, "$ORIGIN %s.\n$TTL %d\n", origin, ttl); err != nil {
+		return fmt.Errorf("Write: %v", err)
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return fmt.Errorf("Write: %v", err)
+		}
+	}
+	if len(comments) > 0 {
+		if _, err := fmt.Fprintln(w, "; Records outside of the zone bailiwick"); err != nil {
+			return fmt.Errorf("Write: %v", err)
+		}
+	}
+	for _, line := range comments {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return fmt.Errorf("Write: %v", err)
+		}
+	}
+	return nil
+}
+
+// newRR converts the answer into a resource record with the provided TTL.
+func newRR(rec requests.DNSAnswer, ttl uint32) (dns.RR, error) {
+	name := strings.ToLower(strings.Trim(strings.TrimSpace(rec.Name), "."))
+	if _, ok := dns.IsDomainName(name); !ok || name == "" {
+		return nil, fmt.Errorf("%s is not a valid owner name", rec.Name)
+	}
+
+	rtype := uint16(rec.Type)
+	hdr := dns.RR_Header{
+		Name:   dns.Fqdn(name),
+		Rrtype: rtype,
+		Class:  dns.ClassINET,
+		Ttl:    ttl,
+	}
+	// TXT data is kept raw, so it is split and escaped here instead of being parsed
+	if rtype == dns.TypeTXT {
+		var txt []string
+
+		for _, chunk := range splitTXT(rec.Data) {
+			txt = append(txt, escapeTXT(chunk))
+		}
+		return &dns.TXT{Hdr: hdr, Txt: txt}, nil
+	}
+
+	tstr, found := dns.TypeToString[rtype]
+	if !found {
+		return nil, fmt.Errorf("the record type %d is not supported", rec.Type)
+	}
+
+	data := strings.TrimSpace(rec.Data)
+	switch rtype {
+	case dns.TypeCNAME, dns.TypeNS, dns.TypePTR, dns.TypeMX, dns.TypeSRV:
+		// The targets are always fully qualified, since the origin would be appended otherwise
+		fields := strings.Fields(data)
+		if len(fields) == 0 {
+			return nil, errors.New("the record data is empty")
+		}
+		fields[len(fields)-1] = dns.Fqdn(strings.ToLower(fields[len(fields)-1]))
+		data = strings.Join(fields, " ")
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", hdr.Name, ttl, tstr, data))
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		return nil, errors.New("the record data is empty")
+	}
+	return rr, nil
+}
+
+func splitTXT(data string) []string {
+	var chunks []string
+
+	for len(data) > maxTXTChunk {
+		chunks = append(chunks, data[:maxTXTChunk])
+		data = data[maxTXTChunk:]
+	}
+	return append(chunks, data)
+}
+
+// escapeTXT converts the raw character string into the presentation format expected by the dns package.
+func escapeTXT(data string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func inBailiwick(rr dns.RR, origin string) bool {
+	zone := dns.Fqdn(origin)
+
+	if !dns.IsSubDomain(zone, rr.Header().Name) {
+		return false
+	}
+	if cname, ok := rr.(*dns.CNAME); ok && !dns.IsSubDomain(zone, cname.Target) {
+		return false
+	}
+	return true
+}
+
+// RecordsFromGraph returns the resolved records stored in the graph for names within the domain, sorted by
+// their type and owner name.
+// The graph does not keep MX preferences or SRV priorities, weights and ports, so those are zero.
+func RecordsFromGraph(ctx context.Context, g *netmap.Graph, d string, since time.Time) ([]requests.DNSAnswer, error) {
+	if g == nil || g.DB == nil {
+		return nil, errors.New("RecordsFromGraph: the graph has not been initialized")
+	}
+
+	if !since.IsZero() {
+		since = since.UTC()
+	}
+	var relations []string
+	for rel := range relationTypes {
+		relations = append(relations, rel)
+	}
+
+	var records []requests.DNSAnswer
+	it := cursor.NamesIterator(ctx, g, since, d)
+	for it.Next() {
+		name := it.Name()
+
+		rels, err := g.DB.OutgoingRelations(it.Value(), since, relations...)
+		if err != nil {
+			continue
+		}
+		for _, rel := range rels {
+			to, err := g.DB.FindById(rel.ToAsset.ID, since)
+			if err != nil {
+				continue
+			}
+
+			var data string
+			switch v := to.Asset.(type) {
+			case network.IPAddress:
+				data = v.Address.String()
+			case domain.FQDN:
+				data = v.Name
+			}
+
+			rtype := relationTypes[rel.Type]
+			switch rtype {
+			case dns.TypeMX:
+				data = "0 " + data
+			case dns.TypeSRV:
+				data = "0 0 0 " + data
+			}
+			if data != "" {
+				records = append(records, requests.DNSAnswer{
+					Name: name,
+					Type: int(rtype),
+					Data: data,
+				})
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return records, fmt.Errorf("RecordsFromGraph: %v", err)
+	}
+	// The records are ordered by their type and owner name, so they do not depend on the order of the graph
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Data < b.Data
+	})
+	return records, nil
+}
@@ -0,0 +1,201 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package zone
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+func parseZone(t *testing.T, data []byte) []dns.RR {
+	var rrs []dns.RR
+
+	zp := dns.NewZoneParser(bytes.NewReader(data), "", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		t.Fatalf("The zone file failed to parse: %v\n%s", err, string(data))
+	}
+	return rrs
+}
+
+func TestWrite(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	records := []requests.DNSAnswer{
+		{Name: "WWW.owasp.org", Type: int(dns.TypeA), Data: "192.168.1.1"},
+		{Name: "www.owasp.org.", Type: int(dns.TypeA), Data: "192.168.1.1"},
+		{Name: "www.owasp.org", Type: int(dns.TypeAAAA), Data: "2001:db8::1"},
+		{Name: "mail.owasp.org", Type: int(dns.TypeCNAME), Data: "www.owasp.org"},
+		{Name: "cdn.owasp.org", Type: int(dns.TypeCNAME), Data: "owasp.cdn.example.com"},
+		{Name: "owasp.org", Type: int(dns.TypeMX), Data: "10 mail.owasp.org"},
+		{Name: "owasp.org", Type: int(dns.TypeNS), Data: "ns1.example.net"},
+		{Name: "_sip._tcp.owasp.org", Type: int(dns.TypeSRV), Data: "10 5 5060 sip.owasp.org"},
+		{Name: "owasp.org", Type: int(dns.TypeTXT), Data: "v=spf1 \"quoted\" \\ include:example.com ~all\t"},
+		{Name: "owasp.org", Type: int(dns.TypeTXT), Data: long},
+		{Name: "1.1.168.192.in-addr.arpa", Type: int(dns.TypePTR), Data: "www.owasp.org"},
+		{Name: "bad name", Type: int(dns.TypeA), Data: "192.168.1.2"},
+		{Name: "broken.owasp.org", Type: int(dns.TypeA), Data: "not an address"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "OWASP.org.", 300, records); err != nil {
+		t.Fatalf("Failed to write the zone: %v", err)
+	}
+
+	rrs := parseZone(t, buf.Bytes())
+	if len(rrs) != 8 {
+		t.Errorf("Expected 8 records, got %d:\n%s", len(rrs), buf.String())
+	}
+
+	var txts [][]string
+	for _, rr := range rrs {
+		if rr.Header().Ttl != 300 {
+			t.Errorf("The TTL of %s was not normalized", rr.String())
+		}
+		if !dns.IsSubDomain("owasp.org.", rr.Header().Name) {
+			t.Errorf("The record %s is outside of the zone", rr.String())
+		}
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, txt.Txt)
+		}
+	}
+	if len(txts) != 2 {
+		t.Fatalf("Expected 2 TXT records, got %d", len(txts))
+	}
+	// The parser keeps the character strings in their escaped presentation format
+	for _, txt := range txts {
+		joined := strings.Join(txt, "")
+
+		if joined != long && joined != `v=spf1 \"quoted\" \\ include:example.com ~all\009` {
+			t.Errorf("The TXT data was not preserved: %q", txt)
+		}
+		if joined == long && len(txt) != 2 {
+			t.Errorf("The long TXT data was not split into character strings: %d", len(txt))
+		}
+	}
+
+	out := buf.String()
+	for _, name := range []string{"cdn.owasp.org.", "1.1.168.192.in-addr.arpa."} {
+		var commented bool
+
+		for _, line := range strings.Split(out, "\n") {
+			if strings.Contains(line, name) {
+				commented = strings.HasPrefix(line, ";")
+			}
+		}
+		if !commented {
+			t.Errorf("The out of bailiwick record for %s was not emitted as a comment", name)
+		}
+	}
+}
+
+func TestWriteInvalidOrigin(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(&buf, "", 0, nil); err == nil {
+		t.Errorf("An empty origin was accepted")
+	}
+}
+
+func TestRecordsFromGraph(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	if g == nil {
+		t.Fatal("Failed to create the graph")
+	}
+	defer g.Remove()
+
+	ctx := context.Background()
+	start := time.Now().Add(-time.Minute)
+	if err := g.UpsertA(ctx, "www.owasp.org", "192.168.1.1"); err != nil {
+		t.Fatalf("Failed to insert the A record: %v", err)
+	}
+	if err := g.UpsertCNAME(ctx, "owasp.org", "www.owasp.org"); err != nil {
+		t.Fatalf("Failed to insert the CNAME record: %v", err)
+	}
+	if err := g.UpsertMX(ctx, "owasp.org", "mail.owasp.org"); err != nil {
+		t.Fatalf("Failed to insert the MX record: %v", err)
+	}
+
+	records, err := RecordsFromGraph(ctx, g, "owasp.org", start)
+	if err != nil {
+		t.Fatalf("Failed to read the records: %v", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("Expected 3 records, got %d: %v", len(records), records)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "owasp.org", 0, records); err != nil {
+		t.Fatalf("Failed to write the zone: %v", err)
+	}
+	if rrs := parseZone(t, buf.Bytes()); len(rrs) != 3 {
+		t.Errorf("Expected 3 records in the zone, got %d:\n%s", len(rrs), buf.String())
+	}
+}
+
+// insertFixture stores the records of the fixture event in a local graph, in the order provided.
+func insertFixture(t *testing.T, reversed bool) *netmap.Graph {
+	g := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if g == nil {
+		t.Fatal("Failed to create the graph")
+	}
+
+	ctx := context.Background()
+	inserts := []func() error{
+		func() error { return g.UpsertA(ctx, "www.owasp.org", "192.168.1.1") },
+		func() error { return g.UpsertAAAA(ctx, "www.owasp.org", "2001:db8::1") },
+		func() error { return g.UpsertCNAME(ctx, "owasp.org", "www.owasp.org") },
+		func() error { return g.UpsertMX(ctx, "owasp.org", "mail.owasp.org") },
+		func() error { return g.UpsertA(ctx, "api.owasp.org", "192.168.1.2") },
+		func() error { return g.UpsertNS(ctx, "owasp.org", "ns1.owasp.org") },
+	}
+	for i := range inserts {
+		if reversed {
+			i = len(inserts) - 1 - i
+		}
+		if err := inserts[i](); err != nil {
+			t.Fatalf("Failed to insert the record: %v", err)
+		}
+	}
+	return g
+}
+
+func TestRecordsFromGraphDeterministic(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+
+	var exports [][]byte
+	for _, reversed := range []bool{false, true} {
+		g := insertFixture(t, reversed)
+
+		records, err := RecordsFromGraph(context.Background(), g, "owasp.org", start)
+		if err != nil {
+			t.Fatalf("Failed to read the records: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, "owasp.org", 0, records); err != nil {
+			t.Fatalf("Failed to write the zone: %v", err)
+		}
+		// The records are returned in the same order as well
+		for _, rec := range records {
+			fmt.Fprintf(&buf, "%d %s %s\n", rec.Type, rec.Name, rec.Data)
+		}
+		exports = append(exports, buf.Bytes())
+		g.Remove()
+	}
+
+	if !bytes.Equal(exports[0], exports[1]) {
+		t.Errorf("The exports of the same event differed:\n%s\n%s", exports[0], exports[1])
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package geo enriches the discovered addresses with their geolocation. The locations are provided by a
+// local MaxMind DB file, with an HTTP API as the fallback, and are cached for each prefix during the run.
+// The graph has no place for the locations, so they are kept beside it and included in the output.
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// LocationsFile is the name of the file under the output directory holding the locations of the addresses.
+const LocationsFile = "geolocation.json"
+
+// The prefix lengths the locations are cached for, since the addresses of a prefix share their location.
+const (
+	cachedIPv4Bits = 24
+	cachedIPv6Bits = 48
+)
+
+// The labels of the addresses without a location.
+const (
+	// Unknown is the country of the addresses the providers could not locate
+	Unknown = "unknown"
+	// Private is the country of the addresses within the private and reserved ranges, which are not looked up
+	Private = "private"
+)
+
+// The names of the built-in providers.
+const (
+	ProviderMMDB = "mmdb"
+	ProviderHTTP = "http"
+)
+
+// ErrNotFound is returned by a Provider without a location for the address.
+var ErrNotFound = errors.New("the location of the address was not found")
+
+// Location is the geolocation of an address.
+type Location struct {
+	Country     string `json:"country"`
+	CountryCode string `json:"country_code,omitempty"`
+	Region      string `json:"region,omitempty"`
+	City        string `json:"city,omitempty"`
+}
+
+// Provider looks up the location of an address.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, ip net.IP) (*Location, error)
+}
+
+// Config is the configuration of the geolocation enrichment.
+type Config struct {
+	// Providers are the names of the providers asked in order, until one of them locates the address
+	Providers []string
+	// MMDB is the path of the MaxMind DB file read by the mmdb provider
+	MMDB string
+	// URL is the address of the HTTP API, where {ip} is replaced by the address looked up
+	URL string
+	// QPS is the number of queries sent to the HTTP API every second
+	QPS int
+}
+
+// ConfigFromOptions returns the geolocation settings found in the configuration options,
+// or nil when the enrichment has not been enabled.
+func ConfigFromOptions(cfg *config.Config) *Config {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["geolocation"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, _ := opts["enabled"].(bool); !enabled {
+		return nil
+	}
+
+	c := &Config{
+		Providers: []string{ProviderMMDB},
+		URL:       DefaultURL,
+		QPS:       DefaultQPS,
+	}
+	if list, ok := opts["providers"].([]interface{}); ok {
+		c.Providers = nil
+		for _, v := range list {
+			if name, ok := v.(string); ok && name != "" {
+				c.Providers = append(c.Providers, strings.ToLower(name))
+			}
+		}
+	}
+	if path, ok := opts["mmdb"].(string); ok && path != "" {
+		if !filepath.IsAbs(path) && cfg.Filepath != "" {
+			path = filepath.Join(filepath.Dir(cfg.Filepath), path)
+		}
+		c.MMDB = path
+	}
+	if u, ok := opts["url"].(string); ok && u != "" {
+		c.URL = u
+	}
+	if qps := options.Int(opts["qps"]); qps > 0 {
+		c.QPS = qps
+	}
+	return c
+}
+
+// Enricher locates the addresses through the providers, and keeps the locations of the run.
+type Enricher struct {
+	sync.Mutex
+	providers []Provider
+	// prefixes caches the lookups of each prefix, which the callers asking for the same prefix wait on
+	prefixes map[string]*lookup
+	addrs    map[string]Location
+}
+
+type lookup struct {
+	done chan struct{}
+	loc  Location
+}
+
+// NewEnricher returns the Enricher asking the providers in order.
+func NewEnricher(providers ...Provider) *Enricher {
+	return &Enricher{
+		providers: providers,
+		prefixes:  make(map[string]*lookup),
+		addrs:     make(map[string]Location),
+	}
+}
+
+// FromConfig returns the Enricher of the configured providers. A provider that cannot be used, such as the
+// mmdb provider missing its database, is logged and left out, so the addresses it would locate are unknown.
+func FromConfig(c *Config, logger *log.Logger) *Enricher {
+	var providers []Provider
+
+	for _, name := range c.Providers {
+		switch name {
+		case ProviderMMDB:
+			if c.MMDB == "" {
+				logger.Printf("Geolocation: the path of the MaxMind DB file was not provided")
+				continue
+			}
+			db, err := OpenMMDB(c.MMDB)
+			if err != nil {
+				logger.Printf("Geolocation: %v", err)
+				continue
+			}
+			providers = append(providers, db)
+		case ProviderHTTP:
+			providers = append(providers, NewHTTP(c.URL, c.QPS))
+		default:
+			logger.Printf("Geolocation: the provider %s is not supported", name)
+		}
+	}
+	return NewEnricher(providers...)
+}
+
+// Locate returns the location of the address. The addresses within the private and reserved ranges
+// are labeled without a lookup, and those the providers cannot locate are unknown.
+func (e *Enricher) Locate(ctx context.Context, addr string) Location {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return Location{Country: Unknown}
+	}
+	if reserved, _ := amassnet.IsReservedAddress(ip.String()); reserved {
+		return Location{Country: Private}
+	}
+
+	bits, size := cachedIPv4Bits, 32
+	if ip.To4() == nil {
+		bits, size = cachedIPv6Bits, 128
+	}
+	key := (&net.IPNet{IP: ip.Mask(net.CIDRMask(bits, size)), Mask: net.CIDRMask(bits, size)}).String()
+
+	e.Lock()
+	l, found := e.prefixes[key]
+	if !found {
+		l = &lookup{done: make(chan struct{})}
+		e.prefixes[key] = l
+	}
+	e.Unlock()
+
+	if !found {
+		l.loc = e.query(ctx, ip)
+		close(l.done)
+	}
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return Location{Country: Unknown}
+	}
+
+	e.Lock()
+	e.addrs[ip.String()] = l.loc
+	e.Unlock()
+	return l.loc
+}
+
+func (e *Enricher) query(ctx context.Context, ip net.IP) Location {
+	for _, p := range e.providers {
+		if loc, err := p.Lookup(ctx, ip); err == nil && loc != nil {
+			return *loc
+		}
+	}
+	return Location{Country: Unknown}
+}
+
+// Enrich sets the country and region of the addresses.
+func (e *Enricher) Enrich(ctx context.Context, addrs []requests.AddressInfo) {
+	if e == nil {
+		return
+	}
+
+	for i := range addrs {
+		if addrs[i].Address == nil {
+			continue
+		}
+
+		loc := e.Locate(ctx, addrs[i].Address.String())
+		addrs[i].Country = loc.Country
+		addrs[i].Region = loc.Region
+	}
+}
+
+// Locations returns the locations of the addresses located during the run.
+func (e *Enricher) Locations() map[string]Location {
+	e.Lock()
+	defer e.Unlock()
+
+	locs := make(map[string]Location, len(e.addrs))
+	for addr, loc := range e.addrs {
+		locs[addr] = loc
+	}
+	return locs
+}
+
+// addressLocation is an entry of the locations file.
+type addressLocation struct {
+	Address string `json:"address"`
+	Location
+}
+
+// WriteFile writes the locations of the addresses located during the run, sorted by the address.
+func (e *Enricher) WriteFile(path string) error {
+	locs := e.Locations()
+
+	entries := make([]addressLocation, 0, len(locs))
+	for addr, loc := range locs {
+		entries = append(entries, addressLocation{Address: addr, Location: loc})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Address < entries[j].Address
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
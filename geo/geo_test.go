@@ -0,0 +1,307 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package geo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// mmdbWriter builds a small MaxMind DB file of IPv6 with 24 bit records, holding the IPv4 networks.
+type mmdbWriter struct {
+	nodes [][2]int
+	data  bytes.Buffer
+}
+
+// The records of the nodes that are empty, or hold the offset of the data section with dataRecord added.
+const (
+	emptyRecord = -1
+	dataRecord  = 1 << 30
+)
+
+func (w *mmdbWriter) newNode() int {
+	w.nodes = append(w.nodes, [2]int{emptyRecord, emptyRecord})
+	return len(w.nodes) - 1
+}
+
+// insert adds the IPv4 network within the IPv6 tree, below the 96 zero bits.
+func (w *mmdbWriter) insert(cidr string, record interface{}) {
+	_, ipnet, _ := net.ParseCIDR(cidr)
+	ones, _ := ipnet.Mask.Size()
+	ip := append(make(net.IP, 12), ipnet.IP.To4()...)
+	bits := 96 + ones
+
+	offset := w.data.Len()
+	w.encode(&w.data, record)
+
+	if len(w.nodes) == 0 {
+		w.newNode()
+	}
+	node := 0
+	for i := 0; i < bits; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if i == bits-1 {
+			w.nodes[node][bit] = dataRecord + offset
+			break
+		}
+		next := w.nodes[node][bit]
+		if next == emptyRecord {
+			next = w.newNode()
+			w.nodes[node][bit] = next
+		}
+		node = next
+	}
+}
+
+func (w *mmdbWriter) bytes() []byte {
+	count := len(w.nodes)
+	var out bytes.Buffer
+
+	for _, n := range w.nodes {
+		for _, rec := range n {
+			v := count
+			if rec >= dataRecord {
+				v = count + 16 + rec - dataRecord
+			} else if rec != emptyRecord {
+				v = rec
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(w.data.Bytes())
+	out.Write(metadataMarker)
+	w.encode(&out, map[string]interface{}{
+		"node_count":    uint32(count),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(6),
+		"database_type": "Test-City",
+	})
+	return out.Bytes()
+}
+
+func (w *mmdbWriter) control(buf *bytes.Buffer, typ, size int) {
+	if typ <= 7 {
+		buf.WriteByte(byte(typ<<5 | size))
+		return
+	}
+	buf.WriteByte(byte(size))
+	buf.WriteByte(byte(typ - 7))
+}
+
+func (w *mmdbWriter) encode(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case string:
+		w.control(buf, mmdbString, len(t))
+		buf.WriteString(t)
+	case uint16:
+		w.control(buf, mmdbUint16, 2)
+		_ = binary.Write(buf, binary.BigEndian, t)
+	case uint32:
+		w.control(buf, mmdbUint32, 4)
+		_ = binary.Write(buf, binary.BigEndian, t)
+	case map[string]interface{}:
+		w.control(buf, mmdbMap, len(t))
+		for k, val := range t {
+			w.encode(buf, k)
+			w.encode(buf, val)
+		}
+	case []interface{}:
+		w.control(buf, mmdbArray, len(t))
+		for _, val := range t {
+			w.encode(buf, val)
+		}
+	}
+}
+
+func names(en string) map[string]interface{} {
+	return map[string]interface{}{"names": map[string]interface{}{"en": en}}
+}
+
+func cityRecord(country, code, region, city string) map[string]interface{} {
+	c := names(country)
+	c["iso_code"] = code
+	return map[string]interface{}{
+		"country":      c,
+		"subdivisions": []interface{}{names(region)},
+		"city":         names(city),
+	}
+}
+
+func writeTestMMDB(t *testing.T) string {
+	var w mmdbWriter
+	w.insert("8.8.8.0/24", cityRecord("United States", "US", "California", "Mountain View"))
+	w.insert("1.1.1.0/24", cityRecord("Australia", "AU", "Queensland", "Brisbane"))
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, w.bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMMDB(t *testing.T) {
+	db, err := OpenMMDB(writeTestMMDB(t))
+	if err != nil {
+		t.Fatalf("failed to open the database: %v", err)
+	}
+
+	loc, err := db.Lookup(context.Background(), net.ParseIP("8.8.8.8"))
+	if err != nil || loc.Country != "United States" || loc.CountryCode != "US" ||
+		loc.Region != "California" || loc.City != "Mountain View" {
+		t.Errorf("8.8.8.8 was located at %+v: %v", loc, err)
+	}
+	if loc, err := db.Lookup(context.Background(), net.ParseIP("1.1.1.1")); err != nil || loc.Country != "Australia" {
+		t.Errorf("1.1.1.1 was located at %+v: %v", loc, err)
+	}
+	if _, err := db.Lookup(context.Background(), net.ParseIP("9.9.9.9")); err != ErrNotFound {
+		t.Errorf("the address missing from the database returned %v", err)
+	}
+	if _, err := db.Lookup(context.Background(), net.ParseIP("2001:db8::1")); err != ErrNotFound {
+		t.Errorf("the IPv6 address missing from the database returned %v", err)
+	}
+
+	if _, err := newMMDB([]byte("not a database")); err == nil {
+		t.Error("the file without the metadata was opened")
+	}
+}
+
+type fakeProvider struct {
+	calls int32
+	locs  map[string]*Location
+}
+
+func (f *fakeProvider) Name() string { return "fake" }
+
+func (f *fakeProvider) Lookup(ctx context.Context, ip net.IP) (*Location, error) {
+	atomic.AddInt32(&f.calls, 1)
+	if loc, found := f.locs[ip.String()]; found {
+		return loc, nil
+	}
+	return nil, ErrNotFound
+}
+
+func TestEnricher(t *testing.T) {
+	first := &fakeProvider{locs: map[string]*Location{"8.8.8.8": {Country: "United States", Region: "California"}}}
+	fallback := &fakeProvider{locs: map[string]*Location{"9.9.9.9": {Country: "Switzerland"}}}
+	e := NewEnricher(first, fallback)
+	ctx := context.Background()
+
+	if loc := e.Locate(ctx, "8.8.8.8"); loc.Country != "United States" {
+		t.Errorf("8.8.8.8 was located at %+v", loc)
+	}
+	// The addresses of the prefix share the location found for the first of them
+	if loc := e.Locate(ctx, "8.8.8.4"); loc.Country != "United States" || first.calls != 1 {
+		t.Errorf("8.8.8.4 was located at %+v after %d lookups", loc, first.calls)
+	}
+	if loc := e.Locate(ctx, "9.9.9.9"); loc.Country != "Switzerland" || fallback.calls != 1 {
+		t.Errorf("the fallback located 9.9.9.9 at %+v", loc)
+	}
+	if loc := e.Locate(ctx, "4.4.4.4"); loc.Country != Unknown {
+		t.Errorf("the address no provider located is at %+v", loc)
+	}
+	// The private addresses are labeled without a lookup
+	calls := first.calls
+	if loc := e.Locate(ctx, "192.168.1.1"); loc.Country != Private || first.calls != calls {
+		t.Errorf("the private address was located at %+v", loc)
+	}
+
+	addrs := []requests.AddressInfo{{Address: net.ParseIP("8.8.8.8")}, {Address: net.ParseIP("10.0.0.1")}}
+	e.Enrich(ctx, addrs)
+	if addrs[0].Country != "United States" || addrs[0].Region != "California" || addrs[1].Country != Private {
+		t.Errorf("the addresses were enriched as %+v", addrs)
+	}
+
+	path := filepath.Join(t.TempDir(), LocationsFile)
+	if err := e.WriteFile(path); err != nil {
+		t.Fatalf("failed to write the locations: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	var entries []addressLocation
+	if err := json.Unmarshal(data, &entries); err != nil || len(entries) != 4 || entries[0].Address != "4.4.4.4" {
+		t.Errorf("the locations file holds %s: %v", data, err)
+	}
+
+	// A nil Enricher leaves the addresses as they are
+	var disabled *Enricher
+	disabled.Enrich(ctx, addrs)
+}
+
+func TestHTTPProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json/8.8.8.8":
+			fmt.Fprint(w, `{"status":"success","country":"United States","countryCode":"US","regionName":"Virginia","city":"Ashburn"}`)
+		case "/json/9.9.9.9":
+			fmt.Fprint(w, `{"status":"fail","message":"reserved range"}`)
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	h := NewHTTP(ts.URL+"/json/{ip}", 100)
+	h.http = ts.Client()
+	ctx := context.Background()
+
+	if loc, err := h.Lookup(ctx, net.ParseIP("8.8.8.8")); err != nil || loc.Region != "Virginia" || loc.CountryCode != "US" {
+		t.Errorf("8.8.8.8 was located at %+v: %v", loc, err)
+	}
+	if _, err := h.Lookup(ctx, net.ParseIP("9.9.9.9")); err != ErrNotFound {
+		t.Errorf("the failed lookup returned %v", err)
+	}
+	if _, err := h.Lookup(ctx, net.ParseIP("1.1.1.1")); err == nil {
+		t.Error("the rejected lookup did not return an error")
+	}
+}
+
+func TestConfigFromOptions(t *testing.T) {
+	cfg := config.NewConfig()
+	if ConfigFromOptions(cfg) != nil {
+		t.Error("the enrichment was enabled by default")
+	}
+
+	cfg.Filepath = "/etc/amass/config.yaml"
+	cfg.Options = map[string]interface{}{"geolocation": map[string]interface{}{
+		"enabled":   true,
+		"providers": []interface{}{"MMDB", "http"},
+		"mmdb":      "GeoLite2-City.mmdb",
+		"qps":       2,
+	}}
+	c := ConfigFromOptions(cfg)
+	if c == nil || len(c.Providers) != 2 || c.Providers[0] != ProviderMMDB || c.MMDB != "/etc/amass/GeoLite2-City.mmdb" ||
+		c.QPS != 2 || c.URL != DefaultURL {
+		t.Fatalf("the options were parsed as %+v", c)
+	}
+
+	// The missing database degrades to the unknown locations
+	c.Providers = []string{ProviderMMDB}
+	c.MMDB = filepath.Join(t.TempDir(), "missing.mmdb")
+	e := FromConfig(c, log.New(io.Discard, "", 0))
+	if loc := e.Locate(context.Background(), "8.8.8.8"); loc.Country != Unknown {
+		t.Errorf("the address was located at %+v without a database", loc)
+	}
+
+	c.MMDB = writeTestMMDB(t)
+	e = FromConfig(c, log.New(io.Discard, "", 0))
+	if loc := e.Locate(context.Background(), "1.1.1.1"); loc.Country != "Australia" {
+		t.Errorf("the address was located at %+v", loc)
+	}
+}
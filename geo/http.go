@@ -0,0 +1,102 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	amasshttp "github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/rate"
+)
+
+const (
+	// DefaultURL is the HTTP API asked for the locations when none has been configured.
+	DefaultURL = "http://ip-api.com/json/{ip}?fields=status,message,country,countryCode,regionName,city"
+	// DefaultQPS is the number of queries sent to the HTTP API every second when none has been configured.
+	DefaultQPS = 1
+	// maxResponseSize is the largest HTTP API response read.
+	maxResponseSize = 64 * 1024
+)
+
+// HTTP is the Provider asking an HTTP API for the locations, which answers with the fields of ip-api.com.
+type HTTP struct {
+	url     string
+	http    *http.Client
+	limiter *rate.Limiter
+}
+
+// apiResponse holds the fields of the HTTP API response.
+type apiResponse struct {
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"`
+	Region      string `json:"regionName"`
+	City        string `json:"city"`
+}
+
+// NewHTTP returns the Provider asking the HTTP API, where {ip} within the URL is replaced by the address.
+func NewHTTP(url string, qps int) *HTTP {
+	if url == "" {
+		url = DefaultURL
+	}
+	if qps <= 0 {
+		qps = DefaultQPS
+	}
+
+	return &HTTP{
+		url:     url,
+		http:    amasshttp.DefaultClient,
+		limiter: rate.NewLimiter(qps, 1, clock.System),
+	}
+}
+
+// Name implements the Provider interface.
+func (h *HTTP) Name() string {
+	return "http"
+}
+
+// Lookup implements the Provider interface.
+func (h *HTTP) Lookup(ctx context.Context, ip net.IP) (*Location, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(h.url, "{ip}", ip.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if err := h.limiter.TakeContext(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := h.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the HTTP API returned status %d", resp.StatusCode)
+	}
+
+	var r apiResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&r); err != nil {
+		return nil, err
+	}
+	if (r.Status != "" && r.Status != "success") || (r.Country == "" && r.CountryCode == "") {
+		return nil, ErrNotFound
+	}
+	return &Location{
+		Country:     r.Country,
+		CountryCode: r.CountryCode,
+		Region:      r.Region,
+		City:        r.City,
+	}, nil
+}
@@ -0,0 +1,401 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package geo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMetadataSize is the most bytes at the end of the file searched for the metadata.
+const maxMetadataSize = 128 * 1024
+
+// dataSectionSeparator is the number of zero bytes between the search tree and the data section.
+const dataSectionSeparator = 16
+
+// The types of the fields of the MaxMind DB data section.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// MMDB is the Provider reading the locations from a MaxMind DB file, such as GeoLite2 City or
+// DB-IP Lite, which is read into memory once.
+type MMDB struct {
+	path       string
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+}
+
+// OpenMMDB reads the MaxMind DB file.
+func OpenMMDB(path string) (*MMDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := newMMDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	db.path = path
+	return db, nil
+}
+
+func newMMDB(buf []byte) (*MMDB, error) {
+	start := 0
+	if len(buf) > maxMetadataSize {
+		start = len(buf) - maxMetadataSize
+	}
+	idx := bytes.LastIndex(buf[start:], metadataMarker)
+	if idx < 0 {
+		return nil, errors.New("the file is not a MaxMind DB")
+	}
+	mstart := uint(start + idx + len(metadataMarker))
+
+	// The pointers within the metadata are relative to its start
+	meta := &decoder{buf: buf[mstart:]}
+	v, _, err := meta.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("the metadata is malformed: %v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("the metadata is not a map")
+	}
+
+	db := &MMDB{
+		buf:        buf,
+		nodeCount:  uint(metaUint(m["node_count"])),
+		recordSize: uint(metaUint(m["record_size"])),
+		ipVersion:  uint(metaUint(m["ip_version"])),
+	}
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("the record size %d is not supported", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("the IP version %d is not supported", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	db.dataStart = treeSize + dataSectionSeparator
+	if db.nodeCount == 0 || db.dataStart > mstart-uint(len(metadataMarker)) {
+		return nil, errors.New("the search tree is larger than the file")
+	}
+	// The IPv4 addresses are found below the 96 zero bits of the IPv6 tree
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Name implements the Provider interface.
+func (db *MMDB) Name() string {
+	return "mmdb"
+}
+
+// Lookup implements the Provider interface.
+func (db *MMDB) Lookup(ctx context.Context, ip net.IP) (*Location, error) {
+	v, err := db.find(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	loc := &Location{
+		Country:     englishName(m["country"]),
+		CountryCode: isoCode(m["country"]),
+		City:        englishName(m["city"]),
+	}
+	if subs, ok := m["subdivisions"].([]interface{}); ok && len(subs) > 0 {
+		loc.Region = englishName(subs[0])
+	}
+	if loc.Country == "" && loc.CountryCode == "" {
+		return nil, ErrNotFound
+	}
+	return loc, nil
+}
+
+// find returns the data of the network holding the address.
+func (db *MMDB) find(ip net.IP) (interface{}, error) {
+	bits := ip.To4()
+	node := uint(0)
+	if bits != nil {
+		node = db.ipv4Start
+	} else if bits = ip.To16(); bits == nil || db.ipVersion == 4 {
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = db.record(node, uint(bit))
+	}
+	if node <= db.nodeCount {
+		return nil, ErrNotFound
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparator
+	data := &decoder{buf: db.buf[db.dataStart:]}
+	v, _, err := data.decode(offset)
+	return v, err
+}
+
+// record returns the left or right record of the node in the search tree.
+func (db *MMDB) record(node, right uint) uint {
+	size := db.recordSize / 4
+	off := node * size
+	if off+size > uint(len(db.buf)) {
+		return db.nodeCount
+	}
+	b := db.buf[off : off+size]
+
+	switch db.recordSize {
+	case 24:
+		b = b[right*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if right == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[right*4:]))
+	}
+}
+
+// decoder reads the fields of a MaxMind DB data section.
+type decoder struct {
+	buf []byte
+}
+
+var errTruncated = errors.New("the data section is truncated")
+
+// decode returns the field at the offset and the offset following it.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *decoder) decodeDepth(offset uint, depth int) (interface{}, uint, error) {
+	if depth > 32 {
+		return nil, 0, errors.New("the data section is nested too deeply")
+	}
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errTruncated
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		ptr, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decodeDepth(ptr, depth+1)
+		return v, next, err
+	}
+	if typ == mmdbExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errTruncated
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("the key of a map is not a string")
+			}
+			v, next, err := d.decodeDepth(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errTruncated
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case mmdbString:
+		return string(b), next, nil
+	case mmdbBytes, mmdbUint128:
+		return append([]byte(nil), b...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("the double is not 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("the float is not 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, next, nil
+	case mmdbInt32:
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), next, nil
+	}
+	return nil, 0, fmt.Errorf("the data type %d is not supported", typ)
+}
+
+// pointer returns the offset the pointer refers to and the offset following the pointer.
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	ss := uint(ctrl>>3) & 0x3
+	n := ss + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errTruncated
+	}
+	b := d.buf[offset : offset+n]
+
+	var ptr uint
+	if ss < 3 {
+		ptr = uint(ctrl & 0x7)
+	}
+	for _, c := range b {
+		ptr = ptr<<8 | uint(c)
+	}
+	switch ss {
+	case 1:
+		ptr += 2048
+	case 2:
+		ptr += 526336
+	}
+	return ptr, offset + n, nil
+}
+
+// size returns the size held by the control byte and the bytes following it.
+func (d *decoder) size(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errTruncated
+	}
+	var v uint
+	for _, c := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+	switch size {
+	case 29:
+		return 29 + v, offset + n, nil
+	case 30:
+		return 285 + v, offset + n, nil
+	}
+	return 65821 + v, offset + n, nil
+}
+
+func metaUint(v interface{}) uint64 {
+	if n, ok := v.(uint64); ok {
+		return n
+	}
+	return 0
+}
+
+// englishName returns the English name of a country, subdivision or city record.
+func englishName(v interface{}) string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	names, ok := m["names"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := names["en"].(string)
+	return name
+}
+
+func isoCode(v interface{}) string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	code, _ := m["iso_code"].(string)
+	return code
+}
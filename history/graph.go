@@ -0,0 +1,67 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/caffix/netmap"
+)
+
+// Validity selects the edges returned by the graph queries.
+type Validity int
+
+const (
+	// Current selects the edges still observed at the time of the query
+	Current Validity = iota
+	// Ever selects all the edges that were observed, including the historical ones
+	Ever
+)
+
+// UpsertAddress inserts the A or AAAA record edge from the name to the address into the graph,
+// and widens the period of the edge to include the observation.
+func UpsertAddress(ctx context.Context, g *netmap.Graph, b *Backend, name, addr string, p Period) error {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return fmt.Errorf("%s is not a valid IP address", addr)
+	}
+
+	var err error
+	if ip.To4() != nil {
+		err = g.UpsertA(ctx, name, ip.String())
+	} else {
+		err = g.UpsertAAAA(ctx, name, ip.String())
+	}
+	if err != nil {
+		return err
+	}
+
+	b.Observe(name, ip.String(), p)
+	return nil
+}
+
+// NamesToAddrs returns the pairs of the names and the addresses they resolve to in the graph, like the
+// query of the graph, keeping only the edges still observed at the time when the validity is Current.
+func NamesToAddrs(ctx context.Context, g *netmap.Graph, b *Backend, v Validity, at, since time.Time, names ...string) ([]*netmap.NameAddrPair, error) {
+	pairs, err := g.NamesToAddrs(ctx, since, names...)
+	if err != nil || v == Ever {
+		return pairs, err
+	}
+
+	var current []*netmap.NameAddrPair
+	for _, p := range pairs {
+		if p.FQDN == nil || p.Addr == nil || !p.Addr.Address.IsValid() {
+			continue
+		}
+		if b.ValidAt(p.FQDN.Name, p.Addr.Address.String(), at) {
+			current = append(current, p)
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,259 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package history keeps the periods during which the edges from the names to their addresses were
+// observed, so the resolutions that ended years ago are told apart from the current ones. The graph
+// schema has no properties, so the periods are persisted in the output directory for each graph
+// database system, and the edges without a period, such as those stored before the periods were
+// recorded, are taken as current. The unicode display forms of the internationalized names are kept
+// beside the periods for the same reason.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the file in the output directory that holds the periods of the edges.
+const FileName = "history.json"
+
+// fileVersion is the version of the history file format.
+const fileVersion = 1
+
+// Period is the time between the first and the last observation of an edge.
+type Period struct {
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+	// TTL is the raw TTL in seconds of the record at its last observation, kept for reporting
+	TTL int `json:"ttl,omitempty"`
+	// ValidUntil is the end of the bounded TTL of the record at its last observation
+	ValidUntil time.Time `json:"valid_until,omitempty"`
+}
+
+// ValidAt returns true when the edge was still observed at the time, or its TTL had not elapsed yet.
+// A period without a last seen date has no end, so it is always valid.
+func (p Period) ValidAt(at time.Time) bool {
+	return p.LastSeen.IsZero() || !p.LastSeen.Before(at) || (!p.ValidUntil.IsZero() && !p.ValidUntil.Before(at))
+}
+
+// Widen extends the period to include the other one, keeping the TTL of the latest observation.
+func (p *Period) Widen(other Period) {
+	if !other.FirstSeen.IsZero() && (p.FirstSeen.IsZero() || other.FirstSeen.Before(p.FirstSeen)) {
+		p.FirstSeen = other.FirstSeen
+	}
+	if other.TTL > 0 && !other.LastSeen.Before(p.LastSeen) {
+		p.TTL = other.TTL
+	}
+	if other.LastSeen.After(p.LastSeen) {
+		p.LastSeen = other.LastSeen
+	}
+	if other.ValidUntil.After(p.ValidUntil) {
+		p.ValidUntil = other.ValidUntil
+	}
+}
+
+type historyFile struct {
+	Version int `json:"version"`
+	// Backends maps each graph database system to the names, and each name to the periods of its addresses
+	Backends map[string]map[string]map[string]*Period `json:"backends"`
+	// DisplayNames maps each graph database system to the internationalized names and their unicode forms
+	DisplayNames map[string]map[string]string `json:"display_names,omitempty"`
+}
+
+// Store holds the periods of the edges stored in each graph database system.
+type Store struct {
+	sync.Mutex
+	path     string
+	backends map[string]map[string]map[string]*Period
+	display  map[string]map[string]string
+}
+
+// Open loads the history file in the directory, which is created when the store is closed.
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		path:     filepath.Join(dir, FileName),
+		backends: make(map[string]map[string]map[string]*Period),
+		display:  make(map[string]map[string]string),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the edge history: %v", err)
+	}
+
+	var f historyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse the edge history: %v", err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("the edge history file has the unsupported version %d", f.Version)
+	}
+	for system, names := range f.Backends {
+		if names != nil {
+			s.backends[strings.ToLower(system)] = names
+		}
+	}
+	for system, names := range f.DisplayNames {
+		if names != nil {
+			s.display[strings.ToLower(system)] = names
+		}
+	}
+	return s, nil
+}
+
+// Backend returns the periods of the edges stored in the graph database system.
+func (s *Store) Backend(system string) *Backend {
+	if s == nil {
+		return nil
+	}
+	return &Backend{store: s, system: strings.ToLower(system)}
+}
+
+// Close persists the periods of the edges, replacing the file only once the new content is complete.
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := json.MarshalIndent(&historyFile{
+		Version:      fileVersion,
+		Backends:     s.backends,
+		DisplayNames: s.display,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the edge history: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write the edge history: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace the edge history file: %v", err)
+	}
+	return nil
+}
+
+// Backend provides the periods of the edges of a single graph database system. A nil Backend
+// has no periods, so all the edges are current.
+type Backend struct {
+	store  *Store
+	system string
+}
+
+// Observe widens the period of the edge from the name to the address to include the observation.
+func (b *Backend) Observe(name, addr string, p Period) {
+	if b == nil {
+		return
+	}
+
+	name, addr = normalize(name, addr)
+	if name == "" || addr == "" {
+		return
+	}
+	p.FirstSeen, p.LastSeen, p.ValidUntil = utc(p.FirstSeen), utc(p.LastSeen), utc(p.ValidUntil)
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	names, found := b.store.backends[b.system]
+	if !found {
+		names = make(map[string]map[string]*Period)
+		b.store.backends[b.system] = names
+	}
+	addrs, found := names[name]
+	if !found {
+		addrs = make(map[string]*Period)
+		names[name] = addrs
+	}
+	if cur, found := addrs[addr]; found {
+		cur.Widen(p)
+		return
+	}
+	addrs[addr] = &p
+}
+
+// Period returns the period of the edge from the name to the address, and false when none was recorded.
+func (b *Backend) Period(name, addr string) (Period, bool) {
+	if b == nil {
+		return Period{}, false
+	}
+
+	name, addr = normalize(name, addr)
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	if p, found := b.store.backends[b.system][name][addr]; found {
+		return *p, true
+	}
+	return Period{}, false
+}
+
+// ValidAt returns true when the edge from the name to the address was still observed at the time.
+// The edges without a recorded period are taken as current.
+func (b *Backend) ValidAt(name, addr string, at time.Time) bool {
+	p, found := b.Period(name, addr)
+	return !found || p.ValidAt(at)
+}
+
+// SetDisplayName records the unicode display form of the name stored in the graph in its punycode form.
+// Nothing is recorded when the forms are the same.
+func (b *Backend) SetDisplayName(name, display string) {
+	if b == nil {
+		return
+	}
+
+	name, _ = normalize(name, "")
+	if name == "" || display == "" || display == name {
+		return
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	names, found := b.store.display[b.system]
+	if !found {
+		names = make(map[string]string)
+		b.store.display[b.system] = names
+	}
+	names[name] = display
+}
+
+// DisplayName returns the unicode display form of the name, and false when none was recorded.
+func (b *Backend) DisplayName(name string) (string, bool) {
+	if b == nil {
+		return "", false
+	}
+
+	name, _ = normalize(name, "")
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	display, found := b.store.display[b.system][name]
+	return display, found
+}
+
+func normalize(name, addr string) (string, string) {
+	name = strings.ToLower(strings.Trim(strings.TrimSpace(name), "."))
+	if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+		return name, ip.String()
+	}
+	return name, ""
+}
+
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC()
+}
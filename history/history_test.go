@@ -0,0 +1,178 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+)
+
+func day(y, m, d int) time.Time {
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestHistoryPersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := s.Backend("local")
+	local.Observe("WWW.owasp.org.", "192.0.2.1", Period{FirstSeen: day(2015, 3, 1), LastSeen: day(2016, 1, 1)})
+	local.Observe("www.owasp.org", "192.0.2.1", Period{FirstSeen: day(2014, 6, 1), LastSeen: day(2015, 6, 1)})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, found := s.Backend("Local").Period("www.owasp.org", "192.0.2.1")
+	if !found || !p.FirstSeen.Equal(day(2014, 6, 1)) || !p.LastSeen.Equal(day(2016, 1, 1)) {
+		t.Errorf("the period %+v was not widened and persisted", p)
+	}
+	// The periods of each graph database system are kept apart
+	if _, found := s.Backend("postgres").Period("www.owasp.org", "192.0.2.1"); found {
+		t.Error("the period was recorded for another graph database system")
+	}
+}
+
+func TestDisplayNamesPersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := s.Backend("local")
+	local.SetDisplayName("WWW.xn--mnchen-3ya.de.", "www.münchen.de")
+	local.SetDisplayName("www.owasp.org", "www.owasp.org")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, found := s.Backend("Local").DisplayName("www.xn--mnchen-3ya.de"); !found || d != "www.münchen.de" {
+		t.Errorf("the display name %q was not persisted", d)
+	}
+	if _, found := s.Backend("local").DisplayName("www.owasp.org"); found {
+		t.Error("a display name was recorded for an ASCII name")
+	}
+	if _, found := s.Backend("postgres").DisplayName("www.xn--mnchen-3ya.de"); found {
+		t.Error("the display name was recorded for another graph database system")
+	}
+}
+
+func TestValidAt(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Backend("local")
+	b.Observe("www.owasp.org", "192.0.2.1", Period{FirstSeen: day(2012, 1, 1), LastSeen: day(2013, 1, 1)})
+
+	now := day(2023, 1, 1)
+	if b.ValidAt("www.owasp.org", "192.0.2.1", now) {
+		t.Error("the edge last seen ten years ago is current")
+	}
+	// The edges lacking a period, such as those stored before the history, are current
+	if !b.ValidAt("www.owasp.org", "192.0.2.2", now) || !s.Backend("postgres").ValidAt("www.owasp.org", "192.0.2.1", now) {
+		t.Error("the edge without a period is not current")
+	}
+	var nilBackend *Backend
+	if !nilBackend.ValidAt("www.owasp.org", "192.0.2.1", now) {
+		t.Error("the edge is not current without a history")
+	}
+
+	b.Observe("www.owasp.org", "192.0.2.1", Period{FirstSeen: now, LastSeen: now})
+	if !b.ValidAt("www.owasp.org", "192.0.2.1", now) {
+		t.Error("the edge observed again is not current")
+	}
+
+	// The edge remains current until the bounded TTL of its last observation has elapsed
+	later := now.Add(time.Hour)
+	b.Observe("www.owasp.org", "192.0.2.1", Period{FirstSeen: later, LastSeen: later, TTL: 30, ValidUntil: later.Add(5 * time.Minute)})
+	if p, _ := b.Period("www.owasp.org", "192.0.2.1"); p.TTL != 30 || !p.FirstSeen.Equal(day(2012, 1, 1)) {
+		t.Errorf("the period was widened to %+v", p)
+	}
+	if !b.ValidAt("www.owasp.org", "192.0.2.1", later.Add(4*time.Minute)) || b.ValidAt("www.owasp.org", "192.0.2.1", later.Add(6*time.Minute)) {
+		t.Error("the edge was not current for the bounded TTL only")
+	}
+}
+
+func TestOpenLegacyAndInvalid(t *testing.T) {
+	dir := t.TempDir()
+	// The directories of the earlier versions have no history file
+	if _, err := Open(dir); err != nil {
+		t.Errorf("the directory without a history file failed to open: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(`{"version":2,"backends":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("the history file with an unsupported version was opened")
+	}
+}
+
+func TestNamesToAddrs(t *testing.T) {
+	ctx := context.Background()
+	g := netmap.NewGraph("memory", "", "")
+	defer g.Remove()
+
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Backend("local")
+
+	now := day(2023, 1, 1)
+	if err := UpsertAddress(ctx, g, b, "www.owasp.org", "192.0.2.1", Period{FirstSeen: day(2012, 1, 1), LastSeen: day(2013, 1, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpsertAddress(ctx, g, b, "www.owasp.org", "2001:db8::1", Period{FirstSeen: now, LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	// An edge stored without the history
+	if err := g.UpsertA(ctx, "www.owasp.org", "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := func(v Validity) string {
+		pairs, err := NamesToAddrs(ctx, g, b, v, now, time.Time{}, "www.owasp.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var list []string
+		for _, p := range pairs {
+			list = append(list, p.Addr.Address.String())
+		}
+		sort.Strings(list)
+		return strings.Join(list, ",")
+	}
+	if got, want := addrs(Current), "192.0.2.2,2001:db8::1"; got != want {
+		t.Errorf("the current addresses are %s, expected %s", got, want)
+	}
+	if got, want := addrs(Ever), "192.0.2.1,192.0.2.2,2001:db8::1"; got != want {
+		t.Errorf("the observed addresses are %s, expected %s", got, want)
+	}
+
+	if err := UpsertAddress(ctx, g, b, "www.owasp.org", "not an address", Period{}); err == nil {
+		t.Error("the invalid address was inserted")
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package importer reads externally known names from CSV and JSON Lines files and merges them into the graph.
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/blacklist"
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// Source is the data source recorded for the evidence of imported names.
+const Source = "import"
+
+// The formats of the import files.
+const (
+	FormatCSV       = "csv"
+	FormatJSONLines = "jsonl"
+)
+
+// maxLineLength is the longest JSON Lines record that is read.
+const maxLineLength = 1 << 20
+
+// Record is a name read from an import file, along with the addresses it is known to resolve to.
+type Record struct {
+	Name      string
+	Addresses []net.IP
+	// Line is the first line of the file that provided the name
+	Line int
+}
+
+// Result summarizes the import of a file into the graph.
+type Result struct {
+	// Imported counts the names that were not in the graph before the import
+	Imported int
+	// Merged counts the names that were already in the graph
+	Merged     int
+	OutOfScope int
+	Addresses  int
+}
+
+// FormatFromPath returns the format indicated by the extension of the file name.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".jsonl", ".ndjson", ".json":
+		return FormatJSONLines
+	}
+	return ""
+}
+
+// ReadFile reads the records of the import file, which must have a .csv, .jsonl, .ndjson or .json extension.
+func ReadFile(path string) ([]*Record, []error) {
+	format := FormatFromPath(path)
+	if format == "" {
+		return nil, []error{fmt.Errorf("%s: the import format is not supported", path)}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer f.Close()
+
+	return Read(f, format)
+}
+
+// Read returns the valid records in the input, normalized and merged by name, and an error for each invalid line.
+// CSV input holds the name in the first column and addresses in the others, unless a header row names
+// the 'name' and 'address' or 'addresses' columns. JSON Lines input holds objects with the same fields.
+func Read(r io.Reader, format string) ([]*Record, []error) {
+	rr := &reader{byName: make(map[string]*Record)}
+
+	switch format {
+	case FormatCSV:
+		rr.readCSV(r)
+	case FormatJSONLines:
+		rr.readJSONLines(r)
+	default:
+		return nil, []error{fmt.Errorf("the import format %q is not supported", format)}
+	}
+	return rr.records, rr.errs
+}
+
+type reader struct {
+	records []*Record
+	byName  map[string]*Record
+	errs    []error
+}
+
+func (rr *reader) readCSV(r io.Reader) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	nameCol, addrCols := 0, []int(nil)
+	for first := true; ; first = false {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return
+		}
+
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			rr.errs = append(rr.errs, fmt.Errorf("line %d: %v", line, err))
+			continue
+		}
+		if first {
+			if n, a, ok := csvHeader(fields); ok {
+				nameCol, addrCols = n, a
+				continue
+			}
+		}
+		if nameCol >= len(fields) {
+			rr.errs = append(rr.errs, fmt.Errorf("line %d: the name column is missing", line))
+			continue
+		}
+
+		var addrs []string
+		if addrCols == nil {
+			addrs = append(addrs, fields[nameCol+1:]...)
+		}
+		for _, c := range addrCols {
+			if c < len(fields) {
+				addrs = append(addrs, strings.Fields(strings.ReplaceAll(fields[c], ";", " "))...)
+			}
+		}
+		rr.add(line, fields[nameCol], addrs)
+	}
+}
+
+// csvHeader returns the columns holding the names and addresses when the fields are a header row.
+func csvHeader(fields []string) (int, []int, bool) {
+	name := -1
+	addrs := []int{}
+
+	for i, f := range fields {
+		switch strings.ToLower(strings.TrimSpace(f)) {
+		case "name", "fqdn", "hostname", "subdomain":
+			name = i
+		case "address", "addresses", "ip", "ips":
+			addrs = append(addrs, i)
+		}
+	}
+	return name, addrs, name >= 0
+}
+
+type jsonRecord struct {
+	Name      string   `json:"name"`
+	Address   string   `json:"address"`
+	Addresses []string `json:"addresses"`
+}
+
+func (rr *reader) readJSONLines(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var jr jsonRecord
+		if err := json.Unmarshal([]byte(text), &jr); err != nil {
+			rr.errs = append(rr.errs, fmt.Errorf("line %d: %v", line, err))
+			continue
+		}
+
+		addrs := jr.Addresses
+		if jr.Address != "" {
+			addrs = append(addrs, jr.Address)
+		}
+		rr.add(line, jr.Name, addrs)
+	}
+	if err := scanner.Err(); err != nil {
+		rr.errs = append(rr.errs, err)
+	}
+}
+
+func (rr *reader) add(line int, name string, addrs []string) {
+	name = amassdns.RepairName(strings.TrimSpace(name))
+	if n, err := amassdns.NormalizeName(name); err == nil {
+		name = n
+	}
+
+	if name == "" {
+		rr.errs = append(rr.errs, fmt.Errorf("line %d: the name is missing", line))
+		return
+	}
+	if err := amassdns.ValidateName(name, true); err != nil {
+		rr.errs = append(rr.errs, fmt.Errorf("line %d: %v", line, err))
+		return
+	}
+
+	var ips []net.IP
+	for _, a := range addrs {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		ip := net.ParseIP(a)
+		if ip == nil {
+			rr.errs = append(rr.errs, fmt.Errorf("line %d: %s is not a valid IP address", line, a))
+			continue
+		}
+		ips = append(ips, ip)
+	}
+
+	rec, found := rr.byName[name]
+	if !found {
+		rec = &Record{Name: name, Line: line}
+		rr.byName[name] = rec
+		rr.records = append(rr.records, rec)
+	}
+	for _, ip := range ips {
+		if !hasIP(rec.Addresses, ip) {
+			rec.Addresses = append(rec.Addresses, ip)
+		}
+	}
+}
+
+func hasIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Import merges the records within the configured scope into the graph. Names already in the graph are
+// updated rather than duplicated. When the recorder is provided, the file and line of each imported name
+// are stored as its evidence, tagged with the import source.
+func Import(ctx context.Context, g *netmap.Graph, cfg *config.Config, file string, records []*Record, ev requests.EvidenceRecorder) (*Result, error) {
+	if g == nil || g.DB == nil {
+		return nil, errors.New("Import: the graph has not been initialized")
+	}
+	bl, err := blacklist.FromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Import: %v", err)
+	}
+
+	res := new(Result)
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if cfg.WhichDomain(rec.Name) == "" || bl.Blacklisted(rec.Name) {
+			res.OutOfScope++
+			continue
+		}
+
+		if assets, err := g.DB.FindByContent(domain.FQDN{Name: rec.Name}, time.Time{}); err == nil && len(assets) > 0 {
+			res.Merged++
+		} else {
+			res.Imported++
+		}
+		// The name and its addresses are written together, so an interrupted import leaves no fragments
+		addrs := make([]string, 0, len(rec.Addresses))
+		for _, ip := range rec.Addresses {
+			addrs = append(addrs, ip.String())
+		}
+		if err := systems.UpsertResolvedName(ctx, g, rec.Name, addrs...); err != nil {
+			return res, fmt.Errorf("Import: %s: %v", rec.Name, err)
+		}
+		res.Addresses += len(addrs)
+
+		if ev != nil {
+			fragment, _ := json.Marshal(map[string]interface{}{"file": filepath.Base(file), "line": rec.Line})
+			_, _ = ev.Add(rec.Name, Source, fragment)
+		}
+	}
+	return res, nil
+}
+
+// Requests returns the DNS requests that schedule the records within the configured scope for resolution
+// and data source expansion like any other finding. The file name is recorded as the parent of each name.
+// No request is returned when the blacklist is not valid, which Import reports.
+func Requests(cfg *config.Config, file string, records []*Record) []*requests.DNSRequest {
+	bl, err := blacklist.FromConfig(cfg)
+	if err != nil {
+		return nil
+	}
+
+	var reqs []*requests.DNSRequest
+
+	for _, rec := range records {
+		if d := cfg.WhichDomain(rec.Name); d != "" && !bl.Blacklisted(rec.Name) {
+			reqs = append(reqs, &requests.DNSRequest{
+				Name:       rec.Name,
+				Domain:     d,
+				Derivation: requests.DerivedFromImport,
+				Parent:     filepath.Base(file),
+			})
+		}
+	}
+	return reqs
+}
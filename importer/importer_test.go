@@ -0,0 +1,161 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+type fakeRecorder struct {
+	names     []string
+	sources   []string
+	fragments []string
+}
+
+func (f *fakeRecorder) Add(name, source string, fragment []byte) (string, error) {
+	f.names = append(f.names, name)
+	f.sources = append(f.sources, source)
+	f.fragments = append(f.fragments, string(fragment))
+	return "", nil
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		input    string
+		expected map[string]int
+		errs     int
+	}{
+		{
+			name:     "CSV without a header",
+			format:   FormatCSV,
+			input:    "WWW.owasp.org,192.0.2.1,2001:db8::1\nftp.owasp.org\n# comment\nbad name!.owasp.org\n",
+			expected: map[string]int{"www.owasp.org": 2, "ftp.owasp.org": 0},
+			errs:     1,
+		},
+		{
+			name:     "CSV with a header",
+			format:   FormatCSV,
+			input:    "source,hostname,ips\ncrt,www.owasp.org,192.0.2.1;192.0.2.2\nscan,www.owasp.org,192.0.2.2 192.0.2.3\nx,mail.owasp.org,nope\n",
+			expected: map[string]int{"www.owasp.org": 3, "mail.owasp.org": 0},
+			errs:     1,
+		},
+		{
+			name:     "JSON Lines",
+			format:   FormatJSONLines,
+			input:    "{\"name\":\"www.owasp.org\",\"address\":\"192.0.2.1\"}\n\n{\"name\":\"vpn.owasp.org\",\"addresses\":[\"192.0.2.4\"]}\n{\"name\":\n{\"address\":\"192.0.2.5\"}\n",
+			expected: map[string]int{"www.owasp.org": 1, "vpn.owasp.org": 1},
+			errs:     2,
+		},
+	}
+
+	for _, test := range tests {
+		records, errs := Read(strings.NewReader(test.input), test.format)
+		if len(errs) != test.errs {
+			t.Errorf("%s: Read returned the errors %v, expected %d", test.name, errs, test.errs)
+		}
+		if len(records) != len(test.expected) {
+			t.Errorf("%s: Read returned %d records, expected %d", test.name, len(records), len(test.expected))
+		}
+		for _, rec := range records {
+			if n, found := test.expected[rec.Name]; !found || n != len(rec.Addresses) {
+				t.Errorf("%s: Read returned %s with the addresses %v", test.name, rec.Name, rec.Addresses)
+			}
+		}
+	}
+}
+
+func TestReadLineNumbers(t *testing.T) {
+	_, errs := Read(strings.NewReader("www.owasp.org\n\n-bad-.owasp.org\n"), FormatCSV)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "line 3:") {
+		t.Errorf("Read returned the errors %v", errs)
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	tests := map[string]string{
+		"seeds.csv":    FormatCSV,
+		"seeds.JSONL":  FormatJSONLines,
+		"seeds.ndjson": FormatJSONLines,
+		"seeds.txt":    "",
+	}
+
+	for path, expected := range tests {
+		if got := FormatFromPath(path); got != expected {
+			t.Errorf("FormatFromPath(%s) returned %q, expected %q", path, got, expected)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+
+	g := netmap.NewGraph("memory", "", "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	defer g.Remove()
+
+	ctx := context.Background()
+	if _, err := g.UpsertFQDN(ctx, "www.owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, _ := Read(strings.NewReader("www.owasp.org,192.0.2.1\nvpn.owasp.org,2001:db8::4\nwww.example.com\n"), FormatCSV)
+	ev := new(fakeRecorder)
+	res, err := Import(ctx, g, cfg, "/tmp/seeds.csv", records, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 1 || res.Merged != 1 || res.OutOfScope != 1 || res.Addresses != 2 {
+		t.Errorf("Import returned %+v", *res)
+	}
+
+	// The known name was merged rather than duplicated
+	assets, err := g.DB.FindByScope([]oam.Asset{domain.FQDN{Name: "owasp.org"}}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var www int
+	for _, a := range assets {
+		if fqdn, ok := a.Asset.(domain.FQDN); ok && fqdn.Name == "www.owasp.org" {
+			www++
+		}
+	}
+	if www != 1 {
+		t.Errorf("the graph holds %d assets for www.owasp.org, expected 1", www)
+	}
+	if len(ev.names) != 2 || ev.sources[0] != Source || ev.fragments[0] != `{"file":"seeds.csv","line":1}` {
+		t.Errorf("Import recorded the evidence %v %v %v", ev.names, ev.sources, ev.fragments)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+
+	records := []*Record{{Name: "www.owasp.org", Line: 1}, {Name: "www.example.com", Line: 2}}
+	reqs := Requests(cfg, "/tmp/seeds.jsonl", records)
+	if len(reqs) != 1 {
+		t.Fatalf("Requests returned %d requests, expected 1", len(reqs))
+	}
+
+	req := reqs[0]
+	if req.Name != "www.owasp.org" || req.Domain != "owasp.org" ||
+		req.Derivation != requests.DerivedFromImport || req.Parent != "seeds.jsonl" {
+		t.Errorf("Requests returned %+v", *req)
+	}
+}
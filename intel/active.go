@@ -12,6 +12,7 @@ import (
 	"github.com/caffix/pipeline"
 	"github.com/caffix/queue"
 	"github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/policy"
 	"github.com/owasp-amass/amass/v4/requests"
 	"golang.org/x/net/publicsuffix"
 )
@@ -113,7 +114,7 @@ func (a *activeTask) certEnumeration(ctx context.Context, req *requests.AddrRequ
 	}
 
 	ip := net.ParseIP(req.Address)
-	if ip == nil {
+	if ip == nil || a.c.Policy.BlocksAddress(policy.Port, req.Address) {
 		return
 	}
 
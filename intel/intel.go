@@ -17,6 +17,8 @@ import (
 	"github.com/caffix/stringset"
 	"github.com/owasp-amass/amass/v4/datasrcs"
 	amassnet "github.com/owasp-amass/amass/v4/net"
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/amass/v4/rdap"
 	"github.com/owasp-amass/amass/v4/requests"
 	"github.com/owasp-amass/amass/v4/systems"
 	"github.com/owasp-amass/config/config"
@@ -30,6 +32,9 @@ const (
 	maxActivePipelineTasks int = 50
 )
 
+// registrationTimeout is the time allowed for the registration data of a domain to be obtained.
+const registrationTimeout = 30 * time.Second
+
 // Collection is the object type used to execute a open source information gathering with Amass.
 type Collection struct {
 	sync.Mutex
@@ -42,10 +47,28 @@ type Collection struct {
 	doneAlreadyClosed bool
 	filter            *bf.StableBloomFilter
 	timeChan          chan time.Time
+	// RDAP provides the registrant organizations of the domains for the reverse whois requests when set
+	RDAP *rdap.Client
+	// Registrations keeps the registration data obtained through RDAP when set
+	Registrations *rdap.Store
+	// Policy blocks the reverse DNS queries and the certificate pulls toward the never-touch list before the
+	// scope is consulted, and is loaded from the policy options
+	Policy *policy.Policy
 }
 
 // NewCollection returns an initialized Collection object that has not been started yet.
 func NewCollection(cfg *config.Config, sys systems.System) *Collection {
+	pol, err := policy.FromConfig(cfg)
+	if err != nil {
+		cfg.Log.Printf("%v: every active probe will be blocked", err)
+	}
+	pol.SetASNLookup(func(addr string) int {
+		if asn := sys.Cache().AddrSearch(addr); asn != nil {
+			return asn.ASN
+		}
+		return 0
+	})
+
 	return &Collection{
 		Config:   cfg,
 		Sys:      sys,
@@ -54,6 +77,7 @@ func NewCollection(cfg *config.Config, sys systems.System) *Collection {
 		done:     make(chan struct{}, 2),
 		filter:   bf.NewDefaultStableBloomFilter(1000000, 0.01),
 		timeChan: make(chan time.Time, 50),
+		Policy:   pol,
 	}
 }
 
@@ -137,6 +161,10 @@ func (c *Collection) makeDNSTaskFunc() pipeline.TaskFunc {
 		if ip == nil {
 			return nil, nil
 		}
+		// The addresses on the never-touch list are dropped, even when the netblocks in scope hold them
+		if c.Policy.BlocksAddress(policy.DNS, req.Address) {
+			return nil, nil
+		}
 
 		msg := resolve.ReverseMsg(req.Address)
 		if msg == nil {
@@ -241,10 +269,12 @@ func (c *Collection) ReverseWhois() error {
 			}
 		}
 	}()
-	// Send the whois requests to the data sources
-	for _, src := range c.srcs {
-		for _, domain := range c.Config.Domains() {
-			src.Input() <- &requests.WhoisRequest{Domain: domain}
+	// Send the whois requests to the data sources, along with the registrant organization of each domain
+	for _, domain := range c.Config.Domains() {
+		company := c.registrant(domain)
+
+		for _, src := range c.srcs {
+			src.Input() <- &requests.WhoisRequest{Domain: domain, Company: company}
 		}
 	}
 
@@ -270,6 +300,29 @@ loop:
 	return nil
 }
 
+// registrant returns the organization that registered the domain, as provided by RDAP.
+func (c *Collection) registrant(domain string) string {
+	if c.RDAP == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), registrationTimeout)
+	defer cancel()
+
+	reg, err := c.RDAP.Domain(ctx, domain)
+	if err != nil {
+		c.Config.Log.Printf("Failed to obtain the registration data of %s: %v", domain, err)
+		return ""
+	}
+	if c.Registrations != nil {
+		c.Registrations.SetDomain(domain, reg)
+	}
+	if reg.Org != "" {
+		c.Config.Log.Printf("%s is registered to %s through %s", domain, reg.Org, reg.Registrar)
+	}
+	return reg.Org
+}
+
 func (c *Collection) collect(req *requests.WhoisRequest) {
 	c.timeChan <- time.Now()
 
@@ -0,0 +1,461 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package journal keeps the findings that failed to be written to a remote graph database in a local file,
+// and replays them into the graph once it can be reached again, so an outage of the database does not lose
+// the findings of the enumeration.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// FileName is the name of the journal file in the output directory.
+	FileName = "graph_journal.jsonl"
+	// DefaultMaxSize is the size budget in bytes of the journal file.
+	DefaultMaxSize int64 = 64 << 20
+	// DefaultInterval is the time between the attempts to replay the journal during an enumeration.
+	DefaultInterval = 30 * time.Second
+	// maxLineLength is the longest journal line that is read.
+	maxLineLength = 1 << 20
+	// replaySuffix is appended to the name of the journal file while its records are replayed.
+	replaySuffix = ".replay"
+)
+
+// The operations of the journal records, which match the writes of the graph.
+const (
+	OpFQDN           = "fqdn"
+	OpA              = "a"
+	OpAAAA           = "aaaa"
+	OpCNAME          = "cname"
+	OpPTR            = "ptr"
+	OpSRV            = "srv"
+	OpNS             = "ns"
+	OpMX             = "mx"
+	OpNode           = "node"
+	OpInfrastructure = "infrastructure"
+)
+
+// Config is the configuration of the journal.
+type Config struct {
+	// MaxSize is the size budget in bytes, after which the oldest records are dropped
+	MaxSize int64
+	// Interval is the time between the attempts to replay the journal during an enumeration
+	Interval time.Duration
+}
+
+// ConfigFromOptions returns the journal settings found in the configuration options. The journal is kept
+// for the remote graph databases unless the options disable it, in which case nil is returned.
+func ConfigFromOptions(cfg *config.Config) *Config {
+	c := &Config{MaxSize: DefaultMaxSize, Interval: DefaultInterval}
+	if cfg == nil || cfg.Options == nil {
+		return c
+	}
+
+	opts, ok := cfg.Options["journal"].(map[string]interface{})
+	if !ok {
+		return c
+	}
+	if enabled, found := opts["enabled"].(bool); found && !enabled {
+		return nil
+	}
+	// The size budget is provided in megabytes
+	if mb := options.Int(opts["max_size"]); mb > 0 {
+		c.MaxSize = int64(mb) << 20
+	}
+	if secs := options.Int(opts["interval"]); secs > 0 {
+		c.Interval = time.Duration(secs) * time.Second
+	}
+	return c
+}
+
+// Remote returns true when the graph database system is not kept in the output directory or the memory
+// of the process, so its writes can fail while the enumeration carries on.
+func Remote(system string) bool {
+	return system != "" && system != "local" && system != "memory"
+}
+
+// Record is a write of the graph that failed. The address of the infrastructure records is held by Name,
+// and the domain of the node records is held by Name while the node is held by Target.
+type Record struct {
+	Op     string    `json:"op"`
+	Name   string    `json:"name"`
+	Target string    `json:"target,omitempty"`
+	ASN    int       `json:"asn,omitempty"`
+	Desc   string    `json:"desc,omitempty"`
+	CIDR   string    `json:"cidr,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Stats reports the records held by the journal.
+type Stats struct {
+	// Records is the number of records waiting to be replayed
+	Records int `json:"records"`
+	// Size is the size in bytes of the journal file
+	Size int64 `json:"size"`
+	// Dropped is the number of the oldest records dropped to keep the journal within its size budget
+	Dropped int `json:"dropped"`
+}
+
+// Journal is the file of the graph writes that failed, held as JSON Lines prefixed with their checksum,
+// so the records damaged by a crash or a faulty disk are detected and skipped when they are replayed.
+type Journal struct {
+	sync.Mutex
+	path    string
+	max     int64
+	f       *os.File
+	size    int64
+	records int
+	dropped int
+}
+
+// Open returns the journal kept in the file, with the size budget in bytes. The records left behind by a
+// replay that was interrupted are put back ahead of the others.
+func Open(path string, max int64) (*Journal, error) {
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+
+	j := &Journal{path: path, max: max}
+	if err := j.restoreReplay(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the graph journal: %v", err)
+	}
+	j.f = f
+
+	lines, err := readLines(path)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	for _, l := range lines {
+		j.size += int64(len(l))
+		j.records++
+	}
+	// The line cut by a crash is ended, so the next record does not run into it
+	if n := len(lines); n > 0 && !bytes.HasSuffix(lines[n-1], []byte("\n")) {
+		if _, err := f.Write([]byte("\n")); err == nil {
+			j.size++
+		}
+	}
+	return j, nil
+}
+
+// restoreReplay merges the records of an interrupted replay back into the journal file.
+func (j *Journal) restoreReplay() error {
+	pending, err := readLines(j.path + replaySuffix)
+	if err != nil || len(pending) == 0 {
+		_ = os.Remove(j.path + replaySuffix)
+		return nil
+	}
+
+	current, err := readLines(j.path)
+	if err != nil {
+		return err
+	}
+	if err := writeLines(j.path, append(pending, current...)); err != nil {
+		return err
+	}
+	return os.Remove(j.path + replaySuffix)
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// Path returns the path of the journal file.
+func (j *Journal) Path() string {
+	if j == nil {
+		return ""
+	}
+	return j.path
+}
+
+// Len returns the number of records waiting to be replayed.
+func (j *Journal) Len() int {
+	if j == nil {
+		return 0
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	return j.records
+}
+
+// Stats returns the number of records held by the journal, its size and the number of records dropped.
+func (j *Journal) Stats() Stats {
+	if j == nil {
+		return Stats{}
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	return Stats{Records: j.records, Size: j.size, Dropped: j.dropped}
+}
+
+// Append adds the record to the journal, dropping the oldest records when the size budget is exceeded.
+func (j *Journal) Append(rec Record) error {
+	if j == nil {
+		return nil
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+
+	line, err := encode(rec)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	if j.size+int64(len(line)) > j.max {
+		if err := j.trim(j.max - int64(len(line))); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.f.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write the graph journal: %v", err)
+	}
+	j.records++
+	return nil
+}
+
+// trim drops the oldest records until the journal file holds no more than a quarter below the budget,
+// so the file is not rewritten by each of the following records.
+func (j *Journal) trim(budget int64) error {
+	lines, err := readLines(j.path)
+	if err != nil {
+		return err
+	}
+
+	target := budget - j.max/4
+	var size int64
+	for _, l := range lines {
+		size += int64(len(l))
+	}
+
+	var drop int
+	for drop < len(lines) && size > target {
+		size -= int64(len(lines[drop]))
+		drop++
+	}
+	lines = lines[drop:]
+
+	_ = j.f.Close()
+	j.f = nil
+	if err := writeLines(j.path, lines); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the graph journal: %v", err)
+	}
+	j.f = f
+	j.size = size
+	j.records = len(lines)
+	j.dropped += drop
+	return nil
+}
+
+// takeLines moves the records of the journal aside to be replayed, leaving the journal empty for the
+// records of the writes failing in the meantime.
+func (j *Journal) takeLines() ([][]byte, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.f == nil {
+		return nil, os.ErrClosed
+	}
+	if j.records == 0 && j.size == 0 {
+		return nil, nil
+	}
+
+	_ = j.f.Close()
+	j.f = nil
+	if err := os.Rename(j.path, j.path+replaySuffix); err != nil {
+		return nil, fmt.Errorf("failed to set the graph journal aside: %v", err)
+	}
+
+	lines, err := readLines(j.path + replaySuffix)
+	if f, ferr := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644); ferr == nil {
+		j.f = f
+	} else if err == nil {
+		err = fmt.Errorf("failed to open the graph journal: %v", ferr)
+	}
+	j.size = 0
+	j.records = 0
+	return lines, err
+}
+
+// putBack returns the records that were not replayed to the journal, ahead of those appended since.
+func (j *Journal) putBack(pending [][]byte) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if len(pending) > 0 {
+		current, err := readLines(j.path)
+		if err != nil {
+			return err
+		}
+
+		if j.f != nil {
+			_ = j.f.Close()
+			j.f = nil
+		}
+		lines := append(pending, current...)
+		if err := writeLines(j.path, lines); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open the graph journal: %v", err)
+		}
+		j.f = f
+		j.size = 0
+		for _, l := range lines {
+			j.size += int64(len(l))
+		}
+		j.records = len(lines)
+	}
+	return os.Remove(j.path + replaySuffix)
+}
+
+// encode returns the journal line of the record, which is the checksum of its JSON followed by the JSON.
+func encode(rec Record) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, 0, len(data)+10)
+	line = append(line, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(data))...)
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+// decode returns the record of the journal line, or an error when the line does not match its checksum.
+func decode(line []byte) (*Record, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) < 10 || line[8] != ' ' {
+		return nil, fmt.Errorf("the record is truncated")
+	}
+
+	sum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("the checksum of the record is malformed")
+	}
+
+	data := line[9:]
+	if crc32.ChecksumIEEE(data) != uint32(sum) {
+		return nil, fmt.Errorf("the record does not match its checksum")
+	}
+
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// readLines returns the lines of the file, including their line endings, or none when it does not exist.
+func readLines(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the graph journal: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var lines [][]byte
+	r := bufio.NewReaderSize(f, 64<<10)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > maxLineLength {
+			line = line[:maxLineLength]
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return lines, fmt.Errorf("failed to read the graph journal: %v", err)
+		}
+	}
+	return lines, nil
+}
+
+// writeLines replaces the content of the file with the lines, through a temporary file, so a crash does not
+// leave the file half written.
+func writeLines(path string, lines [][]byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write the graph journal: %v", err)
+	}
+
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		_, _ = w.Write(l)
+		// A truncated last line is completed, so the records appended after it are kept whole
+		if len(l) > 0 && l[len(l)-1] != '\n' {
+			_ = w.WriteByte('\n')
+		}
+	}
+	err = w.Flush()
+	if e := f.Sync(); err == nil {
+		err = e
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write the graph journal: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
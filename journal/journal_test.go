@@ -0,0 +1,320 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package journal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/config/config"
+)
+
+var findings = []Record{
+	{Op: OpFQDN, Name: "owasp.org"},
+	{Op: OpA, Name: "www.owasp.org", Target: "192.0.2.1"},
+	{Op: OpAAAA, Name: "www.owasp.org", Target: "2001:db8::1"},
+	{Op: OpCNAME, Name: "docs.owasp.org", Target: "www.owasp.org"},
+	{Op: OpNS, Name: "owasp.org", Target: "ns1.owasp.org"},
+	{Op: OpMX, Name: "owasp.org", Target: "mail.owasp.org"},
+	{Op: OpNode, Name: "owasp.org", Target: "_dmarc.owasp.org"},
+	{Op: OpInfrastructure, Name: "192.0.2.1", ASN: 64496, Desc: "EXAMPLE-NET", CIDR: "192.0.2.0/24"},
+}
+
+func newGraph(t *testing.T) *netmap.Graph {
+	g := netmap.NewGraph("local", filepath.Join(t.TempDir(), "amass.sqlite"), "")
+	if g == nil {
+		t.Fatal("failed to create the graph")
+	}
+	return g
+}
+
+func openJournal(t *testing.T, path string, max int64) *Journal {
+	j, err := Open(path, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = j.Close() })
+	return j
+}
+
+func TestReplay(t *testing.T) {
+	j := openJournal(t, filepath.Join(t.TempDir(), FileName), 0)
+	for _, rec := range findings {
+		if err := j.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := j.Len(); n != len(findings) {
+		t.Errorf("the journal holds %d records, expected %d", n, len(findings))
+	}
+
+	g := newGraph(t)
+	res, err := j.Replay(context.Background(), g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Replayed != len(findings) || res.Existing != 0 || res.Pending != 0 || j.Len() != 0 {
+		t.Errorf("the replay returned %+v and left %d records", res, j.Len())
+	}
+
+	gt := &graphTarget{g: g}
+	for _, rec := range findings {
+		rec := rec
+		if found, err := gt.exists(context.Background(), &rec); err != nil || !found {
+			t.Errorf("the graph does not hold the %s record of %s", rec.Op, rec.Name)
+		}
+	}
+
+	// The records the graph already holds are not written again
+	for _, rec := range findings {
+		_ = j.Append(rec)
+	}
+	if res, err := j.Replay(context.Background(), g); err != nil || res.Existing != len(findings) || res.Replayed != 0 {
+		t.Errorf("the second replay returned %+v, %v", res, err)
+	}
+}
+
+func TestCorruptRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	j := openJournal(t, path, 0)
+	for _, rec := range findings[:3] {
+		_ = j.Append(rec)
+	}
+	_ = j.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A byte of the second record is flipped, and a record is cut short by a crash
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lines[1] = bytes.Replace(lines[1], []byte("www"), []byte("wwx"), 1)
+	line, _ := encode(findings[3])
+	data = append(bytes.Join(lines, nil), line[:len(line)/2]...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record appended after the cut one is kept whole
+	j = openJournal(t, path, 0)
+	_ = j.Append(findings[4])
+
+	ft := newFakeTarget()
+	res, err := j.replay(context.Background(), ft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Corrupt != 2 || !reflect.DeepEqual(res.CorruptLines, []int{2, 4}) || res.Replayed != 3 {
+		t.Errorf("the replay returned %+v", res)
+	}
+	if want := []string{"owasp.org", "www.owasp.org", "owasp.org"}; !reflect.DeepEqual(ft.names(), want) {
+		t.Errorf("the records replayed were %v, expected %v", ft.names(), want)
+	}
+	// The corrupt records are not kept for the next replay
+	if j.Len() != 0 {
+		t.Errorf("the journal kept %d records", j.Len())
+	}
+}
+
+func TestSizeBudget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	j := openJournal(t, path, 2000)
+
+	for i := 0; i < 100; i++ {
+		if err := j.Append(Record{Op: OpFQDN, Name: fmt.Sprintf("host%d.owasp.org", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := j.Stats()
+	if stats.Dropped == 0 || stats.Size > 2000 || stats.Records+stats.Dropped != 100 {
+		t.Errorf("the journal reported %+v", stats)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != stats.Size {
+		t.Errorf("the journal file does not have the size reported: %v", err)
+	}
+
+	// The newest records are kept
+	ft := newFakeTarget()
+	if _, err := j.replay(context.Background(), ft); err != nil {
+		t.Fatal(err)
+	}
+	names := ft.names()
+	if len(names) != stats.Records || names[len(names)-1] != "host99.owasp.org" || names[0] != fmt.Sprintf("host%d.owasp.org", stats.Dropped) {
+		t.Errorf("the records kept were %v", names)
+	}
+}
+
+func TestUnreachableGraph(t *testing.T) {
+	j := openJournal(t, filepath.Join(t.TempDir(), FileName), 0)
+	for _, rec := range findings {
+		_ = j.Append(rec)
+	}
+
+	// The graph goes down after the second record, while another write fails and is journaled
+	ft := newFakeTarget()
+	ft.down = 2
+	ft.onApply = func() { _ = j.Append(Record{Op: OpFQDN, Name: "late.owasp.org"}) }
+	res, err := j.replay(context.Background(), ft)
+	if !errors.Is(err, ErrUnreachable) {
+		t.Errorf("the replay returned the error %v, expected %v", err, ErrUnreachable)
+	}
+	if res.Replayed != 2 || res.Pending != len(findings)-2 || j.Len() != len(findings)-1 {
+		t.Errorf("the replay returned %+v and left %d records", res, j.Len())
+	}
+
+	// The records that were not replayed come before the one journaled during the replay
+	ft = newFakeTarget()
+	if res, err := j.replay(context.Background(), ft); err != nil || res.Replayed != len(findings)-1 {
+		t.Errorf("the replay returned %+v, %v", res, err)
+	}
+	if names := ft.names(); names[0] != "www.owasp.org" || names[len(names)-1] != "late.owasp.org" {
+		t.Errorf("the records were replayed in the order %v", names)
+	}
+}
+
+func TestRejectedRecords(t *testing.T) {
+	j := openJournal(t, filepath.Join(t.TempDir(), FileName), 0)
+	_ = j.Append(Record{Op: OpA, Name: "www.owasp.org", Target: "not an address"})
+	_ = j.Append(Record{Op: "unknown", Name: "www.owasp.org"})
+	_ = j.Append(findings[1])
+
+	res, err := j.Replay(context.Background(), newGraph(t))
+	if err != nil || res.Rejected != 2 || res.Replayed != 1 || j.Len() != 0 {
+		t.Errorf("the replay returned %+v, %v", res, err)
+	}
+}
+
+func TestInterruptedReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	j := openJournal(t, path, 0)
+	for _, rec := range findings[:2] {
+		_ = j.Append(rec)
+	}
+	if _, err := j.takeLines(); err != nil {
+		t.Fatal(err)
+	}
+	// The process stops during the replay, after another record was journaled
+	_ = j.Append(findings[2])
+	_ = j.Close()
+
+	j = openJournal(t, path, 0)
+	if n := j.Len(); n != 3 {
+		t.Errorf("the journal holds %d records, expected 3", n)
+	}
+	ft := newFakeTarget()
+	if _, err := j.replay(context.Background(), ft); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"owasp.org", "www.owasp.org", "www.owasp.org"}; !reflect.DeepEqual(ft.names(), want) {
+		t.Errorf("the records were replayed in the order %v, expected %v", ft.names(), want)
+	}
+	if _, err := os.Stat(path + replaySuffix); !os.IsNotExist(err) {
+		t.Errorf("the records set aside for the replay were left behind")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	src := newGraph(t)
+	dst := newGraph(t)
+
+	st := &graphTarget{g: src}
+	for _, rec := range findings {
+		rec := rec
+		if err := st.apply(context.Background(), &rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The remote graph received some of the findings before it went down
+	dt := &graphTarget{g: dst}
+	for _, rec := range findings[:3] {
+		rec := rec
+		_ = dt.apply(context.Background(), &rec)
+	}
+
+	res, err := Reconcile(context.Background(), src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Replayed == 0 || res.Existing == 0 || res.Rejected != 0 {
+		t.Errorf("the reconciliation returned %+v", res)
+	}
+	for _, rec := range findings {
+		rec := rec
+		if found, err := dt.exists(context.Background(), &rec); err != nil || !found {
+			t.Errorf("the graph does not hold the %s record of %s", rec.Op, rec.Name)
+		}
+	}
+
+	// Nothing is written once the graphs hold the same findings
+	if res, err := Reconcile(context.Background(), src, dst); err != nil || res.Replayed != 0 {
+		t.Errorf("the second reconciliation returned %+v, %v", res, err)
+	}
+}
+
+func TestConfigFromOptions(t *testing.T) {
+	cfg := config.NewConfig()
+	if c := ConfigFromOptions(cfg); c == nil || c.MaxSize != DefaultMaxSize || c.Interval != DefaultInterval {
+		t.Errorf("the default settings were %+v", c)
+	}
+
+	cfg.Options = map[string]interface{}{"journal": map[string]interface{}{"max_size": 8, "interval": 5.0}}
+	if c := ConfigFromOptions(cfg); c == nil || c.MaxSize != 8<<20 || c.Interval.Seconds() != 5 {
+		t.Errorf("the settings were %+v", c)
+	}
+
+	cfg.Options = map[string]interface{}{"journal": map[string]interface{}{"enabled": false}}
+	if c := ConfigFromOptions(cfg); c != nil {
+		t.Errorf("the disabled journal returned the settings %+v", c)
+	}
+
+	for system, remote := range map[string]bool{"postgres": true, "local": false, "memory": false, "": false} {
+		if Remote(system) != remote {
+			t.Errorf("the %q system was not taken as remote: %t", system, remote)
+		}
+	}
+}
+
+// fakeTarget records the records replayed, and can no longer be reached after the number of writes in down.
+type fakeTarget struct {
+	applied []*Record
+	down    int
+	onApply func()
+}
+
+func newFakeTarget() *fakeTarget {
+	return &fakeTarget{down: -1}
+}
+
+func (f *fakeTarget) exists(ctx context.Context, rec *Record) (bool, error) {
+	if f.down >= 0 && len(f.applied) >= f.down {
+		return false, errors.New("connection refused")
+	}
+	return false, nil
+}
+
+func (f *fakeTarget) apply(ctx context.Context, rec *Record) error {
+	if f.onApply != nil {
+		f.onApply()
+		f.onApply = nil
+	}
+	f.applied = append(f.applied, rec)
+	return nil
+}
+
+func (f *fakeTarget) names() []string {
+	var names []string
+	for _, rec := range f.applied {
+		names = append(names, rec.Name)
+	}
+	return names
+}
@@ -0,0 +1,404 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// ErrUnreachable is returned when the graph database could not be read while the records were replayed,
+// in which case the records that were not replayed are kept for the next attempt.
+var ErrUnreachable = errors.New("the graph database could not be reached")
+
+// relations maps the operations of the records to the relations they create in the graph.
+var relations = map[string]string{
+	OpA:     "a_record",
+	OpAAAA:  "aaaa_record",
+	OpCNAME: "cname_record",
+	OpPTR:   "ptr_record",
+	OpSRV:   "srv_record",
+	OpNS:    "ns_record",
+	OpMX:    "mx_record",
+	OpNode:  "node",
+}
+
+// Result reports the records replayed into the graph.
+type Result struct {
+	// Replayed is the number of records written to the graph
+	Replayed int `json:"replayed"`
+	// Existing is the number of records the graph already held, which were not written again
+	Existing int `json:"existing"`
+	// Rejected is the number of records the graph refused while it could be reached, which were dropped
+	Rejected int `json:"rejected"`
+	// Corrupt is the number of records that did not match their checksum, which were skipped
+	Corrupt int `json:"corrupt"`
+	// CorruptLines are the line numbers of the corrupt records in the journal that was replayed
+	CorruptLines []int `json:"corrupt_lines,omitempty"`
+	// Pending is the number of records kept for the next attempt, since the graph could not be reached
+	Pending int `json:"pending"`
+}
+
+// target is the graph the records are replayed into.
+type target interface {
+	// exists returns true when the graph holds the record, and an error when the graph cannot be read
+	exists(ctx context.Context, rec *Record) (bool, error)
+	apply(ctx context.Context, rec *Record) error
+}
+
+// Replay writes the records of the journal that are missing from the graph, and removes them from the
+// journal along with those the graph already holds. The replay stops once the graph cannot be reached,
+// returning ErrUnreachable, and the records that were not replayed are kept in the journal.
+func (j *Journal) Replay(ctx context.Context, g *netmap.Graph) (*Result, error) {
+	if g == nil || g.DB == nil {
+		return &Result{}, errors.New("the graph has not been initialized")
+	}
+	return j.replay(ctx, &graphTarget{g: g})
+}
+
+func (j *Journal) replay(ctx context.Context, t target) (*Result, error) {
+	res := &Result{}
+	if j == nil {
+		return res, nil
+	}
+
+	lines, err := j.takeLines()
+	if err != nil && len(lines) == 0 {
+		return res, err
+	}
+
+	var pending [][]byte
+	for i, line := range lines {
+		if err != nil {
+			pending = append(pending, line)
+			continue
+		}
+
+		rec, derr := decode(line)
+		if derr != nil {
+			res.Corrupt++
+			res.CorruptLines = append(res.CorruptLines, i+1)
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			pending = append(pending, line)
+			continue
+		}
+		if err = replayRecord(ctx, t, rec, res); err != nil {
+			pending = append(pending, line)
+		}
+	}
+
+	res.Pending = len(pending)
+	if perr := j.putBack(pending); perr != nil && err == nil {
+		err = perr
+	}
+	return res, err
+}
+
+// Reconcile writes the findings of the source graph, such as the local graph of a past enumeration, that are
+// missing from the destination graph. The reconciliation stops once the destination cannot be reached.
+func Reconcile(ctx context.Context, src, dst *netmap.Graph) (*Result, error) {
+	res := &Result{}
+	if src == nil || src.DB == nil || dst == nil || dst.DB == nil {
+		return res, errors.New("the graph has not been initialized")
+	}
+
+	recs, err := graphRecords(src)
+	if err != nil {
+		return res, err
+	}
+
+	t := &graphTarget{g: dst}
+	for i, rec := range recs {
+		if err := ctx.Err(); err != nil {
+			res.Pending = len(recs) - i
+			return res, err
+		}
+		if err := replayRecord(ctx, t, rec, res); err != nil {
+			res.Pending = len(recs) - i
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// replayRecord writes the record when the graph does not already hold it, and counts the outcome in the
+// result. ErrUnreachable is returned when the graph cannot be read.
+func replayRecord(ctx context.Context, t target, rec *Record, res *Result) error {
+	found, err := t.exists(ctx, rec)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	if found {
+		res.Existing++
+		return nil
+	}
+
+	if err := t.apply(ctx, rec); err != nil {
+		// The graph is read again to tell an outage apart from a record it refuses
+		if _, perr := t.exists(ctx, rec); perr != nil {
+			return fmt.Errorf("%w: %v", ErrUnreachable, err)
+		}
+		res.Rejected++
+		return nil
+	}
+	res.Replayed++
+	return nil
+}
+
+// graphRecords returns the records that rebuild the names, the records between them and the infrastructure
+// of the addresses held by the graph.
+func graphRecords(g *netmap.Graph) ([]*Record, error) {
+	var recs []*Record
+	t := &graphTarget{g: g}
+
+	// The graph returns an error when it holds no assets of the type
+	names, _ := g.DB.FindByType(oam.FQDN, time.Time{})
+	for _, a := range names {
+		fqdn, ok := a.Asset.(domain.FQDN)
+		if !ok {
+			continue
+		}
+
+		rels, err := g.DB.OutgoingRelations(a, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		var linked bool
+		for _, r := range rels {
+			op := relationOp(r.Type)
+			if op == "" {
+				continue
+			}
+
+			to, err := t.byID(r.ToAsset)
+			if err != nil {
+				return nil, err
+			}
+
+			var target string
+			switch v := to.Asset.(type) {
+			case domain.FQDN:
+				target = v.Name
+			case network.IPAddress:
+				target = v.Address.String()
+			default:
+				continue
+			}
+			recs = append(recs, &Record{Op: op, Name: fqdn.Name, Target: target})
+			linked = true
+		}
+		if !linked {
+			recs = append(recs, &Record{Op: OpFQDN, Name: fqdn.Name})
+		}
+	}
+
+	systems, _ := g.DB.FindByType(oam.ASN, time.Time{})
+	for _, a := range systems {
+		as, ok := a.Asset.(network.AutonomousSystem)
+		if !ok {
+			continue
+		}
+
+		var desc string
+		if rels, err := g.DB.OutgoingRelations(a, time.Time{}, "managed_by"); err == nil && len(rels) > 0 {
+			if org, err := t.byID(rels[0].ToAsset); err == nil {
+				if o, ok := org.Asset.(network.RIROrganization); ok {
+					desc = o.Name
+				}
+			}
+		}
+
+		blocks, err := g.DB.OutgoingRelations(a, time.Time{}, "announces")
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range blocks {
+			block, err := t.byID(b.ToAsset)
+			if err != nil {
+				return nil, err
+			}
+			nb, ok := block.Asset.(network.Netblock)
+			if !ok {
+				continue
+			}
+
+			addrs, err := g.DB.OutgoingRelations(block, time.Time{}, "contains")
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range addrs {
+				addr, err := t.byID(r.ToAsset)
+				if err != nil {
+					return nil, err
+				}
+				if ip, ok := addr.Asset.(network.IPAddress); ok {
+					recs = append(recs, &Record{
+						Op:   OpInfrastructure,
+						Name: ip.Address.String(),
+						ASN:  as.Number,
+						Desc: desc,
+						CIDR: nb.Cidr.String(),
+					})
+				}
+			}
+		}
+	}
+	return recs, nil
+}
+
+func relationOp(relation string) string {
+	for op, rel := range relations {
+		if rel == relation {
+			return op
+		}
+	}
+	return ""
+}
+
+// graphTarget replays the records into a graph database.
+type graphTarget struct {
+	g *netmap.Graph
+}
+
+func (t *graphTarget) apply(ctx context.Context, rec *Record) error {
+	g := t.g
+
+	switch rec.Op {
+	case OpFQDN:
+		_, err := g.UpsertFQDN(ctx, rec.Name)
+		return err
+	case OpA:
+		return g.UpsertA(ctx, rec.Name, rec.Target)
+	case OpAAAA:
+		return g.UpsertAAAA(ctx, rec.Name, rec.Target)
+	case OpCNAME:
+		return g.UpsertCNAME(ctx, rec.Name, rec.Target)
+	case OpPTR:
+		return g.UpsertPTR(ctx, rec.Name, rec.Target)
+	case OpSRV:
+		return g.UpsertSRV(ctx, rec.Name, rec.Target)
+	case OpNS:
+		return g.UpsertNS(ctx, rec.Name, rec.Target)
+	case OpMX:
+		return g.UpsertMX(ctx, rec.Name, rec.Target)
+	case OpNode:
+		parent, err := g.UpsertFQDN(ctx, rec.Name)
+		if err != nil {
+			return err
+		}
+		_, err = g.DB.Create(parent, "node", &domain.FQDN{Name: rec.Target})
+		return err
+	case OpInfrastructure:
+		return g.UpsertInfrastructure(ctx, rec.ASN, rec.Desc, rec.Name, rec.CIDR)
+	}
+	return fmt.Errorf("the operation %q is not known", rec.Op)
+}
+
+func (t *graphTarget) exists(ctx context.Context, rec *Record) (bool, error) {
+	switch rec.Op {
+	case OpFQDN:
+		a, err := t.find(&domain.FQDN{Name: rec.Name})
+		return a != nil, err
+	case OpA, OpAAAA:
+		ip := ipAsset(rec.Target)
+		if ip == nil {
+			return false, nil
+		}
+		return t.related(&domain.FQDN{Name: rec.Name}, relations[rec.Op], ip)
+	case OpCNAME, OpPTR, OpSRV, OpNS, OpMX, OpNode:
+		return t.related(&domain.FQDN{Name: rec.Name}, relations[rec.Op], &domain.FQDN{Name: rec.Target})
+	case OpInfrastructure:
+		ip := ipAsset(rec.Name)
+		prefix, err := netip.ParsePrefix(rec.CIDR)
+		if ip == nil || err != nil {
+			return false, nil
+		}
+
+		nb := &network.Netblock{Cidr: prefix, Type: ip.Type}
+		as := &network.AutonomousSystem{Number: rec.ASN}
+		for _, edge := range []struct {
+			from     oam.Asset
+			relation string
+			to       oam.Asset
+		}{
+			{from: nb, relation: "contains", to: ip},
+			{from: as, relation: "announces", to: nb},
+			{from: as, relation: "managed_by", to: &network.RIROrganization{Name: rec.Desc}},
+		} {
+			if found, err := t.related(edge.from, edge.relation, edge.to); err != nil || !found {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	// The unknown operations are refused by apply
+	return false, nil
+}
+
+// related returns true when the graph holds the relation between the assets.
+func (t *graphTarget) related(from oam.Asset, relation string, to oam.Asset) (bool, error) {
+	src, err := t.find(from)
+	if err != nil || src == nil {
+		return false, err
+	}
+
+	dst, err := t.find(to)
+	if err != nil || dst == nil {
+		return false, err
+	}
+
+	rels, err := t.g.DB.OutgoingRelations(src, time.Time{}, relation)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rels {
+		if r.ToAsset != nil && r.ToAsset.ID == dst.ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// find returns the asset of the graph holding the content, or nil when there is none.
+func (t *graphTarget) find(a oam.Asset) (*types.Asset, error) {
+	assets, err := t.g.DB.FindByContent(a, time.Time{})
+	if err != nil || len(assets) == 0 {
+		return nil, err
+	}
+	return assets[0], nil
+}
+
+// byID returns the asset referenced by a relation, which only carries its identifier.
+func (t *graphTarget) byID(a *types.Asset) (*types.Asset, error) {
+	if a == nil {
+		return nil, errors.New("the relation does not reference an asset")
+	}
+	return t.g.DB.FindById(a.ID, time.Time{})
+}
+
+func ipAsset(addr string) *network.IPAddress {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil
+	}
+
+	t := "IPv4"
+	if ip.Is6() {
+		t = "IPv6"
+	}
+	return &network.IPAddress{Address: ip, Type: t}
+}
@@ -0,0 +1,164 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package memory watches the memory consumed by a process and attributes it to the registered subsystems,
+// so the subsystems holding the most memory are asked to back off first once the limit is exceeded.
+package memory
+
+import (
+	"sort"
+	"sync"
+)
+
+// Estimator returns the number of bytes a subsystem is estimated to hold.
+type Estimator func() uint64
+
+// Usage is the memory a subsystem was estimated to hold at the last sample.
+type Usage struct {
+	Name  string
+	Bytes uint64
+	// Pressure is true when the subsystem was asked to back off
+	Pressure bool
+}
+
+// Monitor samples the memory consumption of the process and of the registered subsystems.
+type Monitor struct {
+	sync.Mutex
+	limit uint64
+	total func() uint64
+	names []string
+	ests  map[string]Estimator
+	// The results of the last sample
+	consumed uint64
+	usage    []Usage
+	pressure map[string]bool
+}
+
+// NewMonitor returns the Monitor comparing the bytes returned by total against the limit.
+func NewMonitor(limit uint64, total func() uint64) *Monitor {
+	return &Monitor{
+		limit:    limit,
+		total:    total,
+		ests:     make(map[string]Estimator),
+		pressure: make(map[string]bool),
+	}
+}
+
+// Register adds the subsystem, replacing the estimator of a subsystem registered with the same name.
+func (m *Monitor) Register(name string, est Estimator) {
+	if m == nil || est == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if _, found := m.ests[name]; !found {
+		m.names = append(m.names, name)
+	}
+	m.ests[name] = est
+}
+
+// Sample reads the memory consumed by the process and the estimates of the subsystems. Once the limit
+// is exceeded, the subsystems are ranked by their estimates, and those holding the most memory are put
+// under pressure until together they account for the excess. The smaller subsystems are only asked
+// to back off when the larger ones cannot explain the consumption.
+func (m *Monitor) Sample() {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	names := append([]string(nil), m.names...)
+	ests := make([]Estimator, 0, len(names))
+	for _, name := range names {
+		ests = append(ests, m.ests[name])
+	}
+	m.Unlock()
+
+	// The estimators may lock the subsystems, so they are called without holding the Monitor
+	usage := make([]Usage, 0, len(names))
+	for i, name := range names {
+		usage = append(usage, Usage{Name: name, Bytes: ests[i]()})
+	}
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].Bytes > usage[j].Bytes
+	})
+
+	var consumed uint64
+	if m.total != nil {
+		consumed = m.total()
+	}
+
+	pressure := make(map[string]bool)
+	if m.limit > 0 && consumed > m.limit {
+		excess := consumed - m.limit
+
+		var relieved uint64
+		for i := range usage {
+			if relieved >= excess || usage[i].Bytes == 0 {
+				break
+			}
+			usage[i].Pressure = true
+			pressure[usage[i].Name] = true
+			relieved += usage[i].Bytes
+		}
+	}
+
+	m.Lock()
+	m.consumed = consumed
+	m.usage = usage
+	m.pressure = pressure
+	m.Unlock()
+}
+
+// HighMemoryConsumption returns true when the process exceeded the limit at the last sample,
+// regardless of the subsystem responsible. A nil Monitor never reports high consumption.
+func (m *Monitor) HighMemoryConsumption() bool {
+	if m == nil {
+		return false
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.limit > 0 && m.consumed > m.limit
+}
+
+// Consumed returns the bytes consumed by the process at the last sample.
+func (m *Monitor) Consumed() uint64 {
+	if m == nil {
+		return 0
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.consumed
+}
+
+// Pressure returns true when the subsystem was asked to back off at the last sample.
+// A nil Monitor never puts the subsystems under pressure.
+func (m *Monitor) Pressure(name string) bool {
+	if m == nil {
+		return false
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.pressure[name]
+}
+
+// Usage returns the estimates of the subsystems at the last sample, from the largest to the smallest.
+func (m *Monitor) Usage() []Usage {
+	if m == nil {
+		return nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	return append([]Usage(nil), m.usage...)
+}
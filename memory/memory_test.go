@@ -0,0 +1,74 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package memory
+
+import "testing"
+
+func TestMonitorPressure(t *testing.T) {
+	var total uint64
+	sizes := map[string]uint64{"scheduler": 100, "graph": 600, "dedupe": 200}
+
+	m := NewMonitor(1000, func() uint64 { return total })
+	for _, name := range []string{"scheduler", "graph", "dedupe"} {
+		name := name
+		m.Register(name, func() uint64 { return sizes[name] })
+	}
+
+	total = 900
+	m.Sample()
+	if m.HighMemoryConsumption() || m.Pressure("graph") {
+		t.Error("the subsystems were put under pressure below the limit")
+	}
+
+	// The graph write buffer explains the excess, so the brute forcing and scheduler are not throttled
+	total = 1500
+	m.Sample()
+	if !m.HighMemoryConsumption() {
+		t.Error("the consumption above the limit was not reported")
+	}
+	if !m.Pressure("graph") || m.Pressure("dedupe") || m.Pressure("scheduler") {
+		t.Errorf("the pressure was applied as %+v", m.Usage())
+	}
+
+	// The next largest subsystem backs off once the largest cannot explain the excess alone
+	total = 1700
+	m.Sample()
+	if !m.Pressure("graph") || !m.Pressure("dedupe") || m.Pressure("scheduler") {
+		t.Errorf("the pressure was applied as %+v", m.Usage())
+	}
+
+	// The estimates decide the order, so the offender changes along with them
+	sizes["scheduler"] = 900
+	total = 1500
+	m.Sample()
+	if usage := m.Usage(); usage[0].Name != "scheduler" || !usage[0].Pressure || m.Pressure("graph") {
+		t.Errorf("the pressure was applied as %+v", usage)
+	}
+
+	total = 500
+	m.Sample()
+	if m.HighMemoryConsumption() || m.Pressure("scheduler") {
+		t.Error("the pressure was not released below the limit")
+	}
+}
+
+func TestMonitorRegister(t *testing.T) {
+	m := NewMonitor(10, func() uint64 { return 100 })
+	m.Register("cache", func() uint64 { return 1 })
+	m.Register("cache", func() uint64 { return 50 })
+	m.Sample()
+
+	if usage := m.Usage(); len(usage) != 1 || usage[0].Bytes != 50 || !m.Pressure("cache") {
+		t.Errorf("the replaced estimator was sampled as %+v", usage)
+	}
+
+	// A nil Monitor leaves the subsystems unthrottled
+	var disabled *Monitor
+	disabled.Register("cache", func() uint64 { return 1 })
+	disabled.Sample()
+	if disabled.HighMemoryConsumption() || disabled.Pressure("cache") || disabled.Usage() != nil {
+		t.Error("the nil Monitor reported pressure")
+	}
+}
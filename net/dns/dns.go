@@ -56,6 +56,18 @@ func RemoveAsteriskLabel(s string) string {
 	return s[startIndex+2:]
 }
 
+// WildcardZone returns the zone covered by the wildcard entry, such as those of the certificates, or an
+// empty string when the name is not a wildcard entry. Only a leading asterisk label makes a wildcard entry.
+func WildcardZone(name string) string {
+	n := strings.Trim(strings.ToLower(strings.TrimSpace(name)), ".")
+
+	zone := strings.TrimPrefix(n, "*.")
+	if zone == n || strings.Contains(zone, "*") {
+		return ""
+	}
+	return zone
+}
+
 // ReverseString returns the characters of the argument string in reverse order.
 func ReverseString(s string) string {
 	chrs := []rune(s)
@@ -90,6 +90,26 @@ func TestRemoveAsteriskLabel(t *testing.T) {
 	}
 }
 
+func TestWildcardZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"*.internal.owasp.org", "internal.owasp.org"},
+		{" *.Internal.OWASP.org. ", "internal.owasp.org"},
+		{"internal.owasp.org", ""},
+		{"www.*.owasp.org", ""},
+		{"*.*.owasp.org", ""},
+		{"*.", ""},
+	}
+
+	for _, test := range tests {
+		if zone := WildcardZone(test.name); zone != test.expected {
+			t.Errorf("%q returned the zone %q, expected %q", test.name, zone, test.expected)
+		}
+	}
+}
+
 func TestReverseString(t *testing.T) {
 	tests := []struct {
 		Value    string
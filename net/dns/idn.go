@@ -0,0 +1,89 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// idnProfile maps labels for lookup without applying the STD3 rules,
+// since names like _sip._tcp.example.com must survive the conversion.
+var idnProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.StrictDomainName(false),
+)
+
+// NormalizeName returns the ASCII (punycode) form of the provided DNS name, which is the form
+// used for resolution and storage. Labels that are already plain ASCII are only lowercased,
+// while labels containing unicode or punycode are validated and converted.
+func NormalizeName(name string) (string, error) {
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "", nil
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) && !hasACEPrefix(label) {
+			labels[i] = strings.ToLower(label)
+			continue
+		}
+
+		if hasACEPrefix(label) {
+			// Punycode must decode to a non-ASCII label and encode back to the same form
+			u, err := idnProfile.ToUnicode(label)
+			if err != nil || u == "" || isASCII(u) {
+				return "", fmt.Errorf("invalid punycode label %q in %s", label, name)
+			}
+			if a, err := idnProfile.ToASCII(u); err != nil || a != strings.ToLower(label) {
+				return "", fmt.Errorf("invalid punycode label %q in %s", label, name)
+			}
+		}
+
+		ascii, err := idnProfile.ToASCII(label)
+		if err != nil {
+			return "", fmt.Errorf("invalid internationalized label %q in %s: %v", label, name, err)
+		}
+		labels[i] = ascii
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// UnicodeName returns the unicode display form of the provided DNS name.
+// The name is returned unchanged when it contains no valid punycode labels.
+func UnicodeName(name string) string {
+	if !strings.Contains(strings.ToLower(name), "xn--") {
+		return name
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if !hasACEPrefix(label) {
+			continue
+		}
+		if u, err := idnProfile.ToUnicode(label); err == nil {
+			labels[i] = u
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func hasACEPrefix(label string) bool {
+	return len(label) >= 4 && strings.EqualFold(label[:4], "xn--")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package dns
+
+import (
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		err      bool
+	}{
+		{"Plain ASCII", "WWW.OWASP.org.", "www.owasp.org", false},
+		{"Service labels", "_sip._tcp.owasp.org", "_sip._tcp.owasp.org", false},
+		{"Double hyphen ASCII", "r3---sn-abc.googlevideo.com", "r3---sn-abc.googlevideo.com", false},
+		{"Latin unicode", "bücher.owasp.org", "xn--bcher-kva.owasp.org", false},
+		{"Uppercase punycode", "XN--BCHER-KVA.owasp.org", "xn--bcher-kva.owasp.org", false},
+		{"Cyrillic", "пример.испытание", "xn--e1afmkfd.xn--80akhbyknj4f", false},
+		{"Arabic", "مثال.إختبار", "xn--mgbh0fb.xn--kgbechtv", false},
+		{"Mixed script", "aбв.owasp.org", "xn--a-btbd.owasp.org", false},
+		{"Emoji", "i❤.ws", "xn--i-7iq.ws", false},
+		{"Emoji only label", "😀.owasp.org", "xn--e28h.owasp.org", false},
+		{"Invalid punycode", "xn--zz.owasp.org", "", true},
+		{"Punycode decoding to ASCII", "xn--abc-.owasp.org", "", true},
+		{"Empty punycode label", "xn--.owasp.org", "", true},
+		{"Non-canonical punycode", "xn--abc.owasp.org", "", true},
+	}
+
+	for _, tt := range tests {
+		result, err := NormalizeName(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: NormalizeName(%q) returned error %v", tt.name, tt.input, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("%s: NormalizeName(%q) returned %q, expected %q", tt.name, tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestUnicodeName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"www.owasp.org", "www.owasp.org"},
+		{"xn--bcher-kva.owasp.org", "bücher.owasp.org"},
+		{"xn--e1afmkfd.xn--80akhbyknj4f", "пример.испытание"},
+		{"xn--i-7iq.ws", "i❤.ws"},
+		{"xn--zz.owasp.org", "xn--zz.owasp.org"},
+	}
+
+	for _, tt := range tests {
+		if result := UnicodeName(tt.input); result != tt.expected {
+			t.Errorf("UnicodeName(%q) returned %q, expected %q", tt.input, result, tt.expected)
+		}
+	}
+}
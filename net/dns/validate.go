@@ -0,0 +1,91 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxNameLen  = 253
+	maxLabelLen = 63
+)
+
+// wrappingChars are removed from both ends of names extracted from scraped text.
+const wrappingChars = "\"'`<>()[]{}"
+
+// trailingChars are removed from the end of names extracted from scraped text.
+const trailingChars = ".,;:!?"
+
+// RepairName removes the wrapping quotes, brackets and trailing punctuation that commonly
+// surround names extracted from scraped text. The name is otherwise returned unchanged.
+func RepairName(name string) string {
+	for {
+		prev := name
+
+		name = strings.TrimSpace(name)
+		name = strings.Trim(name, wrappingChars)
+		name = strings.TrimRight(name, trailingChars)
+		if name == prev {
+			return name
+		}
+	}
+}
+
+// ValidateName checks that the name is a syntactically valid DNS name made of letters, digits and hyphens.
+// When services is true, labels starting with an underscore are also accepted, as used by service
+// records like _dmarc and _sip._tcp, but names with service labels are never valid host names.
+func ValidateName(name string, services bool) error {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return errors.New("the name is empty")
+	}
+	if len(name) > maxNameLen {
+		return fmt.Errorf("the name is longer than %d characters", maxNameLen)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if err := validateLabel(label, services); err != nil {
+			return fmt.Errorf("the label %q in %s %v", label, name, err)
+		}
+	}
+	return nil
+}
+
+func validateLabel(label string, services bool) error {
+	if label == "" {
+		return errors.New("is empty")
+	}
+	if len(label) > maxLabelLen {
+		return fmt.Errorf("is longer than %d characters", maxLabelLen)
+	}
+	// Wildcard labels are handled by the callers
+	if label == "*" {
+		return nil
+	}
+
+	ldh := label
+	if label[0] == '_' {
+		if !services {
+			return errors.New("is only allowed for service records")
+		}
+		ldh = label[1:]
+		if ldh == "" {
+			return errors.New("has no characters following the underscore")
+		}
+	}
+	if ldh[0] == '-' || ldh[len(ldh)-1] == '-' {
+		return errors.New("starts or ends with a hyphen")
+	}
+
+	for i := 0; i < len(ldh); i++ {
+		if c := ldh[i]; !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' {
+			return fmt.Errorf("contains the invalid character %q", c)
+		}
+	}
+	return nil
+}
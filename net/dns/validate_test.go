@@ -0,0 +1,71 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepairName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"www.owasp.org", "www.owasp.org"},
+		{"\"www.owasp.org\"", "www.owasp.org"},
+		{"'www.owasp.org',", "www.owasp.org"},
+		{"(www.owasp.org).", "www.owasp.org"},
+		{"<www.owasp.org>;", "www.owasp.org"},
+		{" `www.owasp.org`! ", "www.owasp.org"},
+		{"www.owasp.org...", "www.owasp.org"},
+		{"www owasp.org", "www owasp.org"},
+	}
+
+	for _, test := range tests {
+		if result := RepairName(test.input); result != test.expected {
+			t.Errorf("RepairName(%q) returned %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		host     bool
+		services bool
+	}{
+		{"Plain name", "www.owasp.org", true, true},
+		{"Trailing dot", "www.owasp.org.", true, true},
+		{"Digits and hyphens", "r3---sn-abc1.googlevideo.com", true, true},
+		{"Punycode", "xn--bcher-kva.owasp.org", true, true},
+		{"Uppercase", "WWW.OWASP.ORG", true, true},
+		{"DMARC", "_dmarc.owasp.org", false, true},
+		{"SRV", "_sip._tcp.owasp.org", false, true},
+		{"DKIM", "selector1._domainkey.owasp.org", false, true},
+		{"Space", "www owasp.org", false, false},
+		{"Underscore in host label", "my_host.owasp.org", false, false},
+		{"Bare underscore", "_.owasp.org", false, false},
+		{"Leading hyphen", "-www.owasp.org", false, false},
+		{"Trailing hyphen", "www-.owasp.org", false, false},
+		{"Empty label", "www..owasp.org", false, false},
+		{"Long label", strings.Repeat("a", 64) + ".owasp.org", false, false},
+		{"Maximum label", strings.Repeat("a", 63) + ".owasp.org", true, true},
+		{"Long name", strings.Repeat("abcdefghi.", 26) + "org", false, false},
+		{"Quotes", "\"www.owasp.org\"", false, false},
+		{"Slash", "www.owasp.org/index.html", false, false},
+		{"Empty", "", false, false},
+	}
+
+	for _, test := range tests {
+		if err := ValidateName(test.input, false); (err == nil) != test.host {
+			t.Errorf("%s: ValidateName(%q, false) returned %v", test.name, test.input, err)
+		}
+		if err := ValidateName(test.input, true); (err == nil) != test.services {
+			t.Errorf("%s: ValidateName(%q, true) returned %v", test.name, test.input, err)
+		}
+	}
+}
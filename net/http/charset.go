@@ -0,0 +1,292 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io"
+	"mime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// charsetPreviewSize is the number of bytes examined for the charset of a streamed response.
+const charsetPreviewSize = 4096
+
+// maxEntityLen is the longest HTML character reference decoded, such as '&CounterClockwiseContourIntegral;'.
+const maxEntityLen = 34
+
+// detectedCharsets are tried in turn for the responses that do not declare their charset and are not UTF-8.
+// Their kana are encoded with bytes that are rare in the Latin charsets, so a match is reliable.
+var detectedCharsets = []encoding.Encoding{japanese.ShiftJIS, japanese.EUCJP}
+
+// DecodeBody returns the body transcoded to UTF-8 from the charset declared by the Content-Type header,
+// a byte order mark or a meta tag, or detected from the content when none was declared. The bodies that
+// are not text, such as images and archives, are returned unchanged.
+func DecodeBody(body []byte, contentType string) string {
+	if !textual(contentType) {
+		return string(body)
+	}
+
+	e := bodyEncoding(body, contentType)
+	if e == nil {
+		return string(body)
+	}
+	if decoded, err := e.NewDecoder().Bytes(body); err == nil {
+		return string(decoded)
+	}
+	return string(body)
+}
+
+// NewBodyReader returns a reader transcoding the streamed body to UTF-8, like DecodeBody does,
+// with the charset detected from the beginning of the body.
+func NewBodyReader(r io.Reader, contentType string) io.Reader {
+	if !textual(contentType) {
+		return r
+	}
+
+	preview := make([]byte, charsetPreviewSize)
+	n, err := io.ReadFull(r, preview)
+	preview = preview[:n]
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return io.MultiReader(bytes.NewReader(preview), errReader{err})
+	}
+
+	r = io.MultiReader(bytes.NewReader(preview), r)
+	if e := bodyEncoding(preview, contentType); e != nil {
+		r = transform.NewReader(r, e.NewDecoder())
+	}
+	return r
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+// bodyEncoding returns the charset of the body, or nil when it is already UTF-8 or plain ASCII.
+func bodyEncoding(body []byte, contentType string) encoding.Encoding {
+	e, name, certain := charset.DetermineEncoding(body, contentType)
+	if name == "utf-8" {
+		return nil
+	}
+	if certain || name != "windows-1252" || declared(body, contentType) {
+		return e
+	}
+
+	// Nothing was declared, and windows-1252 is only the default of the HTML specification
+	if utf8.Valid(trimPartialRune(body)) {
+		return nil
+	}
+	for _, d := range detectedCharsets {
+		if detectJapanese(body, d) {
+			return d
+		}
+	}
+	return e
+}
+
+// declared returns true when the Content-Type header or a meta tag names the charset of the body.
+func declared(body []byte, contentType string) bool {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		return true
+	}
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	return bytes.Contains(bytes.ToLower(body), []byte("charset"))
+}
+
+// detectJapanese returns true when the body decodes without errors, and holds kana among its characters.
+func detectJapanese(body []byte, e encoding.Encoding) bool {
+	decoded, err := e.NewDecoder().Bytes(body)
+	if err != nil {
+		return false
+	}
+
+	var kana int
+	for len(decoded) > 0 {
+		r, size := utf8.DecodeRune(decoded)
+		decoded = decoded[size:]
+
+		switch {
+		case r == utf8.RuneError:
+			// A character cut at the end of a preview is not held against the charset
+			if len(decoded) > 0 {
+				return false
+			}
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana) && r < 0xff00:
+			kana++
+		}
+	}
+	return kana > 0
+}
+
+// trimPartialRune removes the bytes of a character cut at the end of the body.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i > len(b)-utf8.UTFMax; i-- {
+		if b[i] < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// textual returns true when the Content-Type is missing or names content that can hold DNS names.
+func textual(contentType string) bool {
+	if strings.TrimSpace(contentType) == "" {
+		return true
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	if strings.HasPrefix(mt, "text/") {
+		return true
+	}
+	for _, t := range []string{"html", "xml", "json", "javascript", "ecmascript"} {
+		if strings.Contains(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeText decodes the percent-encoded bytes, the HTML character references and the JavaScript escapes
+// of the text, so the DNS names inside encoded URLs and escaped markup are found whole by the hostname
+// regular expression, and the names found are cleaned with CleanDecodedName.
+func DecodeText(s string) string {
+	if !strings.ContainsAny(s, "%&\\") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		if escapeStart(s[i]) {
+			if out, n := unescape(s[i:]); n > 0 {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+func escapeStart(c byte) bool {
+	return c == '%' || c == '&' || c == '\\'
+}
+
+// unescape decodes the percent-encoded byte, the character reference or the JavaScript escape at the start
+// of s, and returns the number of bytes consumed, which is zero when s does not start with any of them.
+func unescape(s string) (string, int) {
+	switch {
+	case len(s) >= 3 && s[0] == '%' && ishex(s[1]) && ishex(s[2]):
+		return string([]byte{unhex(s[1])<<4 | unhex(s[2])}), 3
+	case len(s) >= 4 && s[0] == '\\' && s[1] == 'x' && ishex(s[2]) && ishex(s[3]):
+		return string(rune(unhex(s[2])<<4 | unhex(s[3]))), 4
+	case len(s) >= 6 && s[0] == '\\' && s[1] == 'u' && ishex(s[2]) && ishex(s[3]) && ishex(s[4]) && ishex(s[5]):
+		r := rune(unhex(s[2]))<<12 | rune(unhex(s[3]))<<8 | rune(unhex(s[4]))<<4 | rune(unhex(s[5]))
+		// The halves of the surrogate pairs are left alone
+		if utf8.ValidRune(r) {
+			return string(r), 6
+		}
+	case len(s) >= 3 && s[0] == '&':
+		end := strings.IndexByte(s[:min(len(s), maxEntityLen)], ';')
+		if end < 2 {
+			return "", 0
+		}
+		if ref := s[:end+1]; strings.IndexAny(ref[1:], "&% \t\r\n<>") == -1 {
+			if out := html.UnescapeString(ref); out != ref {
+				return out, end + 1
+			}
+		}
+	}
+	return "", 0
+}
+
+// NewTextReader returns a reader decoding the escapes of the streamed content, like DecodeText does.
+func NewTextReader(r io.Reader) io.Reader {
+	return &textReader{r: bufio.NewReaderSize(r, 4096)}
+}
+
+type textReader struct {
+	r       *bufio.Reader
+	pending []byte
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	var n int
+
+	for n < len(p) {
+		if len(t.pending) > 0 {
+			c := copy(p[n:], t.pending)
+			t.pending = t.pending[c:]
+			n += c
+			continue
+		}
+
+		c, err := t.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if escapeStart(c) {
+			// The escape is examined without consuming the bytes that follow it
+			_ = t.r.UnreadByte()
+			peek, _ := t.r.Peek(maxEntityLen)
+
+			if out, size := unescape(string(peek)); size > 0 {
+				_, _ = t.r.Discard(size)
+				t.pending = append(t.pending[:0], out...)
+				continue
+			}
+			_, _ = t.r.ReadByte()
+		}
+		p[n] = c
+		n++
+	}
+	return n, nil
+}
+
+func ishex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
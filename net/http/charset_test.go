@@ -0,0 +1,194 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// extractNames runs the hostname regular expression over the decoded text, as the data sources do.
+func extractNames(body string) []string {
+	seen := make(map[string]struct{})
+
+	var names []string
+	for _, match := range subRE.FindAllString(DecodeText(body), -1) {
+		if n := CleanDecodedName(match); n != "" {
+			if _, found := seen[n]; !found {
+				seen[n] = struct{}{}
+				names = append(names, n)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		fixture     string
+		contentType string
+		title       string
+		want        []string
+	}{
+		{
+			name:        "charset of the meta tag",
+			fixture:     "latin1.html",
+			contentType: "text/html",
+			title:       "Résumé de l'équipe",
+			want: []string{"2fa.owasp.org", "intranet.owasp.org", "miroir.owasp.org",
+				"owasp.org", "portail.owasp.org"},
+		},
+		{
+			name:        "charset of the header",
+			fixture:     "latin1.html",
+			contentType: "text/html; charset=iso-8859-1",
+			title:       "Résumé de l'équipe",
+			want: []string{"2fa.owasp.org", "intranet.owasp.org", "miroir.owasp.org",
+				"owasp.org", "portail.owasp.org"},
+		},
+		{
+			name:        "charset of the header",
+			fixture:     "shiftjis.html",
+			contentType: "text/html; charset=Shift_JIS",
+			title:       "日本支部のサイト",
+			want:        []string{"dev.owasp.jp", "login.owasp.jp", "mirror.owasp.jp", "www.owasp.jp"},
+		},
+		{
+			name:        "detected charset",
+			fixture:     "shiftjis.html",
+			contentType: "text/html",
+			title:       "日本支部のサイト",
+			want:        []string{"dev.owasp.jp", "login.owasp.jp", "mirror.owasp.jp", "www.owasp.jp"},
+		},
+	}
+
+	for _, test := range tests {
+		page, err := os.ReadFile(filepath.Join("testdata", test.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			_, _ = w.Write(page)
+		}))
+
+		resp, err := RequestWebPage(context.Background(), &Request{URL: ts.URL})
+		ts.Close()
+		if err != nil {
+			t.Fatalf("%s of %s: %v", test.name, test.fixture, err)
+		}
+		if !utf8.ValidString(resp.Body) || !strings.Contains(resp.Body, test.title) {
+			t.Errorf("%s of %s: the body was not transcoded to UTF-8", test.name, test.fixture)
+		}
+		if got := extractNames(resp.Body); strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s of %s: the names extracted were %v, expected %v", test.name, test.fixture, got, test.want)
+		}
+	}
+}
+
+func TestDecodeBodyUnchanged(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "shiftjis.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := DecodeBody(page, "image/png")
+	if got != string(page) {
+		t.Errorf("the body of the image was transcoded")
+	}
+	// The trail bytes of the Shift-JIS characters are letters, which stick to the names when left undecoded
+	if names := strings.Join(extractNames(got), ","); !strings.Contains(names, "gwww.owasp.jp") {
+		t.Errorf("the names extracted from the undecoded page were %s", names)
+	}
+
+	for _, body := range []string{"plain www.owasp.org", "utf-8 日本 www.owasp.org"} {
+		if got := DecodeBody([]byte(body), ""); got != body {
+			t.Errorf("the body %q was transcoded to %q", body, got)
+		}
+	}
+	// A Latin page without a declaration is not taken for Shift-JIS, even though its bytes decode as such
+	if got := DecodeBody([]byte("r\xe9sum\xe9s \xdcber www.owasp.org"), "text/plain"); got != "résumés Über www.owasp.org" {
+		t.Errorf("the Latin page was decoded as %q", got)
+	}
+}
+
+func TestNewBodyReader(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "shiftjis.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(NewBodyReader(strings.NewReader(string(page)), "application/javascript"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := extractNames(string(body)); len(got) != 4 || got[3] != "www.owasp.jp" {
+		t.Errorf("the names extracted from the stream were %v", got)
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"https%3A%2F%2Fwww.owasp.org%2F", "https://www.owasp.org/"},
+		{"mail&#x2E;owasp&#46;org &amp; dev.owasp.org", "mail.owasp.org & dev.owasp.org"},
+		{"\"https:\\u002F\\u002Fapi.owasp.org\\x2Fv1\"", "\"https://api.owasp.org/v1\""},
+		{"100% &unknown; 50%zz \\u12 &", "100% &unknown; 50%zz \\u12 &"},
+		{"xn--caf-dma.owasp.org%2C%20b%C3%BCcher.owasp.org", "xn--caf-dma.owasp.org, bücher.owasp.org"},
+	}
+
+	for _, test := range tests {
+		if got := DecodeText(test.text); got != test.want {
+			t.Errorf("the text %q was decoded as %q, expected %q", test.text, got, test.want)
+		}
+
+		// The stream is decoded the same way, regardless of the reads cutting the escapes
+		got, err := io.ReadAll(io.LimitReader(NewTextReader(&oneByteReader{s: test.text}), 1<<20))
+		if err != nil || string(got) != test.want {
+			t.Errorf("the streamed text %q was decoded as %q, expected %q", test.text, got, test.want)
+		}
+	}
+}
+
+type oneByteReader struct {
+	s string
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.s) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	p[0] = r.s[0]
+	r.s = r.s[1:]
+	return 1, nil
+}
+
+func TestCleanDecodedName(t *testing.T) {
+	if got := CleanName("2fa.owasp.org"); got != "a.owasp.org" {
+		t.Errorf("the escaped name was cleaned to %s", got)
+	}
+	if got := CleanDecodedName("2fa.owasp.org"); got != "2fa.owasp.org" {
+		t.Errorf("the decoded name was cleaned to %s", got)
+	}
+	if got := CleanDecodedName("-WWW.owasp.org."); got != "www.owasp.org" {
+		t.Errorf("the decoded name was cleaned to %s", got)
+	}
+}
@@ -21,11 +21,13 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/caffix/stringset"
 	"github.com/geziyor/geziyor"
 	"github.com/geziyor/geziyor/client"
+	"github.com/owasp-amass/amass/v4/bandwidth"
 	amassnet "github.com/owasp-amass/amass/v4/net"
 	"github.com/owasp-amass/amass/v4/net/dns"
 	bf "github.com/tylertreat/BoomFilters"
@@ -41,6 +43,10 @@ const (
 	darwinUserAgent  = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36"
 	httpTimeout      = 10 * time.Second
 	handshakeTimeout = 5 * time.Second
+	// DefaultMaxBodySize is the number of response body bytes read when a request does not set a limit.
+	DefaultMaxBodySize int64 = 16 << 20
+	// DefaultMaxRedirects is the number of redirects followed when a request does not set a limit.
+	DefaultMaxRedirects = 10
 )
 
 var (
@@ -48,6 +54,7 @@ var (
 	UserAgent   string
 	subRE       = dns.AnySubdomainRegex()
 	nameStripRE = regexp.MustCompile(`^(u[0-9a-f]{4}|20|22|25|27|2b|2f|3d|3a|40)`)
+	idnNameRE   = regexp.MustCompile(`[\p{L}\p{M}\p{N}\p{So}_-]+(\.[\p{L}\p{M}\p{N}\p{So}_-]+)+`)
 )
 
 // DefaultClient is the same HTTP client used by the package methods.
@@ -63,6 +70,16 @@ type Request struct {
 	Header Header
 	Body   string
 	Auth   *BasicAuth
+	// UserAgents are rotated across the requests, and the default UserAgent is sent when not provided
+	UserAgents *UserAgents
+	// StaticUserAgent opts the request out of user agent rotation
+	StaticUserAgent bool
+	// MaxBodySize caps the response body bytes read, and defaults to DefaultMaxBodySize
+	MaxBodySize int64
+	// Timeout caps the total duration of the request, including the body
+	Timeout time.Duration
+	// MaxRedirects caps the redirects followed, and a negative value disables them
+	MaxRedirects int
 }
 
 // Response represents the HTTP response in the Amass preferred format.
@@ -76,6 +93,8 @@ type Response struct {
 	Body       string
 	Length     int64
 	TLS        *tls.ConnectionState
+	// Truncated is set when the body was cut by the size or duration limits of the request
+	Truncated bool
 }
 
 // BasicAuth contains the data used for HTTP basic authentication.
@@ -90,7 +109,7 @@ func init() {
 		Timeout: httpTimeout,
 		Transport: &http.Transport{
 			Proxy:                 http.ProxyFromEnvironment,
-			DialContext:           amassnet.DialContext,
+			DialContext:           meteredDial,
 			MaxIdleConns:          200,
 			MaxConnsPerHost:       50,
 			IdleConnTimeout:       10 * time.Second,
@@ -111,6 +130,60 @@ func init() {
 	}
 }
 
+// MaxConnsPerHost returns the limit on the connections per host opened by the DefaultClient.
+func MaxConnsPerHost() int {
+	if t, ok := DefaultClient.Transport.(*http.Transport); ok {
+		return t.MaxConnsPerHost
+	}
+	return 0
+}
+
+// SetMaxConnsPerHost changes the limit on the connections per host opened by the DefaultClient,
+// and should be called before the data sources begin making requests.
+func SetMaxConnsPerHost(n int) {
+	if t, ok := DefaultClient.Transport.(*http.Transport); ok && n > 0 {
+		t.MaxConnsPerHost = n
+	}
+}
+
+// UserAgents rotates a list of user agents across the requests it is assigned to.
+// A nil or empty UserAgents always provides the default UserAgent.
+type UserAgents struct {
+	sync.Mutex
+	agents []string
+	index  int
+}
+
+// NewUserAgents returns the UserAgents rotating the provided user agents, ignoring the blank ones.
+func NewUserAgents(agents ...string) *UserAgents {
+	u := new(UserAgents)
+
+	for _, ua := range agents {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			u.agents = append(u.agents, ua)
+		}
+	}
+	return u
+}
+
+// Next returns the user agent to be sent with the next request.
+func (u *UserAgents) Next() string {
+	if u == nil {
+		return UserAgent
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	if len(u.agents) == 0 {
+		return UserAgent
+	}
+
+	ua := u.agents[u.index]
+	u.index = (u.index + 1) % len(u.agents)
+	return ua
+}
+
 // HdrToAmassHeader converts a net/http Header to an Amass Header.
 func HdrToAmassHeader(hdr http.Header) Header {
 	h := make(Header)
@@ -154,11 +227,39 @@ func RespToAmassResponse(resp *http.Response) *Response {
 	var body string
 	if resp.Body != nil {
 		if b, err := io.ReadAll(resp.Body); err == nil {
-			body = string(b)
+			body = DecodeBody(b, resp.Header.Get("Content-Type"))
+		}
+		_ = resp.Body.Close()
+	}
+	return newResponse(resp, body)
+}
+
+// limitedResponse converts the net/http Response while reading no more than max body bytes.
+// The prefix read before an error or the limit is kept, so names can still be extracted from it.
+// The body is transcoded to UTF-8, so the names of pages served in other charsets are not mangled.
+func limitedResponse(resp *http.Response, max int64) *Response {
+	var body []byte
+	var truncated bool
+	if resp.Body != nil {
+		var err error
+
+		body, err = io.ReadAll(io.LimitReader(resp.Body, max+1))
+		if int64(len(body)) > max {
+			body = body[:max]
+			truncated = true
+		} else if err != nil {
+			// the request duration expired while reading the body
+			truncated = true
 		}
 		_ = resp.Body.Close()
 	}
 
+	r := newResponse(resp, DecodeBody(body, resp.Header.Get("Content-Type")))
+	r.Truncated = truncated
+	return r
+}
+
+func newResponse(resp *http.Response, body string) *Response {
 	return &Response{
 		Status:     resp.Status,
 		StatusCode: resp.StatusCode,
@@ -202,7 +303,7 @@ func RequestWebPage(ctx context.Context, r *Request) (*Response, error) {
 
 	if r.Method == "" {
 		r.Method = "GET"
-	} else if r.Method != "GET" && r.Method != "POST" {
+	} else if r.Method != "GET" && r.Method != "POST" && r.Method != "HEAD" {
 		return nil, errors.New("failed to provide a valid HTTP method")
 	}
 
@@ -216,28 +317,79 @@ func RequestWebPage(ctx context.Context, r *Request) (*Response, error) {
 		req.SetBasicAuth(r.Auth.Username, r.Auth.Password)
 	}
 
-	req.Header.Set("User-Agent", UserAgent)
+	ua := UserAgent
+	if !r.StaticUserAgent {
+		ua = r.UserAgents.Next()
+	}
+	req.Header.Set("User-Agent", ua)
 	req.Header.Set("Accept", Accept)
 	req.Header.Set("Accept-Language", AcceptLang)
 	for k, v := range r.Header {
 		req.Header.Set(k, v)
 	}
 
-	resp, err := DefaultClient.Do(req)
+	resp, err := limitedClient(r).Do(req)
 	if err != nil {
 		return nil, err
 	}
-	return RespToAmassResponse(resp), nil
+
+	max := r.MaxBodySize
+	if max <= 0 {
+		max = DefaultMaxBodySize
+	}
+	return limitedResponse(resp, max), nil
+}
+
+// limitedClient returns a client sharing the DefaultClient transport and cookies,
+// which enforces the duration and redirect limits of the request.
+func limitedClient(r *Request) *http.Client {
+	client := *DefaultClient
+	if r.Timeout > 0 {
+		client.Timeout = r.Timeout
+	}
+
+	max := r.MaxRedirects
+	if max == 0 {
+		max = DefaultMaxRedirects
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if max < 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		return nil
+	}
+	return &client
 }
 
 // Crawl will spider the web page at the URL argument looking while staying within the scope provided.
 func Crawl(ctx context.Context, u string, scope []string, max int, callback func(*Request, *Response)) error {
+	return CrawlWithOptions(ctx, u, scope, &CrawlOptions{MaxLinks: max}, callback)
+}
+
+// CrawlWithOptions spiders the web page at the URL within the scope provided, and the limits of the options.
+// The scripts referenced by the landing page are provided to the Script callback of the options when set.
+func CrawlWithOptions(ctx context.Context, u string, scope []string, opts *CrawlOptions, callback func(*Request, *Response)) error {
 	select {
 	case <-ctx.Done():
 		return fmt.Errorf("the context expired")
 	default:
 	}
 
+	opts = opts.defaults()
+	if start, err := url.Parse(u); err == nil && opts.blocked(start.Hostname()) {
+		return fmt.Errorf("the host of %s must never be requested", u)
+	}
+	max := opts.MaxLinks
+	var scripts *scriptFetcher
+	var landing sync.Once
+	if opts.Script != nil {
+		scripts = newScriptFetcher(opts, scope)
+		defer scripts.wait()
+	}
+
 	var count int
 	var m sync.Mutex
 	filter := bf.NewDefaultStableBloomFilter(10000, 0.01)
@@ -249,13 +401,14 @@ func Crawl(ctx context.Context, u string, scope []string, max int, callback func
 		"ins", "link", "noframes", "object", "q", "script", "source", "track", "video"}
 
 	g := geziyor.NewGeziyor(&geziyor.Options{
-		StartURLs:             []string{u},
-		RobotsTxtDisabled:     true,
-		UserAgent:             UserAgent,
-		LogDisabled:           true,
-		ConcurrentRequests:    5,
-		RequestDelay:          50 * time.Millisecond,
-		RequestDelayRandomize: true,
+		StartURLs:                   []string{u},
+		RobotsTxtDisabled:           true,
+		UserAgent:                   UserAgent,
+		LogDisabled:                 true,
+		ConcurrentRequests:          opts.Concurrency,
+		ConcurrentRequestsPerDomain: opts.PerHost,
+		RequestDelay:                50 * time.Millisecond,
+		RequestDelayRandomize:       true,
 		ParseFunc: func(g *geziyor.Geziyor, r *client.Response) {
 			select {
 			case <-ctx.Done():
@@ -268,7 +421,7 @@ func Crawl(ctx context.Context, u string, scope []string, max int, callback func
 				if err != nil {
 					return
 				}
-				if host := u.Hostname(); host == "" || whichDomain(host, scope) == "" {
+				if host := u.Hostname(); host == "" || whichDomain(host, scope) == "" || opts.blocked(host) {
 					return
 				}
 
@@ -290,7 +443,23 @@ func Crawl(ctx context.Context, u string, scope []string, max int, callback func
 					}
 				}
 			}
+			// Only the scripts of the landing page are fetched, and they are streamed rather than followed as links
+			if scripts != nil && r.HTMLDoc != nil {
+				landing.Do(func() {
+					var srcs []string
+
+					r.HTMLDoc.Find("script[src]").Each(func(i int, s *goquery.Selection) {
+						if src, ok := s.Attr("src"); ok {
+							srcs = append(srcs, src)
+						}
+					})
+					scripts.fetch(ctx, r.Request.URL, srcs)
+				})
+			}
 			for _, t := range tags {
+				if t == "script" && scripts != nil {
+					continue
+				}
 				r.HTMLDoc.Find(t).Each(tag)
 			}
 
@@ -356,18 +525,44 @@ func PullCertificateNames(ctx context.Context, addr string, ports []int) []strin
 	return names
 }
 
+// meteredDial opens the connection, and accounts for the bytes crossing it with the Meter carried by the
+// context of the request that dialed it, on behalf of the data source the request was made for. The bytes
+// are counted below TLS and the content encodings, so they are the bytes that went over the network.
+func meteredDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := amassnet.DialContext(ctx, network, addr)
+	m := bandwidth.FromContext(ctx)
+	if err != nil || m == nil {
+		return conn, err
+	}
+
+	component := "enumeration"
+	source := bandwidth.SourceFromContext(ctx)
+	if source != "" {
+		component = "data_sources"
+	}
+	return bandwidth.NewConn(conn, func(sent, received int) {
+		m.Add(bandwidth.HTTP, component, source, int64(sent), int64(received))
+	}), nil
+}
+
 // TLSConn attempts to make a TLS connection with the host on the given port.
 func TLSConn(ctx context.Context, host string, port int) (*tls.Conn, error) {
+	return TLSConnWithServerName(ctx, host, port, "")
+}
+
+// TLSConnWithServerName attempts to make a TLS connection with the host on the given port, presenting the
+// server name through SNI, so the host selects the certificate of the name. No name is presented when empty.
+func TLSConnWithServerName(ctx context.Context, host string, port int, serverName string) (*tls.Conn, error) {
 	// set the maximum time allowed for making the connection
 	tCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
 	defer cancel()
 	// obtain the connection
-	conn, err := amassnet.DialContext(tCtx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	conn, err := meteredDial(tCtx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 	if err != nil {
 		return nil, err
 	}
 
-	c := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	c := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
 	// attempt to acquire the certificate chain
 	if err := c.HandshakeContext(tCtx); err != nil {
 		c.Close()
@@ -407,13 +602,47 @@ func NamesFromCert(cert *x509.Certificate) []string {
 	return subdomains.Slice()
 }
 
+// WildcardsFromCert returns the wildcard entries of the TLS certificate, such as *.internal.example.com.
+func WildcardsFromCert(cert *x509.Certificate) []string {
+	wildcards := stringset.New()
+	defer wildcards.Close()
+
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		if zone := dns.WildcardZone(name); zone != "" {
+			wildcards.Insert("*." + zone)
+		}
+	}
+	return wildcards.Slice()
+}
+
 // CleanName will clean up the names scraped from the web.
 func CleanName(name string) string {
+	return cleanName(name, true)
+}
+
+// CleanDecodedName cleans up the names matched in the text decoded by DecodeText. The text holds no
+// escapes, so the beginning of a name, such as the '2f' of 2fa.example.com, is never taken for one.
+func CleanDecodedName(name string) string {
+	return cleanName(name, false)
+}
+
+func cleanName(name string, escaped bool) string {
 	clean, err := strconv.Unquote("\"" + strings.TrimSpace(name) + "\"")
 	if err != nil {
 		return name
 	}
 
+	// Internationalized names are converted to punycode before extraction
+	if strings.IndexFunc(clean, func(r rune) bool { return r > unicode.MaxASCII }) != -1 {
+		if n := idnNameRE.FindString(clean); n != "" {
+			ascii, err := dns.NormalizeName(n)
+			if err != nil {
+				return ""
+			}
+			clean = strings.Replace(clean, n, ascii, 1)
+		}
+	}
+
 	if re := subRE.FindString(clean); re != "" {
 		clean = re
 	}
@@ -421,6 +650,9 @@ func CleanName(name string) string {
 	clean = strings.ToLower(clean)
 	for {
 		clean = strings.Trim(clean, "-.")
+		if !escaped {
+			break
+		}
 
 		i := nameStripRE.FindStringIndex(clean)
 		if i == nil {
@@ -147,6 +147,104 @@ func TestRequestWebPage(t *testing.T) {
 	}
 }
 
+func TestUserAgentRotation(t *testing.T) {
+	var agents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+	}))
+	defer ts.Close()
+
+	rotation := NewUserAgents("agent-one", " ", "agent-two")
+	for _, static := range []bool{false, false, false, true} {
+		if _, err := RequestWebPage(context.TODO(), &Request{
+			URL:             ts.URL,
+			UserAgents:      rotation,
+			StaticUserAgent: static,
+		}); err != nil {
+			t.Fatalf("Failed to request the web page: %v", err)
+		}
+	}
+
+	expected := []string{"agent-one", "agent-two", "agent-one", UserAgent}
+	if len(agents) != len(expected) {
+		t.Fatalf("Expected %d requests, got %d", len(expected), len(agents))
+	}
+	for i, ua := range expected {
+		if agents[i] != ua {
+			t.Errorf("Request %d used the user agent %s, expected %s", i, agents[i], ua)
+		}
+	}
+
+	var none *UserAgents
+	if ua := none.Next(); ua != UserAgent {
+		t.Errorf("A nil UserAgents provided %s instead of the default user agent", ua)
+	}
+}
+
+func TestRequestWebPageLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	// The huge endpoint streams a body without end, starting with a name to be extracted
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "www.owasp.org ")
+		chunk := strings.Repeat("A", 1024)
+		for {
+			if _, err := fmt.Fprint(w, chunk); err != nil {
+				return
+			}
+		}
+	})
+	// The slow endpoint sends the headers and a name, then stalls while sending the rest of the body
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "api.owasp.org ")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect", http.StatusFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := RequestWebPage(context.TODO(), &Request{
+		URL:         ts.URL + "/huge",
+		MaxBodySize: 4096,
+	})
+	if err != nil {
+		t.Fatalf("Failed to request the huge web page: %v", err)
+	}
+	if !resp.Truncated || len(resp.Body) != 4096 {
+		t.Errorf("The body size cap was not enforced: truncated %t, length %d", resp.Truncated, len(resp.Body))
+	}
+	if !strings.HasPrefix(resp.Body, "www.owasp.org") {
+		t.Errorf("The truncated body lost the prefix")
+	}
+
+	start := time.Now()
+	resp, err = RequestWebPage(context.TODO(), &Request{
+		URL:     ts.URL + "/slow",
+		Timeout: 500 * time.Millisecond,
+	})
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("The request duration cap was not enforced")
+	}
+	if err != nil {
+		t.Fatalf("Failed to request the slow web page: %v", err)
+	}
+	if !resp.Truncated || !strings.HasPrefix(resp.Body, "api.owasp.org") {
+		t.Errorf("The slow response was not returned truncated: %q", resp.Body)
+	}
+
+	if _, err := RequestWebPage(context.TODO(), &Request{
+		URL:          ts.URL + "/redirect",
+		MaxRedirects: 3,
+	}); err == nil || !strings.Contains(err.Error(), "3 redirects") {
+		t.Errorf("The redirect cap was not enforced: %v", err)
+	}
+}
+
 func TestCrawl(t *testing.T) {
 	re, err := regexp.Compile(amassdns.AnySubdomainRegexString())
 	if err != nil {
@@ -219,6 +317,22 @@ func TestCrawl(t *testing.T) {
 	}
 }
 
+func TestCrawlBlocked(t *testing.T) {
+	var requested int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested++
+	}))
+	defer ts.Close()
+
+	opts := &CrawlOptions{Blocked: func(host string) bool { return host == "127.0.0.1" }}
+	if err := CrawlWithOptions(context.Background(), ts.URL, []string{"127.0.0.1"}, opts, func(req *Request, resp *Response) {}); err == nil {
+		t.Errorf("the crawl of the blocked host did not fail")
+	}
+	if requested != 0 {
+		t.Errorf("the blocked host received %d requests", requested)
+	}
+}
+
 func TestPullCertificateNames(t *testing.T) {
 	r := resolve.NewResolvers()
 	if r == nil {
@@ -285,6 +399,18 @@ func TestCleanName(t *testing.T) {
 			data: "http://blackhat2018.owasp.org/index.html",
 			want: "blackhat2018.owasp.org",
 		},
+		{
+			data: "https://Bücher.owasp.org/index.html",
+			want: "xn--bcher-kva.owasp.org",
+		},
+		{
+			data: "i❤.owasp.org",
+			want: "xn--i-7iq.owasp.org",
+		},
+		{
+			data: "bücher.xn--zz.owasp.org",
+			want: "",
+		},
 	}
 
 	for _, test := range tests {
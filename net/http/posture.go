@@ -0,0 +1,132 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SecurityPosture is the web security posture of a host, as told by the headers of the response to its root page.
+type SecurityPosture struct {
+	StatusCode int `json:"status_code"`
+	// HSTS is true when the response carries a Strict-Transport-Security header with a positive max-age
+	HSTS                  bool  `json:"hsts"`
+	HSTSMaxAge            int64 `json:"hsts_max_age,omitempty"`
+	HSTSIncludeSubdomains bool  `json:"hsts_include_subdomains,omitempty"`
+	// CSP is true when the response carries a Content-Security-Policy header that is enforced
+	CSP           bool   `json:"csp"`
+	CSPReportOnly bool   `json:"csp_report_only,omitempty"`
+	XFrameOptions string `json:"x_frame_options,omitempty"`
+	// Cookies holds the flags of the cookies set by the response, sorted by their names
+	Cookies []CookieFlags `json:"cookies,omitempty"`
+}
+
+// CookieFlags are the security flags of a cookie set by a response.
+type CookieFlags struct {
+	Name     string `json:"name"`
+	Secure   bool   `json:"secure"`
+	HTTPOnly bool   `json:"http_only"`
+	SameSite string `json:"same_site,omitempty"`
+}
+
+// InsecureCookies returns the number of cookies set without the Secure or HttpOnly flags.
+func (sp *SecurityPosture) InsecureCookies() int {
+	var n int
+
+	for _, c := range sp.Cookies {
+		if !c.Secure || !c.HTTPOnly {
+			n++
+		}
+	}
+	return n
+}
+
+// ParsePosture returns the security posture told by the status code and headers of a response.
+func ParsePosture(statusCode int, hdr http.Header) *SecurityPosture {
+	sp := &SecurityPosture{StatusCode: statusCode}
+
+	if v := hdr.Get("Strict-Transport-Security"); v != "" {
+		for _, d := range strings.Split(v, ";") {
+			d = strings.ToLower(strings.TrimSpace(d))
+
+			if strings.HasPrefix(d, "max-age=") {
+				age, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(d, "max-age="), `"`), 10, 64)
+				if err == nil && age > 0 {
+					sp.HSTS = true
+					sp.HSTSMaxAge = age
+				}
+			} else if d == "includesubdomains" {
+				sp.HSTSIncludeSubdomains = true
+			}
+		}
+	}
+	sp.CSP = strings.TrimSpace(hdr.Get("Content-Security-Policy")) != ""
+	sp.CSPReportOnly = strings.TrimSpace(hdr.Get("Content-Security-Policy-Report-Only")) != ""
+	sp.XFrameOptions = strings.ToUpper(strings.TrimSpace(hdr.Get("X-Frame-Options")))
+
+	resp := &http.Response{Header: hdr}
+	for _, c := range resp.Cookies() {
+		flags := CookieFlags{Name: c.Name, Secure: c.Secure, HTTPOnly: c.HttpOnly}
+
+		switch c.SameSite {
+		case http.SameSiteLaxMode:
+			flags.SameSite = "Lax"
+		case http.SameSiteStrictMode:
+			flags.SameSite = "Strict"
+		case http.SameSiteNoneMode:
+			flags.SameSite = "None"
+		}
+		sp.Cookies = append(sp.Cookies, flags)
+	}
+	sort.SliceStable(sp.Cookies, func(i, j int) bool { return sp.Cookies[i].Name < sp.Cookies[j].Name })
+	return sp
+}
+
+// RequestPosture connects to the address on the port while presenting the server name through SNI, and requests
+// the root page with the server name as the Host header. Only the headers of the response are read, since the
+// posture is told by them, so the body is never downloaded.
+func RequestPosture(ctx context.Context, addr string, port int, serverName string) (*SecurityPosture, error) {
+	c, err := TLSConnWithServerName(ctx, addr, port, serverName)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	rctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+	if deadline, ok := rctx.Deadline(); ok {
+		_ = c.SetDeadline(deadline)
+	}
+
+	host := serverName
+	if host == "" {
+		host = net.JoinHostPort(addr, strconv.Itoa(port))
+	}
+	req, err := http.NewRequestWithContext(rctx, http.MethodGet, "https://"+host+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", Accept)
+	req.Header.Set("Accept-Language", AcceptLang)
+	req.Header.Set("Connection", "close")
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ParsePosture(resp.StatusCode, resp.Header), nil
+}
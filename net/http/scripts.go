@@ -0,0 +1,250 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultCrawlConcurrency is the number of requests a crawl has in flight when none has been configured.
+	DefaultCrawlConcurrency = 5
+	// DefaultCrawlPerHost is the number of requests a crawl has in flight to each host when none has been configured.
+	DefaultCrawlPerHost = 2
+	// DefaultMaxScripts is the number of scripts fetched from a landing page when none has been configured.
+	DefaultMaxScripts = 20
+	// DefaultMaxScriptSize is the number of bytes read from each script when none has been configured.
+	DefaultMaxScriptSize int64 = 8 << 20
+	// streamChunkSize is the number of bytes read at once from a streamed body.
+	streamChunkSize = 64 << 10
+	// streamOverlap is the number of bytes kept between the chunks, which exceeds the length of any DNS name.
+	streamOverlap = 512
+	// contextSize is the number of bytes on each side of a match provided as its context.
+	contextSize = 80
+)
+
+// CrawlOptions controls the requests made by a crawl.
+type CrawlOptions struct {
+	// MaxLinks is the number of links followed, where zero follows them all
+	MaxLinks int
+	// Concurrency is the number of requests in flight
+	Concurrency int
+	// PerHost is the number of requests in flight to each host
+	PerHost int
+	// MaxScripts is the number of scripts fetched from the landing page
+	MaxScripts int
+	// MaxScriptSize is the number of bytes read from each script
+	MaxScriptSize int64
+	// Script receives the body of each in scope script referenced by the landing page when set, and is called
+	// concurrently. The body is streamed rather than buffered, since bundled scripts are often multiple megabytes.
+	Script func(scriptURL string, body io.Reader)
+	// Blocked returns true for the hosts that must never be requested when set, which are skipped even in scope
+	Blocked func(host string) bool
+}
+
+// blocked returns true when the host must never be requested.
+func (o *CrawlOptions) blocked(host string) bool {
+	return o.Blocked != nil && o.Blocked(host)
+}
+
+// defaults returns a copy of the options with the unset limits replaced by the defaults.
+func (o *CrawlOptions) defaults() *CrawlOptions {
+	c := &CrawlOptions{}
+	if o != nil {
+		*c = *o
+	}
+
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultCrawlConcurrency
+	}
+	if c.PerHost <= 0 {
+		c.PerHost = DefaultCrawlPerHost
+	}
+	if c.MaxScripts <= 0 {
+		c.MaxScripts = DefaultMaxScripts
+	}
+	if c.MaxScriptSize <= 0 {
+		c.MaxScriptSize = DefaultMaxScriptSize
+	}
+	return c
+}
+
+// scriptFetcher requests the scripts of a crawl, within the concurrency limits of the crawl.
+type scriptFetcher struct {
+	sync.Mutex
+	opts  *CrawlOptions
+	scope []string
+	sem   chan struct{}
+	hosts map[string]chan struct{}
+	seen  map[string]struct{}
+	wg    sync.WaitGroup
+}
+
+func newScriptFetcher(opts *CrawlOptions, scope []string) *scriptFetcher {
+	return &scriptFetcher{
+		opts:  opts,
+		scope: scope,
+		sem:   make(chan struct{}, opts.Concurrency),
+		hosts: make(map[string]chan struct{}),
+		seen:  make(map[string]struct{}),
+	}
+}
+
+// fetch requests the scripts referenced by the page that are served by in scope hosts, until the cap is reached.
+func (f *scriptFetcher) fetch(ctx context.Context, page *url.URL, srcs []string) {
+	for _, src := range srcs {
+		u, err := page.Parse(strings.TrimSpace(src))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if host := u.Hostname(); host == "" || whichDomain(host, f.scope) == "" || f.opts.blocked(host) {
+			continue
+		}
+		u.Fragment = ""
+
+		if !f.claim(u.String()) {
+			continue
+		}
+		f.wg.Add(1)
+		go func(u *url.URL) {
+			defer f.wg.Done()
+			f.request(ctx, u)
+		}(u)
+	}
+}
+
+// claim returns true when the script has not been fetched before and the cap has not been reached.
+func (f *scriptFetcher) claim(u string) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, found := f.seen[u]; found || len(f.seen) >= f.opts.MaxScripts {
+		return false
+	}
+	f.seen[u] = struct{}{}
+	return true
+}
+
+// host returns the semaphore limiting the requests in flight to the host.
+func (f *scriptFetcher) host(name string) chan struct{} {
+	f.Lock()
+	defer f.Unlock()
+
+	sem, found := f.hosts[name]
+	if !found {
+		sem = make(chan struct{}, f.opts.PerHost)
+		f.hosts[name] = sem
+	}
+	return sem
+}
+
+func (f *scriptFetcher) request(ctx context.Context, u *url.URL) {
+	hsem := f.host(u.Host)
+	for _, sem := range []chan struct{}{f.sem, hsem} {
+		select {
+		case <-ctx.Done():
+			return
+		case sem <- struct{}{}:
+			defer func(sem chan struct{}) { <-sem }(sem)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", "*/*")
+
+	resp, err := DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		f.opts.Script(u.String(), NewBodyReader(io.LimitReader(resp.Body, f.opts.MaxScriptSize), resp.Header.Get("Content-Type")))
+	}
+}
+
+// wait blocks until the scripts requested have been received.
+func (f *scriptFetcher) wait() {
+	f.wg.Wait()
+}
+
+// StreamMatches runs the regular expression over the content read from r without holding it all in
+// memory, and provides each match along with the text surrounding it. The matches are limited to the
+// length of a DNS name, so the ones crossing the chunks that are read are found within the overlap.
+func StreamMatches(r io.Reader, re *regexp.Regexp, fn func(match, context string)) error {
+	buf := make([]byte, 0, streamChunkSize+streamOverlap)
+	chunk := make([]byte, streamChunkSize)
+
+	for {
+		n, err := io.ReadFull(r, chunk)
+		buf = append(buf, chunk[:n]...)
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return err
+		}
+
+		// Matches ending within the overlap could continue in the next chunk, so they are left for it
+		keep := len(buf)
+		if !eof && len(buf) > streamOverlap {
+			keep = len(buf) - streamOverlap
+		}
+		next, last := keep, 0
+		for _, loc := range re.FindAllIndex(buf, -1) {
+			if !eof && loc[1] > keep {
+				if loc[0] < next {
+					next = loc[0]
+				}
+				break
+			}
+			fn(string(buf[loc[0]:loc[1]]), matchContext(buf, loc[0], loc[1]))
+			last = loc[1]
+		}
+		if eof {
+			return nil
+		}
+		// A name cut by the end of the buffer is kept whole, so the next chunk does not match its tail alone
+		for next > last && next > len(buf)-streamOverlap*2 && nameByte(buf[next-1]) {
+			next--
+		}
+
+		buf = append(buf[:0], buf[next:]...)
+	}
+}
+
+func nameByte(b byte) bool {
+	return b == '.' || b == '-' || b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// matchContext returns the line of the match, cut to the bytes near it in the long lines of minified content.
+func matchContext(buf []byte, start, end int) string {
+	from, to := start-contextSize, end+contextSize
+	if from < 0 {
+		from = 0
+	}
+	if to > len(buf) {
+		to = len(buf)
+	}
+
+	text := string(buf[from:to])
+	offset := start - from
+	if i := strings.LastIndexByte(text[:offset], '\n'); i >= 0 {
+		text, offset = text[i+1:], offset-i-1
+	}
+	if i := strings.IndexByte(text[offset:], '\n'); i >= 0 {
+		text = text[:offset+i]
+	}
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,106 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	amassdns "github.com/owasp-amass/amass/v4/net/dns"
+)
+
+func TestStreamMatches(t *testing.T) {
+	re := amassdns.AnySubdomainRegex()
+
+	// A minified bundle is a single line, so the names straddle the chunks read from the stream
+	var b strings.Builder
+	for i := 0; b.Len() < 5*streamChunkSize; i++ {
+		fmt.Fprintf(&b, `fetch("https://api%d.example.com/v1");%s`, i, strings.Repeat("!", i%700))
+	}
+	content := b.String()
+
+	var got []string
+	err := StreamMatches(iotest.HalfReader(strings.NewReader(content)), re, func(match, text string) {
+		if !strings.Contains(text, match) || len(text) > len(match)+2*contextSize {
+			t.Errorf("the context %q does not surround the match %s", text, match)
+		}
+		got = append(got, match)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := re.FindAllString(content, -1); !reflect.DeepEqual(got, want) {
+		t.Errorf("streamed %d matches, expected the %d matches of the whole content", len(got), len(want))
+	}
+}
+
+func TestCrawlScripts(t *testing.T) {
+	var lock sync.Mutex
+	fetched := make(map[string]int)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head>
+<script src="/static/app.js"></script>
+<script src="/static/app.js#dup"></script>
+<script src="/static/vendor.js"></script>
+<script src="/static/extra.js"></script>
+<script src="https://cdn.out-of-scope.invalid/lib.js"></script>
+</head><body><a href="/about.html">About</a></body></html>`)
+	})
+	mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched[r.URL.Path]++
+		lock.Unlock()
+		fmt.Fprintf(w, `var api="https://api.example.com";var cdn="%s.example.com";`, strings.TrimSuffix(r.URL.Path[8:], ".js"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var names []string
+	var pages []string
+	opts := &CrawlOptions{
+		MaxScripts: 2,
+		Script: func(scriptURL string, body io.Reader) {
+			_ = StreamMatches(body, subRE, func(match, text string) {
+				lock.Lock()
+				names = append(names, match)
+				lock.Unlock()
+			})
+		},
+	}
+	err := CrawlWithOptions(context.Background(), ts.URL, []string{"127.0.0.1"}, opts, func(req *Request, resp *Response) {
+		lock.Lock()
+		pages = append(pages, req.URL)
+		lock.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scripts were fetched once each, up to the cap, and only from the hosts within scope
+	if len(fetched) != 2 || fetched["/static/app.js"] != 1 || fetched["/static/vendor.js"] != 1 {
+		t.Errorf("the scripts fetched were %v", fetched)
+	}
+	sort.Strings(names)
+	if want := []string{"api.example.com", "api.example.com", "app.example.com", "vendor.example.com"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("the names found in the scripts were %v", names)
+	}
+	for _, p := range pages {
+		if strings.HasSuffix(p, ".js") {
+			t.Errorf("the script %s was followed as a link", p)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// virtualHostBodySize is the number of body bytes hashed to compare the content served for the virtual hosts.
+const virtualHostBodySize = 64 << 10
+
+// VirtualHostResponse is the answer of an address asked for a virtual host over TLS.
+type VirtualHostResponse struct {
+	// Certificate is the leaf certificate presented in the handshake
+	Certificate *x509.Certificate
+	// StatusCode is zero when the address completed the handshake, but did not answer the request
+	StatusCode int
+	// Digest is the SHA-256 hash of the first bytes of the response body
+	Digest [sha256.Size]byte
+}
+
+// RequestVirtualHost connects to the address on the port while presenting the server name through SNI, and requests
+// the root page with the server name as the Host header. An empty server name asks the address for its default host.
+// The certificate is returned without the response when the address completes the handshake but not the request.
+func RequestVirtualHost(ctx context.Context, addr string, port int, serverName string) (*VirtualHostResponse, error) {
+	c, err := TLSConnWithServerName(ctx, addr, port, serverName)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	vr := new(VirtualHostResponse)
+	if certs := c.ConnectionState().PeerCertificates; len(certs) > 0 {
+		vr.Certificate = certs[0]
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+	if deadline, ok := rctx.Deadline(); ok {
+		_ = c.SetDeadline(deadline)
+	}
+
+	host := serverName
+	if host == "" {
+		host = net.JoinHostPort(addr, strconv.Itoa(port))
+	}
+	req, err := http.NewRequestWithContext(rctx, http.MethodGet, "https://"+host+"/", nil)
+	if err != nil {
+		return vr, nil
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", Accept)
+	req.Header.Set("Accept-Language", AcceptLang)
+	req.Header.Set("Connection", "close")
+	if err := req.Write(c); err != nil {
+		return vr, nil
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		return vr, nil
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	_, _ = io.Copy(h, io.LimitReader(resp.Body, virtualHostBodySize))
+	vr.StatusCode = resp.StatusCode
+	copy(vr.Digest[:], h.Sum(nil))
+	return vr, nil
+}
@@ -0,0 +1,174 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package net
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBindAttempts bounds the source ports tried before a socket fails to be bound.
+const maxBindAttempts = 64
+
+// PortRange is an inclusive range of source port numbers.
+type PortRange struct {
+	First int
+	Last  int
+}
+
+// ParsePortRange parses a range such as "40000-40999", or a single port number.
+func ParsePortRange(s string) (PortRange, error) {
+	first, last, found := strings.Cut(strings.TrimSpace(s), "-")
+	if !found {
+		last = first
+	}
+
+	var r PortRange
+	var err error
+	if r.First, err = strconv.Atoi(strings.TrimSpace(first)); err != nil {
+		return PortRange{}, fmt.Errorf("%q is not a valid port range", s)
+	}
+	if r.Last, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+		return PortRange{}, fmt.Errorf("%q is not a valid port range", s)
+	}
+	return r, r.validate()
+}
+
+func (r PortRange) validate() error {
+	if r.First < 1 || r.Last > 65535 || r.First > r.Last {
+		return fmt.Errorf("%s is not a valid port range", r)
+	}
+	return nil
+}
+
+func (r PortRange) String() string {
+	if r.First == r.Last {
+		return strconv.Itoa(r.First)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// SocketOptions select the local address and the source ports of the sockets that send DNS queries.
+// The zero value binds the sockets to all the interfaces and to ports chosen by the operating system.
+type SocketOptions struct {
+	// BindAddress is the local address of the sockets, such as one of the addresses of a multi-homed host
+	BindAddress net.IP
+	// SourcePorts holds the ranges the source port of each socket is picked from at random
+	SourcePorts []PortRange
+}
+
+// Validate returns an error when a source port range is invalid or overlaps another one.
+func (o *SocketOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	ranges := append([]PortRange(nil), o.SourcePorts...)
+	for _, r := range ranges {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].First < ranges[j].First })
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].First <= ranges[i-1].Last {
+			return fmt.Errorf("the source port ranges %s and %s overlap", ranges[i-1], ranges[i])
+		}
+	}
+	return nil
+}
+
+// ListenUDP returns a socket bound to the address and one of the source ports selected by the options.
+func (o *SocketOptions) ListenUDP() (*net.UDPConn, error) {
+	return o.bind(func(laddr *net.UDPAddr) (*net.UDPConn, error) {
+		return net.ListenUDP("udp", laddr)
+	})
+}
+
+// DialUDP returns a socket connected to the address, bound to the address and one of the
+// source ports selected by the options.
+func (o *SocketOptions) DialUDP(addr string) (*net.UDPConn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return o.bind(func(laddr *net.UDPAddr) (*net.UDPConn, error) {
+		return net.DialUDP("udp", laddr, raddr)
+	})
+}
+
+// bind calls fn with the local addresses selected by the options, starting at a random source
+// port, until a socket is bound or the attempts have been exhausted.
+func (o *SocketOptions) bind(fn func(laddr *net.UDPAddr) (*net.UDPConn, error)) (*net.UDPConn, error) {
+	laddr := &net.UDPAddr{}
+	if o != nil && len(o.BindAddress) > 0 {
+		laddr.IP = o.BindAddress
+	}
+
+	total := o.portCount()
+	if total == 0 {
+		return fn(laddr)
+	}
+
+	start, err := rand.Int(rand.Reader, big.NewInt(int64(total)))
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := total
+	if attempts > maxBindAttempts {
+		attempts = maxBindAttempts
+	}
+
+	var last error
+	for i := 0; i < attempts; i++ {
+		laddr.Port = o.port((int(start.Int64()) + i) % total)
+
+		conn, err := fn(laddr)
+		if err == nil {
+			return conn, nil
+		}
+		last = err
+	}
+	return nil, fmt.Errorf("failed to bind a socket within the source ports %s: %w", o.rangesString(), last)
+}
+
+func (o *SocketOptions) portCount() int {
+	if o == nil {
+		return 0
+	}
+
+	var total int
+	for _, r := range o.SourcePorts {
+		total += r.Last - r.First + 1
+	}
+	return total
+}
+
+// port returns the port at the index, counted across the source port ranges.
+func (o *SocketOptions) port(idx int) int {
+	for _, r := range o.SourcePorts {
+		if size := r.Last - r.First + 1; idx >= size {
+			idx -= size
+			continue
+		}
+		return r.First + idx
+	}
+	return 0
+}
+
+func (o *SocketOptions) rangesString() string {
+	var ranges []string
+	for _, r := range o.SourcePorts {
+		ranges = append(ranges, r.String())
+	}
+	return strings.Join(ranges, ",")
+}
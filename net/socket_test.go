@@ -0,0 +1,129 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package net
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		input string
+		want  PortRange
+		valid bool
+	}{
+		{"40000-40999", PortRange{First: 40000, Last: 40999}, true},
+		{" 5353 ", PortRange{First: 5353, Last: 5353}, true},
+		{"40999-40000", PortRange{}, false},
+		{"0-100", PortRange{}, false},
+		{"65000-70000", PortRange{}, false},
+		{"high-ports", PortRange{}, false},
+		{"", PortRange{}, false},
+	}
+
+	for _, test := range tests {
+		r, err := ParsePortRange(test.input)
+		if (err == nil) != test.valid || (test.valid && r != test.want) {
+			t.Errorf("%q was parsed as %v with the error %v", test.input, r, err)
+		}
+	}
+}
+
+func TestSocketOptionsValidate(t *testing.T) {
+	tests := []struct {
+		ranges []PortRange
+		valid  bool
+	}{
+		{nil, true},
+		{[]PortRange{{First: 40000, Last: 40099}, {First: 30000, Last: 30099}}, true},
+		{[]PortRange{{First: 40000, Last: 40099}, {First: 40099, Last: 40199}}, false},
+		{[]PortRange{{First: 40000, Last: 40099}, {First: 40010, Last: 40020}}, false},
+		{[]PortRange{{First: 40100, Last: 40000}}, false},
+	}
+
+	for _, test := range tests {
+		opts := &SocketOptions{SourcePorts: test.ranges}
+		if err := opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("the source ports %v were validated with the error %v", test.ranges, err)
+		}
+	}
+}
+
+func TestSocketsBoundWithinRange(t *testing.T) {
+	opts := &SocketOptions{
+		BindAddress: net.ParseIP("127.0.0.1"),
+		SourcePorts: []PortRange{{First: 47310, Last: 47313}, {First: 47320, Last: 47323}},
+	}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	within := func(addr net.Addr) bool {
+		udp, ok := addr.(*net.UDPAddr)
+		if !ok || !udp.IP.Equal(opts.BindAddress) {
+			return false
+		}
+		for _, r := range opts.SourcePorts {
+			if udp.Port >= r.First && udp.Port <= r.Last {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The server receiving the queries sees the source ports selected by the options
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var conns []*net.UDPConn
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	ports := make(map[int]struct{})
+	for i := 0; i < opts.portCount(); i++ {
+		var c *net.UDPConn
+		if i%2 == 0 {
+			c, err = opts.ListenUDP()
+		} else {
+			c, err = opts.DialUDP(server.LocalAddr().String())
+		}
+		if err != nil {
+			t.Fatalf("socket %d was not bound: %v", i, err)
+		}
+		conns = append(conns, c)
+
+		if !within(c.LocalAddr()) {
+			t.Errorf("socket %d was bound to %s, outside of the source ports", i, c.LocalAddr())
+		}
+		ports[c.LocalAddr().(*net.UDPAddr).Port] = struct{}{}
+
+		if i%2 == 1 {
+			if _, err := c.Write([]byte("query")); err != nil {
+				t.Fatal(err)
+			}
+
+			buf := make([]byte, 16)
+			if _, from, err := server.ReadFromUDP(buf); err != nil || !within(from) {
+				t.Errorf("the query was received from %v: %v", from, err)
+			}
+		}
+	}
+	if len(ports) != opts.portCount() {
+		t.Errorf("%d distinct source ports were bound, expected %d", len(ports), opts.portCount())
+	}
+
+	// Every source port is in use now
+	if c, err := opts.ListenUDP(); err == nil {
+		c.Close()
+		t.Errorf("the socket was bound to %s once the source ports were exhausted", c.LocalAddr())
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package opsec holds the settings that make the traffic of an enumeration harder to fingerprint,
+// along with the seeded randomness that allows a run to be reproduced.
+package opsec
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// DefaultJitterMax is the longest delay added before a query when none has been configured.
+	DefaultJitterMax = 250 * time.Millisecond
+	// DefaultSourceStagger is the longest delay before a data source is started when none has been configured.
+	DefaultSourceStagger = 10 * time.Second
+	// MaxJitter bounds the delay added before each query, which bounds the throughput lost to the jitter.
+	MaxJitter = 2 * time.Second
+	// MaxSourceStagger keeps the staggered data sources within the time allowed for them to start.
+	MaxSourceStagger = 30 * time.Second
+)
+
+// Settings holds the 'opsec' configuration options of an enumeration.
+type Settings struct {
+	Seed          int64
+	JitterMin     time.Duration
+	JitterMax     time.Duration
+	SourceStagger time.Duration
+}
+
+// FromConfig parses the 'opsec' configuration options and returns nil when the mode is not enabled.
+// When no seed has been configured for the mode, the seed of the run is used.
+func FromConfig(cfg *config.Config) *Settings {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["opsec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, _ := opts["enabled"].(bool); !enabled {
+		return nil
+	}
+
+	s := &Settings{
+		JitterMin:     time.Duration(options.Int(opts["jitter_min"])) * time.Millisecond,
+		JitterMax:     DefaultJitterMax,
+		SourceStagger: DefaultSourceStagger,
+	}
+	if _, found := opts["jitter_max"]; found {
+		s.JitterMax = time.Duration(options.Int(opts["jitter_max"])) * time.Millisecond
+	}
+	if _, found := opts["source_stagger"]; found {
+		s.SourceStagger = time.Duration(options.Int(opts["source_stagger"])) * time.Second
+	}
+	s.bound()
+
+	if s.Seed = int64(options.Int(opts["seed"])); s.Seed == 0 {
+		s.Seed = random.FromConfig(cfg).Seed
+	}
+	return s
+}
+
+// bound keeps the delays within the limits that bound the impact on the throughput.
+func (s *Settings) bound() {
+	if s.JitterMax > MaxJitter {
+		s.JitterMax = MaxJitter
+	}
+	if s.JitterMax < 0 {
+		s.JitterMax = 0
+	}
+	if s.JitterMin < 0 {
+		s.JitterMin = 0
+	}
+	if s.JitterMin > s.JitterMax {
+		s.JitterMin = s.JitterMax
+	}
+	if s.SourceStagger > MaxSourceStagger {
+		s.SourceStagger = MaxSourceStagger
+	}
+	if s.SourceStagger < 0 {
+		s.SourceStagger = 0
+	}
+}
+
+// Rand returns the randomness used for the named purpose. Each purpose draws its own sequence from
+// the seed, so the order of the calls made for one purpose does not change the others.
+func (s *Settings) Rand(purpose string) *rand.Rand {
+	return random.New(s.Seed).Rand(purpose)
+}
+
+// Metadata returns the settings that are stored with the enumeration event.
+func (s *Settings) Metadata() map[string]string {
+	return map[string]string{
+		"opsec_seed":           strconv.FormatInt(s.Seed, 10),
+		"opsec_jitter_min":     s.JitterMin.String(),
+		"opsec_jitter_max":     s.JitterMax.String(),
+		"opsec_source_stagger": s.SourceStagger.String(),
+	}
+}
+
+// Shuffle returns a copy of the words in an order drawn from the randomness.
+func Shuffle(words []string, rng *rand.Rand) []string {
+	shuffled := append([]string(nil), words...)
+
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// Jitter draws the delays added before the queries, and tracks the total delay for reporting.
+type Jitter struct {
+	sync.Mutex
+	rng   *rand.Rand
+	min   time.Duration
+	max   time.Duration
+	count int64
+	total time.Duration
+}
+
+// NewJitter returns the Jitter drawing delays between the configured minimum and maximum.
+func (s *Settings) NewJitter() *Jitter {
+	return &Jitter{
+		rng: s.Rand("jitter"),
+		min: s.JitterMin,
+		max: s.JitterMax,
+	}
+}
+
+// Next returns the delay added before the next query.
+func (j *Jitter) Next() time.Duration {
+	j.Lock()
+	defer j.Unlock()
+
+	d := j.min
+	if span := j.max - j.min; span > 0 {
+		d += time.Duration(j.rng.Int63n(int64(span) + 1))
+	}
+
+	j.count++
+	j.total += d
+	return d
+}
+
+// Stats returns the number of delays drawn and their total duration.
+func (j *Jitter) Stats() (int64, time.Duration) {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.count, j.total
+}
@@ -0,0 +1,103 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package opsec
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestFromConfig(t *testing.T) {
+	if s := FromConfig(config.NewConfig()); s != nil {
+		t.Error("the settings were returned without the opsec options")
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"opsec": map[string]interface{}{"enabled": false, "seed": 7}}
+	if s := FromConfig(cfg); s != nil {
+		t.Error("the settings were returned when the mode is disabled")
+	}
+
+	tests := []struct {
+		opts    map[string]interface{}
+		min     time.Duration
+		max     time.Duration
+		stagger time.Duration
+	}{
+		{map[string]interface{}{}, 0, DefaultJitterMax, DefaultSourceStagger},
+		{map[string]interface{}{"jitter_min": 50, "jitter_max": 100, "source_stagger": 0}, 50 * time.Millisecond, 100 * time.Millisecond, 0},
+		{map[string]interface{}{"jitter_max": 60000, "source_stagger": 600}, 0, MaxJitter, MaxSourceStagger},
+		{map[string]interface{}{"jitter_min": 500, "jitter_max": 100}, 100 * time.Millisecond, 100 * time.Millisecond, DefaultSourceStagger},
+		{map[string]interface{}{"jitter_min": -5, "jitter_max": -5, "source_stagger": -1}, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		test.opts["enabled"] = true
+		cfg.Options = map[string]interface{}{"opsec": test.opts}
+
+		s := FromConfig(cfg)
+		if s == nil {
+			t.Errorf("no settings were returned for %v", test.opts)
+			continue
+		}
+		if s.JitterMin != test.min || s.JitterMax != test.max || s.SourceStagger != test.stagger {
+			t.Errorf("%v: parsed the jitter of %v to %v with a stagger of %v", test.opts, s.JitterMin, s.JitterMax, s.SourceStagger)
+		}
+	}
+}
+
+func TestFromConfigSeed(t *testing.T) {
+	opts := map[string]interface{}{"enabled": true}
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{"opsec": opts}
+
+	s := FromConfig(cfg)
+	if s == nil || s.Seed == 0 {
+		t.Fatal("no seed was generated")
+	}
+	// The generated seed is recorded, so the other components of the run share it
+	if again := FromConfig(cfg); again.Seed != s.Seed {
+		t.Errorf("the seed changed from %d to %d", s.Seed, again.Seed)
+	}
+	if md := s.Metadata(); md["opsec_seed"] == "" || md["opsec_jitter_max"] != DefaultJitterMax.String() {
+		t.Errorf("the metadata %v does not record the settings", md)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	words := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	s := &Settings{Seed: 42}
+
+	first := Shuffle(words, s.Rand("wordlist"))
+	if !reflect.DeepEqual(first, Shuffle(words, s.Rand("wordlist"))) {
+		t.Error("the same seed produced different orders")
+	}
+	if reflect.DeepEqual(first, Shuffle(words, (&Settings{Seed: 43}).Rand("wordlist"))) {
+		t.Error("different seeds produced the same order")
+	}
+	if words[0] != "a" || words[9] != "j" {
+		t.Error("the original words were reordered")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	s := &Settings{Seed: 42, JitterMin: 10 * time.Millisecond, JitterMax: 20 * time.Millisecond}
+	j := s.NewJitter()
+
+	var sum time.Duration
+	for i := 0; i < 100; i++ {
+		d := j.Next()
+		if d < s.JitterMin || d > s.JitterMax {
+			t.Errorf("the delay %v is outside of the configured bounds", d)
+		}
+		sum += d
+	}
+	if count, total := j.Stats(); count != 100 || total != sum {
+		t.Errorf("the stats reported %d delays totaling %v, expected 100 totaling %v", count, total, sum)
+	}
+}
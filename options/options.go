@@ -0,0 +1,26 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package options reads the values of the configuration options, which hold the numbers decoded
+// from YAML as int, and those decoded from JSON, such as the requests of the HTTP API, as float64.
+package options
+
+// IntValue returns the integer held by the option value, and false when the value is not a number.
+func IntValue(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// Int returns the integer held by the option value, or zero when the value is not a number.
+func Int(v interface{}) int {
+	n, _ := IntValue(v)
+	return n
+}
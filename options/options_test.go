@@ -0,0 +1,28 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package options
+
+import "testing"
+
+func TestIntValue(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		n    int
+		isOK bool
+	}{
+		{10, 10, true},
+		{int64(20), 20, true},
+		{float64(30), 30, true},
+		{"40", 0, false},
+		{nil, 0, false},
+	} {
+		if n, ok := IntValue(tt.v); n != tt.n || ok != tt.isOK {
+			t.Errorf("IntValue(%#v) returned %d and %t", tt.v, n, ok)
+		}
+		if n := Int(tt.v); n != tt.n {
+			t.Errorf("Int(%#v) returned %d", tt.v, n)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package policy keeps the active probes away from the infrastructure that must never be touched, such as
+// government and medical networks or the exclusions required by a client. The never-touch list is evaluated
+// before the scope, so an address or name matching it is blocked even when the scope includes it, and each
+// blocked attempt is counted as evidence that the list was honored.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/owasp-amass/config/config"
+)
+
+// BlocksFile is the name of the file under the output directory receiving the blocked attempts.
+const BlocksFile = "policy_blocks.json"
+
+// The components consulting the policy before sending traffic toward a target.
+const (
+	// DNS covers the DNS sweeps and the queries sent toward the names and their nameservers
+	DNS = "dns"
+	// Web covers the web probes, such as the crawling of the sites
+	Web = "web"
+	// Port covers the port checks, such as pulling the certificates served on the ports in scope
+	Port = "port"
+)
+
+// The kinds of rules matching the targets.
+const (
+	MatchCIDR   = "cidr"
+	MatchASN    = "asn"
+	MatchDomain = "domain"
+	// MatchUnloaded is the policy that could not be loaded, which blocks every target
+	MatchUnloaded = "unloaded"
+)
+
+// ErrBlocked is returned by the components refusing to reach a target on the never-touch list.
+var ErrBlocked = errors.New("the target is on the never-touch list")
+
+// Rule names the netblocks, autonomous systems and domain name suffixes that must never be touched.
+type Rule struct {
+	Name    string   `json:"name"`
+	CIDRs   []string `json:"cidrs,omitempty"`
+	ASNs    []int    `json:"asns,omitempty"`
+	Domains []string `json:"domains,omitempty"`
+}
+
+// Violation is an attempt by a component to reach a target on the never-touch list, which was blocked.
+type Violation struct {
+	Component string `json:"component"`
+	Target    string `json:"target"`
+	Rule      string `json:"rule"`
+	Match     string `json:"match"`
+	// Evidence is the netblock, autonomous system or domain name suffix that matched the target
+	Evidence string `json:"evidence"`
+	Attempts int64  `json:"attempts"`
+}
+
+// Report is the content of the file recording the blocked attempts of an enumeration.
+type Report struct {
+	Path       string       `json:"path"`
+	Blocked    int64        `json:"blocked"`
+	Violations []*Violation `json:"violations"`
+}
+
+type network struct {
+	ipnet *net.IPNet
+	rule  *Rule
+}
+
+type violationKey struct {
+	component string
+	target    string
+}
+
+// Policy blocks the active probes toward the targets matching its rules. The netblocks are checked before
+// the autonomous systems, and the longest prefix or suffix matched wins regardless of the rule order.
+type Policy struct {
+	Path string
+	// Log receives a line for the first blocked attempt of each component toward a target when set
+	Log        *log.Logger
+	rules      []*Rule
+	networks   []network
+	asns       map[int]*Rule
+	suffixes   map[string]*Rule
+	err        error
+	lookup     func(addr string) int
+	lock       sync.Mutex
+	violations map[violationKey]*Violation
+	blocked    int64
+}
+
+type policyFile struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// unloaded is the rule reported for the targets blocked by a policy that could not be loaded.
+var unloaded = &Rule{Name: "unloaded policy"}
+
+// Load parses the JSON never-touch list.
+func Load(r io.Reader) (*Policy, error) {
+	var f policyFile
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse the policy: %v", err)
+	}
+
+	p := &Policy{
+		asns:       make(map[int]*Rule),
+		suffixes:   make(map[string]*Rule),
+		violations: make(map[violationKey]*Violation),
+	}
+	for i, rule := range f.Rules {
+		if rule == nil {
+			continue
+		}
+		if rule.Name = strings.TrimSpace(rule.Name); rule.Name == "" {
+			rule.Name = "rule " + strconv.Itoa(i+1)
+		}
+		if len(rule.CIDRs) == 0 && len(rule.ASNs) == 0 && len(rule.Domains) == 0 {
+			return nil, fmt.Errorf("the policy rule %s does not match anything", rule.Name)
+		}
+
+		for _, c := range rule.CIDRs {
+			_, ipnet, err := net.ParseCIDR(strings.TrimSpace(c))
+			if err != nil {
+				// A single address is accepted as the netblock holding only that address
+				ip := net.ParseIP(strings.TrimSpace(c))
+				if ip == nil {
+					return nil, fmt.Errorf("the policy rule %s has an invalid netblock %s", rule.Name, c)
+				}
+				bits := 8 * len(ip)
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 8*net.IPv4len
+				}
+				ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			}
+			p.networks = append(p.networks, network{ipnet: ipnet, rule: rule})
+		}
+		for _, asn := range rule.ASNs {
+			if asn <= 0 {
+				return nil, fmt.Errorf("the policy rule %s has an invalid autonomous system %d", rule.Name, asn)
+			}
+			p.asns[asn] = rule
+		}
+		for _, d := range rule.Domains {
+			if d = normalizeName(d); d == "" {
+				return nil, fmt.Errorf("the policy rule %s has an empty domain name suffix", rule.Name)
+			}
+			p.suffixes[d] = rule
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// Open loads the never-touch list from the file.
+func Open(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	p, err := Load(f)
+	if err != nil {
+		return nil, err
+	}
+	p.Path = path
+	return p, nil
+}
+
+// PathFromOptions returns the never-touch list file named by the 'policy' options, relative to the
+// configuration file, or an empty string when none was named.
+func PathFromOptions(cfg *config.Config) string {
+	if cfg == nil || cfg.Options == nil {
+		return ""
+	}
+
+	opts, ok := cfg.Options["policy"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	path, _ := opts["file"].(string)
+	if path = strings.TrimSpace(path); path != "" && !filepath.IsAbs(path) && cfg.Filepath != "" {
+		path = filepath.Join(filepath.Dir(cfg.Filepath), path)
+	}
+	return path
+}
+
+// FromConfig returns the policy named by the configuration, or nil when none was named. A file that cannot
+// be loaded is returned as the error, along with a policy blocking every target, since the enumeration
+// cannot tell which targets must never be touched.
+func FromConfig(cfg *config.Config) (*Policy, error) {
+	path := PathFromOptions(cfg)
+	if path == "" {
+		return nil, nil
+	}
+
+	p, err := Open(path)
+	if err != nil {
+		err = fmt.Errorf("failed to load the policy %s: %v", path, err)
+		p = &Policy{
+			Path:       path,
+			err:        err,
+			violations: make(map[violationKey]*Violation),
+		}
+	}
+	if cfg.Log != nil {
+		p.Log = cfg.Log
+	}
+	return p, err
+}
+
+// Err returns the error that prevented the policy from being loaded, in which case every target is blocked.
+func (p *Policy) Err() error {
+	if p == nil {
+		return nil
+	}
+	return p.err
+}
+
+// Rules returns the rules of the never-touch list.
+func (p *Policy) Rules() []*Rule {
+	if p == nil {
+		return nil
+	}
+	return append([]*Rule(nil), p.rules...)
+}
+
+// SetASNLookup sets the function returning the autonomous system announcing an address, or zero when it is
+// unknown, which the rules naming autonomous systems require.
+func (p *Policy) SetASNLookup(fn func(addr string) int) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lookup = fn
+}
+
+// BlocksAddress returns true when the component must not send traffic toward the address, and records the attempt.
+func (p *Policy) BlocksAddress(component, addr string) bool {
+	if p == nil {
+		return false
+	}
+
+	addr = strings.TrimSpace(addr)
+	if p.err != nil {
+		p.record(component, addr, unloaded, MatchUnloaded, p.err.Error())
+		return true
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if n := p.matchAddr(ip); n != nil {
+		p.record(component, ip.String(), n.rule, MatchCIDR, n.ipnet.String())
+		return true
+	}
+
+	p.lock.Lock()
+	lookup := p.lookup
+	p.lock.Unlock()
+	if lookup == nil || len(p.asns) == 0 {
+		return false
+	}
+	if asn := lookup(ip.String()); asn > 0 {
+		if rule, found := p.asns[asn]; found {
+			p.record(component, ip.String(), rule, MatchASN, "AS"+strconv.Itoa(asn))
+			return true
+		}
+	}
+	return false
+}
+
+// BlocksName returns true when the component must not send traffic toward the name, and records the attempt.
+// The names that are addresses, such as the host of a URL, are checked against the netblocks.
+func (p *Policy) BlocksName(component, name string) bool {
+	if p == nil {
+		return false
+	}
+
+	name = normalizeName(name)
+	if net.ParseIP(name) != nil {
+		return p.BlocksAddress(component, name)
+	}
+	if p.err != nil {
+		p.record(component, name, unloaded, MatchUnloaded, p.err.Error())
+		return true
+	}
+	if name == "" {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	for i := range labels {
+		suffix := strings.Join(labels[i:], ".")
+
+		if rule, found := p.suffixes[suffix]; found {
+			p.record(component, name, rule, MatchDomain, suffix)
+			return true
+		}
+	}
+	return false
+}
+
+// matchAddr returns the longest netblock of the never-touch list holding the address.
+func (p *Policy) matchAddr(ip net.IP) *network {
+	var best *network
+	bestLen := -1
+
+	for i, n := range p.networks {
+		if !n.ipnet.Contains(ip) {
+			continue
+		}
+		if ones, _ := n.ipnet.Mask.Size(); ones > bestLen {
+			best, bestLen = &p.networks[i], ones
+		}
+	}
+	return best
+}
+
+// record counts the blocked attempt, and logs the first attempt of the component toward the target.
+func (p *Policy) record(component, target string, rule *Rule, match, evidence string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.blocked++
+	key := violationKey{component: component, target: target}
+	if v, found := p.violations[key]; found {
+		v.Attempts++
+		return
+	}
+
+	p.violations[key] = &Violation{
+		Component: component,
+		Target:    target,
+		Rule:      rule.Name,
+		Match:     match,
+		Evidence:  evidence,
+		Attempts:  1,
+	}
+	if p.Log != nil {
+		p.Log.Printf("Policy block: the %s probe toward %s matches the never-touch rule %s (%s %s)",
+			component, target, rule.Name, match, evidence)
+	}
+}
+
+// Blocked returns the number of attempts blocked by the policy.
+func (p *Policy) Blocked() int64 {
+	if p == nil {
+		return 0
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.blocked
+}
+
+// Violations returns the blocked attempts of each component toward each target, sorted by the component and target.
+func (p *Policy) Violations() []*Violation {
+	if p == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	violations := make([]*Violation, 0, len(p.violations))
+	for _, v := range p.violations {
+		c := *v
+		violations = append(violations, &c)
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Component != violations[j].Component {
+			return violations[i].Component < violations[j].Component
+		}
+		return violations[i].Target < violations[j].Target
+	})
+	return violations
+}
+
+// Report returns the blocked attempts recorded by the policy, or nil when no policy was named.
+func (p *Policy) Report() *Report {
+	if p == nil {
+		return nil
+	}
+
+	return &Report{
+		Path:       p.Path,
+		Blocked:    p.Blocked(),
+		Violations: p.Violations(),
+	}
+}
+
+func normalizeName(name string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(name)), ".")
+}
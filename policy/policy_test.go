@@ -0,0 +1,141 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+const neverTouch = `{
+	"rules": [
+		{"name": "government", "cidrs": ["198.51.100.0/24"], "asns": [64496], "domains": ["gov", "mil"]},
+		{"name": "client exclusions", "cidrs": ["198.51.100.128/25", "2001:db8::1"], "domains": ["payments.owasp.org"]}
+	]
+}`
+
+func TestLoad(t *testing.T) {
+	p, err := Load(strings.NewReader(neverTouch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := p.Rules(); len(rules) != 2 || rules[1].Name != "client exclusions" {
+		t.Errorf("the rules are %+v", rules)
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"name": "empty"}]}`,
+		`{"rules": [{"cidrs": ["198.51.100.0/33"]}]}`,
+		`{"rules": [{"asns": [0]}]}`,
+		`{"rules": [{"domains": ["."]}]}`,
+		`{"rulez": []}`,
+	} {
+		if _, err := Load(strings.NewReader(bad)); err == nil {
+			t.Errorf("the invalid policy %s was accepted", bad)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	p, err := Load(strings.NewReader(neverTouch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	p.Log = log.New(&buf, "", 0)
+	p.SetASNLookup(func(addr string) int {
+		if addr == "203.0.113.9" {
+			return 64496
+		}
+		return 0
+	})
+
+	if !p.BlocksAddress(DNS, "198.51.100.7") || !p.BlocksAddress(DNS, "198.51.100.7") || !p.BlocksAddress(Port, "203.0.113.9") {
+		t.Errorf("the addresses on the never-touch list were not blocked")
+	}
+	if p.BlocksAddress(DNS, "192.0.2.1") || p.BlocksName(DNS, "www.owasp.org") || p.BlocksName(Web, "notgov.com") {
+		t.Errorf("the targets off the never-touch list were blocked")
+	}
+	if !p.BlocksName(Web, "WWW.Agency.GOV.") || !p.BlocksName(DNS, "api.payments.owasp.org") || !p.BlocksName(Web, "2001:db8::1") {
+		t.Errorf("the names on the never-touch list were not blocked")
+	}
+	// The longest netblock wins, regardless of the rule order
+	if !p.BlocksAddress(Port, "198.51.100.200") {
+		t.Fatal("the address in the nested netblock was not blocked")
+	}
+
+	violations := p.Violations()
+	if p.Blocked() != 7 || len(violations) != 6 {
+		t.Fatalf("%d attempts and %d violations were recorded", p.Blocked(), len(violations))
+	}
+	for _, v := range violations {
+		switch {
+		case v.Component == DNS && v.Target == "198.51.100.7":
+			if v.Attempts != 2 || v.Rule != "government" || v.Match != MatchCIDR || v.Evidence != "198.51.100.0/24" {
+				t.Errorf("the violation is %+v", v)
+			}
+		case v.Component == Port && v.Target == "203.0.113.9":
+			if v.Match != MatchASN || v.Evidence != "AS64496" {
+				t.Errorf("the violation is %+v", v)
+			}
+		case v.Component == Port && v.Target == "198.51.100.200":
+			if v.Rule != "client exclusions" {
+				t.Errorf("the violation is %+v", v)
+			}
+		case v.Component == Web && v.Target == "www.agency.gov":
+			if v.Match != MatchDomain || v.Evidence != "gov" {
+				t.Errorf("the violation is %+v", v)
+			}
+		}
+	}
+	// The repeated attempt is counted without being logged again
+	if n := strings.Count(buf.String(), "Policy block:"); n != 6 {
+		t.Errorf("%d blocked attempts were logged", n)
+	}
+
+	var none *Policy
+	if none.BlocksAddress(DNS, "198.51.100.7") || none.BlocksName(DNS, "www.agency.gov") || none.Report() != nil {
+		t.Errorf("the missing policy blocked a target")
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "never_touch.json"), []byte(neverTouch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	if p, err := FromConfig(cfg); p != nil || err != nil {
+		t.Errorf("a policy was returned without the options")
+	}
+
+	// The path is relative to the configuration file
+	cfg.Filepath = filepath.Join(dir, "config.yaml")
+	cfg.Options["policy"] = map[string]interface{}{"file": "never_touch.json"}
+	p, err := FromConfig(cfg)
+	if err != nil || p == nil || p.Path != filepath.Join(dir, "never_touch.json") {
+		t.Fatalf("the policy was not loaded: %v", err)
+	}
+
+	// The policy that cannot be loaded blocks every target
+	cfg.Options["policy"] = map[string]interface{}{"file": "missing.json"}
+	p, err = FromConfig(cfg)
+	if err == nil || p == nil || p.Err() == nil {
+		t.Fatalf("the missing policy was not reported")
+	}
+	if !p.BlocksAddress(DNS, "192.0.2.1") || !p.BlocksName(Web, "www.owasp.org") {
+		t.Errorf("the policy that could not be loaded did not block the targets")
+	}
+	if r := p.Report(); r.Blocked != 2 || r.Violations[0].Match != MatchUnloaded {
+		t.Errorf("the report is %+v", r)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package profile loads configuration files that extend a base configuration file. A profile names the
+// file it extends with the 'extends' key, and its sections override those of the base. Maps are merged
+// key by key, while the lists and the other values of the profile replace those inherited, unless the
+// key carries the append marker. The profile that produced a configuration is recorded in its options.
+package profile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/owasp-amass/config/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ExtendsKey is the key of a profile naming the file it extends, relative to the profile.
+const ExtendsKey = "extends"
+
+// OptionKey is the configuration option recording the profile that produced the configuration.
+const OptionKey = "profile"
+
+// The markers suffixed to the keys of a profile, which select how the value is merged with the one inherited.
+const (
+	// AppendMarker appends the list of the profile to the list inherited, such as 'domains+'
+	AppendMarker = "+"
+	// ReplaceMarker replaces the value inherited without merging it, such as 'options!', which is
+	// what happens to the unmarked lists and scalars
+	ReplaceMarker = "!"
+)
+
+// ErrCycle is returned when a profile extends itself through the chain of the files it extends.
+var ErrCycle = errors.New("cyclic profile")
+
+// Profile identifies the profile that produced a configuration.
+type Profile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Chain holds the files that were merged, starting with the profile and ending with the base
+	Chain []string `json:"chain"`
+}
+
+// Extends returns true when the configuration file extends another file, and is loaded as a profile.
+func Extends(path string) (bool, error) {
+	doc, err := readFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	_, found := doc[ExtendsKey]
+	return found, nil
+}
+
+// Load merges the profile with the files it extends, validates the result, and loads it into the configuration.
+// The relative paths found in the merged sections are resolved from the directory of the profile.
+func Load(path string, cfg *config.Config) (*Profile, error) {
+	merged, chain, err := Merge(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the profile %s: %v", path, err)
+	}
+	if err := validate(data); err != nil {
+		return nil, fmt.Errorf("the profile %s is not valid: %v", path, err)
+	}
+
+	abs := chain[0]
+	if err := loadSettings(cfg, abs, data); err != nil {
+		return nil, fmt.Errorf("failed to load the profile %s: %v", path, err)
+	}
+	if err := cfg.CheckSettings(); err != nil {
+		return nil, fmt.Errorf("the profile %s is not valid: %v", path, err)
+	}
+
+	p := &Profile{
+		Name:  strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs)),
+		Path:  abs,
+		Chain: chain,
+	}
+	if cfg.Options == nil {
+		cfg.Options = make(map[string]interface{})
+	}
+	cfg.Options[OptionKey] = map[string]interface{}{
+		"name":  p.Name,
+		"path":  p.Path,
+		"chain": append([]string(nil), chain...),
+	}
+	return p, nil
+}
+
+// Merge returns the sections of the profile merged over those of the files it extends, along with the
+// absolute paths of the files, starting with the profile. A file extended twice in the chain is rejected.
+func Merge(path string) (map[string]interface{}, []string, error) {
+	var chain []string
+	var docs []map[string]interface{}
+
+	seen := make(map[string]struct{})
+	for next := path; next != ""; {
+		abs, err := filepath.Abs(next)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get the absolute path of %s: %v", next, err)
+		}
+		if _, found := seen[abs]; found {
+			return nil, nil, fmt.Errorf("%w: %s extends %s", ErrCycle, strings.Join(chain, " extends "), abs)
+		}
+		seen[abs] = struct{}{}
+		chain = append(chain, abs)
+
+		doc, err := readFile(abs)
+		if err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, doc)
+
+		next = ""
+		if v, found := doc[ExtendsKey]; found {
+			base, ok := v.(string)
+			if !ok || strings.TrimSpace(base) == "" {
+				return nil, nil, fmt.Errorf("the '%s' key of %s does not name a file", ExtendsKey, abs)
+			}
+			if next = strings.TrimSpace(base); !filepath.IsAbs(next) {
+				next = filepath.Join(filepath.Dir(abs), next)
+			}
+			delete(doc, ExtendsKey)
+		}
+	}
+
+	// The base is merged first, and each profile extending it overrides the result
+	merged := make(map[string]interface{})
+	for i := len(docs) - 1; i >= 0; i-- {
+		var err error
+		if merged, err = mergeMaps(merged, docs[i], ""); err != nil {
+			return nil, nil, fmt.Errorf("failed to merge %s: %v", chain[i], err)
+		}
+	}
+	return merged, chain, nil
+}
+
+// mergeMaps returns the base with the sections of the overlay merged in, following the markers of the keys.
+func mergeMaps(base, overlay map[string]interface{}, prefix string) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(base)+len(overlay))
+	for k, v := range base {
+		result[k] = v
+	}
+
+	for key, v := range overlay {
+		switch {
+		case strings.HasSuffix(key, AppendMarker):
+			name := strings.TrimSuffix(key, AppendMarker)
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("the value of '%s%s' appended is not a list", prefix, key)
+			}
+
+			var inherited []interface{}
+			if cur, found := result[name]; found && cur != nil {
+				if inherited, ok = cur.([]interface{}); !ok {
+					return nil, fmt.Errorf("the value of '%s%s' inherited is not a list", prefix, name)
+				}
+			}
+			result[name] = append(append([]interface{}(nil), inherited...), list...)
+		case strings.HasSuffix(key, ReplaceMarker):
+			result[strings.TrimSuffix(key, ReplaceMarker)] = v
+		default:
+			om, ok := v.(map[string]interface{})
+			bm, isMap := result[key].(map[string]interface{})
+			if !ok || !isMap {
+				result[key] = v
+				continue
+			}
+
+			m, err := mergeMaps(bm, om, prefix+key+".")
+			if err != nil {
+				return nil, err
+			}
+			result[key] = m
+		}
+	}
+
+	// The markers of the nested maps are applied, even when the base had nothing to merge them with
+	for key, v := range result {
+		if m, ok := v.(map[string]interface{}); ok && hasMarkers(m) {
+			clean, err := mergeMaps(nil, m, prefix+key+".")
+			if err != nil {
+				return nil, err
+			}
+			result[key] = clean
+		}
+	}
+	return result, nil
+}
+
+func hasMarkers(m map[string]interface{}) bool {
+	for key, v := range m {
+		if strings.HasSuffix(key, AppendMarker) || strings.HasSuffix(key, ReplaceMarker) {
+			return true
+		}
+		if nested, ok := v.(map[string]interface{}); ok && hasMarkers(nested) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate rejects the merged sections that do not belong to the configuration, such as a misspelled section
+// of a profile, which the configuration would otherwise ignore without a word.
+func validate(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	if err := dec.Decode(config.NewConfig()); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// loadSettings loads the merged sections into the configuration. The file is written beside the profile,
+// so the relative paths are resolved from its directory, and it is removed once the settings are loaded.
+func loadSettings(cfg *config.Config, path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".amass-profile-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := cfg.LoadSettings(f.Name()); err != nil {
+		return err
+	}
+	cfg.Filepath = path
+	return nil
+}
+
+// readFile returns the sections of the configuration file.
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the configuration file: %v", err)
+	}
+
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse the configuration file %s: %v", path, err)
+	}
+	return doc, nil
+}
+
+// FromConfig returns the profile that produced the configuration, or nil when it was not loaded from a profile.
+func FromConfig(cfg *config.Config) *Profile {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options[OptionKey].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	p := &Profile{}
+	p.Name, _ = opts["name"].(string)
+	p.Path, _ = opts["path"].(string)
+	switch chain := opts["chain"].(type) {
+	case []string:
+		p.Chain = append(p.Chain, chain...)
+	case []interface{}:
+		for _, c := range chain {
+			if s, ok := c.(string); ok {
+				p.Chain = append(p.Chain, s)
+			}
+		}
+	}
+	return p
+}
@@ -0,0 +1,175 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package profile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const baseConfig = `
+scope:
+  domains:
+    - owasp.org
+  blacklist:
+    - old.owasp.org
+options:
+  bruteforce:
+    enabled: true
+    wordlists:
+      - words.txt
+  confidence:
+    min: 0.5
+    source: 0.2
+  split_horizon:
+    resolvers:
+      - 10.0.0.53
+`
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "base.yaml", baseConfig)
+	writeFile(t, dir, "clients/team.yaml", `
+extends: ../base.yaml
+scope:
+  blacklist+:
+    - dev.owasp.org
+options:
+  confidence:
+    min: 0.7
+`)
+	path := writeFile(t, dir, "clients/acme.yaml", `
+extends: team.yaml
+scope:
+  domains:
+    - acme.com
+options:
+  split_horizon!:
+    resolvers+:
+      - 10.1.0.53
+`)
+
+	merged, chain, err := Merge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || filepath.Base(chain[0]) != "acme.yaml" || filepath.Base(chain[2]) != "base.yaml" {
+		t.Errorf("the chain is %v", chain)
+	}
+	if _, found := merged[ExtendsKey]; found {
+		t.Errorf("the merged configuration names a file to extend")
+	}
+
+	scope := merged["scope"].(map[string]interface{})
+	// The unmarked list replaces the one inherited, while the marked list is appended
+	if !reflect.DeepEqual(scope["domains"], []interface{}{"acme.com"}) {
+		t.Errorf("the domains are %v", scope["domains"])
+	}
+	if !reflect.DeepEqual(scope["blacklist"], []interface{}{"old.owasp.org", "dev.owasp.org"}) {
+		t.Errorf("the blacklist is %v", scope["blacklist"])
+	}
+
+	opts := merged["options"].(map[string]interface{})
+	// The maps are merged key by key, unless replaced
+	if conf := opts["confidence"].(map[string]interface{}); conf["min"] != 0.7 || conf["source"] != 0.2 {
+		t.Errorf("the confidence section is %v", conf)
+	}
+	if sh := opts["split_horizon"].(map[string]interface{}); !reflect.DeepEqual(sh["resolvers"], []interface{}{"10.1.0.53"}) {
+		t.Errorf("the replaced section is %v", sh)
+	}
+	if _, found := opts["bruteforce"]; !found {
+		t.Errorf("the section of the base was not inherited")
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.yaml", "extends: b.yaml\n")
+	writeFile(t, dir, "b.yaml", "extends: c.yaml\n")
+	writeFile(t, dir, "c.yaml", "extends: a.yaml\n")
+
+	_, _, err := Merge(a)
+	if !errors.Is(err, ErrCycle) || !strings.Contains(err.Error(), "c.yaml extends") {
+		t.Errorf("the cycle was reported as %v", err)
+	}
+
+	self := writeFile(t, dir, "self.yaml", "extends: ./self.yaml\n")
+	if _, _, err := Merge(self); !errors.Is(err, ErrCycle) {
+		t.Errorf("the profile extending itself was reported as %v", err)
+	}
+
+	appended := writeFile(t, dir, "appended.yaml", "extends: base.yaml\nscope:\n  domains+: acme.com\n")
+	writeFile(t, dir, "base.yaml", baseConfig)
+	if _, _, err := Merge(appended); err == nil {
+		t.Errorf("the value appended to the list was not a list, and was accepted")
+	}
+
+	missing := writeFile(t, dir, "missing.yaml", "extends: nowhere.yaml\n")
+	if _, _, err := Merge(missing); err == nil {
+		t.Errorf("the missing base was accepted")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "base.yaml", baseConfig)
+	writeFile(t, dir, "words.txt", "www\nmail\n")
+	path := writeFile(t, dir, "acme.yaml", "extends: base.yaml\nscope:\n  domains+:\n    - acme.com\n")
+
+	if extends, err := Extends(path); err != nil || !extends {
+		t.Errorf("the profile was not recognized: %v", err)
+	}
+	if extends, _ := Extends(filepath.Join(dir, "base.yaml")); extends {
+		t.Errorf("the base was recognized as a profile")
+	}
+
+	cfg := config.NewConfig()
+	p, err := Load(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "acme" || len(p.Chain) != 2 || cfg.Filepath != p.Path {
+		t.Errorf("the profile was recorded as %+v", p)
+	}
+	if domains := cfg.Domains(); len(domains) != 2 {
+		t.Errorf("the domains in scope are %v", domains)
+	}
+	// The relative path of the wordlist is resolved from the directory of the profile
+	if len(cfg.Wordlist) != 2 {
+		t.Errorf("the wordlist holds %d words", len(cfg.Wordlist))
+	}
+	if r := FromConfig(cfg); r == nil || !reflect.DeepEqual(*r, *p) {
+		t.Errorf("the configuration recorded the profile as %+v", r)
+	}
+	// The temporary file holding the merged sections is removed
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".amass-profile-*")); len(matches) != 0 {
+		t.Errorf("the merged sections were left in %v", matches)
+	}
+
+	invalid := writeFile(t, dir, "invalid.yaml", "extends: base.yaml\nscopes:\n  domains:\n    - acme.com\n")
+	if _, err := Load(invalid, config.NewConfig()); err == nil || !strings.Contains(err.Error(), "scopes") {
+		t.Errorf("the misspelled section was reported as %v", err)
+	}
+	if FromConfig(config.NewConfig()) != nil {
+		t.Errorf("the configuration without a profile returned one")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package provenance persists how each finding of the enumerations was discovered, so the chains of derivations
+// can be walked once the enumeration no longer holds them. The derivations are appended to a file in the output
+// directory as they are recorded, for each graph database system, and the events are identified by their root
+// domain names.
+package provenance
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+// FileName is the name of the file in the output directory that holds the derivations.
+const FileName = "provenance.jsonl"
+
+// maxLineLength is the longest line of the file that is read.
+const maxLineLength = 1 << 20
+
+// Step is a single step in the chain explaining how a finding was discovered.
+type Step struct {
+	Name       string `json:"name"`
+	Parent     string `json:"parent,omitempty"`
+	Derivation string `json:"derivation"`
+}
+
+// Root returns true when the derivation ends a chain, since the finding was not derived from another finding.
+func Root(derivation string) bool {
+	switch derivation {
+	case requests.DerivedFromSeed, requests.DerivedFromProvided,
+		requests.DerivedFromGraph, requests.DerivedFromSource, requests.DerivedFromJSFile:
+		return true
+	}
+	return false
+}
+
+// record is a line of the file.
+type record struct {
+	System string `json:"system"`
+	Event  string `json:"event"`
+	Step
+}
+
+// Store appends the derivations recorded by the enumerations to the file in the output directory.
+type Store struct {
+	sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// Open returns the store of the derivations in the directory, creating the file when it does not exist.
+func Open(dir string) (*Store, error) {
+	path := filepath.Join(dir, FileName)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the provenance file: %v", err)
+	}
+
+	s := &Store{path: path, f: f, w: bufio.NewWriter(f)}
+	// A line cut short by a crash is ended, so the next derivation starts a line of its own
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			_ = s.w.WriteByte('\n')
+		}
+	}
+	return s, nil
+}
+
+// Backend returns the derivations of the graph database system.
+func (s *Store) Backend(system string) *Backend {
+	if s == nil {
+		return nil
+	}
+	return &Backend{store: s, system: strings.ToLower(system)}
+}
+
+// Flush writes the derivations appended since the last write to the file.
+func (s *Store) Flush() error {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.flush()
+}
+
+func (s *Store) flush() error {
+	if s.w == nil {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write the provenance file: %v", err)
+	}
+	return nil
+}
+
+// Close writes the derivations appended since the last write, and closes the file.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+
+	err := s.flush()
+	if cerr := s.f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close the provenance file: %v", cerr)
+	}
+	s.f, s.w = nil, nil
+	return err
+}
+
+// Backend provides the derivations of a single graph database system. A nil Backend keeps nothing.
+type Backend struct {
+	store  *Store
+	system string
+}
+
+// Add appends the derivation of the finding discovered by the event.
+func (b *Backend) Add(event string, st Step) error {
+	if b == nil {
+		return nil
+	}
+
+	event, st.Name = strings.ToLower(event), strings.ToLower(st.Name)
+	if event == "" || st.Name == "" {
+		return errors.New("the provenance requires the event and the name")
+	}
+
+	data, err := json.Marshal(&record{System: b.system, Event: event, Step: st})
+	if err != nil {
+		return fmt.Errorf("failed to encode the provenance of %s: %v", st.Name, err)
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	if b.store.w == nil {
+		return errors.New("the provenance file has been closed")
+	}
+	if _, err := b.store.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write the provenance file: %v", err)
+	}
+	return nil
+}
+
+// Load reads the derivations of the findings discovered by the event. The latest derivation recorded for
+// a finding is kept, so the chains follow the latest enumeration of the event.
+func (b *Backend) Load(event string) (*Chains, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if err := b.store.Flush(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(b.store.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the provenance file: %v", err)
+	}
+	defer f.Close()
+
+	c := &Chains{steps: make(map[string]Step)}
+	event = strings.ToLower(event)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineLength)
+	for scanner.Scan() {
+		var rec record
+		// A line cut short by a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.System == b.system && rec.Event == event {
+			c.steps[rec.Name] = rec.Step
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the provenance file: %v", err)
+	}
+	return c, nil
+}
+
+// Chains holds the derivations of the findings discovered by an event.
+type Chains struct {
+	steps map[string]Step
+}
+
+// Len returns the number of findings with a derivation.
+func (c *Chains) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.steps)
+}
+
+// Chain returns the derivations from the finding back to a seed domain or an external source. Since the
+// derivations of several enumerations are held, the chain also ends at a finding it already went through.
+func (c *Chains) Chain(name string) []Step {
+	if c == nil {
+		return nil
+	}
+
+	var results []Step
+	seen := make(map[string]struct{})
+	for n := strings.ToLower(name); n != ""; {
+		st, found := c.steps[n]
+		if _, loop := seen[n]; !found || loop {
+			break
+		}
+		seen[n] = struct{}{}
+
+		results = append(results, st)
+		if Root(st.Derivation) {
+			break
+		}
+		n = st.Parent
+	}
+	return results
+}
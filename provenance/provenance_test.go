@@ -0,0 +1,137 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package provenance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/owasp-amass/amass/v4/requests"
+)
+
+func TestProvenancePersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := s.Backend("local")
+	for _, st := range []Step{
+		{Name: "owasp.org", Derivation: requests.DerivedFromSeed},
+		{Name: "WWW.owasp.org", Parent: "crtsh", Derivation: requests.DerivedFromSource},
+		{Name: "192.168.1.1", Parent: "www.owasp.org", Derivation: requests.DerivedFromA},
+		{Name: "vpn.owasp.org", Parent: "192.168.1.1", Derivation: requests.DerivedFromCert},
+	} {
+		if err := local.Add("OWASP.org", st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := local.Add("owasp.org", Step{Derivation: requests.DerivedFromSeed}); err == nil {
+		t.Error("a derivation without a name was added")
+	}
+	if err := s.Backend("postgres").Add("owasp.org", Step{Name: "mail.owasp.org", Derivation: requests.DerivedFromSource}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Add("owasp.org", Step{Name: "owasp.org", Derivation: requests.DerivedFromSeed}); err == nil {
+		t.Error("a derivation was added after the store was closed")
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := s.Backend("Local").Load("owasp.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Step{
+		{Name: "vpn.owasp.org", Parent: "192.168.1.1", Derivation: requests.DerivedFromCert},
+		{Name: "192.168.1.1", Parent: "www.owasp.org", Derivation: requests.DerivedFromA},
+		{Name: "www.owasp.org", Parent: "crtsh", Derivation: requests.DerivedFromSource},
+	}
+	chain := c.Chain("VPN.owasp.org")
+	if len(chain) != len(expected) {
+		t.Fatalf("Expected a chain of length %d, got %v", len(expected), chain)
+	}
+	for i, st := range expected {
+		if chain[i] != st {
+			t.Errorf("Step %d: expected %v, got %v", i, st, chain[i])
+		}
+	}
+	// The derivations of each graph database system and event are kept apart
+	if c.Len() != 4 {
+		t.Errorf("Load returned %d derivations, expected 4", c.Len())
+	}
+	if c, err := s.Backend("local").Load("owasp.com"); err != nil || c.Len() != 0 {
+		t.Errorf("Load returned %d derivations of another event: %v", c.Len(), err)
+	}
+}
+
+func TestProvenanceLatest(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	b := s.Backend("local")
+	for _, st := range []Step{
+		{Name: "owasp.org", Derivation: requests.DerivedFromSeed},
+		{Name: "vpn.owasp.org", Parent: "owasp.org", Derivation: requests.DerivedFromBrute},
+		{Name: "vpn1.owasp.org", Parent: "vpn.owasp.org", Derivation: requests.DerivedFromAlteration},
+		// A later enumeration derived the names the other way around
+		{Name: "vpn.owasp.org", Parent: "vpn1.owasp.org", Derivation: requests.DerivedFromAlteration},
+	} {
+		if err := b.Add("owasp.org", st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A line cut short by a crash is skipped
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, FileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"system":"local","event":"owasp.org","name":"mail.`)
+	_ = f.Close()
+
+	c, err := b.Load("owasp.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 3 {
+		t.Errorf("Load returned %d derivations, expected 3", c.Len())
+	}
+	// The derivations added after the line cut short are read
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = s.Backend("local")
+	if err := b.Add("owasp.org", Step{Name: "mail.owasp.org", Derivation: requests.DerivedFromSource}); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = b.Load("owasp.org"); err != nil || c.Len() != 4 {
+		t.Errorf("Load returned %d derivations after the line cut short: %v", c.Len(), err)
+	}
+	// The chain ends at a name it already went through
+	if chain := c.Chain("vpn.owasp.org"); len(chain) != 2 || chain[0].Parent != "vpn1.owasp.org" {
+		t.Errorf("Unexpected chain for the names derived from each other: %v", chain)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package quarantine keeps the suspicious findings set apart from the results until an analyst reviews them,
+// along with the decisions to promote them into the results or to reject them for good. The findings are
+// persisted in the output directory for each graph database system, and the events are identified by their
+// root domain names.
+package quarantine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the file in the output directory that holds the quarantined findings.
+const FileName = "quarantine.json"
+
+// fileVersion is the version of the quarantine file format.
+const fileVersion = 1
+
+// The rules classifying the findings as suspicious.
+const (
+	// RuleSingleSource is a name provided by a single source of a low reputation type
+	RuleSingleSource = "single_source"
+	// RuleWildcard is a name sitting under a DNS wildcard
+	RuleWildcard = "wildcard"
+	// RuleAdjacent is a name outside of the scope, under a domain sharing its label with a domain in scope
+	RuleAdjacent = "adjacent"
+)
+
+// State is the review state of a quarantined finding.
+type State string
+
+// The review states of the quarantined findings.
+const (
+	Quarantined State = "quarantined"
+	Promoted    State = "promoted"
+	Rejected    State = "rejected"
+)
+
+// Entry is a finding of an event set apart by a rule, along with the decision made on it.
+type Entry struct {
+	Event string `json:"event"`
+	Name  string `json:"name"`
+	// Domain is the registrable domain of a name outside of the scope
+	Domain    string    `json:"domain,omitempty"`
+	Rule      string    `json:"rule"`
+	Reason    string    `json:"reason"`
+	Sources   []string  `json:"sources,omitempty"`
+	Addresses []string  `json:"addresses,omitempty"`
+	State     State     `json:"state"`
+	Added     time.Time `json:"added"`
+	Updated   time.Time `json:"updated"`
+}
+
+type quarantineFile struct {
+	Version  int                          `json:"version"`
+	Backends map[string]map[string]*Entry `json:"backends"`
+}
+
+// Store holds the quarantined findings of each graph database system. The findings added by the enumerations
+// are written by Save, while the decisions are written as soon as they are made.
+type Store struct {
+	sync.Mutex
+	path     string
+	backends map[string]map[string]*Entry
+	dirty    bool
+}
+
+// Open loads the quarantine file in the directory, which is created by the first finding saved.
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		path:     filepath.Join(dir, FileName),
+		backends: make(map[string]map[string]*Entry),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the quarantine: %v", err)
+	}
+
+	var f quarantineFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse the quarantine: %v", err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("the quarantine file has the unsupported version %d", f.Version)
+	}
+	for system, entries := range f.Backends {
+		if entries != nil {
+			s.backends[strings.ToLower(system)] = entries
+		}
+	}
+	return s, nil
+}
+
+// Backend returns the quarantined findings of the graph database system.
+func (s *Store) Backend(system string) *Backend {
+	if s == nil {
+		return nil
+	}
+	return &Backend{store: s, system: strings.ToLower(system)}
+}
+
+// Save writes the findings added since the last write.
+func (s *Store) Save() error {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// Close writes the findings added since the last write.
+func (s *Store) Close() error {
+	return s.Save()
+}
+
+// save writes the quarantine, replacing the file only once the new content is complete.
+// The store must be locked by the caller.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(&quarantineFile{
+		Version:  fileVersion,
+		Backends: s.backends,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the quarantine: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write the quarantine: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace the quarantine file: %v", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// Backend provides the quarantined findings of a single graph database system. A nil Backend keeps nothing.
+type Backend struct {
+	store  *Store
+	system string
+}
+
+// Add quarantines the finding of the event, and returns the state of the finding. A finding already promoted
+// or rejected keeps the decision made on it, so the decisions hold across the runs.
+func (b *Backend) Add(en Entry) (State, error) {
+	if b == nil {
+		return Quarantined, nil
+	}
+
+	en.Event, en.Name = strings.ToLower(en.Event), strings.ToLower(en.Name)
+	if en.Event == "" || en.Name == "" {
+		return "", errors.New("the quarantine requires the event and the name")
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	entries, found := b.store.backends[b.system]
+	if !found {
+		entries = make(map[string]*Entry)
+		b.store.backends[b.system] = entries
+	}
+
+	now := time.Now()
+	k := key(en.Event, en.Name)
+	if cur, found := entries[k]; found {
+		if cur.State != Quarantined {
+			return cur.State, nil
+		}
+		en.Added = cur.Added
+	} else {
+		en.Added = now
+	}
+
+	en.State = Quarantined
+	en.Updated = now
+	en.Sources = append([]string(nil), en.Sources...)
+	en.Addresses = append([]string(nil), en.Addresses...)
+	entries[k] = &en
+	b.store.dirty = true
+	return Quarantined, nil
+}
+
+// Release removes the quarantined finding of the event, since it is no longer suspicious.
+// The decisions made on the findings are kept.
+func (b *Backend) Release(event, name string) {
+	if b == nil {
+		return
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	k := key(event, name)
+	if en, found := b.store.backends[b.system][k]; found && en.State == Quarantined {
+		delete(b.store.backends[b.system], k)
+		b.store.dirty = true
+	}
+}
+
+// Promote moves the quarantined finding of the event into the results, and persists the decision.
+func (b *Backend) Promote(event, name string) error {
+	return b.decide(event, name, Promoted)
+}
+
+// Reject excludes the quarantined finding of the event from the results for good, and persists the decision.
+func (b *Backend) Reject(event, name string) error {
+	return b.decide(event, name, Rejected)
+}
+
+func (b *Backend) decide(event, name string, state State) error {
+	if b == nil {
+		return errors.New("the quarantine has not been opened")
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	en, found := b.store.backends[b.system][key(event, name)]
+	if !found {
+		return fmt.Errorf("the name %s was not quarantined by the event for %s", strings.ToLower(name), strings.ToLower(event))
+	}
+
+	en.State = state
+	en.Updated = time.Now()
+	return b.store.save()
+}
+
+// Get returns the finding of the event, when it was quarantined.
+func (b *Backend) Get(event, name string) (Entry, bool) {
+	if b == nil {
+		return Entry{}, false
+	}
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	en, found := b.store.backends[b.system][key(event, name)]
+	if !found {
+		return Entry{}, false
+	}
+	return copyEntry(en), true
+}
+
+// State returns the review state of the finding of the event, and false when it was never quarantined.
+func (b *Backend) State(event, name string) (State, bool) {
+	en, found := b.Get(event, name)
+	return en.State, found
+}
+
+// List returns the findings quarantined by the event, sorted by the name.
+func (b *Backend) List(event string) []Entry {
+	if b == nil {
+		return nil
+	}
+	event = strings.ToLower(event)
+
+	b.store.Lock()
+	defer b.store.Unlock()
+
+	var list []Entry
+	for _, en := range b.store.backends[b.system] {
+		if en.Event == event {
+			list = append(list, copyEntry(en))
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func copyEntry(en *Entry) Entry {
+	c := *en
+	c.Sources = append([]string(nil), en.Sources...)
+	c.Addresses = append([]string(nil), en.Addresses...)
+	return c
+}
+
+func key(event, name string) string {
+	return strings.ToLower(event) + "|" + strings.ToLower(name)
+}
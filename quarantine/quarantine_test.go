@@ -0,0 +1,112 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package quarantine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuarantinePersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := s.Backend("local")
+	for _, en := range []Entry{
+		{Event: "owasp.org", Name: "OLD.owasp.org", Rule: RuleSingleSource, Sources: []string{"Wayback"}},
+		{Event: "owasp.org", Name: "app.owasp.org", Rule: RuleWildcard},
+		{Event: "owasp.org", Name: "www.owasp.com", Domain: "owasp.com", Rule: RuleAdjacent},
+	} {
+		if state, err := local.Add(en); err != nil || state != Quarantined {
+			t.Fatalf("the finding %s was added as %s: %v", en.Name, state, err)
+		}
+	}
+	// The findings added are only written by Save
+	if _, err := os.Stat(filepath.Join(dir, FileName)); err == nil {
+		t.Error("the findings were written before the store was saved")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local = s.Backend("Local")
+	list := local.List("owasp.org")
+	if len(list) != 3 || list[0].Name != "app.owasp.org" || list[1].Name != "old.owasp.org" || list[2].Domain != "owasp.com" {
+		t.Fatalf("List returned %+v", list)
+	}
+	// The findings of each graph database system are kept apart
+	if _, found := s.Backend("postgres").State("owasp.org", "app.owasp.org"); found {
+		t.Error("the name was quarantined in another graph database system")
+	}
+	if other := local.List("example.com"); len(other) != 0 {
+		t.Errorf("List returned the findings of another event: %+v", other)
+	}
+}
+
+func TestDecisionsPersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := s.Backend("local")
+	_, _ = local.Add(Entry{Event: "owasp.org", Name: "old.owasp.org", Rule: RuleSingleSource})
+	_, _ = local.Add(Entry{Event: "owasp.org", Name: "app.owasp.org", Rule: RuleWildcard})
+
+	if err := local.Promote("owasp.org", "old.owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Reject("owasp.org", "app.owasp.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Promote("owasp.org", "www.owasp.org"); err == nil {
+		t.Error("the name that was never quarantined was promoted")
+	}
+
+	// The decisions are written without saving the store
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local = s.Backend("local")
+	if state, _ := local.State("owasp.org", "old.owasp.org"); state != Promoted {
+		t.Errorf("the promoted name is %s", state)
+	}
+	if state, _ := local.State("owasp.org", "app.owasp.org"); state != Rejected {
+		t.Errorf("the rejected name is %s", state)
+	}
+
+	// The names found again by the next run keep the decisions made on them
+	if state, _ := local.Add(Entry{Event: "owasp.org", Name: "app.owasp.org", Rule: RuleWildcard}); state != Rejected {
+		t.Errorf("the rejected name was added again as %s", state)
+	}
+	local.Release("owasp.org", "old.owasp.org")
+	if state, _ := local.State("owasp.org", "old.owasp.org"); state != Promoted {
+		t.Errorf("the release removed the decision on the promoted name, which is %s", state)
+	}
+}
+
+func TestNilBackend(t *testing.T) {
+	var b *Backend
+
+	if state, err := b.Add(Entry{Event: "owasp.org", Name: "app.owasp.org"}); err != nil || state != Quarantined {
+		t.Errorf("the nil backend added the finding as %s: %v", state, err)
+	}
+	if _, found := b.State("owasp.org", "app.owasp.org"); found {
+		t.Error("the nil backend kept the finding")
+	}
+	if err := b.Reject("owasp.org", "app.owasp.org"); err == nil {
+		t.Error("the nil backend accepted the decision")
+	}
+}
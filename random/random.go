@@ -0,0 +1,124 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package random draws the randomness of an enumeration from the seed of the run, such as the labels
+// of the wildcard probes and the order of the queries, so a run can be reproduced from its seed.
+package random
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/config/config"
+)
+
+// Source provides the randomness of a run, drawn from its seed.
+type Source struct {
+	Seed int64
+}
+
+var seedLock sync.Mutex
+
+// FromConfig returns the Source of the run seeded by the 'seed' configuration option. When no seed has
+// been configured, a random one is generated and recorded in the options, so all the components of the
+// run share it.
+func FromConfig(cfg *config.Config) *Source {
+	if cfg == nil {
+		return New(newSeed())
+	}
+
+	seedLock.Lock()
+	defer seedLock.Unlock()
+
+	if cfg.Options == nil {
+		cfg.Options = make(map[string]interface{})
+	}
+	if seed := int64Option(cfg.Options["seed"]); seed != 0 {
+		return New(seed)
+	}
+
+	seed := newSeed()
+	cfg.Options["seed"] = seed
+	return New(seed)
+}
+
+// New returns the Source drawing the randomness from the seed.
+func New(seed int64) *Source {
+	return &Source{Seed: seed}
+}
+
+// newSeed returns a seed from the cryptographic randomness of the host, which is never zero.
+func newSeed() int64 {
+	var b [8]byte
+
+	if _, err := crand.Read(b[:]); err == nil {
+		if seed := int64(binary.LittleEndian.Uint64(b[:]) >> 1); seed != 0 {
+			return seed
+		}
+	}
+	return time.Now().UnixNano()
+}
+
+// Rand returns the randomness used for the named purpose. Each purpose draws its own sequence from
+// the seed, so the order of the calls made for one purpose does not change the others. The returned
+// Rand is safe for concurrent use.
+func (s *Source) Rand(purpose string) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(purpose))
+
+	return rand.New(&lockedSource{src: rand.NewSource(s.Seed ^ int64(h.Sum64())).(rand.Source64)})
+}
+
+// Metadata returns the seed that is stored with the enumeration event.
+func (s *Source) Metadata() map[string]string {
+	return map[string]string{"seed": strconv.FormatInt(s.Seed, 10)}
+}
+
+// UnlikelyName returns a name under the zone that is not expected to exist, such as to learn the answers of a wildcard.
+func UnlikelyName(rng *rand.Rand, zone string) string {
+	return strconv.FormatInt(rng.Int63(), 36) + "." + zone
+}
+
+type lockedSource struct {
+	sync.Mutex
+	src rand.Source64
+}
+
+func (ls *lockedSource) Int63() int64 {
+	ls.Lock()
+	defer ls.Unlock()
+
+	return ls.src.Int63()
+}
+
+func (ls *lockedSource) Uint64() uint64 {
+	ls.Lock()
+	defer ls.Unlock()
+
+	return ls.src.Uint64()
+}
+
+func (ls *lockedSource) Seed(seed int64) {
+	ls.Lock()
+	defer ls.Unlock()
+
+	ls.src.Seed(seed)
+}
+
+func int64Option(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
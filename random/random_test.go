@@ -0,0 +1,59 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package random
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+
+	s := FromConfig(cfg)
+	if s.Seed == 0 {
+		t.Fatal("no seed was generated")
+	}
+	// The generated seed is recorded, so the other components of the run share it
+	if again := FromConfig(cfg); again.Seed != s.Seed {
+		t.Errorf("the seed changed from %d to %d", s.Seed, again.Seed)
+	}
+	if other := FromConfig(config.NewConfig()); other.Seed == s.Seed {
+		t.Error("two runs generated the same seed")
+	}
+
+	cfg.Options["seed"] = 42
+	if s := FromConfig(cfg); s.Seed != 42 || s.Metadata()["seed"] != "42" {
+		t.Errorf("the configured seed was parsed as %d", s.Seed)
+	}
+}
+
+func TestRandPurposes(t *testing.T) {
+	s := New(42)
+
+	first := s.Rand("wildcard")
+	second := New(42).Rand("wildcard")
+	other := s.Rand("mail")
+
+	var diverged, differ bool
+	for i := 0; i < 10; i++ {
+		a, b, c := first.Int63(), second.Int63(), other.Int63()
+		diverged = diverged || a != b
+		differ = differ || a != c
+	}
+	if diverged {
+		t.Error("the same seed and purpose produced different sequences")
+	}
+	if !differ {
+		t.Error("the purposes share the same sequence")
+	}
+
+	name := UnlikelyName(New(42).Rand("wildcard"), "owasp.org")
+	if !strings.HasSuffix(name, ".owasp.org") || name != UnlikelyName(New(42).Rand("wildcard"), "owasp.org") {
+		t.Errorf("the unlikely name %s was not reproduced", name)
+	}
+}
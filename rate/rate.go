@@ -0,0 +1,123 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package rate limits the rate of outbound queries with token buckets that stay
+// within their burst when the clock jumps or the process is paused.
+package rate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+)
+
+// Limiter is a token bucket allowing a number of events each second and bursts of a limited size.
+type Limiter struct {
+	sync.Mutex
+	clock  clock.Clock
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// DefaultBurst returns the burst used when none has been configured, which is a tenth of a second of events.
+func DefaultBurst(qps int) int {
+	if burst := qps / 10; burst > 1 {
+		return burst
+	}
+	return 1
+}
+
+// NewLimiter returns a Limiter allowing qps events each second, or nil when qps is not positive.
+// The bucket starts full, and the default burst is used when burst is not positive.
+func NewLimiter(qps, burst int, c clock.Clock) *Limiter {
+	if qps <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = DefaultBurst(qps)
+	}
+	if c == nil {
+		c = clock.System
+	}
+
+	return &Limiter{
+		clock:  c,
+		qps:    float64(qps),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   c.Now(),
+	}
+}
+
+// Take blocks until the next event is allowed. A nil Limiter never blocks.
+func (l *Limiter) Take() {
+	if l == nil {
+		return
+	}
+
+	if wait := l.reserve(); wait > 0 {
+		l.clock.Sleep(wait)
+	}
+}
+
+// TakeContext blocks until the next event is allowed or the context is done, and returns
+// the context error in the latter case. A nil Limiter never blocks.
+func (l *Limiter) TakeContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil || l == nil {
+		return err
+	}
+
+	wait := l.reserve()
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		// Hand the reserved token back to the callers that follow
+		l.Lock()
+		l.tokens++
+		l.Unlock()
+		return ctx.Err()
+	case <-l.clock.After(wait):
+	}
+	return nil
+}
+
+// reserve takes a token, so the callers that follow wait behind this one, and returns
+// how long the caller must wait before the event is allowed.
+func (l *Limiter) reserve() time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	l.refill(l.clock.Now())
+	l.tokens--
+	if l.tokens < 0 {
+		return time.Duration(-l.tokens / l.qps * float64(time.Second))
+	}
+	return 0
+}
+
+// refill adds the tokens accrued since the last observation. The time between two observations
+// is never credited with more than the burst, and a clock that moved backward credits nothing.
+// The waits are computed from the tokens alone, so a jump cannot stall the callers either.
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last)
+	l.last = now
+	if elapsed <= 0 {
+		return
+	}
+
+	accrued := elapsed.Seconds() * l.qps
+	if accrued > l.burst {
+		accrued = l.burst
+	}
+	if l.tokens += accrued; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
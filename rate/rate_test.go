@@ -0,0 +1,170 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+)
+
+// takeAll returns the fake times at which each of the num events were allowed.
+func takeAll(l *Limiter, c *clock.Fake, num int) []time.Time {
+	var times []time.Time
+
+	for i := 0; i < num; i++ {
+		l.Take()
+		times = append(times, c.Now())
+	}
+	return times
+}
+
+// maxPerSecond returns the largest number of events allowed within any one second window.
+func maxPerSecond(times []time.Time) int {
+	var max int
+
+	for i := range times {
+		var n int
+		for j := i; j < len(times) && times[j].Sub(times[i]) < time.Second; j++ {
+			n++
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func TestLimiterRate(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	l := NewLimiter(100, 10, c)
+
+	start := c.Now()
+	takeAll(l, c, 110)
+	// The full bucket is spent at once, and the other events are spaced by the rate
+	if elapsed := c.Now().Sub(start); elapsed < 990*time.Millisecond || elapsed > 1010*time.Millisecond {
+		t.Errorf("110 events at 100 QPS with a burst of 10 took %v, expected 1s", elapsed)
+	}
+}
+
+func TestLimiterClockJump(t *testing.T) {
+	qps, burst := 50, 20
+	c := clock.NewFake(time.Now())
+	l := NewLimiter(qps, burst, c)
+
+	takeAll(l, c, 100)
+	c.Jump(10 * time.Minute)
+	_, before := c.Slept()
+
+	times := takeAll(l, c, 5*qps)
+	var immediate int
+	for _, tm := range times {
+		if !tm.After(times[0]) {
+			immediate++
+		}
+	}
+	// The last event before the jump still holds its reservation
+	if immediate > burst || immediate < burst-1 {
+		t.Errorf("%d events were allowed right after the clock jump, expected the burst of %d", immediate, burst)
+	}
+	if _, after := c.Slept(); after-before < len(times)-burst {
+		t.Errorf("%d events waited after the clock jump, expected at least %d", after-before, len(times)-burst)
+	}
+	if n := maxPerSecond(times); n > qps+burst {
+		t.Errorf("%d events were allowed within a second after the clock jump, expected at most %d", n, qps+burst)
+	}
+	// After the burst has been spent, the events are back to the configured rate
+	if n := maxPerSecond(times[burst:]); n > qps {
+		t.Errorf("%d events per second were allowed after the burst, expected at most %d", n, qps)
+	}
+}
+
+func TestLimiterPendingDebtSurvivesJump(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	l := NewLimiter(10, 5, c)
+
+	// Reserve tokens well beyond the bucket without the clock moving
+	l.Lock()
+	l.tokens = -50
+	l.Unlock()
+
+	c.Jump(time.Hour)
+	l.Lock()
+	l.refill(c.Now())
+	tokens := l.tokens
+	l.Unlock()
+
+	if tokens != -45 {
+		t.Errorf("the jump left %v tokens, expected the debt to be reduced by the burst to -45", tokens)
+	}
+}
+
+func TestLimiterBackwardJump(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	l := NewLimiter(10, 1, c)
+
+	l.Take()
+	c.Jump(-time.Hour)
+	before, _ := c.Slept()
+	l.Take()
+	// The wait is computed from the tokens, so the clock moving backward does not stall the caller
+	if slept, _ := c.Slept(); slept-before > 100*time.Millisecond {
+		t.Errorf("the event waited %v after the clock moved backward", slept-before)
+	}
+	// Nothing accrues for the time the clock moved backward
+	l.Lock()
+	tokens := l.tokens
+	l.Unlock()
+	if tokens > 0 {
+		t.Errorf("the bucket holds %v tokens after the clock moved backward", tokens)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+
+	if NewLimiter(0, 10, nil) != nil {
+		t.Error("NewLimiter returned a limiter for a zero rate")
+	}
+	l.Take()
+	if err := l.TakeContext(context.Background()); err != nil {
+		t.Errorf("TakeContext on a nil limiter returned %v", err)
+	}
+}
+
+func TestTakeContextCancelled(t *testing.T) {
+	l := NewLimiter(1, 1, clock.System)
+	l.Take()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := l.TakeContext(ctx); err == nil {
+		t.Error("TakeContext did not return the context error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("TakeContext waited %v after the context was done", elapsed)
+	}
+
+	l.Lock()
+	tokens := l.tokens
+	l.Unlock()
+	if tokens < -0.5 {
+		t.Errorf("the cancelled wait kept its token, leaving %v tokens", tokens)
+	}
+}
+
+func TestDefaultBurst(t *testing.T) {
+	tests := map[int]int{1: 1, 10: 1, 25: 2, 1000: 100}
+
+	for qps, expected := range tests {
+		if got := DefaultBurst(qps); got != expected {
+			t.Errorf("DefaultBurst(%d) returned %d, expected %d", qps, got, expected)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package rdap
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// service is an entry of a bootstrap file, which maps the TLDs or address blocks to the base URLs of a registry.
+type service struct {
+	entries []string
+	urls    []string
+}
+
+// services requests the bootstrap file of the kind (dns, ipv4 or ipv6) once during the run.
+func (c *Client) services(ctx context.Context, kind string) ([]service, error) {
+	val, err := c.once(ctx, c.bootstrap, kind, func() (interface{}, error) {
+		var file struct {
+			Services [][][]string `json:"services"`
+		}
+
+		if err := c.get(ctx, c.cfg.BootstrapURL+kind+".json", "application/json", &file); err != nil {
+			return nil, err
+		}
+
+		var list []service
+		for _, s := range file.Services {
+			if len(s) == 2 && len(s[0]) > 0 && len(s[1]) > 0 {
+				list = append(list, service{entries: s[0], urls: s[1]})
+			}
+		}
+		return list, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.([]service), nil
+}
+
+// domainService returns the base URL of the registry serving the TLD of the domain name.
+func (c *Client) domainService(ctx context.Context, domain string) (string, error) {
+	list, err := c.services(ctx, "dns")
+	if err != nil {
+		return "", err
+	}
+
+	var best string
+	var urls []string
+	for _, s := range list {
+		for _, tld := range s.entries {
+			tld = strings.ToLower(tld)
+			// The longest label sequence matching the end of the name is the one serving it
+			if (domain == tld || strings.HasSuffix(domain, "."+tld)) && len(tld) > len(best) {
+				best = tld
+				urls = s.urls
+			}
+		}
+	}
+	return baseURL(urls)
+}
+
+// netblockService returns the base URL of the registry serving the smallest address block containing the netblock.
+func (c *Client) netblockService(ctx context.Context, cidr *net.IPNet) (string, error) {
+	kind := "ipv6"
+	if cidr.IP.To4() != nil {
+		kind = "ipv4"
+	}
+
+	list, err := c.services(ctx, kind)
+	if err != nil {
+		return "", err
+	}
+
+	ones, _ := cidr.Mask.Size()
+	best := -1
+	var urls []string
+	for _, s := range list {
+		for _, entry := range s.entries {
+			_, block, err := net.ParseCIDR(entry)
+			if err != nil {
+				continue
+			}
+
+			if size, _ := block.Mask.Size(); size <= ones && size > best && block.Contains(cidr.IP) {
+				best = size
+				urls = s.urls
+			}
+		}
+	}
+	return baseURL(urls)
+}
+
+// baseURL prefers the HTTPS URL of a registry, and ensures it ends with a slash.
+func baseURL(urls []string) (string, error) {
+	if len(urls) == 0 {
+		return "", ErrNoService
+	}
+
+	u := urls[0]
+	for _, candidate := range urls {
+		if strings.HasPrefix(candidate, "https://") {
+			u = candidate
+			break
+		}
+	}
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	return u, nil
+}
@@ -0,0 +1,122 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package rdap
+
+import (
+	"strings"
+	"time"
+)
+
+// object holds the parts of an RDAP domain or IP network object that provide the registration data.
+type object struct {
+	Entities []entity `json:"entities"`
+	Events   []event  `json:"events"`
+}
+
+type entity struct {
+	Roles    []string      `json:"roles"`
+	VCard    []interface{} `json:"vcardArray"`
+	Entities []entity      `json:"entities"`
+}
+
+type event struct {
+	Action string `json:"eventAction"`
+	Date   string `json:"eventDate"`
+}
+
+// registration extracts the registrar, registrant organization, abuse contact and creation date of the object.
+func (o *object) registration() *Registration {
+	reg := new(Registration)
+
+	for _, ev := range o.Events {
+		if strings.EqualFold(ev.Action, "registration") {
+			if t, err := time.Parse(time.RFC3339, ev.Date); err == nil {
+				reg.Created = t.UTC()
+			}
+		}
+	}
+	for _, e := range o.Entities {
+		e.fill(reg)
+	}
+	return reg
+}
+
+// fill sets the fields of the registration provided by the entity and the entities nested within it,
+// such as the abuse contact of a registrar.
+func (e *entity) fill(reg *Registration) {
+	name, org, email := e.vcard()
+	if org == "" {
+		org = name
+	}
+
+	for _, role := range e.Roles {
+		switch strings.ToLower(role) {
+		case "registrar":
+			if reg.Registrar == "" {
+				reg.Registrar = org
+			}
+		case "registrant":
+			if reg.Org == "" {
+				reg.Org = org
+			}
+		case "abuse":
+			if reg.Abuse == "" {
+				if email != "" {
+					reg.Abuse = email
+				} else {
+					reg.Abuse = name
+				}
+			}
+		}
+	}
+	for _, nested := range e.Entities {
+		nested.fill(reg)
+	}
+}
+
+// vcard returns the formatted name, organization and email address of the jCard of the entity.
+func (e *entity) vcard() (name, org, email string) {
+	if len(e.VCard) != 2 {
+		return
+	}
+
+	props, ok := e.VCard[1].([]interface{})
+	if !ok {
+		return
+	}
+	for _, p := range props {
+		prop, ok := p.([]interface{})
+		if !ok || len(prop) < 4 {
+			continue
+		}
+
+		key, _ := prop[0].(string)
+		value := vcardValue(prop[3])
+		switch strings.ToLower(key) {
+		case "fn":
+			name = value
+		case "org":
+			org = value
+		case "email":
+			email = value
+		}
+	}
+	return
+}
+
+// vcardValue returns the text of a jCard property value, which is structured as a list for some properties.
+func vcardValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return strings.TrimSpace(val)
+	case []interface{}:
+		for _, part := range val {
+			if s := vcardValue(part); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,278 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package rdap obtains the registration data of domain names and netblocks through the Registration Data
+// Access Protocol, which provides structured responses where whois only provides free-form text. The
+// registries are discovered through the IANA bootstrap files, and whois is used for the TLDs without RDAP.
+package rdap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	amasshttp "github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/config/config"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// DefaultBootstrapURL is the location of the IANA bootstrap files that map the TLDs and address blocks to their registries.
+	DefaultBootstrapURL = "https://data.iana.org/rdap/"
+	// DefaultWhoisServer is the whois server asked for the server of a TLD or address block without RDAP.
+	DefaultWhoisServer = "whois.iana.org:43"
+	// DefaultQPS is the number of queries sent to each registry every second when none has been configured.
+	DefaultQPS = 2
+	// maxResponseSize is the largest RDAP or whois response read.
+	maxResponseSize = 1 << 20
+)
+
+// ErrNoService is returned when the bootstrap files provide no RDAP service for the domain name or netblock.
+var ErrNoService = errors.New("no RDAP service is available")
+
+// Registration is the registration data of a domain name or netblock.
+type Registration struct {
+	Registrar string    `json:"registrar,omitempty"`
+	Created   time.Time `json:"created"`
+	Org       string    `json:"org,omitempty"`
+	Abuse     string    `json:"abuse,omitempty"`
+	// Source is "rdap" or "whois", depending on the protocol that provided the data
+	Source string `json:"source"`
+}
+
+// Config is the configuration of the RDAP client.
+type Config struct {
+	// QPS is the number of queries sent to each registry every second
+	QPS int
+	// Whois enables the fallback to whois for the TLDs and address blocks without RDAP
+	Whois bool
+	// BootstrapURL is the location of the dns.json, ipv4.json and ipv6.json bootstrap files
+	BootstrapURL string
+	// WhoisServer is the address of the whois server that refers the queries to the registries
+	WhoisServer string
+}
+
+// ConfigFromOptions returns the RDAP client settings found in the configuration options,
+// or nil when the registration lookups have not been enabled.
+func ConfigFromOptions(cfg *config.Config) *Config {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["rdap"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, _ := opts["enabled"].(bool); !enabled {
+		return nil
+	}
+
+	c := &Config{
+		QPS:          DefaultQPS,
+		Whois:        true,
+		BootstrapURL: DefaultBootstrapURL,
+		WhoisServer:  DefaultWhoisServer,
+	}
+	if qps := options.Int(opts["qps"]); qps > 0 {
+		c.QPS = qps
+	}
+	if whois, ok := opts["whois"].(bool); ok {
+		c.Whois = whois
+	}
+	return c
+}
+
+// lookup is a query made once during the run, which the callers asking for the same data wait on.
+type lookup struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// Client queries the RDAP registries, and caches the results for each domain name and netblock
+// during the run. The queries sent to each registry are rate limited.
+type Client struct {
+	sync.Mutex
+	cfg       Config
+	http      *http.Client
+	clock     clock.Clock
+	limiters  map[string]*rate.Limiter
+	bootstrap map[string]*lookup
+	domains   map[string]*lookup
+	netblocks map[string]*lookup
+}
+
+// NewClient returns a Client using the configuration, which sends the queries with the shared HTTP client.
+func NewClient(cfg *Config) *Client {
+	c := Config{QPS: DefaultQPS, Whois: true}
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.QPS <= 0 {
+		c.QPS = DefaultQPS
+	}
+	if c.BootstrapURL == "" {
+		c.BootstrapURL = DefaultBootstrapURL
+	}
+	if !strings.HasSuffix(c.BootstrapURL, "/") {
+		c.BootstrapURL += "/"
+	}
+	if c.WhoisServer == "" {
+		c.WhoisServer = DefaultWhoisServer
+	}
+
+	return &Client{
+		cfg:       c,
+		http:      amasshttp.DefaultClient,
+		clock:     clock.System,
+		limiters:  make(map[string]*rate.Limiter),
+		bootstrap: make(map[string]*lookup),
+		domains:   make(map[string]*lookup),
+		netblocks: make(map[string]*lookup),
+	}
+}
+
+// Domain returns the registration data of the registered domain of the name.
+func (c *Client) Domain(ctx context.Context, name string) (*Registration, error) {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.Trim(strings.ToLower(strings.TrimSpace(name)), "."))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a registered domain name: %v", name, err)
+	}
+
+	val, err := c.once(ctx, c.domains, domain, func() (interface{}, error) {
+		base, err := c.domainService(ctx, domain)
+		if errors.Is(err, ErrNoService) && c.cfg.Whois {
+			return c.whois(ctx, domain)
+		} else if err != nil {
+			return nil, err
+		}
+		return c.query(ctx, base+"domain/"+domain)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(*Registration), nil
+}
+
+// Netblock returns the registration data of the netblock, which is provided in CIDR notation.
+func (c *Client) Netblock(ctx context.Context, prefix string) (*Registration, error) {
+	_, cidr, err := net.ParseCIDR(strings.TrimSpace(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a netblock: %v", prefix, err)
+	}
+	key := cidr.String()
+
+	val, err := c.once(ctx, c.netblocks, key, func() (interface{}, error) {
+		base, err := c.netblockService(ctx, cidr)
+		if errors.Is(err, ErrNoService) && c.cfg.Whois {
+			return c.whois(ctx, cidr.IP.String())
+		} else if err != nil {
+			return nil, err
+		}
+		return c.query(ctx, base+"ip/"+key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(*Registration), nil
+}
+
+// once performs the lookup the first time the key is requested, and returns the cached result afterward.
+// Lookups ended by the context are not cached, so they are attempted again by the next caller.
+func (c *Client) once(ctx context.Context, cache map[string]*lookup, key string, fn func() (interface{}, error)) (interface{}, error) {
+	c.Lock()
+	l, found := cache[key]
+	if !found {
+		l = &lookup{done: make(chan struct{})}
+		cache[key] = l
+	}
+	c.Unlock()
+
+	if found {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-l.done:
+			return l.val, l.err
+		}
+	}
+
+	l.val, l.err = fn()
+	if l.err != nil && ctx.Err() != nil {
+		c.Lock()
+		delete(cache, key)
+		c.Unlock()
+	}
+	close(l.done)
+	return l.val, l.err
+}
+
+// limit blocks until the next query to the host is allowed, or the context is done.
+func (c *Client) limit(ctx context.Context, host string) error {
+	c.Lock()
+	l, found := c.limiters[host]
+	if !found {
+		l = rate.NewLimiter(c.cfg.QPS, 1, c.clock)
+		c.limiters[host] = l
+	}
+	c.Unlock()
+
+	return l.TakeContext(ctx)
+}
+
+// query requests the RDAP object at the URL, and extracts the registration data.
+func (c *Client) query(ctx context.Context, u string) (*Registration, error) {
+	var obj object
+
+	if err := c.get(ctx, u, "application/rdap+json", &obj); err != nil {
+		return nil, err
+	}
+
+	reg := obj.registration()
+	reg.Source = "rdap"
+	return reg, nil
+}
+
+// get requests the JSON document at the URL and decodes it into v.
+func (c *Client) get(ctx context.Context, u, accept string, v interface{}) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if err := c.limit(ctx, parsed.Host); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", amasshttp.UserAgent)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned the status %s", u, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse the response of %s: %v", u, err)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package rdap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+)
+
+const domainObject = `{
+  "objectClassName": "domain",
+  "ldhName": "EXAMPLE.COM",
+  "events": [{"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"}],
+  "entities": [{
+    "roles": ["registrar"],
+    "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "RESERVED-Internet Assigned Numbers Authority"]]],
+    "entities": [{
+      "roles": ["abuse"],
+      "vcardArray": ["vcard", [["fn", {}, "text", "Abuse Desk"], ["email", {}, "text", "abuse@iana.org"]]]
+    }]
+  }, {
+    "roles": ["registrant"],
+    "vcardArray": ["vcard", [["fn", {}, "text", "Domain Administrator"], ["org", {}, "text", "Example Inc."]]]
+  }]
+}`
+
+const networkObject = `{
+  "objectClassName": "ip network",
+  "events": [{"eventAction": "registration", "eventDate": "2010-06-01T12:00:00-04:00"}],
+  "entities": [{
+    "roles": ["registrant"],
+    "vcardArray": ["vcard", [["fn", {}, "text", "Documentation Networks"]]],
+    "entities": [{
+      "roles": ["abuse"],
+      "vcardArray": ["vcard", [["email", {}, "text", "noc@example.net"]]]
+    }]
+  }]
+}`
+
+// fakeRegistry serves the bootstrap files and the RDAP objects, and counts the requests of each path.
+type fakeRegistry struct {
+	sync.Mutex
+	hits      map[string]int
+	bootstrap *httptest.Server
+	registry  *httptest.Server
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	f := &fakeRegistry{hits: make(map[string]int)}
+
+	f.registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.hit(r.URL.Path)
+		switch r.URL.Path {
+		case "/rdap/domain/example.com":
+			fmt.Fprint(w, domainObject)
+		case "/rdap/ip/198.51.100.0/24":
+			fmt.Fprint(w, networkObject)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	f.bootstrap = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.hit(r.URL.Path)
+		base := f.registry.URL + "/rdap"
+		switch r.URL.Path {
+		case "/dns.json":
+			fmt.Fprintf(w, `{"services": [[["net", "com"], ["%s"]], [["co.uk"], ["%s"]]]}`, base, base)
+		case "/ipv4.json":
+			fmt.Fprintf(w, `{"services": [[["198.51.0.0/16"], ["http://unused.example/"]], [["198.51.100.0/22"], ["%s"]]]}`, base)
+		default:
+			fmt.Fprint(w, `{"services": []}`)
+		}
+	}))
+	t.Cleanup(func() {
+		f.registry.Close()
+		f.bootstrap.Close()
+	})
+	return f
+}
+
+func (f *fakeRegistry) hit(path string) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.hits[path]++
+}
+
+func (f *fakeRegistry) count(path string) int {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.hits[path]
+}
+
+func (f *fakeRegistry) client(whois bool) *Client {
+	c := NewClient(&Config{QPS: 1, Whois: whois, BootstrapURL: f.bootstrap.URL})
+	c.http = f.registry.Client()
+	return c
+}
+
+func TestDomainRegistration(t *testing.T) {
+	f := newFakeRegistry(t)
+	c := f.client(false)
+
+	reg, err := c.Domain(context.Background(), "www.Example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(1995, 8, 14, 4, 0, 0, 0, time.UTC)
+	if reg.Registrar != "RESERVED-Internet Assigned Numbers Authority" || reg.Org != "Example Inc." ||
+		reg.Abuse != "abuse@iana.org" || !reg.Created.Equal(created) || reg.Source != "rdap" {
+		t.Errorf("the registration data was not extracted: %+v", reg)
+	}
+
+	// The registrations and bootstrap files are requested once during the run
+	for _, name := range []string{"example.com", "mail.example.com"} {
+		if _, err := c.Domain(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.count("/rdap/domain/example.com"); n != 1 {
+		t.Errorf("the registration of the domain was requested %d times", n)
+	}
+	if n := f.count("/dns.json"); n != 1 {
+		t.Errorf("the bootstrap file was requested %d times", n)
+	}
+
+	if _, err := c.Domain(context.Background(), "example.org"); err != ErrNoService {
+		t.Errorf("a TLD without RDAP returned %v, expected ErrNoService", err)
+	}
+}
+
+func TestNetblockRegistration(t *testing.T) {
+	f := newFakeRegistry(t)
+	c := f.client(false)
+	fake := clock.NewFake(time.Now())
+	c.clock = fake
+
+	if _, err := c.Domain(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The smallest block containing the netblock selects the registry
+	reg, err := c.Netblock(context.Background(), "198.51.100.7/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.Org != "Documentation Networks" || reg.Abuse != "noc@example.net" || reg.Registrar != "" ||
+		!reg.Created.Equal(time.Date(2010, 6, 1, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("the registration data was not extracted: %+v", reg)
+	}
+	if _, err := c.Netblock(context.Background(), "198.51.100.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("/rdap/ip/198.51.100.0/24"); n != 1 {
+		t.Errorf("the registration of the netblock was requested %d times", n)
+	}
+
+	// Only the second request to the bootstrap host waited, since each registry has its own limit
+	if slept, _ := fake.Slept(); slept != time.Second {
+		t.Errorf("the requests waited %s, expected one second", slept)
+	}
+	if _, err := c.Netblock(context.Background(), "203.0.113.0/24"); err != ErrNoService {
+		t.Errorf("an address block without RDAP returned %v, expected ErrNoService", err)
+	}
+}
+
+// startWhoisServer answers each query with the response returned by the function.
+func startWhoisServer(t *testing.T, respond func(query string) string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				query, _ := bufio.NewReader(conn).ReadString('\n')
+				fmt.Fprint(conn, respond(strings.TrimSpace(query)))
+			}(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestWhoisFallback(t *testing.T) {
+	f := newFakeRegistry(t)
+
+	registry := startWhoisServer(t, func(query string) string {
+		if query != "example.org" {
+			return "No match for " + query
+		}
+		return strings.Join([]string{
+			"% Terms of use apply",
+			"Domain Name: EXAMPLE.ORG",
+			"Registrar WHOIS Server: whois.example-registrar.net",
+			"Creation Date: 1995-04-30T04:00:00Z",
+			"Registrar: Example Registrar, LLC",
+			"Registrar Abuse Contact Email: abuse@example-registrar.net",
+			"Registrant Organization: Example Organization",
+			"Registrar: Another Registrar",
+		}, "\r\n")
+	})
+	root := startWhoisServer(t, func(query string) string {
+		return "domain:       ORG\r\n\r\nrefer:        " + registry + "\r\n"
+	})
+
+	c := f.client(true)
+	c.cfg.WhoisServer = root
+	reg, err := c.Domain(context.Background(), "www.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reg.Registrar != "Example Registrar, LLC" || reg.Org != "Example Organization" || reg.Abuse != "abuse@example-registrar.net" ||
+		!reg.Created.Equal(time.Date(1995, 4, 30, 4, 0, 0, 0, time.UTC)) || reg.Source != "whois" {
+		t.Errorf("the registration data was not parsed from the whois response: %+v", reg)
+	}
+
+	// The TLDs with RDAP never reach the whois servers
+	if reg, err := c.Domain(context.Background(), "example.com"); err != nil || reg.Source != "rdap" {
+		t.Errorf("the registration of a TLD with RDAP was provided by %+v: %v", reg, err)
+	}
+}
+
+func TestStorePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetDomain("Example.com.", &Registration{Registrar: "Registrar", Org: "Example Inc.", Source: "rdap"})
+	s.SetNetblock("198.51.100.7/24", &Registration{Org: "Documentation Networks", Abuse: "noc@example.net", Source: "whois"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg := s.Domain("example.com"); reg == nil || reg.Org != "Example Inc." {
+		t.Errorf("the registration of the domain was not persisted: %+v", reg)
+	}
+	if reg := s.Netblock("198.51.100.0/24"); reg == nil || reg.Abuse != "noc@example.net" || reg.Source != "whois" {
+		t.Errorf("the registration of the netblock was not persisted: %+v", reg)
+	}
+	if reg := s.Domain("example.org"); reg != nil {
+		t.Errorf("a domain without registration data returned %+v", reg)
+	}
+}
+
+func TestConfigFromOptions(t *testing.T) {
+	cfg := config.NewConfig()
+	if c := ConfigFromOptions(cfg); c != nil {
+		t.Error("the registration lookups were enabled without the rdap section")
+	}
+
+	cfg.Options["rdap"] = map[string]interface{}{"enabled": true, "qps": 5, "whois": false}
+	c := ConfigFromOptions(cfg)
+	if c == nil || c.QPS != 5 || c.Whois || c.BootstrapURL != DefaultBootstrapURL {
+		t.Errorf("the rdap section was not parsed: %+v", c)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package rdap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileName is the name of the file in the output directory that holds the registration data.
+const FileName = "registrations.json"
+
+// fileVersion is the version of the registrations file format.
+const fileVersion = 1
+
+type registrationsFile struct {
+	Version   int                      `json:"version"`
+	Domains   map[string]*Registration `json:"domains"`
+	Netblocks map[string]*Registration `json:"netblocks"`
+}
+
+// Store keeps the registration data of the domain names and netblocks found by the enumerations.
+// The graph schema has no properties, so the data is kept by the store, keyed by the name of the
+// domain node or the CIDR of the netblock node it describes.
+type Store struct {
+	sync.Mutex
+	path      string
+	domains   map[string]*Registration
+	netblocks map[string]*Registration
+}
+
+// Open loads the registrations file in the directory, which is created when the store is closed.
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		path:      filepath.Join(dir, FileName),
+		domains:   make(map[string]*Registration),
+		netblocks: make(map[string]*Registration),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the registrations: %v", err)
+	}
+
+	var f registrationsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse the registrations: %v", err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("the registrations file has the unsupported version %d", f.Version)
+	}
+	for name, reg := range f.Domains {
+		if reg != nil {
+			s.domains[strings.ToLower(name)] = reg
+		}
+	}
+	for prefix, reg := range f.Netblocks {
+		if reg != nil {
+			s.netblocks[prefix] = reg
+		}
+	}
+	return s, nil
+}
+
+// SetDomain stores the registration data of the domain name.
+func (s *Store) SetDomain(name string, reg *Registration) {
+	if reg == nil {
+		return
+	}
+	c := *reg
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.domains[strings.ToLower(strings.Trim(name, "."))] = &c
+}
+
+// SetNetblock stores the registration data of the netblock, which is provided in CIDR notation.
+func (s *Store) SetNetblock(prefix string, reg *Registration) {
+	if reg == nil {
+		return
+	}
+	c := *reg
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.netblocks[normalizePrefix(prefix)] = &c
+}
+
+// Domain returns the registration data stored for the domain name, or nil when there is none.
+func (s *Store) Domain(name string) *Registration {
+	s.Lock()
+	defer s.Unlock()
+
+	if reg, found := s.domains[strings.ToLower(strings.Trim(name, "."))]; found {
+		c := *reg
+		return &c
+	}
+	return nil
+}
+
+// Netblock returns the registration data stored for the netblock, or nil when there is none.
+func (s *Store) Netblock(prefix string) *Registration {
+	s.Lock()
+	defer s.Unlock()
+
+	if reg, found := s.netblocks[normalizePrefix(prefix)]; found {
+		c := *reg
+		return &c
+	}
+	return nil
+}
+
+// Close persists the registration data, replacing the file only once the new content is complete.
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := json.MarshalIndent(&registrationsFile{
+		Version:   fileVersion,
+		Domains:   s.domains,
+		Netblocks: s.netblocks,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the registrations: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write the registrations: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace the registrations file: %v", err)
+	}
+	return nil
+}
+
+func normalizePrefix(prefix string) string {
+	if _, cidr, err := net.ParseCIDR(strings.TrimSpace(prefix)); err == nil {
+		return cidr.String()
+	}
+	return strings.TrimSpace(prefix)
+}
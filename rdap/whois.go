@@ -0,0 +1,165 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package rdap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	amassnet "github.com/owasp-amass/amass/v4/net"
+)
+
+const whoisTimeout = 15 * time.Second
+
+// whoisFields maps the keys used by the whois servers to the fields of the registration.
+var whoisFields = map[string]string{
+	"registrar":                     "registrar",
+	"sponsoring registrar":          "registrar",
+	"registrant organization":       "org",
+	"registrant organisation":       "org",
+	"registrant":                    "org",
+	"org-name":                      "org",
+	"orgname":                       "org",
+	"owner":                         "org",
+	"registrar abuse contact email": "abuse",
+	"abuse-mailbox":                 "abuse",
+	"orgabuseemail":                 "abuse",
+	"creation date":                 "created",
+	"created":                       "created",
+	"registered on":                 "created",
+	"regdate":                       "created",
+}
+
+// whois obtains the registration data of the domain name or address from the whois server of its registry,
+// which is referred to by the configured whois server. The free-form responses are only parsed for the common keys.
+func (c *Client) whois(ctx context.Context, query string) (*Registration, error) {
+	resp, err := c.whoisQuery(ctx, c.cfg.WhoisServer, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if refer := whoisReferral(resp); refer != "" {
+		if _, _, err := net.SplitHostPort(refer); err != nil {
+			refer = net.JoinHostPort(refer, "43")
+		}
+		if resp, err = c.whoisQuery(ctx, refer, query); err != nil {
+			return nil, err
+		}
+	}
+
+	reg := parseWhois(resp)
+	reg.Source = "whois"
+	return reg, nil
+}
+
+// whoisQuery sends the query to the whois server and returns the response.
+func (c *Client) whoisQuery(ctx context.Context, server, query string) (string, error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a whois server address: %v", server, err)
+	}
+	if err := c.limit(ctx, host); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, whoisTimeout)
+	defer cancel()
+
+	conn, err := amassnet.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach the whois server %s: %v", server, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte(query + "\r\n")); err != nil {
+		return "", fmt.Errorf("failed to query the whois server %s: %v", server, err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(conn, maxResponseSize))
+	if err != nil && len(data) == 0 {
+		return "", fmt.Errorf("failed to read the response of the whois server %s: %v", server, err)
+	}
+	return string(data), nil
+}
+
+// whoisReferral returns the whois server that the response refers the query to.
+func whoisReferral(resp string) string {
+	scanner := bufio.NewScanner(strings.NewReader(resp))
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "refer", "whois", "registrar whois server":
+			if value = strings.TrimSpace(value); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// parseWhois extracts the registration data from the first occurrence of each known key in the response.
+func parseWhois(resp string) *Registration {
+	reg := new(Registration)
+	scanner := bufio.NewScanner(strings.NewReader(resp))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "%") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		if !found || value == "" {
+			continue
+		}
+
+		switch whoisFields[strings.ToLower(strings.TrimSpace(key))] {
+		case "registrar":
+			if reg.Registrar == "" {
+				reg.Registrar = value
+			}
+		case "org":
+			if reg.Org == "" {
+				reg.Org = value
+			}
+		case "abuse":
+			if reg.Abuse == "" {
+				reg.Abuse = value
+			}
+		case "created":
+			if reg.Created.IsZero() {
+				reg.Created = parseWhoisDate(value)
+			}
+		}
+	}
+	return reg
+}
+
+func parseWhoisDate(value string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05Z", "2006-01-02 15:04:05", "2006-01-02", "02-Jan-2006", "2006.01.02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC()
+		}
+	}
+	// Some servers append the time zone or other details to the date
+	if fields := strings.Fields(value); len(fields) > 1 {
+		return parseWhoisDate(fields[0])
+	}
+	return time.Time{}
+}
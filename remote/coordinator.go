@@ -0,0 +1,348 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/resolve"
+)
+
+// sweepChunkSize is the number of addresses sent to a worker in each sweep call.
+const sweepChunkSize = 256
+
+// Coordinator shards the DNS queries of an enumeration across the registered workers.
+// The queries sent to a worker that fails are performed by the remaining workers.
+type Coordinator struct {
+	cfg     *Config
+	tls     *tls.Config
+	workers []*worker
+	done    chan struct{}
+	once    sync.Once
+}
+
+type worker struct {
+	sync.Mutex
+	node      Node
+	client    *rpc.Client
+	rate      *rate.Limiter
+	healthy   bool
+	failures  int
+	resolvers int
+}
+
+// NewCoordinator connects to the workers and starts sending them heartbeats. The workers that
+// cannot be reached are retried with each heartbeat.
+func NewCoordinator(cfg *Config, tlsConfig *tls.Config) (*Coordinator, error) {
+	if cfg == nil || len(cfg.Nodes) == 0 {
+		return nil, errors.New("no workers have been registered")
+	}
+	if tlsConfig == nil {
+		return nil, errors.New("the workers can only be reached using mutual TLS")
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = DefaultHeartbeat
+	}
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = DefaultMaxFailures
+	}
+
+	c := &Coordinator{
+		cfg:  cfg,
+		tls:  tlsConfig,
+		done: make(chan struct{}),
+	}
+	for _, n := range cfg.Nodes {
+		w := &worker{node: n, rate: rate.NewLimiter(n.QPS, n.Burst, clock.System)}
+		c.workers = append(c.workers, w)
+	}
+
+	c.heartbeats()
+	go c.sendHeartbeats()
+	return c, nil
+}
+
+// Close stops the heartbeats and disconnects from the workers.
+func (c *Coordinator) Close() {
+	c.once.Do(func() {
+		close(c.done)
+		for _, w := range c.workers {
+			w.Lock()
+			w.disconnect()
+			w.Unlock()
+		}
+	})
+}
+
+// Len returns the number of resolvers used by the available workers.
+func (c *Coordinator) Len() int {
+	var num int
+
+	for _, w := range c.workers {
+		w.Lock()
+		if w.healthy {
+			num += w.resolvers
+		}
+		w.Unlock()
+	}
+	// The enumeration sizes its queues on the pool, so it cannot be empty
+	if num < len(c.workers) {
+		num = len(c.workers)
+	}
+	return num
+}
+
+// Healthy returns the addresses of the workers that are currently available.
+func (c *Coordinator) Healthy() []string {
+	var addrs []string
+
+	for _, w := range c.workers {
+		w.Lock()
+		if w.healthy {
+			addrs = append(addrs, w.node.Address)
+		}
+		w.Unlock()
+	}
+	return addrs
+}
+
+// Query sends the response on the channel once a worker has performed the query.
+// A failed query is returned with the RcodeNoResponse code, like a local resolver pool.
+func (c *Coordinator) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	go func() {
+		resp, err := c.QueryBlocking(ctx, msg)
+		if err != nil || resp == nil {
+			msg.Rcode = resolve.RcodeNoResponse
+			resp = msg
+		}
+		ch <- resp
+	}()
+}
+
+// QueryBlocking performs the query using the worker responsible for the name.
+func (c *Coordinator) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if msg == nil || len(msg.Question) == 0 {
+		return msg, errors.New("the query has no question")
+	}
+
+	data, err := msg.Pack()
+	if err != nil {
+		return msg, err
+	}
+
+	var reply ResolveReply
+	if err := c.call(ctx, msg.Question[0].Name, "Resolve", ResolveArgs{Msg: data}, &reply); err != nil {
+		return msg, err
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(reply.Msg); err != nil {
+		return msg, err
+	}
+	return resp, nil
+}
+
+// Sweep performs the reverse DNS queries for the addresses across the workers.
+func (c *Coordinator) Sweep(ctx context.Context, addrs []string) ([]requests.DNSAnswer, error) {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var answers []requests.DNSAnswer
+	var failed error
+
+	for i := 0; i < len(addrs); i += sweepChunkSize {
+		end := i + sweepChunkSize
+		if end > len(addrs) {
+			end = len(addrs)
+		}
+
+		wg.Add(1)
+		go func(chunk []string) {
+			defer wg.Done()
+
+			var reply SweepReply
+			err := c.call(ctx, chunk[0], "Sweep", SweepArgs{Addrs: chunk}, &reply)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				failed = err
+				return
+			}
+			answers = append(answers, reply.Answers...)
+		}(addrs[i:end])
+	}
+
+	wg.Wait()
+	return answers, failed
+}
+
+// call invokes the method on the worker responsible for the key. When the worker cannot be
+// reached, the call is sent to the next available worker until none remain.
+func (c *Coordinator) call(ctx context.Context, key, method string, args, reply interface{}) error {
+	tried := make(map[*worker]struct{})
+
+	for {
+		w := c.pick(key, tried)
+		if w == nil {
+			return errors.New("no workers are available")
+		}
+		tried[w] = struct{}{}
+
+		err := w.call(ctx, ServiceName+"."+method, args, reply)
+		if err == nil {
+			return nil
+		}
+		// The worker was reached, so the operation itself failed
+		var serr rpc.ServerError
+		if errors.As(err, &serr) || ctx.Err() != nil {
+			return err
+		}
+		c.failure(w)
+	}
+}
+
+// pick returns the available worker responsible for the key, skipping the workers already tried.
+func (c *Coordinator) pick(key string, tried map[*worker]struct{}) *worker {
+	var candidates []*worker
+
+	for _, w := range c.workers {
+		if _, found := tried[w]; found {
+			continue
+		}
+
+		w.Lock()
+		if w.healthy && w.client != nil {
+			candidates = append(candidates, w)
+		}
+		w.Unlock()
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.ToLower(key)))
+	return candidates[int(h.Sum32()%uint32(len(candidates)))]
+}
+
+func (c *Coordinator) failure(w *worker) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.failures++
+	if w.failures >= c.cfg.MaxFailures {
+		w.healthy = false
+		w.disconnect()
+	}
+}
+
+func (c *Coordinator) sendHeartbeats() {
+	t := time.NewTicker(c.cfg.Heartbeat)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			c.heartbeats()
+		}
+	}
+}
+
+func (c *Coordinator) heartbeats() {
+	var wg sync.WaitGroup
+
+	for _, w := range c.workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			c.heartbeat(w)
+		}(w)
+	}
+	wg.Wait()
+}
+
+func (c *Coordinator) heartbeat(w *worker) {
+	w.Lock()
+	if w.client == nil {
+		if err := w.connect(c.tls, c.cfg.Heartbeat); err != nil {
+			w.healthy = false
+			w.Unlock()
+			return
+		}
+	}
+	w.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Heartbeat)
+	defer cancel()
+
+	var reply HeartbeatReply
+	if err := w.call(ctx, ServiceName+".Heartbeat", HeartbeatArgs{Sent: time.Now()}, &reply); err != nil {
+		c.failure(w)
+		return
+	}
+
+	w.Lock()
+	w.healthy = true
+	w.failures = 0
+	w.resolvers = reply.Resolvers
+	w.Unlock()
+}
+
+// connect must be called while holding the worker lock.
+func (w *worker) connect(tlsConfig *tls.Config, timeout time.Duration) error {
+	d := &net.Dialer{Timeout: timeout}
+
+	conn, err := tls.DialWithDialer(d, "tcp", w.node.Address, tlsConfig.Clone())
+	if err != nil {
+		return fmt.Errorf("failed to connect to the worker %s: %v", w.node.Address, err)
+	}
+
+	w.client = rpc.NewClient(conn)
+	return nil
+}
+
+// disconnect must be called while holding the worker lock.
+func (w *worker) disconnect() {
+	if w.client != nil {
+		_ = w.client.Close()
+		w.client = nil
+	}
+}
+
+func (w *worker) call(ctx context.Context, method string, args, reply interface{}) error {
+	w.Lock()
+	client := w.client
+	w.Unlock()
+
+	if client == nil {
+		return rpc.ErrShutdown
+	}
+	if err := w.rate.TakeContext(ctx); err != nil {
+		return err
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.Done:
+		return call.Error
+	}
+}
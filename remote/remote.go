@@ -0,0 +1,182 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package remote distributes the DNS work of an enumeration across worker processes.
+// The workers and the coordinator communicate through RPC calls over mutually authenticated TLS.
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// ServiceName is the name the worker operations are registered under
+	ServiceName = "Worker"
+	// DefaultHeartbeat is the period between the heartbeats sent to each worker
+	DefaultHeartbeat = 10 * time.Second
+	// DefaultMaxFailures is the number of consecutive failures before a worker is considered down
+	DefaultMaxFailures = 3
+)
+
+// ResolveArgs carries a packed DNS query message to a worker.
+type ResolveArgs struct {
+	Msg []byte
+}
+
+// ResolveReply carries the packed DNS response message obtained by a worker.
+type ResolveReply struct {
+	Msg []byte
+}
+
+// SweepArgs carries the addresses a worker performs reverse DNS queries for.
+type SweepArgs struct {
+	Addrs []string
+}
+
+// SweepReply carries the PTR records found by a worker.
+type SweepReply struct {
+	Answers []requests.DNSAnswer
+}
+
+// HeartbeatArgs is sent by the coordinator to confirm that a worker is available.
+type HeartbeatArgs struct {
+	Sent time.Time
+}
+
+// HeartbeatReply describes the capacity of a worker.
+type HeartbeatReply struct {
+	// Resolvers is the number of DNS resolvers used by the worker
+	Resolvers int
+	// Pending is the number of queries the worker is currently performing
+	Pending int64
+}
+
+// Node is a worker registered with the coordinator.
+type Node struct {
+	Address string
+	// QPS is the maximum number of queries per second sent to the worker
+	QPS int
+	// Burst is the largest number of queries sent to the worker at once, after a pause or a clock jump
+	Burst int
+}
+
+// Config holds the 'workers' configuration options.
+type Config struct {
+	CA          string
+	Cert        string
+	Key         string
+	Heartbeat   time.Duration
+	MaxFailures int
+	Nodes       []Node
+}
+
+// ConfigFromOptions parses the 'workers' configuration options and returns nil when no workers are registered.
+func ConfigFromOptions(cfg *config.Config) *Config {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["workers"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	c := &Config{
+		CA:          stringOption(opts["ca"]),
+		Cert:        stringOption(opts["cert"]),
+		Key:         stringOption(opts["key"]),
+		Heartbeat:   DefaultHeartbeat,
+		MaxFailures: DefaultMaxFailures,
+	}
+	if secs := options.Int(opts["heartbeat"]); secs > 0 {
+		c.Heartbeat = time.Duration(secs) * time.Second
+	}
+	if n := options.Int(opts["max_failures"]); n > 0 {
+		c.MaxFailures = n
+	}
+
+	nodes, _ := opts["nodes"].([]interface{})
+	for _, v := range nodes {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if addr := stringOption(m["address"]); addr != "" {
+			c.Nodes = append(c.Nodes, Node{
+				Address: addr,
+				QPS:     options.Int(m["qps"]),
+				Burst:   options.Int(m["burst"]),
+			})
+		}
+	}
+	if len(c.Nodes) == 0 {
+		return nil
+	}
+	return c
+}
+
+// ServerTLSConfig returns the TLS configuration of a worker, which only accepts clients with certificates signed by the CA.
+func ServerTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	pool, cert, err := loadCredentials(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// ClientTLSConfig returns the TLS configuration of the coordinator, which only trusts workers with certificates signed by the CA.
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	pool, cert, err := loadCredentials(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}, nil
+}
+
+func loadCredentials(caFile, certFile, keyFile string) (*x509.CertPool, tls.Certificate, error) {
+	if caFile == "" || certFile == "" || keyFile == "" {
+		return nil, tls.Certificate{}, errors.New("the CA, certificate and key files are required for mutual TLS")
+	}
+
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("failed to read the CA file %s: %v", caFile, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, tls.Certificate{}, fmt.Errorf("the CA file %s contains no certificates", caFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("failed to load the key pair: %v", err)
+	}
+	return pool, cert, nil
+}
+
+func stringOption(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
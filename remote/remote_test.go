@@ -0,0 +1,340 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+type fakeQuerier struct {
+	sync.Mutex
+	names map[string]int
+}
+
+func newFakeQuerier() *fakeQuerier {
+	return &fakeQuerier{names: make(map[string]int)}
+}
+
+func (fq *fakeQuerier) Len() int { return 5 }
+
+func (fq *fakeQuerier) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	name := msg.Question[0].Name
+
+	fq.Lock()
+	fq.names[strings.TrimSuffix(name, ".")]++
+	fq.Unlock()
+
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	return resp, nil
+}
+
+func (fq *fakeQuerier) seen(name string) bool {
+	fq.Lock()
+	defer fq.Unlock()
+
+	_, found := fq.names[name]
+	return found
+}
+
+func (fq *fakeQuerier) count() int {
+	fq.Lock()
+	defer fq.Unlock()
+
+	return len(fq.names)
+}
+
+// writeCredentials generates a CA and the certificates of a worker and a coordinator signed by it.
+func writeCredentials(t *testing.T) (dir string) {
+	dir = t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Amass Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER)
+
+	for i, name := range []string{"worker", "coordinator"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+		writePEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDER)
+	}
+	return dir
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startWorker serves a worker on the loopback interface until the returned function is called.
+func startWorker(t *testing.T, dir string, fq *fakeQuerier) (string, func()) {
+	tlsConfig, err := ServerTLSConfig(filepath.Join(dir, "ca.pem"),
+		filepath.Join(dir, "worker.pem"), filepath.Join(dir, "worker.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = NewWorker(fq).Serve(ctx, ln)
+		close(done)
+	}()
+
+	var once sync.Once
+	return ln.Addr().String(), func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	dir := writeCredentials(t)
+
+	q1, q2 := newFakeQuerier(), newFakeQuerier()
+	addr1, stop1 := startWorker(t, dir, q1)
+	defer stop1()
+	addr2, stop2 := startWorker(t, dir, q2)
+	defer stop2()
+
+	tlsConfig, err := ClientTLSConfig(filepath.Join(dir, "ca.pem"),
+		filepath.Join(dir, "coordinator.pem"), filepath.Join(dir, "coordinator.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCoordinator(&Config{
+		Heartbeat:   time.Hour,
+		MaxFailures: 1,
+		Nodes:       []Node{{Address: addr1}, {Address: addr2, QPS: 1000}},
+	}, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if n := len(c.Healthy()); n != 2 {
+		t.Fatalf("%d workers are available, expected 2", n)
+	}
+	if n := c.Len(); n != 10 {
+		t.Errorf("the workers reported %d resolvers, expected 10", n)
+	}
+
+	var names []string
+	for i := 0; i < 50; i++ {
+		names = append(names, fmt.Sprintf("host%d.owasp.org", i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, name := range names {
+		resp, err := c.QueryBlocking(ctx, resolve.QueryMsg(name, dns.TypeA))
+		if err != nil {
+			t.Fatalf("the query for %s failed: %v", name, err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Errorf("the response for %s has %d answers, expected 1", name, len(resp.Answer))
+		}
+	}
+	// Each name is sent to exactly one worker
+	if q1.count() == 0 || q2.count() == 0 {
+		t.Fatalf("the queries were not sharded across the workers: %d and %d", q1.count(), q2.count())
+	}
+	if q1.count()+q2.count() != len(names) {
+		t.Errorf("the workers performed %d queries, expected %d", q1.count()+q2.count(), len(names))
+	}
+
+	var moved []string
+	for _, name := range names {
+		if q1.seen(name) {
+			moved = append(moved, name)
+		}
+	}
+
+	// The queries of the failed worker are performed by the remaining worker
+	stop1()
+	for _, name := range moved {
+		resp, err := c.QueryBlocking(ctx, resolve.QueryMsg(name, dns.TypeA))
+		if err != nil || len(resp.Answer) != 1 {
+			t.Fatalf("the query for %s was not sent to the remaining worker: %v", name, err)
+		}
+		if !q2.seen(name) {
+			t.Errorf("the query for %s was not performed by the remaining worker", name)
+		}
+	}
+	if h := c.Healthy(); len(h) != 1 || h[0] != addr2 {
+		t.Errorf("the available workers are %v, expected %s", h, addr2)
+	}
+
+	// The queries fail like a local resolver pool once no workers remain
+	stop2()
+	ch := make(chan *dns.Msg, 1)
+	c.Query(ctx, resolve.QueryMsg("www.owasp.org", dns.TypeA), ch)
+	if resp := <-ch; resp.Rcode != resolve.RcodeNoResponse {
+		t.Errorf("the response has the rcode %d, expected %d", resp.Rcode, resolve.RcodeNoResponse)
+	}
+}
+
+func TestWorkerRequiresClientCertificate(t *testing.T) {
+	dir := writeCredentials(t)
+
+	addr, stop := startWorker(t, dir, newFakeQuerier())
+	defer stop()
+
+	tlsConfig, err := ClientTLSConfig(filepath.Join(dir, "ca.pem"),
+		filepath.Join(dir, "coordinator.pem"), filepath.Join(dir, "coordinator.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig.Certificates = nil
+
+	c, err := NewCoordinator(&Config{Heartbeat: time.Hour, Nodes: []Node{{Address: addr}}}, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if h := c.Healthy(); len(h) != 0 {
+		t.Errorf("the worker accepted a coordinator without a client certificate")
+	}
+}
+
+func TestConfigFromOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    *Config
+	}{
+		{
+			name: "no workers",
+			want: nil,
+		},
+		{
+			name: "no nodes",
+			options: map[string]interface{}{
+				"workers": map[string]interface{}{"ca": "ca.pem"},
+			},
+			want: nil,
+		},
+		{
+			name: "defaults",
+			options: map[string]interface{}{
+				"workers": map[string]interface{}{
+					"nodes": []interface{}{
+						map[string]interface{}{"address": "10.0.0.1:4500"},
+					},
+				},
+			},
+			want: &Config{
+				Heartbeat:   DefaultHeartbeat,
+				MaxFailures: DefaultMaxFailures,
+				Nodes:       []Node{{Address: "10.0.0.1:4500"}},
+			},
+		},
+		{
+			name: "all options",
+			options: map[string]interface{}{
+				"workers": map[string]interface{}{
+					"ca":           "ca.pem",
+					"cert":         "coordinator.pem",
+					"key":          "coordinator.key",
+					"heartbeat":    30,
+					"max_failures": 5,
+					"nodes": []interface{}{
+						map[string]interface{}{"address": "10.0.0.1:4500", "qps": 500},
+						map[string]interface{}{"qps": 100},
+						map[string]interface{}{"address": "10.0.0.2:4500"},
+					},
+				},
+			},
+			want: &Config{
+				CA:          "ca.pem",
+				Cert:        "coordinator.pem",
+				Key:         "coordinator.key",
+				Heartbeat:   30 * time.Second,
+				MaxFailures: 5,
+				Nodes:       []Node{{Address: "10.0.0.1:4500", QPS: 500}, {Address: "10.0.0.2:4500"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		cfg := config.NewConfig()
+		cfg.Options = tt.options
+
+		got := ConfigFromOptions(cfg)
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", tt.want) {
+			t.Errorf("%s: got %+v, expected %+v", tt.name, got, tt.want)
+		}
+	}
+}
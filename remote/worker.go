@@ -0,0 +1,159 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/resolve"
+)
+
+// Querier is implemented by the resolver pools that perform the queries of a worker.
+type Querier interface {
+	Len() int
+	QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+}
+
+// Worker performs the resolution and sweep operations requested by a coordinator.
+type Worker struct {
+	pool    Querier
+	timeout time.Duration
+	pending int64
+}
+
+// NewWorker returns a Worker that performs the queries using the provided resolver pool.
+func NewWorker(pool Querier) *Worker {
+	return &Worker{
+		pool:    pool,
+		timeout: 30 * time.Second,
+	}
+}
+
+// Serve accepts the coordinator connections on the listener until the context is cancelled.
+// The listener is expected to enforce mutual TLS, such as one created with ServerTLSConfig.
+func (w *Worker) Serve(ctx context.Context, ln net.Listener) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(ServiceName, &workerService{w: w, ctx: ctx}); err != nil {
+		return err
+	}
+
+	var lock sync.Mutex
+	conns := make(map[net.Conn]struct{})
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+
+		lock.Lock()
+		defer lock.Unlock()
+		for conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			return err
+		}
+
+		lock.Lock()
+		conns[conn] = struct{}{}
+		lock.Unlock()
+		go func(conn net.Conn) {
+			srv.ServeConn(conn)
+
+			lock.Lock()
+			delete(conns, conn)
+			lock.Unlock()
+		}(conn)
+	}
+}
+
+// workerService holds the methods exposed to the coordinators.
+type workerService struct {
+	w   *Worker
+	ctx context.Context
+}
+
+// Resolve performs the DNS query and returns the response message.
+func (ws *workerService) Resolve(args ResolveArgs, reply *ResolveReply) error {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(args.Msg); err != nil {
+		return err
+	}
+	if len(msg.Question) == 0 {
+		return errors.New("the query has no question")
+	}
+
+	resp, err := ws.query(msg)
+	if err != nil {
+		return err
+	}
+
+	reply.Msg, err = resp.Pack()
+	return err
+}
+
+// Sweep performs the reverse DNS queries for the addresses and returns the PTR records found.
+func (ws *workerService) Sweep(args SweepArgs, reply *SweepReply) error {
+	for _, addr := range args.Addrs {
+		msg := resolve.ReverseMsg(addr)
+		if msg == nil {
+			continue
+		}
+
+		resp, err := ws.query(msg)
+		if err != nil || resp.Rcode != dns.RcodeSuccess {
+			continue
+		}
+
+		for _, a := range resolve.AnswersByType(resolve.ExtractAnswers(resp), dns.TypePTR) {
+			reply.Answers = append(reply.Answers, requests.DNSAnswer{
+				Name: strings.ToLower(resolve.RemoveLastDot(a.Name)),
+				Type: int(dns.TypePTR),
+				Data: strings.ToLower(resolve.RemoveLastDot(a.Data)),
+			})
+		}
+	}
+	return nil
+}
+
+// Heartbeat reports the capacity of the worker.
+func (ws *workerService) Heartbeat(args HeartbeatArgs, reply *HeartbeatReply) error {
+	reply.Resolvers = ws.w.pool.Len()
+	reply.Pending = atomic.LoadInt64(&ws.w.pending)
+	return nil
+}
+
+func (ws *workerService) query(msg *dns.Msg) (*dns.Msg, error) {
+	atomic.AddInt64(&ws.w.pending, 1)
+	defer atomic.AddInt64(&ws.w.pending, -1)
+
+	ctx, cancel := context.WithTimeout(ws.ctx, ws.w.timeout)
+	defer cancel()
+
+	resp, err := ws.w.pool.QueryBlocking(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Rcode == resolve.RcodeNoResponse {
+		return nil, errors.New("query failed")
+	}
+	return resp, nil
+}
@@ -0,0 +1,108 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package requests
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CancelReason names the reason an enumeration was cut short.
+type CancelReason string
+
+// The reasons an enumeration is cut short.
+const (
+	// CancelBudget is the reason when the duration, DNS query or byte budget was exhausted
+	CancelBudget CancelReason = "budget"
+	// CancelStopped is the reason when the operator or the caller stopped the enumeration
+	CancelStopped CancelReason = "operator-stop"
+	// CancelMemory is the reason when the memory consumption exceeded the hard limit
+	CancelMemory CancelReason = "memory-limit"
+)
+
+// CancelCause describes why the context of an enumeration was cancelled.
+type CancelCause struct {
+	Reason CancelReason `json:"reason"`
+	// Detail tells which budget or limit was reached, such as 'the byte budget of 1048576 bytes was exhausted'
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// NewCancelCause returns the cause with the reason and detail, recorded at the current time.
+func NewCancelCause(reason CancelReason, detail string) *CancelCause {
+	return &CancelCause{Reason: reason, Detail: detail, Time: time.Now()}
+}
+
+func (c *CancelCause) Error() string {
+	if c.Detail == "" {
+		return "the enumeration was cancelled: " + string(c.Reason)
+	}
+	return "the enumeration was cancelled: " + string(c.Reason) + ": " + c.Detail
+}
+
+// CursorSaver is implemented by data sources that keep a cursor, such as the position reached in a paginated API,
+// which they may persist before the enumeration stops. The cause is nil when the enumeration was not cut short.
+type CursorSaver interface {
+	SaveCursor(ctx context.Context, cause *CancelCause)
+}
+
+type causeKey struct{}
+
+// causeCtx is a cancellable context recording the cause of the first cancellation.
+type causeCtx struct {
+	context.Context
+	cancel context.CancelFunc
+	lock   sync.Mutex
+	cause  *CancelCause
+}
+
+func (c *causeCtx) Value(key interface{}) interface{} {
+	if key == (causeKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+func (c *causeCtx) cancelWith(cause *CancelCause) {
+	c.lock.Lock()
+	if c.cause == nil && c.Context.Err() == nil {
+		c.cause = cause
+	}
+	c.lock.Unlock()
+	c.cancel()
+}
+
+func (c *causeCtx) recorded() *CancelCause {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.cause
+}
+
+// WithCancelCause returns a copy of the parent that is cancelled by calling the returned function, which records
+// the cause of the first cancellation. A nil cause cancels the context without recording one, as the cleanup does.
+func WithCancelCause(parent context.Context) (context.Context, func(*CancelCause)) {
+	ctx, cancel := context.WithCancel(parent)
+	c := &causeCtx{Context: ctx, cancel: cancel}
+	return c, c.cancelWith
+}
+
+// CancelCauseFromContext returns the cause recorded by the innermost context carrying one, or nil when the
+// context was not cancelled through WithCancelCause, such as when its deadline passed.
+func CancelCauseFromContext(ctx context.Context) *CancelCause {
+	if ctx == nil {
+		return nil
+	}
+
+	c, _ := ctx.Value(causeKey{}).(*causeCtx)
+	for c != nil {
+		if cause := c.recorded(); cause != nil {
+			return cause
+		}
+		c, _ = c.Context.Value(causeKey{}).(*causeCtx)
+	}
+	return nil
+}
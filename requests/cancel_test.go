@@ -0,0 +1,56 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package requests
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWithCancelCause(t *testing.T) {
+	ctx, cancel := WithCancelCause(context.Background())
+	if CancelCauseFromContext(ctx) != nil {
+		t.Error("the running context returned a cause")
+	}
+
+	// The values of the parent remain reachable, and the first cause is kept
+	job := &Job{ID: "job"}
+	jctx := WithJob(ctx, job)
+	cancel(NewCancelCause(CancelBudget, "the DNS query budget of 10 queries was exhausted"))
+	cancel(NewCancelCause(CancelStopped, ""))
+	<-jctx.Done()
+	cause := CancelCauseFromContext(jctx)
+	if cause == nil || cause.Reason != CancelBudget || JobFromContext(jctx) != job {
+		t.Fatalf("the cancelled context returned the cause %+v", cause)
+	}
+	if !strings.Contains(cause.Error(), "budget: the DNS query budget") {
+		t.Errorf("the cause was described as %q", cause.Error())
+	}
+}
+
+func TestCancelCauseOfParent(t *testing.T) {
+	parent, stop := WithCancelCause(context.Background())
+	ctx, cancel := WithCancelCause(parent)
+	defer cancel(nil)
+
+	stop(NewCancelCause(CancelStopped, ""))
+	<-ctx.Done()
+	// The child cancelled after its parent does not replace the cause
+	cancel(NewCancelCause(CancelMemory, ""))
+	if cause := CancelCauseFromContext(ctx); cause == nil || cause.Reason != CancelStopped {
+		t.Errorf("the child of the stopped context returned the cause %+v", cause)
+	}
+
+	// The cleanup cancels without a cause
+	ctx, cancel = WithCancelCause(context.Background())
+	cancel(nil)
+	if ctx.Err() == nil || CancelCauseFromContext(ctx) != nil {
+		t.Error("the context cancelled without a cause returned one")
+	}
+	if CancelCauseFromContext(context.Background()) != nil {
+		t.Error("the background context returned a cause")
+	}
+}
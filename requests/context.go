@@ -0,0 +1,131 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package requests
+
+import (
+	"context"
+	"sync"
+
+	"github.com/owasp-amass/amass/v4/policy"
+	"github.com/owasp-amass/config/config"
+)
+
+// ContextAware is implemented by data sources that accept requests wrapped in a ContextRequest.
+type ContextAware interface {
+	SupportsContext() bool
+}
+
+// ContextRequest carries a request to a data source along with the context of the work it belongs to.
+// Data sources are expected to abandon the request once the context has been cancelled.
+type ContextRequest struct {
+	Ctx     context.Context
+	Request interface{}
+}
+
+// WithContext returns the request wrapped with ctx when the destination data source supports
+// contexts, and the original request otherwise, so older data sources continue to work unchanged.
+func WithContext(ctx context.Context, dest interface{}, req interface{}) interface{} {
+	if ca, ok := dest.(ContextAware); ok && ca.SupportsContext() && ctx != nil {
+		return &ContextRequest{
+			Ctx:     ctx,
+			Request: req,
+		}
+	}
+	return req
+}
+
+// UnwrapContext returns the context and request carried by a ContextRequest.
+// Requests that were not wrapped are returned with a background context.
+func UnwrapContext(req interface{}) (context.Context, interface{}) {
+	if cr, ok := req.(*ContextRequest); ok && cr != nil {
+		ctx := cr.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return ctx, cr.Request
+	}
+	return context.Background(), req
+}
+
+type jobKey struct{}
+
+// EvidenceRecorder stores the data source response fragments that yielded the names of a job.
+type EvidenceRecorder interface {
+	Add(name, source string, fragment []byte) (string, error)
+}
+
+// Job carries the scope and output channels of an enumeration through the data sources it shares with
+// other enumerations, so the findings of concurrent enumerations are never delivered to one another.
+type Job struct {
+	ID     string
+	Config *config.Config
+	// Evidence receives the response fragments that yielded the names when set
+	Evidence EvidenceRecorder
+	// Findings merges the details of the names provided by the data sources
+	Findings *FindingSet
+	// Policy blocks the active probes toward the never-touch list when set
+	Policy  *policy.Policy
+	lock    sync.RWMutex
+	outputs map[string]chan interface{}
+}
+
+// NewJob returns a Job with an output channel for each of the named data sources.
+func NewJob(id string, cfg *config.Config, sources []string) *Job {
+	job := &Job{
+		ID:       id,
+		Config:   cfg,
+		Findings: NewFindingSet(),
+		outputs:  make(map[string]chan interface{}, len(sources)),
+	}
+
+	for _, src := range sources {
+		job.outputs[src] = make(chan interface{}, 10)
+	}
+	return job
+}
+
+// Output returns the channel receiving the findings of the named data source for this job.
+func (j *Job) Output(source string) chan interface{} {
+	if j == nil {
+		return nil
+	}
+
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	return j.outputs[source]
+}
+
+// AddSource returns the output channel of a data source that joined the job after it was created.
+func (j *Job) AddSource(source string) chan interface{} {
+	if j == nil {
+		return nil
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	ch, found := j.outputs[source]
+	if !found {
+		ch = make(chan interface{}, 10)
+		j.outputs[source] = ch
+	}
+	return ch
+}
+
+// WithJob returns a context carrying the job of the enumeration the requests belong to.
+func WithJob(ctx context.Context, job *Job) context.Context {
+	return context.WithValue(ctx, jobKey{}, job)
+}
+
+// JobFromContext returns the job carried by ctx, or nil when the requests do not belong to one.
+func JobFromContext(ctx context.Context) *Job {
+	if ctx == nil {
+		return nil
+	}
+
+	job, _ := ctx.Value(jobKey{}).(*Job)
+	return job
+}
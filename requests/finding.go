@@ -0,0 +1,197 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package requests
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Finding is the common schema of what a data source learned about a name, so the fields that do not fit in
+// a DNSRequest, such as the dates a certificate log or passive DNS sensor first and last observed the name,
+// are kept rather than discarded.
+type Finding struct {
+	Name string `json:"name"`
+	// Addresses holds the addresses the data source observed the name resolving to
+	Addresses []string  `json:"addresses,omitempty"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+	// Ref is the raw reference to the record of the data source, such as a certificate log entry identifier
+	Ref string `json:"ref,omitempty"`
+	// Confidence is the likelihood, between 0 and 1, that the name is legitimate
+	Confidence float64 `json:"confidence,omitempty"`
+	// Sources holds the names of the data sources that provided the finding
+	Sources []string `json:"sources,omitempty"`
+	// Resolutions holds the period each address was observed for the name, when the data source provided it
+	Resolutions []Resolution `json:"resolutions,omitempty"`
+}
+
+// Resolution is an address the name was observed resolving to, between the first and last seen dates.
+type Resolution struct {
+	Address   string    `json:"ip"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+}
+
+// Clone returns a deep copy of the finding.
+func (f *Finding) Clone() *Finding {
+	c := *f
+	c.Addresses = append([]string(nil), f.Addresses...)
+	c.Sources = append([]string(nil), f.Sources...)
+	c.Resolutions = append([]Resolution(nil), f.Resolutions...)
+	return &c
+}
+
+// Merge combines the other finding for the same name into the receiver, keeping the earliest first seen
+// date, the latest last seen date, the union of the addresses and sources, and the highest confidence.
+// The periods observed for each address are widened the same way.
+func (f *Finding) Merge(other *Finding) {
+	if other == nil {
+		return
+	}
+
+	if !other.FirstSeen.IsZero() && (f.FirstSeen.IsZero() || other.FirstSeen.Before(f.FirstSeen)) {
+		f.FirstSeen = other.FirstSeen
+	}
+	if other.LastSeen.After(f.LastSeen) {
+		f.LastSeen = other.LastSeen
+	}
+	if other.Confidence > f.Confidence {
+		f.Confidence = other.Confidence
+	}
+	if f.Ref == "" {
+		f.Ref = other.Ref
+	}
+	f.Addresses = union(f.Addresses, other.Addresses)
+	f.Sources = union(f.Sources, other.Sources)
+	f.Resolutions = mergeResolutions(f.Resolutions, other.Resolutions)
+}
+
+// mergeResolutions combines the periods observed for each address, sorted by the address.
+func mergeResolutions(a, b []Resolution) []Resolution {
+	if len(b) == 0 {
+		return a
+	}
+
+	byAddr := make(map[string]*Resolution, len(a)+len(b))
+	for _, r := range append(append([]Resolution(nil), a...), b...) {
+		cur, found := byAddr[r.Address]
+		if !found {
+			c := r
+			byAddr[r.Address] = &c
+			continue
+		}
+		if !r.FirstSeen.IsZero() && (cur.FirstSeen.IsZero() || r.FirstSeen.Before(cur.FirstSeen)) {
+			cur.FirstSeen = r.FirstSeen
+		}
+		if r.LastSeen.After(cur.LastSeen) {
+			cur.LastSeen = r.LastSeen
+		}
+	}
+
+	list := make([]Resolution, 0, len(byAddr))
+	for _, r := range byAddr {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list
+}
+
+func union(a, b []string) []string {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for _, s := range append(append([]string(nil), a...), b...) {
+		if s != "" {
+			set[s] = struct{}{}
+		}
+	}
+
+	list := make([]string, 0, len(set))
+	for s := range set {
+		list = append(list, s)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// FindingSet merges the findings provided for each name by the data sources of an enumeration.
+type FindingSet struct {
+	sync.Mutex
+	findings map[string]*Finding
+}
+
+// NewFindingSet returns an empty FindingSet.
+func NewFindingSet() *FindingSet {
+	return &FindingSet{findings: make(map[string]*Finding)}
+}
+
+// Add merges the finding into the one kept for its name. The addresses that cannot be parsed are dropped,
+// and the dates are kept in UTC.
+func (fs *FindingSet) Add(f *Finding) {
+	if fs == nil || f == nil {
+		return
+	}
+
+	name := strings.ToLower(strings.Trim(strings.TrimSpace(f.Name), "."))
+	if name == "" {
+		return
+	}
+
+	c := f.Clone()
+	c.Name = name
+	c.Addresses = nil
+	for _, addr := range f.Addresses {
+		if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+			c.Addresses = append(c.Addresses, ip.String())
+		}
+	}
+	// The addresses observed with a period are also among the addresses of the finding
+	c.Resolutions = nil
+	for _, r := range f.Resolutions {
+		if ip := net.ParseIP(strings.TrimSpace(r.Address)); ip != nil {
+			r.Address = ip.String()
+			r.FirstSeen, r.LastSeen = utc(r.FirstSeen), utc(r.LastSeen)
+			c.Resolutions = append(c.Resolutions, r)
+			c.Addresses = append(c.Addresses, r.Address)
+		}
+	}
+	c.FirstSeen, c.LastSeen = utc(c.FirstSeen), utc(c.LastSeen)
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if cur, found := fs.findings[name]; found {
+		cur.Merge(c)
+		return
+	}
+	c.Addresses = union(c.Addresses, nil)
+	c.Sources = union(c.Sources, nil)
+	c.Resolutions = mergeResolutions(nil, c.Resolutions)
+	fs.findings[name] = c
+}
+
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC()
+}
+
+// Get returns a copy of the merged finding of the name, or nil when no data source provided one.
+func (fs *FindingSet) Get(name string) *Finding {
+	if fs == nil {
+		return nil
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if f, found := fs.findings[strings.ToLower(strings.Trim(name, "."))]; found {
+		return f.Clone()
+	}
+	return nil
+}
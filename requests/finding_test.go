@@ -0,0 +1,59 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package requests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindingSetMerge(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	fs := NewFindingSet()
+	fs.Add(&Finding{
+		Name:      "WWW.Example.com.",
+		Addresses: []string{"192.0.2.1", "not an address"},
+		FirstSeen: day(10),
+		LastSeen:  day(20),
+		Ref:       "crt.sh:1",
+		Sources:   []string{"Crtsh"},
+	})
+	fs.Add(&Finding{
+		Name:       "www.example.com",
+		Addresses:  []string{"2001:DB8::1", "192.0.2.1"},
+		FirstSeen:  day(5).In(time.FixedZone("EST", -5*3600)),
+		Confidence: 0.8,
+		Sources:    []string{"CIRCL"},
+	})
+	fs.Add(&Finding{Name: "www.example.com", LastSeen: day(25), Ref: "circl", Sources: []string{"CIRCL"}})
+
+	f := fs.Get("www.example.com")
+	if f == nil {
+		t.Fatal("the finding was not kept")
+	}
+	if !f.FirstSeen.Equal(day(5)) || f.FirstSeen.Location() != time.UTC || !f.LastSeen.Equal(day(25)) {
+		t.Errorf("the finding was seen from %s to %s", f.FirstSeen, f.LastSeen)
+	}
+	if want := []string{"192.0.2.1", "2001:db8::1"}; !reflect.DeepEqual(f.Addresses, want) {
+		t.Errorf("the finding has the addresses %v, expected %v", f.Addresses, want)
+	}
+	if want := []string{"CIRCL", "Crtsh"}; !reflect.DeepEqual(f.Sources, want) {
+		t.Errorf("the finding has the sources %v, expected %v", f.Sources, want)
+	}
+	if f.Ref != "crt.sh:1" || f.Confidence != 0.8 {
+		t.Errorf("the finding has the reference %q and confidence %v", f.Ref, f.Confidence)
+	}
+
+	// The copies returned do not share the merged finding
+	f.Addresses[0] = "198.51.100.1"
+	if g := fs.Get("www.example.com"); g.Addresses[0] != "192.0.2.1" {
+		t.Error("modifying the returned finding changed the merged finding")
+	}
+	if fs.Get("mail.example.com") != nil {
+		t.Error("a name without findings returned one")
+	}
+}
@@ -27,12 +27,36 @@ const (
 	OutputTopic        = "amass:output"
 )
 
+// Derivation types recorded for the names discovered during an enumeration.
+const (
+	DerivedFromSeed       = "seed"
+	DerivedFromProvided   = "provided"
+	DerivedFromImport     = "import"
+	DerivedFromGraph      = "graph"
+	DerivedFromSource     = "source"
+	DerivedFromA          = "a_record"
+	DerivedFromAAAA       = "aaaa_record"
+	DerivedFromCNAME      = "cname"
+	DerivedFromPTR        = "ptr"
+	DerivedFromSRV        = "srv"
+	DerivedFromNS         = "ns"
+	DerivedFromMX         = "mx"
+	DerivedFromTXT        = "txt"
+	DerivedFromSOA        = "soa"
+	DerivedFromCert       = "cert"
+	DerivedFromAlteration = "alteration"
+	DerivedFromBrute      = "brute_force"
+	DerivedFromJSFile     = "js_file"
+)
+
 // DNSAnswer is the type used by Amass to represent a DNS record.
 type DNSAnswer struct {
 	Name string `json:"name"`
 	Type int    `json:"type"`
 	TTL  int    `json:"TTL"`
 	Data string `json:"data"`
+	// Authoritative is the AA bit of the response, set when an authoritative server of the zone answered
+	Authoritative bool `json:"authoritative,omitempty"`
 }
 
 // DNSRequest handles data needed throughout Service processing of a DNS name.
@@ -40,14 +64,20 @@ type DNSRequest struct {
 	Name    string
 	Domain  string
 	Records []DNSAnswer
+	// Parent is the finding or data source the name was derived from
+	Parent string
+	// Derivation is one of the DerivedFrom types
+	Derivation string
 }
 
 // Clone implements pipeline Data.
 func (d *DNSRequest) Clone() pipeline.Data {
 	return &DNSRequest{
-		Name:    d.Name,
-		Domain:  d.Domain,
-		Records: append([]DNSAnswer(nil), d.Records...),
+		Name:       d.Name,
+		Domain:     d.Domain,
+		Records:    append([]DNSAnswer(nil), d.Records...),
+		Parent:     d.Parent,
+		Derivation: d.Derivation,
 	}
 }
 
@@ -245,18 +275,59 @@ type WhoisRequest struct {
 
 // Output contains all the output data for an enumerated DNS name.
 type Output struct {
-	Name      string        `json:"name"`
-	Domain    string        `json:"domain"`
-	Addresses []AddressInfo `json:"addresses"`
+	Name        string        `json:"name"`
+	DisplayName string        `json:"display_name,omitempty"`
+	Domain      string        `json:"domain"`
+	Addresses   []AddressInfo `json:"addresses"`
+	Parent      string        `json:"parent,omitempty"`
+	Derivation  string        `json:"derivation,omitempty"`
+	Evidence    []string      `json:"evidence,omitempty"`
+	// FirstSeen and LastSeen are the earliest and latest dates the data sources observed the name
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	// Historical holds the addresses the passive DNS sensors observed for the name, which it did not
+	// resolve to during the enumeration
+	Historical []Resolution `json:"historical_addresses,omitempty"`
+	// Provider and Service are where the name is hosted, classified by its CNAME target and addresses
+	Provider string `json:"provider,omitempty"`
+	Service  string `json:"service,omitempty"`
+	// Confidence is the likelihood, between 0 and 1, that the name is legitimate, scored from its sources
+	Confidence float64  `json:"confidence,omitempty"`
+	Sources    []string `json:"sources,omitempty"`
+	// Update marks the record carrying the confidence raised by sources that corroborated the name after it was sent
+	Update bool `json:"update,omitempty"`
+	// CarriedForward marks the name copied unchanged from the baseline event of a delta enumeration
+	CarriedForward bool `json:"carried_forward,omitempty"`
 }
 
 // Clone implements pipeline Data.
 func (o *Output) Clone() pipeline.Data {
 	return &Output{
-		Name:      o.Name,
-		Domain:    o.Domain,
-		Addresses: append([]AddressInfo(nil), o.Addresses...),
+		Name:           o.Name,
+		DisplayName:    o.DisplayName,
+		Domain:         o.Domain,
+		Addresses:      append([]AddressInfo(nil), o.Addresses...),
+		Parent:         o.Parent,
+		Derivation:     o.Derivation,
+		Evidence:       append([]string(nil), o.Evidence...),
+		FirstSeen:      cloneTime(o.FirstSeen),
+		LastSeen:       cloneTime(o.LastSeen),
+		Historical:     append([]Resolution(nil), o.Historical...),
+		Provider:       o.Provider,
+		Service:        o.Service,
+		Confidence:     o.Confidence,
+		Sources:        append([]string(nil), o.Sources...),
+		Update:         o.Update,
+		CarriedForward: o.CarriedForward,
+	}
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
 	}
+	c := *t
+	return &c
 }
 
 // MarkAsProcessed implements pipeline Data.
@@ -286,9 +357,15 @@ type AddressInfo struct {
 	CIDRStr     string     `json:"cidr"`
 	ASN         int        `json:"asn"`
 	Description string     `json:"desc"`
+	// Country and Region are the geolocation of the address when the enrichment is enabled
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	// Authoritative marks the address answered by an authoritative server of the zone
+	Authoritative bool `json:"authoritative,omitempty"`
 }
 
 // SanitizeDNSRequest cleans the Name and Domain elements of the receiver.
+// The Name is set to the empty string when either element is not a valid internationalized name.
 func SanitizeDNSRequest(req *DNSRequest) {
 	req.Name = strings.ToLower(req.Name)
 	req.Name = strings.TrimSpace(req.Name)
@@ -298,4 +375,15 @@ func SanitizeDNSRequest(req *DNSRequest) {
 	req.Domain = strings.ToLower(req.Domain)
 	req.Domain = strings.TrimSpace(req.Domain)
 	req.Domain = strings.Trim(req.Domain, ".")
+	// Internationalized names are resolved and stored in their punycode form
+	name, err := amassdns.NormalizeName(req.Name)
+	if err != nil {
+		name = ""
+	}
+	domain, err := amassdns.NormalizeName(req.Domain)
+	if err != nil {
+		name = ""
+	}
+	req.Name = name
+	req.Domain = domain
 }
@@ -325,6 +325,42 @@ func TestSanitizeDNSRequest(t *testing.T) {
 			require.Equal(t, "example.com", test.req.Domain)
 		})
 	}
+}
+
+func TestSanitizeDNSRequestIDN(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		req        DNSRequest
+		wantName   string
+		wantDomain string
+	}{
+		{
+			name:       "Unicode name",
+			req:        DNSRequest{Name: "Bücher.Example.com", Domain: "example.com"},
+			wantName:   "xn--bcher-kva.example.com",
+			wantDomain: "example.com",
+		},
+		{
+			name:       "Unicode domain",
+			req:        DNSRequest{Name: "www.bücher.de", Domain: "bücher.de"},
+			wantName:   "www.xn--bcher-kva.de",
+			wantDomain: "xn--bcher-kva.de",
+		},
+		{
+			name:       "Invalid punycode",
+			req:        DNSRequest{Name: "xn--zz.example.com", Domain: "example.com"},
+			wantName:   "",
+			wantDomain: "example.com",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SanitizeDNSRequest(&test.req)
+			require.Equal(t, test.wantName, test.req.Name)
+			require.Equal(t, test.wantDomain, test.req.Domain)
+		})
+	}
 
 }
 
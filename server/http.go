@@ -0,0 +1,203 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+)
+
+// APIPrefix is the path all the API endpoints are served under.
+const APIPrefix = "/v1/sessions"
+
+// The paths of the health endpoints, which are served without the token when enabled.
+const (
+	// HealthPath answers with 200 while the server is live, and 503 once it should be restarted
+	HealthPath = "/healthz"
+	// ReadyPath answers with 200 while the server is ready to run enumerations, and 503 otherwise
+	ReadyPath = "/readyz"
+)
+
+// maxRequestSize limits the size of the job requests read from the clients.
+const maxRequestSize = 1 << 20
+
+// Handler returns the HTTP handler serving the API:
+//
+//	POST   /v1/sessions                 starts an enumeration
+//	GET    /v1/sessions                 lists the sessions
+//	GET    /v1/sessions/{id}            returns the progress of a session
+//	POST   /v1/sessions/{id}/stop       stops a session
+//	GET    /v1/sessions/{id}/findings   streams the findings as newline delimited JSON
+//	GET    /v1/sessions/{id}/snapshot   returns the configuration snapshot of a session
+//
+// The orchestrators probe the health endpoints without the token, when they have been enabled:
+//
+//	GET    /healthz                     returns the health, with 503 once the server is unhealthy or stopped
+//	GET    /readyz                      returns the health, with 503 until the server is ready
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPrefix, s.handleSessions)
+	mux.HandleFunc(APIPrefix+"/", s.handleSession)
+
+	api := s.authenticate(mux)
+	if !s.cfg.Health {
+		return api
+	}
+
+	root := http.NewServeMux()
+	root.HandleFunc(HealthPath, s.handleHealth(systems.Health.Live))
+	root.HandleFunc(ReadyPath, s.handleHealth(systems.Health.Ready))
+	root.Handle("/", api)
+	return root
+}
+
+// handleHealth answers with the health of the server, and with the 503 status code when the condition fails.
+func (s *Server) handleHealth(cond func(systems.Health) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("the method is not allowed"))
+			return
+		}
+
+		h := s.Health()
+		code := http.StatusOK
+		if !cond(h) {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, h)
+	}
+}
+
+// authenticate rejects the requests that do not present the static token as a bearer token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+
+		if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("a valid token is required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.ListSessions())
+	case http.MethodPost:
+		var req JobRequest
+
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		session, err := s.StartEnumeration(req)
+		if err != nil {
+			writeError(w, statusCode(err, http.StatusBadRequest), err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, session)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("the method is not allowed"))
+	}
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		session, err := s.GetProgress(id)
+		if err != nil {
+			writeError(w, statusCode(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, session)
+	case len(parts) == 2 && parts[1] == "stop" && r.Method == http.MethodPost:
+		session, err := s.StopEnumeration(id)
+		if err != nil {
+			writeError(w, statusCode(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, session)
+	case len(parts) == 2 && parts[1] == "findings" && r.Method == http.MethodGet:
+		s.streamFindings(w, r, id)
+	case len(parts) == 2 && parts[1] == "snapshot" && r.Method == http.MethodGet:
+		snap, err := s.GetSnapshot(id)
+		if err != nil {
+			writeError(w, statusCode(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, snap)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("the endpoint does not exist"))
+	}
+}
+
+func (s *Server) streamFindings(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.GetProgress(id); err != nil {
+		writeError(w, statusCode(err, http.StatusInternalServerError), err)
+		return
+	}
+
+	enc, err := schema.NewEncoder(w, s.schema)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	_ = s.StreamFindings(r.Context(), id, func(o *requests.Output) error {
+		if err := enc.Encode(o); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+func statusCode(err error, def int) int {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrNoSnapshot):
+		return http.StatusNotFound
+	case errors.Is(err, ErrFinished):
+		return http.StatusConflict
+	case errors.Is(err, ErrClosed):
+		return http.StatusServiceUnavailable
+	}
+	return def
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,598 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package server runs enumerations submitted as jobs over an HTTP API, so the scanner can operate as a daemon.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/owasp-amass/amass/v4/blacklist"
+	"github.com/owasp-amass/amass/v4/evidence"
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/resources"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/amass/v4/wordlists"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultMaxConcurrent is the number of enumerations that run at once when the cap is not configured.
+const DefaultMaxConcurrent = 2
+
+var (
+	// ErrNotFound is returned for session identifiers that are not known by the server
+	ErrNotFound = errors.New("the session does not exist")
+	// ErrFinished is returned when stopping a session that is no longer queued or running
+	ErrFinished = errors.New("the session has already finished")
+	// ErrClosed is returned when submitting jobs after the server was closed
+	ErrClosed = errors.New("the server has been closed")
+	// ErrNoSnapshot is returned for the sessions without a configuration snapshot, such as the queued sessions
+	ErrNoSnapshot = errors.New("the session has no configuration snapshot")
+)
+
+// State is the stage of its life cycle a session is in.
+type State string
+
+// The states of a session.
+const (
+	StateQueued   State = "queued"
+	StateRunning  State = "running"
+	StateFinished State = "finished"
+	StateStopped  State = "stopped"
+	StateFailed   State = "failed"
+)
+
+// Done returns true when the session will not find any more names.
+func (s State) Done() bool {
+	return s == StateFinished || s == StateStopped || s == StateFailed
+}
+
+// Config holds the 'server' configuration options.
+type Config struct {
+	Listen string
+	// Token is the static token the API clients present as a bearer token
+	Token string
+	// MaxConcurrent is the number of enumerations that run at once, while the other jobs are queued
+	MaxConcurrent int
+	// Shared runs the enumerations on a single System instead of one System per job
+	Shared bool
+	// Health serves the liveness and readiness endpoints on the listener of the API, without the token
+	Health bool
+}
+
+// ConfigFromOptions parses the 'server' configuration options.
+func ConfigFromOptions(cfg *config.Config) *Config {
+	c := &Config{MaxConcurrent: DefaultMaxConcurrent}
+	if cfg == nil || cfg.Options == nil {
+		return c
+	}
+
+	opts, ok := cfg.Options["server"].(map[string]interface{})
+	if !ok {
+		return c
+	}
+
+	c.Listen, _ = opts["listen"].(string)
+	c.Token, _ = opts["token"].(string)
+	c.Shared, _ = opts["shared"].(bool)
+	c.Health, _ = opts["health"].(bool)
+	if n := options.Int(opts["max_concurrent"]); n > 0 {
+		c.MaxConcurrent = n
+	}
+	return c
+}
+
+// JobRequest is the subset of the configuration an API client provides for an enumeration.
+type JobRequest struct {
+	Domains     []string `json:"domains"`
+	Active      bool     `json:"active,omitempty"`
+	Passive     bool     `json:"passive,omitempty"`
+	BruteForce  bool     `json:"brute_force,omitempty"`
+	Alterations bool     `json:"alterations,omitempty"`
+	Blacklist   []string `json:"blacklist,omitempty"`
+	// Timeout is the number of minutes the enumeration is allowed to run
+	Timeout int `json:"timeout,omitempty"`
+	// DNSQueries is the maximum number of DNS queries the enumeration will send
+	DNSQueries int64 `json:"dns_queries,omitempty"`
+	// Bytes is the maximum number of bytes the enumeration will send and receive over DNS and HTTP
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+// Session describes a job submitted to the server and its progress.
+type Session struct {
+	ID       string     `json:"id"`
+	Request  JobRequest `json:"request"`
+	State    State      `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  time.Time  `json:"started"`
+	Finished time.Time  `json:"finished"`
+	// Findings is the number of names found so far
+	Findings int `json:"findings"`
+	// Sources is the startup progress of the data sources while the session is running
+	Sources *systems.StartupProgress `json:"sources,omitempty"`
+	// Descriptors is the file descriptor usage of the System while the session is running
+	Descriptors *systems.FDUsage `json:"descriptors,omitempty"`
+	// Snapshot identifies the configuration snapshot recorded when the enumeration started
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// runFunc performs the enumeration described by the configuration, sending the findings on the channel.
+type runFunc func(ctx context.Context, cfg *config.Config, req JobRequest, out chan *requests.Output) error
+
+type job struct {
+	sync.Mutex
+	info     Session
+	cfg      *config.Config
+	findings []*requests.Output
+	notify   chan struct{}
+	cancel   context.CancelFunc
+	stopped  bool
+}
+
+func (j *job) session() Session {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.info
+}
+
+// signal wakes up the streams waiting for the job and must be called while holding the lock.
+func (j *job) signal() {
+	close(j.notify)
+	j.notify = make(chan struct{})
+}
+
+// Server manages the enumeration jobs submitted through the API.
+type Server struct {
+	sync.Mutex
+	cfg      *Config
+	base     *config.Config
+	store    *sessionStore
+	evidence *evidence.Store
+	snaps    *snapshot.Store
+	systems  *systemPool
+	run      runFunc
+	// schema is the version of the records streamed to the API clients
+	schema  int
+	jobs    map[string]*job
+	queue   []*job
+	running int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewServer returns a Server running the jobs with the settings of the base configuration.
+// The sessions of previous runs are loaded from the output directory.
+func NewServer(cfg *Config, base *config.Config) (*Server, error) {
+	if cfg == nil {
+		return nil, errors.New("the server configuration was not provided")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("a token is required to authenticate the API clients")
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = DefaultMaxConcurrent
+	}
+
+	version, err := schema.VersionFromConfig(base)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := newSessionStore(base)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		base:    base,
+		store:   store,
+		systems: newSystemPool(base, cfg.Shared),
+		schema:  version,
+		jobs:    make(map[string]*job),
+	}
+	// The enumerations share the evidence store kept in the output directory
+	if ecfg := evidence.ConfigFromOptions(base); ecfg != nil {
+		dir := filepath.Join(config.OutputDirectory(base.Dir), evidence.DirName)
+		if s.evidence, err = evidence.Open(dir, ecfg.MaxSize); err != nil {
+			return nil, err
+		}
+	}
+	// The configuration of each enumeration is recorded beside the results
+	if base != nil {
+		if dir := config.OutputDirectory(base.Dir); dir != "" {
+			if s.snaps, err = snapshot.Open(filepath.Join(dir, snapshot.DirName)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.run = s.enumerate
+	// The shared System is built ahead of the first job, so the daemon becomes ready once it has warmed up
+	if cfg.Health && cfg.Shared {
+		go s.systems.warm()
+	}
+
+	for _, info := range store.load() {
+		// The enumerations that were interrupted by a restart cannot be resumed
+		if !info.State.Done() {
+			info.State = StateFailed
+			info.Error = "the server stopped before the enumeration finished"
+		}
+		s.jobs[info.ID] = &job{info: info, notify: make(chan struct{})}
+	}
+	s.persist()
+	return s, nil
+}
+
+// Health returns the health of the Systems running the jobs, which is stopped once the server has been closed.
+func (s *Server) Health() systems.Health {
+	s.Lock()
+	closed := s.closed
+	s.Unlock()
+
+	if closed {
+		return systems.Health{State: systems.HealthStopped, Cause: "the server has been closed"}
+	}
+	return s.systems.health()
+}
+
+// Close stops the running enumerations and waits for them to finish.
+func (s *Server) Close() {
+	s.Lock()
+	s.closed = true
+	for _, j := range s.queue {
+		s.finishQueued(j)
+	}
+	s.queue = nil
+	for _, j := range s.jobs {
+		j.Lock()
+		if j.cancel != nil {
+			j.stopped = true
+			j.cancel()
+		}
+		j.Unlock()
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+	s.systems.close()
+	s.persist()
+	if s.evidence != nil {
+		_ = s.evidence.Close()
+	}
+}
+
+// StartEnumeration submits the job, which is queued when the concurrency cap has been reached.
+func (s *Server) StartEnumeration(req JobRequest) (Session, error) {
+	cfg, err := s.jobConfig(req)
+	if err != nil {
+		return Session{}, err
+	}
+
+	j := &job{
+		info: Session{
+			ID:       uuid.New().String(),
+			Request:  req,
+			State:    StateQueued,
+			Created:  time.Now(),
+			Snapshot: snapshot.ID(cfg.Domains(), cfg.CollectionStartTime),
+		},
+		cfg:    cfg,
+		notify: make(chan struct{}),
+	}
+
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return Session{}, ErrClosed
+	}
+	s.jobs[j.info.ID] = j
+	s.queue = append(s.queue, j)
+	s.schedule()
+	s.Unlock()
+
+	s.persist()
+	return j.session(), nil
+}
+
+// GetProgress returns the current state of the session.
+func (s *Server) GetProgress(id string) (Session, error) {
+	j, err := s.job(id)
+	if err != nil {
+		return Session{}, err
+	}
+
+	session := j.session()
+	if session.State == StateRunning {
+		if p, ok := s.systems.progress(j.cfg); ok {
+			session.Sources = &p
+		}
+		if u, ok := s.systems.descriptors(j.cfg); ok {
+			session.Descriptors = &u
+		}
+	}
+	return session, nil
+}
+
+// GetSnapshot returns the configuration snapshot of the session, which is recorded once the enumeration starts.
+func (s *Server) GetSnapshot(id string) (*snapshot.Snapshot, error) {
+	j, err := s.job(id)
+	if err != nil {
+		return nil, err
+	}
+
+	session := j.session()
+	if s.snaps == nil || session.Snapshot == "" {
+		return nil, ErrNoSnapshot
+	}
+
+	snap, err := s.snaps.Load(session.Snapshot)
+	if errors.Is(err, snapshot.ErrNotFound) {
+		return nil, ErrNoSnapshot
+	}
+	return snap, err
+}
+
+// ListSessions returns the sessions known by the server, ordered by their creation time.
+func (s *Server) ListSessions() []Session {
+	s.Lock()
+	var sessions []Session
+	for _, j := range s.jobs {
+		sessions = append(sessions, j.session())
+	}
+	s.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Created.Equal(sessions[j].Created) {
+			return sessions[i].ID < sessions[j].ID
+		}
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+	return sessions
+}
+
+// StopEnumeration cancels a running session or removes a queued session from the queue.
+func (s *Server) StopEnumeration(id string) (Session, error) {
+	j, err := s.job(id)
+	if err != nil {
+		return Session{}, err
+	}
+
+	s.Lock()
+	for i, q := range s.queue {
+		if q == j {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.finishQueued(j)
+			s.Unlock()
+			s.persist()
+			return j.session(), nil
+		}
+	}
+	s.Unlock()
+
+	j.Lock()
+	defer j.Unlock()
+	if j.info.State.Done() || j.cancel == nil {
+		return j.info, ErrFinished
+	}
+	j.stopped = true
+	j.cancel()
+	return j.info, nil
+}
+
+// StreamFindings calls fn with each finding of the session, starting with the first, until the
+// session is done or the context expires.
+func (s *Server) StreamFindings(ctx context.Context, id string, fn func(*requests.Output) error) error {
+	j, err := s.job(id)
+	if err != nil {
+		return err
+	}
+
+	for next := 0; ; {
+		j.Lock()
+		findings := j.findings[next:]
+		done := j.info.State.Done()
+		notify := j.notify
+		j.Unlock()
+
+		for _, o := range findings {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+		next += len(findings)
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+func (s *Server) job(id string) (*job, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if j, found := s.jobs[id]; found {
+		return j, nil
+	}
+	return nil, ErrNotFound
+}
+
+// schedule starts the queued jobs allowed by the concurrency cap and must be called while holding the lock.
+func (s *Server) schedule() {
+	for len(s.queue) > 0 && s.running < s.cfg.MaxConcurrent {
+		j := s.queue[0]
+		s.queue = s.queue[1:]
+		s.running++
+
+		ctx, cancel := context.WithCancel(context.Background())
+		j.Lock()
+		j.cancel = cancel
+		j.info.State = StateRunning
+		j.info.Started = time.Now()
+		j.signal()
+		j.Unlock()
+
+		s.wg.Add(1)
+		go s.runJob(ctx, j)
+	}
+}
+
+// finishQueued marks a job that never ran as stopped and must be called while holding the lock.
+func (s *Server) finishQueued(j *job) {
+	j.Lock()
+	defer j.Unlock()
+
+	j.info.State = StateStopped
+	j.info.Finished = time.Now()
+	j.signal()
+}
+
+func (s *Server) runJob(ctx context.Context, j *job) {
+	defer s.wg.Done()
+
+	out := make(chan *requests.Output, 100)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+
+		for o := range out {
+			j.Lock()
+			j.findings = append(j.findings, o)
+			// The update records raise the confidence in the names already counted
+			if !o.Update {
+				j.info.Findings++
+			}
+			j.signal()
+			j.Unlock()
+		}
+	}()
+
+	s.persist()
+	err := s.run(ctx, j.cfg, j.info.Request, out)
+	close(out)
+	<-collected
+
+	j.Lock()
+	j.cancel()
+	j.info.Finished = time.Now()
+	switch {
+	case j.stopped:
+		j.info.State = StateStopped
+	case err != nil:
+		j.info.State = StateFailed
+		j.info.Error = err.Error()
+	default:
+		j.info.State = StateFinished
+	}
+	j.signal()
+	j.Unlock()
+
+	s.Lock()
+	s.running--
+	if !s.closed {
+		s.schedule()
+	}
+	s.Unlock()
+	s.persist()
+}
+
+// jobConfig returns the configuration of an enumeration, built from the base configuration and the job request.
+func (s *Server) jobConfig(req JobRequest) (*config.Config, error) {
+	if len(req.Domains) == 0 {
+		return nil, errors.New("the job does not provide any domain names")
+	}
+	if req.Active && req.Passive {
+		return nil, errors.New("the active and passive modes are mutually exclusive")
+	}
+
+	cfg := config.NewConfig()
+	if b := s.base; b != nil {
+		cfg.Log = b.Log
+		cfg.Dir = b.Dir
+		cfg.Options = b.Options
+		cfg.GraphDBs = b.GraphDBs
+		cfg.DataSrcConfigs = b.DataSrcConfigs
+		cfg.SourceFilter = b.SourceFilter
+		cfg.Resolvers = b.Resolvers
+		cfg.ResolversQPS = b.ResolversQPS
+		cfg.TrustedResolvers = b.TrustedResolvers
+		cfg.TrustedQPS = b.TrustedQPS
+		cfg.MaxDNSQueries = b.MaxDNSQueries
+		cfg.RecordTypes = b.RecordTypes
+		cfg.Wordlist = b.Wordlist
+		cfg.AltWordlist = b.AltWordlist
+	}
+
+	for _, d := range req.Domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d == "" {
+			return nil, fmt.Errorf("the job provides an empty domain name")
+		}
+		cfg.AddDomain(d)
+	}
+	if _, err := blacklist.Compile(req.Blacklist); err != nil {
+		return nil, err
+	}
+	for _, name := range req.Blacklist {
+		cfg.BlacklistSubdomain(name)
+	}
+	cfg.Active = req.Active
+	cfg.Passive = req.Passive
+	cfg.BruteForcing = req.BruteForce
+	cfg.Alterations = req.Alterations
+	// The jobs use the embedded wordlists unless the base configuration provides them
+	if cfg.BruteForcing && len(cfg.Wordlist) == 0 {
+		cfg.Wordlist = resourceWords("namelist.txt")
+	}
+	if cfg.Alterations && len(cfg.AltWordlist) == 0 {
+		cfg.AltWordlist = resourceWords("alterations.txt")
+	}
+	return cfg, nil
+}
+
+func resourceWords(name string) []string {
+	f, err := resources.GetResourceFile(name)
+	if err != nil {
+		return nil
+	}
+
+	list, err := wordlists.Read(f)
+	if err != nil {
+		return nil
+	}
+	return list.Labels()
+}
+
+func (s *Server) persist() {
+	var sessions []Session
+
+	s.Lock()
+	for _, j := range s.jobs {
+		sessions = append(sessions, j.session())
+	}
+	s.Unlock()
+
+	if err := s.store.save(sessions); err != nil && s.base != nil && s.base.Log != nil {
+		s.base.Log.Printf("Failed to save the sessions: %v", err)
+	}
+}
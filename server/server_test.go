@@ -0,0 +1,461 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/format/schema"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// fakeRunner sends a finding for each domain of the job, then blocks until released or cancelled.
+type fakeRunner struct {
+	started chan string
+	release chan struct{}
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{
+		started: make(chan string, 10),
+		release: make(chan struct{}),
+	}
+}
+
+func (fr *fakeRunner) run(ctx context.Context, cfg *config.Config, req JobRequest, out chan *requests.Output) error {
+	fr.started <- cfg.Domains()[0]
+
+	for _, d := range cfg.Domains() {
+		out <- &requests.Output{Name: "www." + d, Domain: d}
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-fr.release:
+	}
+	return nil
+}
+
+func newTestServer(t *testing.T, dir string, max int) (*Server, *fakeRunner) {
+	base := config.NewConfig()
+	base.Dir = dir
+
+	s, err := NewServer(&Config{Token: "secret", MaxConcurrent: max}, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr := newFakeRunner()
+	s.run = fr.run
+	return s, fr
+}
+
+func waitForState(t *testing.T, s *Server, id string, state State) Session {
+	for i := 0; i < 200; i++ {
+		if session, err := s.GetProgress(id); err == nil && session.State == state {
+			return session
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	session, _ := s.GetProgress(id)
+	t.Fatalf("the session %s is %s, expected %s", id, session.State, state)
+	return session
+}
+
+func TestQueueing(t *testing.T) {
+	s, fr := newTestServer(t, t.TempDir(), 1)
+	defer s.Close()
+
+	first, err := s.StartEnumeration(JobRequest{Domains: []string{"owasp.org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.StartEnumeration(JobRequest{Domains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := s.StartEnumeration(JobRequest{Domains: []string{"example.net"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := <-fr.started; d != "owasp.org" {
+		t.Fatalf("the job for %s started first", d)
+	}
+	waitForState(t, s, first.ID, StateRunning)
+	// The jobs submitted at the concurrency cap wait in the queue
+	if session, _ := s.GetProgress(second.ID); session.State != StateQueued {
+		t.Errorf("the second session is %s, expected %s", session.State, StateQueued)
+	}
+
+	// A queued job is removed from the queue without running
+	if session, err := s.StopEnumeration(third.ID); err != nil || session.State != StateStopped {
+		t.Errorf("the third session was not stopped: %v", err)
+	}
+
+	fr.release <- struct{}{}
+	if d := <-fr.started; d != "example.com" {
+		t.Fatalf("the job for %s started after the first, expected example.com", d)
+	}
+	if session := waitForState(t, s, first.ID, StateFinished); session.Findings != 1 {
+		t.Errorf("the first session has %d findings, expected 1", session.Findings)
+	}
+
+	// A running job is cancelled
+	waitForState(t, s, second.ID, StateRunning)
+	if _, err := s.StopEnumeration(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, s, second.ID, StateStopped)
+	if _, err := s.StopEnumeration(second.ID); err != ErrFinished {
+		t.Errorf("stopping a finished session returned %v, expected %v", err, ErrFinished)
+	}
+
+	select {
+	case d := <-fr.started:
+		t.Errorf("the stopped job for %s was started", d)
+	default:
+	}
+	if n := len(s.ListSessions()); n != 3 {
+		t.Errorf("%d sessions were listed, expected 3", n)
+	}
+}
+
+func TestStreamFindings(t *testing.T) {
+	s, fr := newTestServer(t, t.TempDir(), 1)
+	defer s.Close()
+
+	session, err := s.StartEnumeration(JobRequest{Domains: []string{"owasp.org", "example.com", "example.net"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-fr.started
+
+	var names []string
+	done := make(chan error)
+	go func() {
+		done <- s.StreamFindings(context.Background(), session.ID, func(o *requests.Output) error {
+			names = append(names, o.Name)
+			return nil
+		})
+	}()
+
+	// The stream ends once the session has finished
+	waitForState(t, s, session.ID, StateRunning)
+	fr.release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "www.example.com,www.example.net,www.owasp.org" {
+		t.Errorf("the stream provided the findings %v", names)
+	}
+	if err := s.StreamFindings(context.Background(), "missing", nil); err != ErrNotFound {
+		t.Errorf("streaming an unknown session returned %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestSessionPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	s, fr := newTestServer(t, dir, 1)
+	finished, _ := s.StartEnumeration(JobRequest{Domains: []string{"owasp.org"}})
+	<-fr.started
+	fr.release <- struct{}{}
+	waitForState(t, s, finished.ID, StateFinished)
+	running, _ := s.StartEnumeration(JobRequest{Domains: []string{"example.com"}})
+	<-fr.started
+	waitForState(t, s, running.ID, StateRunning)
+	// Simulate the daemon being killed by not closing the server
+	s.persist()
+
+	restarted, _ := newTestServer(t, dir, 1)
+	defer restarted.Close()
+
+	if session, err := restarted.GetProgress(finished.ID); err != nil || session.State != StateFinished || session.Findings != 1 {
+		t.Errorf("the finished session was not restored: %+v, %v", session, err)
+	}
+	if session, err := restarted.GetProgress(running.ID); err != nil || session.State != StateFailed {
+		t.Errorf("the interrupted session was not marked as failed: %+v, %v", session, err)
+	}
+	s.Close()
+}
+
+func TestJobConfig(t *testing.T) {
+	s, _ := newTestServer(t, t.TempDir(), 1)
+	defer s.Close()
+
+	if _, err := s.StartEnumeration(JobRequest{}); err == nil {
+		t.Errorf("a job without domains was accepted")
+	}
+	if _, err := s.StartEnumeration(JobRequest{Domains: []string{"owasp.org"}, Active: true, Passive: true}); err == nil {
+		t.Errorf("a job in both the active and passive modes was accepted")
+	}
+
+	cfg, err := s.jobConfig(JobRequest{
+		Domains:    []string{" OWASP.org. "},
+		Active:     true,
+		BruteForce: true,
+		Blacklist:  []string{"internal.owasp.org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cfg.Domains(); len(d) != 1 || d[0] != "owasp.org" {
+		t.Errorf("the job config has the domains %v", d)
+	}
+	if !cfg.Active || !cfg.BruteForcing || !cfg.Blacklisted("internal.owasp.org") {
+		t.Errorf("the job settings were not applied to the config")
+	}
+	if len(cfg.Wordlist) == 0 {
+		t.Errorf("the brute forcing job has no wordlist")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s, fr := newTestServer(t, t.TempDir(), 1)
+	defer s.Close()
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	call := func(method, path, token, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		resp := call(http.MethodGet, APIPrefix, token, "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("the token %q was given the status %d", token, resp.StatusCode)
+		}
+	}
+
+	resp := call(http.MethodPost, APIPrefix, "secret", `{"domains": ["owasp.org", "example.com"]}`)
+	var session Session
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("the job was not accepted: %d, %v", resp.StatusCode, err)
+	}
+	resp.Body.Close()
+	<-fr.started
+
+	resp = call(http.MethodPost, APIPrefix, "secret", `{"domains": ["owasp.org"], "unknown": true}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("the malformed job was given the status %d", resp.StatusCode)
+	}
+
+	resp = call(http.MethodGet, APIPrefix+"/missing", "secret", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("the unknown session was given the status %d", resp.StatusCode)
+	}
+
+	stream := call(http.MethodGet, APIPrefix+"/"+session.ID+"/findings", "secret", "")
+	defer stream.Body.Close()
+
+	scanner := bufio.NewScanner(stream.Body)
+	found := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		if !scanner.Scan() {
+			t.Fatalf("the stream ended after providing %d findings", i)
+		}
+
+		var o schema.FindingV1
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil || o.SchemaVersion != schema.Latest {
+			t.Errorf("the stream provided %q: %v", scanner.Text(), err)
+		}
+		found[o.Name] = true
+	}
+	if !found["www.owasp.org"] || !found["www.example.com"] {
+		t.Errorf("the stream provided the findings %v", found)
+	}
+
+	// The snapshot is recorded by the enumeration, which the fake runner does not start
+	resp = call(http.MethodGet, APIPrefix+"/"+session.ID+"/snapshot", "secret", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("the missing snapshot was given the status %d", resp.StatusCode)
+	}
+	j, _ := s.job(session.ID)
+	snap, err := snapshot.New(j.cfg, []string{"DNS"})
+	if err != nil || snap.ID != session.Snapshot {
+		t.Fatalf("the snapshot %v does not match the session: %v", snap, err)
+	}
+	if err := s.snaps.Save(snap); err != nil {
+		t.Fatal(err)
+	}
+	resp = call(http.MethodGet, APIPrefix+"/"+session.ID+"/snapshot", "secret", "")
+	var loaded snapshot.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&loaded); err != nil || loaded.ID != session.Snapshot {
+		t.Errorf("the snapshot endpoint provided %v: %v", loaded.ID, err)
+	}
+	resp.Body.Close()
+
+	resp = call(http.MethodPost, APIPrefix+"/"+session.ID+"/stop", "secret", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("stopping the session was given the status %d", resp.StatusCode)
+	}
+	// The stream ends with the session
+	if scanner.Scan() {
+		t.Errorf("the stream provided %q after the session stopped", scanner.Text())
+	}
+
+	resp = call(http.MethodGet, APIPrefix+"/"+session.ID, "secret", "")
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil || session.State != StateStopped {
+		t.Errorf("the session is %s, expected %s", session.State, StateStopped)
+	}
+	resp.Body.Close()
+}
+
+// healthSystem reports the health set by the tests.
+type healthSystem struct {
+	systems.System
+	health systems.Health
+}
+
+func (hs *healthSystem) Health() systems.Health { return hs.health }
+func (hs *healthSystem) Shutdown() error        { return nil }
+
+func TestHealthEndpoints(t *testing.T) {
+	base := config.NewConfig()
+	base.Dir = t.TempDir()
+	s, err := NewServer(&Config{Token: "secret", Health: true}, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	probe := func(path string) (int, systems.Health) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var h systems.Health
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, h
+	}
+
+	// The endpoints are served without the token, and the idle server is ready to run the jobs
+	if code, h := probe(ReadyPath); code != http.StatusOK || h.State != systems.HealthReady || h.Cause != "no enumeration is running" {
+		t.Errorf("the idle server was %d: %+v", code, h)
+	}
+
+	// A job whose System is warming up makes the server live, but not ready
+	warming := &healthSystem{health: systems.Health{State: systems.HealthStarting, Cause: "waiting for the resolvers"}}
+	s.systems.track(config.NewConfig(), warming)
+	if code, _ := probe(HealthPath); code != http.StatusOK {
+		t.Errorf("the warming server was not live: %d", code)
+	}
+	if code, h := probe(ReadyPath); code != http.StatusServiceUnavailable || h.Cause != "waiting for the resolvers" {
+		t.Errorf("the warming server was ready: %d, %+v", code, h)
+	}
+
+	failing := &healthSystem{health: systems.Health{State: systems.HealthUnhealthy, Cause: "the graphs are unhealthy"}}
+	s.systems.track(config.NewConfig(), failing)
+	if code, h := probe(HealthPath); code != http.StatusServiceUnavailable || h.State != systems.HealthUnhealthy {
+		t.Errorf("the failing server was live: %d, %+v", code, h)
+	}
+
+	s.Close()
+	if code, h := probe(HealthPath); code != http.StatusServiceUnavailable || h.State != systems.HealthStopped {
+		t.Errorf("the closed server was live: %d, %+v", code, h)
+	}
+
+	// Without the option, the paths are left to the authenticated API
+	other, _ := newTestServer(t, t.TempDir(), 1)
+	defer other.Close()
+	rec := httptest.NewRecorder()
+	other.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("the disabled health endpoint was given the status %d", rec.Code)
+	}
+}
+
+func TestSharedSystemHealth(t *testing.T) {
+	sp := newSystemPool(config.NewConfig(), true)
+	release := make(chan struct{})
+	sys := &healthSystem{health: systems.Health{State: systems.HealthReady}}
+	sp.newSystem = func(cfg *config.Config) (systems.System, error) {
+		<-release
+		return sys, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sp.warm()
+	}()
+	// The health is reported while the shared System is being built
+	if h := sp.health(); h.State != systems.HealthStarting {
+		t.Errorf("the health of the building pool was %+v", h)
+	}
+	close(release)
+	<-done
+	if h := sp.health(); h.State != systems.HealthReady {
+		t.Errorf("the health of the built pool was %+v", h)
+	}
+
+	failed := newSystemPool(config.NewConfig(), true)
+	failed.newSystem = func(cfg *config.Config) (systems.System, error) {
+		return nil, errors.New("no resolvers")
+	}
+	failed.warm()
+	if h := failed.health(); h.State != systems.HealthUnhealthy || !strings.Contains(h.Cause, "no resolvers") {
+		t.Errorf("the health of the failed pool was %+v", h)
+	}
+}
+
+func TestConfigFromOptions(t *testing.T) {
+	cfg := config.NewConfig()
+	if c := ConfigFromOptions(cfg); c.MaxConcurrent != DefaultMaxConcurrent || c.Token != "" || c.Shared {
+		t.Errorf("the defaults were not used: %+v", c)
+	}
+
+	cfg.Options["server"] = map[string]interface{}{
+		"listen":         "127.0.0.1:4000",
+		"token":          "secret",
+		"max_concurrent": 4,
+		"shared":         true,
+		"health":         true,
+	}
+	c := ConfigFromOptions(cfg)
+	if c.Listen != "127.0.0.1:4000" || c.Token != "secret" || c.MaxConcurrent != 4 || !c.Shared || !c.Health {
+		t.Errorf("the options were not parsed: %+v", c)
+	}
+
+	if _, err := NewServer(&Config{}, cfg); err == nil {
+		t.Errorf("a server without a token was created")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/owasp-amass/config/config"
+)
+
+// SessionsFile is the name of the file next to the local graph database that persists the job metadata.
+const SessionsFile = "sessions.json"
+
+// sessionStore persists the metadata of the jobs, since the graph schema has no asset type to hold them.
+type sessionStore struct {
+	sync.Mutex
+	path     string
+	sessions []Session
+}
+
+func newSessionStore(cfg *config.Config) (*sessionStore, error) {
+	ss := new(sessionStore)
+	if cfg == nil {
+		return ss, nil
+	}
+
+	dir := config.OutputDirectory(cfg.Dir)
+	if dir == "" {
+		return ss, nil
+	}
+	ss.path = filepath.Join(dir, SessionsFile)
+
+	data, err := os.ReadFile(ss.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ss, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the sessions file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &ss.sessions); err != nil {
+		return nil, fmt.Errorf("failed to parse the sessions file: %v", err)
+	}
+	return ss, nil
+}
+
+func (ss *sessionStore) load() []Session {
+	ss.Lock()
+	defer ss.Unlock()
+
+	return append([]Session(nil), ss.sessions...)
+}
+
+func (ss *sessionStore) save(sessions []Session) error {
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+
+	ss.Lock()
+	defer ss.Unlock()
+
+	ss.sessions = sessions
+	if ss.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ss.path), 0755); err != nil {
+		return err
+	}
+	// Replace the file atomically, so an interrupted write never loses the previous sessions
+	tmp := ss.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ss.path)
+}
@@ -0,0 +1,244 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/datasrcs"
+	"github.com/owasp-amass/amass/v4/enum"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+)
+
+// systemPool provides the System each job runs on. In the shared mode every job runs on a single
+// System built from the base configuration, otherwise each job gets its own System.
+type systemPool struct {
+	sync.Mutex
+	base      *config.Config
+	shared    bool
+	sys       systems.System
+	newSystem func(cfg *config.Config) (systems.System, error)
+	// inUse holds the System of each running job, keyed by the job configuration
+	inUse map[*config.Config]systems.System
+	// created is the time the pool was created, reported until a System provides its own health
+	created time.Time
+	closed  bool
+	warmErr error
+}
+
+func newSystemPool(base *config.Config, shared bool) *systemPool {
+	return &systemPool{
+		base:      base,
+		shared:    shared,
+		newSystem: newLocalSystem,
+		inUse:     make(map[*config.Config]systems.System),
+		created:   time.Now(),
+	}
+}
+
+// baseConfig returns the configuration the shared System is built from.
+func (sp *systemPool) baseConfig() *config.Config {
+	if sp.base == nil {
+		return config.NewConfig()
+	}
+	return sp.base
+}
+
+// warm builds the shared System ahead of the first job, without holding the lock, so the health of the
+// pool is reported while the System starts. A System built after the pool was closed, or after a job
+// built the shared System first, is shut down.
+func (sp *systemPool) warm() {
+	sp.Lock()
+	if !sp.shared || sp.sys != nil || sp.closed {
+		sp.Unlock()
+		return
+	}
+	base := sp.baseConfig()
+	sp.Unlock()
+
+	sys, err := sp.newSystem(base)
+
+	sp.Lock()
+	defer sp.Unlock()
+
+	sp.warmErr = err
+	if err != nil {
+		return
+	}
+	if sp.sys != nil || sp.closed {
+		_ = sys.Shutdown()
+		return
+	}
+	sp.sys = sys
+}
+
+// health merges the health of the shared System and of the Systems running the jobs. The shared System is
+// starting until it has been built, while the pool building a System for each job is ready when none is running.
+func (sp *systemPool) health() systems.Health {
+	sp.Lock()
+	var reporters []systems.HealthReporter
+	seen := make(map[systems.System]struct{})
+	add := func(sys systems.System) {
+		if _, found := seen[sys]; found {
+			return
+		}
+		seen[sys] = struct{}{}
+		if r, ok := sys.(systems.HealthReporter); ok {
+			reporters = append(reporters, r)
+		}
+	}
+	if sp.sys != nil {
+		add(sp.sys)
+	}
+	for _, sys := range sp.inUse {
+		add(sys)
+	}
+	building, err := sp.shared && sp.sys == nil, sp.warmErr
+	sp.Unlock()
+
+	// The Systems evaluate their components without the lock, since the checks may wait on the graph databases
+	hs := make([]systems.Health, 0, len(reporters))
+	for _, r := range reporters {
+		hs = append(hs, r.Health())
+	}
+
+	h := systems.MergeHealth(hs...)
+	switch {
+	case building && err != nil:
+		h = systems.Health{State: systems.HealthUnhealthy, Since: sp.created, Cause: fmt.Sprintf("the shared system could not be built: %v", err)}
+	case building:
+		h = systems.Health{State: systems.HealthStarting, Since: sp.created, Cause: "the shared system is being built"}
+	case len(hs) == 0:
+		h.Since, h.Cause = sp.created, "no enumeration is running"
+	}
+	return h
+}
+
+func newLocalSystem(cfg *config.Config) (systems.System, error) {
+	sys, err := systems.NewLocalSystem(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sys.SetDataSources(datasrcs.GetAllSources(sys)); err != nil {
+		_ = sys.Shutdown()
+		return nil, err
+	}
+	return sys, nil
+}
+
+// acquire returns the System the job runs on and the function releasing it once the job is done.
+func (sp *systemPool) acquire(cfg *config.Config) (systems.System, func(), error) {
+	if !sp.shared {
+		sys, err := sp.newSystem(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sp.track(cfg, sys)
+		return sys, func() {
+			sp.untrack(cfg)
+			_ = sys.Shutdown()
+		}, nil
+	}
+
+	sp.Lock()
+	defer sp.Unlock()
+
+	if sp.sys == nil {
+		sys, err := sp.newSystem(sp.baseConfig())
+		if err != nil {
+			return nil, nil, err
+		}
+		sp.sys = sys
+	}
+
+	sp.inUse[cfg] = sp.sys
+	return sp.sys, func() { sp.untrack(cfg) }, nil
+}
+
+func (sp *systemPool) track(cfg *config.Config, sys systems.System) {
+	sp.Lock()
+	defer sp.Unlock()
+
+	sp.inUse[cfg] = sys
+}
+
+func (sp *systemPool) untrack(cfg *config.Config) {
+	sp.Lock()
+	defer sp.Unlock()
+
+	delete(sp.inUse, cfg)
+}
+
+// progress returns the startup progress of the data sources used by the job, while the job holds a System.
+func (sp *systemPool) progress(cfg *config.Config) (systems.StartupProgress, bool) {
+	sp.Lock()
+	defer sp.Unlock()
+
+	if sys, found := sp.inUse[cfg]; found {
+		return sys.StartupProgress(), true
+	}
+	return systems.StartupProgress{}, false
+}
+
+// descriptors returns the file descriptor usage of the System used by the job, while the job holds a System.
+func (sp *systemPool) descriptors(cfg *config.Config) (systems.FDUsage, bool) {
+	sp.Lock()
+	defer sp.Unlock()
+
+	if sys, found := sp.inUse[cfg]; found {
+		return sys.FileDescriptors(), true
+	}
+	return systems.FDUsage{}, false
+}
+
+func (sp *systemPool) close() {
+	sp.Lock()
+	defer sp.Unlock()
+
+	sp.closed = true
+	if sp.sys != nil {
+		_ = sp.sys.Shutdown()
+		sp.sys = nil
+	}
+}
+
+// enumerate runs the enumeration of a job on a System from the pool.
+func (s *Server) enumerate(ctx context.Context, cfg *config.Config, req JobRequest, out chan *requests.Output) error {
+	sys, release, err := s.systems.acquire(cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	graphs := sys.GraphDatabases()
+	if len(graphs) == 0 {
+		return errors.New("the system has no graph database")
+	}
+
+	e := enum.NewEnumeration(cfg, sys, graphs[0])
+	if e == nil {
+		return errors.New("failed to setup the enumeration")
+	}
+	if err := e.Policy.Err(); err != nil {
+		return err
+	}
+	e.Output = out
+	e.Evidence = s.evidence
+	e.Snapshots = s.snaps
+	e.Budget = enum.Budget{
+		Duration:   time.Duration(req.Timeout) * time.Minute,
+		DNSQueries: req.DNSQueries,
+		Bytes:      req.Bytes,
+	}
+	return e.Start(ctx)
+}
@@ -0,0 +1,243 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package snapshot records the effective configuration of each enumeration, so the wordlists, data sources
+// and resolvers that produced a set of results can be reconstructed later. The secrets are redacted before
+// the snapshots are stored, and the snapshots of two runs can be compared.
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/format"
+	"github.com/owasp-amass/config/config"
+)
+
+// Redacted replaces the values of the settings that hold secrets.
+const Redacted = "REDACTED"
+
+// sensitiveWords identify the names of the settings that hold secrets, such as API keys and passwords.
+var sensitiveWords = []string{"key", "pass", "token", "secret"}
+
+// Snapshot is the effective configuration of an enumeration, with the secrets redacted.
+type Snapshot struct {
+	ID      string    `json:"id"`
+	Domains []string  `json:"domains"`
+	Start   time.Time `json:"start"`
+	Version string    `json:"version"`
+	// Settings holds the configuration, keyed by the name of each setting
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// ID returns the identifier of the snapshot of the event that enumerated the domains, starting at the time provided.
+func ID(domains []string, start time.Time) string {
+	names := append([]string(nil), domains...)
+	sort.Strings(names)
+
+	sum := sha256.Sum256([]byte(strings.Join(names, ",")))
+	return fmt.Sprintf("%d-%s", start.UnixNano(), hex.EncodeToString(sum[:4]))
+}
+
+// New returns the snapshot of the configuration, for the enumeration querying the named data sources.
+func New(cfg *config.Config, sources []string) (*Snapshot, error) {
+	domains := sortedCopy(cfg.Domains())
+	s := &Snapshot{
+		ID:      ID(domains, cfg.CollectionStartTime),
+		Domains: domains,
+		Start:   cfg.CollectionStartTime,
+		Version: format.Version,
+	}
+
+	settings := map[string]interface{}{
+		"active":            cfg.Active,
+		"passive":           cfg.Passive,
+		"brute_forcing":     cfg.BruteForcing,
+		"wordlist":          wordlist(cfg.Wordlist),
+		"recursive":         cfg.Recursive,
+		"min_for_recursive": cfg.MinForRecursive,
+		"max_depth":         cfg.MaxDepth,
+		"alterations":       cfg.Alterations,
+		"alt_wordlist":      wordlist(cfg.AltWordlist),
+		"resolvers":         cfg.Resolvers,
+		"resolvers_qps":     cfg.ResolversQPS,
+		"trusted_resolvers": cfg.TrustedResolvers,
+		"trusted_qps":       cfg.TrustedQPS,
+		"max_dns_queries":   cfg.MaxDNSQueries,
+		"record_types":      cfg.RecordTypes,
+		"sources":           sortedCopy(sources),
+		"options":           cfg.Options,
+	}
+	if cfg.Scope != nil {
+		settings["scope"] = map[string]interface{}{
+			"asns":      cfg.Scope.ASNs,
+			"cidrs":     cfg.Scope.CIDRStrings,
+			"ips":       cfg.Scope.IP,
+			"ports":     cfg.Scope.Ports,
+			"blacklist": cfg.Scope.Blacklist,
+		}
+	}
+	if cfg.DataSrcConfigs != nil {
+		settings["data_sources"] = dataSources(cfg.DataSrcConfigs)
+	}
+
+	// The settings are brought into their JSON form, so a snapshot compares equal to itself once it is loaded
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the configuration: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.Settings); err != nil {
+		return nil, fmt.Errorf("failed to decode the configuration: %v", err)
+	}
+	s.Settings = redact(s.Settings).(map[string]interface{})
+	return s, nil
+}
+
+// wordlist describes the words of a wordlist by their number and digest, rather than holding them all.
+func wordlist(words []string) map[string]interface{} {
+	if len(words) == 0 {
+		return nil
+	}
+
+	h := sha256.New()
+	for _, w := range words {
+		h.Write([]byte(w))
+		h.Write([]byte{'\n'})
+	}
+	return map[string]interface{}{
+		"words":  len(words),
+		"sha256": hex.EncodeToString(h.Sum(nil)),
+	}
+}
+
+// dataSources returns the settings of the data sources, including the credentials that are redacted later.
+func dataSources(dsc *config.DataSourceConfig) map[string]interface{} {
+	srcs := make(map[string]interface{}, len(dsc.Datasources))
+
+	for _, ds := range dsc.Datasources {
+		if ds == nil {
+			continue
+		}
+
+		creds := make(map[string]interface{}, len(ds.Creds))
+		for account, c := range ds.Creds {
+			if c != nil {
+				creds[account] = map[string]interface{}{
+					"username": c.Username,
+					"password": c.Password,
+					"apikey":   c.Apikey,
+					"secret":   c.Secret,
+				}
+			}
+		}
+		srcs[ds.Name] = map[string]interface{}{"ttl": ds.TTL, "creds": creds}
+	}
+	return map[string]interface{}{
+		"global_options": dsc.GlobalOptions,
+		"datasources":    srcs,
+	}
+}
+
+// sensitive returns true when the name of the setting indicates that the value is a secret.
+func sensitive(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, word := range sensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact replaces the values of the sensitive settings found anywhere within the value.
+func redact(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, e := range val {
+			if sensitive(k) && !empty(e) {
+				val[k] = Redacted
+			} else {
+				val[k] = redact(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range val {
+			val[i] = redact(e)
+		}
+	}
+	return v
+}
+
+func empty(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return val == ""
+	case map[string]interface{}:
+		return len(val) == 0
+	case []interface{}:
+		return len(val) == 0
+	}
+	return false
+}
+
+// Change is a setting that differs between two snapshots. A setting missing from a snapshot has a nil value.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// Diff returns the settings that differ between the snapshots, sorted by their path. The nested settings
+// are compared one by one, and their paths join the names of the settings with dots.
+func Diff(old, new *Snapshot) []Change {
+	var changes []Change
+
+	if !reflect.DeepEqual(old.Domains, new.Domains) {
+		changes = append(changes, Change{Path: "domains", Old: old.Domains, New: new.Domains})
+	}
+	if old.Version != new.Version {
+		changes = append(changes, Change{Path: "version", Old: old.Version, New: new.Version})
+	}
+	changes = append(changes, diff("settings", old.Settings, new.Settings)...)
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diff(path string, old, new interface{}) []Change {
+	om, ok1 := old.(map[string]interface{})
+	nm, ok2 := new.(map[string]interface{})
+	if !ok1 || !ok2 {
+		if reflect.DeepEqual(old, new) {
+			return nil
+		}
+		return []Change{{Path: path, Old: old, New: new}}
+	}
+
+	var changes []Change
+	for k, v := range om {
+		changes = append(changes, diff(path+"."+k, v, nm[k])...)
+	}
+	for k, v := range nm {
+		if _, found := om[k]; !found {
+			changes = append(changes, diff(path+"."+k, nil, v)...)
+		}
+	}
+	return changes
+}
+
+func sortedCopy(list []string) []string {
+	c := append([]string(nil), list...)
+	sort.Strings(c)
+	return c
+}
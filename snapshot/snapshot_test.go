@@ -0,0 +1,202 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/config/config"
+)
+
+var secrets = []string{"s3cr3t-apikey", "hunter2", "tok-123", "client-secret", "nested-pass"}
+
+func testConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.AddDomains("example.com", "example.org")
+	cfg.Wordlist = []string{"www", "mail", "dev"}
+	cfg.DataSrcConfigs = &config.DataSourceConfig{
+		GlobalOptions: map[string]int{"minimum_ttl": 1440},
+		Datasources: []*config.DataSource{{
+			Name: "Shodan",
+			TTL:  4320,
+			Creds: map[string]*config.Credentials{
+				"account": {Username: "analyst", Password: "hunter2", Apikey: "s3cr3t-apikey", Secret: "client-secret"},
+			},
+		}},
+	}
+	cfg.Options["notify"] = map[string]interface{}{
+		"enabled": true,
+		"targets": []interface{}{
+			map[string]interface{}{"url": "https://hooks.example.com", "Auth_Token": "tok-123"},
+		},
+		"smtp": map[string]interface{}{"username": "alerts", "passwd": "nested-pass", "api_key": ""},
+	}
+	return cfg
+}
+
+func TestRedaction(t *testing.T) {
+	cfg := testConfig()
+	snap, err := New(cfg, []string{"Shodan", "DNS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// None of the credentials land in the stored form of the snapshot
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secrets {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("the secret %s was found in the snapshot", secret)
+		}
+	}
+
+	creds := snap.Settings["data_sources"].(map[string]interface{})["datasources"].(map[string]interface{})["Shodan"].(map[string]interface{})["creds"].(map[string]interface{})["account"].(map[string]interface{})
+	for _, field := range []string{"password", "apikey", "secret"} {
+		if creds[field] != Redacted {
+			t.Errorf("the credential %s was stored as %v", field, creds[field])
+		}
+	}
+	if creds["username"] != "analyst" {
+		t.Errorf("the username was stored as %v", creds["username"])
+	}
+
+	notify := snap.Settings["options"].(map[string]interface{})["notify"].(map[string]interface{})
+	target := notify["targets"].([]interface{})[0].(map[string]interface{})
+	if target["Auth_Token"] != Redacted || target["url"] != "https://hooks.example.com" {
+		t.Errorf("the settings within the list were stored as %v", target)
+	}
+	if smtp := notify["smtp"].(map[string]interface{}); smtp["passwd"] != Redacted || smtp["api_key"] != "" {
+		t.Errorf("the nested settings were stored as %v", smtp)
+	}
+
+	// The configuration used by the enumeration keeps the credentials
+	if cfg.DataSrcConfigs.Datasources[0].Creds["account"].Apikey != "s3cr3t-apikey" {
+		t.Error("the redaction modified the configuration")
+	}
+	if cfg.Options["notify"].(map[string]interface{})["smtp"].(map[string]interface{})["passwd"] != "nested-pass" {
+		t.Error("the redaction modified the options of the configuration")
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	for name, want := range map[string]bool{
+		"apikey":        true,
+		"API_KEY":       true,
+		"password":      true,
+		"passphrase":    true,
+		"access_token":  true,
+		"client_secret": true,
+		"username":      false,
+		"url":           false,
+		"enabled":       false,
+	} {
+		if got := sensitive(name); got != want {
+			t.Errorf("sensitive(%q) returned %v, expected %v", name, got, want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old, err := New(testConfig(), []string{"Shodan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.Active = true
+	cfg.Wordlist = append(cfg.Wordlist, "api")
+	delete(cfg.Options, "notify")
+	cfg.Options["rdap"] = map[string]interface{}{"enabled": true}
+	new, err := New(cfg, []string{"Shodan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("a snapshot differs from itself: %v", changes)
+	}
+
+	var paths []string
+	for _, c := range Diff(old, new) {
+		paths = append(paths, c.Path)
+	}
+	want := []string{
+		"settings.active",
+		"settings.options.notify",
+		"settings.options.rdap",
+		"settings.wordlist.sha256",
+		"settings.wordlist.words",
+	}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("the changes were %v, expected %v", paths, want)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		cfg := testConfig()
+		cfg.CollectionStartTime = start.Add(time.Duration(i) * time.Hour)
+		snap, err := New(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if snaps, err := s.List(); err != nil || len(snaps) != 2 || !snaps[0].Start.Equal(start) {
+		t.Fatalf("the snapshots listed were %v: %v", snaps, err)
+	}
+	if snap, err := s.ForEvent("Example.com", time.Time{}); err != nil || !snap.Start.Equal(start.Add(time.Hour)) {
+		t.Errorf("the latest snapshot of the domain was %v: %v", snap, err)
+	}
+
+	snap, err := s.ForEvent("example.org", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := s.Load(ID([]string{"example.org", "example.com"}, start)); err != nil || len(Diff(snap, loaded)) != 0 {
+		t.Errorf("the snapshot loaded by its identifier was %v: %v", loaded, err)
+	}
+	if _, err := s.ForEvent("example.net", time.Time{}); err != ErrNotFound {
+		t.Errorf("a domain without events returned %v, expected ErrNotFound", err)
+	}
+	if _, err := s.Load("../" + DirName); err != ErrNotFound {
+		t.Errorf("an identifier outside of the store returned %v, expected ErrNotFound", err)
+	}
+
+	if err := s.Delete(snap.ID); err != nil {
+		t.Errorf("failed to delete the snapshot: %v", err)
+	}
+	if _, err := s.Load(snap.ID); err != ErrNotFound {
+		t.Errorf("the deleted snapshot returned %v, expected ErrNotFound", err)
+	}
+	if err := s.Delete(snap.ID); err != ErrNotFound {
+		t.Errorf("deleting the missing snapshot returned %v, expected ErrNotFound", err)
+	}
+
+	// The snapshot files may only be read by their owner, in case a secret was not recognized
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("the snapshot file %s has the mode %v", f, fi.Mode())
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirName is the name of the directory in the output directory that holds the snapshots.
+const DirName = "snapshots"
+
+// ErrNotFound is returned when no snapshot has been stored for the event.
+var ErrNotFound = errors.New("the snapshot was not found")
+
+// fileVersion is the version of the snapshot file format.
+const fileVersion = 1
+
+type snapshotFile struct {
+	Version  int       `json:"version"`
+	Snapshot *Snapshot `json:"snapshot"`
+}
+
+// Store keeps a snapshot file for each enumeration event. The graph schema has no properties,
+// so the snapshots are kept by the store, keyed by the root domains and start time of the event.
+type Store struct {
+	sync.Mutex
+	dir string
+}
+
+// Open returns the store that keeps the snapshot files in the directory, which is created when missing.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the snapshots directory: %v", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save writes the snapshot, replacing the file only once the new content is complete.
+func (s *Store) Save(snap *Snapshot) error {
+	if snap == nil || snap.ID == "" {
+		return errors.New("the snapshot has no identifier")
+	}
+
+	data, err := json.MarshalIndent(&snapshotFile{Version: fileVersion, Snapshot: snap}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the snapshot: %v", err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	path := s.path(snap.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write the snapshot: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace the snapshot file: %v", err)
+	}
+	return nil
+}
+
+// Load returns the snapshot with the identifier.
+func (s *Store) Load(id string) (*Snapshot, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, ErrNotFound
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.load(s.path(id))
+}
+
+func (s *Store) load(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the snapshot: %v", err)
+	}
+
+	var f snapshotFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse the snapshot: %v", err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("the snapshot file has the unsupported version %d", f.Version)
+	}
+	if f.Snapshot == nil {
+		return nil, ErrNotFound
+	}
+	return f.Snapshot, nil
+}
+
+// Delete removes the snapshot with the identifier.
+func (s *Store) Delete(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return ErrNotFound
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := os.Remove(s.path(id)); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to remove the snapshot: %v", err)
+	}
+	return nil
+}
+
+// List returns the snapshots in the store, sorted by the start time of their events.
+func (s *Store) List() ([]*Snapshot, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps []*Snapshot
+	for _, path := range paths {
+		snap, err := s.load(path)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].Start.Before(snaps[j].Start) })
+	return snaps, nil
+}
+
+// ForEvent returns the snapshot of the event that enumerated the domain, starting at the time provided.
+// The latest event that enumerated the domain is selected when the time is zero.
+func (s *Store) ForEvent(domain string, start time.Time) (*Snapshot, error) {
+	snaps, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	for i := len(snaps) - 1; i >= 0; i-- {
+		snap := snaps[i]
+		if !start.IsZero() && !snap.Start.Equal(start) {
+			continue
+		}
+		for _, d := range snap.Domains {
+			if strings.EqualFold(d, domain) {
+				return snap, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
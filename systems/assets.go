@@ -0,0 +1,223 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/caffix/netmap"
+	"github.com/glebarez/sqlite"
+	"github.com/owasp-amass/asset-db/repository"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+	"golang.org/x/net/publicsuffix"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// assetStores holds the connections to the databases of the graphs opened by the Systems. The related writes
+// of an asset are applied in a single transaction through them, and the graphs are repaired with queries.
+var assetStores = struct {
+	sync.Mutex
+	graphs map[*netmap.Graph]*gorm.DB
+}{graphs: make(map[*netmap.Graph]*gorm.DB)}
+
+// openAssetStore returns a separate connection to the database of the graph.
+func openAssetStore(system, dsn string) (*gorm.DB, error) {
+	var dialect gorm.Dialector
+
+	switch system {
+	case "local":
+		// The writes wait for those of the graph instead of failing
+		if !strings.Contains(dsn, "?") {
+			dsn += "?_pragma=busy_timeout(5000)"
+		}
+		dialect = sqlite.Open(dsn)
+	case "postgres":
+		dialect = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("the %s database does not support the asset transactions", system)
+	}
+
+	db, err := gorm.Open(dialect, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the asset store: %v", err)
+	}
+	return db, nil
+}
+
+// RegisterAssetStore opens a separate connection to the database of the graph, through which UpsertResolvedName
+// applies its writes in a transaction and RepairOrphans queries the graph. The Systems register their graphs.
+func RegisterAssetStore(g *netmap.Graph, system, dsn string) error {
+	db, err := openAssetStore(system, dsn)
+	if err != nil {
+		return err
+	}
+
+	assetStores.Lock()
+	prev, found := assetStores.graphs[g]
+	assetStores.graphs[g] = db
+	assetStores.Unlock()
+
+	if found {
+		if sqldb, err := prev.DB(); err == nil {
+			_ = sqldb.Close()
+		}
+	}
+	return nil
+}
+
+// UnregisterAssetStore closes the connection registered for the graph.
+func UnregisterAssetStore(g *netmap.Graph) {
+	assetStores.Lock()
+	db, found := assetStores.graphs[g]
+	delete(assetStores.graphs, g)
+	assetStores.Unlock()
+
+	if found {
+		if sqldb, err := db.DB(); err == nil {
+			_ = sqldb.Close()
+		}
+	}
+}
+
+func assetStoreFor(g *netmap.Graph) *gorm.DB {
+	assetStores.Lock()
+	defer assetStores.Unlock()
+
+	return assetStores.graphs[g]
+}
+
+// UpsertResolvedName writes the name, the addresses it resolved to and the A and AAAA record edges joining them
+// as a group. The group is applied in a single transaction to the graphs opened by the Systems. Otherwise, the
+// name is written first and each address right before its edge, so an interrupted write only leaves fragments
+// that RepairOrphans removes.
+func UpsertResolvedName(ctx context.Context, g *netmap.Graph, name string, addrs ...string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if g == nil || name == "" {
+		return errors.New("UpsertResolvedName: the graph and the name are required")
+	}
+
+	ips := make([]netip.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+		if err != nil {
+			return fmt.Errorf("UpsertResolvedName: %v", err)
+		}
+		ips = append(ips, ip.Unmap())
+	}
+
+	if db := assetStoreFor(g); db != nil {
+		return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return upsertResolvedName(tx, name, ips)
+		})
+	}
+
+	if _, err := g.UpsertFQDN(ctx, name); err != nil {
+		return fmt.Errorf("UpsertResolvedName: %v", err)
+	}
+	for _, ip := range ips {
+		var err error
+
+		if ip.Is4() {
+			err = g.UpsertA(ctx, name, ip.String())
+		} else {
+			err = g.UpsertAAAA(ctx, name, ip.String())
+		}
+		if err != nil {
+			return fmt.Errorf("UpsertResolvedName: %v", err)
+		}
+	}
+	return nil
+}
+
+func upsertResolvedName(tx *gorm.DB, name string, ips []netip.Addr) error {
+	// The registered domain is written with each name, like the graph does
+	if d, err := publicsuffix.EffectiveTLDPlusOne(name); err != nil {
+		return fmt.Errorf("UpsertResolvedName: %v", err)
+	} else if d != name {
+		if _, err := upsertAsset(tx, &domain.FQDN{Name: d}); err != nil {
+			return err
+		}
+	}
+
+	from, err := upsertAsset(tx, &domain.FQDN{Name: name})
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		t, rrtype := "IPv4", "a_record"
+		if ip.Is6() {
+			t, rrtype = "IPv6", "aaaa_record"
+		}
+
+		to, err := upsertAsset(tx, &network.IPAddress{Address: ip, Type: t})
+		if err != nil {
+			return err
+		}
+		if err := upsertRelation(tx, from, rrtype, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertAsset returns the identifier of the asset, which is created unless the graph holds it already.
+// Like the graph, the asset found has its last seen time updated.
+func upsertAsset(tx *gorm.DB, a oam.Asset) (int64, error) {
+	content, err := a.JSON()
+	if err != nil {
+		return 0, fmt.Errorf("UpsertResolvedName: %v", err)
+	}
+
+	row := repository.Asset{Type: string(a.AssetType()), Content: content}
+	q, err := row.JSONQuery()
+	if err != nil {
+		return 0, fmt.Errorf("UpsertResolvedName: %v", err)
+	}
+
+	var rows []repository.Asset
+	if err := tx.Where("type = ?", row.Type).Limit(1).Find(&rows, q).Error; err != nil {
+		return 0, fmt.Errorf("UpsertResolvedName: %v", err)
+	}
+	if len(rows) > 0 {
+		if err := tx.Exec("UPDATE assets SET last_seen = current_timestamp WHERE id = ?", rows[0].ID).Error; err != nil {
+			return 0, fmt.Errorf("UpsertResolvedName: %v", err)
+		}
+		return rows[0].ID, nil
+	}
+
+	if err := tx.Create(&row).Error; err != nil {
+		return 0, fmt.Errorf("UpsertResolvedName: failed to create the %s asset: %v", row.Type, err)
+	}
+	return row.ID, nil
+}
+
+// upsertRelation creates the edge between the assets, or updates the last seen time of the edge stored before.
+func upsertRelation(tx *gorm.DB, from int64, rtype string, to int64) error {
+	var rows []repository.Relation
+	if err := tx.Where("type = ? AND from_asset_id = ? AND to_asset_id = ?", rtype, from, to).Limit(1).Find(&rows).Error; err != nil {
+		return fmt.Errorf("UpsertResolvedName: %v", err)
+	}
+	if len(rows) > 0 {
+		if err := tx.Exec("UPDATE relations SET last_seen = current_timestamp WHERE id = ?", rows[0].ID).Error; err != nil {
+			return fmt.Errorf("UpsertResolvedName: %v", err)
+		}
+		return nil
+	}
+
+	row := &repository.Relation{Type: rtype, FromAssetID: from, ToAssetID: to}
+	if err := tx.Omit("FromAsset", "ToAsset").Create(row).Error; err != nil {
+		return fmt.Errorf("UpsertResolvedName: failed to create the %s edge: %v", rtype, err)
+	}
+	return nil
+}
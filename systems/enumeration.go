@@ -0,0 +1,197 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/blacklist"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultMaxEnumerations is the number of enumerations a LocalSystem runs at once when the limit is not configured.
+const DefaultMaxEnumerations = 4
+
+// Scope describes the targets of an enumeration started by a System, and the resources it may consume.
+// The techniques, such as brute forcing, are the ones selected by the configuration of the System.
+type Scope struct {
+	Domains   []string
+	Addresses []net.IP
+	CIDRs     []*net.IPNet
+	ASNs      []int
+	Blacklist []string
+	// Duration is the maximum amount of time the enumeration will run
+	Duration time.Duration
+	// DNSQueries is the maximum number of DNS queries the enumeration will send
+	DNSQueries int64
+	// Bytes is the maximum number of bytes the enumeration will send and receive over DNS and HTTP
+	Bytes int64
+}
+
+// ScopeFromConfig returns the scope of the configuration, which helps the callers that build
+// a configuration for each enumeration move to the System interface.
+func ScopeFromConfig(cfg *config.Config) Scope {
+	var scope Scope
+
+	scope.Domains = cfg.Domains()
+	if cfg.Scope != nil {
+		scope.Addresses = append(scope.Addresses, cfg.Scope.Addresses...)
+		scope.CIDRs = append(scope.CIDRs, cfg.Scope.CIDRs...)
+		scope.ASNs = append(scope.ASNs, cfg.Scope.ASNs...)
+		scope.Blacklist = append(scope.Blacklist, cfg.Scope.Blacklist...)
+	}
+	return scope
+}
+
+// EnumerationProgress is the progress of an enumeration started by a System.
+type EnumerationProgress struct {
+	// Findings is the number of names found so far
+	Findings int `json:"findings"`
+	// Queries is the number of DNS queries sent so far
+	Queries int64 `json:"queries"`
+	// Blocked is the number of active probes toward the never-touch list that were blocked so far
+	Blocked int64 `json:"blocked,omitempty"`
+	// Coalesced is the number of DNS queries answered by an identical query already in flight
+	Coalesced int64           `json:"coalesced,omitempty"`
+	Sources   StartupProgress `json:"sources"`
+	// Bandwidth holds the bytes sent and received so far over DNS and HTTP
+	Bandwidth bandwidth.Stats `json:"bandwidth"`
+}
+
+// Enumeration is an enumeration started by a System.
+type Enumeration interface {
+	// Done is closed once the enumeration has finished or been stopped
+	Done() <-chan struct{}
+
+	// Progress returns the findings and DNS queries of the enumeration so far
+	Progress() EnumerationProgress
+
+	// Output returns the names resolved within scope, which must be received until the channel is closed
+	Output() <-chan *requests.Output
+
+	// Stop ends the enumeration and returns once it is done
+	Stop()
+
+	// Err returns the error that ended the enumeration, once it is done
+	Err() error
+}
+
+// EnumerationStarter starts an enumeration of the configuration on the System. The configuration
+// already holds the scope, which also provides the budget of the enumeration.
+type EnumerationStarter func(ctx context.Context, sys System, cfg *config.Config, scope Scope) (Enumeration, error)
+
+var (
+	starterLock sync.Mutex
+	starter     EnumerationStarter
+)
+
+// RegisterEnumerationStarter sets the function that starts the enumerations of the Systems. The enum
+// package registers its starter when imported, since it builds upon this package.
+func RegisterEnumerationStarter(fn EnumerationStarter) {
+	starterLock.Lock()
+	defer starterLock.Unlock()
+
+	starter = fn
+}
+
+func startEnumeration(ctx context.Context, sys System, cfg *config.Config, scope Scope) (Enumeration, error) {
+	starterLock.Lock()
+	fn := starter
+	starterLock.Unlock()
+
+	if fn == nil {
+		return nil, ErrNoEnumerationStarter
+	}
+	return fn(ctx, sys, cfg, scope)
+}
+
+// scopeConfig returns the configuration of an enumeration, built from the configuration of the System and the scope.
+// Every setting of the System is kept, while the targets and the blacklist are those of the scope.
+func scopeConfig(base *config.Config, scope Scope) (*config.Config, error) {
+	if len(scope.Domains) == 0 && len(scope.Addresses) == 0 && len(scope.CIDRs) == 0 && len(scope.ASNs) == 0 {
+		return nil, &ConfigError{Field: "scope", Reason: "the scope does not provide any domain names, addresses, CIDRs or ASNs"}
+	}
+
+	// The configuration holds locks, so the settings are copied one by one
+	cfg := config.NewConfig()
+	cfg.Log = base.Log
+	cfg.Options = base.Options
+	cfg.Filepath = base.Filepath
+	cfg.ScriptsDirectory = base.ScriptsDirectory
+	cfg.Dir = base.Dir
+	cfg.GraphDBs = base.GraphDBs
+	cfg.MaxDNSQueries = base.MaxDNSQueries
+	cfg.Wordlist = base.Wordlist
+	cfg.BruteForcing = base.BruteForcing
+	cfg.Recursive = base.Recursive
+	cfg.MinForRecursive = base.MinForRecursive
+	cfg.MaxDepth = base.MaxDepth
+	cfg.Alterations = base.Alterations
+	cfg.FlipWords = base.FlipWords
+	cfg.FlipNumbers = base.FlipNumbers
+	cfg.AddWords = base.AddWords
+	cfg.AddNumbers = base.AddNumbers
+	cfg.MinForWordFlip = base.MinForWordFlip
+	cfg.EditDistance = base.EditDistance
+	cfg.AltWordlist = base.AltWordlist
+	cfg.Passive = base.Passive
+	cfg.Active = base.Active
+	cfg.SourceFilter = base.SourceFilter
+	cfg.MinimumTTL = base.MinimumTTL
+	cfg.RecordTypes = base.RecordTypes
+	cfg.Resolvers = base.Resolvers
+	cfg.ResolversQPS = base.ResolversQPS
+	cfg.TrustedResolvers = base.TrustedResolvers
+	cfg.TrustedQPS = base.TrustedQPS
+	cfg.Verbose = base.Verbose
+	cfg.ProvidedNames = base.ProvidedNames
+	cfg.Mode = base.Mode
+	cfg.DataSrcConfigs = base.DataSrcConfigs
+	if base.Scope != nil {
+		cfg.Scope.Ports = base.Scope.Ports
+	}
+
+	for _, d := range scope.Domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d == "" {
+			return nil, &ConfigError{Field: "domains", Reason: "the scope provides an empty domain name"}
+		}
+		cfg.AddDomain(d)
+	}
+	for _, addr := range scope.Addresses {
+		cfg.Scope.Addresses = append(cfg.Scope.Addresses, addr)
+		cfg.Scope.IP = append(cfg.Scope.IP, addr.String())
+	}
+	for _, cidr := range scope.CIDRs {
+		cfg.Scope.CIDRs = append(cfg.Scope.CIDRs, cidr)
+		cfg.Scope.CIDRStrings = append(cfg.Scope.CIDRStrings, cidr.String())
+	}
+	cfg.Scope.ASNs = append(cfg.Scope.ASNs, scope.ASNs...)
+	if _, err := blacklist.Compile(scope.Blacklist); err != nil {
+		return nil, &ConfigError{Field: "blacklist", Reason: err.Error(), Err: err}
+	}
+	for _, name := range scope.Blacklist {
+		cfg.BlacklistSubdomain(name)
+	}
+	return cfg, nil
+}
+
+// maxEnumerations parses the 'max_enumerations' configuration option.
+func maxEnumerations(cfg *config.Config) int {
+	if cfg == nil || cfg.Options == nil {
+		return DefaultMaxEnumerations
+	}
+	if n, ok := options.IntValue(cfg.Options["max_enumerations"]); ok && n > 0 {
+		return n
+	}
+	return DefaultMaxEnumerations
+}
@@ -0,0 +1,175 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// fakeEnumeration runs until it is stopped.
+type fakeEnumeration struct {
+	cfg   *config.Config
+	scope Scope
+	out   chan *requests.Output
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (f *fakeEnumeration) Done() <-chan struct{}           { return f.done }
+func (f *fakeEnumeration) Progress() EnumerationProgress   { return EnumerationProgress{} }
+func (f *fakeEnumeration) Output() <-chan *requests.Output { return f.out }
+func (f *fakeEnumeration) Err() error                      { return nil }
+
+func (f *fakeEnumeration) Stop() {
+	f.once.Do(func() {
+		close(f.out)
+		close(f.done)
+	})
+}
+
+func registerFakeStarter(t *testing.T) *[]*fakeEnumeration {
+	var started []*fakeEnumeration
+
+	RegisterEnumerationStarter(func(ctx context.Context, sys System, cfg *config.Config, scope Scope) (Enumeration, error) {
+		f := &fakeEnumeration{
+			cfg:   cfg,
+			scope: scope,
+			out:   make(chan *requests.Output),
+			done:  make(chan struct{}),
+		}
+		started = append(started, f)
+		return f, nil
+	})
+	t.Cleanup(func() { RegisterEnumerationStarter(nil) })
+	return &started
+}
+
+func TestLocalSystemStartEnumeration(t *testing.T) {
+	started := registerFakeStarter(t)
+
+	cfg := config.NewConfig()
+	cfg.Active = true
+	cfg.Options["max_enumerations"] = 2
+	sys := &LocalSystem{
+		Cfg:        cfg,
+		pool:       resolve.NewResolvers(),
+		trusted:    resolve.NewResolvers(),
+		done:       make(chan struct{}, 2),
+		addSource:  make(chan service.Service),
+		allSources: make(chan chan []service.Service, 10),
+	}
+	go sys.manageDataSources()
+
+	if _, err := sys.StartEnumeration(context.Background(), Scope{}); err == nil {
+		t.Error("an enumeration without any domain names was started")
+	}
+	var cerr *ConfigError
+	if _, err := sys.StartEnumeration(context.Background(), Scope{Domains: []string{"owasp.org"}, Blacklist: []string{"re:[a-"}}); !errors.As(err, &cerr) || cerr.Field != "blacklist" {
+		t.Errorf("the enumeration with a blacklist that is not valid returned %v", err)
+	}
+
+	first, err := sys.StartEnumeration(context.Background(), Scope{Domains: []string{"OWASP.org."}, Blacklist: []string{"www.owasp.org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sys.StartEnumeration(context.Background(), Scope{Domains: []string{"example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sys.StartEnumeration(context.Background(), Scope{Domains: []string{"example.org"}}); !errors.Is(err, ErrEnumerationLimit) {
+		t.Fatalf("starting a third enumeration returned %v, expected ErrEnumerationLimit", err)
+	}
+
+	// Each enumeration has its own scope, and the techniques of the System
+	f := (*started)[0]
+	if !reflect.DeepEqual(f.cfg.Domains(), []string{"owasp.org"}) || !f.cfg.Blacklisted("www.owasp.org") || !f.cfg.Active {
+		t.Errorf("the enumeration was started with the domains %v", f.cfg.Domains())
+	}
+	if (*started)[1].cfg.Blacklisted("www.owasp.org") {
+		t.Error("the blacklist of one enumeration was shared with another")
+	}
+
+	// The enumerations that are done no longer count against the limit
+	first.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err = sys.StartEnumeration(context.Background(), Scope{Domains: []string{"example.org"}}); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the finished enumeration still counts against the limit: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = sys.Shutdown()
+	for i, f := range *started {
+		select {
+		case <-f.Done():
+		default:
+			t.Errorf("enumeration %d was not stopped by the shutdown", i)
+		}
+	}
+	if _, err := sys.StartEnumeration(context.Background(), Scope{Domains: []string{"example.org"}}); err == nil {
+		t.Error("an enumeration was started after the shutdown")
+	}
+}
+
+func TestStartEnumerationAddressScope(t *testing.T) {
+	started := registerFakeStarter(t)
+
+	cfg := config.NewConfig()
+	cfg.Options["dns"] = map[string]interface{}{"ttl_floor": 60}
+	cfg.MinimumTTL = 1440
+	cfg.FlipWords = true
+	cfg.EditDistance = 2
+	sys := &SimpleSystem{Cfg: cfg}
+
+	_, cidr, _ := net.ParseCIDR("192.0.2.0/24")
+	scope := Scope{Addresses: []net.IP{net.ParseIP("198.51.100.1")}, CIDRs: []*net.IPNet{cidr}}
+	if _, err := sys.StartEnumeration(context.Background(), scope); err != nil {
+		t.Fatalf("the enumeration of the addresses was not started: %v", err)
+	}
+
+	f := (*started)[0]
+	if len(f.cfg.Domains()) != 0 || !f.cfg.IsAddressInScope("198.51.100.1") || !f.cfg.IsAddressInScope("192.0.2.10") {
+		t.Errorf("the enumeration was started with the scope %+v", f.cfg.Scope)
+	}
+	// The settings of the System are kept by the enumeration
+	if f.cfg.MinimumTTL != 1440 || !f.cfg.FlipWords || f.cfg.EditDistance != 2 || !reflect.DeepEqual(f.cfg.Options, cfg.Options) {
+		t.Errorf("the settings of the System were not kept by the enumeration")
+	}
+}
+
+func TestStartEnumerationWithoutStarter(t *testing.T) {
+	sys := &SimpleSystem{Cfg: config.NewConfig()}
+
+	if _, err := sys.StartEnumeration(context.Background(), Scope{Domains: []string{"owasp.org"}}); !errors.Is(err, ErrNoEnumerationStarter) {
+		t.Errorf("starting an enumeration without a starter returned %v, expected ErrNoEnumerationStarter", err)
+	}
+}
+
+func TestVerifyWithoutVerifier(t *testing.T) {
+	cfg := config.NewConfig()
+	sys := &SimpleSystem{Cfg: cfg}
+
+	if _, err := sys.Verify(context.Background(), []string{"www.owasp.org"}); err == nil {
+		t.Error("the names were verified without a domain in scope")
+	}
+	cfg.AddDomain("owasp.org")
+	if _, err := sys.Verify(context.Background(), []string{"www.owasp.org"}); !errors.Is(err, ErrNoNameVerifier) {
+		t.Errorf("verifying the names without a verifier returned %v, expected ErrNoNameVerifier", err)
+	}
+}
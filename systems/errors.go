@@ -0,0 +1,85 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNoResolvers is returned when none of the DNS resolvers needed by the System can be used.
+	ErrNoResolvers = errors.New("no DNS resolvers are available")
+	// ErrGraphUnavailable is returned when a graph database cannot be opened.
+	ErrGraphUnavailable = errors.New("the graph database is unavailable")
+	// ErrEnumerationLimit is returned when the System is already running the most enumerations it allows.
+	ErrEnumerationLimit = errors.New("the system is running the maximum number of enumerations")
+	// ErrNoEnumerationStarter is returned when enumerations are started without the enum package being imported.
+	ErrNoEnumerationStarter = errors.New("no enumeration starter has been registered")
+	// ErrNoNameVerifier is returned when names are verified without the enum package being imported.
+	ErrNoNameVerifier = errors.New("no name verifier has been registered")
+)
+
+// ConfigError reports a configuration setting that keeps the System from being built.
+type ConfigError struct {
+	// Field is the name of the setting, such as the configuration option
+	Field  string
+	Reason string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field == "" {
+		return "configuration error: " + e.Reason
+	}
+	return fmt.Sprintf("configuration error in %s: %s", e.Field, e.Reason)
+}
+
+// Unwrap returns the underlying error when there is one.
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// SourceError reports a data source that failed to start. Temporary failures, such
+// as network timeouts, are likely to go away when the start is retried.
+type SourceError struct {
+	Name      string
+	Temporary bool
+	Err       error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("the %s data source failed to start: %v", e.Name, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *SourceError) Unwrap() error { return e.Err }
+
+// sourceError returns the error of the data source as a SourceError, classifying it unless it already is one.
+func sourceError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var serr *SourceError
+	if errors.As(err, &serr) {
+		return err
+	}
+	return &SourceError{Name: name, Temporary: IsTransient(err), Err: err}
+}
+
+// PreflightError reports the preflight checks that failed in a way that keeps the enumeration from finding anything.
+type PreflightError struct {
+	Report *PreflightReport
+}
+
+func (e *PreflightError) Error() string {
+	var checks []string
+	for _, res := range e.Report.Failures() {
+		if res.Hard {
+			checks = append(checks, fmt.Sprintf("%s (%s)", res.Check, res.Code))
+		}
+	}
+	return "the preflight checks failed: " + strings.Join(checks, ", ")
+}
@@ -0,0 +1,152 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	for _, sentinel := range []error{ErrNoResolvers, ErrGraphUnavailable} {
+		err := fmt.Errorf("%w: the system failed", sentinel)
+
+		if !errors.Is(err, sentinel) {
+			t.Errorf("errors.Is did not find %v in %v", sentinel, err)
+		}
+		if err = fmt.Errorf("setup: %w", err); !errors.Is(err, sentinel) {
+			t.Errorf("errors.Is did not find %v in the twice wrapped %v", sentinel, err)
+		}
+	}
+	if errors.Is(fmt.Errorf("%w: failed", ErrNoResolvers), ErrGraphUnavailable) {
+		t.Error("errors.Is matched the wrong sentinel error")
+	}
+}
+
+func TestCheckSettingsConfigError(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(cfg *config.Config)
+		field string
+	}{
+		{"valid", func(cfg *config.Config) {}, ""},
+		{"brute", func(cfg *config.Config) {
+			cfg.Passive = true
+			cfg.BruteForcing = true
+		}, "brute_forcing"},
+		{"active", func(cfg *config.Config) {
+			cfg.Passive = true
+			cfg.Active = true
+		}, "active"},
+		{"source ports", func(cfg *config.Config) {
+			cfg.Options["dns"] = map[string]interface{}{"source_ports": []interface{}{"40000-40999", "40500-41000"}}
+		}, "dns.source_ports"},
+		{"bind address", func(cfg *config.Config) {
+			cfg.Options["dns"] = map[string]interface{}{"bind_address": "eth0"}
+		}, "dns.bind_address"},
+		{"unbound pools", func(cfg *config.Config) {
+			cfg.Options["dns"] = map[string]interface{}{"source_ports": []interface{}{"40000-40999"}}
+		}, "dns.source_ports"},
+		{"pipelined sockets", func(cfg *config.Config) {
+			cfg.Options["dns"] = map[string]interface{}{"pipelined": true, "bind_address": "192.0.2.5", "source_ports": []interface{}{"40000-40999"}}
+		}, ""},
+		{"ttl floor", func(cfg *config.Config) {
+			cfg.Options["dns"] = map[string]interface{}{"ttl_floor": 3600, "ttl_ceiling": 60}
+		}, "dns.ttl_floor"},
+		{"source check", func(cfg *config.Config) {
+			cfg.Options["dns"] = map[string]interface{}{"pipelined": true, "source_check": "loose"}
+		}, "dns.source_check"},
+		{"blacklist", func(cfg *config.Config) {
+			cfg.BlacklistSubdomain("re:(internal")
+		}, "blacklist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			test.setup(cfg)
+
+			err := checkSettings(cfg)
+			if test.field == "" {
+				if err != nil {
+					t.Errorf("checkSettings returned the error %v", err)
+				}
+				return
+			}
+
+			var cerr *ConfigError
+			if !errors.As(fmt.Errorf("wrapped: %w", err), &cerr) {
+				t.Fatalf("checkSettings returned %v, which is not a ConfigError", err)
+			}
+			if cerr.Field != test.field {
+				t.Errorf("the ConfigError names the field %q, expected %q", cerr.Field, test.field)
+			}
+		})
+	}
+}
+
+func TestSelectReadGraphsConfigError(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["read_database"] = "cayley"
+
+	var cerr *ConfigError
+	l := &LocalSystem{graphSystems: []string{"local"}}
+	if err := l.selectReadGraphs(cfg); !errors.As(err, &cerr) || cerr.Field != "read_database" {
+		t.Errorf("selectReadGraphs returned %v, expected a ConfigError for read_database", err)
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	permanent := errors.New("check callback failed for the configuration")
+
+	err := sourceError("Shodan", permanent)
+	var serr *SourceError
+	if !errors.As(err, &serr) {
+		t.Fatalf("sourceError returned %v, which is not a SourceError", err)
+	}
+	if serr.Name != "Shodan" || serr.Temporary || !errors.Is(err, permanent) {
+		t.Errorf("sourceError returned the wrong classification %+v", serr)
+	}
+
+	if err := sourceError("Shodan", errors.New("dial tcp: i/o timeout")); !errors.As(err, &serr) || !serr.Temporary {
+		t.Errorf("sourceError did not classify %v as temporary", err)
+	}
+	// An error already classified by the data source keeps its classification
+	classified := &SourceError{Name: "Shodan", Temporary: true, Err: permanent}
+	if err := sourceError("Other", classified); err != classified {
+		t.Errorf("sourceError replaced the SourceError with %v", err)
+	}
+	if sourceError("Shodan", nil) != nil {
+		t.Error("sourceError returned an error for a nil error")
+	}
+}
+
+func TestStartSourceHonorsTemporary(t *testing.T) {
+	// The message looks permanent, but the data source reported it as temporary
+	err := &SourceError{Name: "Flaky", Temporary: true, Err: errors.New("check callback failed")}
+	src := newFlakySource("Flaky", 2, err)
+	opts := startOptions{retries: 3, backoff: time.Millisecond, clock: clock.System}
+
+	done := make(chan struct{})
+	defer close(done)
+	if err := startSource(src, opts, 0, done); err != nil || src.starts != 3 {
+		t.Errorf("the temporary SourceError was not retried: %v after %d starts", err, src.starts)
+	}
+}
+
+func TestOpenGraphDBUnavailable(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Dir = t.TempDir()
+
+	l := &LocalSystem{Cfg: cfg}
+	if _, _, err := l.openGraphDB(cfg, &config.Database{System: "invalid", Primary: true}); !errors.Is(err, ErrGraphUnavailable) {
+		t.Errorf("openGraphDB returned %v, expected ErrGraphUnavailable", err)
+	}
+}
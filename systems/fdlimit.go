@@ -0,0 +1,168 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"log"
+	"runtime"
+	"sync"
+
+	amasshttp "github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/amass/v4/transport"
+	"github.com/owasp-amass/config/config"
+)
+
+// fdReserved is the number of file descriptors kept for the standard streams, the log and output files, and the wordlists.
+const fdReserved = 64
+
+// fdPerGraph is the number of connections expected to be opened to each graph database.
+const fdPerGraph = 10
+
+// FDUsage is the file descriptor limit of the process and the file descriptors used by a System.
+type FDUsage struct {
+	// Limit is the soft limit of the process, and zero when it is not known or unlimited
+	Limit int `json:"limit"`
+	// Expected is the number of file descriptors the System plans to use
+	Expected int `json:"expected"`
+	// Open is the number of file descriptors open in the process, and -1 when they cannot be counted
+	Open int `json:"open"`
+}
+
+// fdBudget fits the resolver pools and the HTTP connections of a System into the file descriptor limit of
+// the process, so a System configured beyond the limit is scaled down instead of failing during the run.
+type fdBudget struct {
+	sync.Mutex
+	limit     int
+	resolvers int
+	graphs    int
+	http      int
+}
+
+var (
+	raiseOnce  sync.Once
+	raisedSoft int
+)
+
+// newFDBudget returns the budget of a System. The first budget of the process raises the soft limit to the hard limit.
+func newFDBudget(logger *log.Logger) *fdBudget {
+	raiseOnce.Do(func() {
+		soft, hard, err := fdLimit()
+		if err != nil {
+			return
+		}
+
+		raisedSoft = soft
+		if soft > 0 && (hard > soft || hard == 0) {
+			if n, err := raiseFDLimit(hard); err == nil && n != soft {
+				raisedSoft = n
+				if logger != nil {
+					logger.Printf("System: raised the open file limit from %d to %d", soft, n)
+				}
+			}
+		}
+	})
+	return &fdBudget{limit: raisedSoft}
+}
+
+// resolverShare returns the number of untrusted resolvers that fit in the half of the descriptors
+// left for the resolver pools, or zero when the pool size does not need to be limited.
+func (b *fdBudget) resolverShare(cfg *config.Config) int {
+	if b == nil || b.limit == 0 {
+		return 0
+	}
+
+	trusted := len(cfg.TrustedResolvers)
+	if trusted == 0 {
+		trusted = len(config.DefaultBaselineResolvers)
+	}
+
+	avail := b.limit - fdReserved - (len(cfg.GraphDBs)+1)*fdPerGraph - 2*runtime.NumCPU()
+	if share := (avail/2 - trusted) / resolverFDs(cfg); share > 0 {
+		return share
+	}
+	return 1
+}
+
+// resolverFDs returns the descriptors used by each untrusted resolver, which are the TCP connection it
+// can open and, when the pipelined transport is enabled, the sockets the transport opens to it.
+func resolverFDs(cfg *config.Config) int {
+	opts := transport.OptionsFromConfig(cfg)
+	if opts == nil {
+		return 1
+	}
+	if opts.Sockets <= 0 {
+		return 1 + transport.DefaultSockets
+	}
+	return 1 + opts.Sockets
+}
+
+// addResolvers accounts for the sockets of the resolver pools, and the TCP connection each resolver can open.
+func (b *fdBudget) addResolvers(untrusted, trusted int) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.resolvers = 2*runtime.NumCPU() + untrusted + trusted
+}
+
+func (b *fdBudget) addGraphs(n int) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.graphs = n * fdPerGraph
+}
+
+// fitHTTP lowers the connections per host of the HTTP client, so the data sources fit in the descriptors
+// left by the resolver pools and the graph databases. The limit is never raised, since the client is shared.
+func (b *fdBudget) fitHTTP(sources int, logger *log.Logger) {
+	if b == nil || sources <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	perHost := amasshttp.MaxConnsPerHost()
+	if b.limit > 0 {
+		if fit := (b.limit - fdReserved - b.resolvers - b.graphs) / sources; fit < perHost || perHost == 0 {
+			if fit < 1 {
+				fit = 1
+			}
+
+			amasshttp.SetMaxConnsPerHost(fit)
+			perHost = fit
+			if logger != nil {
+				logger.Printf("System: limited the HTTP connections per host to %d to fit the open file limit of %d", fit, b.limit)
+			}
+		}
+	}
+
+	b.http = perHost * sources
+	if expected := fdReserved + b.resolvers + b.graphs + b.http; b.limit > 0 && expected > b.limit && logger != nil {
+		logger.Printf("System: the %d file descriptors expected exceed the open file limit of %d", expected, b.limit)
+	}
+}
+
+// usage returns the limit and the expected usage of the System, along with the descriptors currently open.
+func (b *fdBudget) usage() FDUsage {
+	u := FDUsage{Open: openFDs()}
+	if b == nil {
+		return u
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	u.Limit = b.limit
+	u.Expected = fdReserved + b.resolvers + b.graphs + b.http
+	return u
+}
@@ -0,0 +1,23 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package systems
+
+import "errors"
+
+var errNoFDLimit = errors.New("the open file limit is not available on this platform")
+
+func fdLimit() (int, int, error) {
+	return 0, 0, errNoFDLimit
+}
+
+func raiseFDLimit(hard int) (int, error) {
+	return 0, errNoFDLimit
+}
+
+func openFDs() int {
+	return -1
+}
@@ -0,0 +1,81 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"runtime"
+	"testing"
+
+	amasshttp "github.com/owasp-amass/amass/v4/net/http"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestFDBudgetResolverShare(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.TrustedResolvers = []string{"8.8.8.8", "1.1.1.1"}
+
+	if n := (&fdBudget{}).resolverShare(cfg); n != 0 {
+		t.Errorf("an unknown limit restricted the pool to %d resolvers", n)
+	}
+
+	b := &fdBudget{limit: fdReserved + fdPerGraph + 2*runtime.NumCPU() + 200}
+	if n := b.resolverShare(cfg); n != 98 {
+		t.Errorf("the pool was limited to %d resolvers, expected 98", n)
+	}
+
+	// Each resolver of the pipelined transport also takes its sockets
+	cfg.Options["dns"] = map[string]interface{}{"pipelined": true, "sockets": 3}
+	if n := b.resolverShare(cfg); n != 24 {
+		t.Errorf("the pool was limited to %d resolvers with the pipelined transport, expected 24", n)
+	}
+	delete(cfg.Options, "dns")
+
+	b.limit = fdReserved
+	if n := b.resolverShare(cfg); n != 1 {
+		t.Errorf("the pool was limited to %d resolvers below the limit, expected 1", n)
+	}
+}
+
+func TestFDBudgetFitHTTP(t *testing.T) {
+	orig := amasshttp.MaxConnsPerHost()
+	defer amasshttp.SetMaxConnsPerHost(orig)
+
+	b := &fdBudget{limit: 1 << 20}
+	b.addResolvers(100, 10)
+	b.addGraphs(1)
+	b.fitHTTP(50, nil)
+	if n := amasshttp.MaxConnsPerHost(); n != orig {
+		t.Errorf("the connections per host changed to %d within the limit", n)
+	}
+
+	b.limit = fdReserved + 2*runtime.NumCPU() + 110 + fdPerGraph + 500
+	b.fitHTTP(50, nil)
+	if n := amasshttp.MaxConnsPerHost(); n != 10 {
+		t.Fatalf("the connections per host were lowered to %d, expected 10", n)
+	}
+	if u := b.usage(); u.Expected != b.limit {
+		t.Errorf("the expected usage is %d, expected the limit of %d", u.Expected, b.limit)
+	}
+
+	// The limit is never raised, since the HTTP client is shared by the systems
+	b.limit = 1 << 20
+	b.fitHTTP(50, nil)
+	if n := amasshttp.MaxConnsPerHost(); n != 10 {
+		t.Errorf("the connections per host were raised to %d", n)
+	}
+}
+
+func TestOpenFDs(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("the open file descriptors are not counted on this platform")
+	}
+
+	if n := openFDs(); n < 3 {
+		t.Errorf("counted %d open file descriptors, expected at least the standard streams", n)
+	}
+	if soft, _, err := fdLimit(); err != nil || soft < 0 {
+		t.Errorf("failed to obtain the open file limit: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package systems
+
+import (
+	"os"
+	"syscall"
+)
+
+// fdMaxTarget caps the soft limit requested when the hard limit is unlimited.
+const fdMaxTarget = 1 << 20
+
+// fdLimit returns the soft and hard limits on the open files of the process, where zero is unlimited.
+func fdLimit() (int, int, error) {
+	var rl syscall.Rlimit
+
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, err
+	}
+	return rlimitValue(rl.Cur), rlimitValue(rl.Max), nil
+}
+
+// raiseFDLimit sets the soft limit on the open files to the hard limit, and returns the resulting soft limit.
+func raiseFDLimit(hard int) (int, error) {
+	target := hard
+	if target == 0 {
+		target = fdMaxTarget
+	}
+
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+
+	rl.Cur = uint64(target)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+
+	soft, _, err := fdLimit()
+	return soft, err
+}
+
+func rlimitValue(v uint64) int {
+	// The values beyond the descriptors any process could open are treated as unlimited
+	if v > 1<<30 {
+		return 0
+	}
+	return int(v)
+}
+
+// openFDs returns the number of file descriptors open in the process, or -1 when they cannot be counted.
+func openFDs() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if entries, err := os.ReadDir(dir); err == nil {
+			// Reading the directory opens a descriptor that is included in the entries
+			return len(entries) - 1
+		}
+	}
+	return -1
+}
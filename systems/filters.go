@@ -0,0 +1,146 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultFilterLatency is the average latency above which a name filter is reported as slow.
+const DefaultFilterLatency = 100 * time.Microsecond
+
+// minFilterCalls is the number of calls averaged before the latency of a name filter is judged.
+const minFilterCalls = 1000
+
+// NameFilter returns false to drop the candidate name provided by the source. The filters are called
+// for every candidate name from many goroutines, so they must be safe for concurrent use and cheap.
+type NameFilter func(name, src string) bool
+
+// NameFilterStats counts the candidate names seen and dropped by a name filter.
+type NameFilterStats struct {
+	// Index is the position of the filter in the order they were added
+	Index      int
+	Calls      int64
+	Dropped    int64
+	AvgLatency time.Duration
+}
+
+// NameFilters is the chain of custom name filters, which are consulted in the order they were added.
+type NameFilters struct {
+	sync.RWMutex
+	log       *log.Logger
+	threshold time.Duration
+	filters   []*nameFilter
+}
+
+type nameFilter struct {
+	fn      NameFilter
+	calls   atomic.Int64
+	dropped atomic.Int64
+	nanos   atomic.Int64
+	warned  atomic.Bool
+}
+
+// NewNameFilters returns the chain logging the filters whose average latency exceeds the threshold.
+func NewNameFilters(l *log.Logger, threshold time.Duration) *NameFilters {
+	if threshold <= 0 {
+		threshold = DefaultFilterLatency
+	}
+	return &NameFilters{log: l, threshold: threshold}
+}
+
+// Add appends the filter to the chain.
+func (nf *NameFilters) Add(f NameFilter) {
+	if nf == nil || f == nil {
+		return
+	}
+
+	nf.Lock()
+	defer nf.Unlock()
+
+	nf.filters = append(nf.filters, &nameFilter{fn: f})
+}
+
+// Allow returns false when a filter of the chain drops the name, which is counted against the filter.
+func (nf *NameFilters) Allow(name, src string) bool {
+	if nf == nil {
+		return true
+	}
+
+	nf.RLock()
+	filters := nf.filters
+	nf.RUnlock()
+
+	for i, f := range filters {
+		start := time.Now()
+		keep := f.fn(name, src)
+		nanos := f.nanos.Add(int64(time.Since(start)))
+		calls := f.calls.Add(1)
+
+		if calls%minFilterCalls == 0 {
+			if avg := time.Duration(nanos / calls); avg > nf.threshold && !f.warned.Swap(true) && nf.log != nil {
+				nf.log.Printf("The name filter %d takes %s on average, exceeding %s, and slows the enumerations", i, avg, nf.threshold)
+			}
+		}
+		if !keep {
+			f.dropped.Add(1)
+			return false
+		}
+	}
+	return true
+}
+
+// Stats returns the counters of each filter, in the order they were added.
+func (nf *NameFilters) Stats() []NameFilterStats {
+	if nf == nil {
+		return nil
+	}
+
+	nf.RLock()
+	defer nf.RUnlock()
+
+	stats := make([]NameFilterStats, 0, len(nf.filters))
+	for i, f := range nf.filters {
+		s := NameFilterStats{
+			Index:   i,
+			Calls:   f.calls.Load(),
+			Dropped: f.dropped.Load(),
+		}
+		if s.Calls > 0 {
+			s.AvgLatency = time.Duration(f.nanos.Load() / s.Calls)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// RegexNameFilter returns the filter dropping the names that match any of the patterns, regardless of case.
+func RegexNameFilter(patterns ...string) (NameFilter, error) {
+	var res []*regexp.Regexp
+
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("the name filter pattern %q is invalid: %v", p, err)
+		}
+		res = append(res, re)
+	}
+
+	return func(name, src string) bool {
+		name = strings.TrimSuffix(name, ".")
+		for _, re := range res {
+			if re.MatchString(name) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
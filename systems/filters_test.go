@@ -0,0 +1,89 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNameFilters(t *testing.T) {
+	nf := NewNameFilters(nil, 0)
+	if !nf.Allow("www.owasp.org", "crtsh") {
+		t.Error("the name was dropped without filters")
+	}
+
+	re, err := RegexNameFilter(`^legacy\.`, `\.payments\.example\.com$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nf.Add(re)
+	nf.Add(func(name, src string) bool { return src != "brute_force" })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				nf.Allow(fmt.Sprintf("host%d-%d.owasp.org", i, j), "crtsh")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, tc := range []struct {
+		name, src string
+		keep      bool
+	}{
+		{"LEGACY.owasp.org", "crtsh", false},
+		{"api.payments.example.com.", "crtsh", false},
+		{"payments.example.com", "crtsh", true},
+		{"dev.owasp.org", "brute_force", false},
+	} {
+		if keep := nf.Allow(tc.name, tc.src); keep != tc.keep {
+			t.Errorf("the name %s from %s was kept: %v", tc.name, tc.src, keep)
+		}
+	}
+
+	stats := nf.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("the statistics of %d filters were returned", len(stats))
+	}
+	if s := stats[0]; s.Index != 0 || s.Calls != 804 || s.Dropped != 2 {
+		t.Errorf("the regular expression filter counted %+v", s)
+	}
+	// The names dropped by the first filter are not seen by the second
+	if s := stats[1]; s.Index != 1 || s.Calls != 802 || s.Dropped != 1 {
+		t.Errorf("the second filter counted %+v", s)
+	}
+
+	if _, err := RegexNameFilter(`(`); err == nil {
+		t.Error("the invalid pattern was accepted")
+	}
+}
+
+func TestSlowNameFilter(t *testing.T) {
+	var logs strings.Builder
+	nf := NewNameFilters(log.New(&logs, "", 0), time.Nanosecond)
+	nf.Add(func(name, src string) bool {
+		time.Sleep(time.Microsecond)
+		return true
+	})
+
+	for i := 0; i < 2*minFilterCalls; i++ {
+		nf.Allow("www.owasp.org", "crtsh")
+	}
+	if n := strings.Count(logs.String(), "The name filter 0 takes"); n != 1 {
+		t.Errorf("the slow filter was logged %d times:\n%s", n, logs.String())
+	}
+	if stats := nf.Stats(); stats[0].AvgLatency < time.Microsecond {
+		t.Errorf("the average latency was %v", stats[0].AvgLatency)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// DefaultHealthInterval is the time between the evaluations of the health of the System in the background,
+// so the transitions are logged even when no orchestrator asks for the health.
+const DefaultHealthInterval = 15 * time.Second
+
+// healthProbeName is looked up in the graph databases to learn that they answer, and is never stored.
+const healthProbeName = "amass-health-probe.invalid"
+
+// HealthState is the stage of its life cycle the System is in, as reported to the orchestrators.
+type HealthState string
+
+// The states of the health of a System.
+const (
+	// HealthStarting is the state of the System until every component is ready
+	HealthStarting HealthState = "starting"
+	// HealthReady is the state of the System able to run enumerations
+	HealthReady HealthState = "ready"
+	// HealthUnhealthy is the state of the System while a component cannot do its work
+	HealthUnhealthy HealthState = "unhealthy"
+	// HealthStopped is the state of the System once it has been shut down
+	HealthStopped HealthState = "stopped"
+)
+
+// severity orders the states from the best to the worst, for the health merged from several Systems.
+func (s HealthState) severity() int {
+	switch s {
+	case HealthReady:
+		return 0
+	case HealthStarting:
+		return 1
+	case HealthUnhealthy:
+		return 2
+	}
+	return 3
+}
+
+// ComponentHealth is the health of one of the components the System depends on.
+type ComponentHealth struct {
+	Name string `json:"name"`
+	// Ready is true once the component has finished starting, such as the resolvers warming up
+	Ready bool `json:"ready"`
+	// Healthy is false while the component cannot do its work, such as a resolver pool without live resolvers
+	Healthy bool   `json:"healthy"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Health is the state of a System aggregated from the health of its components.
+type Health struct {
+	State HealthState `json:"state"`
+	// Since is the time of the last transition
+	Since time.Time `json:"since"`
+	// Cause tells why the System made its last transition
+	Cause      string            `json:"cause,omitempty"`
+	Components []ComponentHealth `json:"components,omitempty"`
+}
+
+// Live returns false once the System is unhealthy or stopped, and should be restarted.
+func (h Health) Live() bool {
+	return h.State == HealthStarting || h.State == HealthReady
+}
+
+// Ready returns true while the System is able to run enumerations.
+func (h Health) Ready() bool {
+	return h.State == HealthReady
+}
+
+// HealthReporter is implemented by the Systems aggregating the health of their components, which the
+// health endpoints of the daemons report.
+type HealthReporter interface {
+	// Health evaluates the components of the System, and returns the state they put the System in
+	Health() Health
+}
+
+// HealthCheck returns the current health of a component.
+type HealthCheck func() ComponentHealth
+
+// MergeHealth aggregates the health of several Systems, such as those running the jobs of a daemon. The merged
+// state is the worst of the states, and a component is only ready and healthy when it is in every System.
+func MergeHealth(hs ...Health) Health {
+	merged := Health{State: HealthReady}
+	if len(hs) == 0 {
+		return merged
+	}
+
+	index := make(map[string]int)
+	for i, h := range hs {
+		if i == 0 || h.State.severity() > merged.State.severity() {
+			merged.State, merged.Since, merged.Cause = h.State, h.Since, h.Cause
+		}
+
+		for _, c := range h.Components {
+			j, found := index[c.Name]
+			if !found {
+				index[c.Name] = len(merged.Components)
+				merged.Components = append(merged.Components, c)
+				continue
+			}
+
+			m := &merged.Components[j]
+			if (m.Ready && m.Healthy) && (!c.Ready || !c.Healthy) {
+				m.Detail = c.Detail
+			}
+			m.Ready = m.Ready && c.Ready
+			m.Healthy = m.Healthy && c.Healthy
+		}
+	}
+	return merged
+}
+
+// healthMonitor is the state machine aggregating the health of the components. The System is starting until
+// every component is ready, is unhealthy while any component is unhealthy, and is stopped for good once it shuts
+// down. A System that has been ready does not start over when a component is no longer ready, such as while the
+// data sources added later are starting. Each transition is logged along with its cause.
+type healthMonitor struct {
+	sync.Mutex
+	checks []HealthCheck
+	clock  clock.Clock
+	log    *log.Logger
+	state  HealthState
+	since  time.Time
+	cause  string
+	ready  bool
+}
+
+func newHealthMonitor(l *log.Logger, clk clock.Clock, checks ...HealthCheck) *healthMonitor {
+	return &healthMonitor{
+		checks: checks,
+		clock:  clk,
+		log:    l,
+		state:  HealthStarting,
+		since:  clk.Now(),
+		cause:  "the system is starting",
+	}
+}
+
+// evaluate runs the checks of the components, and moves the state machine to the state they put the System in.
+func (m *healthMonitor) evaluate() Health {
+	if m == nil {
+		return Health{State: HealthStarting}
+	}
+
+	m.Lock()
+	stopped := m.state == HealthStopped
+	m.Unlock()
+	if stopped {
+		return m.current(nil)
+	}
+
+	// The checks may wait on the graph databases, so the lock is not held while they run
+	components := make([]ComponentHealth, 0, len(m.checks))
+	for _, check := range m.checks {
+		components = append(components, check())
+	}
+
+	next, cause := HealthReady, "every component is ready"
+	for _, c := range components {
+		if !c.Healthy {
+			next, cause = HealthUnhealthy, fmt.Sprintf("the %s are unhealthy: %s", c.Name, c.Detail)
+			break
+		}
+	}
+	if next == HealthReady {
+		for _, c := range components {
+			if !c.Ready {
+				next, cause = HealthStarting, fmt.Sprintf("waiting for the %s: %s", c.Name, c.Detail)
+				break
+			}
+		}
+	}
+
+	m.Lock()
+	if next == HealthStarting && m.ready {
+		next, cause = HealthReady, "the components have recovered"
+	}
+	m.transition(next, cause)
+	m.Unlock()
+	return m.current(components)
+}
+
+// stop moves the System to the stopped state, which is never left.
+func (m *healthMonitor) stop(cause string) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.transition(HealthStopped, cause)
+}
+
+// transition moves the state machine to the state and logs the cause, and must be called while holding the lock.
+func (m *healthMonitor) transition(next HealthState, cause string) {
+	if next == m.state || m.state == HealthStopped {
+		return
+	}
+
+	if m.log != nil {
+		m.log.Printf("System: the health changed from %s to %s: %s", m.state, next, cause)
+	}
+	m.state, m.since, m.cause = next, m.clock.Now(), cause
+	if next == HealthReady {
+		m.ready = true
+	}
+}
+
+func (m *healthMonitor) current(components []ComponentHealth) Health {
+	m.Lock()
+	defer m.Unlock()
+
+	return Health{State: m.state, Since: m.since, Cause: m.cause, Components: components}
+}
+
+// Health implements the HealthReporter interface.
+func (l *LocalSystem) Health() Health {
+	return l.health.evaluate()
+}
+
+// watchHealth evaluates the health of the System at the interval until it shuts down.
+func (l *LocalSystem) watchHealth(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		l.health.evaluate()
+
+		select {
+		case <-l.done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// resolverHealth is ready once the reputation probes have warmed the resolvers up, and unhealthy
+// while the pool has no live resolvers, since those exceeding the failure thresholds are stopped.
+func (l *LocalSystem) resolverHealth() ComponentHealth {
+	c := ComponentHealth{Name: "resolvers", Ready: l.reputation.warmedUp(), Healthy: true}
+
+	live := l.pool.Len()
+	switch {
+	case live == 0:
+		c.Healthy = false
+		c.Detail = "no resolver of the pool is live"
+	case !c.Ready:
+		c.Detail = "the resolvers are being probed"
+	default:
+		c.Detail = fmt.Sprintf("%d resolvers are live", live)
+	}
+	return c
+}
+
+// sourceHealth is ready once the data sources have been set and each of them has started or failed to.
+// The data sources failing to start do not make the System unhealthy, since the others still provide names.
+func (l *LocalSystem) sourceHealth() ComponentHealth {
+	l.startLock.Lock()
+	p, set := l.progress, l.sourcesSet
+	l.startLock.Unlock()
+
+	c := ComponentHealth{Name: "data_sources", Ready: set && p.Done(), Healthy: true}
+	if !set {
+		c.Detail = "the data sources have not been set"
+	} else {
+		c.Detail = fmt.Sprintf("%d of the %d data sources have started and %d failed to", p.Started, p.Total, p.Failed)
+	}
+	return c
+}
+
+// graphHealth is ready once the graph databases have been opened, and unhealthy when every one of them
+// fails to answer a lookup.
+func (l *LocalSystem) graphHealth() ComponentHealth {
+	c := ComponentHealth{Name: "graphs", Ready: len(l.graphs) > 0}
+	if !c.Ready {
+		c.Detail = "no graph database has been opened"
+		return c
+	}
+
+	var failed int
+	var last error
+	for _, g := range l.graphs {
+		if _, err := g.DB.FindByContent(&domain.FQDN{Name: healthProbeName}, time.Time{}); err != nil {
+			failed++
+			last = err
+		}
+	}
+
+	c.Healthy = failed < len(l.graphs)
+	if failed == 0 {
+		c.Detail = fmt.Sprintf("%d graph databases are answering", len(l.graphs))
+	} else {
+		c.Detail = fmt.Sprintf("%d of the %d graph databases are failing: %v", failed, len(l.graphs), last)
+	}
+	return c
+}
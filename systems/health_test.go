@@ -0,0 +1,156 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+)
+
+// fakeComponent is a component whose health is set by the tests.
+type fakeComponent struct {
+	ComponentHealth
+}
+
+func (c *fakeComponent) check() ComponentHealth {
+	return c.ComponentHealth
+}
+
+func TestHealthTransitions(t *testing.T) {
+	var logs strings.Builder
+	clk := clock.NewFake(time.Now())
+	resolvers := &fakeComponent{ComponentHealth{Name: "resolvers", Healthy: true, Detail: "the resolvers are being probed"}}
+	graphs := &fakeComponent{ComponentHealth{Name: "graphs", Ready: true, Healthy: true}}
+	m := newHealthMonitor(log.New(&logs, "", 0), clk, resolvers.check, graphs.check)
+
+	// The System starts until every component is ready
+	if h := m.evaluate(); h.State != HealthStarting || !h.Live() || h.Ready() || len(h.Components) != 2 {
+		t.Errorf("the health of the warming system was %+v", h)
+	}
+
+	clk.Jump(time.Minute)
+	resolvers.Ready = true
+	if h := m.evaluate(); h.State != HealthReady || !h.Ready() || !h.Since.Equal(clk.Now()) {
+		t.Errorf("the health of the warm system was %+v", h)
+	}
+
+	// Every graph database failing makes the System unhealthy, until the databases answer again
+	graphs.Healthy, graphs.Detail = false, "1 of the 1 graph databases are failing"
+	if h := m.evaluate(); h.State != HealthUnhealthy || h.Live() || !strings.Contains(h.Cause, "graphs") {
+		t.Errorf("the health with the failing graphs was %+v", h)
+	}
+	graphs.Healthy = true
+	resolvers.Ready = false
+	// A System that has been ready does not start over
+	if h := m.evaluate(); h.State != HealthReady || h.Cause != "the components have recovered" {
+		t.Errorf("the health of the recovered system was %+v", h)
+	}
+
+	m.stop("the system was shut down")
+	resolvers.Ready = true
+	if h := m.evaluate(); h.State != HealthStopped || h.Live() {
+		t.Errorf("the health of the stopped system was %+v", h)
+	}
+
+	expected := []string{
+		"from starting to ready: every component is ready",
+		"from ready to unhealthy: the graphs are unhealthy: 1 of the 1 graph databases are failing",
+		"from unhealthy to ready: the components have recovered",
+		"from ready to stopped: the system was shut down",
+	}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("the transitions were logged as %q", lines)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, expected[i]) {
+			t.Errorf("the transition %q was logged, expected %q", line, expected[i])
+		}
+	}
+}
+
+func TestHealthWithoutLiveResolvers(t *testing.T) {
+	resolvers := &fakeComponent{ComponentHealth{Name: "resolvers", Detail: "no resolver of the pool is live"}}
+	m := newHealthMonitor(nil, clock.NewFake(time.Now()), resolvers.check)
+
+	// The unhealthy component takes precedence over the components that are not ready
+	if h := m.evaluate(); h.State != HealthUnhealthy || h.Cause != "the resolvers are unhealthy: no resolver of the pool is live" {
+		t.Errorf("the health without live resolvers was %+v", h)
+	}
+
+	var none *healthMonitor
+	none.stop("the system was shut down")
+	if h := none.evaluate(); h.Ready() {
+		t.Errorf("the system without a monitor was %+v", h)
+	}
+}
+
+func TestMergeHealth(t *testing.T) {
+	now := time.Now()
+	ready := Health{State: HealthReady, Since: now, Components: []ComponentHealth{
+		{Name: "resolvers", Ready: true, Healthy: true, Detail: "25 resolvers are live"},
+	}}
+	failing := Health{State: HealthUnhealthy, Since: now.Add(time.Minute), Cause: "the resolvers are unhealthy", Components: []ComponentHealth{
+		{Name: "resolvers", Ready: true, Detail: "no resolver of the pool is live"},
+	}}
+
+	if h := MergeHealth(); h.State != HealthReady {
+		t.Errorf("the health of no systems was %+v", h)
+	}
+	h := MergeHealth(ready, failing)
+	if h.State != HealthUnhealthy || h.Cause != failing.Cause || !h.Since.Equal(failing.Since) {
+		t.Errorf("the merged health was %+v", h)
+	}
+	if len(h.Components) != 1 || h.Components[0].Healthy || h.Components[0].Detail != "no resolver of the pool is live" {
+		t.Errorf("the merged components were %+v", h.Components)
+	}
+}
+
+func TestLocalSystemComponents(t *testing.T) {
+	cfg := config.NewConfig()
+	l := &LocalSystem{Cfg: cfg}
+
+	if c := l.graphHealth(); c.Ready || c.Healthy {
+		t.Errorf("the graphs were %+v before they were opened", c)
+	}
+	if err := l.setupGraphDBs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, g := range l.graphs {
+			releaseMemoryGraph(g)
+		}
+	}()
+	if c := l.graphHealth(); !c.Ready || !c.Healthy {
+		t.Errorf("the opened graphs were %+v", c)
+	}
+
+	if c := l.sourceHealth(); c.Ready {
+		t.Errorf("the data sources were %+v before they were set", c)
+	}
+	if _, err := l.SetDataSources(nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := l.sourceHealth(); !c.Ready || !c.Healthy {
+		t.Errorf("the data sources were %+v once set", c)
+	}
+
+	// The reputation probes are warming the resolvers up
+	rep := &reputation{}
+	rep.probing.Store(true)
+	l.reputation = rep
+	if l.reputation.warmedUp() {
+		t.Error("the resolvers were warm while being probed")
+	}
+	rep.probing.Store(false)
+	if !l.reputation.warmedUp() {
+		t.Error("the resolvers were not warm once probed")
+	}
+}
@@ -5,6 +5,7 @@
 package systems
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -12,86 +13,185 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caffix/netmap"
 	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/blacklist"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/custom"
 	amassnet "github.com/owasp-amass/amass/v4/net"
 	"github.com/owasp-amass/amass/v4/requests"
 	"github.com/owasp-amass/amass/v4/resources"
+	"github.com/owasp-amass/amass/v4/snapshot"
+	"github.com/owasp-amass/amass/v4/transport"
 	"github.com/owasp-amass/config/config"
 	"github.com/owasp-amass/resolve"
 )
 
 // LocalSystem implements a System to be executed within a single process.
 type LocalSystem struct {
-	Cfg               *config.Config
-	pool              *resolve.Resolvers
-	trusted           *resolve.Resolvers
-	graphs            []*netmap.Graph
-	cache             *requests.ASNCache
-	done              chan struct{}
-	doneAlreadyClosed bool
-	addSource         chan service.Service
-	allSources        chan chan []service.Service
+	Cfg          *config.Config
+	pool         *resolve.Resolvers
+	trusted      *resolve.Resolvers
+	graphs       []*netmap.Graph
+	graphSystems []string
+	readGraphs   []*netmap.Graph
+	lock         *DirLock
+	cache        *requests.ASNCache
+	reputation   *reputation
+	shared       *SharedResolvers
+	fds          *fdBudget
+	done         chan struct{}
+	doneOnce     sync.Once
+	addSource    chan service.Service
+	allSources   chan chan []service.Service
+	startLock    sync.Mutex
+	progress     StartupProgress
+	sourcesSet   bool
+	stopStart    chan struct{}
+	starting     sync.WaitGroup
+	enumLock     sync.Mutex
+	enums        map[Enumeration]struct{}
+	filters      *NameFilters
+	traces       *NameTraces
+	health       *healthMonitor
 }
 
 // NewLocalSystem returns an initialized LocalSystem object.
 func NewLocalSystem(cfg *config.Config) (*LocalSystem, error) {
-	if err := cfg.CheckSettings(); err != nil {
+	return NewLocalSystemWithResolvers(cfg, nil)
+}
+
+// NewLocalSystemWithResolvers returns an initialized LocalSystem object that uses the shared resolver
+// pools, instead of building its own, when they are provided. Shutting down the System does not stop
+// the shared pools, and the queries sent by its enumerations are still limited by the configuration.
+func NewLocalSystemWithResolvers(cfg *config.Config, shared *SharedResolvers) (*LocalSystem, error) {
+	if err := checkSettings(cfg); err != nil {
 		return nil, err
 	}
-
-	trusted, num := trustedResolvers(cfg)
-	if trusted == nil || num == 0 {
-		return nil, errors.New("the system was unable to build the pool of trusted resolvers")
+	if err := runPreflight(cfg); err != nil {
+		return nil, err
 	}
 
-	pool, num := untrustedResolvers(cfg)
-	if pool == nil || num == 0 {
-		return nil, errors.New("the system was unable to build the pool of untrusted resolvers")
-	}
-	if cfg.MaxDNSQueries == 0 {
-		cfg.MaxDNSQueries += num * cfg.ResolversQPS
+	var rep *reputation
+	var pool, trusted *resolve.Resolvers
+	fds := newFDBudget(cfg.Log)
+	if shared != nil {
+		if err := shared.acquire(); err != nil {
+			return nil, err
+		}
+		// The rate of the shared pools is left to their creator, so this System accounts for its own share
+		pool, trusted = shared.Resolvers(), shared.TrustedResolvers()
+		if cfg.MaxDNSQueries == 0 {
+			cfg.MaxDNSQueries = pool.Len() * cfg.ResolversQPS
+		}
 	} else {
-		pool.SetMaxQPS(cfg.MaxDNSQueries)
+		rep = reputationFromConfig(cfg)
+
+		var err error
+		if pool, trusted, err = resolverPools(cfg, rep, fds.resolverShare(cfg)); err != nil {
+			return nil, err
+		}
 	}
-	// set a single name server rate limiter for both resolver pools
-	rate := resolve.NewRateTracker()
-	trusted.SetRateTracker(rate)
-	pool.SetRateTracker(rate)
+	fds.addResolvers(pool.Len()*resolverFDs(cfg), trusted.Len())
 
 	sys := &LocalSystem{
 		Cfg:        cfg,
 		pool:       pool,
 		trusted:    trusted,
 		cache:      requests.NewASNCache(),
+		reputation: rep,
+		shared:     shared,
+		fds:        fds,
 		done:       make(chan struct{}, 2),
 		addSource:  make(chan service.Service),
 		allSources: make(chan chan []service.Service, 10),
+		stopStart:  make(chan struct{}),
+		filters:    NewNameFilters(cfg.Log, DefaultFilterLatency),
+		traces:     NewNameTraces(DefaultTraceRecords),
 	}
+	sys.health = newHealthMonitor(cfg.Log, clock.System, sys.resolverHealth, sys.sourceHealth, sys.graphHealth)
 
 	// Load the ASN information into the cache
 	if err := sys.loadCacheData(); err != nil {
-		_ = sys.Shutdown()
+		sys.releaseResources()
 		return nil, err
 	}
 	// Make sure that the output directory is setup for this local system
 	if err := sys.setupOutputDirectory(); err != nil {
-		_ = sys.Shutdown()
+		sys.releaseResources()
 		return nil, err
 	}
 	// Setup the correct graph database handler
 	if err := sys.setupGraphDBs(cfg); err != nil {
-		_ = sys.Shutdown()
+		sys.releaseResources()
 		return nil, err
 	}
-
+	fds.addGraphs(len(sys.graphs))
+	// Background goroutines are only started once every fallible step has succeeded
 	go sys.manageDataSources()
+	sys.reputation.start(cfg.Resolvers)
+	go sys.watchHealth(DefaultHealthInterval)
 	return sys, nil
 }
 
+// runPreflight runs the preflight checks when the 'preflight' section is configured, and returns
+// a PreflightError when a hard check failed and the failures are fatal.
+func runPreflight(cfg *config.Config) error {
+	opts := preflightOptionsFromConfig(cfg)
+	if !opts.enabled {
+		return nil
+	}
+
+	report := Preflight(context.Background(), cfg)
+	for _, res := range report.Failures() {
+		cfg.Log.Printf("System: the preflight check %s failed (%s): %s; %s", res.Check, res.Code, res.Detail, res.Hint)
+	}
+	if err := report.Err(); err != nil && opts.fatal {
+		return err
+	}
+	return nil
+}
+
+// checkSettings returns a ConfigError naming the setting that the System cannot be built with.
+func checkSettings(cfg *config.Config) error {
+	if cfg.BruteForcing && cfg.Passive {
+		return &ConfigError{Field: "brute_forcing", Reason: "brute forcing cannot be performed without DNS resolution"}
+	}
+	if cfg.Active && cfg.Passive {
+		return &ConfigError{Field: "active", Reason: "active enumeration cannot be performed without DNS resolution"}
+	}
+	if err := cfg.CheckSettings(); err != nil {
+		return &ConfigError{Field: "wordlist", Reason: err.Error(), Err: err}
+	}
+	if _, err := SocketOptionsFromConfig(cfg); err != nil {
+		return err
+	}
+	// The sockets of the resolver pools are bound by the resolve package, so only the transport can honor the settings
+	if field := socketField(cfg); field != "" && transport.OptionsFromConfig(cfg) == nil {
+		return &ConfigError{Field: field, Reason: "the resolver sockets are only bound when the pipelined transport is enabled"}
+	}
+	if _, err := TTLBoundsFromConfig(cfg); err != nil {
+		return err
+	}
+	if _, err := blacklist.FromConfig(cfg); err != nil {
+		return &ConfigError{Field: "blacklist", Reason: err.Error(), Err: err}
+	}
+	if opts := transport.OptionsFromConfig(cfg); opts != nil && !transport.ValidSourceCheck(opts.SourceCheck) {
+		return &ConfigError{
+			Field:  "dns.source_check",
+			Reason: fmt.Sprintf("%q is neither %s nor %s", opts.SourceCheck, transport.SourceStrict, transport.SourceWarn),
+		}
+	}
+	return nil
+}
+
 // Config implements the System interface.
 func (l *LocalSystem) Config() *config.Config {
 	return l.Cfg
@@ -107,6 +207,56 @@ func (l *LocalSystem) TrustedResolvers() *resolve.Resolvers {
 	return l.trusted
 }
 
+// ObserveDisagreement implements the DisagreementObserver interface.
+func (l *LocalSystem) ObserveDisagreement(addr string) {
+	l.reputation.disagreement(addr)
+}
+
+// ObserveSourceMismatch implements the SourceMismatchObserver interface.
+func (l *LocalSystem) ObserveSourceMismatch(addr string) {
+	l.reputation.sourceMismatch(addr)
+}
+
+// AddNameFilter implements the NameFilterer interface.
+func (l *LocalSystem) AddNameFilter(f func(name string, src string) bool) {
+	l.filters.Add(f)
+}
+
+// FilterName implements the NameFilterer interface.
+func (l *LocalSystem) FilterName(name, src string) bool {
+	return l.filters.Allow(name, src)
+}
+
+// NameFilterStats implements the NameFilterer interface.
+func (l *LocalSystem) NameFilterStats() []NameFilterStats {
+	return l.filters.Stats()
+}
+
+// TraceName implements the NameTracer interface.
+func (l *LocalSystem) TraceName(pattern string) {
+	l.traces.Add(pattern)
+}
+
+// UntraceName implements the NameTracer interface.
+func (l *LocalSystem) UntraceName(pattern string) {
+	l.traces.Remove(pattern)
+}
+
+// Tracing implements the NameTracer interface.
+func (l *LocalSystem) Tracing(name string) bool {
+	return l.traces.Match(name)
+}
+
+// AddTrace implements the NameTracer interface.
+func (l *LocalSystem) AddTrace(rec TraceRecord) {
+	l.traces.Record(rec)
+}
+
+// Traces implements the NameTracer interface.
+func (l *LocalSystem) Traces(name string) []TraceRecord {
+	return l.traces.Records(name)
+}
+
 // Cache implements the System interface.
 func (l *LocalSystem) Cache() *requests.ASNCache {
 	return l.cache
@@ -114,7 +264,11 @@ func (l *LocalSystem) Cache() *requests.ASNCache {
 
 // AddSource implements the System interface.
 func (l *LocalSystem) AddSource(src service.Service) error {
-	l.addSource <- src
+	select {
+	case <-l.done:
+		return errors.New("the system has already been shutdown")
+	case l.addSource <- src:
+	}
 	return nil
 }
 
@@ -132,34 +286,265 @@ func (l *LocalSystem) AddAndStart(srv service.Service) error {
 func (l *LocalSystem) DataSources() []service.Service {
 	ch := make(chan []service.Service, 2)
 
-	l.allSources <- ch
-	return <-ch
+	select {
+	case <-l.done:
+		return nil
+	case l.allSources <- ch:
+	}
+
+	select {
+	case <-l.done:
+		return nil
+	case srcs := <-ch:
+		return srcs
+	}
+}
+
+// SetDataSources implements the System interface. The data sources are started in batches, and the
+// System can be used once the first batch is up, while the later batches join it in the background.
+// The failures of the later batches are reported by StartupProgress, since they happen after returning.
+func (l *LocalSystem) SetDataSources(sources []service.Service) (map[string]error, error) {
+	opts := startOptionsFromConfig(l.Cfg)
+	batches := opts.startBatches(sources)
+	// The HTTP connections of the data sources are fit into the descriptors left by the resolvers and graphs
+	l.fds.fitHTTP(len(sources), l.Cfg.Log)
+	l.updateProgress(func(p *StartupProgress) { p.Total += len(sources) })
+	l.startLock.Lock()
+	l.sourcesSet = true
+	l.startLock.Unlock()
+
+	// Any start failure is fatal for the compliance runs, so all the batches are started before returning
+	last := 1
+	if opts.fatal {
+		last = len(batches)
+	}
+	if last > len(batches) {
+		last = len(batches)
+	}
+
+	failures := make(map[string]error)
+	for i, batch := range batches[:last] {
+		if i > 0 && !l.waitBatchDelay(opts) {
+			l.abandonBatches(batches[i:])
+			return failures, errors.New("the system was shut down before the data sources started")
+		}
+		if err := l.startBatch(batch, opts, failures); err != nil {
+			l.abandonBatches(batches[i+1:])
+			return failures, err
+		}
+	}
+
+	if rest := batches[last:]; len(rest) > 0 {
+		l.starting.Add(1)
+		go l.startLaterBatches(rest, opts)
+	}
+	if opts.fatal && len(failures) > 0 {
+		return failures, startFailuresError(failures)
+	}
+	return failures, nil
+}
+
+// StartupProgress implements the System interface.
+func (l *LocalSystem) StartupProgress() StartupProgress {
+	l.startLock.Lock()
+	defer l.startLock.Unlock()
+
+	p := l.progress
+	if len(p.Failures) > 0 {
+		p.Failures = make(map[string]string, len(l.progress.Failures))
+		for name, err := range l.progress.Failures {
+			p.Failures[name] = err
+		}
+	}
+	return p
+}
+
+// startLaterBatches starts the remaining batches after the first, and the data sources join the running System.
+func (l *LocalSystem) startLaterBatches(batches [][]service.Service, opts startOptions) {
+	defer l.starting.Done()
+
+	failures := make(map[string]error)
+	for i, batch := range batches {
+		if !l.waitBatchDelay(opts) {
+			l.abandonBatches(batches[i:])
+			return
+		}
+		if err := l.startBatch(batch, opts, failures); err != nil {
+			l.Cfg.Log.Printf("System: %v", err)
+			l.abandonBatches(batches[i+1:])
+			return
+		}
+	}
 }
 
-// SetDataSources assigns the data sources that will be used by the system.
-func (l *LocalSystem) SetDataSources(sources []service.Service) error {
-	ch := make(chan error, len(sources))
+// startBatch starts the data sources of the batch together, adds those that started to the System,
+// and records the others in the failures.
+func (l *LocalSystem) startBatch(batch []service.Service, opts startOptions, failures map[string]error) error {
+	type result struct {
+		name string
+		err  error
+	}
+
+	ch := make(chan result, len(batch))
+	pending := make(map[string]struct{}, len(batch))
+	delays := opts.startDelays(len(batch))
 	// Add all the data sources that successfully start to the list
-	for _, src := range sources {
-		go func(src service.Service, ch chan error) {
-			ch <- l.AddAndStart(src)
-		}(src, ch)
+	for i, src := range batch {
+		pending[src.String()] = struct{}{}
+
+		go func(src service.Service, delay time.Duration, ch chan result) {
+			err := startSource(src, opts, delay, l.stopStart)
+			if err == nil {
+				err = l.AddSource(src)
+			}
+			ch <- result{name: src.String(), err: sourceError(src.String(), err)}
+		}(src, delays[i], ch)
 	}
 
-	t := time.NewTimer(time.Minute)
+	t := time.NewTimer(startTimeout)
 	defer t.Stop()
 
-	var err error
-loop:
-	for i := 0; i < len(sources); i++ {
+	for i := 0; i < len(batch); i++ {
 		select {
 		case <-t.C:
-			err = errors.New("the data source startup routines timed out")
-			break loop
-		case <-ch:
+			for name := range pending {
+				failures[name] = &SourceError{Name: name, Temporary: true, Err: errors.New("the startup timed out")}
+			}
+			l.updateProgress(func(p *StartupProgress) {
+				for name := range pending {
+					p.fail(name, failures[name])
+				}
+			})
+			return errors.New("the data source startup routines timed out")
+		case r := <-ch:
+			delete(pending, r.name)
+			if r.err != nil {
+				failures[r.name] = r.err
+				l.Cfg.Log.Printf("System: %v", r.err)
+			}
+			l.updateProgress(func(p *StartupProgress) {
+				if r.err != nil {
+					p.fail(r.name, r.err)
+				} else {
+					p.Started++
+				}
+			})
 		}
 	}
-	return err
+	return nil
+}
+
+// waitBatchDelay waits before the next batch is started, and returns false when the System has been shut down.
+func (l *LocalSystem) waitBatchDelay(opts startOptions) bool {
+	if opts.batchDelay <= 0 {
+		select {
+		case <-l.stopStart:
+			return false
+		default:
+			return true
+		}
+	}
+
+	select {
+	case <-l.stopStart:
+		return false
+	case <-opts.clock.After(opts.batchDelay):
+	}
+	return true
+}
+
+// abandonBatches counts the data sources of the batches that will not be started as failed.
+func (l *LocalSystem) abandonBatches(batches [][]service.Service) {
+	err := errors.New("the system was shut down before the data source started")
+
+	l.updateProgress(func(p *StartupProgress) {
+		for _, batch := range batches {
+			for _, src := range batch {
+				p.fail(src.String(), err)
+			}
+		}
+	})
+}
+
+func (l *LocalSystem) updateProgress(update func(p *StartupProgress)) {
+	l.startLock.Lock()
+	defer l.startLock.Unlock()
+
+	update(&l.progress)
+}
+
+// StartEnumeration implements the System interface. The enumerations share the resolver pools, data
+// sources and graph databases of the System, and the 'max_enumerations' option limits how many run at once.
+func (l *LocalSystem) StartEnumeration(ctx context.Context, scope Scope) (Enumeration, error) {
+	select {
+	case <-l.done:
+		return nil, errors.New("the system has already been shutdown")
+	default:
+	}
+
+	cfg, err := scopeConfig(l.Cfg, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	l.enumLock.Lock()
+	defer l.enumLock.Unlock()
+
+	if len(l.enums) >= maxEnumerations(l.Cfg) {
+		return nil, ErrEnumerationLimit
+	}
+
+	e, err := startEnumeration(ctx, l, cfg, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.enums == nil {
+		l.enums = make(map[Enumeration]struct{})
+	}
+	l.enums[e] = struct{}{}
+	go func() {
+		<-e.Done()
+		l.enumLock.Lock()
+		delete(l.enums, e)
+		l.enumLock.Unlock()
+	}()
+	return e, nil
+}
+
+// Verify implements the System interface. The names are checked against the scope of the System, and
+// resolved with the resolver pools shared by the enumerations.
+func (l *LocalSystem) Verify(ctx context.Context, names []string) (*Verification, error) {
+	select {
+	case <-l.done:
+		return nil, errors.New("the system has already been shutdown")
+	default:
+	}
+
+	cfg, err := scopeConfig(l.Cfg, ScopeFromConfig(l.Cfg))
+	if err != nil {
+		return nil, err
+	}
+	return verifyNames(ctx, l, cfg, names)
+}
+
+// Enumerations implements the EnumerationLister interface.
+func (l *LocalSystem) Enumerations() []Enumeration {
+	l.enumLock.Lock()
+	defer l.enumLock.Unlock()
+
+	enums := make([]Enumeration, 0, len(l.enums))
+	for e := range l.enums {
+		enums = append(enums, e)
+	}
+	return enums
+}
+
+// stopEnumerations stops the enumerations started by the System and waits for them to be done.
+func (l *LocalSystem) stopEnumerations() {
+	for _, e := range l.Enumerations() {
+		e.Stop()
+	}
 }
 
 // GraphDatabases implements the System interface.
@@ -167,36 +552,84 @@ func (l *LocalSystem) GraphDatabases() []*netmap.Graph {
 	return l.graphs
 }
 
+// ReadGraphDatabases implements the System interface.
+func (l *LocalSystem) ReadGraphDatabases() []*netmap.Graph {
+	return l.readGraphs
+}
+
+// GraphSystem implements the System interface.
+func (l *LocalSystem) GraphSystem(g *netmap.Graph) string {
+	for i, graph := range l.graphs {
+		if graph == g && i < len(l.graphSystems) {
+			return l.graphSystems[i]
+		}
+	}
+	return ""
+}
+
 // Shutdown implements the System interface.
 func (l *LocalSystem) Shutdown() error {
-	if l.doneAlreadyClosed {
-		return nil
-	}
-	l.doneAlreadyClosed = true
+	l.doneOnce.Do(func() {
+		l.health.stop("the system was shut down")
+		// The lock is removed even when stopping the data sources panics
+		defer func() { _ = l.lock.Release() }()
+		// The enumerations are stopped while the data sources and resolvers are still running
+		l.stopEnumerations()
+		// The batches waiting to start are abandoned before the data sources are collected
+		if l.stopStart != nil {
+			close(l.stopStart)
+		}
+		l.starting.Wait()
 
-	var wg sync.WaitGroup
-	for _, src := range l.DataSources() {
-		wg.Add(1)
+		var wg sync.WaitGroup
+		// The data sources must be collected before the done channel is closed
+		for _, src := range l.DataSources() {
+			wg.Add(1)
 
-		go func(s service.Service, w *sync.WaitGroup) {
-			defer w.Done()
-			_ = s.Stop()
-		}(src, &wg)
-	}
+			go func(s service.Service, w *sync.WaitGroup) {
+				defer w.Done()
+				_ = s.Stop()
+			}(src, &wg)
+		}
 
-	wg.Wait()
+		wg.Wait()
+		// The reputation is saved while the output directory is still locked
+		l.reputation.stop()
+		if err := l.reputation.save(); err != nil {
+			l.Cfg.Log.Printf("System: %v", err)
+		}
+		l.releaseResources()
+	})
+	return nil
+}
+
+// releaseResources closes the done channel and stops everything acquired during construction.
+// It does not depend on the data source manager, so it is safe to use when NewLocalSystem fails.
+func (l *LocalSystem) releaseResources() {
 	close(l.done)
-	for range l.GraphDatabases() {
-		//g.Close()
+	for _, g := range l.GraphDatabases() {
+		cursor.Unregister(g)
+		custom.Unregister(g)
+		UnregisterAssetStore(g)
+		releaseMemoryGraph(g)
 	}
 
-	l.pool.Stop()
-	l.trusted.Stop()
+	if l.shared != nil {
+		l.shared.release()
+	} else {
+		l.pool.Stop()
+		l.trusted.Stop()
+	}
 	l.cache = nil
-	return nil
+	_ = l.lock.Release()
 }
 
 func (l *LocalSystem) setupOutputDirectory() error {
+	// The memory graph leaves nothing to be written in the output directory
+	if memoryGraphSelected(l.Cfg) {
+		return nil
+	}
+
 	path := config.OutputDirectory(l.Cfg.Dir)
 	if path == "" {
 		return nil
@@ -213,35 +646,207 @@ func (l *LocalSystem) setupOutputDirectory() error {
 
 // Select the graph that will store the System findings.
 func (l *LocalSystem) setupGraphDBs(cfg *config.Config) error {
-	// Add the local database settings to the configuration
+	if memoryGraphSelected(cfg) {
+		// Without an output directory or a remote graph, the findings are kept in memory
+		cfg.GraphDBs = []*config.Database{memoryDatabaseSettings()}
+	} else {
+		// Add the local database settings to the configuration
+		cfg.GraphDBs = append(cfg.GraphDBs, cfg.LocalDatabaseSettings(cfg.GraphDBs))
+	}
+
+	primary := primaryDatabase(cfg)
+	if primary == nil {
+		return &ConfigError{Field: "database", Reason: "no primary databases found to create the graph"}
+	}
+
+	g, dsn, err := l.openGraphDB(cfg, primary)
+	if err != nil {
+		return err
+	}
+	// The fragments left behind by a previous run that was interrupted mid-write are removed before any
+	// enumeration can write to the graph. Only the local database is repaired, since the lock of the output
+	// directory keeps the other processes from writing to it, while a remote database can be shared.
+	if primary.System == "local" && l.lock != nil {
+		if n, err := RepairOrphans(g, repairEvents(cfg)...); err != nil {
+			cfg.Log.Printf("System: failed to repair the graph database: %v", err)
+		} else if n > 0 {
+			cfg.Log.Printf("System: removed %d incomplete assets from the graph database", n)
+		}
+	}
+	l.graphs = append(l.graphs, g)
+	l.graphSystems = append(l.graphSystems, primary.System)
+	// The other databases are only read from, and failing to open them does not stop the enumeration
+	opened := map[string]struct{}{primary.System + dsn: {}}
+	for _, db := range cfg.GraphDBs {
+		if db == primary {
+			continue
+		}
+		if _, found := opened[db.System+graphDSN(cfg, db)]; found {
+			continue
+		}
+
+		g, dsn, err := l.openGraphDB(cfg, db)
+		if err != nil {
+			cfg.Log.Printf("System: the %s graph database will not be read: %v", db.System, err)
+			continue
+		}
+		l.graphs = append(l.graphs, g)
+		l.graphSystems = append(l.graphSystems, db.System)
+		opened[db.System+dsn] = struct{}{}
+	}
+
+	return l.selectReadGraphs(cfg)
+}
+
+// OpenPrimaryGraph opens the primary graph database without the rest of the System, such as for changing
+// the findings of a stored event. The returned function releases the graph and the output directory lock.
+func OpenPrimaryGraph(cfg *config.Config) (*netmap.Graph, func(), error) {
 	cfg.GraphDBs = append(cfg.GraphDBs, cfg.LocalDatabaseSettings(cfg.GraphDBs))
 
+	primary := primaryDatabase(cfg)
+	if primary == nil {
+		return nil, nil, &ConfigError{Field: "database", Reason: "no primary databases found to create the graph"}
+	}
+
+	l := &LocalSystem{Cfg: cfg}
+	g, _, err := l.openGraphDB(cfg, primary)
+	if err != nil {
+		_ = l.lock.Release()
+		return nil, nil, err
+	}
+
+	return g, func() {
+		cursor.Unregister(g)
+		custom.Unregister(g)
+		UnregisterAssetStore(g)
+		_ = l.lock.Release()
+	}, nil
+}
+
+func primaryDatabase(cfg *config.Config) *config.Database {
 	for _, db := range cfg.GraphDBs {
 		if db.Primary {
-			var g *netmap.Graph
+			return db
+		}
+	}
+	return nil
+}
 
-			if db.System == "local" {
-				g = netmap.NewGraph(db.System, filepath.Join(config.OutputDirectory(cfg.Dir), "amass.sqlite"), db.Options)
-			} else {
-				connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s", db.Host, db.Port, db.Username, db.Password, db.DBName)
-				g = netmap.NewGraph(db.System, connStr, db.Options)
-			}
+// openGraphDB returns the graph for the database and the data source name used to open it.
+func (l *LocalSystem) openGraphDB(cfg *config.Config, db *config.Database) (*netmap.Graph, string, error) {
+	if db.System == MemoryGraphSystem {
+		g, dsn, err := newMemoryGraph()
+		if err != nil {
+			return nil, "", err
+		}
+		if p, err := cursor.NewSQLPager("local", dsn); err == nil {
+			cursor.Register(g, p)
+		}
+		if s, err := custom.Open("local", dsn); err == nil {
+			custom.Register(g, s)
+		}
+		_ = RegisterAssetStore(g, "local", dsn)
+		return g, dsn, nil
+	}
+	if db.System == "local" && l.lock == nil {
+		// Two processes writing to the same local database corrupt it
+		lock, err := LockDirectory(config.OutputDirectory(cfg.Dir), forceOption(cfg))
+		if err != nil {
+			return nil, "", err
+		}
+		l.lock = lock
+	}
 
-			if g == nil {
-				return fmt.Errorf("System: failed to create the graph for database: %s", db.System)
-			}
+	dsn := graphDSN(cfg, db)
+	g := netmap.NewGraph(db.System, dsn, db.Options)
+	if g == nil {
+		return nil, "", fmt.Errorf("%w: failed to create the graph for the %s database", ErrGraphUnavailable, db.System)
+	}
+	// Exports page through the findings instead of loading them all at once
+	if p, err := cursor.NewSQLPager(db.System, dsn); err == nil {
+		cursor.Register(g, p)
+	} else {
+		cfg.Log.Printf("System: %v", err)
+	}
+	// The custom node types and edge predicates are stored through a separate connection
+	if s, err := custom.Open(db.System, dsn); err == nil {
+		custom.Register(g, s)
+	} else {
+		cfg.Log.Printf("System: %v", err)
+	}
+	// The related writes of each asset are applied together through a separate connection
+	if err := RegisterAssetStore(g, db.System, dsn); err != nil {
+		cfg.Log.Printf("System: %v", err)
+	}
+	return g, dsn, nil
+}
+
+// repairEvents returns the root domain names of the configuration and of the events recorded by the
+// configuration snapshots in the output directory, which the names stored in the graph belong to.
+func repairEvents(cfg *config.Config) []string {
+	events := cfg.Domains()
 
-			l.graphs = append(l.graphs, g)
-			break
+	dir := filepath.Join(config.OutputDirectory(cfg.Dir), snapshot.DirName)
+	if _, err := os.Stat(dir); err != nil {
+		return events
+	}
+	store, err := snapshot.Open(dir)
+	if err != nil {
+		return events
+	}
+	if snaps, err := store.List(); err == nil {
+		for _, snap := range snaps {
+			events = append(events, snap.Domains...)
 		}
 	}
+	return events
+}
+
+func graphDSN(cfg *config.Config, db *config.Database) string {
+	if db.System == "local" {
+		return filepath.Join(config.OutputDirectory(cfg.Dir), "amass.sqlite")
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s", db.Host, db.Port, db.Username, db.Password, db.DBName)
+}
+
+// selectReadGraphs applies the 'read_database' configuration option, which pins the reads to a single database system.
+func (l *LocalSystem) selectReadGraphs(cfg *config.Config) error {
+	var pin string
+	if cfg.Options != nil {
+		pin, _ = cfg.Options["read_database"].(string)
+	}
 
-	if len(l.graphs) == 0 {
-		return errors.New("System: no primary databases found to create the graph")
+	var systems []string
+	for i, g := range l.graphs {
+		if pin == "" || strings.EqualFold(pin, l.graphSystems[i]) {
+			l.readGraphs = append(l.readGraphs, g)
+			systems = append(systems, l.graphSystems[i])
+		}
+	}
+	if len(l.readGraphs) == 0 {
+		return &ConfigError{Field: "read_database", Reason: fmt.Sprintf("the %s graph database selected for reading is not configured", pin)}
+	}
+	if len(l.readGraphs) > 1 {
+		cfg.Log.Printf("System: reading the findings from the graph databases %s", strings.Join(systems, ", "))
 	}
 	return nil
 }
 
+// forceOption returns true when the 'force' configuration option allows stale locks to be broken.
+func forceOption(cfg *config.Config) bool {
+	if cfg == nil || cfg.Options == nil {
+		return false
+	}
+
+	force, _ := cfg.Options["force"].(bool)
+	return force
+}
+
+// FileDescriptors implements the System interface.
+func (l *LocalSystem) FileDescriptors() FDUsage {
+	return l.fds.usage()
+}
+
 // GetMemoryUsage returns the number bytes allocated to heap objects on this system.
 func (l *LocalSystem) GetMemoryUsage() uint64 {
 	var m runtime.MemStats
@@ -294,14 +899,92 @@ func (l *LocalSystem) loadCacheData() error {
 	return nil
 }
 
+// resolverPools builds the untrusted and trusted resolver pools, which share a single name server rate tracker.
+// The untrusted pool is limited to max resolvers when max is greater than zero.
+func resolverPools(cfg *config.Config, rep *reputation, max int) (*resolve.Resolvers, *resolve.Resolvers, error) {
+	// The untrusted pool is built first, since it learns whether the public resolvers are reachable
+	pool, num := untrustedResolvers(cfg, rep, max)
+	if pool == nil || num == 0 {
+		if pool != nil {
+			pool.Stop()
+		}
+		return nil, nil, fmt.Errorf("%w: the system was unable to build the pool of untrusted resolvers", ErrNoResolvers)
+	}
+
+	trusted, tnum := trustedResolvers(cfg)
+	if trusted == nil || tnum == 0 {
+		pool.Stop()
+		if trusted != nil {
+			trusted.Stop()
+		}
+		return nil, nil, fmt.Errorf("%w: the system was unable to build the pool of trusted resolvers", ErrNoResolvers)
+	}
+	if cfg.MaxDNSQueries == 0 {
+		cfg.MaxDNSQueries += num * cfg.ResolversQPS
+	} else {
+		pool.SetMaxQPS(cfg.MaxDNSQueries)
+	}
+	// set a single name server rate limiter for both resolver pools
+	rate := resolve.NewRateTracker()
+	trusted.SetRateTracker(rate)
+	pool.SetRateTracker(rate)
+	return pool, trusted, nil
+}
+
+// TrustedResolversFromConfig returns the trusted resolvers confirming the answers of the untrusted resolvers
+// before they are stored. The resolvers provided on the command line take precedence over the
+// 'trusted_resolvers' option, which lists addresses and files of addresses relative to the config directory.
+// It returns no resolvers when the trusted tier was not configured.
+func TrustedResolversFromConfig(cfg *config.Config) ([]string, error) {
+	if len(cfg.TrustedResolvers) > 0 {
+		return cfg.TrustedResolvers, nil
+	}
+
+	var entries []interface{}
+	switch v := cfg.Options["trusted_resolvers"].(type) {
+	case nil:
+	case []interface{}:
+		entries = v
+	default:
+		entries = []interface{}{v}
+	}
+
+	var trusted []string
+	for _, v := range entries {
+		entry := strings.TrimSpace(fmt.Sprint(v))
+		if len(checkAddresses([]string{entry})) > 0 {
+			trusted = append(trusted, entry)
+			continue
+		}
+
+		path, err := cfg.AbsPathFromConfigDir(entry)
+		if err != nil {
+			return trusted, &ConfigError{Field: "trusted_resolvers", Reason: err.Error(), Err: err}
+		}
+		list, err := config.GetListFromFile(path)
+		if err != nil {
+			return trusted, &ConfigError{Field: "trusted_resolvers", Reason: fmt.Sprintf("failed to read %s", path), Err: err}
+		}
+		trusted = append(trusted, list...)
+	}
+	return trusted, nil
+}
+
 func trustedResolvers(cfg *config.Config) (*resolve.Resolvers, int) {
 	pool := resolve.NewResolvers()
-	trusted := config.DefaultBaselineResolvers
-	if len(cfg.TrustedResolvers) > 0 {
-		trusted = cfg.TrustedResolvers
+	trusted, err := TrustedResolversFromConfig(cfg)
+	if err != nil {
+		cfg.Log.Printf("System: %v", err)
+	}
+	// Without access to the public resolvers, the baseline resolvers are likely unreachable as well
+	if len(trusted) == 0 && publicUnavailable.Load() {
+		trusted = systemResolverAddrs(cfg)
+	}
+	if len(trusted) == 0 {
+		trusted = config.DefaultBaselineResolvers
 	}
 
-	_ = pool.AddResolvers(cfg.TrustedQPS, trusted...)
+	_ = pool.AddResolvers(cfg.TrustedQPS, checkAddresses(trusted)...)
 	pool.SetDetectionResolver(cfg.TrustedQPS, "8.8.8.8")
 
 	pool.SetLogger(cfg.Log)
@@ -309,15 +992,35 @@ func trustedResolvers(cfg *config.Config) (*resolve.Resolvers, int) {
 	return pool, pool.Len()
 }
 
-func untrustedResolvers(cfg *config.Config) (*resolve.Resolvers, int) {
+// NewResolverPool returns the pool of untrusted resolvers selected by the configuration.
+func NewResolverPool(cfg *config.Config) *resolve.Resolvers {
+	pool, _ := untrustedResolvers(cfg, reputationFromConfig(cfg), 0)
+	return pool
+}
+
+// untrustedResolvers builds the pool of untrusted resolvers, leaving out those with a bad reputation from earlier runs.
+// The best ranked resolvers are kept when the pool is limited to max resolvers.
+func untrustedResolvers(cfg *config.Config, rep *reputation, max int) (*resolve.Resolvers, int) {
 	if len(cfg.Resolvers) == 0 {
 		cfg.Resolvers = publicResolverAddrs(cfg)
 		if len(cfg.Resolvers) == 0 {
-			// Failed to use the public DNS resolvers database
+			// Failed to use the public DNS resolvers database, as happens on networks without internet access
+			cfg.Resolvers = systemResolverAddrs(cfg)
+		}
+		if len(cfg.Resolvers) == 0 {
 			cfg.Resolvers = config.DefaultBaselineResolvers
 		}
 	}
 	cfg.Resolvers = checkAddresses(cfg.Resolvers)
+	ranked, removed := rep.rank(cfg.Resolvers)
+	if removed > 0 {
+		cfg.Log.Printf("System: %d untrusted resolvers were left out for their reputation in earlier runs", removed)
+	}
+	cfg.Resolvers = ranked
+	if max > 0 && len(cfg.Resolvers) > max {
+		cfg.Log.Printf("System: the untrusted resolvers were reduced from %d to %d to fit the open file limit", len(cfg.Resolvers), max)
+		cfg.Resolvers = cfg.Resolvers[:max]
+	}
 
 	pool := resolve.NewResolvers()
 	pool.SetLogger(cfg.Log)
@@ -338,6 +1041,9 @@ func untrustedResolvers(cfg *config.Config) (*resolve.Resolvers, int) {
 	return pool, pool.Len()
 }
 
+// publicUnavailable is set once the public DNS resolvers database could not be obtained.
+var publicUnavailable atomic.Bool
+
 func publicResolverAddrs(cfg *config.Config) []string {
 	addrs := config.PublicResolvers
 
@@ -346,22 +1052,28 @@ func publicResolverAddrs(cfg *config.Config) []string {
 			cfg.Log.Printf("%v", err)
 		}
 		addrs = config.PublicResolvers
+		publicUnavailable.Store(len(addrs) == 0)
 	}
 	return addrs
 }
 
+// checkAddresses returns the resolver addresses with their port numbers, such as 10.0.0.53:5353,
+// adding the default DNS port to the bare IP addresses and leaving out the invalid entries.
 func checkAddresses(addrs []string) []string {
 	ips := []string{}
 
 	for _, addr := range addrs {
-		ip, port, err := net.SplitHostPort(addr)
+		ip, port, err := net.SplitHostPort(strings.TrimSpace(addr))
 		if err != nil {
-			ip = addr
+			ip = strings.TrimSpace(addr)
 			port = "53"
 		}
 		if net.ParseIP(ip) == nil {
 			continue
 		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			continue
+		}
 		ips = append(ips, net.JoinHostPort(ip, port))
 	}
 	return ips
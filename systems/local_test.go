@@ -5,8 +5,24 @@
 package systems
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/amass/v4/custom"
+	"github.com/owasp-amass/config/config"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
 )
 
 func TestCheckAddresses(t *testing.T) {
@@ -40,6 +56,16 @@ func TestCheckAddresses(t *testing.T) {
 			addr:     []string{"300.300.300.300:53"},
 			expected: []string{},
 		},
+		{
+			name:     "Nonstandard ports",
+			addr:     []string{"10.0.0.53:5353", "[2001:db8::53]:5353", "2001:db8::1"},
+			expected: []string{"10.0.0.53:5353", "[2001:db8::53]:5353", "[2001:db8::1]:53"},
+		},
+		{
+			name:     "Invalid port",
+			addr:     []string{"10.0.0.53:0", "10.0.0.54:65536", "10.0.0.55:dns"},
+			expected: []string{},
+		},
 		{
 			name:     "Multiple IPs, valid and invalid",
 			addr:     []string{"192.168.61.221", "NotAnIP:80", "111.111.111.111:111"},
@@ -56,3 +82,147 @@ func TestCheckAddresses(t *testing.T) {
 		})
 	}
 }
+
+func TestTrustedResolversFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "trusted.txt"), []byte("192.0.2.2\n192.0.2.3:5353\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Filepath = filepath.Join(dir, "config.yaml")
+	if trusted, err := TrustedResolversFromConfig(cfg); err != nil || len(trusted) != 0 {
+		t.Errorf("the trusted tier was configured without the option: %v, %v", trusted, err)
+	}
+
+	cfg.Options = map[string]interface{}{"trusted_resolvers": []interface{}{"192.0.2.1", "trusted.txt"}}
+	expected := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3:5353"}
+	trusted, err := TrustedResolversFromConfig(cfg)
+	// The entries of a file are read without keeping their order
+	sort.Strings(trusted)
+	if err != nil || !reflect.DeepEqual(trusted, expected) {
+		t.Errorf("the trusted resolvers were %v, %v, expected %v", trusted, err, expected)
+	}
+
+	cfg.Options = map[string]interface{}{"trusted_resolvers": "missing.txt"}
+	var cerr *ConfigError
+	if _, err := TrustedResolversFromConfig(cfg); !errors.As(err, &cerr) || cerr.Field != "trusted_resolvers" {
+		t.Errorf("the missing file returned the error %v", err)
+	}
+
+	// The resolvers provided on the command line take precedence
+	cfg.TrustedResolvers = []string{"198.51.100.1"}
+	if trusted, err := TrustedResolversFromConfig(cfg); err != nil || !reflect.DeepEqual(trusted, cfg.TrustedResolvers) {
+		t.Errorf("the trusted resolvers were %v, %v", trusted, err)
+	}
+}
+
+func TestNewLocalSystemFailureLeaksNothing(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Resolvers = []string{"8.8.8.8"}
+	cfg.TrustedResolvers = []string{"8.8.8.8"}
+	cfg.GraphDBs = []*config.Database{{System: "invalid", Primary: true}}
+
+	before := runtime.NumGoroutine()
+	if sys, err := NewLocalSystem(cfg); err == nil {
+		_ = sys.Shutdown()
+		t.Fatal("NewLocalSystem succeeded with an invalid graph database configuration")
+	}
+	// Give the stopped resolver pools a moment to observe the shutdown
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(5 * time.Second); after > before && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	if after > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("NewLocalSystem leaked %d goroutines\n%s", after-before, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestSelectReadGraphs(t *testing.T) {
+	primary, local := &netmap.Graph{}, &netmap.Graph{}
+
+	tests := []struct {
+		pin      string
+		expected []*netmap.Graph
+		err      bool
+	}{
+		{"", []*netmap.Graph{primary, local}, false},
+		{"Local", []*netmap.Graph{local}, false},
+		{"postgres", []*netmap.Graph{primary}, false},
+		{"cayley", nil, true},
+	}
+	for _, test := range tests {
+		cfg := config.NewConfig()
+		if test.pin != "" {
+			cfg.Options["read_database"] = test.pin
+		}
+
+		l := &LocalSystem{
+			graphs:       []*netmap.Graph{primary, local},
+			graphSystems: []string{"postgres", "local"},
+		}
+		err := l.selectReadGraphs(cfg)
+		if (err != nil) != test.err {
+			t.Errorf("pinning the reads to %q returned the error %v", test.pin, err)
+		}
+		graphs := l.ReadGraphDatabases()
+		if len(graphs) != len(test.expected) {
+			t.Errorf("pinning the reads to %q selected %d graphs, expected %d", test.pin, len(graphs), len(test.expected))
+			continue
+		}
+		for i, g := range graphs {
+			if g != test.expected[i] {
+				t.Errorf("pinning the reads to %q selected the wrong graph at %d", test.pin, i)
+			}
+		}
+	}
+}
+
+func TestSetupGraphDBsRepairsLocalStore(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Log = log.New(io.Discard, "", 0)
+	cfg.AddDomain("owasp.org")
+
+	// The fragments left by a previous run that was interrupted mid-write
+	g := netmap.NewGraph("local", filepath.Join(config.OutputDirectory(cfg.Dir), "amass.sqlite"), "")
+	if g == nil {
+		t.Fatal("failed to create the local graph")
+	}
+	ctx := context.Background()
+	for _, name := range []string{"mail.owasp.org", "ns1.example.com"} {
+		if _, err := g.UpsertFQDN(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := g.UpsertAddress(ctx, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &LocalSystem{Cfg: cfg}
+	if err := l.setupGraphDBs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, g := range l.graphs {
+			cursor.Unregister(g)
+			custom.Unregister(g)
+			UnregisterAssetStore(g)
+		}
+		_ = l.lock.Release()
+	}()
+
+	primary := l.GraphDatabases()[0]
+	if n := countAssets(primary, oam.IPAddress); n != 0 {
+		t.Errorf("the local store kept %d orphaned addresses", n)
+	}
+	// The names of the event are kept, while the name outside of it lost its edge
+	for name, want := range map[string]int{"mail.owasp.org": 1, "ns1.example.com": 0} {
+		if assets, err := primary.DB.FindByContent(&domain.FQDN{Name: name}, time.Time{}); err != nil || len(assets) != want {
+			t.Errorf("expected %d assets for %s after the repair, got %d: %v", want, name, len(assets), err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LockFile is the name of the file that marks the output directory as in use by a process.
+const LockFile = "amass.lock"
+
+// LockedError is returned when the output directory is held by another process.
+type LockedError struct {
+	Path string
+	PID  int
+	// Stale is true when the process holding the lock is no longer running
+	Stale bool
+}
+
+// Error implements the error interface.
+func (e *LockedError) Error() string {
+	if e.Stale {
+		return fmt.Sprintf("the output directory is locked by process %d, which is no longer running: "+
+			"set the force option or remove %s", e.PID, e.Path)
+	}
+	return fmt.Sprintf("the output directory is in use by process %d, which holds %s", e.PID, e.Path)
+}
+
+// Is reports the locked output directory as an unavailable graph database.
+func (e *LockedError) Is(target error) bool {
+	return target == ErrGraphUnavailable
+}
+
+// DirLock is an advisory lock on an output directory, shared by the systems within a process.
+type DirLock struct {
+	path string
+	pid  int
+	once sync.Once
+}
+
+type heldLock struct {
+	pid  int
+	refs int
+}
+
+var (
+	lockMu    sync.Mutex
+	heldLocks = make(map[string]*heldLock)
+)
+
+// LockDirectory acquires the lock on the output directory for this process.
+// A lock left behind by a process that is no longer running is only broken when force is true.
+func LockDirectory(dir string, force bool) (*DirLock, error) {
+	return lockDirectory(dir, os.Getpid(), force)
+}
+
+func lockDirectory(dir string, pid int, force bool) (*DirLock, error) {
+	path := filepath.Join(dir, LockFile)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	// The systems within the same process share the lock
+	if h, found := heldLocks[path]; found && h.pid == pid {
+		h.refs++
+		return &DirLock{path: path, pid: pid}, nil
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		err := createLockFile(path, pid)
+		if err == nil {
+			heldLocks[path] = &heldLock{pid: pid, refs: 1}
+			return &DirLock{path: path, pid: pid}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create the lock file %s: %v", path, err)
+		}
+
+		owner, err := readLockFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// The lock was released after the attempt to create it
+			continue
+		}
+		// A lock file from this PID that is not held was left by an earlier process with the same PID
+		if err == nil && owner != pid && processAlive(owner) {
+			return nil, &LockedError{Path: path, PID: owner}
+		}
+		if !force {
+			return nil, &LockedError{Path: path, PID: owner, Stale: true}
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to break the stale lock file %s: %v", path, err)
+		}
+	}
+	return nil, fmt.Errorf("failed to acquire the lock file %s", path)
+}
+
+// createLockFile writes the PID to a temporary file and links it into place, so the lock
+// file never exists without the PID of its holder.
+func createLockFile(path string, pid int) error {
+	tmp := fmt.Sprintf("%s.%d", path, pid)
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Link(tmp, path)
+}
+
+func readLockFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Release removes the lock file once every system of the process sharing the lock has released it.
+func (l *DirLock) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	var err error
+	l.once.Do(func() {
+		lockMu.Lock()
+		defer lockMu.Unlock()
+
+		if h, found := heldLocks[l.path]; found && h.pid == l.pid {
+			if h.refs--; h.refs > 0 {
+				return
+			}
+			delete(heldLocks, l.path)
+		}
+		// Only the lock file written by this holder is removed
+		if owner, e := readLockFile(l.path); e == nil && owner == l.pid {
+			err = os.Remove(l.path)
+		}
+	})
+	return err
+}
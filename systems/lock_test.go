@@ -0,0 +1,156 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/caffix/service"
+)
+
+// deadPID returns the PID of a process that has exited.
+func deadPID(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestConcurrentLocks(t *testing.T) {
+	dir := t.TempDir()
+	// The test process and its parent are both alive, so they stand in for separate processes
+	pids := []int{os.Getpid(), os.Getppid()}
+
+	var wg sync.WaitGroup
+	locks := make([]*DirLock, len(pids))
+	errs := make([]error, len(pids))
+	for i, pid := range pids {
+		wg.Add(1)
+		go func(i, pid int) {
+			defer wg.Done()
+			locks[i], errs[i] = lockDirectory(dir, pid, false)
+		}(i, pid)
+	}
+	wg.Wait()
+
+	var holder, loser int
+	switch {
+	case errs[0] == nil && errs[1] != nil:
+		holder, loser = 0, 1
+	case errs[1] == nil && errs[0] != nil:
+		holder, loser = 1, 0
+	default:
+		t.Fatalf("expected exactly one lock to be acquired: %v", errs)
+	}
+
+	var le *LockedError
+	if !errors.As(errs[loser], &le) || le.PID != pids[holder] || le.Stale {
+		t.Errorf("the refused lock returned %v, expected a LockedError for process %d", errs[loser], pids[holder])
+	}
+	// A live holder is never broken by the force option
+	if _, err := lockDirectory(dir, pids[loser], true); !errors.As(err, &le) {
+		t.Errorf("the force option broke the lock of a live process: %v", err)
+	}
+
+	if err := locks[holder].Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, LockFile)); !os.IsNotExist(err) {
+		t.Errorf("the lock file remains after the lock was released")
+	}
+
+	l, err := lockDirectory(dir, pids[loser], false)
+	if err != nil {
+		t.Fatalf("the lock was not acquired after being released: %v", err)
+	}
+	_ = l.Release()
+}
+
+func TestLocksSharedWithinProcess(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := LockDirectory(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LockDirectory(dir, false)
+	if err != nil {
+		t.Fatalf("a second system within the process was refused the lock: %v", err)
+	}
+
+	_ = first.Release()
+	// Releasing twice does not drop the reference of another system
+	_ = first.Release()
+	if _, err := os.Stat(filepath.Join(dir, LockFile)); err != nil {
+		t.Errorf("the lock file was removed while still held: %v", err)
+	}
+	_ = second.Release()
+	if _, err := os.Stat(filepath.Join(dir, LockFile)); !os.IsNotExist(err) {
+		t.Errorf("the lock file remains after every system released the lock")
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, LockFile)
+	pid := deadPID(t)
+
+	if err := os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var le *LockedError
+	if _, err := LockDirectory(dir, false); !errors.As(err, &le) || !le.Stale || le.PID != pid {
+		t.Fatalf("the stale lock returned %v, expected a stale LockedError for process %d", err, pid)
+	} else if !errors.Is(err, ErrGraphUnavailable) {
+		t.Errorf("the LockedError does not match ErrGraphUnavailable")
+	}
+
+	l, err := LockDirectory(dir, true)
+	if err != nil {
+		t.Fatalf("the force option did not break the stale lock: %v", err)
+	}
+	if owner, err := readLockFile(path); err != nil || owner != os.Getpid() {
+		t.Errorf("the lock file holds the PID %d, expected %d", owner, os.Getpid())
+	}
+	_ = l.Release()
+}
+
+func TestShutdownReleasesLockAfterPanic(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := LockDirectory(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The system has no resolver pools, so releasing its resources panics
+	sys := &LocalSystem{
+		lock:       l,
+		done:       make(chan struct{}),
+		addSource:  make(chan service.Service),
+		allSources: make(chan chan []service.Service, 1),
+	}
+	go sys.manageDataSources()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("the shutdown did not panic")
+			}
+		}()
+		_ = sys.Shutdown()
+	}()
+
+	if _, err := os.Stat(filepath.Join(dir, LockFile)); !os.IsNotExist(err) {
+		t.Errorf("the lock file remains after the shutdown panicked")
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package systems
+
+import "os"
+
+// processAlive returns true when a process with the PID is running.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// FindProcess opens a handle to the process, which fails once it has exited
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
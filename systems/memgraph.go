@@ -0,0 +1,113 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caffix/netmap"
+	"github.com/glebarez/sqlite"
+	"github.com/owasp-amass/config/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// MemoryGraphSystem is the database system of the graph held in the memory of the process.
+const MemoryGraphSystem = "memory"
+
+var memGraphCount int64
+
+var memGraphs = struct {
+	sync.Mutex
+	graphs map[*netmap.Graph]*gorm.DB
+}{graphs: make(map[*netmap.Graph]*gorm.DB)}
+
+// memoryGraphSelected returns true when the configuration provides neither an output directory
+// nor a remote graph database, which leaves the enumeration findings in the memory of the process.
+func memoryGraphSelected(cfg *config.Config) bool {
+	if cfg.Dir != "" {
+		return false
+	}
+
+	for _, db := range cfg.GraphDBs {
+		if db != nil && db.System != "local" && db.System != MemoryGraphSystem {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryDatabaseSettings returns the primary database that replaces the local database when the memory graph is selected.
+func memoryDatabaseSettings() *config.Database {
+	return &config.Database{
+		System:  MemoryGraphSystem,
+		Primary: true,
+	}
+}
+
+// memoryGraphDSN returns a name that no other memory graph of the process shares. The SQLite
+// shared cache keeps the database alive for every connection opened with the same name.
+func memoryGraphDSN() string {
+	n := atomic.AddInt64(&memGraphCount, 1)
+	return fmt.Sprintf("file:amass-%d-%d?mode=memory&cache=shared", os.Getpid(), n)
+}
+
+// newMemoryGraph returns a graph that writes nothing to disk, along with the data source name used to open it.
+func newMemoryGraph() (*netmap.Graph, string, error) {
+	dsn := memoryGraphDSN()
+
+	g := netmap.NewGraph("local", dsn, "")
+	if g == nil {
+		return nil, "", fmt.Errorf("%w: failed to create the graph for the %s database", ErrGraphUnavailable, MemoryGraphSystem)
+	}
+	// A separate connection measures the pages held by the database
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrGraphUnavailable, err)
+	}
+
+	memGraphs.Lock()
+	memGraphs.graphs[g] = db
+	memGraphs.Unlock()
+	return g, dsn, nil
+}
+
+// GraphMemory returns the number of bytes held by the graph when it is kept in memory,
+// and zero for the graphs stored on disk or in a remote database.
+func GraphMemory(g *netmap.Graph) uint64 {
+	memGraphs.Lock()
+	db, found := memGraphs.graphs[g]
+	memGraphs.Unlock()
+	if !found {
+		return 0
+	}
+
+	var pages, size int64
+	if err := db.Raw("PRAGMA page_count").Scan(&pages).Error; err != nil {
+		return 0
+	}
+	if err := db.Raw("PRAGMA page_size").Scan(&size).Error; err != nil {
+		return 0
+	}
+	return uint64(pages * size)
+}
+
+// releaseMemoryGraph closes the connection used to measure the graph.
+func releaseMemoryGraph(g *netmap.Graph) {
+	memGraphs.Lock()
+	db, found := memGraphs.graphs[g]
+	delete(memGraphs.graphs, g)
+	memGraphs.Unlock()
+
+	if !found {
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/cursor"
+	"github.com/owasp-amass/config/config"
+	oam "github.com/owasp-amass/open-asset-model"
+)
+
+func TestMemoryGraphSelected(t *testing.T) {
+	cfg := config.NewConfig()
+	if !memoryGraphSelected(cfg) {
+		t.Errorf("the memory graph was not selected without an output directory")
+	}
+
+	cfg.Dir = t.TempDir()
+	if memoryGraphSelected(cfg) {
+		t.Errorf("the memory graph was selected with an output directory")
+	}
+
+	cfg.Dir = ""
+	cfg.GraphDBs = []*config.Database{{System: "postgres", Primary: true}}
+	if memoryGraphSelected(cfg) {
+		t.Errorf("the memory graph was selected with a remote graph database")
+	}
+}
+
+func TestMemoryGraph(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.NewConfig()
+	l := &LocalSystem{Cfg: cfg}
+
+	if err := l.setupGraphDBs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, g := range l.graphs {
+			cursor.Unregister(g)
+			releaseMemoryGraph(g)
+		}
+	}()
+	if len(l.graphs) != 1 || l.graphSystems[0] != MemoryGraphSystem || l.lock != nil {
+		t.Fatalf("the graphs %v were opened", l.graphSystems)
+	}
+
+	g := l.graphs[0]
+	for i := 0; i < 10; i++ {
+		if _, err := g.UpsertFQDN(ctx, fmt.Sprintf("host%d.owasp.org", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if GraphMemory(g) == 0 {
+		t.Errorf("the memory held by the graph was not reported")
+	}
+
+	var names int
+	for it := cursor.NamesIterator(ctx, g, time.Time{}, "owasp.org"); it.Next(); {
+		names++
+	}
+	// The apex domain is stored along with the names
+	if names != 11 {
+		t.Errorf("%d names were read from the memory graph", names)
+	}
+
+	// Another memory graph of the process does not share the findings
+	other, _, err := newMemoryGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseMemoryGraph(other)
+	if assets, _ := other.DB.FindByType(oam.FQDN, time.Time{}); len(assets) != 0 {
+		t.Errorf("the new memory graph holds %d names", len(assets))
+	}
+}
@@ -0,0 +1,556 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/resources"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultPreflightTimeout bounds the time taken by all the preflight checks together.
+const DefaultPreflightTimeout = 10 * time.Second
+
+// DefaultPreflightSample is the number of resolvers and data source endpoints contacted by the preflight checks.
+const DefaultPreflightSample = 3
+
+// preflightQueryTimeout bounds each query and request of the preflight checks.
+const preflightQueryTimeout = 3 * time.Second
+
+// The preflight checks.
+const (
+	// PreflightDNSUDP sends a query to the configured resolvers over UDP
+	PreflightDNSUDP = "dns_udp"
+	// PreflightDNSTCP sends a query to the configured resolvers over TCP
+	PreflightDNSTCP = "dns_tcp"
+	// PreflightHTTPS requests the endpoints of the enabled data sources, verifying their certificates
+	PreflightHTTPS = "https"
+	// PreflightClock compares the clock with the validity of the signatures of the root zone
+	PreflightClock = "clock"
+	// PreflightOutputDirectory writes a file to the output directory
+	PreflightOutputDirectory = "output_directory"
+)
+
+// PreflightStatus is the outcome of a preflight check.
+type PreflightStatus string
+
+// The outcomes of the preflight checks.
+const (
+	PreflightPass PreflightStatus = "pass"
+	PreflightFail PreflightStatus = "fail"
+	// PreflightSkipped is recorded when the check had nothing to work with, such as the signatures stripped by the resolvers
+	PreflightSkipped PreflightStatus = "skipped"
+)
+
+// RemediationCode identifies the problem found by a failed preflight check.
+type RemediationCode string
+
+// The problems found by the preflight checks.
+const (
+	RemediationUDPBlocked        RemediationCode = "dns_udp_blocked"
+	RemediationTCPBlocked        RemediationCode = "dns_tcp_blocked"
+	RemediationHTTPSBlocked      RemediationCode = "https_blocked"
+	RemediationTLSInterception   RemediationCode = "tls_interception"
+	RemediationClockSkew         RemediationCode = "clock_skew"
+	RemediationOutputNotWritable RemediationCode = "output_not_writable"
+	// RemediationTimedOut is recorded for the checks that did not finish within the time allowed
+	RemediationTimedOut RemediationCode = "timed_out"
+)
+
+var remediationHints = map[RemediationCode]string{
+	RemediationUDPBlocked:        "allow outbound UDP to port 53 of the resolvers, or configure resolvers that are reachable from this network",
+	RemediationTCPBlocked:        "allow outbound TCP to port 53 of the resolvers, which is needed for the truncated responses",
+	RemediationHTTPSBlocked:      "allow outbound HTTPS to the data sources, or set the HTTPS_PROXY environment variable to the proxy of this network",
+	RemediationTLSInterception:   "a proxy is intercepting TLS, so exempt the data sources from the inspection or add its certificate authority to the system trust store",
+	RemediationClockSkew:         "synchronize the system clock, for instance with NTP, since the certificates and signatures are checked against it",
+	RemediationOutputNotWritable: "select an output directory the user can write to with the -dir flag, or fix the permissions of the directory",
+	RemediationTimedOut:          "the network did not respond in time, so check the firewall for outbound traffic being dropped silently",
+}
+
+// Hint returns the remediation of the problem.
+func (c RemediationCode) Hint() string {
+	return remediationHints[c]
+}
+
+// PreflightResult is the outcome of a preflight check.
+type PreflightResult struct {
+	Check  string          `json:"check"`
+	Status PreflightStatus `json:"status"`
+	// Hard is set for the checks whose failure keeps the enumeration from finding anything,
+	// such as the queries over UDP unless the enumeration is passive
+	Hard   bool            `json:"hard"`
+	Code   RemediationCode `json:"code,omitempty"`
+	Hint   string          `json:"hint,omitempty"`
+	Detail string          `json:"detail,omitempty"`
+}
+
+// PreflightReport holds the outcomes of the preflight checks, in the order they are listed.
+type PreflightReport struct {
+	Results  []PreflightResult `json:"results"`
+	Duration time.Duration     `json:"duration"`
+}
+
+// Failures returns the checks that failed.
+func (r *PreflightReport) Failures() []PreflightResult {
+	var failed []PreflightResult
+
+	for _, res := range r.Results {
+		if res.Status == PreflightFail {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Err returns a PreflightError when a hard check failed, and nil otherwise.
+func (r *PreflightReport) Err() error {
+	for _, res := range r.Failures() {
+		if res.Hard {
+			return &PreflightError{Report: r}
+		}
+	}
+	return nil
+}
+
+type preflightOptions struct {
+	enabled   bool
+	fatal     bool
+	timeout   time.Duration
+	sample    int
+	endpoints []string
+}
+
+// preflightOptionsFromConfig parses the 'preflight' configuration options. The checks are only run by
+// the System when the section is present.
+func preflightOptionsFromConfig(cfg *config.Config) preflightOptions {
+	opts := preflightOptions{
+		fatal:   true,
+		timeout: DefaultPreflightTimeout,
+		sample:  DefaultPreflightSample,
+	}
+	if cfg == nil || cfg.Options == nil {
+		return opts
+	}
+
+	section, ok := cfg.Options["preflight"].(map[string]interface{})
+	if !ok {
+		return opts
+	}
+	opts.enabled = true
+	if enabled, ok := section["enabled"].(bool); ok {
+		opts.enabled = enabled
+	}
+	if fatal, ok := section["fatal"].(bool); ok {
+		opts.fatal = fatal
+	}
+	if n, ok := options.IntValue(section["timeout"]); ok && n > 0 {
+		opts.timeout = time.Duration(n) * time.Second
+	}
+	if n, ok := options.IntValue(section["sample"]); ok && n > 0 {
+		opts.sample = n
+	}
+	if list, ok := section["endpoints"].([]interface{}); ok {
+		for _, v := range list {
+			if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+				opts.endpoints = append(opts.endpoints, strings.TrimSpace(s))
+			}
+		}
+	}
+	return opts
+}
+
+// Preflight checks that the network allows the enumeration to work before it starts: the queries to the
+// configured resolvers over UDP and TCP, the HTTPS requests to a sample of the enabled data sources, the
+// clock against the signatures of the root zone, and the writes to the output directory. The checks run
+// together, and the report is returned within the time allowed by the 'preflight.timeout' option however
+// broken the network is, with the checks that did not finish recorded as timed out.
+func Preflight(ctx context.Context, cfg *config.Config) *PreflightReport {
+	return newPreflighter(cfg).run(ctx)
+}
+
+type preflighter struct {
+	cfg       *config.Config
+	opts      preflightOptions
+	resolvers []string
+	endpoints []string
+	// roots verifies the certificates of the endpoints, and is the system trust store when nil
+	roots *x509.CertPool
+	now   func() time.Time
+}
+
+func newPreflighter(cfg *config.Config) *preflighter {
+	opts := preflightOptionsFromConfig(cfg)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	p := &preflighter{
+		cfg:  cfg,
+		opts: opts,
+		now:  time.Now,
+	}
+	p.resolvers = sample(rng, preflightResolvers(cfg), opts.sample)
+	if len(opts.endpoints) > 0 {
+		p.endpoints = opts.endpoints
+	} else {
+		p.endpoints = sample(rng, sourceEndpoints(cfg), opts.sample)
+	}
+	return p
+}
+
+// preflightResolvers returns the resolvers configured for the System, or the baseline resolvers.
+func preflightResolvers(cfg *config.Config) []string {
+	addrs := append([]string{}, cfg.Resolvers...)
+	if trusted, err := TrustedResolversFromConfig(cfg); err == nil {
+		addrs = append(addrs, trusted...)
+	}
+	if len(addrs) == 0 {
+		addrs = config.DefaultBaselineResolvers
+	}
+	return checkAddresses(addrs)
+}
+
+var (
+	scriptNameRE = regexp.MustCompile(`(?m)^name\s*=\s*"([^"]+)"`)
+	scriptURLRE  = regexp.MustCompile(`"https://([a-zA-Z0-9.-]+\.[a-zA-Z]{2,})[/:"?]`)
+)
+
+// sourceEndpoints returns the landing pages of the HTTPS hosts requested by the data sources selected by the configuration.
+func sourceEndpoints(cfg *config.Config) []string {
+	scripts, err := resources.GetDefaultScripts()
+	if err != nil {
+		return nil
+	}
+
+	specified := make(map[string]bool)
+	for _, name := range cfg.SourceFilter.Sources {
+		specified[strings.ToLower(name)] = true
+	}
+
+	hosts := make(map[string]struct{})
+	for _, script := range scripts {
+		m := scriptNameRE.FindStringSubmatch(script)
+		if len(m) < 2 {
+			continue
+		}
+		if listed := specified[strings.ToLower(m[1])]; len(specified) > 0 && listed != cfg.SourceFilter.Include {
+			continue
+		}
+		for _, u := range scriptURLRE.FindAllStringSubmatch(script, -1) {
+			hosts[strings.ToLower(u[1])] = struct{}{}
+		}
+	}
+
+	endpoints := make([]string, 0, len(hosts))
+	for host := range hosts {
+		endpoints = append(endpoints, "https://"+host+"/")
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// sample returns at most n of the elements, picked at random.
+func sample(rng *rand.Rand, list []string, n int) []string {
+	if len(list) <= n {
+		return list
+	}
+
+	c := append([]string{}, list...)
+	rng.Shuffle(len(c), func(i, j int) { c[i], c[j] = c[j], c[i] })
+	return c[:n]
+}
+
+type preflightCheck struct {
+	name string
+	hard bool
+	fn   func(ctx context.Context) PreflightResult
+}
+
+func (p *preflighter) run(ctx context.Context) *PreflightReport {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, p.opts.timeout)
+	defer cancel()
+
+	checks := []preflightCheck{
+		{name: PreflightDNSUDP, hard: !p.cfg.Passive, fn: func(ctx context.Context) PreflightResult { return p.checkDNS(ctx, "udp") }},
+		{name: PreflightDNSTCP, fn: func(ctx context.Context) PreflightResult { return p.checkDNS(ctx, "tcp") }},
+		{name: PreflightHTTPS, fn: p.checkHTTPS},
+		{name: PreflightClock, fn: p.checkClock},
+		{name: PreflightOutputDirectory, hard: true, fn: p.checkOutputDirectory},
+	}
+
+	type outcome struct {
+		idx int
+		res PreflightResult
+	}
+	// The channel holds every outcome, so the checks still running once the time is up do not block
+	outcomes := make(chan outcome, len(checks))
+	report := &PreflightReport{Results: make([]PreflightResult, len(checks))}
+	for i, c := range checks {
+		report.Results[i] = preflightFailure(c.name, RemediationTimedOut, "the check did not finish within "+p.opts.timeout.String())
+
+		go func(i int, c preflightCheck) {
+			outcomes <- outcome{idx: i, res: c.fn(ctx)}
+		}(i, c)
+	}
+
+loop:
+	for n := 0; n < len(checks); n++ {
+		select {
+		case <-ctx.Done():
+			break loop
+		case o := <-outcomes:
+			// The check failing once the time is up was cut short, so it is reported as timed out
+			if o.res.Status == PreflightFail && ctx.Err() != nil {
+				continue
+			}
+			report.Results[o.idx] = o.res
+		}
+	}
+	for i, c := range checks {
+		report.Results[i].Check = c.name
+		report.Results[i].Hard = c.hard
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+func preflightFailure(check string, code RemediationCode, detail string) PreflightResult {
+	return PreflightResult{
+		Check:  check,
+		Status: PreflightFail,
+		Code:   code,
+		Hint:   code.Hint(),
+		Detail: detail,
+	}
+}
+
+// exchange sends the query to each resolver over the network, and returns the responses received.
+func (p *preflighter) exchange(ctx context.Context, network string, msg *dns.Msg) ([]*dns.Msg, []error) {
+	var lock sync.Mutex
+	var resps []*dns.Msg
+	var errs []error
+
+	var wg sync.WaitGroup
+	for _, addr := range p.resolvers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			qctx, cancel := context.WithTimeout(ctx, preflightQueryTimeout)
+			defer cancel()
+
+			client := &dns.Client{Net: network, Timeout: preflightQueryTimeout}
+			resp, _, err := client.ExchangeContext(qctx, msg.Copy(), addr)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil || resp == nil {
+				errs = append(errs, fmt.Errorf("%s: %v", addr, err))
+				return
+			}
+			resps = append(resps, resp)
+		}(addr)
+	}
+	wg.Wait()
+	return resps, errs
+}
+
+// checkDNS passes when at least one of the resolvers answered over the network, whatever the response code.
+func (p *preflighter) checkDNS(ctx context.Context, network string) PreflightResult {
+	check, code := PreflightDNSUDP, RemediationUDPBlocked
+	if network == "tcp" {
+		check, code = PreflightDNSTCP, RemediationTCPBlocked
+	}
+	if len(p.resolvers) == 0 {
+		return PreflightResult{Check: check, Status: PreflightSkipped, Detail: "no resolvers are configured"}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(".", dns.TypeNS)
+	resps, errs := p.exchange(ctx, network, msg)
+	if len(resps) == 0 {
+		msgs := make([]string, 0, len(errs))
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return preflightFailure(check, code, fmt.Sprintf("none of the %d resolvers answered over %s: %s",
+			len(p.resolvers), strings.ToUpper(network), strings.Join(msgs, "; ")))
+	}
+	return PreflightResult{
+		Check:  check,
+		Status: PreflightPass,
+		Detail: fmt.Sprintf("%d of the %d resolvers answered over %s", len(resps), len(p.resolvers), strings.ToUpper(network)),
+	}
+}
+
+// checkHTTPS passes when at least one of the endpoints responded, and none of them presented a certificate
+// failing the verification, which reveals the proxies intercepting TLS.
+func (p *preflighter) checkHTTPS(ctx context.Context) PreflightResult {
+	if len(p.endpoints) == 0 {
+		return PreflightResult{Check: PreflightHTTPS, Status: PreflightSkipped, Detail: "no data source endpoints are enabled"}
+	}
+
+	client := &http.Client{
+		Timeout: preflightQueryTimeout,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{RootCAs: p.roots},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	var lock sync.Mutex
+	var reached int
+	var intercepted, unreachable []string
+
+	var wg sync.WaitGroup
+	for _, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+
+			err := requestEndpoint(ctx, client, endpoint)
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case err == nil:
+				reached++
+			case isCertificateError(err):
+				intercepted = append(intercepted, fmt.Sprintf("%s: %v", endpoint, err))
+			default:
+				unreachable = append(unreachable, fmt.Sprintf("%s: %v", endpoint, err))
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+
+	if len(intercepted) > 0 {
+		return preflightFailure(PreflightHTTPS, RemediationTLSInterception, strings.Join(intercepted, "; "))
+	}
+	if reached == 0 {
+		return preflightFailure(PreflightHTTPS, RemediationHTTPSBlocked, strings.Join(unreachable, "; "))
+	}
+	return PreflightResult{
+		Check:  PreflightHTTPS,
+		Status: PreflightPass,
+		Detail: fmt.Sprintf("%d of the %d data source endpoints responded", reached, len(p.endpoints)),
+	}
+}
+
+func requestEndpoint(ctx context.Context, client *http.Client, endpoint string) error {
+	if _, err := url.Parse(endpoint); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.Body.Close()
+}
+
+// isCertificateError returns true when the certificate presented by the server failed the verification.
+func isCertificateError(err error) bool {
+	var unknown x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var verification *tls.CertificateVerificationError
+
+	return errors.As(err, &unknown) || errors.As(err, &invalid) ||
+		errors.As(err, &hostname) || errors.As(err, &verification)
+}
+
+// checkClock compares the clock with the validity period of the signatures of the root zone returned by the
+// resolvers, which reveals the skew that would break the verification of the certificates and signatures.
+func (p *preflighter) checkClock(ctx context.Context) PreflightResult {
+	if len(p.resolvers) == 0 {
+		return PreflightResult{Check: PreflightClock, Status: PreflightSkipped, Detail: "no resolvers are configured"}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(".", dns.TypeSOA)
+	msg.SetEdns0(dns.DefaultMsgSize, true)
+	resps, _ := p.exchange(ctx, "udp", msg)
+
+	var sig *dns.RRSIG
+	for _, resp := range resps {
+		for _, rr := range resp.Answer {
+			if s, ok := rr.(*dns.RRSIG); ok && s.TypeCovered == dns.TypeSOA {
+				sig = s
+			}
+		}
+	}
+	if sig == nil {
+		return PreflightResult{Check: PreflightClock, Status: PreflightSkipped, Detail: "the resolvers did not return the signatures of the root zone"}
+	}
+
+	now := p.now()
+	inception := time.Unix(int64(sig.Inception), 0)
+	expiration := time.Unix(int64(sig.Expiration), 0)
+	switch {
+	case now.Before(inception):
+		return preflightFailure(PreflightClock, RemediationClockSkew,
+			fmt.Sprintf("the clock is at least %v behind, since the signature of the root zone is valid from %s",
+				inception.Sub(now).Round(time.Second), inception.UTC().Format(time.RFC3339)))
+	case now.After(expiration):
+		return preflightFailure(PreflightClock, RemediationClockSkew,
+			fmt.Sprintf("the clock is at least %v ahead, since the signature of the root zone expired at %s",
+				now.Sub(expiration).Round(time.Second), expiration.UTC().Format(time.RFC3339)))
+	}
+	return PreflightResult{
+		Check:  PreflightClock,
+		Status: PreflightPass,
+		Detail: fmt.Sprintf("the clock is within the validity of the root zone signature, from %s to %s",
+			inception.UTC().Format(time.RFC3339), expiration.UTC().Format(time.RFC3339)),
+	}
+}
+
+// checkOutputDirectory creates the output directory when missing, and writes a file to it.
+func (p *preflighter) checkOutputDirectory(ctx context.Context) PreflightResult {
+	dir := config.OutputDirectory(p.cfg.Dir)
+	if dir == "" {
+		return preflightFailure(PreflightOutputDirectory, RemediationOutputNotWritable, "the output directory could not be determined")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return preflightFailure(PreflightOutputDirectory, RemediationOutputNotWritable, err.Error())
+	}
+
+	f, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		return preflightFailure(PreflightOutputDirectory, RemediationOutputNotWritable, err.Error())
+	}
+	_, err = f.WriteString("amass")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	_ = os.Remove(f.Name())
+	if err != nil {
+		return preflightFailure(PreflightOutputDirectory, RemediationOutputNotWritable, err.Error())
+	}
+	return PreflightResult{Check: PreflightOutputDirectory, Status: PreflightPass, Detail: dir}
+}
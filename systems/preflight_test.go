@@ -0,0 +1,235 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+)
+
+// preflightDNSServer answers the queries over UDP and TCP on the same port, signing the root zone
+// from a day ago to a week from now.
+func preflightDNSServer(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skipf("the TCP port could not be bound: %v", err)
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if req.Question[0].Qtype == dns.TypeSOA {
+			now := time.Now()
+			m.Answer = append(m.Answer, &dns.RRSIG{
+				Hdr:         dns.RR_Header{Name: ".", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 86400},
+				TypeCovered: dns.TypeSOA,
+				Algorithm:   dns.RSASHA256,
+				Inception:   uint32(now.Add(-24 * time.Hour).Unix()),
+				Expiration:  uint32(now.Add(7 * 24 * time.Hour).Unix()),
+				SignerName:  ".",
+				Signature:   "AAAA",
+			})
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	for _, srv := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: l, Handler: handler}} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go func(srv *dns.Server) { _ = srv.ActivateAndServe() }(srv)
+		<-started
+		t.Cleanup(func() { _ = srv.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+func testPreflighter(t *testing.T, resolver string, srv *httptest.Server) *preflighter {
+	cfg := config.NewConfig()
+	cfg.Log = log.New(io.Discard, "", 0)
+	cfg.Dir = t.TempDir()
+
+	p := &preflighter{
+		cfg:       cfg,
+		opts:      preflightOptions{timeout: 5 * time.Second},
+		resolvers: []string{resolver},
+		endpoints: []string{srv.URL + "/"},
+		roots:     x509.NewCertPool(),
+		now:       time.Now,
+	}
+	p.roots.AddCert(srv.Certificate())
+	return p
+}
+
+func newPreflightHTTPS(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPreflightPasses(t *testing.T) {
+	p := testPreflighter(t, preflightDNSServer(t), newPreflightHTTPS(t))
+
+	report := p.run(context.Background())
+	if len(report.Results) != 5 {
+		t.Fatalf("the report had %d results", len(report.Results))
+	}
+	for _, res := range report.Results {
+		if res.Status != PreflightPass {
+			t.Errorf("the %s check did not pass: %+v", res.Check, res)
+		}
+	}
+	if err := report.Err(); err != nil {
+		t.Errorf("the report returned the error %v", err)
+	}
+}
+
+func TestPreflightFailures(t *testing.T) {
+	p := testPreflighter(t, preflightDNSServer(t), newPreflightHTTPS(t))
+
+	// The certificate of the endpoint is not trusted, as with a proxy intercepting TLS
+	p.roots = x509.NewCertPool()
+	// The clock is a month ahead of the signature
+	p.now = func() time.Time { return time.Now().Add(30 * 24 * time.Hour) }
+	// The output directory is a regular file
+	file := filepath.Join(p.cfg.Dir, "file")
+	if err := os.WriteFile(file, []byte("amass"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p.cfg.Dir = file
+
+	report := p.run(context.Background())
+	expected := map[string]RemediationCode{
+		PreflightHTTPS:           RemediationTLSInterception,
+		PreflightClock:           RemediationClockSkew,
+		PreflightOutputDirectory: RemediationOutputNotWritable,
+	}
+	if failed := report.Failures(); len(failed) != len(expected) {
+		t.Errorf("%d checks failed: %+v", len(failed), failed)
+	}
+	for _, res := range report.Failures() {
+		if res.Code != expected[res.Check] || res.Hint == "" {
+			t.Errorf("the %s check failed with the code %s", res.Check, res.Code)
+		}
+	}
+
+	var perr *PreflightError
+	if err := report.Err(); !errors.As(err, &perr) || perr.Report != report {
+		t.Errorf("the failure of the output directory was not returned as a PreflightError: %v", err)
+	}
+}
+
+func TestPreflightUnreachableResolver(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing answers on the closed port
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	p := testPreflighter(t, addr, newPreflightHTTPS(t))
+	report := p.run(context.Background())
+	if res := report.Results[0]; res.Check != PreflightDNSUDP || res.Status != PreflightFail || !res.Hard || res.Code != RemediationUDPBlocked {
+		t.Errorf("the UDP check resulted in %+v", res)
+	}
+	if res := report.Results[3]; res.Status != PreflightSkipped {
+		t.Errorf("the clock check without signatures resulted in %+v", res)
+	}
+	if report.Err() == nil {
+		t.Error("the hard failure did not return an error")
+	}
+
+	// The passive enumerations do not need the resolvers
+	p.cfg.Passive = true
+	if err := p.run(context.Background()).Err(); err != nil {
+		t.Errorf("the passive enumeration failed the preflight: %v", err)
+	}
+}
+
+func TestPreflightBounded(t *testing.T) {
+	// The resolver and the endpoint accept the connections and never respond
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	hang := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer srv.Close()
+	defer close(hang)
+
+	p := testPreflighter(t, pc.LocalAddr().String(), srv)
+	p.opts.timeout = 500 * time.Millisecond
+
+	start := time.Now()
+	report := p.run(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the preflight took %v with a timeout of %v", elapsed, p.opts.timeout)
+	}
+	if res := report.Results[0]; res.Status != PreflightFail || res.Code != RemediationTimedOut {
+		t.Errorf("the UDP check resulted in %+v", res)
+	}
+	if res := report.Results[4]; res.Status != PreflightPass {
+		t.Errorf("the output directory check resulted in %+v", res)
+	}
+}
+
+func TestPreflightOptionsFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if opts := preflightOptionsFromConfig(cfg); opts.enabled || !opts.fatal || opts.timeout != DefaultPreflightTimeout {
+		t.Errorf("the options without the section were %+v", opts)
+	}
+
+	cfg.Options["preflight"] = map[string]interface{}{
+		"fatal":     false,
+		"timeout":   5,
+		"sample":    2,
+		"endpoints": []interface{}{"https://crt.sh/", " "},
+	}
+	opts := preflightOptionsFromConfig(cfg)
+	if !opts.enabled || opts.fatal || opts.timeout != 5*time.Second || opts.sample != 2 || len(opts.endpoints) != 1 {
+		t.Errorf("the options were %+v", opts)
+	}
+
+	cfg.Options["preflight"] = map[string]interface{}{"enabled": false}
+	if err := runPreflight(cfg); err != nil || preflightOptionsFromConfig(cfg).enabled {
+		t.Error("the disabled preflight was run")
+	}
+}
+
+func TestSourceEndpoints(t *testing.T) {
+	cfg := config.NewConfig()
+	all := sourceEndpoints(cfg)
+	if len(all) == 0 {
+		t.Fatal("no data source endpoints were found")
+	}
+
+	cfg.SourceFilter.Include = true
+	cfg.SourceFilter.Sources = []string{"crtsh"}
+	if endpoints := sourceEndpoints(cfg); len(endpoints) != 1 || endpoints[0] != "https://crt.sh/" {
+		t.Errorf("the endpoints of the crtsh data source were %v", endpoints)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/caffix/netmap"
+	"github.com/owasp-amass/asset-db/repository"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"golang.org/x/net/publicsuffix"
+	"gorm.io/gorm"
+)
+
+// orphanChecks describes, per asset type, the relations that must exist for a node to be
+// considered complete. The graph helpers always write the nodes of an asset before the edge
+// that joins them, so an interrupted write leaves a node that is missing these relations.
+// The order matters, since removing a netblock can leave the addresses it contained orphaned.
+var orphanChecks = []struct {
+	atype     oam.AssetType
+	incoming  bool
+	relations []string
+}{
+	{atype: oam.ASN, incoming: false, relations: []string{"announces"}},
+	{atype: oam.Netblock, incoming: true, relations: []string{"announces"}},
+	{atype: oam.IPAddress, incoming: true},
+}
+
+// repairBatchSize is the number of assets deleted by each statement of the repair.
+const repairBatchSize = 500
+
+// ErrGraphInUse is returned by RepairOrphans while an enumeration is writing to the graph.
+var ErrGraphInUse = errors.New("RepairOrphans: the graph is being written by an enumeration")
+
+// graphWriters counts the enumerations writing to each graph, and holds the graphs being repaired.
+var graphWriters = struct {
+	sync.Mutex
+	writers   map[*netmap.Graph]int
+	repairing map[*netmap.Graph]chan struct{}
+}{
+	writers:   make(map[*netmap.Graph]int),
+	repairing: make(map[*netmap.Graph]chan struct{}),
+}
+
+// AcquireGraphWriter registers a writer of the graph, such as an enumeration, until the returned function
+// is called. The writer waits for the repair of the graph in progress, and the graph is not repaired while
+// any writer is registered.
+func AcquireGraphWriter(g *netmap.Graph) func() {
+	if g == nil {
+		return func() {}
+	}
+
+	graphWriters.Lock()
+	for {
+		ch, found := graphWriters.repairing[g]
+		if !found {
+			break
+		}
+		graphWriters.Unlock()
+		<-ch
+		graphWriters.Lock()
+	}
+	graphWriters.writers[g]++
+	graphWriters.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			graphWriters.Lock()
+			defer graphWriters.Unlock()
+
+			if graphWriters.writers[g]--; graphWriters.writers[g] <= 0 {
+				delete(graphWriters.writers, g)
+			}
+		})
+	}
+}
+
+// RepairOrphans removes the fragments left in the graph by asset writes that were interrupted
+// before completion, and returns the number of edges and nodes that were deleted. The edges that
+// reference missing nodes are removed, along with the nodes missing the relations written with
+// them. The names without any edge are removed as well when they fall outside of the root domain
+// names of the events provided, since no event is associated with them. The System repairs its
+// local graph database under the lock of the output directory once it is opened, and the other
+// graphs are only repaired by calling this function. ErrGraphInUse is returned while an enumeration
+// holds the graph, since the nodes it has just written are not linked yet.
+func RepairOrphans(g *netmap.Graph, events ...string) (int, error) {
+	if g == nil || g.DB == nil {
+		return 0, errors.New("RepairOrphans: the graph has not been initialized")
+	}
+
+	graphWriters.Lock()
+	if graphWriters.writers[g] > 0 || graphWriters.repairing[g] != nil {
+		graphWriters.Unlock()
+		return 0, ErrGraphInUse
+	}
+	db := assetStoreFor(g)
+	if db == nil {
+		graphWriters.Unlock()
+		return 0, errors.New("RepairOrphans: no asset store has been registered for the graph")
+	}
+	done := make(chan struct{})
+	graphWriters.repairing[g] = done
+	graphWriters.Unlock()
+	defer func() {
+		graphWriters.Lock()
+		delete(graphWriters.repairing, g)
+		graphWriters.Unlock()
+		close(done)
+	}()
+
+	var removed int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+
+		removed, err = repairOrphans(tx, events)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("RepairOrphans: %v", err)
+	}
+	return removed, nil
+}
+
+func repairOrphans(tx *gorm.DB, events []string) (int, error) {
+	removed, err := deleteDanglingRelations(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, check := range orphanChecks {
+		column := "from_asset_id"
+		if check.incoming {
+			column = "to_asset_id"
+		}
+
+		cond := "NOT EXISTS (SELECT 1 FROM relations r WHERE r." + column + " = assets.id)"
+		args := []interface{}{string(check.atype)}
+		if len(check.relations) > 0 {
+			cond = "NOT EXISTS (SELECT 1 FROM relations r WHERE r." + column + " = assets.id AND r.type IN ?)"
+			args = append(args, check.relations)
+		}
+
+		var ids []int64
+		if err := tx.Model(&repository.Asset{}).Where("type = ? AND "+cond, args...).Pluck("id", &ids).Error; err != nil {
+			return removed, fmt.Errorf("failed to find the incomplete %s assets: %v", check.atype, err)
+		}
+		if err := deleteAssets(tx, ids); err != nil {
+			return removed, err
+		}
+		removed += len(ids)
+	}
+
+	if len(events) == 0 {
+		return removed, nil
+	}
+	ids, err := unassociatedNames(tx, events)
+	if err != nil {
+		return removed, err
+	}
+	if err := deleteAssets(tx, ids); err != nil {
+		return removed, err
+	}
+	return removed + len(ids), nil
+}
+
+// deleteDanglingRelations removes the edges that reference nodes missing from the graph.
+func deleteDanglingRelations(tx *gorm.DB) (int, error) {
+	res := tx.Exec("DELETE FROM relations WHERE NOT EXISTS (SELECT 1 FROM assets a WHERE a.id = relations.from_asset_id) " +
+		"OR NOT EXISTS (SELECT 1 FROM assets a WHERE a.id = relations.to_asset_id)")
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to delete the dangling edges: %v", res.Error)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// unassociatedNames returns the names without any edge that fall outside of the root domain names of the events.
+// The graph writes the registered domain of each name without an edge, so those are kept.
+func unassociatedNames(tx *gorm.DB, events []string) ([]int64, error) {
+	var rows []repository.Asset
+	if err := tx.Where("type = ? AND NOT EXISTS (SELECT 1 FROM relations r WHERE r.from_asset_id = assets.id) "+
+		"AND NOT EXISTS (SELECT 1 FROM relations r WHERE r.to_asset_id = assets.id)", string(oam.FQDN)).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to find the names without edges: %v", err)
+	}
+
+	var ids []int64
+	for _, row := range rows {
+		a, err := row.Parse()
+		if err != nil {
+			continue
+		}
+		name := strings.ToLower(a.(domain.FQDN).Name)
+		if d, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil && d == name {
+			continue
+		}
+		if !associated(name, events) {
+			ids = append(ids, row.ID)
+		}
+	}
+	return ids, nil
+}
+
+func associated(name string, events []string) bool {
+	for _, event := range events {
+		event = strings.ToLower(strings.Trim(event, "."))
+		if event != "" && (name == event || strings.HasSuffix(name, "."+event)) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteAssets removes the nodes along with the edges that reference them.
+func deleteAssets(tx *gorm.DB, ids []int64) error {
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > repairBatchSize {
+			batch = ids[:repairBatchSize]
+		}
+		ids = ids[len(batch):]
+
+		if err := tx.Exec("DELETE FROM relations WHERE from_asset_id IN ? OR to_asset_id IN ?", batch, batch).Error; err != nil {
+			return fmt.Errorf("failed to delete the edges of the incomplete assets: %v", err)
+		}
+		if err := tx.Exec("DELETE FROM assets WHERE id IN ?", batch).Error; err != nil {
+			return fmt.Errorf("failed to delete the incomplete assets: %v", err)
+		}
+	}
+	return nil
+}
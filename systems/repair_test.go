@@ -0,0 +1,216 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"gorm.io/gorm"
+)
+
+// newRepairGraph returns a graph held in memory along with the asset store registered for it.
+func newRepairGraph(t *testing.T) (*netmap.Graph, *gorm.DB) {
+	g, dsn, err := newMemoryGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterAssetStore(g, "local", dsn); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		UnregisterAssetStore(g)
+		releaseMemoryGraph(g)
+	})
+	return g, assetStoreFor(g)
+}
+
+func countAssets(g *netmap.Graph, atype oam.AssetType) int {
+	// The graph returns an error when no asset has the type
+	assets, _ := g.DB.FindByType(atype, time.Time{})
+	return len(assets)
+}
+
+func TestRepairOrphans(t *testing.T) {
+	g, db := newRepairGraph(t)
+
+	ctx := context.Background()
+	// Complete assets that must survive the repair
+	if err := g.UpsertA(ctx, "www.owasp.org", "192.168.1.1"); err != nil {
+		t.Fatalf("failed to insert the A record: %v", err)
+	}
+	if err := g.UpsertInfrastructure(ctx, 26808, "UTICA-COLLEGE", "192.168.1.1", "192.168.1.0/24"); err != nil {
+		t.Fatalf("failed to insert the infrastructure: %v", err)
+	}
+	// A name within the event stored without its records remains part of the findings
+	if _, err := g.UpsertFQDN(ctx, "mail.owasp.org"); err != nil {
+		t.Fatalf("failed to insert the FQDN: %v", err)
+	}
+	// Simulate a crash between writing the address node and the A record edge
+	if _, err := g.UpsertAddress(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("failed to insert the address: %v", err)
+	}
+	// Simulate a crash between writing the netblock containment and the AS announcement
+	ip, err := g.UpsertAddress(ctx, "172.16.0.1")
+	if err != nil {
+		t.Fatalf("failed to insert the address: %v", err)
+	}
+	nb, err := g.UpsertNetblock(ctx, "172.16.0.0/24")
+	if err != nil {
+		t.Fatalf("failed to insert the netblock: %v", err)
+	}
+	if _, err := g.DB.Create(nb, "contains", ip.Asset); err != nil {
+		t.Fatalf("failed to create the contains edge: %v", err)
+	}
+	// Simulate a crash between writing the name server outside of the scope and the NS record edge
+	if _, err := g.UpsertFQDN(ctx, "ns1.example.com"); err != nil {
+		t.Fatalf("failed to insert the FQDN: %v", err)
+	}
+	// Simulate an edge left behind by the deletion of the node it pointed at
+	www, err := g.DB.FindByContent(&domain.FQDN{Name: "www.owasp.org"}, time.Time{})
+	if err != nil || len(www) != 1 {
+		t.Fatalf("failed to find the name: %v", err)
+	}
+	if err := db.Exec("INSERT INTO relations (type, from_asset_id, to_asset_id) VALUES (?, ?, ?)", "a_record", www[0].ID, 99999).Error; err != nil {
+		t.Fatalf("failed to insert the dangling edge: %v", err)
+	}
+
+	n, err := RepairOrphans(g, "owasp.org")
+	if err != nil {
+		t.Fatalf("RepairOrphans returned an error: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("expected 5 orphaned edges and assets to be removed, got %d", n)
+	}
+
+	for _, tc := range []struct {
+		atype oam.AssetType
+		want  int
+	}{
+		// The names of the event and the registered domain written by the graph are kept
+		{atype: oam.FQDN, want: 4},
+		{atype: oam.IPAddress, want: 1},
+		{atype: oam.Netblock, want: 1},
+		{atype: oam.ASN, want: 1},
+	} {
+		if got := countAssets(g, tc.atype); got != tc.want {
+			t.Errorf("expected %d assets of type %s after the repair, got %d", tc.want, tc.atype, got)
+		}
+	}
+	if rels, err := g.DB.OutgoingRelations(www[0], time.Time{}, "a_record"); err != nil || len(rels) != 1 {
+		t.Errorf("expected the A record of the name to remain, got %d: %v", len(rels), err)
+	}
+
+	if n, err := RepairOrphans(g, "owasp.org"); err != nil || n != 0 {
+		t.Errorf("expected a repaired graph to remain unchanged, got %d removals and error %v", n, err)
+	}
+}
+
+func TestRepairOrphansWithoutEvents(t *testing.T) {
+	g, _ := newRepairGraph(t)
+
+	// The names without edges are kept when no event is known
+	if _, err := g.UpsertFQDN(context.Background(), "ns1.example.com"); err != nil {
+		t.Fatalf("failed to insert the FQDN: %v", err)
+	}
+	if n, err := RepairOrphans(g); err != nil || n != 0 {
+		t.Errorf("the names were repaired without the events: %d, %v", n, err)
+	}
+
+	unregistered := netmap.NewGraph("memory", "", "")
+	if unregistered == nil {
+		t.Fatal("failed to create the in-memory graph")
+	}
+	defer unregistered.Remove()
+	if _, err := RepairOrphans(unregistered); err == nil {
+		t.Error("a graph without an asset store was repaired")
+	}
+}
+
+func TestRepairOrphansWithWriters(t *testing.T) {
+	g, _ := newRepairGraph(t)
+
+	// The address just written by the enumeration is not linked yet
+	if _, err := g.UpsertAddress(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("failed to insert the address: %v", err)
+	}
+
+	release := AcquireGraphWriter(g)
+	second := AcquireGraphWriter(g)
+	if n, err := RepairOrphans(g); err != ErrGraphInUse || n != 0 {
+		t.Errorf("the graph held by the writers was repaired: %d, %v", n, err)
+	}
+	release()
+	// Releasing twice does not release the other writer
+	release()
+	if _, err := RepairOrphans(g); err != ErrGraphInUse {
+		t.Errorf("the graph held by the second writer was repaired: %v", err)
+	}
+	second()
+
+	if n, err := RepairOrphans(g); err != nil || n != 1 {
+		t.Errorf("the released graph was repaired with %d removals and error %v", n, err)
+	}
+}
+
+func TestUpsertResolvedName(t *testing.T) {
+	g, db := newRepairGraph(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := UpsertResolvedName(ctx, g, "WWW.owasp.org", "192.168.1.1", "2001:db8::1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pairs, err := g.NamesToAddrs(ctx, time.Time{}, "www.owasp.org")
+	if err != nil || len(pairs) != 2 {
+		t.Fatalf("expected the name to resolve to both addresses, got %d: %v", len(pairs), err)
+	}
+	// Writing the group again updates the assets instead of duplicating them
+	if got := countAssets(g, oam.FQDN); got != 2 {
+		t.Errorf("expected the name and its registered domain, got %d names", got)
+	}
+	if got := countAssets(g, oam.IPAddress); got != 2 {
+		t.Errorf("expected 2 addresses, got %d", got)
+	}
+
+	// Simulate a crash before the group was committed
+	crash := errors.New("crash")
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := upsertResolvedName(tx, "mail.owasp.org", nil); err != nil {
+			return err
+		}
+		return crash
+	}); err != crash {
+		t.Fatalf("the transaction returned %v", err)
+	}
+	if got := countAssets(g, oam.FQDN); got != 2 {
+		t.Errorf("the interrupted group left %d names", got)
+	}
+	if err := UpsertResolvedName(ctx, g, "mail.owasp.org", "not-an-address"); err == nil {
+		t.Error("a name was written with an invalid address")
+	}
+	if got := countAssets(g, oam.FQDN); got != 2 {
+		t.Errorf("the group with an invalid address left %d names", got)
+	}
+
+	// The graphs without an asset store are written in order
+	unregistered := netmap.NewGraph("memory", "", "")
+	if unregistered == nil {
+		t.Fatal("failed to create the in-memory graph")
+	}
+	defer unregistered.Remove()
+	if err := UpsertResolvedName(ctx, unregistered, "www.owasp.org", "192.168.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if pairs, err := unregistered.NamesToAddrs(ctx, time.Time{}, "www.owasp.org"); err != nil || len(pairs) != 1 {
+		t.Errorf("expected the name to resolve to the address, got %d: %v", len(pairs), err)
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// ReputationFile is the name of the file in the output directory that persists the resolver reputation.
+const ReputationFile = "resolvers.json"
+
+// reputationVersion is the version of the reputation file format. Files of other versions are discarded.
+const reputationVersion = 1
+
+const (
+	// DefaultReputationHalfLife is the age that halves the weight of the resolver observations.
+	DefaultReputationHalfLife = 7 * 24 * time.Hour
+	// DefaultReputationProbes is the number of resolvers probed during each run.
+	DefaultReputationProbes = 50
+	// DefaultMinSuccess is the success rate below which an observed resolver is not used.
+	DefaultMinSuccess = 0.5
+	// maxDisagreements is the weight of the answers refuted by the trusted resolvers that keeps a resolver out
+	maxDisagreements = 3
+	// maxSourceMismatches is the weight of the responses from unexpected addresses that keeps a resolver out
+	maxSourceMismatches = 5
+	// minObservations is the weight of the observations needed before a resolver is filtered for its success rate
+	minObservations = 3
+	// maxLatencySamples bounds the latencies kept for each resolver
+	maxLatencySamples = 32
+	// probeTimeout bounds each of the queries sent to probe a resolver
+	probeTimeout = 3 * time.Second
+	// probeDomain has no wildcard, so a resolver that answers for its random names is poisoned
+	probeDomain = "example.com"
+)
+
+// resolverRecord holds the decayed observations of a single resolver.
+type resolverRecord struct {
+	Queries   float64 `json:"queries"`
+	Successes float64 `json:"successes"`
+	Poisoned  float64 `json:"poisoned"`
+	// Disagreements counts the positive answers that the trusted resolvers refuted during the enumerations
+	Disagreements float64 `json:"disagreements,omitempty"`
+	// SourceMismatches counts the responses to the queries sent to the resolver that arrived from another address
+	SourceMismatches float64 `json:"source_mismatches,omitempty"`
+	// Latencies holds the most recent response times in milliseconds
+	Latencies []int64   `json:"latencies_ms,omitempty"`
+	P50       int64     `json:"latency_p50_ms"`
+	P90       int64     `json:"latency_p90_ms"`
+	P99       int64     `json:"latency_p99_ms"`
+	Updated   time.Time `json:"updated"`
+}
+
+type reputationFile struct {
+	Version   int                        `json:"version"`
+	Resolvers map[string]*resolverRecord `json:"resolvers"`
+}
+
+// exchangeFunc sends the message to the resolver and returns the response with the round trip time.
+type exchangeFunc func(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error)
+
+// reputation ranks the untrusted resolvers using the observations persisted across runs, so the
+// resolvers that failed or were poisoned before are not used again until their observations decay.
+type reputation struct {
+	sync.Mutex
+	path       string
+	halfLife   time.Duration
+	probes     int
+	minSuccess float64
+	clock      clock.Clock
+	rng        *rand.Rand
+	exchange   exchangeFunc
+	records    map[string]*resolverRecord
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	probing    atomic.Bool
+}
+
+// reputationFromConfig parses the 'resolver_reputation' configuration options and loads the state file
+// in the output directory. It returns nil when the persistence has been disabled.
+func reputationFromConfig(cfg *config.Config) *reputation {
+	rep := &reputation{
+		halfLife:   DefaultReputationHalfLife,
+		probes:     DefaultReputationProbes,
+		minSuccess: DefaultMinSuccess,
+		clock:      clock.System,
+		rng:        random.FromConfig(cfg).Rand("reputation"),
+		exchange:   exchangeUDP,
+		records:    make(map[string]*resolverRecord),
+	}
+
+	if cfg.Options != nil {
+		if opts, ok := cfg.Options["resolver_reputation"].(map[string]interface{}); ok {
+			if persist, ok := opts["persist"].(bool); ok && !persist {
+				return nil
+			}
+			if hours, ok := options.IntValue(opts["half_life"]); ok && hours > 0 {
+				rep.halfLife = time.Duration(hours) * time.Hour
+			}
+			if n, ok := options.IntValue(opts["probes"]); ok && n >= 0 {
+				rep.probes = n
+			}
+			if f, ok := floatValue(opts["min_success"]); ok && f >= 0 && f <= 1 {
+				rep.minSuccess = f
+			}
+		}
+	}
+
+	// The probes are sent from the bind address and source ports of the resolver sockets
+	if opts, err := SocketOptionsFromConfig(cfg); err == nil {
+		rep.exchange = socketExchange(opts)
+	}
+
+	dir := config.OutputDirectory(cfg.Dir)
+	if dir == "" {
+		return nil
+	}
+
+	rep.path = filepath.Join(dir, ReputationFile)
+	if err := rep.load(); err != nil {
+		cfg.Log.Printf("Resolver reputation: starting fresh: %v", err)
+	}
+	return rep
+}
+
+// load reads the state file and decays the observations to the current time. A missing file
+// leaves the records empty, while a corrupt file or one of another version is discarded.
+func (r *reputation) load() error {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read the state file: %v", err)
+	}
+
+	var f reputationFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("failed to parse the state file: %v", err)
+	}
+	if f.Version != reputationVersion {
+		return fmt.Errorf("the state file has the unsupported version %d", f.Version)
+	}
+
+	now := r.clock.Now()
+	for addr, rec := range f.Resolvers {
+		if rec == nil || rec.Queries < 0 || rec.Successes < 0 || rec.Poisoned < 0 {
+			continue
+		}
+
+		r.decay(rec, now)
+		r.records[addr] = rec
+	}
+	return nil
+}
+
+// decay scales the observations down by their age, so the resolvers that recover are retried eventually.
+func (r *reputation) decay(rec *resolverRecord, now time.Time) {
+	if age := now.Sub(rec.Updated); age > 0 && !rec.Updated.IsZero() {
+		f := math.Pow(0.5, float64(age)/float64(r.halfLife))
+
+		rec.Queries *= f
+		rec.Successes *= f
+		rec.Poisoned *= f
+		rec.Disagreements *= f
+		rec.SourceMismatches *= f
+	}
+	rec.Updated = now
+}
+
+// filtered returns true when the observations show that the resolver should not be used.
+func (r *reputation) filtered(rec *resolverRecord) bool {
+	if rec == nil {
+		return false
+	}
+	// A single poisoned answer keeps the resolver out for a half life
+	if rec.Poisoned >= 0.5 || rec.Disagreements >= maxDisagreements || rec.SourceMismatches >= maxSourceMismatches {
+		return true
+	}
+	return rec.Queries >= minObservations && rec.Successes/rec.Queries < r.minSuccess
+}
+
+// score estimates the success rate of the resolver, where those without observations rank in the middle.
+func (r *reputation) score(rec *resolverRecord) float64 {
+	if rec == nil {
+		return 0.5
+	}
+	return (rec.Successes + 1) / (rec.Queries + 2)
+}
+
+// rank removes the resolvers with a bad reputation from the addresses and orders the remaining by their
+// success rate and latency. When every resolver would be removed, all of them are ranked instead.
+func (r *reputation) rank(addrs []string) ([]string, int) {
+	if r == nil {
+		return addrs, 0
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	var kept []string
+	for _, addr := range addrs {
+		if !r.filtered(r.records[addr]) {
+			kept = append(kept, addr)
+		}
+	}
+
+	removed := len(addrs) - len(kept)
+	if len(kept) == 0 {
+		kept, removed = append([]string(nil), addrs...), 0
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		a, b := r.records[kept[i]], r.records[kept[j]]
+
+		if sa, sb := r.score(a), r.score(b); sa != sb {
+			return sa > sb
+		}
+		return a != nil && b != nil && len(a.Latencies) > 0 && len(b.Latencies) > 0 && a.P50 < b.P50
+	})
+	return kept, removed
+}
+
+// observe records the outcome of a query sent to the resolver.
+func (r *reputation) observe(addr string, success, poisoned bool, latency time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	rec, found := r.records[addr]
+	if !found {
+		rec = &resolverRecord{}
+		r.records[addr] = rec
+	}
+	r.decay(rec, r.clock.Now())
+
+	rec.Queries++
+	if success {
+		rec.Successes++
+		rec.Latencies = append(rec.Latencies, latency.Milliseconds())
+		if n := len(rec.Latencies); n > maxLatencySamples {
+			rec.Latencies = rec.Latencies[n-maxLatencySamples:]
+		}
+		rec.P50, rec.P90, rec.P99 = latencyPercentiles(rec.Latencies)
+	}
+	if poisoned {
+		rec.Poisoned++
+	}
+}
+
+// disagreement records a positive answer of the resolver that the trusted resolvers refuted, which counts
+// as a failed query. Unlike the probes, the name may have been removed in between, so a few are tolerated.
+func (r *reputation) disagreement(addr string) {
+	if r == nil || addr == "" {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	rec, found := r.records[addr]
+	if !found {
+		rec = &resolverRecord{}
+		r.records[addr] = rec
+	}
+	r.decay(rec, r.clock.Now())
+
+	rec.Queries++
+	rec.Disagreements++
+}
+
+// sourceMismatch records a response to a query sent to the resolver that arrived from another address. The
+// response was dropped, so the query is not counted as failed, and a few are tolerated as stray packets, while
+// a resolver whose answers are raced by forged responses persistently is left out.
+func (r *reputation) sourceMismatch(addr string) {
+	if r == nil || addr == "" {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	rec, found := r.records[addr]
+	if !found {
+		rec = &resolverRecord{}
+		r.records[addr] = rec
+	}
+	r.decay(rec, r.clock.Now())
+
+	rec.SourceMismatches++
+}
+
+// start probes the resolvers with the fewest observations in the background, until stopped.
+func (r *reputation) start(addrs []string) {
+	if r == nil || r.probes == 0 || len(addrs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	selected := r.selectProbes(addrs)
+	r.probing.Store(true)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.probing.Store(false)
+
+		for _, addr := range selected {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			r.probe(ctx, addr)
+		}
+	}()
+}
+
+// warmedUp returns true once the resolvers selected for probing have been probed, or the probes were stopped.
+func (r *reputation) warmedUp() bool {
+	return r == nil || !r.probing.Load()
+}
+
+// selectProbes returns the resolvers with the least weight of observations, so each run learns about new ones.
+func (r *reputation) selectProbes(addrs []string) []string {
+	r.Lock()
+	defer r.Unlock()
+
+	candidates := append([]string(nil), addrs...)
+	r.rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	weight := func(addr string) float64 {
+		if rec := r.records[addr]; rec != nil {
+			return rec.Queries
+		}
+		return 0
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return weight(candidates[i]) < weight(candidates[j])
+	})
+
+	if len(candidates) > r.probes {
+		candidates = candidates[:r.probes]
+	}
+	return candidates
+}
+
+// probe checks that the resolver answers for an existing name, and that it does not answer for a random name.
+func (r *reputation) probe(ctx context.Context, addr string) {
+	resp, rtt, err := r.exchange(ctx, resolve.QueryMsg(probeDomain, dns.TypeA), addr)
+	if ctx.Err() != nil {
+		return
+	}
+	r.observe(addr, err == nil && resp != nil && resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0, false, rtt)
+
+	name := random.UnlikelyName(r.rng, probeDomain)
+	resp, rtt, err = r.exchange(ctx, resolve.QueryMsg(name, dns.TypeA), addr)
+	if ctx.Err() != nil {
+		return
+	}
+
+	poisoned := err == nil && resp != nil && resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0
+	r.observe(addr, err == nil && resp != nil && resp.Rcode == dns.RcodeNameError, poisoned, rtt)
+}
+
+// stop ends the probing and waits for the query in flight.
+func (r *reputation) stop() {
+	if r == nil || r.cancel == nil {
+		return
+	}
+
+	r.cancel()
+	r.wg.Wait()
+}
+
+// save writes the observations to the state file, replacing it only once the new content is complete.
+func (r *reputation) save() error {
+	if r == nil || r.path == "" {
+		return nil
+	}
+
+	r.Lock()
+	data, err := json.MarshalIndent(&reputationFile{
+		Version:   reputationVersion,
+		Resolvers: r.records,
+	}, "", "  ")
+	r.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode the resolver reputation: %v", err)
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write the resolver reputation: %v", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace the resolver reputation file: %v", err)
+	}
+	return nil
+}
+
+func latencyPercentiles(samples []int64) (int64, int64, int64) {
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+
+	sorted := append([]int64(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	at := func(p float64) int64 {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return at(0.5), at(0.9), at(0.99)
+}
+
+func exchangeUDP(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+	client := dns.Client{Net: "udp", Timeout: probeTimeout}
+
+	return client.ExchangeContext(ctx, msg, addr)
+}
+
+func floatValue(v interface{}) (float64, bool) {
+	if f, ok := v.(float64); ok {
+		return f, true
+	}
+	if n, ok := options.IntValue(v); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,247 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+)
+
+func newTestReputation(t *testing.T, opts map[string]interface{}) (*config.Config, *reputation) {
+	cfg := config.NewConfig()
+	cfg.Dir = t.TempDir()
+	if opts != nil {
+		cfg.Options = map[string]interface{}{"resolver_reputation": opts}
+	}
+
+	rep := reputationFromConfig(cfg)
+	if rep == nil {
+		t.Fatal("the reputation was not enabled")
+	}
+	return cfg, rep
+}
+
+func TestReputationFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Options = map[string]interface{}{"resolver_reputation": map[string]interface{}{"persist": false}}
+	if rep := reputationFromConfig(cfg); rep != nil {
+		t.Error("the reputation was enabled when the persistence is disabled")
+	}
+
+	_, rep := newTestReputation(t, map[string]interface{}{"half_life": 24, "probes": 5, "min_success": 0.8})
+	if rep.halfLife != 24*time.Hour || rep.probes != 5 || rep.minSuccess != 0.8 {
+		t.Errorf("parsed a half life of %v, %d probes and a minimum success of %v", rep.halfLife, rep.probes, rep.minSuccess)
+	}
+}
+
+func TestReputationRank(t *testing.T) {
+	_, rep := newTestReputation(t, nil)
+	rep.records = map[string]*resolverRecord{
+		"192.0.2.1:53": {Queries: 10, Successes: 9, P50: 80, Latencies: []int64{80}},
+		"192.0.2.2:53": {Queries: 10, Successes: 9, P50: 20, Latencies: []int64{20}},
+		"192.0.2.3:53": {Queries: 10, Successes: 2},
+		"192.0.2.4:53": {Queries: 10, Successes: 10, Poisoned: 2},
+		"192.0.2.5:53": {Queries: 2, Successes: 0},
+	}
+
+	ranked, removed := rep.rank([]string{
+		"192.0.2.6:53", "192.0.2.5:53", "192.0.2.4:53", "192.0.2.3:53", "192.0.2.1:53", "192.0.2.2:53",
+	})
+	// Resolvers without enough observations remain, ranked by their estimated success
+	expected := []string{"192.0.2.2:53", "192.0.2.1:53", "192.0.2.6:53", "192.0.2.5:53"}
+	if removed != 2 || !reflect.DeepEqual(ranked, expected) {
+		t.Errorf("ranked the resolvers %v with %d removed, expected %v with 2 removed", ranked, removed, expected)
+	}
+
+	all := []string{"192.0.2.3:53", "192.0.2.4:53"}
+	if ranked, removed := rep.rank(all); removed != 0 || len(ranked) != 2 {
+		t.Errorf("the pool was emptied by the reputation: %v", ranked)
+	}
+}
+
+func TestReputationDecay(t *testing.T) {
+	_, rep := newTestReputation(t, map[string]interface{}{"half_life": 24})
+	start := time.Now()
+	rep.clock = clock.NewFake(start)
+
+	for i := 0; i < 8; i++ {
+		rep.observe("192.0.2.1:53", false, i == 0, time.Second)
+	}
+	if ranked, _ := rep.rank([]string{"192.0.2.1:53", "192.0.2.2:53"}); len(ranked) != 1 {
+		t.Fatalf("the failing resolver was not filtered: %v", ranked)
+	}
+	if err := rep.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a few half lives, the resolver is retried
+	for days, filtered := range []bool{true, true, false, false} {
+		later := &reputation{
+			path:       rep.path,
+			halfLife:   rep.halfLife,
+			minSuccess: rep.minSuccess,
+			clock:      clock.NewFake(start.Add(time.Duration(days) * 24 * time.Hour)),
+			records:    make(map[string]*resolverRecord),
+		}
+		if err := later.load(); err != nil {
+			t.Fatal(err)
+		}
+		if got := later.filtered(later.records["192.0.2.1:53"]); got != filtered {
+			t.Errorf("after %d days, the resolver was filtered: %t, expected %t", days, got, filtered)
+		}
+	}
+}
+
+func TestReputationDisagreements(t *testing.T) {
+	_, rep := newTestReputation(t, nil)
+	rep.clock = clock.NewFake(time.Now())
+
+	for i := 0; i < 20; i++ {
+		rep.observe("192.0.2.1:53", true, false, 10*time.Millisecond)
+	}
+	// A few refuted answers are tolerated, since the names may have been removed in between
+	for i := 0; i < maxDisagreements-1; i++ {
+		rep.disagreement("192.0.2.1:53")
+	}
+	if rep.filtered(rep.records["192.0.2.1:53"]) {
+		t.Error("the resolver was filtered before reaching the maximum disagreements")
+	}
+
+	rep.disagreement("192.0.2.1:53")
+	if rec := rep.records["192.0.2.1:53"]; !rep.filtered(rec) || rec.Queries != 20+maxDisagreements {
+		t.Errorf("the resolver was not filtered for its disagreements: %+v", rec)
+	}
+
+	// The unattributed disagreements and those observed without the reputation are ignored
+	rep.disagreement("")
+	var none *reputation
+	none.disagreement("192.0.2.1:53")
+	if len(rep.records) != 1 {
+		t.Errorf("the reputation holds %d records, expected 1", len(rep.records))
+	}
+}
+
+func TestReputationSourceMismatches(t *testing.T) {
+	_, rep := newTestReputation(t, nil)
+	rep.clock = clock.NewFake(time.Now())
+
+	for i := 0; i < 20; i++ {
+		rep.observe("192.0.2.1:53", true, false, 10*time.Millisecond)
+	}
+	// A few stray responses are tolerated, and the dropped responses do not fail the queries
+	for i := 0; i < maxSourceMismatches-1; i++ {
+		rep.sourceMismatch("192.0.2.1:53")
+	}
+	if rec := rep.records["192.0.2.1:53"]; rep.filtered(rec) || rec.Queries != 20 {
+		t.Errorf("the resolver was filtered before reaching the maximum source mismatches: %+v", rec)
+	}
+
+	rep.sourceMismatch("192.0.2.1:53")
+	if rec := rep.records["192.0.2.1:53"]; !rep.filtered(rec) {
+		t.Errorf("the resolver was not filtered for its source mismatches: %+v", rec)
+	}
+
+	var none *reputation
+	none.sourceMismatch("192.0.2.1:53")
+	rep.sourceMismatch("")
+	if len(rep.records) != 1 {
+		t.Errorf("the reputation holds %d records, expected 1", len(rep.records))
+	}
+}
+
+func TestReputationCorruption(t *testing.T) {
+	for _, content := range []string{`{"version": 1, "resolvers": {"192.0.2.1:53": `, `{"version": 99, "resolvers": {}}`, "\x00\x01"} {
+		cfg := config.NewConfig()
+		cfg.Dir = t.TempDir()
+		var logs strings.Builder
+		cfg.Log.SetOutput(&logs)
+
+		if err := os.WriteFile(filepath.Join(cfg.Dir, ReputationFile), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		rep := reputationFromConfig(cfg)
+		if rep == nil || len(rep.records) != 0 {
+			t.Errorf("the reputation did not start fresh from the corrupt file %q", content)
+			continue
+		}
+		if !strings.Contains(logs.String(), "starting fresh") {
+			t.Errorf("the corrupt file %q was not logged", content)
+		}
+	}
+}
+
+func TestReputationProbe(t *testing.T) {
+	cfg, rep := newTestReputation(t, map[string]interface{}{"probes": 3})
+	rep.exchange = func(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(msg)
+		name := msg.Question[0].Name
+
+		switch addr {
+		case "192.0.2.3:53":
+			return nil, probeTimeout, errors.New("i/o timeout")
+		case "192.0.2.2:53":
+			// Answers for every name, including those that do not exist
+		default:
+			if name != probeDomain+"." {
+				resp.Rcode = dns.RcodeNameError
+				return resp, 10 * time.Millisecond, nil
+			}
+		}
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   []byte{192, 0, 2, 100},
+		})
+		return resp, 10 * time.Millisecond, nil
+	}
+
+	rep.start([]string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"})
+	rep.wg.Wait()
+	rep.stop()
+	if err := rep.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := reputationFromConfig(cfg)
+	good, poisoned, dead := loaded.records["192.0.2.1:53"], loaded.records["192.0.2.2:53"], loaded.records["192.0.2.3:53"]
+	if good == nil || poisoned == nil || dead == nil {
+		t.Fatalf("the probed resolvers were not persisted: %v", loaded.records)
+	}
+	if good.Successes < 1.9 || good.Poisoned != 0 || good.P50 != 10 {
+		t.Errorf("the good resolver was recorded as %+v", good)
+	}
+	if poisoned.Poisoned < 0.9 || !loaded.filtered(poisoned) {
+		t.Errorf("the poisoned resolver was recorded as %+v", poisoned)
+	}
+	if dead.Successes != 0 || dead.Queries < 1.9 {
+		t.Errorf("the dead resolver was recorded as %+v", dead)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	var samples []int64
+	for i := int64(100); i > 0; i-- {
+		samples = append(samples, i)
+	}
+
+	if p50, p90, p99 := latencyPercentiles(samples); p50 != 50 || p90 != 90 || p99 != 99 {
+		t.Errorf("computed the percentiles %d, %d and %d", p50, p90, p99)
+	}
+	if p50, _, _ := latencyPercentiles(nil); p50 != 0 {
+		t.Errorf("computed the median %d without samples", p50)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// SharedResolvers holds the resolver pools used by many LocalSystems, such as one System for each
+// target organization, so the resolvers are only built and warmed up once. The pools are stopped
+// once they have been closed by their creator and the last System using them has shut down.
+type SharedResolvers struct {
+	sync.Mutex
+	pool    *resolve.Resolvers
+	trusted *resolve.Resolvers
+	refs    int
+	closed  bool
+	stopped bool
+	stop    func()
+}
+
+// NewSharedResolvers builds the untrusted and trusted resolver pools selected by the configuration.
+func NewSharedResolvers(cfg *config.Config) (*SharedResolvers, error) {
+	pool, trusted, err := resolverPools(cfg, reputationFromConfig(cfg), newFDBudget(cfg.Log).resolverShare(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return NewSharedResolversFromPools(pool, trusted), nil
+}
+
+// NewSharedResolversFromPools shares the pre-built resolver pools, which are stopped by the SharedResolvers.
+func NewSharedResolversFromPools(pool, trusted *resolve.Resolvers) *SharedResolvers {
+	s := &SharedResolvers{
+		pool:    pool,
+		trusted: trusted,
+	}
+
+	s.stop = func() {
+		s.pool.Stop()
+		s.trusted.Stop()
+	}
+	return s
+}
+
+// Resolvers returns the shared pool of untrusted resolvers.
+func (s *SharedResolvers) Resolvers() *resolve.Resolvers {
+	return s.pool
+}
+
+// TrustedResolvers returns the shared pool of trusted resolvers.
+func (s *SharedResolvers) TrustedResolvers() *resolve.Resolvers {
+	return s.trusted
+}
+
+// Close releases the reference of the creator. The pools keep running until the Systems using them have shut down.
+func (s *SharedResolvers) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closed = true
+	s.stopUnused()
+}
+
+// acquire adds a reference for a System using the pools.
+func (s *SharedResolvers) acquire() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("%w: the shared resolver pools have been closed", ErrNoResolvers)
+	}
+	s.refs++
+	return nil
+}
+
+// release drops the reference of a System that has shut down.
+func (s *SharedResolvers) release() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.refs > 0 {
+		s.refs--
+	}
+	s.stopUnused()
+}
+
+func (s *SharedResolvers) stopUnused() {
+	if s.closed && s.refs == 0 && !s.stopped {
+		s.stopped = true
+		s.stop()
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+func fakeSharedResolvers(stops *int) *SharedResolvers {
+	shared := NewSharedResolversFromPools(resolve.NewResolvers(), resolve.NewResolvers())
+	shared.stop = func() { *stops++ }
+	return shared
+}
+
+func TestSharedResolversOwnership(t *testing.T) {
+	var stops int
+	shared := fakeSharedResolvers(&stops)
+
+	if err := shared.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := shared.acquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	shared.release()
+	if stops != 0 {
+		t.Fatal("releasing one of the systems stopped the shared pools")
+	}
+	shared.Close()
+	if stops != 0 {
+		t.Fatal("closing the shared pools stopped them while a system was still using them")
+	}
+	if err := shared.acquire(); !errors.Is(err, ErrNoResolvers) {
+		t.Errorf("acquiring closed pools returned %v, expected ErrNoResolvers", err)
+	}
+
+	shared.release()
+	if stops != 1 {
+		t.Fatalf("the shared pools were stopped %d times after the last system released them", stops)
+	}
+	shared.release()
+	shared.Close()
+	if stops != 1 {
+		t.Errorf("the shared pools were stopped %d times", stops)
+	}
+}
+
+func TestLocalSystemsSharingResolvers(t *testing.T) {
+	var stops int
+	shared := fakeSharedResolvers(&stops)
+
+	// The systems are assembled like NewLocalSystemWithResolvers does, without loading the ASN cache
+	newSystem := func() *LocalSystem {
+		if err := shared.acquire(); err != nil {
+			t.Fatal(err)
+		}
+
+		sys := &LocalSystem{
+			Cfg:        config.NewConfig(),
+			pool:       shared.Resolvers(),
+			trusted:    shared.TrustedResolvers(),
+			shared:     shared,
+			done:       make(chan struct{}, 2),
+			addSource:  make(chan service.Service),
+			allSources: make(chan chan []service.Service, 10),
+		}
+		go sys.manageDataSources()
+		return sys
+	}
+
+	first, second := newSystem(), newSystem()
+	if first.Resolvers() != second.Resolvers() || first.TrustedResolvers() != second.TrustedResolvers() {
+		t.Fatal("the systems did not use the shared resolver pools")
+	}
+
+	_ = first.Shutdown()
+	if stops != 0 {
+		t.Fatal("shutting down one system stopped the resolver pools shared with the other")
+	}
+	shared.Close()
+	if stops != 0 {
+		t.Fatal("closing the shared pools stopped them while a system was still using them")
+	}
+
+	_ = second.Shutdown()
+	if stops != 1 {
+		t.Errorf("the shared pools were stopped %d times after both systems shut down", stops)
+	}
+
+	if _, err := NewLocalSystemWithResolvers(config.NewConfig(), shared); !errors.Is(err, ErrNoResolvers) {
+		t.Errorf("building a system on closed pools returned %v, expected ErrNoResolvers", err)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultStopDeadline is the time the System is given to shut down after the stop signal when the deadline is not provided.
+const DefaultStopDeadline = 30 * time.Second
+
+// EnumerationLister is implemented by the Systems keeping the enumerations they started, which the status dump reports.
+type EnumerationLister interface {
+	// Enumerations returns the enumerations of the System that are still running
+	Enumerations() []Enumeration
+}
+
+// StatusRecord is a record of the status dump written to the log, holding either the progress of an
+// enumeration or the summary of the System.
+type StatusRecord struct {
+	Time time.Time `json:"time"`
+	// Kind is "enumeration" for the progress of an enumeration, and "system" for the summary
+	Kind        string               `json:"kind"`
+	Enumeration int                  `json:"enumeration,omitempty"`
+	Progress    *EnumerationProgress `json:"progress,omitempty"`
+	Sources     *StartupProgress     `json:"sources,omitempty"`
+	Memory      uint64               `json:"memory,omitempty"`
+	Files       *FDUsage             `json:"files,omitempty"`
+	// Summary is provided by the program embedding the System
+	Summary interface{} `json:"summary,omitempty"`
+}
+
+// SignalOptions tailors the signal handlers registered by HandleSignals.
+type SignalOptions struct {
+	// Log receives the status dumps and the progress of the stop, instead of the log of the configuration
+	Log *log.Logger
+	// Summary returns the state of the program written along with the summary of the System
+	Summary func() interface{}
+	// Deadline is the time the System is given to shut down after the stop signal
+	Deadline time.Duration
+	// Expired is called when the System did not shut down within the deadline, such as to exit the process
+	Expired func()
+}
+
+// SignalHandler writes the status of the System to the log on SIGUSR1, where the platform provides it, and
+// shuts the System down on SIGTERM. The handlers are only registered when a program asks for them.
+type SignalHandler struct {
+	sys      System
+	opts     SignalOptions
+	log      *log.Logger
+	signals  chan os.Signal
+	quit     chan struct{}
+	quitOnce sync.Once
+	stopped  chan struct{}
+	dumps    int64
+	stops    int64
+}
+
+// HandleSignals registers the signal handlers of the System, which remain until Release is called.
+func HandleSignals(sys System, opts *SignalOptions) *SignalHandler {
+	h := &SignalHandler{
+		sys:     sys,
+		signals: make(chan os.Signal, 4),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Deadline <= 0 {
+		h.opts.Deadline = DefaultStopDeadline
+	}
+
+	h.log = h.opts.Log
+	if h.log == nil {
+		if cfg := sys.Config(); cfg != nil && cfg.Log != nil {
+			h.log = cfg.Log
+		} else {
+			h.log = log.New(os.Stderr, "", log.LstdFlags)
+		}
+	}
+
+	signal.Notify(h.signals, append(statusSignals, syscall.SIGTERM)...)
+	go h.handle()
+	return h
+}
+
+// Release removes the signal handlers, so the signals have their default behavior again.
+func (h *SignalHandler) Release() {
+	h.quitOnce.Do(func() {
+		signal.Stop(h.signals)
+		close(h.quit)
+	})
+}
+
+// Stopped is closed once the System has shut down after the stop signal, or the deadline has passed.
+func (h *SignalHandler) Stopped() <-chan struct{} {
+	return h.stopped
+}
+
+// Dumps returns the number of status dumps written to the log.
+func (h *SignalHandler) Dumps() int64 {
+	return atomic.LoadInt64(&h.dumps)
+}
+
+func (h *SignalHandler) handle() {
+	for {
+		select {
+		case <-h.quit:
+			return
+		case sig := <-h.signals:
+			if sig == syscall.SIGTERM {
+				h.Stop()
+			} else {
+				h.DumpStatus()
+			}
+		}
+	}
+}
+
+// DumpStatus writes the progress of each running enumeration and the summary of the System to the log,
+// as a JSON record on each line.
+func (h *SignalHandler) DumpStatus() {
+	now := time.Now()
+
+	var records []*StatusRecord
+	if lister, ok := h.sys.(EnumerationLister); ok {
+		for i, e := range lister.Enumerations() {
+			p := e.Progress()
+			records = append(records, &StatusRecord{Time: now, Kind: "enumeration", Enumeration: i + 1, Progress: &p})
+		}
+	}
+
+	sources := h.sys.StartupProgress()
+	files := h.sys.FileDescriptors()
+	summary := &StatusRecord{
+		Time:    now,
+		Kind:    "system",
+		Sources: &sources,
+		Memory:  h.sys.GetMemoryUsage(),
+		Files:   &files,
+	}
+	if h.opts.Summary != nil {
+		summary.Summary = h.opts.Summary()
+	}
+	records = append(records, summary)
+
+	for _, rec := range records {
+		if b, err := json.Marshal(rec); err == nil {
+			h.log.Printf("Status: %s", b)
+		}
+	}
+	atomic.AddInt64(&h.dumps, 1)
+}
+
+// Stop shuts the System down, which stops the enumerations and flushes their findings, and returns
+// without waiting. The stop signals received after the first one are only logged.
+func (h *SignalHandler) Stop() {
+	if atomic.AddInt64(&h.stops, 1) > 1 {
+		h.log.Printf("Status: the system is already stopping")
+		return
+	}
+
+	h.log.Printf("Status: stopping the system within %s", h.opts.Deadline)
+	go h.shutdown()
+}
+
+func (h *SignalHandler) shutdown() {
+	defer close(h.stopped)
+
+	done := make(chan error, 1)
+	go func() { done <- h.sys.Shutdown() }()
+
+	t := time.NewTimer(h.opts.Deadline)
+	defer t.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			h.log.Printf("Status: the system failed to stop: %v", err)
+			return
+		}
+		h.log.Printf("Status: the system has stopped")
+	case <-t.C:
+		h.log.Printf("Status: the system did not stop within %s", h.opts.Deadline)
+		if h.opts.Expired != nil {
+			h.opts.Expired()
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package systems
+
+import (
+	"os"
+	"syscall"
+)
+
+// statusSignals are the signals asking for a status dump.
+var statusSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,170 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package systems
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+type progressEnumeration struct{ findings int }
+
+func (e *progressEnumeration) Done() <-chan struct{}           { return nil }
+func (e *progressEnumeration) Output() <-chan *requests.Output { return nil }
+func (e *progressEnumeration) Stop()                           {}
+func (e *progressEnumeration) Err() error                      { return nil }
+
+func (e *progressEnumeration) Progress() EnumerationProgress {
+	return EnumerationProgress{Findings: e.findings}
+}
+
+// signaledSystem blocks its shutdown until it is released.
+type signaledSystem struct {
+	SimpleSystem
+	shutdowns int64
+	release   chan struct{}
+}
+
+func (s *signaledSystem) Enumerations() []Enumeration {
+	return []Enumeration{&progressEnumeration{findings: 7}}
+}
+
+func (s *signaledSystem) StartupProgress() StartupProgress {
+	return StartupProgress{Started: 2, Total: 3}
+}
+
+func (s *signaledSystem) FileDescriptors() FDUsage { return FDUsage{Limit: 1024, Open: 10} }
+
+func (s *signaledSystem) Shutdown() error {
+	atomic.AddInt64(&s.shutdowns, 1)
+	<-s.release
+	return nil
+}
+
+func signalHandler(t *testing.T, deadline time.Duration, expired func()) (*SignalHandler, *signaledSystem, *lockedBuffer) {
+	buf := new(lockedBuffer)
+	sys := &signaledSystem{SimpleSystem: SimpleSystem{Cfg: config.NewConfig()}, release: make(chan struct{})}
+
+	h := HandleSignals(sys, &SignalOptions{
+		Log:      log.New(buf, "", 0),
+		Summary:  func() interface{} { return map[string]int{"pending": 3} },
+		Deadline: deadline,
+		Expired:  expired,
+	})
+	t.Cleanup(h.Release)
+	return h, sys, buf
+}
+
+func signalProcess(t *testing.T, sig syscall.Signal) {
+	if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the signal was not handled in time")
+		}
+	}
+}
+
+func TestStatusSignal(t *testing.T) {
+	h, _, buf := signalHandler(t, time.Minute, nil)
+
+	// The pending signals of the same kind are merged, so the next one is sent after the first dump
+	signalProcess(t, syscall.SIGUSR1)
+	waitFor(t, func() bool { return h.Dumps() == 1 })
+	signalProcess(t, syscall.SIGUSR1)
+	waitFor(t, func() bool { return h.Dumps() == 2 })
+
+	var records []StatusRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec StatusRecord
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "Status: ")), &rec); err != nil {
+			t.Fatalf("the status record %q was not JSON: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	// Each dump holds the progress of the enumeration followed by the summary of the System
+	if len(records) != 4 {
+		t.Fatalf("%d status records were written", len(records))
+	}
+	if e := records[0]; e.Kind != "enumeration" || e.Progress == nil || e.Progress.Findings != 7 {
+		t.Errorf("the progress of the enumeration was %+v", e)
+	}
+	if s := records[1]; s.Kind != "system" || s.Sources == nil || s.Sources.Started != 2 || s.Files == nil || s.Files.Open != 10 || s.Summary == nil {
+		t.Errorf("the summary of the system was %+v", s)
+	}
+}
+
+func TestStopSignal(t *testing.T) {
+	h, sys, buf := signalHandler(t, time.Minute, nil)
+
+	// The repeated stop signals shut the system down once
+	signalProcess(t, syscall.SIGTERM)
+	waitFor(t, func() bool { return atomic.LoadInt64(&sys.shutdowns) == 1 })
+	signalProcess(t, syscall.SIGTERM)
+	waitFor(t, func() bool { return strings.Contains(buf.String(), "already stopping") })
+	close(sys.release)
+
+	select {
+	case <-h.Stopped():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the system did not stop")
+	}
+	if n := atomic.LoadInt64(&sys.shutdowns); n != 1 {
+		t.Errorf("the system was shut down %d times", n)
+	}
+	if !strings.Contains(buf.String(), "the system has stopped") {
+		t.Errorf("the stop was not logged: %s", buf.String())
+	}
+}
+
+func TestStopDeadline(t *testing.T) {
+	var expired int64
+	h, sys, buf := signalHandler(t, 50*time.Millisecond, func() { atomic.AddInt64(&expired, 1) })
+	defer close(sys.release)
+
+	// The system never finishes shutting down, so the deadline passes
+	h.Stop()
+	select {
+	case <-h.Stopped():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the deadline did not pass")
+	}
+	if atomic.LoadInt64(&expired) != 1 || !strings.Contains(buf.String(), "did not stop within") {
+		t.Errorf("the deadline was not reported: %s", buf.String())
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package systems
+
+import "os"
+
+// statusSignals is empty, since the platform does not provide SIGUSR1, so the status is only dumped
+// by calling DumpStatus.
+var statusSignals []os.Signal
@@ -5,6 +5,7 @@
 package systems
 
 import (
+	"context"
 	"runtime"
 
 	"github.com/caffix/netmap"
@@ -53,14 +54,46 @@ func (ss *SimpleSystem) AddAndStart(srv service.Service) error {
 func (ss *SimpleSystem) DataSources() []service.Service { return []service.Service{ss.Service} }
 
 // SetDataSources assigns the data sources that will be used by the system.
-func (ss *SimpleSystem) SetDataSources(sources []service.Service) error {
+func (ss *SimpleSystem) SetDataSources(sources []service.Service) (map[string]error, error) {
 	ss.Service = sources[0]
-	return nil
+	return nil, nil
+}
+
+// StartupProgress implements the System interface.
+func (ss *SimpleSystem) StartupProgress() StartupProgress {
+	if ss.Service == nil {
+		return StartupProgress{}
+	}
+	return StartupProgress{Started: 1, Total: 1}
+}
+
+// StartEnumeration implements the System interface.
+func (ss *SimpleSystem) StartEnumeration(ctx context.Context, scope Scope) (Enumeration, error) {
+	cfg, err := scopeConfig(ss.Cfg, scope)
+	if err != nil {
+		return nil, err
+	}
+	return startEnumeration(ctx, ss, cfg, scope)
+}
+
+// Verify implements the System interface.
+func (ss *SimpleSystem) Verify(ctx context.Context, names []string) (*Verification, error) {
+	cfg, err := scopeConfig(ss.Cfg, ScopeFromConfig(ss.Cfg))
+	if err != nil {
+		return nil, err
+	}
+	return verifyNames(ctx, ss, cfg, names)
 }
 
 // GraphDatabases implements the System interface.
 func (ss *SimpleSystem) GraphDatabases() []*netmap.Graph { return []*netmap.Graph{ss.Graph} }
 
+// ReadGraphDatabases implements the System interface.
+func (ss *SimpleSystem) ReadGraphDatabases() []*netmap.Graph { return ss.GraphDatabases() }
+
+// GraphSystem implements the System interface.
+func (ss *SimpleSystem) GraphSystem(g *netmap.Graph) string { return "" }
+
 // Shutdown implements the System interface.
 func (ss *SimpleSystem) Shutdown() error {
 	if ss.Service != nil {
@@ -78,6 +111,15 @@ func (ss *SimpleSystem) Shutdown() error {
 	return nil
 }
 
+// FileDescriptors implements the System interface.
+func (ss *SimpleSystem) FileDescriptors() FDUsage {
+	u := FDUsage{Open: openFDs()}
+	if soft, _, err := fdLimit(); err == nil {
+		u.Limit = soft
+	}
+	return u
+}
+
 // GetMemoryUsage returns the number bytes allocated to heap objects on this system.
 func (ss *SimpleSystem) GetMemoryUsage() uint64 {
 	var m runtime.MemStats
@@ -0,0 +1,100 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	"github.com/owasp-amass/config/config"
+)
+
+// SocketOptionsFromConfig parses the 'bind_address' and 'source_ports' options of the 'dns' section, which
+// select the local address and source ports of the resolver sockets. The address of the network interface
+// selected with the -iface flag is used when no bind address is provided. It returns nil when the sockets
+// are left to the operating system, and a ConfigError for an invalid address or port range.
+func SocketOptionsFromConfig(cfg *config.Config) (*amassnet.SocketOptions, error) {
+	opts := &amassnet.SocketOptions{}
+
+	var dnsOpts map[string]interface{}
+	if cfg.Options != nil {
+		dnsOpts, _ = cfg.Options["dns"].(map[string]interface{})
+	}
+
+	if v, found := dnsOpts["bind_address"]; found {
+		s, _ := v.(string)
+		if opts.BindAddress = net.ParseIP(strings.TrimSpace(s)); opts.BindAddress == nil {
+			return nil, &ConfigError{Field: "dns.bind_address", Reason: fmt.Sprintf("%v is not a valid IP address", v)}
+		}
+	} else if amassnet.LocalAddr != nil {
+		if ip, _, err := net.ParseCIDR(amassnet.LocalAddr.String()); err == nil {
+			opts.BindAddress = ip
+		}
+	}
+
+	var ranges []interface{}
+	switch v := dnsOpts["source_ports"].(type) {
+	case nil:
+	case []interface{}:
+		ranges = v
+	default:
+		ranges = []interface{}{v}
+	}
+	for _, v := range ranges {
+		r, err := amassnet.ParsePortRange(fmt.Sprint(v))
+		if err != nil {
+			return nil, &ConfigError{Field: "dns.source_ports", Reason: err.Error(), Err: err}
+		}
+		opts.SourcePorts = append(opts.SourcePorts, r)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, &ConfigError{Field: "dns.source_ports", Reason: err.Error(), Err: err}
+	}
+
+	if opts.BindAddress == nil && len(opts.SourcePorts) == 0 {
+		return nil, nil
+	}
+	return opts, nil
+}
+
+// socketField returns the name of the 'dns' option binding the resolver sockets, or an empty string
+// when neither the bind address nor the source ports are configured.
+func socketField(cfg *config.Config) string {
+	if cfg.Options == nil {
+		return ""
+	}
+
+	dnsOpts, _ := cfg.Options["dns"].(map[string]interface{})
+	if _, found := dnsOpts["bind_address"]; found {
+		return "dns.bind_address"
+	}
+	if _, found := dnsOpts["source_ports"]; found {
+		return "dns.source_ports"
+	}
+	return ""
+}
+
+// socketExchange returns the exchange sending the queries from sockets bound as selected by the options.
+func socketExchange(opts *amassnet.SocketOptions) exchangeFunc {
+	if opts == nil {
+		return exchangeUDP
+	}
+
+	return func(ctx context.Context, msg *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+		conn, err := opts.DialUDP(addr)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer conn.Close()
+
+		client := dns.Client{Net: "udp", Timeout: probeTimeout}
+		return client.ExchangeWithConnContext(ctx, msg, &dns.Conn{Conn: conn})
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestSocketOptionsFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if opts, err := SocketOptionsFromConfig(cfg); err != nil || opts != nil {
+		t.Errorf("the sockets without options were selected as %+v with the error %v", opts, err)
+	}
+
+	cfg.Options["dns"] = map[string]interface{}{
+		"bind_address": "192.0.2.5",
+		"source_ports": []interface{}{"40000-40999", 5353},
+	}
+	opts, err := SocketOptionsFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.BindAddress.Equal(net.ParseIP("192.0.2.5")) {
+		t.Errorf("the sockets are bound to %s", opts.BindAddress)
+	}
+	expected := []amassnet.PortRange{{First: 40000, Last: 40999}, {First: 5353, Last: 5353}}
+	if !reflect.DeepEqual(opts.SourcePorts, expected) {
+		t.Errorf("the source ports are %v, expected %v", opts.SourcePorts, expected)
+	}
+
+	for _, ports := range []interface{}{"40000-39999", []interface{}{"40000-40999", "40999"}, "any"} {
+		cfg.Options["dns"] = map[string]interface{}{"source_ports": ports}
+		if _, err := SocketOptionsFromConfig(cfg); err == nil {
+			t.Errorf("the source ports %v were accepted", ports)
+		}
+	}
+}
+
+func TestSocketExchange(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := make(chan net.Addr, 1)
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		from <- w.RemoteAddr()
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	defer func() { _ = server.Shutdown() }()
+
+	opts := &amassnet.SocketOptions{
+		BindAddress: net.ParseIP("127.0.0.1"),
+		SourcePorts: []amassnet.PortRange{{First: 47330, Last: 47339}},
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.owasp.org.", dns.TypeA)
+
+	if _, _, err := socketExchange(opts)(context.Background(), msg, pc.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if addr := <-from; addr.(*net.UDPAddr).Port < 47330 || addr.(*net.UDPAddr).Port > 47339 {
+		t.Errorf("the probe was sent from %v, outside of the source ports", addr)
+	}
+}
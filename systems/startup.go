@@ -0,0 +1,253 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/amass/v4/opsec"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+// DefaultStartRetries is the number of times a data source start that failed for a transient reason is retried.
+const DefaultStartRetries = 2
+
+// DefaultStartBackoff is the delay before the first retry, which doubles after each attempt.
+const DefaultStartBackoff = time.Second
+
+// DefaultStartBatchSize is the number of data sources started together.
+const DefaultStartBatchSize = 10
+
+// DefaultStartBatchDelay is the delay between the starts of the data source batches.
+const DefaultStartBatchDelay = time.Second
+
+// startTimeout bounds the time spent starting a batch of data sources.
+const startTimeout = time.Minute
+
+// transientPatterns identify the transient failures reported by data sources that only provide the error message.
+var transientPatterns = []string{
+	"timeout",
+	"timed out",
+	"connection refused",
+	"connection reset",
+	"temporary failure",
+	"too many requests",
+	"service unavailable",
+	"unexpected eof",
+}
+
+type startOptions struct {
+	retries int
+	backoff time.Duration
+	fatal   bool
+	clock   clock.Clock
+	// stagger is the longest delay before a data source is started in OPSEC mode
+	stagger time.Duration
+	rng     *rand.Rand
+	// batchSize is the number of data sources started together, and zero starts them all at once
+	batchSize  int
+	batchDelay time.Duration
+}
+
+// StartupProgress is the number of data sources that have started, or failed to, out of
+// all the data sources being started by the System.
+type StartupProgress struct {
+	Started int `json:"started"`
+	Failed  int `json:"failed"`
+	Total   int `json:"total"`
+	// Failures holds the error of each data source that failed to start, keyed by the data source name,
+	// including those of the batches started after SetDataSources returned
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// Done returns true once all the data sources have started or failed to.
+func (p StartupProgress) Done() bool {
+	return p.Started+p.Failed >= p.Total
+}
+
+// fail counts the data source that failed to start, and records its error.
+func (p *StartupProgress) fail(name string, err error) {
+	if p.Failures == nil {
+		p.Failures = make(map[string]string)
+	}
+
+	p.Failed++
+	p.Failures[name] = err.Error()
+}
+
+// startOptionsFromConfig parses the 'datasource_start' configuration options.
+func startOptionsFromConfig(cfg *config.Config) startOptions {
+	opts := startOptions{
+		retries:    DefaultStartRetries,
+		backoff:    DefaultStartBackoff,
+		clock:      clock.System,
+		batchSize:  DefaultStartBatchSize,
+		batchDelay: DefaultStartBatchDelay,
+	}
+	if cfg == nil || cfg.Options == nil {
+		return opts
+	}
+	if s := opsec.FromConfig(cfg); s != nil && s.SourceStagger > 0 {
+		opts.stagger = s.SourceStagger
+		opts.rng = s.Rand("sources")
+	}
+
+	section, ok := cfg.Options["datasource_start"].(map[string]interface{})
+	if !ok {
+		return opts
+	}
+	if v, found := section["retries"]; found {
+		if n, ok := options.IntValue(v); ok && n >= 0 {
+			opts.retries = n
+		}
+	}
+	if n, ok := options.IntValue(section["backoff"]); ok && n > 0 {
+		opts.backoff = time.Duration(n) * time.Millisecond
+	}
+	if v, found := section["batch_size"]; found {
+		if n, ok := options.IntValue(v); ok && n >= 0 {
+			opts.batchSize = n
+		}
+	}
+	if v, found := section["batch_delay"]; found {
+		if n, ok := options.IntValue(v); ok && n >= 0 {
+			opts.batchDelay = time.Duration(n) * time.Millisecond
+		}
+	}
+	opts.fatal, _ = section["fatal"].(bool)
+	return opts
+}
+
+// startBatches orders the data sources so the API sources, which only validate their keys, start before
+// the heavy scrapers, and splits them into the batches that are started one after another.
+func (o startOptions) startBatches(sources []service.Service) [][]service.Service {
+	ordered := append([]service.Service(nil), sources...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return startPriority(ordered[i]) < startPriority(ordered[j])
+	})
+
+	size := o.batchSize
+	if size <= 0 {
+		size = len(ordered)
+	}
+
+	var batches [][]service.Service
+	for len(ordered) > 0 {
+		n := size
+		if n > len(ordered) {
+			n = len(ordered)
+		}
+		batches = append(batches, ordered[:n])
+		ordered = ordered[n:]
+	}
+	return batches
+}
+
+// startPriority returns the rank of the data source type in the startup order.
+func startPriority(src service.Service) int {
+	switch strings.ToLower(src.Description()) {
+	case "api":
+		return 0
+	case "scrape", "crawl", "archive":
+		return 2
+	}
+	return 1
+}
+
+// startDelays returns the random delay before each of the data sources is started, so the
+// sources do not all send their requests at the same moment. The delays are all zero unless staggered.
+func (o startOptions) startDelays(n int) []time.Duration {
+	delays := make([]time.Duration, n)
+	if o.stagger <= 0 || o.rng == nil {
+		return delays
+	}
+
+	for i := range delays {
+		delays[i] = time.Duration(o.rng.Int63n(int64(o.stagger)))
+	}
+	return delays
+}
+
+// IsTransient returns true when the error reported by a data source start is likely to go away when retried.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	// The data sources that classify their own failures are trusted over the patterns
+	var serr *SourceError
+	if errors.As(err, &serr) {
+		return serr.Temporary
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, p := range transientPatterns {
+		if strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// startSource starts the data source and retries the failures that are transient, using exponential backoff.
+func startSource(src service.Service, opts startOptions, delay time.Duration, done <-chan struct{}) error {
+	c := opts.clock
+	if c == nil {
+		c = clock.System
+	}
+
+	if delay > 0 {
+		select {
+		case <-done:
+			return errors.New("the system was shut down before the start")
+		case <-c.After(delay):
+		}
+	}
+	err := src.Start()
+
+	delay = opts.backoff
+	for attempt := 0; err != nil && IsTransient(err) && attempt < opts.retries; attempt++ {
+		select {
+		case <-done:
+			return err
+		case <-c.After(delay):
+		}
+		// The service was marked as running by the first attempt, so only the start callback is repeated
+		err = src.OnStart()
+		delay *= 2
+	}
+	return err
+}
+
+// startFailuresError returns the error reported when any start failure is fatal.
+func startFailuresError(failures map[string]error) error {
+	var names []string
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("%d data sources failed to start: %s", len(names), strings.Join(names, ", "))
+}
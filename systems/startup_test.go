@@ -0,0 +1,292 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caffix/service"
+	"github.com/owasp-amass/amass/v4/clock"
+	"github.com/owasp-amass/config/config"
+)
+
+type flakySource struct {
+	*service.BaseService
+	failures int
+	err      error
+	starts   int
+}
+
+func newFlakySource(name string, failures int, err error) *flakySource {
+	fs := &flakySource{failures: failures, err: err}
+	fs.BaseService = service.NewBaseService(fs, name)
+	return fs
+}
+
+func (fs *flakySource) OnStart() error {
+	fs.starts++
+	if fs.starts <= fs.failures {
+		return fs.err
+	}
+	return nil
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{fmt.Errorf("request failed: %w", io.ErrUnexpectedEOF), true},
+		{errors.New("Crtsh: start callback: Get https://crt.sh: net/http: request canceled (Client.Timeout exceeded)"), true},
+		{errors.New("Shodan: check callback failed for the configuration"), false},
+		{&SourceError{Name: "Shodan", Temporary: true, Err: errors.New("rate limited by the provider")}, true},
+		{&SourceError{Name: "Shodan", Temporary: false, Err: errors.New("dial tcp: i/o timeout")}, false},
+		{fmt.Errorf("wrapped: %w", &SourceError{Name: "Shodan", Temporary: true, Err: errors.New("busy")}), true},
+		{errors.New("Shodan has already been started"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsTransient(test.err); got != test.expected {
+			t.Errorf("IsTransient(%v) returned %t, expected %t", test.err, got, test.expected)
+		}
+	}
+}
+
+func TestStartSource(t *testing.T) {
+	opts := startOptions{retries: 2, backoff: 1}
+	done := make(chan struct{})
+	transient := errors.New("dial tcp: i/o timeout")
+
+	src := newFlakySource("Flaky", 2, transient)
+	if err := startSource(src, opts, 0, done); err != nil || src.starts != 3 {
+		t.Errorf("the start returned %v after %d attempts", err, src.starts)
+	}
+
+	src = newFlakySource("Down", 5, transient)
+	if err := startSource(src, opts, 0, done); err != transient || src.starts != 3 {
+		t.Errorf("the start returned %v after %d attempts, expected 3", err, src.starts)
+	}
+
+	permanent := errors.New("check callback failed for the configuration")
+	src = newFlakySource("NoKey", 5, permanent)
+	if err := startSource(src, opts, 0, done); err != permanent || src.starts != 1 {
+		t.Errorf("the permanent failure was retried %d times", src.starts-1)
+	}
+}
+
+func TestStartSourceBackoff(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	opts := startOptions{retries: 3, backoff: time.Second, clock: c}
+
+	src := newFlakySource("Flaky", 5, errors.New("dial tcp: i/o timeout"))
+	_ = startSource(src, opts, 0, make(chan struct{}))
+	// The delay doubles after each attempt, and is measured on the injected clock
+	if slept, n := c.Slept(); slept != 7*time.Second || n != 3 {
+		t.Errorf("the retries waited %v over %d attempts, expected 7s over 3", slept, n)
+	}
+}
+
+func TestSetDataSources(t *testing.T) {
+	cfg := config.NewConfig()
+	var logs strings.Builder
+	cfg.Log = log.New(&logs, "", 0)
+	cfg.Options["datasource_start"] = map[string]interface{}{"retries": 1, "backoff": 1}
+
+	l := &LocalSystem{
+		Cfg:        cfg,
+		done:       make(chan struct{}),
+		addSource:  make(chan service.Service),
+		allSources: make(chan chan []service.Service, 10),
+	}
+	go l.manageDataSources()
+	defer close(l.done)
+
+	permanent := errors.New("check callback failed for the configuration")
+	sources := []service.Service{
+		newFlakySource("Good", 0, nil),
+		newFlakySource("Blip", 1, errors.New("connection reset by peer")),
+		newFlakySource("NoKey", 1, permanent),
+	}
+
+	failures, err := l.SetDataSources(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serr *SourceError
+	if len(failures) != 1 || !errors.Is(failures["NoKey"], permanent) {
+		t.Errorf("SetDataSources returned the failures %v", failures)
+	} else if !errors.As(failures["NoKey"], &serr) || serr.Name != "NoKey" || serr.Temporary {
+		t.Errorf("the failure %v was not classified as a permanent SourceError", failures["NoKey"])
+	}
+	if srcs := l.DataSources(); len(srcs) != 2 {
+		t.Errorf("the system has %d data sources, expected 2", len(srcs))
+	}
+	if !strings.Contains(logs.String(), "NoKey data source failed to start: "+permanent.Error()) {
+		t.Errorf("the permanent failure was not logged: %q", logs.String())
+	}
+
+	// Any start failure is fatal when requested
+	cfg.Options["datasource_start"] = map[string]interface{}{"retries": 0, "fatal": true}
+	if _, err := l.SetDataSources([]service.Service{newFlakySource("Broken", 1, permanent)}); err == nil {
+		t.Error("SetDataSources did not fail for the compliance run")
+	}
+}
+
+func TestStartDelays(t *testing.T) {
+	if delays := startOptionsFromConfig(config.NewConfig()).startDelays(3); delays[0] != 0 || delays[1] != 0 || delays[2] != 0 {
+		t.Errorf("the data sources were staggered without OPSEC mode: %v", delays)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Options = map[string]interface{}{
+		"opsec": map[string]interface{}{"enabled": true, "seed": 42, "source_stagger": 5},
+	}
+	first := startOptionsFromConfig(cfg).startDelays(20)
+	second := startOptionsFromConfig(cfg).startDelays(20)
+
+	for i, d := range first {
+		if d < 0 || d >= 5*time.Second {
+			t.Errorf("the delay %v is outside the stagger of 5s", d)
+		}
+		if d != second[i] {
+			t.Errorf("the delays differ for the same seed: %v and %v", d, second[i])
+		}
+	}
+}
+
+type typedSource struct {
+	*flakySource
+	kind string
+}
+
+func newTypedSource(name, kind string) *typedSource {
+	ts := &typedSource{kind: kind, flakySource: &flakySource{}}
+	ts.BaseService = service.NewBaseService(ts, name)
+	return ts
+}
+
+func (ts *typedSource) Description() string { return ts.kind }
+
+func TestStartBatches(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Options["datasource_start"] = map[string]interface{}{"batch_size": 2, "batch_delay": 250}
+	opts := startOptionsFromConfig(cfg)
+	if opts.batchSize != 2 || opts.batchDelay != 250*time.Millisecond {
+		t.Fatalf("the batches were parsed as %d sources every %v", opts.batchSize, opts.batchDelay)
+	}
+
+	sources := []service.Service{
+		newTypedSource("Wayback", "archive"),
+		newTypedSource("Crtsh", "cert"),
+		newTypedSource("Shodan", "api"),
+		newTypedSource("Bing", "scrape"),
+		newTypedSource("Censys", "api"),
+	}
+
+	var order [][]string
+	for _, batch := range opts.startBatches(sources) {
+		var names []string
+		for _, src := range batch {
+			names = append(names, src.String())
+		}
+		order = append(order, names)
+	}
+	// The API sources come first, and the scrapers keep their order at the end
+	expected := "[[Shodan Censys] [Crtsh Wayback] [Bing]]"
+	if got := fmt.Sprint(order); got != expected {
+		t.Errorf("the sources were started in the batches %s, expected %s", got, expected)
+	}
+
+	opts.batchSize = 0
+	if batches := opts.startBatches(sources); len(batches) != 1 || len(batches[0]) != len(sources) {
+		t.Errorf("a zero batch size did not start all the sources at once: %v", batches)
+	}
+}
+
+func newStartupSystem(cfg *config.Config) *LocalSystem {
+	l := &LocalSystem{
+		Cfg:        cfg,
+		done:       make(chan struct{}),
+		addSource:  make(chan service.Service),
+		allSources: make(chan chan []service.Service, 10),
+		stopStart:  make(chan struct{}),
+	}
+	go l.manageDataSources()
+	return l
+}
+
+func TestSetDataSourcesInBatches(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Log = log.New(io.Discard, "", 0)
+	cfg.Options["datasource_start"] = map[string]interface{}{"batch_size": 1, "batch_delay": 1}
+
+	l := newStartupSystem(cfg)
+	defer close(l.done)
+
+	sources := []service.Service{
+		newTypedSource("Bing", "scrape"),
+		newTypedSource("Shodan", "api"),
+		newFlakySource("NoKey", 1, errors.New("check callback failed for the configuration")),
+	}
+	failures, err := l.SetDataSources(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The System is usable once the first batch, holding the API source, is up
+	if p := l.StartupProgress(); p.Total != 3 || p.Started == 0 {
+		t.Errorf("the first batch was not started: %+v", p)
+	}
+	if len(failures) != 0 {
+		t.Errorf("the first batch returned the failures %v", failures)
+	}
+
+	// The source failing in a later batch is reported by the progress
+	l.starting.Wait()
+	p := l.StartupProgress()
+	if !p.Done() || p.Started != 2 || p.Failed != 1 {
+		t.Errorf("the later batches did not join the system: %+v", p)
+	}
+	if msg := p.Failures["NoKey"]; !strings.Contains(msg, "check callback failed") || len(p.Failures) != 1 {
+		t.Errorf("the failure of the later batch was reported as %v", p.Failures)
+	}
+	if srcs := l.DataSources(); len(srcs) != 2 {
+		t.Errorf("the system has %d data sources, expected 2", len(srcs))
+	}
+}
+
+func TestSetDataSourcesAbandonedBatches(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Log = log.New(io.Discard, "", 0)
+	cfg.Options["datasource_start"] = map[string]interface{}{"batch_size": 1, "batch_delay": 60000}
+
+	l := newStartupSystem(cfg)
+	defer close(l.done)
+
+	sources := []service.Service{
+		newTypedSource("Shodan", "api"),
+		newTypedSource("Bing", "scrape"),
+		newTypedSource("Wayback", "archive"),
+	}
+	if _, err := l.SetDataSources(sources); err != nil {
+		t.Fatal(err)
+	}
+	// The shutdown abandons the batches waiting for their delay
+	close(l.stopStart)
+	l.starting.Wait()
+
+	if p := l.StartupProgress(); !p.Done() || p.Started != 1 || p.Failed != 2 || p.Failures["Wayback"] == "" {
+		t.Errorf("the abandoned batches were not counted: %+v", p)
+	}
+}
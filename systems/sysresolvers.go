@@ -0,0 +1,94 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/owasp-amass/config/config"
+)
+
+// HostResolvers holds the DNS configuration of the host the enumeration runs on.
+type HostResolvers struct {
+	Servers []string
+	// Search holds the search domains, which are ignored by the enumeration
+	Search []string
+}
+
+// systemResolverAddrs returns the resolvers configured on the host, unless the 'system_resolvers'
+// option disables them for runs that must not reveal the activity to the local DNS infrastructure.
+func systemResolverAddrs(cfg *config.Config) []string {
+	if enabled, ok := cfg.Options["system_resolvers"].(bool); ok && !enabled {
+		return nil
+	}
+
+	host, err := discoverSystemResolvers()
+	if err != nil {
+		cfg.Log.Printf("Failed to discover the resolvers configured on the host: %v", err)
+		return nil
+	}
+	if len(host.Search) > 0 {
+		cfg.Log.Printf("Ignoring the search domains configured on the host: %s", strings.Join(host.Search, ", "))
+	}
+	if len(host.Servers) > 0 {
+		cfg.Log.Printf("Using the resolvers configured on the host: %s", strings.Join(host.Servers, ", "))
+	}
+	return host.Servers
+}
+
+// parseResolvConf extracts the nameservers and search domains from the resolv.conf format.
+func parseResolvConf(r io.Reader) *HostResolvers {
+	host := new(HostResolvers)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if addr := resolverAddr(fields[1]); addr != "" {
+				host.Servers = appendUnique(host.Servers, addr)
+			}
+		case "domain", "search":
+			// The last search or domain entry overrides the earlier ones
+			host.Search = nil
+			for _, d := range fields[1:] {
+				host.Search = appendUnique(host.Search, strings.TrimSuffix(strings.ToLower(d), "."))
+			}
+		}
+	}
+	return host
+}
+
+// resolverAddr returns the address of a nameserver, skipping the link-local addresses that require a zone.
+func resolverAddr(s string) string {
+	if strings.Contains(s, "%") {
+		return ""
+	}
+	if ip := net.ParseIP(s); ip != nil && !ip.IsUnspecified() {
+		return ip.String()
+	}
+	return ""
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
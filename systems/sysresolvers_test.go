@@ -0,0 +1,35 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	tests := []struct {
+		conf    string
+		servers string
+		search  string
+	}{
+		{"nameserver 10.0.0.2\nnameserver 10.0.0.3\n", "10.0.0.2,10.0.0.3", ""},
+		{"# generated by NetworkManager\nsearch corp.example.com Example.NET.\nnameserver 127.0.0.53\noptions edns0 trust-ad\n", "127.0.0.53", "corp.example.com,example.net"},
+		{"domain old.example.com\nsearch new.example.com\nnameserver 2001:db8::53 ; primary\n", "2001:db8::53", "new.example.com"},
+		{"nameserver fe80::1%eth0\nnameserver 0.0.0.0\nnameserver bogus\nnameserver 10.0.0.2\nnameserver 10.0.0.2\n", "10.0.0.2", ""},
+		{"", "", ""},
+	}
+
+	for i, test := range tests {
+		host := parseResolvConf(strings.NewReader(test.conf))
+
+		if got := strings.Join(host.Servers, ","); got != test.servers {
+			t.Errorf("test %d: the servers were %q, expected %q", i, got, test.servers)
+		}
+		if got := strings.Join(host.Search, ","); got != test.search {
+			t.Errorf("test %d: the search domains were %q, expected %q", i, got, test.search)
+		}
+	}
+}
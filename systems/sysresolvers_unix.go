@@ -0,0 +1,22 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package systems
+
+import "os"
+
+// resolvConfPath is the file providing the resolvers of the host.
+var resolvConfPath = "/etc/resolv.conf"
+
+func discoverSystemResolvers() (*HostResolvers, error) {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseResolvConf(f), nil
+}
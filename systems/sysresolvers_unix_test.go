@@ -0,0 +1,43 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package systems
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/owasp-amass/config/config"
+)
+
+func TestSystemResolverAddrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte("search corp.example.com\nnameserver 10.0.0.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := resolvConfPath
+	resolvConfPath = path
+	defer func() { resolvConfPath = saved }()
+
+	cfg := config.NewConfig()
+	if addrs := systemResolverAddrs(cfg); strings.Join(addrs, ",") != "10.0.0.2" {
+		t.Errorf("the resolvers %v were discovered, expected 10.0.0.2", addrs)
+	}
+
+	cfg.Options["system_resolvers"] = false
+	if addrs := systemResolverAddrs(cfg); len(addrs) > 0 {
+		t.Errorf("the disabled system resolvers were used: %v", addrs)
+	}
+
+	resolvConfPath = filepath.Join(t.TempDir(), "missing")
+	delete(cfg.Options, "system_resolvers")
+	if addrs := systemResolverAddrs(cfg); len(addrs) > 0 {
+		t.Errorf("the resolvers %v were discovered without a resolv.conf", addrs)
+	}
+}
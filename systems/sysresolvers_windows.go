@@ -0,0 +1,55 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package systems
+
+import (
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func discoverSystemResolvers() (*HostResolvers, error) {
+	size := uint32(15000)
+
+	var buf []byte
+	for attempt := 0; ; attempt++ {
+		buf = make([]byte, size)
+
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_PREFIX,
+			0, (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		// The size has been updated with the length of the buffer that is required
+		if err != windows.ERROR_BUFFER_OVERFLOW || attempt >= 3 {
+			return nil, err
+		}
+	}
+
+	host := new(HostResolvers)
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); aa != nil; aa = aa.Next {
+		if aa.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+
+		for dns := aa.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			ip := dns.Address.IP()
+			// Windows lists the deprecated site-local resolvers on adapters without IPv6 resolvers
+			if ip == nil || strings.HasPrefix(ip.String(), "fec0:0:0:ffff::") {
+				continue
+			}
+			if addr := resolverAddr(ip.String()); addr != "" {
+				host.Servers = appendUnique(host.Servers, addr)
+			}
+		}
+		if suffix := windows.UTF16PtrToString(aa.DnsSuffix); suffix != "" {
+			host.Search = appendUnique(host.Search, strings.TrimSuffix(strings.ToLower(suffix), "."))
+		}
+	}
+	return host, nil
+}
@@ -38,12 +38,35 @@ type System interface {
 	// DataSources returns the slice of data sources managed by the System
 	DataSources() []service.Service
 
-	// SetDataSources assigns the data sources that will be used by System
-	SetDataSources(sources []service.Service) error
+	// SetDataSources starts the data sources that will be used by System, and returns the
+	// error of each data source that failed to start keyed by the data source name. A System
+	// starting the data sources in batches returns once the first batch is up, and the errors
+	// of the later batches are only reported by StartupProgress
+	SetDataSources(sources []service.Service) (map[string]error, error)
+
+	// StartupProgress returns the number of data sources started so far by SetDataSources,
+	// along with the errors of all the data sources that failed to start
+	StartupProgress() StartupProgress
 
 	// GraphDatabases return the Graphs used by the System
 	GraphDatabases() []*netmap.Graph
 
+	// ReadGraphDatabases returns the Graphs that findings are read from, beginning with the primary
+	ReadGraphDatabases() []*netmap.Graph
+
+	// GraphSystem returns the database system of the Graph, or an empty string when it is not known
+	GraphSystem(g *netmap.Graph) string
+
+	// StartEnumeration begins an enumeration of the scope, which runs alongside the other enumerations of the System
+	StartEnumeration(ctx context.Context, scope Scope) (Enumeration, error)
+
+	// Verify resolves the names within the scope of the System and writes their records into a new event,
+	// without brute forcing, altering names or querying the data sources
+	Verify(ctx context.Context, names []string) (*Verification, error)
+
+	// FileDescriptors returns the open file limit of the process and the file descriptors used by the System
+	FileDescriptors() FDUsage
+
 	// GetMemoryUsage() returns the number bytes allocated to heap objects on this system
 	GetMemoryUsage() uint64
 
@@ -51,11 +74,57 @@ type System interface {
 	Shutdown() error
 }
 
+// DisagreementObserver is implemented by the Systems keeping the reputation of the untrusted resolvers, which
+// are told of the answers the trusted resolvers refuted.
+type DisagreementObserver interface {
+	// ObserveDisagreement records an answer of the untrusted resolver that the trusted resolvers refuted
+	ObserveDisagreement(addr string)
+}
+
+// SourceMismatchObserver is implemented by the Systems keeping the reputation of the untrusted resolvers, which
+// are told of the responses that arrived from another address than the resolver queried.
+type SourceMismatchObserver interface {
+	// ObserveSourceMismatch records a response to a query sent to the resolver that arrived from another address
+	ObserveSourceMismatch(addr string)
+}
+
+// NameFilterer is implemented by the Systems holding the custom name filters, which the enumerations consult
+// for every candidate name before it is resolved or stored.
+type NameFilterer interface {
+	// AddNameFilter appends the filter, which returns false to drop the candidate name provided by the source
+	AddNameFilter(f func(name string, src string) bool)
+
+	// FilterName returns false when a custom name filter drops the candidate name
+	FilterName(name, src string) bool
+
+	// NameFilterStats returns the names seen and dropped by each filter, in the order they were added
+	NameFilterStats() []NameFilterStats
+}
+
+// NameTracer is implemented by the Systems capturing the events of the enumerations for the traced names,
+// such as the queries sent for them, the wildcard checks, the scope decisions and the graph writes.
+type NameTracer interface {
+	// TraceName traces the name, or the names below the domain when the pattern starts with "*."
+	TraceName(pattern string)
+
+	// UntraceName stops tracing the pattern, while the records already captured are kept
+	UntraceName(pattern string)
+
+	// Tracing returns true when the name is traced, and is cheap while nothing is traced
+	Tracing(name string) bool
+
+	// AddTrace captures the event of the traced name
+	AddTrace(rec TraceRecord)
+
+	// Traces returns the records of the name in the order they were captured, or all of them when the name is empty
+	Traces(name string) []TraceRecord
+}
+
 // PopulateCache updates the provided System cache with ASN information from the System data sources.
 func PopulateCache(ctx context.Context, asn int, sys System) {
 	// Send the ASN requests to the data sources
 	for _, src := range sys.DataSources() {
-		src.Input() <- &requests.ASNRequest{ASN: asn}
+		src.Input() <- requests.WithContext(ctx, src, &requests.ASNRequest{ASN: asn})
 		time.Sleep(time.Second)
 		select {
 		case <-ctx.Done():
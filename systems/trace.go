@@ -0,0 +1,212 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceFile is the name of the file in the output directory holding the trace of the names, rendered as text.
+const TraceFile = "trace.txt"
+
+// DefaultTraceRecords is the number of trace records kept, after which the oldest records are dropped.
+const DefaultTraceRecords = 10000
+
+// The stages of the enumeration the trace records come from.
+const (
+	// TraceScope is a decision on the candidate name, such as its filtering or the scope check
+	TraceScope = "scope"
+	// TraceQuery is a DNS query sent for the name
+	TraceQuery = "query"
+	// TraceAnswer is a DNS response received for the name, along with the resolver that answered when known
+	TraceAnswer = "answer"
+	// TraceWildcard is a check of the answers against the DNS wildcards
+	TraceWildcard = "wildcard"
+	// TraceCache is a query or write answered by one already made, such as an identical query in flight
+	TraceCache = "cache"
+	// TraceStore is a write of the records of the name to the graph database
+	TraceStore = "store"
+)
+
+// TraceRecord is an event of the enumeration for a traced name.
+type TraceRecord struct {
+	// Seq orders the records, even when they carry the same time
+	Seq   uint64            `json:"seq"`
+	Time  time.Time         `json:"time"`
+	Name  string            `json:"name"`
+	Stage string            `json:"stage"`
+	Event string            `json:"event"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// NameTraces captures the events of the enumerations for the traced names into a bounded buffer. The names
+// are traced exactly, or with the names below them when the pattern starts with "*.", and checking a name
+// costs a single atomic load while nothing is traced.
+type NameTraces struct {
+	sync.RWMutex
+	active   atomic.Int32
+	exact    map[string]struct{}
+	suffixes map[string]struct{}
+	records  []TraceRecord
+	next     int
+	seq      uint64
+	capacity int
+}
+
+// NewNameTraces returns the traces keeping up to the capacity of records.
+func NewNameTraces(capacity int) *NameTraces {
+	if capacity <= 0 {
+		capacity = DefaultTraceRecords
+	}
+	return &NameTraces{
+		exact:    make(map[string]struct{}),
+		suffixes: make(map[string]struct{}),
+		capacity: capacity,
+	}
+}
+
+// tracePattern returns the normalized name of the pattern, and true when the names below it are traced as well.
+func tracePattern(pattern string) (string, bool) {
+	name := strings.ToLower(strings.Trim(strings.TrimSpace(pattern), "."))
+
+	if strings.HasPrefix(name, "*.") {
+		return strings.TrimPrefix(name, "*."), true
+	}
+	return name, false
+}
+
+// Add traces the name, or the names below the domain when the pattern starts with "*.".
+func (nt *NameTraces) Add(pattern string) {
+	name, suffix := tracePattern(pattern)
+	if nt == nil || name == "" {
+		return
+	}
+
+	nt.Lock()
+	defer nt.Unlock()
+
+	if suffix {
+		nt.suffixes[name] = struct{}{}
+	} else {
+		nt.exact[name] = struct{}{}
+	}
+	nt.active.Store(int32(len(nt.exact) + len(nt.suffixes)))
+}
+
+// Remove stops tracing the pattern. The records already captured are kept.
+func (nt *NameTraces) Remove(pattern string) {
+	name, suffix := tracePattern(pattern)
+	if nt == nil || name == "" {
+		return
+	}
+
+	nt.Lock()
+	defer nt.Unlock()
+
+	if suffix {
+		delete(nt.suffixes, name)
+	} else {
+		delete(nt.exact, name)
+	}
+	nt.active.Store(int32(len(nt.exact) + len(nt.suffixes)))
+}
+
+// Match returns true when the name is traced.
+func (nt *NameTraces) Match(name string) bool {
+	if nt == nil || nt.active.Load() == 0 {
+		return false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	nt.RLock()
+	defer nt.RUnlock()
+
+	if _, found := nt.exact[name]; found {
+		return true
+	}
+	for sub := name; sub != ""; {
+		if _, found := nt.suffixes[sub]; found {
+			return true
+		}
+
+		i := strings.IndexByte(sub, '.')
+		if i < 0 {
+			break
+		}
+		sub = sub[i+1:]
+	}
+	return false
+}
+
+// Record captures the event for the name, dropping the oldest record once the buffer is full.
+func (nt *NameTraces) Record(rec TraceRecord) {
+	if nt == nil {
+		return
+	}
+	rec.Name = strings.ToLower(strings.TrimSuffix(rec.Name, "."))
+
+	nt.Lock()
+	defer nt.Unlock()
+
+	nt.seq++
+	rec.Seq = nt.seq
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	if len(nt.records) < nt.capacity {
+		nt.records = append(nt.records, rec)
+		return
+	}
+	nt.records[nt.next] = rec
+	nt.next = (nt.next + 1) % nt.capacity
+}
+
+// Records returns the records of the name in the order they were captured, or all of them when the name is empty.
+func (nt *NameTraces) Records(name string) []TraceRecord {
+	if nt == nil {
+		return nil
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	nt.RLock()
+	defer nt.RUnlock()
+
+	var recs []TraceRecord
+	for _, rec := range nt.records {
+		if name == "" || rec.Name == name {
+			recs = append(recs, rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Seq < recs[j].Seq })
+	return recs
+}
+
+// RenderTrace writes the records as text, one line for each record with its time, name, stage and event,
+// followed by the attributes sorted by their keys.
+func RenderTrace(w io.Writer, recs []TraceRecord) error {
+	for _, rec := range recs {
+		line := fmt.Sprintf("%s %s [%s] %s", rec.Time.Format("15:04:05.000000"), rec.Name, rec.Stage, rec.Event)
+
+		keys := make([]string, 0, len(rec.Attrs))
+		for k := range rec.Attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line += fmt.Sprintf(" %s=%q", k, rec.Attrs[k])
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
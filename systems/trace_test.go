@@ -0,0 +1,90 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNameTracesMatch(t *testing.T) {
+	nt := NewNameTraces(10)
+	if nt.Match("www.owasp.org") {
+		t.Error("the name was traced without any pattern")
+	}
+
+	nt.Add("WWW.owasp.org.")
+	nt.Add("*.dev.owasp.org")
+	for name, want := range map[string]bool{
+		"www.owasp.org":      true,
+		"www.owasp.org.":     true,
+		"mail.owasp.org":     false,
+		"dev.owasp.org":      true,
+		"api.dev.owasp.org":  true,
+		"a.b.dev.owasp.org":  true,
+		"api.devs.owasp.org": false,
+	} {
+		if got := nt.Match(name); got != want {
+			t.Errorf("the name %s was traced: %t", name, got)
+		}
+	}
+
+	nt.Remove("*.dev.owasp.org")
+	if nt.Match("api.dev.owasp.org") {
+		t.Error("the names were still traced below the removed domain")
+	}
+
+	var nilnt *NameTraces
+	nilnt.Add("www.owasp.org")
+	if nilnt.Match("www.owasp.org") || nilnt.Records("") != nil {
+		t.Error("the nil traces captured the name")
+	}
+}
+
+func TestNameTracesBounded(t *testing.T) {
+	nt := NewNameTraces(5)
+	nt.Add("*.owasp.org")
+
+	for i := 0; i < 8; i++ {
+		nt.Record(TraceRecord{Name: fmt.Sprintf("host%d.owasp.org", i%2), Stage: TraceQuery, Event: fmt.Sprint(i)})
+	}
+
+	recs := nt.Records("")
+	if len(recs) != 5 {
+		t.Fatalf("%d records were kept", len(recs))
+	}
+	// The oldest records were dropped, and the rest are kept in the order they were captured
+	for i, rec := range recs {
+		if rec.Event != fmt.Sprint(i+3) || rec.Time.IsZero() {
+			t.Errorf("the record %d was %+v", i, rec)
+		}
+	}
+	if recs := nt.Records("HOST1.owasp.org"); len(recs) != 3 {
+		t.Errorf("%d records were returned for the name", len(recs))
+	}
+}
+
+func TestRenderTrace(t *testing.T) {
+	at := time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	if err := RenderTrace(&buf, []TraceRecord{
+		{Time: at, Name: "www.owasp.org", Stage: TraceQuery, Event: "sent", Attrs: map[string]string{"type": "A", "attempt": "1"}},
+		{Time: at, Name: "www.owasp.org", Stage: TraceStore, Event: "written"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines were rendered", len(lines))
+	}
+	if want := `12:30:00.000000 www.owasp.org [query] sent attempt="1" type="A"`; lines[0] != want {
+		t.Errorf("the record was rendered as %q", lines[0])
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/config/config"
+)
+
+const (
+	// DefaultTTLFloor is the least time an answer is taken to remain valid, whatever its TTL
+	DefaultTTLFloor = 5 * time.Minute
+	// DefaultTTLCeiling is the most time an answer is taken to remain valid, whatever its TTL
+	DefaultTTLCeiling = 24 * time.Hour
+)
+
+// TTLBounds hold the floor and ceiling applied to the TTLs of the DNS answers when they decide how long the answers
+// are cached, when the names are verified again and how long the edges remain current. The raw TTLs are still kept
+// for reporting, so the records of the CDNs with TTLs of seconds do not make the answers churn.
+type TTLBounds struct {
+	Floor   time.Duration
+	Ceiling time.Duration
+}
+
+// TTLBoundsFromConfig parses the 'ttl_floor' and 'ttl_ceiling' options of the 'dns' section, in seconds, and
+// returns a ConfigError when an option is negative or the floor is above the ceiling. A ceiling of zero removes it.
+func TTLBoundsFromConfig(cfg *config.Config) (TTLBounds, error) {
+	b := TTLBounds{Floor: DefaultTTLFloor, Ceiling: DefaultTTLCeiling}
+
+	var dnsOpts map[string]interface{}
+	if cfg != nil && cfg.Options != nil {
+		dnsOpts, _ = cfg.Options["dns"].(map[string]interface{})
+	}
+
+	for _, opt := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"ttl_floor", &b.Floor},
+		{"ttl_ceiling", &b.Ceiling},
+	} {
+		v, found := dnsOpts[opt.name]
+		if !found {
+			continue
+		}
+
+		n, ok := options.IntValue(v)
+		if !ok || n < 0 {
+			return TTLBounds{}, &ConfigError{Field: "dns." + opt.name, Reason: fmt.Sprintf("%v is not a number of seconds", v)}
+		}
+		*opt.d = time.Duration(n) * time.Second
+	}
+
+	if b.Ceiling > 0 && b.Floor > b.Ceiling {
+		return TTLBounds{}, &ConfigError{
+			Field:  "dns.ttl_floor",
+			Reason: fmt.Sprintf("the floor of %s is above the ceiling of %s", b.Floor, b.Ceiling),
+		}
+	}
+	return b, nil
+}
+
+// Apply returns the time an answer with the raw TTL, in seconds, is taken to remain valid.
+func (b TTLBounds) Apply(ttl int) time.Duration {
+	d := time.Duration(ttl) * time.Second
+	if ttl < 0 {
+		d = 0
+	}
+
+	if d < b.Floor {
+		return b.Floor
+	}
+	if b.Ceiling > 0 && d > b.Ceiling {
+		return b.Ceiling
+	}
+	return d
+}
+
+// MessageTTL returns the least raw TTL of the records in the answer section of the message, and false
+// when the message holds no answers.
+func MessageTTL(msg *dns.Msg) (int, bool) {
+	if msg == nil || len(msg.Answer) == 0 {
+		return 0, false
+	}
+
+	least := msg.Answer[0].Header().Ttl
+	for _, rr := range msg.Answer[1:] {
+		if ttl := rr.Header().Ttl; ttl < least {
+			least = ttl
+		}
+	}
+	return int(least), true
+}
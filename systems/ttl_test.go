@@ -0,0 +1,70 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+)
+
+func TestTTLBoundsFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if b, err := TTLBoundsFromConfig(cfg); err != nil || b.Floor != DefaultTTLFloor || b.Ceiling != DefaultTTLCeiling {
+		t.Errorf("the defaults were not used: %+v, %v", b, err)
+	}
+
+	cfg.Options["dns"] = map[string]interface{}{"ttl_floor": 60, "ttl_ceiling": 3600}
+	if b, err := TTLBoundsFromConfig(cfg); err != nil || b.Floor != time.Minute || b.Ceiling != time.Hour {
+		t.Errorf("the options were not parsed: %+v, %v", b, err)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{"ttl_floor": 7200, "ttl_ceiling": 3600},
+		{"ttl_floor": -1},
+		{"ttl_ceiling": "an hour"},
+	} {
+		cfg.Options["dns"] = opts
+		if _, err := TTLBoundsFromConfig(cfg); err == nil {
+			t.Errorf("the options %v were accepted", opts)
+		}
+	}
+}
+
+func TestTTLBoundsApply(t *testing.T) {
+	b := TTLBounds{Floor: 5 * time.Minute, Ceiling: time.Hour}
+
+	for ttl, expected := range map[int]time.Duration{
+		0:     5 * time.Minute,
+		30:    5 * time.Minute,
+		900:   15 * time.Minute,
+		86400: time.Hour,
+	} {
+		if d := b.Apply(ttl); d != expected {
+			t.Errorf("the TTL of %d seconds was taken as %s, expected %s", ttl, d, expected)
+		}
+	}
+	if d := (TTLBounds{Floor: time.Minute}).Apply(86400); d != 24*time.Hour {
+		t.Errorf("the TTL was bounded by the ceiling removed: %s", d)
+	}
+}
+
+func TestMessageTTL(t *testing.T) {
+	msg := new(dns.Msg)
+	if _, ok := MessageTTL(msg); ok {
+		t.Error("the message without answers returned a TTL")
+	}
+
+	msg.Answer = []dns.RR{
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.owasp.org.", Rrtype: dns.TypeCNAME, Ttl: 3600}, Target: "cdn.owasp.net."},
+		&dns.A{Hdr: dns.RR_Header{Name: "cdn.owasp.net.", Rrtype: dns.TypeA, Ttl: 30}, A: net.ParseIP("192.0.2.1")},
+	}
+	if ttl, ok := MessageTTL(msg); !ok || ttl != 30 {
+		t.Errorf("the least TTL was %d", ttl)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package systems
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+// VerifyResult is the outcome of verifying a single name.
+type VerifyResult struct {
+	Name string `json:"name"`
+	// Domain is the domain of the scope the name belongs to, and is empty for the names outside of the scope
+	Domain  string `json:"domain,omitempty"`
+	InScope bool   `json:"in_scope"`
+	// Exists is true when the trusted resolvers returned records of the configured types for the name
+	Exists bool `json:"exists"`
+	// Wildcard is true when the answers matched the wildcard of the domain, so the records were not kept
+	Wildcard bool                 `json:"wildcard,omitempty"`
+	Records  []requests.DNSAnswer `json:"records,omitempty"`
+	// Error explains why the name could not be verified, such as a name that is not valid or blocked by the policy
+	Error string `json:"error,omitempty"`
+}
+
+// Verification holds the results of a verification run, which is recorded as an event of its own.
+type Verification struct {
+	// Event is the identifier of the event holding the records written by the run
+	Event   string         `json:"event"`
+	Start   time.Time      `json:"start"`
+	Results []VerifyResult `json:"results"`
+}
+
+// Found returns the results of the names that exist and were kept.
+func (v *Verification) Found() []VerifyResult {
+	if v == nil {
+		return nil
+	}
+
+	var found []VerifyResult
+	for _, res := range v.Results {
+		if res.Exists && !res.Wildcard {
+			found = append(found, res)
+		}
+	}
+	return found
+}
+
+// NameVerifier resolves the names on the System and writes the records into a new event of the configuration.
+type NameVerifier func(ctx context.Context, sys System, cfg *config.Config, names []string) (*Verification, error)
+
+var (
+	verifierLock sync.Mutex
+	verifier     NameVerifier
+)
+
+// RegisterNameVerifier sets the function that verifies the names for the Systems. The enum package
+// registers its verifier when imported, since it builds upon this package.
+func RegisterNameVerifier(fn NameVerifier) {
+	verifierLock.Lock()
+	defer verifierLock.Unlock()
+
+	verifier = fn
+}
+
+func verifyNames(ctx context.Context, sys System, cfg *config.Config, names []string) (*Verification, error) {
+	verifierLock.Lock()
+	fn := verifier
+	verifierLock.Unlock()
+
+	if fn == nil {
+		return nil, ErrNoNameVerifier
+	}
+	return fn(ctx, sys, cfg, names)
+}
@@ -0,0 +1,616 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport sends DNS queries over a small fixed set of UDP sockets per resolver. A reader
+// goroutine per socket matches the responses to the outstanding queries by their message ID, so a
+// query in flight costs neither a goroutine nor a socket of its own.
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/bandwidth"
+	"github.com/owasp-amass/amass/v4/clock"
+	amassnet "github.com/owasp-amass/amass/v4/net"
+	"github.com/owasp-amass/amass/v4/options"
+	"github.com/owasp-amass/amass/v4/rate"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// DefaultSockets is the number of sockets opened to each resolver when none has been configured.
+const DefaultSockets = 2
+
+// DefaultTimeout is the time a query waits for its response when none has been configured.
+const DefaultTimeout = 3 * time.Second
+
+// readBuffer is the size requested for the receive buffer of each socket, which holds the responses
+// arriving in bursts while the reader is busy.
+const readBuffer = 1 << 20
+
+// The modes of the check of the source address of the responses.
+const (
+	// SourceStrict drops the responses that did not arrive from the address the query was sent to
+	SourceStrict = "strict"
+	// SourceWarn accepts such responses and reports them, for the anycast resolvers answering from other addresses
+	SourceWarn = "warn"
+)
+
+// maxPending bounds the queries outstanding on a socket, so a free message ID is always found quickly.
+const maxPending = 1 << 15
+
+// ErrClosed is returned for the queries sent after the transport has been closed.
+var ErrClosed = errors.New("the DNS transport has been closed")
+
+// Options select the sockets and the timeout of the transport.
+type Options struct {
+	// Sockets is the number of sockets opened to each resolver
+	Sockets int
+	// Timeout is the time a query waits for its response before it fails with the RcodeNoResponse code
+	Timeout time.Duration
+	// QPS is the number of queries sent to each resolver every second, which is unlimited when zero
+	QPS int
+	// Socket holds the bind address and the source ports of the sockets
+	Socket *amassnet.SocketOptions
+	// Observe is called with each response and the address of the resolver that sent it, before the response is delivered
+	Observe func(resp *dns.Msg, server string)
+	// Bytes is called with the number of bytes written to and read from the sockets, including the responses
+	// that arrived too late and the exchanges over TCP
+	Bytes func(sent, received int)
+	// SourceCheck is SourceStrict or SourceWarn, and decides whether the responses arriving from another
+	// address than the resolver are dropped or accepted. An empty mode is strict
+	SourceCheck string
+	// SourceMismatch is called with each response that arrived from another address than the resolver, along
+	// with the address it came from and whether it was accepted, before the response is delivered
+	SourceMismatch func(resp *dns.Msg, server, from string, accepted bool)
+}
+
+// ValidSourceCheck returns true when the mode of the source address check is known.
+func ValidSourceCheck(mode string) bool {
+	return mode == "" || mode == SourceStrict || mode == SourceWarn
+}
+
+// OptionsFromConfig returns the transport selected by the 'dns.pipelined' option, or nil when it is not enabled.
+func OptionsFromConfig(cfg *config.Config) *Options {
+	if cfg == nil || cfg.Options == nil {
+		return nil
+	}
+
+	opts, ok := cfg.Options["dns"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if enabled, _ := opts["pipelined"].(bool); !enabled {
+		return nil
+	}
+
+	mode, _ := opts["source_check"].(string)
+	return &Options{
+		Sockets:     options.Int(opts["sockets"]),
+		Timeout:     time.Duration(options.Int(opts["timeout"])) * time.Second,
+		QPS:         cfg.ResolversQPS,
+		SourceCheck: strings.ToLower(strings.TrimSpace(mode)),
+	}
+}
+
+// Stats are the counters of the transport.
+type Stats struct {
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+	Timeouts int64 `json:"timeouts"`
+	// Mismatched counts the responses carrying the ID of an outstanding query, but not its question
+	Mismatched int64 `json:"mismatched"`
+	// SourceMismatches counts the responses that arrived from another address than the resolver, whether dropped or accepted
+	SourceMismatches int64 `json:"source_mismatches"`
+	Truncated        int64 `json:"truncated"`
+	Outstanding      int   `json:"outstanding"`
+}
+
+// Pool sends the queries to its resolvers in turn, and implements the pool used by the enumeration.
+type Pool struct {
+	resolvers []*resolver
+	timeout   time.Duration
+	bind      net.IP
+	observe   func(resp *dns.Msg, server string)
+	bytes     func(sent, received int)
+	warn      bool
+	wrongSrc  func(resp *dns.Msg, server, from string, accepted bool)
+	next      atomic.Uint32
+	ctx       context.Context
+	cancel    context.CancelFunc
+	once      sync.Once
+	wg        sync.WaitGroup
+	sent      atomic.Int64
+	received  atomic.Int64
+	timeouts  atomic.Int64
+	mismatch  atomic.Int64
+	truncated atomic.Int64
+}
+
+type resolver struct {
+	addr       string
+	conns      []*conn
+	next       atomic.Uint32
+	rate       *rate.Limiter
+	mismatches atomic.Int64
+}
+
+// conn is a socket sending the queries to a resolver, along with its outstanding queries. The queries are kept
+// in the order they were sent, which is also the order of their deadlines, since the timeout is fixed. The socket
+// is not connected, so the responses arriving from other addresses are received, and checked, instead of being
+// discarded by the operating system.
+type conn struct {
+	sync.Mutex
+	pool    *Pool
+	res     *resolver
+	addr    string
+	raddr   *net.UDPAddr
+	udp     *net.UDPConn
+	pending map[uint16]*query
+	fifo    []*query
+	closed  bool
+}
+
+type query struct {
+	id       uint16
+	msg      *dns.Msg
+	ch       chan *dns.Msg
+	deadline time.Time
+	done     bool
+}
+
+// New opens the sockets to the resolvers, and starts their readers along with the goroutine expiring the queries.
+func New(addrs []string, opts *Options) (*Pool, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("the DNS transport requires at least one resolver")
+	}
+
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Sockets <= 0 {
+		o.Sockets = DefaultSockets
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if !ValidSourceCheck(o.SourceCheck) {
+		return nil, fmt.Errorf("the source address check %q is neither %s nor %s", o.SourceCheck, SourceStrict, SourceWarn)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		timeout:  o.Timeout,
+		observe:  o.Observe,
+		bytes:    o.Bytes,
+		warn:     o.SourceCheck == SourceWarn,
+		wrongSrc: o.SourceMismatch,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	if o.Socket != nil {
+		p.bind = o.Socket.BindAddress
+	}
+
+	for _, addr := range addrs {
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+
+		r := &resolver{addr: addr, rate: rate.NewLimiter(o.QPS, 0, clock.System)}
+		// The resolver is added first, so its sockets are closed when a later socket cannot be opened
+		p.resolvers = append(p.resolvers, r)
+
+		for i := 0; i < o.Sockets; i++ {
+			udp, err := o.Socket.ListenUDP()
+			if err != nil {
+				p.Close()
+				return nil, err
+			}
+			_ = udp.SetReadBuffer(readBuffer)
+
+			c := &conn{
+				pool:    p,
+				res:     r,
+				addr:    addr,
+				raddr:   raddr,
+				udp:     udp,
+				pending: make(map[uint16]*query),
+			}
+			r.conns = append(r.conns, c)
+			p.wg.Add(1)
+			go c.read()
+		}
+	}
+
+	p.wg.Add(1)
+	go p.expire()
+	return p, nil
+}
+
+// Len returns the number of resolvers used by the transport.
+func (p *Pool) Len() int {
+	return len(p.resolvers)
+}
+
+// Stats returns the counters of the transport.
+func (p *Pool) Stats() Stats {
+	s := Stats{
+		Sent:       p.sent.Load(),
+		Received:   p.received.Load(),
+		Timeouts:   p.timeouts.Load(),
+		Mismatched: p.mismatch.Load(),
+		Truncated:  p.truncated.Load(),
+	}
+	for _, r := range p.resolvers {
+		s.SourceMismatches += r.mismatches.Load()
+		for _, c := range r.conns {
+			c.Lock()
+			s.Outstanding += len(c.pending)
+			c.Unlock()
+		}
+	}
+	return s
+}
+
+// SourceMismatches returns the number of responses that arrived from another address, for each resolver that received any.
+func (p *Pool) SourceMismatches() map[string]int64 {
+	counts := make(map[string]int64)
+	for _, r := range p.resolvers {
+		if n := r.mismatches.Load(); n > 0 {
+			counts[r.addr] += n
+		}
+	}
+	return counts
+}
+
+// Close stops the readers and fails the outstanding queries with the RcodeNoResponse code.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		p.cancel()
+		for _, r := range p.resolvers {
+			for _, c := range r.conns {
+				_ = c.udp.Close()
+			}
+		}
+		p.wg.Wait()
+
+		for _, r := range p.resolvers {
+			for _, c := range r.conns {
+				c.Lock()
+				c.closed = true
+				pending := c.pending
+				c.pending = make(map[uint16]*query)
+				c.fifo = nil
+				c.Unlock()
+
+				for _, q := range pending {
+					p.deliver(q.ch, noResponse(q.msg))
+				}
+			}
+		}
+	})
+}
+
+// Query sends the response on the channel once it has been received. A query that could not be sent,
+// or that was not answered before the timeout, is returned with the RcodeNoResponse code.
+func (p *Pool) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	if msg == nil || len(msg.Question) == 0 {
+		ch <- msg
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		p.deliver(ch, noResponse(msg))
+		return
+	case <-p.ctx.Done():
+		p.deliver(ch, noResponse(msg))
+		return
+	default:
+	}
+
+	r := p.resolvers[int(p.next.Add(1)-1)%len(p.resolvers)]
+	if r.rate.TakeContext(ctx) != nil {
+		p.deliver(ch, noResponse(msg))
+		return
+	}
+	c := r.conns[int(r.next.Add(1)-1)%len(r.conns)]
+	if err := c.send(msg, ch); err != nil {
+		p.deliver(ch, noResponse(msg))
+	}
+}
+
+// QueryBlocking returns the response once it has been received.
+func (p *Pool) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	select {
+	case <-ctx.Done():
+		return msg, errors.New("the context expired")
+	default:
+	}
+
+	ch := make(chan *dns.Msg, 1)
+	p.Query(ctx, msg, ch)
+
+	select {
+	case <-ctx.Done():
+		return msg, errors.New("the context expired")
+	case <-p.ctx.Done():
+		return msg, ErrClosed
+	case resp := <-ch:
+		var err error
+		if resp == nil {
+			err = errors.New("query failed")
+		}
+		return resp, err
+	}
+}
+
+// send registers the query under a message ID that is not outstanding on the socket, and writes it.
+// The ID of the message is left untouched, since the caller matches the response on it.
+func (c *conn) send(msg *dns.Msg, ch chan *dns.Msg) error {
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return ErrClosed
+	}
+	if len(c.pending) >= maxPending {
+		c.Unlock()
+		return errors.New("too many queries are outstanding on the socket")
+	}
+
+	// The IDs are picked at random, and the next free ID is taken when one collides with an outstanding query
+	id := uint16(rand.Intn(1 << 16))
+	for {
+		if _, found := c.pending[id]; !found {
+			break
+		}
+		id++
+	}
+
+	q := &query{
+		id:       id,
+		msg:      msg,
+		ch:       ch,
+		deadline: time.Now().Add(c.pool.timeout),
+	}
+	c.pending[id] = q
+	c.fifo = append(c.fifo, q)
+	c.Unlock()
+
+	binary.BigEndian.PutUint16(buf, id)
+	n, err := c.udp.WriteToUDP(buf, c.raddr)
+	if err != nil {
+		c.remove(q)
+		return err
+	}
+	c.pool.count(n, 0)
+	c.pool.sent.Add(1)
+	return nil
+}
+
+// remove takes the query off the outstanding queries, and returns false when it was already answered or expired.
+func (c *conn) remove(q *query) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if q.done || c.pending[q.id] != q {
+		return false
+	}
+	q.done = true
+	delete(c.pending, q.id)
+	return true
+}
+
+// read delivers the responses received on the socket until it is closed.
+func (c *conn) read() {
+	defer c.pool.wg.Done()
+
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, from, err := c.udp.ReadFromUDP(buf)
+		if err != nil {
+			if c.pool.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		c.pool.count(0, n)
+		if n < 12 {
+			continue
+		}
+
+		c.Lock()
+		q := c.pending[binary.BigEndian.Uint16(buf)]
+		c.Unlock()
+		if q == nil {
+			// The response arrived after the query expired, or was never asked for
+			continue
+		}
+
+		resp := new(dns.Msg)
+		if err := resp.Unpack(buf[:n]); err != nil || !sameQuestion(q.msg, resp) {
+			c.pool.mismatch.Add(1)
+			continue
+		}
+		// The response answering the query from another address may be forged, and is left to the timeout
+		// unless the resolver is expected to answer from other addresses of its anycast network
+		if !c.fromResolver(from) {
+			c.res.mismatches.Add(1)
+			c.pool.sourceMismatch(resp, c.addr, from.String(), c.pool.warn)
+			if !c.pool.warn {
+				continue
+			}
+		}
+		if !c.remove(q) {
+			continue
+		}
+		c.pool.received.Add(1)
+
+		resp.Id = q.msg.Id
+		if resp.Truncated {
+			c.pool.truncated.Add(1)
+			c.pool.wg.Add(1)
+			go c.pool.exchangeTCP(c.addr, q)
+			continue
+		}
+		c.pool.answered(resp, c.addr)
+		c.pool.deliver(q.ch, resp)
+	}
+}
+
+// fromResolver returns true when the response arrived from the address the queries of the socket are sent to.
+func (c *conn) fromResolver(from *net.UDPAddr) bool {
+	return from != nil && from.Port == c.raddr.Port && from.IP.Equal(c.raddr.IP)
+}
+
+// exchangeTCP sends again the query that received a truncated response, using a TCP connection.
+func (p *Pool) exchangeTCP(addr string, q *query) {
+	defer p.wg.Done()
+
+	client := &dns.Client{Net: "tcp", Timeout: p.timeout}
+	if len(p.bind) > 0 {
+		client.Dialer = &net.Dialer{Timeout: p.timeout, LocalAddr: &net.TCPAddr{IP: p.bind}}
+	}
+
+	var resp *dns.Msg
+	co, err := client.DialContext(p.ctx, addr)
+	if err == nil {
+		co.Conn = bandwidth.NewConn(co.Conn, p.bytes)
+		resp, _, err = client.ExchangeWithConnContext(p.ctx, q.msg, co)
+		_ = co.Close()
+	}
+	if err != nil || resp == nil {
+		resp = noResponse(q.msg)
+	} else {
+		p.answered(resp, addr)
+	}
+	p.deliver(q.ch, resp)
+}
+
+// count passes the bytes crossing the sockets to the function set by the options.
+func (p *Pool) count(sent, received int) {
+	if p.bytes != nil {
+		p.bytes(sent, received)
+	}
+}
+
+// answered passes the response to the observer, along with the resolver that sent it.
+func (p *Pool) answered(resp *dns.Msg, server string) {
+	if p.observe != nil {
+		p.observe(resp, server)
+	}
+}
+
+// sourceMismatch passes the response that arrived from another address to the function set by the options.
+func (p *Pool) sourceMismatch(resp *dns.Msg, server, from string, accepted bool) {
+	if p.wrongSrc != nil {
+		p.wrongSrc(resp, server, from, accepted)
+	}
+}
+
+// expire fails the queries that were not answered before their deadline.
+func (p *Pool) expire() {
+	defer p.wg.Done()
+
+	interval := p.timeout / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var expired []*query
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-t.C:
+			for _, r := range p.resolvers {
+				for _, c := range r.conns {
+					expired = c.expired(now, expired[:0])
+
+					for _, q := range expired {
+						p.timeouts.Add(1)
+						p.deliver(q.ch, noResponse(q.msg))
+					}
+				}
+			}
+		}
+	}
+}
+
+// expired removes the queries that passed their deadline, and appends those still outstanding to the slice.
+func (c *conn) expired(now time.Time, queries []*query) []*query {
+	c.Lock()
+	defer c.Unlock()
+
+	var i int
+	for ; i < len(c.fifo); i++ {
+		q := c.fifo[i]
+		if q.deadline.After(now) {
+			break
+		}
+		if !q.done && c.pending[q.id] == q {
+			q.done = true
+			delete(c.pending, q.id)
+			queries = append(queries, q)
+		}
+		c.fifo[i] = nil
+	}
+	c.fifo = c.fifo[i:]
+	return queries
+}
+
+// deliver sends the response on the channel, and gives up on a busy channel once the transport has been closed.
+func (p *Pool) deliver(ch chan *dns.Msg, resp *dns.Msg) {
+	select {
+	case ch <- resp:
+	case <-p.ctx.Done():
+		select {
+		case ch <- resp:
+		default:
+		}
+	}
+}
+
+func noResponse(msg *dns.Msg) *dns.Msg {
+	resp := msg.Copy()
+	resp.Rcode = resolve.RcodeNoResponse
+	return resp
+}
+
+// sameQuestion returns true when the response answers the question of the query. Resolvers may change
+// the case of the name, so the names are compared without regard to case.
+func sameQuestion(msg, resp *dns.Msg) bool {
+	if len(resp.Question) != len(msg.Question) {
+		return false
+	}
+
+	for i, q := range msg.Question {
+		r := resp.Question[i]
+		if r.Qtype != q.Qtype || r.Qclass != q.Qclass || !strings.EqualFold(r.Name, q.Name) {
+			return false
+		}
+	}
+	return true
+}
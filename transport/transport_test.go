@@ -0,0 +1,615 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/config/config"
+	"github.com/owasp-amass/resolve"
+)
+
+// fakeHandler answers a query received by the fake resolver through the reply function, which it may call
+// any number of times, including none or after a delay.
+type fakeHandler func(reply func(*dns.Msg), req *dns.Msg, tcp bool)
+
+// startFakeResolver serves the handler on a local UDP port, and on the TCP port of the same number when tcp is set.
+// The UDP queries are answered by a single goroutine, so the goroutines of the pools can be counted.
+func startFakeResolver(tb testing.TB, handler fakeHandler, tcp bool) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// The queries are sent in bursts, which would overflow the default receive buffer
+	_ = pc.(*net.UDPConn).SetReadBuffer(4 << 20)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			req := new(dns.Msg)
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Question) == 0 {
+				continue
+			}
+			handler(func(m *dns.Msg) {
+				if data, err := m.Pack(); err == nil {
+					_, _ = pc.WriteTo(data, from)
+				}
+			}, req, false)
+		}
+	}()
+	tb.Cleanup(func() {
+		_ = pc.Close()
+		wg.Wait()
+	})
+
+	if tcp {
+		l, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		started := make(chan struct{})
+		srv := &dns.Server{
+			Listener:          l,
+			NotifyStartedFunc: func() { close(started) },
+			Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+				handler(func(m *dns.Msg) { _ = w.WriteMsg(m) }, req, true)
+			}),
+		}
+		go func() { _ = srv.ActivateAndServe() }()
+		<-started
+		tb.Cleanup(func() { _ = srv.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+// answer replies with an address derived from the length of the name, so each answer can be checked.
+func answer(reply func(*dns.Msg), req *dns.Msg, tcp bool) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(10, 0, 0, byte(len(req.Question[0].Name))),
+	})
+	reply(m)
+}
+
+func checkAnswer(t *testing.T, msg, resp *dns.Msg) {
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("the query for %s was answered with %v", msg.Question[0].Name, resp)
+	}
+	if resp.Id != msg.Id || !strings.EqualFold(resp.Question[0].Name, msg.Question[0].Name) {
+		t.Errorf("the response %d for %s does not match the query %d", resp.Id, resp.Question[0].Name, msg.Id)
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || !a.A.Equal(net.IPv4(10, 0, 0, byte(len(msg.Question[0].Name)))) {
+		t.Errorf("the query for %s was answered with %v", msg.Question[0].Name, resp.Answer[0])
+	}
+}
+
+func TestQuery(t *testing.T) {
+	addr := startFakeResolver(t, answer, false)
+	p, err := New([]string{addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+	resp, err := p.QueryBlocking(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, msg, resp)
+
+	if s := p.Stats(); s.Sent != 1 || s.Received != 1 || s.Outstanding != 0 {
+		t.Errorf("the counters are %+v", s)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	addrs := []string{startFakeResolver(t, answer, false), startFakeResolver(t, answer, false)}
+
+	var lock sync.Mutex
+	servers := make(map[string]string)
+	p, err := New(addrs, &Options{Observe: func(resp *dns.Msg, server string) {
+		lock.Lock()
+		defer lock.Unlock()
+		servers[resp.Question[0].Name] = server
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The resolvers are used in turn
+	for i, name := range []string{"www.owasp.org", "api.owasp.org"} {
+		msg := resolve.QueryMsg(name, dns.TypeA)
+		resp, err := p.QueryBlocking(context.Background(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkAnswer(t, msg, resp)
+
+		lock.Lock()
+		server := servers[msg.Question[0].Name]
+		lock.Unlock()
+		if server != addrs[i] {
+			t.Errorf("the response for %s was observed from %q, expected %q", name, server, addrs[i])
+		}
+	}
+}
+
+func TestIDCollisions(t *testing.T) {
+	addr := startFakeResolver(t, answer, false)
+	// A single socket carries the queries, which the callers all sent with the same ID
+	p, err := New([]string{addr}, &Options{Sockets: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	const num = 2000
+	ch := make(chan *dns.Msg, num)
+	msgs := make(map[string]*dns.Msg, num)
+	for i := 0; i < num; i++ {
+		msg := resolve.QueryMsg(fmt.Sprintf("host%d.%s.owasp.org", i, strings.Repeat("a", 1+i%50)), dns.TypeA)
+		msg.Id = 53
+		msgs[strings.ToLower(msg.Question[0].Name)] = msg
+		p.Query(context.Background(), msg, ch)
+	}
+
+	for i := 0; i < num; i++ {
+		resp := <-ch
+		msg, found := msgs[strings.ToLower(resp.Question[0].Name)]
+		if !found {
+			t.Fatalf("the response for %s was not asked for", resp.Question[0].Name)
+		}
+		checkAnswer(t, msg, resp)
+		delete(msgs, strings.ToLower(resp.Question[0].Name))
+	}
+	if len(msgs) != 0 {
+		t.Errorf("%d queries were not answered", len(msgs))
+	}
+}
+
+func TestMismatchedResponse(t *testing.T) {
+	// The resolver first sends a response with the ID of the query, but another question
+	addr := startFakeResolver(t, func(reply func(*dns.Msg), req *dns.Msg, tcp bool) {
+		bogus := resolve.QueryMsg("spoofed.owasp.org", dns.TypeA)
+		bogus.Id = req.Id
+		bogus.Response = true
+		reply(bogus)
+		answer(reply, req, tcp)
+	}, false)
+
+	p, err := New([]string{addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+	resp, err := p.QueryBlocking(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, msg, resp)
+	if s := p.Stats(); s.Mismatched != 1 {
+		t.Errorf("the counters are %+v", s)
+	}
+}
+
+// startSpoofedResolver receives the queries on a local UDP port, and answers each of them at once from another
+// port, like a packet source forging the responses or an anycast resolver answering from another address. The
+// genuine answer follows from the port of the resolver after the delay, and is never sent when the delay is zero.
+func startSpoofedResolver(tb testing.TB, delay time.Duration) (string, string) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	source, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			req := new(dns.Msg)
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Question) == 0 {
+				continue
+			}
+
+			forged := new(dns.Msg)
+			forged.SetReply(req)
+			forged.Answer = append(forged.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   net.IPv4(192, 0, 2, 66),
+			})
+			if data, err := forged.Pack(); err == nil {
+				_, _ = source.WriteTo(data, from)
+			}
+			if delay > 0 {
+				time.AfterFunc(delay, func() {
+					answer(func(m *dns.Msg) {
+						if data, err := m.Pack(); err == nil {
+							_, _ = pc.WriteTo(data, from)
+						}
+					}, req, false)
+				})
+			}
+		}
+	}()
+	tb.Cleanup(func() {
+		_ = pc.Close()
+		_ = source.Close()
+		wg.Wait()
+	})
+	return pc.LocalAddr().String(), source.LocalAddr().String()
+}
+
+type sourceMismatch struct {
+	name, server, from string
+	accepted           bool
+}
+
+func mismatchRecorder() (func(*dns.Msg, string, string, bool), func() []sourceMismatch) {
+	var lock sync.Mutex
+	var found []sourceMismatch
+	return func(resp *dns.Msg, server, from string, accepted bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			found = append(found, sourceMismatch{resp.Question[0].Name, server, from, accepted})
+		}, func() []sourceMismatch {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]sourceMismatch(nil), found...)
+		}
+}
+
+func TestSourceMismatchStrict(t *testing.T) {
+	addr, source := startSpoofedResolver(t, 100*time.Millisecond)
+	record, found := mismatchRecorder()
+	p, err := New([]string{addr}, &Options{SourceMismatch: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The forged response arrives first, and is dropped in favor of the genuine answer
+	msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+	resp, err := p.QueryBlocking(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, msg, resp)
+
+	if f := found(); len(f) != 1 || f[0].server != addr || f[0].from != source || f[0].accepted {
+		t.Errorf("the mismatches were reported as %+v", f)
+	}
+	if s := p.Stats(); s.SourceMismatches != 1 || s.Received != 1 {
+		t.Errorf("the counters are %+v", s)
+	}
+	if m := p.SourceMismatches(); m[addr] != 1 {
+		t.Errorf("the mismatches of the resolvers are %v", m)
+	}
+}
+
+func TestSourceMismatchWarn(t *testing.T) {
+	addr, source := startSpoofedResolver(t, 0)
+	record, found := mismatchRecorder()
+	p, err := New([]string{addr}, &Options{SourceCheck: SourceWarn, SourceMismatch: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The response from the other address is accepted, and reported as such
+	msg := resolve.QueryMsg("www.owasp.org", dns.TypeA)
+	resp, err := p.QueryBlocking(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || !resp.Answer[0].(*dns.A).A.Equal(net.IPv4(192, 0, 2, 66)) {
+		t.Errorf("the query was answered with %v", resp)
+	}
+
+	if f := found(); len(f) != 1 || f[0].name != "www.owasp.org." || f[0].from != source || !f[0].accepted {
+		t.Errorf("the mismatches were reported as %+v", f)
+	}
+	if s := p.Stats(); s.SourceMismatches != 1 || s.Received != 1 {
+		t.Errorf("the counters are %+v", s)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// The resolver answers after the queries have expired
+	addr := startFakeResolver(t, func(reply func(*dns.Msg), req *dns.Msg, tcp bool) {
+		time.AfterFunc(300*time.Millisecond, func() { answer(reply, req, tcp) })
+	}, false)
+
+	p, err := New([]string{addr}, &Options{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ch := make(chan *dns.Msg, 10)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		p.Query(context.Background(), resolve.QueryMsg(fmt.Sprintf("slow%d.owasp.org", i), dns.TypeA), ch)
+	}
+	for i := 0; i < 5; i++ {
+		if resp := <-ch; resp.Rcode != resolve.RcodeNoResponse {
+			t.Errorf("the expired query was returned with the code %d", resp.Rcode)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the queries expired after %s", elapsed)
+	}
+
+	// The late responses are dropped, since the queries are no longer outstanding
+	time.Sleep(400 * time.Millisecond)
+	select {
+	case resp := <-ch:
+		t.Errorf("the late response %v was delivered", resp)
+	default:
+	}
+	if s := p.Stats(); s.Timeouts != 5 || s.Received != 0 || s.Outstanding != 0 {
+		t.Errorf("the counters are %+v", s)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	addr := startFakeResolver(t, func(reply func(*dns.Msg), req *dns.Msg, tcp bool) {
+		if !tcp {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.Truncated = true
+			reply(m)
+			return
+		}
+		answer(reply, req, tcp)
+	}, true)
+
+	p, err := New([]string{addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := resolve.QueryMsg("large.owasp.org", dns.TypeA)
+	resp, err := p.QueryBlocking(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, msg, resp)
+	if s := p.Stats(); s.Truncated != 1 {
+		t.Errorf("the counters are %+v", s)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	// The replies are measured as the fake resolver packs them, and carry the length prefix over TCP
+	var replied atomic.Int64
+	addr := startFakeResolver(t, func(reply func(*dns.Msg), req *dns.Msg, tcp bool) {
+		measured := func(m *dns.Msg) {
+			if data, err := m.Pack(); err == nil {
+				n := len(data)
+				if tcp {
+					n += 2
+				}
+				replied.Add(int64(n))
+			}
+			reply(m)
+		}
+		if !tcp {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.Truncated = true
+			measured(m)
+			return
+		}
+		answer(measured, req, tcp)
+	}, true)
+
+	var sent, received atomic.Int64
+	p, err := New([]string{addr}, &Options{Bytes: func(s, r int) {
+		sent.Add(int64(s))
+		received.Add(int64(r))
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := resolve.QueryMsg("large.owasp.org", dns.TypeA)
+	resp, err := p.QueryBlocking(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, msg, resp)
+
+	data, _ := msg.Pack()
+	// The query was sent over UDP, and again over TCP once the response was truncated
+	if expected := int64(2*len(data) + 2); sent.Load() != expected {
+		t.Errorf("%d bytes were sent, expected %d", sent.Load(), expected)
+	}
+	if received.Load() != replied.Load() {
+		t.Errorf("%d bytes were received, expected %d", received.Load(), replied.Load())
+	}
+}
+
+func TestClose(t *testing.T) {
+	var received atomic.Int64
+	// The resolver never answers
+	addr := startFakeResolver(t, func(reply func(*dns.Msg), req *dns.Msg, tcp bool) { received.Add(1) }, false)
+
+	before := runtime.NumGoroutine()
+	p, err := New([]string{addr, addr}, &Options{Sockets: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan *dns.Msg, 100)
+	for i := 0; i < 100; i++ {
+		p.Query(context.Background(), resolve.QueryMsg(fmt.Sprintf("host%d.owasp.org", i), dns.TypeA), ch)
+	}
+	for received.Load() < 100 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// The caller that is not reading its channel does not hold up the shutdown
+		p.Query(context.Background(), resolve.QueryMsg("blocked.owasp.org", dns.TypeA), make(chan *dns.Msg))
+	}()
+
+	p.Close()
+	wg.Wait()
+	for i := 0; i < 100; i++ {
+		if resp := <-ch; resp.Rcode != resolve.RcodeNoResponse {
+			t.Errorf("the outstanding query was returned with the code %d", resp.Rcode)
+		}
+	}
+
+	// The queries sent after the transport was closed fail right away
+	if resp, err := p.QueryBlocking(context.Background(), resolve.QueryMsg("late.owasp.org", dns.TypeA)); err == nil && resp.Rcode != resolve.RcodeNoResponse {
+		t.Errorf("the query sent after the close was answered with %v", resp)
+	}
+	p.Close()
+
+	// The readers and the goroutine expiring the queries have returned
+	for i := 0; i < 50 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines remain after the close", n-before)
+	}
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	if OptionsFromConfig(cfg) != nil {
+		t.Errorf("the transport was selected without the options")
+	}
+
+	cfg.ResolversQPS = 50
+	cfg.Options["dns"] = map[string]interface{}{"pipelined": true, "sockets": 4, "timeout": 5}
+	o := OptionsFromConfig(cfg)
+	if o == nil || o.Sockets != 4 || o.Timeout != 5*time.Second || o.QPS != 50 || o.SourceCheck != "" {
+		t.Errorf("the options are %+v", o)
+	}
+
+	cfg.Options["dns"] = map[string]interface{}{"pipelined": true, "source_check": " Warn "}
+	if o := OptionsFromConfig(cfg); o == nil || o.SourceCheck != SourceWarn {
+		t.Errorf("the options are %+v", o)
+	}
+	if _, err := New([]string{"127.0.0.1:53"}, &Options{SourceCheck: "loose"}); err == nil {
+		t.Error("the unknown source address check was accepted")
+	}
+}
+
+// benchInFlight is the number of queries kept outstanding by the benchmarks, as the DNS task of an enumeration does.
+const benchInFlight = 1000
+
+// benchPool is the part of the pools measured by the benchmarks.
+type benchPool interface {
+	Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg)
+}
+
+// runBenchmark keeps the queries outstanding against the fake resolver, and reports the queries answered each
+// second along with the goroutines added by the pool at steady state.
+func runBenchmark(b *testing.B, pool benchPool, baseline int) {
+	msgs := make([]*dns.Msg, benchInFlight)
+	for i := range msgs {
+		msgs[i] = resolve.QueryMsg(fmt.Sprintf("host%d.bench.example", i), dns.TypeA)
+	}
+
+	ctx := context.Background()
+	ch := make(chan *dns.Msg, benchInFlight)
+	b.ResetTimer()
+	start := time.Now()
+
+	var sent, answered, peak int
+	for ; sent < benchInFlight && sent < b.N; sent++ {
+		pool.Query(ctx, msgs[sent], ch)
+	}
+	for answered < sent {
+		resp := <-ch
+		answered++
+		if resp.Rcode == resolve.RcodeNoResponse {
+			b.Fatal("a query was not answered")
+		}
+		if sent < b.N {
+			pool.Query(ctx, msgs[sent%benchInFlight], ch)
+			sent++
+		}
+		if answered%benchInFlight == 0 {
+			if n := runtime.NumGoroutine() - baseline; n > peak {
+				peak = n
+			}
+		}
+	}
+
+	b.ReportMetric(float64(answered)/time.Since(start).Seconds(), "queries/s")
+	b.ReportMetric(float64(peak), "goroutines")
+}
+
+// BenchmarkTransport compares the pipelined transport with the pool of the resolve package, both sending
+// the queries to the same fake resolver.
+func BenchmarkTransport(b *testing.B) {
+	addr := startFakeResolver(b, answer, false)
+
+	b.Run("pipelined", func(b *testing.B) {
+		baseline := runtime.NumGoroutine()
+		p, err := New([]string{addr}, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer p.Close()
+
+		runBenchmark(b, p, baseline)
+	})
+
+	b.Run("resolve", func(b *testing.B) {
+		baseline := runtime.NumGoroutine()
+		pool := resolve.NewResolvers()
+		_ = pool.AddResolvers(1000000, addr)
+		pool.SetTimeout(DefaultTimeout)
+		defer pool.Stop()
+
+		runBenchmark(b, pool, baseline)
+	})
+}
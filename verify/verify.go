@@ -0,0 +1,424 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package verify
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/random"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/amass/v4/systems"
+	"github.com/owasp-amass/config/config"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+	"github.com/owasp-amass/resolve"
+)
+
+const (
+	// DefaultWindow is the period the verification queries are spread over
+	DefaultWindow = 24 * time.Hour
+	// DefaultMaxFailures is the number of consecutive failed verifications before a name becomes stale
+	DefaultMaxFailures = 3
+)
+
+// The types of changes reported by the Verifier.
+const (
+	RecordsChanged = "changed"
+	NameStale      = "stale"
+	NameRecovered  = "recovered"
+)
+
+// verifyTypes are the record types resolved to confirm that a name still exists.
+var verifyTypes = []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA}
+
+// Change is the notification sent when the verification of a name differs from its previous state.
+type Change struct {
+	Name     string
+	Domain   string
+	Type     string
+	Added    []requests.DNSAnswer
+	Removed  []requests.DNSAnswer
+	Failures int
+}
+
+type queryFunc func(ctx context.Context, name string, qtype uint16) ([]requests.DNSAnswer, error)
+
+type entry struct {
+	domain   string
+	records  []requests.DNSAnswer
+	failures int
+	stale    bool
+	// validUntil is the end of the bounded TTL of the records at the last verification
+	validUntil time.Time
+}
+
+// Verifier re-resolves the names stored by previous enumerations to confirm that they still exist,
+// spreading the queries over the configured window instead of performing a full enumeration.
+type Verifier struct {
+	sync.Mutex
+	Config      *config.Config
+	Sys         systems.System
+	Window      time.Duration
+	MaxFailures int
+	// TTLs bound the TTLs of the answers, which keep a name from being verified again before they elapse
+	TTLs   systems.TTLBounds
+	Output chan *Change
+	graph  *netmap.Graph
+	names  map[string]*entry
+	query  queryFunc
+	rand   *rand.Rand
+}
+
+// NewVerifier returns an initialized Verifier that has not been started yet.
+func NewVerifier(cfg *config.Config, sys systems.System, graph *netmap.Graph) *Verifier {
+	v := &Verifier{
+		Config:      cfg,
+		Sys:         sys,
+		Window:      DefaultWindow,
+		MaxFailures: DefaultMaxFailures,
+		Output:      make(chan *Change, 100),
+		graph:       graph,
+		names:       make(map[string]*entry),
+		rand:        random.FromConfig(cfg).Rand("verify"),
+	}
+	// The bounds are checked when the System is built, so the defaults are kept otherwise
+	if ttls, err := systems.TTLBoundsFromConfig(cfg); err == nil {
+		v.TTLs = ttls
+	} else {
+		v.TTLs = systems.TTLBounds{Floor: systems.DefaultTTLFloor, Ceiling: systems.DefaultTTLCeiling}
+	}
+
+	v.query = v.resolve
+	return v
+}
+
+// Start verifies the names seen since the provided time, which is the start of the latest stored event,
+// and continues with another pass each window until the context is cancelled.
+// The Output channel is closed when the Verifier returns.
+func (v *Verifier) Start(ctx context.Context, since time.Time) error {
+	defer close(v.Output)
+
+	for {
+		start := time.Now()
+		if err := v.Verify(ctx, since); err != nil {
+			return err
+		}
+		// Wait for the end of the window, so the passes are not run back-to-back
+		t := time.NewTimer(time.Until(start.Add(v.Window)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+		since = start
+	}
+}
+
+// Verify performs a single pass over the names, scheduling the queries evenly across the window.
+func (v *Verifier) Verify(ctx context.Context, since time.Time) error {
+	if v.graph == nil || v.graph.DB == nil {
+		return errors.New("Verify: the graph has not been initialized")
+	}
+	if err := v.loadNames(since); err != nil {
+		return err
+	}
+
+	names := v.sortedNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	slot := v.Window / time.Duration(len(names))
+	begin := time.Now()
+	for i, name := range names {
+		offset := slot * time.Duration(i)
+		if slot > 0 {
+			offset += time.Duration(v.rand.Int63n(int64(slot)))
+		}
+
+		t := time.NewTimer(time.Until(begin.Add(offset)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+		// The records within their TTL cannot have changed since they were verified
+		if v.valid(name, time.Now()) {
+			continue
+		}
+
+		v.verifyName(ctx, name)
+	}
+	return nil
+}
+
+// valid returns true when the bounded TTL of the records verified last for the name has not elapsed.
+func (v *Verifier) valid(name string, now time.Time) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	e, found := v.names[name]
+	return found && now.Before(e.validUntil)
+}
+
+// Stale returns true when the name failed the maximum number of consecutive verifications.
+func (v *Verifier) Stale(name string) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	if e, found := v.names[strings.ToLower(name)]; found {
+		return e.stale
+	}
+	return false
+}
+
+// loadNames reads the in scope names and records seen since the provided time from the graph.
+// The names tracked by previous passes are kept, so the failing names continue to be verified.
+func (v *Verifier) loadNames(since time.Time) error {
+	if !since.IsZero() {
+		since = since.UTC()
+	}
+
+	var fqdns []oam.Asset
+	for _, d := range v.Config.Domains() {
+		fqdns = append(fqdns, domain.FQDN{Name: d})
+	}
+
+	assets, err := v.graph.DB.FindByScope(fqdns, since)
+	if err != nil {
+		return err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	for _, a := range assets {
+		fqdn, ok := a.Asset.(domain.FQDN)
+		if !ok {
+			continue
+		}
+
+		name := strings.ToLower(fqdn.Name)
+		if _, found := v.names[name]; found {
+			continue
+		}
+
+		d := v.Config.WhichDomain(name)
+		if d == "" {
+			continue
+		}
+
+		var records []requests.DNSAnswer
+		if rels, err := v.graph.DB.OutgoingRelations(a, since, "cname_record", "a_record", "aaaa_record"); err == nil {
+			for _, rel := range rels {
+				if rec, ok := v.relationToAnswer(name, rel.Type, rel.ToAsset.ID, since); ok {
+					records = append(records, rec)
+				}
+			}
+		}
+		// Names without records only structure the graph, and were never resolved
+		if len(records) > 0 {
+			v.names[name] = &entry{domain: d, records: sortAnswers(records)}
+		}
+	}
+	return nil
+}
+
+func (v *Verifier) relationToAnswer(name, rtype, id string, since time.Time) (requests.DNSAnswer, bool) {
+	to, err := v.graph.DB.FindById(id, since)
+	if err != nil {
+		return requests.DNSAnswer{}, false
+	}
+
+	rec := requests.DNSAnswer{Name: name}
+	switch a := to.Asset.(type) {
+	case domain.FQDN:
+		rec.Type = int(dns.TypeCNAME)
+		rec.Data = strings.ToLower(a.Name)
+	case network.IPAddress:
+		rec.Type = int(dns.TypeA)
+		if rtype == "aaaa_record" {
+			rec.Type = int(dns.TypeAAAA)
+		}
+		rec.Data = a.Address.String()
+	default:
+		return rec, false
+	}
+	return rec, true
+}
+
+func (v *Verifier) sortedNames() []string {
+	v.Lock()
+	defer v.Unlock()
+
+	var names []string
+	for name := range v.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (v *Verifier) verifyName(ctx context.Context, name string) {
+	var records []requests.DNSAnswer
+
+	for _, qtype := range verifyTypes {
+		ans, err := v.query(ctx, name, qtype)
+		if err == nil {
+			records = append(records, ans...)
+		}
+		// An alias makes the address records belong to the target
+		if qtype == dns.TypeCNAME && len(ans) > 0 {
+			break
+		}
+	}
+
+	v.Lock()
+	e, found := v.names[name]
+	if !found {
+		v.Unlock()
+		return
+	}
+
+	var change *Change
+	if len(records) == 0 {
+		e.failures++
+		if !e.stale && e.failures >= v.MaxFailures {
+			e.stale = true
+			change = &Change{Name: name, Domain: e.domain, Type: NameStale, Failures: e.failures}
+		}
+		v.Unlock()
+		v.send(ctx, change)
+		return
+	}
+
+	records = sortAnswers(records)
+	e.validUntil = time.Now().Add(v.TTLs.Apply(leastTTL(records)))
+	added, removed := diffAnswers(e.records, records)
+	if e.stale {
+		change = &Change{Name: name, Domain: e.domain, Type: NameRecovered, Added: added, Removed: removed}
+	} else if len(added) > 0 || len(removed) > 0 {
+		change = &Change{Name: name, Domain: e.domain, Type: RecordsChanged, Added: added, Removed: removed}
+	}
+	e.failures = 0
+	e.stale = false
+	e.records = records
+	v.Unlock()
+
+	v.store(ctx, records)
+	v.send(ctx, change)
+}
+
+// store writes the verified records, which updates their last seen time as part of the new event.
+func (v *Verifier) store(ctx context.Context, records []requests.DNSAnswer) {
+	for _, rec := range records {
+		var err error
+
+		switch uint16(rec.Type) {
+		case dns.TypeCNAME:
+			err = v.graph.UpsertCNAME(ctx, rec.Name, rec.Data)
+		case dns.TypeA:
+			err = v.graph.UpsertA(ctx, rec.Name, rec.Data)
+		case dns.TypeAAAA:
+			err = v.graph.UpsertAAAA(ctx, rec.Name, rec.Data)
+		}
+		if err != nil {
+			v.Config.Log.Printf("Verify: failed to store the record for %s: %v", rec.Name, err)
+		}
+	}
+}
+
+func (v *Verifier) send(ctx context.Context, change *Change) {
+	if change == nil {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case v.Output <- change:
+	}
+}
+
+func (v *Verifier) resolve(ctx context.Context, name string, qtype uint16) ([]requests.DNSAnswer, error) {
+	resp, err := v.Sys.TrustedResolvers().QueryBlocking(ctx, resolve.QueryMsg(name, qtype))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, errors.New("the query was not successful")
+	}
+
+	ttl, _ := systems.MessageTTL(resp)
+	var answers []requests.DNSAnswer
+	for _, a := range resolve.AnswersByType(resolve.ExtractAnswers(resp), qtype) {
+		answers = append(answers, requests.DNSAnswer{
+			Name: strings.ToLower(resolve.RemoveLastDot(a.Name)),
+			Type: int(a.Type),
+			TTL:  ttl,
+			Data: strings.ToLower(resolve.RemoveLastDot(a.Data)),
+		})
+	}
+	return answers, nil
+}
+
+// leastTTL returns the least raw TTL of the records, where zero is taken as unknown.
+func leastTTL(records []requests.DNSAnswer) int {
+	var least int
+	for _, rec := range records {
+		if rec.TTL > 0 && (least == 0 || rec.TTL < least) {
+			least = rec.TTL
+		}
+	}
+	return least
+}
+
+func answerKey(a requests.DNSAnswer) string {
+	return strings.ToLower(a.Name) + "|" + dns.TypeToString[uint16(a.Type)] + "|" + strings.ToLower(a.Data)
+}
+
+func sortAnswers(answers []requests.DNSAnswer) []requests.DNSAnswer {
+	sort.Slice(answers, func(i, j int) bool {
+		return answerKey(answers[i]) < answerKey(answers[j])
+	})
+	return answers
+}
+
+// diffAnswers returns the records that were added and removed between the previous and current sets.
+func diffAnswers(prev, cur []requests.DNSAnswer) ([]requests.DNSAnswer, []requests.DNSAnswer) {
+	seen := make(map[string]struct{}, len(prev))
+	for _, a := range prev {
+		seen[answerKey(a)] = struct{}{}
+	}
+
+	var added []requests.DNSAnswer
+	current := make(map[string]struct{}, len(cur))
+	for _, a := range cur {
+		k := answerKey(a)
+
+		current[k] = struct{}{}
+		if _, found := seen[k]; !found {
+			added = append(added, a)
+		}
+	}
+
+	var removed []requests.DNSAnswer
+	for _, a := range prev {
+		if _, found := current[answerKey(a)]; !found {
+			removed = append(removed, a)
+		}
+	}
+	return added, removed
+}
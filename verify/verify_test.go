@@ -0,0 +1,209 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package verify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+	"github.com/miekg/dns"
+	"github.com/owasp-amass/amass/v4/requests"
+	"github.com/owasp-amass/config/config"
+)
+
+type fakeDNS struct {
+	sync.Mutex
+	records map[string][]requests.DNSAnswer
+	times   []time.Time
+}
+
+func (f *fakeDNS) query(ctx context.Context, name string, qtype uint16) ([]requests.DNSAnswer, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if qtype == dns.TypeCNAME {
+		f.times = append(f.times, time.Now())
+	}
+
+	var answers []requests.DNSAnswer
+	for _, a := range f.records[name] {
+		if uint16(a.Type) == qtype {
+			answers = append(answers, a)
+		}
+	}
+	if len(answers) == 0 {
+		return nil, errors.New("no records")
+	}
+	return answers, nil
+}
+
+func (f *fakeDNS) set(name string, answers ...requests.DNSAnswer) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.records[name] = answers
+}
+
+func setupVerifier(t *testing.T) (*Verifier, *netmap.Graph, *fakeDNS) {
+	g := netmap.NewGraph("memory", "", "")
+	if g == nil {
+		t.Fatal("Failed to create the graph")
+	}
+
+	ctx := context.Background()
+	for name, addr := range map[string]string{
+		"www.owasp.org":  "192.168.1.1",
+		"mail.owasp.org": "192.168.1.2",
+		"vpn.owasp.org":  "192.168.1.3",
+	} {
+		if err := g.UpsertA(ctx, name, addr); err != nil {
+			t.Fatalf("Failed to insert the A record: %v", err)
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.AddDomain("owasp.org")
+
+	f := &fakeDNS{records: make(map[string][]requests.DNSAnswer)}
+	v := NewVerifier(cfg, nil, g)
+	v.Window = 30 * time.Millisecond
+	v.MaxFailures = 2
+	v.query = f.query
+	return v, g, f
+}
+
+func a(name, addr string) requests.DNSAnswer {
+	return requests.DNSAnswer{Name: name, Type: int(dns.TypeA), Data: addr}
+}
+
+func drain(ch chan *Change) map[string]*Change {
+	changes := make(map[string]*Change)
+
+	for {
+		select {
+		case c := <-ch:
+			changes[c.Name] = c
+		default:
+			return changes
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	v, g, f := setupVerifier(t)
+	defer g.Remove()
+
+	ctx := context.Background()
+	f.set("www.owasp.org", a("www.owasp.org", "192.168.1.1"))
+	f.set("mail.owasp.org", a("mail.owasp.org", "192.168.1.20"))
+
+	start := time.Now()
+	if err := v.Verify(ctx, time.Time{}); err != nil {
+		t.Fatalf("The verification failed: %v", err)
+	}
+	if len(f.times) != 3 {
+		t.Fatalf("Expected 3 names to be verified, got %d", len(f.times))
+	}
+	// The queries are spread across the window
+	if f.times[2].Sub(start) < v.Window/3 {
+		t.Errorf("The queries were not spread over the window")
+	}
+
+	changes := drain(v.Output)
+	if c, found := changes["mail.owasp.org"]; !found || c.Type != RecordsChanged ||
+		len(c.Added) != 1 || c.Added[0].Data != "192.168.1.20" || len(c.Removed) != 1 {
+		t.Errorf("The record change was not reported: %+v", c)
+	}
+	if _, found := changes["www.owasp.org"]; found {
+		t.Errorf("A change was reported for an unchanged name")
+	}
+	if _, found := changes["vpn.owasp.org"]; found || v.Stale("vpn.owasp.org") {
+		t.Errorf("The name became stale after a single failure")
+	}
+	// The verified records are written to the graph as part of the new event
+	var stored bool
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "mail.owasp.org"); err == nil {
+		for _, p := range pairs {
+			if p.Addr.Address.String() == "192.168.1.20" {
+				stored = true
+			}
+		}
+	}
+	if !stored {
+		t.Errorf("The verified record was not stored")
+	}
+
+	if err := v.Verify(ctx, time.Time{}); err != nil {
+		t.Fatalf("The verification failed: %v", err)
+	}
+	changes = drain(v.Output)
+	if c, found := changes["vpn.owasp.org"]; !found || c.Type != NameStale || c.Failures != 2 || !v.Stale("vpn.owasp.org") {
+		t.Errorf("The name was not marked stale: %+v", c)
+	}
+	// Stale names are kept in the graph and continue to be verified
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "vpn.owasp.org"); err != nil || len(pairs) == 0 {
+		t.Errorf("The stale name was removed from the graph")
+	}
+
+	f.set("vpn.owasp.org", a("vpn.owasp.org", "192.168.1.3"))
+	if err := v.Verify(ctx, time.Time{}); err != nil {
+		t.Fatalf("The verification failed: %v", err)
+	}
+	changes = drain(v.Output)
+	if c, found := changes["vpn.owasp.org"]; !found || c.Type != NameRecovered || v.Stale("vpn.owasp.org") {
+		t.Errorf("The name did not recover: %+v", c)
+	}
+}
+
+func TestVerifyWithinTTL(t *testing.T) {
+	v, g, f := setupVerifier(t)
+	defer g.Remove()
+
+	// The records with a TTL of 30 seconds remain valid for the floor of an hour
+	v.TTLs.Floor = time.Hour
+	ttl := a("www.owasp.org", "192.168.1.1")
+	ttl.TTL = 30
+	f.set("www.owasp.org", ttl)
+	f.set("mail.owasp.org", a("mail.owasp.org", "192.168.1.2"))
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := v.Verify(ctx, time.Time{}); err != nil {
+			t.Fatalf("The verification failed: %v", err)
+		}
+	}
+	// Only the name that failed was verified again
+	if len(f.times) != 4 {
+		t.Errorf("Expected 4 verifications, got %d", len(f.times))
+	}
+}
+
+func TestStartCancel(t *testing.T) {
+	v, g, _ := setupVerifier(t)
+	defer g.Remove()
+
+	v.Window = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- v.Start(ctx, time.Time{}) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start returned an error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after the context was cancelled")
+	}
+	if _, open := <-v.Output; open {
+		t.Errorf("The output channel was not closed")
+	}
+}
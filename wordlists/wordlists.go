@@ -0,0 +1,292 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package wordlists
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caffix/netmap"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+// maxLabelLen is the maximum length of a single DNS label.
+const maxLabelLen = 63
+
+// Entry is a label of a wordlist along with the number of names it produced in previous enumerations.
+type Entry struct {
+	Label string
+	Hits  int
+}
+
+// List is an ordered wordlist, where the labels expected to yield the most names come first.
+type List []Entry
+
+// Normalize returns the label in lowercase and reports whether it can be used to build DNS names.
+// Labels made of multiple DNS labels separated by dots are accepted.
+func Normalize(label string) (string, bool) {
+	label = strings.Trim(strings.ToLower(strings.TrimSpace(label)), ".")
+	if label == "" {
+		return "", false
+	}
+
+	for _, part := range strings.Split(label, ".") {
+		if part == "" || len(part) > maxLabelLen || part[0] == '-' || part[len(part)-1] == '-' {
+			return "", false
+		}
+		for i := 0; i < len(part); i++ {
+			if c := part[i]; !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+				return "", false
+			}
+		}
+	}
+	return label, true
+}
+
+// FromLabels returns a List containing the normalized and deduplicated labels in the order provided.
+func FromLabels(labels ...string) List {
+	var l List
+
+	for _, label := range labels {
+		l = append(l, Entry{Label: label})
+	}
+	return Merge(l)
+}
+
+// Merge combines the lists into a single deduplicated List. The earlier lists have priority,
+// so their labels come first, and the largest number of hits is kept for each label.
+func Merge(lists ...List) List {
+	var merged List
+	idx := make(map[string]int)
+
+	for _, l := range lists {
+		for _, e := range l {
+			label, ok := Normalize(e.Label)
+			if !ok {
+				continue
+			}
+
+			if i, found := idx[label]; found {
+				if e.Hits > merged[i].Hits {
+					merged[i].Hits = e.Hits
+				}
+				continue
+			}
+			idx[label] = len(merged)
+			merged = append(merged, Entry{Label: label, Hits: e.Hits})
+		}
+	}
+	return merged
+}
+
+// Load reads the wordlist files, which can be plain text or gzip compressed, and merges them
+// with the earlier files having priority.
+func Load(paths ...string) (List, error) {
+	var lists []List
+
+	for _, path := range paths {
+		l, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return Merge(lists...), nil
+}
+
+func loadFile(path string) (List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the wordlist file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	l, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the wordlist file %s: %v", path, err)
+	}
+	return l, nil
+}
+
+// Read parses a wordlist, which can be gzip compressed. Each line contains a label, optionally
+// followed by a tab and the number of hits, and lines starting with '#' are comments.
+func Read(r io.Reader) (List, error) {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		br = bufio.NewReader(zr)
+	}
+
+	var l List
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		e := Entry{Label: line}
+		if parts := strings.SplitN(line, "\t", 2); len(parts) == 2 {
+			hits, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || hits < 0 {
+				return nil, fmt.Errorf("the line %q has an invalid number of hits", line)
+			}
+			e = Entry{Label: parts[0], Hits: hits}
+		}
+		l = append(l, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Merge(l), nil
+}
+
+// Labels returns the labels of the List in order.
+func (l List) Labels() []string {
+	labels := make([]string, 0, len(l))
+
+	for _, e := range l {
+		labels = append(labels, e.Label)
+	}
+	return labels
+}
+
+// Sort orders the List by the number of hits, while keeping the order of labels with equal hits.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Hits > l[j].Hits
+	})
+}
+
+// Annotate assigns the hit frequencies to the labels, keeping the largest of the known and learned
+// values, and orders the List by expected yield.
+func (l List) Annotate(freq map[string]int) {
+	for i, e := range l {
+		if hits := freq[e.Label]; hits > e.Hits {
+			l[i].Hits = hits
+		}
+	}
+	l.Sort()
+}
+
+// Write saves the List in the format accepted by Read, so annotated lists can be loaded by the next run.
+func (l List) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, e := range l {
+		var err error
+
+		if e.Hits > 0 {
+			_, err = fmt.Fprintf(bw, "%s\t%d\n", e.Label, e.Hits)
+		} else {
+			_, err = fmt.Fprintln(bw, e.Label)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Save writes the List to the file, which is gzip compressed when the path ends with '.gz'.
+func (l List) Save(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the wordlist file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		zw := gzip.NewWriter(f)
+		defer zw.Close()
+
+		w = zw
+	}
+	if err := l.Write(w); err != nil {
+		return fmt.Errorf("failed to write the wordlist file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Frequencies counts how often each label appears in the in scope names stored in the graph since
+// the provided time. The leftmost label and the complete subdomain prefix of each name are counted,
+// since those are the words that would have produced the name through brute forcing.
+func Frequencies(ctx context.Context, g *netmap.Graph, domains []string, since time.Time) (map[string]int, error) {
+	if g == nil || g.DB == nil {
+		return nil, errors.New("Frequencies: the graph has not been initialized")
+	}
+
+	var fqdns []oam.Asset
+	for _, d := range domains {
+		fqdns = append(fqdns, domain.FQDN{Name: d})
+	}
+
+	if !since.IsZero() {
+		since = since.UTC()
+	}
+	assets, err := g.DB.FindByScope(fqdns, since)
+	if err != nil {
+		return nil, fmt.Errorf("Frequencies: %v", err)
+	}
+
+	freq := make(map[string]int)
+	for _, a := range assets {
+		select {
+		case <-ctx.Done():
+			return freq, ctx.Err()
+		default:
+		}
+
+		fqdn, ok := a.Asset.(domain.FQDN)
+		if !ok {
+			continue
+		}
+
+		prefix := subdomainPrefix(strings.ToLower(fqdn.Name), domains)
+		if prefix == "" {
+			continue
+		}
+
+		first := strings.SplitN(prefix, ".", 2)[0]
+		freq[first]++
+		if prefix != first {
+			freq[prefix]++
+		}
+	}
+	return freq, nil
+}
+
+// subdomainPrefix returns the labels of the name preceding the longest matching domain.
+func subdomainPrefix(name string, domains []string) string {
+	var match string
+
+	for _, d := range domains {
+		d = strings.ToLower(d)
+		if strings.HasSuffix(name, "."+d) && len(d) > len(match) {
+			match = d
+		}
+	}
+	if match == "" {
+		return ""
+	}
+	return strings.TrimSuffix(name, "."+match)
+}
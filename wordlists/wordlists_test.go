@@ -0,0 +1,127 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package wordlists
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caffix/netmap"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		label    string
+		expected string
+		valid    bool
+	}{
+		{"WWW", "www", true},
+		{" dev.api. ", "dev.api", true},
+		{"_dmarc", "_dmarc", true},
+		{"-bad", "", false},
+		{"bad-", "", false},
+		{"in valid", "", false},
+		{"a..b", "", false},
+		{strings.Repeat("a", 64), "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		if label, valid := Normalize(test.label); label != test.expected || valid != test.valid {
+			t.Errorf("Normalize(%q) returned %q, %t", test.label, label, valid)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first := List{{Label: "www"}, {Label: "mail", Hits: 2}}
+	second := List{{Label: "MAIL", Hits: 5}, {Label: "vpn"}, {Label: "www"}}
+
+	expected := List{{Label: "www"}, {Label: "mail", Hits: 5}, {Label: "vpn"}}
+	if merged := Merge(first, second); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Merge returned %v, expected %v", merged, expected)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	plain := filepath.Join(dir, "plain.txt")
+	if err := os.WriteFile(plain, []byte("# comment\nWWW\nmail\n\nwww\nbad label\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := filepath.Join(dir, "words.gz")
+	f, err := os.Create(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	_, _ = zw.Write([]byte("vpn\nmail\n"))
+	_ = zw.Close()
+	_ = f.Close()
+
+	l, err := Load(plain, compressed)
+	if err != nil {
+		t.Fatalf("Failed to load the wordlists: %v", err)
+	}
+	if labels := l.Labels(); !reflect.DeepEqual(labels, []string{"www", "mail", "vpn"}) {
+		t.Errorf("Load returned %v", labels)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Errorf("Load did not return an error for a missing file")
+	}
+}
+
+func TestAnnotateRoundTrip(t *testing.T) {
+	g := netmap.NewGraph("memory", "", "")
+	if g == nil {
+		t.Fatal("Failed to create the graph")
+	}
+	defer g.Remove()
+
+	ctx := context.Background()
+	for _, name := range []string{"mail.owasp.org", "dev.api.owasp.org", "api.owasp.org", "dev.owasp.org"} {
+		if err := g.UpsertA(ctx, name, "192.168.1.1"); err != nil {
+			t.Fatalf("Failed to insert the A record: %v", err)
+		}
+	}
+
+	freq, err := Frequencies(ctx, g, []string{"owasp.org"}, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to learn the frequencies: %v", err)
+	}
+	if freq["dev"] != 2 || freq["api"] != 1 || freq["dev.api"] != 1 {
+		t.Errorf("Unexpected frequencies: %v", freq)
+	}
+
+	l := FromLabels("www", "api", "dev", "mail")
+	l.Annotate(freq)
+	if labels := l.Labels(); !reflect.DeepEqual(labels, []string{"dev", "api", "mail", "www"}) {
+		t.Errorf("The annotated list was not ordered by yield: %v", labels)
+	}
+
+	for _, name := range []string{"words.txt", "words.txt.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		if err := l.Save(path); err != nil {
+			t.Fatalf("Failed to save the list: %v", err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("Failed to load the saved list: %v", err)
+		}
+		if !reflect.DeepEqual(loaded, l) {
+			t.Errorf("The round trip through %s returned %v, expected %v", name, loaded, l)
+		}
+	}
+}